@@ -33,6 +33,10 @@ func main() {
 	datastore.Environ = &datastore.Env{}
 
 	err := run()
+	if e, ok := err.(*sync.ExitError); ok {
+		// The exit code tells the failure category of the sync
+		os.Exit(e.Code)
+	}
 	if err != nil {
 		os.Exit(1)
 	}
@@ -20,13 +20,29 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"os"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/devmode"
+	"github.com/CanonicalLtd/serial-vault/geoip"
+	"github.com/CanonicalLtd/serial-vault/logsink"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/directory"
+	"github.com/CanonicalLtd/serial-vault/service/failover"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
+	"github.com/CanonicalLtd/serial-vault/service/invalidation"
+	"github.com/CanonicalLtd/serial-vault/service/janitor"
+	"github.com/CanonicalLtd/serial-vault/service/keyreload"
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/maintenance"
+	"github.com/CanonicalLtd/serial-vault/service/replication"
+	"github.com/CanonicalLtd/serial-vault/service/selfcheck"
+	"github.com/CanonicalLtd/serial-vault/service/systemd"
 	logging "github.com/op/go-logging"
 )
 
@@ -35,34 +51,167 @@ func main() {
 	// Parse the command line arguments
 	config.ParseArgs()
 	err := config.ReadConfig(&datastore.Environ.Config, config.SettingsFile)
-	if err != nil {
+	if err != nil && !(config.DevMode && os.IsNotExist(err)) {
 		log.Fatalf("Error parsing the config file: %v", err)
 	}
 
-	// Open the connection to the local database
-	datastore.OpenSysDatabase(datastore.Environ.Config.Driver, datastore.Environ.Config.DataSource)
+	// Write the logs to the configured sinks, verbosely in dev mode
+	level := logging.INFO
+	if config.DevMode {
+		level = logging.DEBUG
+	}
+	err = svlog.InitSinks(datastore.Environ.Config.LogSinks, level)
+	if err != nil {
+		log.Fatalf("Error opening the log sinks: %v", err)
+	}
+
+	// The vaults of a failover pair share the database, so it cannot be the local sqlite database
+	if datastore.Environ.Config.Failover && datastore.Environ.UsesSQLite() {
+		log.Fatalf("Error in the failover: it needs a Postgres database that is shared by the vaults")
+	}
+
+	// Check the maintenance windows, so they cannot be silently ignored
+	if _, err := maintenance.Windows(datastore.Environ.Config); err != nil {
+		log.Fatalf("Error in the maintenance windows: %v", err)
+	}
+
+	// Check the authentication rules of the endpoint groups, so no endpoint is left unprotected
+	if _, err := service.EndpointAuthRules(datastore.Environ.Config); err != nil {
+		log.Fatalf("Error in the endpoint authentication: %v", err)
+	}
+
+	if config.DevMode {
+		// Use the in-memory datastore and keystore, seeded with the example data
+		seed, err := devmode.Setup(datastore.Environ)
+		if err != nil {
+			log.Fatalf("Error seeding the dev mode data: %v", err)
+		}
+		svlog.Infof("Dev mode: brand %s, model %s, API key %s, signing-key %s", seed.BrandID, seed.ModelName, seed.APIKey, seed.KeyID)
+	} else {
+		// Open the connection to the local database
+		datastore.OpenSysDatabase(datastore.Environ.Config.Driver, datastore.Environ.Config.DataSource)
+
+		// Warn when the large tables are missing the indexes that the searches rely on
+		datastore.AdviseIndexes(datastore.Environ.DB)
+
+		// Opening the keypair manager to create the signing database
+		err = datastore.OpenKeyStore(datastore.Environ.Config)
+		if err != nil {
+			log.Fatalf("Error initializing the signing-key database: %v", err)
+		}
+	}
+
+	// Open the write-once storage of the signing log, when it is configured
+	datastore.Environ.SigningLogSink, err = logsink.Open(datastore.Environ.Config)
+	if err != nil {
+		log.Fatalf("Error opening the signing log sink: %v", err)
+	}
 
-	// Opening the keypair manager to create the signing database
-	err = datastore.OpenKeyStore(datastore.Environ.Config)
+	// Load the GeoIP database for the origin of the signing requests, when it is configured
+	datastore.Environ.GeoIP, err = geoip.Open(datastore.Environ.Config)
 	if err != nil {
-		log.Fatalf("Error initializing the signing-key database: %v", err)
+		log.Fatalf("Error loading the GeoIP database: %v", err)
 	}
 
+	// Check the database, the models and the keystore, logging the misconfigurations
+	selfcheck.Run(context.Background(), datastore.Environ)
+
+	srv := service.NewService(datastore.Environ)
+
 	var handler http.Handler
 	var address string
+	mode := "signing"
+
+	switch {
+	case config.DevMode:
+		// Serve the admin service in the background, as the in-memory data is not shared between processes
+		adminServer := srv.HTTPServer(":8081", srv.AdminRouter())
+		go func() {
+			log.Fatal(srv.ListenAndServe(adminServer))
+		}()
+		svlog.Infof("Starting admin service on port %s", adminServer.Addr)
+
+		handler = srv.SigningRouter()
+		address = ":8080"
+
+		go janitor.Run(context.Background(), datastore.Environ, janitor.Interval(datastore.Environ.Config))
+	case config.ServiceMode == "admin":
+		mode = "admin"
 
-	switch config.ServiceMode {
-	case "admin":
 		// Create the admin web service router
-		handler = service.AdminRouter()
+		handler = srv.AdminRouter()
 		address = ":8081"
+
+		// Replicate the signing log from the peer vaults in the background
+		if len(datastore.Environ.Config.ReplicationPeers) > 0 {
+			go replication.Run(context.Background(), datastore.Environ, replication.Interval(datastore.Environ.Config))
+		}
+
+		// Sync the users from the directory groups in the background
+		if len(datastore.Environ.Config.Directory.URL) > 0 {
+			go directory.Run(context.Background(), datastore.Environ, directory.Interval(datastore.Environ.Config))
+		}
 	default:
 		// Create the user web service router
-		handler = service.SigningRouter()
+		handler = srv.SigningRouter()
 		address = ":8080"
+
+		// Purge the expired nonces in the background
+		go janitor.Run(context.Background(), datastore.Environ, janitor.Interval(datastore.Environ.Config))
+
+		// Retry the signing logs that could not be written to the sink in the background
+		if datastore.Environ.SigningLogSink != nil {
+			go logsink.Run(context.Background(), logsink.Interval())
+		}
+
+		// Elect the active vault of the failover pair in the background. The vault signs once
+		// it is promoted
+		if datastore.Environ.Config.Failover {
+			go failover.Run(context.Background(), datastore.Environ, failover.Interval(datastore.Environ.Config))
+		}
+	}
+
+	// Load the signing-keys that are added to the keystore after it is opened e.g. by the factory sync
+	go keyreload.Run(context.Background(), datastore.Environ, keyreload.Interval(datastore.Environ.Config))
+
+	// Load the signing-keys as soon as they are changed by another instance, instead of waiting
+	// for the next reload. The in-memory data of the dev mode is not shared
+	invalidation.Subscribe(invalidation.Keypair, func(invalidation.Event) {
+		keyreload.Reload(context.Background(), datastore.Environ)
+	})
+	if !config.DevMode {
+		invalidation.Use(invalidation.Open(datastore.Environ))
+	}
+	go invalidation.Run(context.Background())
+
+	// Register in the instance registry. Factories register with the cloud when they sync
+	if !datastore.InFactory() {
+		go instance.Run(context.Background(), datastore.Environ, mode, instance.Interval(datastore.Environ.Config))
+	}
+
+	// Serve on the socket that is passed by systemd when the service is socket activated, so the
+	// socket is not dropped when the service is restarted
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("Error opening the systemd sockets: %v", err)
+	}
+	var listener net.Listener
+	if len(listeners) > 0 {
+		listener = listeners[0]
+		svlog.Infof("Starting service on the systemd socket %s", listener.Addr())
+	} else {
+		listener, err = net.Listen("tcp", address)
+		if err != nil {
+			log.Fatalf("Error listening on port %s: %v", address, err)
+		}
+		svlog.Infof("Starting service on port %s", address)
+	}
+
+	// Tell systemd that the service is ready, and ping its watchdog when it is enabled
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		svlog.Errorf("Error notifying systemd: %v", err)
 	}
+	go systemd.RunWatchdog(context.Background(), systemd.WatchdogInterval())
 
-	svlog.InitLogger(logging.INFO)
-	svlog.Infof("Starting service on port %s", address)
-	log.Fatal(http.ListenAndServe(address, handler))
+	log.Fatal(srv.Serve(srv.HTTPServer(address, handler), listener))
 }
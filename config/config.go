@@ -50,6 +50,361 @@ type Settings struct {
 	SyncURL        string `yaml:"syncUrl"`
 	SyncUser       string `yaml:"syncUser"`
 	SyncAPIKey     string `yaml:"syncAPIKey"`
+
+	// InstanceID identifies this vault in the signing audit trail and the instance registry
+	// (defaults to the hostname), InstanceRole is one of cloud, factory or proxy (defaults to
+	// factory for a sqlite database or a failover pair) and InstanceHeartbeatInterval is the time
+	// in seconds between its heartbeats
+	InstanceID                string `yaml:"instanceID"`
+	InstanceRole              string `yaml:"instanceRole"`
+	InstanceHeartbeatInterval int    `yaml:"instanceHeartbeatInterval"`
+
+	// Failover runs the signing service as one of an active/standby pair of vaults that share a
+	// Postgres database, and FailoverInterval is the time in seconds between the checks of the
+	// leader lock (zero uses the default)
+	Failover         bool `yaml:"failover"`
+	FailoverInterval int  `yaml:"failoverInterval"`
+
+	// FactoryCheckInSilence is the time in seconds without a check-in before the cloud reports
+	// a factory as silent (zero uses the default)
+	FactoryCheckInSilence int `yaml:"factoryCheckInSilence"`
+
+	// SyncRequestTimeout limits each request to the cloud serial-vault and SyncCycleTimeout
+	// limits a complete sync cycle, in seconds (zero uses the default)
+	SyncRequestTimeout int `yaml:"syncRequestTimeout"`
+	SyncCycleTimeout   int `yaml:"syncCycleTimeout"`
+
+	// SyncParallelism is the number of logs uploaded concurrently to the cloud serial-vault
+	SyncParallelism int `yaml:"syncParallelism"`
+
+	// DatastoreTimeout is the latency budget in seconds for the datastore queries of a
+	// signing request, and KeystoreTimeout is the limit for a signing operation (zero is unlimited)
+	DatastoreTimeout int `yaml:"datastoreTimeout"`
+	KeystoreTimeout  int `yaml:"keystoreTimeout"`
+
+	// KeystoreReloadInterval is the time in seconds between the reloads of the signing-keys
+	// that were added since the keystore was opened (zero uses the default)
+	KeystoreReloadInterval int `yaml:"keystoreReloadInterval"`
+
+	// KeystoreConcurrency is the limit of the signings that run at the same time on a signing-key,
+	// or on the keystore backend when KeystoreConcurrencyScope is "backend" (zero is unlimited).
+	// The signings over the limit wait for KeystoreQueueTimeout seconds (zero waits for the KeystoreTimeout)
+	KeystoreConcurrency      int    `yaml:"keystoreConcurrency"`
+	KeystoreConcurrencyScope string `yaml:"keystoreConcurrencyScope"`
+	KeystoreQueueTimeout     int    `yaml:"keystoreQueueTimeout"`
+
+	// ResponseCompression compresses the responses of the admin list methods with gzip or deflate,
+	// when the client accepts it
+	ResponseCompression bool `yaml:"responseCompression"`
+
+	// TLSCertFile and TLSKeyFile terminate TLS in the service itself, which also serves HTTP/2
+	// unless DisableHTTP2 is set. The certificate is reloaded when the files are renewed
+	TLSCertFile  string `yaml:"tlsCertFile"`
+	TLSKeyFile   string `yaml:"tlsKeyFile"`
+	DisableHTTP2 bool   `yaml:"disableHTTP2"`
+
+	// TLSClientCAFile is the PEM file of the CAs that verify the TLS client certificates, which are
+	// requested when the service terminates TLS (optional)
+	TLSClientCAFile string `yaml:"tlsClientCAFile"`
+
+	// ACMEDomains terminate TLS with the certificates of the domains from an ACME server (Let's
+	// Encrypt, unless ACMEDirectoryURL is set), instead of the certificate files. The certificates
+	// are cached in ACMECacheDir, and the http-01 challenges are answered on ACMEHTTPAddress
+	ACMEDomains      []string `yaml:"acmeDomains"`
+	ACMEEmail        string   `yaml:"acmeEmail"`
+	ACMEDirectoryURL string   `yaml:"acmeDirectoryURL"`
+	ACMECacheDir     string   `yaml:"acmeCacheDir"`
+	ACMEHTTPAddress  string   `yaml:"acmeHTTPAddress"`
+
+	// DatastoreMaxOpenConns and DatastoreMaxIdleConns limit the connection pool of the database, and
+	// DatastoreConnMaxLifetime is the time in seconds before a connection is recycled (zero uses the default)
+	DatastoreMaxOpenConns    int `yaml:"datastoreMaxOpenConns"`
+	DatastoreMaxIdleConns    int `yaml:"datastoreMaxIdleConns"`
+	DatastoreConnMaxLifetime int `yaml:"datastoreConnMaxLifetime"`
+
+	// DatastoreStatementCache is "prepare" to prepare the query statements on the database session,
+	// or "none" to send the parameters with the query e.g. behind pgbouncer in transaction pooling mode
+	DatastoreStatementCache string `yaml:"datastoreStatementCache"`
+
+	// DatastoreSlowQuery is the time in milliseconds after which a datastore query is recorded
+	// in the slow-query log, with its parameters redacted (zero uses the default)
+	DatastoreSlowQuery int `yaml:"datastoreSlowQuery"`
+
+	// BreakerFailures is the number of consecutive datastore failures in the signing path
+	// that open the circuit breaker, and BreakerCooldown is the time in seconds that the
+	// requests are shed before the datastore is probed (zero uses the default)
+	BreakerFailures int `yaml:"breakerFailures"`
+	BreakerCooldown int `yaml:"breakerCooldown"`
+
+	// SigningQueueCapacity is the number of signing requests the vault is sized to process at a
+	// time, and SigningQueueWatermark is the utilization percentage of the capacity above which the
+	// signing requests are rejected, telling the clients to retry after SigningQueueRetryAfter
+	// seconds (zero uses the default, and a zero watermark never rejects the requests)
+	SigningQueueCapacity   int `yaml:"signingQueueCapacity"`
+	SigningQueueWatermark  int `yaml:"signingQueueWatermark"`
+	SigningQueueRetryAfter int `yaml:"signingQueueRetryAfter"`
+
+	// NonceRateLimit is the number of nonces an API key may request per minute, before it is
+	// banned from requesting nonces for NonceBanDuration seconds (zero uses the default). The
+	// nonces are counted, and the API key is banned, by each signing instance
+	NonceRateLimit   int `yaml:"nonceRateLimit"`
+	NonceBanDuration int `yaml:"nonceBanDuration"`
+
+	// NonceJanitorInterval is the time in seconds between the purges of the expired nonces
+	NonceJanitorInterval int `yaml:"nonceJanitorInterval"`
+
+	// NonceBinding is "apikey" (the default) to only accept a nonce in a serial-request with the
+	// API key that requested it, "apikey-ip" to also require the same client IP, or "none" to
+	// accept a nonce with any API key, as in earlier versions
+	NonceBinding string `yaml:"nonceBinding"`
+
+	// SerialReplayWindow is the time in seconds that an exact replay of a serial-request returns the
+	// serial assertion that was signed for it, instead of signing a new revision, for the models whose
+	// duplicate policy allows replays (zero uses the default, negative disables the replay detection)
+	SerialReplayWindow int `yaml:"serialReplayWindow"`
+
+	// SerialLint is the policy for the signed serial assertions that violate the content rules:
+	// "log" (the default) to log the violations, "block" to also reject the serial assertion, or
+	// "off". SerialLintRules are the rules that are checked: "required-headers", "authority-brand",
+	// "timestamp" and "key-id" (all of them when empty)
+	SerialLint      string   `yaml:"serialLint"`
+	SerialLintRules []string `yaml:"serialLintRules"`
+
+	// RefuseSigningDeviceStates are the lifecycle states of the devices whose serial numbers are
+	// not signed again e.g. "scrapped". The devices are signed in any state when it is empty
+	RefuseSigningDeviceStates []string `yaml:"refuseSigningDeviceStates"`
+
+	// RevokeDeviceStates are the lifecycle states that revoke a device e.g. "scrapped". The revoked
+	// devices of a brand are listed in its signed revocation list, which the signing service serves,
+	// and each revocation is published to the RevocationPublishURL e.g. an endpoint of the store API,
+	// with the RevocationPublishAuth as its Authorization header (optional). The devices are not
+	// revoked when it is empty
+	RevokeDeviceStates    []string `yaml:"revokeDeviceStates"`
+	RevocationPublishURL  string   `yaml:"revocationPublishURL"`
+	RevocationPublishAuth string   `yaml:"revocationPublishAuth"`
+
+	// DeviceCertificates issues an X.509 device certificate to each signed device, binding its serial
+	// number to its device-key, from the device CA of the vault. The certificates are valid for
+	// DeviceCertificateDays (zero uses the default), and point to the CRL and status endpoints of the
+	// signing service at DeviceCertificateURL e.g. https://serial-vault.example.com (optional)
+	DeviceCertificates    bool   `yaml:"deviceCertificates"`
+	DeviceCertificateDays int    `yaml:"deviceCertificateDays"`
+	DeviceCertificateURL  string `yaml:"deviceCertificateURL"`
+
+	// PolicyHook is the external policy engine that allows or denies each signing request, for the
+	// bespoke rules of the brands (optional)
+	PolicyHook PolicyHook `yaml:"policyHook"`
+
+	// MaintenanceWindows are the scheduled times during which the signing requests are rejected
+	// with a maintenance error, e.g. for a database migration or a key ceremony
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenanceWindows"`
+
+	// ModelNameCase are the case policies of the model names of the brands. The model names of a
+	// brand are "lower" case (the default), or kept as they are with the "exact" policy
+	ModelNameCase []ModelNameCase `yaml:"modelNameCase"`
+
+	// ClientIPHeader is the request header that holds the client IP when the service is behind
+	// a proxy e.g. X-Forwarded-For, instead of the remote address of the connection
+	ClientIPHeader string `yaml:"clientIPHeader"`
+
+	// ClientCertHeader is the request header that holds the subject of the TLS client certificate
+	// when the TLS connection is terminated by a proxy e.g. X-SSL-Client-S-DN
+	ClientCertHeader string `yaml:"clientCertHeader"`
+
+	// TrustedProxies are the addresses, or CIDR networks, of the TLS proxies that the ClientCertHeader
+	// is accepted from. The header is never accepted when the service terminates TLS itself
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// EndpointAuth are the authentication schemes that the endpoint groups accept, which are checked
+	// before the handlers. The groups without a rule keep the authentication of their handlers
+	EndpointAuth []EndpointAuth `yaml:"endpointAuth"`
+
+	// SigningLogBodyFields is the allowlist of serial-request body fields stored in the signing log
+	SigningLogBodyFields []string `yaml:"signingLogBodyFields"`
+
+	// The origin of the signing requests that is stored in the signing log. SigningLogClientIP is
+	// "full" (the default), "truncated" to the /24 network (/48 for IPv6) or "none". The country
+	// is found in the SigningLogGeoIPDatabase, a CSV file of networks and their ISO country codes,
+	// before the client IP is truncated. SigningLogTLSIdentity is "subject" (the default) to store
+	// the subject of the TLS client certificate or "none"
+	SigningLogClientIP      string `yaml:"signingLogClientIP"`
+	SigningLogGeoIPDatabase string `yaml:"signingLogGeoIPDatabase"`
+	SigningLogTLSIdentity   string `yaml:"signingLogTLSIdentity"`
+
+	// SigningLogSink is the write-once storage that the signing log entries are also written to
+	// at sign time: "s3" for S3 object-lock storage, or empty for none. SigningLogSinkPolicy is
+	// "block" to fail the signing request when the entry cannot be written, or "queue" to return
+	// the assertion anyway. The failed entries are queued and retried every SigningLogSinkRetryInterval seconds
+	SigningLogSink              string `yaml:"signingLogSink"`
+	SigningLogSinkPolicy        string `yaml:"signingLogSinkPolicy"`
+	SigningLogSinkRetryInterval int    `yaml:"signingLogSinkRetryInterval"`
+
+	// The S3 bucket of the signing log sink, which must have object lock enabled. The entries are
+	// locked for SigningLogS3RetentionDays in the SigningLogS3RetentionMode (COMPLIANCE or GOVERNANCE),
+	// or with the default retention of the bucket when the days are zero
+	SigningLogS3Endpoint      string `yaml:"signingLogS3Endpoint"`
+	SigningLogS3Region        string `yaml:"signingLogS3Region"`
+	SigningLogS3Bucket        string `yaml:"signingLogS3Bucket"`
+	SigningLogS3AccessKey     string `yaml:"signingLogS3AccessKey"`
+	SigningLogS3SecretKey     string `yaml:"signingLogS3SecretKey"`
+	SigningLogS3RetentionMode string `yaml:"signingLogS3RetentionMode"`
+	SigningLogS3RetentionDays int    `yaml:"signingLogS3RetentionDays"`
+
+	// LogSinks are the destinations of the service logs, each with its own level. The logs
+	// are written to stderr when none are configured
+	LogSinks []LogSink `yaml:"logSinks"`
+
+	// Approvals are the sensitive operations that need the approval of a second admin before
+	// they complete. The operations that are not listed complete without an approval
+	Approvals []ApprovalRule `yaml:"approvals"`
+
+	// ImpersonationNotify are the notification hooks of the brands, which are sent the start of
+	// the support sessions in which a superuser acts as an admin of the brand (optional)
+	ImpersonationNotify []ImpersonationNotify `yaml:"impersonationNotify"`
+
+	// ReplicationPeers are the vaults that the signing log is replicated from, so that each vault
+	// has the registry of the devices signed by the others. The entries of the peers are fetched
+	// every ReplicationInterval seconds. The signing-keys are not replicated
+	ReplicationPeers    []ReplicationPeer `yaml:"replicationPeers"`
+	ReplicationInterval int               `yaml:"replicationInterval"`
+
+	// KeyUsageAlerts are the model allowlists of the signing-keys and the notification hooks that
+	// are sent an alert when a signing-key signs for a brand/model that it has not signed before,
+	// or that is not in its allowlist (optional)
+	KeyUsageAlerts []KeyUsageAlert `yaml:"keyUsageAlerts"`
+
+	// BillingWebhook is the hook that is sent the usage of each billing period when it is
+	// closed, e.g. the endpoint of the billing system (optional)
+	BillingWebhook string `yaml:"billingWebhook"`
+
+	// Directory is the LDAP or Active Directory server whose groups are synced to the users, roles
+	// and account memberships of the vault every Directory.Interval seconds (optional)
+	Directory Directory `yaml:"directory"`
+}
+
+// MaintenanceWindow is a time during which signing is paused. The Start and End are RFC3339 times.
+// The window applies to all the models, to the models of the Brand, or to one Model of the Brand.
+// The Reason is returned to the devices in the maintenance error
+type MaintenanceWindow struct {
+	Start  string `yaml:"start"`
+	End    string `yaml:"end"`
+	Brand  string `yaml:"brand"`
+	Model  string `yaml:"model"`
+	Reason string `yaml:"reason"`
+}
+
+// PolicyHook is the external policy engine that decides the signing requests: the data API of an
+// Open Policy Agent e.g. http://localhost:8181/v1/data/serialvault/sign, which is sent the context
+// of each request. The Authorization is sent as its header (optional), and the Timeout is in
+// seconds (default 2). When the engine fails, the requests are denied unless the FailurePolicy is
+// "allow". The Brands limit the hook to the signing requests of the brands (all when empty)
+type PolicyHook struct {
+	URL           string   `yaml:"url"`
+	Authorization string   `yaml:"authorization"`
+	Timeout       int      `yaml:"timeout"`
+	FailurePolicy string   `yaml:"failurePolicy"`
+	Brands        []string `yaml:"brands"`
+}
+
+// EndpointAuth is the rule of an endpoint group: "sign" (the signing service API), "admin" (the
+// admin web UI API), "api" (the admin API) or "sync" (the admin API that the factories sync with).
+// The Schemes are "apikey" (the api-key header of a model), "user-apikey" (the user and api-key
+// headers of a user), "mtls" (a verified TLS client certificate, or the ClientCertHeader of a
+// trusted proxy that terminated TLS) and "jwt" (the token of the OpenID login). A request must present one
+// of the schemes, or all of them when the Match is "all"
+type EndpointAuth struct {
+	Group   string   `yaml:"group"`
+	Schemes []string `yaml:"schemes"`
+	Match   string   `yaml:"match"`
+}
+
+// ModelNameCase is the case policy of the model names of the Brand, or of all the brands when
+// the Brand is empty. The Case is "lower" or "exact"
+type ModelNameCase struct {
+	Brand string `yaml:"brand"`
+	Case  string `yaml:"case"`
+}
+
+// ApprovalRule makes an operation need the approval of a second admin: "keypair-enable",
+// "model-signing-key", "quota-raise" or "keypair-export". The NotifyURL is sent the approvals
+// of the operation when they are requested and decided (optional). The "brand-root-key"
+// operation always needs an approval, so its rule only sets the NotifyURL
+type ApprovalRule struct {
+	Operation string `yaml:"operation"`
+	NotifyURL string `yaml:"notifyURL"`
+}
+
+// ImpersonationNotify is the notification hook of the Brand, or of all the brands when the Brand
+// is empty. The NotifyURL is sent the audit log entry that starts each support session
+type ImpersonationNotify struct {
+	Brand     string `yaml:"brand"`
+	NotifyURL string `yaml:"notifyURL"`
+}
+
+// ReplicationPeer is a vault that the signing log is replicated from. The URL is the HTTPS address
+// of the admin API of the peer e.g. https://serial-vault.example.com/api/, and the Username and
+// APIKey are the credentials of a sync user of the peer, whose accounts are replicated
+type ReplicationPeer struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	APIKey   string `yaml:"apiKey"`
+}
+
+// KeyUsageAlert is the model allowlist of the signing-key with the KeyID, or of all the signing-keys
+// when the KeyID is empty. The Models are "brand/model" names, and an empty list allows all the
+// models. The NotifyURL is sent the alerts of the signing-key (optional)
+type KeyUsageAlert struct {
+	KeyID     string   `yaml:"keyID"`
+	Models    []string `yaml:"models"`
+	NotifyURL string   `yaml:"notifyURL"`
+}
+
+// Directory is an LDAP or Active Directory server. The URL is ldaps://host:636, or ldap://host:389 with
+// StartTLS, so the bind password is always encrypted. The certificate of the server is verified with the
+// system CAs, or the CA certificates in the CACert PEM file. The BindDN and BindPassword are the
+// credentials of a read-only account. The members of the Groups are
+// found under the BaseDN by their MemberOfAttribute, and their username, name and email are read from
+// the UsernameAttribute (default "uid", "sAMAccountName" for Active Directory), NameAttribute (default
+// "cn") and EmailAttribute (default "mail"). With DryRun, the scheduled sync only reports the changes
+type Directory struct {
+	URL               string           `yaml:"url"`
+	StartTLS          bool             `yaml:"startTLS"`
+	CACert            string           `yaml:"caCert"`
+	BindDN            string           `yaml:"bindDN"`
+	BindPassword      string           `yaml:"bindPassword"`
+	BaseDN            string           `yaml:"baseDN"`
+	UsernameAttribute string           `yaml:"usernameAttribute"`
+	NameAttribute     string           `yaml:"nameAttribute"`
+	EmailAttribute    string           `yaml:"emailAttribute"`
+	MemberOfAttribute string           `yaml:"memberOfAttribute"`
+	Interval          int              `yaml:"interval"`
+	DryRun            bool             `yaml:"dryRun"`
+	Groups            []DirectoryGroup `yaml:"groups"`
+}
+
+// DirectoryGroup maps the members of the directory group with the DN to a role of the vault: "standard",
+// "syncuser", "admin" or "superuser", and to the Accounts with the authority-ids. A user that is a member
+// of several groups has the highest of their roles and all of their accounts
+type DirectoryGroup struct {
+	DN       string   `yaml:"dn"`
+	Role     string   `yaml:"role"`
+	Accounts []string `yaml:"accounts"`
+}
+
+// LogSink is a destination of the service logs: "stderr", "stdout", "syslog", "journald" or "file".
+// The Format is "text" or "json" (the default for stdout). A file at Path is rotated when it reaches
+// MaxSize megabytes or at the Rotate interval ("hourly" or "daily"), keeping MaxBackups rotated files.
+// The Tag identifies the service in syslog and journald
+type LogSink struct {
+	Type       string `yaml:"type"`
+	Level      string `yaml:"level"`
+	Format     string `yaml:"format"`
+	Path       string `yaml:"path"`
+	MaxSize    int    `yaml:"maxSize"`
+	Rotate     string `yaml:"rotate"`
+	MaxBackups int    `yaml:"maxBackups"`
+	Tag        string `yaml:"tag"`
 }
 
 // SettingsFile is the path to the YAML configuration file
@@ -58,10 +413,15 @@ var SettingsFile string
 // ServiceMode is whether we are running the user or admin service
 var ServiceMode string
 
+// DevMode runs the signing and admin services with an in-memory datastore and keystore,
+// seeded with example data, for development
+var DevMode bool
+
 // ParseArgs checks the command line arguments
 func ParseArgs() {
 	flag.StringVar(&SettingsFile, "config", "./settings.yaml", "Path to the config file")
 	flag.StringVar(&ServiceMode, "mode", "", "Mode of operation: signing, admin or system-user service ")
+	flag.BoolVar(&DevMode, "dev", false, "Run with an in-memory datastore and keystore, seeded with example data, for development")
 	flag.Parse()
 }
 
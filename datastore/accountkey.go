@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"errors"
+	"log"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+const createAccountKeyTableSQL = `
+	CREATE TABLE IF NOT EXISTS accountkey (
+		id            serial primary key not null,
+		authority_id  varchar(200) not null,
+		key_id        varchar(200) not null,
+		assertion     text not null,
+		UNIQUE (authority_id, key_id)
+	)
+`
+
+const getAccountKeySQL = "SELECT id, authority_id, key_id, assertion FROM accountkey WHERE authority_id=$1 AND key_id=$2"
+
+const syncUpsertAccountKeySQL = `
+	INSERT OR REPLACE INTO accountkey
+	(authority_id, key_id, assertion)
+	VALUES ($1, $2, $3)
+`
+
+// AccountKey holds the account-key assertion of a signing-key of an account. The factory
+// keeps the account-key assertions that are synced from the cloud, as it may not reach the store
+type AccountKey struct {
+	ID          int
+	AuthorityID string
+	KeyID       string
+	Assertion   string
+}
+
+// CreateAccountKeyTable creates the database table for the account-key assertions
+func (db *DB) CreateAccountKeyTable() error {
+	_, err := db.Exec(createAccountKeyTableSQL)
+	return err
+}
+
+// GetAccountKey returns the account-key assertion of the signing-key of the account
+func (db *DB) GetAccountKey(authorityID, keyID string) (AccountKey, error) {
+	key := AccountKey{}
+	err := db.QueryRow(getAccountKeySQL, authorityID, keyID).Scan(&key.ID, &key.AuthorityID, &key.KeyID, &key.Assertion)
+	if err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// SyncAccountKey stores the account-key assertion that is synced from the cloud
+func (db *DB) SyncAccountKey(key AccountKey) error {
+	if err := ValidateAccountKey(key); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(syncUpsertAccountKeySQL, key.AuthorityID, key.KeyID, key.Assertion)
+	if err != nil {
+		log.Printf("Error updating the account-key assertion: %v\n", err)
+	}
+	return err
+}
+
+// ValidateAccountKey checks that the assertion is the account-key assertion of the signing-key
+// of the account
+func ValidateAccountKey(key AccountKey) error {
+	assertion, err := asserts.Decode([]byte(key.Assertion))
+	if err != nil {
+		return err
+	}
+	if assertion.Type() != asserts.AccountKeyType {
+		return errors.New("The assertion is not an account-key assertion")
+	}
+	if assertion.HeaderString("account-id") != key.AuthorityID {
+		return errors.New("The account-key assertion is not for the account")
+	}
+	if assertion.HeaderString("public-key-sha3-384") != key.KeyID {
+		return errors.New("The account-key assertion is not for the signing-key")
+	}
+	return nil
+}
+
+// ValidateAccountAssertion checks that the assertion of the account, when there is one, is the
+// account assertion of the account
+func ValidateAccountAssertion(account Account) error {
+	if len(account.Assertion) == 0 {
+		return nil
+	}
+
+	assertion, err := asserts.Decode([]byte(account.Assertion))
+	if err != nil {
+		return err
+	}
+	if assertion.Type() != asserts.AccountType {
+		return errors.New("The assertion is not an account assertion")
+	}
+	if assertion.HeaderString("account-id") != account.AuthorityID {
+		return errors.New("The account assertion is not for the account")
+	}
+	return nil
+}
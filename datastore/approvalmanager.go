@@ -0,0 +1,281 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sensitive operations that can be configured to need the approval of a second admin
+const (
+	ApprovalKeypairEnable   = "keypair-enable"    // activating a signing-key
+	ApprovalModelSigningKey = "model-signing-key" // changing the signing-keys of a model
+	ApprovalQuotaRaise      = "quota-raise"       // raising the limit of an integer config setting
+	ApprovalKeypairExport   = "keypair-export"    // exporting the signing-keys to a factory
+	ApprovalBrandRootKey    = "brand-root-key"    // replacing or removing the root key of a brand, always approved
+)
+
+// Statuses of an approval
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+	ApprovalCompleted = "completed"
+)
+
+// ApprovalLifetime is the time that an approval is valid for, from when it was requested
+const ApprovalLifetime = 24 * time.Hour
+
+// Approval errors
+var (
+	ErrSelfApproval     = errors.New("An operation must be approved by a different user than the one that requested it")
+	ErrApprovalDecided  = errors.New("The approval has already been decided")
+	ErrApprovalNotFound = errors.New("Cannot find the approval")
+	ErrApprovalAccount  = errors.New("You do not have permissions to the account of the approval")
+)
+
+const createApprovalTableSQL = `
+	CREATE TABLE IF NOT EXISTS approval (
+		id               serial primary key not null,
+		operation        varchar(100) not null,
+		target           varchar(200) not null,
+		authority_id     varchar(200) default '',
+		details          text default '',
+		requested_by     varchar(200) not null,
+		status           varchar(20) not null,
+		decided_by       varchar(200) default '',
+		created          timestamp default current_timestamp,
+		decided          timestamp
+	)
+`
+
+// Indexes
+const createApprovalIndexSQL = "CREATE INDEX IF NOT EXISTS approval_idx ON approval (operation, target)"
+
+const alterApprovalAuthoritySQL = "ALTER TABLE approval ADD COLUMN authority_id varchar(200) default ''"
+
+const findApprovalSQL = `
+	SELECT id, operation, target, authority_id, details, requested_by, status, decided_by, created, decided
+	FROM approval
+	WHERE operation=$1 AND target=$2 AND details=$3 AND requested_by=$4 AND status IN ('pending', 'approved') AND created>$5
+	ORDER BY id DESC LIMIT 1
+	FOR UPDATE`
+
+const createApprovalSQL = `
+	INSERT INTO approval (operation, target, authority_id, details, requested_by, status)
+	VALUES ($1,$2,$3,$4,$5,'pending')
+	RETURNING id, created`
+
+const getApprovalForUpdateSQL = `
+	SELECT id, operation, target, authority_id, details, requested_by, status, decided_by, created, decided
+	FROM approval WHERE id=$1 FOR UPDATE`
+
+const completeApprovalSQL = "UPDATE approval SET status='completed' WHERE id=$1"
+const decideApprovalSQL = "UPDATE approval SET status=$2, decided_by=$3, decided=$4 WHERE id=$1"
+
+const listApprovalsSQL = "SELECT a.id, a.operation, a.target, a.authority_id, a.details, a.requested_by, a.status, a.decided_by, a.created, a.decided FROM approval a"
+const listApprovalsForUserSQL = `
+	EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=a.authority_id and u.username=$%d
+	)`
+
+// Approval is a request for a sensitive operation, which completes once a second admin has
+// approved it. The requester repeats the operation after the approval, and the approval is
+// completed by it, so each approval allows the operation once
+type Approval struct {
+	ID          int        `json:"id"`
+	Operation   string     `json:"operation"`
+	Target      string     `json:"target"`       // e.g. keypair/1
+	AuthorityID string     `json:"authority-id"` // the account of the target, empty for the global settings
+	Details     string     `json:"details"`      // the change to the target, which must match when it is repeated
+	RequestedBy string     `json:"requested-by"`
+	Status      string     `json:"status"`
+	DecidedBy   string     `json:"decided-by"`
+	Created     time.Time  `json:"created"`
+	Decided     *time.Time `json:"decided,omitempty"`
+}
+
+// ValidateApproval checks the operation, target and requester of an approval
+func ValidateApproval(approval Approval) error {
+	switch approval.Operation {
+	case ApprovalKeypairEnable, ApprovalModelSigningKey, ApprovalQuotaRaise, ApprovalKeypairExport, ApprovalBrandRootKey:
+	default:
+		return errors.New("Invalid approval operation")
+	}
+	if err := validateNotEmpty("target", approval.Target); err != nil {
+		return err
+	}
+	return validateNotEmpty("requested by", approval.RequestedBy)
+}
+
+// CreateApprovalTable creates the database table for the approvals of the sensitive operations
+func (db *DB) CreateApprovalTable() error {
+	if _, err := db.Exec(createApprovalTableSQL); err != nil {
+		return err
+	}
+
+	// Ignore the error as the column may already exist
+	db.Exec(alterApprovalAuthoritySQL)
+
+	_, err := db.Exec(createApprovalIndexSQL)
+	return err
+}
+
+// RequestApproval finds the approval of the operation for the requester. An approved approval
+// is completed, so the operation can go ahead. Otherwise the pending approval is returned,
+// creating it if there is none, and the flag reports if it has been created
+func (db *DB) RequestApproval(approval Approval) (Approval, bool, error) {
+	if err := ValidateApproval(approval); err != nil {
+		return approval, false, err
+	}
+
+	var created bool
+	err := db.transaction(func(tx *sql.Tx) error {
+		a, err := scanApproval(tx.QueryRow(findApprovalSQL, approval.Operation, approval.Target, approval.Details,
+			approval.RequestedBy, time.Now().UTC().Add(-ApprovalLifetime)))
+		switch {
+		case err == sql.ErrNoRows:
+			approval.Status = ApprovalPending
+			created = true
+			return tx.QueryRow(createApprovalSQL, approval.Operation, approval.Target, approval.AuthorityID, approval.Details, approval.RequestedBy).Scan(&approval.ID, &approval.Created)
+		case err != nil:
+			return err
+		case a.Status == ApprovalApproved:
+			a.Status = ApprovalCompleted
+			_, err = tx.Exec(completeApprovalSQL, a.ID)
+		}
+		approval = a
+		return err
+	})
+	if err != nil {
+		log.Printf("Error requesting the approval: %v\n", err)
+	}
+	return approval, created, err
+}
+
+// DecideApproval approves or rejects a pending approval. The approver must be a different
+// user than the requester, with write access to the account of the approval. An approval
+// without an account can only be decided by a superuser
+func (db *DB) DecideApproval(approvalID int, approved bool, authorization User) (Approval, error) {
+	var approval Approval
+	err := db.transaction(func(tx *sql.Tx) error {
+		a, err := scanApproval(tx.QueryRow(getApprovalForUpdateSQL, approvalID))
+		switch {
+		case err == sql.ErrNoRows:
+			return ErrApprovalNotFound
+		case err != nil:
+			return err
+		}
+		if !db.canWriteAuthority(authorization, a.AuthorityID) {
+			return ErrApprovalAccount
+		}
+
+		if err := a.Decide(approved, authorization.Username, time.Now().UTC()); err != nil {
+			return err
+		}
+		approval = a
+		_, err = tx.Exec(decideApprovalSQL, a.ID, a.Status, a.DecidedBy, a.Decided)
+		return err
+	})
+	return approval, err
+}
+
+// ListAllowedApprovals returns the approvals with the status, or all the approvals when it is
+// empty, for the accounts that the user is authorized to see. The approvals without an account
+// are only listed for the superusers
+func (db *DB) ListAllowedApprovals(authorization User, status string) ([]Approval, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+	case Admin:
+		args = append(args, authorization.Username)
+		conditions = append(conditions, fmt.Sprintf(listApprovalsForUserSQL, len(args)))
+	default:
+		return []Approval{}, nil
+	}
+	if len(status) > 0 {
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("a.status=$%d", len(args)))
+	}
+
+	query := listApprovalsSQL
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := db.Query(query+" ORDER BY a.id DESC", args...)
+	if err != nil {
+		log.Printf("Error retrieving the approvals: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := []Approval{}
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
+
+// Decide checks that the approval can be decided by the user, and records the decision
+func (a *Approval) Decide(approved bool, decidedBy string, now time.Time) error {
+	if a.Status != ApprovalPending {
+		return ErrApprovalDecided
+	}
+	if a.Created.Add(ApprovalLifetime).Before(now) {
+		return errors.New("The approval has expired")
+	}
+	if a.RequestedBy == decidedBy {
+		return ErrSelfApproval
+	}
+
+	a.Status = ApprovalRejected
+	if approved {
+		a.Status = ApprovalApproved
+	}
+	a.DecidedBy = decidedBy
+	a.Decided = &now
+	return nil
+}
+
+type approvalScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanApproval(row approvalScanner) (Approval, error) {
+	a := Approval{}
+	err := row.Scan(&a.ID, &a.Operation, &a.Target, &a.AuthorityID, &a.Details, &a.RequestedBy, &a.Status, &a.DecidedBy, &a.Created, &a.Decided)
+	return a, err
+}
@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateApproval(t *testing.T) {
+	tests := []struct {
+		approval Approval
+		valid    bool
+	}{
+		{Approval{Operation: ApprovalKeypairEnable, Target: "keypair/1", RequestedBy: "sv"}, true},
+		{Approval{Operation: ApprovalQuotaRaise, Target: "setting/signing/nonce-rate-limit", Details: "1000", RequestedBy: "sv"}, true},
+		{Approval{Operation: "invalid", Target: "keypair/1", RequestedBy: "sv"}, false},
+		{Approval{Operation: ApprovalKeypairEnable, RequestedBy: "sv"}, false},
+		{Approval{Operation: ApprovalKeypairEnable, Target: "keypair/1"}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateApproval(tt.approval)
+		if (err == nil) != tt.valid {
+			t.Errorf("Approval %v: expected valid %v, got %v", tt.approval, tt.valid, err)
+		}
+	}
+}
+
+func TestApprovalDecide(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		approval  Approval
+		approved  bool
+		decidedBy string
+		status    string
+		err       bool
+	}{
+		{Approval{RequestedBy: "sv", Status: ApprovalPending, Created: now}, true, "root", ApprovalApproved, false},
+		{Approval{RequestedBy: "sv", Status: ApprovalPending, Created: now}, false, "root", ApprovalRejected, false},
+		{Approval{RequestedBy: "sv", Status: ApprovalPending, Created: now}, true, "sv", ApprovalPending, true},
+		{Approval{RequestedBy: "sv", Status: ApprovalApproved, Created: now}, true, "root", ApprovalApproved, true},
+		{Approval{RequestedBy: "sv", Status: ApprovalPending, Created: now.Add(-ApprovalLifetime - time.Hour)}, true, "root", ApprovalPending, true},
+	}
+
+	for _, tt := range tests {
+		a := tt.approval
+		err := a.Decide(tt.approved, tt.decidedBy, now)
+		if (err != nil) != tt.err {
+			t.Errorf("Decide by %s: expected error %v, got %v", tt.decidedBy, tt.err, err)
+		}
+		if a.Status != tt.status {
+			t.Errorf("Decide by %s: expected status %s, got %s", tt.decidedBy, tt.status, a.Status)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Actions of the audit log that are not a request to the admin API
+const (
+	AuditImpersonationStart = "impersonation-start"
+	AuditImpersonationEnd   = "impersonation-end"
+	AuditNonceBan           = "nonce-ban"
+)
+
+const createAuditLogTableSQL = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id               serial primary key not null,
+		username         varchar(200) not null,
+		impersonator     varchar(200) default '',
+		brand            varchar(200) default '',
+		action           varchar(200) not null,
+		status           int default 0,
+		created          timestamp default current_timestamp
+	)
+`
+
+// Indexes
+const createAuditLogIndexSQL = "CREATE INDEX IF NOT EXISTS audit_log_impersonator_idx ON audit_log (impersonator)"
+
+const createAuditEntrySQL = `
+	INSERT INTO audit_log (username, impersonator, brand, action, status)
+	VALUES ($1,$2,$3,$4,$5)
+	RETURNING id, created`
+
+const listAuditLogSQL = `
+	SELECT id, username, impersonator, brand, action, status, created
+	FROM audit_log ORDER BY id DESC LIMIT 1000`
+
+const listAuditLogForImpersonatorSQL = `
+	SELECT id, username, impersonator, brand, action, status, created
+	FROM audit_log WHERE impersonator=$1 ORDER BY id DESC LIMIT 1000`
+
+// AuditEntry is an action of an admin user. The Impersonator is the superuser that performed
+// the action acting as the user, which flags the impersonated actions
+type AuditEntry struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	Impersonator string    `json:"impersonator,omitempty"`
+	Brand        string    `json:"brand,omitempty"`
+	Action       string    `json:"action"` // e.g. PUT /v1/models/1
+	Status       int       `json:"status,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// CreateAuditLogTable creates the database table for the audit log of the admin actions
+func (db *DB) CreateAuditLogTable() error {
+	if _, err := db.Exec(createAuditLogTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createAuditLogIndexSQL)
+	return err
+}
+
+// CreateAuditEntry records an action in the audit log
+func (db *DB) CreateAuditEntry(entry AuditEntry) (AuditEntry, error) {
+	err := db.QueryRow(createAuditEntrySQL, entry.Username, entry.Impersonator, entry.Brand, entry.Action, entry.Status).Scan(&entry.ID, &entry.Created)
+	if err != nil {
+		log.Printf("Error recording the audit log entry: %v\n", err)
+	}
+	return entry, err
+}
+
+// ListAuditLog returns the latest entries of the audit log, of the impersonator or of all the users when it is empty
+func (db *DB) ListAuditLog(impersonator string) ([]AuditEntry, error) {
+	var rows *sql.Rows
+	var err error
+	if len(impersonator) == 0 {
+		rows, err = db.Query(listAuditLogSQL)
+	} else {
+		rows, err = db.Query(listAuditLogForImpersonatorSQL, impersonator)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the audit log: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e := AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.Username, &e.Impersonator, &e.Brand, &e.Action, &e.Status, &e.Created); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
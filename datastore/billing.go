@@ -0,0 +1,250 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// BillingPeriodFormat is the format of a billing period, which is a calendar month (UTC)
+const BillingPeriodFormat = "2006-01"
+
+// Billing errors
+var (
+	ErrBillingPeriodClosed = errors.New("The billing period is already closed")
+	ErrBillingPeriodOpen   = errors.New("The billing period has not ended")
+)
+
+const createBillingPeriodTableSQL = `
+	CREATE TABLE IF NOT EXISTS billingperiod (
+		id             serial primary key not null,
+		period         varchar(7) not null unique,
+		closed_by      varchar(200) default '',
+		closed         timestamp default current_timestamp,
+		digest         varchar(200) not null
+	)
+`
+
+const createBillingUsageTableSQL = `
+	CREATE TABLE IF NOT EXISTS billingusage (
+		id             serial primary key not null,
+		period         varchar(7) not null,
+		authority_id   varchar(200) not null,
+		model          varchar(200) not null,
+		signings       int not null,
+		devices        int not null,
+		UNIQUE (period, authority_id, model)
+	)
+`
+
+// The signings of a period, per account and model
+const billingUsageSQL = `
+	SELECT make, model, count(*), count(distinct serial_number)
+	FROM signinglog
+	WHERE created >= $1 AND created < $2
+	GROUP BY make, model
+	ORDER BY make, model`
+
+const findBillingPeriodSQL = "SELECT EXISTS(SELECT * FROM billingperiod WHERE period=$1)"
+const getBillingPeriodSQL = "SELECT period, closed_by, closed, digest FROM billingperiod WHERE period=$1"
+const listBillingPeriodsSQL = "SELECT period, closed_by, closed, digest FROM billingperiod ORDER BY period DESC"
+const listBillingUsageSQL = "SELECT authority_id, model, signings, devices FROM billingusage WHERE period=$1 ORDER BY authority_id, model"
+
+// The close-out snapshots are only inserted, never updated or deleted
+const createBillingPeriodSQL = "INSERT INTO billingperiod (period, closed_by, digest) VALUES ($1,$2,$3) RETURNING closed"
+const createBillingUsageSQL = "INSERT INTO billingusage (period, authority_id, model, signings, devices) VALUES ($1,$2,$3,$4,$5)"
+
+// BillingUsage is the number of signings and of distinct devices of a model in a billing period
+type BillingUsage struct {
+	AuthorityID string `json:"authority-id"`
+	Model       string `json:"model"`
+	Signings    int    `json:"signings"`
+	Devices     int    `json:"devices"`
+}
+
+// BillingPeriod is the usage of the accounts in a billing period. A closed period is an immutable
+// snapshot of the usage, with the digest of its usage, so it does not change when the signing log
+// is synced or imported after the close-out
+type BillingPeriod struct {
+	Period   string         `json:"period"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"` // exclusive
+	Closed   bool           `json:"closed"`
+	ClosedBy string         `json:"closed-by,omitempty"`
+	ClosedAt *time.Time     `json:"closed-at,omitempty"`
+	Digest   string         `json:"digest,omitempty"` // SHA-256 of the JSON encoded usage
+	Usage    []BillingUsage `json:"usage,omitempty"`
+}
+
+// ParseBillingPeriod returns the start and the (exclusive) end of a billing period e.g. 2018-06
+func ParseBillingPeriod(period string) (time.Time, time.Time, error) {
+	from, err := time.Parse(BillingPeriodFormat, period)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid billing period '%s', expected YYYY-MM", period)
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// BillingDigest is the digest of the usage of a closed billing period
+func BillingDigest(usage []BillingUsage) string {
+	data, _ := json.Marshal(usage)
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// CreateBillingTables creates the database tables for the close-out snapshots of the billing periods
+func (db *DB) CreateBillingTables() error {
+	if _, err := db.Exec(createBillingPeriodTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createBillingUsageTableSQL)
+	return err
+}
+
+// GetBillingPeriod returns the snapshot of a closed billing period, or the current usage of a
+// period that is not closed
+func (db *DB) GetBillingPeriod(period string) (BillingPeriod, error) {
+	from, to, err := ParseBillingPeriod(period)
+	if err != nil {
+		return BillingPeriod{}, err
+	}
+	p := BillingPeriod{Period: period, From: from, To: to}
+
+	err = db.QueryRow(getBillingPeriodSQL, period).Scan(&p.Period, &p.ClosedBy, &p.ClosedAt, &p.Digest)
+	switch {
+	case err == sql.ErrNoRows:
+		p.Usage, err = billingUsage(db.Query, from, to)
+		return p, err
+	case err != nil:
+		log.Printf("Error retrieving the billing period: %v\n", err)
+		return p, err
+	}
+
+	p.Closed = true
+	p.Usage, err = listBillingUsage(db.Query, period)
+	return p, err
+}
+
+// ListBillingPeriods returns the closed billing periods, latest first, without their usage
+func (db *DB) ListBillingPeriods() ([]BillingPeriod, error) {
+	rows, err := db.Query(listBillingPeriodsSQL)
+	if err != nil {
+		log.Printf("Error retrieving the billing periods: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := []BillingPeriod{}
+	for rows.Next() {
+		p := BillingPeriod{Closed: true}
+		if err := rows.Scan(&p.Period, &p.ClosedBy, &p.ClosedAt, &p.Digest); err != nil {
+			return nil, err
+		}
+		p.From, p.To, _ = ParseBillingPeriod(p.Period)
+		periods = append(periods, p)
+	}
+	return periods, rows.Err()
+}
+
+// CloseBillingPeriod takes the immutable snapshot of the usage of a billing period that has ended
+func (db *DB) CloseBillingPeriod(period, closedBy string) (BillingPeriod, error) {
+	from, to, err := ParseBillingPeriod(period)
+	if err != nil {
+		return BillingPeriod{}, err
+	}
+	if time.Now().UTC().Before(to) {
+		return BillingPeriod{}, ErrBillingPeriodOpen
+	}
+
+	p := BillingPeriod{Period: period, From: from, To: to, Closed: true, ClosedBy: closedBy}
+	err = db.transaction(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(findBillingPeriodSQL, period).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrBillingPeriodClosed
+		}
+
+		usage, err := billingUsage(tx.Query, from, to)
+		if err != nil {
+			return err
+		}
+		p.Usage = usage
+		p.Digest = BillingDigest(usage)
+
+		if err := tx.QueryRow(createBillingPeriodSQL, period, closedBy, p.Digest).Scan(&p.ClosedAt); err != nil {
+			return err
+		}
+		for _, u := range usage {
+			if _, err := tx.Exec(createBillingUsageSQL, period, u.AuthorityID, u.Model, u.Signings, u.Devices); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && err != ErrBillingPeriodClosed {
+		log.Printf("Error closing the billing period: %v\n", err)
+	}
+	return p, err
+}
+
+type billingQuery func(query string, args ...interface{}) (*sql.Rows, error)
+
+func billingUsage(query billingQuery, from, to time.Time) ([]BillingUsage, error) {
+	rows, err := query(billingUsageSQL, from, to)
+	if err != nil {
+		log.Printf("Error retrieving the billing usage: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanBillingUsage(rows)
+}
+
+func listBillingUsage(query billingQuery, period string) ([]BillingUsage, error) {
+	rows, err := query(listBillingUsageSQL, period)
+	if err != nil {
+		log.Printf("Error retrieving the billing usage: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanBillingUsage(rows)
+}
+
+func scanBillingUsage(rows *sql.Rows) ([]BillingUsage, error) {
+	usage := []BillingUsage{}
+	for rows.Next() {
+		u := BillingUsage{}
+		if err := rows.Scan(&u.AuthorityID, &u.Model, &u.Signings, &u.Devices); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
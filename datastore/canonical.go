@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"log"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// Case policies of the model names of a brand
+const (
+	ModelNameCaseLower = "lower" // the model names are in lowercase
+	ModelNameCaseExact = "exact" // the model names are kept as they are
+)
+
+// The models are listed to find the near-duplicates, which the canonicalization makes ambiguous
+const listModelNamesSQL = "select id, brand_id, name from model order by id"
+
+// NearDuplicateModels are the models that have the same canonical brand ID and model name
+type NearDuplicateModels struct {
+	BrandID string
+	Name    string
+	Models  []Model
+}
+
+// CanonicalBrandID returns the brand ID without the surrounding whitespace. The brand ID is
+// an account ID, so its case is kept
+func CanonicalBrandID(brandID string) string {
+	return strings.TrimSpace(brandID)
+}
+
+// CanonicalModelName returns the model name without the surrounding whitespace, in lowercase
+// unless the case policy of the brand keeps it as it is. The model names are canonicalized
+// when the models are created and looked up, so a device finds its model whatever the case
+func CanonicalModelName(modelNameCase []config.ModelNameCase, brandID, name string) string {
+	name = strings.TrimSpace(name)
+	if ModelNameCasePolicy(modelNameCase, brandID) == ModelNameCaseExact {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// ModelNameCasePolicy returns the case policy of the model names of the brand, from the case policies
+// of the config. The policy of the brand is used, else the policy for all the brands, else the lowercase policy
+func ModelNameCasePolicy(modelNameCase []config.ModelNameCase, brandID string) string {
+	policy := ModelNameCaseLower
+	for _, c := range modelNameCase {
+		switch {
+		case c.Brand == CanonicalBrandID(brandID):
+			return validModelNameCase(c.Case)
+		case len(c.Brand) == 0:
+			policy = validModelNameCase(c.Case)
+		}
+	}
+	return policy
+}
+
+func validModelNameCase(policy string) string {
+	if policy == ModelNameCaseExact {
+		return ModelNameCaseExact
+	}
+	return ModelNameCaseLower
+}
+
+// canonicalModel returns the model with its canonical brand ID and name
+func canonicalModel(modelNameCase []config.ModelNameCase, model Model) Model {
+	model.BrandID = CanonicalBrandID(model.BrandID)
+	model.Name = CanonicalModelName(modelNameCase, model.BrandID, model.Name)
+	return model
+}
+
+// ListNearDuplicateModels finds the models that have the same canonical brand ID and model
+// name, as they were created before the canonicalization. Only one of them can be found by
+// the devices, so they must be renamed or deleted
+func (db *DB) ListNearDuplicateModels() ([]NearDuplicateModels, error) {
+	rows, err := db.Query(listModelNamesSQL)
+	if err != nil {
+		log.Printf("Error retrieving the model names: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	models := []Model{}
+	for rows.Next() {
+		model := Model{}
+		if err := rows.Scan(&model.ID, &model.BrandID, &model.Name); err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return FindNearDuplicateModels(db.modelNameCase, models), nil
+}
+
+// FindNearDuplicateModels groups the models that have the same canonical brand ID and model
+// name, in the order of their first model
+func FindNearDuplicateModels(modelNameCase []config.ModelNameCase, models []Model) []NearDuplicateModels {
+	type key struct{ brandID, name string }
+
+	groups := map[key]*NearDuplicateModels{}
+	order := []key{}
+	for _, m := range models {
+		c := canonicalModel(modelNameCase, m)
+		k := key{c.BrandID, c.Name}
+		if groups[k] == nil {
+			groups[k] = &NearDuplicateModels{BrandID: c.BrandID, Name: c.Name}
+			order = append(order, k)
+		}
+		groups[k].Models = append(groups[k].Models, m)
+	}
+
+	duplicates := []NearDuplicateModels{}
+	for _, k := range order {
+		if len(groups[k].Models) > 1 {
+			duplicates = append(duplicates, *groups[k])
+		}
+	}
+	return duplicates
+}
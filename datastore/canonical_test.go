@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestCanonicalModelName(t *testing.T) {
+	modelNameCase := []config.ModelNameCase{
+		{Brand: "", Case: "lower"},
+		{Brand: "exact-brand", Case: "exact"},
+	}
+
+	tests := []struct {
+		brandID string
+		name    string
+		want    string
+	}{
+		{"system", "alder", "alder"},
+		{"system", " Alder ", "alder"},
+		{" system ", "ALDER", "alder"},
+		{"exact-brand", " Alder ", "Alder"},
+		{" exact-brand", "ALDER", "ALDER"},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalModelName(modelNameCase, tt.brandID, tt.name); got != tt.want {
+			t.Errorf("CanonicalModelName(%q, %q): expected %q, got %q", tt.brandID, tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestModelNameCasePolicy(t *testing.T) {
+	if policy := ModelNameCasePolicy(nil, "system"); policy != ModelNameCaseLower {
+		t.Errorf("Expected the lowercase policy without the case policies, got %s", policy)
+	}
+
+	modelNameCase := []config.ModelNameCase{
+		{Brand: "", Case: "exact"},
+		{Brand: "lower-brand", Case: "lower"},
+		{Brand: "other-brand", Case: "invalid"},
+	}
+
+	tests := []struct {
+		brandID string
+		want    string
+	}{
+		{"system", ModelNameCaseExact},
+		{"lower-brand", ModelNameCaseLower},
+		{"other-brand", ModelNameCaseLower},
+	}
+
+	for _, tt := range tests {
+		if got := ModelNameCasePolicy(modelNameCase, tt.brandID); got != tt.want {
+			t.Errorf("ModelNameCasePolicy(%q): expected %s, got %s", tt.brandID, tt.want, got)
+		}
+	}
+}
+
+func TestFindNearDuplicateModels(t *testing.T) {
+	models := []Model{
+		{ID: 1, BrandID: "system", Name: "alder"},
+		{ID: 2, BrandID: "system", Name: "ash"},
+		{ID: 3, BrandID: "system", Name: "Alder "},
+		{ID: 4, BrandID: "other", Name: "alder"},
+		{ID: 5, BrandID: "system", Name: "ASH"},
+	}
+
+	duplicates := FindNearDuplicateModels(nil, models)
+	if len(duplicates) != 2 {
+		t.Fatalf("Expected 2 near-duplicates, got %d", len(duplicates))
+	}
+	if duplicates[0].Name != "alder" || len(duplicates[0].Models) != 2 || duplicates[0].Models[1].ID != 3 {
+		t.Errorf("Unexpected near-duplicates of 'alder': %v", duplicates[0])
+	}
+	if duplicates[1].Name != "ash" || len(duplicates[1].Models) != 2 || duplicates[1].Models[1].ID != 5 {
+		t.Errorf("Unexpected near-duplicates of 'ash': %v", duplicates[1])
+	}
+}
@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+const createFactoryCheckInTableSQL = `
+	CREATE TABLE IF NOT EXISTS factorycheckin (
+		id                  serial primary key not null,
+		instance_id         varchar(200) not null,
+		username            varchar(200) default '',
+		hostname            varchar(200) default '',
+		version             varchar(50) default '',
+		pending_signinglogs int default 0,
+		pending_testlogs    int default 0,
+		keystore_healthy    bool default true,
+		keystore_error      text default '',
+		reported            timestamp not null,
+		clock_skew          int default 0,
+		received            timestamp default current_timestamp
+	)
+`
+
+// Indexes
+const createFactoryCheckInUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS factorycheckin_idx ON factorycheckin (instance_id)"
+
+const findFactoryCheckInReportedSQL = "SELECT reported FROM factorycheckin WHERE instance_id=$1 FOR UPDATE"
+
+const updateFactoryCheckInSQL = `
+	UPDATE factorycheckin
+	SET username=$2, hostname=$3, version=$4, pending_signinglogs=$5, pending_testlogs=$6,
+		keystore_healthy=$7, keystore_error=$8, reported=$9, clock_skew=$10, received=$11
+	WHERE instance_id=$1
+`
+
+const createFactoryCheckInSQL = `
+	INSERT INTO factorycheckin (instance_id, username, hostname, version, pending_signinglogs, pending_testlogs,
+		keystore_healthy, keystore_error, reported, clock_skew, received)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+`
+
+const listFactoryCheckInsSQL = `
+	SELECT id, instance_id, username, hostname, version, pending_signinglogs, pending_testlogs,
+		keystore_healthy, keystore_error, reported, clock_skew, received
+	FROM factorycheckin ORDER BY instance_id
+`
+
+// ErrStaleCheckIn is the error for a check-in that is not newer than the last check-in of the
+// factory, so a captured check-in cannot be replayed to hide that a factory has gone silent
+var ErrStaleCheckIn = errors.New("The check-in is not newer than the last check-in of the factory")
+
+// FactoryCheckIn is the health report that a factory instance sends to the cloud when it syncs.
+// The clock skew is the time in seconds that the clock of the cloud is ahead of the factory
+type FactoryCheckIn struct {
+	ID                 int       `json:"id"`
+	InstanceID         string    `json:"instance-id"`
+	Username           string    `json:"username"`
+	Hostname           string    `json:"hostname"`
+	Version            string    `json:"version"`
+	PendingSigningLogs int       `json:"pending-signinglogs"`
+	PendingTestLogs    int       `json:"pending-testlogs"`
+	KeystoreHealthy    bool      `json:"keystore-healthy"`
+	KeystoreError      string    `json:"keystore-error"`
+	Reported           time.Time `json:"reported"`
+	ClockSkew          int       `json:"clock-skew"`
+	Received           time.Time `json:"received"`
+}
+
+// CreateFactoryCheckInTable creates the database table for the check-ins of the factories
+func (db *DB) CreateFactoryCheckInTable() error {
+	if _, err := db.Exec(createFactoryCheckInTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createFactoryCheckInUniqueIndexSQL)
+	return err
+}
+
+// CheckInFactory records the latest check-in of a factory, as long as it is newer than the
+// check-in that is recorded
+func (db *DB) CheckInFactory(checkIn FactoryCheckIn) error {
+	if err := ValidateFactoryCheckIn(checkIn); err != nil {
+		return err
+	}
+
+	err := db.transaction(func(tx *sql.Tx) error {
+		var reported time.Time
+		err := tx.QueryRow(findFactoryCheckInReportedSQL, checkIn.InstanceID).Scan(&reported)
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(createFactoryCheckInSQL, checkIn.InstanceID, checkIn.Username, checkIn.Hostname, checkIn.Version,
+				checkIn.PendingSigningLogs, checkIn.PendingTestLogs, checkIn.KeystoreHealthy, checkIn.KeystoreError,
+				checkIn.Reported, checkIn.ClockSkew, checkIn.Received)
+			return err
+		case err != nil:
+			return err
+		case !checkIn.Reported.After(reported):
+			return ErrStaleCheckIn
+		}
+
+		_, err = tx.Exec(updateFactoryCheckInSQL, checkIn.InstanceID, checkIn.Username, checkIn.Hostname, checkIn.Version,
+			checkIn.PendingSigningLogs, checkIn.PendingTestLogs, checkIn.KeystoreHealthy, checkIn.KeystoreError,
+			checkIn.Reported, checkIn.ClockSkew, checkIn.Received)
+		return err
+	})
+	if err != nil && err != ErrStaleCheckIn {
+		log.Printf("Error recording the factory check-in: %v\n", err)
+	}
+	return err
+}
+
+// ListFactoryCheckIns returns the latest check-in of each factory
+func (db *DB) ListFactoryCheckIns() ([]FactoryCheckIn, error) {
+	rows, err := db.Query(listFactoryCheckInsSQL)
+	if err != nil {
+		log.Printf("Error retrieving the factory check-ins: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkIns := []FactoryCheckIn{}
+	for rows.Next() {
+		c, err := scanFactoryCheckIn(rows)
+		if err != nil {
+			return nil, err
+		}
+		checkIns = append(checkIns, c)
+	}
+	return checkIns, rows.Err()
+}
+
+type checkInScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFactoryCheckIn(row checkInScanner) (FactoryCheckIn, error) {
+	c := FactoryCheckIn{}
+	err := row.Scan(&c.ID, &c.InstanceID, &c.Username, &c.Hostname, &c.Version, &c.PendingSigningLogs, &c.PendingTestLogs,
+		&c.KeystoreHealthy, &c.KeystoreError, &c.Reported, &c.ClockSkew, &c.Received)
+	return c, err
+}
+
+// ValidateFactoryCheckIn checks the identity of the factory and the time of its check-in
+func ValidateFactoryCheckIn(checkIn FactoryCheckIn) error {
+	if err := validateNotEmpty("instance ID", checkIn.InstanceID); err != nil {
+		return err
+	}
+	if checkIn.Reported.IsZero() {
+		return errors.New("The time of the check-in must be supplied")
+	}
+	if checkIn.PendingSigningLogs < 0 || checkIn.PendingTestLogs < 0 {
+		return errors.New("The pending sync counts must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// The config settings are typed and validated records, managed through the admin API.
+// They are kept apart from the settings table, as that holds the keypair auth entries
+// which must never be readable through the API
+
+// Types of the config settings
+const (
+	SettingTypeString = "string"
+	SettingTypeInt    = "int"
+	SettingTypeBool   = "bool"
+)
+
+// ConfigSettingDefinition describes a config setting: its type, default and limits
+type ConfigSettingDefinition struct {
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Default     string `json:"default"`
+	Description string `json:"description"`
+	Min         int    `json:"min,omitempty"` // limits of an integer setting
+	Max         int    `json:"max,omitempty"`
+}
+
+// configSettingDefinitions are the understood config settings
+var configSettingDefinitions = []ConfigSettingDefinition{
+	{"signing", "nonce-rate-limit", SettingTypeInt, "600", "Nonces an API key may request per minute", 1, 100000},
+	{"signing", "nonce-ban-duration", SettingTypeInt, "600", "Seconds that an API key is banned when it requests too many nonces", 1, 86400},
+	{"signing", "breaker-failures", SettingTypeInt, "5", "Consecutive datastore failures that open the circuit breaker", 1, 1000},
+	{"signing", "breaker-cooldown", SettingTypeInt, "30", "Seconds that signing requests are shed before the datastore is probed", 1, 3600},
+	{"sync", "parallelism", SettingTypeInt, "4", "Logs uploaded concurrently to the cloud serial-vault", 1, 32},
+	{"ui", "banner", SettingTypeString, "", "Message shown to the users of the admin interface", 0, 500},
+	{"ui", "read-only", SettingTypeBool, "false", "Shows the admin interface as read-only", 0, 0},
+}
+
+const createConfigSettingTableSQL = `
+	CREATE TABLE IF NOT EXISTS configsetting (
+		id               serial primary key not null,
+		namespace        varchar(100) not null,
+		name             varchar(100) not null,
+		value            text not null,
+		modified         timestamp default current_timestamp,
+		modified_by      varchar(200) default ''
+	)
+`
+
+const createConfigSettingHistoryTableSQL = `
+	CREATE TABLE IF NOT EXISTS configsettinghistory (
+		id               serial primary key not null,
+		namespace        varchar(100) not null,
+		name             varchar(100) not null,
+		old_value        text,
+		new_value        text not null,
+		changed          timestamp default current_timestamp,
+		changed_by       varchar(200) default ''
+	)
+`
+
+// Indexes
+const createConfigSettingUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS configsetting_idx ON configsetting (namespace, name)"
+const createConfigSettingHistoryIndexSQL = "CREATE INDEX IF NOT EXISTS configsettinghistory_idx ON configsettinghistory (namespace, name)"
+
+const listConfigSettingsSQL = "SELECT namespace, name, value, modified, modified_by FROM configsetting ORDER BY namespace, name"
+const getConfigSettingValueSQL = "SELECT value FROM configsetting WHERE namespace=$1 AND name=$2"
+const createConfigSettingSQL = "INSERT INTO configsetting (namespace, name, value, modified_by) VALUES ($1,$2,$3,$4)"
+const updateConfigSettingSQL = "UPDATE configsetting SET value=$3, modified=current_timestamp, modified_by=$4 WHERE namespace=$1 AND name=$2"
+const createConfigSettingHistorySQL = "INSERT INTO configsettinghistory (namespace, name, old_value, new_value, changed_by) VALUES ($1,$2,$3,$4,$5)"
+const listConfigSettingHistorySQL = `
+	SELECT id, namespace, name, old_value, new_value, changed, changed_by
+	FROM configsettinghistory
+	WHERE namespace=$1 AND name=$2
+	ORDER BY id DESC`
+
+// ConfigSetting holds the value of a config setting
+type ConfigSetting struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Modified   time.Time `json:"modified"`
+	ModifiedBy string    `json:"modified-by"`
+}
+
+// ConfigSettingChange is a change in the history of a config setting
+type ConfigSettingChange struct {
+	ID        int       `json:"id"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	OldValue  string    `json:"old-value"`
+	NewValue  string    `json:"new-value"`
+	Changed   time.Time `json:"changed"`
+	ChangedBy string    `json:"changed-by"`
+}
+
+// ConfigSettingDefinitions returns the understood config settings
+func ConfigSettingDefinitions() []ConfigSettingDefinition {
+	return append([]ConfigSettingDefinition{}, configSettingDefinitions...)
+}
+
+// FindConfigSettingDefinition returns the definition of a config setting
+func FindConfigSettingDefinition(namespace, name string) (ConfigSettingDefinition, error) {
+	for _, d := range configSettingDefinitions {
+		if d.Namespace == namespace && d.Name == name {
+			return d, nil
+		}
+	}
+	return ConfigSettingDefinition{}, fmt.Errorf("The setting '%s/%s' is not known", namespace, name)
+}
+
+// Validate checks that the value is valid for the type and limits of the setting
+func (d ConfigSettingDefinition) Validate(value string) error {
+	switch d.Type {
+	case SettingTypeInt:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("The setting '%s/%s' must be an integer", d.Namespace, d.Name)
+		}
+		if v < d.Min || v > d.Max {
+			return fmt.Errorf("The setting '%s/%s' must be between %d and %d", d.Namespace, d.Name, d.Min, d.Max)
+		}
+	case SettingTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("The setting '%s/%s' must be true or false", d.Namespace, d.Name)
+		}
+	case SettingTypeString:
+		if d.Max > 0 && len(value) > d.Max {
+			return fmt.Errorf("The setting '%s/%s' must be at most %d characters", d.Namespace, d.Name, d.Max)
+		}
+	default:
+		return fmt.Errorf("The setting '%s/%s' has an unknown type", d.Namespace, d.Name)
+	}
+	return nil
+}
+
+// ValidateConfigSetting checks that the setting is understood and that its value is valid
+func ValidateConfigSetting(setting ConfigSetting) error {
+	d, err := FindConfigSettingDefinition(setting.Namespace, setting.Name)
+	if err != nil {
+		return err
+	}
+	return d.Validate(setting.Value)
+}
+
+// CreateConfigSettingTables creates the database tables for the config settings and their history
+func (db *DB) CreateConfigSettingTables() error {
+	for _, s := range []string{createConfigSettingTableSQL, createConfigSettingUniqueIndexSQL,
+		createConfigSettingHistoryTableSQL, createConfigSettingHistoryIndexSQL} {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListConfigSettings returns the config settings that have been set
+func (db *DB) ListConfigSettings() ([]ConfigSetting, error) {
+	rows, err := db.Query(listConfigSettingsSQL)
+	if err != nil {
+		log.Printf("Error retrieving the config settings: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := []ConfigSetting{}
+	for rows.Next() {
+		s := ConfigSetting{}
+		err := rows.Scan(&s.Namespace, &s.Name, &s.Value, &s.Modified, &s.ModifiedBy)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+
+	return settings, rows.Err()
+}
+
+// PutConfigSetting validates and stores a config setting, recording the change in its history
+func (db *DB) PutConfigSetting(setting ConfigSetting) error {
+	if err := ValidateConfigSetting(setting); err != nil {
+		return err
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		var oldValue sql.NullString
+		err := tx.QueryRow(getConfigSettingValueSQL, setting.Namespace, setting.Name).Scan(&oldValue)
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(createConfigSettingSQL, setting.Namespace, setting.Name, setting.Value, setting.ModifiedBy)
+		case err == nil:
+			_, err = tx.Exec(updateConfigSettingSQL, setting.Namespace, setting.Name, setting.Value, setting.ModifiedBy)
+		}
+		if err != nil {
+			log.Printf("Error storing the config setting: %v\n", err)
+			return errors.New("Error communicating with the database")
+		}
+
+		_, err = tx.Exec(createConfigSettingHistorySQL, setting.Namespace, setting.Name, oldValue, setting.Value, setting.ModifiedBy)
+		if err != nil {
+			log.Printf("Error storing the config setting history: %v\n", err)
+			return errors.New("Error communicating with the database")
+		}
+		return nil
+	})
+}
+
+// ListConfigSettingHistory returns the changes of a config setting, newest first
+func (db *DB) ListConfigSettingHistory(namespace, name string) ([]ConfigSettingChange, error) {
+	rows, err := db.Query(listConfigSettingHistorySQL, namespace, name)
+	if err != nil {
+		log.Printf("Error retrieving the config setting history: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []ConfigSettingChange{}
+	for rows.Next() {
+		c := ConfigSettingChange{}
+		var oldValue sql.NullString
+		err := rows.Scan(&c.ID, &c.Namespace, &c.Name, &oldValue, &c.NewValue, &c.Changed, &c.ChangedBy)
+		if err != nil {
+			return nil, err
+		}
+		c.OldValue = oldValue.String
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
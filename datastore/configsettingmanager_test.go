@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "testing"
+
+func TestValidateConfigSetting(t *testing.T) {
+	tests := []struct {
+		setting ConfigSetting
+		valid   bool
+	}{
+		{ConfigSetting{Namespace: "sync", Name: "parallelism", Value: "8"}, true},
+		{ConfigSetting{Namespace: "sync", Name: "parallelism", Value: "0"}, false},
+		{ConfigSetting{Namespace: "sync", Name: "parallelism", Value: "33"}, false},
+		{ConfigSetting{Namespace: "sync", Name: "parallelism", Value: "eight"}, false},
+		{ConfigSetting{Namespace: "ui", Name: "read-only", Value: "true"}, true},
+		{ConfigSetting{Namespace: "ui", Name: "read-only", Value: "yes"}, false},
+		{ConfigSetting{Namespace: "ui", Name: "banner", Value: ""}, true},
+		{ConfigSetting{Namespace: "ui", Name: "banner", Value: string(make([]byte, 501))}, false},
+		{ConfigSetting{Namespace: "System", Name: "12345678abcdef", Value: "auth"}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateConfigSetting(tt.setting)
+		if tt.valid && err != nil {
+			t.Errorf("Expected %s/%s=%q to be valid, got: %v", tt.setting.Namespace, tt.setting.Name, tt.setting.Value, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected %s/%s=%q to be invalid", tt.setting.Namespace, tt.setting.Name, tt.setting.Value)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+const dashboardSigningsSQL = `
+	SELECT make, model,
+		COUNT(CASE WHEN created >= $1 THEN 1 END),
+		COUNT(*)
+	FROM signinglog
+	WHERE created >= $2
+	GROUP BY make, model
+	ORDER BY make, model`
+const dashboardSigningsForUserSQL = `
+	SELECT s.make, s.model,
+		COUNT(CASE WHEN s.created >= $1 THEN 1 END),
+		COUNT(*)
+	FROM signinglog s
+	WHERE s.created >= $2 and EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$3
+	)
+	GROUP BY s.make, s.model
+	ORDER BY s.make, s.model`
+
+const dashboardDuplicatesSQL = "SELECT COUNT(*) FROM signinglog WHERE created >= $1 AND revision > 1"
+const dashboardDuplicatesForUserSQL = `
+	SELECT COUNT(*) FROM signinglog s
+	WHERE s.created >= $1 AND s.revision > 1 and EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2
+	)`
+
+const dashboardPendingSyncSQLite = "SELECT COUNT(*) FROM signinglog WHERE synced = 0"
+
+// A conflict is a serial number that was signed this week for a different device-key than an
+// earlier revision, and has not been resolved by quarantining the device
+const dashboardConflictsSQL = `
+	SELECT COUNT(DISTINCT s.make || '/' || s.model || '/' || s.serial_number) FROM signinglog s
+	WHERE s.created >= $1 AND s.revision > 1 AND EXISTS(
+		SELECT * FROM signinglog p
+		WHERE p.make=s.make AND p.model=s.model AND p.serial_number=s.serial_number
+			AND p.revision < s.revision AND p.fingerprint<>s.fingerprint
+	) AND NOT EXISTS(
+		SELECT * FROM devicequarantine q WHERE q.brand_id=s.make AND q.serial_number=s.serial_number
+	)`
+const dashboardConflictsForUserSQL = dashboardConflictsSQL + ` AND EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2
+	)`
+
+// The factories check in with the sync user, which shares the accounts of the admin
+const dashboardLastSyncSQL = `
+	SELECT id, instance_id, username, hostname, version, pending_signinglogs, pending_testlogs,
+		keystore_healthy, keystore_error, reported, clock_skew, received
+	FROM factorycheckin ORDER BY received DESC LIMIT 1`
+const dashboardLastSyncForUserSQL = `
+	SELECT c.id, c.instance_id, c.username, c.hostname, c.version, c.pending_signinglogs, c.pending_testlogs,
+		c.keystore_healthy, c.keystore_error, c.reported, c.clock_skew, c.received
+	FROM factorycheckin c
+	WHERE EXISTS(
+		SELECT * FROM userinfo su
+		INNER JOIN useraccountlink sua on sua.user_id=su.id
+		INNER JOIN useraccountlink ua on ua.account_id=sua.account_id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE su.username=c.username and u.username=$1
+	)
+	ORDER BY c.received DESC LIMIT 1`
+
+// ModelSigningCount holds the number of serial assertions signed for a model
+type ModelSigningCount struct {
+	Make  string `json:"make"`
+	Model string `json:"model"`
+	Today int    `json:"today"`
+	Week  int    `json:"week"`
+}
+
+// Dashboard holds the summary of the signing activity
+type Dashboard struct {
+	Signings    []ModelSigningCount `json:"signings"`
+	Duplicates  int                 `json:"duplicates"`  // serial numbers re-signed with a new revision this week
+	PendingSync int                 `json:"pendingSync"` // signing logs not yet synced to the cloud (factory only)
+	Conflicts   int                 `json:"conflicts"`   // serial numbers re-signed for a different device-key this week, not quarantined
+	LastSync    *FactoryCheckIn     `json:"lastSync"`    // the latest check-in of the factories when they sync (cloud only)
+}
+
+func (db *DB) allDashboard() (Dashboard, error) {
+	return db.dashboardFilteredByUser(anyUserFilter)
+}
+
+func (db *DB) dashboardFilteredByUser(username string) (Dashboard, error) {
+	dashboard := Dashboard{Signings: []ModelSigningCount{}}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	week := today.AddDate(0, 0, -6)
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	if len(username) == 0 {
+		rows, err = db.Query(dashboardSigningsSQL, today, week)
+	} else {
+		rows, err = db.Query(dashboardSigningsForUserSQL, today, week, username)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the signing counts: %v\n", err)
+		return dashboard, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := ModelSigningCount{}
+		err := rows.Scan(&c.Make, &c.Model, &c.Today, &c.Week)
+		if err != nil {
+			return dashboard, err
+		}
+		dashboard.Signings = append(dashboard.Signings, c)
+	}
+
+	if len(username) == 0 {
+		err = db.QueryRow(dashboardDuplicatesSQL, week).Scan(&dashboard.Duplicates)
+	} else {
+		err = db.QueryRow(dashboardDuplicatesForUserSQL, week, username).Scan(&dashboard.Duplicates)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the duplicate signing count: %v\n", err)
+		return dashboard, err
+	}
+
+	if len(username) == 0 {
+		err = db.QueryRow(dashboardConflictsSQL, week).Scan(&dashboard.Conflicts)
+	} else {
+		err = db.QueryRow(dashboardConflictsForUserSQL, week, username).Scan(&dashboard.Conflicts)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the conflict count: %v\n", err)
+		return dashboard, err
+	}
+
+	if InFactory() {
+		err = db.QueryRow(dashboardPendingSyncSQLite).Scan(&dashboard.PendingSync)
+		if err != nil {
+			log.Printf("Error retrieving the pending sync count: %v\n", err)
+			return dashboard, err
+		}
+		return dashboard, nil
+	}
+
+	var checkIn FactoryCheckIn
+	if len(username) == 0 {
+		checkIn, err = scanFactoryCheckIn(db.QueryRow(dashboardLastSyncSQL))
+	} else {
+		checkIn, err = scanFactoryCheckIn(db.QueryRow(dashboardLastSyncForUserSQL, username))
+	}
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		log.Printf("Error retrieving the last sync: %v\n", err)
+		return dashboard, err
+	default:
+		dashboard.LastSync = &checkIn
+	}
+
+	return dashboard, nil
+}
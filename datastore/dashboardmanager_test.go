@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestDashboardSQL(t *testing.T) {
+	env := Environ
+	defer func() { Environ = env }()
+	Environ = &Env{Config: config.Settings{Driver: "postgres", InstanceRole: InstanceRoleCloud}}
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening the database: %v", err)
+	}
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &DB{DB: sqlDB}
+	now := time.Now().UTC()
+	schema := []string{
+		createSigningLogTableSQL, createAccountTableSQL, createUserTableSQL, createAccountUserLinkTableSQL,
+		createDeviceQuarantineTableSQL, createFactoryCheckInTableSQL,
+		"INSERT INTO account (id, authority_id) VALUES (1, 'system'), (2, 'acme')",
+		"INSERT INTO userinfo (id, username, email, userrole, api_key) VALUES (1, 'sv', 'sv@example.com', 200, 'key1'), (2, 'sync', 'sync@example.com', 150, 'key2'), (3, 'acme', 'acme@example.com', 200, 'key3')",
+		"INSERT INTO useraccountlink (user_id, account_id) VALUES (1, 1), (2, 1), (3, 2)",
+		"INSERT INTO devicequarantine (id, brand_id, serial_number) VALUES (1, 'system', 'A102')",
+	}
+	for _, s := range schema {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("Error preparing the database: %v", err)
+		}
+	}
+
+	// A100 is re-signed for the same device, A101 and A102 for a different device, but A102 is quarantined
+	logs := []struct {
+		serial, fingerprint string
+		revision            int
+	}{
+		{"A100", "f100", 1}, {"A100", "f100", 2},
+		{"A101", "f101", 1}, {"A101", "f999", 2},
+		{"A102", "f102", 1}, {"A102", "f998", 2},
+	}
+	for i, l := range logs {
+		if _, err := db.Exec("INSERT INTO signinglog (id, make, model, serial_number, fingerprint, revision, created) VALUES ($1,$2,$3,$4,$5,$6,$7)",
+			i+1, "system", "alder", l.serial, l.fingerprint, l.revision, now); err != nil {
+			t.Fatalf("Error storing the signing log: %v", err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO factorycheckin (id, instance_id, username, reported, received) VALUES (1, 'factory1', 'sync', $1, $1)", now); err != nil {
+		t.Fatalf("Error storing the check-in: %v", err)
+	}
+
+	tests := []struct {
+		username   string
+		duplicates int
+		conflicts  int
+		lastSync   bool
+	}{
+		{anyUserFilter, 3, 1, true},
+		{"sv", 3, 1, true},
+		{"acme", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		dashboard, err := db.dashboardFilteredByUser(tt.username)
+		if err != nil {
+			t.Fatalf("%s: error building the dashboard: %v", tt.username, err)
+		}
+		if dashboard.Duplicates != tt.duplicates || dashboard.Conflicts != tt.conflicts {
+			t.Errorf("%s: expected %d duplicates and %d conflicts, got %d and %d", tt.username, tt.duplicates, tt.conflicts, dashboard.Duplicates, dashboard.Conflicts)
+		}
+		if (dashboard.LastSync != nil) != tt.lastSync {
+			t.Errorf("%s: expected last sync %v, got %#v", tt.username, tt.lastSync, dashboard.LastSync)
+		}
+		if dashboard.LastSync != nil && dashboard.LastSync.InstanceID != "factory1" {
+			t.Errorf("%s: unexpected last sync: %#v", tt.username, dashboard.LastSync)
+		}
+	}
+}
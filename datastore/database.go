@@ -21,7 +21,9 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 )
@@ -30,6 +32,41 @@ const anyUserFilter = ""
 
 // Datastore interface for the database logic
 type Datastore interface {
+	ModelDatastore
+	KeypairDatastore
+	SettingDatastore
+	SigningLogDatastore
+	NonceDatastore
+	AccountDatastore
+	UserDatastore
+	SubstoreDatastore
+	TestLogDatastore
+	StationDatastore
+	ReportDatastore
+	SyncDatastore
+	InstanceDatastore
+	SigningLogSinkDatastore
+	ShareTokenDatastore
+	ApprovalDatastore
+	DeviceStateDatastore
+	DeviceQuarantineDatastore
+	DeviceCertificateDatastore
+	AuditLogDatastore
+	OnboardingDatastore
+	BillingDatastore
+
+	HealthCheck() error
+
+	// MissingIndexes returns the expected indexes that are missing on the large tables
+	MissingIndexes(minRows int64) ([]MissingIndex, error)
+
+	// WithContext returns the datastore with its queries bound to the context, so they
+	// are cancelled when the context is done
+	WithContext(ctx context.Context) Datastore
+}
+
+// ModelDatastore interface for the models and their model assertions
+type ModelDatastore interface {
 	ListAllowedModels(authorization User) ([]Model, error)
 	FindModel(brandID, modelName, apiKey string) (Model, error)
 	GetAllowedModel(modelID int, authorization User) (Model, error)
@@ -40,6 +77,8 @@ type Datastore interface {
 	AlterModelTable() error
 	CheckAPIKey(apiKey string) bool
 	CheckModelExists(brandID, name string) bool
+	ListModelsMissingKeypairs() ([]Model, error)
+	ListNearDuplicateModels() ([]NearDuplicateModels, error)
 
 	CreateModelAssertTable() error
 	AlterModelAssertTable() error
@@ -48,6 +87,23 @@ type Datastore interface {
 	GetModelAssert(modelID int) (ModelAssertion, error)
 	UpsertModelAssert(m ModelAssertion) error
 
+	CreateModelFallbackKeyTable() error
+	ListModelFallbackKeypairs(modelID int) ([]Keypair, error)
+	UpdateAllowedModelFallbackKeypairs(modelID int, keypairIDs []int, authorization User) error
+
+	CreateModelCanaryTables() error
+	GetModelCanary(modelID int) (ModelCanary, error)
+	UpdateAllowedModelCanary(canary ModelCanary, authorization User) error
+	RecordModelKeyResult(modelID, keypairID int, signed bool) error
+	ListModelKeyResults(modelID int) ([]ModelKeyResult, error)
+
+	CreateModelKeypairHistoryTable() error
+	AssignAllowedModelKeypair(assignment ModelKeypairAssignment, authorization User) ([]ModelKeypairChange, error)
+	ListAllowedModelKeypairHistory(modelID int, authorization User) ([]ModelKeypairChange, error)
+}
+
+// KeypairDatastore interface for the signing-keys and their creation status
+type KeypairDatastore interface {
 	ListAllowedKeypairs(authorization User) ([]Keypair, error)
 	GetKeypair(keypairID int) (Keypair, error)
 	GetKeypairByPublicID(authorityID, keyID string) (Keypair, error)
@@ -58,23 +114,99 @@ type Datastore interface {
 	CreateKeypairTable() error
 	AlterKeypairTable() error
 	CheckKeypairKeynameExists(authorityID, name string) bool
+	UpdateKeypairSealedKey(keypair SyncKeypair) error
 
+	CreateKeypairStatusTable() error
+	AlterKeypairStatusTable() error
+	CreateKeypairStatus(ks KeypairStatus) (int, error)
+	UpdateKeypairStatus(ks KeypairStatus) error
+	DeleteKeypairStatus(ks KeypairStatus) error
+	GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error)
+	ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error)
+
+	CreateKeypairUsageTable() error
+	RecordKeypairUsage(keypairID int, brandID, model string) (bool, error)
+	ListKeypairUsage(keypairID int) ([]KeypairUsage, error)
+
+	CreateKeypairModelTable() error
+	ListKeypairModels(keypairID int) ([]KeypairModel, error)
+	ListKeypairModelsByKeyID(keyID string) ([]KeypairModel, error)
+	UpdateAllowedKeypairModels(keypairID int, models []KeypairModel, authorization User) error
+
+	CreateKeypairCompromiseTable() error
+	CompromiseAllowedKeypair(keypairID int, reason string, authorization User) (KeypairCompromise, error)
+	GetKeypairCompromise(keypairID int) (KeypairCompromise, error)
+	ListKeypairCompromises() ([]KeypairCompromise, error)
+
+	CreateKeyDelegationTables() error
+	GetBrandRootKey(authorityID string) (BrandRootKey, error)
+	UpdateAllowedBrandRootKey(rootKey BrandRootKey, revocation RootKeyRevocation, authorization User) error
+	ListAllowedBrandRootKeys(authorization User) ([]BrandRootKey, error)
+	CreateAllowedKeyDelegation(delegation KeyDelegation, authorization User) (int, error)
+	ListKeyDelegations(authorityID string) ([]KeyDelegation, error)
+	ListKeyDelegationsByKeyID(keyID string) ([]KeyDelegation, error)
+	DeleteAllowedKeyDelegation(delegationID int, authorization User) error
+	SyncKeyDelegations(rootKeys []BrandRootKey, delegations []KeyDelegation) error
+}
+
+// SettingDatastore interface for the application settings
+type SettingDatastore interface {
 	CreateSettingsTable() error
 	PutSetting(setting Setting) error
 	GetSetting(code string) (Setting, error)
+	PutSchemaVersion(version int) error
+	GetSchemaVersion() (int, error)
+
+	CreateConfigSettingTables() error
+	ListConfigSettings() ([]ConfigSetting, error)
+	PutConfigSetting(setting ConfigSetting) error
+	ListConfigSettingHistory(namespace, name string) ([]ConfigSettingChange, error)
+}
 
+// SigningLogDatastore interface for the signing log and its integrity checks
+type SigningLogDatastore interface {
 	CreateSigningLogTable() error
-	CheckForDuplicate(signLog *SigningLog) (bool, int, error)
+	CreateSigningLogSearchIndexes() error
+	CheckForDuplicate(signLog *SigningLog, scope string) (bool, int, error)
 	CreateSigningLog(signLog SigningLog) error
 	ListAllowedSigningLog(authorization User) ([]SigningLog, error)
 	ListAllowedSigningLogForAccount(authorization User, authorityID string) ([]SigningLog, error)
+	SearchAllowedSigningLogForAccount(authorization User, authorityID, field, value string) ([]SigningLog, error)
 	AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error)
+	CreateSigningLogCheckpointTable() error
+	CreateSigningLogCheckpoint() (SigningLogCheckpoint, error)
+	VerifySigningLog() (SigningLogVerification, error)
+	ResignSigningLogCheckpoints(newSecret string) (int, error)
+	ListSignedDevices(authorityID string) ([]SignedDevice, error)
+	FindSignedDevices(authorityID, serialNumber string) ([]SignedDevice, error)
+	ListSerialSigningLog(make, model, serialNumber string) ([]SigningLog, error)
+	ImportSigningLog(signLog SigningLog) (string, error)
+	ListAllowedReplicationSigningLog(authorization User, afterID, limit int) ([]SigningLog, error)
+	TailAllowedSigningLog(authorization User, afterID, limit int) ([]SigningLog, error)
+	CreateSigningLogAnnotationTable() error
+	CreateAllowedSigningLogAnnotation(annotation SigningLogAnnotation, authorization User) (SigningLogAnnotation, error)
+}
 
+// NonceDatastore interface for the device and OpenID nonces
+type NonceDatastore interface {
 	CreateDeviceNonceTable() error
-	DeleteExpiredDeviceNonces() error
-	CreateDeviceNonce() (DeviceNonce, error)
-	ValidateDeviceNonce(nonce string) error
+	DeleteExpiredDeviceNonces() (int, error)
+	CreateDeviceNonce(apiKey, clientIP string) (DeviceNonce, error)
+	CreateDeviceNonces(apiKey, clientIP string, count int) ([]DeviceNonce, error)
+	CountDeviceNonces(apiKey string) (int, error)
+	ValidateDeviceNonce(nonce, apiKey, clientIP string) error
+
+	CreateSerialReplayTable() error
+	GetSerialReplay(requestHash, apiKey string, window time.Duration) (SerialReplay, error)
+	CreateSerialReplay(requestHash, apiKey string, replay SerialReplay) error
+	DeleteExpiredSerialReplays(window time.Duration) (int, error)
 
+	CreateOpenidNonceTable() error
+	CreateOpenidNonce(nonce OpenidNonce) error
+}
+
+// AccountDatastore interface for the accounts (brands)
+type AccountDatastore interface {
 	CreateAccountTable() error
 	AlterAccountTable() error
 	ListAllowedAccounts(authorization User) ([]Account, error)
@@ -85,9 +217,12 @@ type Datastore interface {
 	UpdateAccount(account Account, authorization User) error
 	PutAccount(account Account, authorization User) (string, error)
 
-	CreateOpenidNonceTable() error
-	CreateOpenidNonce(nonce OpenidNonce) error
+	CreateAccountKeyTable() error
+	GetAccountKey(authorityID, keyID string) (AccountKey, error)
+}
 
+// UserDatastore interface for the users and their accounts
+type UserDatastore interface {
 	CreateUser(user User) (int, error)
 	ListUsers() ([]User, error)
 	FindUsers(query string) ([]User, error)
@@ -104,30 +239,54 @@ type Datastore interface {
 	ListUserAccounts(username string) ([]Account, error)
 	ListNotUserAccounts(username string) ([]Account, error)
 	ListAccountUsers(authorityID string) ([]User, error)
+}
 
-	CreateKeypairStatusTable() error
-	AlterKeypairStatusTable() error
-	CreateKeypairStatus(ks KeypairStatus) (int, error)
-	UpdateKeypairStatus(ks KeypairStatus) error
-	DeleteKeypairStatus(ks KeypairStatus) error
-	GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error)
-	ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error)
-
+// SubstoreDatastore interface for the sub-store models
+type SubstoreDatastore interface {
 	CreateSubstoreTable() error
+	AlterSubstoreTable() error
 	CreateAllowedSubstore(store Substore, authorization User) error
+	CreateAllowedSubstores(stores []Substore, authorization User) error
 	ListSubstores(accountID int, authorization User) ([]Substore, error)
 	UpdateAllowedSubstore(store Substore, authorization User) error
 	DeleteAllowedSubstore(storeID int, authorization User) (string, error)
 	GetSubstore(fromModelID int, serialNumber string) (Substore, error)
 	GetSubstoreModel(brand, model, serialNumber string) (Substore, error)
+	PivotTarget(store Substore) (Model, bool, error)
+}
 
+// TestLogDatastore interface for the factory test logs and device manifests
+type TestLogDatastore interface {
 	CreateTestLogTable() error
 	CreateTestLog(testLog TestLog) error
 	ListAllowedTestLog(authorization User) ([]TestLog, error)
+	UpdateAllowedTestLog(ID int, authorization User) error
 
-	HealthCheck() error
+	CreateDeviceManifestTable() error
+	CreateDeviceManifest(manifest DeviceManifest) error
+	CreateAllowedDeviceManifest(manifest DeviceManifest, authorization User) error
+	ListAllowedDeviceManifests(authorization User, authorityID string, query DeviceManifestQuery) ([]DeviceManifest, error)
+}
 
+// StationDatastore interface for the factory stations of a model
+type StationDatastore interface {
+	CreateStationTable() error
+	ValidateStation(modelID int, code string) error
+	ListAllowedStations(modelID int, authorization User) ([]Station, error)
+	CreateAllowedStation(station Station, authorization User) error
+	DeleteAllowedStation(stationID int, authorization User) error
+}
+
+// ReportDatastore interface for the dashboard and production reports
+type ReportDatastore interface {
+	AllowedDashboard(authorization User) (Dashboard, error)
+	AllowedProductionReport(authorization User, authorityID string, from, to time.Time) (ProductionReport, error)
+}
+
+// SyncDatastore interface for the synchronization between the factory and the cloud
+type SyncDatastore interface {
 	SyncAccount(account Account) error
+	SyncAccountKey(key AccountKey) error
 	SyncKeypair(keypair SyncKeypair) error
 	SyncModel(m Model) error
 	CheckForMatching(signLog SigningLog) (bool, error)
@@ -136,19 +295,151 @@ type Datastore interface {
 	SyncUpdateSigningLog(id int) error
 	SyncListTestLogs() ([]TestLog, error)
 	SyncDeleteTestLog(ID int) error
-	UpdateAllowedTestLog(ID int, authorization User) error
+	SyncListDeviceManifests() ([]DeviceManifest, error)
+	SyncDeleteDeviceManifest(ID int) error
+
+	CreateSyncModelAssignmentTable() error
+	ListSyncModelAssignments(userID int) ([]SyncModelAssignment, error)
+	CreateSyncModelAssignment(assignment SyncModelAssignment) error
+	DeleteSyncModelAssignment(assignmentID int) error
+	SyncModelScoped(userID int) (bool, error)
+	SetSyncModelScope(userID int, scoped bool) error
+	ListAllowedSyncKeypairs(authorization User) ([]Keypair, error)
+
+	CreateSigningAuthorizationTable() error
+	SyncSigningAuthorizations(scoped bool, assignments []SyncModelAssignment) error
+	CheckSigningAuthorization(brandID, modelName string) error
+}
+
+// InstanceDatastore interface for the registry of the running vault instances
+type InstanceDatastore interface {
+	CreateInstanceTable() error
+	RegisterInstance(instance Instance) error
+	ListInstances() ([]Instance, error)
+
+	CreateFactoryCheckInTable() error
+	CheckInFactory(checkIn FactoryCheckIn) error
+	ListFactoryCheckIns() ([]FactoryCheckIn, error)
+
+	TryLeaderLock() (LeaderLock, bool, error)
+	NotifyInvalidation(payload string) error
+}
+
+// SigningLogSinkDatastore interface for the queue of the signing log sink
+type SigningLogSinkDatastore interface {
+	CreateSigningLogSinkQueueTable() error
+	QueueSigningLogSink(signLog SigningLog, reason string) error
+	ListSigningLogSinkQueue() ([]SigningLogSinkEntry, error)
+	DeleteSigningLogSinkQueue(id int) error
+	ListSigningLogForBrand(authorityID string) ([]SigningLog, error)
+	ListSigningLogForKeypair(keyID string) ([]SigningLog, error)
+}
+
+// ShareTokenDatastore interface for the tokens that share a brand's signing log with its partners
+type ShareTokenDatastore interface {
+	CreateShareTokenTable() error
+	CreateAllowedShareToken(token ShareToken, authorization User) (ShareToken, error)
+	ListAllowedShareTokens(authorization User, authorityID string) ([]ShareToken, error)
+	DeleteAllowedShareToken(tokenID int, authorization User) error
+	GetShareToken(token string) (ShareToken, error)
+}
+
+// ApprovalDatastore interface for the approvals of the sensitive operations
+type ApprovalDatastore interface {
+	CreateApprovalTable() error
+	RequestApproval(approval Approval) (Approval, bool, error)
+	DecideApproval(approvalID int, approved bool, authorization User) (Approval, error)
+	ListAllowedApprovals(authorization User, status string) ([]Approval, error)
+}
+
+// OnboardingDatastore interface for the onboarding of the new brands
+type OnboardingDatastore interface {
+	CreateOnboardingTable() error
+	CreateOnboarding(onboarding Onboarding) (Onboarding, error)
+	GetOnboarding(onboardingID int) (Onboarding, error)
+	GetOnboardingByToken(token string) (Onboarding, error)
+	ListOnboardings(status string) ([]Onboarding, error)
+	DecideOnboarding(onboardingID int, approved bool, decidedBy, reason string) (Onboarding, error)
+	FinishOnboarding(onboardingID int, status, apiKey, reason string) error
+}
+
+// BillingDatastore interface for the usage of the accounts in the billing periods
+type BillingDatastore interface {
+	CreateBillingTables() error
+	GetBillingPeriod(period string) (BillingPeriod, error)
+	ListBillingPeriods() ([]BillingPeriod, error)
+	CloseBillingPeriod(period, closedBy string) (BillingPeriod, error)
+}
+
+// AuditLogDatastore interface for the audit log of the admin actions
+type AuditLogDatastore interface {
+	CreateAuditLogTable() error
+	CreateAuditEntry(entry AuditEntry) (AuditEntry, error)
+	ListAuditLog(impersonator string) ([]AuditEntry, error)
+}
+
+// DeviceStateDatastore interface for the lifecycle states of the signed devices
+type DeviceStateDatastore interface {
+	CreateDeviceStateTable() error
+	GetDeviceState(brandID, model, serialNumber string) (string, error)
+	TransitionAllowedDeviceState(state DeviceState, authorization User) (DeviceState, error)
+	ListAllowedDeviceStateHistory(authorization User, brandID, model, serialNumber string) ([]DeviceState, error)
+	ListDeviceRevocations(brandID string, states []string) ([]DeviceRevocation, error)
+}
+
+// DeviceQuarantineDatastore interface for the quarantine list of the devices that are not signed
+type DeviceQuarantineDatastore interface {
+	CreateDeviceQuarantineTable() error
+	CheckDeviceQuarantine(brandID, serialNumber, fingerprint string) (DeviceQuarantine, error)
+	QuarantineAllowedDevices(brandID string, devices []DeviceQuarantine, source string, authorization User) (DeviceQuarantineResult, error)
+	ListAllowedDeviceQuarantine(authorization User, brandID string) ([]DeviceQuarantine, error)
+	DeleteAllowedDeviceQuarantine(brandID string, quarantineID int, authorization User) error
+	SyncDeviceQuarantine(devices []DeviceQuarantine) error
+}
+
+// DeviceCertificateDatastore interface for the X.509 certificates issued to the signed devices
+type DeviceCertificateDatastore interface {
+	CreateDeviceCertificateTable() error
+	CreateDeviceCertificate(cert DeviceCertificate) error
+	GetDeviceCertificate(certificateSerial string) (DeviceCertificate, error)
+	GetLatestDeviceCertificate(brandID, model, serialNumber string) (DeviceCertificate, error)
+	RevokeDeviceCertificates(brandID, model, serialNumber string, revoked bool) error
+	ListRevokedDeviceCertificates() ([]DeviceCertificate, error)
 }
 
 // DB local database interface with our custom methods.
 type DB struct {
 	*sql.DB
+	ctx context.Context
+
+	// modelNameCase are the case policies of the model names of the brands, from the config
+	modelNameCase []config.ModelNameCase
+
+	// slowQuery is the time after which a query is recorded in the slow-query log
+	slowQuery time.Duration
+
+	// nonceBinding is the binding of the device nonces, to the API key when it is not set
+	nonceBinding string
 }
 
+// Check that the implementations satisfy the full datastore interface
+var (
+	_ Datastore = &DB{}
+	_ Datastore = &MockDB{}
+	_ Datastore = &ErrorMockDB{}
+)
+
 // Env Environment struct that holds the config and data store details.
 type Env struct {
 	Config    config.Settings
 	DB        Datastore
 	KeypairDB *KeypairDatabase
+
+	// SigningLogSink is the write-once storage of the signing log, when it is configured
+	SigningLogSink SigningLogSink
+
+	// GeoIP finds the country of the client IP of the signing requests, when it is configured
+	GeoIP CountryLookup
 }
 
 // Environ contains the parsed config file settings.
@@ -167,6 +458,43 @@ func OpenSysDatabase(driver, dataSource string) {
 	}
 }
 
+// WithContext returns the database with its queries bound to the context
+func (db *DB) WithContext(ctx context.Context) Datastore {
+	return &DB{DB: db.DB, ctx: ctx, modelNameCase: db.modelNameCase, slowQuery: db.slowQuery, nonceBinding: db.nonceBinding}
+}
+
+// context returns the context of the queries, which is not cancelled unless it has been bound
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// Query runs a query in the context of the database, recording its latency. The plan of the
+// query is explained before it is run, when the statements are captured for the context
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer db.observe(query, args, db.explain(query, args), time.Now())
+	return db.DB.QueryContext(db.context(), query, args...)
+}
+
+// QueryRow runs a query that returns a single row in the context of the database, recording its latency
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer db.observe(query, args, db.explain(query, args), time.Now())
+	return db.DB.QueryRowContext(db.context(), query, args...)
+}
+
+// Exec runs a statement in the context of the database, recording its latency
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer db.observe(query, args, nil, time.Now())
+	return db.DB.ExecContext(db.context(), query, args...)
+}
+
+// Begin starts a transaction in the context of the database
+func (db *DB) Begin() (*sql.Tx, error) {
+	return db.DB.BeginTx(db.context(), nil)
+}
+
 func (db *DB) transaction(txFunc func(*sql.Tx) error) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -186,10 +514,34 @@ func (db *DB) transaction(txFunc func(*sql.Tx) error) error {
 	return err
 }
 
-// InFactory checks if we are running in the factory (with a sqlite database)
+// InFactory checks if we are running in the factory
 func InFactory() bool {
-	if Environ.Config.Driver == "sqlite3" {
-		return true
+	return Environ.InFactory()
+}
+
+// InFactory checks if the environment is a factory vault, from its instance role
+func (env *Env) InFactory() bool {
+	return env.InstanceRole() == InstanceRoleFactory
+}
+
+// InstanceRole returns the configured role of the instance, which defaults to the factory role
+// for a sqlite database or a failover pair
+func (env *Env) InstanceRole() string {
+	if len(env.Config.InstanceRole) > 0 {
+		return env.Config.InstanceRole
+	}
+	if env.UsesSQLite() || env.Config.Failover {
+		return InstanceRoleFactory
 	}
-	return false
+	return InstanceRoleCloud
+}
+
+// usesSQLite checks if the database is the local sqlite database, which needs its own SQL dialect
+func usesSQLite() bool {
+	return Environ.UsesSQLite()
+}
+
+// UsesSQLite checks if the database of the environment is a sqlite database
+func (env *Env) UsesSQLite() bool {
+	return env.Config.Driver == "sqlite3"
 }
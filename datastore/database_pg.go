@@ -22,18 +22,42 @@ package datastore
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	_ "github.com/lib/pq" // postgresql driver
 )
 
+// Default limits of the connection pool of the database
+const (
+	DefaultMaxOpenConns    = 20
+	DefaultMaxIdleConns    = 10
+	DefaultConnMaxLifetime = 5 * time.Minute
+)
+
+// Statement cache modes of the database connection
+const (
+	StatementCachePrepare = "prepare"
+	StatementCacheNone    = "none"
+)
+
 // openPostgreSQLDatabase return an open database connection for an postgresql database
 func openPostgreSQLDatabase(driver, dataSource string) {
+	dataSource, err := postgreSQLDataSource(dataSource, Environ.Config.DatastoreStatementCache)
+	if err != nil {
+		log.Fatalf("Error configuring the database: %v\n", err)
+	}
+
 	// Open the database connection
 	db, err := sql.Open(driver, dataSource)
 	if err != nil {
 		log.Fatalf("Error opening the database: %v\n", err)
 	}
+	configurePool(db, Environ.Config)
 
 	// Check that we have a valid database connection
 	err = db.Ping()
@@ -41,6 +65,58 @@ func openPostgreSQLDatabase(driver, dataSource string) {
 		log.Fatalf("Error accessing the database: %v\n", err)
 	}
 
-	Environ.DB = &DB{db}
-	OpenidNonceStore.DB = &DB{db}
+	Environ.DB = &DB{DB: db, modelNameCase: Environ.Config.ModelNameCase, slowQuery: slowQueryThreshold(Environ.Config), nonceBinding: NonceBinding(Environ.Config)}
+	OpenidNonceStore.DB = &DB{DB: db}
+}
+
+// configurePool limits the connection pool, so that bursts of requests queue for a connection
+// instead of exhausting the connections of the database
+func configurePool(db *sql.DB, settings config.Settings) {
+	maxOpen := settings.DatastoreMaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenConns
+	}
+
+	maxIdle := settings.DatastoreMaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = DefaultMaxIdleConns
+	}
+	if maxIdle > maxOpen {
+		maxIdle = maxOpen
+	}
+
+	lifetime := DefaultConnMaxLifetime
+	if settings.DatastoreConnMaxLifetime > 0 {
+		lifetime = time.Duration(settings.DatastoreConnMaxLifetime) * time.Second
+	}
+
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(lifetime)
+}
+
+// postgreSQLDataSource sets the connection parameters for the statement cache mode. Without the
+// cache, the query parameters are sent with the query instead of preparing a statement on the
+// session, as pgbouncer in transaction pooling mode does not keep the session between transactions
+func postgreSQLDataSource(dataSource, statementCache string) (string, error) {
+	switch statementCache {
+	case "", StatementCachePrepare:
+		return dataSource, nil
+	case StatementCacheNone:
+	default:
+		return "", fmt.Errorf("Invalid statement cache mode: %s", statementCache)
+	}
+
+	if strings.HasPrefix(dataSource, "postgres://") || strings.HasPrefix(dataSource, "postgresql://") {
+		u, err := url.Parse(dataSource)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		query.Set("binary_parameters", "yes")
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	return strings.TrimSpace(dataSource + " binary_parameters=yes"), nil
 }
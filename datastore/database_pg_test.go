@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestConfigurePool(t *testing.T) {
+	tests := []struct {
+		settings config.Settings
+		maxOpen  int
+	}{
+		{config.Settings{}, DefaultMaxOpenConns},
+		{config.Settings{DatastoreMaxOpenConns: 5, DatastoreMaxIdleConns: 10, DatastoreConnMaxLifetime: 60}, 5},
+	}
+
+	for _, tt := range tests {
+		db, err := sql.Open("postgres", "dbname=serialvault")
+		if err != nil {
+			t.Fatalf("Error opening the database: %v", err)
+		}
+
+		configurePool(db, tt.settings)
+		if db.Stats().MaxOpenConnections != tt.maxOpen {
+			t.Errorf("Expected %d max open connections, got: %d", tt.maxOpen, db.Stats().MaxOpenConnections)
+		}
+		db.Close()
+	}
+}
+
+func TestPostgreSQLDataSource(t *testing.T) {
+	tests := []struct {
+		dataSource     string
+		statementCache string
+		expected       string
+		err            bool
+	}{
+		{"dbname=serialvault sslmode=disable", "", "dbname=serialvault sslmode=disable", false},
+		{"dbname=serialvault sslmode=disable", StatementCachePrepare, "dbname=serialvault sslmode=disable", false},
+		{"dbname=serialvault sslmode=disable", StatementCacheNone, "dbname=serialvault sslmode=disable binary_parameters=yes", false},
+		{"postgres://sv@pgbouncer:6432/serialvault?sslmode=disable", StatementCacheNone, "postgres://sv@pgbouncer:6432/serialvault?binary_parameters=yes&sslmode=disable", false},
+		{"dbname=serialvault", "invalid", "", true},
+	}
+
+	for _, tt := range tests {
+		dataSource, err := postgreSQLDataSource(tt.dataSource, tt.statementCache)
+		if (err != nil) != tt.err {
+			t.Errorf("Unexpected error for %s: %v", tt.statementCache, err)
+		}
+		if dataSource != tt.expected {
+			t.Errorf("Expected data source `%s`, got: `%s`", tt.expected, dataSource)
+		}
+	}
+}
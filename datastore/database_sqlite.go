@@ -41,6 +41,6 @@ func openSQLiteDatabase(driver, dataSource string) {
 		log.Fatalf("Error accessing the database: %v\n", err)
 	}
 
-	Environ.DB = &DB{db}
-	OpenidNonceStore.DB = &DB{db}
+	Environ.DB = &DB{DB: db, modelNameCase: Environ.Config.ModelNameCase, slowQuery: slowQueryThreshold(Environ.Config), nonceBinding: NonceBinding(Environ.Config)}
+	OpenidNonceStore.DB = &DB{DB: db}
 }
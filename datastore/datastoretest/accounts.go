@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// ListAllowedAccounts returns the accounts visible to the authorization
+func (db *DB) ListAllowedAccounts(authorization datastore.User) ([]datastore.Account, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	accounts := []datastore.Account{}
+	for _, a := range db.accounts {
+		if db.canRead(authorization, a.AuthorityID) {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+// GetAllowedAccount returns the account, if it is visible to the authorization
+func (db *DB) GetAllowedAccount(authorityID string, authorization datastore.User) (datastore.Account, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if !db.canWrite(authorization, authorityID) {
+		return datastore.Account{}, errNotFound
+	}
+	return db.account(authorityID)
+}
+
+// GetAccount returns the account for the authority
+func (db *DB) GetAccount(authorityID string) (datastore.Account, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.account(authorityID)
+}
+
+// GetAccountByID returns the account, if it is visible to the authorization
+func (db *DB) GetAccountByID(accountID int, authorization datastore.User) (datastore.Account, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, a := range db.accounts {
+		if a.ID == accountID && db.canWrite(authorization, a.AuthorityID) {
+			return a, nil
+		}
+	}
+	return datastore.Account{}, errNotFound
+}
+
+// CreateAccount adds a new account
+func (db *DB) CreateAccount(account datastore.Account) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, err := db.account(account.AuthorityID); err == nil {
+		return errors.New("The account already exists")
+	}
+	db.addAccount(account)
+	return nil
+}
+
+// UpdateAccount updates the account, if the authorization is allowed to change it
+func (db *DB) UpdateAccount(account datastore.Account, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, a := range db.accounts {
+		if a.ID == account.ID && db.canWrite(authorization, a.AuthorityID) {
+			db.accounts[i].AuthorityID = account.AuthorityID
+			db.accounts[i].ResellerAPI = account.ResellerAPI
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// PutAccount creates or updates the account assertion of an authority
+func (db *DB) PutAccount(account datastore.Account, authorization datastore.User) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(strings.TrimSpace(account.AuthorityID)) == 0 {
+		return "error-validate-account", errors.New("Authority ID must not be empty")
+	}
+	if authorization.Role == datastore.Admin && !db.userInAccount(authorization.Username, account.AuthorityID) {
+		return "error-auth", errors.New("You do not have permissions for that authority")
+	}
+
+	db.upsertAccount(account)
+	return "", nil
+}
+
+// SyncAccount stores an account from the cloud
+func (db *DB) SyncAccount(account datastore.Account) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(strings.TrimSpace(account.AuthorityID)) == 0 {
+		return errors.New("Authority ID must not be empty")
+	}
+
+	db.upsertAccount(account)
+	return nil
+}
+
+// GetAccountKey returns the account-key assertion of the signing-key of the account
+func (db *DB) GetAccountKey(authorityID, keyID string) (datastore.AccountKey, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, k := range db.accountKeys {
+		if k.AuthorityID == authorityID && k.KeyID == keyID {
+			return k, nil
+		}
+	}
+	return datastore.AccountKey{}, errNotFound
+}
+
+// SyncAccountKey stores the account-key assertion that is synced from the cloud
+func (db *DB) SyncAccountKey(key datastore.AccountKey) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateAccountKey(key); err != nil {
+		return err
+	}
+
+	for i, k := range db.accountKeys {
+		if k.AuthorityID == key.AuthorityID && k.KeyID == key.KeyID {
+			key.ID = k.ID
+			db.accountKeys[i] = key
+			return nil
+		}
+	}
+	key.ID = db.nextID()
+	db.accountKeys = append(db.accountKeys, key)
+	return nil
+}
+
+// CreateOpenidNonce stores an OpenID nonce
+func (db *DB) CreateOpenidNonce(nonce datastore.OpenidNonce) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nonce.ID = db.nextID()
+	db.openidNonces = append(db.openidNonces, nonce)
+	return nil
+}
+
+func (db *DB) account(authorityID string) (datastore.Account, error) {
+	for _, a := range db.accounts {
+		if a.AuthorityID == authorityID {
+			return a, nil
+		}
+	}
+	return datastore.Account{}, errNotFound
+}
+
+func (db *DB) addAccount(account datastore.Account) datastore.Account {
+	if account.ID == 0 {
+		account.ID = db.nextID()
+	}
+	db.accounts = append(db.accounts, account)
+	return account
+}
+
+func (db *DB) upsertAccount(account datastore.Account) {
+	for i, a := range db.accounts {
+		if a.AuthorityID == account.AuthorityID {
+			db.accounts[i].Assertion = account.Assertion
+			return
+		}
+	}
+	db.addAccount(account)
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CreateAllowedSigningLogAnnotation attaches an annotation to a signing log entry, if the
+// authorization is allowed to do it
+func (db *DB) CreateAllowedSigningLogAnnotation(annotation datastore.SigningLogAnnotation, authorization datastore.User) (datastore.SigningLogAnnotation, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateSigningLogAnnotation(annotation); err != nil {
+		return datastore.SigningLogAnnotation{}, err
+	}
+
+	for _, l := range db.signingLogs {
+		if l.ID != annotation.SigningLogID {
+			continue
+		}
+		if !db.canWrite(authorization, l.Make) {
+			return datastore.SigningLogAnnotation{}, errors.New("You do not have permissions to this brand")
+		}
+
+		annotation.ID = db.nextID()
+		annotation.CreatedBy = authorization.Username
+		annotation.Created = time.Now().UTC()
+		db.annotations = append(db.annotations, annotation)
+		return annotation, nil
+	}
+	return datastore.SigningLogAnnotation{}, errors.New("Cannot find the signing log entry")
+}
+
+// annotateSigningLogs attaches the annotations to the signing log entries
+func (db *DB) annotateSigningLogs(logs []datastore.SigningLog) []datastore.SigningLog {
+	for i := range logs {
+		logs[i].Annotations = nil
+		for _, a := range db.annotations {
+			if a.SigningLogID == logs[i].ID {
+				logs[i].Annotations = append(logs[i].Annotations, a)
+			}
+		}
+	}
+	return logs
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// RequestApproval completes an approved approval of the operation for the requester, or
+// returns the pending approval, creating it if there is none
+func (db *DB) RequestApproval(approval datastore.Approval) (datastore.Approval, bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateApproval(approval); err != nil {
+		return approval, false, err
+	}
+
+	since := time.Now().UTC().Add(-datastore.ApprovalLifetime)
+	for i := len(db.approvals) - 1; i >= 0; i-- {
+		a := db.approvals[i]
+		if a.Operation != approval.Operation || a.Target != approval.Target || a.Details != approval.Details ||
+			a.RequestedBy != approval.RequestedBy || !a.Created.After(since) {
+			continue
+		}
+		switch a.Status {
+		case datastore.ApprovalApproved:
+			db.approvals[i].Status = datastore.ApprovalCompleted
+			return db.approvals[i], false, nil
+		case datastore.ApprovalPending:
+			return a, false, nil
+		}
+	}
+
+	approval.ID = db.nextID()
+	approval.Status = datastore.ApprovalPending
+	approval.Created = time.Now().UTC()
+	db.approvals = append(db.approvals, approval)
+	return approval, true, nil
+}
+
+// DecideApproval approves or rejects a pending approval, if the user can write to its account
+func (db *DB) DecideApproval(approvalID int, approved bool, authorization datastore.User) (datastore.Approval, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i := range db.approvals {
+		if db.approvals[i].ID == approvalID {
+			a := db.approvals[i]
+			if !db.canWrite(authorization, a.AuthorityID) {
+				return datastore.Approval{}, datastore.ErrApprovalAccount
+			}
+			if err := a.Decide(approved, authorization.Username, time.Now().UTC()); err != nil {
+				return datastore.Approval{}, err
+			}
+			db.approvals[i] = a
+			return a, nil
+		}
+	}
+	return datastore.Approval{}, datastore.ErrApprovalNotFound
+}
+
+// ListAllowedApprovals returns the approvals with the status, or all the approvals, latest first,
+// for the accounts that the user can write to
+func (db *DB) ListAllowedApprovals(authorization datastore.User, status string) ([]datastore.Approval, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	approvals := []datastore.Approval{}
+	for i := len(db.approvals) - 1; i >= 0; i-- {
+		if (len(status) == 0 || db.approvals[i].Status == status) && db.canWrite(authorization, db.approvals[i].AuthorityID) {
+			approvals = append(approvals, db.approvals[i])
+		}
+	}
+	return approvals, nil
+}
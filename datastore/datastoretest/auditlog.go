@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CreateAuditEntry records an action in the audit log
+func (db *DB) CreateAuditEntry(entry datastore.AuditEntry) (datastore.AuditEntry, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	entry.ID = db.nextID()
+	entry.Created = time.Now().UTC()
+	db.auditLog = append(db.auditLog, entry)
+	return entry, nil
+}
+
+// ListAuditLog returns the entries of the audit log of the impersonator, or all the entries, latest first
+func (db *DB) ListAuditLog(impersonator string) ([]datastore.AuditEntry, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	entries := []datastore.AuditEntry{}
+	for i := len(db.auditLog) - 1; i >= 0; i-- {
+		if len(impersonator) == 0 || db.auditLog[i].Impersonator == impersonator {
+			entries = append(entries, db.auditLog[i])
+		}
+	}
+	return entries, nil
+}
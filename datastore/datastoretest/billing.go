@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// GetBillingPeriod returns the snapshot of a closed billing period, or the current usage of a
+// period that is not closed
+func (db *DB) GetBillingPeriod(period string) (datastore.BillingPeriod, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	from, to, err := datastore.ParseBillingPeriod(period)
+	if err != nil {
+		return datastore.BillingPeriod{}, err
+	}
+	for _, p := range db.billing {
+		if p.Period == period {
+			return p, nil
+		}
+	}
+	return datastore.BillingPeriod{Period: period, From: from, To: to, Usage: db.billingUsage(from, to)}, nil
+}
+
+// ListBillingPeriods returns the closed billing periods, latest first, without their usage
+func (db *DB) ListBillingPeriods() ([]datastore.BillingPeriod, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	periods := []datastore.BillingPeriod{}
+	for _, p := range db.billing {
+		p.Usage = nil
+		periods = append(periods, p)
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Period > periods[j].Period })
+	return periods, nil
+}
+
+// CloseBillingPeriod takes the snapshot of the usage of a billing period that has ended
+func (db *DB) CloseBillingPeriod(period, closedBy string) (datastore.BillingPeriod, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	from, to, err := datastore.ParseBillingPeriod(period)
+	if err != nil {
+		return datastore.BillingPeriod{}, err
+	}
+	now := time.Now().UTC()
+	if now.Before(to) {
+		return datastore.BillingPeriod{}, datastore.ErrBillingPeriodOpen
+	}
+	for _, p := range db.billing {
+		if p.Period == period {
+			return datastore.BillingPeriod{}, datastore.ErrBillingPeriodClosed
+		}
+	}
+
+	usage := db.billingUsage(from, to)
+	p := datastore.BillingPeriod{Period: period, From: from, To: to, Closed: true, ClosedBy: closedBy, ClosedAt: &now,
+		Digest: datastore.BillingDigest(usage), Usage: usage}
+	db.billing = append(db.billing, p)
+	return p, nil
+}
+
+// billingUsage counts the signings and the distinct devices of each model in the period
+func (db *DB) billingUsage(from, to time.Time) []datastore.BillingUsage {
+	usage := []datastore.BillingUsage{}
+	index := map[[2]string]int{}
+	devices := map[[3]string]bool{}
+	for _, l := range db.signingLogs {
+		if l.Created.Before(from) || !l.Created.Before(to) {
+			continue
+		}
+
+		key := [2]string{l.Make, l.Model}
+		i, ok := index[key]
+		if !ok {
+			i = len(usage)
+			index[key] = i
+			usage = append(usage, datastore.BillingUsage{AuthorityID: l.Make, Model: l.Model})
+		}
+		usage[i].Signings++
+		if device := [3]string{l.Make, l.Model, l.SerialNumber}; !devices[device] {
+			devices[device] = true
+			usage[i].Devices++
+		}
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].AuthorityID != usage[j].AuthorityID {
+			return usage[i].AuthorityID < usage[j].AuthorityID
+		}
+		return usage[i].Model < usage[j].Model
+	})
+	return usage
+}
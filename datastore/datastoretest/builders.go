@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// KeypairBuilder builds a signing-key fixture
+type KeypairBuilder struct {
+	keypair datastore.Keypair
+}
+
+// NewKeypair starts an active signing-key for the authority, named after the authority
+func NewKeypair(authorityID, keyID string) *KeypairBuilder {
+	return &KeypairBuilder{datastore.Keypair{
+		AuthorityID: authorityID,
+		KeyID:       keyID,
+		Active:      true,
+		KeyName:     authorityID,
+	}}
+}
+
+// WithName sets the name of the signing-key
+func (b *KeypairBuilder) WithName(name string) *KeypairBuilder {
+	b.keypair.KeyName = name
+	return b
+}
+
+// WithSealedKey sets the sealed signing-key
+func (b *KeypairBuilder) WithSealedKey(sealedKey string) *KeypairBuilder {
+	b.keypair.SealedKey = sealedKey
+	return b
+}
+
+// WithAssertion sets the account-key assertion of the signing-key
+func (b *KeypairBuilder) WithAssertion(assertion string) *KeypairBuilder {
+	b.keypair.Assertion = assertion
+	return b
+}
+
+// Inactive disables the signing-key
+func (b *KeypairBuilder) Inactive() *KeypairBuilder {
+	b.keypair.Active = false
+	return b
+}
+
+// Build returns the signing-key
+func (b *KeypairBuilder) Build() datastore.Keypair {
+	return b.keypair
+}
+
+// ModelBuilder builds a model fixture
+type ModelBuilder struct {
+	model datastore.Model
+}
+
+// NewModel starts a model for the brand. The signing-keys must be set before it is added
+func NewModel(brandID, name string) *ModelBuilder {
+	return &ModelBuilder{datastore.Model{
+		BrandID: brandID,
+		Name:    name,
+	}}
+}
+
+// WithKeypair sets the signing-key of the model, which is also used for the
+// system-user assertions unless WithUserKeypair is set
+func (b *ModelBuilder) WithKeypair(keypair datastore.Keypair) *ModelBuilder {
+	b.model.KeypairID = keypair.ID
+	if b.model.KeypairIDUser == 0 {
+		b.model.KeypairIDUser = keypair.ID
+	}
+	return b
+}
+
+// WithUserKeypair sets the signing-key for the system-user assertions of the model
+func (b *ModelBuilder) WithUserKeypair(keypair datastore.Keypair) *ModelBuilder {
+	b.model.KeypairIDUser = keypair.ID
+	return b
+}
+
+// WithAPIKey sets the API key of the model
+func (b *ModelBuilder) WithAPIKey(apiKey string) *ModelBuilder {
+	b.model.APIKey = apiKey
+	return b
+}
+
+// Build returns the model
+func (b *ModelBuilder) Build() datastore.Model {
+	return b.model
+}
+
+// SigningLogBuilder builds a signing log fixture
+type SigningLogBuilder struct {
+	signLog datastore.SigningLog
+}
+
+// NewSigningLog starts the first revision of a serial number, signed now
+func NewSigningLog(brandID, model, serialNumber string) *SigningLogBuilder {
+	return &SigningLogBuilder{datastore.SigningLog{
+		Make:         brandID,
+		Model:        model,
+		SerialNumber: serialNumber,
+		Fingerprint:  fmt.Sprintf("fingerprint-%s", serialNumber),
+		Created:      time.Now().UTC(),
+		Revision:     1,
+	}}
+}
+
+// WithFingerprint sets the fingerprint of the device-key
+func (b *SigningLogBuilder) WithFingerprint(fingerprint string) *SigningLogBuilder {
+	b.signLog.Fingerprint = fingerprint
+	return b
+}
+
+// WithRevision sets the revision of the serial assertion
+func (b *SigningLogBuilder) WithRevision(revision int) *SigningLogBuilder {
+	b.signLog.Revision = revision
+	return b
+}
+
+// WithCreated sets the time of the signing
+func (b *SigningLogBuilder) WithCreated(created time.Time) *SigningLogBuilder {
+	b.signLog.Created = created
+	return b
+}
+
+// WithStation sets the factory station that requested the signing
+func (b *SigningLogBuilder) WithStation(station string) *SigningLogBuilder {
+	b.signLog.Station = station
+	return b
+}
+
+// WithDetail adds a field from the serial-request body
+func (b *SigningLogBuilder) WithDetail(field, value string) *SigningLogBuilder {
+	if b.signLog.Details == nil {
+		b.signLog.Details = map[string]string{}
+	}
+	b.signLog.Details[field] = value
+	return b
+}
+
+// Synced marks the entry as synced to the cloud
+func (b *SigningLogBuilder) Synced() *SigningLogBuilder {
+	b.signLog.Synced = 1
+	return b
+}
+
+// Build returns the signing log entry
+func (b *SigningLogBuilder) Build() datastore.SigningLog {
+	return b.signLog
+}
@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// GetModelCanary returns the canary signing-key of the model, with a zero keypair ID
+// when the model does not have one
+func (db *DB) GetModelCanary(modelID int) (datastore.ModelCanary, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, c := range db.canaries {
+		if c.ModelID != modelID {
+			continue
+		}
+		k, err := db.keypair(c.KeypairID)
+		if err != nil {
+			return datastore.ModelCanary{}, err
+		}
+		c.AuthorityID = k.AuthorityID
+		c.KeyID = k.KeyID
+		c.KeyActive = k.Active
+		c.SealedKey = k.SealedKey
+		return c, nil
+	}
+	return datastore.ModelCanary{}, nil
+}
+
+// UpdateAllowedModelCanary sets the canary signing-key of the model, if the authorization
+// is allowed to change it. The signing results are reset when the canary key changes
+func (db *DB) UpdateAllowedModelCanary(canary datastore.ModelCanary, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	m, err := db.model(canary.ModelID)
+	if err != nil || !db.canWrite(authorization, m.BrandID) {
+		return errors.New("You do not have permissions to this model")
+	}
+	m = db.withKeypairs(m)
+
+	if err := datastore.ValidateModelCanary(canary, m); err != nil {
+		return err
+	}
+	if canary.Percent > 0 {
+		k, err := db.keypair(canary.KeypairID)
+		if err != nil {
+			return errors.New("Cannot find the canary signing-key")
+		}
+		if k.AuthorityID != m.AuthorityID {
+			return errors.New("The canary key must have the same authority as the signing-key of the model")
+		}
+	}
+
+	canaries := []datastore.ModelCanary{}
+	currentID := 0
+	for _, c := range db.canaries {
+		if c.ModelID == canary.ModelID {
+			currentID = c.KeypairID
+			continue
+		}
+		canaries = append(canaries, c)
+	}
+	if currentID != canary.KeypairID {
+		results := []datastore.ModelKeyResult{}
+		for _, r := range db.keyResults {
+			if r.ModelID != canary.ModelID {
+				results = append(results, r)
+			}
+		}
+		db.keyResults = results
+	}
+	if canary.Percent > 0 {
+		canaries = append(canaries, datastore.ModelCanary{ModelID: canary.ModelID, KeypairID: canary.KeypairID, Percent: canary.Percent})
+	}
+	db.canaries = canaries
+	return nil
+}
+
+// RecordModelKeyResult counts a serial assertion of the model that the key signed or failed to sign
+func (db *DB) RecordModelKeyResult(modelID, keypairID int, signed bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	i := -1
+	for j, r := range db.keyResults {
+		if r.ModelID == modelID && r.KeypairID == keypairID {
+			i = j
+		}
+	}
+	if i < 0 {
+		db.keyResults = append(db.keyResults, datastore.ModelKeyResult{ModelID: modelID, KeypairID: keypairID})
+		i = len(db.keyResults) - 1
+	}
+
+	if signed {
+		db.keyResults[i].Signed++
+	} else {
+		db.keyResults[i].Failed++
+	}
+	return nil
+}
+
+// ListModelKeyResults returns the signing results of the keys of the model
+func (db *DB) ListModelKeyResults(modelID int) ([]datastore.ModelKeyResult, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	results := []datastore.ModelKeyResult{}
+	for _, r := range db.keyResults {
+		if r.ModelID != modelID {
+			continue
+		}
+		if k, err := db.keypair(r.KeypairID); err == nil {
+			r.KeyID = k.KeyID
+		}
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].KeypairID < results[j].KeypairID })
+	return results, nil
+}
@@ -0,0 +1,237 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package datastoretest provides an in-memory implementation of the datastore
+// interface and builders for the test fixtures, so that tests do not need a
+// database or a hand-written mock of the datastore.
+//
+// The in-memory database keeps the records and applies the same role-based
+// filtering as the database adapters. The validation of the input and the
+// hash chain of the signing log are not replicated.
+package datastoretest
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// DB is an in-memory datastore
+type DB struct {
+	// ModelNameCase are the case policies of the model names of the brands, as in the config of the database
+	ModelNameCase []config.ModelNameCase
+	// NonceBinding is the binding of the device nonces, to the API key when it is not set
+	NonceBinding string
+
+	lock   sync.Mutex
+	lastID int
+
+	accounts       []datastore.Account
+	accountKeys    []datastore.AccountKey
+	users          []datastore.User
+	keypairs       []datastore.Keypair
+	keypairStatus  []datastore.KeypairStatus
+	models         []datastore.Model
+	modelAsserts   []datastore.ModelAssertion
+	settings       []datastore.Setting
+	configSettings []datastore.ConfigSetting
+	settingChanges []datastore.ConfigSettingChange
+	signingLogs    []datastore.SigningLog
+	annotations    []datastore.SigningLogAnnotation
+	checkpoints    []datastore.SigningLogCheckpoint
+	deviceNonces   []deviceNonce
+	openidNonces   []datastore.OpenidNonce
+	substores      []datastore.Substore
+	testLogs       []datastore.TestLog
+	manifests      []datastore.DeviceManifest
+	deviceStates   []datastore.DeviceState
+	quarantine     []datastore.DeviceQuarantine
+	certificates   []datastore.DeviceCertificate
+	stations       []datastore.Station
+	syncModels     []datastore.SyncModelAssignment
+	syncScoped     map[int]bool
+	authorizations []datastore.SyncModelAssignment
+	authScoped     bool
+	fallbackKeys   map[int][]int
+	canaries       []datastore.ModelCanary
+	keyResults     []datastore.ModelKeyResult
+	keypairHistory []datastore.ModelKeypairChange
+	instances      []datastore.Instance
+	checkIns       []datastore.FactoryCheckIn
+	leader         *leaderLock
+	invalidations  []string
+	sinkQueue      []datastore.SigningLogSinkEntry
+	shareTokens    []shareToken
+	approvals      []datastore.Approval
+	onboardings    []onboarding
+	billing        []datastore.BillingPeriod
+	auditLog       []datastore.AuditEntry
+	keypairUsage   []datastore.KeypairUsage
+	keypairModels  map[int][]datastore.KeypairModel
+	compromises    []datastore.KeypairCompromise
+	rootKeys       map[string]datastore.BrandRootKey
+	delegations    []datastore.KeyDelegation
+	serialReplays  map[string]serialReplay
+}
+
+// Check that the in-memory database satisfies the full datastore interface
+var _ datastore.Datastore = &DB{}
+
+// New creates an empty in-memory datastore
+func New() *DB {
+	return &DB{}
+}
+
+// nextID generates the ID of a new record. The IDs are unique across all the records
+func (db *DB) nextID() int {
+	db.lastID++
+	return db.lastID
+}
+
+// canRead checks if the authorization may see the records of an account
+func (db *DB) canRead(authorization datastore.User, authorityID string) bool {
+	switch authorization.Role {
+	case datastore.Invalid: // Authentication is disabled
+		fallthrough
+	case datastore.Superuser:
+		return true
+	case datastore.Standard:
+		fallthrough
+	case datastore.SyncUser:
+		fallthrough
+	case datastore.Admin:
+		return db.userInAccount(authorization.Username, authorityID)
+	default:
+		return false
+	}
+}
+
+// canWrite checks if the authorization may change the records of an account
+func (db *DB) canWrite(authorization datastore.User, authorityID string) bool {
+	switch authorization.Role {
+	case datastore.Invalid: // Authentication is disabled
+		fallthrough
+	case datastore.Superuser:
+		return true
+	case datastore.Admin:
+		return db.userInAccount(authorization.Username, authorityID)
+	default:
+		return false
+	}
+}
+
+func (db *DB) userInAccount(username, authorityID string) bool {
+	for _, u := range db.users {
+		if u.Username != username {
+			continue
+		}
+		for _, a := range u.Accounts {
+			if a.AuthorityID == authorityID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddAccount stores an account fixture, with a new ID unless it has one
+func (db *DB) AddAccount(account datastore.Account) datastore.Account {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.addAccount(account)
+}
+
+// AddUser stores a user fixture, with a new ID unless it has one. The user
+// can access the records of its accounts
+func (db *DB) AddUser(user datastore.User) datastore.User {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if user.ID == 0 {
+		user.ID = db.nextID()
+	}
+	db.users = append(db.users, user)
+	return user
+}
+
+// AddKeypair stores a signing-key fixture, with a new ID unless it has one
+func (db *DB) AddKeypair(keypair datastore.Keypair) datastore.Keypair {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.addKeypair(keypair)
+}
+
+// AddModel stores a model fixture, with a new ID unless it has one. The API key
+// defaults to "<brand>-<model>". The returned model includes the details of its signing-keys
+func (db *DB) AddModel(model datastore.Model) datastore.Model {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(model.APIKey) == 0 {
+		model.APIKey = model.BrandID + "-" + model.Name
+	}
+
+	// The API key is set, so no error can occur
+	m, _ := db.addModel(model)
+	return db.withKeypairs(m)
+}
+
+// AddSigningLog stores a signing log fixture, keeping its timestamp
+func (db *DB) AddSigningLog(signLog datastore.SigningLog) datastore.SigningLog {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.addSigningLog(signLog)
+}
+
+// AddDeviceNonce stores a device nonce fixture for the model API key, keeping its
+// timestamp so that expired nonces can be set up
+func (db *DB) AddDeviceNonce(nonce datastore.DeviceNonce, apiKey string) datastore.DeviceNonce {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if nonce.ID == 0 {
+		nonce.ID = db.nextID()
+	}
+	db.deviceNonces = append(db.deviceNonces, deviceNonce{DeviceNonce: nonce, apiKey: apiKey})
+	return nonce
+}
+
+// WithContext returns the in-memory datastore, which does not block so it ignores the context
+func (db *DB) WithContext(ctx context.Context) datastore.Datastore {
+	return db
+}
+
+// HealthCheck checks the in-memory datastore, which is always available
+func (db *DB) HealthCheck() error {
+	return nil
+}
+
+// MissingIndexes returns no indexes, as the in-memory datastore has no tables
+func (db *DB) MissingIndexes(minRows int64) ([]datastore.MissingIndex, error) {
+	return []datastore.MissingIndex{}, nil
+}
+
+// errNotFound is returned when a record does not exist, as the database does
+var errNotFound = sql.ErrNoRows
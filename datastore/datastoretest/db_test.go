@@ -0,0 +1,359 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	check "gopkg.in/check.v1"
+)
+
+func TestDatastoreSuite(t *testing.T) { check.TestingT(t) }
+
+type DatastoreSuite struct {
+	db    *DB
+	model datastore.Model
+}
+
+var _ = check.Suite(&DatastoreSuite{})
+
+func (s *DatastoreSuite) SetUpTest(c *check.C) {
+	s.db = New()
+
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "sv", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "user1", Role: datastore.Standard})
+
+	key := s.db.AddKeypair(NewKeypair("system", "61abf588e52be7a3").WithSealedKey("sealed").Build())
+	otherKey := s.db.AddKeypair(NewKeypair("other", "b3aff1a7c6e4d9f0").Inactive().Build())
+	s.model = s.db.AddModel(NewModel("system", "alder").WithKeypair(key).Build())
+	s.db.AddModel(NewModel("other", "ash").WithKeypair(otherKey).WithAPIKey("ash-key").Build())
+
+	s.db.AddSigningLog(NewSigningLog("system", "alder", "A1").WithDetail("mac", "00:11:22:33:44:55").Build())
+	s.db.AddSigningLog(NewSigningLog("system", "alder", "A1").WithFingerprint("new").WithRevision(2).Build())
+	s.db.AddSigningLog(NewSigningLog("other", "ash", "B1").Synced().Build())
+}
+
+func (s *DatastoreSuite) TestModels(c *check.C) {
+	c.Assert(s.model.ID, check.Not(check.Equals), 0)
+	c.Assert(s.model.APIKey, check.Equals, "system-alder")
+	c.Assert(s.model.KeyID, check.Equals, "61abf588e52be7a3")
+	c.Assert(s.model.SealedKey, check.Equals, "sealed")
+	c.Assert(s.model.KeyActive, check.Equals, true)
+	c.Assert(s.model.KeyIDUser, check.Equals, "61abf588e52be7a3")
+
+	m, err := s.db.FindModel("other", "ash", "ash-key")
+	c.Assert(err, check.IsNil)
+	c.Assert(m.KeyActive, check.Equals, false)
+
+	_, err = s.db.FindModel("other", "ash", "invalid")
+	c.Assert(err, check.NotNil)
+
+	c.Assert(s.db.CheckAPIKey("system-alder"), check.Equals, true)
+	c.Assert(s.db.CheckModelExists("system", "alder"), check.Equals, true)
+	c.Assert(s.db.CheckModelExists("system", "ash"), check.Equals, false)
+}
+
+func (s *DatastoreSuite) TestAuthorization(c *check.C) {
+	tests := []struct {
+		user    datastore.User
+		models  int
+		logs    int
+		canSign bool
+	}{
+		{datastore.User{}, 2, 3, true},
+		{datastore.User{Username: "root", Role: datastore.Superuser}, 2, 3, true},
+		{datastore.User{Username: "sv", Role: datastore.Admin}, 1, 2, true},
+		{datastore.User{Username: "user1", Role: datastore.Standard}, 0, 0, false},
+	}
+
+	for _, t := range tests {
+		models, err := s.db.ListAllowedModels(t.user)
+		c.Assert(err, check.IsNil)
+		c.Assert(models, check.HasLen, t.models)
+
+		logs, err := s.db.ListAllowedSigningLog(t.user)
+		c.Assert(err, check.IsNil)
+		c.Assert(logs, check.HasLen, t.logs)
+
+		m, err := s.db.GetAllowedModel(s.model.ID, t.user)
+		c.Assert(err == nil, check.Equals, t.canSign)
+		c.Assert(m.ID == s.model.ID, check.Equals, t.canSign)
+	}
+}
+
+func (s *DatastoreSuite) TestCreateAllowedModel(c *check.C) {
+	sv, err := s.db.GetUserByUsername("sv")
+	c.Assert(err, check.IsNil)
+
+	m := NewModel("system", "birch").WithKeypair(datastore.Keypair{ID: s.model.KeypairID}).Build()
+	created, _, err := s.db.CreateAllowedModel(m, sv)
+	c.Assert(err, check.IsNil)
+	c.Assert(created.APIKey, check.Not(check.Equals), "")
+
+	_, errorSubcode, err := s.db.CreateAllowedModel(m, sv)
+	c.Assert(err, check.ErrorMatches, "A device with the same Brand and Model already exists")
+	c.Assert(errorSubcode, check.Equals, "error-model-exists")
+
+	m = NewModel("other", "birch").WithKeypair(datastore.Keypair{ID: s.model.KeypairID}).Build()
+	_, errorSubcode, err = s.db.CreateAllowedModel(m, sv)
+	c.Assert(err, check.NotNil)
+	c.Assert(errorSubcode, check.Equals, "error-auth")
+}
+
+func (s *DatastoreSuite) TestSigningLog(c *check.C) {
+	duplicate, maxRevision, err := s.db.CheckForDuplicate(&datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "new"}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(duplicate, check.Equals, true)
+	c.Assert(maxRevision, check.Equals, 2)
+
+	// The duplicate scope decides whether the serial number and/or the device-key are checked
+	tests := []struct {
+		log       datastore.SigningLog
+		scope     string
+		duplicate bool
+	}{
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A9", Fingerprint: "new"}, datastore.DuplicateScopeEither, true},
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A9", Fingerprint: "new"}, datastore.DuplicateScopeSerial, false},
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A9", Fingerprint: "new"}, datastore.DuplicateScopeDeviceKey, true},
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "other"}, datastore.DuplicateScopeSerial, true},
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "other"}, datastore.DuplicateScopeDeviceKey, false},
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "other"}, datastore.DuplicateScopePair, false},
+		{datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "new"}, datastore.DuplicateScopePair, true},
+	}
+	for _, t := range tests {
+		duplicate, _, err := s.db.CheckForDuplicate(&t.log, t.scope)
+		c.Assert(err, check.IsNil)
+		c.Check(duplicate, check.Equals, t.duplicate, check.Commentf("%s/%s in scope %s", t.log.SerialNumber, t.log.Fingerprint, t.scope))
+	}
+
+	err = s.db.CreateSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "a2", Revision: 1})
+	c.Assert(err, check.IsNil)
+	err = s.db.CreateSigningLog(datastore.SigningLog{Make: "system", Model: "alder"})
+	c.Assert(err, check.NotNil)
+
+	logs, err := s.db.ListAllowedSigningLogForAccount(datastore.User{}, "system")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 3)
+	c.Assert(logs[0].SerialNumber, check.Equals, "A2")
+
+	logs, err = s.db.SearchAllowedSigningLogForAccount(datastore.User{}, "system", "mac", "00:11:22:33:44:55")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+
+	devices, err := s.db.ListSignedDevices("system")
+	c.Assert(err, check.IsNil)
+	c.Assert(devices, check.HasLen, 2)
+
+	unsynced, err := s.db.SyncSigningLog()
+	c.Assert(err, check.IsNil)
+	c.Assert(unsynced, check.HasLen, 3)
+	c.Assert(s.db.SyncUpdateSigningLog(unsynced[0].ID), check.IsNil)
+	unsynced, err = s.db.SyncSigningLog()
+	c.Assert(err, check.IsNil)
+	c.Assert(unsynced, check.HasLen, 2)
+}
+
+func (s *DatastoreSuite) TestDeviceNonce(c *check.C) {
+	nonce, err := s.db.CreateDeviceNonce("system-alder", "10.0.0.1")
+	c.Assert(err, check.IsNil)
+
+	count, err := s.db.CountDeviceNonces("system-alder")
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+
+	c.Assert(s.db.ValidateDeviceNonce(nonce.Nonce, "system-alder", "10.0.0.1"), check.IsNil)
+	c.Assert(s.db.ValidateDeviceNonce(nonce.Nonce, "system-alder", "10.0.0.1"), check.ErrorMatches, "The nonce is invalid or expired")
+}
+
+func (s *DatastoreSuite) TestDeviceNonceLimit(c *check.C) {
+	for i := 0; i < datastore.NonceOutstandingMaximum/datastore.NonceBatchMaximum; i++ {
+		_, err := s.db.CreateDeviceNonces("system-alder", "10.0.0.1", datastore.NonceBatchMaximum)
+		c.Assert(err, check.IsNil)
+	}
+
+	// The batch that would exceed the limit is refused whole
+	_, err := s.db.CreateDeviceNonces("system-alder", "10.0.0.1", 1)
+	c.Assert(err, check.Equals, datastore.ErrNonceLimit)
+
+	count, err := s.db.CountDeviceNonces("system-alder")
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, datastore.NonceOutstandingMaximum)
+}
+
+func (s *DatastoreSuite) TestDeviceNonceBinding(c *check.C) {
+	tests := []struct {
+		binding  string
+		apiKey   string
+		clientIP string
+		valid    bool
+	}{
+		{"", "system-alder", "10.0.0.2", true},
+		{"", "other-ash", "10.0.0.1", false},
+		{datastore.NonceBindAPIKey, "other-ash", "10.0.0.1", false},
+		{datastore.NonceBindClientIP, "system-alder", "10.0.0.1", true},
+		{datastore.NonceBindClientIP, "system-alder", "10.0.0.2", false},
+		{datastore.NonceBindClientIP, "other-ash", "10.0.0.1", false},
+		{datastore.NonceBindNone, "other-ash", "10.0.0.2", true},
+	}
+
+	for _, t := range tests {
+		s.db.NonceBinding = datastore.NonceBinding(config.Settings{NonceBinding: t.binding})
+		nonce, err := s.db.CreateDeviceNonce("system-alder", "10.0.0.1")
+		c.Assert(err, check.IsNil)
+
+		err = s.db.ValidateDeviceNonce(nonce.Nonce, t.apiKey, t.clientIP)
+		if t.valid {
+			c.Assert(err, check.IsNil)
+			continue
+		}
+		c.Assert(err, check.ErrorMatches, "The nonce is invalid or expired")
+
+		// The nonce is not used up by a request that it is not bound to
+		c.Assert(s.db.ValidateDeviceNonce(nonce.Nonce, "system-alder", "10.0.0.1"), check.IsNil)
+	}
+}
+
+func (s *DatastoreSuite) TestSigningAuthorization(c *check.C) {
+	c.Assert(s.db.CheckSigningAuthorization("other", "ash"), check.IsNil)
+
+	// The key of the model assertion is kept for an authorized model
+	assertKey := s.db.AddKeypair(NewKeypair("system", "c4d2e8f1a0b3e5d7").WithSealedKey("sealed").Build())
+	_, err := s.db.CreateModelAssert(datastore.ModelAssertion{ModelID: s.model.ID, KeypairID: assertKey.ID})
+	c.Assert(err, check.IsNil)
+
+	until := time.Now().Add(time.Hour)
+	err = s.db.SyncSigningAuthorizations(true, []datastore.SyncModelAssignment{
+		{BrandID: "system", Name: "alder", ValidUntil: &until, MaxUnits: 2},
+	})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(s.db.CheckSigningAuthorization("other", "ash"), check.ErrorMatches, "The factory is not authorized to sign for this model")
+	c.Assert(s.db.CheckSigningAuthorization("system", "alder"), check.IsNil)
+
+	s.db.AddSigningLog(NewSigningLog("system", "alder", "A2").Build())
+	c.Assert(s.db.CheckSigningAuthorization("system", "alder"), check.ErrorMatches, "The maximum of 2 units .*")
+
+	// The cap is checked again when the signing log is stored
+	err = s.db.CreateSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A3", Fingerprint: "a3", Revision: 1})
+	c.Assert(err, check.FitsTypeOf, datastore.ErrMaxUnitsReached{})
+
+	// The signing-key of the unauthorized model is revoked
+	m, err := s.db.FindModel("other", "ash", "ash-key")
+	c.Assert(err, check.IsNil)
+	c.Assert(m.SealedKey, check.Equals, "")
+	k, err := s.db.GetKeypair(assertKey.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(k.SealedKey, check.Equals, "sealed")
+
+	// A model-scoped factory without authorizations signs for no model
+	c.Assert(s.db.SyncSigningAuthorizations(true, nil), check.IsNil)
+	c.Assert(s.db.CheckSigningAuthorization("system", "alder"), check.ErrorMatches, "The factory is not authorized to sign for this model")
+
+	// A factory that is not model-scoped signs for all models
+	c.Assert(s.db.SyncSigningAuthorizations(false, nil), check.IsNil)
+	c.Assert(s.db.CheckSigningAuthorization("system", "alder"), check.IsNil)
+}
+
+func (s *DatastoreSuite) TestSyncModelScope(c *check.C) {
+	system, err := s.db.GetAccount("system")
+	c.Assert(err, check.IsNil)
+	sync := s.db.AddUser(datastore.User{Username: "sync", Role: datastore.SyncUser, Accounts: []datastore.Account{system}})
+	s.db.AddKeypair(NewKeypair("system", "c6e4d9f0b3aff1a7").Build())
+
+	// A user without models receives all the signing-keys of its accounts
+	keypairs, err := s.db.ListAllowedSyncKeypairs(sync)
+	c.Assert(err, check.IsNil)
+	c.Assert(keypairs, check.HasLen, 2)
+
+	err = s.db.CreateSyncModelAssignment(datastore.SyncModelAssignment{UserID: sync.ID, ModelID: s.model.ID})
+	c.Assert(err, check.IsNil)
+	keypairs, err = s.db.ListAllowedSyncKeypairs(sync)
+	c.Assert(err, check.IsNil)
+	c.Assert(keypairs, check.HasLen, 1)
+	c.Assert(keypairs[0].KeyID, check.Equals, "61abf588e52be7a3")
+
+	// Removing the last model does not widen the access
+	assignments, err := s.db.ListSyncModelAssignments(sync.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.db.DeleteSyncModelAssignment(assignments[0].ID), check.IsNil)
+	keypairs, err = s.db.ListAllowedSyncKeypairs(sync)
+	c.Assert(err, check.IsNil)
+	c.Assert(keypairs, check.HasLen, 0)
+
+	c.Assert(s.db.SetSyncModelScope(sync.ID, false), check.IsNil)
+	keypairs, err = s.db.ListAllowedSyncKeypairs(sync)
+	c.Assert(err, check.IsNil)
+	c.Assert(keypairs, check.HasLen, 2)
+}
+
+func (s *DatastoreSuite) TestDeviceManifests(c *check.C) {
+	kernel := func(revision string) []datastore.ManifestSnap {
+		return []datastore.ManifestSnap{{Name: "pc-kernel", Revision: revision}, {Name: "core22", Revision: "1380"}}
+	}
+	c.Assert(s.db.CreateDeviceManifest(datastore.DeviceManifest{Brand: "system", Model: "alder", SerialNumber: "A1", Snaps: kernel("1001")}), check.IsNil)
+	c.Assert(s.db.CreateDeviceManifest(datastore.DeviceManifest{Brand: "system", Model: "alder", SerialNumber: "A2", Snaps: kernel("1001")}), check.IsNil)
+	c.Assert(s.db.CreateDeviceManifest(datastore.DeviceManifest{Brand: "other", Model: "ash", SerialNumber: "B1", Snaps: kernel("1001")}), check.IsNil)
+	c.Assert(s.db.CreateDeviceManifest(datastore.DeviceManifest{Brand: "system", Model: "alder", Snaps: kernel("1001")}), check.NotNil)
+
+	// A new manifest of the device replaces the previous one
+	c.Assert(s.db.CreateDeviceManifest(datastore.DeviceManifest{Brand: "system", Model: "alder", SerialNumber: "A2", Snaps: kernel("1002")}), check.IsNil)
+
+	admin := datastore.User{Username: "sv", Role: datastore.Admin}
+	manifests, err := s.db.ListAllowedDeviceManifests(admin, "system", datastore.DeviceManifestQuery{})
+	c.Assert(err, check.IsNil)
+	c.Assert(manifests, check.HasLen, 2)
+
+	manifests, err = s.db.ListAllowedDeviceManifests(admin, "system", datastore.DeviceManifestQuery{Snap: "pc-kernel", Revision: "1001"})
+	c.Assert(err, check.IsNil)
+	c.Assert(manifests, check.HasLen, 1)
+	c.Assert(manifests[0].SerialNumber, check.Equals, "A1")
+
+	manifests, err = s.db.ListAllowedDeviceManifests(admin, "system", datastore.DeviceManifestQuery{Snap: "core22"})
+	c.Assert(err, check.IsNil)
+	c.Assert(manifests, check.HasLen, 2)
+
+	manifests, err = s.db.ListAllowedDeviceManifests(admin, "other", datastore.DeviceManifestQuery{})
+	c.Assert(err, check.IsNil)
+	c.Assert(manifests, check.HasLen, 0)
+
+	err = s.db.CreateAllowedDeviceManifest(datastore.DeviceManifest{Brand: "other", Model: "ash", SerialNumber: "B2", Snaps: kernel("1001")}, admin)
+	c.Assert(err, check.ErrorMatches, "You do not have permissions to this account")
+}
+
+func (s *DatastoreSuite) TestSearchSigningLogOrigin(c *check.C) {
+	l := NewSigningLog("system", "alder", "A2").Build()
+	l.Origin = &datastore.SigningOrigin{ClientIP: "81.2.69.160", Country: "GB"}
+	s.db.AddSigningLog(l)
+
+	admin := datastore.User{Username: "sv", Role: datastore.Admin}
+	logs, err := s.db.SearchAllowedSigningLogForAccount(admin, "system", datastore.OriginCountry, "GB")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].SerialNumber, check.Equals, "A2")
+
+	logs, err = s.db.SearchAllowedSigningLogForAccount(admin, "system", datastore.OriginCountry, "DE")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 0)
+}
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CreateDeviceCertificate stores a device certificate that has been issued
+func (db *DB) CreateDeviceCertificate(cert datastore.DeviceCertificate) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, c := range db.certificates {
+		if c.CertificateSerial == cert.CertificateSerial {
+			return errors.New("The certificate serial already exists")
+		}
+	}
+	cert.ID = db.nextID()
+	cert.Revoked = false
+	cert.Created = time.Now().UTC()
+	cert.RevokedAt = cert.Created
+	db.certificates = append(db.certificates, cert)
+	return nil
+}
+
+// GetDeviceCertificate returns the device certificate by its hex encoded serial
+func (db *DB) GetDeviceCertificate(certificateSerial string) (datastore.DeviceCertificate, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, c := range db.certificates {
+		if c.CertificateSerial == certificateSerial {
+			return c, nil
+		}
+	}
+	return datastore.DeviceCertificate{}, errNotFound
+}
+
+// GetLatestDeviceCertificate returns the latest certificate issued to the device
+func (db *DB) GetLatestDeviceCertificate(brandID, model, serialNumber string) (datastore.DeviceCertificate, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i := len(db.certificates) - 1; i >= 0; i-- {
+		c := db.certificates[i]
+		if c.Brand == brandID && c.Model == model && c.SerialNumber == serialNumber {
+			return c, nil
+		}
+	}
+	return datastore.DeviceCertificate{}, errNotFound
+}
+
+// RevokeDeviceCertificates revokes the certificates of the device, or reinstates them
+func (db *DB) RevokeDeviceCertificates(brandID, model, serialNumber string, revoked bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, c := range db.certificates {
+		if c.Brand == brandID && c.Model == model && c.SerialNumber == serialNumber && c.Revoked != revoked {
+			db.certificates[i].Revoked = revoked
+			db.certificates[i].RevokedAt = time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+// ListRevokedDeviceCertificates returns the revoked device certificates that have not expired
+func (db *DB) ListRevokedDeviceCertificates() ([]datastore.DeviceCertificate, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	now := time.Now().UTC()
+	certs := []datastore.DeviceCertificate{}
+	for _, c := range db.certificates {
+		if c.Revoked && c.NotAfter.After(now) {
+			certs = append(certs, c)
+		}
+	}
+	return certs, nil
+}
@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CheckDeviceQuarantine returns the quarantine of a device by its serial number or device-key
+// fingerprint, or sql.ErrNoRows when the device is not quarantined. The device-keys of the
+// signed devices are found from the signing log
+func (db *DB) CheckDeviceQuarantine(brandID, serialNumber, fingerprint string) (datastore.DeviceQuarantine, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	fingerprints := map[string]bool{}
+	if len(fingerprint) > 0 {
+		fingerprints[fingerprint] = true
+	}
+	for _, l := range db.signingLogs {
+		if l.Make == brandID && l.SerialNumber == serialNumber {
+			fingerprints[l.Fingerprint] = true
+		}
+	}
+
+	for _, q := range db.quarantine {
+		if q.Brand != brandID {
+			continue
+		}
+		if (len(q.SerialNumber) > 0 && q.SerialNumber == serialNumber) || (len(q.Fingerprint) > 0 && fingerprints[q.Fingerprint]) {
+			return q, nil
+		}
+	}
+	return datastore.DeviceQuarantine{}, errNotFound
+}
+
+// QuarantineAllowedDevices adds the devices to the quarantine list of the brand, if the user
+// is authorized for the brand. The devices that are already quarantined are skipped
+func (db *DB) QuarantineAllowedDevices(brandID string, devices []datastore.DeviceQuarantine, source string, authorization datastore.User) (datastore.DeviceQuarantineResult, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if !db.canWrite(authorization, brandID) {
+		return datastore.DeviceQuarantineResult{}, errors.New("You do not have permissions to this brand")
+	}
+	for i := range devices {
+		devices[i].Brand = brandID
+		if err := datastore.ValidateDeviceQuarantine(devices[i]); err != nil {
+			return datastore.DeviceQuarantineResult{}, err
+		}
+	}
+
+	result := datastore.DeviceQuarantineResult{}
+	for _, q := range devices {
+		if db.quarantined(q) {
+			result.Duplicates++
+			continue
+		}
+		q.ID = db.nextID()
+		q.Source = source
+		q.CreatedBy = authorization.Username
+		q.Created = time.Now().UTC()
+		db.quarantine = append(db.quarantine, q)
+		result.Quarantined++
+	}
+	return result, nil
+}
+
+// ListAllowedDeviceQuarantine returns the quarantined devices of a brand, or of all the brands
+// when the brand is empty, that the user is authorized to see
+func (db *DB) ListAllowedDeviceQuarantine(authorization datastore.User, brandID string) ([]datastore.DeviceQuarantine, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	devices := []datastore.DeviceQuarantine{}
+	for _, q := range db.quarantine {
+		if (len(brandID) == 0 || q.Brand == brandID) && db.canRead(authorization, q.Brand) {
+			devices = append(devices, q)
+		}
+	}
+	return devices, nil
+}
+
+// DeleteAllowedDeviceQuarantine releases a device from the quarantine list of the brand, if the
+// user is authorized for the brand
+func (db *DB) DeleteAllowedDeviceQuarantine(brandID string, quarantineID int, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if !db.canWrite(authorization, brandID) {
+		return errors.New("You do not have permissions to this brand")
+	}
+	for i, q := range db.quarantine {
+		if q.ID == quarantineID && q.Brand == brandID {
+			db.quarantine = append(db.quarantine[:i], db.quarantine[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("Cannot find the quarantined device")
+}
+
+// SyncDeviceQuarantine replaces the quarantine list with the quarantined devices of the cloud
+func (db *DB) SyncDeviceQuarantine(devices []datastore.DeviceQuarantine) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.quarantine = append([]datastore.DeviceQuarantine{}, devices...)
+	return nil
+}
+
+// quarantined checks if the device is already on the quarantine list
+func (db *DB) quarantined(device datastore.DeviceQuarantine) bool {
+	for _, q := range db.quarantine {
+		if q.Brand == device.Brand && q.SerialNumber == device.SerialNumber && q.Fingerprint == device.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// GetDeviceState returns the current lifecycle state of the device, which is empty when no
+// state has been recorded
+func (db *DB) GetDeviceState(brandID, model, serialNumber string) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.deviceState(brandID, model, serialNumber), nil
+}
+
+// TransitionAllowedDeviceState moves a signed device to a new lifecycle state, if the
+// authorization is allowed to do it for the brand
+func (db *DB) TransitionAllowedDeviceState(state datastore.DeviceState, authorization datastore.User) (datastore.DeviceState, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateDeviceState(state); err != nil {
+		return datastore.DeviceState{}, err
+	}
+	if !db.canWrite(authorization, state.Brand) {
+		return datastore.DeviceState{}, errors.New("You do not have permissions to this brand")
+	}
+
+	signed := len(db.signingLogsWhere(func(l datastore.SigningLog) bool {
+		return l.Make == state.Brand && l.Model == state.Model && l.SerialNumber == state.SerialNumber
+	})) > 0
+	if !signed {
+		return datastore.DeviceState{}, errors.New("Cannot find the signed device")
+	}
+
+	state.PreviousState = db.deviceState(state.Brand, state.Model, state.SerialNumber)
+	if err := datastore.ValidateDeviceStateTransition(state.PreviousState, state.State); err != nil {
+		return datastore.DeviceState{}, err
+	}
+
+	state.ID = db.nextID()
+	state.CreatedBy = authorization.Username
+	state.Created = time.Now().UTC()
+	db.deviceStates = append(db.deviceStates, state)
+	return state, nil
+}
+
+// ListAllowedDeviceStateHistory returns the lifecycle state transitions of the device, if the
+// brand is visible to the authorization
+func (db *DB) ListAllowedDeviceStateHistory(authorization datastore.User, brandID, model, serialNumber string) ([]datastore.DeviceState, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	states := []datastore.DeviceState{}
+	if !db.canWrite(authorization, brandID) {
+		return states, nil
+	}
+	for _, s := range db.deviceStates {
+		if s.Brand == brandID && s.Model == model && s.SerialNumber == serialNumber {
+			states = append(states, s)
+		}
+	}
+	return states, nil
+}
+
+// ListDeviceRevocations returns the devices of the brand whose current lifecycle state is one
+// of the revoking states, with the device-key fingerprint of their latest signing
+func (db *DB) ListDeviceRevocations(brandID string, states []string) ([]datastore.DeviceRevocation, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	revocations := []datastore.DeviceRevocation{}
+	for i, s := range db.deviceStates {
+		if s.Brand != brandID || !revokingState(states, s.State) || db.laterDeviceState(i) {
+			continue
+		}
+
+		r := datastore.DeviceRevocation{Brand: s.Brand, Model: s.Model, SerialNumber: s.SerialNumber, State: s.State, Revoked: s.Created}
+		logs := db.signingLogsWhere(func(l datastore.SigningLog) bool {
+			return l.Make == s.Brand && l.Model == s.Model && l.SerialNumber == s.SerialNumber
+		})
+		if len(logs) > 0 {
+			r.Fingerprint = logs[0].Fingerprint
+		}
+		revocations = append(revocations, r)
+	}
+	return revocations, nil
+}
+
+// laterDeviceState checks if the device of the state transition at the index has moved to
+// another state since
+func (db *DB) laterDeviceState(index int) bool {
+	s := db.deviceStates[index]
+	for _, later := range db.deviceStates[index+1:] {
+		if later.Brand == s.Brand && later.Model == s.Model && later.SerialNumber == s.SerialNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceState returns the state of the latest transition of the device
+func (db *DB) deviceState(brandID, model, serialNumber string) string {
+	for i := len(db.deviceStates) - 1; i >= 0; i-- {
+		s := db.deviceStates[i]
+		if s.Brand == brandID && s.Model == model && s.SerialNumber == serialNumber {
+			return s.State
+		}
+	}
+	return ""
+}
+
+func revokingState(states []string, state string) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// ListModelFallbackKeypairs returns the fallback signing-keys of the model, in priority order
+func (db *DB) ListModelFallbackKeypairs(modelID int) ([]datastore.Keypair, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	keypairs := []datastore.Keypair{}
+	for _, keypairID := range db.fallbackKeys[modelID] {
+		k, err := db.keypair(keypairID)
+		if err != nil {
+			return nil, err
+		}
+		keypairs = append(keypairs, k)
+	}
+	return keypairs, nil
+}
+
+// UpdateAllowedModelFallbackKeypairs replaces the fallback signing-keys of the model,
+// if the authorization is allowed to change it
+func (db *DB) UpdateAllowedModelFallbackKeypairs(modelID int, keypairIDs []int, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	m, err := db.model(modelID)
+	if err != nil || !db.canWrite(authorization, m.BrandID) {
+		return errors.New("You do not have permissions to this model")
+	}
+	m = db.withKeypairs(m)
+
+	seen := map[int]bool{}
+	for _, keypairID := range keypairIDs {
+		if keypairID == m.KeypairID {
+			return errors.New("The signing-key of the model cannot also be a fallback key")
+		}
+		if seen[keypairID] {
+			return errors.New("The fallback keys must not be repeated")
+		}
+		seen[keypairID] = true
+
+		k, err := db.keypair(keypairID)
+		if err != nil {
+			return errors.New("Cannot find the fallback signing-key")
+		}
+		if k.AuthorityID != m.AuthorityID {
+			return errors.New("The fallback keys must have the same authority as the signing-key of the model")
+		}
+	}
+
+	if db.fallbackKeys == nil {
+		db.fallbackKeys = map[int][]int{}
+	}
+	db.fallbackKeys[modelID] = append([]int{}, keypairIDs...)
+	return nil
+}
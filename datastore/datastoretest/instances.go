@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// RegisterInstance records the instance in the registry, updating its heartbeat
+func (db *DB) RegisterInstance(instance datastore.Instance) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateInstance(instance); err != nil {
+		return err
+	}
+	instance.Heartbeat = time.Now().UTC()
+
+	for i, inst := range db.instances {
+		if inst.InstanceID == instance.InstanceID && inst.Mode == instance.Mode {
+			instance.ID = inst.ID
+			db.instances[i] = instance
+			return nil
+		}
+	}
+
+	instance.ID = db.nextID()
+	db.instances = append(db.instances, instance)
+	return nil
+}
+
+// ListInstances returns the registered instances
+func (db *DB) ListInstances() ([]datastore.Instance, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	instances := append([]datastore.Instance{}, db.instances...)
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Role != instances[j].Role {
+			return instances[i].Role < instances[j].Role
+		}
+		if instances[i].InstanceID != instances[j].InstanceID {
+			return instances[i].InstanceID < instances[j].InstanceID
+		}
+		return instances[i].Mode < instances[j].Mode
+	})
+	return instances, nil
+}
+
+// CheckInFactory records the latest check-in of a factory, rejecting check-ins that are not newer
+func (db *DB) CheckInFactory(checkIn datastore.FactoryCheckIn) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateFactoryCheckIn(checkIn); err != nil {
+		return err
+	}
+
+	for i, c := range db.checkIns {
+		if c.InstanceID == checkIn.InstanceID {
+			if !checkIn.Reported.After(c.Reported) {
+				return datastore.ErrStaleCheckIn
+			}
+			checkIn.ID = c.ID
+			db.checkIns[i] = checkIn
+			return nil
+		}
+	}
+
+	checkIn.ID = db.nextID()
+	db.checkIns = append(db.checkIns, checkIn)
+	return nil
+}
+
+// ListFactoryCheckIns returns the latest check-in of each factory
+func (db *DB) ListFactoryCheckIns() ([]datastore.FactoryCheckIn, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	checkIns := append([]datastore.FactoryCheckIn{}, db.checkIns...)
+	sort.Slice(checkIns, func(i, j int) bool {
+		return checkIns[i].InstanceID < checkIns[j].InstanceID
+	})
+	return checkIns, nil
+}
+
+// leaderLock is the leader lock of the in-memory database
+type leaderLock struct {
+	db   *DB
+	lost bool
+}
+
+// TryLeaderLock takes the leader lock, without waiting. It returns false when the lock is held
+func (db *DB) TryLeaderLock() (datastore.LeaderLock, bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.leader != nil {
+		return nil, false, nil
+	}
+	db.leader = &leaderLock{db: db}
+	return db.leader, true, nil
+}
+
+// DropLeaderLock releases the leader lock on the side of the database, as when the session
+// of the active vault ends
+func (db *DB) DropLeaderLock() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.leader != nil {
+		db.leader.lost = true
+		db.leader = nil
+	}
+}
+
+// Check verifies that the lock is still held
+func (l *leaderLock) Check(ctx context.Context) error {
+	l.db.lock.Lock()
+	defer l.db.lock.Unlock()
+
+	if l.lost {
+		return datastore.ErrLeaderLockLost
+	}
+	return nil
+}
+
+// Release releases the lock
+func (l *leaderLock) Release() error {
+	l.db.lock.Lock()
+	defer l.db.lock.Unlock()
+
+	if l.db.leader == l {
+		l.db.leader = nil
+	}
+	l.lost = true
+	return nil
+}
+
+// NotifyInvalidation records the invalidation event, as there are no other instances to listen
+func (db *DB) NotifyInvalidation(payload string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.invalidations = append(db.invalidations, payload)
+	return nil
+}
+
+// Invalidations returns the invalidation events that have been sent
+func (db *DB) Invalidations() []string {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return append([]string{}, db.invalidations...)
+}
@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// GetBrandRootKey returns the root key of the brand, or sql.ErrNoRows when the brand has none
+func (db *DB) GetBrandRootKey(authorityID string) (datastore.BrandRootKey, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	rootKey, ok := db.rootKeys[authorityID]
+	if !ok {
+		return datastore.BrandRootKey{}, errNotFound
+	}
+	return rootKey, nil
+}
+
+// UpdateAllowedBrandRootKey registers the root key of the brand, if the user is authorized for
+// the account. An empty public key removes the root key. Replacing or removing the root key
+// needs a superuser, or a revocation signed by the current root key
+func (db *DB) UpdateAllowedBrandRootKey(rootKey datastore.BrandRootKey, revocation datastore.RootKeyRevocation, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if !db.canWrite(authorization, rootKey.AuthorityID) {
+		return errors.New("You do not have permissions for that authority")
+	}
+
+	rootKey, err := datastore.CanonicalBrandRootKey(rootKey)
+	if err != nil {
+		return err
+	}
+
+	if current, ok := db.rootKeys[rootKey.AuthorityID]; ok {
+		if err := datastore.CheckRootKeyChange(current, rootKey, revocation, authorization); err != nil {
+			return err
+		}
+	}
+
+	if db.rootKeys == nil {
+		db.rootKeys = map[string]datastore.BrandRootKey{}
+	}
+	if len(rootKey.PublicKey) == 0 {
+		delete(db.rootKeys, rootKey.AuthorityID)
+		return nil
+	}
+	rootKey.Created = time.Now().UTC()
+	db.rootKeys[rootKey.AuthorityID] = rootKey
+	return nil
+}
+
+// ListAllowedBrandRootKeys returns the root keys of the brands of the user
+func (db *DB) ListAllowedBrandRootKeys(authorization datastore.User) ([]datastore.BrandRootKey, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	rootKeys := []datastore.BrandRootKey{}
+	for _, k := range db.rootKeys {
+		if db.canRead(authorization, k.AuthorityID) {
+			rootKeys = append(rootKeys, k)
+		}
+	}
+	sort.Slice(rootKeys, func(i, j int) bool { return rootKeys[i].AuthorityID < rootKeys[j].AuthorityID })
+	return rootKeys, nil
+}
+
+// SyncKeyDelegations replaces the root keys and the delegations with the ones from the cloud,
+// skipping the delegations that are not signed by the root key of their brand
+func (db *DB) SyncKeyDelegations(rootKeys []datastore.BrandRootKey, delegations []datastore.KeyDelegation) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.rootKeys = map[string]datastore.BrandRootKey{}
+	for _, k := range rootKeys {
+		db.rootKeys[k.AuthorityID] = k
+	}
+
+	db.delegations = []datastore.KeyDelegation{}
+	for _, d := range delegations {
+		verified, err := datastore.VerifyKeyDelegation(db.ModelNameCase, db.rootKeys[d.AuthorityID], d.Document, d.Signature)
+		if err != nil {
+			continue
+		}
+		verified.ID = d.ID
+		verified.Created = d.Created
+		db.delegations = append(db.delegations, verified)
+	}
+	return nil
+}
+
+// CreateAllowedKeyDelegation stores a delegation of the brand root key, if the user is authorized
+// for the account and the signature of the delegation is valid
+func (db *DB) CreateAllowedKeyDelegation(delegation datastore.KeyDelegation, authorization datastore.User) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if !db.canWrite(authorization, delegation.AuthorityID) {
+		return 0, errors.New("You do not have permissions for that authority")
+	}
+
+	rootKey, ok := db.rootKeys[delegation.AuthorityID]
+	if !ok {
+		return 0, errors.New("The brand does not have a root key")
+	}
+
+	delegation, err := datastore.VerifyKeyDelegation(db.ModelNameCase, rootKey, delegation.Document, delegation.Signature)
+	if err != nil {
+		return 0, err
+	}
+
+	found := false
+	for _, k := range db.keypairs {
+		if k.AuthorityID == delegation.AuthorityID && k.KeyID == delegation.KeyID {
+			found = true
+		}
+	}
+	if !found {
+		return 0, errors.New("Cannot find the delegated signing-key")
+	}
+
+	delegation.ID = db.nextID()
+	delegation.Created = time.Now().UTC()
+	db.delegations = append(db.delegations, delegation)
+	return delegation.ID, nil
+}
+
+// ListKeyDelegations returns the delegations of the root key of the brand
+func (db *DB) ListKeyDelegations(authorityID string) ([]datastore.KeyDelegation, error) {
+	return db.listKeyDelegations(func(d datastore.KeyDelegation) bool { return d.AuthorityID == authorityID }), nil
+}
+
+// ListKeyDelegationsByKeyID returns the delegations to the signing-key with the key ID
+func (db *DB) ListKeyDelegationsByKeyID(keyID string) ([]datastore.KeyDelegation, error) {
+	return db.listKeyDelegations(func(d datastore.KeyDelegation) bool { return d.KeyID == keyID }), nil
+}
+
+// DeleteAllowedKeyDelegation revokes a delegation of the brand root key, if the user is authorized
+// for the account
+func (db *DB) DeleteAllowedKeyDelegation(delegationID int, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, d := range db.delegations {
+		if d.ID != delegationID {
+			continue
+		}
+		if !db.canWrite(authorization, d.AuthorityID) {
+			return errors.New("You do not have permissions for that authority")
+		}
+		db.delegations = append(db.delegations[:i], db.delegations[i+1:]...)
+		return nil
+	}
+	return errors.New("Cannot find the key delegation")
+}
+
+func (db *DB) listKeyDelegations(match func(datastore.KeyDelegation) bool) []datastore.KeyDelegation {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delegations := []datastore.KeyDelegation{}
+	for _, d := range db.delegations {
+		if match(d) {
+			delegations = append(delegations, d)
+		}
+	}
+	sort.SliceStable(delegations, func(i, j int) bool { return delegations[i].KeyID < delegations[j].KeyID })
+	return delegations
+}
@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// AssignAllowedModelKeypair re-points the models of a keypair to a new keypair,
+// if the authorization is allowed to change the models of the keypair's authority
+func (db *DB) AssignAllowedModelKeypair(assignment datastore.ModelKeypairAssignment, authorization datastore.User) ([]datastore.ModelKeypairChange, error) {
+	if err := datastore.ValidateModelKeypairAssignment(assignment); err != nil {
+		return nil, err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	from, err := db.keypair(assignment.FromKeypairID)
+	if err != nil {
+		return nil, errors.New("Cannot find the keypair of the models")
+	}
+	keypair, err := db.keypair(assignment.KeypairID)
+	if err != nil {
+		return nil, errors.New("Cannot find the new keypair")
+	}
+	if keypair.AuthorityID != from.AuthorityID {
+		return nil, errors.New("The new keypair must have the same authority as the keypair of the models")
+	}
+	if !keypair.Active {
+		return nil, errors.New("The new keypair must be active")
+	}
+	if !db.canWrite(authorization, keypair.AuthorityID) {
+		return nil, errors.New("You do not have permissions to this account")
+	}
+
+	selected := map[int]bool{}
+	for _, id := range assignment.ModelIDs {
+		selected[id] = true
+	}
+	found := map[int]bool{}
+
+	changes := []datastore.ModelKeypairChange{}
+	indexes := []int{}
+	for i, m := range db.models {
+		if m.KeypairID != assignment.FromKeypairID && m.KeypairIDUser != assignment.FromKeypairID {
+			continue
+		}
+		if len(selected) > 0 && !selected[m.ID] {
+			continue
+		}
+		found[m.ID] = true
+
+		c := datastore.ModelKeypairChange{
+			ModelID: m.ID, BrandID: m.BrandID, Model: m.Name,
+			OldKeypairID: m.KeypairID, NewKeypairID: m.KeypairID,
+			OldKeypairIDUser: m.KeypairIDUser, NewKeypairIDUser: m.KeypairIDUser,
+		}
+		if m.KeypairID == assignment.FromKeypairID {
+			c.NewKeypairID = assignment.KeypairID
+		}
+		if m.KeypairIDUser == assignment.FromKeypairID {
+			c.NewKeypairIDUser = assignment.KeypairID
+		}
+		if c.NewKeypairID != c.OldKeypairID && containsInt(db.fallbackKeys[m.ID], c.NewKeypairID) {
+			return nil, fmt.Errorf("The new keypair is a fallback key of model '%s'", m.Name)
+		}
+		changes = append(changes, c)
+		indexes = append(indexes, i)
+	}
+	for _, id := range assignment.ModelIDs {
+		if !found[id] {
+			return nil, fmt.Errorf("Model %d does not use the keypair", id)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Model < changes[j].Model })
+
+	if assignment.Preview {
+		return changes, nil
+	}
+
+	for _, i := range indexes {
+		if db.models[i].KeypairID == assignment.FromKeypairID {
+			db.models[i].KeypairID = assignment.KeypairID
+		}
+		if db.models[i].KeypairIDUser == assignment.FromKeypairID {
+			db.models[i].KeypairIDUser = assignment.KeypairID
+		}
+	}
+	for i := range changes {
+		changes[i].ID = db.nextID()
+		changes[i].Changed = time.Now().UTC()
+		changes[i].ChangedBy = authorization.Username
+		db.keypairHistory = append(db.keypairHistory, changes[i])
+	}
+	return changes, nil
+}
+
+// ListAllowedModelKeypairHistory returns the audit of the re-pointed keypairs of a model,
+// if the authorization is allowed to see the model
+func (db *DB) ListAllowedModelKeypairHistory(modelID int, authorization datastore.User) ([]datastore.ModelKeypairChange, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	m, err := db.model(modelID)
+	if err != nil || !db.canWrite(authorization, m.BrandID) {
+		return nil, errors.New("You do not have permissions to this model")
+	}
+
+	changes := []datastore.ModelKeypairChange{}
+	for i := len(db.keypairHistory) - 1; i >= 0; i-- {
+		if db.keypairHistory[i].ModelID == modelID {
+			changes = append(changes, db.keypairHistory[i])
+		}
+	}
+	return changes, nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
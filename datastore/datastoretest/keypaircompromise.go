@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CompromiseAllowedKeypair deactivates the signing-key and records that it is compromised, if the
+// user is authorized for the account of the signing-key
+func (db *DB) CompromiseAllowedKeypair(keypairID int, reason string, authorization datastore.User) (datastore.KeypairCompromise, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	k, err := db.keypair(keypairID)
+	if err != nil {
+		return datastore.KeypairCompromise{}, errors.New("Cannot find the signing-key")
+	}
+	if !db.canWrite(authorization, k.AuthorityID) {
+		return datastore.KeypairCompromise{}, errors.New("You do not have permissions for that authority")
+	}
+
+	for i := range db.keypairs {
+		if db.keypairs[i].ID == keypairID {
+			db.keypairs[i].Active = false
+		}
+	}
+
+	for _, c := range db.compromises {
+		if c.KeypairID == keypairID {
+			return c, nil
+		}
+	}
+	c := datastore.KeypairCompromise{
+		ID:          db.nextID(),
+		KeypairID:   keypairID,
+		AuthorityID: k.AuthorityID,
+		KeyID:       k.KeyID,
+		Reason:      strings.TrimSpace(reason),
+		ReportedBy:  authorization.Username,
+		Created:     time.Now().UTC(),
+	}
+	db.compromises = append(db.compromises, c)
+	return c, nil
+}
+
+// GetKeypairCompromise returns the compromise record of the signing-key, or sql.ErrNoRows when
+// the signing-key is not compromised
+func (db *DB) GetKeypairCompromise(keypairID int) (datastore.KeypairCompromise, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, c := range db.compromises {
+		if c.KeypairID == keypairID {
+			return c, nil
+		}
+	}
+	return datastore.KeypairCompromise{}, errNotFound
+}
+
+// ListKeypairCompromises returns the compromised signing-keys
+func (db *DB) ListKeypairCompromises() ([]datastore.KeypairCompromise, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return append([]datastore.KeypairCompromise{}, db.compromises...), nil
+}
+
+func (db *DB) compromised(keypairID int) bool {
+	for _, c := range db.compromises {
+		if c.KeypairID == keypairID {
+			return true
+		}
+	}
+	return false
+}
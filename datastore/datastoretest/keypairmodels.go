@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// ListKeypairModels returns the model allowlist of the signing-key
+func (db *DB) ListKeypairModels(keypairID int) ([]datastore.KeypairModel, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.listKeypairModels(keypairID), nil
+}
+
+// ListKeypairModelsByKeyID returns the model allowlist of the signing-key with the key ID
+func (db *DB) ListKeypairModelsByKeyID(keyID string) ([]datastore.KeypairModel, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, k := range db.keypairs {
+		if k.KeyID == keyID {
+			return db.listKeypairModels(k.ID), nil
+		}
+	}
+	return []datastore.KeypairModel{}, nil
+}
+
+// UpdateAllowedKeypairModels replaces the model allowlist of the signing-key, if the user is
+// authorized for the account of the signing-key
+func (db *DB) UpdateAllowedKeypairModels(keypairID int, models []datastore.KeypairModel, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	k, err := db.keypair(keypairID)
+	if err != nil {
+		return errors.New("Cannot find the signing-key")
+	}
+	if !db.canWrite(authorization, k.AuthorityID) {
+		return errors.New("You do not have permissions for that authority")
+	}
+
+	models, err = datastore.CanonicalKeypairModels(db.ModelNameCase, models)
+	if err != nil {
+		return err
+	}
+
+	if db.keypairModels == nil {
+		db.keypairModels = map[int][]datastore.KeypairModel{}
+	}
+	db.keypairModels[keypairID] = models
+	return nil
+}
+
+func (db *DB) listKeypairModels(keypairID int) []datastore.KeypairModel {
+	models := append([]datastore.KeypairModel{}, db.keypairModels[keypairID]...)
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].BrandID != models[j].BrandID {
+			return models[i].BrandID < models[j].BrandID
+		}
+		return models[i].Model < models[j].Model
+	})
+	return models
+}
@@ -0,0 +1,277 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// ListAllowedKeypairs returns the signing-keys visible to the authorization
+func (db *DB) ListAllowedKeypairs(authorization datastore.User) ([]datastore.Keypair, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	keypairs := []datastore.Keypair{}
+	for _, k := range db.keypairs {
+		if db.canRead(authorization, k.AuthorityID) {
+			keypairs = append(keypairs, k)
+		}
+	}
+	return keypairs, nil
+}
+
+// GetKeypair returns the signing-key by ID
+func (db *DB) GetKeypair(keypairID int) (datastore.Keypair, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.keypair(keypairID)
+}
+
+// GetKeypairByPublicID returns the signing-key by its public key ID
+func (db *DB) GetKeypairByPublicID(authorityID, keyID string) (datastore.Keypair, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, k := range db.keypairs {
+		if k.AuthorityID == authorityID && k.KeyID == keyID {
+			return k, nil
+		}
+	}
+	return datastore.Keypair{}, errNotFound
+}
+
+// GetKeypairByName returns the signing-key by its name
+func (db *DB) GetKeypairByName(authorityID, keyName string) (datastore.Keypair, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, k := range db.keypairs {
+		if k.AuthorityID == authorityID && k.KeyName == keyName {
+			return k, nil
+		}
+	}
+	return datastore.Keypair{}, errNotFound
+}
+
+// PutKeypair creates or updates a signing-key
+func (db *DB) PutKeypair(keypair datastore.Keypair) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(keypair.AuthorityID) == 0 || len(keypair.KeyID) == 0 {
+		return "error-validate-keypair", errors.New("The Authority ID and the Key ID must be entered")
+	}
+	if len(keypair.KeyName) == 0 {
+		keypair.KeyName = keypair.AuthorityID
+	}
+
+	for i, k := range db.keypairs {
+		if k.AuthorityID == keypair.AuthorityID && k.KeyID == keypair.KeyID {
+			db.keypairs[i].SealedKey = keypair.SealedKey
+			db.keypairs[i].Assertion = keypair.Assertion
+			db.keypairs[i].KeyName = keypair.KeyName
+			return "", nil
+		}
+	}
+
+	keypair.Active = true
+	db.addKeypair(keypair)
+	return "", nil
+}
+
+// UpdateAllowedKeypairActive enables or disables a signing-key, if the authorization is allowed to change it
+func (db *DB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if active && db.compromised(keypairID) {
+		return datastore.ErrKeypairCompromised
+	}
+
+	for i, k := range db.keypairs {
+		if k.ID == keypairID && db.canWrite(authorization, k.AuthorityID) {
+			db.keypairs[i].Active = active
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// UpdateKeypairAssertion updates the account-key assertion of a signing-key
+func (db *DB) UpdateKeypairAssertion(keypair datastore.Keypair, authorization datastore.User) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, k := range db.keypairs {
+		if k.ID != keypair.ID {
+			continue
+		}
+		if k.AuthorityID != keypair.AuthorityID || k.KeyID != keypair.KeyID {
+			return "invalid-assertion", errors.New("Authority ID does not match the existing account key")
+		}
+		if authorization.Role == datastore.Admin && !db.userInAccount(authorization.Username, keypair.AuthorityID) {
+			return "error-auth", errors.New("You do not have permissions for that authority")
+		}
+		db.keypairs[i].Assertion = keypair.Assertion
+		return "", nil
+	}
+	return "invalid-assertion", errNotFound
+}
+
+// CheckKeypairKeynameExists checks if the authority has a signing-key with the name
+func (db *DB) CheckKeypairKeynameExists(authorityID, name string) bool {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, k := range db.keypairs {
+		if k.AuthorityID == authorityID && k.KeyName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncKeypair stores a signing-key from the cloud
+func (db *DB) SyncKeypair(keypair datastore.SyncKeypair) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(keypair.AuthorityID) == 0 || len(keypair.KeyID) == 0 {
+		return errors.New("The Authority ID and the Key ID must be entered")
+	}
+
+	for i, k := range db.keypairs {
+		if k.ID == keypair.ID {
+			db.keypairs[i] = keypair.Keypair
+			return nil
+		}
+	}
+	db.addKeypair(keypair.Keypair)
+	return nil
+}
+
+// UpdateKeypairSealedKey replaces the sealed signing-key and its auth-key setting
+func (db *DB) UpdateKeypairSealedKey(keypair datastore.SyncKeypair) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, k := range db.keypairs {
+		if k.ID != keypair.ID {
+			continue
+		}
+		for j, s := range db.settings {
+			if s.Code == crypt.GenerateAuthKey(k.AuthorityID, k.KeyID) {
+				db.keypairs[i].SealedKey = keypair.SealedKey
+				db.settings[j].Data = keypair.AuthKeyHash
+				return nil
+			}
+		}
+	}
+	return errNotFound
+}
+
+func (db *DB) keypair(keypairID int) (datastore.Keypair, error) {
+	for _, k := range db.keypairs {
+		if k.ID == keypairID {
+			return k, nil
+		}
+	}
+	return datastore.Keypair{}, errNotFound
+}
+
+func (db *DB) addKeypair(keypair datastore.Keypair) datastore.Keypair {
+	if keypair.ID == 0 {
+		keypair.ID = db.nextID()
+	}
+	db.keypairs = append(db.keypairs, keypair)
+	return keypair
+}
+
+// CreateKeypairStatus adds the status record for the generation of a signing-key
+func (db *DB) CreateKeypairStatus(ks datastore.KeypairStatus) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	ks.ID = db.nextID()
+	ks.Status = datastore.KeypairStatusCreating
+	db.keypairStatus = append(db.keypairStatus, ks)
+	return ks.ID, nil
+}
+
+// UpdateKeypairStatus updates the status of the generation of a signing-key
+func (db *DB) UpdateKeypairStatus(ks datastore.KeypairStatus) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, s := range db.keypairStatus {
+		if s.AuthorityID == ks.AuthorityID && s.KeyName == ks.KeyName {
+			db.keypairStatus[i].Status = ks.Status
+			if ks.KeypairID > 0 {
+				db.keypairStatus[i].KeypairID = ks.KeypairID
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteKeypairStatus removes the status record for the generation of a signing-key
+func (db *DB) DeleteKeypairStatus(ks datastore.KeypairStatus) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, s := range db.keypairStatus {
+		if s.ID == ks.ID {
+			db.keypairStatus = append(db.keypairStatus[:i], db.keypairStatus[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetKeypairStatus returns the status of the generation of a signing-key
+func (db *DB) GetKeypairStatus(authorityID, keyName string) (datastore.KeypairStatus, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, s := range db.keypairStatus {
+		if s.AuthorityID == authorityID && s.KeyName == keyName {
+			return s, nil
+		}
+	}
+	return datastore.KeypairStatus{}, errNotFound
+}
+
+// ListAllowedKeypairStatus returns the status of the signing-keys visible to the authorization
+func (db *DB) ListAllowedKeypairStatus(authorization datastore.User) ([]datastore.KeypairStatus, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	statuses := []datastore.KeypairStatus{}
+	for _, s := range db.keypairStatus {
+		if db.canWrite(authorization, s.AuthorityID) {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses, nil
+}
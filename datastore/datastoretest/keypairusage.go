@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// RecordKeypairUsage counts an assertion that the signing-key signed for the brand/model, returning
+// true when it is the first one
+func (db *DB) RecordKeypairUsage(keypairID int, brandID, model string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	now := time.Now().UTC()
+	for i, u := range db.keypairUsage {
+		if u.KeypairID == keypairID && u.BrandID == brandID && u.Model == model {
+			db.keypairUsage[i].Signed++
+			db.keypairUsage[i].LastSigned = now
+			return false, nil
+		}
+	}
+
+	db.keypairUsage = append(db.keypairUsage, datastore.KeypairUsage{KeypairID: keypairID, BrandID: brandID, Model: model, Signed: 1, FirstSigned: now, LastSigned: now})
+	return true, nil
+}
+
+// ListKeypairUsage returns the brands/models that the signing-key has signed for
+func (db *DB) ListKeypairUsage(keypairID int) ([]datastore.KeypairUsage, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	usage := []datastore.KeypairUsage{}
+	for _, u := range db.keypairUsage {
+		if u.KeypairID == keypairID {
+			usage = append(usage, u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].BrandID != usage[j].BrandID {
+			return usage[i].BrandID < usage[j].BrandID
+		}
+		return usage[i].Model < usage[j].Model
+	})
+	return usage, nil
+}
@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CreateDeviceManifest stores the manifest of a device, replacing its previous manifest
+func (db *DB) CreateDeviceManifest(manifest datastore.DeviceManifest) error {
+	if err := datastore.ValidateDeviceManifest(manifest); err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, m := range db.manifests {
+		if m.Brand == manifest.Brand && m.Model == manifest.Model && m.SerialNumber == manifest.SerialNumber {
+			db.manifests = append(db.manifests[:i], db.manifests[i+1:]...)
+			break
+		}
+	}
+
+	manifest.ID = db.nextID()
+	manifest.Created = time.Now().UTC()
+	db.manifests = append(db.manifests, manifest)
+	return nil
+}
+
+// CreateAllowedDeviceManifest stores the manifest of a device, if the authorization is
+// allowed to do it for the brand
+func (db *DB) CreateAllowedDeviceManifest(manifest datastore.DeviceManifest, authorization datastore.User) error {
+	db.lock.Lock()
+	allowed := authorization.Role != datastore.Standard && db.canRead(authorization, manifest.Brand)
+	db.lock.Unlock()
+
+	if !allowed {
+		return errors.New("You do not have permissions to this account")
+	}
+	return db.CreateDeviceManifest(manifest)
+}
+
+// ListAllowedDeviceManifests returns the device manifests of an account visible to the
+// authorization, with the filters of the query
+func (db *DB) ListAllowedDeviceManifests(authorization datastore.User, authorityID string, query datastore.DeviceManifestQuery) ([]datastore.DeviceManifest, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	manifests := []datastore.DeviceManifest{}
+	if authorization.Role == datastore.Standard || !db.canRead(authorization, authorityID) {
+		return manifests, nil
+	}
+
+	// The most recent manifests first
+	for i := len(db.manifests) - 1; i >= 0; i-- {
+		m := db.manifests[i]
+		if m.Brand != authorityID || !matchManifest(m, query) {
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// matchManifest checks the manifest against the filters of the query
+func matchManifest(manifest datastore.DeviceManifest, query datastore.DeviceManifestQuery) bool {
+	if len(query.Model) > 0 && query.Model != manifest.Model {
+		return false
+	}
+	if len(query.SerialNumber) > 0 && query.SerialNumber != manifest.SerialNumber {
+		return false
+	}
+	if len(query.Snap) == 0 {
+		return true
+	}
+
+	for _, snap := range manifest.Snaps {
+		if snap.Name == query.Snap && (len(query.Revision) == 0 || snap.Revision == query.Revision) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncListDeviceManifests returns the device manifests to sync to the cloud
+func (db *DB) SyncListDeviceManifests() ([]datastore.DeviceManifest, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return append([]datastore.DeviceManifest{}, db.manifests...), nil
+}
+
+// SyncDeleteDeviceManifest removes a device manifest that has been synced to the cloud
+func (db *DB) SyncDeleteDeviceManifest(ID int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, m := range db.manifests {
+		if m.ID == ID {
+			db.manifests = append(db.manifests[:i], db.manifests[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
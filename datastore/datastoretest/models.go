@@ -0,0 +1,385 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// ListAllowedModels returns the models visible to the authorization
+func (db *DB) ListAllowedModels(authorization datastore.User) ([]datastore.Model, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	models := []datastore.Model{}
+	for _, m := range db.models {
+		if db.canRead(authorization, m.BrandID) {
+			models = append(models, db.withKeypairs(m))
+		}
+	}
+	return models, nil
+}
+
+// ListModelsMissingKeypairs returns the models that are linked with a keypair that does not exist
+func (db *DB) ListModelsMissingKeypairs() ([]datastore.Model, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	models := []datastore.Model{}
+	for _, m := range db.models {
+		_, err := db.keypair(m.KeypairID)
+		_, errUser := db.keypair(m.KeypairIDUser)
+		if err != nil || errUser != nil {
+			models = append(models, m)
+		}
+	}
+	return models, nil
+}
+
+// FindModel returns the model with the brand, name and API key
+func (db *DB) FindModel(brandID, modelName, apiKey string) (datastore.Model, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	brandID = datastore.CanonicalBrandID(brandID)
+	modelName = datastore.CanonicalModelName(db.ModelNameCase, brandID, modelName)
+	for _, m := range db.models {
+		if m.BrandID == brandID && m.Name == modelName && m.APIKey == apiKey {
+			return db.withKeypairs(m), nil
+		}
+	}
+	return datastore.Model{}, errNotFound
+}
+
+// GetAllowedModel returns the model, if it is visible to the authorization
+func (db *DB) GetAllowedModel(modelID int, authorization datastore.User) (datastore.Model, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	m, err := db.model(modelID)
+	if err != nil || !db.canWrite(authorization, m.BrandID) {
+		return datastore.Model{}, errNotFound
+	}
+	return db.withKeypairs(m), nil
+}
+
+// UpdateAllowedModel updates the model, if the authorization is allowed to change it
+func (db *DB) UpdateAllowedModel(model datastore.Model, authorization datastore.User) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	model = db.canonicalModel(model)
+	if errorSubcode, err := db.validateModel(model, "error-validate-model"); err != nil {
+		return errorSubcode, err
+	}
+
+	for i, m := range db.models {
+		if m.ID != model.ID {
+			continue
+		}
+		if (model.BrandID != m.BrandID || model.Name != m.Name) && db.modelExists(model.BrandID, model.Name) {
+			return "error-model-exists", errors.New("A device with the same Brand and Model already exists")
+		}
+		if !db.canWrite(authorization, m.BrandID) {
+			return "", nil
+		}
+		if len(model.APIKey) == 0 {
+			model.APIKey = m.APIKey
+		}
+		db.models[i] = storedModel(model)
+		return "", nil
+	}
+	return "error-model-not-found", errors.New("Cannot find the model")
+}
+
+// DeleteAllowedModel removes the model and its model assertion, if the authorization is allowed to do it
+func (db *DB) DeleteAllowedModel(model datastore.Model, authorization datastore.User) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, m := range db.models {
+		if m.ID == model.ID && db.canWrite(authorization, m.BrandID) {
+			db.models = append(db.models[:i], db.models[i+1:]...)
+			db.deleteModelAssert(model.ID)
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// CreateAllowedModel adds a new model, if the authorization is allowed to do it
+func (db *DB) CreateAllowedModel(model datastore.Model, authorization datastore.User) (datastore.Model, string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	model = db.canonicalModel(model)
+	if errorSubcode, err := db.validateModel(model, "error-validate-new-model"); err != nil {
+		return model, errorSubcode, err
+	}
+	if !db.canWrite(authorization, model.BrandID) {
+		return model, "error-auth", errors.New("The user does not have permissions to create a model for this account")
+	}
+	if db.modelExists(model.BrandID, model.Name) {
+		return model, "error-model-exists", errors.New("A device with the same Brand and Model already exists")
+	}
+
+	m, err := db.addModel(model)
+	if err != nil {
+		return model, "error-model-apikey", errors.New("Error in generating a valid API key")
+	}
+	return db.withKeypairs(m), "", nil
+}
+
+// CheckAPIKey checks if a model has the API key
+func (db *DB) CheckAPIKey(apiKey string) bool {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, m := range db.models {
+		if m.APIKey == apiKey {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckModelExists checks if the brand has a model with the name
+func (db *DB) CheckModelExists(brandID, name string) bool {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	brandID = datastore.CanonicalBrandID(brandID)
+	return db.modelExists(brandID, datastore.CanonicalModelName(db.ModelNameCase, brandID, name))
+}
+
+// ListNearDuplicateModels finds the models that have the same canonical brand ID and model name
+func (db *DB) ListNearDuplicateModels() ([]datastore.NearDuplicateModels, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return datastore.FindNearDuplicateModels(db.ModelNameCase, db.models), nil
+}
+
+// SyncModel stores a model from the cloud
+func (db *DB) SyncModel(model datastore.Model) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, err := db.validateModel(model, "error-validate-new-model"); err != nil {
+		return err
+	}
+
+	for i, m := range db.models {
+		if m.ID == model.ID {
+			db.models[i] = storedModel(model)
+			return nil
+		}
+	}
+	_, err := db.addModel(model)
+	return err
+}
+
+// CreateModelAssert adds the model assertion details of a model
+func (db *DB) CreateModelAssert(m datastore.ModelAssertion) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.addModelAssert(m), nil
+}
+
+// UpdateModelAssert updates the model assertion details of a model
+func (db *DB) UpdateModelAssert(m datastore.ModelAssertion) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, a := range db.modelAsserts {
+		if a.ID == m.ID {
+			m.Created = a.Created
+			m.Modified = time.Now().UTC()
+			db.modelAsserts[i] = m
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// GetModelAssert returns the model assertion details of a model
+func (db *DB) GetModelAssert(modelID int) (datastore.ModelAssertion, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.modelAssert(modelID)
+}
+
+// UpsertModelAssert creates or updates the model assertion details of a model
+func (db *DB) UpsertModelAssert(m datastore.ModelAssertion) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, a := range db.modelAsserts {
+		if a.ModelID == m.ModelID {
+			m.ID = a.ID
+			m.Created = a.Created
+			m.Modified = time.Now().UTC()
+			db.modelAsserts[i] = m
+			return nil
+		}
+	}
+	db.addModelAssert(m)
+	return nil
+}
+
+func (db *DB) model(modelID int) (datastore.Model, error) {
+	for _, m := range db.models {
+		if m.ID == modelID {
+			return m, nil
+		}
+	}
+	return datastore.Model{}, errNotFound
+}
+
+// canonicalModel returns the model with its canonical brand ID and name
+func (db *DB) canonicalModel(model datastore.Model) datastore.Model {
+	model.BrandID = datastore.CanonicalBrandID(model.BrandID)
+	model.Name = datastore.CanonicalModelName(db.ModelNameCase, model.BrandID, model.Name)
+	return model
+}
+
+func (db *DB) modelExists(brandID, name string) bool {
+	for _, m := range db.models {
+		if m.BrandID == brandID && m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) addModel(model datastore.Model) (datastore.Model, error) {
+	if len(model.APIKey) == 0 {
+		apiKey, err := random.GenerateRandomString(40)
+		if err != nil {
+			return model, err
+		}
+		model.APIKey = apiKey
+	}
+	if model.ID == 0 {
+		model.ID = db.nextID()
+	}
+	model = storedModel(model)
+	db.models = append(db.models, model)
+	return model, nil
+}
+
+func (db *DB) validateModel(model datastore.Model, validateModelLabel string) (string, error) {
+	if len(model.BrandID) == 0 || len(model.Name) == 0 {
+		return validateModelLabel, errors.New("The Brand ID and the Model name must be entered")
+	}
+	if model.KeypairID <= 0 {
+		return "error-validate-signingkey", errors.New("The Signing Key must be selected")
+	}
+	if model.KeypairIDUser <= 0 {
+		return "error-validate-userkey", errors.New("The System-User Key must be selected")
+	}
+	if err := datastore.ValidateTimestampPolicy(model.TimestampPolicy); err != nil {
+		return "error-validate-timestamp-policy", err
+	}
+	if err := datastore.ValidateDeviceKeyPolicy(model.DeviceKeyPolicy); err != nil {
+		return "error-validate-device-key-policy", err
+	}
+	if err := datastore.ValidateSerialPipeline(model.SerialPipeline); err != nil {
+		return "error-validate-serial-pipeline", err
+	}
+	if err := datastore.ValidateDuplicatePolicy(model.DuplicatePolicy); err != nil {
+		return "error-validate-duplicate-policy", err
+	}
+	if err := datastore.ValidateSerialResponse(model.SerialResponse); err != nil {
+		return "error-validate-serial-response", err
+	}
+
+	for _, keypairID := range []int{model.KeypairID, model.KeypairIDUser} {
+		if k, err := db.keypair(keypairID); err == nil && k.AuthorityID != model.BrandID {
+			return "error-auth", errors.New("The model and the keys must have the same brand")
+		}
+	}
+	return "", nil
+}
+
+// storedModel clears the fields of the model that come from the keypairs and the model assertion
+func storedModel(model datastore.Model) datastore.Model {
+	return datastore.Model{
+		ID:            model.ID,
+		BrandID:       model.BrandID,
+		Name:          model.Name,
+		KeypairID:     model.KeypairID,
+		APIKey:        model.APIKey,
+		KeypairIDUser: model.KeypairIDUser,
+	}
+}
+
+// withKeypairs fills the fields of the model that come from the keypairs and the model assertion
+func (db *DB) withKeypairs(model datastore.Model) datastore.Model {
+	if k, err := db.keypair(model.KeypairID); err == nil {
+		model.AuthorityID = k.AuthorityID
+		model.KeyID = k.KeyID
+		model.KeyActive = k.Active
+		model.SealedKey = k.SealedKey
+	}
+	if k, err := db.keypair(model.KeypairIDUser); err == nil {
+		model.AuthorityIDUser = k.AuthorityID
+		model.KeyIDUser = k.KeyID
+		model.KeyActiveUser = k.Active
+		model.SealedKeyUser = k.SealedKey
+		model.AssertionUser = k.Assertion
+	}
+	if a, err := db.modelAssert(model.ID); err == nil {
+		model.ModelAssertion = a
+	}
+	return model
+}
+
+func (db *DB) modelAssert(modelID int) (datastore.ModelAssertion, error) {
+	for _, a := range db.modelAsserts {
+		if a.ModelID == modelID {
+			return a, nil
+		}
+	}
+	return datastore.ModelAssertion{}, errNotFound
+}
+
+func (db *DB) addModelAssert(m datastore.ModelAssertion) int {
+	m.ID = db.nextID()
+	m.Created = time.Now().UTC()
+	m.Modified = m.Created
+	db.modelAsserts = append(db.modelAsserts, m)
+	return m.ID
+}
+
+func (db *DB) deleteModelAssert(modelID int) {
+	for i, a := range db.modelAsserts {
+		if a.ModelID == modelID {
+			db.modelAsserts = append(db.modelAsserts[:i], db.modelAsserts[i+1:]...)
+			return
+		}
+	}
+}
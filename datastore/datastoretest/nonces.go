@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// nonceMaximumAge is the lifetime of a device nonce in seconds, as in the database
+const nonceMaximumAge = 600
+
+type deviceNonce struct {
+	datastore.DeviceNonce
+	apiKey   string
+	clientIP string
+}
+
+// DeleteExpiredDeviceNonces removes the device nonces that have expired
+func (db *DB) DeleteExpiredDeviceNonces() (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nonces := []deviceNonce{}
+	for _, n := range db.deviceNonces {
+		if !expired(n) {
+			nonces = append(nonces, n)
+		}
+	}
+	purged := len(db.deviceNonces) - len(nonces)
+	db.deviceNonces = nonces
+	return purged, nil
+}
+
+// CreateDeviceNonce generates a device nonce for the model API key and the client IP
+func (db *DB) CreateDeviceNonce(apiKey, clientIP string) (datastore.DeviceNonce, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.createDeviceNonce(apiKey, clientIP)
+}
+
+// CreateDeviceNonces generates a batch of device nonces for the model API key and the client IP
+func (db *DB) CreateDeviceNonces(apiKey, clientIP string, count int) ([]datastore.DeviceNonce, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nonces := []datastore.DeviceNonce{}
+	if count < 1 || count > datastore.NonceBatchMaximum {
+		return nonces, fmt.Errorf("The number of nonces must be between 1 and %d", datastore.NonceBatchMaximum)
+	}
+
+	outstanding := 0
+	for _, n := range db.deviceNonces {
+		if n.apiKey == apiKey && !expired(n) {
+			outstanding++
+		}
+	}
+	if outstanding+count > datastore.NonceOutstandingMaximum {
+		return nil, datastore.ErrNonceLimit
+	}
+
+	for i := 0; i < count; i++ {
+		nonce, err := db.createDeviceNonce(apiKey, clientIP)
+		if err != nil {
+			return nil, err
+		}
+		nonces = append(nonces, nonce)
+	}
+	return nonces, nil
+}
+
+// CountDeviceNonces returns the number of unexpired device nonces of the model API key
+func (db *DB) CountDeviceNonces(apiKey string) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	count := 0
+	for _, n := range db.deviceNonces {
+		if n.apiKey == apiKey && !expired(n) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ValidateDeviceNonce checks that the device nonce is valid and bound to the request, and uses it up
+func (db *DB) ValidateDeviceNonce(nonce, apiKey, clientIP string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, n := range db.deviceNonces {
+		if n.Nonce == nonce && !expired(n) && boundTo(db.NonceBinding, n, apiKey, clientIP) {
+			db.deviceNonces = append(db.deviceNonces[:i], db.deviceNonces[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("The nonce is invalid or expired")
+}
+
+func (db *DB) createDeviceNonce(apiKey, clientIP string) (datastore.DeviceNonce, error) {
+	token, err := random.GenerateRandomString(64)
+	if err != nil {
+		return datastore.DeviceNonce{}, err
+	}
+
+	nonce := datastore.DeviceNonce{
+		ID:        db.nextID(),
+		Nonce:     token,
+		TimeStamp: time.Now().Unix(),
+		Created:   time.Now().UTC(),
+	}
+	db.deviceNonces = append(db.deviceNonces, deviceNonce{nonce, apiKey, clientIP})
+	return nonce, nil
+}
+
+// boundTo checks if the device nonce was issued for the request, depending on the nonce binding
+func boundTo(binding string, n deviceNonce, apiKey, clientIP string) bool {
+	switch binding {
+	case datastore.NonceBindNone:
+		return true
+	case datastore.NonceBindClientIP:
+		return n.apiKey == apiKey && n.clientIP == clientIP
+	default:
+		return n.apiKey == apiKey
+	}
+}
+
+// expired checks if the device nonce is older than its lifetime
+func expired(n deviceNonce) bool {
+	return n.TimeStamp < time.Now().Unix()-nonceMaximumAge
+}
@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// onboarding is an onboarding with the hash of its token, as the token is not stored
+type onboarding struct {
+	datastore.Onboarding
+	hash string
+}
+
+// CreateOnboarding stores a pending onboarding and returns it with its onboarding token
+func (db *DB) CreateOnboarding(o datastore.Onboarding) (datastore.Onboarding, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateOnboarding(db.ModelNameCase, o); err != nil {
+		return o, err
+	}
+	for _, existing := range db.onboardings {
+		if existing.AuthorityID == o.AuthorityID && (existing.Status == datastore.OnboardingPending || existing.Status == datastore.OnboardingApproved) {
+			return o, errors.New("The brand already has an onboarding in progress")
+		}
+	}
+
+	token, hash, err := datastore.NewShareTokenSecret()
+	if err != nil {
+		return o, err
+	}
+
+	o.ID = db.nextID()
+	o.Token = ""
+	o.Status = datastore.OnboardingPending
+	o.Created = time.Now().UTC()
+	db.onboardings = append(db.onboardings, onboarding{Onboarding: o, hash: hash})
+
+	o.Token = token
+	return o, nil
+}
+
+// GetOnboarding fetches an onboarding, with its sealed signing-key
+func (db *DB) GetOnboarding(onboardingID int) (datastore.Onboarding, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, o := range db.onboardings {
+		if o.ID == onboardingID {
+			return o.Onboarding, nil
+		}
+	}
+	return datastore.Onboarding{}, datastore.ErrOnboardingNotFound
+}
+
+// GetOnboardingByToken fetches the onboarding of an onboarding token
+func (db *DB) GetOnboardingByToken(token string) (datastore.Onboarding, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(token) == 0 {
+		return datastore.Onboarding{}, errors.New("The onboarding token must be provided")
+	}
+	hash := datastore.ShareTokenHash(token)
+	for _, o := range db.onboardings {
+		if o.hash == hash {
+			return o.Onboarding, nil
+		}
+	}
+	return datastore.Onboarding{}, errors.New("Invalid onboarding token")
+}
+
+// ListOnboardings returns the onboardings with the status, or all the onboardings, latest first
+func (db *DB) ListOnboardings(status string) ([]datastore.Onboarding, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	onboardings := []datastore.Onboarding{}
+	for i := len(db.onboardings) - 1; i >= 0; i-- {
+		if len(status) == 0 || db.onboardings[i].Status == status {
+			o := db.onboardings[i].Onboarding
+			o.SealedKey = ""
+			onboardings = append(onboardings, o)
+		}
+	}
+	return onboardings, nil
+}
+
+// DecideOnboarding approves or rejects a pending onboarding
+func (db *DB) DecideOnboarding(onboardingID int, approved bool, decidedBy, reason string) (datastore.Onboarding, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i := range db.onboardings {
+		if db.onboardings[i].ID == onboardingID {
+			o := db.onboardings[i].Onboarding
+			if err := o.Decide(approved, decidedBy, reason, time.Now().UTC()); err != nil {
+				return datastore.Onboarding{}, err
+			}
+			if !approved {
+				o.SealedKey = ""
+			}
+			db.onboardings[i].Onboarding = o
+			return o, nil
+		}
+	}
+	return datastore.Onboarding{}, datastore.ErrOnboardingNotFound
+}
+
+// FinishOnboarding records the outcome of the provisioning of an approved onboarding
+func (db *DB) FinishOnboarding(onboardingID int, status, apiKey, reason string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i := range db.onboardings {
+		if db.onboardings[i].ID == onboardingID {
+			db.onboardings[i].Status = status
+			db.onboardings[i].APIKey = apiKey
+			db.onboardings[i].Reason = reason
+			db.onboardings[i].SealedKey = ""
+			return nil
+		}
+	}
+	return datastore.ErrOnboardingNotFound
+}
@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// AllowedDashboard returns the summary of the signing activity visible to the authorization
+func (db *DB) AllowedDashboard(authorization datastore.User) (datastore.Dashboard, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	dashboard := datastore.Dashboard{Signings: []datastore.ModelSigningCount{}}
+	if authorization.Role == datastore.Standard {
+		return dashboard, nil
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	week := today.AddDate(0, 0, -6)
+
+	counts := map[string]int{}
+	conflicts := map[string]bool{}
+	for _, l := range db.signingLogs {
+		if !db.canRead(authorization, l.Make) || l.Created.Before(week) {
+			continue
+		}
+
+		if l.Revision > 1 {
+			dashboard.Duplicates++
+			if db.conflicts(l) {
+				conflicts[l.Make+"/"+l.Model+"/"+l.SerialNumber] = true
+			}
+		}
+
+		key := l.Make + "/" + l.Model
+		i, ok := counts[key]
+		if !ok {
+			i = len(dashboard.Signings)
+			counts[key] = i
+			dashboard.Signings = append(dashboard.Signings, datastore.ModelSigningCount{Make: l.Make, Model: l.Model})
+		}
+		dashboard.Signings[i].Week++
+		if !l.Created.Before(today) {
+			dashboard.Signings[i].Today++
+		}
+	}
+
+	sort.Slice(dashboard.Signings, func(i, j int) bool {
+		if dashboard.Signings[i].Make != dashboard.Signings[j].Make {
+			return dashboard.Signings[i].Make < dashboard.Signings[j].Make
+		}
+		return dashboard.Signings[i].Model < dashboard.Signings[j].Model
+	})
+
+	dashboard.Conflicts = len(conflicts)
+
+	if datastore.Environ != nil && datastore.InFactory() {
+		for _, l := range db.signingLogs {
+			if l.Synced == 0 {
+				dashboard.PendingSync++
+			}
+		}
+		return dashboard, nil
+	}
+
+	for _, c := range db.checkIns {
+		if db.canReadSyncUser(authorization, c.Username) && (dashboard.LastSync == nil || c.Received.After(dashboard.LastSync.Received)) {
+			checkIn := c
+			dashboard.LastSync = &checkIn
+		}
+	}
+
+	return dashboard, nil
+}
+
+// conflicts checks if a revision of a serial number was signed for a different device-key than
+// an earlier revision, and the device is not quarantined
+func (db *DB) conflicts(signingLog datastore.SigningLog) bool {
+	for _, q := range db.quarantine {
+		if q.Brand == signingLog.Make && q.SerialNumber == signingLog.SerialNumber {
+			return false
+		}
+	}
+	for _, l := range db.signingLogs {
+		if l.Make == signingLog.Make && l.Model == signingLog.Model && l.SerialNumber == signingLog.SerialNumber &&
+			l.Revision < signingLog.Revision && l.Fingerprint != signingLog.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// canReadSyncUser checks if the authorization shares an account with the sync user
+func (db *DB) canReadSyncUser(authorization datastore.User, username string) bool {
+	if authorization.Role == datastore.Invalid || authorization.Role == datastore.Superuser {
+		return true
+	}
+	for _, u := range db.users {
+		if u.Username != username {
+			continue
+		}
+		for _, a := range u.Accounts {
+			if db.canRead(authorization, a.AuthorityID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowedProductionReport returns the production report of the account between the from
+// and to days (inclusive), if the account is visible to the authorization
+func (db *DB) AllowedProductionReport(authorization datastore.User, authorityID string, from, to time.Time) (datastore.ProductionReport, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	report := datastore.ProductionReport{
+		AuthorityID: authorityID,
+		From:        from.Format(datastore.ReportDateFormat),
+		To:          to.Format(datastore.ReportDateFormat),
+		Generated:   time.Now().UTC(),
+		Days:        []datastore.ReportModelDay{},
+		Ranges:      []datastore.ReportSerialRange{},
+	}
+	if authorization.Role == datastore.Standard || !db.canRead(authorization, authorityID) {
+		return report, nil
+	}
+
+	end := to.AddDate(0, 0, 1)
+	logs := []datastore.SigningLog{}
+	for _, l := range db.signingLogs {
+		if l.Make == authorityID && !l.Created.Before(from) && l.Created.Before(end) {
+			logs = append(logs, l)
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].Model != logs[j].Model {
+			return logs[i].Model < logs[j].Model
+		}
+		return logs[i].Created.Before(logs[j].Created)
+	})
+
+	var (
+		day     *datastore.ReportModelDay
+		serials *datastore.ReportSerialRange
+		seen    map[string]bool
+	)
+
+	// The logs are ordered by model and date, so a change starts a new entry
+	for _, l := range logs {
+		created := l.Created.UTC().Format(datastore.ReportDateFormat)
+		if day == nil || day.Model != l.Model || day.Day != created {
+			report.Days = append(report.Days, datastore.ReportModelDay{Model: l.Model, Day: created})
+			day = &report.Days[len(report.Days)-1]
+		}
+		day.Count++
+
+		if serials == nil || serials.Model != l.Model {
+			report.Ranges = append(report.Ranges, datastore.ReportSerialRange{Model: l.Model, First: l.SerialNumber, Last: l.SerialNumber})
+			serials = &report.Ranges[len(report.Ranges)-1]
+			seen = map[string]bool{}
+		}
+		if l.SerialNumber < serials.First {
+			serials.First = l.SerialNumber
+		}
+		if l.SerialNumber > serials.Last {
+			serials.Last = l.SerialNumber
+		}
+		if !seen[l.SerialNumber] {
+			seen[l.SerialNumber] = true
+			serials.Serials++
+		}
+	}
+
+	return report, nil
+}
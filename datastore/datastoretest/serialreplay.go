@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+type serialReplay struct {
+	apiKey  string
+	replay  datastore.SerialReplay
+	created time.Time
+}
+
+// GetSerialReplay returns the serial assertion that was signed for the serial-request hash within
+// the window, or an empty assertion
+func (db *DB) GetSerialReplay(requestHash, apiKey string, window time.Duration) (datastore.SerialReplay, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	r, ok := db.serialReplays[requestHash]
+	if !ok || r.apiKey != apiKey || r.created.Before(time.Now().Add(-window)) {
+		return datastore.SerialReplay{}, nil
+	}
+	return r.replay, nil
+}
+
+// CreateSerialReplay stores the serial assertion that was signed for the serial-request hash
+func (db *DB) CreateSerialReplay(requestHash, apiKey string, replay datastore.SerialReplay) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.serialReplays == nil {
+		db.serialReplays = map[string]serialReplay{}
+	}
+	db.serialReplays[requestHash] = serialReplay{apiKey: apiKey, replay: replay, created: time.Now()}
+	return nil
+}
+
+// DeleteExpiredSerialReplays removes the serial assertions that were signed before the window
+func (db *DB) DeleteExpiredSerialReplays(window time.Duration) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	purged := 0
+	for hash, r := range db.serialReplays {
+		if r.created.Before(time.Now().Add(-window)) {
+			delete(db.serialReplays, hash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// AddSerialReplay stores a serial replay fixture, signed at the created time
+func (db *DB) AddSerialReplay(requestHash, apiKey, serial string, created time.Time) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.serialReplays == nil {
+		db.serialReplays = map[string]serialReplay{}
+	}
+	db.serialReplays[requestHash] = serialReplay{apiKey: apiKey, replay: datastore.SerialReplay{Serial: serial}, created: created}
+}
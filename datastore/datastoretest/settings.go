@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// PutSetting creates or updates a setting
+func (db *DB) PutSetting(setting datastore.Setting) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, s := range db.settings {
+		if s.Code == setting.Code {
+			db.settings[i].Data = setting.Data
+			return nil
+		}
+	}
+
+	setting.ID = db.nextID()
+	db.settings = append(db.settings, setting)
+	return nil
+}
+
+// GetSetting returns the setting by its code
+func (db *DB) GetSetting(code string) (datastore.Setting, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, s := range db.settings {
+		if s.Code == code {
+			return s, nil
+		}
+	}
+	return datastore.Setting{}, errNotFound
+}
+
+// PutSchemaVersion records the version of the database schema
+func (db *DB) PutSchemaVersion(version int) error {
+	return db.PutSetting(datastore.Setting{Code: datastore.SettingSchemaVersion, Data: strconv.Itoa(version)})
+}
+
+// GetSchemaVersion returns the version of the database schema, which is 0 when it has not been recorded
+func (db *DB) GetSchemaVersion() (int, error) {
+	setting, err := db.GetSetting(datastore.SettingSchemaVersion)
+	if err != nil {
+		return 0, nil
+	}
+	return strconv.Atoi(setting.Data)
+}
+
+// ListConfigSettings returns the config settings that have been set
+func (db *DB) ListConfigSettings() ([]datastore.ConfigSetting, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	settings := append([]datastore.ConfigSetting{}, db.configSettings...)
+	sort.Slice(settings, func(i, j int) bool {
+		if settings[i].Namespace != settings[j].Namespace {
+			return settings[i].Namespace < settings[j].Namespace
+		}
+		return settings[i].Name < settings[j].Name
+	})
+	return settings, nil
+}
+
+// PutConfigSetting validates and stores a config setting, recording the change in its history
+func (db *DB) PutConfigSetting(setting datastore.ConfigSetting) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := datastore.ValidateConfigSetting(setting); err != nil {
+		return err
+	}
+
+	change := datastore.ConfigSettingChange{
+		ID:        db.nextID(),
+		Namespace: setting.Namespace,
+		Name:      setting.Name,
+		NewValue:  setting.Value,
+		Changed:   time.Now().UTC(),
+		ChangedBy: setting.ModifiedBy,
+	}
+	setting.Modified = change.Changed
+
+	found := false
+	for i, s := range db.configSettings {
+		if s.Namespace == setting.Namespace && s.Name == setting.Name {
+			change.OldValue = s.Value
+			db.configSettings[i] = setting
+			found = true
+		}
+	}
+	if !found {
+		db.configSettings = append(db.configSettings, setting)
+	}
+	db.settingChanges = append(db.settingChanges, change)
+	return nil
+}
+
+// ListConfigSettingHistory returns the changes of a config setting, newest first
+func (db *DB) ListConfigSettingHistory(namespace, name string) ([]datastore.ConfigSettingChange, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	changes := []datastore.ConfigSettingChange{}
+	for i := len(db.settingChanges) - 1; i >= 0; i-- {
+		c := db.settingChanges[i]
+		if c.Namespace == namespace && c.Name == name {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// shareToken is a share token with the hash of its token, as the token is not stored
+type shareToken struct {
+	datastore.ShareToken
+	hash string
+}
+
+// CreateAllowedShareToken creates a share token for the brand, if the authorization is allowed to do it
+func (db *DB) CreateAllowedShareToken(token datastore.ShareToken, authorization datastore.User) (datastore.ShareToken, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	now := time.Now().UTC()
+	if err := datastore.ValidateShareToken(token, now); err != nil {
+		return datastore.ShareToken{}, err
+	}
+	if !db.canWrite(authorization, token.BrandID) {
+		return datastore.ShareToken{}, errors.New("You do not have permissions to this brand")
+	}
+	for _, m := range token.Models {
+		if !db.modelExists(token.BrandID, m) {
+			return datastore.ShareToken{}, fmt.Errorf("Cannot find model '%s' for the brand", m)
+		}
+	}
+
+	secret, hash, err := datastore.NewShareTokenSecret()
+	if err != nil {
+		return datastore.ShareToken{}, err
+	}
+
+	token.ID = db.nextID()
+	token.Token = ""
+	token.CreatedBy = authorization.Username
+	token.Created = now
+	db.shareTokens = append(db.shareTokens, shareToken{ShareToken: token, hash: hash})
+
+	token.Token = secret
+	return token, nil
+}
+
+// ListAllowedShareTokens returns the share tokens of the brand, if it is visible to the authorization
+func (db *DB) ListAllowedShareTokens(authorization datastore.User, authorityID string) ([]datastore.ShareToken, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tokens := []datastore.ShareToken{}
+	if !db.canWrite(authorization, authorityID) {
+		return tokens, nil
+	}
+	for _, t := range db.shareTokens {
+		if t.BrandID == authorityID {
+			tokens = append(tokens, t.ShareToken)
+		}
+	}
+	return tokens, nil
+}
+
+// DeleteAllowedShareToken revokes the share token, if the authorization is allowed to do it
+func (db *DB) DeleteAllowedShareToken(tokenID int, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, t := range db.shareTokens {
+		if t.ID == tokenID && db.canWrite(authorization, t.BrandID) {
+			db.shareTokens = append(db.shareTokens[:i], db.shareTokens[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// GetShareToken finds the share token, returning an error if it is unknown or it has expired
+func (db *DB) GetShareToken(token string) (datastore.ShareToken, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(token) == 0 {
+		return datastore.ShareToken{}, errors.New("The share token must be provided")
+	}
+
+	hash := datastore.ShareTokenHash(token)
+	for _, t := range db.shareTokens {
+		if t.hash != hash {
+			continue
+		}
+		if t.Expired(time.Now().UTC()) {
+			return datastore.ShareToken{}, errors.New("The share token has expired")
+		}
+		return t.ShareToken, nil
+	}
+	return datastore.ShareToken{}, errors.New("Invalid share token")
+}
@@ -0,0 +1,418 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CheckForDuplicate checks if the serial number and/or the device-key have been signed, as set
+// by the duplicate scope, and returns the highest revision signed for the serial number
+func (db *DB) CheckForDuplicate(signLog *datastore.SigningLog, scope string) (bool, int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var duplicateExists bool
+	var maxRevision int
+	for _, l := range db.signingLogs {
+		sameSerial := l.Make == signLog.Make && l.Model == signLog.Model && l.SerialNumber == signLog.SerialNumber
+		sameKey := l.Fingerprint == signLog.Fingerprint
+
+		switch scope {
+		case datastore.DuplicateScopeSerial:
+			duplicateExists = duplicateExists || sameSerial
+		case datastore.DuplicateScopeDeviceKey:
+			duplicateExists = duplicateExists || sameKey
+		case datastore.DuplicateScopePair:
+			duplicateExists = duplicateExists || (sameSerial && sameKey)
+		default:
+			duplicateExists = duplicateExists || sameSerial || sameKey
+		}
+		if sameSerial && l.Revision > maxRevision {
+			maxRevision = l.Revision
+		}
+	}
+	return duplicateExists, maxRevision, nil
+}
+
+// CheckForMatching checks if the signing log has an entry with the same serial number and revision
+func (db *DB) CheckForMatching(signLog datastore.SigningLog) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, l := range db.signingLogs {
+		if l.Make == signLog.Make && l.Model == signLog.Model && l.SerialNumber == signLog.SerialNumber && l.Revision == signLog.Revision {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateSigningLog adds an entry to the signing log
+func (db *DB) CreateSigningLog(signLog datastore.SigningLog) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(signLog.Make) == 0 || len(signLog.Model) == 0 || len(signLog.SerialNumber) == 0 || len(signLog.Fingerprint) == 0 {
+		return errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
+	}
+
+	// The signed units of a new device are counted again under the lock
+	if signLog.Revision == 1 {
+		a, err := db.signingAuthorization(signLog.Make, signLog.Model)
+		if err != nil {
+			return err
+		}
+		if a != nil {
+			if err := db.checkSignedUnits(*a); err != nil {
+				return err
+			}
+		}
+	}
+
+	signLog.ID = 0
+	signLog.Created = time.Now().UTC()
+	db.addSigningLog(signLog)
+	return nil
+}
+
+// CreateSigningLogSync adds an entry from the factory to the signing log, keeping its ID and timestamp
+func (db *DB) CreateSigningLogSync(signLog datastore.SigningLog) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.addSigningLog(signLog)
+	return nil
+}
+
+// ImportSigningLog adds a historical entry to the signing log, keeping its signing time,
+// unless it duplicates or conflicts with an entry of the signing log
+func (db *DB) ImportSigningLog(signLog datastore.SigningLog) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(signLog.Make) == 0 || len(signLog.Model) == 0 || len(signLog.SerialNumber) == 0 || len(signLog.Fingerprint) == 0 {
+		return "", errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
+	}
+
+	var maxRevision int
+	var conflict bool
+	for _, l := range db.signingLogs {
+		if l.Make != signLog.Make || l.Model != signLog.Model || l.SerialNumber != signLog.SerialNumber {
+			continue
+		}
+		if l.Fingerprint == signLog.Fingerprint {
+			return datastore.ImportDuplicate, nil
+		}
+		if l.Revision == signLog.Revision {
+			conflict = true
+		}
+		if l.Revision > maxRevision {
+			maxRevision = l.Revision
+		}
+	}
+
+	if signLog.Revision == 0 {
+		signLog.Revision = maxRevision + 1
+	} else if conflict {
+		return datastore.ImportConflict, nil
+	}
+
+	signLog.ID = 0
+	db.addSigningLog(signLog)
+	return datastore.ImportCreated, nil
+}
+
+// ListAllowedReplicationSigningLog returns the signing log entries after the ID that are visible to
+// the authorization, oldest first, without the entries that were replicated from other vaults
+func (db *DB) ListAllowedReplicationSigningLog(authorization datastore.User, afterID, limit int) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if limit <= 0 || limit > datastore.MaxReplicationBatch {
+		limit = datastore.MaxReplicationBatch
+	}
+
+	logs := []datastore.SigningLog{}
+	for _, l := range db.signingLogs {
+		if len(logs) == limit {
+			break
+		}
+		if l.ID <= afterID || (l.Signer != nil && l.Signer.Replica != nil) || !db.canRead(authorization, l.Make) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// TailAllowedSigningLog returns the signing log entries after the ID that are visible to the
+// authorization, oldest first. A negative ID returns the latest entries
+func (db *DB) TailAllowedSigningLog(authorization datastore.User, afterID, limit int) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if limit <= 0 || limit > datastore.MaxTailBatch {
+		limit = datastore.MaxTailBatch
+	}
+
+	logs := []datastore.SigningLog{}
+	for _, l := range db.signingLogs {
+		if l.ID <= afterID || !db.canRead(authorization, l.Make) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+
+	if afterID < 0 && len(logs) > limit {
+		return logs[len(logs)-limit:], nil
+	}
+	if len(logs) > limit {
+		return logs[:limit], nil
+	}
+	return logs, nil
+}
+
+// ListAllowedSigningLog returns the signing log entries visible to the authorization
+func (db *DB) ListAllowedSigningLog(authorization datastore.User) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.annotateSigningLogs(db.signingLogsWhere(func(l datastore.SigningLog) bool {
+		return db.canRead(authorization, l.Make)
+	})), nil
+}
+
+// ListAllowedSigningLogForAccount returns the signing log entries of the account, if it is visible to the authorization
+func (db *DB) ListAllowedSigningLogForAccount(authorization datastore.User, authorityID string) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.annotateSigningLogs(db.signingLogsWhere(func(l datastore.SigningLog) bool {
+		return l.Make == authorityID && db.canRead(authorization, l.Make)
+	})), nil
+}
+
+// SearchAllowedSigningLogForAccount returns the signing log entries of the account that have the detail
+// or origin field with the value, if the account is visible to the authorization
+func (db *DB) SearchAllowedSigningLogForAccount(authorization datastore.User, authorityID, field, value string) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.annotateSigningLogs(db.signingLogsWhere(func(l datastore.SigningLog) bool {
+		return l.Make == authorityID && db.canRead(authorization, l.Make) && signingLogValue(l, field) == value
+	})), nil
+}
+
+// signingLogValue returns the value of a detail field, or of a field of the origin of the request
+func signingLogValue(l datastore.SigningLog, field string) string {
+	if !datastore.IsOriginField(field) {
+		return l.Details[field]
+	}
+	if l.Origin == nil {
+		return ""
+	}
+
+	switch field {
+	case datastore.OriginClientIP:
+		return l.Origin.ClientIP
+	case datastore.OriginCountry:
+		return l.Origin.Country
+	default:
+		return l.Origin.TLSIdentity
+	}
+}
+
+// AllowedSigningLogFilterValues returns the makes and models in the signing log of the account
+func (db *DB) AllowedSigningLogFilterValues(authorization datastore.User, authorityID string) (datastore.SigningLogFilters, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	filters := datastore.SigningLogFilters{Makes: []string{}, Models: []string{}}
+	makes := map[string]bool{}
+	models := map[string]bool{}
+	for _, l := range db.signingLogs {
+		if l.Make != authorityID || !db.canRead(authorization, l.Make) {
+			continue
+		}
+		if !makes[l.Make] {
+			makes[l.Make] = true
+			filters.Makes = append(filters.Makes, l.Make)
+		}
+		if !models[l.Model] {
+			models[l.Model] = true
+			filters.Models = append(filters.Models, l.Model)
+		}
+	}
+	sort.Strings(filters.Makes)
+	sort.Strings(filters.Models)
+	return filters, nil
+}
+
+// CreateSigningLogCheckpoint records a checkpoint at the latest entry of the signing log
+func (db *DB) CreateSigningLogCheckpoint() (datastore.SigningLogCheckpoint, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(db.signingLogs) == 0 {
+		return datastore.SigningLogCheckpoint{}, errors.New("The signing log is empty")
+	}
+
+	last := db.signingLogs[len(db.signingLogs)-1]
+	checkpoint := datastore.SigningLogCheckpoint{
+		ID:      db.nextID(),
+		LogID:   last.ID,
+		Hash:    last.Hash,
+		Created: time.Now().UTC(),
+	}
+	db.checkpoints = append(db.checkpoints, checkpoint)
+	return checkpoint, nil
+}
+
+// VerifySigningLog counts the entries and checkpoints of the signing log. The in-memory
+// signing log cannot be tampered with, so there are no errors
+func (db *DB) VerifySigningLog() (datastore.SigningLogVerification, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return datastore.SigningLogVerification{
+		Rows:        len(db.signingLogs),
+		Checkpoints: len(db.checkpoints),
+		Errors:      []string{},
+	}, nil
+}
+
+// ResignSigningLogCheckpoints is a no-op for the in-memory signing log, as its checkpoints
+// are not signed
+func (db *DB) ResignSigningLogCheckpoints(newSecret string) (int, error) {
+	return 0, nil
+}
+
+// ListSignedDevices returns the devices signed for the brand, with the time they were first signed
+func (db *DB) ListSignedDevices(authorityID string) ([]datastore.SignedDevice, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	devices := []datastore.SignedDevice{}
+	seen := map[string]int{}
+	for _, l := range db.signingLogs {
+		if l.Make != authorityID {
+			continue
+		}
+
+		key := l.Model + "/" + l.SerialNumber
+		if i, ok := seen[key]; ok {
+			if l.Created.Before(devices[i].Signed) {
+				devices[i].Signed = l.Created
+			}
+			continue
+		}
+		seen[key] = len(devices)
+		devices = append(devices, datastore.SignedDevice{Model: l.Model, Serial: l.SerialNumber, Signed: l.Created})
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Model != devices[j].Model {
+			return devices[i].Model < devices[j].Model
+		}
+		return devices[i].Serial < devices[j].Serial
+	})
+	return devices, nil
+}
+
+// ListSerialSigningLog returns the signing log entries of the device, ordered by revision
+func (db *DB) ListSerialSigningLog(make, model, serialNumber string) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logs := db.signingLogsWhere(func(l datastore.SigningLog) bool {
+		return l.Make == make && l.Model == model && l.SerialNumber == serialNumber
+	})
+	sort.Slice(logs, func(i, j int) bool { return logs[i].Revision < logs[j].Revision })
+	return logs, nil
+}
+
+// FindSignedDevices returns the devices signed for the brand with the serial number, one for
+// each model, with the time they were first signed
+func (db *DB) FindSignedDevices(authorityID, serialNumber string) ([]datastore.SignedDevice, error) {
+	devices, err := db.ListSignedDevices(authorityID)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []datastore.SignedDevice{}
+	for _, d := range devices {
+		if d.Serial == serialNumber {
+			found = append(found, d)
+		}
+	}
+	return found, nil
+}
+
+// SyncSigningLog returns the signing log entries that have not been synced to the cloud
+func (db *DB) SyncSigningLog() ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logs := []datastore.SigningLog{}
+	for _, l := range db.signingLogs {
+		if l.Synced == 0 {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+// SyncUpdateSigningLog marks the signing log entry as synced to the cloud
+func (db *DB) SyncUpdateSigningLog(id int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, l := range db.signingLogs {
+		if l.ID == id {
+			db.signingLogs[i].Synced = 1
+			return nil
+		}
+	}
+	return nil
+}
+
+func (db *DB) addSigningLog(signLog datastore.SigningLog) datastore.SigningLog {
+	if signLog.ID == 0 {
+		signLog.ID = db.nextID()
+	}
+	db.signingLogs = append(db.signingLogs, signLog)
+	return signLog
+}
+
+// signingLogsWhere returns the signing log entries that match, the latest first
+func (db *DB) signingLogsWhere(match func(l datastore.SigningLog) bool) []datastore.SigningLog {
+	logs := []datastore.SigningLog{}
+	for i := len(db.signingLogs) - 1; i >= 0; i-- {
+		if match(db.signingLogs[i]) {
+			logs = append(logs, db.signingLogs[i])
+		}
+	}
+	return logs
+}
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// QueueSigningLogSink queues a signing log entry that could not be written to the sink
+func (db *DB) QueueSigningLogSink(signLog datastore.SigningLog, reason string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.sinkQueue = append(db.sinkQueue, datastore.SigningLogSinkEntry{ID: db.nextID(), SigningLog: signLog, Reason: reason, Created: time.Now().UTC()})
+	return nil
+}
+
+// ListSigningLogSinkQueue returns the queued signing log entries, oldest first
+func (db *DB) ListSigningLogSinkQueue() ([]datastore.SigningLogSinkEntry, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return append([]datastore.SigningLogSinkEntry{}, db.sinkQueue...), nil
+}
+
+// DeleteSigningLogSinkQueue removes an entry from the queue
+func (db *DB) DeleteSigningLogSinkQueue(id int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, e := range db.sinkQueue {
+		if e.ID == id {
+			db.sinkQueue = append(db.sinkQueue[:i], db.sinkQueue[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// ListSigningLogForKeypair returns the signing log entries of the devices signed by the signing-key
+func (db *DB) ListSigningLogForKeypair(keyID string) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logs := []datastore.SigningLog{}
+	for _, l := range db.signingLogs {
+		if datastore.SignedByKeypair(l, keyID) {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+// ListSigningLogForBrand returns all the signing log entries of a brand
+func (db *DB) ListSigningLogForBrand(authorityID string) ([]datastore.SigningLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logs := []datastore.SigningLog{}
+	for _, l := range db.signingLogs {
+		if l.Make == authorityID {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
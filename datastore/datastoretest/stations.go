@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// ValidateStation checks that the station is registered for the model. Models
+// without stations accept any station
+func (db *DB) ValidateStation(modelID int, code string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	registered := false
+	for _, s := range db.stations {
+		if s.ModelID != modelID {
+			continue
+		}
+		registered = true
+		if len(code) > 0 && s.Code == code {
+			return nil
+		}
+	}
+
+	switch {
+	case !registered:
+		return nil
+	case len(code) == 0:
+		return errors.New("The station must be provided for this model")
+	default:
+		return errors.New("The station is not registered for this model")
+	}
+}
+
+// ListAllowedStations returns the stations of the model, if it is visible to the authorization
+func (db *DB) ListAllowedStations(modelID int, authorization datastore.User) ([]datastore.Station, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	stations := []datastore.Station{}
+	if !db.canWriteModel(authorization, modelID) {
+		return stations, nil
+	}
+	for _, s := range db.stations {
+		if s.ModelID == modelID {
+			stations = append(stations, s)
+		}
+	}
+	return stations, nil
+}
+
+// CreateAllowedStation registers a station for the model, if the authorization is allowed to do it
+func (db *DB) CreateAllowedStation(station datastore.Station, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if station.ModelID <= 0 {
+		return errors.New("Model must be selected")
+	}
+	if len(station.Code) == 0 {
+		return errors.New("Station must not be empty")
+	}
+	if !db.canWriteModel(authorization, station.ModelID) {
+		return errors.New("You do not have permissions to this model")
+	}
+	for _, s := range db.stations {
+		if s.ModelID == station.ModelID && s.Code == station.Code {
+			return errors.New("The station is already registered for this model")
+		}
+	}
+
+	station.ID = db.nextID()
+	db.stations = append(db.stations, station)
+	return nil
+}
+
+// DeleteAllowedStation removes the station, if the authorization is allowed to do it
+func (db *DB) DeleteAllowedStation(stationID int, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, s := range db.stations {
+		if s.ID == stationID && db.canWriteModel(authorization, s.ModelID) {
+			db.stations = append(db.stations[:i], db.stations[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// canWriteModel checks if the authorization may change the records of the model with the ID
+func (db *DB) canWriteModel(authorization datastore.User, modelID int) bool {
+	m, err := db.model(modelID)
+	if err != nil {
+		return false
+	}
+	return db.canWrite(authorization, m.BrandID)
+}
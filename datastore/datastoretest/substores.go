@@ -0,0 +1,270 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CreateAllowedSubstore adds a sub-store model, if the authorization is allowed to do it
+func (db *DB) CreateAllowedSubstore(store datastore.Substore, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	store = db.canonicalSubstore(store)
+	if err := validateSubstore(store); err != nil {
+		return err
+	}
+	if err := db.validatePivotTarget(store, authorization); err != nil {
+		return err
+	}
+	if !db.canWriteAccount(authorization, store.AccountID) {
+		return errors.New("You do not have permissions to this account")
+	}
+
+	store.ID = db.nextID()
+	store.FromModel = datastore.Model{}
+	db.substores = append(db.substores, store)
+	return nil
+}
+
+// CreateAllowedSubstores adds the sub-store models of a bulk import, if the authorization
+// is allowed to do it
+func (db *DB) CreateAllowedSubstores(stores []datastore.Substore, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, store := range stores {
+		store = db.canonicalSubstore(store)
+		stores[i] = store
+		if err := validateSubstore(store); err != nil {
+			return err
+		}
+		if err := db.validatePivotTarget(store, authorization); err != nil {
+			return err
+		}
+		if !db.canWriteAccount(authorization, store.AccountID) {
+			return errors.New("You do not have permissions to this account")
+		}
+	}
+
+	for _, store := range stores {
+		store.ID = db.nextID()
+		store.FromModel = datastore.Model{}
+		db.substores = append(db.substores, store)
+	}
+	return nil
+}
+
+// ListSubstores returns the sub-store models of the account, if it is visible to the authorization
+func (db *DB) ListSubstores(accountID int, authorization datastore.User) ([]datastore.Substore, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	stores := []datastore.Substore{}
+	if !db.canWriteAccount(authorization, accountID) {
+		return stores, nil
+	}
+	for _, s := range db.substores {
+		if s.AccountID == accountID {
+			stores = append(stores, db.withFromModel(s))
+		}
+	}
+	return stores, nil
+}
+
+// UpdateAllowedSubstore updates the sub-store model, if the authorization is allowed to change it
+func (db *DB) UpdateAllowedSubstore(store datastore.Substore, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	store = db.canonicalSubstore(store)
+	if err := validateSubstore(store); err != nil {
+		return err
+	}
+	if err := db.validatePivotTarget(store, authorization); err != nil {
+		return err
+	}
+
+	for i, s := range db.substores {
+		if s.ID == store.ID && db.canWriteAccount(authorization, s.AccountID) {
+			store.AccountID = s.AccountID
+			store.FromModel = datastore.Model{}
+			db.substores[i] = store
+			return nil
+		}
+	}
+	return nil
+}
+
+// DeleteAllowedSubstore removes the sub-store model, if the authorization is allowed to do it
+func (db *DB) DeleteAllowedSubstore(storeID int, authorization datastore.User) (string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, s := range db.substores {
+		if s.ID == storeID && db.canWriteAccount(authorization, s.AccountID) {
+			db.substores = append(db.substores[:i], db.substores[i+1:]...)
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// GetSubstore returns the sub-store model that the serial number of a model pivots to, matching
+// the serial ranges and patterns when there is no exact serial number
+func (db *DB) GetSubstore(fromModelID int, serialNumber string) (datastore.Substore, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	patterns := []datastore.Substore{}
+	for _, s := range db.substores {
+		if s.FromModelID != fromModelID {
+			continue
+		}
+		if datastore.IsSerialPattern(s.SerialNumber) {
+			patterns = append(patterns, s)
+		} else if s.SerialNumber == serialNumber {
+			return db.withFromModel(s), nil
+		}
+	}
+
+	if s, ok := datastore.MatchSubstore(patterns, serialNumber); ok {
+		return db.withFromModel(s), nil
+	}
+	return datastore.Substore{}, errNotFound
+}
+
+// GetSubstoreModel returns the sub-store model of a pivoted device
+func (db *DB) GetSubstoreModel(brand, model, serialNumber string) (datastore.Substore, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	brand = datastore.CanonicalBrandID(brand)
+	model = datastore.CanonicalModelName(db.ModelNameCase, brand, model)
+	patterns := []datastore.Substore{}
+	for _, s := range db.substores {
+		if s.ModelName != model {
+			continue
+		}
+		if m, err := db.model(s.FromModelID); err != nil || (m.BrandID != brand && db.accountBrand(s.AccountID) != brand) {
+			continue
+		}
+		if datastore.IsSerialPattern(s.SerialNumber) {
+			patterns = append(patterns, s)
+		} else if s.SerialNumber == serialNumber {
+			return db.withFromModel(s), nil
+		}
+	}
+
+	if s, ok := datastore.MatchSubstore(patterns, serialNumber); ok {
+		return db.withFromModel(s), nil
+	}
+	return datastore.Substore{}, errNotFound
+}
+
+// PivotTarget returns the model of the account brand that the sub-store pivots the devices to,
+// when the account is another brand than the model they are pivoted from
+func (db *DB) PivotTarget(store datastore.Substore) (datastore.Model, bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	brandID := db.accountBrand(store.AccountID)
+	if len(brandID) == 0 {
+		return datastore.Model{}, false, errNotFound
+	}
+	if brandID == store.FromModel.BrandID {
+		return datastore.Model{}, false, nil
+	}
+
+	for _, m := range db.models {
+		if m.BrandID == brandID && m.Name == datastore.CanonicalModelName(db.ModelNameCase, brandID, store.ModelName) {
+			return db.withKeypairs(m), true, nil
+		}
+	}
+	return datastore.Model{}, true, datastore.ErrPivotTargetNotFound
+}
+
+// validatePivotTarget checks that the brand of the account has the model that a sub-store of
+// another brand pivots the devices to, and that the authorization may change the model they
+// are pivoted from
+func (db *DB) validatePivotTarget(store datastore.Substore, authorization datastore.User) error {
+	fromModel, err := db.model(store.FromModelID)
+	if err != nil {
+		return errors.New("Cannot find the model that the sub-store is pivoted from")
+	}
+	brandID := db.accountBrand(store.AccountID)
+	if len(brandID) == 0 || brandID == fromModel.BrandID {
+		return nil
+	}
+	if !db.modelExists(brandID, store.ModelName) {
+		return fmt.Errorf("The brand of the account must have the model '%s' to pivot the devices to another brand", store.ModelName)
+	}
+	if !db.canWrite(authorization, fromModel.BrandID) {
+		return errors.New("You do not have permissions to the model that the sub-store is pivoted from")
+	}
+	return nil
+}
+
+// accountBrand returns the brand of the account with the ID
+func (db *DB) accountBrand(accountID int) string {
+	for _, a := range db.accounts {
+		if a.ID == accountID {
+			return a.AuthorityID
+		}
+	}
+	return ""
+}
+
+func validateSubstore(store datastore.Substore) error {
+	if store.FromModelID <= 0 {
+		return errors.New("From Model must be selected")
+	}
+	if len(store.Store) == 0 || len(store.SerialNumber) == 0 || len(store.ModelName) == 0 {
+		return errors.New("The sub-store name, serial number and model name must be entered")
+	}
+	return nil
+}
+
+// canonicalSubstore returns the sub-store with the canonical model name for the brand of its account
+func (db *DB) canonicalSubstore(store datastore.Substore) datastore.Substore {
+	store.ModelName = datastore.CanonicalModelName(db.ModelNameCase, db.accountBrand(store.AccountID), store.ModelName)
+	return store
+}
+
+// canWriteAccount checks if the authorization may change the records of the account with the ID
+func (db *DB) canWriteAccount(authorization datastore.User, accountID int) bool {
+	for _, a := range db.accounts {
+		if a.ID == accountID {
+			return db.canWrite(authorization, a.AuthorityID)
+		}
+	}
+	return false
+}
+
+func (db *DB) withFromModel(store datastore.Substore) datastore.Substore {
+	if m, err := db.model(store.FromModelID); err == nil {
+		store.FromModel = db.withKeypairs(m)
+	}
+	return store
+}
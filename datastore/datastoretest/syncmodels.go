@@ -0,0 +1,261 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// ListSyncModelAssignments returns the models assigned to the sync user
+func (db *DB) ListSyncModelAssignments(userID int) ([]datastore.SyncModelAssignment, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	assignments := []datastore.SyncModelAssignment{}
+	for _, a := range db.syncModels {
+		if a.UserID != userID {
+			continue
+		}
+		if m, err := db.model(a.ModelID); err == nil {
+			a.BrandID = m.BrandID
+			a.Name = m.Name
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, nil
+}
+
+// CreateSyncModelAssignment assigns a model to a sync user, which makes the user model-scoped
+func (db *DB) CreateSyncModelAssignment(assignment datastore.SyncModelAssignment) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	user, err := db.user(assignment.UserID)
+	if err != nil {
+		return errors.New("Cannot find the user")
+	}
+	if user.Role != datastore.SyncUser {
+		return errors.New("Models can only be assigned to sync users")
+	}
+	if _, err = db.model(assignment.ModelID); err != nil {
+		return errors.New("Cannot find the model")
+	}
+	if assignment.ValidFrom != nil && assignment.ValidUntil != nil && !assignment.ValidUntil.After(*assignment.ValidFrom) {
+		return errors.New("The end of the authorization window must be after its start")
+	}
+	if assignment.MaxUnits < 0 {
+		return errors.New("The maximum number of units cannot be negative")
+	}
+	for _, a := range db.syncModels {
+		if a.UserID == assignment.UserID && a.ModelID == assignment.ModelID {
+			return errors.New("The model is already assigned to the sync user")
+		}
+	}
+
+	assignment.ID = db.nextID()
+	db.syncModels = append(db.syncModels, assignment)
+	db.setSyncModelScope(assignment.UserID, true)
+	return nil
+}
+
+// DeleteSyncModelAssignment removes a model from a sync user. The user stays model-scoped
+func (db *DB) DeleteSyncModelAssignment(assignmentID int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, a := range db.syncModels {
+		if a.ID == assignmentID {
+			db.syncModels = append(db.syncModels[:i], db.syncModels[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// SyncModelScoped checks if the sync user only receives the signing-keys of its assigned models
+func (db *DB) SyncModelScoped(userID int) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.syncScoped[userID], nil
+}
+
+// SetSyncModelScope sets if the sync user only receives the signing-keys of its assigned models
+func (db *DB) SetSyncModelScope(userID int, scoped bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if scoped {
+		user, err := db.user(userID)
+		if err != nil {
+			return errors.New("Cannot find the user")
+		}
+		if user.Role != datastore.SyncUser {
+			return errors.New("Models can only be assigned to sync users")
+		}
+	}
+	db.setSyncModelScope(userID, scoped)
+	return nil
+}
+
+func (db *DB) setSyncModelScope(userID int, scoped bool) {
+	if db.syncScoped == nil {
+		db.syncScoped = map[int]bool{}
+	}
+	db.syncScoped[userID] = scoped
+}
+
+// ListAllowedSyncKeypairs returns the signing-keys to sync to the factory. When the user is
+// model-scoped, only the keys of its models with an open window are synced
+func (db *DB) ListAllowedSyncKeypairs(authorization datastore.User) ([]datastore.Keypair, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	keypairIDs := map[int]bool{}
+	scoped := false
+	for id, s := range db.syncScoped {
+		if u, err := db.user(id); err == nil && u.Username == authorization.Username {
+			scoped = s
+		}
+	}
+	now := time.Now()
+	for _, a := range db.syncModels {
+		u, err := db.user(a.UserID)
+		if err != nil || u.Username != authorization.Username {
+			continue
+		}
+		if !a.Open(now) {
+			continue
+		}
+		if m, err := db.model(a.ModelID); err == nil {
+			keypairIDs[m.KeypairID] = true
+			keypairIDs[m.KeypairIDUser] = true
+		}
+		if ma, err := db.modelAssert(a.ModelID); err == nil {
+			keypairIDs[ma.KeypairID] = true
+		}
+	}
+
+	keypairs := []datastore.Keypair{}
+	for _, k := range db.keypairs {
+		if !db.canRead(authorization, k.AuthorityID) || authorization.Role == datastore.Standard {
+			continue
+		}
+		if !scoped || keypairIDs[k.ID] {
+			keypairs = append(keypairs, k)
+		}
+	}
+
+	sort.Slice(keypairs, func(i, j int) bool {
+		if keypairs[i].AuthorityID != keypairs[j].AuthorityID {
+			return keypairs[i].AuthorityID < keypairs[j].AuthorityID
+		}
+		return keypairs[i].KeyID < keypairs[j].KeyID
+	})
+	return keypairs, nil
+}
+
+// SyncSigningAuthorizations replaces the factory's signing authorizations. When the sync user is
+// model-scoped, the signing-keys that are not used by an open authorization are revoked
+func (db *DB) SyncSigningAuthorizations(scoped bool, assignments []datastore.SyncModelAssignment) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.authorizations = append([]datastore.SyncModelAssignment{}, assignments...)
+	db.authScoped = scoped
+	if !scoped {
+		return nil
+	}
+
+	keypairIDs := map[int]bool{}
+	now := time.Now()
+	for _, a := range db.authorizations {
+		if !a.Open(now) {
+			continue
+		}
+		for _, m := range db.models {
+			if m.BrandID == a.BrandID && m.Name == a.Name {
+				keypairIDs[m.KeypairID] = true
+				keypairIDs[m.KeypairIDUser] = true
+				if ma, err := db.modelAssert(m.ID); err == nil {
+					keypairIDs[ma.KeypairID] = true
+				}
+			}
+		}
+	}
+
+	for i, k := range db.keypairs {
+		if !keypairIDs[k.ID] {
+			db.keypairs[i].Active = false
+			db.keypairs[i].SealedKey = ""
+		}
+	}
+	return nil
+}
+
+// CheckSigningAuthorization checks that the factory is authorized to sign a device for the model
+func (db *DB) CheckSigningAuthorization(brandID, modelName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	a, err := db.signingAuthorization(brandID, modelName)
+	if err != nil || a == nil {
+		return err
+	}
+	if !a.Open(time.Now()) {
+		return errors.New("The signing authorization for this model has expired or is not yet valid")
+	}
+	return db.checkSignedUnits(*a)
+}
+
+// signingAuthorization returns the authorization of the model, or nil when the factory is not model-scoped
+func (db *DB) signingAuthorization(brandID, modelName string) (*datastore.SyncModelAssignment, error) {
+	if !db.authScoped {
+		return nil, nil
+	}
+	for _, a := range db.authorizations {
+		if a.BrandID == brandID && a.Name == modelName {
+			return &a, nil
+		}
+	}
+	return nil, errors.New("The factory is not authorized to sign for this model")
+}
+
+// checkSignedUnits counts the devices signed since the start of the authorization window
+func (db *DB) checkSignedUnits(a datastore.SyncModelAssignment) error {
+	if a.MaxUnits == 0 {
+		return nil
+	}
+
+	units := 0
+	for _, l := range db.signingLogs {
+		if l.Make == a.BrandID && l.Model == a.Name && l.Revision == 1 && (a.ValidFrom == nil || !l.Created.Before(*a.ValidFrom)) {
+			units++
+		}
+	}
+	if units >= a.MaxUnits {
+		return datastore.ErrMaxUnitsReached{MaxUnits: a.MaxUnits}
+	}
+	return nil
+}
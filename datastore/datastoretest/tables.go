@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+// The in-memory datastore has no tables, so the schema updates do nothing
+
+// CreateModelTable is a no-op for the in-memory datastore
+func (db *DB) CreateModelTable() error { return nil }
+
+// AlterModelTable is a no-op for the in-memory datastore
+func (db *DB) AlterModelTable() error { return nil }
+
+// CreateModelAssertTable is a no-op for the in-memory datastore
+func (db *DB) CreateModelAssertTable() error { return nil }
+
+// AlterModelAssertTable is a no-op for the in-memory datastore
+func (db *DB) AlterModelAssertTable() error { return nil }
+
+// CreateKeypairTable is a no-op for the in-memory datastore
+func (db *DB) CreateKeypairTable() error { return nil }
+
+// AlterKeypairTable is a no-op for the in-memory datastore
+func (db *DB) AlterKeypairTable() error { return nil }
+
+// CreateKeypairStatusTable is a no-op for the in-memory datastore
+func (db *DB) CreateKeypairStatusTable() error { return nil }
+
+// AlterKeypairStatusTable is a no-op for the in-memory datastore
+func (db *DB) AlterKeypairStatusTable() error { return nil }
+
+// CreateSettingsTable is a no-op for the in-memory datastore
+func (db *DB) CreateSettingsTable() error { return nil }
+
+// CreateSigningLogTable is a no-op for the in-memory datastore
+func (db *DB) CreateSigningLogTable() error { return nil }
+
+// CreateSigningLogSearchIndexes is a no-op for the in-memory datastore
+func (db *DB) CreateSigningLogSearchIndexes() error { return nil }
+
+// CreateSigningLogCheckpointTable is a no-op for the in-memory datastore
+func (db *DB) CreateSigningLogCheckpointTable() error { return nil }
+
+// CreateDeviceNonceTable is a no-op for the in-memory datastore
+func (db *DB) CreateDeviceNonceTable() error { return nil }
+
+// CreateOpenidNonceTable is a no-op for the in-memory datastore
+func (db *DB) CreateOpenidNonceTable() error { return nil }
+
+// CreateAccountTable is a no-op for the in-memory datastore
+func (db *DB) CreateAccountTable() error { return nil }
+
+// AlterAccountTable is a no-op for the in-memory datastore
+func (db *DB) AlterAccountTable() error { return nil }
+
+// CreateAccountKeyTable is a no-op for the in-memory datastore
+func (db *DB) CreateAccountKeyTable() error { return nil }
+
+// CreateUserTable is a no-op for the in-memory datastore
+func (db *DB) CreateUserTable() error { return nil }
+
+// CreateAccountUserLinkTable is a no-op for the in-memory datastore
+func (db *DB) CreateAccountUserLinkTable() error { return nil }
+
+// AlterUserTable is a no-op for the in-memory datastore
+func (db *DB) AlterUserTable() error { return nil }
+
+// CreateSubstoreTable is a no-op for the in-memory datastore
+func (db *DB) CreateSubstoreTable() error { return nil }
+
+// AlterSubstoreTable is a no-op for the in-memory datastore
+func (db *DB) AlterSubstoreTable() error { return nil }
+
+// CreateTestLogTable is a no-op for the in-memory datastore
+func (db *DB) CreateTestLogTable() error { return nil }
+
+// CreateDeviceManifestTable is a no-op for the in-memory datastore
+func (db *DB) CreateDeviceManifestTable() error { return nil }
+
+// CreateConfigSettingTables is a no-op for the in-memory datastore
+func (db *DB) CreateConfigSettingTables() error { return nil }
+
+// CreateStationTable is a no-op for the in-memory datastore
+func (db *DB) CreateStationTable() error { return nil }
+
+// CreateModelFallbackKeyTable is a no-op for the in-memory datastore
+func (db *DB) CreateModelFallbackKeyTable() error { return nil }
+
+// CreateModelCanaryTables is a no-op for the in-memory datastore
+func (db *DB) CreateModelCanaryTables() error { return nil }
+
+// CreateSyncModelAssignmentTable is a no-op for the in-memory datastore
+func (db *DB) CreateSyncModelAssignmentTable() error { return nil }
+
+// CreateSigningAuthorizationTable is a no-op for the in-memory datastore
+func (db *DB) CreateSigningAuthorizationTable() error { return nil }
+
+// CreateInstanceTable is a no-op for the in-memory datastore
+func (db *DB) CreateInstanceTable() error { return nil }
+
+// CreateFactoryCheckInTable is a no-op for the in-memory datastore
+func (db *DB) CreateFactoryCheckInTable() error { return nil }
+
+// CreateBillingTables is a no-op for the in-memory datastore
+func (db *DB) CreateBillingTables() error { return nil }
+
+// CreateOnboardingTable is a no-op for the in-memory datastore
+func (db *DB) CreateOnboardingTable() error { return nil }
+
+// CreateApprovalTable is a no-op for the in-memory datastore
+func (db *DB) CreateApprovalTable() error { return nil }
+
+// CreateAuditLogTable is a no-op for the in-memory datastore
+func (db *DB) CreateAuditLogTable() error { return nil }
+
+// CreateSigningLogSinkQueueTable is a no-op for the in-memory datastore
+func (db *DB) CreateSigningLogSinkQueueTable() error { return nil }
+
+// CreateSigningLogAnnotationTable is a no-op for the in-memory datastore
+func (db *DB) CreateSigningLogAnnotationTable() error { return nil }
+
+// CreateShareTokenTable is a no-op for the in-memory datastore
+func (db *DB) CreateShareTokenTable() error { return nil }
+
+// CreateDeviceStateTable is a no-op for the in-memory datastore
+func (db *DB) CreateDeviceStateTable() error { return nil }
+
+// CreateDeviceQuarantineTable is a no-op for the in-memory datastore
+func (db *DB) CreateDeviceQuarantineTable() error { return nil }
+
+// CreateDeviceCertificateTable is a no-op for the in-memory datastore
+func (db *DB) CreateDeviceCertificateTable() error { return nil }
+
+// CreateModelKeypairHistoryTable is a no-op for the in-memory datastore
+func (db *DB) CreateModelKeypairHistoryTable() error { return nil }
+
+// CreateKeypairUsageTable is a no-op for the in-memory datastore
+func (db *DB) CreateKeypairUsageTable() error { return nil }
+
+// CreateKeypairModelTable is a no-op for the in-memory datastore
+func (db *DB) CreateKeypairModelTable() error { return nil }
+
+// CreateKeypairCompromiseTable is a no-op for the in-memory datastore
+func (db *DB) CreateKeypairCompromiseTable() error { return nil }
+
+// CreateKeyDelegationTables is a no-op for the in-memory datastore
+func (db *DB) CreateKeyDelegationTables() error { return nil }
+
+// CreateSerialReplayTable is a no-op for the in-memory datastore
+func (db *DB) CreateSerialReplayTable() error { return nil }
@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CreateTestLog adds a test log
+func (db *DB) CreateTestLog(testLog datastore.TestLog) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(testLog.Brand) == 0 || len(testLog.Model) == 0 || len(testLog.Filename) == 0 || len(testLog.Data) == 0 {
+		return errors.New("The brand, model, filename and file (base64-encoded) must be supplied")
+	}
+
+	testLog.ID = db.nextID()
+	testLog.Created = time.Now().UTC()
+	db.testLogs = append(db.testLogs, testLog)
+	return nil
+}
+
+// ListAllowedTestLog returns the test logs visible to the authorization
+func (db *DB) ListAllowedTestLog(authorization datastore.User) ([]datastore.TestLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logs := []datastore.TestLog{}
+	if authorization.Role == datastore.Standard {
+		return logs, nil
+	}
+	for _, l := range db.testLogs {
+		if db.canRead(authorization, l.Brand) {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+// UpdateAllowedTestLog marks the test log as synced, if the authorization is allowed to do it
+func (db *DB) UpdateAllowedTestLog(ID int, authorization datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	switch authorization.Role {
+	case datastore.Superuser, datastore.SyncUser, datastore.Admin:
+	default:
+		return errors.New("Not authorized to update a testlog")
+	}
+
+	for i, l := range db.testLogs {
+		if l.ID == ID && db.canRead(authorization, l.Brand) {
+			db.testLogs[i].Synced = time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+// SyncListTestLogs returns the test logs to sync to the cloud
+func (db *DB) SyncListTestLogs() ([]datastore.TestLog, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return append([]datastore.TestLog{}, db.testLogs...), nil
+}
+
+// SyncDeleteTestLog removes a test log that has been synced to the cloud
+func (db *DB) SyncDeleteTestLog(ID int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, l := range db.testLogs {
+		if l.ID == ID {
+			db.testLogs = append(db.testLogs[:i], db.testLogs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// CreateUser adds a new user and links it to its accounts
+func (db *DB) CreateUser(user datastore.User) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(user.Username) == 0 {
+		return 0, errors.New("Username must not be empty")
+	}
+	if _, err := db.userByUsername(user.Username); err == nil {
+		return 0, errors.New("The username already exists")
+	}
+	if len(user.APIKey) == 0 {
+		apiKey, err := random.GenerateRandomString(40)
+		if err != nil {
+			return 0, errors.New("Error in generating a valid API key")
+		}
+		user.APIKey = apiKey
+	}
+
+	user.ID = db.nextID()
+	db.users = append(db.users, user)
+	return user.ID, nil
+}
+
+// ListUsers returns all the users
+func (db *DB) ListUsers() ([]datastore.User, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return append([]datastore.User{}, db.users...), nil
+}
+
+// FindUsers returns the users with a username or name that contains the query
+func (db *DB) FindUsers(query string) ([]datastore.User, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	users := []datastore.User{}
+	for _, u := range db.users {
+		if strings.Contains(u.Username, query) || strings.Contains(u.Name, query) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// GetUser returns the user by ID
+func (db *DB) GetUser(userID int) (datastore.User, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.user(userID)
+}
+
+// GetUserByUsername returns the user by username
+func (db *DB) GetUserByUsername(username string) (datastore.User, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.userByUsername(username)
+}
+
+// GetUserByAPIKey returns the user with the username and API key
+func (db *DB) GetUserByAPIKey(apiKey, username string) (datastore.User, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	user, err := db.userByUsername(username)
+	if err != nil || user.APIKey != apiKey {
+		return datastore.User{}, errNotFound
+	}
+	return user, nil
+}
+
+// UpdateUser updates the user and its accounts
+func (db *DB) UpdateUser(user datastore.User) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, u := range db.users {
+		if u.ID == user.ID {
+			if len(user.APIKey) == 0 {
+				user.APIKey = u.APIKey
+			}
+			db.users[i] = user
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// DeleteUser removes the user
+func (db *DB) DeleteUser(userID int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for i, u := range db.users {
+		if u.ID == userID {
+			db.users = append(db.users[:i], db.users[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// CheckUserInAccount checks if the user is linked to the account
+func (db *DB) CheckUserInAccount(username, authorityID string) bool {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.userInAccount(username, authorityID)
+}
+
+// ListUserAccounts returns the accounts linked to the user
+func (db *DB) ListUserAccounts(username string) ([]datastore.Account, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	accounts := []datastore.Account{}
+	for _, a := range db.accounts {
+		if db.userInAccount(username, a.AuthorityID) {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+// ListNotUserAccounts returns the accounts that are not linked to the user
+func (db *DB) ListNotUserAccounts(username string) ([]datastore.Account, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	accounts := []datastore.Account{}
+	for _, a := range db.accounts {
+		if !db.userInAccount(username, a.AuthorityID) {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+// ListAccountUsers returns the users linked to the account
+func (db *DB) ListAccountUsers(authorityID string) ([]datastore.User, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	users := []datastore.User{}
+	for _, u := range db.users {
+		if db.userInAccount(u.Username, authorityID) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (db *DB) userByUsername(username string) (datastore.User, error) {
+	for _, u := range db.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return datastore.User{}, errNotFound
+}
+
+func (db *DB) user(userID int) (datastore.User, error) {
+	for _, u := range db.users {
+		if u.ID == userID {
+			return u, nil
+		}
+	}
+	return datastore.User{}, errNotFound
+}
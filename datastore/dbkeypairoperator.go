@@ -157,6 +157,10 @@ var ReEncryptKeypair = func(keypair Keypair, newSecret string) (string, string,
 }
 
 func decryptKeypair(authorityID, keyID, base64SealedSigningKey string) ([]byte, error) {
+	return decryptKeypairWithSecret(authorityID, keyID, base64SealedSigningKey, Environ.Config.KeyStoreSecret)
+}
+
+func decryptKeypairWithSecret(authorityID, keyID, base64SealedSigningKey, keystoreSecret string) ([]byte, error) {
 	// Decode and decrypt the auth-key
 	authKeySetting, err := Environ.DB.GetSetting(crypt.GenerateAuthKey(authorityID, keyID))
 	if err != nil {
@@ -172,7 +176,7 @@ func decryptKeypair(authorityID, keyID, base64SealedSigningKey string) ([]byte,
 	}
 
 	// Decrypt the decoded auth-key
-	authKey, err := crypt.DecryptKey(encryptedAuthKey, Environ.Config.KeyStoreSecret)
+	authKey, err := crypt.DecryptKey(encryptedAuthKey, keystoreSecret)
 	if err != nil {
 		log.Println("Could not decrypt the auth-key for the signing-key")
 		return nil, err
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+)
+
+// Settings that hold the device CA: its sealed key and its self-signed certificate
+const (
+	deviceCAKeySettingCode         = "device-ca-key"
+	deviceCACertificateSettingCode = "device-ca-certificate"
+)
+
+const deviceCAKeyBits = 3072
+
+// deviceCAValidity is the validity of the self-signed certificate of the device CA
+const deviceCAValidity = 20 * 365 * 24 * time.Hour
+
+// deviceCAName is the common name of the device CA
+const deviceCAName = "Serial Vault Device CA"
+
+// deviceCAMutex serializes the generation of the device CA
+var deviceCAMutex sync.Mutex
+
+// DeviceCA fetches the certificate authority that issues the device certificates, generating and
+// storing it on first use. The key is sealed with the keystore secret, as the reporting key
+func DeviceCA(db Datastore, settings config.Settings) (*x509.Certificate, *rsa.PrivateKey, error) {
+	deviceCAMutex.Lock()
+	defer deviceCAMutex.Unlock()
+
+	setting, err := db.GetSetting(deviceCAKeySettingCode)
+	if err == nil {
+		return unsealDeviceCA(db, settings, setting.Data)
+	}
+	if err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, deviceCAKeyBits)
+	if err != nil {
+		log.Printf("Error generating the device CA key: %v\n", err)
+		return nil, nil, err
+	}
+
+	serial, err := NewCertificateSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: deviceCAName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(deviceCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Printf("Error creating the device CA certificate: %v\n", err)
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sealed, err := crypt.EncryptKey(string(x509.MarshalPKCS1PrivateKey(key)), settings.KeyStoreSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The certificate is stored first, so a stored key always has its certificate
+	err = db.PutSetting(Setting{Code: deviceCACertificateSettingCode, Data: string(EncodeCertificate(der))})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = db.PutSetting(Setting{Code: deviceCAKeySettingCode, Data: base64.StdEncoding.EncodeToString(sealed)})
+	return certificate, key, err
+}
+
+// NewCertificateSerial generates a random 128-bit serial number for a certificate
+func NewCertificateSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// EncodeCertificate returns the PEM encoding of a DER encoded certificate
+func EncodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func unsealDeviceCA(db Datastore, settings config.Settings, data string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	// The device CA key is sealed in the same way as the reporting key
+	key, err := unsealReportKeyWithSecret(data, settings.KeyStoreSecret)
+	if err != nil {
+		return nil, nil, errors.New("The stored device CA key is invalid")
+	}
+
+	setting, err := db.GetSetting(deviceCACertificateSettingCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode([]byte(setting.Data))
+	if block == nil {
+		return nil, nil, errors.New("The stored device CA certificate is invalid")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.New("The stored device CA certificate is invalid")
+	}
+	return certificate, key, nil
+}
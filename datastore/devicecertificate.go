@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+const createDeviceCertificateTableSQL = `
+	CREATE TABLE IF NOT EXISTS devicecertificate (
+		id                 serial primary key not null,
+		certificate_serial varchar(64) not null unique,
+		brand_id           varchar(200) not null,
+		model              varchar(200) not null,
+		serial_number      varchar(200) not null,
+		fingerprint        varchar(200) not null,
+		certificate        text not null,
+		not_after          timestamp not null,
+		revoked            boolean default false,
+		revoked_at         timestamp default current_timestamp,
+		created            timestamp default current_timestamp
+	)
+`
+
+const createDeviceCertificateSerialIndexSQL = "CREATE INDEX IF NOT EXISTS devicecertificate_serial_idx ON devicecertificate (brand_id, model, serial_number)"
+
+const maxIDDeviceCertificateSQLite = "SELECT COALESCE(MAX(id),0)+1 FROM devicecertificate"
+const createDeviceCertificateSQLite = "INSERT INTO devicecertificate (id,certificate_serial,brand_id,model,serial_number,fingerprint,certificate,not_after) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)"
+const createDeviceCertificateSQL = "INSERT INTO devicecertificate (certificate_serial,brand_id,model,serial_number,fingerprint,certificate,not_after) VALUES ($1,$2,$3,$4,$5,$6,$7)"
+
+const listDeviceCertificateSQL = "SELECT id, certificate_serial, brand_id, model, serial_number, fingerprint, certificate, not_after, revoked, revoked_at, created FROM devicecertificate"
+const getDeviceCertificateSQL = listDeviceCertificateSQL + " WHERE certificate_serial=$1"
+const getLatestDeviceCertificateSQL = listDeviceCertificateSQL + " WHERE brand_id=$1 AND model=$2 AND serial_number=$3 ORDER BY id DESC LIMIT 1"
+const listRevokedDeviceCertificatesSQL = listDeviceCertificateSQL + " WHERE revoked=$1 ORDER BY id"
+
+// The revocation time is kept when the certificates are reinstated, and is only reported while they are revoked
+const revokeDeviceCertificatesSQL = "UPDATE devicecertificate SET revoked=$4, revoked_at=current_timestamp WHERE brand_id=$1 AND model=$2 AND serial_number=$3 AND revoked<>$4"
+
+// DeviceCertificate is an X.509 certificate issued to a signed device by the device CA, binding the
+// serial number of the device to its device-key. The certificate serial is hex encoded, and the
+// certificate is PEM encoded
+type DeviceCertificate struct {
+	ID                int       `json:"id"`
+	CertificateSerial string    `json:"certificate-serial"`
+	Brand             string    `json:"brand-id"`
+	Model             string    `json:"model"`
+	SerialNumber      string    `json:"serial"`
+	Fingerprint       string    `json:"device-key-sha3-384"`
+	Certificate       string    `json:"certificate"`
+	NotAfter          time.Time `json:"not-after"`
+	Revoked           bool      `json:"revoked"`
+	RevokedAt         time.Time `json:"revoked-at"`
+	Created           time.Time `json:"created"`
+}
+
+// CreateDeviceCertificateTable creates the database table for the device certificates
+func (db *DB) CreateDeviceCertificateTable() error {
+	if _, err := db.Exec(createDeviceCertificateTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createDeviceCertificateSerialIndexSQL)
+	return err
+}
+
+// CreateDeviceCertificate stores a device certificate that has been issued
+func (db *DB) CreateDeviceCertificate(cert DeviceCertificate) error {
+	var err error
+	if usesSQLite() {
+		// Need to generate our own ID
+		var id int
+		if err = db.QueryRow(maxIDDeviceCertificateSQLite).Scan(&id); err == nil {
+			_, err = db.Exec(createDeviceCertificateSQLite, id, cert.CertificateSerial, cert.Brand, cert.Model, cert.SerialNumber, cert.Fingerprint, cert.Certificate, cert.NotAfter)
+		}
+	} else {
+		_, err = db.Exec(createDeviceCertificateSQL, cert.CertificateSerial, cert.Brand, cert.Model, cert.SerialNumber, cert.Fingerprint, cert.Certificate, cert.NotAfter)
+	}
+	if err != nil {
+		log.Printf("Error storing the device certificate: %v\n", err)
+	}
+	return err
+}
+
+// GetDeviceCertificate returns the device certificate by its hex encoded serial, or sql.ErrNoRows
+// when the device CA did not issue it
+func (db *DB) GetDeviceCertificate(certificateSerial string) (DeviceCertificate, error) {
+	cert, err := scanDeviceCertificate(db.QueryRow(getDeviceCertificateSQL, certificateSerial))
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving the device certificate: %v\n", err)
+	}
+	return cert, err
+}
+
+// GetLatestDeviceCertificate returns the latest certificate issued to the device, or sql.ErrNoRows
+// when it has none
+func (db *DB) GetLatestDeviceCertificate(brandID, model, serialNumber string) (DeviceCertificate, error) {
+	cert, err := scanDeviceCertificate(db.QueryRow(getLatestDeviceCertificateSQL, brandID, model, serialNumber))
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving the device certificate: %v\n", err)
+	}
+	return cert, err
+}
+
+// RevokeDeviceCertificates revokes the certificates of the device, or reinstates them
+func (db *DB) RevokeDeviceCertificates(brandID, model, serialNumber string, revoked bool) error {
+	_, err := db.Exec(revokeDeviceCertificatesSQL, brandID, model, serialNumber, revoked)
+	if err != nil {
+		log.Printf("Error revoking the device certificates: %v\n", err)
+	}
+	return err
+}
+
+// ListRevokedDeviceCertificates returns the revoked device certificates that have not expired,
+// which are the entries of the certificate revocation list
+func (db *DB) ListRevokedDeviceCertificates() ([]DeviceCertificate, error) {
+	rows, err := db.Query(listRevokedDeviceCertificatesSQL, true)
+	if err != nil {
+		log.Printf("Error retrieving the revoked device certificates: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	certs := []DeviceCertificate{}
+	for rows.Next() {
+		cert, err := scanDeviceCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		if cert.NotAfter.After(now) {
+			certs = append(certs, cert)
+		}
+	}
+	return certs, rows.Err()
+}
+
+type deviceCertificateScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeviceCertificate(row deviceCertificateScanner) (DeviceCertificate, error) {
+	c := DeviceCertificate{}
+	err := row.Scan(&c.ID, &c.CertificateSerial, &c.Brand, &c.Model, &c.SerialNumber, &c.Fingerprint, &c.Certificate, &c.NotAfter, &c.Revoked, &c.RevokedAt, &c.Created)
+	return c, err
+}
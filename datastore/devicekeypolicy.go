@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Types of the device-keys
+const (
+	DeviceKeyRSA   = "rsa"
+	DeviceKeyECDSA = "ecdsa"
+)
+
+// DefaultMinRSABits is the minimum size of an RSA device-key, unless the policy of the model raises it
+const DefaultMinRSABits = 2048
+
+// DeviceKeyCurves are the elliptic curves of the ECDSA device-keys that are supported
+var DeviceKeyCurves = []string{"P-256", "P-384", "P-521"}
+
+// DeviceKeyPolicy decides the device-keys that are accepted in the serial-requests of a
+// model. By default, only RSA keys of at least DefaultMinRSABits are accepted
+type DeviceKeyPolicy struct {
+	KeyTypes   []string `json:"key-types"`    // rsa and/or ecdsa
+	MinRSABits int      `json:"min-rsa-bits"` // minimum size of an RSA key
+	Curves     []string `json:"curves"`       // allowed curves of an ECDSA key, all the supported curves by default
+}
+
+// Add the device-key policy to the models table
+const alterModelDeviceKeyPolicySQL = "ALTER TABLE model ADD COLUMN device_key_policy text default ''"
+
+// AllowsType checks if the policy accepts the type of device-key
+func (policy DeviceKeyPolicy) AllowsType(keyType string) bool {
+	if len(policy.KeyTypes) == 0 {
+		return keyType == DeviceKeyRSA
+	}
+	return containsString(policy.KeyTypes, keyType)
+}
+
+// AllowsCurve checks if the policy accepts the curve of an ECDSA device-key
+func (policy DeviceKeyPolicy) AllowsCurve(curve string) bool {
+	if len(policy.Curves) == 0 {
+		return containsString(DeviceKeyCurves, curve)
+	}
+	return containsString(policy.Curves, curve)
+}
+
+// RSABits returns the minimum size of an RSA device-key
+func (policy DeviceKeyPolicy) RSABits() int {
+	if policy.MinRSABits > 0 {
+		return policy.MinRSABits
+	}
+	return DefaultMinRSABits
+}
+
+// ValidateDeviceKeyPolicy checks the device-key policy of a model. The policy may not accept
+// RSA keys that are smaller than the default
+func ValidateDeviceKeyPolicy(policy DeviceKeyPolicy) error {
+	for _, t := range policy.KeyTypes {
+		if t != DeviceKeyRSA && t != DeviceKeyECDSA {
+			return fmt.Errorf("The device-key type '%s' must be one of: rsa or ecdsa", t)
+		}
+	}
+	if policy.MinRSABits < 0 || (policy.MinRSABits > 0 && policy.MinRSABits < DefaultMinRSABits) {
+		return fmt.Errorf("The minimum size of an RSA device-key must be at least %d bits", DefaultMinRSABits)
+	}
+	for _, c := range policy.Curves {
+		if !containsString(DeviceKeyCurves, c) {
+			return fmt.Errorf("The device-key curve '%s' is not supported", c)
+		}
+	}
+	if len(policy.Curves) > 0 && !policy.AllowsType(DeviceKeyECDSA) {
+		return errors.New("The device-key curves can only be entered when ECDSA keys are accepted")
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeDeviceKeyPolicy(policy DeviceKeyPolicy) string {
+	if len(policy.KeyTypes) == 0 && policy.MinRSABits == 0 && len(policy.Curves) == 0 {
+		return ""
+	}
+	content, _ := json.Marshal(policy)
+	return string(content)
+}
+
+func decodeDeviceKeyPolicy(content string) DeviceKeyPolicy {
+	policy := DeviceKeyPolicy{}
+	if len(content) == 0 {
+		return policy
+	}
+	if err := json.Unmarshal([]byte(content), &policy); err != nil {
+		log.Printf("Error decoding the device-key policy: %v\n", err)
+	}
+	return policy
+}
@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "errors"
+
+// CreateAllowedDeviceManifest stores the manifest of a device, if the authorization is
+// allowed to do it for the brand
+func (db *DB) CreateAllowedDeviceManifest(manifest DeviceManifest, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.CreateDeviceManifest(manifest)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, manifest.Brand) {
+			return errors.New("You do not have permissions to this account")
+		}
+		return db.CreateDeviceManifest(manifest)
+	default:
+		return errors.New("Not authorized to create a device manifest")
+	}
+}
+
+// ListAllowedDeviceManifests returns the device manifests of an account the user is
+// authorized to see, with the filters of the query
+func (db *DB) ListAllowedDeviceManifests(authorization User, authorityID string, query DeviceManifestQuery) ([]DeviceManifest, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listAllDeviceManifests(authorityID, query)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		return db.listDeviceManifestsFilteredByUser(authorization.Username, authorityID, query)
+	default:
+		return []DeviceManifest{}, nil
+	}
+}
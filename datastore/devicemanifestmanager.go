@@ -0,0 +1,319 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// MaxManifestSnaps is the maximum number of snaps in a device manifest
+const MaxManifestSnaps = 500
+
+// MaxManifestComponents is the maximum number of hardware components in a device manifest
+const MaxManifestComponents = 500
+
+const createDeviceManifestTableSQL = `
+	CREATE TABLE IF NOT EXISTS devicemanifest (
+		id               serial primary key not null,
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null,
+		serial_number    varchar(200) not null,
+		image_version    varchar(200) not null default '',
+		snaps            text not null default '',
+		components       text not null default '',
+		created          timestamp default current_timestamp,
+		UNIQUE (brand_id, model, serial_number)
+	)
+`
+
+// The snaps of the manifests are also stored one per row, so the devices can be found by
+// the revision of a snap
+const createDeviceManifestSnapTableSQL = `
+	CREATE TABLE IF NOT EXISTS devicemanifestsnap (
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null,
+		serial_number    varchar(200) not null,
+		name             varchar(200) not null,
+		revision         varchar(200) not null
+	)
+`
+const createDeviceManifestSnapIndexSQL = "CREATE INDEX IF NOT EXISTS devicemanifestsnap_name_idx ON devicemanifestsnap (name, revision)"
+
+const createDeviceManifestSQLite = "INSERT INTO devicemanifest (id,brand_id,model,serial_number,image_version,snaps,components) VALUES ($1,$2,$3,$4,$5,$6,$7)"
+const createDeviceManifestSQL = "INSERT INTO devicemanifest (brand_id,model,serial_number,image_version,snaps,components) VALUES ($1,$2,$3,$4,$5,$6)"
+const createDeviceManifestSnapSQL = "INSERT INTO devicemanifestsnap (brand_id,model,serial_number,name,revision) VALUES ($1,$2,$3,$4,$5)"
+const deleteDeviceManifestSnapsSQL = "DELETE FROM devicemanifestsnap WHERE brand_id=$1 AND model=$2 AND serial_number=$3"
+const deleteDeviceManifestForSerialSQL = "DELETE FROM devicemanifest WHERE brand_id=$1 AND model=$2 AND serial_number=$3"
+const maxIDDeviceManifestSQLite = "SELECT COALESCE(MAX(id),0)+1 FROM devicemanifest"
+
+const listDeviceManifestSQL = "SELECT m.id, m.brand_id, m.model, m.serial_number, m.image_version, m.snaps, m.components, m.created FROM devicemanifest m"
+const listDeviceManifestForUserSQL = `
+	EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=m.brand_id and u.username=$%d
+	)`
+const listDeviceManifestForSnapSQL = `
+	EXISTS(
+		SELECT * FROM devicemanifestsnap s
+		WHERE s.brand_id=m.brand_id AND s.model=m.model AND s.serial_number=m.serial_number
+		AND s.name=$%d%s
+	)`
+
+const syncDeviceManifestSQL = "SELECT id, brand_id, model, serial_number, image_version, snaps, components, created FROM devicemanifest ORDER BY id"
+const syncDeleteDeviceManifestSQL = "DELETE FROM devicemanifest WHERE id=$1"
+const syncDeleteDeviceManifestSnapsSQL = `
+	DELETE FROM devicemanifestsnap WHERE EXISTS(
+		SELECT * FROM devicemanifest m
+		WHERE m.brand_id=devicemanifestsnap.brand_id AND m.model=devicemanifestsnap.model
+		AND m.serial_number=devicemanifestsnap.serial_number AND m.id=$1
+	)`
+
+// DeviceManifest holds the software and hardware that a device shipped with: the installed
+// snap revisions, the image version and the hardware components
+type DeviceManifest struct {
+	ID           int                 `json:"id" yaml:"-"`
+	Brand        string              `json:"brand_id" yaml:"-"`
+	Model        string              `json:"model" yaml:"-"`
+	SerialNumber string              `json:"serial" yaml:"-"`
+	ImageVersion string              `json:"image_version" yaml:"image-version"`
+	Snaps        []ManifestSnap      `json:"snaps" yaml:"snaps"`
+	Components   []ManifestComponent `json:"components" yaml:"components"`
+	Created      time.Time           `json:"created" yaml:"-"`
+}
+
+// ManifestSnap is a snap revision installed on a device
+type ManifestSnap struct {
+	Name     string `json:"name" yaml:"name"`
+	Revision string `json:"revision" yaml:"revision"`
+}
+
+// ManifestComponent is a hardware component of a device
+type ManifestComponent struct {
+	Name    string `json:"name" yaml:"name"`
+	Vendor  string `json:"vendor" yaml:"vendor"`
+	Version string `json:"version" yaml:"version"`
+}
+
+// DeviceManifestQuery holds the filters to find the device manifests of an account. The
+// empty fields are not filtered, and the revision is only used with the snap name
+type DeviceManifestQuery struct {
+	Model        string
+	SerialNumber string
+	Snap         string
+	Revision     string
+}
+
+// ValidateDeviceManifest checks that the manifest identifies the device and its snaps
+func ValidateDeviceManifest(manifest DeviceManifest) error {
+	if !validateStringsNotEmpty(manifest.Brand, manifest.Model, manifest.SerialNumber) {
+		return errors.New("The brand, model and serial number of the device must be supplied")
+	}
+	if len(manifest.Snaps) > MaxManifestSnaps || len(manifest.Components) > MaxManifestComponents {
+		return fmt.Errorf("The manifest can have at most %d snaps and %d components", MaxManifestSnaps, MaxManifestComponents)
+	}
+
+	for _, snap := range manifest.Snaps {
+		if !validateStringsNotEmpty(snap.Name, snap.Revision) {
+			return errors.New("The name and revision of the snaps of the manifest must be supplied")
+		}
+	}
+	for _, component := range manifest.Components {
+		if !validateStringsNotEmpty(component.Name) {
+			return errors.New("The name of the components of the manifest must be supplied")
+		}
+	}
+	return nil
+}
+
+// CreateDeviceManifestTable creates the database tables for the device manifests
+func (db *DB) CreateDeviceManifestTable() error {
+	for _, sql := range []string{createDeviceManifestTableSQL, createDeviceManifestSnapTableSQL, createDeviceManifestSnapIndexSQL} {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateDeviceManifest stores the manifest of a device, replacing its previous manifest
+func (db *DB) CreateDeviceManifest(manifest DeviceManifest) error {
+	if err := ValidateDeviceManifest(manifest); err != nil {
+		return err
+	}
+
+	snaps, err := json.Marshal(manifest.Snaps)
+	if err != nil {
+		return err
+	}
+	components, err := json.Marshal(manifest.Components)
+	if err != nil {
+		return err
+	}
+
+	err = db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteDeviceManifestSnapsSQL, manifest.Brand, manifest.Model, manifest.SerialNumber); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteDeviceManifestForSerialSQL, manifest.Brand, manifest.Model, manifest.SerialNumber); err != nil {
+			return err
+		}
+
+		if usesSQLite() {
+			// Need to generate our own ID
+			var nextID int
+			if err := tx.QueryRow(maxIDDeviceManifestSQLite).Scan(&nextID); err != nil {
+				return err
+			}
+			_, err = tx.Exec(createDeviceManifestSQLite, nextID, manifest.Brand, manifest.Model, manifest.SerialNumber, manifest.ImageVersion, string(snaps), string(components))
+		} else {
+			_, err = tx.Exec(createDeviceManifestSQL, manifest.Brand, manifest.Model, manifest.SerialNumber, manifest.ImageVersion, string(snaps), string(components))
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, snap := range manifest.Snaps {
+			if _, err := tx.Exec(createDeviceManifestSnapSQL, manifest.Brand, manifest.Model, manifest.SerialNumber, snap.Name, snap.Revision); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error creating the device manifest: %v\n", err)
+	}
+	return err
+}
+
+func (db *DB) listAllDeviceManifests(authorityID string, query DeviceManifestQuery) ([]DeviceManifest, error) {
+	return db.listDeviceManifestsFilteredByUser(anyUserFilter, authorityID, query)
+}
+
+// listDeviceManifestsFilteredByUser finds the device manifests of an account, with the
+// filters of the query
+func (db *DB) listDeviceManifestsFilteredByUser(username, authorityID string, query DeviceManifestQuery) ([]DeviceManifest, error) {
+	conditions := []string{"m.brand_id=$1"}
+	args := []interface{}{authorityID}
+
+	if len(username) > 0 {
+		args = append(args, username)
+		conditions = append(conditions, fmt.Sprintf(listDeviceManifestForUserSQL, len(args)))
+	}
+	if len(query.Model) > 0 {
+		args = append(args, query.Model)
+		conditions = append(conditions, fmt.Sprintf("m.model=$%d", len(args)))
+	}
+	if len(query.SerialNumber) > 0 {
+		args = append(args, query.SerialNumber)
+		conditions = append(conditions, fmt.Sprintf("m.serial_number=$%d", len(args)))
+	}
+	if len(query.Snap) > 0 {
+		args = append(args, query.Snap)
+		snapArg := len(args)
+		revision := ""
+		if len(query.Revision) > 0 {
+			args = append(args, query.Revision)
+			revision = fmt.Sprintf(" AND s.revision=$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(listDeviceManifestForSnapSQL, snapArg, revision))
+	}
+
+	rows, err := db.Query(listDeviceManifestSQL+" WHERE "+strings.Join(conditions, " AND ")+" ORDER BY m.id DESC LIMIT 10000", args...)
+	if err != nil {
+		log.Printf("Error retrieving the device manifests: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return db.scanDeviceManifests(rows)
+}
+
+func (db *DB) scanDeviceManifests(rows *sql.Rows) ([]DeviceManifest, error) {
+	manifests := []DeviceManifest{}
+	for rows.Next() {
+		manifest := DeviceManifest{}
+		var snaps, components string
+		err := rows.Scan(&manifest.ID, &manifest.Brand, &manifest.Model, &manifest.SerialNumber, &manifest.ImageVersion, &snaps, &components, &manifest.Created)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Snaps = decodeManifestSnaps(snaps)
+		manifest.Components = decodeManifestComponents(components)
+		manifests = append(manifests, manifest)
+	}
+	return manifests, rows.Err()
+}
+
+// decodeManifestSnaps decodes the stored snaps of a manifest
+func decodeManifestSnaps(snaps string) []ManifestSnap {
+	s := []ManifestSnap{}
+	if err := json.Unmarshal([]byte(snaps), &s); err != nil || s == nil {
+		return []ManifestSnap{}
+	}
+	return s
+}
+
+// decodeManifestComponents decodes the stored hardware components of a manifest
+func decodeManifestComponents(components string) []ManifestComponent {
+	c := []ManifestComponent{}
+	if err := json.Unmarshal([]byte(components), &c); err != nil || c == nil {
+		return []ManifestComponent{}
+	}
+	return c
+}
+
+// SyncListDeviceManifests fetches the device manifests from the factory database
+func (db *DB) SyncListDeviceManifests() ([]DeviceManifest, error) {
+	if !InFactory() {
+		return nil, errors.New("Only valid within a factory")
+	}
+
+	rows, err := db.Query(syncDeviceManifestSQL)
+	if err != nil {
+		log.Printf("Error retrieving the device manifests: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return db.scanDeviceManifests(rows)
+}
+
+// SyncDeleteDeviceManifest removes a device manifest from the factory, once it is synced
+func (db *DB) SyncDeleteDeviceManifest(ID int) error {
+	if !InFactory() {
+		return errors.New("Only valid within a factory")
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(syncDeleteDeviceManifestSnapsSQL, ID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(syncDeleteDeviceManifestSQL, ID)
+		return err
+	})
+}
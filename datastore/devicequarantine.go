@@ -0,0 +1,343 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sources of the quarantined devices
+const (
+	QuarantineSourceManual = "manual"
+	QuarantineSourceImport = "import"
+)
+
+// DeviceStateQuarantined is the status of a quarantined device in the status lookups
+const DeviceStateQuarantined = "quarantined"
+
+// MaxDeviceQuarantineImport is the maximum number of devices in an import of the quarantine list
+const MaxDeviceQuarantineImport = 50000
+
+// MaxDeviceQuarantineReasonLength limits the length of the reason of a quarantined device
+const MaxDeviceQuarantineReasonLength = 500
+
+// quarantineCSVHeader is the header of the CSV file of quarantined devices
+var quarantineCSVHeader = []string{"serialnumber", "fingerprint", "reason"}
+
+const createDeviceQuarantineTableSQL = `
+	CREATE TABLE IF NOT EXISTS devicequarantine (
+		id               serial primary key not null,
+		brand_id         varchar(200) not null,
+		serial_number    varchar(200) not null default '',
+		fingerprint      varchar(200) not null default '',
+		reason           varchar(500) not null default '',
+		source           varchar(200) not null default '',
+		created_by       varchar(200) default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const createDeviceQuarantineSerialIndexSQL = "CREATE INDEX IF NOT EXISTS devicequarantine_serial_idx ON devicequarantine (brand_id, serial_number)"
+const createDeviceQuarantineFingerprintIndexSQL = "CREATE INDEX IF NOT EXISTS devicequarantine_fingerprint_idx ON devicequarantine (fingerprint)"
+
+const maxIDDeviceQuarantineSQLite = "SELECT COALESCE(MAX(id),0)+1 FROM devicequarantine"
+const createDeviceQuarantineSQLite = "INSERT INTO devicequarantine (id,brand_id,serial_number,fingerprint,reason,source,created_by) VALUES ($1,$2,$3,$4,$5,$6,$7)"
+const createDeviceQuarantineSQL = "INSERT INTO devicequarantine (brand_id,serial_number,fingerprint,reason,source,created_by) VALUES ($1,$2,$3,$4,$5,$6)"
+const countDeviceQuarantineSQL = "SELECT COUNT(*) FROM devicequarantine WHERE brand_id=$1 AND serial_number=$2 AND fingerprint=$3"
+const deleteDeviceQuarantineSQL = "DELETE FROM devicequarantine WHERE id=$1 AND brand_id=$2"
+const listDeviceQuarantineSQL = "SELECT q.id, q.brand_id, q.serial_number, q.fingerprint, q.reason, q.source, q.created_by, q.created FROM devicequarantine q"
+const listDeviceQuarantineForUserSQL = `
+	EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=q.brand_id and u.username=$%d
+	)`
+
+// A device is quarantined by its serial number, or by its device-key fingerprint. The status
+// lookups do not know the device-key, so it is found from the signing log of the device
+const checkDeviceQuarantineSQL = listDeviceQuarantineSQL + `
+	WHERE q.brand_id=$1 AND (
+		(q.serial_number<>'' AND q.serial_number=$2) OR
+		(q.fingerprint<>'' AND (q.fingerprint=$3 OR q.fingerprint IN (SELECT fingerprint FROM signinglog WHERE make=$1 AND serial_number=$2)))
+	)
+	ORDER BY q.id LIMIT 1`
+
+const syncDeleteDeviceQuarantineSQL = "DELETE FROM devicequarantine"
+const syncDeviceQuarantineSQL = "INSERT INTO devicequarantine (id,brand_id,serial_number,fingerprint,reason,source,created_by,created) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)"
+
+// DeviceQuarantine is a device for which signing is refused e.g. a device of a recalled batch,
+// identified by its serial number or by its device-key fingerprint
+type DeviceQuarantine struct {
+	ID           int       `json:"id"`
+	Brand        string    `json:"brand_id"`
+	SerialNumber string    `json:"serial"`
+	Fingerprint  string    `json:"fingerprint"`
+	Reason       string    `json:"reason"`
+	Source       string    `json:"source"`
+	CreatedBy    string    `json:"created-by"`
+	Created      time.Time `json:"created"`
+}
+
+// DeviceQuarantineResult summarizes the devices that are added to the quarantine list
+type DeviceQuarantineResult struct {
+	Quarantined int `json:"quarantined"`
+	Duplicates  int `json:"duplicates"`
+}
+
+// ValidateDeviceQuarantine checks that a quarantined device has either a serial number or a
+// device-key fingerprint
+func ValidateDeviceQuarantine(q DeviceQuarantine) error {
+	if !validateStringsNotEmpty(q.Brand) {
+		return errors.New("The brand of the quarantined device must be supplied")
+	}
+	if validateStringsNotEmpty(q.SerialNumber) == validateStringsNotEmpty(q.Fingerprint) {
+		return errors.New("Either the serial number or the device-key fingerprint of the quarantined device must be supplied")
+	}
+	if IsSerialPattern(q.SerialNumber) {
+		return fmt.Errorf("The serial number '%s' of the quarantined device must not be a pattern", q.SerialNumber)
+	}
+	if len(q.Reason) > MaxDeviceQuarantineReasonLength {
+		return fmt.Errorf("The reason of the quarantined device must not be longer than %d characters", MaxDeviceQuarantineReasonLength)
+	}
+	return nil
+}
+
+// ParseDeviceQuarantineCSV reads the quarantined devices of a CSV file with the columns:
+// serialnumber, fingerprint, reason. A range of serial numbers, e.g. A0001..A0100, is
+// quarantined as a device for each serial number
+func ParseDeviceQuarantineCSV(r io.Reader) ([]DeviceQuarantine, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(quarantineCSVHeader)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("The quarantine import is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the quarantine import: %v", err)
+	}
+	for i := range quarantineCSVHeader {
+		if strings.ToLower(strings.TrimSpace(header[i])) != quarantineCSVHeader[i] {
+			return nil, fmt.Errorf("The quarantine import must have the header: %s", strings.Join(quarantineCSVHeader, ","))
+		}
+	}
+
+	devices := []DeviceQuarantine{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the quarantine import: %v", err)
+		}
+
+		serial := strings.TrimSpace(record[0])
+		fingerprint := strings.TrimSpace(record[1])
+		reason := strings.TrimSpace(record[2])
+		if len(serial) == 0 {
+			devices = append(devices, DeviceQuarantine{Fingerprint: fingerprint, Reason: reason})
+			continue
+		}
+
+		serials, err := ExpandSerialRange(serial, MaxDeviceQuarantineImport)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		for _, s := range serials {
+			devices = append(devices, DeviceQuarantine{SerialNumber: s, Fingerprint: fingerprint, Reason: reason})
+		}
+		if len(devices) > MaxDeviceQuarantineImport {
+			break
+		}
+	}
+
+	if len(devices) > MaxDeviceQuarantineImport {
+		return nil, fmt.Errorf("The quarantine import must not have more than %d devices", MaxDeviceQuarantineImport)
+	}
+	return devices, nil
+}
+
+// CreateDeviceQuarantineTable creates the database table for the quarantined devices
+func (db *DB) CreateDeviceQuarantineTable() error {
+	if _, err := db.Exec(createDeviceQuarantineTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createDeviceQuarantineSerialIndexSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createDeviceQuarantineFingerprintIndexSQL)
+	return err
+}
+
+// CheckDeviceQuarantine returns the quarantine of a device by its serial number or device-key
+// fingerprint, or sql.ErrNoRows when the device is not quarantined. The fingerprint may be
+// empty, as the device-keys of the signed devices are found from the signing log
+func (db *DB) CheckDeviceQuarantine(brandID, serialNumber, fingerprint string) (DeviceQuarantine, error) {
+	q := DeviceQuarantine{}
+	err := db.QueryRow(checkDeviceQuarantineSQL, brandID, serialNumber, fingerprint).Scan(&q.ID, &q.Brand, &q.SerialNumber, &q.Fingerprint, &q.Reason, &q.Source, &q.CreatedBy, &q.Created)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving the device quarantine: %v\n", err)
+	}
+	return q, err
+}
+
+// QuarantineAllowedDevices adds the devices to the quarantine list of the brand, if the user
+// is authorized for the brand. The devices that are already quarantined are skipped
+func (db *DB) QuarantineAllowedDevices(brandID string, devices []DeviceQuarantine, source string, authorization User) (DeviceQuarantineResult, error) {
+	if !db.canWriteAuthority(authorization, brandID) {
+		return DeviceQuarantineResult{}, errors.New("You do not have permissions to this brand")
+	}
+	for i := range devices {
+		devices[i].Brand = brandID
+		if err := ValidateDeviceQuarantine(devices[i]); err != nil {
+			return DeviceQuarantineResult{}, err
+		}
+	}
+
+	result := DeviceQuarantineResult{}
+	err := db.transaction(func(tx *sql.Tx) error {
+		for _, q := range devices {
+			var count int
+			if err := tx.QueryRow(countDeviceQuarantineSQL, brandID, q.SerialNumber, q.Fingerprint).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				result.Duplicates++
+				continue
+			}
+
+			if usesSQLite() {
+				// Need to generate our own ID
+				var id int
+				if err := tx.QueryRow(maxIDDeviceQuarantineSQLite).Scan(&id); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(createDeviceQuarantineSQLite, id, brandID, q.SerialNumber, q.Fingerprint, q.Reason, source, authorization.Username); err != nil {
+					return err
+				}
+			} else {
+				if _, err := tx.Exec(createDeviceQuarantineSQL, brandID, q.SerialNumber, q.Fingerprint, q.Reason, source, authorization.Username); err != nil {
+					return err
+				}
+			}
+			result.Quarantined++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error quarantining the devices: %v\n", err)
+		return DeviceQuarantineResult{}, err
+	}
+	return result, nil
+}
+
+// ListAllowedDeviceQuarantine returns the quarantined devices of a brand, or of all the brands
+// when the brand is empty, that the user is authorized to see
+func (db *DB) ListAllowedDeviceQuarantine(authorization User, brandID string) ([]DeviceQuarantine, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+	case SyncUser:
+		fallthrough
+	case Admin:
+		args = append(args, authorization.Username)
+		conditions = append(conditions, fmt.Sprintf(listDeviceQuarantineForUserSQL, len(args)))
+	default:
+		return []DeviceQuarantine{}, nil
+	}
+	if len(brandID) > 0 {
+		args = append(args, brandID)
+		conditions = append(conditions, fmt.Sprintf("q.brand_id=$%d", len(args)))
+	}
+
+	query := listDeviceQuarantineSQL
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := db.Query(query+" ORDER BY q.id", args...)
+	if err != nil {
+		log.Printf("Error retrieving the quarantined devices: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []DeviceQuarantine{}
+	for rows.Next() {
+		q := DeviceQuarantine{}
+		if err := rows.Scan(&q.ID, &q.Brand, &q.SerialNumber, &q.Fingerprint, &q.Reason, &q.Source, &q.CreatedBy, &q.Created); err != nil {
+			return nil, err
+		}
+		devices = append(devices, q)
+	}
+	return devices, rows.Err()
+}
+
+// DeleteAllowedDeviceQuarantine releases a device from the quarantine list of the brand, if the
+// user is authorized for the brand
+func (db *DB) DeleteAllowedDeviceQuarantine(brandID string, quarantineID int, authorization User) error {
+	if !db.canWriteAuthority(authorization, brandID) {
+		return errors.New("You do not have permissions to this brand")
+	}
+
+	result, err := db.Exec(deleteDeviceQuarantineSQL, quarantineID, brandID)
+	if err != nil {
+		log.Printf("Error releasing the quarantined device: %v\n", err)
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errors.New("Cannot find the quarantined device")
+	}
+	return nil
+}
+
+// SyncDeviceQuarantine replaces the factory's quarantine list with the quarantined devices of
+// the cloud serial-vault
+func (db *DB) SyncDeviceQuarantine(devices []DeviceQuarantine) error {
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(syncDeleteDeviceQuarantineSQL); err != nil {
+			log.Printf("Error removing the quarantined devices: %v\n", err)
+			return err
+		}
+
+		for _, q := range devices {
+			_, err := tx.Exec(syncDeviceQuarantineSQL, q.ID, q.Brand, q.SerialNumber, q.Fingerprint, q.Reason, q.Source, q.CreatedBy, q.Created.UTC().Format(sqliteTimestampFormat))
+			if err != nil {
+				log.Printf("Error storing the quarantined device: %v\n", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
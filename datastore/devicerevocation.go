@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"log"
+	"time"
+)
+
+// listDeviceRevocationsSQL fetches the current lifecycle state of each device of the brand, with
+// the device-key fingerprint of its latest signing
+const listDeviceRevocationsSQL = `
+	SELECT s.brand_id, s.model, s.serial_number, s.state, s.created,
+		COALESCE((SELECT l.fingerprint FROM signinglog l WHERE l.make=s.brand_id AND l.model=s.model AND l.serial_number=s.serial_number ORDER BY l.id DESC LIMIT 1), '')
+	FROM devicestate s
+	WHERE s.brand_id=$1 AND s.id=(
+		SELECT MAX(d.id) FROM devicestate d
+		WHERE d.brand_id=s.brand_id AND d.model=s.model AND d.serial_number=s.serial_number)
+	ORDER BY s.id`
+
+// DeviceRevocation is a device that has been revoked by its transition to a revoking lifecycle
+// state e.g. scrapped. The fingerprint is the SHA3-384 digest of the device-key of its latest signing
+type DeviceRevocation struct {
+	Brand        string    `json:"brand-id"`
+	Model        string    `json:"model"`
+	SerialNumber string    `json:"serial"`
+	Fingerprint  string    `json:"device-key-sha3-384"`
+	State        string    `json:"state"`
+	Revoked      time.Time `json:"revoked"`
+}
+
+// ListDeviceRevocations returns the devices of the brand whose current lifecycle state is one of
+// the revoking states, in the order they were revoked
+func (db *DB) ListDeviceRevocations(brandID string, states []string) ([]DeviceRevocation, error) {
+	revocations := []DeviceRevocation{}
+	if len(states) == 0 {
+		return revocations, nil
+	}
+
+	rows, err := db.Query(listDeviceRevocationsSQL, brandID)
+	if err != nil {
+		log.Printf("Error retrieving the device revocations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := DeviceRevocation{}
+		if err := rows.Scan(&r.Brand, &r.Model, &r.SerialNumber, &r.State, &r.Revoked, &r.Fingerprint); err != nil {
+			return nil, err
+		}
+		if containsString(states, r.State) {
+			revocations = append(revocations, r)
+		}
+	}
+	return revocations, rows.Err()
+}
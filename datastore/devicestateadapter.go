@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "errors"
+
+// TransitionAllowedDeviceState moves a signed device to a new lifecycle state, if the
+// authorization is allowed to do it for the brand
+func (db *DB) TransitionAllowedDeviceState(state DeviceState, authorization User) (DeviceState, error) {
+	state.CreatedBy = authorization.Username
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.transitionDeviceState(state)
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, state.Brand) {
+			return DeviceState{}, errors.New("You do not have permissions to this brand")
+		}
+		return db.transitionDeviceState(state)
+	default:
+		return DeviceState{}, errors.New("You do not have permissions to this brand")
+	}
+}
+
+// ListAllowedDeviceStateHistory returns the lifecycle state transitions of a device, if the
+// user is authorized to see the brand
+func (db *DB) ListAllowedDeviceStateHistory(authorization User, brandID, model, serialNumber string) ([]DeviceState, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listDeviceStateHistory(brandID, model, serialNumber)
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, brandID) {
+			return []DeviceState{}, nil
+		}
+		return db.listDeviceStateHistory(brandID, model, serialNumber)
+	default:
+		return []DeviceState{}, nil
+	}
+}
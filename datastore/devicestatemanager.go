@@ -0,0 +1,207 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Lifecycle states of a signed device
+const (
+	DeviceStateManufactured = "manufactured"
+	DeviceStateShipped      = "shipped"
+	DeviceStateRMA          = "rma"
+	DeviceStateScrapped     = "scrapped"
+)
+
+// MaxDeviceStateNoteLength limits the length of the note of a state transition
+const MaxDeviceStateNoteLength = 500
+
+// deviceStateTransitions are the states that a device can move to from each state. A signed
+// device without a recorded state is manufactured, and a scrapped device cannot leave that state
+var deviceStateTransitions = map[string][]string{
+	DeviceStateManufactured: {DeviceStateShipped, DeviceStateRMA, DeviceStateScrapped},
+	DeviceStateShipped:      {DeviceStateRMA, DeviceStateScrapped},
+	DeviceStateRMA:          {DeviceStateManufactured, DeviceStateShipped, DeviceStateScrapped},
+	DeviceStateScrapped:     {},
+}
+
+const createDeviceStateTableSQL = `
+	CREATE TABLE IF NOT EXISTS devicestate (
+		id               serial primary key not null,
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null,
+		serial_number    varchar(200) not null,
+		state            varchar(200) not null,
+		previous_state   varchar(200) not null default '',
+		note             varchar(500) not null default '',
+		created_by       varchar(200) default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const createDeviceStateIndexSQL = "CREATE INDEX IF NOT EXISTS devicestate_serial_idx ON devicestate (brand_id, model, serial_number)"
+
+const maxIDDeviceStateSQLite = "SELECT COALESCE(MAX(id),0)+1 FROM devicestate"
+const createDeviceStateSQLite = "INSERT INTO devicestate (id,brand_id,model,serial_number,state,previous_state,note,created_by) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)"
+const createDeviceStateSQL = "INSERT INTO devicestate (brand_id,model,serial_number,state,previous_state,note,created_by) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id"
+const getDeviceStateSQL = "SELECT id, brand_id, model, serial_number, state, previous_state, note, created_by, created FROM devicestate WHERE id=$1"
+const currentDeviceStateSQL = "SELECT state FROM devicestate WHERE brand_id=$1 AND model=$2 AND serial_number=$3 ORDER BY id DESC LIMIT 1"
+const listDeviceStateSQL = "SELECT id, brand_id, model, serial_number, state, previous_state, note, created_by, created FROM devicestate WHERE brand_id=$1 AND model=$2 AND serial_number=$3 ORDER BY id"
+const findSignedDeviceSQL = "SELECT EXISTS(SELECT * FROM signinglog WHERE make=$1 AND model=$2 AND serial_number=$3)"
+
+// DeviceState is a transition of a signed device to a lifecycle state e.g. shipped or scrapped.
+// The transitions are only added, so they are the history of the device
+type DeviceState struct {
+	ID            int       `json:"id"`
+	Brand         string    `json:"brand_id"`
+	Model         string    `json:"model"`
+	SerialNumber  string    `json:"serial"`
+	State         string    `json:"state"`
+	PreviousState string    `json:"previous-state"`
+	Note          string    `json:"note"`
+	CreatedBy     string    `json:"created-by"`
+	Created       time.Time `json:"created"`
+}
+
+// ValidateDeviceStateTransition checks that a device can move from the current state to the new
+// state. A device without a recorded state is manufactured
+func ValidateDeviceStateTransition(current, state string) error {
+	if _, ok := deviceStateTransitions[state]; !ok {
+		return fmt.Errorf("Invalid device state '%s'", state)
+	}
+	if len(current) == 0 {
+		current = DeviceStateManufactured
+	}
+	if !containsString(deviceStateTransitions[current], state) {
+		return fmt.Errorf("A device cannot move from %s to %s", current, state)
+	}
+	return nil
+}
+
+// ValidateDeviceState checks the device and the note of a new state transition
+func ValidateDeviceState(state DeviceState) error {
+	if !validateStringsNotEmpty(state.Brand, state.Model, state.SerialNumber) {
+		return errors.New("The brand, model and serial number of the device must be supplied")
+	}
+	if len(state.Note) > MaxDeviceStateNoteLength {
+		return fmt.Errorf("The note of the device state must not be longer than %d characters", MaxDeviceStateNoteLength)
+	}
+	return nil
+}
+
+// CreateDeviceStateTable creates the database table for the lifecycle states of the devices
+func (db *DB) CreateDeviceStateTable() error {
+	_, err := db.Exec(createDeviceStateTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createDeviceStateIndexSQL)
+	return err
+}
+
+// GetDeviceState returns the current lifecycle state of a device, which is empty when no
+// state has been recorded for the device
+func (db *DB) GetDeviceState(brandID, model, serialNumber string) (string, error) {
+	var state string
+	err := db.QueryRow(currentDeviceStateSQL, brandID, model, serialNumber).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving the device state: %v\n", err)
+		return "", errors.New("Error communicating with the database")
+	}
+	return state, nil
+}
+
+// transitionDeviceState moves a signed device to a new lifecycle state, if the transition
+// is allowed from its current state
+func (db *DB) transitionDeviceState(state DeviceState) (DeviceState, error) {
+	if err := ValidateDeviceState(state); err != nil {
+		return DeviceState{}, err
+	}
+
+	var stateID int
+	err := db.transaction(func(tx *sql.Tx) error {
+		var signed bool
+		if err := tx.QueryRow(findSignedDeviceSQL, state.Brand, state.Model, state.SerialNumber).Scan(&signed); err != nil {
+			return err
+		}
+		if !signed {
+			return errors.New("Cannot find the signed device")
+		}
+
+		err := tx.QueryRow(currentDeviceStateSQL, state.Brand, state.Model, state.SerialNumber).Scan(&state.PreviousState)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err := ValidateDeviceStateTransition(state.PreviousState, state.State); err != nil {
+			return err
+		}
+
+		if usesSQLite() {
+			// Need to generate our own ID
+			if err := tx.QueryRow(maxIDDeviceStateSQLite).Scan(&stateID); err != nil {
+				return err
+			}
+			_, err = tx.Exec(createDeviceStateSQLite, stateID, state.Brand, state.Model, state.SerialNumber, state.State, state.PreviousState, state.Note, state.CreatedBy)
+			return err
+		}
+		return tx.QueryRow(createDeviceStateSQL, state.Brand, state.Model, state.SerialNumber, state.State, state.PreviousState, state.Note, state.CreatedBy).Scan(&stateID)
+	})
+	if err != nil {
+		log.Printf("Error changing the device state: %v\n", err)
+		return DeviceState{}, err
+	}
+
+	err = db.QueryRow(getDeviceStateSQL, stateID).Scan(&state.ID, &state.Brand, &state.Model, &state.SerialNumber, &state.State, &state.PreviousState, &state.Note, &state.CreatedBy, &state.Created)
+	if err != nil {
+		log.Printf("Error retrieving the device state: %v\n", err)
+		return DeviceState{}, err
+	}
+	return state, nil
+}
+
+// listDeviceStateHistory returns the lifecycle state transitions of a device, oldest first
+func (db *DB) listDeviceStateHistory(brandID, model, serialNumber string) ([]DeviceState, error) {
+	rows, err := db.Query(listDeviceStateSQL, brandID, model, serialNumber)
+	if err != nil {
+		log.Printf("Error retrieving the device states: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []DeviceState{}
+	for rows.Next() {
+		s := DeviceState{}
+		err := rows.Scan(&s.ID, &s.Brand, &s.Model, &s.SerialNumber, &s.State, &s.PreviousState, &s.Note, &s.CreatedBy, &s.Created)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Scopes of the duplicate check of the serial-requests of a model
+const (
+	DuplicateScopeEither    = "either"     // the serial number or the device-key has been signed before
+	DuplicateScopeSerial    = "serial"     // the serial number has been signed before, for any device-key
+	DuplicateScopeDeviceKey = "device-key" // the device-key has been signed before, for any serial number
+	DuplicateScopePair      = "pair"       // the serial number has been signed before for the same device-key
+)
+
+// Handling of the serial-requests that are duplicates in the scope of the model
+const (
+	ResignRevision = "revision" // the device is signed with the next revision of the serial number
+	ResignReject   = "reject"   // the serial-request is rejected
+)
+
+// DuplicateScopes are the scopes of the duplicate check
+var DuplicateScopes = []string{DuplicateScopeEither, DuplicateScopeSerial, DuplicateScopeDeviceKey, DuplicateScopePair}
+
+// DuplicatePolicy decides when a serial-request of a model is a re-sign of a device that has been
+// signed before, and whether a re-sign is signed as a new revision or rejected. By default, the
+// serial number or the device-key are checked, and the re-signs are signed as revisions. When
+// Replay is set, an exact replay of a serial-request within the replay window returns the serial
+// assertion that was signed for it
+type DuplicatePolicy struct {
+	Scope  string `json:"scope"`            // either, serial, device-key or pair
+	Resign string `json:"resign"`           // revision or reject
+	Replay bool   `json:"replay,omitempty"` // return the signed serial assertion to an exact replay
+}
+
+// Add the duplicate policy to the models table
+const alterModelDuplicatePolicySQL = "ALTER TABLE model ADD COLUMN duplicate_policy text default ''"
+
+// DuplicateScope returns the scope of the duplicate check
+func (policy DuplicatePolicy) DuplicateScope() string {
+	if len(policy.Scope) == 0 {
+		return DuplicateScopeEither
+	}
+	return policy.Scope
+}
+
+// RejectsResigns checks if the serial-requests that are duplicates are rejected
+func (policy DuplicatePolicy) RejectsResigns() bool {
+	return policy.Resign == ResignReject
+}
+
+// ValidateDuplicatePolicy checks the duplicate policy of a model
+func ValidateDuplicatePolicy(policy DuplicatePolicy) error {
+	if len(policy.Scope) > 0 && !containsString(DuplicateScopes, policy.Scope) {
+		return fmt.Errorf("The duplicate scope '%s' must be one of: either, serial, device-key or pair", policy.Scope)
+	}
+	if len(policy.Resign) > 0 && policy.Resign != ResignRevision && policy.Resign != ResignReject {
+		return fmt.Errorf("The re-sign handling '%s' must be one of: revision or reject", policy.Resign)
+	}
+	return nil
+}
+
+func encodeDuplicatePolicy(policy DuplicatePolicy) string {
+	if len(policy.Scope) == 0 && len(policy.Resign) == 0 && !policy.Replay {
+		return ""
+	}
+	content, _ := json.Marshal(policy)
+	return string(content)
+}
+
+func decodeDuplicatePolicy(content string) DuplicatePolicy {
+	policy := DuplicatePolicy{}
+	if len(content) == 0 {
+		return policy
+	}
+	if err := json.Unmarshal([]byte(content), &policy); err != nil {
+		log.Printf("Error decoding the duplicate policy: %v\n", err)
+	}
+	return policy
+}
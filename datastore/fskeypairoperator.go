@@ -0,0 +1,278 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// Directories of the filesystem keystore
+const (
+	fsPlaintextKeysDir = "private-keys-v1" // the unencrypted keys of the snapd filesystem keystore
+	fsSealedKeysDir    = "sealed-keys-v1"
+)
+
+// ErrKeyStoreSecretRequired is returned when the filesystem keystore has no secret to encrypt the keys
+var ErrKeyStoreSecretRequired = errors.New("The filesystem keystore requires the keystoreSecret to encrypt the signing-keys")
+
+// sealedKeyFile is a signing-key of the filesystem keystore, encrypted with a random auth-key
+// that is itself encrypted with the keystore secret
+type sealedKeyFile struct {
+	AuthKey    string `json:"auth-key"`
+	SigningKey string `json:"signing-key"`
+}
+
+// FilesystemKeypairOperator is the storage container for signing-keys that are sealed in files.
+// The keys are unsealed into the memory store when the keystore is opened
+type FilesystemKeypairOperator struct {
+	path   string
+	secret string
+}
+
+// ImportKeypair seals a new signing-key in its file of the filesystem keystore.
+// The main operations:
+//   - Generate a random auth-key
+//   - Use AES symmetric encryption to encrypt the signing-key with the auth-key
+//   - Encrypt the auth-key with the keystore secret
+//   - Write both to the file of the key-id
+//
+// The file holds the sealed signing-key, so there is no sealed key to store in the database
+func (fsStore *FilesystemKeypairOperator) ImportKeypair(authorityID, keyID, base64PrivateKey string) (string, error) {
+	return "", sealKeyFile(fsStore.path, fsStore.secret, keyID, base64PrivateKey)
+}
+
+// UnsealKeypair unseals a signing-key from its file and stores it in the memory store
+func (fsStore *FilesystemKeypairOperator) UnsealKeypair(authorityID string, keyID string, base64SealedSigningKey string) error {
+	// Check if we have already unsealed the key into the memory store
+	if _, err := keypairDB.PublicKey(keyID); err == nil {
+		return nil
+	}
+
+	privateKey, err := unsealKeyFile(fsStore.path, fsStore.secret, keyID)
+	if err != nil {
+		return err
+	}
+	return keypairDB.ImportKey(privateKey)
+}
+
+// openFilesystemKeyStore verifies the filesystem keystore and unseals its signing-keys into the
+// memory store. The keystore must have a secret and must not have unencrypted signing-keys
+func openFilesystemKeyStore(path, secret string, db *asserts.Database) error {
+	if len(secret) == 0 {
+		return ErrKeyStoreSecretRequired
+	}
+
+	plaintext, err := keyFiles(filepath.Join(path, fsPlaintextKeysDir))
+	if err != nil {
+		return err
+	}
+	if len(plaintext) > 0 {
+		return fmt.Errorf("The filesystem keystore has %d unencrypted signing-keys: run 'serial-vault-admin keystore encrypt'", len(plaintext))
+	}
+
+	if err := os.MkdirAll(filepath.Join(path, fsSealedKeysDir), 0700); err != nil {
+		return err
+	}
+
+	sealed, err := keyFiles(filepath.Join(path, fsSealedKeysDir))
+	if err != nil {
+		return err
+	}
+	for _, keyID := range sealed {
+		privateKey, err := unsealKeyFile(path, secret, keyID)
+		if err != nil {
+			return fmt.Errorf("Cannot unseal signing-key %s with the keystore secret: %v", keyID, err)
+		}
+		if err := db.ImportKey(privateKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptFilesystemKeystore seals the unencrypted signing-keys of a filesystem keystore with
+// the secret, removing each unencrypted key once its sealed file has been verified. It returns
+// the key-ids of the signing-keys that were sealed
+func EncryptFilesystemKeystore(path, secret string) ([]string, error) {
+	if len(secret) == 0 {
+		return nil, ErrKeyStoreSecretRequired
+	}
+
+	plaintextDir := filepath.Join(path, fsPlaintextKeysDir)
+	plaintext, err := keyFiles(plaintextDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(path, fsSealedKeysDir), 0700); err != nil {
+		return nil, err
+	}
+
+	encrypted := []string{}
+	for _, keyID := range plaintext {
+		data, err := ioutil.ReadFile(filepath.Join(plaintextDir, keyID))
+		if err != nil {
+			return encrypted, err
+		}
+
+		// The snapd keystore holds the keys in the base64 snapd encoding
+		imported, _, err := crypt.ConvertPrivateKey(string(data))
+		if err != nil {
+			return encrypted, fmt.Errorf("Cannot read signing-key %s: %v", keyID, err)
+		}
+		if imported.KeyID != keyID {
+			return encrypted, fmt.Errorf("The file of signing-key %s holds signing-key %s", keyID, imported.KeyID)
+		}
+
+		if err = sealKeyFile(path, secret, keyID, imported.Base64PrivateKey); err != nil {
+			return encrypted, fmt.Errorf("Cannot seal signing-key %s: %v", keyID, err)
+		}
+		if _, err = unsealKeyFile(path, secret, keyID); err != nil {
+			return encrypted, fmt.Errorf("Cannot verify the sealed signing-key %s: %v", keyID, err)
+		}
+
+		if err = os.Remove(filepath.Join(plaintextDir, keyID)); err != nil {
+			return encrypted, err
+		}
+		encrypted = append(encrypted, keyID)
+	}
+
+	// The directory is only removed when it is empty
+	os.Remove(plaintextDir)
+	return encrypted, nil
+}
+
+// keyFiles returns the key-ids of the key files in a directory of the keystore. The dot-files are
+// skipped, as they are the temporary files of a key that was being sealed when the vault stopped
+func keyFiles(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyIDs := []string{}
+	for _, f := range files {
+		if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), ".") {
+			keyIDs = append(keyIDs, f.Name())
+		}
+	}
+	return keyIDs, nil
+}
+
+func sealKeyFile(path, secret, keyID, base64PrivateKey string) error {
+	if len(secret) == 0 {
+		return ErrKeyStoreSecretRequired
+	}
+
+	authKey, err := crypt.CreateSecret(32)
+	if err != nil {
+		return err
+	}
+	sealedAuthKey, err := crypt.EncryptKey(authKey, secret)
+	if err != nil {
+		return err
+	}
+	sealedSigningKey, err := crypt.EncryptKey(base64PrivateKey, authKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(sealedKeyFile{
+		AuthKey:    base64.StdEncoding.EncodeToString(sealedAuthKey),
+		SigningKey: base64.StdEncoding.EncodeToString(sealedSigningKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write the file atomically, so an interrupted write does not leave a broken key
+	dir := filepath.Join(path, fsSealedKeysDir)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+keyID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, keyID))
+}
+
+func unsealKeyFile(path, secret, keyID string) (asserts.PrivateKey, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, fsSealedKeysDir, keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := sealedKeyFile{}
+	if err = json.Unmarshal(data, &sealed); err != nil {
+		return nil, err
+	}
+
+	sealedAuthKey, err := base64.StdEncoding.DecodeString(sealed.AuthKey)
+	if err != nil {
+		return nil, err
+	}
+	authKey, err := crypt.DecryptKey(sealedAuthKey, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedSigningKey, err := base64.StdEncoding.DecodeString(sealed.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	base64SigningKey, err := crypt.DecryptKey(sealedSigningKey, string(authKey))
+	if err != nil {
+		return nil, err
+	}
+
+	// A wrong secret decrypts to a key that cannot be read
+	imported, _, err := crypt.ConvertPrivateKey(string(base64SigningKey))
+	if err != nil {
+		return nil, errors.New("The signing-key cannot be decrypted")
+	}
+	if imported.KeyID != keyID {
+		return nil, fmt.Errorf("The sealed file of signing-key %s holds signing-key %s", keyID, imported.KeyID)
+	}
+
+	privateKey, _, err := crypt.DeserializePrivateKey(imported.Base64PrivateKey)
+	return privateKey, err
+}
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKeyID = "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"
+
+func TestEncryptFilesystemKeystore(t *testing.T) {
+	path, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("Error creating the keystore: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	data, err := ioutil.ReadFile("../keystore/TestKey.snapd")
+	if err != nil {
+		t.Fatalf("Error reading the test key: %v", err)
+	}
+	os.Mkdir(filepath.Join(path, fsPlaintextKeysDir), 0700)
+	ioutil.WriteFile(filepath.Join(path, fsPlaintextKeysDir, testKeyID), data, 0600)
+	ioutil.WriteFile(filepath.Join(path, fsPlaintextKeysDir, "wrong-key-id"), data, 0600)
+
+	if err = openFilesystemKeyStore(path, "", nil); err != ErrKeyStoreSecretRequired {
+		t.Errorf("Expected the secret to be required, got: %v", err)
+	}
+	if err = openFilesystemKeyStore(path, "secret", nil); err == nil {
+		t.Error("Expected an error opening a keystore with unencrypted keys")
+	}
+
+	// The keys are encrypted in order, and the key in the file of another key-id is not encrypted
+	encrypted, err := EncryptFilesystemKeystore(path, "secret")
+	if err == nil || len(encrypted) != 1 || encrypted[0] != testKeyID {
+		t.Fatalf("Expected the test key to be encrypted before the wrong key-id, got: %v %v", encrypted, err)
+	}
+	if _, err = os.Stat(filepath.Join(path, fsPlaintextKeysDir, testKeyID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the unencrypted key to be removed, got: %v", err)
+	}
+	os.Remove(filepath.Join(path, fsPlaintextKeysDir, "wrong-key-id"))
+
+	sealed, err := ioutil.ReadFile(filepath.Join(path, fsSealedKeysDir, testKeyID))
+	if err != nil {
+		t.Fatalf("Error reading the sealed key: %v", err)
+	}
+	if string(sealed) == string(data) {
+		t.Error("Expected the sealed key to be encrypted")
+	}
+
+	if _, err = unsealKeyFile(path, "secret", testKeyID); err != nil {
+		t.Errorf("Error unsealing the key: %v", err)
+	}
+	if _, err = unsealKeyFile(path, "wrong secret", testKeyID); err == nil {
+		t.Error("Expected an error unsealing the key with the wrong secret")
+	}
+	if err = openFilesystemKeyStore(path, "secret", nil); err != nil {
+		t.Errorf("Error opening the encrypted keystore: %v", err)
+	}
+
+	// The temporary file of a key that was being sealed when the vault stopped is ignored
+	ioutil.WriteFile(filepath.Join(path, fsSealedKeysDir, "."+testKeyID+"123456"), []byte("{"), 0600)
+	if err = openFilesystemKeyStore(path, "secret", nil); err != nil {
+		t.Errorf("Error opening the keystore with a temporary file: %v", err)
+	}
+
+	// Running the migration again has nothing to encrypt, and removes the empty directory
+	encrypted, err = EncryptFilesystemKeystore(path, "secret")
+	if err != nil || len(encrypted) != 0 {
+		t.Errorf("Expected nothing to encrypt, got: %v %v", encrypted, err)
+	}
+	if _, err = os.Stat(filepath.Join(path, fsPlaintextKeysDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected the unencrypted keys to be removed, got: %v", err)
+	}
+}
+
+func TestFilesystemKeystoreFixture(t *testing.T) {
+	// The test keystore is sealed with the secret of the test settings
+	if _, err := unsealKeyFile("../keystore", "secret code to encrypt the auth-key hash", testKeyID); err != nil {
+		t.Errorf("Error unsealing the test key: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"fmt"
+	"log"
+)
+
+// DefaultIndexAdvisorRows is the estimated number of rows above which a table is large enough
+// for the queries to degrade when one of its expected indexes is missing
+const DefaultIndexAdvisorRows = 100000
+
+// ExpectedIndex is an index that the queries of a table rely on
+type ExpectedIndex struct {
+	Table   string
+	Name    string
+	Purpose string
+}
+
+// MissingIndex is an expected index that is missing, or invalid, on a large table
+type MissingIndex struct {
+	ExpectedIndex
+	Rows int64
+}
+
+// expectedIndexes are the indexes of the large tables, as created by the database schema updates
+var expectedIndexes = []ExpectedIndex{
+	{"signinglog", "serialnumber_idx", "duplicate checks of the serial numbers"},
+	{"signinglog", "fingerprint_idx", "duplicate checks and search of the device-key fingerprints"},
+	{"signinglog", "created_idx", "reports by creation date"},
+	{"signinglog", "signinglog_make_id_idx", "listing of the signing logs of an account"},
+	{"signinglog", "signinglog_station_idx", "filter of the signing logs by station"},
+	{"signinglog", "signinglog_details_trgm_idx", "search of the fields of the serial-request body"},
+	{"signinglog", "signinglog_origin_trgm_idx", "search of the origin of the serial requests"},
+}
+
+// The estimated rows are taken from the statistics of the table, as counting them is slow
+const estimateTableRowsSQL = "SELECT COALESCE((SELECT reltuples::bigint FROM pg_class WHERE oid=to_regclass($1)), 0)"
+
+// An index that failed to build concurrently is left invalid, so it is only found when it is valid
+const findIndexSQL = "SELECT EXISTS(SELECT * FROM pg_index WHERE indrelid=to_regclass($1) AND indexrelid=to_regclass($2) AND indisvalid)"
+
+// MissingIndexes returns the expected indexes that are missing or invalid on the tables with more
+// than the estimated number of rows
+func (db *DB) MissingIndexes(minRows int64) ([]MissingIndex, error) {
+	missing := []MissingIndex{}
+	rows := map[string]int64{}
+
+	for _, index := range expectedIndexes {
+		count, ok := rows[index.Table]
+		if !ok {
+			err := db.QueryRow(estimateTableRowsSQL, index.Table).Scan(&count)
+			if err != nil {
+				return nil, err
+			}
+			rows[index.Table] = count
+		}
+		if count < minRows {
+			continue
+		}
+
+		var exists bool
+		err := db.QueryRow(findIndexSQL, index.Table, index.Name).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, MissingIndex{index, count})
+		}
+	}
+
+	return missing, nil
+}
+
+// AdviseIndexes logs a warning for each expected index that is missing or invalid on a large table. The
+// sqlite database of a factory is small and has no statistics, so it is not checked
+func AdviseIndexes(db Datastore) {
+	if usesSQLite() {
+		return
+	}
+
+	missing, err := db.MissingIndexes(DefaultIndexAdvisorRows)
+	if err != nil {
+		log.Printf("Error checking the indexes of the database: %v\n", err)
+		return
+	}
+	for _, w := range indexWarnings(missing) {
+		log.Println(w)
+	}
+}
+
+// indexWarnings describes the missing indexes and how to create them
+func indexWarnings(missing []MissingIndex) []string {
+	warnings := []string{}
+	for _, m := range missing {
+		warnings = append(warnings, fmt.Sprintf("Warning: the '%s' table has about %d rows, but the '%s' index for the %s is missing or invalid. Run `serial-vault-admin database` to create it", m.Table, m.Rows, m.Name, m.Purpose))
+	}
+	return warnings
+}
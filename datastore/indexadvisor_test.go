@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpectedIndexesAreCreated(t *testing.T) {
+	created := strings.Join([]string{
+		createSigningLogSerialNumberIndexSQL,
+		createSigningLogFingerprintIndexSQL,
+		createSigningLogCreatedIndexSQL,
+		createSigningLogMakeIndexSQL,
+		createSigningLogStationIndexSQL,
+		createSigningLogDetailsIndexSQL,
+		createSigningLogOriginIndexSQL,
+	}, "\n")
+
+	for _, index := range expectedIndexes {
+		if !strings.Contains(created, " "+index.Name+" ON "+index.Table+" ") {
+			t.Errorf("Expected index `%s` is not created by the schema updates", index.Name)
+		}
+	}
+}
+
+func TestInvalidIndexesAreDropped(t *testing.T) {
+	// The indexes that are built concurrently are dropped by name when the build left them invalid
+	for _, drop := range []struct{ dropSQL, createSQL string }{
+		{dropSigningLogDetailsIndexSQL, createSigningLogDetailsIndexSQL},
+		{dropSigningLogOriginIndexSQL, createSigningLogOriginIndexSQL},
+	} {
+		name := drop.dropSQL[strings.LastIndex(drop.dropSQL, " ")+1:]
+		if !strings.Contains(drop.createSQL, " "+name+" ON ") {
+			t.Errorf("Expected `%s` to drop the index of `%s`", drop.dropSQL, drop.createSQL)
+		}
+	}
+}
+
+func TestIndexWarnings(t *testing.T) {
+	missing := []MissingIndex{
+		{ExpectedIndex{"signinglog", "signinglog_details_trgm_idx", "search of the fields of the serial-request body"}, 250000},
+	}
+
+	warnings := indexWarnings(missing)
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got: %d", len(warnings))
+	}
+	for _, s := range []string{"'signinglog'", "250000", "'signinglog_details_trgm_idx'", "serial-vault-admin database"} {
+		if !strings.Contains(warnings[0], s) {
+			t.Errorf("Expected the warning to contain `%s`, got: %s", s, warnings[0])
+		}
+	}
+
+	if len(indexWarnings(nil)) != 0 {
+		t.Error("Expected no warnings without missing indexes")
+	}
+}
+
+func TestMissingIndexesMock(t *testing.T) {
+	missing, err := (&MockDB{}).MissingIndexes(DefaultIndexAdvisorRows)
+	if err != nil || len(missing) != 0 {
+		t.Errorf("Expected no missing indexes, got: %v %v", missing, err)
+	}
+
+	_, err = (&ErrorMockDB{}).MissingIndexes(DefaultIndexAdvisorRows)
+	if err == nil {
+		t.Error("Expected an error checking the indexes")
+	}
+}
@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Roles of the vault instances
+const (
+	InstanceRoleCloud   = "cloud"
+	InstanceRoleFactory = "factory"
+	InstanceRoleProxy   = "proxy"
+)
+
+const createInstanceTableSQL = `
+	CREATE TABLE IF NOT EXISTS instance (
+		id               serial primary key not null,
+		instance_id      varchar(200) not null,
+		hostname         varchar(200) default '',
+		version          varchar(50) default '',
+		role             varchar(50) not null,
+		mode             varchar(50) default '',
+		started          timestamp default current_timestamp,
+		heartbeat        timestamp default current_timestamp
+	)
+`
+
+// Indexes
+const createInstanceUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS instance_idx ON instance (instance_id, mode)"
+
+const upsertInstanceSQL = `
+	WITH upsert AS (
+		UPDATE instance SET hostname=$3, version=$4, role=$5, started=$6, heartbeat=current_timestamp
+		WHERE instance_id=$1 AND mode=$2
+		RETURNING *
+	)
+	INSERT INTO instance (instance_id, mode, hostname, version, role, started)
+	SELECT $1, $2, $3, $4, $5, $6
+	WHERE NOT EXISTS (SELECT * FROM upsert)
+`
+
+const listInstancesSQL = "SELECT id, instance_id, hostname, version, role, mode, started, heartbeat FROM instance ORDER BY role, instance_id, mode"
+
+// Instance is a running vault that has registered itself, with the time of its last heartbeat
+type Instance struct {
+	ID         int       `json:"id"`
+	InstanceID string    `json:"instance-id"`
+	Hostname   string    `json:"hostname"`
+	Version    string    `json:"version"`
+	Role       string    `json:"role"`
+	Mode       string    `json:"mode"` // the service of the instance e.g. signing, admin, sync
+	Started    time.Time `json:"started"`
+	Heartbeat  time.Time `json:"heartbeat"`
+}
+
+// CreateInstanceTable creates the database table for the instance registry
+func (db *DB) CreateInstanceTable() error {
+	if _, err := db.Exec(createInstanceTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createInstanceUniqueIndexSQL)
+	return err
+}
+
+// RegisterInstance records the instance in the registry, updating its heartbeat
+func (db *DB) RegisterInstance(instance Instance) error {
+	if err := ValidateInstance(instance); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(upsertInstanceSQL, instance.InstanceID, instance.Mode, instance.Hostname, instance.Version, instance.Role, instance.Started)
+	if err != nil {
+		log.Printf("Error registering the instance: %v\n", err)
+	}
+	return err
+}
+
+// ListInstances returns the registered instances
+func (db *DB) ListInstances() ([]Instance, error) {
+	rows, err := db.Query(listInstancesSQL)
+	if err != nil {
+		log.Printf("Error retrieving the instances: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	instances := []Instance{}
+	for rows.Next() {
+		i := Instance{}
+		err := rows.Scan(&i.ID, &i.InstanceID, &i.Hostname, &i.Version, &i.Role, &i.Mode, &i.Started, &i.Heartbeat)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, i)
+	}
+	return instances, rows.Err()
+}
+
+// ValidateInstance checks the identity and role of an instance
+func ValidateInstance(instance Instance) error {
+	if err := validateNotEmpty("instance ID", instance.InstanceID); err != nil {
+		return err
+	}
+
+	switch instance.Role {
+	case InstanceRoleCloud, InstanceRoleFactory, InstanceRoleProxy:
+		return nil
+	default:
+		return errors.New("The role must be one of: cloud, factory or proxy")
+	}
+}
@@ -0,0 +1,44 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"log"
+)
+
+// InvalidationChannel is the channel of the Postgres notifications that broadcast the changes of
+// the models, signing-keys and substores to the instances that share the database
+const InvalidationChannel = "serial_vault_invalidation"
+
+const notifyInvalidationSQL = "SELECT pg_notify($1, $2)"
+
+// NotifyInvalidation sends the invalidation event to the instances that listen on the
+// invalidation channel. The local sqlite database of the factory is not shared, so nothing is sent
+func (db *DB) NotifyInvalidation(payload string) error {
+	if usesSQLite() {
+		return nil
+	}
+
+	_, err := db.Exec(notifyInvalidationSQL, InvalidationChannel, payload)
+	if err != nil {
+		log.Printf("Error sending the invalidation event: %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+// attestationPrefix separates the challenge digest of a proof-of-possession from the
+// digest of a snap, as a snap file cannot start with this prefix
+const attestationPrefix = "serial-vault-key-attestation:"
+
+// KeyAttestation is the attestation of where the private key of a signing-key resides,
+// with a proof that the vault holds the key
+type KeyAttestation struct {
+	AuthorityID     string     `json:"authority-id"`
+	KeyID           string     `json:"key-id"`
+	KeyName         string     `json:"key-name"`
+	Active          bool       `json:"active"`
+	Backend         string     `json:"backend"`
+	Location        string     `json:"location"`
+	SealedKeySHA256 string     `json:"sealed-key-sha256,omitempty"`
+	Created         *time.Time `json:"created,omitempty"`
+	Proof           string     `json:"proof,omitempty"`
+	ProofError      string     `json:"proof-error,omitempty"`
+}
+
+// AttestKeypair describes where the private key of the keypair resides and, when a
+// challenge is given, proves possession of the key by signing a snap-build assertion
+// over the digest of the challenge. The creation time is the registration time of the
+// account-key assertion
+func (kdb *KeypairDatabase) AttestKeypair(ctx context.Context, keypair Keypair, challenge string) KeyAttestation {
+	attestation := KeyAttestation{
+		AuthorityID: keypair.AuthorityID,
+		KeyID:       keypair.KeyID,
+		KeyName:     keypair.KeyName,
+		Active:      keypair.Active,
+		Backend:     kdb.KeyStoreType.Name,
+		Location:    kdb.keyLocation(keypair),
+		Created:     keyCreated(keypair),
+	}
+
+	if len(keypair.SealedKey) > 0 {
+		digest := sha256.Sum256([]byte(keypair.SealedKey))
+		attestation.SealedKeySHA256 = hex.EncodeToString(digest[:])
+	}
+
+	if len(challenge) == 0 {
+		return attestation
+	}
+
+	proof, err := kdb.provePossession(ctx, keypair, challenge)
+	if err != nil {
+		attestation.ProofError = err.Error()
+		return attestation
+	}
+	attestation.Proof = proof
+	return attestation
+}
+
+// keyLocation describes where the private key resides in the keystore
+func (kdb *KeypairDatabase) keyLocation(keypair Keypair) string {
+	switch kdb.KeyStoreType.Name {
+	case DatabaseStore.Name:
+		return fmt.Sprintf("Sealed blob in the keypair table (id %d), encrypted with a key derived from the keystore secret", keypair.ID)
+
+	case TPM20Store.Name:
+		return fmt.Sprintf("Sealed blob in the keypair table (id %d), encrypted with an HMAC from TPM 2.0 handle %s", keypair.ID, handleHash)
+
+	case FilesystemStore.Name:
+		return filepath.Join(Environ.Config.KeyStorePath, fsSealedKeysDir, keypair.KeyID)
+
+	default:
+		return "Memory store of the service"
+	}
+}
+
+// provePossession signs a snap-build assertion over the digest of the challenge
+func (kdb *KeypairDatabase) provePossession(ctx context.Context, keypair Keypair, challenge string) (string, error) {
+	content := []byte(attestationPrefix + challenge)
+
+	h := crypto.SHA3_384.New()
+	h.Write(content)
+	digest, err := asserts.EncodeDigest(crypto.SHA3_384, h.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+
+	headers := map[string]interface{}{
+		"authority-id":  keypair.AuthorityID,
+		"developer-id":  keypair.AuthorityID,
+		"snap-sha3-384": digest,
+		"snap-size":     strconv.Itoa(len(content)),
+		"grade":         "devel",
+		"timestamp":     time.Now().UTC().Format(time.RFC3339),
+	}
+
+	signed, err := kdb.SignAssertion(ctx, nil, asserts.SnapBuildType, headers, nil, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
+	if err != nil {
+		return "", err
+	}
+	return string(asserts.Encode(signed)), nil
+}
+
+// keyCreated returns the time the signing-key was registered, from its account-key assertion
+func keyCreated(keypair Keypair) *time.Time {
+	if len(keypair.Assertion) == 0 {
+		return nil
+	}
+
+	assertion, err := asserts.Decode([]byte(keypair.Assertion))
+	if err != nil || assertion == nil || assertion.Type() != asserts.AccountKeyType {
+		return nil
+	}
+
+	since, err := time.Parse(time.RFC3339, assertion.HeaderString("since"))
+	if err != nil {
+		return nil
+	}
+	return &since
+}
+
+// KeypairExpiry returns the time the signing-key expires, from the until header of its
+// account-key assertion. A signing-key without an expiry returns nil
+func KeypairExpiry(keypair Keypair) *time.Time {
+	if len(keypair.Assertion) == 0 {
+		return nil
+	}
+
+	assertion, err := asserts.Decode([]byte(keypair.Assertion))
+	if err != nil || assertion == nil || assertion.Type() != asserts.AccountKeyType {
+		return nil
+	}
+
+	until, err := time.Parse(time.RFC3339, assertion.HeaderString("until"))
+	if err != nil {
+		return nil
+	}
+	return &until
+}
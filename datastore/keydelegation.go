@@ -0,0 +1,626 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/snapcore/snapd/asserts"
+	"golang.org/x/crypto/openpgp"
+)
+
+const createBrandRootKeyTableSQL = `
+	CREATE TABLE IF NOT EXISTS brandrootkey (
+		id               serial primary key not null,
+		authority_id     varchar(200) not null unique,
+		fingerprint      varchar(200) not null,
+		public_key       text not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+const createKeyDelegationTableSQL = `
+	CREATE TABLE IF NOT EXISTS keydelegation (
+		id               serial primary key not null,
+		authority_id     varchar(200) not null,
+		key_id           varchar(200) not null,
+		fingerprint      varchar(200) not null,
+		models           text not null,
+		not_before       timestamp not null,
+		expires          timestamp not null,
+		document         text not null,
+		signature        text not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+// Indexes
+const createKeyDelegationKeyIndexSQL = "CREATE INDEX IF NOT EXISTS keydelegation_key_idx ON keydelegation (key_id)"
+
+const getBrandRootKeySQL = "SELECT authority_id, fingerprint, public_key, created FROM brandrootkey WHERE authority_id=$1"
+const createBrandRootKeySQL = "INSERT INTO brandrootkey (authority_id, fingerprint, public_key) VALUES ($1,$2,$3)"
+const deleteBrandRootKeySQL = "DELETE FROM brandrootkey WHERE authority_id=$1"
+
+const listBrandRootKeysSQL = "SELECT authority_id, fingerprint, public_key, created FROM brandrootkey"
+const listBrandRootKeysForUserSQL = `
+	WHERE EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=brandrootkey.authority_id and u.username=$1
+	)`
+
+// The root keys and their delegations are synced from the cloud to the factory
+const syncDeleteBrandRootKeysSQL = "DELETE FROM brandrootkey"
+const syncDeleteKeyDelegationsSQL = "DELETE FROM keydelegation"
+const syncBrandRootKeySQL = "INSERT INTO brandrootkey (id, authority_id, fingerprint, public_key, created) VALUES ($1,$2,$3,$4,$5)"
+const syncKeyDelegationSQL = `
+	INSERT INTO keydelegation (id, authority_id, key_id, fingerprint, models, not_before, expires, document, signature, created)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`
+
+const listKeyDelegationsSQL = `
+	SELECT id, authority_id, key_id, fingerprint, models, not_before, expires, document, signature, created
+	FROM keydelegation
+	WHERE authority_id=$1
+	ORDER BY key_id, id`
+
+const listKeyDelegationsByKeyIDSQL = `
+	SELECT id, authority_id, key_id, fingerprint, models, not_before, expires, document, signature, created
+	FROM keydelegation
+	WHERE key_id=$1
+	ORDER BY id`
+
+const getKeyDelegationAuthoritySQL = "SELECT authority_id FROM keydelegation WHERE id=$1"
+
+const createKeyDelegationSQL = `
+	INSERT INTO keydelegation (authority_id, key_id, fingerprint, models, not_before, expires, document, signature)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	RETURNING id`
+
+const deleteKeyDelegationSQL = "DELETE FROM keydelegation WHERE id=$1"
+
+// BrandRootKey is the public part of the offline root key of a brand. When a brand has a root key,
+// the signing-keys of the vault only sign the assertions of the brand for the models that the
+// root key delegated to them
+type BrandRootKey struct {
+	AuthorityID string    `json:"authority-id"`
+	Fingerprint string    `json:"fingerprint"`
+	PublicKey   string    `json:"public-key"`
+	Created     time.Time `json:"created"`
+}
+
+// KeyDelegation is the delegation of the models of a brand to a signing-key of the vault, signed
+// by the offline root key of the brand. The document is the JSON text that was signed and the
+// signature is the ASCII-armored, detached OpenPGP signature of the document
+type KeyDelegation struct {
+	ID          int            `json:"id"`
+	AuthorityID string         `json:"authority-id"`
+	KeyID       string         `json:"key-id"`
+	Fingerprint string         `json:"fingerprint"`
+	Models      []KeypairModel `json:"models"`
+	NotBefore   time.Time      `json:"not-before"`
+	Expires     time.Time      `json:"expires"`
+	Document    string         `json:"document"`
+	Signature   string         `json:"signature"`
+	Created     time.Time      `json:"created"`
+}
+
+// delegationDocument is the signed content of a key delegation
+type delegationDocument struct {
+	AuthorityID string         `json:"authority-id"`
+	KeyID       string         `json:"key-id"`
+	Models      []KeypairModel `json:"models"`
+	NotBefore   time.Time      `json:"not-before"`
+	Expires     time.Time      `json:"expires"`
+}
+
+// RootKeyRevocation is the revocation of the root key of a brand, signed by that root key. The
+// document is the JSON text that was signed and the signature is the ASCII-armored, detached
+// OpenPGP signature of the document
+type RootKeyRevocation struct {
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+}
+
+// revocationDocument is the signed content of a root key revocation. The public key replaces the
+// revoked root key, or it is empty to remove the root key of the brand
+type revocationDocument struct {
+	AuthorityID string `json:"authority-id"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public-key"`
+}
+
+// ErrRootKeyChange is the error when the root key of a brand is replaced or removed by a user
+// that is not a superuser, without a revocation signed by the root key
+var ErrRootKeyChange = errors.New("Replacing or removing the root key of a brand needs a superuser, or a revocation signed by the root key")
+
+// Active checks that the delegation is in force at the time
+func (d KeyDelegation) Active(now time.Time) bool {
+	return !now.Before(d.NotBefore) && now.Before(d.Expires)
+}
+
+// ErrKeyNotDelegated is the error when a signing-key is asked to sign an assertion for a model of a
+// brand with a root key, without an active delegation for the model from the root key
+type ErrKeyNotDelegated struct {
+	KeyID   string
+	BrandID string
+	Model   string
+}
+
+func (e ErrKeyNotDelegated) Error() string {
+	return fmt.Sprintf("The signing-key %s has no active delegation from the root key of the brand for the model %s/%s", e.KeyID, e.BrandID, e.Model)
+}
+
+// CreateKeyDelegationTables creates the database tables for the brand root keys and their
+// delegations to the signing-keys
+func (db *DB) CreateKeyDelegationTables() error {
+	if _, err := db.Exec(createBrandRootKeyTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createKeyDelegationTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createKeyDelegationKeyIndexSQL)
+	return err
+}
+
+// GetBrandRootKey returns the root key of the brand, or sql.ErrNoRows when the brand has none
+func (db *DB) GetBrandRootKey(authorityID string) (BrandRootKey, error) {
+	rootKey := BrandRootKey{}
+	err := db.QueryRow(getBrandRootKeySQL, authorityID).Scan(&rootKey.AuthorityID, &rootKey.Fingerprint, &rootKey.PublicKey, &rootKey.Created)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving the brand root key: %v\n", err)
+	}
+	return rootKey, err
+}
+
+// UpdateAllowedBrandRootKey registers the root key of the brand, if the user is authorized for the
+// account. An empty public key removes the root key, so the signing-keys of the brand no longer
+// need a delegation. The delegations of a replaced root key are no longer valid. Replacing or
+// removing the root key needs a superuser, or a revocation signed by the current root key
+func (db *DB) UpdateAllowedBrandRootKey(rootKey BrandRootKey, revocation RootKeyRevocation, authorization User) error {
+	if !db.canWriteAuthority(authorization, rootKey.AuthorityID) {
+		return errors.New("You do not have permissions for that authority")
+	}
+
+	rootKey, err := CanonicalBrandRootKey(rootKey)
+	if err != nil {
+		return err
+	}
+
+	current, err := db.GetBrandRootKey(rootKey.AuthorityID)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	default:
+		if err := CheckRootKeyChange(current, rootKey, revocation, authorization); err != nil {
+			return err
+		}
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteBrandRootKeySQL, rootKey.AuthorityID); err != nil {
+			log.Printf("Error removing the brand root key: %v\n", err)
+			return err
+		}
+		if len(rootKey.PublicKey) == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(createBrandRootKeySQL, rootKey.AuthorityID, rootKey.Fingerprint, rootKey.PublicKey); err != nil {
+			log.Printf("Error storing the brand root key: %v\n", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// ListAllowedBrandRootKeys returns the root keys of the brands of the user e.g. for the factory sync
+func (db *DB) ListAllowedBrandRootKeys(authorization User) ([]BrandRootKey, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		rows, err = db.Query(listBrandRootKeysSQL + " ORDER BY authority_id")
+	case SyncUser:
+		fallthrough
+	case Admin:
+		rows, err = db.Query(listBrandRootKeysSQL+listBrandRootKeysForUserSQL+" ORDER BY authority_id", authorization.Username)
+	default:
+		return []BrandRootKey{}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving the brand root keys: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rootKeys := []BrandRootKey{}
+	for rows.Next() {
+		rootKey := BrandRootKey{}
+		if err := rows.Scan(&rootKey.AuthorityID, &rootKey.Fingerprint, &rootKey.PublicKey, &rootKey.Created); err != nil {
+			return nil, err
+		}
+		rootKeys = append(rootKeys, rootKey)
+	}
+	return rootKeys, rows.Err()
+}
+
+// SyncKeyDelegations replaces the root keys and the delegations of the factory with the ones
+// from the cloud. A delegation that is not signed by the root key of its brand is not stored
+func (db *DB) SyncKeyDelegations(rootKeys []BrandRootKey, delegations []KeyDelegation) error {
+	keys := map[string]BrandRootKey{}
+	for _, k := range rootKeys {
+		keys[k.AuthorityID] = k
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(syncDeleteKeyDelegationsSQL); err != nil {
+			log.Printf("Error removing the key delegations: %v\n", err)
+			return err
+		}
+		if _, err := tx.Exec(syncDeleteBrandRootKeysSQL); err != nil {
+			log.Printf("Error removing the brand root keys: %v\n", err)
+			return err
+		}
+
+		for i, k := range rootKeys {
+			if _, err := tx.Exec(syncBrandRootKeySQL, i+1, k.AuthorityID, k.Fingerprint, k.PublicKey, k.Created.UTC().Format(sqliteTimestampFormat)); err != nil {
+				log.Printf("Error storing the brand root key: %v\n", err)
+				return err
+			}
+		}
+
+		for _, d := range delegations {
+			verified, err := VerifyKeyDelegation(db.modelNameCase, keys[d.AuthorityID], d.Document, d.Signature)
+			if err != nil {
+				log.Printf("Invalid key delegation %d of %s: %v\n", d.ID, d.AuthorityID, err)
+				continue
+			}
+			models, err := json.Marshal(verified.Models)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(syncKeyDelegationSQL, d.ID, verified.AuthorityID, verified.KeyID, verified.Fingerprint, string(models),
+				verified.NotBefore, verified.Expires, verified.Document, verified.Signature, d.Created.UTC().Format(sqliteTimestampFormat))
+			if err != nil {
+				log.Printf("Error storing the key delegation: %v\n", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateAllowedKeyDelegation stores a delegation of the brand root key, if the user is authorized
+// for the account. The signature of the delegation is verified with the root key of the brand, and
+// the delegated signing-key must be in the vault
+func (db *DB) CreateAllowedKeyDelegation(delegation KeyDelegation, authorization User) (int, error) {
+	if !db.canWriteAuthority(authorization, delegation.AuthorityID) {
+		return 0, errors.New("You do not have permissions for that authority")
+	}
+
+	rootKey, err := db.GetBrandRootKey(delegation.AuthorityID)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("The brand does not have a root key")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	delegation, err = VerifyKeyDelegation(db.modelNameCase, rootKey, delegation.Document, delegation.Signature)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := db.GetKeypairByPublicID(delegation.AuthorityID, delegation.KeyID); err != nil {
+		return 0, errors.New("Cannot find the delegated signing-key")
+	}
+
+	models, err := json.Marshal(delegation.Models)
+	if err != nil {
+		return 0, err
+	}
+
+	var createdID int
+	err = db.QueryRow(createKeyDelegationSQL, delegation.AuthorityID, delegation.KeyID, delegation.Fingerprint, string(models),
+		delegation.NotBefore, delegation.Expires, delegation.Document, delegation.Signature).Scan(&createdID)
+	if err != nil {
+		log.Printf("Error storing the key delegation: %v\n", err)
+	}
+	return createdID, err
+}
+
+// ListKeyDelegations returns the delegations of the root key of the brand
+func (db *DB) ListKeyDelegations(authorityID string) ([]KeyDelegation, error) {
+	return db.listKeyDelegations(listKeyDelegationsSQL, authorityID)
+}
+
+// ListKeyDelegationsByKeyID returns the delegations to the signing-key with the key ID
+func (db *DB) ListKeyDelegationsByKeyID(keyID string) ([]KeyDelegation, error) {
+	return db.listKeyDelegations(listKeyDelegationsByKeyIDSQL, keyID)
+}
+
+func (db *DB) listKeyDelegations(query string, args ...interface{}) ([]KeyDelegation, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error retrieving the key delegations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	delegations := []KeyDelegation{}
+	for rows.Next() {
+		d := KeyDelegation{}
+		var models string
+		if err := rows.Scan(&d.ID, &d.AuthorityID, &d.KeyID, &d.Fingerprint, &models, &d.NotBefore, &d.Expires, &d.Document, &d.Signature, &d.Created); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(models), &d.Models); err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, d)
+	}
+	return delegations, rows.Err()
+}
+
+// DeleteAllowedKeyDelegation revokes a delegation of the brand root key, if the user is authorized
+// for the account
+func (db *DB) DeleteAllowedKeyDelegation(delegationID int, authorization User) error {
+	var authorityID string
+	if err := db.QueryRow(getKeyDelegationAuthoritySQL, delegationID).Scan(&authorityID); err != nil {
+		return errors.New("Cannot find the key delegation")
+	}
+	if !db.canWriteAuthority(authorization, authorityID) {
+		return errors.New("You do not have permissions for that authority")
+	}
+
+	if _, err := db.Exec(deleteKeyDelegationSQL, delegationID); err != nil {
+		log.Printf("Error removing the key delegation: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) canWriteAuthority(authorization User, authorityID string) bool {
+	switch authorization.Role {
+	case Invalid, Superuser:
+		return true
+	case Admin:
+		return db.CheckUserInAccount(authorization.Username, authorityID)
+	default:
+		return false
+	}
+}
+
+// CanonicalBrandRootKey validates the root key of a brand, setting the fingerprint of the
+// ASCII-armored OpenPGP public key. An empty public key is valid, to remove the root key
+func CanonicalBrandRootKey(rootKey BrandRootKey) (BrandRootKey, error) {
+	if err := validateNotEmpty("Authority ID", rootKey.AuthorityID); err != nil {
+		return rootKey, err
+	}
+	rootKey.AuthorityID = CanonicalBrandID(rootKey.AuthorityID)
+	rootKey.PublicKey = strings.TrimSpace(rootKey.PublicKey)
+	rootKey.Fingerprint = ""
+	if len(rootKey.PublicKey) == 0 {
+		return rootKey, nil
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(rootKey.PublicKey))
+	if err != nil {
+		return rootKey, fmt.Errorf("Invalid root key: %v", err)
+	}
+	if len(keyring) != 1 {
+		return rootKey, errors.New("Invalid root key: expected a single public key")
+	}
+	if keyring[0].PrivateKey != nil {
+		return rootKey, errors.New("Invalid root key: the private key must stay offline")
+	}
+
+	rootKey.Fingerprint = fmt.Sprintf("%X", keyring[0].PrimaryKey.Fingerprint)
+	return rootKey, nil
+}
+
+// ReplacesRootKey checks if the root key replaces or removes the current root key of the brand
+func ReplacesRootKey(current, rootKey BrandRootKey) bool {
+	return current.Fingerprint != rootKey.Fingerprint
+}
+
+// CheckRootKeyChange checks that the user can replace or remove the current root key of the
+// brand: a superuser can, and any user of the brand with a revocation signed by the current
+// root key. Registering the same root key again is not a change
+func CheckRootKeyChange(current, rootKey BrandRootKey, revocation RootKeyRevocation, authorization User) error {
+	if !ReplacesRootKey(current, rootKey) {
+		return nil
+	}
+	if len(revocation.Signature) > 0 {
+		return VerifyRootKeyRevocation(current, rootKey, revocation)
+	}
+
+	switch authorization.Role {
+	case Invalid, Superuser:
+		return nil
+	default:
+		return ErrRootKeyChange
+	}
+}
+
+// VerifyRootKeyRevocation verifies that the revocation is signed by the current root key of the
+// brand, and that it revokes that root key in favour of the new one (or of none)
+func VerifyRootKeyRevocation(current, rootKey BrandRootKey, revocation RootKeyRevocation) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(current.PublicKey))
+	if err != nil {
+		return fmt.Errorf("Invalid root key: %v", err)
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(revocation.Document), strings.NewReader(revocation.Signature)); err != nil {
+		return fmt.Errorf("The revocation is not signed by the root key of the brand: %v", err)
+	}
+
+	doc := revocationDocument{}
+	if err := decodeSignedDocument(revocation.Document, &doc, "authority-id", "fingerprint", "public-key"); err != nil {
+		return fmt.Errorf("Invalid revocation document: %v", err)
+	}
+
+	if CanonicalBrandID(doc.AuthorityID) != current.AuthorityID {
+		return errors.New("The revocation is for another brand")
+	}
+	if !strings.EqualFold(strings.TrimSpace(doc.Fingerprint), current.Fingerprint) {
+		return errors.New("The revocation is for another root key")
+	}
+	if strings.TrimSpace(doc.PublicKey) != rootKey.PublicKey {
+		return errors.New("The revocation is for another replacement of the root key")
+	}
+	return nil
+}
+
+// decodeSignedDocument decodes a signed JSON document, which cannot have other fields than the
+// ones that are expected
+func decodeSignedDocument(document string, v interface{}, fields ...string) error {
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f] = true
+	}
+
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(document), &values); err != nil {
+		return err
+	}
+	for f := range values {
+		if !known[f] {
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+	return json.Unmarshal([]byte(document), v)
+}
+
+// VerifyKeyDelegation verifies the detached signature of the delegation document with the root
+// key of the brand, returning the delegation with its canonical models
+func VerifyKeyDelegation(modelNameCase []config.ModelNameCase, rootKey BrandRootKey, document, signature string) (KeyDelegation, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(rootKey.PublicKey))
+	if err != nil {
+		return KeyDelegation{}, fmt.Errorf("Invalid root key: %v", err)
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(document), strings.NewReader(signature)); err != nil {
+		return KeyDelegation{}, fmt.Errorf("The delegation is not signed by the root key of the brand: %v", err)
+	}
+
+	doc := delegationDocument{}
+	if err := decodeSignedDocument(document, &doc, "authority-id", "key-id", "models", "not-before", "expires"); err != nil {
+		return KeyDelegation{}, fmt.Errorf("Invalid delegation document: %v", err)
+	}
+
+	if CanonicalBrandID(doc.AuthorityID) != rootKey.AuthorityID {
+		return KeyDelegation{}, errors.New("The delegation is for another brand")
+	}
+	if err := validateNotEmpty("Key ID", doc.KeyID); err != nil {
+		return KeyDelegation{}, err
+	}
+	if len(doc.Models) == 0 {
+		return KeyDelegation{}, errors.New("The delegation must have at least one model")
+	}
+	for _, m := range doc.Models {
+		if CanonicalBrandID(m.BrandID) != rootKey.AuthorityID {
+			return KeyDelegation{}, fmt.Errorf("The delegation cannot be for a model of another brand: %s", m.BrandID)
+		}
+	}
+	models, err := CanonicalKeypairModels(modelNameCase, doc.Models)
+	if err != nil {
+		return KeyDelegation{}, err
+	}
+	if doc.NotBefore.IsZero() || doc.Expires.IsZero() || !doc.Expires.After(doc.NotBefore) {
+		return KeyDelegation{}, errors.New("The delegation must expire after it starts")
+	}
+
+	return KeyDelegation{
+		AuthorityID: rootKey.AuthorityID,
+		KeyID:       strings.TrimSpace(doc.KeyID),
+		Fingerprint: rootKey.Fingerprint,
+		Models:      models,
+		NotBefore:   doc.NotBefore.UTC(),
+		Expires:     doc.Expires.UTC(),
+		Document:    document,
+		Signature:   signature,
+	}, nil
+}
+
+// CheckKeyDelegation checks that one of the delegations of the current root key of the brand is
+// active and covers the brand/model
+func CheckKeyDelegation(modelNameCase []config.ModelNameCase, rootKey BrandRootKey, delegations []KeyDelegation, keyID, brandID, model string, now time.Time) error {
+	m := KeypairModel{BrandID: CanonicalBrandID(brandID), Model: CanonicalModelName(modelNameCase, brandID, model)}
+	for _, d := range delegations {
+		if d.AuthorityID != rootKey.AuthorityID || d.Fingerprint != rootKey.Fingerprint || !d.Active(now) {
+			continue
+		}
+		for _, dm := range d.Models {
+			if dm == m {
+				return nil
+			}
+		}
+	}
+	return ErrKeyNotDelegated{KeyID: keyID, BrandID: brandID, Model: model}
+}
+
+// checkAssertionDelegation enforces the delegations of the brand root key on the assertion that a
+// signing-key is about to sign. The assertions of a brand without a root key are not checked. The
+// signing is refused when the root key or the delegations cannot be read
+func checkAssertionDelegation(db Datastore, modelNameCase []config.ModelNameCase, assertType *asserts.AssertionType, headers map[string]interface{}, keyID string) error {
+	models := assertionModels(assertType, headers)
+	if len(models) == 0 {
+		return nil
+	}
+
+	authorityID, _ := headers["authority-id"].(string)
+	if db == nil {
+		return fmt.Errorf("Cannot check the root key of the brand %s without the datastore", authorityID)
+	}
+	rootKey, err := db.GetBrandRootKey(CanonicalBrandID(authorityID))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot check the root key of the brand %s: %v", authorityID, err)
+	}
+
+	delegations, err := db.ListKeyDelegationsByKeyID(keyID)
+	if err != nil {
+		return fmt.Errorf("Cannot check the delegations of the signing-key %s: %v", keyID, err)
+	}
+
+	brandID, _ := headers["brand-id"].(string)
+	now := time.Now()
+	for _, model := range models {
+		if err := CheckKeyDelegation(modelNameCase, rootKey, delegations, keyID, brandID, model, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
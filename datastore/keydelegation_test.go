@@ -0,0 +1,260 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// delegationDB is the mock database with a root key for the system brand
+type delegationDB struct {
+	MockDB
+	rootKey     BrandRootKey
+	delegations []KeyDelegation
+}
+
+func (db *delegationDB) GetBrandRootKey(authorityID string) (BrandRootKey, error) {
+	if authorityID != db.rootKey.AuthorityID {
+		return BrandRootKey{}, sql.ErrNoRows
+	}
+	return db.rootKey, nil
+}
+
+func (db *delegationDB) ListKeyDelegationsByKeyID(keyID string) ([]KeyDelegation, error) {
+	return db.delegations, nil
+}
+
+// generateRootKey generates an offline root key for the brand, returning the entity to sign the
+// delegations and the registered public key
+func generateRootKey(t *testing.T, authorityID string) (*openpgp.Entity, BrandRootKey) {
+	entity, err := openpgp.NewEntity("root", "", "root@example.com", nil)
+	if err != nil {
+		t.Fatalf("Error generating the root key: %v", err)
+	}
+
+	// The self-signatures of a new entity are only signed when its private key is serialized
+	if err := entity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatalf("Error signing the root key: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Error armoring the root key: %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("Error serializing the root key: %v", err)
+	}
+	w.Close()
+
+	rootKey, err := CanonicalBrandRootKey(BrandRootKey{AuthorityID: authorityID, PublicKey: buf.String()})
+	if err != nil {
+		t.Fatalf("Expected the root key to be valid, got %v", err)
+	}
+	return entity, rootKey
+}
+
+func signDelegation(t *testing.T, entity *openpgp.Entity, document string) string {
+	buf := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(buf, entity, strings.NewReader(document), nil); err != nil {
+		t.Fatalf("Error signing the delegation: %v", err)
+	}
+	return buf.String()
+}
+
+const delegationDocumentJSON = `{"authority-id": "system", "key-id": "key1", "models": [{"brand-id": "system", "model": "Alder"}], "not-before": "2020-01-01T00:00:00Z", "expires": "2030-01-01T00:00:00Z"}`
+
+func TestCanonicalBrandRootKey(t *testing.T) {
+	Environ = nil
+	_, rootKey := generateRootKey(t, " system")
+
+	if rootKey.AuthorityID != "system" || len(rootKey.Fingerprint) != 40 {
+		t.Errorf("Expected the canonical root key with its fingerprint, got %v/%v", rootKey.AuthorityID, rootKey.Fingerprint)
+	}
+
+	if r, err := CanonicalBrandRootKey(BrandRootKey{AuthorityID: "system"}); err != nil || r.Fingerprint != "" {
+		t.Errorf("Expected an empty root key to be valid, got %v", err)
+	}
+	if _, err := CanonicalBrandRootKey(BrandRootKey{AuthorityID: "system", PublicKey: "invalid"}); err == nil {
+		t.Error("Expected an error for an invalid root key")
+	}
+	if _, err := CanonicalBrandRootKey(BrandRootKey{PublicKey: rootKey.PublicKey}); err == nil {
+		t.Error("Expected an error for an empty authority")
+	}
+}
+
+func TestVerifyKeyDelegation(t *testing.T) {
+	Environ = nil
+	entity, rootKey := generateRootKey(t, "system")
+	other, _ := generateRootKey(t, "system")
+
+	delegation, err := VerifyKeyDelegation(nil, rootKey, delegationDocumentJSON, signDelegation(t, entity, delegationDocumentJSON))
+	if err != nil {
+		t.Fatalf("Expected the delegation to be valid, got %v", err)
+	}
+	if delegation.KeyID != "key1" || delegation.Fingerprint != rootKey.Fingerprint || len(delegation.Models) != 1 || delegation.Models[0].Model != "alder" {
+		t.Errorf("Expected the canonical delegation, got %v", delegation)
+	}
+
+	tests := []struct {
+		name     string
+		document string
+		signer   *openpgp.Entity
+	}{
+		{"other signer", delegationDocumentJSON, other},
+		{"other brand", strings.Replace(delegationDocumentJSON, `"authority-id": "system"`, `"authority-id": "other"`, 1), entity},
+		{"other brand model", strings.Replace(delegationDocumentJSON, `"brand-id": "system"`, `"brand-id": "other"`, 1), entity},
+		{"no models", strings.Replace(delegationDocumentJSON, `[{"brand-id": "system", "model": "Alder"}]`, `[]`, 1), entity},
+		{"no key", strings.Replace(delegationDocumentJSON, `"key1"`, `""`, 1), entity},
+		{"expires before start", strings.Replace(delegationDocumentJSON, "2030", "2019", 1), entity},
+		{"unknown field", strings.Replace(delegationDocumentJSON, `"key-id"`, `"serial": "A1", "key-id"`, 1), entity},
+	}
+
+	for _, tt := range tests {
+		if _, err := VerifyKeyDelegation(nil, rootKey, tt.document, signDelegation(t, tt.signer, tt.document)); err == nil {
+			t.Errorf("%s: expected the delegation to be invalid", tt.name)
+		}
+	}
+
+	// The signature covers the exact document
+	tampered := strings.Replace(delegationDocumentJSON, "Alder", "Ash", 1)
+	if _, err := VerifyKeyDelegation(nil, rootKey, tampered, signDelegation(t, entity, delegationDocumentJSON)); err == nil {
+		t.Error("Expected an error for a tampered delegation")
+	}
+}
+
+func TestCheckKeyDelegation(t *testing.T) {
+	Environ = nil
+	rootKey := BrandRootKey{AuthorityID: "system", Fingerprint: "F1"}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	delegation := KeyDelegation{
+		AuthorityID: "system", KeyID: "key1", Fingerprint: "F1",
+		Models:    []KeypairModel{{BrandID: "system", Model: "alder"}},
+		NotBefore: now.Add(-time.Hour), Expires: now.Add(time.Hour),
+	}
+	replaced := delegation
+	replaced.Fingerprint = "F0"
+
+	tests := []struct {
+		name        string
+		delegations []KeyDelegation
+		model       string
+		now         time.Time
+		allowed     bool
+	}{
+		{"delegated", []KeyDelegation{delegation}, "Alder", now, true},
+		{"other model", []KeyDelegation{delegation}, "ash", now, false},
+		{"not started", []KeyDelegation{delegation}, "alder", now.Add(-2 * time.Hour), false},
+		{"expired", []KeyDelegation{delegation}, "alder", now.Add(time.Hour), false},
+		{"replaced root key", []KeyDelegation{replaced}, "alder", now, false},
+		{"no delegations", nil, "alder", now, false},
+	}
+
+	for _, tt := range tests {
+		err := CheckKeyDelegation(nil, rootKey, tt.delegations, "key1", "system", tt.model, tt.now)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed %v, got %v", tt.name, tt.allowed, err)
+		}
+		if _, ok := err.(ErrKeyNotDelegated); err != nil && !ok {
+			t.Errorf("%s: expected the not-delegated error, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestCheckAssertionDelegation(t *testing.T) {
+	rootKey := BrandRootKey{AuthorityID: "system", Fingerprint: "F1"}
+	db := &delegationDB{rootKey: rootKey, delegations: []KeyDelegation{{
+		AuthorityID: "system", KeyID: "key1", Fingerprint: "F1",
+		Models:    []KeypairModel{{BrandID: "system", Model: "alder"}},
+		NotBefore: time.Now().Add(-time.Hour), Expires: time.Now().Add(time.Hour),
+	}}}
+
+	tests := []struct {
+		db        Datastore
+		assertion *asserts.AssertionType
+		headers   map[string]interface{}
+		allowed   bool
+	}{
+		{db, asserts.SerialType, map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "alder"}, true},
+		{db, asserts.SerialType, map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "ash"}, false},
+		{db, asserts.SystemUserType, map[string]interface{}{"authority-id": "system", "brand-id": "system", "models": []interface{}{"alder", "ash"}}, false},
+		{db, asserts.SerialType, map[string]interface{}{"authority-id": "other", "brand-id": "other", "model": "ash"}, true},
+		{db, asserts.SnapBuildType, map[string]interface{}{"authority-id": "system"}, true},
+		{&MockDB{}, asserts.SerialType, map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "ash"}, true},
+		{&ErrorMockDB{}, asserts.SerialType, map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "alder"}, false},
+		{nil, asserts.SerialType, map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "alder"}, false},
+		{nil, asserts.SnapBuildType, map[string]interface{}{"authority-id": "system"}, true},
+	}
+
+	for _, tt := range tests {
+		err := checkAssertionDelegation(tt.db, nil, tt.assertion, tt.headers, "key1")
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s %v: expected allowed %v, got %v", tt.assertion.Name, tt.headers, tt.allowed, err)
+		}
+	}
+}
+
+func TestCheckRootKeyChange(t *testing.T) {
+	Environ = nil
+	entity, current := generateRootKey(t, "system")
+	other, replacement := generateRootKey(t, "system")
+
+	revocation := func(signer *openpgp.Entity, fingerprint, publicKey string) RootKeyRevocation {
+		document := `{"authority-id": "system", "fingerprint": "` + fingerprint + `", "public-key": ` + strconv.Quote(publicKey) + `}`
+		return RootKeyRevocation{Document: document, Signature: signDelegation(t, signer, document)}
+	}
+	removed := BrandRootKey{AuthorityID: "system"}
+	admin := User{Username: "sv", Role: Admin}
+
+	tests := []struct {
+		name          string
+		rootKey       BrandRootKey
+		revocation    RootKeyRevocation
+		authorization User
+		valid         bool
+	}{
+		{"same key", current, RootKeyRevocation{}, admin, true},
+		{"replace by admin", replacement, RootKeyRevocation{}, admin, false},
+		{"remove by admin", removed, RootKeyRevocation{}, admin, false},
+		{"replace by superuser", replacement, RootKeyRevocation{}, User{Username: "root", Role: Superuser}, true},
+		{"replace with revocation", replacement, revocation(entity, current.Fingerprint, replacement.PublicKey), admin, true},
+		{"remove with revocation", removed, revocation(entity, current.Fingerprint, ""), admin, true},
+		{"revocation by other key", replacement, revocation(other, current.Fingerprint, replacement.PublicKey), admin, false},
+		{"revocation of other key", replacement, revocation(entity, replacement.Fingerprint, replacement.PublicKey), admin, false},
+		{"revocation for other replacement", removed, revocation(entity, current.Fingerprint, replacement.PublicKey), admin, false},
+	}
+
+	for _, tt := range tests {
+		err := CheckRootKeyChange(current, tt.rootKey, tt.revocation, tt.authorization)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got: %v", tt.name, tt.valid, err)
+		}
+	}
+}
@@ -20,7 +20,10 @@
 
 package datastore
 
-import "errors"
+import (
+	"database/sql"
+	"errors"
+)
 
 // ListAllowedKeypairs return the list of keypairs allowed to the user
 func (db *DB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
@@ -38,8 +41,19 @@ func (db *DB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
 	}
 }
 
-// UpdateAllowedKeypairActive updates active enable/disable flag if user is authorized
+// UpdateAllowedKeypairActive updates active enable/disable flag if user is authorized. A
+// compromised signing-key cannot be enabled
 func (db *DB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error {
+	if active {
+		_, err := db.GetKeypairCompromise(keypairID)
+		switch {
+		case err == nil:
+			return ErrKeypairCompromised
+		case err != sql.ErrNoRows:
+			return err
+		}
+	}
+
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
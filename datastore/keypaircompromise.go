@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const createKeypairCompromiseTableSQL = `
+	CREATE TABLE IF NOT EXISTS keypaircompromise (
+		id               serial primary key not null,
+		keypair_id       int references keypair not null unique,
+		reason           text default '',
+		reported_by      varchar(200) default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const getKeypairCompromiseSQL = `
+	SELECT c.id, c.keypair_id, k.authority_id, k.key_id, c.reason, c.reported_by, c.created
+	FROM keypaircompromise c
+	INNER JOIN keypair k ON k.id=c.keypair_id
+	WHERE c.keypair_id=$1`
+
+const listKeypairCompromisesSQL = `
+	SELECT c.id, c.keypair_id, k.authority_id, k.key_id, c.reason, c.reported_by, c.created
+	FROM keypaircompromise c
+	INNER JOIN keypair k ON k.id=c.keypair_id
+	ORDER BY c.id`
+
+const countKeypairCompromiseSQL = "SELECT count(*) FROM keypaircompromise WHERE keypair_id=$1"
+const createKeypairCompromiseSQL = "INSERT INTO keypaircompromise (keypair_id, reason, reported_by) VALUES ($1,$2,$3)"
+
+// The signer is stored as JSON text, so the entries are matched on the key ID and then checked
+const listSigningLogForKeypairSQL = "SELECT * FROM signinglog WHERE fallback_key=$1 OR signer LIKE $2 ORDER BY id"
+
+// KeypairCompromise records that a signing-key was compromised. A compromised signing-key is
+// inactive and cannot be enabled again, and it is revoked on the factories by the sync
+type KeypairCompromise struct {
+	ID          int       `json:"id"`
+	KeypairID   int       `json:"keypair-id"`
+	AuthorityID string    `json:"authority-id"`
+	KeyID       string    `json:"key-id"`
+	Reason      string    `json:"reason"`
+	ReportedBy  string    `json:"reported-by"`
+	Created     time.Time `json:"created"`
+}
+
+// ErrKeypairCompromised is the error when a compromised signing-key is enabled
+var ErrKeypairCompromised = errors.New("The signing-key is compromised and cannot be enabled")
+
+// CreateKeypairCompromiseTable creates the database table for the compromised signing-keys
+func (db *DB) CreateKeypairCompromiseTable() error {
+	_, err := db.Exec(createKeypairCompromiseTableSQL)
+	return err
+}
+
+// CompromiseAllowedKeypair deactivates the signing-key and records that it is compromised, in
+// one transaction, if the user is authorized for the account of the signing-key. Compromising a
+// signing-key again returns the existing record
+func (db *DB) CompromiseAllowedKeypair(keypairID int, reason string, authorization User) (KeypairCompromise, error) {
+	keypair, err := db.GetKeypair(keypairID)
+	if err != nil {
+		return KeypairCompromise{}, errors.New("Cannot find the signing-key")
+	}
+	if !db.canWriteAuthority(authorization, keypair.AuthorityID) {
+		return KeypairCompromise{}, errors.New("You do not have permissions for that authority")
+	}
+
+	err = db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(toggleKeypairSQL, keypairID, false); err != nil {
+			log.Printf("Error deactivating the compromised signing-key: %v\n", err)
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRow(countKeypairCompromiseSQL, keypairID).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		if _, err := tx.Exec(createKeypairCompromiseSQL, keypairID, strings.TrimSpace(reason), authorization.Username); err != nil {
+			log.Printf("Error recording the compromised signing-key: %v\n", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return KeypairCompromise{}, err
+	}
+
+	return db.GetKeypairCompromise(keypairID)
+}
+
+// GetKeypairCompromise returns the compromise record of the signing-key, or sql.ErrNoRows when
+// the signing-key is not compromised
+func (db *DB) GetKeypairCompromise(keypairID int) (KeypairCompromise, error) {
+	c := KeypairCompromise{}
+	err := db.QueryRow(getKeypairCompromiseSQL, keypairID).Scan(&c.ID, &c.KeypairID, &c.AuthorityID, &c.KeyID, &c.Reason, &c.ReportedBy, &c.Created)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving the compromised signing-key: %v\n", err)
+	}
+	return c, err
+}
+
+// ListKeypairCompromises returns the compromised signing-keys
+func (db *DB) ListKeypairCompromises() ([]KeypairCompromise, error) {
+	rows, err := db.Query(listKeypairCompromisesSQL)
+	if err != nil {
+		log.Printf("Error retrieving the compromised signing-keys: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	compromises := []KeypairCompromise{}
+	for rows.Next() {
+		c := KeypairCompromise{}
+		if err := rows.Scan(&c.ID, &c.KeypairID, &c.AuthorityID, &c.KeyID, &c.Reason, &c.ReportedBy, &c.Created); err != nil {
+			return nil, err
+		}
+		compromises = append(compromises, c)
+	}
+	return compromises, rows.Err()
+}
+
+// ListSigningLogForKeypair returns the signing log entries of the devices that were signed by the
+// signing-key, as the signer or as a fallback signing-key. The entries that do not record their
+// signer are not included
+func (db *DB) ListSigningLogForKeypair(keyID string) ([]SigningLog, error) {
+	rows, err := db.Query(listSigningLogForKeypairSQL, keyID, "%"+keyID+"%")
+	if err != nil {
+		log.Printf("Error retrieving signing logs: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	signingLogs := []SigningLog{}
+	for rows.Next() {
+		signingLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return nil, err
+		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
+		if SignedByKeypair(signingLog, keyID) {
+			signingLogs = append(signingLogs, signingLog)
+		}
+	}
+	return signingLogs, rows.Err()
+}
+
+// SignedByKeypair checks that the device of the signing log entry was signed by the signing-key
+func SignedByKeypair(signLog SigningLog, keyID string) bool {
+	if signLog.FallbackKeyID == keyID {
+		return true
+	}
+	return signLog.Signer != nil && signLog.Signer.KeyID == keyID
+}
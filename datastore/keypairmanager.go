@@ -119,10 +119,12 @@ type Keypair struct {
 	KeyName     string
 }
 
-// SyncKeypair is the response to fetch keypairs
+// SyncKeypair is the response to fetch keypairs. A revoked keypair is compromised: it is sent
+// without the sealed key, so the factory deactivates it and removes its sealed key
 type SyncKeypair struct {
 	Keypair
 	AuthKeyHash string
+	Revoked     bool
 }
 
 // CreateKeypairTable creates the database table for a keypair.
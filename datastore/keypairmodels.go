@@ -0,0 +1,238 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/snapcore/snapd/asserts"
+)
+
+const createKeypairModelTableSQL = `
+	CREATE TABLE IF NOT EXISTS keypairmodel (
+		id               serial primary key not null,
+		keypair_id       int references keypair not null,
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null
+	)
+`
+
+// Indexes
+const createKeypairModelUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS keypairmodel_idx ON keypairmodel (keypair_id, brand_id, model)"
+
+const listKeypairModelsSQL = "SELECT brand_id, model FROM keypairmodel WHERE keypair_id=$1 ORDER BY brand_id, model"
+
+const listKeypairModelsByKeyIDSQL = `
+	SELECT a.brand_id, a.model
+	FROM keypairmodel a
+	INNER JOIN keypair k ON k.id=a.keypair_id
+	WHERE k.key_id=$1
+	ORDER BY a.brand_id, a.model`
+
+const createKeypairModelSQL = "INSERT INTO keypairmodel (keypair_id, brand_id, model) VALUES ($1,$2,$3)"
+const deleteKeypairModelsSQL = "DELETE FROM keypairmodel WHERE keypair_id=$1"
+
+// KeypairModel is a brand/model in the allowlist of a signing-key. A signing-key with an allowlist
+// only signs the assertions of its models, whatever the signing-key of the model records
+type KeypairModel struct {
+	BrandID string `json:"brand-id"`
+	Model   string `json:"model"`
+}
+
+// ErrKeypairModelNotAllowed is the error when a signing-key is asked to sign an assertion for a
+// model that is not in its allowlist
+type ErrKeypairModelNotAllowed struct {
+	KeyID   string
+	BrandID string
+	Model   string
+}
+
+func (e ErrKeypairModelNotAllowed) Error() string {
+	return fmt.Sprintf("The signing-key %s is not allowed to sign for the model %s/%s", e.KeyID, e.BrandID, e.Model)
+}
+
+// CreateKeypairModelTable creates the database table for the model allowlists of the signing-keys
+func (db *DB) CreateKeypairModelTable() error {
+	if _, err := db.Exec(createKeypairModelTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createKeypairModelUniqueIndexSQL)
+	return err
+}
+
+// ListKeypairModels returns the model allowlist of the signing-key, which is empty when the
+// signing-key can sign for all the models
+func (db *DB) ListKeypairModels(keypairID int) ([]KeypairModel, error) {
+	return db.listKeypairModels(listKeypairModelsSQL, keypairID)
+}
+
+// ListKeypairModelsByKeyID returns the model allowlist of the signing-key with the key ID
+func (db *DB) ListKeypairModelsByKeyID(keyID string) ([]KeypairModel, error) {
+	return db.listKeypairModels(listKeypairModelsByKeyIDSQL, keyID)
+}
+
+func (db *DB) listKeypairModels(query string, args ...interface{}) ([]KeypairModel, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error retrieving the model allowlist of the signing-key: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	models := []KeypairModel{}
+	for rows.Next() {
+		m := KeypairModel{}
+		if err := rows.Scan(&m.BrandID, &m.Model); err != nil {
+			return nil, err
+		}
+		models = append(models, m)
+	}
+	return models, rows.Err()
+}
+
+// UpdateAllowedKeypairModels replaces the model allowlist of the signing-key, if the user is
+// authorized for the account of the signing-key. An empty allowlist lets the signing-key sign
+// for all the models
+func (db *DB) UpdateAllowedKeypairModels(keypairID int, models []KeypairModel, authorization User) error {
+	keypair, err := db.GetKeypair(keypairID)
+	if err != nil {
+		return errors.New("Cannot find the signing-key")
+	}
+
+	switch authorization.Role {
+	case Invalid, Superuser:
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, keypair.AuthorityID) {
+			return errors.New("You do not have permissions for that authority")
+		}
+	default:
+		return errors.New("You do not have permissions for that authority")
+	}
+
+	models, err = CanonicalKeypairModels(db.modelNameCase, models)
+	if err != nil {
+		return err
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteKeypairModelsSQL, keypairID); err != nil {
+			log.Printf("Error removing the model allowlist of the signing-key: %v\n", err)
+			return err
+		}
+		for _, m := range models {
+			if _, err := tx.Exec(createKeypairModelSQL, keypairID, m.BrandID, m.Model); err != nil {
+				log.Printf("Error storing the model allowlist of the signing-key: %v\n", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CanonicalKeypairModels validates the models of an allowlist, returning them canonicalized and
+// without the duplicates
+func CanonicalKeypairModels(modelNameCase []config.ModelNameCase, models []KeypairModel) ([]KeypairModel, error) {
+	canonical := []KeypairModel{}
+	seen := map[KeypairModel]bool{}
+	for _, m := range models {
+		if err := validateNotEmpty("Brand ID", m.BrandID); err != nil {
+			return nil, err
+		}
+		if err := validateNotEmpty("Model", m.Model); err != nil {
+			return nil, err
+		}
+
+		m = KeypairModel{BrandID: CanonicalBrandID(m.BrandID), Model: CanonicalModelName(modelNameCase, m.BrandID, m.Model)}
+		if !seen[m] {
+			seen[m] = true
+			canonical = append(canonical, m)
+		}
+	}
+	return canonical, nil
+}
+
+// CheckKeypairModel checks that the brand/model is in the allowlist of the signing-key. A
+// signing-key without an allowlist can sign for all the models
+func CheckKeypairModel(modelNameCase []config.ModelNameCase, allowlist []KeypairModel, keyID, brandID, model string) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+
+	m := KeypairModel{BrandID: CanonicalBrandID(brandID), Model: CanonicalModelName(modelNameCase, brandID, model)}
+	for _, a := range allowlist {
+		if a == m {
+			return nil
+		}
+	}
+	return ErrKeypairModelNotAllowed{KeyID: keyID, BrandID: brandID, Model: model}
+}
+
+// checkAssertionModels enforces the model allowlist of the signing-key on the assertion that it
+// is about to sign, using the brand and model headers of the assertion. The assertion types that
+// are not for a model, e.g. snap-build, are not checked. The allowlist is read from the datastore
+// of the signing request, and the signing is refused when it cannot be read
+func checkAssertionModels(db Datastore, modelNameCase []config.ModelNameCase, assertType *asserts.AssertionType, headers map[string]interface{}, keyID string) error {
+	brandID, _ := headers["brand-id"].(string)
+	models := assertionModels(assertType, headers)
+	if len(models) == 0 {
+		return nil
+	}
+
+	if db == nil {
+		return fmt.Errorf("Cannot check the model allowlist of the signing-key %s without the datastore", keyID)
+	}
+	allowlist, err := db.ListKeypairModelsByKeyID(keyID)
+	if err != nil {
+		return fmt.Errorf("Cannot check the model allowlist of the signing-key %s: %v", keyID, err)
+	}
+
+	for _, model := range models {
+		if err := CheckKeypairModel(modelNameCase, allowlist, keyID, brandID, model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assertionModels returns the models that the assertion is for
+func assertionModels(assertType *asserts.AssertionType, headers map[string]interface{}) []string {
+	switch assertType {
+	case asserts.SerialType, asserts.ModelType:
+		if model, ok := headers["model"].(string); ok && len(strings.TrimSpace(model)) > 0 {
+			return []string{model}
+		}
+	case asserts.SystemUserType:
+		models := []string{}
+		if list, ok := headers["models"].([]interface{}); ok {
+			for _, m := range list {
+				if model, ok := m.(string); ok {
+					models = append(models, model)
+				}
+			}
+		}
+		return models
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+// allowlistDB is the mock database with a model allowlist for all the signing-keys
+type allowlistDB struct {
+	MockDB
+	models []KeypairModel
+}
+
+func (db *allowlistDB) ListKeypairModelsByKeyID(keyID string) ([]KeypairModel, error) {
+	return db.models, nil
+}
+
+func TestCheckKeypairModel(t *testing.T) {
+	Environ = nil
+	allowlist := []KeypairModel{{BrandID: "system", Model: "alder"}, {BrandID: "system", Model: "ash"}}
+
+	tests := []struct {
+		allowlist []KeypairModel
+		brandID   string
+		model     string
+		allowed   bool
+	}{
+		{allowlist, "system", "alder", true},
+		{allowlist, " system", "ASH ", true},
+		{allowlist, "system", "birch", false},
+		{allowlist, "other", "alder", false},
+		{nil, "other", "alder", true},
+	}
+
+	for _, tt := range tests {
+		err := CheckKeypairModel(nil, tt.allowlist, "key1", tt.brandID, tt.model)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s/%s: expected allowed %v, got %v", tt.brandID, tt.model, tt.allowed, err)
+		}
+		if _, ok := err.(ErrKeypairModelNotAllowed); err != nil && !ok {
+			t.Errorf("%s/%s: expected the not-allowed error, got %v", tt.brandID, tt.model, err)
+		}
+	}
+}
+
+func TestCanonicalKeypairModels(t *testing.T) {
+	Environ = nil
+
+	models, err := CanonicalKeypairModels(nil, []KeypairModel{{BrandID: " system", Model: "Alder"}, {BrandID: "system", Model: "alder "}, {BrandID: "system", Model: "ash"}})
+	if err != nil {
+		t.Fatalf("Expected the models to be valid, got %v", err)
+	}
+	if len(models) != 2 || models[0] != (KeypairModel{BrandID: "system", Model: "alder"}) {
+		t.Errorf("Expected the canonical models without duplicates, got %v", models)
+	}
+
+	if _, err := CanonicalKeypairModels(nil, []KeypairModel{{BrandID: "system", Model: " "}}); err == nil {
+		t.Error("Expected an error for an empty model")
+	}
+	if _, err := CanonicalKeypairModels(nil, []KeypairModel{{Model: "alder"}}); err == nil {
+		t.Error("Expected an error for an empty brand")
+	}
+}
+
+func TestCheckAssertionModels(t *testing.T) {
+	db := &allowlistDB{models: []KeypairModel{{BrandID: "system", Model: "alder"}}}
+
+	tests := []struct {
+		db        Datastore
+		assertion *asserts.AssertionType
+		headers   map[string]interface{}
+		allowed   bool
+	}{
+		{db, asserts.SerialType, map[string]interface{}{"brand-id": "system", "model": "alder"}, true},
+		{db, asserts.SerialType, map[string]interface{}{"brand-id": "system", "model": "ash"}, false},
+		{db, asserts.ModelType, map[string]interface{}{"brand-id": "other", "model": "alder"}, false},
+		{db, asserts.SystemUserType, map[string]interface{}{"brand-id": "system", "models": []interface{}{"alder"}}, true},
+		{db, asserts.SystemUserType, map[string]interface{}{"brand-id": "system", "models": []interface{}{"alder", "ash"}}, false},
+		{db, asserts.SnapBuildType, map[string]interface{}{"authority-id": "system"}, true},
+		{&MockDB{}, asserts.SerialType, map[string]interface{}{"brand-id": "system", "model": "ash"}, true},
+		{&ErrorMockDB{}, asserts.SerialType, map[string]interface{}{"brand-id": "system", "model": "alder"}, false},
+		{&ErrorMockDB{}, asserts.SnapBuildType, map[string]interface{}{"authority-id": "system"}, true},
+		{nil, asserts.SerialType, map[string]interface{}{"brand-id": "system", "model": "alder"}, false},
+	}
+
+	for _, tt := range tests {
+		err := checkAssertionModels(tt.db, nil, tt.assertion, tt.headers, "key1")
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s %v: expected allowed %v, got %v", tt.assertion.Name, tt.headers, tt.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+const createKeypairUsageTableSQL = `
+	CREATE TABLE IF NOT EXISTS keypairusage (
+		id               serial primary key not null,
+		keypair_id       int references keypair not null,
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null,
+		signed           int default 0,
+		first_signed     timestamp default current_timestamp,
+		last_signed      timestamp default current_timestamp
+	)
+`
+
+// Indexes
+const createKeypairUsageUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS keypairusage_idx ON keypairusage (keypair_id, brand_id, model)"
+
+const createKeypairUsageSQL = "INSERT INTO keypairusage (keypair_id, brand_id, model, signed) VALUES ($1,$2,$3,1)"
+const updateKeypairUsageSQL = "UPDATE keypairusage SET signed=signed+1, last_signed=current_timestamp WHERE keypair_id=$1 AND brand_id=$2 AND model=$3"
+
+const listKeypairUsageSQL = `
+	SELECT keypair_id, brand_id, model, signed, first_signed, last_signed
+	FROM keypairusage
+	WHERE keypair_id=$1
+	ORDER BY brand_id, model`
+
+// KeypairUsage counts the assertions that a signing-key signed for a brand/model
+type KeypairUsage struct {
+	KeypairID   int       `json:"keypair-id"`
+	BrandID     string    `json:"brand-id"`
+	Model       string    `json:"model"`
+	Signed      int       `json:"signed"`
+	FirstSigned time.Time `json:"first-signed"`
+	LastSigned  time.Time `json:"last-signed"`
+}
+
+// CreateKeypairUsageTable creates the database table for the brands/models signed by the signing-keys
+func (db *DB) CreateKeypairUsageTable() error {
+	if _, err := db.Exec(createKeypairUsageTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(createKeypairUsageUniqueIndexSQL)
+	return err
+}
+
+// RecordKeypairUsage counts an assertion that the signing-key signed for the brand/model. It
+// returns true when the signing-key had not signed for the brand/model before
+func (db *DB) RecordKeypairUsage(keypairID int, brandID, model string) (bool, error) {
+	first := false
+	err := db.transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(updateKeypairUsageSQL, keypairID, brandID, model)
+		if err != nil {
+			log.Printf("Error updating the signing-key usage: %v\n", err)
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+			return err
+		}
+		if _, err = tx.Exec(createKeypairUsageSQL, keypairID, brandID, model); err != nil {
+			log.Printf("Error storing the signing-key usage: %v\n", err)
+			return err
+		}
+		first = true
+		return nil
+	})
+	return first, err
+}
+
+// ListKeypairUsage returns the brands/models that the signing-key has signed for
+func (db *DB) ListKeypairUsage(keypairID int) ([]KeypairUsage, error) {
+	rows, err := db.Query(listKeypairUsageSQL, keypairID)
+	if err != nil {
+		log.Printf("Error retrieving the signing-key usage: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []KeypairUsage{}
+	for rows.Next() {
+		u := KeypairUsage{}
+		if err := rows.Scan(&u.KeypairID, &u.BrandID, &u.Model, &u.Signed, &u.FirstSigned, &u.LastSigned); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -20,7 +20,11 @@
 package datastore
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/crypt"
@@ -37,6 +41,7 @@ var (
 	FilesystemStore = KeypairStoreType{"filesystem"}
 	DatabaseStore   = KeypairStoreType{"database"}
 	TPM20Store      = KeypairStoreType{"tpm2.0"}
+	MemoryStore     = KeypairStoreType{"memory"} // the keys are only held in memory, for testing
 )
 
 // Common error messages.
@@ -62,12 +67,19 @@ type KeypairDatabase struct {
 	KeyStoreType KeypairStoreType
 	*asserts.Database
 	keypairOperator KeypairOperator
+
+	// modelNameCase are the case policies of the model names, to check the models of the assertions
+	modelNameCase []config.ModelNameCase
 }
 
 var keypairDB KeypairDatabase
 
 // OpenKeyStore returns the keystore as defined in the config file
 func OpenKeyStore(config config.Settings) error {
+	if err := checkKeystoreLimit(config); err != nil {
+		return err
+	}
+
 	keypairDB, err := getKeyStore(config)
 	if err != nil {
 		return err
@@ -88,7 +100,7 @@ func getKeyStore(config config.Settings) (*KeypairDatabase, error) {
 
 		dbOperator := DatabaseKeypairOperator{}
 
-		keypairDB = KeypairDatabase{DatabaseStore, db, &dbOperator}
+		keypairDB = KeypairDatabase{DatabaseStore, db, &dbOperator, config.ModelNameCase}
 		return &keypairDB, err
 
 	case TPM20Store.Name:
@@ -101,29 +113,56 @@ func getKeyStore(config config.Settings) (*KeypairDatabase, error) {
 			KeypairManager: memStore,
 		})
 
-		keypairDB = KeypairDatabase{TPM20Store, db, &tpm20}
+		keypairDB = KeypairDatabase{TPM20Store, db, &tpm20, config.ModelNameCase}
 		return &keypairDB, err
 
 	case FilesystemStore.Name:
-		fsStore, err := asserts.OpenFSKeypairManager(config.KeyStorePath)
+		fsOperator := FilesystemKeypairOperator{config.KeyStorePath, config.KeyStoreSecret}
+
+		// Prepare the memory store for the unsealed keys
+		memStore := asserts.NewMemoryKeypairManager()
+		db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+			KeypairManager: memStore,
+		})
 		if err != nil {
 			return nil, err
 		}
-		db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
-			KeypairManager: fsStore,
-		})
 
-		keypairDB = KeypairDatabase{FilesystemStore, db, nil}
-		return &keypairDB, err
+		// The signing-keys are sealed in their files, so they are verified and unsealed now
+		if err = openFilesystemKeyStore(config.KeyStorePath, config.KeyStoreSecret, db); err != nil {
+			return nil, err
+		}
+
+		keypairDB = KeypairDatabase{FilesystemStore, db, &fsOperator, config.ModelNameCase}
+		return &keypairDB, nil
 
 	default:
 		return nil, ErrorInvalidKeystoreType
 	}
 }
 
-// ImportSigningKey adds a new signing-key for an authority into the keypair store
-func (kdb *KeypairDatabase) ImportSigningKey(authorityID, base64PrivateKey string) (asserts.PrivateKey, string, error) {
-	privateKey, _, err := crypt.DeserializePrivateKey(base64PrivateKey)
+// CheckKeyStore checks that the keystore defined in the config file is available, without opening it
+func CheckKeyStore(config config.Settings) error {
+	switch config.KeyStoreType {
+	case DatabaseStore.Name:
+		return nil
+	case TPM20Store.Name, FilesystemStore.Name:
+		_, err := os.Stat(config.KeyStorePath)
+		return err
+	default:
+		return ErrorInvalidKeystoreType
+	}
+}
+
+// ImportSigningKey adds a new signing-key for an authority into the keypair store.
+// The key can be in any of the formats accepted by crypt.ImportPrivateKey
+func (kdb *KeypairDatabase) ImportSigningKey(authorityID, privateKeyData string) (asserts.PrivateKey, string, error) {
+	imported, _, err := crypt.ImportPrivateKey(privateKeyData)
+	if err != nil {
+		return nil, "", err
+	}
+
+	privateKey, _, err := crypt.DeserializePrivateKey(imported.Base64PrivateKey)
 	if err != nil {
 		return nil, "", err
 	}
@@ -134,17 +173,78 @@ func (kdb *KeypairDatabase) ImportSigningKey(authorityID, base64PrivateKey strin
 
 	case TPM20Store.Name:
 		// Use an internal operator to handle encryption of signing-keys for storage
-		sealedPrivateKey, err := kdb.keypairOperator.ImportKeypair(authorityID, privateKey.PublicKey().ID(), base64PrivateKey)
+		sealedPrivateKey, err := kdb.keypairOperator.ImportKeypair(authorityID, imported.KeyID, imported.Base64PrivateKey)
 		return privateKey, sealedPrivateKey, err
 
+	case FilesystemStore.Name:
+		// The signing-key is kept unsealed in the memory store, and sealed in its file
+		if err = kdb.ImportKey(privateKey); err != nil {
+			return nil, "", err
+		}
+		_, err = kdb.keypairOperator.ImportKeypair(authorityID, imported.KeyID, imported.Base64PrivateKey)
+		return privateKey, "", err
+
 	default:
 		// Keypairs are handled by the snapd library, so this is a pass-through to the core library
 		return privateKey, "", kdb.ImportKey(privateKey)
 	}
 }
 
-// SignAssertion signs an assertion using the signing-key from the keypair store
-func (kdb *KeypairDatabase) SignAssertion(assertType *asserts.AssertionType, headers map[string]interface{}, body []byte, authorityID string, keyID string, sealedSigningKey string) (asserts.Assertion, error) {
+// SignAssertion signs an assertion using the signing-key from the keypair store, if the model of
+// the assertion is in the allowlist of the signing-key and, for a brand with a root key, in an
+// active delegation of the root key to the signing-key. The allowlist and the delegations are
+// read from the datastore of the caller, which must be given for the assertions of a model. The signing is abandoned when the context
+// is done or the keystore timeout expires, so a stuck keystore (e.g. an HSM) does not block the caller.
+// The signings at the same time are limited by the concurrency limit of the keystore
+func (kdb *KeypairDatabase) SignAssertion(ctx context.Context, db Datastore, assertType *asserts.AssertionType, headers map[string]interface{}, body []byte, authorityID string, keyID string, sealedSigningKey string) (asserts.Assertion, error) {
+	// Refuse to sign for a model outside the allowlist of the signing-key, whatever the model record says
+	if err := checkAssertionModels(db, kdb.modelNameCase, assertType, headers, keyID); err != nil {
+		return nil, err
+	}
+	if err := checkAssertionDelegation(db, kdb.modelNameCase, assertType, headers, keyID); err != nil {
+		return nil, err
+	}
+
+	if Environ != nil && Environ.Config.KeystoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(Environ.Config.KeystoreTimeout)*time.Second)
+		defer cancel()
+	}
+
+	// The signings over the concurrency limit of the keystore wait for a free slot, which is
+	// held until the signing completes, even if it is abandoned
+	release, err := acquireKeystoreSlot(ctx, kdb.KeyStoreType.Name, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	type signed struct {
+		assertion asserts.Assertion
+		err       error
+	}
+
+	// The channel is buffered, so the signing completes even if it is abandoned
+	start := time.Now()
+	result := make(chan signed, 1)
+	go func() {
+		defer release()
+		assertion, err := kdb.signAssertion(ctx, assertType, headers, body, authorityID, keyID, sealedSigningKey)
+		result <- signed{assertion, err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.assertion, r.err
+	case <-ctx.Done():
+		observeKeystore(ctx, kdb.KeyStoreType.Name, KeystoreTimeout, keyID, start, ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// signAssertion unseals the signing-key, when the keystore needs it, and signs the assertion,
+// recording the latency of each operation of the keystore
+func (kdb *KeypairDatabase) signAssertion(ctx context.Context, assertType *asserts.AssertionType, headers map[string]interface{}, body []byte, authorityID string, keyID string, sealedSigningKey string) (asserts.Assertion, error) {
+	start := time.Now()
 
 	switch kdb.KeyStoreType.Name {
 
@@ -154,17 +254,28 @@ func (kdb *KeypairDatabase) SignAssertion(assertType *asserts.AssertionType, hea
 	case TPM20Store.Name:
 		// Use an internal operator to handle decryption of signing-keys from storage
 		err := kdb.keypairOperator.UnsealKeypair(authorityID, keyID, sealedSigningKey)
+		observeKeystore(ctx, kdb.KeyStoreType.Name, KeystoreUnseal, keyID, start, err)
 		if err != nil {
 			return nil, err
 		}
 
-		// Sign the key using the unsealed key in the memory keypair store
-		return kdb.Sign(assertType, headers, body, keyID)
+	case FilesystemStore.Name:
+		// The signing-key may have been added since the keystore was opened
+		err := kdb.loadFilesystemKeypair(authorityID, keyID, sealedSigningKey)
+		observeKeystore(ctx, kdb.KeyStoreType.Name, KeystoreUnseal, keyID, start, err)
+		if err != nil {
+			return nil, err
+		}
 
 	default:
-		// Filesystem keypairs are handled by the snapd library, so this is a pass-through to the core library
-		return kdb.Sign(assertType, headers, body, keyID)
+		// Keypairs are handled by the snapd library, so this is a pass-through to the core library
 	}
+
+	// Sign the key using the unsealed key in the memory keypair store
+	start = time.Now()
+	assertion, err := kdb.Sign(assertType, headers, body, keyID)
+	observeKeystore(ctx, kdb.KeyStoreType.Name, KeystoreSign, keyID, start, err)
+	return assertion, err
 }
 
 // LoadKeypair checks if a keypair is in the memory store and (unseals and) loads it if it isn't
@@ -178,8 +289,72 @@ func (kdb *KeypairDatabase) LoadKeypair(authorityID string, keyID string, sealed
 		err := kdb.keypairOperator.UnsealKeypair(authorityID, keyID, sealedSigningKey)
 		return err
 
+	case FilesystemStore.Name:
+		return kdb.loadFilesystemKeypair(authorityID, keyID, sealedSigningKey)
+
 	default:
-		// Filesystem keypairs are all loaded, so this is a no-op
+		// Keypairs are handled by the snapd library, so this is a no-op
 		return nil
 	}
 }
+
+// loadFilesystemKeypair unseals a signing-key that is not in the memory store. The filesystem
+// keypairs are unsealed when the keystore is opened, but a key file may have been added since
+// then by another process. The signing-keys synced from the cloud are sealed in the database
+func (kdb *KeypairDatabase) loadFilesystemKeypair(authorityID string, keyID string, sealedSigningKey string) error {
+	err := kdb.keypairOperator.UnsealKeypair(authorityID, keyID, sealedSigningKey)
+	if err != nil && len(sealedSigningKey) > 0 {
+		return unsealKeypair(authorityID, keyID, sealedSigningKey)
+	}
+	return err
+}
+
+// ReloadKeypairs loads the active signing-keys that are not in the memory store yet e.g. the
+// keys synced from the cloud after the keystore was opened, so they are signable without
+// restarting the service. It returns the number of signing-keys that were loaded, and the
+// error of the last signing-key that could not be loaded
+func (kdb *KeypairDatabase) ReloadKeypairs(keypairs []Keypair) (int, error) {
+	var lastErr error
+	loaded := 0
+	for _, k := range keypairs {
+		if !k.Active {
+			continue
+		}
+		if _, err := kdb.PublicKey(k.KeyID); err == nil {
+			continue
+		}
+
+		start := time.Now()
+		err := kdb.LoadKeypair(k.AuthorityID, k.KeyID, k.SealedKey)
+		observeKeystore(context.Background(), kdb.KeyStoreType.Name, KeystoreReload, k.KeyID, start, err)
+		if err != nil {
+			lastErr = fmt.Errorf("Cannot load signing-key %s/%s: %v", k.AuthorityID, k.KeyID, err)
+			continue
+		}
+		if _, err := kdb.PublicKey(k.KeyID); err == nil {
+			loaded++
+		}
+	}
+	return loaded, lastErr
+}
+
+// KeypairPublicKey returns the public key of a signing-key, to check the signature of the
+// assertions signed with it. The public key is taken from the account-key assertion of the
+// signing-key, or from the keypair store when the signing-key has not been registered
+func (kdb *KeypairDatabase) KeypairPublicKey(keypair Keypair) (asserts.PublicKey, error) {
+	if len(keypair.Assertion) > 0 {
+		assertion, err := asserts.Decode([]byte(keypair.Assertion))
+		if err != nil {
+			return nil, err
+		}
+		if assertion.Type() != asserts.AccountKeyType || assertion.HeaderString("public-key-sha3-384") != keypair.KeyID {
+			return nil, errors.New("The account-key assertion is not for the signing-key")
+		}
+		return asserts.DecodePublicKey(assertion.Body())
+	}
+
+	if err := kdb.LoadKeypair(keypair.AuthorityID, keypair.KeyID, keypair.SealedKey); err != nil {
+		return nil, err
+	}
+	return kdb.PublicKey(keypair.KeyID)
+}
@@ -20,14 +20,20 @@
 package datastore
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/snapcore/snapd/asserts"
 )
 
 func TestGetKeyStoreFilesystem(t *testing.T) {
 	// Set up the environment variables
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash"}
 	Environ = &Env{Config: config}
 
 	err := OpenKeyStore(config)
@@ -46,3 +52,120 @@ func TestGetKeyStoreInvalid(t *testing.T) {
 		t.Errorf("Expected error, but got success: %v", err)
 	}
 }
+
+func TestCheckKeyStore(t *testing.T) {
+	tests := []struct {
+		Config  config.Settings
+		Healthy bool
+	}{
+		{config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore"}, true},
+		{config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../does-not-exist"}, false},
+		{config.Settings{KeyStoreType: "tpm2.0", KeyStorePath: "../does-not-exist"}, false},
+		{config.Settings{KeyStoreType: "database"}, true},
+		{config.Settings{KeyStoreType: "invalid", KeyStorePath: "../keystore"}, false},
+	}
+
+	for _, tt := range tests {
+		err := CheckKeyStore(tt.Config)
+		if (err == nil) != tt.Healthy {
+			t.Errorf("Keystore %s at %s: expected healthy %v, got %v", tt.Config.KeyStoreType, tt.Config.KeyStorePath, tt.Healthy, err)
+		}
+	}
+}
+
+func TestFilesystemKeystoreLoadKeypair(t *testing.T) {
+	path, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("Error creating the keystore: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: path, KeyStoreSecret: "secret"}
+	Environ = &Env{Config: config}
+	if err = OpenKeyStore(config); err != nil {
+		t.Fatalf("Error opening the keystore: %v", err)
+	}
+
+	if err = Environ.KeypairDB.LoadKeypair("system", testKeyID, ""); err == nil {
+		t.Error("Expected an error loading a signing-key that is not in the keystore")
+	}
+
+	// The signing-key is added after the keystore was opened
+	data, err := ioutil.ReadFile("../keystore/TestKey.snapd")
+	if err != nil {
+		t.Fatalf("Error reading the test key: %v", err)
+	}
+	imported, _, err := crypt.ConvertPrivateKey(string(data))
+	if err != nil {
+		t.Fatalf("Error converting the test key: %v", err)
+	}
+	if err = sealKeyFile(path, "secret", testKeyID, imported.Base64PrivateKey); err != nil {
+		t.Fatalf("Error sealing the test key: %v", err)
+	}
+
+	if err = Environ.KeypairDB.LoadKeypair("system", testKeyID, ""); err != nil {
+		t.Errorf("Error loading the added signing-key: %v", err)
+	}
+	if _, err = Environ.KeypairDB.PublicKey(testKeyID); err != nil {
+		t.Errorf("Expected the added signing-key in the memory store: %v", err)
+	}
+}
+
+func TestKeystoreTrace(t *testing.T) {
+	path, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("Error creating the keystore: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: path, KeyStoreSecret: "secret"}
+	Environ = &Env{Config: config}
+	if err = OpenKeyStore(config); err != nil {
+		t.Fatalf("Error opening the keystore: %v", err)
+	}
+
+	// The signing-key is not in the keystore, so it cannot be unsealed
+	ctx, trace := WithQueryTrace(context.Background())
+	headers := map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "alder", "serial": "A1"}
+	if _, err = Environ.KeypairDB.SignAssertion(ctx, &MockDB{}, asserts.SerialType, headers, nil, "system", testKeyID, ""); err == nil {
+		t.Fatal("Expected an error signing with a signing-key that is not in the keystore")
+	}
+
+	if len(trace.Keystore) != 1 {
+		t.Fatalf("Expected the unseal in the trace, got: %v", trace.Keystore)
+	}
+	op := trace.Keystore[0]
+	if op.Backend != "filesystem" || op.Operation != KeystoreUnseal || op.KeyID != testKeyID || len(op.Error) == 0 {
+		t.Errorf("Unexpected keystore operation in the trace: %v", op)
+	}
+
+	if metrics.Keystore()["filesystem unseal"].Count == 0 {
+		t.Error("Expected the unseal in the keystore statistics")
+	}
+	errs := metrics.RecentKeystoreErrors()
+	if len(errs) == 0 || errs[0].Operation != KeystoreUnseal || errs[0].KeyID != testKeyID {
+		t.Errorf("Expected the unseal in the recent keystore errors, got: %v", errs)
+	}
+}
+
+func TestSignAssertionAllowlist(t *testing.T) {
+	path, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("Error creating the keystore: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: path, KeyStoreSecret: "secret"}
+	Environ = &Env{DB: &MockDB{}, Config: config}
+	if err = OpenKeyStore(config); err != nil {
+		t.Fatalf("Error opening the keystore: %v", err)
+	}
+
+	// The allowlist is read from the datastore of the caller, not from the environment
+	db := &allowlistDB{models: []KeypairModel{{BrandID: "system", Model: "ash"}}}
+	headers := map[string]interface{}{"authority-id": "system", "brand-id": "system", "model": "alder", "serial": "A1"}
+	_, err = Environ.KeypairDB.SignAssertion(context.Background(), db, asserts.SerialType, headers, nil, "system", testKeyID, "")
+	if _, ok := err.(ErrKeypairModelNotAllowed); !ok {
+		t.Errorf("Expected the model to be refused by the allowlist, got: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+// Scopes of the concurrency limit of the keystore
+const (
+	KeystoreLimitKeypair = "keypair" // the limit applies to each signing-key
+	KeystoreLimitBackend = "backend" // the limit applies to all the signing-keys of the backend
+)
+
+// ErrKeystoreBusy is returned when a signing waits longer than the queue timeout for a free slot
+var ErrKeystoreBusy = errors.New("The keystore is busy: no signing slot was free in time")
+
+// keystoreSlots holds the semaphores of the concurrency limit, by signing-key or backend
+var keystoreSlots = struct {
+	sync.Mutex
+	sems map[string]chan struct{}
+}{sems: map[string]chan struct{}{}}
+
+// checkKeystoreLimit checks the scope of the concurrency limit in the config
+func checkKeystoreLimit(settings config.Settings) error {
+	switch settings.KeystoreConcurrencyScope {
+	case "", KeystoreLimitKeypair, KeystoreLimitBackend:
+		return nil
+	default:
+		return fmt.Errorf("Invalid keystore concurrency scope: %s", settings.KeystoreConcurrencyScope)
+	}
+}
+
+// keystoreSlot returns the name and the semaphore of the signing-key or backend, or a nil
+// semaphore when the signings are not limited
+func keystoreSlot(backend, keyID string) (string, chan struct{}) {
+	if Environ == nil || Environ.Config.KeystoreConcurrency <= 0 {
+		return "", nil
+	}
+	limit := Environ.Config.KeystoreConcurrency
+
+	name := keyID
+	if Environ.Config.KeystoreConcurrencyScope == KeystoreLimitBackend {
+		name = backend
+	}
+
+	keystoreSlots.Lock()
+	defer keystoreSlots.Unlock()
+
+	// The semaphore is replaced when the limit is changed, and the signings that hold a slot
+	// release it on the semaphore that they took it from
+	sem, ok := keystoreSlots.sems[name]
+	if !ok || cap(sem) != limit {
+		sem = make(chan struct{}, limit)
+		keystoreSlots.sems[name] = sem
+	}
+	return name, sem
+}
+
+// acquireKeystoreSlot waits for a free slot of the concurrency limit of the signing-key or backend,
+// until the queue timeout expires or the context is done. The returned function releases the slot
+func acquireKeystoreSlot(ctx context.Context, backend, keyID string) (func(), error) {
+	name, sem := keystoreSlot(backend, keyID)
+	if sem == nil {
+		return func() {}, nil
+	}
+	release := func() { <-sem }
+
+	start := time.Now()
+	select {
+	case sem <- struct{}{}:
+		observeKeystore(ctx, backend, KeystoreQueue, keyID, start, nil)
+		return release, nil
+	default:
+	}
+
+	metrics.QueueKeystore(name, 1)
+	defer metrics.QueueKeystore(name, -1)
+
+	var timeout <-chan time.Time
+	if Environ.Config.KeystoreQueueTimeout > 0 {
+		timer := time.NewTimer(time.Duration(Environ.Config.KeystoreQueueTimeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case sem <- struct{}{}:
+		observeKeystore(ctx, backend, KeystoreQueue, keyID, start, nil)
+		return release, nil
+	case <-timeout:
+		observeKeystore(ctx, backend, KeystoreQueue, keyID, start, ErrKeystoreBusy)
+		return nil, ErrKeystoreBusy
+	case <-ctx.Done():
+		observeKeystore(ctx, backend, KeystoreQueue, keyID, start, ctx.Err())
+		return nil, ctx.Err()
+	}
+}
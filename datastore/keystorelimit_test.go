@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+func TestAcquireKeystoreSlotUnlimited(t *testing.T) {
+	Environ = &Env{Config: config.Settings{}}
+
+	for i := 0; i < 10; i++ {
+		if _, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "unlimited-key"); err != nil {
+			t.Fatalf("Expected no limit, got: %v", err)
+		}
+	}
+}
+
+func TestAcquireKeystoreSlotQueueTimeout(t *testing.T) {
+	Environ = &Env{Config: config.Settings{KeystoreConcurrency: 1, KeystoreQueueTimeout: 1}}
+
+	release, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "busy-key")
+	if err != nil {
+		t.Fatalf("Error taking the free slot: %v", err)
+	}
+
+	// Another signing-key has its own slots
+	releaseOther, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "other-key")
+	if err != nil {
+		t.Fatalf("Error taking the slot of another signing-key: %v", err)
+	}
+	releaseOther()
+
+	if _, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "busy-key"); err != ErrKeystoreBusy {
+		t.Fatalf("Expected the keystore to be busy, got: %v", err)
+	}
+	if queue := metrics.KeystoreQueue()["busy-key"]; queue != 0 {
+		t.Errorf("Expected an empty queue, got %d", queue)
+	}
+
+	release()
+	release, err = acquireKeystoreSlot(context.Background(), "tpm2.0", "busy-key")
+	if err != nil {
+		t.Fatalf("Error taking the released slot: %v", err)
+	}
+	release()
+}
+
+func TestAcquireKeystoreSlotQueued(t *testing.T) {
+	Environ = &Env{Config: config.Settings{KeystoreConcurrency: 1}}
+
+	release, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "queued-key")
+	if err != nil {
+		t.Fatalf("Error taking the free slot: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		releaseQueued, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "queued-key")
+		if err == nil {
+			releaseQueued()
+		}
+		acquired <- err
+	}()
+
+	// The signing waits in the queue until the slot is released
+	for i := 0; i < 100 && metrics.KeystoreQueue()["queued-key"] == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queue := metrics.KeystoreQueue()["queued-key"]; queue != 1 {
+		t.Fatalf("Expected one queued signing, got %d", queue)
+	}
+
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Error taking the released slot: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The queued signing did not get the released slot")
+	}
+}
+
+func TestAcquireKeystoreSlotContextDone(t *testing.T) {
+	Environ = &Env{Config: config.Settings{KeystoreConcurrency: 1}}
+
+	release, err := acquireKeystoreSlot(context.Background(), "tpm2.0", "cancelled-key")
+	if err != nil {
+		t.Fatalf("Error taking the free slot: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := acquireKeystoreSlot(ctx, "tpm2.0", "cancelled-key"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline to expire, got: %v", err)
+	}
+}
+
+func TestAcquireKeystoreSlotBackend(t *testing.T) {
+	Environ = &Env{Config: config.Settings{KeystoreConcurrency: 1, KeystoreConcurrencyScope: KeystoreLimitBackend, KeystoreQueueTimeout: 1}}
+
+	release, err := acquireKeystoreSlot(context.Background(), "hsm-backend", "first-key")
+	if err != nil {
+		t.Fatalf("Error taking the free slot: %v", err)
+	}
+	defer release()
+
+	// The signing-keys share the slots of the backend
+	if _, err := acquireKeystoreSlot(context.Background(), "hsm-backend", "second-key"); err != ErrKeystoreBusy {
+		t.Fatalf("Expected the backend to be busy, got: %v", err)
+	}
+}
+
+func TestOpenKeyStoreInvalidConcurrencyScope(t *testing.T) {
+	settings := config.Settings{KeyStoreType: "database", KeystoreConcurrency: 2, KeystoreConcurrencyScope: "invalid"}
+	Environ = &Env{Config: settings}
+
+	if err := OpenKeyStore(settings); err == nil {
+		t.Error("Expected an error for the invalid concurrency scope")
+	}
+}
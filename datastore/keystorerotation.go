@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/CanonicalLtd/serial-vault/crypt"
+)
+
+const updateKeypairSealedKeySQL = "UPDATE keypair SET sealed_key=$2 WHERE id=$1"
+const updateSettingDataSQL = "UPDATE settings SET data=$2 WHERE code=$1"
+
+// RotateKeypairSecret re-encrypts a database-sealed signing-key under the new keystore secret,
+// storing the sealed key and its auth-key setting together. A signing-key that is already
+// sealed with the new secret is skipped, so an interrupted rotation can be resumed
+func RotateKeypairSecret(keypair Keypair, newSecret string) (bool, error) {
+	if keypairSealedWith(keypair, newSecret) {
+		return false, nil
+	}
+	if !keypairSealedWith(keypair, Environ.Config.KeyStoreSecret) {
+		return false, errors.New("The signing-key is not sealed with the current keystore secret")
+	}
+
+	base64SealedSigningkey, base64AuthKeyHash, err := ReEncryptKeypair(keypair, newSecret)
+	if err != nil {
+		return false, err
+	}
+
+	keypair.SealedKey = base64SealedSigningkey
+	err = Environ.DB.UpdateKeypairSealedKey(SyncKeypair{Keypair: keypair, AuthKeyHash: base64AuthKeyHash})
+	return err == nil, err
+}
+
+// keypairSealedWith checks if the signing-key unseals to a valid private key using the secret.
+// The encryption is not authenticated, so the wrong secret only shows as an invalid key
+func keypairSealedWith(keypair Keypair, keystoreSecret string) bool {
+	base64SigningKey, err := decryptKeypairWithSecret(keypair.AuthorityID, keypair.KeyID, keypair.SealedKey, keystoreSecret)
+	if err != nil {
+		return false
+	}
+
+	_, _, err = crypt.DeserializePrivateKey(string(base64SigningKey))
+	return err == nil
+}
+
+// RotateReportKeySecret re-seals the reporting key under the new keystore secret. The key
+// is skipped when it has not been generated yet, or it is already sealed with the new secret
+func RotateReportKeySecret(newSecret string) (bool, error) {
+	reportKeyMutex.Lock()
+	defer reportKeyMutex.Unlock()
+
+	setting, err := Environ.DB.GetSetting(reportKeySettingCode)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = unsealReportKeyWithSecret(setting.Data, newSecret); err == nil {
+		return false, nil
+	}
+
+	key, err := unsealReportKeyWithSecret(setting.Data, Environ.Config.KeyStoreSecret)
+	if err != nil {
+		return false, errors.New("The reporting key is not sealed with the current keystore secret")
+	}
+
+	sealed, err := crypt.EncryptKey(string(x509.MarshalPKCS1PrivateKey(key)), newSecret)
+	if err != nil {
+		return false, err
+	}
+
+	setting.Data = base64.StdEncoding.EncodeToString(sealed)
+	return true, Environ.DB.PutSetting(setting)
+}
+
+// UpdateKeypairSealedKey replaces the sealed signing-key and its auth-key setting in a transaction
+func (db *DB) UpdateKeypairSealedKey(keypair SyncKeypair) error {
+	err := db.transaction(func(tx *sql.Tx) error {
+		if err := updateSingleRow(tx, updateKeypairSealedKeySQL, keypair.ID, keypair.SealedKey); err != nil {
+			return err
+		}
+		return updateSingleRow(tx, updateSettingDataSQL, crypt.GenerateAuthKey(keypair.AuthorityID, keypair.KeyID), keypair.AuthKeyHash)
+	})
+	if err != nil {
+		log.Printf("Error updating the sealed signing-key: %v\n", err)
+	}
+	return err
+}
+
+// ResignSigningLogCheckpoints signs the signing log checkpoints with the new keystore secret.
+// Checkpoints that are already signed with the new secret are skipped, and the re-signing is
+// abandoned if any checkpoint does not match the current secret, as it may have been tampered with
+func (db *DB) ResignSigningLogCheckpoints(newSecret string) (int, error) {
+	var count int
+
+	err := db.transaction(func(tx *sql.Tx) error {
+		count = 0
+		checkpoints, err := listSigningLogCheckpoints(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range checkpoints {
+			if c.Signature == signingLogCheckpointSignature(newSecret, c.LogID, c.Hash) {
+				continue
+			}
+			if c.Signature != signingLogCheckpointSignature(Environ.Config.KeyStoreSecret, c.LogID, c.Hash) {
+				return fmt.Errorf("The signature of signing log checkpoint %d is invalid", c.ID)
+			}
+
+			signature := signingLogCheckpointSignature(newSecret, c.LogID, c.Hash)
+			if err := updateSingleRow(tx, updateSigningLogCheckpointSignatureSQL, c.ID, signature); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error re-signing the signing log checkpoints: %v\n", err)
+	}
+	return count, err
+}
+
+// updateSingleRow runs an update that is expected to change exactly one row
+func updateSingleRow(tx *sql.Tx, query string, args ...interface{}) error {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+)
+
+const newKeystoreSecret = "this needs to be something new and secure"
+
+func TestRotateKeypairSecret(t *testing.T) {
+	keypairDB, _ := getDatabaseKeyStore()
+
+	signingKey, err := ioutil.ReadFile("../keystore/TestKey.asc")
+	if err != nil {
+		t.Fatalf("Error reading the signing-key file: %v", err)
+	}
+
+	sealedSigningKey, err := keypairDB.keypairOperator.ImportKeypair("System", "abcdef12345678", base64.StdEncoding.EncodeToString(signingKey))
+	if err != nil {
+		t.Fatalf("Error encrypting the signing-key: %v", err)
+	}
+	keypair := Keypair{ID: 1, AuthorityID: "System", KeyID: "abcdef12345678", SealedKey: sealedSigningKey}
+
+	rotated, err := RotateKeypairSecret(keypair, newKeystoreSecret)
+	if err != nil || !rotated {
+		t.Fatalf("Expected the signing-key to be rotated: %v", err)
+	}
+
+	// The mock database only stores the auth-key, which is now sealed with the new secret
+	if keypairSealedWith(keypair, "this needs to be something secure") {
+		t.Error("Expected the signing-key to no longer unseal with the old secret")
+	}
+}
+
+func TestRotateKeypairSecretResume(t *testing.T) {
+	keypairDB, _ := getDatabaseKeyStore()
+
+	signingKey, err := ioutil.ReadFile("../keystore/TestKey.asc")
+	if err != nil {
+		t.Fatalf("Error reading the signing-key file: %v", err)
+	}
+
+	sealedSigningKey, err := keypairDB.keypairOperator.ImportKeypair("System", "abcdef12345678", base64.StdEncoding.EncodeToString(signingKey))
+	if err != nil {
+		t.Fatalf("Error encrypting the signing-key: %v", err)
+	}
+	keypair := Keypair{ID: 1, AuthorityID: "System", KeyID: "abcdef12345678", SealedKey: sealedSigningKey}
+
+	resealed, authKeyHash, err := ReEncryptKeypair(keypair, newKeystoreSecret)
+	if err != nil {
+		t.Fatalf("Error re-encrypting the signing-key: %v", err)
+	}
+	keypair.SealedKey = resealed
+	Environ.DB.PutSetting(Setting{Code: "System/abcdef12345678", Data: authKeyHash})
+
+	rotated, err := RotateKeypairSecret(keypair, newKeystoreSecret)
+	if err != nil || rotated {
+		t.Errorf("Expected the rotated signing-key to be skipped: %v", err)
+	}
+
+	if !keypairSealedWith(keypair, newKeystoreSecret) {
+		t.Error("Expected the rotated signing-key to unseal with the new secret")
+	}
+}
+
+func TestRotateKeypairSecretError(t *testing.T) {
+	getDatabaseKeyStore()
+	Environ.DB = &ErrorMockDB{}
+
+	_, err := RotateKeypairSecret(Keypair{ID: 1, AuthorityID: "System", KeyID: "abcdef12345678", SealedKey: "invalid"}, newKeystoreSecret)
+	if err == nil {
+		t.Error("Expected an error rotating an invalid signing-key")
+	}
+}
+
+func TestRotateReportKeySecret(t *testing.T) {
+	getDatabaseKeyStore()
+
+	rotated, err := RotateReportKeySecret(newKeystoreSecret)
+	if err != nil || rotated {
+		t.Errorf("Expected the missing reporting key to be skipped: %v", err)
+	}
+
+	key, err := reportKey(Environ.DB, Environ.Config)
+	if err != nil {
+		t.Fatalf("Error generating the reporting key: %v", err)
+	}
+
+	rotated, err = RotateReportKeySecret(newKeystoreSecret)
+	if err != nil || !rotated {
+		t.Fatalf("Expected the reporting key to be rotated: %v", err)
+	}
+
+	rotated, err = RotateReportKeySecret(newKeystoreSecret)
+	if err != nil || rotated {
+		t.Errorf("Expected the rotated reporting key to be skipped: %v", err)
+	}
+
+	Environ.Config.KeyStoreSecret = newKeystoreSecret
+	rotatedKey, err := reportKey(Environ.DB, Environ.Config)
+	if err != nil {
+		t.Fatalf("Error unsealing the rotated reporting key: %v", err)
+	}
+	if rotatedKey.N.Cmp(key.N) != 0 {
+		t.Error("Expected the rotated reporting key to be unchanged")
+	}
+}
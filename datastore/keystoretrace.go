@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+// Operations of the keystore that are measured
+const (
+	KeystoreUnseal  = "unseal"  // unseal a signing-key into the memory store
+	KeystoreSign    = "sign"    // sign an assertion with an unsealed signing-key
+	KeystoreTimeout = "timeout" // a signing abandoned by the keystore timeout or the caller
+	KeystoreReload  = "reload"  // load a signing-key into a keystore that is already open
+	KeystoreQueue   = "queue"   // wait for a free slot of the concurrency limit of the keystore
+)
+
+// TracedKeystoreOperation is an operation of the keystore that was run for a request
+type TracedKeystoreOperation struct {
+	Backend    string  `json:"backend"`
+	Operation  string  `json:"operation"`
+	KeyID      string  `json:"key-id"`
+	DurationMS float64 `json:"duration-ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// observeKeystore records the latency and the error of an operation of the keystore backend,
+// capturing it in the trace of the context
+func observeKeystore(ctx context.Context, backend, operation, keyID string, start time.Time, err error) {
+	d := time.Since(start)
+	metrics.ObserveKeystore(backend, operation, keyID, d, err)
+
+	trace := queryTrace(ctx)
+	if trace == nil {
+		return
+	}
+
+	op := TracedKeystoreOperation{
+		Backend:    backend,
+		Operation:  operation,
+		KeyID:      keyID,
+		DurationMS: float64(d) / float64(time.Millisecond),
+	}
+	if err != nil {
+		op.Error = err.Error()
+	}
+	trace.addKeystore(op)
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// leaderLockKey is the key of the Postgres advisory lock that is held by the active vault of a
+// failover pair. It is below 2^32, so it is found in pg_locks by its objid
+const leaderLockKey = 0x53564c44
+
+const tryLeaderLockSQL = "SELECT pg_try_advisory_lock($1), pg_backend_pid()"
+
+const checkLeaderLockSQL = `
+	SELECT EXISTS (
+		SELECT 1 FROM pg_locks
+		WHERE locktype='advisory' AND classid=0 AND objid=$1 AND objsubid=1 AND granted AND pid=pg_backend_pid()
+	)`
+
+const releaseLeaderLockSQL = "SELECT pg_advisory_unlock($1)"
+
+const terminateLeaderLockSQL = "SELECT pg_terminate_backend($1)"
+
+// ErrLeaderLockLost is returned when the leader lock is no longer held e.g. when the database
+// session of the lock has been closed
+var ErrLeaderLockLost = errors.New("The leader lock has been lost")
+
+// LeaderLock is the lock held by the active vault of a failover pair. It is released by the
+// database when the session of the vault ends, so a standby vault can take it over
+type LeaderLock interface {
+	// Check verifies that the lock is still held
+	Check(ctx context.Context) error
+	// Release releases the lock, so the standby vault can take it over
+	Release() error
+}
+
+// pgLeaderLock is the advisory lock of a dedicated database session
+type pgLeaderLock struct {
+	db   *DB
+	conn *sql.Conn
+	pid  int // the server process of the session
+}
+
+// TryLeaderLock takes the leader lock of the failover pair, without waiting. It returns false
+// when the lock is held by another vault. The lock needs a Postgres database shared by the pair
+func (db *DB) TryLeaderLock() (LeaderLock, bool, error) {
+	if usesSQLite() {
+		return nil, false, errors.New("The failover needs a Postgres database that is shared by the vaults")
+	}
+
+	// The advisory lock belongs to the session, so it is taken on a connection that is kept
+	// out of the pool until the lock is released
+	conn, err := db.DB.Conn(db.context())
+	if err != nil {
+		log.Printf("Error opening the session of the leader lock: %v\n", err)
+		return nil, false, err
+	}
+
+	var (
+		acquired bool
+		pid      int
+	)
+	if err := conn.QueryRowContext(db.context(), tryLeaderLockSQL, leaderLockKey).Scan(&acquired, &pid); err != nil {
+		log.Printf("Error taking the leader lock: %v\n", err)
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &pgLeaderLock{db: db, conn: conn, pid: pid}, true, nil
+}
+
+// Check verifies that the session of the lock is alive and still holds the lock
+func (l *pgLeaderLock) Check(ctx context.Context) error {
+	var held bool
+	if err := l.conn.QueryRowContext(ctx, checkLeaderLockSQL, leaderLockKey).Scan(&held); err != nil {
+		return err
+	}
+	if !held {
+		return ErrLeaderLockLost
+	}
+	return nil
+}
+
+// Release releases the lock and returns its session to the pool. When the unlock fails, the
+// session is terminated from another connection instead, which releases the lock. A broken
+// session is discarded by the pool
+func (l *pgLeaderLock) Release() error {
+	_, err := l.conn.ExecContext(context.Background(), releaseLeaderLockSQL, leaderLockKey)
+	if err != nil {
+		if _, errTerminate := l.db.DB.Exec(terminateLeaderLockSQL, l.pid); errTerminate != nil {
+			log.Printf("Error terminating the session of the leader lock: %v\n", errTerminate)
+		}
+	}
+	l.conn.Close()
+	return err
+}
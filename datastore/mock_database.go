@@ -20,6 +20,8 @@
 package datastore
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
@@ -29,6 +31,11 @@ import (
 // MockDB holds the successful mocks for the database
 type MockDB struct {
 	encryptedAuthKeyHash string
+	reportKey            string
+	syncModels           []SyncModelAssignment
+	syncScoped           map[int]bool
+	authorizations       []SyncModelAssignment
+	authScoped           bool
 }
 
 // CreateModelTable mock for the create model table method
@@ -151,6 +158,21 @@ func (mdb *MockDB) SyncAccount(account Account) error {
 	return nil
 }
 
+// CreateAccountKeyTable database mock
+func (mdb *MockDB) CreateAccountKeyTable() error {
+	return nil
+}
+
+// GetAccountKey database mock
+func (mdb *MockDB) GetAccountKey(authorityID, keyID string) (AccountKey, error) {
+	return AccountKey{}, sql.ErrNoRows
+}
+
+// SyncAccountKey database mock
+func (mdb *MockDB) SyncAccountKey(key AccountKey) error {
+	return ValidateAccountKey(key)
+}
+
 // FindModel mocks the database response for finding a model
 func (mdb *MockDB) FindModel(brandID, modelName, apiKey string) (Model, error) {
 	model := Model{ID: 1, BrandID: "system", Name: "alder", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: true, SealedKey: ""}
@@ -181,6 +203,20 @@ func (mdb *MockDB) CheckModelExists(brandID, modelName string) bool {
 	return true
 }
 
+// ListModelsMissingKeypairs mocks the models that are linked with a missing keypair
+func (mdb *MockDB) ListModelsMissingKeypairs() ([]Model, error) {
+	return []Model{}, nil
+}
+
+// ListNearDuplicateModels mocks finding the near-duplicate models
+func (mdb *MockDB) ListNearDuplicateModels() ([]NearDuplicateModels, error) {
+	return FindNearDuplicateModels(nil, []Model{
+		{ID: 1, BrandID: "system", Name: "alder"},
+		{ID: 2, BrandID: "system", Name: "Alder "},
+		{ID: 3, BrandID: "system", Name: "ash"},
+	}), nil
+}
+
 // CheckAPIKey mocks the database response to check the API key
 func (mdb *MockDB) CheckAPIKey(apiKey string) bool {
 	if apiKey == "InvalidAPIKey" {
@@ -312,6 +348,11 @@ func (mdb *MockDB) CheckKeypairKeynameExists(authorityID, name string) bool {
 	return false
 }
 
+// UpdateKeypairSealedKey database mock
+func (mdb *MockDB) UpdateKeypairSealedKey(keypair SyncKeypair) error {
+	return mdb.PutSetting(Setting{Code: keypair.AuthorityID + "/" + keypair.KeyID, Data: keypair.AuthKeyHash})
+}
+
 // SyncKeypair database mock
 func (mdb *MockDB) SyncKeypair(keypair SyncKeypair) error {
 	return nil
@@ -338,15 +379,58 @@ func (mdb *MockDB) GetSetting(code string) (Setting, error) {
 	case "do-not-find":
 		return Setting{}, errors.New("Cannot find 'do-not-find'")
 
+	case reportKeySettingCode:
+		if len(mdb.reportKey) == 0 {
+			return Setting{}, sql.ErrNoRows
+		}
+		return Setting{Code: reportKeySettingCode, Data: mdb.reportKey}, nil
+
 	default:
 		return Setting{Code: code, Data: code}, nil
 	}
 }
 
+// PutSchemaVersion database mock
+func (mdb *MockDB) PutSchemaVersion(version int) error {
+	return nil
+}
+
+// GetSchemaVersion database mock, which is at the version of the release
+func (mdb *MockDB) GetSchemaVersion() (int, error) {
+	return SchemaVersion, nil
+}
+
+// CreateConfigSettingTables database mock
+func (mdb *MockDB) CreateConfigSettingTables() error {
+	return nil
+}
+
+// ListConfigSettings database mock
+func (mdb *MockDB) ListConfigSettings() ([]ConfigSetting, error) {
+	return []ConfigSetting{
+		{Namespace: "sync", Name: "parallelism", Value: "8", Modified: time.Date(2018, time.May, 1, 10, 0, 0, 0, time.UTC), ModifiedBy: "sv"},
+	}, nil
+}
+
+// PutConfigSetting database mock
+func (mdb *MockDB) PutConfigSetting(setting ConfigSetting) error {
+	return ValidateConfigSetting(setting)
+}
+
+// ListConfigSettingHistory database mock
+func (mdb *MockDB) ListConfigSettingHistory(namespace, name string) ([]ConfigSettingChange, error) {
+	return []ConfigSettingChange{
+		{ID: 1, Namespace: namespace, Name: name, OldValue: "4", NewValue: "8", Changed: time.Date(2018, time.May, 1, 10, 0, 0, 0, time.UTC), ChangedBy: "sv"},
+	}, nil
+}
+
 // PutSetting database mock
 func (mdb *MockDB) PutSetting(setting Setting) error {
-	if setting.Code == "System/abcdef12345678" {
+	switch setting.Code {
+	case "System/abcdef12345678":
 		mdb.encryptedAuthKeyHash = setting.Data
+	case reportKeySettingCode:
+		mdb.reportKey = setting.Data
 	}
 	return nil
 }
@@ -356,13 +440,23 @@ func (mdb *MockDB) CreateSigningLogTable() error {
 	return nil
 }
 
+// CreateSigningLogSearchIndexes database mock
+func (mdb *MockDB) CreateSigningLogSearchIndexes() error {
+	return nil
+}
+
+// MissingIndexes database mock
+func (mdb *MockDB) MissingIndexes(minRows int64) ([]MissingIndex, error) {
+	return []MissingIndex{}, nil
+}
+
 // CreateTestLogTable error mock for the database
 func (mdb *MockDB) CreateTestLogTable() error {
 	return nil
 }
 
 // CheckForDuplicate database mock
-func (mdb *MockDB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
+func (mdb *MockDB) CheckForDuplicate(signLog *SigningLog, scope string) (bool, int, error) {
 	switch signLog.SerialNumber {
 	case "Aduplicate":
 		return true, 3, nil
@@ -409,7 +503,7 @@ func (mdb *MockDB) ListAllowedSigningLog(authorization User) ([]SigningLog, erro
 	}
 
 	for i := 1; i < fromID; i++ {
-		signingLog = append(signingLog, SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Created: time.Now()})
+		signingLog = append(signingLog, SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Created: time.Now(), Details: map[string]string{"mac": fmt.Sprintf("00:11:22:33:44:%02d", i)}})
 	}
 	return signingLog, nil
 }
@@ -419,6 +513,27 @@ func (mdb *MockDB) ListAllowedSigningLogForAccount(authorization User, authority
 	return mdb.ListAllowedSigningLog(authorization)
 }
 
+// SearchAllowedSigningLogForAccount database mock
+func (mdb *MockDB) SearchAllowedSigningLogForAccount(authorization User, authorityID, field, value string) ([]SigningLog, error) {
+	logs, _ := mdb.ListAllowedSigningLog(authorization)
+	signingLog := []SigningLog{}
+	for _, l := range logs {
+		if l.Details[field] == value {
+			signingLog = append(signingLog, l)
+		}
+	}
+	return signingLog, nil
+}
+
+// ListSignedDevices database mock
+func (mdb *MockDB) ListSignedDevices(authorityID string) ([]SignedDevice, error) {
+	devices := []SignedDevice{}
+	for i := 1; i < 5; i++ {
+		devices = append(devices, SignedDevice{Model: "alder", Serial: fmt.Sprintf("A%d", i), Signed: time.Now()})
+	}
+	return devices, nil
+}
+
 // SyncSigningLog database mock
 func (mdb *MockDB) SyncSigningLog() ([]SigningLog, error) {
 	signingLog := []SigningLog{}
@@ -438,23 +553,67 @@ func (mdb *MockDB) AllowedSigningLogFilterValues(authorization User, authorityID
 	return SigningLogFilters{Makes: []string{"System"}, Models: []string{"Router 3400"}}, nil
 }
 
+// CreateSigningLogCheckpointTable database mock
+func (mdb *MockDB) CreateSigningLogCheckpointTable() error {
+	return nil
+}
+
+// CreateSigningLogCheckpoint database mock
+func (mdb *MockDB) CreateSigningLogCheckpoint() (SigningLogCheckpoint, error) {
+	return SigningLogCheckpoint{ID: 1, LogID: 10, Hash: "abcdef", Signature: "123456", Created: time.Now()}, nil
+}
+
+// VerifySigningLog database mock
+func (mdb *MockDB) VerifySigningLog() (SigningLogVerification, error) {
+	return SigningLogVerification{Rows: 10, Checkpoints: 1, Errors: []string{}}, nil
+}
+
+// ResignSigningLogCheckpoints database mock
+func (mdb *MockDB) ResignSigningLogCheckpoints(newSecret string) (int, error) {
+	return 1, nil
+}
+
 // CreateDeviceNonceTable database mock
 func (mdb *MockDB) CreateDeviceNonceTable() error {
 	return nil
 }
 
 // DeleteExpiredDeviceNonces database mock
-func (mdb *MockDB) DeleteExpiredDeviceNonces() error {
-	return nil
+func (mdb *MockDB) DeleteExpiredDeviceNonces() (int, error) {
+	return 0, nil
 }
 
 // CreateDeviceNonce database mock
-func (mdb *MockDB) CreateDeviceNonce() (DeviceNonce, error) {
+func (mdb *MockDB) CreateDeviceNonce(apiKey, clientIP string) (DeviceNonce, error) {
 	return DeviceNonce{Nonce: "1234567890", TimeStamp: 1234567890}, nil
 }
 
+// CreateDeviceNonces database mock
+func (mdb *MockDB) CreateDeviceNonces(apiKey, clientIP string, count int) ([]DeviceNonce, error) {
+	if count < 1 || count > NonceBatchMaximum {
+		return nil, errors.New("MOCK invalid number of nonces")
+	}
+	if apiKey == "ExhaustedAPIKey" {
+		return nil, ErrNonceLimit
+	}
+
+	nonces := []DeviceNonce{}
+	for i := 0; i < count; i++ {
+		nonces = append(nonces, DeviceNonce{Nonce: fmt.Sprintf("123456789%d", i), TimeStamp: 1234567890})
+	}
+	return nonces, nil
+}
+
+// CountDeviceNonces database mock
+func (mdb *MockDB) CountDeviceNonces(apiKey string) (int, error) {
+	if apiKey == "ExhaustedAPIKey" {
+		return NonceOutstandingMaximum, nil
+	}
+	return 10, nil
+}
+
 // ValidateDeviceNonce database mock
-func (mdb *MockDB) ValidateDeviceNonce(nonce string) error {
+func (mdb *MockDB) ValidateDeviceNonce(nonce, apiKey, clientIP string) error {
 	return nil
 }
 
@@ -761,16 +920,206 @@ func (mdb *MockDB) UpsertModelAssert(m ModelAssertion) error {
 	return nil
 }
 
+// CreateModelFallbackKeyTable mock for the create model fallback key table method
+func (mdb *MockDB) CreateModelFallbackKeyTable() error {
+	return nil
+}
+
+// ListModelFallbackKeypairs database mock
+func (mdb *MockDB) ListModelFallbackKeypairs(modelID int) ([]Keypair, error) {
+	return []Keypair{}, nil
+}
+
+// UpdateAllowedModelFallbackKeypairs database mock
+func (mdb *MockDB) UpdateAllowedModelFallbackKeypairs(modelID int, keypairIDs []int, authorization User) error {
+	return nil
+}
+
+// CreateModelCanaryTables database mock
+func (mdb *MockDB) CreateModelCanaryTables() error {
+	return nil
+}
+
+// GetModelCanary database mock, the models do not have a canary signing-key
+func (mdb *MockDB) GetModelCanary(modelID int) (ModelCanary, error) {
+	return ModelCanary{}, nil
+}
+
+// UpdateAllowedModelCanary database mock
+func (mdb *MockDB) UpdateAllowedModelCanary(canary ModelCanary, authorization User) error {
+	return nil
+}
+
+// CreateSerialReplayTable database mock
+func (mdb *MockDB) CreateSerialReplayTable() error {
+	return nil
+}
+
+// GetSerialReplay database mock, the serial-requests have not been signed before
+func (mdb *MockDB) GetSerialReplay(requestHash, apiKey string, window time.Duration) (SerialReplay, error) {
+	return SerialReplay{}, nil
+}
+
+// CreateSerialReplay database mock
+func (mdb *MockDB) CreateSerialReplay(requestHash, apiKey string, replay SerialReplay) error {
+	return nil
+}
+
+// DeleteExpiredSerialReplays database mock
+func (mdb *MockDB) DeleteExpiredSerialReplays(window time.Duration) (int, error) {
+	return 0, nil
+}
+
+// CreateKeypairUsageTable database mock
+func (mdb *MockDB) CreateKeypairUsageTable() error {
+	return nil
+}
+
+// RecordKeypairUsage database mock
+func (mdb *MockDB) RecordKeypairUsage(keypairID int, brandID, model string) (bool, error) {
+	return false, nil
+}
+
+// ListKeypairUsage database mock
+func (mdb *MockDB) ListKeypairUsage(keypairID int) ([]KeypairUsage, error) {
+	return []KeypairUsage{{KeypairID: keypairID, BrandID: "System", Model: "alder", Signed: 10, FirstSigned: time.Now().UTC(), LastSigned: time.Now().UTC()}}, nil
+}
+
+// CreateKeypairModelTable database mock
+func (mdb *MockDB) CreateKeypairModelTable() error {
+	return nil
+}
+
+// ListKeypairModels database mock
+func (mdb *MockDB) ListKeypairModels(keypairID int) ([]KeypairModel, error) {
+	return []KeypairModel{{BrandID: "System", Model: "alder"}}, nil
+}
+
+// ListKeypairModelsByKeyID database mock, the signing-keys can sign for all the models
+func (mdb *MockDB) ListKeypairModelsByKeyID(keyID string) ([]KeypairModel, error) {
+	return []KeypairModel{}, nil
+}
+
+// UpdateAllowedKeypairModels database mock
+func (mdb *MockDB) UpdateAllowedKeypairModels(keypairID int, models []KeypairModel, authorization User) error {
+	return nil
+}
+
+// CreateKeypairCompromiseTable database mock
+func (mdb *MockDB) CreateKeypairCompromiseTable() error {
+	return nil
+}
+
+// CompromiseAllowedKeypair database mock
+func (mdb *MockDB) CompromiseAllowedKeypair(keypairID int, reason string, authorization User) (KeypairCompromise, error) {
+	return KeypairCompromise{ID: 1, KeypairID: keypairID, AuthorityID: "system", KeyID: "61abf588e52be7a3", Reason: reason, ReportedBy: authorization.Username, Created: time.Now()}, nil
+}
+
+// GetKeypairCompromise database mock, the signing-keys are not compromised
+func (mdb *MockDB) GetKeypairCompromise(keypairID int) (KeypairCompromise, error) {
+	return KeypairCompromise{}, sql.ErrNoRows
+}
+
+// ListKeypairCompromises database mock
+func (mdb *MockDB) ListKeypairCompromises() ([]KeypairCompromise, error) {
+	return []KeypairCompromise{}, nil
+}
+
+// CreateKeyDelegationTables database mock
+func (mdb *MockDB) CreateKeyDelegationTables() error {
+	return nil
+}
+
+// GetBrandRootKey database mock, the brands do not have a root key
+func (mdb *MockDB) GetBrandRootKey(authorityID string) (BrandRootKey, error) {
+	return BrandRootKey{}, sql.ErrNoRows
+}
+
+// UpdateAllowedBrandRootKey database mock
+func (mdb *MockDB) UpdateAllowedBrandRootKey(rootKey BrandRootKey, revocation RootKeyRevocation, authorization User) error {
+	return nil
+}
+
+// ListAllowedBrandRootKeys database mock, the brands do not have a root key
+func (mdb *MockDB) ListAllowedBrandRootKeys(authorization User) ([]BrandRootKey, error) {
+	return []BrandRootKey{}, nil
+}
+
+// CreateAllowedKeyDelegation database mock
+func (mdb *MockDB) CreateAllowedKeyDelegation(delegation KeyDelegation, authorization User) (int, error) {
+	return 1, nil
+}
+
+// ListKeyDelegations database mock
+func (mdb *MockDB) ListKeyDelegations(authorityID string) ([]KeyDelegation, error) {
+	return []KeyDelegation{}, nil
+}
+
+// ListKeyDelegationsByKeyID database mock
+func (mdb *MockDB) ListKeyDelegationsByKeyID(keyID string) ([]KeyDelegation, error) {
+	return []KeyDelegation{}, nil
+}
+
+// DeleteAllowedKeyDelegation database mock
+func (mdb *MockDB) DeleteAllowedKeyDelegation(delegationID int, authorization User) error {
+	return nil
+}
+
+// SyncKeyDelegations database mock
+func (mdb *MockDB) SyncKeyDelegations(rootKeys []BrandRootKey, delegations []KeyDelegation) error {
+	return nil
+}
+
+// RecordModelKeyResult database mock
+func (mdb *MockDB) RecordModelKeyResult(modelID, keypairID int, signed bool) error {
+	return nil
+}
+
+// ListModelKeyResults database mock
+func (mdb *MockDB) ListModelKeyResults(modelID int) ([]ModelKeyResult, error) {
+	return []ModelKeyResult{}, nil
+}
+
+// CreateModelKeypairHistoryTable mock for the create model keypair history table method
+func (mdb *MockDB) CreateModelKeypairHistoryTable() error {
+	return nil
+}
+
+// AssignAllowedModelKeypair database mock
+func (mdb *MockDB) AssignAllowedModelKeypair(assignment ModelKeypairAssignment, authorization User) ([]ModelKeypairChange, error) {
+	if err := ValidateModelKeypairAssignment(assignment); err != nil {
+		return nil, err
+	}
+	change := ModelKeypairChange{ModelID: 1, BrandID: "System", Model: "alder", OldKeypairID: assignment.FromKeypairID, NewKeypairID: assignment.KeypairID,
+		OldKeypairIDUser: assignment.FromKeypairID, NewKeypairIDUser: assignment.KeypairID, ChangedBy: authorization.Username}
+	return []ModelKeypairChange{change}, nil
+}
+
+// ListAllowedModelKeypairHistory database mock
+func (mdb *MockDB) ListAllowedModelKeypairHistory(modelID int, authorization User) ([]ModelKeypairChange, error) {
+	return []ModelKeypairChange{}, nil
+}
+
 // CreateSubstoreTable mock for the create substore table method
 func (mdb *MockDB) CreateSubstoreTable() error {
 	return nil
 }
 
+// AlterSubstoreTable mock for the alter substore table method
+func (mdb *MockDB) AlterSubstoreTable() error {
+	return nil
+}
+
 // CreateAllowedSubstore mock to create a substore record
 func (mdb *MockDB) CreateAllowedSubstore(store Substore, authorization User) error {
 	return nil
 }
 
+// CreateAllowedSubstores mock to create substore records
+func (mdb *MockDB) CreateAllowedSubstores(stores []Substore, authorization User) error {
+	return nil
+}
+
 // ListSubstores mock to list substore records
 func (mdb *MockDB) ListSubstores(accountID int, authorization User) ([]Substore, error) {
 	fromModel, _ := mdb.GetAllowedModel(1, authorization)
@@ -808,6 +1157,11 @@ func (mdb *MockDB) GetSubstoreModel(brand, model, serialNumber string) (Substore
 	return mdb.GetSubstore(1, serialNumber)
 }
 
+// PivotTarget mock to get the model of another brand that a sub-store pivots to
+func (mdb *MockDB) PivotTarget(store Substore) (Model, bool, error) {
+	return Model{}, false, nil
+}
+
 // CreateTestLog mock to create a test log
 func (mdb *MockDB) CreateTestLog(testLog TestLog) error {
 	return nil
@@ -847,514 +1201,1942 @@ func (mdb *MockDB) UpdateAllowedTestLog(ID int, authorization User) error {
 	return errors.New("MOCK no permissions to update the test log")
 }
 
-// HealthCheck mock for a healthy datastore
-func (mdb *MockDB) HealthCheck() error {
+// CreateDeviceManifestTable mock for the database
+func (mdb *MockDB) CreateDeviceManifestTable() error {
 	return nil
 }
 
-// -----------------------------------------------------------------------------
+// CreateDeviceManifest mock to create a device manifest
+func (mdb *MockDB) CreateDeviceManifest(manifest DeviceManifest) error {
+	return ValidateDeviceManifest(manifest)
+}
 
-// ErrorMockDB holds the unsuccessful mocks for the database
-type ErrorMockDB struct{}
+// CreateAllowedDeviceManifest mock to create a device manifest
+func (mdb *MockDB) CreateAllowedDeviceManifest(manifest DeviceManifest, authorization User) error {
+	if authorization.Role != Invalid && authorization.Role < SyncUser {
+		return errors.New("Not authorized to create a device manifest")
+	}
+	return ValidateDeviceManifest(manifest)
+}
 
-// CreateModelTable mock for the create model table method
-func (mdb *ErrorMockDB) CreateModelTable() error {
-	return errors.New("Error creating the model table")
+// ListAllowedDeviceManifests database mock
+func (mdb *MockDB) ListAllowedDeviceManifests(authorization User, authorityID string, query DeviceManifestQuery) ([]DeviceManifest, error) {
+	manifests := []DeviceManifest{}
+	for i := 1; i < 3; i++ {
+		manifest := DeviceManifest{
+			ID: i, Brand: "system", Model: "alder", SerialNumber: fmt.Sprintf("A%d", i), ImageVersion: "20260901",
+			Snaps:      []ManifestSnap{{Name: "pc-kernel", Revision: fmt.Sprint(1000 + i)}, {Name: "core22", Revision: "1380"}},
+			Components: []ManifestComponent{{Name: "wifi", Vendor: "Acme", Version: "1.2"}},
+		}
+		if authorityID != manifest.Brand || (query.SerialNumber != "" && query.SerialNumber != manifest.SerialNumber) {
+			continue
+		}
+		if query.Snap == "pc-kernel" && query.Revision != "" && query.Revision != manifest.Snaps[0].Revision {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
 }
 
-// AlterModelTable mock for the alter model table method
-func (mdb *ErrorMockDB) AlterModelTable() error {
+// SyncListDeviceManifests database mock
+func (mdb *MockDB) SyncListDeviceManifests() ([]DeviceManifest, error) {
+	manifests := []DeviceManifest{
+		{ID: 1, Brand: "system", Model: "alder", SerialNumber: "A1", Snaps: []ManifestSnap{{Name: "pc-kernel", Revision: "1001"}}},
+		{ID: 2, Brand: "system", Model: "alder", SerialNumber: "A2", Snaps: []ManifestSnap{{Name: "pc-kernel", Revision: "1002"}}},
+	}
+	return manifests, nil
+}
+
+// SyncDeleteDeviceManifest database mock
+func (mdb *MockDB) SyncDeleteDeviceManifest(ID int) error {
+	if ID > 2 {
+		return errors.New("MOCK error deleting the device manifest")
+	}
 	return nil
 }
 
-// CreateKeypairTable mock for the create keypair table method
-func (mdb *ErrorMockDB) CreateKeypairTable() error {
+// AllowedDashboard database mock
+func (mdb *MockDB) AllowedDashboard(authorization User) (Dashboard, error) {
+	return Dashboard{
+		Signings: []ModelSigningCount{
+			{Make: "System", Model: "Router 3400", Today: 2, Week: 10},
+			{Make: "System", Model: "Alder", Today: 0, Week: 4},
+		},
+		Duplicates: 1,
+	}, nil
+}
+
+// CreateStationTable database mock
+func (mdb *MockDB) CreateStationTable() error {
 	return nil
 }
 
-// AlterKeypairTable mock for the alter keypair table method
-func (mdb *ErrorMockDB) AlterKeypairTable() error {
+// ValidateStation database mock
+func (mdb *MockDB) ValidateStation(modelID int, code string) error {
+	if code == "unregistered" {
+		return errors.New("MOCK the station is not registered for this model")
+	}
 	return nil
 }
 
-// UpdateKeypairAssertion mock to update the account-key assertion of a keypair
-func (mdb *ErrorMockDB) UpdateKeypairAssertion(keypair Keypair, authorization User) (string, error) {
-	return "invalid-assertion", errors.New("MOCK Error updating the keypair assertion")
+// ListAllowedStations database mock
+func (mdb *MockDB) ListAllowedStations(modelID int, authorization User) ([]Station, error) {
+	stations := []Station{}
+	if authorization.Role == Invalid || authorization.Role >= Admin {
+		stations = append(stations, Station{ID: 1, ModelID: modelID, Code: "line-1", Description: "Line one"})
+		stations = append(stations, Station{ID: 2, ModelID: modelID, Code: "line-2", Description: "Line two"})
+	}
+	return stations, nil
 }
 
-// CreateSettingsTable mock for the create settings table method
-func (mdb *ErrorMockDB) CreateSettingsTable() error {
+// CreateAllowedStation database mock
+func (mdb *MockDB) CreateAllowedStation(station Station, authorization User) error {
+	if station.ModelID == 0 || len(station.Code) == 0 {
+		return errors.New("MOCK the model and station must be supplied")
+	}
 	return nil
 }
 
-// CreateAccountTable mock for the create account table method
-func (mdb *ErrorMockDB) CreateAccountTable() error {
+// DeleteAllowedStation database mock
+func (mdb *MockDB) DeleteAllowedStation(stationID int, authorization User) error {
 	return nil
 }
 
-// AlterAccountTable mock for the create account table method
-func (mdb *ErrorMockDB) AlterAccountTable() error {
+// HealthCheck mock for a healthy datastore
+func (mdb *MockDB) HealthCheck() error {
 	return nil
 }
 
-// CreateAccount mock to create an account record
-func (mdb *ErrorMockDB) CreateAccount(account Account) error {
-	return errors.New("MOCK creating the account")
+// CreateInstanceTable database mock
+func (mdb *MockDB) CreateInstanceTable() error {
+	return nil
 }
 
-// GetAccount mock to return a single account key
-func (mdb *ErrorMockDB) GetAccount(authorityID string) (Account, error) {
-
-	accounts, _ := mdb.ListAllowedAccounts(User{})
-
-	for _, acc := range accounts {
-		if acc.AuthorityID == authorityID {
-			return acc, nil
-		}
-	}
-	return Account{}, errors.New("Cannot found the account assertion")
+// RegisterInstance database mock
+func (mdb *MockDB) RegisterInstance(instance Instance) error {
+	return ValidateInstance(instance)
 }
 
-// GetAccountByID mock to return a single account key
-func (mdb *ErrorMockDB) GetAccountByID(ID int, user User) (Account, error) {
+// ListInstances database mock
+func (mdb *MockDB) ListInstances() ([]Instance, error) {
+	now := time.Now().UTC()
+	return []Instance{
+		{ID: 1, InstanceID: "vault-1", Hostname: "vault-1", Version: "2.4-6", Role: InstanceRoleCloud, Mode: "signing", Started: now.Add(-time.Hour), Heartbeat: now},
+		{ID: 2, InstanceID: "factory-1", Hostname: "factory-1", Version: "2.4-5", Role: InstanceRoleFactory, Mode: "sync", Started: now.Add(-48 * time.Hour), Heartbeat: now.Add(-24 * time.Hour)},
+	}, nil
+}
 
-	accounts, _ := mdb.ListAllowedAccounts(user)
+// CreateFactoryCheckInTable database mock
+func (mdb *MockDB) CreateFactoryCheckInTable() error {
+	return nil
+}
 
-	for _, acc := range accounts {
-		if acc.ID == ID {
-			return acc, nil
-		}
-	}
-	return Account{}, errors.New("Cannot found the account assertion")
+// CheckInFactory database mock
+func (mdb *MockDB) CheckInFactory(checkIn FactoryCheckIn) error {
+	return ValidateFactoryCheckIn(checkIn)
 }
 
-// GetAllowedAccount mock to fetch account
-func (mdb *ErrorMockDB) GetAllowedAccount(authorityID string, authorization User) (Account, error) {
-	return Account{}, errors.New("MOCK error getting the account")
+// ListFactoryCheckIns database mock
+func (mdb *MockDB) ListFactoryCheckIns() ([]FactoryCheckIn, error) {
+	now := time.Now().UTC()
+	return []FactoryCheckIn{
+		{ID: 1, InstanceID: "factory-1", Username: "sync", Hostname: "factory-1", Version: "2.4-6", KeystoreHealthy: true, Reported: now.Add(-time.Hour), Received: now.Add(-time.Hour)},
+		{ID: 2, InstanceID: "factory-2", Username: "sync", Hostname: "factory-2", Version: "2.4-5", PendingSigningLogs: 120, KeystoreHealthy: false, KeystoreError: "MOCK keystore error", Reported: now.Add(-72 * time.Hour), ClockSkew: 90, Received: now.Add(-72 * time.Hour)},
+	}, nil
 }
 
-// ListAllowedAccounts mock to return a list of the available accounts
-func (mdb *ErrorMockDB) ListAllowedAccounts(authorization User) ([]Account, error) {
-	return nil, errors.New("Error getting the accounts")
+// TryLeaderLock database mock
+func (mdb *MockDB) TryLeaderLock() (LeaderLock, bool, error) {
+	return mockLeaderLock{}, true, nil
 }
 
-// PutAccount mock to update an account assertion
-func (mdb *ErrorMockDB) PutAccount(account Account, authorization User) (string, error) {
-	return "", errors.New("MOCK error upserting the account")
+// NotifyInvalidation database mock
+func (mdb *MockDB) NotifyInvalidation(payload string) error {
+	return nil
 }
 
-// SyncAccount mock to update the account
-func (mdb *ErrorMockDB) SyncAccount(account Account) error {
-	return errors.New("MOCK error syncing the account")
+// mockLeaderLock is a leader lock that is always held
+type mockLeaderLock struct{}
+
+// Check database mock
+func (l mockLeaderLock) Check(ctx context.Context) error {
+	return nil
 }
 
-// UpdateAccountAssertion mock to update the account assertion
-func (mdb *ErrorMockDB) UpdateAccountAssertion(authorityID, assertion string, resellerAPI bool) error {
+// Release database mock
+func (l mockLeaderLock) Release() error {
 	return nil
 }
 
-// UpdateAccount mock to update the account
-func (mdb *ErrorMockDB) UpdateAccount(account Account, authorization User) error {
+// CreateDeviceStateTable database mock
+func (mdb *MockDB) CreateDeviceStateTable() error {
 	return nil
 }
 
-// ListAllowedModels ModelsList Mock the database response for a list of models
+// GetDeviceState database mock
+func (mdb *MockDB) GetDeviceState(brandID, model, serialNumber string) (string, error) {
+	if serialNumber == "AScrapped" {
+		return DeviceStateScrapped, nil
+	}
+	return "", nil
+}
+
+// ListDeviceRevocations database mock
+func (mdb *MockDB) ListDeviceRevocations(brandID string, states []string) ([]DeviceRevocation, error) {
+	revocations := []DeviceRevocation{}
+	if containsString(states, DeviceStateScrapped) {
+		revocations = append(revocations, DeviceRevocation{Brand: brandID, Model: "alder", SerialNumber: "AScrapped", Fingerprint: "fingerprint", State: DeviceStateScrapped, Revoked: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)})
+	}
+	return revocations, nil
+}
+
+// TransitionAllowedDeviceState database mock
+func (mdb *MockDB) TransitionAllowedDeviceState(state DeviceState, authorization User) (DeviceState, error) {
+	if err := ValidateDeviceStateTransition("", state.State); err != nil {
+		return DeviceState{}, err
+	}
+	state.ID = 1
+	state.CreatedBy = authorization.Username
+	state.Created = time.Now().UTC()
+	return state, nil
+}
+
+// ListAllowedDeviceStateHistory database mock
+func (mdb *MockDB) ListAllowedDeviceStateHistory(authorization User, brandID, model, serialNumber string) ([]DeviceState, error) {
+	return []DeviceState{
+		{ID: 1, Brand: brandID, Model: model, SerialNumber: serialNumber, State: DeviceStateShipped, CreatedBy: "sv", Created: time.Now().UTC()},
+	}, nil
+}
+
+// CreateDeviceQuarantineTable database mock
+func (mdb *MockDB) CreateDeviceQuarantineTable() error {
+	return nil
+}
+
+// CheckDeviceQuarantine database mock
+func (mdb *MockDB) CheckDeviceQuarantine(brandID, serialNumber, fingerprint string) (DeviceQuarantine, error) {
+	if serialNumber == "AQuarantined" {
+		return DeviceQuarantine{ID: 1, Brand: brandID, SerialNumber: serialNumber, Reason: "Recalled batch", Source: QuarantineSourceManual, CreatedBy: "sv", Created: time.Now().UTC()}, nil
+	}
+	return DeviceQuarantine{}, sql.ErrNoRows
+}
+
+// QuarantineAllowedDevices database mock
+func (mdb *MockDB) QuarantineAllowedDevices(brandID string, devices []DeviceQuarantine, source string, authorization User) (DeviceQuarantineResult, error) {
+	for _, q := range devices {
+		q.Brand = brandID
+		if err := ValidateDeviceQuarantine(q); err != nil {
+			return DeviceQuarantineResult{}, err
+		}
+	}
+	return DeviceQuarantineResult{Quarantined: len(devices)}, nil
+}
+
+// ListAllowedDeviceQuarantine database mock
+func (mdb *MockDB) ListAllowedDeviceQuarantine(authorization User, brandID string) ([]DeviceQuarantine, error) {
+	return []DeviceQuarantine{
+		{ID: 1, Brand: "System", SerialNumber: "AQuarantined", Reason: "Recalled batch", Source: QuarantineSourceManual, CreatedBy: "sv", Created: time.Now().UTC()},
+	}, nil
+}
+
+// DeleteAllowedDeviceQuarantine database mock
+func (mdb *MockDB) DeleteAllowedDeviceQuarantine(brandID string, quarantineID int, authorization User) error {
+	return nil
+}
+
+// SyncDeviceQuarantine database mock
+func (mdb *MockDB) SyncDeviceQuarantine(devices []DeviceQuarantine) error {
+	return nil
+}
+
+// CreateDeviceCertificateTable database mock
+func (mdb *MockDB) CreateDeviceCertificateTable() error {
+	return nil
+}
+
+// CreateDeviceCertificate database mock
+func (mdb *MockDB) CreateDeviceCertificate(cert DeviceCertificate) error {
+	return nil
+}
+
+// GetDeviceCertificate database mock
+func (mdb *MockDB) GetDeviceCertificate(certificateSerial string) (DeviceCertificate, error) {
+	return DeviceCertificate{}, sql.ErrNoRows
+}
+
+// GetLatestDeviceCertificate database mock
+func (mdb *MockDB) GetLatestDeviceCertificate(brandID, model, serialNumber string) (DeviceCertificate, error) {
+	return DeviceCertificate{}, sql.ErrNoRows
+}
+
+// RevokeDeviceCertificates database mock
+func (mdb *MockDB) RevokeDeviceCertificates(brandID, model, serialNumber string, revoked bool) error {
+	return nil
+}
+
+// ListRevokedDeviceCertificates database mock
+func (mdb *MockDB) ListRevokedDeviceCertificates() ([]DeviceCertificate, error) {
+	return []DeviceCertificate{}, nil
+}
+
+// CreateBillingTables database mock
+func (mdb *MockDB) CreateBillingTables() error {
+	return nil
+}
+
+// GetBillingPeriod database mock
+func (mdb *MockDB) GetBillingPeriod(period string) (BillingPeriod, error) {
+	from, to, err := ParseBillingPeriod(period)
+	if err != nil {
+		return BillingPeriod{}, err
+	}
+	return BillingPeriod{Period: period, From: from, To: to, Usage: []BillingUsage{{AuthorityID: "system", Model: "alder", Signings: 3, Devices: 2}}}, nil
+}
+
+// ListBillingPeriods database mock
+func (mdb *MockDB) ListBillingPeriods() ([]BillingPeriod, error) {
+	return []BillingPeriod{}, nil
+}
+
+// CloseBillingPeriod database mock
+func (mdb *MockDB) CloseBillingPeriod(period, closedBy string) (BillingPeriod, error) {
+	p, err := mdb.GetBillingPeriod(period)
+	if err != nil {
+		return p, err
+	}
+	if time.Now().UTC().Before(p.To) {
+		return BillingPeriod{}, ErrBillingPeriodOpen
+	}
+	p.Closed = true
+	p.ClosedBy = closedBy
+	p.Digest = BillingDigest(p.Usage)
+	return p, nil
+}
+
+// CreateOnboardingTable database mock
+func (mdb *MockDB) CreateOnboardingTable() error {
+	return nil
+}
+
+// CreateOnboarding database mock
+func (mdb *MockDB) CreateOnboarding(onboarding Onboarding) (Onboarding, error) {
+	if err := ValidateOnboarding(nil, onboarding); err != nil {
+		return onboarding, err
+	}
+	onboarding.ID = 1
+	onboarding.Token = "ValidOnboardingToken"
+	onboarding.Status = OnboardingPending
+	onboarding.Created = time.Now().UTC()
+	return onboarding, nil
+}
+
+// GetOnboarding database mock
+func (mdb *MockDB) GetOnboarding(onboardingID int) (Onboarding, error) {
+	if onboardingID != 1 {
+		return Onboarding{}, ErrOnboardingNotFound
+	}
+	return mockOnboarding(), nil
+}
+
+// GetOnboardingByToken database mock
+func (mdb *MockDB) GetOnboardingByToken(token string) (Onboarding, error) {
+	if token != "ValidOnboardingToken" {
+		return Onboarding{}, errors.New("Invalid onboarding token")
+	}
+	return mockOnboarding(), nil
+}
+
+// ListOnboardings database mock
+func (mdb *MockDB) ListOnboardings(status string) ([]Onboarding, error) {
+	return []Onboarding{mockOnboarding()}, nil
+}
+
+// DecideOnboarding database mock, for a pending onboarding
+func (mdb *MockDB) DecideOnboarding(onboardingID int, approved bool, decidedBy, reason string) (Onboarding, error) {
+	o, err := mdb.GetOnboarding(onboardingID)
+	if err != nil {
+		return o, err
+	}
+	err = o.Decide(approved, decidedBy, reason, time.Now().UTC())
+	return o, err
+}
+
+// FinishOnboarding database mock
+func (mdb *MockDB) FinishOnboarding(onboardingID int, status, apiKey, reason string) error {
+	return nil
+}
+
+func mockOnboarding() Onboarding {
+	return Onboarding{ID: 1, AuthorityID: "newbrand", Username: "newadmin", Name: "New Admin", Email: "admin@newbrand.com",
+		KeyName: "newbrand-key", Generate: true, Model: "alder", Status: OnboardingPending, Created: time.Now().UTC()}
+}
+
+// CreateApprovalTable database mock
+func (mdb *MockDB) CreateApprovalTable() error {
+	return nil
+}
+
+// RequestApproval database mock, creating a pending approval
+func (mdb *MockDB) RequestApproval(approval Approval) (Approval, bool, error) {
+	if err := ValidateApproval(approval); err != nil {
+		return approval, false, err
+	}
+	approval.ID = 1
+	approval.Status = ApprovalPending
+	approval.Created = time.Now().UTC()
+	return approval, true, nil
+}
+
+// DecideApproval database mock, for a pending approval requested by user1
+func (mdb *MockDB) DecideApproval(approvalID int, approved bool, authorization User) (Approval, error) {
+	if approvalID != 1 {
+		return Approval{}, ErrApprovalNotFound
+	}
+	approval := Approval{ID: 1, Operation: ApprovalKeypairEnable, Target: "keypair/1", AuthorityID: "System", RequestedBy: "user1", Status: ApprovalPending, Created: time.Now().UTC()}
+	err := approval.Decide(approved, authorization.Username, time.Now().UTC())
+	return approval, err
+}
+
+// ListAllowedApprovals database mock
+func (mdb *MockDB) ListAllowedApprovals(authorization User, status string) ([]Approval, error) {
+	approvals := []Approval{
+		{ID: 2, Operation: ApprovalModelSigningKey, Target: "model/1", AuthorityID: "System", Details: "keypair-id=2", RequestedBy: "sv", Status: ApprovalApproved, DecidedBy: "root", Created: time.Now().UTC()},
+		{ID: 1, Operation: ApprovalKeypairEnable, Target: "keypair/1", AuthorityID: "System", RequestedBy: "user1", Status: ApprovalPending, Created: time.Now().UTC()},
+	}
+
+	filtered := []Approval{}
+	for _, a := range approvals {
+		if len(status) == 0 || a.Status == status {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered, nil
+}
+
+// CreateAuditLogTable database mock
+func (mdb *MockDB) CreateAuditLogTable() error {
+	return nil
+}
+
+// CreateAuditEntry database mock
+func (mdb *MockDB) CreateAuditEntry(entry AuditEntry) (AuditEntry, error) {
+	entry.ID = 1
+	entry.Created = time.Now().UTC()
+	return entry, nil
+}
+
+// ListAuditLog database mock
+func (mdb *MockDB) ListAuditLog(impersonator string) ([]AuditEntry, error) {
+	entries := []AuditEntry{
+		{ID: 2, Username: "sv", Impersonator: "root", Brand: "system", Action: "PUT /v1/models/1", Status: 200, Created: time.Now().UTC()},
+		{ID: 1, Username: "sv", Impersonator: "root", Brand: "system", Action: AuditImpersonationStart, Created: time.Now().UTC()},
+	}
+
+	filtered := []AuditEntry{}
+	for _, e := range entries {
+		if len(impersonator) == 0 || e.Impersonator == impersonator {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
+
+// CreateSigningLogSinkQueueTable database mock
+func (mdb *MockDB) CreateSigningLogSinkQueueTable() error {
+	return nil
+}
+
+// QueueSigningLogSink database mock
+func (mdb *MockDB) QueueSigningLogSink(signLog SigningLog, reason string) error {
+	return nil
+}
+
+// ListSigningLogSinkQueue database mock
+func (mdb *MockDB) ListSigningLogSinkQueue() ([]SigningLogSinkEntry, error) {
+	return []SigningLogSinkEntry{}, nil
+}
+
+// DeleteSigningLogSinkQueue database mock
+func (mdb *MockDB) DeleteSigningLogSinkQueue(id int) error {
+	return nil
+}
+
+// ListSigningLogForBrand database mock
+func (mdb *MockDB) ListSigningLogForBrand(authorityID string) ([]SigningLog, error) {
+	return []SigningLog{
+		{ID: 1, Make: authorityID, Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Revision: 1, Created: time.Now()},
+		{ID: 2, Make: authorityID, Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Revision: 2, Created: time.Now()},
+	}, nil
+}
+
+// ListSigningLogForKeypair database mock
+func (mdb *MockDB) ListSigningLogForKeypair(keyID string) ([]SigningLog, error) {
+	return []SigningLog{
+		{ID: 1, Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Revision: 1, Created: time.Now(), Signer: &SigningAudit{KeypairID: 1, KeyID: keyID}},
+	}, nil
+}
+
+// FindSignedDevices database mock
+func (mdb *MockDB) FindSignedDevices(authorityID, serialNumber string) ([]SignedDevice, error) {
+	devices := []SignedDevice{}
+	for i := 1; i < 5; i++ {
+		if serialNumber == fmt.Sprintf("A%d", i) {
+			devices = append(devices, SignedDevice{Model: "alder", Serial: serialNumber, Signed: time.Now()})
+		}
+	}
+	return devices, nil
+}
+
+// ListSerialSigningLog database mock
+func (mdb *MockDB) ListSerialSigningLog(make, model, serialNumber string) ([]SigningLog, error) {
+	signingLogs := []SigningLog{}
+	for i := 1; i < 5; i++ {
+		if make == "system" && model == "alder" && serialNumber == fmt.Sprintf("A%d", i) {
+			signingLogs = append(signingLogs, SigningLog{ID: i, Make: make, Model: model, SerialNumber: serialNumber, Fingerprint: fmt.Sprintf("a%d", i), Revision: 1, Created: time.Now()})
+		}
+	}
+	return signingLogs, nil
+}
+
+// ImportSigningLog database mock
+func (mdb *MockDB) ImportSigningLog(signLog SigningLog) (string, error) {
+	if signLog.SerialNumber == "A1" {
+		return ImportDuplicate, nil
+	}
+	return ImportCreated, nil
+}
+
+// ListAllowedReplicationSigningLog database mock
+func (mdb *MockDB) ListAllowedReplicationSigningLog(authorization User, afterID, limit int) ([]SigningLog, error) {
+	signingLog := []SigningLog{}
+	for i := afterID + 1; i <= 10 && len(signingLog) < limit; i++ {
+		signingLog = append(signingLog, SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Revision: 1, Created: time.Now()})
+	}
+	return signingLog, nil
+}
+
+// TailAllowedSigningLog database mock
+func (mdb *MockDB) TailAllowedSigningLog(authorization User, afterID, limit int) ([]SigningLog, error) {
+	if afterID < 0 {
+		afterID = 9
+	}
+	signingLog := []SigningLog{}
+	for i := afterID + 1; i <= 10 && len(signingLog) < limit; i++ {
+		signingLog = append(signingLog, SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Revision: 1, Created: time.Now()})
+	}
+	return signingLog, nil
+}
+
+// CreateSigningLogAnnotationTable database mock
+func (mdb *MockDB) CreateSigningLogAnnotationTable() error {
+	return nil
+}
+
+// CreateAllowedSigningLogAnnotation database mock
+func (mdb *MockDB) CreateAllowedSigningLogAnnotation(annotation SigningLogAnnotation, authorization User) (SigningLogAnnotation, error) {
+	if err := ValidateSigningLogAnnotation(annotation); err != nil {
+		return SigningLogAnnotation{}, err
+	}
+	if annotation.SigningLogID != 1 {
+		return SigningLogAnnotation{}, errors.New("Cannot find the signing log entry")
+	}
+	annotation.ID = 1
+	annotation.CreatedBy = authorization.Username
+	annotation.Created = time.Now().UTC()
+	return annotation, nil
+}
+
+// CreateShareTokenTable database mock
+func (mdb *MockDB) CreateShareTokenTable() error {
+	return nil
+}
+
+// CreateAllowedShareToken database mock
+func (mdb *MockDB) CreateAllowedShareToken(token ShareToken, authorization User) (ShareToken, error) {
+	if err := ValidateShareToken(token, time.Now().UTC()); err != nil {
+		return ShareToken{}, err
+	}
+	token.ID = 1
+	token.Token = "ValidShareToken"
+	token.CreatedBy = authorization.Username
+	token.Created = time.Now().UTC()
+	return token, nil
+}
+
+// ListAllowedShareTokens database mock
+func (mdb *MockDB) ListAllowedShareTokens(authorization User, authorityID string) ([]ShareToken, error) {
+	return []ShareToken{
+		{ID: 1, BrandID: authorityID, Models: []string{"alder"}, Description: "Distributor", CreatedBy: "sv", Created: time.Now().UTC(), Expires: time.Now().UTC().Add(DefaultShareTokenLifetime)},
+	}, nil
+}
+
+// DeleteAllowedShareToken database mock
+func (mdb *MockDB) DeleteAllowedShareToken(tokenID int, authorization User) error {
+	return nil
+}
+
+// GetShareToken database mock
+func (mdb *MockDB) GetShareToken(token string) (ShareToken, error) {
+	if token != "ValidShareToken" {
+		return ShareToken{}, errors.New("Invalid share token")
+	}
+	return ShareToken{ID: 1, BrandID: "system", Models: []string{"alder"}, Description: "Distributor", CreatedBy: "sv", Created: time.Now().UTC(), Expires: time.Now().UTC().Add(DefaultShareTokenLifetime)}, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// ErrorMockDB holds the unsuccessful mocks for the database
+type ErrorMockDB struct{}
+
+// CreateModelTable mock for the create model table method
+func (mdb *ErrorMockDB) CreateModelTable() error {
+	return errors.New("Error creating the model table")
+}
+
+// AlterModelTable mock for the alter model table method
+func (mdb *ErrorMockDB) AlterModelTable() error {
+	return nil
+}
+
+// CreateKeypairTable mock for the create keypair table method
+func (mdb *ErrorMockDB) CreateKeypairTable() error {
+	return nil
+}
+
+// AlterKeypairTable mock for the alter keypair table method
+func (mdb *ErrorMockDB) AlterKeypairTable() error {
+	return nil
+}
+
+// UpdateKeypairAssertion mock to update the account-key assertion of a keypair
+func (mdb *ErrorMockDB) UpdateKeypairAssertion(keypair Keypair, authorization User) (string, error) {
+	return "invalid-assertion", errors.New("MOCK Error updating the keypair assertion")
+}
+
+// CreateSettingsTable mock for the create settings table method
+func (mdb *ErrorMockDB) CreateSettingsTable() error {
+	return nil
+}
+
+// CreateAccountTable mock for the create account table method
+func (mdb *ErrorMockDB) CreateAccountTable() error {
+	return nil
+}
+
+// AlterAccountTable mock for the create account table method
+func (mdb *ErrorMockDB) AlterAccountTable() error {
+	return nil
+}
+
+// CreateAccount mock to create an account record
+func (mdb *ErrorMockDB) CreateAccount(account Account) error {
+	return errors.New("MOCK creating the account")
+}
+
+// GetAccount mock to return a single account key
+func (mdb *ErrorMockDB) GetAccount(authorityID string) (Account, error) {
+
+	accounts, _ := mdb.ListAllowedAccounts(User{})
+
+	for _, acc := range accounts {
+		if acc.AuthorityID == authorityID {
+			return acc, nil
+		}
+	}
+	return Account{}, errors.New("Cannot found the account assertion")
+}
+
+// GetAccountByID mock to return a single account key
+func (mdb *ErrorMockDB) GetAccountByID(ID int, user User) (Account, error) {
+
+	accounts, _ := mdb.ListAllowedAccounts(user)
+
+	for _, acc := range accounts {
+		if acc.ID == ID {
+			return acc, nil
+		}
+	}
+	return Account{}, errors.New("Cannot found the account assertion")
+}
+
+// GetAllowedAccount mock to fetch account
+func (mdb *ErrorMockDB) GetAllowedAccount(authorityID string, authorization User) (Account, error) {
+	return Account{}, errors.New("MOCK error getting the account")
+}
+
+// ListAllowedAccounts mock to return a list of the available accounts
+func (mdb *ErrorMockDB) ListAllowedAccounts(authorization User) ([]Account, error) {
+	return nil, errors.New("Error getting the accounts")
+}
+
+// PutAccount mock to update an account assertion
+func (mdb *ErrorMockDB) PutAccount(account Account, authorization User) (string, error) {
+	return "", errors.New("MOCK error upserting the account")
+}
+
+// SyncAccount mock to update the account
+func (mdb *ErrorMockDB) SyncAccount(account Account) error {
+	return errors.New("MOCK error syncing the account")
+}
+
+// CreateAccountKeyTable error mock for the database
+func (mdb *ErrorMockDB) CreateAccountKeyTable() error {
+	return nil
+}
+
+// GetAccountKey error mock for the database
+func (mdb *ErrorMockDB) GetAccountKey(authorityID, keyID string) (AccountKey, error) {
+	return AccountKey{}, errors.New("MOCK error retrieving the account-key assertion")
+}
+
+// SyncAccountKey error mock for the database
+func (mdb *ErrorMockDB) SyncAccountKey(key AccountKey) error {
+	return errors.New("MOCK error syncing the account-key assertion")
+}
+
+// UpdateAccountAssertion mock to update the account assertion
+func (mdb *ErrorMockDB) UpdateAccountAssertion(authorityID, assertion string, resellerAPI bool) error {
+	return nil
+}
+
+// UpdateAccount mock to update the account
+func (mdb *ErrorMockDB) UpdateAccount(account Account, authorization User) error {
+	return nil
+}
+
+// ListAllowedModels ModelsList Mock the database response for a list of models
 func (mdb *ErrorMockDB) ListAllowedModels(authorization User) ([]Model, error) {
 	return nil, errors.New("Error getting the models")
 }
 
-// FindModel mocks the database response for finding a model, returning an invalid signing-key
-func (mdb *ErrorMockDB) FindModel(brandID, modelName, apiKey string) (Model, error) {
-	return Model{}, errors.New("Error finding the model")
+// FindModel mocks the database response for finding a model, returning an invalid signing-key
+func (mdb *ErrorMockDB) FindModel(brandID, modelName, apiKey string) (Model, error) {
+	return Model{}, errors.New("Error finding the model")
+}
+
+// CheckModelExists mocks the database response for finding a model
+func (mdb *ErrorMockDB) CheckModelExists(brandID, modelName string) bool {
+	return false
+}
+
+// ListModelsMissingKeypairs error mock for the database
+func (mdb *ErrorMockDB) ListModelsMissingKeypairs() ([]Model, error) {
+	return nil, errors.New("MOCK error listing the models")
+}
+
+// ListNearDuplicateModels mocks the error finding the near-duplicate models
+func (mdb *ErrorMockDB) ListNearDuplicateModels() ([]NearDuplicateModels, error) {
+	return nil, errors.New("MOCK error listing the model names")
+}
+
+// CheckAPIKey mocks the database response to check the API key
+func (mdb *ErrorMockDB) CheckAPIKey(apiKey string) bool {
+	return true
+}
+
+// GetAllowedModel mocks the model from the database by ID, returning an error.
+func (mdb *ErrorMockDB) GetAllowedModel(modelID int, authorization User) (Model, error) {
+	return Model{}, errors.New("Error retrieving the model")
+}
+
+// UpdateAllowedModel mocks the model update, returning an error.
+func (mdb *ErrorMockDB) UpdateAllowedModel(model Model, authorization User) (string, error) {
+	return "", errors.New("Error updating the database model")
+}
+
+// DeleteAllowedModel mocks the model deletion, returning an error.
+func (mdb *ErrorMockDB) DeleteAllowedModel(model Model, authorization User) (string, error) {
+	return "", errors.New("Error deleting the database model")
+}
+
+// CreateAllowedModel mocks creating a new model, returning an error.
+func (mdb *ErrorMockDB) CreateAllowedModel(model Model, authorization User) (Model, string, error) {
+	return Model{}, "", errors.New("Error creating the database model")
+}
+
+// SyncModel mocks creating a new model, returning an error.
+func (mdb *ErrorMockDB) SyncModel(model Model) error {
+	return errors.New("Error creating the database model")
+}
+
+// GetKeypair error mock for the database
+func (mdb *ErrorMockDB) GetKeypair(keypairID int) (Keypair, error) {
+	keypair := Keypair{AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
+	return keypair, errors.New("Error fetching from the database")
+}
+
+// GetKeypairByPublicID error mock for the database
+func (mdb *ErrorMockDB) GetKeypairByPublicID(auth, keyID string) (Keypair, error) {
+	keypair := Keypair{AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
+	return keypair, errors.New("Error fetching from the database")
+}
+
+// GetKeypairByName mocks getting a keypair by name
+func (mdb *ErrorMockDB) GetKeypairByName(authorityID, keyName string) (Keypair, error) {
+	keypair := Keypair{ID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
+	return keypair, errors.New("Error fetching from the database")
+}
+
+// ListAllowedKeypairs error mock for the database
+func (mdb *ErrorMockDB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
+	var keypairs []Keypair
+	return keypairs, errors.New("MOCK Error fetching from the database")
+}
+
+// PutKeypair error mock for the database
+func (mdb *ErrorMockDB) PutKeypair(keypair Keypair) (string, error) {
+	return "", errors.New("Error updating the database")
+}
+
+// CheckKeypairKeynameExists mock checking for keypair by name
+func (mdb *ErrorMockDB) CheckKeypairKeynameExists(authorityID, name string) bool {
+	if name == "invalid" {
+		return true
+	}
+	return false
+}
+
+// UpdateKeypairSealedKey error mock for the database
+func (mdb *ErrorMockDB) UpdateKeypairSealedKey(keypair SyncKeypair) error {
+	return errors.New("MOCK error updating the sealed signing-key")
+}
+
+// SyncKeypair error mock for the database
+func (mdb *ErrorMockDB) SyncKeypair(keypair SyncKeypair) error {
+	return errors.New("Error updating the database")
+}
+
+// UpdateAllowedKeypairActive error mock for the database
+func (mdb *ErrorMockDB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error {
+	return errors.New("Error updating the database")
+}
+
+// GetSetting error mock for the database
+func (mdb *ErrorMockDB) GetSetting(code string) (Setting, error) {
+	return Setting{Code: code, Data: code}, nil
+}
+
+// PutSchemaVersion error mock for the database
+func (mdb *ErrorMockDB) PutSchemaVersion(version int) error {
+	return errors.New("MOCK error recording the schema version")
+}
+
+// GetSchemaVersion error mock for the database
+func (mdb *ErrorMockDB) GetSchemaVersion() (int, error) {
+	return 0, errors.New("MOCK error fetching the schema version")
+}
+
+// CreateConfigSettingTables error mock for the database
+func (mdb *ErrorMockDB) CreateConfigSettingTables() error {
+	return nil
+}
+
+// ListConfigSettings error mock for the database
+func (mdb *ErrorMockDB) ListConfigSettings() ([]ConfigSetting, error) {
+	return nil, errors.New("MOCK error retrieving the config settings")
+}
+
+// PutConfigSetting error mock for the database
+func (mdb *ErrorMockDB) PutConfigSetting(setting ConfigSetting) error {
+	return errors.New("MOCK error storing the config setting")
+}
+
+// ListConfigSettingHistory error mock for the database
+func (mdb *ErrorMockDB) ListConfigSettingHistory(namespace, name string) ([]ConfigSettingChange, error) {
+	return nil, errors.New("MOCK error retrieving the config setting history")
+}
+
+// PutSetting error mock for the database
+func (mdb *ErrorMockDB) PutSetting(setting Setting) error {
+	return nil
+}
+
+// CheckForDuplicate error mock for the database
+func (mdb *ErrorMockDB) CheckForDuplicate(signLog *SigningLog, scope string) (bool, int, error) {
+	return false, 0, nil
+}
+
+// CheckForMatching error mock for the database
+func (mdb *ErrorMockDB) CheckForMatching(signLog SigningLog) (bool, error) {
+	return false, nil
+}
+
+// CreateSigningLog error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLog(signLog SigningLog) error {
+	return nil
+}
+
+// CreateSigningLogSync error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogSync(signLog SigningLog) error {
+	return nil
+}
+
+// CreateSigningLogTable error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogTable() error {
+	return nil
+}
+
+// CreateSigningLogSearchIndexes error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogSearchIndexes() error {
+	return nil
+}
+
+// MissingIndexes error mock for the database
+func (mdb *ErrorMockDB) MissingIndexes(minRows int64) ([]MissingIndex, error) {
+	return nil, errors.New("MOCK error checking the indexes")
+}
+
+// CreateTestLogTable error mock for the database
+func (mdb *ErrorMockDB) CreateTestLogTable() error {
+	return nil
+}
+
+// ListAllowedSigningLog error mock for the database
+func (mdb *ErrorMockDB) ListAllowedSigningLog(authorization User) ([]SigningLog, error) {
+	var signingLog []SigningLog
+	return signingLog, errors.New("Error retrieving the signing logs")
+}
+
+// ListAllowedSigningLogForAccount database mock
+func (mdb *ErrorMockDB) ListAllowedSigningLogForAccount(authorization User, authorityID string) ([]SigningLog, error) {
+	return mdb.ListAllowedSigningLog(authorization)
+}
+
+// SearchAllowedSigningLogForAccount error mock for the database
+func (mdb *ErrorMockDB) SearchAllowedSigningLogForAccount(authorization User, authorityID, field, value string) ([]SigningLog, error) {
+	return nil, errors.New("Error searching the signing logs")
+}
+
+// ListSignedDevices error mock for the database
+func (mdb *ErrorMockDB) ListSignedDevices(authorityID string) ([]SignedDevice, error) {
+	return nil, errors.New("MOCK error retrieving the signed devices")
+}
+
+// SyncSigningLog error mock for the database
+func (mdb *ErrorMockDB) SyncSigningLog() ([]SigningLog, error) {
+	var signingLog []SigningLog
+	return signingLog, errors.New("Error retrieving the signing logs")
+}
+
+// SyncUpdateSigningLog database mock
+func (mdb *ErrorMockDB) SyncUpdateSigningLog(id int) error {
+	return errors.New("Error updating the signing log")
+}
+
+// AllowedSigningLogFilterValues error mock for the database
+func (mdb *ErrorMockDB) AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error) {
+	return SigningLogFilters{}, errors.New("Error retrieving the signing log filters")
+}
+
+// CreateSigningLogCheckpointTable error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogCheckpointTable() error {
+	return errors.New("MOCK error creating the signing log checkpoint table")
+}
+
+// CreateSigningLogCheckpoint error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogCheckpoint() (SigningLogCheckpoint, error) {
+	return SigningLogCheckpoint{}, errors.New("MOCK error creating the signing log checkpoint")
+}
+
+// VerifySigningLog error mock for the database
+func (mdb *ErrorMockDB) VerifySigningLog() (SigningLogVerification, error) {
+	return SigningLogVerification{Rows: 10, Checkpoints: 1, Errors: []string{"signing log 5 has been modified, or a preceding entry has been deleted"}}, nil
+}
+
+// ResignSigningLogCheckpoints error mock for the database
+func (mdb *ErrorMockDB) ResignSigningLogCheckpoints(newSecret string) (int, error) {
+	return 0, errors.New("MOCK error re-signing the signing log checkpoints")
+}
+
+// CreateDeviceNonceTable error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceNonceTable() error {
+	return nil
+}
+
+// DeleteExpiredDeviceNonces error mock for the database
+func (mdb *ErrorMockDB) DeleteExpiredDeviceNonces() (int, error) {
+	return 0, errors.New("MOCK error deleting the expired nonces")
+}
+
+// CreateDeviceNonce error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceNonce(apiKey, clientIP string) (DeviceNonce, error) {
+	return DeviceNonce{}, errors.New("MOCK error generating the nonce")
+}
+
+// CreateDeviceNonces error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceNonces(apiKey, clientIP string, count int) ([]DeviceNonce, error) {
+	return nil, errors.New("MOCK error generating the nonces")
+}
+
+// CountDeviceNonces error mock for the database
+func (mdb *ErrorMockDB) CountDeviceNonces(apiKey string) (int, error) {
+	return 0, errors.New("MOCK error counting the nonces")
+}
+
+// ValidateDeviceNonce error mock for the database
+func (mdb *ErrorMockDB) ValidateDeviceNonce(nonce, apiKey, clientIP string) error {
+	return errors.New("MOCK error validating a nonce")
+}
+
+// CreateOpenidNonceTable database mock
+func (mdb *ErrorMockDB) CreateOpenidNonceTable() error {
+	return nil
+}
+
+// CreateOpenidNonce database mock
+func (mdb *ErrorMockDB) CreateOpenidNonce(nonce OpenidNonce) error {
+	return errors.New("MOCK error generating the nonce")
+}
+
+// CheckUserInAccount verifies that a user has permissions to a specific account
+func (mdb *ErrorMockDB) CheckUserInAccount(username, authorityID string) bool {
+	return true
+}
+
+// CreateUserTable mock for creating database User table operation
+func (mdb *ErrorMockDB) CreateUserTable() error {
+	return errors.New("Could not create User table")
+}
+
+// CreateAccountUserLinkTable mock for creating database AccountUserLink table operation
+func (mdb *ErrorMockDB) CreateAccountUserLinkTable() error {
+	return errors.New("Could not create AccountUserLink table")
+}
+
+// AlterUserTable mock for modifications on User table operation
+func (mdb *ErrorMockDB) AlterUserTable() error {
+	return errors.New("Could not alter User table")
+}
+
+// CreateUser error mock for create user operation
+func (mdb *ErrorMockDB) CreateUser(user User) (int, error) {
+	return 0, errors.New("Cannot create user")
+}
+
+// ListUsers mock returning an error for list users operation
+func (mdb *ErrorMockDB) ListUsers() ([]User, error) {
+	return []User{}, errors.New("Could not retrieve users list")
+}
+
+// FindUsers mock returning an error for find users operation
+func (mdb *ErrorMockDB) FindUsers(query string) ([]User, error) {
+	return []User{}, errors.New("Could not find any user")
+}
+
+// GetUser mock returning an error for get user operation
+func (mdb *ErrorMockDB) GetUser(userID int) (User, error) {
+	return User{}, errors.New("Cannot get the user")
+}
+
+// GetUserByUsername returns error for get user by username operation
+func (mdb *ErrorMockDB) GetUserByUsername(username string) (User, error) {
+	return User{}, errors.New("Cannot get the user")
+}
+
+// GetUserByAPIKey returns error for get user by username operation
+func (mdb *ErrorMockDB) GetUserByAPIKey(apiKey, username string) (User, error) {
+	return User{}, errors.New("Cannot get the user")
+}
+
+// UpdateUser mock returning an error for update user operation
+func (mdb *ErrorMockDB) UpdateUser(user User) error {
+	return errors.New("Cannot update the user")
+}
+
+// DeleteUser mock returning an error for delete user operation
+func (mdb *ErrorMockDB) DeleteUser(userID int) error {
+	return errors.New("Cannot delete the user")
+}
+
+// ListUserAccounts mock returning an error for list user accounts operation
+func (mdb *ErrorMockDB) ListUserAccounts(username string) ([]Account, error) {
+	return []Account{}, errors.New("Could not get accounts for that user")
+}
+
+// ListNotUserAccounts mock returning an error for list non-user accounts operation
+func (mdb *ErrorMockDB) ListNotUserAccounts(username string) ([]Account, error) {
+	return []Account{}, errors.New("Could not get accounts not related to that user")
+}
+
+// ListAccountUsers mock returning an error for list account users operation
+func (mdb *ErrorMockDB) ListAccountUsers(authorityID string) ([]User, error) {
+	return []User{}, errors.New("Could not get any user for that account")
+}
+
+// CreateKeypairStatusTable mock the database creation with error
+func (mdb *ErrorMockDB) CreateKeypairStatusTable() error {
+	return errors.New("Could not create the Keypair Status table")
+}
+
+// AlterKeypairStatusTable mock the database creation
+func (mdb *ErrorMockDB) AlterKeypairStatusTable() error {
+	return errors.New("Could not update the Keypair Status table")
+}
+
+// CreateKeypairStatus mocks the creation of a keypair status record
+func (mdb *ErrorMockDB) CreateKeypairStatus(ks KeypairStatus) (int, error) {
+	return 0, errors.New("Cannot create keypair status record")
 }
 
-// CheckModelExists mocks the database response for finding a model
-func (mdb *ErrorMockDB) CheckModelExists(brandID, modelName string) bool {
-	return false
+// UpdateKeypairStatus mocks the update of a keypair status record
+func (mdb *ErrorMockDB) UpdateKeypairStatus(ks KeypairStatus) error {
+	return errors.New("Cannot update keypair status record")
 }
 
-// CheckAPIKey mocks the database response to check the API key
-func (mdb *ErrorMockDB) CheckAPIKey(apiKey string) bool {
-	return true
+// ListAllowedKeypairStatus lists the keypair statuses
+func (mdb *ErrorMockDB) ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error) {
+	return []KeypairStatus{}, errors.New("Cannot update keypair status record")
 }
 
-// GetAllowedModel mocks the model from the database by ID, returning an error.
-func (mdb *ErrorMockDB) GetAllowedModel(modelID int, authorization User) (Model, error) {
-	return Model{}, errors.New("Error retrieving the model")
+// GetKeypairStatus fetches a single keypair status record
+func (mdb *ErrorMockDB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error) {
+	return KeypairStatus{}, errors.New("Cannot find the keypair status")
+}
+
+// DeleteKeypairStatus removes a keypair status
+func (mdb *ErrorMockDB) DeleteKeypairStatus(ks KeypairStatus) error {
+	return errors.New("Cannot delete the keypair status")
+}
+
+// CreateModelAssertTable mock for creating database model assertion headers table
+func (mdb *ErrorMockDB) CreateModelAssertTable() error {
+	return errors.New("Cannot create the model assertion table")
+}
+
+// CreateModelAssert mock for creating model assertion record
+func (mdb *ErrorMockDB) CreateModelAssert(m ModelAssertion) (int, error) {
+	return 0, errors.New("Cannot create the model assertion record")
+}
+
+// AlterModelAssertTable mock for the alter model assertion table method
+func (mdb *ErrorMockDB) AlterModelAssertTable() error {
+	return errors.New("Cannot amend the model assertion table")
+}
+
+// UpdateModelAssert mock for updating model assertion record
+func (mdb *ErrorMockDB) UpdateModelAssert(m ModelAssertion) error {
+	return errors.New("Cannot update the model assertion record")
+}
+
+// GetModelAssert mock for updating model assertion record
+func (mdb *ErrorMockDB) GetModelAssert(modelID int) (ModelAssertion, error) {
+	return ModelAssertion{}, errors.New("Cannot find the model assertion record")
+}
+
+// UpsertModelAssert mock for creating or updating model assertion record
+func (mdb *ErrorMockDB) UpsertModelAssert(m ModelAssertion) error {
+	return errors.New("Cannot upsert the model assertion record")
+}
+
+// CreateModelFallbackKeyTable mock for the create model fallback key table method
+func (mdb *ErrorMockDB) CreateModelFallbackKeyTable() error {
+	return nil
+}
+
+// CreateModelCanaryTables mock for the create model canary tables method
+func (mdb *ErrorMockDB) CreateModelCanaryTables() error {
+	return nil
+}
+
+// ListModelFallbackKeypairs error mock for the database
+func (mdb *ErrorMockDB) ListModelFallbackKeypairs(modelID int) ([]Keypair, error) {
+	return nil, errors.New("MOCK error retrieving the fallback keypairs")
+}
+
+// UpdateAllowedModelFallbackKeypairs error mock for the database
+func (mdb *ErrorMockDB) UpdateAllowedModelFallbackKeypairs(modelID int, keypairIDs []int, authorization User) error {
+	return errors.New("MOCK error updating the fallback keypairs")
+}
+
+// GetModelCanary error mock for the database
+func (mdb *ErrorMockDB) GetModelCanary(modelID int) (ModelCanary, error) {
+	return ModelCanary{}, errors.New("MOCK error retrieving the canary keypair")
+}
+
+// UpdateAllowedModelCanary error mock for the database
+func (mdb *ErrorMockDB) UpdateAllowedModelCanary(canary ModelCanary, authorization User) error {
+	return errors.New("MOCK error updating the canary keypair")
+}
+
+// CreateSerialReplayTable error mock for the database
+func (mdb *ErrorMockDB) CreateSerialReplayTable() error {
+	return errors.New("MOCK error creating the serial replay table")
+}
+
+// GetSerialReplay error mock for the database
+func (mdb *ErrorMockDB) GetSerialReplay(requestHash, apiKey string, window time.Duration) (SerialReplay, error) {
+	return SerialReplay{}, errors.New("MOCK error retrieving the serial replay")
+}
+
+// CreateSerialReplay error mock for the database
+func (mdb *ErrorMockDB) CreateSerialReplay(requestHash, apiKey string, replay SerialReplay) error {
+	return errors.New("MOCK error storing the serial replay")
+}
+
+// DeleteExpiredSerialReplays error mock for the database
+func (mdb *ErrorMockDB) DeleteExpiredSerialReplays(window time.Duration) (int, error) {
+	return 0, errors.New("MOCK error deleting the serial replays")
+}
+
+// CreateKeypairUsageTable error mock for the database
+func (mdb *ErrorMockDB) CreateKeypairUsageTable() error {
+	return errors.New("MOCK error creating the signing-key usage table")
+}
+
+// RecordKeypairUsage error mock for the database
+func (mdb *ErrorMockDB) RecordKeypairUsage(keypairID int, brandID, model string) (bool, error) {
+	return false, errors.New("MOCK error storing the signing-key usage")
+}
+
+// ListKeypairUsage error mock for the database
+func (mdb *ErrorMockDB) ListKeypairUsage(keypairID int) ([]KeypairUsage, error) {
+	return nil, errors.New("MOCK error retrieving the signing-key usage")
+}
+
+// CreateKeypairModelTable error mock for the database
+func (mdb *ErrorMockDB) CreateKeypairModelTable() error {
+	return errors.New("MOCK error creating the signing-key model table")
+}
+
+// ListKeypairModels error mock for the database
+func (mdb *ErrorMockDB) ListKeypairModels(keypairID int) ([]KeypairModel, error) {
+	return nil, errors.New("MOCK error retrieving the model allowlist")
+}
+
+// ListKeypairModelsByKeyID error mock for the database
+func (mdb *ErrorMockDB) ListKeypairModelsByKeyID(keyID string) ([]KeypairModel, error) {
+	return nil, errors.New("MOCK error retrieving the model allowlist")
+}
+
+// UpdateAllowedKeypairModels error mock for the database
+func (mdb *ErrorMockDB) UpdateAllowedKeypairModels(keypairID int, models []KeypairModel, authorization User) error {
+	return errors.New("MOCK error storing the model allowlist")
+}
+
+// CreateKeypairCompromiseTable error mock for the database
+func (mdb *ErrorMockDB) CreateKeypairCompromiseTable() error {
+	return errors.New("MOCK error creating the keypair compromise table")
+}
+
+// CompromiseAllowedKeypair error mock for the database
+func (mdb *ErrorMockDB) CompromiseAllowedKeypair(keypairID int, reason string, authorization User) (KeypairCompromise, error) {
+	return KeypairCompromise{}, errors.New("MOCK error compromising the signing-key")
+}
+
+// GetKeypairCompromise error mock for the database
+func (mdb *ErrorMockDB) GetKeypairCompromise(keypairID int) (KeypairCompromise, error) {
+	return KeypairCompromise{}, errors.New("MOCK error retrieving the compromised signing-key")
+}
+
+// ListKeypairCompromises error mock for the database
+func (mdb *ErrorMockDB) ListKeypairCompromises() ([]KeypairCompromise, error) {
+	return nil, errors.New("MOCK error retrieving the compromised signing-keys")
+}
+
+// CreateKeyDelegationTables error mock for the database
+func (mdb *ErrorMockDB) CreateKeyDelegationTables() error {
+	return errors.New("MOCK error creating the key delegation tables")
+}
+
+// GetBrandRootKey error mock for the database
+func (mdb *ErrorMockDB) GetBrandRootKey(authorityID string) (BrandRootKey, error) {
+	return BrandRootKey{}, errors.New("MOCK error retrieving the brand root key")
+}
+
+// UpdateAllowedBrandRootKey error mock for the database
+func (mdb *ErrorMockDB) UpdateAllowedBrandRootKey(rootKey BrandRootKey, revocation RootKeyRevocation, authorization User) error {
+	return errors.New("MOCK error storing the brand root key")
+}
+
+// ListAllowedBrandRootKeys error mock for the database
+func (mdb *ErrorMockDB) ListAllowedBrandRootKeys(authorization User) ([]BrandRootKey, error) {
+	return nil, errors.New("MOCK error retrieving the brand root keys")
+}
+
+// CreateAllowedKeyDelegation error mock for the database
+func (mdb *ErrorMockDB) CreateAllowedKeyDelegation(delegation KeyDelegation, authorization User) (int, error) {
+	return 0, errors.New("MOCK error storing the key delegation")
+}
+
+// ListKeyDelegations error mock for the database
+func (mdb *ErrorMockDB) ListKeyDelegations(authorityID string) ([]KeyDelegation, error) {
+	return nil, errors.New("MOCK error retrieving the key delegations")
+}
+
+// ListKeyDelegationsByKeyID error mock for the database
+func (mdb *ErrorMockDB) ListKeyDelegationsByKeyID(keyID string) ([]KeyDelegation, error) {
+	return nil, errors.New("MOCK error retrieving the key delegations")
+}
+
+// DeleteAllowedKeyDelegation error mock for the database
+func (mdb *ErrorMockDB) DeleteAllowedKeyDelegation(delegationID int, authorization User) error {
+	return errors.New("MOCK error removing the key delegation")
+}
+
+// SyncKeyDelegations error mock for the database
+func (mdb *ErrorMockDB) SyncKeyDelegations(rootKeys []BrandRootKey, delegations []KeyDelegation) error {
+	return errors.New("MOCK error syncing the key delegations")
+}
+
+// RecordModelKeyResult error mock for the database
+func (mdb *ErrorMockDB) RecordModelKeyResult(modelID, keypairID int, signed bool) error {
+	return errors.New("MOCK error storing the signing result")
+}
+
+// ListModelKeyResults error mock for the database
+func (mdb *ErrorMockDB) ListModelKeyResults(modelID int) ([]ModelKeyResult, error) {
+	return nil, errors.New("MOCK error retrieving the signing results")
+}
+
+// CreateModelKeypairHistoryTable mock for the create model keypair history table method
+func (mdb *ErrorMockDB) CreateModelKeypairHistoryTable() error {
+	return nil
+}
+
+// AssignAllowedModelKeypair error mock for the database
+func (mdb *ErrorMockDB) AssignAllowedModelKeypair(assignment ModelKeypairAssignment, authorization User) ([]ModelKeypairChange, error) {
+	return nil, errors.New("MOCK error assigning the keypair of the models")
+}
+
+// ListAllowedModelKeypairHistory error mock for the database
+func (mdb *ErrorMockDB) ListAllowedModelKeypairHistory(modelID int, authorization User) ([]ModelKeypairChange, error) {
+	return nil, errors.New("MOCK error retrieving the keypair history of the model")
+}
+
+// CreateSubstoreTable mock for the create substore table method
+func (mdb *ErrorMockDB) CreateSubstoreTable() error {
+	return nil
+}
+
+// AlterSubstoreTable mock for the alter substore table method
+func (mdb *ErrorMockDB) AlterSubstoreTable() error {
+	return nil
+}
+
+// CreateAllowedSubstore mock to create a substore record
+func (mdb *ErrorMockDB) CreateAllowedSubstore(store Substore, authorization User) error {
+	return errors.New("Cannot create the sub-store model")
+}
+
+// CreateAllowedSubstores mock to create substore records
+func (mdb *ErrorMockDB) CreateAllowedSubstores(stores []Substore, authorization User) error {
+	return errors.New("Cannot create the sub-stores")
+}
+
+// ListSubstores mock to list substore records
+func (mdb *ErrorMockDB) ListSubstores(accountID int, authorization User) ([]Substore, error) {
+	fromModel, _ := mdb.GetAllowedModel(1, authorization)
+
+	substores := []Substore{
+		{ID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc1234", ModelName: "alder-mybrand"},
+		{ID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc5678", ModelName: "alder-mybrand"},
+	}
+
+	return substores, errors.New("Cannot list the sub-stores")
+}
+
+// UpdateAllowedSubstore mock to update a substore record
+func (mdb *ErrorMockDB) UpdateAllowedSubstore(store Substore, authorization User) error {
+	return errors.New("Cannot update the sub-store model")
+}
+
+// DeleteAllowedSubstore mock to update a substore record
+func (mdb *ErrorMockDB) DeleteAllowedSubstore(storeID int, authorization User) (string, error) {
+	return "", errors.New("Cannot delete the sub-store model")
+}
+
+// GetSubstore mock to get a substore record
+func (mdb *ErrorMockDB) GetSubstore(fromModelID int, serialNumber string) (Substore, error) {
+	return Substore{}, errors.New("Cannot get the sub-store model")
+}
+
+// GetSubstoreModel mock to get a substore record
+func (mdb *ErrorMockDB) GetSubstoreModel(brand, model, serialNumber string) (Substore, error) {
+	return Substore{}, errors.New("Cannot get the sub-store model")
+}
+
+// PivotTarget mock to get the model of another brand that a sub-store pivots to
+func (mdb *ErrorMockDB) PivotTarget(store Substore) (Model, bool, error) {
+	return Model{}, false, errors.New("Cannot get the sub-store account")
+}
+
+// CreateTestLog mock to create a test log
+func (mdb *ErrorMockDB) CreateTestLog(testLog TestLog) error {
+	return errors.New("MOCK Cannot create the test log")
+}
+
+// ListAllowedTestLog database mock
+func (mdb *ErrorMockDB) ListAllowedTestLog(authorization User) ([]TestLog, error) {
+	return nil, errors.New("MOCK Cannot fetch the test logs")
+}
+
+// SyncListTestLogs database mock
+func (mdb *ErrorMockDB) SyncListTestLogs() ([]TestLog, error) {
+	return nil, errors.New("MOCK Cannot fetch the test logs")
+}
+
+// SyncDeleteTestLog database mock
+func (mdb *ErrorMockDB) SyncDeleteTestLog(ID int) error {
+	return errors.New("MOCK error deleting the test log")
+}
+
+// UpdateAllowedTestLog database mock
+func (mdb *ErrorMockDB) UpdateAllowedTestLog(ID int, authorization User) error {
+	return errors.New("MOCK error updating the test log")
+}
+
+// CreateDeviceManifestTable error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceManifestTable() error {
+	return nil
+}
+
+// CreateDeviceManifest error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceManifest(manifest DeviceManifest) error {
+	return errors.New("MOCK error creating the device manifest")
+}
+
+// CreateAllowedDeviceManifest error mock for the database
+func (mdb *ErrorMockDB) CreateAllowedDeviceManifest(manifest DeviceManifest, authorization User) error {
+	return errors.New("MOCK error creating the device manifest")
+}
+
+// ListAllowedDeviceManifests error mock for the database
+func (mdb *ErrorMockDB) ListAllowedDeviceManifests(authorization User, authorityID string, query DeviceManifestQuery) ([]DeviceManifest, error) {
+	return nil, errors.New("MOCK error retrieving the device manifests")
+}
+
+// SyncListDeviceManifests error mock for the database
+func (mdb *ErrorMockDB) SyncListDeviceManifests() ([]DeviceManifest, error) {
+	return nil, errors.New("MOCK error retrieving the device manifests")
+}
+
+// SyncDeleteDeviceManifest error mock for the database
+func (mdb *ErrorMockDB) SyncDeleteDeviceManifest(ID int) error {
+	return errors.New("MOCK error deleting the device manifest")
+}
+
+// HealthCheck mock to simulate failed HealthCheck
+func (mdb *ErrorMockDB) HealthCheck() error {
+	return errors.New("Health check failed")
+}
+
+// CreateInstanceTable error mock for the database
+func (mdb *ErrorMockDB) CreateInstanceTable() error {
+	return nil
+}
+
+// RegisterInstance error mock for the database
+func (mdb *ErrorMockDB) RegisterInstance(instance Instance) error {
+	return errors.New("MOCK error registering the instance")
+}
+
+// ListInstances error mock for the database
+func (mdb *ErrorMockDB) ListInstances() ([]Instance, error) {
+	return nil, errors.New("MOCK error retrieving the instances")
+}
+
+// CreateFactoryCheckInTable error mock for the database
+func (mdb *ErrorMockDB) CreateFactoryCheckInTable() error {
+	return nil
+}
+
+// CheckInFactory error mock for the database
+func (mdb *ErrorMockDB) CheckInFactory(checkIn FactoryCheckIn) error {
+	return errors.New("MOCK error recording the factory check-in")
+}
+
+// ListFactoryCheckIns error mock for the database
+func (mdb *ErrorMockDB) ListFactoryCheckIns() ([]FactoryCheckIn, error) {
+	return nil, errors.New("MOCK error retrieving the factory check-ins")
+}
+
+// TryLeaderLock error mock for the database
+func (mdb *ErrorMockDB) TryLeaderLock() (LeaderLock, bool, error) {
+	return nil, false, errors.New("MOCK error taking the leader lock")
+}
+
+// NotifyInvalidation error mock for the database
+func (mdb *ErrorMockDB) NotifyInvalidation(payload string) error {
+	return errors.New("MOCK error sending the invalidation event")
+}
+
+// CreateDeviceStateTable error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceStateTable() error {
+	return nil
+}
+
+// GetDeviceState error mock for the database
+func (mdb *ErrorMockDB) GetDeviceState(brandID, model, serialNumber string) (string, error) {
+	return "", errors.New("MOCK error retrieving the device state")
+}
+
+// ListDeviceRevocations error mock for the database
+func (mdb *ErrorMockDB) ListDeviceRevocations(brandID string, states []string) ([]DeviceRevocation, error) {
+	return nil, errors.New("MOCK error retrieving the device revocations")
+}
+
+// TransitionAllowedDeviceState error mock for the database
+func (mdb *ErrorMockDB) TransitionAllowedDeviceState(state DeviceState, authorization User) (DeviceState, error) {
+	return DeviceState{}, errors.New("MOCK error changing the device state")
+}
+
+// ListAllowedDeviceStateHistory error mock for the database
+func (mdb *ErrorMockDB) ListAllowedDeviceStateHistory(authorization User, brandID, model, serialNumber string) ([]DeviceState, error) {
+	return nil, errors.New("MOCK error retrieving the device states")
+}
+
+// CreateDeviceQuarantineTable error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceQuarantineTable() error {
+	return nil
 }
 
-// UpdateAllowedModel mocks the model update, returning an error.
-func (mdb *ErrorMockDB) UpdateAllowedModel(model Model, authorization User) (string, error) {
-	return "", errors.New("Error updating the database model")
+// CheckDeviceQuarantine error mock for the database
+func (mdb *ErrorMockDB) CheckDeviceQuarantine(brandID, serialNumber, fingerprint string) (DeviceQuarantine, error) {
+	return DeviceQuarantine{}, errors.New("MOCK error retrieving the device quarantine")
 }
 
-// DeleteAllowedModel mocks the model deletion, returning an error.
-func (mdb *ErrorMockDB) DeleteAllowedModel(model Model, authorization User) (string, error) {
-	return "", errors.New("Error deleting the database model")
+// QuarantineAllowedDevices error mock for the database
+func (mdb *ErrorMockDB) QuarantineAllowedDevices(brandID string, devices []DeviceQuarantine, source string, authorization User) (DeviceQuarantineResult, error) {
+	return DeviceQuarantineResult{}, errors.New("MOCK error quarantining the devices")
 }
 
-// CreateAllowedModel mocks creating a new model, returning an error.
-func (mdb *ErrorMockDB) CreateAllowedModel(model Model, authorization User) (Model, string, error) {
-	return Model{}, "", errors.New("Error creating the database model")
+// ListAllowedDeviceQuarantine error mock for the database
+func (mdb *ErrorMockDB) ListAllowedDeviceQuarantine(authorization User, brandID string) ([]DeviceQuarantine, error) {
+	return nil, errors.New("MOCK error retrieving the quarantined devices")
 }
 
-// SyncModel mocks creating a new model, returning an error.
-func (mdb *ErrorMockDB) SyncModel(model Model) error {
-	return errors.New("Error creating the database model")
+// DeleteAllowedDeviceQuarantine error mock for the database
+func (mdb *ErrorMockDB) DeleteAllowedDeviceQuarantine(brandID string, quarantineID int, authorization User) error {
+	return errors.New("MOCK error releasing the quarantined device")
 }
 
-// GetKeypair error mock for the database
-func (mdb *ErrorMockDB) GetKeypair(keypairID int) (Keypair, error) {
-	keypair := Keypair{AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
-	return keypair, errors.New("Error fetching from the database")
+// SyncDeviceQuarantine error mock for the database
+func (mdb *ErrorMockDB) SyncDeviceQuarantine(devices []DeviceQuarantine) error {
+	return errors.New("MOCK error syncing the quarantined devices")
 }
 
-// GetKeypairByPublicID error mock for the database
-func (mdb *ErrorMockDB) GetKeypairByPublicID(auth, keyID string) (Keypair, error) {
-	keypair := Keypair{AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
-	return keypair, errors.New("Error fetching from the database")
+// CreateDeviceCertificateTable error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceCertificateTable() error {
+	return nil
 }
 
-// GetKeypairByName mocks getting a keypair by name
-func (mdb *ErrorMockDB) GetKeypairByName(authorityID, keyName string) (Keypair, error) {
-	keypair := Keypair{ID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
-	return keypair, errors.New("Error fetching from the database")
+// CreateDeviceCertificate error mock for the database
+func (mdb *ErrorMockDB) CreateDeviceCertificate(cert DeviceCertificate) error {
+	return errors.New("MOCK error storing the device certificate")
 }
 
-// ListAllowedKeypairs error mock for the database
-func (mdb *ErrorMockDB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
-	var keypairs []Keypair
-	return keypairs, errors.New("MOCK Error fetching from the database")
+// GetDeviceCertificate error mock for the database
+func (mdb *ErrorMockDB) GetDeviceCertificate(certificateSerial string) (DeviceCertificate, error) {
+	return DeviceCertificate{}, errors.New("MOCK error retrieving the device certificate")
 }
 
-// PutKeypair error mock for the database
-func (mdb *ErrorMockDB) PutKeypair(keypair Keypair) (string, error) {
-	return "", errors.New("Error updating the database")
+// GetLatestDeviceCertificate error mock for the database
+func (mdb *ErrorMockDB) GetLatestDeviceCertificate(brandID, model, serialNumber string) (DeviceCertificate, error) {
+	return DeviceCertificate{}, errors.New("MOCK error retrieving the device certificate")
 }
 
-// CheckKeypairKeynameExists mock checking for keypair by name
-func (mdb *ErrorMockDB) CheckKeypairKeynameExists(authorityID, name string) bool {
-	if name == "invalid" {
-		return true
-	}
-	return false
+// RevokeDeviceCertificates error mock for the database
+func (mdb *ErrorMockDB) RevokeDeviceCertificates(brandID, model, serialNumber string, revoked bool) error {
+	return errors.New("MOCK error revoking the device certificates")
 }
 
-// SyncKeypair error mock for the database
-func (mdb *ErrorMockDB) SyncKeypair(keypair SyncKeypair) error {
-	return errors.New("Error updating the database")
+// ListRevokedDeviceCertificates error mock for the database
+func (mdb *ErrorMockDB) ListRevokedDeviceCertificates() ([]DeviceCertificate, error) {
+	return nil, errors.New("MOCK error retrieving the revoked device certificates")
 }
 
-// UpdateAllowedKeypairActive error mock for the database
-func (mdb *ErrorMockDB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error {
-	return errors.New("Error updating the database")
+// CreateAuditLogTable error mock for the database
+func (mdb *ErrorMockDB) CreateAuditLogTable() error {
+	return errors.New("MOCK error creating the audit log table")
 }
 
-// GetSetting error mock for the database
-func (mdb *ErrorMockDB) GetSetting(code string) (Setting, error) {
-	return Setting{Code: code, Data: code}, nil
+// CreateAuditEntry error mock for the database
+func (mdb *ErrorMockDB) CreateAuditEntry(entry AuditEntry) (AuditEntry, error) {
+	return entry, errors.New("MOCK error recording the audit log entry")
 }
 
-// PutSetting error mock for the database
-func (mdb *ErrorMockDB) PutSetting(setting Setting) error {
-	return nil
+// ListAuditLog error mock for the database
+func (mdb *ErrorMockDB) ListAuditLog(impersonator string) ([]AuditEntry, error) {
+	return nil, errors.New("MOCK error listing the audit log")
 }
 
-// CheckForDuplicate error mock for the database
-func (mdb *ErrorMockDB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
-	return false, 0, nil
+// CreateBillingTables error mock for the database
+func (mdb *ErrorMockDB) CreateBillingTables() error {
+	return nil
 }
 
-// CheckForMatching error mock for the database
-func (mdb *ErrorMockDB) CheckForMatching(signLog SigningLog) (bool, error) {
-	return false, nil
+// GetBillingPeriod error mock for the database
+func (mdb *ErrorMockDB) GetBillingPeriod(period string) (BillingPeriod, error) {
+	return BillingPeriod{}, errors.New("MOCK error retrieving the billing period")
 }
 
-// CreateSigningLog error mock for the database
-func (mdb *ErrorMockDB) CreateSigningLog(signLog SigningLog) error {
-	return nil
+// ListBillingPeriods error mock for the database
+func (mdb *ErrorMockDB) ListBillingPeriods() ([]BillingPeriod, error) {
+	return nil, errors.New("MOCK error retrieving the billing periods")
 }
 
-// CreateSigningLogSync error mock for the database
-func (mdb *ErrorMockDB) CreateSigningLogSync(signLog SigningLog) error {
-	return nil
+// CloseBillingPeriod error mock for the database
+func (mdb *ErrorMockDB) CloseBillingPeriod(period, closedBy string) (BillingPeriod, error) {
+	return BillingPeriod{}, errors.New("MOCK error closing the billing period")
 }
 
-// CreateSigningLogTable error mock for the database
-func (mdb *ErrorMockDB) CreateSigningLogTable() error {
+// CreateOnboardingTable error mock for the database
+func (mdb *ErrorMockDB) CreateOnboardingTable() error {
 	return nil
 }
 
-// CreateTestLogTable error mock for the database
-func (mdb *ErrorMockDB) CreateTestLogTable() error {
-	return nil
+// CreateOnboarding error mock for the database
+func (mdb *ErrorMockDB) CreateOnboarding(onboarding Onboarding) (Onboarding, error) {
+	return onboarding, errors.New("MOCK error creating the onboarding")
 }
 
-// ListAllowedSigningLog error mock for the database
-func (mdb *ErrorMockDB) ListAllowedSigningLog(authorization User) ([]SigningLog, error) {
-	var signingLog []SigningLog
-	return signingLog, errors.New("Error retrieving the signing logs")
+// GetOnboarding error mock for the database
+func (mdb *ErrorMockDB) GetOnboarding(onboardingID int) (Onboarding, error) {
+	return Onboarding{}, errors.New("MOCK error retrieving the onboarding")
 }
 
-// ListAllowedSigningLogForAccount database mock
-func (mdb *ErrorMockDB) ListAllowedSigningLogForAccount(authorization User, authorityID string) ([]SigningLog, error) {
-	return mdb.ListAllowedSigningLog(authorization)
+// GetOnboardingByToken error mock for the database
+func (mdb *ErrorMockDB) GetOnboardingByToken(token string) (Onboarding, error) {
+	return Onboarding{}, errors.New("MOCK error retrieving the onboarding")
 }
 
-// SyncSigningLog error mock for the database
-func (mdb *ErrorMockDB) SyncSigningLog() ([]SigningLog, error) {
-	var signingLog []SigningLog
-	return signingLog, errors.New("Error retrieving the signing logs")
+// ListOnboardings error mock for the database
+func (mdb *ErrorMockDB) ListOnboardings(status string) ([]Onboarding, error) {
+	return nil, errors.New("MOCK error retrieving the onboardings")
 }
 
-// SyncUpdateSigningLog database mock
-func (mdb *ErrorMockDB) SyncUpdateSigningLog(id int) error {
-	return errors.New("Error updating the signing log")
+// DecideOnboarding error mock for the database
+func (mdb *ErrorMockDB) DecideOnboarding(onboardingID int, approved bool, decidedBy, reason string) (Onboarding, error) {
+	return Onboarding{}, errors.New("MOCK error deciding the onboarding")
 }
 
-// AllowedSigningLogFilterValues error mock for the database
-func (mdb *ErrorMockDB) AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error) {
-	return SigningLogFilters{}, errors.New("Error retrieving the signing log filters")
+// FinishOnboarding error mock for the database
+func (mdb *ErrorMockDB) FinishOnboarding(onboardingID int, status, apiKey, reason string) error {
+	return errors.New("MOCK error finishing the onboarding")
 }
 
-// CreateDeviceNonceTable error mock for the database
-func (mdb *ErrorMockDB) CreateDeviceNonceTable() error {
+// CreateApprovalTable error mock for the database
+func (mdb *ErrorMockDB) CreateApprovalTable() error {
 	return nil
 }
 
-// DeleteExpiredDeviceNonces error mock for the database
-func (mdb *ErrorMockDB) DeleteExpiredDeviceNonces() error {
-	return nil
+// RequestApproval error mock for the database
+func (mdb *ErrorMockDB) RequestApproval(approval Approval) (Approval, bool, error) {
+	return approval, false, errors.New("MOCK error requesting the approval")
 }
 
-// CreateDeviceNonce error mock for the database
-func (mdb *ErrorMockDB) CreateDeviceNonce() (DeviceNonce, error) {
-	return DeviceNonce{}, errors.New("MOCK error generating the nonce")
+// DecideApproval error mock for the database
+func (mdb *ErrorMockDB) DecideApproval(approvalID int, approved bool, authorization User) (Approval, error) {
+	return Approval{}, errors.New("MOCK error deciding the approval")
 }
 
-// ValidateDeviceNonce error mock for the database
-func (mdb *ErrorMockDB) ValidateDeviceNonce(nonce string) error {
-	return errors.New("MOCK error validating a nonce")
+// ListAllowedApprovals error mock for the database
+func (mdb *ErrorMockDB) ListAllowedApprovals(authorization User, status string) ([]Approval, error) {
+	return nil, errors.New("MOCK error retrieving the approvals")
 }
 
-// CreateOpenidNonceTable database mock
-func (mdb *ErrorMockDB) CreateOpenidNonceTable() error {
+// CreateSigningLogSinkQueueTable error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogSinkQueueTable() error {
 	return nil
 }
 
-// CreateOpenidNonce database mock
-func (mdb *ErrorMockDB) CreateOpenidNonce(nonce OpenidNonce) error {
-	return errors.New("MOCK error generating the nonce")
+// QueueSigningLogSink error mock for the database
+func (mdb *ErrorMockDB) QueueSigningLogSink(signLog SigningLog, reason string) error {
+	return errors.New("MOCK error queuing the signing log")
 }
 
-// CheckUserInAccount verifies that a user has permissions to a specific account
-func (mdb *ErrorMockDB) CheckUserInAccount(username, authorityID string) bool {
-	return true
+// ListSigningLogSinkQueue error mock for the database
+func (mdb *ErrorMockDB) ListSigningLogSinkQueue() ([]SigningLogSinkEntry, error) {
+	return nil, errors.New("MOCK error retrieving the signing log queue")
 }
 
-// CreateUserTable mock for creating database User table operation
-func (mdb *ErrorMockDB) CreateUserTable() error {
-	return errors.New("Could not create User table")
+// DeleteSigningLogSinkQueue error mock for the database
+func (mdb *ErrorMockDB) DeleteSigningLogSinkQueue(id int) error {
+	return errors.New("MOCK error deleting the queued signing log")
 }
 
-// CreateAccountUserLinkTable mock for creating database AccountUserLink table operation
-func (mdb *ErrorMockDB) CreateAccountUserLinkTable() error {
-	return errors.New("Could not create AccountUserLink table")
+// ListSigningLogForBrand error mock for the database
+func (mdb *ErrorMockDB) ListSigningLogForBrand(authorityID string) ([]SigningLog, error) {
+	return nil, errors.New("MOCK error retrieving the signing logs")
 }
 
-// AlterUserTable mock for modifications on User table operation
-func (mdb *ErrorMockDB) AlterUserTable() error {
-	return errors.New("Could not alter User table")
+// ListSigningLogForKeypair error mock for the database
+func (mdb *ErrorMockDB) ListSigningLogForKeypair(keyID string) ([]SigningLog, error) {
+	return nil, errors.New("MOCK error retrieving the signing logs")
 }
 
-// CreateUser error mock for create user operation
-func (mdb *ErrorMockDB) CreateUser(user User) (int, error) {
-	return 0, errors.New("Cannot create user")
+// FindSignedDevices error mock for the database
+func (mdb *ErrorMockDB) FindSignedDevices(authorityID, serialNumber string) ([]SignedDevice, error) {
+	return nil, errors.New("MOCK error finding the signed devices")
 }
 
-// ListUsers mock returning an error for list users operation
-func (mdb *ErrorMockDB) ListUsers() ([]User, error) {
-	return []User{}, errors.New("Could not retrieve users list")
+// ListSerialSigningLog error mock for the database
+func (mdb *ErrorMockDB) ListSerialSigningLog(make, model, serialNumber string) ([]SigningLog, error) {
+	return nil, errors.New("MOCK error retrieving the signing logs")
 }
 
-// FindUsers mock returning an error for find users operation
-func (mdb *ErrorMockDB) FindUsers(query string) ([]User, error) {
-	return []User{}, errors.New("Could not find any user")
+// ImportSigningLog error mock for the database
+func (mdb *ErrorMockDB) ImportSigningLog(signLog SigningLog) (string, error) {
+	return "", errors.New("MOCK error importing the signing log")
 }
 
-// GetUser mock returning an error for get user operation
-func (mdb *ErrorMockDB) GetUser(userID int) (User, error) {
-	return User{}, errors.New("Cannot get the user")
+// ListAllowedReplicationSigningLog error mock for the database
+func (mdb *ErrorMockDB) ListAllowedReplicationSigningLog(authorization User, afterID, limit int) ([]SigningLog, error) {
+	return nil, errors.New("MOCK error listing the signing log for replication")
 }
 
-// GetUserByUsername returns error for get user by username operation
-func (mdb *ErrorMockDB) GetUserByUsername(username string) (User, error) {
-	return User{}, errors.New("Cannot get the user")
+// TailAllowedSigningLog error mock for the database
+func (mdb *ErrorMockDB) TailAllowedSigningLog(authorization User, afterID, limit int) ([]SigningLog, error) {
+	return nil, errors.New("MOCK error following the signing log")
 }
 
-// GetUserByAPIKey returns error for get user by username operation
-func (mdb *ErrorMockDB) GetUserByAPIKey(apiKey, username string) (User, error) {
-	return User{}, errors.New("Cannot get the user")
+// CreateSigningLogAnnotationTable error mock for the database
+func (mdb *ErrorMockDB) CreateSigningLogAnnotationTable() error {
+	return errors.New("MOCK error creating the signing log annotation table")
 }
 
-// UpdateUser mock returning an error for update user operation
-func (mdb *ErrorMockDB) UpdateUser(user User) error {
-	return errors.New("Cannot update the user")
+// CreateAllowedSigningLogAnnotation error mock for the database
+func (mdb *ErrorMockDB) CreateAllowedSigningLogAnnotation(annotation SigningLogAnnotation, authorization User) (SigningLogAnnotation, error) {
+	return SigningLogAnnotation{}, errors.New("MOCK error annotating the signing log")
 }
 
-// DeleteUser mock returning an error for delete user operation
-func (mdb *ErrorMockDB) DeleteUser(userID int) error {
-	return errors.New("Cannot delete the user")
+// CreateShareTokenTable error mock for the database
+func (mdb *ErrorMockDB) CreateShareTokenTable() error {
+	return errors.New("MOCK error creating the share token table")
 }
 
-// ListUserAccounts mock returning an error for list user accounts operation
-func (mdb *ErrorMockDB) ListUserAccounts(username string) ([]Account, error) {
-	return []Account{}, errors.New("Could not get accounts for that user")
+// CreateAllowedShareToken error mock for the database
+func (mdb *ErrorMockDB) CreateAllowedShareToken(token ShareToken, authorization User) (ShareToken, error) {
+	return ShareToken{}, errors.New("MOCK error creating the share token")
 }
 
-// ListNotUserAccounts mock returning an error for list non-user accounts operation
-func (mdb *ErrorMockDB) ListNotUserAccounts(username string) ([]Account, error) {
-	return []Account{}, errors.New("Could not get accounts not related to that user")
+// ListAllowedShareTokens error mock for the database
+func (mdb *ErrorMockDB) ListAllowedShareTokens(authorization User, authorityID string) ([]ShareToken, error) {
+	return nil, errors.New("MOCK error retrieving the share tokens")
 }
 
-// ListAccountUsers mock returning an error for list account users operation
-func (mdb *ErrorMockDB) ListAccountUsers(authorityID string) ([]User, error) {
-	return []User{}, errors.New("Could not get any user for that account")
+// DeleteAllowedShareToken error mock for the database
+func (mdb *ErrorMockDB) DeleteAllowedShareToken(tokenID int, authorization User) error {
+	return errors.New("MOCK error deleting the share token")
 }
 
-// CreateKeypairStatusTable mock the database creation with error
-func (mdb *ErrorMockDB) CreateKeypairStatusTable() error {
-	return errors.New("Could not create the Keypair Status table")
+// GetShareToken error mock for the database
+func (mdb *ErrorMockDB) GetShareToken(token string) (ShareToken, error) {
+	return ShareToken{}, errors.New("MOCK error retrieving the share token")
 }
 
-// AlterKeypairStatusTable mock the database creation
-func (mdb *ErrorMockDB) AlterKeypairStatusTable() error {
-	return errors.New("Could not update the Keypair Status table")
+// AllowedDashboard error mock for the database
+func (mdb *ErrorMockDB) AllowedDashboard(authorization User) (Dashboard, error) {
+	return Dashboard{}, errors.New("MOCK error retrieving the dashboard")
 }
 
-// CreateKeypairStatus mocks the creation of a keypair status record
-func (mdb *ErrorMockDB) CreateKeypairStatus(ks KeypairStatus) (int, error) {
-	return 0, errors.New("Cannot create keypair status record")
+// CreateStationTable error mock for the database
+func (mdb *ErrorMockDB) CreateStationTable() error {
+	return errors.New("MOCK error creating the station table")
 }
 
-// UpdateKeypairStatus mocks the update of a keypair status record
-func (mdb *ErrorMockDB) UpdateKeypairStatus(ks KeypairStatus) error {
-	return errors.New("Cannot update keypair status record")
+// ValidateStation error mock for the database
+func (mdb *ErrorMockDB) ValidateStation(modelID int, code string) error {
+	return errors.New("MOCK error validating the station")
 }
 
-// ListAllowedKeypairStatus lists the keypair statuses
-func (mdb *ErrorMockDB) ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error) {
-	return []KeypairStatus{}, errors.New("Cannot update keypair status record")
+// ListAllowedStations error mock for the database
+func (mdb *ErrorMockDB) ListAllowedStations(modelID int, authorization User) ([]Station, error) {
+	return nil, errors.New("MOCK error retrieving the stations")
 }
 
-// GetKeypairStatus fetches a single keypair status record
-func (mdb *ErrorMockDB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error) {
-	return KeypairStatus{}, errors.New("Cannot find the keypair status")
+// CreateAllowedStation error mock for the database
+func (mdb *ErrorMockDB) CreateAllowedStation(station Station, authorization User) error {
+	return errors.New("MOCK error creating the station")
 }
 
-// DeleteKeypairStatus removes a keypair status
-func (mdb *ErrorMockDB) DeleteKeypairStatus(ks KeypairStatus) error {
-	return errors.New("Cannot delete the keypair status")
+// DeleteAllowedStation error mock for the database
+func (mdb *ErrorMockDB) DeleteAllowedStation(stationID int, authorization User) error {
+	return errors.New("MOCK error deleting the station")
 }
 
-// CreateModelAssertTable mock for creating database model assertion headers table
-func (mdb *ErrorMockDB) CreateModelAssertTable() error {
-	return errors.New("Cannot create the model assertion table")
+// AllowedProductionReport database mock
+func (mdb *MockDB) AllowedProductionReport(authorization User, authorityID string, from, to time.Time) (ProductionReport, error) {
+	return ProductionReport{
+		AuthorityID: authorityID,
+		From:        from.Format(ReportDateFormat),
+		To:          to.Format(ReportDateFormat),
+		Generated:   time.Date(2018, time.May, 1, 12, 0, 0, 0, time.UTC),
+		Days: []ReportModelDay{
+			{Model: "alder", Day: from.Format(ReportDateFormat), Count: 2},
+			{Model: "alder", Day: to.Format(ReportDateFormat), Count: 1},
+		},
+		Ranges: []ReportSerialRange{
+			{Model: "alder", First: "A1000", Last: "A1002", Serials: 3},
+		},
+	}, nil
 }
 
-// CreateModelAssert mock for creating model assertion record
-func (mdb *ErrorMockDB) CreateModelAssert(m ModelAssertion) (int, error) {
-	return 0, errors.New("Cannot create the model assertion record")
+// AllowedProductionReport error mock for the database
+func (mdb *ErrorMockDB) AllowedProductionReport(authorization User, authorityID string, from, to time.Time) (ProductionReport, error) {
+	return ProductionReport{}, errors.New("MOCK error retrieving the production report")
 }
 
-// AlterModelAssertTable mock for the alter model assertion table method
-func (mdb *ErrorMockDB) AlterModelAssertTable() error {
-	return errors.New("Cannot amend the model assertion table")
+// CreateSyncModelAssignmentTable database mock
+func (mdb *MockDB) CreateSyncModelAssignmentTable() error {
+	return nil
 }
 
-// UpdateModelAssert mock for updating model assertion record
-func (mdb *ErrorMockDB) UpdateModelAssert(m ModelAssertion) error {
-	return errors.New("Cannot update the model assertion record")
+// ListSyncModelAssignments database mock
+func (mdb *MockDB) ListSyncModelAssignments(userID int) ([]SyncModelAssignment, error) {
+	assignments := []SyncModelAssignment{}
+	for _, a := range mdb.syncModels {
+		if a.UserID == userID {
+			assignments = append(assignments, a)
+		}
+	}
+	return assignments, nil
 }
 
-// GetModelAssert mock for updating model assertion record
-func (mdb *ErrorMockDB) GetModelAssert(modelID int) (ModelAssertion, error) {
-	return ModelAssertion{}, errors.New("Cannot find the model assertion record")
-}
+// CreateSyncModelAssignment database mock
+func (mdb *MockDB) CreateSyncModelAssignment(assignment SyncModelAssignment) error {
+	user, err := mdb.GetUser(assignment.UserID)
+	if err != nil {
+		return err
+	}
+	if user.Role != SyncUser {
+		return errors.New("Models can only be assigned to sync users")
+	}
+	if err = validateSyncModelWindow(assignment); err != nil {
+		return err
+	}
 
-// UpsertModelAssert mock for creating or updating model assertion record
-func (mdb *ErrorMockDB) UpsertModelAssert(m ModelAssertion) error {
-	return errors.New("Cannot upsert the model assertion record")
+	assignment.ID = len(mdb.syncModels) + 1
+	mdb.syncModels = append(mdb.syncModels, assignment)
+	return mdb.SetSyncModelScope(assignment.UserID, true)
 }
 
-// CreateSubstoreTable mock for the create substore table method
-func (mdb *ErrorMockDB) CreateSubstoreTable() error {
+// DeleteSyncModelAssignment database mock
+func (mdb *MockDB) DeleteSyncModelAssignment(assignmentID int) error {
 	return nil
 }
 
-// CreateAllowedSubstore mock to create a substore record
-func (mdb *ErrorMockDB) CreateAllowedSubstore(store Substore, authorization User) error {
-	return errors.New("Cannot create the sub-store model")
+// SyncModelScoped database mock
+func (mdb *MockDB) SyncModelScoped(userID int) (bool, error) {
+	return mdb.syncScoped[userID], nil
 }
 
-// ListSubstores mock to list substore records
-func (mdb *ErrorMockDB) ListSubstores(accountID int, authorization User) ([]Substore, error) {
-	fromModel, _ := mdb.GetAllowedModel(1, authorization)
+// SetSyncModelScope database mock
+func (mdb *MockDB) SetSyncModelScope(userID int, scoped bool) error {
+	if mdb.syncScoped == nil {
+		mdb.syncScoped = map[int]bool{}
+	}
+	mdb.syncScoped[userID] = scoped
+	return nil
+}
 
-	substores := []Substore{
-		{ID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc1234", ModelName: "alder-mybrand"},
-		{ID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc5678", ModelName: "alder-mybrand"},
+// ListAllowedSyncKeypairs database mock. The assigned models only use the first keypair, and a
+// model-scoped user without models has none
+func (mdb *MockDB) ListAllowedSyncKeypairs(authorization User) ([]Keypair, error) {
+	keypairs, err := mdb.ListAllowedKeypairs(authorization)
+	if err != nil || len(keypairs) == 0 {
+		return keypairs, err
 	}
 
-	return substores, errors.New("Cannot list the sub-stores")
+	user, err := mdb.GetUserByUsername(authorization.Username)
+	if err != nil {
+		return keypairs, nil
+	}
+	if !mdb.syncScoped[user.ID] {
+		return keypairs, nil
+	}
+	if assignments, _ := mdb.ListSyncModelAssignments(user.ID); len(assignments) > 0 {
+		return keypairs[:1], nil
+	}
+	return []Keypair{}, nil
 }
 
-// UpdateAllowedSubstore mock to update a substore record
-func (mdb *ErrorMockDB) UpdateAllowedSubstore(store Substore, authorization User) error {
-	return errors.New("Cannot update the sub-store model")
+// CreateSyncModelAssignmentTable error mock for the database
+func (mdb *ErrorMockDB) CreateSyncModelAssignmentTable() error {
+	return errors.New("MOCK error creating the sync model table")
 }
 
-// DeleteAllowedSubstore mock to update a substore record
-func (mdb *ErrorMockDB) DeleteAllowedSubstore(storeID int, authorization User) (string, error) {
-	return "", errors.New("Cannot delete the sub-store model")
+// ListSyncModelAssignments error mock for the database
+func (mdb *ErrorMockDB) ListSyncModelAssignments(userID int) ([]SyncModelAssignment, error) {
+	return nil, errors.New("MOCK error listing the sync user's models")
 }
 
-// GetSubstore mock to get a substore record
-func (mdb *ErrorMockDB) GetSubstore(fromModelID int, serialNumber string) (Substore, error) {
-	return Substore{}, errors.New("Cannot get the sub-store model")
+// CreateSyncModelAssignment error mock for the database
+func (mdb *ErrorMockDB) CreateSyncModelAssignment(assignment SyncModelAssignment) error {
+	return errors.New("MOCK error assigning the model")
 }
 
-// GetSubstoreModel mock to get a substore record
-func (mdb *ErrorMockDB) GetSubstoreModel(brand, model, serialNumber string) (Substore, error) {
-	return Substore{}, errors.New("Cannot get the sub-store model")
+// DeleteSyncModelAssignment error mock for the database
+func (mdb *ErrorMockDB) DeleteSyncModelAssignment(assignmentID int) error {
+	return errors.New("MOCK error removing the model")
 }
 
-// CreateTestLog mock to create a test log
-func (mdb *ErrorMockDB) CreateTestLog(testLog TestLog) error {
-	return errors.New("MOCK Cannot create the test log")
+// SyncModelScoped error mock for the database
+func (mdb *ErrorMockDB) SyncModelScoped(userID int) (bool, error) {
+	return false, errors.New("MOCK error retrieving the sync user's scope")
 }
 
-// ListAllowedTestLog database mock
-func (mdb *ErrorMockDB) ListAllowedTestLog(authorization User) ([]TestLog, error) {
-	return nil, errors.New("MOCK Cannot fetch the test logs")
+// SetSyncModelScope error mock for the database
+func (mdb *ErrorMockDB) SetSyncModelScope(userID int, scoped bool) error {
+	return errors.New("MOCK error setting the sync user's scope")
 }
 
-// SyncListTestLogs database mock
-func (mdb *ErrorMockDB) SyncListTestLogs() ([]TestLog, error) {
-	return nil, errors.New("MOCK Cannot fetch the test logs")
+// ListAllowedSyncKeypairs error mock for the database
+func (mdb *ErrorMockDB) ListAllowedSyncKeypairs(authorization User) ([]Keypair, error) {
+	return nil, errors.New("MOCK error listing the sync keypairs")
 }
 
-// SyncDeleteTestLog database mock
-func (mdb *ErrorMockDB) SyncDeleteTestLog(ID int) error {
-	return errors.New("MOCK error deleting the test log")
+// CreateSigningAuthorizationTable database mock
+func (mdb *MockDB) CreateSigningAuthorizationTable() error {
+	return nil
 }
 
-// UpdateAllowedTestLog database mock
-func (mdb *ErrorMockDB) UpdateAllowedTestLog(ID int, authorization User) error {
-	return errors.New("MOCK error updating the test log")
+// SyncSigningAuthorizations database mock
+func (mdb *MockDB) SyncSigningAuthorizations(scoped bool, assignments []SyncModelAssignment) error {
+	mdb.authorizations = assignments
+	mdb.authScoped = scoped
+	return nil
 }
 
-// HealthCheck mock to simulate failed HealthCheck
-func (mdb *ErrorMockDB) HealthCheck() error {
-	return errors.New("Health check failed")
+// CheckSigningAuthorization database mock. The units are not counted
+func (mdb *MockDB) CheckSigningAuthorization(brandID, modelName string) error {
+	if !mdb.authScoped {
+		return nil
+	}
+	for _, a := range mdb.authorizations {
+		if a.BrandID != brandID || a.Name != modelName {
+			continue
+		}
+		if !a.Open(time.Now()) {
+			return errors.New("The signing authorization for this model has expired or is not yet valid")
+		}
+		return nil
+	}
+	return errors.New("The factory is not authorized to sign for this model")
+}
+
+// CreateSigningAuthorizationTable error mock for the database
+func (mdb *ErrorMockDB) CreateSigningAuthorizationTable() error {
+	return errors.New("MOCK error creating the signing authorization table")
+}
+
+// SyncSigningAuthorizations error mock for the database
+func (mdb *ErrorMockDB) SyncSigningAuthorizations(scoped bool, assignments []SyncModelAssignment) error {
+	return errors.New("MOCK error syncing the signing authorizations")
+}
+
+// CheckSigningAuthorization error mock for the database
+func (mdb *ErrorMockDB) CheckSigningAuthorization(brandID, modelName string) error {
+	return errors.New("MOCK error checking the signing authorization")
+}
+
+// WithContext mock for the database bound to a context
+func (mdb *MockDB) WithContext(ctx context.Context) Datastore {
+	return mdb
+}
+
+// WithContext error mock for the database bound to a context
+func (mdb *ErrorMockDB) WithContext(ctx context.Context) Datastore {
+	return mdb
 }
@@ -33,7 +33,7 @@ func GetMemoryKeyStore(config config.Settings) (*KeypairDatabase, error) {
 	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
 		KeypairManager: asserts.NewMemoryKeypairManager(),
 	})
-	kdb := KeypairDatabase{FilesystemStore, db, nil}
+	kdb := KeypairDatabase{MemoryStore, db, nil, nil}
 	return &kdb, err
 }
 
@@ -52,6 +52,6 @@ func GetErrorMockKeyStore(config config.Settings) (*KeypairDatabase, error) {
 	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
 		KeypairManager: mockStore,
 	})
-	kdb := KeypairDatabase{FilesystemStore, db, nil}
+	kdb := KeypairDatabase{MemoryStore, db, nil, nil}
 	return &kdb, err
 }
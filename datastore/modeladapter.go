@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/random"
 )
 
@@ -62,8 +63,9 @@ func (db *DB) GetAllowedModel(modelID int, authorization User) (Model, error) {
 
 // UpdateAllowedModel updates the model if authorization is allowed to do it
 func (db *DB) UpdateAllowedModel(model Model, authorization User) (string, error) {
+	model = canonicalModel(db.modelNameCase, model)
 
-	errorSubcode, err := validateModel(model, "error-validate-model")
+	errorSubcode, err := validateModel(db.modelNameCase, model, "error-validate-model")
 	if err != nil {
 		return errorSubcode, err
 	}
@@ -121,8 +123,9 @@ func (db *DB) DeleteAllowedModel(model Model, authorization User) (string, error
 
 // CreateAllowedModel creates a new model in case authorization is allowed to do it
 func (db *DB) CreateAllowedModel(model Model, authorization User) (Model, string, error) {
+	model = canonicalModel(db.modelNameCase, model)
 
-	errorSubcode, err := validateModel(model, "error-validate-new-model")
+	errorSubcode, err := validateModel(db.modelNameCase, model, "error-validate-new-model")
 	if err != nil {
 		return model, errorSubcode, err
 	}
@@ -159,14 +162,14 @@ func (db *DB) CreateAllowedModel(model Model, authorization User) (Model, string
 	}
 }
 
-func validateModel(model Model, validateModelLabel string) (string, error) {
+func validateModel(modelNameCase []config.ModelNameCase, model Model, validateModelLabel string) (string, error) {
 
 	err := validateBrandID(model.BrandID)
 	if err != nil {
 		return validateModelLabel, err
 	}
 
-	err = validateModelName(model.Name)
+	err = validateBrandModelName(modelNameCase, model.BrandID, model.Name)
 	if err != nil {
 		return validateModelLabel, err
 	}
@@ -181,6 +184,31 @@ func validateModel(model Model, validateModelLabel string) (string, error) {
 		return "error-validate-userkey", err
 	}
 
+	err = ValidateTimestampPolicy(model.TimestampPolicy)
+	if err != nil {
+		return "error-validate-timestamp-policy", err
+	}
+
+	err = ValidateDeviceKeyPolicy(model.DeviceKeyPolicy)
+	if err != nil {
+		return "error-validate-device-key-policy", err
+	}
+
+	err = ValidateSerialPipeline(model.SerialPipeline)
+	if err != nil {
+		return "error-validate-serial-pipeline", err
+	}
+
+	err = ValidateDuplicatePolicy(model.DuplicatePolicy)
+	if err != nil {
+		return "error-validate-duplicate-policy", err
+	}
+
+	err = ValidateSerialResponse(model.SerialResponse)
+	if err != nil {
+		return "error-validate-serial-response", err
+	}
+
 	return "", nil
 }
 
@@ -193,6 +221,14 @@ func validateModelName(name string) error {
 	return validateCaseInsensitive("Model name", name)
 }
 
+// validateBrandModelName validates the name of a model of the brand, for the case policy of the brand
+func validateBrandModelName(modelNameCase []config.ModelNameCase, brandID, name string) error {
+	if ModelNameCasePolicy(modelNameCase, brandID) == ModelNameCaseExact {
+		return validateNotEmpty("Model name", name)
+	}
+	return validateModelName(name)
+}
+
 func validateKeypairID(keypairID int) error {
 	if keypairID <= 0 {
 		return errors.New("The Signing Key must be selected")
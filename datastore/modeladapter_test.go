@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -52,3 +53,158 @@ func TestModelNameUpperChar(t *testing.T) {
 		t.Error("Error happening is not the one searched for")
 	}
 }
+
+func TestValidateTimestampPolicy(t *testing.T) {
+	tests := []struct {
+		policy TimestampPolicy
+		valid  bool
+	}{
+		{TimestampPolicy{}, true},
+		{TimestampPolicy{ManufactureDate: true, MaxAge: 30}, true},
+		{TimestampPolicy{ManufactureDate: true, MaxAge: 30, MaxFuture: 10}, true},
+		{TimestampPolicy{ManufactureDate: true}, false},
+		{TimestampPolicy{ManufactureDate: true, MaxAge: -1}, false},
+		{TimestampPolicy{ManufactureDate: true, MaxAge: 30, MaxFuture: -1}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateTimestampPolicy(tt.policy)
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", tt.policy, tt.valid, err)
+		}
+		if policy := decodeTimestampPolicy(encodeTimestampPolicy(tt.policy)); policy != tt.policy {
+			t.Errorf("expected the policy %+v, got %+v", tt.policy, policy)
+		}
+	}
+}
+
+func TestValidateDeviceKeyPolicy(t *testing.T) {
+	tests := []struct {
+		policy DeviceKeyPolicy
+		valid  bool
+	}{
+		{DeviceKeyPolicy{}, true},
+		{DeviceKeyPolicy{MinRSABits: 3072}, true},
+		{DeviceKeyPolicy{KeyTypes: []string{"rsa", "ecdsa"}}, true},
+		{DeviceKeyPolicy{KeyTypes: []string{"ecdsa"}, Curves: []string{"P-384"}}, true},
+		{DeviceKeyPolicy{KeyTypes: []string{"dsa"}}, false},
+		{DeviceKeyPolicy{MinRSABits: 1024}, false},
+		{DeviceKeyPolicy{MinRSABits: -1}, false},
+		{DeviceKeyPolicy{KeyTypes: []string{"ecdsa"}, Curves: []string{"P-224"}}, false},
+		{DeviceKeyPolicy{Curves: []string{"P-256"}}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateDeviceKeyPolicy(tt.policy)
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", tt.policy, tt.valid, err)
+		}
+		if policy := decodeDeviceKeyPolicy(encodeDeviceKeyPolicy(tt.policy)); !reflect.DeepEqual(policy, tt.policy) {
+			t.Errorf("expected the policy %+v, got %+v", tt.policy, policy)
+		}
+	}
+}
+
+func TestValidateSerialPipeline(t *testing.T) {
+	tests := []struct {
+		pipeline SerialPipeline
+		valid    bool
+	}{
+		{nil, true},
+		{SerialPipeline{{Step: SerialStepTrim}, {Step: SerialStepUppercase}}, true},
+		{SerialPipeline{{Step: SerialStepStripPrefix, Value: "SN:"}, {Step: SerialStepLuhn}}, true},
+		{SerialPipeline{{Step: SerialStepPattern, Value: "^[A-Z0-9]+$"}}, true},
+		{SerialPipeline{{Step: "reverse"}}, false},
+		{SerialPipeline{{Step: SerialStepStripPrefix}}, false},
+		{SerialPipeline{{Step: SerialStepUppercase, Value: "A"}}, false},
+		{SerialPipeline{{Step: SerialStepPattern, Value: "[A-Z"}}, false},
+		{make(SerialPipeline, MaxSerialPipelineSteps+1), false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateSerialPipeline(tt.pipeline)
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", tt.pipeline, tt.valid, err)
+		}
+		if pipeline := decodeSerialPipeline(encodeSerialPipeline(tt.pipeline)); len(tt.pipeline) > 0 && !reflect.DeepEqual(pipeline, tt.pipeline) {
+			t.Errorf("expected the pipeline %+v, got %+v", tt.pipeline, pipeline)
+		}
+	}
+}
+
+func TestValidateSerialResponse(t *testing.T) {
+	tests := []struct {
+		fields SerialResponse
+		valid  bool
+	}{
+		{nil, true},
+		{SerialResponse{{Name: "enroll-url", Value: "https://mdm.example.com/{{.Model}}/{{.Serial}}"}}, true},
+		{SerialResponse{{Name: "token", Value: "static"}, {Name: "mac", Value: "{{.Details.mac}}"}}, true},
+		{SerialResponse{{Name: "Enroll URL", Value: "x"}}, false},
+		{SerialResponse{{Name: "", Value: "x"}}, false},
+		{SerialResponse{{Name: "token", Value: "a"}, {Name: "token", Value: "b"}}, false},
+		{SerialResponse{{Name: "token", Value: "{{.Serial"}}, false},
+		{SerialResponse{{Name: "token", Value: "{{.Unknown}}"}}, false},
+		{make(SerialResponse, MaxSerialResponseFields+1), false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateSerialResponse(tt.fields)
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", tt.fields, tt.valid, err)
+		}
+		if fields := decodeSerialResponse(encodeSerialResponse(tt.fields)); len(tt.fields) > 0 && !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("expected the fields %+v, got %+v", tt.fields, fields)
+		}
+	}
+}
+
+func TestDeviceKeyPolicyDefaults(t *testing.T) {
+	policy := DeviceKeyPolicy{}
+	if !policy.AllowsType(DeviceKeyRSA) || policy.AllowsType(DeviceKeyECDSA) {
+		t.Error("Expected only RSA device-keys to be accepted by default")
+	}
+	if policy.RSABits() != DefaultMinRSABits {
+		t.Errorf("Expected the default minimum RSA size, got %d", policy.RSABits())
+	}
+	if !policy.AllowsCurve("P-521") || policy.AllowsCurve("P-224") {
+		t.Error("Expected the supported curves to be accepted by default")
+	}
+
+	policy = DeviceKeyPolicy{KeyTypes: []string{DeviceKeyECDSA}, Curves: []string{"P-384"}, MinRSABits: 4096}
+	if policy.AllowsType(DeviceKeyRSA) || !policy.AllowsType(DeviceKeyECDSA) {
+		t.Error("Expected only ECDSA device-keys to be accepted")
+	}
+	if policy.RSABits() != 4096 || policy.AllowsCurve("P-256") || !policy.AllowsCurve("P-384") {
+		t.Errorf("Expected the policy to be applied: %+v", policy)
+	}
+}
+
+func TestValidateDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		policy DuplicatePolicy
+		valid  bool
+	}{
+		{DuplicatePolicy{}, true},
+		{DuplicatePolicy{Scope: "serial"}, true},
+		{DuplicatePolicy{Scope: "device-key", Resign: "reject"}, true},
+		{DuplicatePolicy{Scope: "pair", Resign: "revision"}, true},
+		{DuplicatePolicy{Scope: "model"}, false},
+		{DuplicatePolicy{Resign: "ignore"}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateDuplicatePolicy(tt.policy)
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", tt.policy, tt.valid, err)
+		}
+		if policy := decodeDuplicatePolicy(encodeDuplicatePolicy(tt.policy)); policy != tt.policy {
+			t.Errorf("expected the policy %+v, got %+v", tt.policy, policy)
+		}
+	}
+
+	policy := DuplicatePolicy{}
+	if policy.DuplicateScope() != DuplicateScopeEither || policy.RejectsResigns() {
+		t.Errorf("Expected the serial number or device-key to be checked and the re-signs to be revisions by default")
+	}
+}
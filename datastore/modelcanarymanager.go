@@ -0,0 +1,235 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+const createModelCanaryTableSQL = `
+	CREATE TABLE IF NOT EXISTS modelcanary (
+		id               serial primary key not null,
+		model_id         int references model not null,
+		keypair_id       int references keypair not null,
+		percent          int not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+const createModelKeyResultTableSQL = `
+	CREATE TABLE IF NOT EXISTS modelkeyresult (
+		id               serial primary key not null,
+		model_id         int references model not null,
+		keypair_id       int references keypair not null,
+		signed           int default 0,
+		failed           int default 0
+	)
+`
+
+// Indexes
+const createModelCanaryUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS modelcanary_idx ON modelcanary (model_id)"
+const createModelKeyResultUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS modelkeyresult_idx ON modelkeyresult (model_id, keypair_id)"
+
+const getModelCanarySQL = `
+	SELECT c.model_id, c.keypair_id, c.percent, k.authority_id, k.key_id, k.active, k.sealed_key
+	FROM modelcanary c
+	INNER JOIN keypair k ON k.id=c.keypair_id
+	WHERE c.model_id=$1`
+
+const createModelCanarySQL = "INSERT INTO modelcanary (model_id, keypair_id, percent) VALUES ($1,$2,$3)"
+const deleteModelCanarySQL = "DELETE FROM modelcanary WHERE model_id=$1"
+
+const createModelKeyResultSQL = "INSERT INTO modelkeyresult (model_id, keypair_id, signed, failed) VALUES ($1,$2,$3,$4)"
+const updateModelKeyResultSQL = "UPDATE modelkeyresult SET signed=signed+$3, failed=failed+$4 WHERE model_id=$1 AND keypair_id=$2"
+const deleteModelKeyResultsSQL = "DELETE FROM modelkeyresult WHERE model_id=$1"
+
+const listModelKeyResultsSQL = `
+	SELECT r.model_id, r.keypair_id, k.key_id, r.signed, r.failed
+	FROM modelkeyresult r
+	INNER JOIN keypair k ON k.id=r.keypair_id
+	WHERE r.model_id=$1
+	ORDER BY r.keypair_id`
+
+// ModelCanary is a new signing-key of a model that signs a percentage of the serial
+// requests, so the brand can verify it before the full cutover
+type ModelCanary struct {
+	ModelID     int    `json:"model-id"`
+	KeypairID   int    `json:"keypair-id"`
+	Percent     int    `json:"percent"`
+	AuthorityID string `json:"authority-id"` // from the canary keypair
+	KeyID       string `json:"key-id"`       // from the canary keypair
+	KeyActive   bool   `json:"key-active"`   // from the canary keypair
+	SealedKey   string `json:"-"`            // from the canary keypair
+}
+
+// ModelKeyResult counts the serial assertions of a model that a signing-key signed or
+// failed to sign, while the model has a canary signing-key
+type ModelKeyResult struct {
+	ModelID   int    `json:"model-id"`
+	KeypairID int    `json:"keypair-id"`
+	KeyID     string `json:"key-id"`
+	Signed    int    `json:"signed"`
+	Failed    int    `json:"failed"`
+}
+
+// CreateModelCanaryTables creates the database tables for the canary signing-keys of the
+// models and the signing results of their keys
+func (db *DB) CreateModelCanaryTables() error {
+	for _, s := range []string{createModelCanaryTableSQL, createModelCanaryUniqueIndexSQL, createModelKeyResultTableSQL, createModelKeyResultUniqueIndexSQL} {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetModelCanary returns the canary signing-key of a model. The keypair ID is zero
+// when the model does not have one
+func (db *DB) GetModelCanary(modelID int) (ModelCanary, error) {
+	canary := ModelCanary{}
+	err := db.QueryRow(getModelCanarySQL, modelID).Scan(&canary.ModelID, &canary.KeypairID, &canary.Percent, &canary.AuthorityID, &canary.KeyID, &canary.KeyActive, &canary.SealedKey)
+	if err == sql.ErrNoRows {
+		return ModelCanary{}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving the canary keypair: %v\n", err)
+	}
+	return canary, err
+}
+
+// UpdateAllowedModelCanary sets the canary signing-key of a model and the percentage of
+// the serial requests that it signs, if the user is authorized to change the model. A
+// zero percentage removes the canary. The signing results are reset when the canary
+// signing-key changes
+func (db *DB) UpdateAllowedModelCanary(canary ModelCanary, authorization User) error {
+	err := validateModelID("Model", canary.ModelID)
+	if err != nil {
+		return err
+	}
+
+	// Validate that the user has access to the model
+	model, err := db.GetAllowedModel(canary.ModelID, authorization)
+	if err != nil || model.ID == 0 {
+		return errors.New("You do not have permissions to this model")
+	}
+
+	if err = ValidateModelCanary(canary, model); err != nil {
+		return err
+	}
+	if canary.Percent > 0 {
+		keypair, err := db.GetKeypair(canary.KeypairID)
+		if err != nil {
+			return errors.New("Cannot find the canary signing-key")
+		}
+		if keypair.AuthorityID != model.AuthorityID {
+			return errors.New("The canary key must have the same authority as the signing-key of the model")
+		}
+	}
+
+	current, err := db.GetModelCanary(canary.ModelID)
+	if err != nil {
+		return err
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteModelCanarySQL, canary.ModelID); err != nil {
+			log.Printf("Error removing the canary keypair: %v\n", err)
+			return err
+		}
+		if current.KeypairID != canary.KeypairID {
+			if _, err := tx.Exec(deleteModelKeyResultsSQL, canary.ModelID); err != nil {
+				log.Printf("Error resetting the signing results: %v\n", err)
+				return err
+			}
+		}
+		if canary.Percent == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(createModelCanarySQL, canary.ModelID, canary.KeypairID, canary.Percent); err != nil {
+			log.Printf("Error storing the canary keypair: %v\n", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// ValidateModelCanary checks the canary signing-key of the model
+func ValidateModelCanary(canary ModelCanary, model Model) error {
+	if canary.Percent < 0 || canary.Percent > 100 {
+		return errors.New("The canary percentage must be between 0 and 100")
+	}
+	if canary.Percent == 0 {
+		return nil
+	}
+	if canary.KeypairID <= 0 {
+		return errors.New("The canary signing-key must be provided")
+	}
+	if canary.KeypairID == model.KeypairID {
+		return errors.New("The signing-key of the model cannot also be the canary key")
+	}
+	return nil
+}
+
+// RecordModelKeyResult counts a serial assertion of the model that the signing-key
+// signed or failed to sign
+func (db *DB) RecordModelKeyResult(modelID, keypairID int, signed bool) error {
+	success, failure := 0, 1
+	if signed {
+		success, failure = 1, 0
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(updateModelKeyResultSQL, modelID, keypairID, success, failure)
+		if err != nil {
+			log.Printf("Error updating the signing result: %v\n", err)
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+			return err
+		}
+		if _, err = tx.Exec(createModelKeyResultSQL, modelID, keypairID, success, failure); err != nil {
+			log.Printf("Error storing the signing result: %v\n", err)
+		}
+		return err
+	})
+}
+
+// ListModelKeyResults returns the signing results of the keys of a model
+func (db *DB) ListModelKeyResults(modelID int) ([]ModelKeyResult, error) {
+	rows, err := db.Query(listModelKeyResultsSQL, modelID)
+	if err != nil {
+		log.Printf("Error retrieving the signing results: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ModelKeyResult{}
+	for rows.Next() {
+		r := ModelKeyResult{}
+		if err := rows.Scan(&r.ModelID, &r.KeypairID, &r.KeyID, &r.Signed, &r.Failed); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+const createModelFallbackKeyTableSQL = `
+	CREATE TABLE IF NOT EXISTS modelfallbackkey (
+		id               serial primary key not null,
+		model_id         int references model not null,
+		keypair_id       int references keypair not null,
+		priority         int not null
+	)
+`
+
+// Indexes
+const createModelFallbackKeyUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS modelfallbackkey_idx ON modelfallbackkey (model_id, keypair_id)"
+
+const createModelFallbackKeySQL = "INSERT INTO modelfallbackkey (model_id, keypair_id, priority) VALUES ($1,$2,$3)"
+const deleteModelFallbackKeysSQL = "DELETE FROM modelfallbackkey WHERE model_id=$1"
+
+const listModelFallbackKeypairsSQL = `
+	SELECT k.id, k.authority_id, k.key_id, k.active, k.sealed_key, k.assertion, k.key_name
+	FROM modelfallbackkey f
+	INNER JOIN keypair k ON k.id=f.keypair_id
+	WHERE f.model_id=$1
+	ORDER BY f.priority`
+
+// CreateModelFallbackKeyTable creates the database table for the fallback signing-keys of a model
+func (db *DB) CreateModelFallbackKeyTable() error {
+	_, err := db.Exec(createModelFallbackKeyTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createModelFallbackKeyUniqueIndexSQL)
+	return err
+}
+
+// ListModelFallbackKeypairs returns the fallback signing-keys of a model, in the order
+// that they are to be tried when the model's signing-key cannot be used
+func (db *DB) ListModelFallbackKeypairs(modelID int) ([]Keypair, error) {
+	rows, err := db.Query(listModelFallbackKeypairsSQL, modelID)
+	if err != nil {
+		log.Printf("Error retrieving the fallback keypairs: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keypairs := []Keypair{}
+	for rows.Next() {
+		keypair := Keypair{}
+		err := rows.Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName)
+		if err != nil {
+			return nil, err
+		}
+		keypairs = append(keypairs, keypair)
+	}
+
+	return keypairs, rows.Err()
+}
+
+// UpdateAllowedModelFallbackKeypairs replaces the ordered fallback signing-keys of a model,
+// if the user is authorized to change the model
+func (db *DB) UpdateAllowedModelFallbackKeypairs(modelID int, keypairIDs []int, authorization User) error {
+	err := validateModelID("Model", modelID)
+	if err != nil {
+		return err
+	}
+
+	// Validate that the user has access to the model
+	model, err := db.GetAllowedModel(modelID, authorization)
+	if err != nil || model.ID == 0 {
+		return errors.New("You do not have permissions to this model")
+	}
+
+	seen := map[int]bool{}
+	for _, keypairID := range keypairIDs {
+		if keypairID == model.KeypairID {
+			return errors.New("The signing-key of the model cannot also be a fallback key")
+		}
+		if seen[keypairID] {
+			return errors.New("The fallback keys must not be repeated")
+		}
+		seen[keypairID] = true
+
+		keypair, err := db.GetKeypair(keypairID)
+		if err != nil {
+			return errors.New("Cannot find the fallback signing-key")
+		}
+		if keypair.AuthorityID != model.AuthorityID {
+			return errors.New("The fallback keys must have the same authority as the signing-key of the model")
+		}
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteModelFallbackKeysSQL, modelID); err != nil {
+			log.Printf("Error removing the fallback keypairs: %v\n", err)
+			return err
+		}
+		for i, keypairID := range keypairIDs {
+			if _, err := tx.Exec(createModelFallbackKeySQL, modelID, keypairID, i); err != nil {
+				log.Printf("Error storing the fallback keypair: %v\n", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
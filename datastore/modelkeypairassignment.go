@@ -0,0 +1,266 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const createModelKeypairHistoryTableSQL = `
+	CREATE TABLE IF NOT EXISTS modelkeypairhistory (
+		id                  serial primary key not null,
+		model_id            int not null,
+		brand_id            varchar(200) not null,
+		model               varchar(200) not null,
+		old_keypair_id      int not null,
+		new_keypair_id      int not null,
+		old_user_keypair_id int not null,
+		new_user_keypair_id int not null,
+		changed             timestamp default current_timestamp,
+		changed_by          varchar(200) default ''
+	)
+`
+
+const createModelKeypairHistoryIndexSQL = "CREATE INDEX IF NOT EXISTS modelkeypairhistory_model_idx ON modelkeypairhistory (model_id)"
+
+const listModelsForKeypairSQL = "SELECT id, brand_id, name, keypair_id, user_keypair_id FROM model WHERE keypair_id=$1 OR user_keypair_id=$1 ORDER BY name"
+const updateModelKeypairsSQL = "UPDATE model SET keypair_id=$2, user_keypair_id=$3 WHERE id=$1"
+const findModelFallbackKeySQL = "SELECT EXISTS(SELECT * FROM modelfallbackkey WHERE model_id=$1 AND keypair_id=$2)"
+
+const createModelKeypairHistorySQL = `
+	INSERT INTO modelkeypairhistory (model_id, brand_id, model, old_keypair_id, new_keypair_id, old_user_keypair_id, new_user_keypair_id, changed_by)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+
+const listModelKeypairHistorySQL = `
+	SELECT id, model_id, brand_id, model, old_keypair_id, new_keypair_id, old_user_keypair_id, new_user_keypair_id, changed, changed_by
+	FROM modelkeypairhistory
+	WHERE model_id=$1
+	ORDER BY id DESC`
+
+// ModelKeypairAssignment re-points the models from a keypair to another keypair of the same
+// authority e.g. when the keypair is retired. Both the signing-key and the system-user key of
+// the models are re-pointed. The changes are only returned when it is a preview
+type ModelKeypairAssignment struct {
+	FromKeypairID int   `json:"from-keypair-id"`
+	KeypairID     int   `json:"keypair-id"`
+	ModelIDs      []int `json:"models"` // all the models of the keypair, when empty
+	Preview       bool  `json:"preview"`
+}
+
+// ModelKeypairChange is the audit entry of the keypairs of a model that were re-pointed
+type ModelKeypairChange struct {
+	ID               int       `json:"id"`
+	ModelID          int       `json:"model-id"`
+	BrandID          string    `json:"brand-id"`
+	Model            string    `json:"model"`
+	OldKeypairID     int       `json:"old-keypair-id"`
+	NewKeypairID     int       `json:"new-keypair-id"`
+	OldKeypairIDUser int       `json:"old-keypair-id-user"`
+	NewKeypairIDUser int       `json:"new-keypair-id-user"`
+	Changed          time.Time `json:"changed"`
+	ChangedBy        string    `json:"changed-by"`
+}
+
+// ValidateModelKeypairAssignment checks the keypairs of the assignment
+func ValidateModelKeypairAssignment(assignment ModelKeypairAssignment) error {
+	if assignment.FromKeypairID <= 0 || assignment.KeypairID <= 0 {
+		return errors.New("The keypair of the models and the new keypair must be selected")
+	}
+	if assignment.FromKeypairID == assignment.KeypairID {
+		return errors.New("The new keypair must be different to the keypair of the models")
+	}
+	return nil
+}
+
+// CreateModelKeypairHistoryTable creates the database table for the audit of the re-pointed keypairs of the models
+func (db *DB) CreateModelKeypairHistoryTable() error {
+	_, err := db.Exec(createModelKeypairHistoryTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createModelKeypairHistoryIndexSQL)
+	return err
+}
+
+// assignModelKeypair re-points the models of the keypair to the new keypair in a single
+// transaction, recording an audit entry for each model
+func (db *DB) assignModelKeypair(assignment ModelKeypairAssignment, changedBy string) ([]ModelKeypairChange, error) {
+	changes := []ModelKeypairChange{}
+
+	err := db.transaction(func(tx *sql.Tx) error {
+		var err error
+		changes, err = db.modelKeypairChanges(tx, assignment)
+		if err != nil || assignment.Preview {
+			return err
+		}
+
+		for i, c := range changes {
+			if _, err := tx.Exec(updateModelKeypairsSQL, c.ModelID, c.NewKeypairID, c.NewKeypairIDUser); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(createModelKeypairHistorySQL, c.ModelID, c.BrandID, c.Model, c.OldKeypairID, c.NewKeypairID, c.OldKeypairIDUser, c.NewKeypairIDUser, changedBy); err != nil {
+				return err
+			}
+			changes[i].ChangedBy = changedBy
+			changes[i].Changed = time.Now().UTC()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error assigning the keypair of the models: %v\n", err)
+		return nil, err
+	}
+	return changes, nil
+}
+
+// modelKeypairChanges finds the models of the keypair that are re-pointed by the assignment
+func (db *DB) modelKeypairChanges(tx *sql.Tx, assignment ModelKeypairAssignment) ([]ModelKeypairChange, error) {
+	rows, err := tx.Query(listModelsForKeypairSQL, assignment.FromKeypairID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	selected := map[int]bool{}
+	for _, id := range assignment.ModelIDs {
+		selected[id] = true
+	}
+	found := map[int]bool{}
+
+	changes := []ModelKeypairChange{}
+	for rows.Next() {
+		c := ModelKeypairChange{}
+		err := rows.Scan(&c.ModelID, &c.BrandID, &c.Model, &c.OldKeypairID, &c.OldKeypairIDUser)
+		if err != nil {
+			return nil, err
+		}
+		if len(selected) > 0 && !selected[c.ModelID] {
+			continue
+		}
+		found[c.ModelID] = true
+
+		c.NewKeypairID, c.NewKeypairIDUser = c.OldKeypairID, c.OldKeypairIDUser
+		if c.OldKeypairID == assignment.FromKeypairID {
+			c.NewKeypairID = assignment.KeypairID
+		}
+		if c.OldKeypairIDUser == assignment.FromKeypairID {
+			c.NewKeypairIDUser = assignment.KeypairID
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range assignment.ModelIDs {
+		if !found[id] {
+			return nil, fmt.Errorf("Model %d does not use the keypair", id)
+		}
+	}
+
+	// The signing-key of a model cannot also be one of its fallback keys
+	for _, c := range changes {
+		if c.NewKeypairID == c.OldKeypairID {
+			continue
+		}
+		var fallback bool
+		if err := tx.QueryRow(findModelFallbackKeySQL, c.ModelID, c.NewKeypairID).Scan(&fallback); err != nil {
+			return nil, err
+		}
+		if fallback {
+			return nil, fmt.Errorf("The new keypair is a fallback key of model '%s'", c.Model)
+		}
+	}
+	return changes, nil
+}
+
+// listModelKeypairHistory returns the audit of the re-pointed keypairs of a model, newest first
+func (db *DB) listModelKeypairHistory(modelID int) ([]ModelKeypairChange, error) {
+	rows, err := db.Query(listModelKeypairHistorySQL, modelID)
+	if err != nil {
+		log.Printf("Error retrieving the keypair history of the model: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []ModelKeypairChange{}
+	for rows.Next() {
+		c := ModelKeypairChange{}
+		err := rows.Scan(&c.ID, &c.ModelID, &c.BrandID, &c.Model, &c.OldKeypairID, &c.NewKeypairID, &c.OldKeypairIDUser, &c.NewKeypairIDUser, &c.Changed, &c.ChangedBy)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// AssignAllowedModelKeypair re-points the models of a keypair to a new keypair, if the user is
+// authorized to change the models of the keypair's authority
+func (db *DB) AssignAllowedModelKeypair(assignment ModelKeypairAssignment, authorization User) ([]ModelKeypairChange, error) {
+	err := ValidateModelKeypairAssignment(assignment)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := db.GetKeypair(assignment.FromKeypairID)
+	if err != nil {
+		return nil, errors.New("Cannot find the keypair of the models")
+	}
+	keypair, err := db.GetKeypair(assignment.KeypairID)
+	if err != nil {
+		return nil, errors.New("Cannot find the new keypair")
+	}
+	if keypair.AuthorityID != from.AuthorityID {
+		return nil, errors.New("The new keypair must have the same authority as the keypair of the models")
+	}
+	if !keypair.Active {
+		return nil, errors.New("The new keypair must be active")
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.assignModelKeypair(assignment, authorization.Username)
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, keypair.AuthorityID) {
+			return nil, errors.New("You do not have permissions to this account")
+		}
+		return db.assignModelKeypair(assignment, authorization.Username)
+	default:
+		return nil, errors.New("You do not have permissions to this account")
+	}
+}
+
+// ListAllowedModelKeypairHistory returns the audit of the re-pointed keypairs of a model, if
+// the user is authorized to see the model
+func (db *DB) ListAllowedModelKeypairHistory(modelID int, authorization User) ([]ModelKeypairChange, error) {
+	model, err := db.GetAllowedModel(modelID, authorization)
+	if err != nil || model.ID == 0 {
+		return nil, errors.New("You do not have permissions to this model")
+	}
+	return db.listModelKeypairHistory(modelID)
+}
@@ -32,18 +32,23 @@ const createModelTableSQL = `
 		name             varchar(200) not null,
 		keypair_id       int references keypair not null,
 		user_keypair_id  int references keypair not null,
-		api_key          varchar(200) not null
+		api_key          varchar(200) not null,
+		timestamp_policy text default '',
+		device_key_policy text default '',
+		serial_pipeline  text default '',
+		duplicate_policy text default '',
+		serial_response  text default ''
 	)
 `
 const listModelsSQL = `
-	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion
+	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion, m.timestamp_policy, m.device_key_policy, m.serial_pipeline, m.duplicate_policy, m.serial_response
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
 	order by name
 `
 const listModelsForUserSQL = `
-	select m.id, brand_id, m.name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion
+	select m.id, brand_id, m.name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion, m.timestamp_policy, m.device_key_policy, m.serial_pipeline, m.duplicate_policy, m.serial_response
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
@@ -54,19 +59,25 @@ const listModelsForUserSQL = `
 	order by name
 `
 const findModelSQL = `
-	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion
+	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.timestamp_policy, m.device_key_policy, m.serial_pipeline, m.duplicate_policy, m.serial_response
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
 	where brand_id=$1 and name=$2 and api_key=$3`
+const findModelByNameSQL = `
+	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.timestamp_policy, m.device_key_policy, m.serial_pipeline, m.duplicate_policy, m.serial_response
+	from model m
+	inner join keypair k on k.id = m.keypair_id
+	inner join keypair ku on ku.id = m.user_keypair_id
+	where brand_id=$1 and name=$2`
 const getModelSQL = `
-	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion
+	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.timestamp_policy, m.device_key_policy, m.serial_pipeline, m.duplicate_policy, m.serial_response
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
 	where m.id=$1`
 const getModelForUserSQL = `
-	select m.id, m.brand_id, m.name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion
+	select m.id, m.brand_id, m.name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.timestamp_policy, m.device_key_policy, m.serial_pipeline, m.duplicate_policy, m.serial_response
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
@@ -74,20 +85,26 @@ const getModelForUserSQL = `
 	inner join useraccountlink ua on ua.account_id=acc.id
 	inner join userinfo u on ua.user_id=u.id
 	where m.id=$1 and u.username=$2`
-const updateModelSQL = "update model set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6 where id=$1"
+const listModelsMissingKeypairsSQL = `
+	select m.id, m.brand_id, m.name, m.keypair_id, m.user_keypair_id
+	from model m
+	where not exists(select * from keypair k where k.id = m.keypair_id)
+	or not exists(select * from keypair ku where ku.id = m.user_keypair_id)
+	order by m.name`
+const updateModelSQL = "update model set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6, timestamp_policy=$7, device_key_policy=$8, serial_pipeline=$9, duplicate_policy=$10, serial_response=$11 where id=$1"
 const updateModelForUserSQL = `
-	update model m set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6
+	update model m set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6, timestamp_policy=$8, device_key_policy=$9, serial_pipeline=$10, duplicate_policy=$11, serial_response=$12
 	from account acc
 	inner join useraccountlink ua on ua.account_id=acc.id
 	inner join userinfo u on ua.user_id=u.id
 	where acc.authority_id=m.brand_id and m.id=$1 and u.username=$7`
-const createModelSQL = "insert into model (brand_id,name,keypair_id,user_keypair_id,api_key) values ($1,$2,$3,$4,$5) RETURNING id"
+const createModelSQL = "insert into model (brand_id,name,keypair_id,user_keypair_id,api_key,timestamp_policy,device_key_policy,serial_pipeline,duplicate_policy,serial_response) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id"
 
 // sqlite3 syntax for syncing data locally
 const syncUpsertModelSQL = `
 	INSERT OR REPLACE INTO model
-	(id,brand_id,name,keypair_id,user_keypair_id,api_key)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	(id,brand_id,name,keypair_id,user_keypair_id,api_key,timestamp_policy,device_key_policy,serial_pipeline,duplicate_policy,serial_response)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 `
 
 const deleteModelSQL = "delete from model where id=$1"
@@ -139,22 +156,27 @@ const minAPIKeyLength = 10
 
 // Model holds the model details in the local database
 type Model struct {
-	ID              int            `json:"id"`
-	BrandID         string         `json:"brand-id"`
-	Name            string         `json:"model"`
-	KeypairID       int            `json:"keypair-id"`
-	APIKey          string         `json:"api-key"`
-	AuthorityID     string         `json:"authority-id"`      // from the signing keypair
-	KeyID           string         `json:"key-id"`            // from the signing keypair
-	KeyActive       bool           `json:"key-active"`        // from the signing keypair
-	SealedKey       string         `json:"-"`                 // from the signing keypair
-	KeypairIDUser   int            `json:"keypair-id-user"`   // from the system-user keypair
-	AuthorityIDUser string         `json:"authority-id-user"` // from the system-user keypair
-	KeyIDUser       string         `json:"key-id-user"`       // from the system-user keypair
-	KeyActiveUser   bool           `json:"key-active-user"`   // from the system-user keypair
-	SealedKeyUser   string         `json:"-"`                 // from the system-user keypair
-	AssertionUser   string         `json:"-"`                 // from the system-user keypair
-	ModelAssertion  ModelAssertion `json:"assertion"`
+	ID              int             `json:"id"`
+	BrandID         string          `json:"brand-id"`
+	Name            string          `json:"model"`
+	KeypairID       int             `json:"keypair-id"`
+	APIKey          string          `json:"api-key"`
+	AuthorityID     string          `json:"authority-id"`      // from the signing keypair
+	KeyID           string          `json:"key-id"`            // from the signing keypair
+	KeyActive       bool            `json:"key-active"`        // from the signing keypair
+	SealedKey       string          `json:"-"`                 // from the signing keypair
+	KeypairIDUser   int             `json:"keypair-id-user"`   // from the system-user keypair
+	AuthorityIDUser string          `json:"authority-id-user"` // from the system-user keypair
+	KeyIDUser       string          `json:"key-id-user"`       // from the system-user keypair
+	KeyActiveUser   bool            `json:"key-active-user"`   // from the system-user keypair
+	SealedKeyUser   string          `json:"-"`                 // from the system-user keypair
+	AssertionUser   string          `json:"-"`                 // from the system-user keypair
+	ModelAssertion  ModelAssertion  `json:"assertion"`
+	TimestampPolicy TimestampPolicy `json:"timestamp-policy"`
+	DeviceKeyPolicy DeviceKeyPolicy `json:"device-key-policy"`
+	SerialPipeline  SerialPipeline  `json:"serial-pipeline"`
+	DuplicatePolicy DuplicatePolicy `json:"duplicate-policy"`
+	SerialResponse  SerialResponse  `json:"serial-response"`
 }
 
 // CreateModelTable creates the database table for a model.
@@ -181,6 +203,13 @@ func (db *DB) AlterModelTable() error {
 		return err
 	}
 
+	// Ignoring the error when adding the column
+	db.Exec(alterModelTimestampPolicySQL)
+	db.Exec(alterModelDeviceKeyPolicySQL)
+	db.Exec(alterModelSerialPipelineSQL)
+	db.Exec(alterModelDuplicatePolicySQL)
+	db.Exec(alterModelSerialResponseSQL)
+
 	return nil
 }
 
@@ -248,6 +277,31 @@ func (db *DB) addAPIKeyField() error {
 	return nil
 }
 
+// ListModelsMissingKeypairs returns the models that are linked with a signing-key or system-user
+// key that does not exist. They are left out of the other queries of the models, so they are only
+// found when a device of the model requests its serial
+func (db *DB) ListModelsMissingKeypairs() ([]Model, error) {
+	models := []Model{}
+
+	rows, err := db.Query(listModelsMissingKeypairsSQL)
+	if err != nil {
+		log.Printf("Error retrieving the models missing keypairs: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		model := Model{}
+		err := rows.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.KeypairIDUser)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+
+	return models, rows.Err()
+}
+
 func (db *DB) listAllModels() ([]Model, error) {
 	return db.listModelsFilteredByUser(anyUserFilter)
 }
@@ -276,11 +330,17 @@ func (db *DB) listModelsFilteredByUser(username string) ([]Model, error) {
 
 	for rows.Next() {
 		model := Model{}
+		var policy, keyPolicy, pipeline, duplicatePolicy, serialResponse string
 		err := rows.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.AuthorityID, &model.KeyID, &model.KeyActive,
-			&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.AssertionUser)
+			&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.AssertionUser, &policy, &keyPolicy, &pipeline, &duplicatePolicy, &serialResponse)
 		if err != nil {
 			return nil, err
 		}
+		model.TimestampPolicy = decodeTimestampPolicy(policy)
+		model.DeviceKeyPolicy = decodeDeviceKeyPolicy(keyPolicy)
+		model.SerialPipeline = decodeSerialPipeline(pipeline)
+		model.DuplicatePolicy = decodeDuplicatePolicy(duplicatePolicy)
+		model.SerialResponse = decodeSerialResponse(serialResponse)
 
 		// Get the linked model assertion headers
 		m, _ := db.GetModelAssert(model.ID)
@@ -292,13 +352,26 @@ func (db *DB) listModelsFilteredByUser(username string) ([]Model, error) {
 	return models, nil
 }
 
-// FindModel retrieves the model from the database.
+// FindModel retrieves the model from the database, by the canonical brand ID and model name
 func (db *DB) FindModel(brandID, modelName, apiKey string) (Model, error) {
+	brandID = CanonicalBrandID(brandID)
+	return db.scanFoundModel(db.QueryRow(findModelSQL, brandID, CanonicalModelName(db.modelNameCase, brandID, modelName), apiKey))
+}
+
+// findModelByName retrieves the model from the database by the canonical brand ID and model
+// name, whatever its API key
+func (db *DB) findModelByName(brandID, modelName string) (Model, error) {
+	brandID = CanonicalBrandID(brandID)
+	return db.scanFoundModel(db.QueryRow(findModelByNameSQL, brandID, CanonicalModelName(db.modelNameCase, brandID, modelName)))
+}
+
+func (db *DB) scanFoundModel(row *sql.Row) (Model, error) {
 	model := Model{}
+	var policy, keyPolicy, pipeline, duplicatePolicy, serialResponse string
 
-	err := db.QueryRow(findModelSQL, brandID, modelName, apiKey).Scan(
+	err := row.Scan(
 		&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.AuthorityID, &model.KeyID, &model.KeyActive, &model.SealedKey,
-		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser)
+		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser, &policy, &keyPolicy, &pipeline, &duplicatePolicy, &serialResponse)
 	switch {
 	case err == sql.ErrNoRows:
 		return model, err
@@ -306,6 +379,11 @@ func (db *DB) FindModel(brandID, modelName, apiKey string) (Model, error) {
 		log.Printf("Error retrieving database model: %v\n", err)
 		return model, err
 	}
+	model.TimestampPolicy = decodeTimestampPolicy(policy)
+	model.DeviceKeyPolicy = decodeDeviceKeyPolicy(keyPolicy)
+	model.SerialPipeline = decodeSerialPipeline(pipeline)
+	model.DuplicatePolicy = decodeDuplicatePolicy(duplicatePolicy)
+	model.SerialResponse = decodeSerialResponse(serialResponse)
 
 	// Get the linked model assertion headers
 	m, _ := db.GetModelAssert(model.ID)
@@ -329,12 +407,18 @@ func (db *DB) getModelFilteredByUser(modelID int, username string) (Model, error
 		row = db.QueryRow(getModelForUserSQL, modelID, username)
 	}
 
+	var policy, keyPolicy, pipeline, duplicatePolicy, serialResponse string
 	err := row.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.AuthorityID, &model.KeyID, &model.KeyActive, &model.SealedKey,
-		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser)
+		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser, &policy, &keyPolicy, &pipeline, &duplicatePolicy, &serialResponse)
 	if err != nil {
 		log.Printf("Error retrieving database model by ID: %v\n", err)
 		return model, err
 	}
+	model.TimestampPolicy = decodeTimestampPolicy(policy)
+	model.DeviceKeyPolicy = decodeDeviceKeyPolicy(keyPolicy)
+	model.SerialPipeline = decodeSerialPipeline(pipeline)
+	model.DuplicatePolicy = decodeDuplicatePolicy(duplicatePolicy)
+	model.SerialResponse = decodeSerialResponse(serialResponse)
 
 	// Get the linked model assertion headers
 	m, _ := db.GetModelAssert(model.ID)
@@ -351,9 +435,9 @@ func (db *DB) updateModelFilteredByUser(model Model, username string) (string, e
 	var err error
 
 	if len(username) == 0 {
-		_, err = db.Exec(updateModelSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey)
+		_, err = db.Exec(updateModelSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, encodeTimestampPolicy(model.TimestampPolicy), encodeDeviceKeyPolicy(model.DeviceKeyPolicy), encodeSerialPipeline(model.SerialPipeline), encodeDuplicatePolicy(model.DuplicatePolicy), encodeSerialResponse(model.SerialResponse))
 	} else {
-		_, err = db.Exec(updateModelForUserSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, username)
+		_, err = db.Exec(updateModelForUserSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, username, encodeTimestampPolicy(model.TimestampPolicy), encodeDeviceKeyPolicy(model.DeviceKeyPolicy), encodeSerialPipeline(model.SerialPipeline), encodeDuplicatePolicy(model.DuplicatePolicy), encodeSerialResponse(model.SerialResponse))
 	}
 	if err != nil {
 		log.Printf("Error updating the database model: %v\n", err)
@@ -371,7 +455,7 @@ func (db *DB) createModelFilteredByUser(model Model, username string) (Model, st
 	// Create the model in the database
 	var createdModelID int
 
-	err := db.QueryRow(createModelSQL, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey).Scan(&createdModelID)
+	err := db.QueryRow(createModelSQL, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, encodeTimestampPolicy(model.TimestampPolicy), encodeDeviceKeyPolicy(model.DeviceKeyPolicy), encodeSerialPipeline(model.SerialPipeline), encodeDuplicatePolicy(model.DuplicatePolicy), encodeSerialResponse(model.SerialResponse)).Scan(&createdModelID)
 	if err != nil {
 		log.Printf("Error creating the database model: %v\n", err)
 		return model, "", err
@@ -388,12 +472,12 @@ func (db *DB) createModelFilteredByUser(model Model, username string) (Model, st
 
 // SyncModel creates a model for the factory sync
 func (db *DB) SyncModel(m Model) error {
-	_, err := validateModel(m, "error-validate-new-model")
+	_, err := validateModel(db.modelNameCase, m, "error-validate-new-model")
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec(syncUpsertModelSQL, m.ID, m.BrandID, m.Name, m.KeypairID, m.KeypairIDUser, m.APIKey)
+	_, err = db.Exec(syncUpsertModelSQL, m.ID, m.BrandID, m.Name, m.KeypairID, m.KeypairIDUser, m.APIKey, encodeTimestampPolicy(m.TimestampPolicy), encodeDeviceKeyPolicy(m.DeviceKeyPolicy), encodeSerialPipeline(m.SerialPipeline), encodeDuplicatePolicy(m.DuplicatePolicy), encodeSerialResponse(m.SerialResponse))
 	if err != nil {
 		log.Printf("Error updating the database model: %v\n", err)
 		return err
@@ -448,9 +532,10 @@ func (db *DB) CheckAPIKey(apiKey string) bool {
 	return db.checkBoolQuery(row)
 }
 
-// CheckModelExists validates that there is a model for the brand and name
+// CheckModelExists validates that there is a model for the canonical brand and name
 func (db *DB) CheckModelExists(brandID, name string) bool {
-	row := db.QueryRow(checkModelExistsSQL, brandID, name)
+	brandID = CanonicalBrandID(brandID)
+	row := db.QueryRow(checkModelExistsSQL, brandID, CanonicalModelName(db.modelNameCase, brandID, name))
 	return db.checkBoolQuery(row)
 }
 
@@ -21,6 +21,7 @@ package datastore
 
 import (
 	"crypto/sha1"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
@@ -28,31 +29,56 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/random"
 )
 
 // Set the nonce expiry time
 const nonceMaximumAge = 600
 
+// NonceBatchMaximum is the maximum number of nonces that can be issued in a single batch
+const NonceBatchMaximum = 100
+
+// NonceOutstandingMaximum is the maximum number of unused nonces an API key can hold
+const NonceOutstandingMaximum = 1000
+
 const createDeviceNonceTableSQL = `
 	CREATE TABLE IF NOT EXISTS devicenonce (
 		id             serial primary key not null,
 		nonce          varchar(200) not null,
 		timestamp      int not null,		
-		created        timestamp default current_timestamp
+		created        timestamp default current_timestamp,
+		api_key        varchar(200) default '',
+		client_ip      varchar(200) default ''
 	)
 `
 
+// Additional columns
+const alterDeviceNonceAddAPIKeySQL = "ALTER TABLE devicenonce ADD COLUMN api_key varchar(200) default ''"
+const alterDeviceNonceAddClientIPSQL = "ALTER TABLE devicenonce ADD COLUMN client_ip varchar(200) default ''"
+
 // Indexes
 const createDeviceNonceNonceIndexSQL = "CREATE INDEX IF NOT EXISTS nonce_idx ON devicenonce (nonce)"
 const createDeviceNonceTimeStampIndexSQL = "CREATE INDEX IF NOT EXISTS timestamp_idx ON devicenonce (timestamp)"
+const createDeviceNonceAPIKeyIndexSQL = "CREATE INDEX IF NOT EXISTS nonce_apikey_idx ON devicenonce (api_key)"
 
 // Queries
 const maxIDDeviceNonceSQLite = "SELECT COUNT(*)+1 from devicenonce"
-const createDeviceNonceSQLite = "INSERT INTO devicenonce (id, nonce, timestamp) VALUES ($1, $2, $3)"
-const createDeviceNonceSQL = "INSERT INTO devicenonce (nonce, timestamp) VALUES ($1, $2)"
+const createDeviceNonceSQLite = "INSERT INTO devicenonce (id, nonce, timestamp, api_key, client_ip) VALUES ($1, $2, $3, $4, $5)"
+const createDeviceNonceSQL = "INSERT INTO devicenonce (nonce, timestamp, api_key, client_ip) VALUES ($1, $2, $3, $4)"
+const countDeviceNonceSQL = "SELECT COUNT(*) FROM devicenonce WHERE api_key=$1 AND timestamp>=$2"
+const lockDeviceNonceAPIKeySQL = "SELECT pg_advisory_xact_lock(hashtext($1))"
 const deleteExpiredDeviceNonceSQL = "DELETE FROM devicenonce where timestamp<$1"
-const deleteDeviceNonceSQL = "DELETE FROM devicenonce where nonce=$1"
+const deleteDeviceNonceSQL = "DELETE FROM devicenonce where nonce=$1 AND timestamp>=$2"
+const deleteDeviceNonceForAPIKeySQL = "DELETE FROM devicenonce where nonce=$1 AND timestamp>=$2 AND api_key=$3"
+const deleteDeviceNonceForClientSQL = "DELETE FROM devicenonce where nonce=$1 AND timestamp>=$2 AND api_key=$3 AND client_ip=$4"
+
+// Bindings of a device nonce to the request that issued it, set by the nonceBinding config
+const (
+	NonceBindAPIKey   = "apikey"    // the nonce can only be used with the API key that requested it
+	NonceBindClientIP = "apikey-ip" // the nonce can only be used with the API key and from the client IP
+	NonceBindNone     = "none"      // the nonce can be used with any API key, as in earlier versions
+)
 
 // DeviceNonce holds the details of the nonce, combining a timestamp and random text
 type DeviceNonce struct {
@@ -76,11 +102,33 @@ func (db *DB) CreateDeviceNonceTable() error {
 		return err
 	}
 	_, err = db.Exec(createDeviceNonceTimeStampIndexSQL)
+	if err != nil {
+		return err
+	}
+
+	// Ignoring the error when adding the columns
+	db.Exec(alterDeviceNonceAddAPIKeySQL)
+	db.Exec(alterDeviceNonceAddClientIPSQL)
+
+	_, err = db.Exec(createDeviceNonceAPIKeyIndexSQL)
 	return err
 }
 
-// CreateDeviceNonce stores a new nonce entry
-func (db *DB) CreateDeviceNonce() (DeviceNonce, error) {
+// ErrNonceLimit is returned when a batch of nonces would take the API key over its outstanding maximum
+var ErrNonceLimit = errors.New("Too many unused nonces have been issued for the API key")
+
+// nonceQuerier is satisfied by both the database and a transaction
+type nonceQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// CreateDeviceNonce stores a new nonce entry, issued for the model API key and the client IP
+func (db *DB) CreateDeviceNonce(apiKey, clientIP string) (DeviceNonce, error) {
+	return createDeviceNonce(db, apiKey, clientIP)
+}
+
+func createDeviceNonce(q nonceQuerier, apiKey, clientIP string) (DeviceNonce, error) {
 	// Generate a nonce with a timestamp and random string
 	nonce, err := generateNonce()
 	if err != nil {
@@ -89,18 +137,18 @@ func (db *DB) CreateDeviceNonce() (DeviceNonce, error) {
 	}
 
 	// Create the nonce in the database
-	if InFactory() {
+	if usesSQLite() {
 		// Need to generate our own ID
 		var nextID int
-		err = db.QueryRow(maxIDDeviceNonceSQLite).Scan(&nextID)
+		err = q.QueryRow(maxIDDeviceNonceSQLite).Scan(&nextID)
 		if err != nil {
 			log.Printf("Error retrieving next nonce ID: %v\n", err)
 			return nonce, err
 		}
 
-		_, err = db.Exec(createDeviceNonceSQLite, nextID, nonce.Nonce, nonce.TimeStamp)
+		_, err = q.Exec(createDeviceNonceSQLite, nextID, nonce.Nonce, nonce.TimeStamp, apiKey, clientIP)
 	} else {
-		_, err = db.Exec(createDeviceNonceSQL, nonce.Nonce, nonce.TimeStamp)
+		_, err = q.Exec(createDeviceNonceSQL, nonce.Nonce, nonce.TimeStamp, apiKey, clientIP)
 	}
 
 	if err != nil {
@@ -111,30 +159,104 @@ func (db *DB) CreateDeviceNonce() (DeviceNonce, error) {
 	return nonce, nil
 }
 
-// DeleteExpiredDeviceNonces removes nonces with timestamp older than max allowed lifetime
-func (db *DB) DeleteExpiredDeviceNonces() error {
-	// Remove expired nonces from the table
+// CreateDeviceNonces stores a batch of new nonce entries, issued for the model API key and the
+// client IP. Each nonce is single-use and expires independently of the others. The outstanding
+// nonces of the API key are counted in the same transaction as the inserts, so the batch is either
+// stored whole or not at all, and returns ErrNonceLimit if it would exceed NonceOutstandingMaximum.
+func (db *DB) CreateDeviceNonces(apiKey, clientIP string, count int) ([]DeviceNonce, error) {
+	nonces := []DeviceNonce{}
+
+	if count < 1 || count > NonceBatchMaximum {
+		return nonces, fmt.Errorf("The number of nonces must be between 1 and %d", NonceBatchMaximum)
+	}
+
+	err := db.transaction(func(tx *sql.Tx) error {
+		// Serialize the batches of the API key, so concurrent requests cannot both pass the count
+		if !usesSQLite() {
+			if _, err := tx.Exec(lockDeviceNonceAPIKeySQL, apiKey); err != nil {
+				log.Printf("Error locking the nonces of the API key: %v\n", err)
+				return err
+			}
+		}
+
+		var outstanding int
+		timestamp := time.Now().Unix() - nonceMaximumAge
+		if err := tx.QueryRow(countDeviceNonceSQL, apiKey, timestamp).Scan(&outstanding); err != nil {
+			log.Printf("Error counting the nonces: %v\n", err)
+			return err
+		}
+		if outstanding+count > NonceOutstandingMaximum {
+			return ErrNonceLimit
+		}
+
+		for i := 0; i < count; i++ {
+			nonce, err := createDeviceNonce(tx, apiKey, clientIP)
+			if err != nil {
+				return err
+			}
+			nonces = append(nonces, nonce)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nonces, nil
+}
+
+// CountDeviceNonces returns the number of unexpired nonces that have been issued for the model API key
+func (db *DB) CountDeviceNonces(apiKey string) (int, error) {
+	var count int
+
 	timestamp := time.Now().Unix() - nonceMaximumAge
-	_, err := db.Exec(deleteExpiredDeviceNonceSQL, timestamp)
+	err := db.QueryRow(countDeviceNonceSQL, apiKey, timestamp).Scan(&count)
 	if err != nil {
-		log.Printf("Error deleting expired nonces: %v\n", err)
-		return errors.New("Error communicating with the database")
+		log.Printf("Error counting the nonces: %v\n", err)
+		return 0, errors.New("Error communicating with the database")
 	}
 
-	return nil
+	return count, nil
 }
 
-// ValidateDeviceNonce checks that a device nonce is valid and has not expired
-func (db *DB) ValidateDeviceNonce(nonce string) error {
-	err := db.DeleteExpiredDeviceNonces()
+// DeleteExpiredDeviceNonces removes nonces with timestamp older than max allowed lifetime,
+// returning the number of nonces that were removed
+func (db *DB) DeleteExpiredDeviceNonces() (int, error) {
+	// Remove expired nonces from the table
+	timestamp := time.Now().Unix() - nonceMaximumAge
+	result, err := db.Exec(deleteExpiredDeviceNonceSQL, timestamp)
 	if err != nil {
-		log.Printf("Error checking expired nonces: %v\n", err)
-		return err
+		log.Printf("Error deleting expired nonces: %v\n", err)
+		return 0, errors.New("Error communicating with the database")
 	}
-	// Find the nonce in the database to check that it is valid (we already deleted expired nonces)
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Error checking expired nonces delete row count: %v\n", err)
+		return 0, errors.New("Error communicating with the database")
+	}
+	return int(rows), nil
+}
+
+// ValidateDeviceNonce checks that a device nonce is valid, has not expired and, depending on the
+// nonce binding, that it was issued for the API key and client IP of the request
+func (db *DB) ValidateDeviceNonce(nonce, apiKey, clientIP string) error {
+	// Find the nonce in the database to check that it is valid and has not expired. The expired
+	// nonces are left for the janitor to remove.
 	// Here we attempt to delete the nonce and check the number of rows affected. This makes sure that
-	// we do not allow a nonce to be re-used.
-	result, err := db.Exec(deleteDeviceNonceSQL, nonce)
+	// we do not allow a nonce to be re-used. A nonce that is bound to another API key is not used up.
+	timestamp := time.Now().Unix() - nonceMaximumAge
+
+	var result sql.Result
+	var err error
+	switch db.nonceBinding {
+	case NonceBindNone:
+		result, err = db.Exec(deleteDeviceNonceSQL, nonce, timestamp)
+	case NonceBindClientIP:
+		result, err = db.Exec(deleteDeviceNonceForClientSQL, nonce, timestamp, apiKey, clientIP)
+	default:
+		result, err = db.Exec(deleteDeviceNonceForAPIKeySQL, nonce, timestamp, apiKey)
+	}
 	if err != nil {
 		log.Printf("Error checking nonce: %v\n", err)
 		return errors.New("Error communicating with the database")
@@ -152,6 +274,17 @@ func (db *DB) ValidateDeviceNonce(nonce string) error {
 	return nil
 }
 
+// NonceBinding returns the binding of the device nonces from the config, binding them to
+// the API key by default
+func NonceBinding(settings config.Settings) string {
+	switch settings.NonceBinding {
+	case NonceBindNone, NonceBindClientIP:
+		return settings.NonceBinding
+	default:
+		return NonceBindAPIKey
+	}
+}
+
 func generateNonce() (DeviceNonce, error) {
 	token, err := random.GenerateRandomString(64)
 	if err != nil {
@@ -0,0 +1,292 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// Statuses of a brand onboarding
+const (
+	OnboardingPending   = "pending"
+	OnboardingApproved  = "approved" // the brand is being provisioned
+	OnboardingCompleted = "completed"
+	OnboardingRejected  = "rejected"
+	OnboardingFailed    = "failed"
+)
+
+// Onboarding errors
+var (
+	ErrOnboardingNotFound = errors.New("Cannot find the onboarding")
+	ErrOnboardingDecided  = errors.New("The onboarding has already been decided")
+)
+
+const createOnboardingTableSQL = `
+	CREATE TABLE IF NOT EXISTS onboarding (
+		id                serial primary key not null,
+		token_hash        varchar(200) not null unique,
+		authority_id      varchar(200) not null,
+		username          varchar(200) not null,
+		name              varchar(200) not null,
+		email             varchar(200) not null,
+		account_assertion text not null,
+		key_name          varchar(200) not null,
+		key_id            varchar(200) default '',
+		sealed_key        text default '',
+		generate          bool default false,
+		model             varchar(200) not null,
+		api_key           varchar(200) default '',
+		status            varchar(20) not null,
+		decided_by        varchar(200) default '',
+		reason            text default '',
+		created           timestamp default current_timestamp,
+		decided           timestamp
+	)
+`
+
+const onboardingFields = `id, authority_id, username, name, email, account_assertion, key_name, key_id, sealed_key,
+	generate, model, api_key, status, decided_by, reason, created, decided`
+
+const findOpenOnboardingSQL = "SELECT EXISTS(SELECT * FROM onboarding WHERE authority_id=$1 AND status IN ('pending', 'approved'))"
+
+const createOnboardingSQL = `
+	INSERT INTO onboarding (token_hash, authority_id, username, name, email, account_assertion, key_name, key_id,
+		sealed_key, generate, model, status)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,'pending')
+	RETURNING id, created`
+
+const getOnboardingSQL = "SELECT " + onboardingFields + " FROM onboarding WHERE id=$1"
+const getOnboardingForUpdateSQL = getOnboardingSQL + " FOR UPDATE"
+const getOnboardingByTokenSQL = "SELECT " + onboardingFields + " FROM onboarding WHERE token_hash=$1"
+const listOnboardingsSQL = "SELECT " + onboardingFields + " FROM onboarding ORDER BY id DESC"
+const listOnboardingsForStatusSQL = "SELECT " + onboardingFields + " FROM onboarding WHERE status=$1 ORDER BY id DESC"
+
+const decideOnboardingSQL = "UPDATE onboarding SET status=$2, decided_by=$3, reason=$4, decided=$5 WHERE id=$1"
+
+// The signing-key that is uploaded with an onboarding is discarded once it is provisioned or rejected
+const finishOnboardingSQL = "UPDATE onboarding SET status=$2, api_key=$3, reason=$4, sealed_key='' WHERE id=$1"
+const rejectOnboardingSQL = "UPDATE onboarding SET status=$2, decided_by=$3, reason=$4, decided=$5, sealed_key='' WHERE id=$1"
+
+// Onboarding is the application of a new brand to use the vault. The brand admin supplies
+// their account assertion, a signing-key or a request for the vault to generate one, and
+// their first model. Once an operator approves it, the account, the admin user, the
+// signing-key and the model are provisioned, and the API key of the model is returned to
+// the applicant. Only the hash of the onboarding token of the applicant is stored
+type Onboarding struct {
+	ID               int        `json:"id"`
+	Token            string     `json:"token,omitempty"`
+	AuthorityID      string     `json:"authority-id"`
+	Username         string     `json:"username"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	AccountAssertion string     `json:"account-assertion"`
+	KeyName          string     `json:"key-name"`
+	KeyID            string     `json:"key-id"` // of the uploaded signing-key
+	SealedKey        string     `json:"-"`
+	Generate         bool       `json:"generate"` // the signing-key is generated by the vault
+	Model            string     `json:"model"`
+	APIKey           string     `json:"api-key,omitempty"`
+	Status           string     `json:"status"`
+	DecidedBy        string     `json:"decided-by"`
+	Reason           string     `json:"reason"` // of a rejection or a failed provisioning
+	Created          time.Time  `json:"created"`
+	Decided          *time.Time `json:"decided,omitempty"`
+}
+
+// ValidateOnboarding checks the details of an onboarding application
+func ValidateOnboarding(modelNameCase []config.ModelNameCase, o Onboarding) error {
+	if err := validateAuthorityID(o.AuthorityID); err != nil {
+		return err
+	}
+	if err := validateUser(User{Username: o.Username, Name: o.Name, Email: o.Email, Role: Admin}); err != nil {
+		return err
+	}
+	if err := validateNotEmpty("Account assertion", o.AccountAssertion); err != nil {
+		return err
+	}
+	if err := ValidateAccountAssertion(Account{AuthorityID: o.AuthorityID, Assertion: o.AccountAssertion}); err != nil {
+		return err
+	}
+	if err := validateNotEmpty("Key name", o.KeyName); err != nil {
+		return err
+	}
+	if o.Generate == (len(o.SealedKey) > 0) {
+		return errors.New("A signing-key must either be uploaded or generated by the vault")
+	}
+	return validateBrandModelName(modelNameCase, o.AuthorityID, o.Model)
+}
+
+// Decide checks that the onboarding can be decided, and records the decision
+func (o *Onboarding) Decide(approved bool, decidedBy, reason string, now time.Time) error {
+	if o.Status != OnboardingPending {
+		return ErrOnboardingDecided
+	}
+
+	o.Status = OnboardingRejected
+	if approved {
+		o.Status = OnboardingApproved
+	}
+	o.DecidedBy = decidedBy
+	o.Reason = reason
+	o.Decided = &now
+	return nil
+}
+
+// CreateOnboardingTable creates the database table for the onboarding of the brands
+func (db *DB) CreateOnboardingTable() error {
+	_, err := db.Exec(createOnboardingTableSQL)
+	return err
+}
+
+// CreateOnboarding stores a pending onboarding and returns it with its onboarding token.
+// A brand can only have one onboarding that is not decided or provisioned
+func (db *DB) CreateOnboarding(o Onboarding) (Onboarding, error) {
+	if err := ValidateOnboarding(db.modelNameCase, o); err != nil {
+		return o, err
+	}
+
+	token, hash, err := NewShareTokenSecret()
+	if err != nil {
+		log.Printf("Error generating the onboarding token: %v\n", err)
+		return o, err
+	}
+
+	err = db.transaction(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(findOpenOnboardingSQL, o.AuthorityID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return errors.New("The brand already has an onboarding in progress")
+		}
+		return tx.QueryRow(createOnboardingSQL, hash, o.AuthorityID, o.Username, o.Name, o.Email, o.AccountAssertion,
+			o.KeyName, o.KeyID, o.SealedKey, o.Generate, o.Model).Scan(&o.ID, &o.Created)
+	})
+	if err != nil {
+		log.Printf("Error creating the onboarding: %v\n", err)
+		return o, err
+	}
+
+	o.Token = token
+	o.Status = OnboardingPending
+	return o, nil
+}
+
+// GetOnboarding fetches an onboarding, with its sealed signing-key
+func (db *DB) GetOnboarding(onboardingID int) (Onboarding, error) {
+	o, err := scanOnboarding(db.QueryRow(getOnboardingSQL, onboardingID))
+	if err == sql.ErrNoRows {
+		return o, ErrOnboardingNotFound
+	}
+	return o, err
+}
+
+// GetOnboardingByToken fetches the onboarding of an onboarding token
+func (db *DB) GetOnboardingByToken(token string) (Onboarding, error) {
+	if len(token) == 0 {
+		return Onboarding{}, errors.New("The onboarding token must be provided")
+	}
+
+	o, err := scanOnboarding(db.QueryRow(getOnboardingByTokenSQL, ShareTokenHash(token)))
+	if err == sql.ErrNoRows {
+		return o, errors.New("Invalid onboarding token")
+	}
+	return o, err
+}
+
+// ListOnboardings returns the onboardings with the status, or all the onboardings when it is empty
+func (db *DB) ListOnboardings(status string) ([]Onboarding, error) {
+	var rows *sql.Rows
+	var err error
+	if len(status) == 0 {
+		rows, err = db.Query(listOnboardingsSQL)
+	} else {
+		rows, err = db.Query(listOnboardingsForStatusSQL, status)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the onboardings: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	onboardings := []Onboarding{}
+	for rows.Next() {
+		o, err := scanOnboarding(rows)
+		if err != nil {
+			return nil, err
+		}
+		o.SealedKey = ""
+		onboardings = append(onboardings, o)
+	}
+	return onboardings, rows.Err()
+}
+
+// DecideOnboarding approves or rejects a pending onboarding. The uploaded signing-key of a
+// rejected onboarding is discarded
+func (db *DB) DecideOnboarding(onboardingID int, approved bool, decidedBy, reason string) (Onboarding, error) {
+	var onboarding Onboarding
+	err := db.transaction(func(tx *sql.Tx) error {
+		o, err := scanOnboarding(tx.QueryRow(getOnboardingForUpdateSQL, onboardingID))
+		switch {
+		case err == sql.ErrNoRows:
+			return ErrOnboardingNotFound
+		case err != nil:
+			return err
+		}
+
+		if err := o.Decide(approved, decidedBy, reason, time.Now().UTC()); err != nil {
+			return err
+		}
+		if !approved {
+			o.SealedKey = ""
+			_, err = tx.Exec(rejectOnboardingSQL, o.ID, o.Status, o.DecidedBy, o.Reason, o.Decided)
+		} else {
+			_, err = tx.Exec(decideOnboardingSQL, o.ID, o.Status, o.DecidedBy, o.Reason, o.Decided)
+		}
+		onboarding = o
+		return err
+	})
+	return onboarding, err
+}
+
+// FinishOnboarding records the outcome of the provisioning of an approved onboarding: the API
+// key of the model when it is completed, or the reason when it has failed
+func (db *DB) FinishOnboarding(onboardingID int, status, apiKey, reason string) error {
+	_, err := db.Exec(finishOnboardingSQL, onboardingID, status, apiKey, reason)
+	if err != nil {
+		log.Printf("Error finishing the onboarding: %v\n", err)
+	}
+	return err
+}
+
+type onboardingScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOnboarding(row onboardingScanner) (Onboarding, error) {
+	o := Onboarding{}
+	err := row.Scan(&o.ID, &o.AuthorityID, &o.Username, &o.Name, &o.Email, &o.AccountAssertion, &o.KeyName, &o.KeyID,
+		&o.SealedKey, &o.Generate, &o.Model, &o.APIKey, &o.Status, &o.DecidedBy, &o.Reason, &o.Created, &o.Decided)
+	return o, err
+}
@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+// DefaultSlowQuery is the time after which a query is recorded in the slow-query log
+const DefaultSlowQuery = 500 * time.Millisecond
+
+// slowQueryThreshold returns the configured time after which a query is slow
+func slowQueryThreshold(settings config.Settings) time.Duration {
+	if settings.DatastoreSlowQuery > 0 {
+		return time.Duration(settings.DatastoreSlowQuery) * time.Millisecond
+	}
+	return DefaultSlowQuery
+}
+
+// observeQuery records the latency of the query, and logs it when it is slower than the
+// threshold. The parameters may hold secrets or personal data, so they are never logged
+func observeQuery(query string, args []interface{}, start time.Time, threshold time.Duration) {
+	d := time.Since(start)
+	query = normalizeQuery(query)
+	metrics.ObserveQuery(query, d)
+
+	if d < threshold {
+		return
+	}
+	params := redactParams(args)
+	log.Printf("Slow query (%v): %s %v\n", d, query, params)
+	metrics.RecordSlowQuery(metrics.SlowQuery{Query: query, Params: params, DurationMS: int64(d / time.Millisecond), Time: start.UTC()})
+}
+
+// normalizeQuery collapses the whitespace of the statement, so it fits on one line
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// redactParams replaces the parameters of a query with their types
+func redactParams(args []interface{}) []string {
+	params := []string{}
+	for _, a := range args {
+		if a == nil {
+			params = append(params, "null")
+			continue
+		}
+		params = append(params, fmt.Sprintf("%T", a))
+	}
+	return params
+}
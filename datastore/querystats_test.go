@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+func TestObserveQuery(t *testing.T) {
+	queries := metrics.Value(metrics.DatastoreQueries)
+	slow := metrics.Value(metrics.DatastoreSlowQueries)
+
+	query := `
+		select id from sharetoken
+		where token_hash=$1 and brand_id=$2`
+	observeQuery(query, []interface{}{"secret", 42, nil}, time.Now(), 100*time.Millisecond)
+	if metrics.Value(metrics.DatastoreQueries) != queries+1 || metrics.Value(metrics.DatastoreSlowQueries) != slow {
+		t.Error("Expected the query to be counted, but not as a slow query")
+	}
+
+	observeQuery(query, []interface{}{"secret", 42, nil}, time.Now().Add(-200*time.Millisecond), 100*time.Millisecond)
+	if metrics.Value(metrics.DatastoreSlowQueries) != slow+1 {
+		t.Fatal("Expected a slow query")
+	}
+
+	q := metrics.SlowQueries()[0]
+	if q.Query != "select id from sharetoken where token_hash=$1 and brand_id=$2" {
+		t.Errorf("Expected the normalized query, got: %s", q.Query)
+	}
+	if !reflect.DeepEqual(q.Params, []string{"string", "int", "null"}) {
+		t.Errorf("Expected the redacted parameters, got: %v", q.Params)
+	}
+	if q.DurationMS < 200 {
+		t.Errorf("Expected the duration of the query, got: %d", q.DurationMS)
+	}
+
+	h, ok := metrics.Queries()[q.Query]
+	if !ok || h.Count < 2 {
+		t.Errorf("Expected the latency histogram of the query, got: %+v", h)
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	if threshold := slowQueryThreshold(config.Settings{}); threshold != DefaultSlowQuery {
+		t.Errorf("Expected the default threshold, got %v", threshold)
+	}
+	if threshold := slowQueryThreshold(config.Settings{DatastoreSlowQuery: 50}); threshold != 50*time.Millisecond {
+		t.Errorf("Expected the configured threshold, got %v", threshold)
+	}
+}
@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTracedQueries limits the statements that are captured for a request
+const maxTracedQueries = 500
+
+// TracedQuery is a statement that was run for a request, with the plan of a query. The
+// parameters may hold secrets or personal data, so only their types are captured
+type TracedQuery struct {
+	Query      string   `json:"query"`
+	Params     []string `json:"params"`
+	DurationMS float64  `json:"duration-ms"`
+	Plan       []string `json:"plan,omitempty"`
+	PlanError  string   `json:"plan-error,omitempty"`
+}
+
+// QueryTrace captures the statements that are run with the database bound to a context, for
+// diagnosing the slow requests. The statements of the transactions are not captured. The
+// operations of the keystore are captured too, to tell whether the keystore or the database
+// slows down a request
+type QueryTrace struct {
+	lock      sync.Mutex
+	Queries   []TracedQuery             `json:"queries"`
+	Keystore  []TracedKeystoreOperation `json:"keystore,omitempty"`
+	Truncated bool                      `json:"truncated,omitempty"`
+}
+
+type queryTraceKey struct{}
+
+// WithQueryTrace returns a context that captures the statements that are run with the database
+// bound to it
+func WithQueryTrace(ctx context.Context) (context.Context, *QueryTrace) {
+	trace := &QueryTrace{Queries: []TracedQuery{}}
+	return context.WithValue(ctx, queryTraceKey{}, trace), trace
+}
+
+// queryTrace returns the trace of the context, or nil when the statements are not captured
+func queryTrace(ctx context.Context) *QueryTrace {
+	if ctx == nil {
+		return nil
+	}
+	trace, _ := ctx.Value(queryTraceKey{}).(*QueryTrace)
+	return trace
+}
+
+// add captures a statement, until the limit of the trace is reached
+func (t *QueryTrace) add(q TracedQuery) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.Queries) >= maxTracedQueries {
+		t.Truncated = true
+		return
+	}
+	t.Queries = append(t.Queries, q)
+}
+
+// addKeystore captures an operation of the keystore, until the limit of the trace is reached
+func (t *QueryTrace) addKeystore(op TracedKeystoreOperation) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.Keystore) >= maxTracedQueries {
+		t.Truncated = true
+		return
+	}
+	t.Keystore = append(t.Keystore, op)
+}
+
+// queryPlan is the plan of a query that is captured in the trace
+type queryPlan struct {
+	plan []string
+	err  error
+}
+
+// explain returns the plan of the query when the statements are captured for the context. Only
+// the queries are explained, as the plan is not run, and it is explained before the query is run
+// so it does not wait for the connection of the query
+func (db *DB) explain(query string, args []interface{}) *queryPlan {
+	if queryTrace(db.ctx) == nil {
+		return nil
+	}
+	statement := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(statement, "SELECT") && !strings.HasPrefix(statement, "WITH") {
+		return &queryPlan{}
+	}
+
+	explain := "EXPLAIN "
+	if Environ != nil && Environ.UsesSQLite() {
+		explain = "EXPLAIN QUERY PLAN "
+	}
+
+	rows, err := db.DB.QueryContext(db.context(), explain+query, args...)
+	if err != nil {
+		return &queryPlan{err: err}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return &queryPlan{err: err}
+	}
+
+	p := &queryPlan{plan: []string{}}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return &queryPlan{err: err}
+		}
+
+		// The plan is the last column: the line of the plan for postgres, the detail for sqlite
+		line := *(values[len(values)-1].(*interface{}))
+		if b, ok := line.([]byte); ok {
+			line = string(b)
+		}
+		p.plan = append(p.plan, fmt.Sprint(line))
+	}
+	p.err = rows.Err()
+	return p
+}
+
+// observe records the latency of the statement, capturing it in the trace of the context
+func (db *DB) observe(query string, args []interface{}, plan *queryPlan, start time.Time) {
+	// The databases that are not opened from the config use the default threshold
+	threshold := db.slowQuery
+	if threshold == 0 {
+		threshold = DefaultSlowQuery
+	}
+	observeQuery(query, args, start, threshold)
+
+	trace := queryTrace(db.ctx)
+	if trace == nil {
+		return
+	}
+
+	q := TracedQuery{
+		Query:      normalizeQuery(query),
+		Params:     redactParams(args),
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if plan != nil {
+		q.Plan = plan.plan
+		if plan.err != nil {
+			q.PlanError = plan.err.Error()
+		}
+	}
+	trace.add(q)
+}
@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestQueryTrace(t *testing.T) {
+	env := Environ
+	defer func() { Environ = env }()
+	Environ = &Env{Config: config.Settings{Driver: "sqlite3"}}
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening the database: %v", err)
+	}
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &DB{DB: sqlDB}
+	if _, err := db.Exec("CREATE TABLE model (id int, name varchar(200))"); err != nil {
+		t.Fatalf("Error creating the table: %v", err)
+	}
+
+	ctx, trace := WithQueryTrace(context.Background())
+	traced := db.WithContext(ctx).(*DB)
+	if _, err := traced.Exec("INSERT INTO model (id, name) VALUES ($1, $2)", 1, "alder"); err != nil {
+		t.Fatalf("Error storing the model: %v", err)
+	}
+	var name string
+	if err := traced.QueryRow("SELECT name FROM model WHERE id=$1", 1).Scan(&name); err != nil {
+		t.Fatalf("Error retrieving the model: %v", err)
+	}
+
+	// The statements that are not bound to the trace are not captured
+	db.QueryRow("SELECT name FROM model WHERE id=$1", 1).Scan(&name)
+
+	if len(trace.Queries) != 2 {
+		t.Fatalf("Expected 2 captured statements, got: %d", len(trace.Queries))
+	}
+
+	insert, query := trace.Queries[0], trace.Queries[1]
+	if len(insert.Plan) != 0 || insert.Params[0] != "int" || insert.Params[1] != "string" {
+		t.Errorf("Unexpected capture of the insert: %#v", insert)
+	}
+	if query.Query != "SELECT name FROM model WHERE id=$1" || len(query.Plan) == 0 || len(query.PlanError) > 0 {
+		t.Errorf("Unexpected capture of the query: %#v", query)
+	}
+}
+
+func TestQueryTraceLimit(t *testing.T) {
+	_, trace := WithQueryTrace(context.Background())
+	for i := 0; i < maxTracedQueries+1; i++ {
+		trace.add(TracedQuery{Query: "SELECT 1"})
+	}
+
+	if len(trace.Queries) != maxTracedQueries || !trace.Truncated {
+		t.Errorf("Expected the trace to be truncated at %d statements, got: %d", maxTracedQueries, len(trace.Queries))
+	}
+}
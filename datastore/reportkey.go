@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+)
+
+// reportKeySettingCode is the setting that holds the sealed reporting key
+const reportKeySettingCode = "report-signing-key"
+
+const reportKeyBits = 2048
+
+// reportKeyMutex serializes the generation of the reporting key
+var reportKeyMutex sync.Mutex
+
+// ReportSignature holds the detached signature of a report document
+type ReportSignature struct {
+	Signature string `json:"signature"` // base64 encoded RSA PKCS#1 v1.5 signature of the SHA-256 digest
+	KeyID     string `json:"key-id"`
+}
+
+// ReportPublicKey returns the PEM encoded public key of the vault reporting key
+// and its ID, so that auditors can verify signed reports
+func ReportPublicKey(db Datastore, settings config.Settings) (string, string, error) {
+	key, err := reportKey(db, settings)
+	if err != nil {
+		return "", "", err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), reportKeyID(der), nil
+}
+
+// SignReport signs a report document with the vault reporting key
+func SignReport(db Datastore, settings config.Settings, document []byte) (ReportSignature, error) {
+	key, err := reportKey(db, settings)
+	if err != nil {
+		return ReportSignature{}, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return ReportSignature{}, err
+	}
+
+	digest := sha256.Sum256(document)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return ReportSignature{}, err
+	}
+
+	return ReportSignature{
+		Signature: base64.StdEncoding.EncodeToString(signature),
+		KeyID:     reportKeyID(der),
+	}, nil
+}
+
+// reportKeyID is the SHA-256 fingerprint of the DER encoded public key
+func reportKeyID(der []byte) string {
+	digest := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// reportKey fetches the reporting key, generating and storing it on first use.
+// The key is sealed with the keystore secret
+func reportKey(db Datastore, settings config.Settings) (*rsa.PrivateKey, error) {
+	reportKeyMutex.Lock()
+	defer reportKeyMutex.Unlock()
+
+	setting, err := db.GetSetting(reportKeySettingCode)
+	if err == nil {
+		return unsealReportKeyWithSecret(setting.Data, settings.KeyStoreSecret)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, reportKeyBits)
+	if err != nil {
+		log.Printf("Error generating the reporting key: %v\n", err)
+		return nil, err
+	}
+
+	sealed, err := crypt.EncryptKey(string(x509.MarshalPKCS1PrivateKey(key)), settings.KeyStoreSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PutSetting(Setting{Code: reportKeySettingCode, Data: base64.StdEncoding.EncodeToString(sealed)})
+	return key, err
+}
+
+func unsealReportKeyWithSecret(data, keystoreSecret string) (*rsa.PrivateKey, error) {
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := crypt.DecryptKey(sealed, keystoreSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("The stored reporting key is invalid")
+	}
+	return key, nil
+}
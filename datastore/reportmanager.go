@@ -0,0 +1,147 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ReportDateFormat is the format of the days in a production report
+const ReportDateFormat = "2006-01-02"
+
+const productionReportSQL = `
+	SELECT model, serial_number, created
+	FROM signinglog
+	WHERE make=$1 AND created >= $2 AND created < $3
+	ORDER BY model, created`
+const productionReportForUserSQL = `
+	SELECT s.model, s.serial_number, s.created
+	FROM signinglog s
+	INNER JOIN account acc on acc.authority_id=s.make
+	INNER JOIN useraccountlink ua on ua.account_id=acc.id
+	INNER JOIN userinfo u on ua.user_id=u.id
+	WHERE s.make=$1 AND s.created >= $2 AND s.created < $3 AND u.username=$4
+	ORDER BY s.model, s.created`
+
+// ReportModelDay holds the number of devices signed for a model on a day
+type ReportModelDay struct {
+	Model string `json:"model"`
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// ReportSerialRange holds the lowest and highest serial numbers signed for a model
+type ReportSerialRange struct {
+	Model   string `json:"model"`
+	First   string `json:"first"`
+	Last    string `json:"last"`
+	Serials int    `json:"serials"` // number of distinct serial numbers
+}
+
+// ProductionReport holds the production numbers of a brand for a period
+type ProductionReport struct {
+	AuthorityID string              `json:"authority-id"`
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	Generated   time.Time           `json:"generated"`
+	Days        []ReportModelDay    `json:"days"`
+	Ranges      []ReportSerialRange `json:"ranges"`
+}
+
+func (db *DB) allProductionReport(authorityID string, from, to time.Time) (ProductionReport, error) {
+	return db.productionReportFilteredByUser(anyUserFilter, authorityID, from, to)
+}
+
+// productionReportFilteredByUser builds the report for the signing logs between
+// the from and to days (inclusive)
+func (db *DB) productionReportFilteredByUser(username, authorityID string, from, to time.Time) (ProductionReport, error) {
+	report := ProductionReport{
+		AuthorityID: authorityID,
+		From:        from.Format(ReportDateFormat),
+		To:          to.Format(ReportDateFormat),
+		Generated:   time.Now().UTC(),
+		Days:        []ReportModelDay{},
+		Ranges:      []ReportSerialRange{},
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	end := to.AddDate(0, 0, 1)
+	if len(username) == 0 {
+		rows, err = db.Query(productionReportSQL, authorityID, from, end)
+	} else {
+		rows, err = db.Query(productionReportForUserSQL, authorityID, from, end, username)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the production report: %v\n", err)
+		return report, err
+	}
+	defer rows.Close()
+
+	var (
+		day     *ReportModelDay
+		serials *ReportSerialRange
+		seen    map[string]bool
+	)
+
+	for rows.Next() {
+		var (
+			model, serial string
+			created       time.Time
+		)
+		if err := rows.Scan(&model, &serial, &created); err != nil {
+			return report, err
+		}
+
+		// Rows are ordered by model and date, so a change starts a new entry
+		created = created.UTC()
+		if day == nil || day.Model != model || day.Day != created.Format(ReportDateFormat) {
+			report.Days = append(report.Days, ReportModelDay{Model: model, Day: created.Format(ReportDateFormat)})
+			day = &report.Days[len(report.Days)-1]
+		}
+		day.Count++
+
+		if serials == nil || serials.Model != model {
+			report.Ranges = append(report.Ranges, ReportSerialRange{Model: model, First: serial, Last: serial})
+			serials = &report.Ranges[len(report.Ranges)-1]
+			seen = map[string]bool{}
+		}
+		if serial < serials.First {
+			serials.First = serial
+		}
+		if serial > serials.Last {
+			serials.Last = serial
+		}
+		if !seen[serial] {
+			seen[serial] = true
+			serials.Serials++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
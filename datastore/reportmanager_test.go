@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestProductionReportSQL(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening the database: %v", err)
+	}
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &DB{DB: sqlDB}
+	schema := []string{
+		createSigningLogTableSQL, createAccountTableSQL, createUserTableSQL, createAccountUserLinkTableSQL,
+		"INSERT INTO account (id, authority_id) VALUES (1, 'system')",
+		"INSERT INTO userinfo (id, username, email, userrole, api_key) VALUES (1, 'sv', 'sv@example.com', 100, 'key')",
+		"INSERT INTO useraccountlink (user_id, account_id) VALUES (1, 1)",
+	}
+	for _, s := range schema {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("Error preparing the database: %v", err)
+		}
+	}
+
+	day := time.Date(2018, time.March, 1, 10, 0, 0, 0, time.UTC)
+	logs := []struct {
+		model, serial string
+		created       time.Time
+	}{
+		{"alder", "A100", day},
+		{"alder", "A101", day.Add(time.Hour)},
+		{"alder", "A099", day.AddDate(0, 0, 1)},
+		{"alder", "A200", day.AddDate(0, 0, 5)},
+	}
+	for i, l := range logs {
+		if _, err := db.Exec("INSERT INTO signinglog (id, make, model, serial_number, fingerprint, created) VALUES ($1,$2,$3,$4,$5,$6)",
+			i+1, "system", l.model, l.serial, l.serial, l.created); err != nil {
+			t.Fatalf("Error storing the signing log: %v", err)
+		}
+	}
+
+	tests := []struct {
+		username string
+		users    string
+	}{
+		{anyUserFilter, "any user"},
+		{"sv", "user"},
+	}
+
+	for _, tt := range tests {
+		report, err := db.productionReportFilteredByUser(tt.username, "system", day, day.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("%s: error building the production report: %v", tt.users, err)
+		}
+		if len(report.Days) != 2 || report.Days[0].Count != 2 || report.Days[1].Count != 1 {
+			t.Errorf("%s: unexpected days in the production report: %#v", tt.users, report.Days)
+		}
+		if len(report.Ranges) != 1 || report.Ranges[0].First != "A099" || report.Ranges[0].Last != "A101" || report.Ranges[0].Serials != 3 {
+			t.Errorf("%s: unexpected serial ranges in the production report: %#v", tt.users, report.Ranges)
+		}
+	}
+
+	report, err := db.productionReportFilteredByUser("unknown", "system", day, day.AddDate(0, 0, 1))
+	if err != nil || len(report.Days) != 0 {
+		t.Errorf("Expected an empty report for an unlinked user, got: %#v, %v", report.Days, err)
+	}
+}
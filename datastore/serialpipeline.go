@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+)
+
+// Built-in steps of the serial pipeline
+const (
+	SerialStepTrim        = "trim"         // removes the surrounding whitespace
+	SerialStepUppercase   = "uppercase"    // converts the serial to upper case
+	SerialStepLowercase   = "lowercase"    // converts the serial to lower case
+	SerialStepStripPrefix = "strip-prefix" // removes the prefix in the value, when it is present
+	SerialStepStripSuffix = "strip-suffix" // removes the suffix in the value, when it is present
+	SerialStepRemoveChars = "remove-chars" // removes all the characters in the value, e.g. separators
+	SerialStepLuhn        = "luhn"         // checks the trailing Luhn check digit
+	SerialStepPattern     = "pattern"      // checks the serial against the regular expression in the value
+)
+
+// MaxSerialPipelineSteps is the maximum number of steps in the serial pipeline of a model
+const MaxSerialPipelineSteps = 20
+
+// SerialSteps are the built-in steps of the serial pipeline
+var SerialSteps = []string{SerialStepTrim, SerialStepUppercase, SerialStepLowercase, SerialStepStripPrefix,
+	SerialStepStripSuffix, SerialStepRemoveChars, SerialStepLuhn, SerialStepPattern}
+
+// serialStepsWithValue are the steps that need a value
+var serialStepsWithValue = []string{SerialStepStripPrefix, SerialStepStripSuffix, SerialStepRemoveChars, SerialStepPattern}
+
+// SerialStep is a transformation or validation of the serial number of a serial-request
+type SerialStep struct {
+	Step  string `json:"step"`
+	Value string `json:"value,omitempty"`
+}
+
+// SerialPipeline is the ordered list of steps that normalize the serial number of the
+// serial-requests of a model, before it is signed. By default, the serial is not changed
+type SerialPipeline []SerialStep
+
+// Add the serial pipeline to the models table
+const alterModelSerialPipelineSQL = "ALTER TABLE model ADD COLUMN serial_pipeline text default ''"
+
+// ValidateSerialPipeline checks the steps of the serial pipeline of a model
+func ValidateSerialPipeline(pipeline SerialPipeline) error {
+	if len(pipeline) > MaxSerialPipelineSteps {
+		return fmt.Errorf("The serial pipeline must not have more than %d steps", MaxSerialPipelineSteps)
+	}
+	for _, s := range pipeline {
+		if !containsString(SerialSteps, s.Step) {
+			return fmt.Errorf("The serial pipeline step '%s' is not supported", s.Step)
+		}
+		if containsString(serialStepsWithValue, s.Step) && len(s.Value) == 0 {
+			return fmt.Errorf("The value of the serial pipeline step '%s' must be entered", s.Step)
+		}
+		if !containsString(serialStepsWithValue, s.Step) && len(s.Value) > 0 {
+			return fmt.Errorf("The serial pipeline step '%s' does not take a value", s.Step)
+		}
+		if s.Step == SerialStepPattern {
+			if _, err := regexp.Compile(s.Value); err != nil {
+				return fmt.Errorf("The pattern of the serial pipeline is invalid: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func encodeSerialPipeline(pipeline SerialPipeline) string {
+	if len(pipeline) == 0 {
+		return ""
+	}
+	content, _ := json.Marshal(pipeline)
+	return string(content)
+}
+
+func decodeSerialPipeline(content string) SerialPipeline {
+	if len(content) == 0 {
+		return nil
+	}
+	pipeline := SerialPipeline{}
+	if err := json.Unmarshal([]byte(content), &pipeline); err != nil {
+		log.Printf("Error decoding the serial pipeline: %v\n", err)
+	}
+	return pipeline
+}
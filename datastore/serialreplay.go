@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// DefaultSerialReplayWindow is the time that an exact replay of a serial-request returns the
+// serial assertion that was signed for it, for the models that allow the replays
+const DefaultSerialReplayWindow = 10 * time.Minute
+
+const createSerialReplayTableSQL = `
+	CREATE TABLE IF NOT EXISTS serialreplay (
+		request_hash   varchar(200) primary key not null,
+		api_key        varchar(200) not null,
+		serial         text not null,
+		timestamp      int not null
+	)
+`
+
+// Add the signing log entry of the serial assertion to the serial replays table
+const alterSerialReplaySigningLogSQL = "ALTER TABLE serialreplay ADD COLUMN signinglog text default ''"
+
+// Indexes
+const createSerialReplayTimeStampIndexSQL = "CREATE INDEX IF NOT EXISTS serialreplay_timestamp_idx ON serialreplay (timestamp)"
+
+// Queries
+const getSerialReplaySQL = "SELECT serial, signinglog FROM serialreplay WHERE request_hash=$1 AND api_key=$2 AND timestamp>=$3"
+const deleteSerialReplaySQL = "DELETE FROM serialreplay WHERE request_hash=$1"
+const createSerialReplaySQL = "INSERT INTO serialreplay (request_hash, api_key, serial, signinglog, timestamp) VALUES ($1,$2,$3,$4,$5)"
+const deleteExpiredSerialReplaySQL = "DELETE FROM serialreplay WHERE timestamp<$1"
+
+// SerialReplay is the serial assertion that was signed for a serial-request, with its signing log
+// entry, so a replay returns the signer and the serial response fields of the signing
+type SerialReplay struct {
+	Serial     string
+	SigningLog SigningLog
+}
+
+// SerialReplayWindow returns the time that an exact replay of a serial-request returns the serial
+// assertion that was signed for it, from the config. It is zero when the replay detection is disabled
+func SerialReplayWindow(settings config.Settings) time.Duration {
+	switch {
+	case settings.SerialReplayWindow < 0:
+		return 0
+	case settings.SerialReplayWindow > 0:
+		return time.Duration(settings.SerialReplayWindow) * time.Second
+	default:
+		return DefaultSerialReplayWindow
+	}
+}
+
+// CreateSerialReplayTable creates the database table for the serial assertions of the signed
+// serial-requests
+func (db *DB) CreateSerialReplayTable() error {
+	if _, err := db.Exec(createSerialReplayTableSQL); err != nil {
+		return err
+	}
+
+	// Ignore the error as the column may already exist
+	db.Exec(alterSerialReplaySigningLogSQL)
+
+	_, err := db.Exec(createSerialReplayTimeStampIndexSQL)
+	return err
+}
+
+// GetSerialReplay returns the serial assertion that was signed for the serial-request hash with the
+// model API key within the window. It returns an empty assertion when the serial-request was not signed
+func (db *DB) GetSerialReplay(requestHash, apiKey string, window time.Duration) (SerialReplay, error) {
+	var serial, signingLog string
+
+	timestamp := time.Now().Add(-window).Unix()
+	err := db.QueryRow(getSerialReplaySQL, requestHash, apiKey, timestamp).Scan(&serial, &signingLog)
+	if err == sql.ErrNoRows {
+		return SerialReplay{}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving the serial replay: %v\n", err)
+		return SerialReplay{}, errors.New("Error communicating with the database")
+	}
+
+	replay := SerialReplay{Serial: serial}
+	if len(signingLog) > 0 {
+		if err := json.Unmarshal([]byte(signingLog), &replay.SigningLog); err != nil {
+			log.Printf("Error decoding the signing log of the serial replay: %v\n", err)
+			return SerialReplay{}, errors.New("Error decoding the signing log of the serial replay")
+		}
+	}
+	return replay, nil
+}
+
+// CreateSerialReplay stores the serial assertion that was signed for the serial-request hash with
+// the model API key, replacing an earlier one
+func (db *DB) CreateSerialReplay(requestHash, apiKey string, replay SerialReplay) error {
+	signingLog, err := json.Marshal(replay.SigningLog)
+	if err != nil {
+		return err
+	}
+
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteSerialReplaySQL, requestHash); err != nil {
+			log.Printf("Error deleting the serial replay: %v\n", err)
+			return err
+		}
+		if _, err := tx.Exec(createSerialReplaySQL, requestHash, apiKey, replay.Serial, string(signingLog), time.Now().Unix()); err != nil {
+			log.Printf("Error storing the serial replay: %v\n", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// DeleteExpiredSerialReplays removes the serial assertions that were signed before the window,
+// returning the number that were removed
+func (db *DB) DeleteExpiredSerialReplays(window time.Duration) (int, error) {
+	timestamp := time.Now().Add(-window).Unix()
+	result, err := db.Exec(deleteExpiredSerialReplaySQL, timestamp)
+	if err != nil {
+		log.Printf("Error deleting expired serial replays: %v\n", err)
+		return 0, errors.New("Error communicating with the database")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Error checking expired serial replays delete row count: %v\n", err)
+		return 0, errors.New("Error communicating with the database")
+	}
+	return int(rows), nil
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"text/template"
+)
+
+// MaxSerialResponseFields is the maximum number of fields in the serial response of a model
+const MaxSerialResponseFields = 20
+
+// validSerialResponseName is the format of the name of a serial response field
+var validSerialResponseName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// SerialResponseField is an extra field of the JSON envelope of the serial method. The value
+// is a text/template that is rendered with the SerialResponseContext e.g.
+// https://mdm.example.com/enroll/{{.Model}}/{{.Serial}}
+type SerialResponseField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SerialResponse is the list of extra fields that are returned with the signed serial
+// assertions of a model, for the provisioning stacks that need metadata alongside the
+// assertion. By default, no fields are returned
+type SerialResponse []SerialResponseField
+
+// SerialResponseContext holds the model settings and the signing context that the serial
+// response fields are rendered with
+type SerialResponseContext struct {
+	BrandID     string
+	Model       string
+	Serial      string
+	Revision    int
+	DeviceKey   string // the sha3-384 of the device-key
+	Timestamp   string
+	Station     string
+	AuthorityID string // of the signing-key
+	KeyID       string // of the signing-key
+	Details     map[string]string
+}
+
+// Add the serial response to the models table
+const alterModelSerialResponseSQL = "ALTER TABLE model ADD COLUMN serial_response text default ''"
+
+// ParseSerialResponseField parses the template of a serial response field
+func ParseSerialResponseField(field SerialResponseField) (*template.Template, error) {
+	return template.New(field.Name).Option("missingkey=zero").Parse(field.Value)
+}
+
+// ValidateSerialResponse checks the fields of the serial response of a model
+func ValidateSerialResponse(fields SerialResponse) error {
+	if len(fields) > MaxSerialResponseFields {
+		return fmt.Errorf("The serial response must not have more than %d fields", MaxSerialResponseFields)
+	}
+	names := []string{}
+	for _, f := range fields {
+		if !validSerialResponseName.MatchString(f.Name) {
+			return fmt.Errorf("The serial response field name '%s' is invalid", f.Name)
+		}
+		if containsString(names, f.Name) {
+			return fmt.Errorf("The serial response field '%s' is duplicated", f.Name)
+		}
+		names = append(names, f.Name)
+
+		t, err := ParseSerialResponseField(f)
+		if err != nil {
+			return fmt.Errorf("The template of the serial response field '%s' is invalid: %v", f.Name, err)
+		}
+		// Catch the references to unknown context fields before a device is signed
+		if err = t.Execute(ioutil.Discard, SerialResponseContext{}); err != nil {
+			return fmt.Errorf("The template of the serial response field '%s' is invalid: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func encodeSerialResponse(fields SerialResponse) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	content, _ := json.Marshal(fields)
+	return string(content)
+}
+
+func decodeSerialResponse(content string) SerialResponse {
+	if len(content) == 0 {
+		return nil
+	}
+	fields := SerialResponse{}
+	if err := json.Unmarshal([]byte(content), &fields); err != nil {
+		log.Printf("Error decoding the serial response: %v\n", err)
+	}
+	return fields
+}
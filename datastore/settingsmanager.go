@@ -20,14 +20,21 @@
 package datastore
 
 import (
+	"database/sql"
 	"errors"
 	"log"
+	"strconv"
 )
 
+// SchemaVersion is the version of the database schema of this release, which is recorded by the
+// database schema update. It must be increased when a schema update is added
+const SchemaVersion = 1
+
 // Understood settings codes
 var (
 	SettingParentContext = "parent"
 	SettingKeyContext    = "key"
+	SettingSchemaVersion = "schema-version"
 )
 
 const createSettingsTableSQL = `
@@ -61,6 +68,7 @@ const maxIDSettingsSQLite = `
 `
 
 const getSettingSQL = "select id, code, data from settings where code=$1"
+const updateSettingSQL = "update settings set data=$2 where code=$1"
 
 // Setting holds the keypair reference details in the local database
 type Setting struct {
@@ -83,7 +91,7 @@ func (db *DB) PutSetting(setting Setting) error {
 		return errors.New("The code must be entered to store a Setting")
 	}
 
-	if InFactory() {
+	if usesSQLite() {
 		// We only add new settings for the factory, we don't ever update a setting
 		// Need to generate our own ID
 		var nextID int
@@ -118,3 +126,30 @@ func (db *DB) GetSetting(code string) (Setting, error) {
 
 	return setting, nil
 }
+
+// PutSchemaVersion records the version of the database schema. The factory only adds new settings,
+// so the version is updated in place before it is added
+func (db *DB) PutSchemaVersion(version int) error {
+	result, err := db.Exec(updateSettingSQL, SettingSchemaVersion, strconv.Itoa(version))
+	if err != nil {
+		log.Printf("Error updating the schema version: %v\n", err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		return nil
+	}
+	return db.PutSetting(Setting{Code: SettingSchemaVersion, Data: strconv.Itoa(version)})
+}
+
+// GetSchemaVersion returns the version of the database schema, which is 0 when the schema
+// update has not recorded it
+func (db *DB) GetSchemaVersion() (int, error) {
+	setting, err := db.GetSetting(SettingSchemaVersion)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(setting.Data)
+}
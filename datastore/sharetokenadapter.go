@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"errors"
+	"time"
+)
+
+// CreateAllowedShareToken creates a share token for the brand, if the user is authorized to do it.
+// The returned share token holds the token, which is not stored
+func (db *DB) CreateAllowedShareToken(token ShareToken, authorization User) (ShareToken, error) {
+	err := ValidateShareToken(token, time.Now().UTC())
+	if err != nil {
+		return ShareToken{}, err
+	}
+	token.CreatedBy = authorization.Username
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.createShareToken(token)
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, token.BrandID) {
+			return ShareToken{}, errors.New("You do not have permissions to this brand")
+		}
+		return db.createShareToken(token)
+	default:
+		return ShareToken{}, errors.New("You do not have permissions to this brand")
+	}
+}
+
+// ListAllowedShareTokens returns the share tokens of a brand that the user is authorized to see
+func (db *DB) ListAllowedShareTokens(authorization User, authorityID string) ([]ShareToken, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listShareTokens(authorityID)
+	case Admin:
+		return db.listShareTokensFilteredByUser(authorityID, authorization.Username)
+	default:
+		return []ShareToken{}, nil
+	}
+}
+
+// DeleteAllowedShareToken revokes a share token, if the user is authorized to do it
+func (db *DB) DeleteAllowedShareToken(tokenID int, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.deleteShareToken(tokenID)
+	case Admin:
+		return db.deleteShareTokenFilteredByUser(tokenID, authorization.Username)
+	default:
+		return nil
+	}
+}
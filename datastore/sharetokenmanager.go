@@ -0,0 +1,283 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Lifetime of the share tokens
+const (
+	DefaultShareTokenLifetime = 30 * 24 * time.Hour
+	MaxShareTokenLifetime     = 90 * 24 * time.Hour
+)
+
+const createShareTokenTableSQL = `
+	CREATE TABLE IF NOT EXISTS sharetoken (
+		id               serial primary key not null,
+		token_hash       varchar(200) not null unique,
+		brand_id         varchar(200) not null,
+		models           text default '[]',
+		description      varchar(200) default '',
+		created_by       varchar(200) default '',
+		created          timestamp default current_timestamp,
+		expires          timestamp not null
+	)
+`
+
+const createShareTokenSQL = `
+	INSERT INTO sharetoken (token_hash, brand_id, models, description, created_by, expires)
+	VALUES ($1,$2,$3,$4,$5,$6)
+	RETURNING id, created`
+
+const listShareTokenSQL = `
+	SELECT id, brand_id, models, description, created_by, created, expires
+	FROM sharetoken
+	WHERE brand_id=$1
+	ORDER BY id`
+
+const listShareTokenForUserSQL = `
+	SELECT t.id, t.brand_id, t.models, t.description, t.created_by, t.created, t.expires
+	FROM sharetoken t
+	INNER JOIN account acc ON acc.authority_id=t.brand_id
+	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
+	INNER JOIN userinfo u ON ua.user_id=u.id
+	WHERE t.brand_id=$1 AND u.username=$2
+	ORDER BY t.id`
+
+const getShareTokenSQL = `
+	SELECT id, brand_id, models, description, created_by, created, expires
+	FROM sharetoken
+	WHERE token_hash=$1`
+
+const deleteShareTokenSQL = "DELETE FROM sharetoken WHERE id=$1"
+const deleteShareTokenForUserSQL = `
+	DELETE FROM sharetoken t
+	USING account acc
+	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
+	INNER JOIN userinfo u ON ua.user_id=u.id
+	WHERE t.id=$1 AND acc.authority_id=t.brand_id AND u.username=$2`
+
+const findModelForBrandSQL = "SELECT EXISTS(SELECT * FROM model WHERE brand_id=$1 AND name=$2)"
+
+// ShareToken is a time-limited, read-only token that lets a partner of the brand e.g. a
+// distributor, check the devices that have been signed for the brand's models. Only the
+// hash of the token is stored, so the token is only returned when it is created
+type ShareToken struct {
+	ID          int       `json:"id"`
+	Token       string    `json:"token,omitempty"`
+	BrandID     string    `json:"brand-id"`
+	Models      []string  `json:"models"` // all the brand's models, when empty
+	Description string    `json:"description"`
+	CreatedBy   string    `json:"created-by"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+}
+
+// AllowsModel checks if the token shares the signing log of the model
+func (t ShareToken) AllowsModel(model string) bool {
+	return len(t.Models) == 0 || containsString(t.Models, model)
+}
+
+// Expired checks if the token has expired
+func (t ShareToken) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+// NewShareTokenSecret generates a random token and the hash that is stored for it
+func NewShareTokenSecret() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, ShareTokenHash(token), nil
+}
+
+// ShareTokenHash is the hash of the token that is stored in the database
+func ShareTokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// ValidateShareToken checks the brand, models and expiry of a new share token
+func ValidateShareToken(token ShareToken, now time.Time) error {
+	if err := validateAuthorityID(token.BrandID); err != nil {
+		return err
+	}
+	for _, m := range token.Models {
+		if len(m) == 0 {
+			return errors.New("The models must not be empty")
+		}
+	}
+	if token.Expired(now) {
+		return errors.New("The expiry of the share token must be in the future")
+	}
+	if token.Expires.After(now.Add(MaxShareTokenLifetime)) {
+		return fmt.Errorf("The share token must expire within %d days", int(MaxShareTokenLifetime.Hours()/24))
+	}
+	return nil
+}
+
+// CreateShareTokenTable creates the database table for the share tokens
+func (db *DB) CreateShareTokenTable() error {
+	_, err := db.Exec(createShareTokenTableSQL)
+	return err
+}
+
+// GetShareToken finds the share token, returning an error if it is unknown or it has expired
+func (db *DB) GetShareToken(token string) (ShareToken, error) {
+	if len(token) == 0 {
+		return ShareToken{}, errors.New("The share token must be provided")
+	}
+
+	t := ShareToken{}
+	var models string
+	err := db.QueryRow(getShareTokenSQL, ShareTokenHash(token)).Scan(&t.ID, &t.BrandID, &models, &t.Description, &t.CreatedBy, &t.Created, &t.Expires)
+	if err == sql.ErrNoRows {
+		return ShareToken{}, errors.New("Invalid share token")
+	}
+	if err != nil {
+		log.Printf("Error retrieving the share token: %v\n", err)
+		return ShareToken{}, errors.New("Error communicating with the database")
+	}
+	if t.Expired(time.Now().UTC()) {
+		return ShareToken{}, errors.New("The share token has expired")
+	}
+
+	t.Models = decodeShareTokenModels(models)
+	return t, nil
+}
+
+func (db *DB) createShareToken(token ShareToken) (ShareToken, error) {
+	for _, m := range token.Models {
+		var exists bool
+		err := db.QueryRow(findModelForBrandSQL, token.BrandID, m).Scan(&exists)
+		if err != nil {
+			log.Printf("Error checking the model of the share token: %v\n", err)
+			return ShareToken{}, errors.New("Error communicating with the database")
+		}
+		if !exists {
+			return ShareToken{}, fmt.Errorf("Cannot find model '%s' for the brand", m)
+		}
+	}
+
+	secret, hash, err := NewShareTokenSecret()
+	if err != nil {
+		log.Printf("Error generating the share token: %v\n", err)
+		return ShareToken{}, err
+	}
+
+	err = db.QueryRow(createShareTokenSQL, hash, token.BrandID, encodeShareTokenModels(token.Models), token.Description, token.CreatedBy, token.Expires).Scan(&token.ID, &token.Created)
+	if err != nil {
+		log.Printf("Error creating the share token: %v\n", err)
+		return ShareToken{}, err
+	}
+
+	token.Token = secret
+	return token, nil
+}
+
+func (db *DB) listShareTokens(authorityID string) ([]ShareToken, error) {
+	return db.listShareTokensFilteredByUser(authorityID, anyUserFilter)
+}
+
+func (db *DB) listShareTokensFilteredByUser(authorityID, username string) ([]ShareToken, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	if len(username) == 0 {
+		rows, err = db.Query(listShareTokenSQL, authorityID)
+	} else {
+		rows, err = db.Query(listShareTokenForUserSQL, authorityID, username)
+	}
+	if err != nil {
+		log.Printf("Error retrieving share tokens: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []ShareToken{}
+	for rows.Next() {
+		t := ShareToken{}
+		var models string
+		err := rows.Scan(&t.ID, &t.BrandID, &models, &t.Description, &t.CreatedBy, &t.Created, &t.Expires)
+		if err != nil {
+			return nil, err
+		}
+		t.Models = decodeShareTokenModels(models)
+		tokens = append(tokens, t)
+	}
+
+	return tokens, nil
+}
+
+func (db *DB) deleteShareToken(tokenID int) error {
+	return db.deleteShareTokenFilteredByUser(tokenID, anyUserFilter)
+}
+
+func (db *DB) deleteShareTokenFilteredByUser(tokenID int, username string) error {
+	var err error
+
+	if len(username) == 0 {
+		_, err = db.Exec(deleteShareTokenSQL, tokenID)
+	} else {
+		_, err = db.Exec(deleteShareTokenForUserSQL, tokenID, username)
+	}
+	if err != nil {
+		log.Printf("Error deleting the share token: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func encodeShareTokenModels(models []string) string {
+	if len(models) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(models)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
+
+func decodeShareTokenModels(models string) []string {
+	m := []string{}
+	if len(models) == 0 {
+		return m
+	}
+	if err := json.Unmarshal([]byte(models), &m); err != nil {
+		log.Printf("Error decoding the models of the share token: %v\n", err)
+	}
+	return m
+}
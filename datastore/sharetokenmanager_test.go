@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateShareToken(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		token ShareToken
+		valid bool
+	}{
+		{ShareToken{BrandID: "system", Expires: now.Add(DefaultShareTokenLifetime)}, true},
+		{ShareToken{BrandID: "system", Models: []string{"alder", "ash"}, Expires: now.Add(MaxShareTokenLifetime)}, true},
+		{ShareToken{Expires: now.Add(DefaultShareTokenLifetime)}, false},
+		{ShareToken{BrandID: "system", Models: []string{""}, Expires: now.Add(DefaultShareTokenLifetime)}, false},
+		{ShareToken{BrandID: "system"}, false},
+		{ShareToken{BrandID: "system", Expires: now.Add(-time.Hour)}, false},
+		{ShareToken{BrandID: "system", Expires: now.Add(MaxShareTokenLifetime + time.Hour)}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateShareToken(tt.token, now)
+		if tt.valid && err != nil {
+			t.Errorf("Expected %v to be valid, got: %v", tt.token, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected %v to be invalid", tt.token)
+		}
+	}
+}
+
+func TestShareTokenModels(t *testing.T) {
+	all := ShareToken{BrandID: "system"}
+	if !all.AllowsModel("alder") {
+		t.Error("Expected a token without models to allow all the models")
+	}
+
+	scoped := ShareToken{BrandID: "system", Models: decodeShareTokenModels(encodeShareTokenModels([]string{"alder"}))}
+	if !scoped.AllowsModel("alder") || scoped.AllowsModel("ash") {
+		t.Errorf("Expected the token to only allow alder, got: %v", scoped.Models)
+	}
+}
+
+func TestNewShareTokenSecret(t *testing.T) {
+	token, hash, err := NewShareTokenSecret()
+	if err != nil {
+		t.Fatalf("Error generating the share token: %v", err)
+	}
+	if len(token) < 40 {
+		t.Errorf("Expected a long random token, got: %s", token)
+	}
+	if hash != ShareTokenHash(token) || hash == token {
+		t.Errorf("Expected the hash of the token, got: %s", hash)
+	}
+
+	other, _, _ := NewShareTokenSecret()
+	if other == token {
+		t.Error("Expected a different token")
+	}
+}
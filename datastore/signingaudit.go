@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"fmt"
+	"os"
+)
+
+// SigningAudit identifies what performed the signing of an assertion, for forensic
+// traceability across vault upgrades
+type SigningAudit struct {
+	KeypairID int    `json:"keypair-id"`
+	KeyID     string `json:"key-id"` // the fingerprint of the signing-key
+	Backend   string `json:"backend"`
+	Instance  string `json:"instance"`
+	Version   string `json:"version"`
+	Canary    bool   `json:"canary,omitempty"` // signed with the canary signing-key of the model
+
+	// Import is the provenance of an entry that was imported from another signing system
+	Import *SigningImport `json:"import,omitempty"`
+
+	// Replica is the provenance of an entry that was replicated from a peer vault
+	Replica *SigningReplica `json:"replica,omitempty"`
+}
+
+// NewSigningAudit records that the keypair was used to sign by the vault instance of the environment
+func (env *Env) NewSigningAudit(keypair Keypair) *SigningAudit {
+	audit := SigningAudit{
+		KeypairID: keypair.ID,
+		KeyID:     keypair.KeyID,
+		Instance:  env.InstanceID(),
+		Version:   env.Config.Version,
+	}
+	if env.KeypairDB != nil {
+		audit.Backend = env.KeypairDB.KeyStoreType.Name
+	}
+	return &audit
+}
+
+// InstanceID identifies the vault instance of the environment, using the hostname when it is not configured
+func (env *Env) InstanceID() string {
+	if len(env.Config.InstanceID) > 0 {
+		return env.Config.InstanceID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// String formats the audit for the response header of a signed assertion
+func (audit SigningAudit) String() string {
+	s := fmt.Sprintf("keypair-id=%d; key-id=%s; backend=%s; instance=%s; version=%s", audit.KeypairID, audit.KeyID, audit.Backend, audit.Instance, audit.Version)
+	if audit.Canary {
+		s += "; canary=true"
+	}
+	return s
+}
@@ -0,0 +1,260 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The signing authorizations are synced from the cloud to the factory instance
+const createSigningAuthorizationTableSQL = `
+	CREATE TABLE IF NOT EXISTS signingauthorization (
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null,
+		valid_from       timestamp null,
+		valid_until      timestamp null,
+		max_units        int default 0,
+		primary key (brand_id, model)
+	)
+`
+
+// The single row of the scope records if the sync user of the factory is model-scoped, so the
+// factory only signs for the models with an authorization. A factory that has not synced the
+// authorizations has no scope
+const createSigningAuthorizationScopeTableSQL = `
+	CREATE TABLE IF NOT EXISTS signingauthorizationscope (
+		id               int primary key not null,
+		scoped           int default 0
+	)
+`
+
+// The factories that synced authorizations before the scope was stored are model-scoped
+const migrateSigningAuthorizationScopeSQL = `
+	INSERT INTO signingauthorizationscope (id, scoped)
+	SELECT 1, 1 WHERE EXISTS(SELECT * FROM signingauthorization) AND NOT EXISTS(SELECT * FROM signingauthorizationscope)`
+
+const deleteSigningAuthorizationsSQL = "DELETE FROM signingauthorization"
+const createSigningAuthorizationSQL = "INSERT INTO signingauthorization (brand_id, model, valid_from, valid_until, max_units) VALUES ($1,$2,$3,$4,$5)"
+const deleteSigningAuthorizationScopeSQL = "DELETE FROM signingauthorizationscope"
+const createSigningAuthorizationScopeSQL = "INSERT INTO signingauthorizationscope (id, scoped) VALUES (1, $1)"
+const getSigningAuthorizationScopeSQL = "SELECT scoped FROM signingauthorizationscope WHERE id=1"
+const getSigningAuthorizationSQL = "SELECT brand_id, model, valid_from, valid_until, max_units FROM signingauthorization WHERE brand_id=$1 AND model=$2"
+const countSignedUnitsSQL = "SELECT COUNT(*) FROM signinglog WHERE make=$1 AND model=$2 AND revision=1 AND created >= $3"
+
+// Revoke the signing-keys that are not used by a model with an open authorization window
+const revokeUnauthorizedKeypairsSQL = `
+	UPDATE keypair SET active=$1, sealed_key=''
+	WHERE id NOT IN (
+		SELECT m.keypair_id FROM model m
+		INNER JOIN signingauthorization a ON a.brand_id=m.brand_id AND a.model=m.name
+		WHERE (a.valid_from IS NULL OR a.valid_from <= $2) AND (a.valid_until IS NULL OR a.valid_until > $2)
+		UNION
+		SELECT m.user_keypair_id FROM model m
+		INNER JOIN signingauthorization a ON a.brand_id=m.brand_id AND a.model=m.name
+		WHERE (a.valid_from IS NULL OR a.valid_from <= $2) AND (a.valid_until IS NULL OR a.valid_until > $2)
+		UNION
+		SELECT ma.keypair_id FROM modelassertion ma
+		INNER JOIN model m ON m.id=ma.model_id
+		INNER JOIN signingauthorization a ON a.brand_id=m.brand_id AND a.model=m.name
+		WHERE (a.valid_from IS NULL OR a.valid_from <= $2) AND (a.valid_until IS NULL OR a.valid_until > $2)
+	)`
+
+// sqliteTimestampFormat is the format that sqlite uses for the current_timestamp
+const sqliteTimestampFormat = "2006-01-02 15:04:05"
+
+// ErrMaxUnitsReached is the error when the factory has signed the maximum number of units that
+// its authorization allows for a model
+type ErrMaxUnitsReached struct {
+	MaxUnits int
+}
+
+func (e ErrMaxUnitsReached) Error() string {
+	return fmt.Sprintf("The maximum of %d units authorized for this model has been reached", e.MaxUnits)
+}
+
+// authorizationQuerier is satisfied by both the database and a transaction
+type authorizationQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateSigningAuthorizationTable creates the database tables for the factory's signing authorizations
+func (db *DB) CreateSigningAuthorizationTable() error {
+	if _, err := db.Exec(createSigningAuthorizationTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createSigningAuthorizationScopeTableSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(migrateSigningAuthorizationScopeSQL)
+	return err
+}
+
+// SyncSigningAuthorizations replaces the factory's signing authorizations with the models
+// assigned to the sync user in the cloud. When the sync user is model-scoped, the factory
+// only signs for the assigned models, so it signs for none without assignments, and the
+// signing-keys of the models that are outside their authorization window are revoked
+func (db *DB) SyncSigningAuthorizations(scoped bool, assignments []SyncModelAssignment) error {
+	return db.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(deleteSigningAuthorizationsSQL)
+		if err != nil {
+			log.Printf("Error removing the signing authorizations: %v\n", err)
+			return err
+		}
+		if _, err = tx.Exec(deleteSigningAuthorizationScopeSQL); err != nil {
+			log.Printf("Error removing the signing authorization scope: %v\n", err)
+			return err
+		}
+
+		scope := 0
+		if scoped {
+			scope = 1
+		}
+		if _, err = tx.Exec(createSigningAuthorizationScopeSQL, scope); err != nil {
+			log.Printf("Error storing the signing authorization scope: %v\n", err)
+			return err
+		}
+
+		for _, a := range assignments {
+			_, err = tx.Exec(createSigningAuthorizationSQL, a.BrandID, a.Name, sqliteTimestamp(a.ValidFrom), sqliteTimestamp(a.ValidUntil), a.MaxUnits)
+			if err != nil {
+				log.Printf("Error storing the signing authorization: %v\n", err)
+				return err
+			}
+		}
+
+		// A sync user that is not model-scoped can sign for all its models
+		if !scoped {
+			return nil
+		}
+
+		now := time.Now().UTC().Format(sqliteTimestampFormat)
+		_, err = tx.Exec(revokeUnauthorizedKeypairsSQL, false, now)
+		if err != nil {
+			log.Printf("Error revoking the signing-keys: %v\n", err)
+		}
+		return err
+	})
+}
+
+// CheckSigningAuthorization verifies that the factory is authorized to sign a serial
+// assertion for the model: the authorization window must be open and the maximum
+// number of units must not have been reached. The check only applies to a factory
+// instance whose sync user is model-scoped
+func (db *DB) CheckSigningAuthorization(brandID, modelName string) error {
+	if !InFactory() {
+		return nil
+	}
+
+	a, err := signingAuthorization(db, brandID, modelName)
+	if err != nil || a == nil {
+		return err
+	}
+
+	if !a.Open(time.Now()) {
+		return errors.New("The signing authorization for this model has expired or is not yet valid")
+	}
+	return checkSignedUnits(db, *a)
+}
+
+// signingAuthorization returns the signing authorization of the model, or nil when the
+// factory is not model-scoped
+func signingAuthorization(q authorizationQuerier, brandID, modelName string) (*SyncModelAssignment, error) {
+	var scoped int
+	err := q.QueryRow(getSigningAuthorizationScopeSQL).Scan(&scoped)
+	if err == sql.ErrNoRows || (err == nil && scoped == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving the signing authorization scope: %v\n", err)
+		return nil, errors.New("Error communicating with the database")
+	}
+
+	var (
+		a                     SyncModelAssignment
+		validFrom, validUntil sql.NullString
+	)
+	err = q.QueryRow(getSigningAuthorizationSQL, brandID, modelName).Scan(&a.BrandID, &a.Name, &validFrom, &validUntil, &a.MaxUnits)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("The factory is not authorized to sign for this model")
+	}
+	if err != nil {
+		log.Printf("Error retrieving the signing authorization: %v\n", err)
+		return nil, errors.New("Error communicating with the database")
+	}
+	if a.ValidFrom, err = parseSqliteTimestamp(validFrom); err != nil {
+		return nil, err
+	}
+	if a.ValidUntil, err = parseSqliteTimestamp(validUntil); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// checkSignedUnits verifies that the devices signed since the start of the authorization window
+// have not reached its maximum number of units
+func checkSignedUnits(q authorizationQuerier, a SyncModelAssignment) error {
+	if a.MaxUnits == 0 {
+		return nil
+	}
+
+	// Count the devices signed since the start of the window
+	from := time.Time{}.Format(sqliteTimestampFormat)
+	if a.ValidFrom != nil {
+		from = a.ValidFrom.UTC().Format(sqliteTimestampFormat)
+	}
+	var units int
+	err := q.QueryRow(countSignedUnitsSQL, a.BrandID, a.Name, from).Scan(&units)
+	if err != nil {
+		log.Printf("Error counting the signed units: %v\n", err)
+		return errors.New("Error communicating with the database")
+	}
+	if units >= a.MaxUnits {
+		return ErrMaxUnitsReached{MaxUnits: a.MaxUnits}
+	}
+	return nil
+}
+
+// sqliteTimestamp formats a time in the same (UTC) format as the sqlite current_timestamp,
+// so the values can be compared
+func sqliteTimestamp(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(sqliteTimestampFormat)
+}
+
+func parseSqliteTimestamp(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(sqliteTimestampFormat, value.String)
+	if err != nil {
+		// The driver converts timestamp columns, so they are returned in RFC3339 format
+		if t, err = time.Parse(time.RFC3339Nano, value.String); err != nil {
+			log.Printf("Error parsing the signing authorization window: %v\n", err)
+			return nil, errors.New("Invalid signing authorization window")
+		}
+	}
+	return &t, nil
+}
@@ -0,0 +1,123 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestInstanceRole(t *testing.T) {
+	tests := []struct {
+		settings  config.Settings
+		role      string
+		inFactory bool
+		sqlite    bool
+	}{
+		{config.Settings{Driver: "sqlite3"}, InstanceRoleFactory, true, true},
+		{config.Settings{Driver: "postgres"}, InstanceRoleCloud, false, false},
+		{config.Settings{Driver: "postgres", InstanceRole: InstanceRoleFactory}, InstanceRoleFactory, true, false},
+		{config.Settings{Driver: "postgres", Failover: true}, InstanceRoleFactory, true, false},
+		{config.Settings{Driver: "postgres", InstanceRole: InstanceRoleProxy}, InstanceRoleProxy, false, false},
+	}
+
+	for _, tt := range tests {
+		env := &Env{Config: tt.settings}
+		if env.InstanceRole() != tt.role {
+			t.Errorf("Expected the role %s, got: %s", tt.role, env.InstanceRole())
+		}
+		if env.InFactory() != tt.inFactory {
+			t.Errorf("%s: expected in factory %v, got: %v", tt.role, tt.inFactory, env.InFactory())
+		}
+		if env.UsesSQLite() != tt.sqlite {
+			t.Errorf("%s: expected sqlite %v, got: %v", tt.role, tt.sqlite, env.UsesSQLite())
+		}
+	}
+}
+
+func TestCheckSigningAuthorizationPostgresFactory(t *testing.T) {
+	env := Environ
+	defer func() { Environ = env }()
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening the database: %v", err)
+	}
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	// The statements do not depend on the dialect, so they run on sqlite for a factory that is
+	// configured with a Postgres database
+	db := &DB{DB: sqlDB}
+	for _, s := range []string{createSigningAuthorizationTableSQL, createSigningAuthorizationScopeTableSQL, createSigningLogTableSQL} {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("Error preparing the database: %v", err)
+		}
+	}
+
+	// Without a scope, the factory signs for all models
+	Environ = &Env{Config: config.Settings{Driver: "postgres", InstanceRole: InstanceRoleFactory}}
+	if err := db.CheckSigningAuthorization("system", "birch"); err != nil {
+		t.Errorf("Expected no error without a scope, got: %v", err)
+	}
+	if _, err := db.Exec(createSigningAuthorizationScopeSQL, 1); err != nil {
+		t.Fatalf("Error storing the signing authorization scope: %v", err)
+	}
+	if err := db.CheckSigningAuthorization("system", "birch"); err == nil || err.Error() != "The factory is not authorized to sign for this model" {
+		t.Errorf("Expected a scoped factory without authorizations to refuse the model, got: %v", err)
+	}
+
+	until := time.Now().UTC().Add(-time.Hour)
+	if _, err := db.Exec(createSigningAuthorizationSQL, "system", "alder", nil, sqliteTimestamp(&until), 0); err != nil {
+		t.Fatalf("Error storing the signing authorization: %v", err)
+	}
+	if _, err := db.Exec(createSigningAuthorizationSQL, "system", "ash", nil, nil, 1); err != nil {
+		t.Fatalf("Error storing the signing authorization: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO signinglog (id, make, model, serial_number, fingerprint) VALUES (1, 'system', 'ash', 'A1', 'fp')"); err != nil {
+		t.Fatalf("Error storing the signing log: %v", err)
+	}
+
+	tests := []struct {
+		settings config.Settings
+		model    string
+		err      string
+	}{
+		{config.Settings{Driver: "postgres", InstanceRole: InstanceRoleFactory}, "alder", "The signing authorization for this model has expired or is not yet valid"},
+		{config.Settings{Driver: "postgres", InstanceRole: InstanceRoleFactory}, "ash", ErrMaxUnitsReached{MaxUnits: 1}.Error()},
+		{config.Settings{Driver: "postgres", InstanceRole: InstanceRoleFactory}, "birch", "The factory is not authorized to sign for this model"},
+		{config.Settings{Driver: "postgres", Failover: true}, "alder", "The signing authorization for this model has expired or is not yet valid"},
+		{config.Settings{Driver: "postgres"}, "alder", ""},
+	}
+
+	for _, tt := range tests {
+		Environ = &Env{Config: tt.settings}
+		err := db.CheckSigningAuthorization("system", tt.model)
+		if len(tt.err) == 0 && err != nil {
+			t.Errorf("%s: unexpected error for a cloud instance: %v", tt.model, err)
+		}
+		if len(tt.err) > 0 && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: expected error `%s`, got: %v", tt.model, tt.err, err)
+		}
+	}
+}
@@ -19,6 +19,8 @@
 
 package datastore
 
+import "errors"
+
 // ListAllowedSigningLog return signing logs the user is authorized to see
 func (db *DB) ListAllowedSigningLog(authorization User) ([]SigningLog, error) {
 	switch authorization.Role {
@@ -51,6 +53,23 @@ func (db *DB) ListAllowedSigningLogForAccount(authorization User, authorityID st
 	}
 }
 
+// SearchAllowedSigningLogForAccount returns the signing logs the user is authorized to see,
+// with a matching value for a field of the serial-request details
+func (db *DB) SearchAllowedSigningLogForAccount(authorization User, authorityID, field, value string) ([]SigningLog, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.searchAllSigningLogForAccount(authorityID, field, value)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		return db.searchSigningLogForAccountFilteredByUser(authorization.Username, authorityID, field, value)
+	default:
+		return []SigningLog{}, nil
+	}
+}
+
 // AllowedSigningLogFilterValues return signing log filters authorized for the user
 func (db *DB) AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error) {
 	switch authorization.Role {
@@ -64,3 +83,32 @@ func (db *DB) AllowedSigningLogFilterValues(authorization User, authorityID stri
 		return SigningLogFilters{}, nil
 	}
 }
+
+// CreateAllowedSigningLogAnnotation attaches an annotation to a signing log entry, if the user is
+// authorized to do it
+func (db *DB) CreateAllowedSigningLogAnnotation(annotation SigningLogAnnotation, authorization User) (SigningLogAnnotation, error) {
+	err := ValidateSigningLogAnnotation(annotation)
+	if err != nil {
+		return SigningLogAnnotation{}, err
+	}
+	annotation.CreatedBy = authorization.Username
+
+	make, err := db.signingLogMake(annotation.SigningLogID)
+	if err != nil {
+		return SigningLogAnnotation{}, err
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.createSigningLogAnnotation(annotation)
+	case Admin:
+		if !db.CheckUserInAccount(authorization.Username, make) {
+			return SigningLogAnnotation{}, errors.New("You do not have permissions to this brand")
+		}
+		return db.createSigningLogAnnotation(annotation)
+	default:
+		return SigningLogAnnotation{}, errors.New("You do not have permissions to this brand")
+	}
+}
@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// MaxAnnotationLength limits the length of the note of an annotation
+const MaxAnnotationLength = 500
+
+const createSigningLogAnnotationTableSQL = `
+	CREATE TABLE IF NOT EXISTS signinglogannotation (
+		id               serial primary key not null,
+		signinglog_id    int not null,
+		note             varchar(500) not null,
+		created_by       varchar(200) default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const createSigningLogAnnotationIndexSQL = "CREATE INDEX IF NOT EXISTS annotation_signinglog_idx ON signinglogannotation (signinglog_id)"
+
+const createSigningLogAnnotationSQL = `
+	INSERT INTO signinglogannotation (signinglog_id, note, created_by)
+	VALUES ($1,$2,$3)
+	RETURNING id, created`
+
+const getSigningLogMakeSQL = "SELECT make FROM signinglog WHERE id=$1"
+
+const listSigningLogAnnotationSQL = "SELECT id, signinglog_id, note, created_by, created FROM signinglogannotation ORDER BY id"
+
+const listSigningLogAnnotationForAccountSQL = `
+	SELECT a.id, a.signinglog_id, a.note, a.created_by, a.created
+	FROM signinglogannotation a
+	INNER JOIN signinglog s ON s.id=a.signinglog_id
+	WHERE s.make=$1
+	ORDER BY a.id`
+
+// SigningLogAnnotation is a note that an admin attached to a signing log entry e.g. "RMA replacement".
+// The annotations are only added, so they are the audit of who annotated the entry and when. They
+// are not part of the hash chain of the signing log
+type SigningLogAnnotation struct {
+	ID           int       `json:"id"`
+	SigningLogID int       `json:"signinglog-id"`
+	Note         string    `json:"note"`
+	CreatedBy    string    `json:"created-by"`
+	Created      time.Time `json:"created"`
+}
+
+// ValidateSigningLogAnnotation checks the note of a new annotation
+func ValidateSigningLogAnnotation(annotation SigningLogAnnotation) error {
+	if len(strings.TrimSpace(annotation.Note)) == 0 {
+		return errors.New("The note of the annotation must be provided")
+	}
+	if len(annotation.Note) > MaxAnnotationLength {
+		return fmt.Errorf("The note of the annotation must not be longer than %d characters", MaxAnnotationLength)
+	}
+	return nil
+}
+
+// CreateSigningLogAnnotationTable creates the database table for the annotations of the signing log
+func (db *DB) CreateSigningLogAnnotationTable() error {
+	_, err := db.Exec(createSigningLogAnnotationTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createSigningLogAnnotationIndexSQL)
+	return err
+}
+
+// signingLogMake finds the brand of a signing log entry
+func (db *DB) signingLogMake(signingLogID int) (string, error) {
+	var make string
+	err := db.QueryRow(getSigningLogMakeSQL, signingLogID).Scan(&make)
+	if err == sql.ErrNoRows {
+		return "", errors.New("Cannot find the signing log entry")
+	}
+	if err != nil {
+		log.Printf("Error retrieving the signing log entry: %v\n", err)
+		return "", errors.New("Error communicating with the database")
+	}
+	return make, nil
+}
+
+func (db *DB) createSigningLogAnnotation(annotation SigningLogAnnotation) (SigningLogAnnotation, error) {
+	err := db.QueryRow(createSigningLogAnnotationSQL, annotation.SigningLogID, annotation.Note, annotation.CreatedBy).Scan(&annotation.ID, &annotation.Created)
+	if err != nil {
+		log.Printf("Error creating the signing log annotation: %v\n", err)
+		return SigningLogAnnotation{}, err
+	}
+	return annotation, nil
+}
+
+// annotateSigningLogs attaches the annotations to the signing log entries. The annotations of
+// all the brands are fetched when the authority ID is empty
+func (db *DB) annotateSigningLogs(signingLogs []SigningLog, authorityID string) ([]SigningLog, error) {
+	if len(signingLogs) == 0 {
+		return signingLogs, nil
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	if len(authorityID) == 0 {
+		rows, err = db.Query(listSigningLogAnnotationSQL)
+	} else {
+		rows, err = db.Query(listSigningLogAnnotationForAccountSQL, authorityID)
+	}
+	if err != nil {
+		log.Printf("Error retrieving signing log annotations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := map[int][]SigningLogAnnotation{}
+	for rows.Next() {
+		a := SigningLogAnnotation{}
+		err := rows.Scan(&a.ID, &a.SigningLogID, &a.Note, &a.CreatedBy, &a.Created)
+		if err != nil {
+			return nil, err
+		}
+		annotations[a.SigningLogID] = append(annotations[a.SigningLogID], a)
+	}
+
+	for i := range signingLogs {
+		signingLogs[i].Annotations = annotations[signingLogs[i].ID]
+	}
+	return signingLogs, nil
+}
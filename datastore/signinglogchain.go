@@ -0,0 +1,394 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signingLogCheckpointInterval is the number of signing logs between automatic checkpoints
+const signingLogCheckpointInterval = 1000
+
+const createSigningLogCheckpointTableSQL = `
+	CREATE TABLE IF NOT EXISTS signinglogcheckpoint (
+		id             serial primary key not null,
+		log_id         int not null,
+		hash           varchar(200) not null,
+		signature      varchar(200) not null,
+		created        timestamp default current_timestamp
+	)
+`
+
+// The chain head holds the most recent entry of the signing log, in a single row that is locked
+// by each insert, so the entries are chained one after the other without locking the signing log
+const createSigningLogChainHeadTableSQL = `
+	CREATE TABLE IF NOT EXISTS signinglogchainhead (
+		id             int primary key not null,
+		log_id         int not null default 0,
+		hash           varchar(200) not null default ''
+	)
+`
+
+// Start the chain head at the most recent entry of an existing signing log
+const initSigningLogChainHeadSQL = `
+	INSERT INTO signinglogchainhead (id, log_id, hash)
+	SELECT 1, (SELECT COALESCE(MAX(id), 0) FROM signinglog), COALESCE((SELECT hash FROM signinglog ORDER BY id DESC LIMIT 1), '')
+	WHERE NOT EXISTS (SELECT * FROM signinglogchainhead)`
+
+// Queries
+const lockSigningLogChainHeadSQL = "SELECT log_id, hash FROM signinglogchainhead WHERE id=1 FOR UPDATE"
+const getSigningLogChainHeadSQL = "SELECT log_id, hash FROM signinglogchainhead WHERE id=1"
+const updateSigningLogChainHeadSQL = "UPDATE signinglogchainhead SET log_id=$1, hash=$2 WHERE id=1"
+const countSigningLogSinceCheckpointSQL = "SELECT COUNT(*) FROM signinglog WHERE id > (SELECT COALESCE(MAX(log_id), 0) FROM signinglogcheckpoint)"
+const listSigningLogChainSQL = "SELECT id, make, model, serial_number, fingerprint, created, revision, station, hash, details, fallback_key, signer, origin FROM signinglog ORDER BY id"
+const maxIDSigningLogCheckpointSQLite = "SELECT COUNT(*)+1 from signinglogcheckpoint"
+const createSigningLogCheckpointSQLite = "INSERT INTO signinglogcheckpoint (id, log_id, hash, signature) VALUES ($1, $2, $3, $4)"
+const createSigningLogCheckpointSQL = "INSERT INTO signinglogcheckpoint (log_id, hash, signature) VALUES ($1, $2, $3)"
+const updateSigningLogCheckpointSignatureSQL = "UPDATE signinglogcheckpoint SET signature=$2 WHERE id=$1"
+const listSigningLogCheckpointSQL = "SELECT id, log_id, hash, signature, created FROM signinglogcheckpoint ORDER BY id"
+
+// SigningLogCheckpoint anchors the signing log chain: it records the hash of a signing log
+// entry, signed with a key derived from the keystore secret
+type SigningLogCheckpoint struct {
+	ID        int       `json:"id"`
+	LogID     int       `json:"logID"`
+	Hash      string    `json:"hash"`
+	Signature string    `json:"signature"`
+	Created   time.Time `json:"created"`
+}
+
+// SigningLogVerification holds the result of verifying the signing log chain
+type SigningLogVerification struct {
+	Rows        int      `json:"rows"`
+	Checkpoints int      `json:"checkpoints"`
+	Errors      []string `json:"errors"`
+}
+
+// signingLogHashPrefix marks the hashes that cover every stored column of the signing log
+const signingLogHashPrefix = "v2:"
+
+// CreateSigningLogCheckpointTable creates the database tables for the signing log checkpoints
+// and the chain head
+func (db *DB) CreateSigningLogCheckpointTable() error {
+	_, err := db.Exec(createSigningLogCheckpointTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createSigningLogChainHeadTableSQL)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(initSigningLogChainHeadSQL)
+	return err
+}
+
+// signingLogCreated normalizes the signing time to the precision that the database stores, so
+// the hash of an entry can be computed before it is inserted
+func signingLogCreated(created time.Time) time.Time {
+	return created.UTC().Truncate(time.Microsecond)
+}
+
+// signingLogHash chains the hash of the previous signing log with the content of this one. Every
+// stored column is hashed, except for the ID, which is covered by the order of the chain, and the
+// synced flag, which is updated after the entry is created
+func signingLogHash(previousHash string, signLog SigningLog) string {
+	fields := []string{
+		previousHash, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint,
+		strconv.Itoa(signLog.Revision), signLog.Station,
+		signingLogCreated(signLog.Created).Format(time.RFC3339Nano),
+		encodeSigningLogDetails(signLog.Details), signLog.FallbackKeyID,
+		encodeSigningLogSigner(signLog.Signer), encodeSigningLogOrigin(signLog.Origin),
+	}
+	content, _ := json.Marshal(fields)
+
+	h := sha256.Sum256(content)
+	return signingLogHashPrefix + hex.EncodeToString(h[:])
+}
+
+// signingLogLegacyHash is the hash of the entries that were chained before the hash covered
+// every column. It is only used to verify those entries
+func signingLogLegacyHash(previousHash string, signLog SigningLog) string {
+	fields := []string{
+		previousHash, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint,
+		strconv.Itoa(signLog.Revision), signLog.Station,
+	}
+	// The details are only chained when present, so existing hashes remain valid
+	if details := encodeSigningLogDetails(signLog.Details); len(details) > 0 {
+		fields = append(fields, details)
+	}
+	// The fallback signing-key is only chained when it was used
+	if len(signLog.FallbackKeyID) > 0 {
+		fields = append(fields, "fallback-key:"+signLog.FallbackKeyID)
+	}
+	// The signer is only chained when it was recorded, as older entries do not have it
+	if signer := encodeSigningLogSigner(signLog.Signer); len(signer) > 0 {
+		fields = append(fields, "signer:"+signer)
+	}
+	// The origin is only chained when it was recorded
+	if origin := encodeSigningLogOrigin(signLog.Origin); len(origin) > 0 {
+		fields = append(fields, "origin:"+origin)
+	}
+	content, _ := json.Marshal(fields)
+
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+// signingLogCheckpointSignature signs the hash of the anchored signing log
+func signingLogCheckpointSignature(secret string, logID int, hash string) string {
+	mac := hmac.New(sha256.New, []byte("signinglog-checkpoint:"+secret))
+	fmt.Fprintf(mac, "%d:%s", logID, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lastSigningLogHash locks the chain head and fetches the most recent entry, so that concurrent
+// signing requests are chained one after the other. Only the chain head is locked, so the
+// signing log can still be read and updated while the transaction is open
+func lastSigningLogHash(tx *sql.Tx) (int, string, error) {
+	var (
+		logID int
+		hash  string
+	)
+
+	// sqlite does not support row locks, and serializes the write transactions anyway
+	query := lockSigningLogChainHeadSQL
+	if usesSQLite() {
+		query = getSigningLogChainHeadSQL
+	}
+
+	err := tx.QueryRow(query).Scan(&logID, &hash)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return logID, hash, err
+}
+
+// advanceSigningLogChainHead moves the chain head to the entry that has been inserted
+func advanceSigningLogChainHead(tx *sql.Tx, logID int, hash string) error {
+	_, err := tx.Exec(updateSigningLogChainHeadSQL, logID, hash)
+	return err
+}
+
+// autoCheckpointSigningLog creates a checkpoint when enough signing logs have been added since the last one
+func (db *DB) autoCheckpointSigningLog(tx *sql.Tx) error {
+	var count int
+	err := tx.QueryRow(countSigningLogSinceCheckpointSQL).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count < signingLogCheckpointInterval {
+		return nil
+	}
+
+	_, err = db.createSigningLogCheckpoint(tx)
+	return err
+}
+
+func (db *DB) createSigningLogCheckpoint(tx *sql.Tx) (SigningLogCheckpoint, error) {
+	logID, hash, err := lastSigningLogHash(tx)
+	if err != nil {
+		return SigningLogCheckpoint{}, err
+	}
+
+	checkpoint := SigningLogCheckpoint{
+		LogID:     logID,
+		Hash:      hash,
+		Signature: signingLogCheckpointSignature(Environ.Config.KeyStoreSecret, logID, hash),
+		Created:   time.Now().UTC(),
+	}
+
+	if usesSQLite() {
+		// Need to generate our own ID
+		err = tx.QueryRow(maxIDSigningLogCheckpointSQLite).Scan(&checkpoint.ID)
+		if err != nil {
+			return checkpoint, err
+		}
+		_, err = tx.Exec(createSigningLogCheckpointSQLite, checkpoint.ID, checkpoint.LogID, checkpoint.Hash, checkpoint.Signature)
+	} else {
+		_, err = tx.Exec(createSigningLogCheckpointSQL, checkpoint.LogID, checkpoint.Hash, checkpoint.Signature)
+	}
+	return checkpoint, err
+}
+
+// CreateSigningLogCheckpoint anchors the most recent signing log entry with a signed checkpoint
+func (db *DB) CreateSigningLogCheckpoint() (SigningLogCheckpoint, error) {
+	var checkpoint SigningLogCheckpoint
+
+	err := db.transaction(func(tx *sql.Tx) error {
+		var err error
+		checkpoint, err = db.createSigningLogCheckpoint(tx)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error creating the signing log checkpoint: %v\n", err)
+		return checkpoint, err
+	}
+
+	return checkpoint, nil
+}
+
+// VerifySigningLog walks through the signing log chain, detecting modified or deleted entries
+func (db *DB) VerifySigningLog() (SigningLogVerification, error) {
+	checkpoints, err := listSigningLogCheckpoints(db)
+	if err != nil {
+		log.Printf("Error retrieving signing log checkpoints: %v\n", err)
+		return SigningLogVerification{}, err
+	}
+
+	verifier := newSigningLogVerifier(Environ.Config.KeyStoreSecret, checkpoints)
+
+	rows, err := db.Query(listSigningLogChainSQL)
+	if err != nil {
+		log.Printf("Error retrieving signing logs: %v\n", err)
+		return SigningLogVerification{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		signLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signLog.ID, &signLog.Make, &signLog.Model, &signLog.SerialNumber, &signLog.Fingerprint, &signLog.Created, &signLog.Revision, &signLog.Station, &signLog.Hash, &details, &signLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return SigningLogVerification{}, err
+		}
+		signLog.Details = decodeSigningLogDetails(details)
+		signLog.Signer = decodeSigningLogSigner(signer)
+		signLog.Origin = decodeSigningLogOrigin(origin)
+		verifier.add(signLog)
+	}
+
+	return verifier.finish(), nil
+}
+
+// checkpointQuerier is satisfied by both the database and a transaction
+type checkpointQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func listSigningLogCheckpoints(q checkpointQuerier) ([]SigningLogCheckpoint, error) {
+	rows, err := q.Query(listSigningLogCheckpointSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []SigningLogCheckpoint{}
+	for rows.Next() {
+		c := SigningLogCheckpoint{}
+		err := rows.Scan(&c.ID, &c.LogID, &c.Hash, &c.Signature, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, nil
+}
+
+// signingLogVerifier checks the signing log entries, in ID order, against the chain and its checkpoints
+type signingLogVerifier struct {
+	secret       string
+	checkpoints  map[int][]SigningLogCheckpoint
+	previousHash string
+	chained      bool
+	hashedAll    bool
+	result       SigningLogVerification
+}
+
+func newSigningLogVerifier(secret string, checkpoints []SigningLogCheckpoint) *signingLogVerifier {
+	v := &signingLogVerifier{
+		secret:      secret,
+		checkpoints: make(map[int][]SigningLogCheckpoint),
+		result:      SigningLogVerification{Checkpoints: len(checkpoints), Errors: []string{}},
+	}
+
+	for _, c := range checkpoints {
+		if c.Signature != signingLogCheckpointSignature(secret, c.LogID, c.Hash) {
+			v.result.Errors = append(v.result.Errors, fmt.Sprintf("checkpoint %d has an invalid signature", c.ID))
+			continue
+		}
+		v.checkpoints[c.LogID] = append(v.checkpoints[c.LogID], c)
+	}
+	return v
+}
+
+func (v *signingLogVerifier) add(signLog SigningLog) {
+	v.result.Rows++
+
+	// Entries logged before chaining was introduced have no hash
+	if len(signLog.Hash) == 0 {
+		if v.chained {
+			v.result.Errors = append(v.result.Errors, fmt.Sprintf("signing log %d is missing its hash", signLog.ID))
+		}
+		v.previousHash = ""
+	} else {
+		v.chained = true
+		hash := signingLogHash(v.previousHash, signLog)
+		if !strings.HasPrefix(signLog.Hash, signingLogHashPrefix) {
+			// Once the hashes cover every column, an entry is not expected to have a legacy hash
+			if v.hashedAll {
+				v.result.Errors = append(v.result.Errors, fmt.Sprintf("signing log %d has a legacy hash", signLog.ID))
+			}
+			hash = signingLogLegacyHash(v.previousHash, signLog)
+		} else {
+			v.hashedAll = true
+		}
+		if hash != signLog.Hash {
+			v.result.Errors = append(v.result.Errors, fmt.Sprintf("signing log %d has been modified, or a preceding entry has been deleted", signLog.ID))
+		}
+		v.previousHash = signLog.Hash
+	}
+
+	for _, c := range v.checkpoints[signLog.ID] {
+		if c.Hash != signLog.Hash {
+			v.result.Errors = append(v.result.Errors, fmt.Sprintf("signing log %d does not match checkpoint %d", signLog.ID, c.ID))
+		}
+	}
+	delete(v.checkpoints, signLog.ID)
+}
+
+func (v *signingLogVerifier) finish() SigningLogVerification {
+	// Any remaining checkpoints refer to entries that no longer exist
+	logIDs := []int{}
+	for logID := range v.checkpoints {
+		// Ignore checkpoints of an empty signing log
+		if logID > 0 {
+			logIDs = append(logIDs, logID)
+		}
+	}
+	sort.Ints(logIDs)
+
+	for _, logID := range logIDs {
+		for _, c := range v.checkpoints[logID] {
+			v.result.Errors = append(v.result.Errors, fmt.Sprintf("signing log %d, anchored by checkpoint %d, has been deleted", logID, c.ID))
+		}
+	}
+	return v.result
+}
@@ -0,0 +1,239 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func chainedSigningLogs(secret string) ([]SigningLog, []SigningLogCheckpoint) {
+	logs := []SigningLog{
+		{ID: 1, Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Revision: 1},
+		{ID: 2, Make: "System", Model: "alder", SerialNumber: "A2", Fingerprint: "a2", Revision: 1},
+		{ID: 3, Make: "System", Model: "alder", SerialNumber: "A3", Fingerprint: "a3", Revision: 1, Station: "line-1"},
+		{ID: 4, Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "a4", Revision: 2},
+	}
+
+	previousHash := ""
+	for i := range logs {
+		logs[i].Hash = signingLogHash(previousHash, logs[i])
+		previousHash = logs[i].Hash
+	}
+
+	checkpoints := []SigningLogCheckpoint{
+		{ID: 1, LogID: 4, Hash: logs[3].Hash, Signature: signingLogCheckpointSignature(secret, 4, logs[3].Hash)},
+	}
+	return logs, checkpoints
+}
+
+func verifySigningLogs(secret string, logs []SigningLog, checkpoints []SigningLogCheckpoint) SigningLogVerification {
+	v := newSigningLogVerifier(secret, checkpoints)
+	for _, l := range logs {
+		v.add(l)
+	}
+	return v.finish()
+}
+
+func TestSigningLogChainValid(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// Legacy entries, without a hash, can only precede the chain
+	legacy := SigningLog{ID: 0, Make: "System", Model: "alder", SerialNumber: "A0", Fingerprint: "a0", Revision: 1}
+
+	result := verifySigningLogs("secret", append([]SigningLog{legacy}, logs...), checkpoints)
+	if len(result.Errors) > 0 {
+		t.Errorf("Expected a valid chain, got: %v", result.Errors)
+	}
+	if result.Rows != 5 || result.Checkpoints != 1 {
+		t.Errorf("Expected 5 rows and 1 checkpoint, got %d and %d", result.Rows, result.Checkpoints)
+	}
+}
+
+func TestSigningLogChainModified(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+	logs[1].SerialNumber = "B2"
+
+	result := verifySigningLogs("secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the modified entry to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainDeleted(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// Delete an entry in the middle of the chain
+	result := verifySigningLogs("secret", append(logs[:1:1], logs[2:]...), checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the deleted entry to be detected, got: %v", result.Errors)
+	}
+
+	// Delete the anchored entry at the end of the chain
+	result = verifySigningLogs("secret", logs[:3], checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the deleted anchored entry to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainCheckpointSignature(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	result := verifySigningLogs("another secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the invalid checkpoint signature to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainDetails(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// The details are chained when present
+	withDetails := logs[1]
+	withDetails.Details = map[string]string{"mac": "00:11:22:33:44:55"}
+	if signingLogHash(logs[0].Hash, withDetails) == logs[1].Hash {
+		t.Error("Expected the details to change the hash")
+	}
+
+	logs[1].Details = map[string]string{"mac": "00:11:22:33:44:55"}
+	result := verifySigningLogs("secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the added details to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogDetailsPattern(t *testing.T) {
+	details := encodeSigningLogDetails(map[string]string{"sku": "A_100%", "mac": "00:11"})
+	if details != `{"mac":"00:11","sku":"A_100%"}` {
+		t.Errorf("Unexpected encoded details: %s", details)
+	}
+
+	pattern := signingLogDetailsPattern("sku", "A_100%")
+	if pattern != `%"sku":"A\_100\%"%` {
+		t.Errorf("Unexpected details pattern: %s", pattern)
+	}
+
+	if len(decodeSigningLogDetails("")) != 0 || decodeSigningLogDetails(details)["mac"] != "00:11" {
+		t.Errorf("Unexpected decoded details for: %s", details)
+	}
+}
+
+func TestSigningLogChainFallbackKey(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// The fallback signing-key is chained when present
+	withFallback := logs[1]
+	withFallback.FallbackKeyID = "fallback-key-id"
+	if signingLogHash(logs[0].Hash, withFallback) == logs[1].Hash {
+		t.Error("Expected the fallback signing-key to change the hash")
+	}
+
+	logs[1].FallbackKeyID = "fallback-key-id"
+	result := verifySigningLogs("secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the added fallback signing-key to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainSigner(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// The signer is chained when recorded
+	withSigner := logs[1]
+	withSigner.Signer = &SigningAudit{KeypairID: 1, KeyID: "key-id", Backend: "database", Instance: "vault-1", Version: "2.4-6"}
+	if signingLogHash(logs[0].Hash, withSigner) == logs[1].Hash {
+		t.Error("Expected the signer to change the hash")
+	}
+
+	logs[1].Signer = withSigner.Signer
+	result := verifySigningLogs("secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the added signer to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainOrigin(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// An empty origin is stored as an empty column, so it keeps the hash
+	withEmptyOrigin := logs[1]
+	withEmptyOrigin.Origin = &SigningOrigin{}
+	if signingLogHash(logs[0].Hash, withEmptyOrigin) != logs[1].Hash {
+		t.Error("Expected an empty origin to keep the hash")
+	}
+
+	// The origin is chained when recorded
+	withOrigin := logs[1]
+	withOrigin.Origin = &SigningOrigin{ClientIP: "81.2.69.160", Country: "GB"}
+	if signingLogHash(logs[0].Hash, withOrigin) == logs[1].Hash {
+		t.Error("Expected the origin to change the hash")
+	}
+
+	logs[1].Origin = withOrigin.Origin
+	result := verifySigningLogs("secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the added origin to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainCreated(t *testing.T) {
+	logs, checkpoints := chainedSigningLogs("secret")
+
+	// The signing time is chained
+	backdated := logs[1]
+	backdated.Created = logs[1].Created.Add(-time.Hour)
+	if signingLogHash(logs[0].Hash, backdated) == logs[1].Hash {
+		t.Error("Expected the signing time to change the hash")
+	}
+
+	logs[1].Created = backdated.Created
+	result := verifySigningLogs("secret", logs, checkpoints)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the backdated entry to be detected, got: %v", result.Errors)
+	}
+}
+
+func TestSigningLogChainLegacyHash(t *testing.T) {
+	legacy := []SigningLog{
+		{ID: 1, Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Revision: 1},
+		{ID: 2, Make: "System", Model: "alder", SerialNumber: "A2", Fingerprint: "a2", Revision: 1},
+	}
+	legacy[0].Hash = signingLogLegacyHash("", legacy[0])
+	legacy[1].Hash = signingLogLegacyHash(legacy[0].Hash, legacy[1])
+
+	// The entries chained before the hash covered every column remain valid
+	logs := append([]SigningLog{}, legacy...)
+	logs = append(logs, SigningLog{ID: 3, Make: "System", Model: "alder", SerialNumber: "A3", Fingerprint: "a3", Revision: 1})
+	logs[2].Hash = signingLogHash(logs[1].Hash, logs[2])
+
+	result := verifySigningLogs("secret", logs, nil)
+	if len(result.Errors) != 0 {
+		t.Errorf("Expected a valid chain, got: %v", result.Errors)
+	}
+
+	// A legacy hash is not accepted after the chain covers every column
+	tail := SigningLog{ID: 4, Make: "System", Model: "alder", SerialNumber: "A4", Fingerprint: "a4", Revision: 1}
+	tail.Hash = signingLogLegacyHash(logs[2].Hash, tail)
+
+	result = verifySigningLogs("secret", append(logs, tail), nil)
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the legacy hash to be detected, got: %v", result.Errors)
+	}
+}
@@ -0,0 +1,224 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// MaxSigningLogImport is the maximum number of entries in an import of the signing log
+const MaxSigningLogImport = 10000
+
+// SigningImportBackend is the backend of the signer of an imported signing log entry
+const SigningImportBackend = "import"
+
+// Outcomes of the import of a signing log entry
+const (
+	ImportCreated   = "imported"  // the entry was added to the signing log
+	ImportDuplicate = "duplicate" // the serial number was already signed for the device-key
+	ImportConflict  = "conflict"  // the revision of the serial number was signed for a different device-key
+)
+
+const findSigningLogForDeviceSQL = "SELECT EXISTS(SELECT * FROM signinglog where make=$1 and model=$2 and serial_number=$3 and fingerprint=$4)"
+
+// SigningImport records the provenance of a signing log entry that was imported from
+// another signing system
+type SigningImport struct {
+	Source     string    `json:"source"`              // the signing system of the entry
+	SourceID   string    `json:"source-id,omitempty"` // the ID of the entry in the signing system
+	Batch      string    `json:"batch"`               // identifies the import run
+	ImportedBy string    `json:"imported-by"`
+	Imported   time.Time `json:"imported"`
+}
+
+// SigningLogImportEntry is a historical signing log entry from another signing system. A
+// missing revision is numbered after the revisions of the serial number in the signing log
+type SigningLogImportEntry struct {
+	SourceID     string            `json:"source-id"`
+	Make         string            `json:"make"`
+	Model        string            `json:"model"`
+	SerialNumber string            `json:"serialnumber"`
+	Fingerprint  string            `json:"fingerprint"`
+	Created      time.Time         `json:"created"`
+	Revision     int               `json:"revision"`
+	Station      string            `json:"station"`
+	Details      map[string]string `json:"details,omitempty"`
+	KeyID        string            `json:"key-id"` // the signing-key that signed the serial assertion
+}
+
+// SigningLogImport is a batch of historical signing log entries from another signing system
+type SigningLogImport struct {
+	Source  string                  `json:"source"`
+	Entries []SigningLogImportEntry `json:"entries"`
+}
+
+// SigningLogImportResult summarizes the import of a batch of signing log entries
+type SigningLogImportResult struct {
+	Batch      string                  `json:"batch"`
+	Imported   int                     `json:"imported"`
+	Duplicates int                     `json:"duplicates"`
+	Conflicts  []SigningLogImportEntry `json:"conflicts"`
+}
+
+// ValidateSigningLogImport checks the entries of an import, which must have been signed in the past
+func ValidateSigningLogImport(imp SigningLogImport, now time.Time) error {
+	if len(imp.Source) == 0 {
+		return errors.New("The source of the imported signing log must be entered")
+	}
+	if len(imp.Entries) == 0 {
+		return errors.New("The imported signing log has no entries")
+	}
+	if len(imp.Entries) > MaxSigningLogImport {
+		return fmt.Errorf("The imported signing log must not have more than %d entries", MaxSigningLogImport)
+	}
+
+	for i, e := range imp.Entries {
+		if !validateStringsNotEmpty(e.Make, e.Model, e.SerialNumber, e.Fingerprint) {
+			return fmt.Errorf("Entry %d: the Make, Model, Serial Number and device-key Fingerprint must be supplied", i+1)
+		}
+		if e.Created.IsZero() || e.Created.After(now) {
+			return fmt.Errorf("Entry %d: the signing time must be supplied and must not be in the future", i+1)
+		}
+		if e.Revision < 0 {
+			return fmt.Errorf("Entry %d: the revision must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// ImportSigningLogs adds the entries of an import to the signing log, oldest first, marking
+// their provenance. The entries that were already signed for the device-key are skipped, as
+// are the entries whose revision was signed for a different device-key
+func ImportSigningLogs(db Datastore, imp SigningLogImport, importedBy string) (SigningLogImportResult, error) {
+	batch, err := random.GenerateRandomString(16)
+	if err != nil {
+		return SigningLogImportResult{}, err
+	}
+	result := SigningLogImportResult{Batch: batch, Conflicts: []SigningLogImportEntry{}}
+
+	entries := make([]SigningLogImportEntry, len(imp.Entries))
+	copy(entries, imp.Entries)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Created.Before(entries[j].Created) })
+
+	imported := time.Now().UTC()
+	for _, e := range entries {
+		signLog := SigningLog{
+			Make:         e.Make,
+			Model:        e.Model,
+			SerialNumber: e.SerialNumber,
+			Fingerprint:  e.Fingerprint,
+			Created:      e.Created.UTC(),
+			Revision:     e.Revision,
+			Station:      e.Station,
+			Details:      e.Details,
+			Signer: &SigningAudit{
+				KeyID:   e.KeyID,
+				Backend: SigningImportBackend,
+				Import:  &SigningImport{Source: imp.Source, SourceID: e.SourceID, Batch: batch, ImportedBy: importedBy, Imported: imported},
+			},
+		}
+
+		outcome, err := db.ImportSigningLog(signLog)
+		if err != nil {
+			return result, fmt.Errorf("Error importing %s/%s/%s: %v", e.Make, e.Model, e.SerialNumber, err)
+		}
+
+		switch outcome {
+		case ImportCreated:
+			result.Imported++
+		case ImportDuplicate:
+			result.Duplicates++
+		case ImportConflict:
+			result.Conflicts = append(result.Conflicts, e)
+		}
+	}
+
+	return result, nil
+}
+
+// ImportSigningLog adds a historical entry to the signing log, keeping its signing time. It
+// returns whether the entry was imported, or skipped as a duplicate or conflict
+func (db *DB) ImportSigningLog(signLog SigningLog) (string, error) {
+	if InFactory() {
+		return "", errors.New("The signing log can only be imported in the cloud")
+	}
+	if !validateStringsNotEmpty(signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint) {
+		return "", errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
+	}
+
+	var outcome string
+	err := db.transaction(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(findSigningLogForDeviceSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			outcome = ImportDuplicate
+			return nil
+		}
+
+		// Number the entry after the revisions of the serial number, unless it has a revision
+		if signLog.Revision == 0 {
+			err = tx.QueryRow(findMaxRevisionSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber).Scan(&signLog.Revision)
+			if err != nil {
+				return err
+			}
+			signLog.Revision++
+		} else {
+			err = tx.QueryRow(findMatchingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Revision).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists {
+				outcome = ImportConflict
+				return nil
+			}
+		}
+
+		// Chain the entry to the previous entry, as it is appended to the signing log
+		_, previousHash, err := lastSigningLogHash(tx)
+		if err != nil {
+			return err
+		}
+		signLog.Created = signingLogCreated(signLog.Created)
+		signLog.Hash = signingLogHash(previousHash, signLog)
+
+		if err := insertSigningLog(tx, signLog); err != nil {
+			return err
+		}
+		outcome = ImportCreated
+
+		return db.autoCheckpointSigningLog(tx)
+	})
+	if err != nil {
+		log.Printf("Error importing the signing log: %v\n", err)
+		return "", err
+	}
+
+	return outcome, nil
+}
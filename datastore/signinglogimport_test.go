@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+// importRecorder records the signing logs that are imported, in order
+type importRecorder struct {
+	MockDB
+	logs []SigningLog
+}
+
+func (db *importRecorder) ImportSigningLog(signLog SigningLog) (string, error) {
+	db.logs = append(db.logs, signLog)
+	return db.MockDB.ImportSigningLog(signLog)
+}
+
+func TestImportSigningLogs(t *testing.T) {
+	signed := time.Now().UTC().Add(-time.Hour)
+	imp := SigningLogImport{Source: "legacy", Entries: []SigningLogImportEntry{
+		{SourceID: "2", Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "a2", Created: signed},
+		{SourceID: "1", Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Created: signed.Add(-time.Minute), KeyID: "legacy-key"},
+	}}
+
+	db := &importRecorder{}
+	result, err := ImportSigningLogs(db, imp, "sv")
+	if err != nil {
+		t.Fatalf("Expected the import to succeed: %v", err)
+	}
+	if result.Imported != 1 || result.Duplicates != 1 || len(result.Conflicts) != 0 || len(result.Batch) == 0 {
+		t.Errorf("Unexpected import result: %v", result)
+	}
+
+	// The entries are imported oldest first, marked with their provenance
+	if len(db.logs) != 2 || db.logs[0].SerialNumber != "A1" || db.logs[1].SerialNumber != "A2" {
+		t.Fatalf("Expected the entries in signing order, got: %v", db.logs)
+	}
+	signer := db.logs[0].Signer
+	if signer == nil || signer.Backend != SigningImportBackend || signer.KeyID != "legacy-key" || signer.Import == nil {
+		t.Fatalf("Expected the import audit, got: %v", signer)
+	}
+	if signer.Import.Source != "legacy" || signer.Import.SourceID != "1" || signer.Import.Batch != result.Batch || signer.Import.ImportedBy != "sv" {
+		t.Errorf("Unexpected import provenance: %v", signer.Import)
+	}
+
+	// The provenance is protected by the signing log chain
+	marked := db.logs[0]
+	unmarked := marked
+	unmarked.Signer = nil
+	if signingLogHash("", marked) == signingLogHash("", unmarked) {
+		t.Error("Expected the provenance to be part of the signing log hash")
+	}
+
+	_, err = ImportSigningLogs(&ErrorMockDB{}, imp, "sv")
+	if err == nil {
+		t.Error("Expected an error from the datastore")
+	}
+}
+
+func TestValidateSigningLogImport(t *testing.T) {
+	now := time.Now().UTC()
+	valid := SigningLogImportEntry{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Created: now.Add(-time.Hour)}
+
+	entries := func(update func(e *SigningLogImportEntry)) []SigningLogImportEntry {
+		e := valid
+		update(&e)
+		return []SigningLogImportEntry{e}
+	}
+
+	tests := []struct {
+		imp   SigningLogImport
+		valid bool
+	}{
+		{SigningLogImport{Source: "legacy", Entries: []SigningLogImportEntry{valid}}, true},
+		{SigningLogImport{Entries: []SigningLogImportEntry{valid}}, false},
+		{SigningLogImport{Source: "legacy"}, false},
+		{SigningLogImport{Source: "legacy", Entries: make([]SigningLogImportEntry, MaxSigningLogImport+1)}, false},
+		{SigningLogImport{Source: "legacy", Entries: entries(func(e *SigningLogImportEntry) { e.Fingerprint = "" })}, false},
+		{SigningLogImport{Source: "legacy", Entries: entries(func(e *SigningLogImportEntry) { e.Created = time.Time{} })}, false},
+		{SigningLogImport{Source: "legacy", Entries: entries(func(e *SigningLogImportEntry) { e.Created = now.Add(time.Hour) })}, false},
+		{SigningLogImport{Source: "legacy", Entries: entries(func(e *SigningLogImportEntry) { e.Revision = -1 })}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateSigningLogImport(tt.imp, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("Expected valid=%v for %v, got: %v", tt.valid, tt.imp.Entries, err)
+		}
+	}
+}
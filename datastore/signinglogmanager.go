@@ -21,8 +21,10 @@ package datastore
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -35,13 +37,25 @@ const createSigningLogTableSQL = `
 		fingerprint    varchar(200) not null,
 		created        timestamp default current_timestamp,
 		revision       int default 1,
-		synced         int default 0
+		synced         int default 0,
+		station        varchar(200) default '',
+		hash           varchar(200) default '',
+		details        text default '',
+		fallback_key   varchar(200) default '',
+		signer         text default '',
+		origin         text default ''
 	)
 `
 
 // Additional columns
 const alterSigningLogAddRevisionSQL = "ALTER TABLE signinglog ADD COLUMN revision int default 1"
 const alterSigningLogAddSyncedSQL = "ALTER TABLE signinglog ADD COLUMN synced int default 0"
+const alterSigningLogAddStationSQL = "ALTER TABLE signinglog ADD COLUMN station varchar(200) default ''"
+const alterSigningLogAddHashSQL = "ALTER TABLE signinglog ADD COLUMN hash varchar(200) default ''"
+const alterSigningLogAddDetailsSQL = "ALTER TABLE signinglog ADD COLUMN details text default ''"
+const alterSigningLogAddFallbackKeySQL = "ALTER TABLE signinglog ADD COLUMN fallback_key varchar(200) default ''"
+const alterSigningLogAddSignerSQL = "ALTER TABLE signinglog ADD COLUMN signer text default ''"
+const alterSigningLogAddOriginSQL = "ALTER TABLE signinglog ADD COLUMN origin text default ''"
 
 // MaxFromID is the maximum ID value
 const MaxFromID = 2147483647
@@ -50,15 +64,30 @@ const MaxFromID = 2147483647
 const createSigningLogSerialNumberIndexSQL = "CREATE INDEX IF NOT EXISTS serialnumber_idx ON signinglog (make,model,serial_number)"
 const createSigningLogFingerprintIndexSQL = "CREATE INDEX IF NOT EXISTS fingerprint_idx ON signinglog (fingerprint)"
 const createSigningLogCreatedIndexSQL = "CREATE INDEX IF NOT EXISTS created_idx ON signinglog (created)"
+const createSigningLogMakeIndexSQL = "CREATE INDEX IF NOT EXISTS signinglog_make_id_idx ON signinglog (make,id)"
+const createSigningLogStationIndexSQL = "CREATE INDEX IF NOT EXISTS signinglog_station_idx ON signinglog (make,station,id)"
+
+// Trigram indexes for the searches of the details and origin, which match a pattern within the
+// JSON-encoded text. They are only available on Postgres, with the pg_trgm extension
+const createTrigramExtensionSQL = "CREATE EXTENSION IF NOT EXISTS pg_trgm"
+const createSigningLogDetailsIndexSQL = "CREATE INDEX CONCURRENTLY IF NOT EXISTS signinglog_details_trgm_idx ON signinglog USING gin (details gin_trgm_ops)"
+const createSigningLogOriginIndexSQL = "CREATE INDEX CONCURRENTLY IF NOT EXISTS signinglog_origin_trgm_idx ON signinglog USING gin (origin gin_trgm_ops)"
+
+// A concurrent build that fails leaves an invalid index, which IF NOT EXISTS would then skip
+const findInvalidIndexSQL = "SELECT EXISTS(SELECT * FROM pg_index WHERE indexrelid=to_regclass($1) AND NOT indisvalid)"
+const dropSigningLogDetailsIndexSQL = "DROP INDEX CONCURRENTLY IF EXISTS signinglog_details_trgm_idx"
+const dropSigningLogOriginIndexSQL = "DROP INDEX CONCURRENTLY IF EXISTS signinglog_origin_trgm_idx"
 
 // Queries
 const findMatchingSigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where make=$1 and model=$2 and serial_number=$3 and revision=$4)"
 const findExistingSigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where (make=$1 and model=$2 and serial_number=$3) or fingerprint=$4)"
+const findExistingSerialSigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where make=$1 and model=$2 and serial_number=$3)"
+const findExistingDeviceKeySigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where fingerprint=$1)"
+const findExistingPairSigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where make=$1 and model=$2 and serial_number=$3 and fingerprint=$4)"
 const findMaxRevisionSigningLogSQL = "SELECT COALESCE(MAX(revision), 0) FROM signinglog where make=$1 and model=$2 and serial_number=$3"
 const maxIDSigningLogSQLite = "SELECT COUNT(*)+1 from signinglog"
-const createSigningLogSQLite = "INSERT INTO signinglog (id, make, model, serial_number, fingerprint,revision) VALUES ($1, $2, $3, $4, $5, $6)"
-const createSigningLogSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision) VALUES ($1, $2, $3, $4, $5)"
-const createSigningLogSyncSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision,created) VALUES ($1, $2, $3, $4, $5, $6)"
+const createSigningLogSQLite = "INSERT INTO signinglog (id, make, model, serial_number, fingerprint,revision,created,station,hash,details,fallback_key,signer,origin) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)"
+const createSigningLogSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision,created,station,hash,details,fallback_key,signer,origin) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id"
 const listSigningLogSQL = "SELECT * FROM signinglog WHERE id < $1 ORDER BY id DESC LIMIT 10000"
 const listSigningLogForUserSQL = `
 	SELECT s.* FROM signinglog s
@@ -82,6 +111,32 @@ const listSigningLogForAccountForUserSQL = `
 	AND s.make=$3
 	ORDER BY id DESC LIMIT 10000`
 
+// Search the details of the signing logs of an account, matching the JSON-encoded "field":"value" pair
+const searchSigningLogForAccountSQL = `SELECT * FROM signinglog WHERE id < $1 AND make=$2 AND details LIKE $3 ESCAPE '\' ORDER BY id DESC LIMIT 10000`
+const searchSigningLogForAccountForUserSQL = `
+	SELECT s.* FROM signinglog s
+	WHERE id < $1 and EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2
+	)
+	AND s.make=$3 AND s.details LIKE $4 ESCAPE '\'
+	ORDER BY id DESC LIMIT 10000`
+
+// Search the origin of the signing logs of an account, matching the JSON-encoded "field":"value" pair
+const searchSigningLogOriginForAccountSQL = `SELECT * FROM signinglog WHERE id < $1 AND make=$2 AND origin LIKE $3 ESCAPE '\' ORDER BY id DESC LIMIT 10000`
+const searchSigningLogOriginForAccountForUserSQL = `
+	SELECT s.* FROM signinglog s
+	WHERE id < $1 and EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2
+	)
+	AND s.make=$3 AND s.origin LIKE $4 ESCAPE '\'
+	ORDER BY id DESC LIMIT 10000`
+
 const deleteSigningLogSQL = "DELETE FROM signinglog WHERE id=$1"
 
 const filterValuesModelSigningLogSQL = "SELECT DISTINCT model FROM signinglog WHERE make=$1 ORDER BY model"
@@ -95,6 +150,9 @@ const filterValuesModelSigningLogForUserSQL = `
 	)
 	AND s.make = $2
 	ORDER BY model`
+const listSignedDevicesSQL = "SELECT model, serial_number, created FROM signinglog WHERE make=$1 ORDER BY model, serial_number, id"
+const listSerialSigningLogSQL = "SELECT * FROM signinglog WHERE make=$1 AND model=$2 AND serial_number=$3 ORDER BY revision"
+const findSignedDevicesSQL = "SELECT model, serial_number, created FROM signinglog WHERE make=$1 AND serial_number=$2 ORDER BY model, id"
 const syncSigningLogSQLite = "SELECT * FROM signinglog WHERE synced = 0"
 const syncSigningLogUpdateSQLite = "UPDATE signinglog SET synced=1 WHERE id = $1"
 
@@ -109,6 +167,25 @@ type SigningLog struct {
 	Created      time.Time `json:"created"`
 	Revision     int       `json:"revision"`
 	Synced       int       `json:"synced"`
+	Station      string    `json:"station"`
+	Hash         string    `json:"hash"` // chains the hash of the previous entry with this entry
+	// Details holds the allowed fields from the serial-request body e.g. MAC address, SKU
+	Details map[string]string `json:"details,omitempty"`
+	// FallbackKeyID marks a device signed with a fallback signing-key, as the keystore failed for the model's signing-key
+	FallbackKeyID string `json:"fallback-key-id,omitempty"`
+	// Signer records the signing-key, keystore and vault instance that signed the assertion
+	Signer *SigningAudit `json:"signer,omitempty"`
+	// Origin records the client IP, country and TLS client identity of the signing request
+	Origin *SigningOrigin `json:"origin,omitempty"`
+	// Annotations are the notes that admins attached to the entry
+	Annotations []SigningLogAnnotation `json:"annotations,omitempty"`
+}
+
+// SignedDevice is a device that has been signed, with the date of its first signing log entry
+type SignedDevice struct {
+	Model  string    `json:"model"`
+	Serial string    `json:"serial"`
+	Signed time.Time `json:"signed"`
 }
 
 // SigningLogFilters holds the values of the filters for the searchable columns
@@ -139,16 +216,80 @@ func (db *DB) CreateSigningLogTable() error {
 	// Ignoring the error when adding the column
 	db.Exec(alterSigningLogAddRevisionSQL)
 	db.Exec(alterSigningLogAddSyncedSQL)
+	db.Exec(alterSigningLogAddStationSQL)
+	db.Exec(alterSigningLogAddHashSQL)
+	db.Exec(alterSigningLogAddDetailsSQL)
+	db.Exec(alterSigningLogAddFallbackKeySQL)
+	db.Exec(alterSigningLogAddSignerSQL)
+	db.Exec(alterSigningLogAddOriginSQL)
+
+	// The indexes of the searches use the added columns
+	_, err = db.Exec(createSigningLogMakeIndexSQL)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(createSigningLogStationIndexSQL)
+	return err
+}
 
-	return nil
+// CreateSigningLogSearchIndexes creates the trigram indexes for the searches of the signing log.
+// The indexes are built concurrently, so the signing requests are not blocked on a large table.
+// Creating the extension needs privileges that the database user may not have, in which case
+// the searches still work without the indexes and the startup check warns that they are missing.
+// A sqlite database is searched without the indexes
+func (db *DB) CreateSigningLogSearchIndexes() error {
+	if usesSQLite() {
+		return nil
+	}
+
+	_, err := db.Exec(createTrigramExtensionSQL)
+	if err != nil {
+		log.Printf("Error creating the pg_trgm extension, the signing log search is not indexed: %v\n", err)
+		return nil
+	}
+
+	err = db.createIndexConcurrently("signinglog_details_trgm_idx", dropSigningLogDetailsIndexSQL, createSigningLogDetailsIndexSQL)
+	if err != nil {
+		return err
+	}
+	return db.createIndexConcurrently("signinglog_origin_trgm_idx", dropSigningLogOriginIndexSQL, createSigningLogOriginIndexSQL)
+}
+
+// createIndexConcurrently builds the index, first dropping it when an earlier build failed and left it invalid
+func (db *DB) createIndexConcurrently(name, dropSQL, createSQL string) error {
+	var invalid bool
+	err := db.QueryRow(findInvalidIndexSQL, name).Scan(&invalid)
+	if err != nil {
+		return err
+	}
+	if invalid {
+		log.Printf("Dropping the invalid '%s' index to create it again\n", name)
+		if _, err = db.Exec(dropSQL); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(createSQL)
+	return err
 }
 
-// CheckForDuplicate verifies that the serial number and the device-key fingerprint have not be used previously.
-// If a duplicate serial number does exist, it returns the maximum revision number for the serial number.
-func (db *DB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
+// CheckForDuplicate verifies that the serial number and/or the device-key fingerprint have not be used previously,
+// as set by the duplicate scope of the model. It also returns the maximum revision number for the serial number.
+func (db *DB) CheckForDuplicate(signLog *SigningLog, scope string) (bool, int, error) {
 	var duplicateExists bool
 	var maxRevision int
-	err := db.QueryRow(findExistingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint).Scan(&duplicateExists)
+	var row *sql.Row
+	switch scope {
+	case DuplicateScopeSerial:
+		row = db.QueryRow(findExistingSerialSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber)
+	case DuplicateScopeDeviceKey:
+		row = db.QueryRow(findExistingDeviceKeySigningLogSQL, signLog.Fingerprint)
+	case DuplicateScopePair:
+		row = db.QueryRow(findExistingPairSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint)
+	default:
+		row = db.QueryRow(findExistingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint)
+	}
+	err := row.Scan(&duplicateExists)
 	if err != nil {
 		log.Printf("Error checking signinglog for duplicate: %v\n", err)
 		return false, 0, errors.New("Error communicating with the database")
@@ -185,20 +326,35 @@ func (db *DB) CreateSigningLog(signLog SigningLog) error {
 		return errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
 	}
 
-	// Create the signing log in the database
-	if InFactory() {
-		// Need to generate our own ID
-		var nextID int
-		err = db.QueryRow(maxIDSigningLogSQLite).Scan(&nextID)
+	// Create the signing log in the database, chained to the previous entry
+	err = db.transaction(func(tx *sql.Tx) error {
+		_, previousHash, err := lastSigningLogHash(tx)
 		if err != nil {
-			log.Printf("Error retrieving next signing-log ID: %v\n", err)
 			return err
 		}
+		signLog.Created = signingLogCreated(time.Now())
+		signLog.Hash = signingLogHash(previousHash, signLog)
+
+		// The signed units of a new device are counted again while the signing log is locked,
+		// so concurrent signing requests cannot go over the maximum of the authorization
+		if signLog.Revision == 1 && InFactory() {
+			a, err := signingAuthorization(tx, signLog.Make, signLog.Model)
+			if err != nil {
+				return err
+			}
+			if a != nil {
+				if err := checkSignedUnits(tx, *a); err != nil {
+					return err
+				}
+			}
+		}
 
-		_, err = db.Exec(createSigningLogSQLite, nextID, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision)
-	} else {
-		_, err = db.Exec(createSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision)
-	}
+		if err := insertSigningLog(tx, signLog); err != nil {
+			return err
+		}
+
+		return db.autoCheckpointSigningLog(tx)
+	})
 
 	// Create the log in the database
 	if err != nil {
@@ -209,6 +365,29 @@ func (db *DB) CreateSigningLog(signLog SigningLog) error {
 	return nil
 }
 
+// insertSigningLog stores the chained signing log and moves the chain head to it
+func insertSigningLog(tx *sql.Tx, signLog SigningLog) error {
+	var logID int
+	var err error
+	if usesSQLite() {
+		// Need to generate our own ID
+		err = tx.QueryRow(maxIDSigningLogSQLite).Scan(&logID)
+		if err != nil {
+			log.Printf("Error retrieving next signing-log ID: %v\n", err)
+			return err
+		}
+
+		_, err = tx.Exec(createSigningLogSQLite, logID, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, signLog.Created, signLog.Station, signLog.Hash, encodeSigningLogDetails(signLog.Details), signLog.FallbackKeyID, encodeSigningLogSigner(signLog.Signer), encodeSigningLogOrigin(signLog.Origin))
+	} else {
+		err = tx.QueryRow(createSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, signLog.Created, signLog.Station, signLog.Hash, encodeSigningLogDetails(signLog.Details), signLog.FallbackKeyID, encodeSigningLogSigner(signLog.Signer), encodeSigningLogOrigin(signLog.Origin)).Scan(&logID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return advanceSigningLogChainHead(tx, logID, signLog.Hash)
+}
+
 // CreateSigningLogSync logs that a specific serial number has been used, along with the device-key fingerprint.
 func (db *DB) CreateSigningLogSync(signLog SigningLog) error {
 	var err error
@@ -217,8 +396,21 @@ func (db *DB) CreateSigningLogSync(signLog SigningLog) error {
 		return errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
 	}
 
-	// Create the signing log in the database
-	_, err = db.Exec(createSigningLogSyncSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, signLog.Created)
+	// Create the signing log in the database, chained to the previous entry
+	err = db.transaction(func(tx *sql.Tx) error {
+		_, previousHash, err := lastSigningLogHash(tx)
+		if err != nil {
+			return err
+		}
+		signLog.Created = signingLogCreated(signLog.Created)
+		signLog.Hash = signingLogHash(previousHash, signLog)
+
+		if err := insertSigningLog(tx, signLog); err != nil {
+			return err
+		}
+
+		return db.autoCheckpointSigningLog(tx)
+	})
 	if err != nil {
 		log.Printf("Error creating the signing log: %v\n", err)
 		return err
@@ -252,14 +444,18 @@ func (db *DB) listSigningLogFilteredByUser(username string) ([]SigningLog, error
 
 	for rows.Next() {
 		signingLog := SigningLog{}
-		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced)
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
 		if err != nil {
 			return nil, err
 		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
 		signingLogs = append(signingLogs, signingLog)
 	}
 
-	return signingLogs, nil
+	return db.annotateSigningLogs(signingLogs, "")
 }
 
 func (db *DB) listAllSigningLogForAccount(authorityID string) ([]SigningLog, error) {
@@ -287,14 +483,65 @@ func (db *DB) listSigningLogForAccountFilteredByUser(username, authorityID strin
 
 	for rows.Next() {
 		signingLog := SigningLog{}
-		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced)
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
 		if err != nil {
 			return nil, err
 		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
 		signingLogs = append(signingLogs, signingLog)
 	}
 
-	return signingLogs, nil
+	return db.annotateSigningLogs(signingLogs, authorityID)
+}
+
+func (db *DB) searchAllSigningLogForAccount(authorityID, field, value string) ([]SigningLog, error) {
+	return db.searchSigningLogForAccountFilteredByUser(anyUserFilter, authorityID, field, value)
+}
+
+// searchSigningLogForAccountFilteredByUser finds the signing logs with a matching value
+// for a field in the details from the serial-request body, or in the origin of the request
+func (db *DB) searchSigningLogForAccountFilteredByUser(username, authorityID, field, value string) ([]SigningLog, error) {
+	signingLogs := []SigningLog{}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	searchSQL, searchForUserSQL := searchSigningLogForAccountSQL, searchSigningLogForAccountForUserSQL
+	if IsOriginField(field) {
+		searchSQL, searchForUserSQL = searchSigningLogOriginForAccountSQL, searchSigningLogOriginForAccountForUserSQL
+	}
+
+	pattern := signingLogDetailsPattern(field, value)
+	if len(username) == 0 {
+		rows, err = db.Query(searchSQL, MaxFromID, authorityID, pattern)
+	} else {
+		rows, err = db.Query(searchForUserSQL, MaxFromID, username, authorityID, pattern)
+	}
+	if err != nil {
+		log.Printf("Error searching signing logs: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		signingLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return nil, err
+		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
+		signingLogs = append(signingLogs, signingLog)
+	}
+
+	return db.annotateSigningLogs(signingLogs, authorityID)
 }
 
 func (db *DB) allSigningLogFilterValues(authorityID string) (SigningLogFilters, error) {
@@ -355,6 +602,78 @@ func (db *DB) filterValuesForField(username, sqlQuery, authorityID string, field
 	return nil
 }
 
+// ListSignedDevices lists the devices of a brand that have been signed, as recorded in the
+// signing log. A device that was signed again is only listed once
+func (db *DB) ListSignedDevices(authorityID string) ([]SignedDevice, error) {
+	rows, err := db.Query(listSignedDevicesSQL, authorityID)
+	if err != nil {
+		log.Printf("Error retrieving the signed devices: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSignedDevices(rows)
+}
+
+// ListSerialSigningLog returns the signing log entries of a device, one for each revision
+// of its serial assertion
+func (db *DB) ListSerialSigningLog(make, model, serialNumber string) ([]SigningLog, error) {
+	rows, err := db.Query(listSerialSigningLogSQL, make, model, serialNumber)
+	if err != nil {
+		log.Printf("Error retrieving the signing logs of the device: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	signingLogs := []SigningLog{}
+	for rows.Next() {
+		signingLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return nil, err
+		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
+		signingLogs = append(signingLogs, signingLog)
+	}
+	return signingLogs, rows.Err()
+}
+
+// FindSignedDevices finds the devices of a brand that have been signed with the serial number,
+// one for each model, with the date of the first signing log entry
+func (db *DB) FindSignedDevices(authorityID, serialNumber string) ([]SignedDevice, error) {
+	rows, err := db.Query(findSignedDevicesSQL, authorityID, serialNumber)
+	if err != nil {
+		log.Printf("Error finding the signed devices: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSignedDevices(rows)
+}
+
+// scanSignedDevices reads the signed devices, ordered by model and serial number
+func scanSignedDevices(rows *sql.Rows) ([]SignedDevice, error) {
+	devices := []SignedDevice{}
+	for rows.Next() {
+		d := SignedDevice{}
+		err := rows.Scan(&d.Model, &d.Serial, &d.Signed)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the later revisions of the device
+		if last := len(devices) - 1; last >= 0 && devices[last].Model == d.Model && devices[last].Serial == d.Serial {
+			continue
+		}
+		devices = append(devices, d)
+	}
+
+	return devices, nil
+}
+
 // SyncSigningLog fetches the factory signing logs to sync with the cloud
 func (db *DB) SyncSigningLog() ([]SigningLog, error) {
 	signingLogs := []SigningLog{}
@@ -368,10 +687,14 @@ func (db *DB) SyncSigningLog() ([]SigningLog, error) {
 
 	for rows.Next() {
 		signingLog := SigningLog{}
-		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced)
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
 		if err != nil {
 			return nil, err
 		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
 		signingLogs = append(signingLogs, signingLog)
 	}
 
@@ -383,3 +706,52 @@ func (db *DB) SyncUpdateSigningLog(id int) error {
 	_, err := db.Exec(syncSigningLogUpdateSQLite, id)
 	return err
 }
+
+// encodeSigningLogDetails stores the details as JSON, with the fields in sorted order
+func encodeSigningLogDetails(details map[string]string) string {
+	if len(details) == 0 {
+		return ""
+	}
+	content, _ := json.Marshal(details)
+	return string(content)
+}
+
+func encodeSigningLogSigner(signer *SigningAudit) string {
+	if signer == nil {
+		return ""
+	}
+	content, _ := json.Marshal(signer)
+	return string(content)
+}
+
+func decodeSigningLogSigner(content string) *SigningAudit {
+	if len(content) == 0 {
+		return nil
+	}
+	signer := SigningAudit{}
+	if err := json.Unmarshal([]byte(content), &signer); err != nil {
+		log.Printf("Error decoding the signing log signer: %v\n", err)
+		return nil
+	}
+	return &signer
+}
+
+func decodeSigningLogDetails(content string) map[string]string {
+	if len(content) == 0 {
+		return nil
+	}
+	details := map[string]string{}
+	if err := json.Unmarshal([]byte(content), &details); err != nil {
+		log.Printf("Error decoding the signing log details: %v\n", err)
+		return nil
+	}
+	return details
+}
+
+// signingLogDetailsPattern is the LIKE pattern to find the "field":"value" pair in the details
+func signingLogDetailsPattern(field, value string) string {
+	k, _ := json.Marshal(field)
+	v, _ := json.Marshal(value)
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(string(k)+":"+string(v)) + "%"
+}
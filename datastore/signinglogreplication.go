@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// MaxReplicationBatch is the maximum number of signing log entries that a peer vault fetches at once
+const MaxReplicationBatch = 1000
+
+// The replicated entries are not replicated again, so the vaults only serve the entries that they signed
+const listSigningLogForReplicationSQL = `
+	SELECT * FROM signinglog
+	WHERE id > $1 AND signer NOT LIKE '%"replica":%'
+	ORDER BY id LIMIT $2`
+const listSigningLogForReplicationForUserSQL = `
+	SELECT s.* FROM signinglog s
+	WHERE id > $1 AND s.signer NOT LIKE '%"replica":%' AND EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2
+	)
+	ORDER BY id LIMIT $3`
+
+// SigningReplica records the provenance of a signing log entry that was replicated from a peer vault
+type SigningReplica struct {
+	Peer       string    `json:"peer"`
+	PeerID     int       `json:"peer-id"` // the ID of the entry in the signing log of the peer
+	Replicated time.Time `json:"replicated"`
+}
+
+// ListAllowedReplicationSigningLog returns the signing log entries after the ID, for a peer vault
+// to replicate. The entries that were replicated from other vaults are not included
+func (db *DB) ListAllowedReplicationSigningLog(authorization User, afterID, limit int) ([]SigningLog, error) {
+	if limit <= 0 || limit > MaxReplicationBatch {
+		limit = MaxReplicationBatch
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		rows, err = db.Query(listSigningLogForReplicationSQL, afterID, limit)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		rows, err = db.Query(listSigningLogForReplicationForUserSQL, afterID, authorization.Username, limit)
+	default:
+		return []SigningLog{}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving signing logs for replication: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	signingLogs := []SigningLog{}
+	for rows.Next() {
+		signingLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return nil, err
+		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
+		signingLogs = append(signingLogs, signingLog)
+	}
+
+	return signingLogs, rows.Err()
+}
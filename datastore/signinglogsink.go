@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// Policies of the signing log sink when an entry cannot be written
+const (
+	SinkPolicyBlock = "block" // fail the signing request
+	SinkPolicyQueue = "queue" // return the signed assertion, retrying the entry in the background
+)
+
+// SigningLogSink is a secondary, write-once storage of the signing log e.g. for regulatory
+// retention. The entries are written at sign time, keyed by SigningLogSinkKey
+type SigningLogSink interface {
+	Name() string
+	Write(ctx context.Context, signLog SigningLog) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+const createSigningLogSinkQueueTableSQL = `
+	CREATE TABLE IF NOT EXISTS signinglogsinkqueue (
+		id             serial primary key not null,
+		entry          text not null,
+		reason         text default '',
+		created        timestamp default current_timestamp
+	)
+`
+
+// Queries
+const maxIDSigningLogSinkQueueSQLite = "SELECT COALESCE(MAX(id), 0)+1 FROM signinglogsinkqueue"
+const createSigningLogSinkQueueSQLite = "INSERT INTO signinglogsinkqueue (id, entry, reason) VALUES ($1, $2, $3)"
+const createSigningLogSinkQueueSQL = "INSERT INTO signinglogsinkqueue (entry, reason) VALUES ($1, $2)"
+const listSigningLogSinkQueueSQL = "SELECT id, entry, reason, created FROM signinglogsinkqueue ORDER BY id"
+const deleteSigningLogSinkQueueSQL = "DELETE FROM signinglogsinkqueue WHERE id=$1"
+const listSigningLogForBrandSQL = "SELECT * FROM signinglog WHERE make=$1 ORDER BY id"
+
+// SigningLogSinkEntry is a signing log entry that is waiting to be written to the sink
+type SigningLogSinkEntry struct {
+	ID         int        `json:"id"`
+	SigningLog SigningLog `json:"signinglog"`
+	Reason     string     `json:"reason"` // the error of the last write
+	Created    time.Time  `json:"created"`
+}
+
+// SigningLogSinkKey is the object key of a signing log entry in the sink. The brand is the
+// first segment, so the entries of a brand can be listed by their prefix
+func SigningLogSinkKey(signLog SigningLog) string {
+	return fmt.Sprintf("%s%s/%s/%d.json", SigningLogSinkPrefix(signLog.Make), url.PathEscape(signLog.Model), url.PathEscape(signLog.SerialNumber), signLog.Revision)
+}
+
+// SigningLogSinkPrefix is the prefix of the object keys of the brand's entries in the sink
+func SigningLogSinkPrefix(brandID string) string {
+	return url.PathEscape(brandID) + "/"
+}
+
+// SinkPolicy returns the policy of the signing log sink from the config, blocking by default
+func SinkPolicy() string {
+	if Environ.Config.SigningLogSinkPolicy == SinkPolicyQueue {
+		return SinkPolicyQueue
+	}
+	return SinkPolicyBlock
+}
+
+// CreateSigningLogSinkQueueTable creates the database table for the queue of the signing log sink
+func (db *DB) CreateSigningLogSinkQueueTable() error {
+	_, err := db.Exec(createSigningLogSinkQueueTableSQL)
+	return err
+}
+
+// QueueSigningLogSink queues a signing log entry that could not be written to the sink
+func (db *DB) QueueSigningLogSink(signLog SigningLog, reason string) error {
+	entry, err := json.Marshal(signLog)
+	if err != nil {
+		return err
+	}
+
+	if usesSQLite() {
+		// Need to generate our own ID
+		var nextID int
+		if err = db.QueryRow(maxIDSigningLogSinkQueueSQLite).Scan(&nextID); err != nil {
+			log.Printf("Error retrieving next signing log sink queue ID: %v\n", err)
+			return err
+		}
+		_, err = db.Exec(createSigningLogSinkQueueSQLite, nextID, string(entry), reason)
+	} else {
+		_, err = db.Exec(createSigningLogSinkQueueSQL, string(entry), reason)
+	}
+	if err != nil {
+		log.Printf("Error queuing the signing log for the sink: %v\n", err)
+	}
+	return err
+}
+
+// ListSigningLogSinkQueue returns the queued signing log entries, oldest first
+func (db *DB) ListSigningLogSinkQueue() ([]SigningLogSinkEntry, error) {
+	rows, err := db.Query(listSigningLogSinkQueueSQL)
+	if err != nil {
+		log.Printf("Error retrieving the signing log sink queue: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []SigningLogSinkEntry{}
+	for rows.Next() {
+		e := SigningLogSinkEntry{}
+		var entry string
+		if err := rows.Scan(&e.ID, &entry, &e.Reason, &e.Created); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(entry), &e.SigningLog); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteSigningLogSinkQueue removes an entry from the queue, once it is in the sink
+func (db *DB) DeleteSigningLogSinkQueue(id int) error {
+	_, err := db.Exec(deleteSigningLogSinkQueueSQL, id)
+	return err
+}
+
+// ListSigningLogForBrand returns all the signing log entries of a brand, to reconcile them with the sink
+func (db *DB) ListSigningLogForBrand(authorityID string) ([]SigningLog, error) {
+	rows, err := db.Query(listSigningLogForBrandSQL, authorityID)
+	if err != nil {
+		log.Printf("Error retrieving signing logs: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	signingLogs := []SigningLog{}
+	for rows.Next() {
+		signingLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return nil, err
+		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
+		signingLogs = append(signingLogs, signingLog)
+	}
+	return signingLogs, rows.Err()
+}
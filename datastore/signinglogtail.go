@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"log"
+)
+
+// MaxTailBatch is the maximum number of signing log entries that are fetched at once to follow the signing log
+const MaxTailBatch = 500
+
+const tailSigningLogSQL = "SELECT * FROM signinglog WHERE id > $1 ORDER BY id LIMIT $2"
+const tailSigningLogForUserSQL = `
+	SELECT s.* FROM signinglog s
+	WHERE id > $1 AND EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2
+	)
+	ORDER BY id LIMIT $3`
+
+// The latest entries are fetched newest first, and returned oldest first
+const lastSigningLogSQL = "SELECT * FROM signinglog ORDER BY id DESC LIMIT $1"
+const lastSigningLogForUserSQL = `
+	SELECT s.* FROM signinglog s
+	WHERE EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$1
+	)
+	ORDER BY id DESC LIMIT $2`
+
+// TailAllowedSigningLog returns the signing log entries after the ID that are visible to the
+// authorization, oldest first, to follow the signing log as the devices are signed. A negative
+// ID returns the latest entries instead, which is where a new follower starts
+func (db *DB) TailAllowedSigningLog(authorization User, afterID, limit int) ([]SigningLog, error) {
+	if limit <= 0 || limit > MaxTailBatch {
+		limit = MaxTailBatch
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		if afterID < 0 {
+			rows, err = db.Query(lastSigningLogSQL, limit)
+		} else {
+			rows, err = db.Query(tailSigningLogSQL, afterID, limit)
+		}
+	case Standard:
+		fallthrough
+	case SyncUser:
+		fallthrough
+	case Admin:
+		if afterID < 0 {
+			rows, err = db.Query(lastSigningLogForUserSQL, authorization.Username, limit)
+		} else {
+			rows, err = db.Query(tailSigningLogForUserSQL, afterID, authorization.Username, limit)
+		}
+	default:
+		return []SigningLog{}, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving signing logs to follow: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	signingLogs := []SigningLog{}
+	for rows.Next() {
+		signingLog := SigningLog{}
+		var details, signer, origin string
+		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.Station, &signingLog.Hash, &details, &signingLog.FallbackKeyID, &signer, &origin)
+		if err != nil {
+			return nil, err
+		}
+		signingLog.Details = decodeSigningLogDetails(details)
+		signingLog.Signer = decodeSigningLogSigner(signer)
+		signingLog.Origin = decodeSigningLogOrigin(origin)
+		signingLogs = append(signingLogs, signingLog)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if afterID < 0 {
+		for i, j := 0, len(signingLogs)-1; i < j; i, j = i+1, j-1 {
+			signingLogs[i], signingLogs[j] = signingLogs[j], signingLogs[i]
+		}
+	}
+	return signingLogs, nil
+}
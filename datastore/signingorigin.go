@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// The fields of the origin of a signing request, which are searched in the origin of the
+// signing log instead of the serial-request details
+const (
+	OriginClientIP    = "client-ip"
+	OriginCountry     = "country"
+	OriginTLSIdentity = "tls-identity"
+)
+
+// SigningOrigin records where a signing request came from: the client IP, the country of
+// the client IP from the GeoIP database and the identity of the TLS client certificate.
+// The fields that are disabled by the privacy controls of the config are empty
+type SigningOrigin struct {
+	ClientIP    string `json:"client-ip,omitempty"`
+	Country     string `json:"country,omitempty"`
+	TLSIdentity string `json:"tls-identity,omitempty"`
+}
+
+// CountryLookup finds the country of an IP address in a GeoIP database, returning the
+// ISO country code or an empty string when the address is not found
+type CountryLookup interface {
+	Country(ip string) string
+}
+
+// IsOriginField checks if a search field is a field of the origin of the signing requests
+func IsOriginField(field string) bool {
+	switch field {
+	case OriginClientIP, OriginCountry, OriginTLSIdentity:
+		return true
+	default:
+		return false
+	}
+}
+
+func encodeSigningLogOrigin(origin *SigningOrigin) string {
+	if origin == nil || *origin == (SigningOrigin{}) {
+		return ""
+	}
+	content, _ := json.Marshal(origin)
+	return string(content)
+}
+
+func decodeSigningLogOrigin(content string) *SigningOrigin {
+	if len(content) == 0 {
+		return nil
+	}
+	origin := SigningOrigin{}
+	if err := json.Unmarshal([]byte(content), &origin); err != nil {
+		log.Printf("Error decoding the signing log origin: %v\n", err)
+		return nil
+	}
+	return &origin
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "errors"
+
+// ListAllowedStations returns the stations of a model that the user is authorized to see
+func (db *DB) ListAllowedStations(modelID int, authorization User) ([]Station, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listStations(modelID)
+	case Admin:
+		return db.listStationsFilteredByUser(modelID, authorization.Username)
+	default:
+		return []Station{}, nil
+	}
+}
+
+// CreateAllowedStation registers a station for a model, if the user is authorized to do it
+func (db *DB) CreateAllowedStation(station Station, authorization User) error {
+	err := validateModelID("Model", station.ModelID)
+	if err != nil {
+		return err
+	}
+
+	err = validateNotEmpty("Station", station.Code)
+	if err != nil {
+		return err
+	}
+
+	// Validate that the user has access to the model
+	model, err := db.GetAllowedModel(station.ModelID, authorization)
+	if err != nil || model.ID == 0 {
+		return errors.New("You do not have permissions to this model")
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		return db.createStation(station)
+	default:
+		return nil
+	}
+}
+
+// DeleteAllowedStation removes a station, if the user is authorized to do it
+func (db *DB) DeleteAllowedStation(stationID int, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.deleteStation(stationID)
+	case Admin:
+		return db.deleteStationFilteredByUser(stationID, authorization.Username)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,190 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+const createStationTableSQL = `
+	CREATE TABLE IF NOT EXISTS station (
+		id               serial primary key not null,
+		model_id         int references model not null,
+		code             varchar(200) not null,
+		description      varchar(200) default ''
+	)
+`
+
+// Indexes
+const createStationUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS station_idx ON station (model_id, code)"
+
+const createStationSQL = "INSERT INTO station (model_id, code, description) VALUES ($1,$2,$3)"
+
+const listStationSQL = `
+	SELECT id, model_id, code, description
+	FROM station
+	WHERE model_id=$1
+	ORDER BY code`
+
+const listStationForUserSQL = `
+	SELECT s.id, s.model_id, s.code, s.description
+	FROM station s
+	INNER JOIN model m ON m.id = s.model_id
+	INNER JOIN account acc ON acc.authority_id=m.brand_id
+	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
+	INNER JOIN userinfo u ON ua.user_id=u.id
+	WHERE s.model_id=$1 AND u.username=$2
+	ORDER BY s.code`
+
+const deleteStationSQL = "DELETE FROM station WHERE id=$1"
+const deleteStationForUserSQL = `
+	DELETE FROM station s
+	USING model m
+	INNER JOIN account acc ON acc.authority_id=m.brand_id
+	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
+	INNER JOIN userinfo u ON ua.user_id=u.id
+	WHERE s.id=$1 AND m.id=s.model_id AND u.username=$2`
+
+const countStationForModelSQL = "SELECT COUNT(*) FROM station WHERE model_id=$1"
+const findStationForModelSQL = "SELECT EXISTS(SELECT * FROM station WHERE model_id=$1 AND code=$2)"
+
+// Station holds the details of a provisioning station (line) that is registered for a model.
+// Serial requests can identify the station, so every signed device is attributed to it.
+type Station struct {
+	ID          int    `json:"id"`
+	ModelID     int    `json:"modelID"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// CreateStationTable creates the database table for a provisioning station
+func (db *DB) CreateStationTable() error {
+	_, err := db.Exec(createStationTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createStationUniqueIndexSQL)
+	return err
+}
+
+// ValidateStation checks the station against the stations registered for the model.
+// A model without registered stations accepts any station, or none, otherwise the
+// request must identify one of the registered stations.
+func (db *DB) ValidateStation(modelID int, code string) error {
+	var count int
+	err := db.QueryRow(countStationForModelSQL, modelID).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting the stations for the model: %v\n", err)
+		return errors.New("Error communicating with the database")
+	}
+	if count == 0 {
+		return nil
+	}
+
+	if len(code) == 0 {
+		return errors.New("The station must be provided for this model")
+	}
+
+	var exists bool
+	err = db.QueryRow(findStationForModelSQL, modelID, code).Scan(&exists)
+	if err != nil {
+		log.Printf("Error checking the station for the model: %v\n", err)
+		return errors.New("Error communicating with the database")
+	}
+	if !exists {
+		return errors.New("The station is not registered for this model")
+	}
+	return nil
+}
+
+func (db *DB) createStation(station Station) error {
+	_, err := db.Exec(createStationSQL, station.ModelID, station.Code, station.Description)
+	if err, ok := err.(*pq.Error); ok {
+		// This is a PostgreSQL error...
+		if err.Code.Name() == "unique_violation" {
+			// Output a more readable message
+			return errors.New("The station is already registered for this model")
+		}
+	}
+	if err != nil {
+		log.Printf("Error creating the database station: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) listStations(modelID int) ([]Station, error) {
+	return db.listStationsFilteredByUser(modelID, anyUserFilter)
+}
+
+func (db *DB) listStationsFilteredByUser(modelID int, username string) ([]Station, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	if len(username) == 0 {
+		rows, err = db.Query(listStationSQL, modelID)
+	} else {
+		rows, err = db.Query(listStationForUserSQL, modelID, username)
+	}
+	if err != nil {
+		log.Printf("Error retrieving stations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	stations := []Station{}
+	for rows.Next() {
+		station := Station{}
+		err := rows.Scan(&station.ID, &station.ModelID, &station.Code, &station.Description)
+		if err != nil {
+			return nil, err
+		}
+		stations = append(stations, station)
+	}
+
+	return stations, nil
+}
+
+func (db *DB) deleteStation(stationID int) error {
+	return db.deleteStationFilteredByUser(stationID, anyUserFilter)
+}
+
+func (db *DB) deleteStationFilteredByUser(stationID int, username string) error {
+	var err error
+
+	if len(username) == 0 {
+		_, err = db.Exec(deleteStationSQL, stationID)
+	} else {
+		_, err = db.Exec(deleteStationForUserSQL, stationID, username)
+	}
+	if err != nil {
+		log.Printf("Error deleting the database station: %v\n", err)
+		return err
+	}
+
+	return nil
+}
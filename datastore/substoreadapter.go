@@ -23,6 +23,8 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+
+	"github.com/CanonicalLtd/serial-vault/config"
 )
 
 var validSerialNumberRegexp = regexp.MustCompile(defaultNicknamePattern)
@@ -43,11 +45,15 @@ func (db *DB) ListSubstores(accountID int, authorization User) ([]Substore, erro
 
 // UpdateAllowedSubstore updates the sub-store if authorization is allowed to do it
 func (db *DB) UpdateAllowedSubstore(store Substore, authorization User) error {
+	store, brandID := db.canonicalSubstore(store)
 
-	_, err := validateSubstore(store, "error-validate-store")
+	_, err := validateSubstore(db.modelNameCase, store, brandID, "error-validate-store")
 	if err != nil {
 		return err
 	}
+	if err := db.validatePivotTarget(store, authorization); err != nil {
+		return err
+	}
 
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
@@ -64,7 +70,8 @@ func (db *DB) UpdateAllowedSubstore(store Substore, authorization User) error {
 // CreateAllowedSubstore creates a new model in case authorization is allowed to do it
 func (db *DB) CreateAllowedSubstore(store Substore, authorization User) error {
 	// Validate the substore record
-	_, err := validateSubstore(store, "")
+	store, brandID := db.canonicalSubstore(store)
+	_, err := validateSubstore(db.modelNameCase, store, brandID, "")
 	if err != nil {
 		return err
 	}
@@ -74,6 +81,9 @@ func (db *DB) CreateAllowedSubstore(store Substore, authorization User) error {
 	if err != nil || acc.ID == 0 {
 		return errors.New("You do not have permissions to this account")
 	}
+	if err := db.validatePivotTarget(store, authorization); err != nil {
+		return err
+	}
 
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
@@ -87,6 +97,52 @@ func (db *DB) CreateAllowedSubstore(store Substore, authorization User) error {
 	}
 }
 
+// CreateAllowedSubstores creates the sub-store mappings of a bulk import, in case authorization
+// is allowed to do it. Either all the mappings are created, or none of them
+func (db *DB) CreateAllowedSubstores(stores []Substore, authorization User) error {
+	accounts := map[int]bool{}
+	brands := map[int]string{}
+	for i, store := range stores {
+		brandID, ok := brands[store.AccountID]
+		if !ok {
+			account, _ := db.getAccountByID(store.AccountID)
+			brandID = account.AuthorityID
+			brands[store.AccountID] = brandID
+		}
+		store.ModelName = CanonicalModelName(db.modelNameCase, brandID, store.ModelName)
+		stores[i] = store
+
+		_, err := validateSubstore(db.modelNameCase, store, brandID, "")
+		if err != nil {
+			return err
+		}
+		if err := db.validatePivotTarget(store, authorization); err != nil {
+			return err
+		}
+
+		// Validate that the user has access to the account
+		if accounts[store.AccountID] {
+			continue
+		}
+		acc, err := db.GetAccountByID(store.AccountID, authorization)
+		if err != nil || acc.ID == 0 {
+			return errors.New("You do not have permissions to this account")
+		}
+		accounts[store.AccountID] = true
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		return db.createSubstores(stores)
+	default:
+		return nil
+	}
+}
+
 // DeleteAllowedSubstore deletes sub-store model if allowed to authorization
 func (db *DB) DeleteAllowedSubstore(storeID int, authorization User) (string, error) {
 	switch authorization.Role {
@@ -101,7 +157,44 @@ func (db *DB) DeleteAllowedSubstore(storeID int, authorization User) (string, er
 	}
 }
 
-func validateSubstore(store Substore, validateStoreLabel string) (string, error) {
+// canonicalSubstore returns the sub-store with the canonical model name for the brand of its
+// account, and the brand. The account is checked by the caller, so a missing account is ignored
+func (db *DB) canonicalSubstore(store Substore) (Substore, string) {
+	account, _ := db.getAccountByID(store.AccountID)
+	store.ModelName = CanonicalModelName(db.modelNameCase, account.AuthorityID, store.ModelName)
+	return store, account.AuthorityID
+}
+
+// validatePivotTarget checks a sub-store that pivots the devices to another brand: the brand of
+// the account must have the model that they are pivoted to, and the user must have access to the
+// model they are pivoted from, so that a brand cannot take over the devices of another brand
+func (db *DB) validatePivotTarget(store Substore, authorization User) error {
+	fromModel, err := db.getModel(store.FromModelID)
+	if err != nil {
+		return errors.New("Cannot find the model that the sub-store is pivoted from")
+	}
+	store.FromModel = fromModel
+
+	_, crossAuthority, err := db.PivotTarget(store)
+	if !crossAuthority {
+		// A missing account is reported by the account checks
+		return nil
+	}
+	if err == ErrPivotTargetNotFound {
+		return fmt.Errorf("The brand of the account must have the model '%s' to pivot the devices to another brand", store.ModelName)
+	}
+	if err != nil {
+		return err
+	}
+
+	model, err := db.GetAllowedModel(store.FromModelID, authorization)
+	if err != nil || model.ID == 0 {
+		return errors.New("You do not have permissions to the model that the sub-store is pivoted from")
+	}
+	return nil
+}
+
+func validateSubstore(modelNameCase []config.ModelNameCase, store Substore, brandID, validateStoreLabel string) (string, error) {
 
 	err := validateModelID("From Model", store.FromModelID)
 	if err != nil {
@@ -118,7 +211,11 @@ func validateSubstore(store Substore, validateStoreLabel string) (string, error)
 		return validateStoreLabel, err
 	}
 
-	err = validateModelName(store.ModelName)
+	if _, err = parseSerialPattern(store.SerialNumber); err != nil {
+		return validateStoreLabel, err
+	}
+
+	err = validateBrandModelName(modelNameCase, brandID, store.ModelName)
 	if err != nil {
 		return validateStoreLabel, err
 	}
@@ -0,0 +1,324 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// MaxSubstoreImport is the maximum number of serial numbers in an import of sub-store mappings
+const MaxSubstoreImport = 50000
+
+// SerialRangeSeparator separates the first and last serial number of a range, e.g. A0001..A0100
+const SerialRangeSeparator = ".."
+
+// substoreCSVHeader is the header of the CSV file of sub-store mappings
+var substoreCSVHeader = []string{"model", "store", "serialnumber", "modelname"}
+
+// SubstoreImportEntry is a row of the CSV import of sub-store mappings. The serial number
+// may be a range of serial numbers that share a prefix and have a fixed number of digits
+type SubstoreImportEntry struct {
+	Line         int    `json:"line"`
+	Model        string `json:"model"` // the name of the model that is pivoted
+	Store        string `json:"store"`
+	SerialNumber string `json:"serialnumber"`
+	ModelName    string `json:"modelname"`
+}
+
+// SubstoreImportConflict is a serial number of the import that is mapped to a different
+// sub-store model, either by another row of the import or by an existing mapping
+type SubstoreImportConflict struct {
+	Line         int    `json:"line"`
+	SerialNumber string `json:"serialnumber"`
+	Message      string `json:"message"`
+}
+
+// SubstoreImportResult summarizes the import of the sub-store mappings
+type SubstoreImportResult struct {
+	Imported   int                      `json:"imported"`
+	Duplicates int                      `json:"duplicates"`
+	Conflicts  []SubstoreImportConflict `json:"conflicts"`
+}
+
+// ParseSubstoreCSV reads the sub-store mappings of a CSV file with the columns:
+// model, store, serialnumber, modelname
+func ParseSubstoreCSV(r io.Reader) ([]SubstoreImportEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(substoreCSVHeader)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("The sub-store import is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the sub-store import: %v", err)
+	}
+	for i := range substoreCSVHeader {
+		if strings.ToLower(strings.TrimSpace(header[i])) != substoreCSVHeader[i] {
+			return nil, fmt.Errorf("The sub-store import must have the header: %s", strings.Join(substoreCSVHeader, ","))
+		}
+	}
+
+	entries := []SubstoreImportEntry{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the sub-store import: %v", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		entries = append(entries, SubstoreImportEntry{
+			Line:         line,
+			Model:        strings.TrimSpace(record[0]),
+			Store:        strings.TrimSpace(record[1]),
+			SerialNumber: strings.TrimSpace(record[2]),
+			ModelName:    strings.TrimSpace(record[3]),
+		})
+	}
+	return entries, nil
+}
+
+// ExpandSerialRange returns the serial numbers of a range, e.g. A0001..A0100. A serial
+// number that is not a range is returned as it is
+func ExpandSerialRange(serial string, max int) ([]string, error) {
+	if SerialPatternKind(serial) != SerialRange {
+		return []string{serial}, nil
+	}
+
+	p, err := parseSerialPattern(serial)
+	if err != nil {
+		return nil, err
+	}
+	if p.to-p.from >= uint64(max) {
+		return nil, fmt.Errorf("The serial range '%s' must not have more than %d serial numbers", serial, max)
+	}
+
+	serials := make([]string, 0, p.to-p.from+1)
+	for n := p.from; n <= p.to; n++ {
+		serials = append(serials, fmt.Sprintf("%s%0*d", p.prefix, p.width, n))
+	}
+	return serials, nil
+}
+
+// splitSerialNumber splits the trailing digits from the prefix of a serial number
+func splitSerialNumber(serial string) (string, string) {
+	i := len(serial)
+	for i > 0 && serial[i-1] >= '0' && serial[i-1] <= '9' {
+		i--
+	}
+	return serial[:i], serial[i:]
+}
+
+// CompactSerialRanges sorts the serial numbers, combining the consecutive serial numbers
+// into ranges. The serial patterns are kept as they are
+func CompactSerialRanges(serials []string) []string {
+	sorted := make([]string, len(serials))
+	copy(sorted, serials)
+	sort.Strings(sorted)
+
+	compacted := []string{}
+	for i := 0; i < len(sorted); {
+		prefix, digits := splitSerialNumber(sorted[i])
+		n, err := strconv.ParseUint(digits, 10, 64)
+		if len(digits) == 0 || err != nil || IsSerialPattern(sorted[i]) {
+			compacted = append(compacted, sorted[i])
+			i++
+			continue
+		}
+
+		// The serial numbers of a range are in order, as they have the same number of digits
+		j := i + 1
+		for ; j < len(sorted); j++ {
+			p, d := splitSerialNumber(sorted[j])
+			next, err := strconv.ParseUint(d, 10, 64)
+			if p != prefix || len(d) != len(digits) || err != nil || next != n+uint64(j-i) || IsSerialPattern(sorted[j]) {
+				break
+			}
+		}
+
+		if j-i == 1 {
+			compacted = append(compacted, sorted[i])
+		} else {
+			compacted = append(compacted, sorted[i]+SerialRangeSeparator+sorted[j-1])
+		}
+		i = j
+	}
+	return compacted
+}
+
+// WriteSubstoreCSV writes the sub-store mappings as a CSV file that can be imported,
+// combining the consecutive serial numbers of a sub-store model into ranges
+func WriteSubstoreCSV(w io.Writer, stores []Substore) error {
+	type target struct {
+		model, store, modelName string
+	}
+
+	serials := map[target][]string{}
+	for _, s := range stores {
+		t := target{s.FromModel.Name, s.Store, s.ModelName}
+		serials[t] = append(serials[t], s.SerialNumber)
+	}
+
+	targets := make([]target, 0, len(serials))
+	for t := range serials {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].model != targets[j].model {
+			return targets[i].model < targets[j].model
+		}
+		if targets[i].store != targets[j].store {
+			return targets[i].store < targets[j].store
+		}
+		return targets[i].modelName < targets[j].modelName
+	})
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(substoreCSVHeader); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		for _, serial := range CompactSerialRanges(serials[t]) {
+			if err := writer.Write([]string{t.model, t.store, serial, t.modelName}); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportSubstores creates the sub-store mappings of an import for the models of the account.
+// The mappings that already exist are skipped. Nothing is created when a serial number is
+// mapped to a different sub-store model, by another row or by an existing mapping
+func ImportSubstores(db Datastore, modelNameCase []config.ModelNameCase, account Account, entries []SubstoreImportEntry, authorization User) (SubstoreImportResult, error) {
+	result := SubstoreImportResult{Conflicts: []SubstoreImportConflict{}}
+	if len(entries) == 0 {
+		return result, errors.New("The sub-store import has no mappings")
+	}
+
+	allowed, err := db.ListAllowedModels(authorization)
+	if err != nil {
+		return result, err
+	}
+	models := map[string]Model{}
+	for _, m := range allowed {
+		if m.BrandID == account.AuthorityID {
+			models[m.Name] = m
+		}
+	}
+
+	type mapping struct {
+		fromModelID int
+		serial      string
+	}
+
+	existing, err := db.ListSubstores(account.ID, authorization)
+	if err != nil {
+		return result, err
+	}
+	mapped := map[mapping]Substore{}
+	for _, s := range existing {
+		mapped[mapping{s.FromModelID, s.SerialNumber}] = s
+	}
+
+	imported := map[mapping]SubstoreImportEntry{}
+	stores := []Substore{}
+	for _, e := range entries {
+		if !validateStringsNotEmpty(e.Model, e.Store, e.SerialNumber, e.ModelName) {
+			return result, fmt.Errorf("Line %d: the model, store, serial number and model name must be entered", e.Line)
+		}
+		e.Model = CanonicalModelName(modelNameCase, account.AuthorityID, e.Model)
+		e.ModelName = CanonicalModelName(modelNameCase, account.AuthorityID, e.ModelName)
+		if err := validateBrandModelName(modelNameCase, account.AuthorityID, e.ModelName); err != nil {
+			return result, fmt.Errorf("Line %d: %v", e.Line, err)
+		}
+		model, ok := models[e.Model]
+		if !ok {
+			return result, fmt.Errorf("Line %d: the model '%s' is not a model of the account", e.Line, e.Model)
+		}
+
+		// A range is imported as its serial numbers, and a glob or regular expression as a
+		// single mapping
+		serials, err := ExpandSerialRange(e.SerialNumber, MaxSubstoreImport)
+		if err != nil {
+			return result, fmt.Errorf("Line %d: %v", e.Line, err)
+		}
+		if _, err := parseSerialPattern(serials[0]); err != nil {
+			return result, fmt.Errorf("Line %d: %v", e.Line, err)
+		}
+
+		for _, serial := range serials {
+			key := mapping{model.ID, serial}
+
+			if other, ok := imported[key]; ok {
+				if other.Store != e.Store || other.ModelName != e.ModelName {
+					result.Conflicts = append(result.Conflicts, SubstoreImportConflict{
+						Line: e.Line, SerialNumber: serial,
+						Message: fmt.Sprintf("Overlaps line %d, which maps it to '%s' in sub-store '%s'", other.Line, other.ModelName, other.Store),
+					})
+					continue
+				}
+				result.Duplicates++
+				continue
+			}
+			imported[key] = e
+
+			if s, ok := mapped[key]; ok {
+				if s.Store != e.Store || s.ModelName != e.ModelName {
+					result.Conflicts = append(result.Conflicts, SubstoreImportConflict{
+						Line: e.Line, SerialNumber: serial,
+						Message: fmt.Sprintf("Already mapped to '%s' in sub-store '%s'", s.ModelName, s.Store),
+					})
+					continue
+				}
+				result.Duplicates++
+				continue
+			}
+
+			stores = append(stores, Substore{AccountID: account.ID, FromModelID: model.ID, Store: e.Store, SerialNumber: serial, ModelName: e.ModelName})
+			if len(stores) > MaxSubstoreImport {
+				return result, fmt.Errorf("The sub-store import must not have more than %d serial numbers", MaxSubstoreImport)
+			}
+		}
+	}
+
+	if len(result.Conflicts) > 0 || len(stores) == 0 {
+		return result, nil
+	}
+
+	if err := db.CreateAllowedSubstores(stores, authorization); err != nil {
+		return result, err
+	}
+	result.Imported = len(stores)
+	return result, nil
+}
@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandSerialRange(t *testing.T) {
+	tests := []struct {
+		serial  string
+		serials []string
+		err     bool
+	}{
+		{"abc1234", []string{"abc1234"}, false},
+		{"A0098..A0101", []string{"A0098", "A0099", "A0100", "A0101"}, false},
+		{"A01..A01", []string{"A01"}, false},
+		{"A01..B02", nil, true},
+		{"A01..A002", nil, true},
+		{"A..A", nil, true},
+		{"A05..A01", nil, true},
+		{"A01..A05..A09", nil, true},
+		{"A0001..A9999", nil, true},
+	}
+
+	for _, tt := range tests {
+		serials, err := ExpandSerialRange(tt.serial, 100)
+		if (err != nil) != tt.err {
+			t.Errorf("ExpandSerialRange(%s) error = %v, expected error %v", tt.serial, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(serials, tt.serials) {
+			t.Errorf("ExpandSerialRange(%s) = %v, expected %v", tt.serial, serials, tt.serials)
+		}
+	}
+}
+
+func TestCompactSerialRanges(t *testing.T) {
+	serials := []string{"A0003", "xyz", "A0001", "A0002", "A0005", "B0006", "B0007", "A10"}
+	expected := []string{"A0001..A0003", "A0005", "A10", "B0006..B0007", "xyz"}
+
+	if compacted := CompactSerialRanges(serials); !reflect.DeepEqual(compacted, expected) {
+		t.Errorf("CompactSerialRanges() = %v, expected %v", compacted, expected)
+	}
+}
+
+func TestParseSubstoreCSV(t *testing.T) {
+	entries, err := ParseSubstoreCSV(strings.NewReader("model,store,serialnumber,modelname\nalder, mybrand ,A0001..A0100,alder-mybrand\n\nash,other,B1,ash-other\n"))
+	if err != nil {
+		t.Fatalf("Error parsing the sub-store CSV: %v", err)
+	}
+	expected := []SubstoreImportEntry{
+		{Line: 2, Model: "alder", Store: "mybrand", SerialNumber: "A0001..A0100", ModelName: "alder-mybrand"},
+		{Line: 4, Model: "ash", Store: "other", SerialNumber: "B1", ModelName: "ash-other"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("ParseSubstoreCSV() = %v, expected %v", entries, expected)
+	}
+
+	for _, data := range []string{"", "brand,store,serialnumber,modelname\n", "model,store,serialnumber,modelname\nalder,mybrand,A1\n"} {
+		if _, err := ParseSubstoreCSV(strings.NewReader(data)); err == nil {
+			t.Errorf("ParseSubstoreCSV(%q) expected an error", data)
+		}
+	}
+}
+
+func TestImportSubstores(t *testing.T) {
+	db := &MockDB{}
+	account := Account{ID: 1, AuthorityID: "system"}
+	admin := User{Username: "sv", Role: Admin}
+
+	tests := []struct {
+		entries    []SubstoreImportEntry
+		imported   int
+		duplicates int
+		conflicts  int
+		err        bool
+	}{
+		{[]SubstoreImportEntry{
+			{Line: 2, Model: "alder", Store: "mybrand", SerialNumber: "abc1233..abc1235", ModelName: "alder-mybrand"},
+			{Line: 3, Model: "ash", Store: "mybrand", SerialNumber: "abc1234", ModelName: "ash-mybrand"},
+		}, 3, 1, 0, false},
+		{[]SubstoreImportEntry{
+			{Line: 2, Model: "alder", Store: "mybrand", SerialNumber: "X01..X10", ModelName: "alder-mybrand"},
+			{Line: 3, Model: "alder", Store: "mybrand", SerialNumber: "X05..X12", ModelName: "alder-other"},
+		}, 0, 0, 6, false},
+		{[]SubstoreImportEntry{
+			{Line: 2, Model: "alder", Store: "mybrand", SerialNumber: "X01..X10", ModelName: "alder-mybrand"},
+			{Line: 3, Model: "alder", Store: "mybrand", SerialNumber: "X05..X12", ModelName: "alder-mybrand"},
+		}, 12, 6, 0, false},
+		{[]SubstoreImportEntry{
+			{Line: 2, Model: "alder", Store: "otherstore", SerialNumber: "abc5678", ModelName: "alder-mybrand"},
+		}, 0, 0, 1, false},
+		{[]SubstoreImportEntry{
+			{Line: 2, Model: "unknown", Store: "mybrand", SerialNumber: "X01", ModelName: "alder-mybrand"},
+		}, 0, 0, 0, true},
+		{[]SubstoreImportEntry{
+			{Line: 2, Model: "alder", Store: "", SerialNumber: "X01", ModelName: "alder-mybrand"},
+		}, 0, 0, 0, true},
+		{[]SubstoreImportEntry{}, 0, 0, 0, true},
+	}
+
+	for i, tt := range tests {
+		result, err := ImportSubstores(db, nil, account, tt.entries, admin)
+		if (err != nil) != tt.err {
+			t.Errorf("%d: ImportSubstores() error = %v, expected error %v", i, err, tt.err)
+			continue
+		}
+		if result.Imported != tt.imported || result.Duplicates != tt.duplicates || len(result.Conflicts) != tt.conflicts {
+			t.Errorf("%d: ImportSubstores() = %d imported, %d duplicates, %d conflicts, expected %d, %d, %d", i, result.Imported, result.Duplicates, len(result.Conflicts), tt.imported, tt.duplicates, tt.conflicts)
+		}
+	}
+
+	_, err := ImportSubstores(&ErrorMockDB{}, nil, account, tests[0].entries, admin)
+	if err == nil {
+		t.Error("ImportSubstores() expected an error")
+	}
+}
+
+func TestWriteSubstoreCSV(t *testing.T) {
+	alder := Model{ID: 1, Name: "alder"}
+	stores := []Substore{
+		{FromModelID: 1, FromModel: alder, Store: "mybrand", SerialNumber: "A0002", ModelName: "alder-mybrand"},
+		{FromModelID: 1, FromModel: alder, Store: "mybrand", SerialNumber: "A0001", ModelName: "alder-mybrand"},
+		{FromModelID: 1, FromModel: alder, Store: "mybrand", SerialNumber: "A0003", ModelName: "alder-other"},
+	}
+
+	var b bytes.Buffer
+	if err := WriteSubstoreCSV(&b, stores); err != nil {
+		t.Fatalf("Error writing the sub-store CSV: %v", err)
+	}
+
+	expected := "model,store,serialnumber,modelname\nalder,mybrand,A0001..A0002,alder-mybrand\nalder,mybrand,A0003,alder-other\n"
+	if b.String() != expected {
+		t.Errorf("WriteSubstoreCSV() = %q, expected %q", b.String(), expected)
+	}
+
+	// The export can be imported again
+	entries, err := ParseSubstoreCSV(&b)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("ParseSubstoreCSV() of the export = %v, %v", entries, err)
+	}
+}
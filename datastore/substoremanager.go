@@ -23,6 +23,7 @@ package datastore
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 
 	"github.com/lib/pq"
@@ -35,10 +36,14 @@ const createSubstoreTableSQL = `
 		from_model_id    int references model not null,
 		store            varchar(200) not null,
 		serial_number    varchar(200) not null,
-		model_name       varchar(200) not null
+		model_name       varchar(200) not null,
+		pattern          bool not null default false
 	)
 `
 
+// Add the pattern field to indicate that the serial number is a range, glob or regular expression
+const alterSubstorePatternSQL = "alter table substore add column pattern bool not null default false"
+
 // Indexes
 const createSubstoreUniqueIndexSQL = `
 	CREATE UNIQUE INDEX IF NOT EXISTS substore_idx ON substore 
@@ -46,19 +51,32 @@ const createSubstoreUniqueIndexSQL = `
 
 const createSubstoreSQL = `
 	INSERT INTO substore 
-	(account_id, from_model_id, store, serial_number, model_name) 
-	VALUES ($1,$2,$3,$4,$5)`
+	(account_id, from_model_id, store, serial_number, model_name, pattern) 
+	VALUES ($1,$2,$3,$4,$5,$6)`
 
 const getSubstoreSQL = `
 	SELECT id, account_id, from_model_id, store, serial_number, model_name 
 	FROM substore 
-	WHERE from_model_id=$1 AND serial_number=$2`
+	WHERE from_model_id=$1 AND serial_number=$2 AND NOT pattern`
+
+const listSubstorePatternSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name 
+	FROM substore 
+	WHERE from_model_id=$1 AND pattern`
 
 const getSubstoreModelSQL = `
 	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name 
 	FROM substore s
 	INNER JOIN model m ON m.id = s.from_model_id
-	WHERE m.brand_id=$1 AND s.model_name=$2 AND s.serial_number=$3`
+	INNER JOIN account a ON a.id = s.account_id
+	WHERE (m.brand_id=$1 OR a.authority_id=$1) AND s.model_name=$2 AND s.serial_number=$3 AND NOT s.pattern`
+
+const listSubstoreModelPatternSQL = `
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name 
+	FROM substore s
+	INNER JOIN model m ON m.id = s.from_model_id
+	INNER JOIN account a ON a.id = s.account_id
+	WHERE (m.brand_id=$1 OR a.authority_id=$1) AND s.model_name=$2 AND s.pattern`
 
 const listSubstoreSQL = `
 	SELECT id, account_id, from_model_id, store, serial_number, model_name 
@@ -74,14 +92,14 @@ const listUserSubstoreSQL = `
 `
 const updateSubstoreSQL = `
 	UPDATE substore 
-	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6 
+	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6, pattern=$7 
 	WHERE id=$1`
 const updateSubstoreForUserSQL = `
 	UPDATE substore s 
-	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6 
+	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6, pattern=$7 
 	FROM useraccountlink ua
 	INNER JOIN userinfo u ON ua.user_id=u.id
-	WHERE s.id=$1 AND u.username=$7
+	WHERE s.id=$1 AND u.username=$8
 	AND ua.account_id=s.account_id`
 
 const deleteSubstoreSQL = "delete from substore where id=$1"
@@ -92,6 +110,10 @@ const deleteSubstoreForUserSQL = `
 		INNER JOIN userinfo u ON ua.user_id=u.id
 		WHERE s.id=$1 AND acc.id=s.account_id AND u.username=$2`
 
+// ErrPivotTargetNotFound is returned when the brand of a sub-store account has no model with the
+// sub-store model name, so a device cannot be pivoted to another authority
+var ErrPivotTargetNotFound = errors.New("Cannot find the model of the sub-store brand that the device is pivoted to")
+
 // Substore holds the substore details for an account in the local database
 type Substore struct {
 	ID           int    `json:"id"`
@@ -114,9 +136,15 @@ func (db *DB) CreateSubstoreTable() error {
 	return err
 }
 
+// AlterSubstoreTable modifies the database table for a sub-store
+func (db *DB) AlterSubstoreTable() error {
+	db.Exec(alterSubstorePatternSQL)
+	return nil
+}
+
 // createSubstore creates a sub-store in the database
 func (db *DB) createSubstore(store Substore) error {
-	_, err := db.Exec(createSubstoreSQL, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName)
+	_, err := db.Exec(createSubstoreSQL, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, IsSerialPattern(store.SerialNumber))
 	if err, ok := err.(*pq.Error); ok {
 		// This is a PostgreSQL error...
 		if err.Code.Name() == "unique_violation" {
@@ -131,7 +159,28 @@ func (db *DB) createSubstore(store Substore) error {
 	return nil
 }
 
-// GetSubstore fetches a sub-store in the database
+// createSubstores creates the sub-stores in the database in a single transaction
+func (db *DB) createSubstores(stores []Substore) error {
+	err := db.transaction(func(tx *sql.Tx) error {
+		for _, store := range stores {
+			_, err := tx.Exec(createSubstoreSQL, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, IsSerialPattern(store.SerialNumber))
+			if err, ok := err.(*pq.Error); ok && err.Code.Name() == "unique_violation" {
+				return fmt.Errorf("A sub-store mapping already exists for serial-number '%s' and sub-store '%s'", store.SerialNumber, store.Store)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error creating the database sub-stores: %v\n", err)
+	}
+	return err
+}
+
+// GetSubstore fetches a sub-store in the database. When there is no sub-store for the exact
+// serial number, the serial ranges and patterns of the model are matched
 func (db *DB) GetSubstore(fromModelID int, serialNumber string) (Substore, error) {
 	store := Substore{}
 
@@ -139,6 +188,9 @@ func (db *DB) GetSubstore(fromModelID int, serialNumber string) (Substore, error
 
 	row = db.QueryRow(getSubstoreSQL, fromModelID, serialNumber)
 	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName)
+	if err == sql.ErrNoRows {
+		return db.matchSubstorePattern(serialNumber, listSubstorePatternSQL, fromModelID)
+	}
 	if err != nil {
 		log.Printf("Error retrieving database model by ID: %v\n", err)
 		return store, err
@@ -153,12 +205,18 @@ func (db *DB) GetSubstore(fromModelID int, serialNumber string) (Substore, error
 	return store, nil
 }
 
-// GetSubstoreModel fetches a sub-store in the database using the pivoted model name
+// GetSubstoreModel fetches a sub-store in the database using the pivoted model name. When
+// there is no sub-store for the exact serial number, the serial ranges and patterns are matched
 func (db *DB) GetSubstoreModel(brand, model, serialNumber string) (Substore, error) {
 	store := Substore{}
+	brand = CanonicalBrandID(brand)
+	model = CanonicalModelName(db.modelNameCase, brand, model)
 
 	row := db.QueryRow(getSubstoreModelSQL, brand, model, serialNumber)
 	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName)
+	if err == sql.ErrNoRows {
+		return db.matchSubstorePattern(serialNumber, listSubstoreModelPatternSQL, brand, model)
+	}
 	if err != nil {
 		log.Printf("Error retrieving database model by ID: %v\n", err)
 		return store, err
@@ -173,6 +231,50 @@ func (db *DB) GetSubstoreModel(brand, model, serialNumber string) (Substore, err
 	return store, nil
 }
 
+// PivotTarget returns the model that a device is pivoted to when the account of the sub-store is
+// another brand than the model it was pivoted from: the model of the account brand with the
+// sub-store model name, which signs the assertions of the pivoted device. A sub-store of the same
+// brand has no target model, as the model it was pivoted from signs for the device
+func (db *DB) PivotTarget(store Substore) (Model, bool, error) {
+	account, err := db.getAccountByID(store.AccountID)
+	if err != nil {
+		return Model{}, false, err
+	}
+
+	brandID := CanonicalBrandID(account.AuthorityID)
+	if brandID == store.FromModel.BrandID {
+		return Model{}, false, nil
+	}
+
+	model, err := db.findModelByName(brandID, store.ModelName)
+	if err == sql.ErrNoRows {
+		return Model{}, true, ErrPivotTargetNotFound
+	}
+	return model, true, err
+}
+
+// matchSubstorePattern finds the sub-store with the serial range or pattern that takes
+// precedence for the serial number
+func (db *DB) matchSubstorePattern(serialNumber, query string, args ...interface{}) (Substore, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error retrieving the sub-store patterns: %v\n", err)
+		return Substore{}, err
+	}
+	defer rows.Close()
+
+	stores, err := db.rowsToSubstores(rows)
+	if err != nil {
+		return Substore{}, err
+	}
+
+	store, ok := MatchSubstore(stores, serialNumber)
+	if !ok {
+		return Substore{}, sql.ErrNoRows
+	}
+	return store, nil
+}
+
 // HealthCheck returns an error if there is a problem talking to the underlying Datastore
 func (db *DB) HealthCheck() error {
 	_, err := db.Exec("select 1;")
@@ -253,9 +355,9 @@ func (db *DB) updateSubstoreFilteredByUser(store Substore, username string) erro
 	var err error
 
 	if len(username) == 0 {
-		_, err = db.Exec(updateSubstoreSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName)
+		_, err = db.Exec(updateSubstoreSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, IsSerialPattern(store.SerialNumber))
 	} else {
-		_, err = db.Exec(updateSubstoreForUserSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, username)
+		_, err = db.Exec(updateSubstoreForUserSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, IsSerialPattern(store.SerialNumber), username)
 	}
 	if err, ok := err.(*pq.Error); ok {
 		// This is a PostgreSQL error...
@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of serial number of a sub-store mapping, in the order of precedence when more than
+// one mapping matches the serial number of a device
+const (
+	SerialExact  = iota // a single serial number, e.g. A0001
+	SerialRange         // a range of serial numbers, e.g. A0001..A0100
+	SerialGlob          // a glob pattern, e.g. A01*
+	SerialRegexp        // a regular expression between slashes, e.g. /^A0[0-9]{3}$/
+)
+
+// serialPattern is the parsed serial number of a sub-store mapping
+type serialPattern struct {
+	kind     int
+	value    string
+	prefix   string
+	width    int
+	from, to uint64
+	re       *regexp.Regexp
+}
+
+// SerialPatternKind returns the kind of serial number of a sub-store mapping
+func SerialPatternKind(serial string) int {
+	switch {
+	case len(serial) > 1 && strings.HasPrefix(serial, "/") && strings.HasSuffix(serial, "/"):
+		return SerialRegexp
+	case strings.Contains(serial, SerialRangeSeparator):
+		return SerialRange
+	case strings.ContainsAny(serial, "*?["):
+		return SerialGlob
+	default:
+		return SerialExact
+	}
+}
+
+// IsSerialPattern checks if the serial number of a sub-store mapping matches more than one device
+func IsSerialPattern(serial string) bool {
+	return SerialPatternKind(serial) != SerialExact
+}
+
+// parseSerialPattern validates the serial number of a sub-store mapping
+func parseSerialPattern(serial string) (serialPattern, error) {
+	p := serialPattern{kind: SerialPatternKind(serial), value: serial}
+
+	switch p.kind {
+	case SerialRange:
+		bounds := strings.Split(serial, SerialRangeSeparator)
+		if len(bounds) != 2 {
+			return p, fmt.Errorf("The serial range '%s' must have a first and last serial number", serial)
+		}
+		prefix, first := splitSerialNumber(bounds[0])
+		lastPrefix, last := splitSerialNumber(bounds[1])
+		if len(first) == 0 || prefix != lastPrefix || len(first) != len(last) {
+			return p, fmt.Errorf("The serial range '%s' must have bounds with the same prefix and number of digits", serial)
+		}
+
+		var err error
+		if p.from, err = strconv.ParseUint(first, 10, 64); err != nil {
+			return p, fmt.Errorf("The serial range '%s' is invalid: %v", serial, err)
+		}
+		if p.to, err = strconv.ParseUint(last, 10, 64); err != nil {
+			return p, fmt.Errorf("The serial range '%s' is invalid: %v", serial, err)
+		}
+		if p.to < p.from {
+			return p, fmt.Errorf("The serial range '%s' must not end before it starts", serial)
+		}
+		p.prefix, p.width = prefix, len(first)
+
+	case SerialGlob:
+		if _, err := path.Match(serial, ""); err != nil {
+			return p, fmt.Errorf("The serial pattern '%s' is invalid: %v", serial, err)
+		}
+
+	case SerialRegexp:
+		// The expression must match the whole serial number
+		re, err := regexp.Compile("^(?:" + serial[1:len(serial)-1] + ")$")
+		if err != nil {
+			return p, fmt.Errorf("The serial expression '%s' is invalid: %v", serial, err)
+		}
+		p.re = re
+	}
+
+	return p, nil
+}
+
+// match checks if the serial number of a device matches the serial number of the mapping
+func (p serialPattern) match(serial string) bool {
+	switch p.kind {
+	case SerialRange:
+		prefix, digits := splitSerialNumber(serial)
+		if prefix != p.prefix || len(digits) != p.width {
+			return false
+		}
+		n, err := strconv.ParseUint(digits, 10, 64)
+		return err == nil && n >= p.from && n <= p.to
+	case SerialGlob:
+		matched, _ := path.Match(p.value, serial)
+		return matched
+	case SerialRegexp:
+		return p.re.MatchString(serial)
+	default:
+		return p.value == serial
+	}
+}
+
+// specificity orders the mappings of the same kind, the most specific first: the range with
+// fewer serial numbers, or the glob pattern with more literal characters
+func (p serialPattern) specificity() int {
+	switch p.kind {
+	case SerialRange:
+		return int(p.to - p.from)
+	case SerialGlob:
+		return -len(strings.Trim(p.value, "*?"))
+	default:
+		return 0
+	}
+}
+
+// MatchSubstore finds the sub-store mapping for the serial number of a device. When more
+// than one mapping matches, an exact serial number takes precedence over a range, a range
+// over a glob pattern, and a glob pattern over a regular expression. Between mappings of
+// the same kind, the narrower range or the glob with more literal characters wins, and
+// then the mapping that was created first
+func MatchSubstore(stores []Substore, serialNumber string) (Substore, bool) {
+	type candidate struct {
+		store   Substore
+		pattern serialPattern
+	}
+
+	matches := []candidate{}
+	for _, s := range stores {
+		p, err := parseSerialPattern(s.SerialNumber)
+		if err != nil || !p.match(serialNumber) {
+			continue
+		}
+		matches = append(matches, candidate{s, p})
+	}
+	if len(matches) == 0 {
+		return Substore{}, false
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i].pattern, matches[j].pattern
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.specificity() != b.specificity() {
+			return a.specificity() < b.specificity()
+		}
+		return matches[i].store.ID < matches[j].store.ID
+	})
+	return matches[0].store, true
+}
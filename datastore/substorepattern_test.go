@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "testing"
+
+func TestSerialPatternKind(t *testing.T) {
+	tests := []struct {
+		serial string
+		kind   int
+	}{
+		{"A0001", SerialExact},
+		{"A0001..A0100", SerialRange},
+		{"A01*", SerialGlob},
+		{"A0?", SerialGlob},
+		{"A[0-3]", SerialGlob},
+		{"/^A0[0-9]{3}$/", SerialRegexp},
+		{"/A..B/", SerialRegexp},
+		{"/", SerialExact},
+	}
+
+	for _, tt := range tests {
+		if kind := SerialPatternKind(tt.serial); kind != tt.kind {
+			t.Errorf("SerialPatternKind(%s) = %d, expected %d", tt.serial, kind, tt.kind)
+		}
+	}
+}
+
+func TestParseSerialPattern(t *testing.T) {
+	for _, serial := range []string{"A0001", "A0001..A0100", "A01*", "/A0[0-9]+/"} {
+		if _, err := parseSerialPattern(serial); err != nil {
+			t.Errorf("parseSerialPattern(%s) unexpected error: %v", serial, err)
+		}
+	}
+	for _, serial := range []string{"A01..B02", "A0100..A0001", "A[0-", "/A(/"} {
+		if _, err := parseSerialPattern(serial); err == nil {
+			t.Errorf("parseSerialPattern(%s) expected an error", serial)
+		}
+	}
+}
+
+func TestMatchSubstore(t *testing.T) {
+	stores := []Substore{
+		{ID: 1, SerialNumber: "/A.*/", ModelName: "regexp"},
+		{ID: 2, SerialNumber: "A0*", ModelName: "glob"},
+		{ID: 3, SerialNumber: "A00*", ModelName: "narrow-glob"},
+		{ID: 4, SerialNumber: "A0001..A0999", ModelName: "range"},
+		{ID: 5, SerialNumber: "A0001..A0099", ModelName: "narrow-range"},
+		{ID: 6, SerialNumber: "A0050", ModelName: "exact"},
+		{ID: 7, SerialNumber: "A0001..A0099", ModelName: "later-range"},
+		{ID: 8, SerialNumber: "A01..B02", ModelName: "invalid"},
+	}
+
+	tests := []struct {
+		serial    string
+		modelName string
+	}{
+		{"A0050", "exact"},
+		{"A0051", "narrow-range"},
+		{"A0500", "range"},
+		{"A00XY", "narrow-glob"},
+		{"A0XYZ", "glob"},
+		{"A1", "regexp"},
+		{"B1", ""},
+	}
+
+	for _, tt := range tests {
+		store, ok := MatchSubstore(stores, tt.serial)
+		if ok != (len(tt.modelName) > 0) || store.ModelName != tt.modelName {
+			t.Errorf("MatchSubstore(%s) = %s, %v, expected %s", tt.serial, store.ModelName, ok, tt.modelName)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+// ListAllowedSyncKeypairs returns the signing-keys that are synced to a factory instance.
+// When the sync user is model-scoped, only the signing-keys of its assigned models are
+// returned, so a user without models receives none. Otherwise all the signing-keys the user
+// is authorized to see are returned
+func (db *DB) ListAllowedSyncKeypairs(authorization User) ([]Keypair, error) {
+	scoped, err := db.syncModelScoped(authorization.Username)
+	if err != nil {
+		return nil, err
+	}
+	if !scoped {
+		return db.ListAllowedKeypairs(authorization)
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listSyncKeypairsForAssignedModels(authorization.Username)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		return db.listSyncKeypairsForAssignedModelsFilteredByUser(authorization.Username, true)
+	default:
+		return []Keypair{}, nil
+	}
+}
@@ -0,0 +1,337 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const createSyncModelTableSQL = `
+	CREATE TABLE IF NOT EXISTS syncusermodel (
+		id               serial primary key not null,
+		user_id          int references userinfo not null,
+		model_id         int references model not null,
+		valid_from       timestamp null,
+		valid_until      timestamp null,
+		max_units        int default 0
+	)
+`
+
+// Additional columns
+const alterSyncModelAddValidFromSQL = "ALTER TABLE syncusermodel ADD COLUMN valid_from timestamp null"
+const alterSyncModelAddValidUntilSQL = "ALTER TABLE syncusermodel ADD COLUMN valid_until timestamp null"
+const alterSyncModelAddMaxUnitsSQL = "ALTER TABLE syncusermodel ADD COLUMN max_units int default 0"
+
+// Indexes
+const createSyncModelUniqueIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS syncusermodel_idx ON syncusermodel (user_id, model_id)"
+
+// The sync users whose factories only receive the signing-keys of their assigned models. A user
+// stays model-scoped when its last model is removed, so it then receives no signing-keys
+const createSyncModelScopeTableSQL = `
+	CREATE TABLE IF NOT EXISTS syncuserscope (
+		user_id          int primary key references userinfo not null
+	)
+`
+
+// The users that had models assigned before the scope was stored are model-scoped
+const migrateSyncModelScopeSQL = `
+	INSERT INTO syncuserscope (user_id)
+	SELECT DISTINCT user_id FROM syncusermodel
+	WHERE user_id NOT IN (SELECT user_id FROM syncuserscope)`
+
+const createSyncModelScopeSQL = "INSERT INTO syncuserscope (user_id) SELECT CAST($1 AS int) WHERE NOT EXISTS(SELECT * FROM syncuserscope WHERE user_id=$1)"
+const deleteSyncModelScopeSQL = "DELETE FROM syncuserscope WHERE user_id=$1"
+const findSyncModelScopeSQL = "SELECT EXISTS(SELECT * FROM syncuserscope WHERE user_id=$1)"
+const findSyncModelScopeForUserSQL = `
+	SELECT EXISTS(
+		SELECT * FROM syncuserscope sc
+		INNER JOIN userinfo u ON u.id = sc.user_id
+		WHERE u.username=$1
+	)`
+
+const createSyncModelSQL = "INSERT INTO syncusermodel (user_id, model_id, valid_from, valid_until, max_units) VALUES ($1,$2,$3,$4,$5)"
+
+const listSyncModelSQL = `
+	SELECT s.id, s.user_id, s.model_id, m.brand_id, m.name, s.valid_from, s.valid_until, s.max_units
+	FROM syncusermodel s
+	INNER JOIN model m ON m.id = s.model_id
+	WHERE s.user_id=$1
+	ORDER BY m.brand_id, m.name`
+
+const deleteSyncModelSQL = "DELETE FROM syncusermodel WHERE id=$1"
+
+// The signing-keys of the assigned models, with an open authorization window: serial,
+// system-user and model assertion keys
+const syncKeypairsForAssignedModelsSQL = `
+	SELECT k.id, k.authority_id, k.key_id, k.active, k.assertion, k.key_name
+	FROM keypair k
+	WHERE k.id IN (
+		SELECT m.keypair_id FROM model m
+		INNER JOIN syncusermodel s ON s.model_id = m.id
+		INNER JOIN userinfo u ON u.id = s.user_id
+		WHERE u.username=$1 AND (s.valid_from IS NULL OR s.valid_from <= current_timestamp) AND (s.valid_until IS NULL OR s.valid_until > current_timestamp)
+		UNION
+		SELECT m.user_keypair_id FROM model m
+		INNER JOIN syncusermodel s ON s.model_id = m.id
+		INNER JOIN userinfo u ON u.id = s.user_id
+		WHERE u.username=$1 AND (s.valid_from IS NULL OR s.valid_from <= current_timestamp) AND (s.valid_until IS NULL OR s.valid_until > current_timestamp)
+		UNION
+		SELECT ma.keypair_id FROM modelassertion ma
+		INNER JOIN syncusermodel s ON s.model_id = ma.model_id
+		INNER JOIN userinfo u ON u.id = s.user_id
+		WHERE u.username=$1 AND (s.valid_from IS NULL OR s.valid_from <= current_timestamp) AND (s.valid_until IS NULL OR s.valid_until > current_timestamp)
+	)
+	ORDER BY k.authority_id, k.key_id`
+const syncKeypairsForAssignedModelsForUserSQL = `
+	SELECT k.id, k.authority_id, k.key_id, k.active, k.assertion, k.key_name
+	FROM keypair k
+	INNER JOIN account acc ON acc.authority_id=k.authority_id
+	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
+	INNER JOIN userinfo u ON ua.user_id=u.id
+	WHERE u.username=$1 AND k.id IN (
+		SELECT m.keypair_id FROM model m
+		INNER JOIN syncusermodel s ON s.model_id = m.id
+		WHERE s.user_id = u.id AND (s.valid_from IS NULL OR s.valid_from <= current_timestamp) AND (s.valid_until IS NULL OR s.valid_until > current_timestamp)
+		UNION
+		SELECT m.user_keypair_id FROM model m
+		INNER JOIN syncusermodel s ON s.model_id = m.id
+		WHERE s.user_id = u.id AND (s.valid_from IS NULL OR s.valid_from <= current_timestamp) AND (s.valid_until IS NULL OR s.valid_until > current_timestamp)
+		UNION
+		SELECT ma.keypair_id FROM modelassertion ma
+		INNER JOIN syncusermodel s ON s.model_id = ma.model_id
+		WHERE s.user_id = u.id AND (s.valid_from IS NULL OR s.valid_from <= current_timestamp) AND (s.valid_until IS NULL OR s.valid_until > current_timestamp)
+	)
+	ORDER BY k.authority_id, k.key_id`
+
+// SyncModelAssignment assigns a model to a sync user, so the factory instance that
+// uses the sync credentials only receives the signing-keys for its assigned models.
+// The assignment authorizes the factory to sign devices for the model within the
+// (optional) time window, up to the maximum number of units (zero is unlimited)
+type SyncModelAssignment struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userID"`
+	ModelID    int        `json:"modelID"`
+	BrandID    string     `json:"brand-id"`
+	Name       string     `json:"model"`
+	ValidFrom  *time.Time `json:"valid-from"`
+	ValidUntil *time.Time `json:"valid-until"`
+	MaxUnits   int        `json:"max-units"`
+}
+
+// Open checks if the authorization window of the assignment is open at the given time
+func (a SyncModelAssignment) Open(t time.Time) bool {
+	if a.ValidFrom != nil && t.Before(*a.ValidFrom) {
+		return false
+	}
+	if a.ValidUntil != nil && !t.Before(*a.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// CreateSyncModelAssignmentTable creates the database table for the sync user's models
+func (db *DB) CreateSyncModelAssignmentTable() error {
+	_, err := db.Exec(createSyncModelTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createSyncModelUniqueIndexSQL)
+	if err != nil {
+		return err
+	}
+
+	// Ignore errors as the fields may already be added
+	db.Exec(alterSyncModelAddValidFromSQL)
+	db.Exec(alterSyncModelAddValidUntilSQL)
+	db.Exec(alterSyncModelAddMaxUnitsSQL)
+
+	if _, err = db.Exec(createSyncModelScopeTableSQL); err != nil {
+		return err
+	}
+	_, err = db.Exec(migrateSyncModelScopeSQL)
+	return err
+}
+
+// ListSyncModelAssignments lists the models assigned to a sync user
+func (db *DB) ListSyncModelAssignments(userID int) ([]SyncModelAssignment, error) {
+	rows, err := db.Query(listSyncModelSQL, userID)
+	if err != nil {
+		log.Printf("Error retrieving the sync user's models: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []SyncModelAssignment{}
+	for rows.Next() {
+		a := SyncModelAssignment{}
+		err := rows.Scan(&a.ID, &a.UserID, &a.ModelID, &a.BrandID, &a.Name, &a.ValidFrom, &a.ValidUntil, &a.MaxUnits)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+
+	return assignments, nil
+}
+
+// CreateSyncModelAssignment assigns a model to a sync user, which makes the user model-scoped
+func (db *DB) CreateSyncModelAssignment(assignment SyncModelAssignment) error {
+	user, err := db.GetUser(assignment.UserID)
+	if err != nil {
+		return errors.New("Cannot find the user")
+	}
+	if user.Role != SyncUser {
+		return errors.New("Models can only be assigned to sync users")
+	}
+
+	if _, err = db.getModel(assignment.ModelID); err != nil {
+		return errors.New("Cannot find the model")
+	}
+
+	if err = validateSyncModelWindow(assignment); err != nil {
+		return err
+	}
+
+	err = db.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(createSyncModelSQL, assignment.UserID, assignment.ModelID, assignment.ValidFrom, assignment.ValidUntil, assignment.MaxUnits)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(createSyncModelScopeSQL, assignment.UserID)
+		return err
+	})
+	if err, ok := err.(*pq.Error); ok {
+		// This is a PostgreSQL error...
+		if err.Code.Name() == "unique_violation" {
+			// Output a more readable message
+			return errors.New("The model is already assigned to the sync user")
+		}
+	}
+	if err != nil {
+		log.Printf("Error assigning the model to the sync user: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func validateSyncModelWindow(assignment SyncModelAssignment) error {
+	if assignment.ValidFrom != nil && assignment.ValidUntil != nil && !assignment.ValidUntil.After(*assignment.ValidFrom) {
+		return errors.New("The end of the authorization window must be after its start")
+	}
+	if assignment.MaxUnits < 0 {
+		return errors.New("The maximum number of units cannot be negative")
+	}
+	return nil
+}
+
+// DeleteSyncModelAssignment removes a model from a sync user. The user stays model-scoped
+func (db *DB) DeleteSyncModelAssignment(assignmentID int) error {
+	_, err := db.Exec(deleteSyncModelSQL, assignmentID)
+	if err != nil {
+		log.Printf("Error removing the model from the sync user: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// SyncModelScoped checks if the sync user only receives the signing-keys of its assigned models
+func (db *DB) SyncModelScoped(userID int) (bool, error) {
+	var scoped bool
+	err := db.QueryRow(findSyncModelScopeSQL, userID).Scan(&scoped)
+	if err != nil {
+		log.Printf("Error retrieving the sync user's scope: %v\n", err)
+	}
+	return scoped, err
+}
+
+// SetSyncModelScope sets if the sync user only receives the signing-keys of its assigned models,
+// or all the signing-keys of its accounts
+func (db *DB) SetSyncModelScope(userID int, scoped bool) error {
+	if !scoped {
+		_, err := db.Exec(deleteSyncModelScopeSQL, userID)
+		return err
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		return errors.New("Cannot find the user")
+	}
+	if user.Role != SyncUser {
+		return errors.New("Models can only be assigned to sync users")
+	}
+
+	_, err = db.Exec(createSyncModelScopeSQL, userID)
+	if err != nil {
+		log.Printf("Error setting the sync user's scope: %v\n", err)
+	}
+	return err
+}
+
+// syncModelScoped checks if the sync user, by its username, is model-scoped
+func (db *DB) syncModelScoped(username string) (bool, error) {
+	var scoped bool
+	err := db.QueryRow(findSyncModelScopeForUserSQL, username).Scan(&scoped)
+	if err != nil {
+		log.Printf("Error retrieving the sync user's scope: %v\n", err)
+	}
+	return scoped, err
+}
+
+func (db *DB) listSyncKeypairsForAssignedModels(username string) ([]Keypair, error) {
+	return db.listSyncKeypairsForAssignedModelsFilteredByUser(username, false)
+}
+
+// listSyncKeypairsForAssignedModelsFilteredByUser lists the signing-keys of the user's
+// assigned models, optionally restricted to the accounts the user can access
+func (db *DB) listSyncKeypairsForAssignedModelsFilteredByUser(username string, filterAccounts bool) ([]Keypair, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	if filterAccounts {
+		rows, err = db.Query(syncKeypairsForAssignedModelsForUserSQL, username)
+	} else {
+		rows, err = db.Query(syncKeypairsForAssignedModelsSQL, username)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the sync keypairs: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keypairs := []Keypair{}
+	for rows.Next() {
+		keypair := Keypair{}
+		err := rows.Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.Assertion, &keypair.KeyName)
+		if err != nil {
+			return nil, err
+		}
+		keypairs = append(keypairs, keypair)
+	}
+
+	return keypairs, nil
+}
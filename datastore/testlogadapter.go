@@ -40,7 +40,7 @@ func (db *DB) ListAllowedTestLog(authorization User) ([]TestLog, error) {
 
 // SyncListTestLogs fetches the test logs from the factory database
 func (db *DB) SyncListTestLogs() ([]TestLog, error) {
-	if Environ.Config.Driver != "sqlite3" {
+	if !InFactory() {
 		return nil, errors.New("Only valid within a factory")
 	}
 
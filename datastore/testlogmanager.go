@@ -90,7 +90,7 @@ func (db *DB) CreateTestLog(testLog TestLog) error {
 	}
 
 	// Create the signing log in the database
-	if InFactory() {
+	if usesSQLite() {
 		// Need to generate our own ID
 		var nextID int
 		err = db.QueryRow(maxIDTestLogSQLite).Scan(&nextID)
@@ -150,7 +150,7 @@ func (db *DB) listTestLogFilteredByUser(username string) ([]TestLog, error) {
 
 // SyncDeleteTestLog remove a test log from the factory
 func (db *DB) SyncDeleteTestLog(ID int) error {
-	if Environ.Config.Driver != "sqlite3" {
+	if !InFactory() {
 		return errors.New("Only valid within a factory")
 	}
 
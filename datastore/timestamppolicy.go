@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// TimestampPolicy decides the timestamp of the serial assertions of a model. By default
+// it is the signing time, but brands may use the manufacture date of the device that
+// is supplied in the serial-request body, when it is within the bounds
+type TimestampPolicy struct {
+	ManufactureDate bool `json:"manufacture-date"` // use the manufacture-date field of the body
+	MaxAge          int  `json:"max-age"`          // days that the manufacture date may precede the signing
+	MaxFuture       int  `json:"max-future"`       // minutes that the manufacture date may follow the signing, for clock skew
+}
+
+// Add the timestamp policy to the models table
+const alterModelTimestampPolicySQL = "ALTER TABLE model ADD COLUMN timestamp_policy text default ''"
+
+// ValidateTimestampPolicy checks the bounds of the timestamp policy of a model
+func ValidateTimestampPolicy(policy TimestampPolicy) error {
+	if policy.MaxAge < 0 || policy.MaxFuture < 0 {
+		return errors.New("The bounds of the timestamp policy must not be negative")
+	}
+	if policy.ManufactureDate && policy.MaxAge == 0 {
+		return errors.New("The maximum age of the manufacture date must be entered")
+	}
+	return nil
+}
+
+func encodeTimestampPolicy(policy TimestampPolicy) string {
+	if policy == (TimestampPolicy{}) {
+		return ""
+	}
+	content, _ := json.Marshal(policy)
+	return string(content)
+}
+
+func decodeTimestampPolicy(content string) TimestampPolicy {
+	policy := TimestampPolicy{}
+	if len(content) == 0 {
+		return policy
+	}
+	if err := json.Unmarshal([]byte(content), &policy); err != nil {
+		log.Printf("Error decoding the timestamp policy: %v\n", err)
+	}
+	return policy
+}
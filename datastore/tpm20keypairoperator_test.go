@@ -50,7 +50,7 @@ func getTPMKeyStoreWithMockCommand() *KeypairDatabase {
 		KeypairManager: memStore,
 	})
 
-	keypairDB = KeypairDatabase{TPM20Store, db, &tpm20}
+	keypairDB = KeypairDatabase{TPM20Store, db, &tpm20, nil}
 	return &keypairDB
 }
 
@@ -112,6 +112,11 @@ type User struct {
 	APIKey   string
 	Role     int
 	Accounts []Account
+
+	// Impersonator is the superuser that acts as the user to support the ImpersonatedBrand.
+	// They are set from the authentication token and are not stored
+	Impersonator      string `json:"-"`
+	ImpersonatedBrand string `json:"-"`
 }
 
 // CreateUserTable creates User table in database
@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package devmode runs the vault with an in-memory datastore and keystore, seeded with an
+// example account, signing-key and model, so the UI and the clients can be developed
+// without a database or a key ceremony. It must not be used in production
+package devmode
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/random"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// Example data seeded in the datastore
+const (
+	BrandID   = "dev-brand"
+	ModelName = "dev-model"
+	KeyName   = "dev-key"
+)
+
+// keyBits is the size of the generated signing-key
+var keyBits = 4096
+
+// Seed is the example data of the datastore, for the clients of the vault
+type Seed struct {
+	BrandID   string
+	ModelName string
+	APIKey    string
+	KeyID     string
+}
+
+// Setup replaces the datastore and the keystore of the environment with in-memory ones, and
+// seeds them with a generated signing-key and an example model. The config is changed so the
+// services run without authentication over plain HTTP
+func Setup(env *datastore.Env) (Seed, error) {
+	if err := devConfig(env); err != nil {
+		return Seed{}, err
+	}
+
+	keypairDB, err := datastore.GetMemoryKeyStore(env.Config)
+	if err != nil {
+		return Seed{}, err
+	}
+	env.KeypairDB = keypairDB
+
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return Seed{}, err
+	}
+	privateKey := asserts.RSAPrivateKey(key)
+	if err := keypairDB.ImportKey(privateKey); err != nil {
+		return Seed{}, err
+	}
+
+	apiKey, err := random.GenerateRandomString(40)
+	if err != nil {
+		return Seed{}, err
+	}
+
+	db := datastoretest.New()
+	db.AddAccount(datastore.Account{AuthorityID: BrandID})
+	keypair := db.AddKeypair(datastoretest.NewKeypair(BrandID, privateKey.PublicKey().ID()).WithName(KeyName).Build())
+	model := db.AddModel(datastoretest.NewModel(BrandID, ModelName).WithKeypair(keypair).WithAPIKey(apiKey).Build())
+	_, err = db.CreateModelAssert(datastore.ModelAssertion{
+		ModelID: model.ID, KeypairID: keypair.ID, Series: 16, Architecture: "amd64", Gadget: "pc", Kernel: "pc-kernel",
+	})
+	if err != nil {
+		return Seed{}, err
+	}
+	env.DB = db
+
+	return Seed{BrandID: BrandID, ModelName: ModelName, APIKey: apiKey, KeyID: keypair.KeyID}, nil
+}
+
+// devConfig disables the authentication of the admin service and the secure CSRF cookie, as
+// the services run over plain HTTP, generating the secrets that are not in the config
+func devConfig(env *datastore.Env) error {
+	env.Config.EnableUserAuth = false
+	os.Setenv("CSRF_SECURE", "disable")
+
+	for _, secret := range []*string{&env.Config.CSRFAuthKey, &env.Config.JwtSecret, &env.Config.KeyStoreSecret} {
+		if len(*secret) > 0 {
+			continue
+		}
+		value, err := random.GenerateRandomString(32)
+		if err != nil {
+			return err
+		}
+		*secret = value
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devmode
+
+import (
+	"os"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	check "gopkg.in/check.v1"
+)
+
+func TestDevModeSuite(t *testing.T) { check.TestingT(t) }
+
+type DevModeSuite struct{}
+
+var _ = check.Suite(&DevModeSuite{})
+
+func (s *DevModeSuite) SetUpTest(c *check.C) {
+	// A smaller key keeps the tests fast
+	keyBits = 1024
+}
+
+func (s *DevModeSuite) TearDownTest(c *check.C) {
+	os.Unsetenv("CSRF_SECURE")
+}
+
+func (s *DevModeSuite) TestSetup(c *check.C) {
+	env := &datastore.Env{}
+	env.Config.EnableUserAuth = true
+
+	seed, err := Setup(env)
+	c.Assert(err, check.IsNil)
+	c.Assert(seed.BrandID, check.Equals, BrandID)
+	c.Assert(seed.ModelName, check.Equals, ModelName)
+	c.Assert(seed.APIKey, check.HasLen, 40)
+
+	// The seeded model is found with its API key, and is signed by the generated key
+	model, err := env.DB.FindModel(seed.BrandID, seed.ModelName, seed.APIKey)
+	c.Assert(err, check.IsNil)
+	c.Assert(model.KeyID, check.Equals, seed.KeyID)
+	_, err = env.KeypairDB.PublicKey(seed.KeyID)
+	c.Assert(err, check.IsNil)
+
+	assertion, err := env.DB.GetModelAssert(model.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(assertion.Gadget, check.Equals, "pc")
+
+	c.Assert(env.Config.EnableUserAuth, check.Equals, false)
+	c.Assert(env.Config.CSRFAuthKey, check.Not(check.Equals), "")
+	c.Assert(env.Config.JwtSecret, check.Not(check.Equals), "")
+	c.Assert(os.Getenv("CSRF_SECURE"), check.Equals, "disable")
+}
+
+func (s *DevModeSuite) TestSetupKeepsSecrets(c *check.C) {
+	env := &datastore.Env{}
+	env.Config.JwtSecret = "configured-secret"
+
+	_, err := Setup(env)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Config.JwtSecret, check.Equals, "configured-secret")
+}
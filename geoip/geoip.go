@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package geoip finds the country of the client IP of a signing request in a GeoIP database:
+// a CSV file with a network and its ISO country code on each row, e.g. "81.2.69.0/24,GB", or
+// the first and last address of a range and its country code, e.g. "81.2.69.0,81.2.69.255,GB"
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// Database holds the address ranges of the GeoIP database, ordered by their first address
+type Database struct {
+	ranges []addressRange
+}
+
+type addressRange struct {
+	first   net.IP
+	last    net.IP
+	country string
+}
+
+// Open loads the GeoIP database from the config, returning nil when none is configured
+func Open(settings config.Settings) (datastore.CountryLookup, error) {
+	if len(settings.SigningLogGeoIPDatabase) == 0 {
+		return nil, nil
+	}
+
+	db, err := Load(settings.SigningLogGeoIPDatabase)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load reads the GeoIP database from a CSV file
+func Load(path string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse reads the GeoIP database from CSV. The first row is skipped when it is a header
+func Parse(r io.Reader) (*Database, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	db := &Database{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		a, err := parseRange(record)
+		if err != nil && line == 1 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid GeoIP database on line %d: %v", line, err)
+		}
+		db.ranges = append(db.ranges, a)
+	}
+
+	sort.SliceStable(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].first, db.ranges[j].first) < 0
+	})
+	return db, nil
+}
+
+// parseRange reads a network or an address range, and its country code
+func parseRange(record []string) (addressRange, error) {
+	switch len(record) {
+	case 2:
+		_, network, err := net.ParseCIDR(record[0])
+		if err != nil {
+			return addressRange{}, err
+		}
+		first := network.IP.To16()
+		last := make(net.IP, len(first))
+		ones, bits := network.Mask.Size()
+		mask := net.CIDRMask(ones+net.IPv6len*8-bits, net.IPv6len*8)
+		for i := range first {
+			last[i] = first[i] | ^mask[i]
+		}
+		return addressRange{first: first, last: last, country: countryCode(record[1])}, nil
+	case 3:
+		first, last := net.ParseIP(record[0]), net.ParseIP(record[1])
+		if first == nil || last == nil || bytes.Compare(first.To16(), last.To16()) > 0 {
+			return addressRange{}, fmt.Errorf("invalid address range '%s-%s'", record[0], record[1])
+		}
+		return addressRange{first: first.To16(), last: last.To16(), country: countryCode(record[2])}, nil
+	default:
+		return addressRange{}, fmt.Errorf("expected a network or an address range and a country code, got %d fields", len(record))
+	}
+}
+
+func countryCode(value string) string {
+	return strings.ToUpper(strings.TrimSpace(value))
+}
+
+// Country finds the country code of the IP address, returning an empty string when the
+// address is not in the database
+func (db *Database) Country(ip string) string {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return ""
+	}
+	address = address.To16()
+
+	// The last range that starts at or before the address
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].first, address) > 0
+	})
+	if i == 0 || bytes.Compare(address, db.ranges[i-1].last) > 0 {
+		return ""
+	}
+	return db.ranges[i-1].country
+}
@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package geoip
+
+import (
+	"strings"
+	"testing"
+)
+
+const database = `network,country
+# The networks of the factories
+81.2.69.0/24,gb
+2001:db8::/32,DE
+10.0.0.1,10.0.0.20,CN
+`
+
+func TestCountry(t *testing.T) {
+	db, err := Parse(strings.NewReader(database))
+	if err != nil {
+		t.Fatalf("Error parsing the GeoIP database: %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+	}{
+		{"81.2.69.160", "GB"},
+		{"81.2.69.0", "GB"},
+		{"81.2.69.255", "GB"},
+		{"81.2.70.1", ""},
+		{"2001:db8:1::1", "DE"},
+		{"2001:db9::1", ""},
+		{"10.0.0.1", "CN"},
+		{"10.0.0.20", "CN"},
+		{"10.0.0.21", ""},
+		{"1.1.1.1", ""},
+		{"invalid", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if country := db.Country(tt.ip); country != tt.country {
+			t.Errorf("%s: expected country '%s', got '%s'", tt.ip, tt.country, country)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"81.2.69.0/24,GB\ninvalid,DE\n",
+		"81.2.69.0/24,GB\n10.0.0.20,10.0.0.1,CN\n",
+		"81.2.69.0/24,GB\n10.0.0.1\n",
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse(strings.NewReader(tt)); err == nil {
+			t.Errorf("%q: expected an error", tt)
+		}
+	}
+}
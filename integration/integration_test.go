@@ -0,0 +1,255 @@
+//go:build integration
+// +build integration
+
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/manage"
+	"github.com/CanonicalLtd/serial-vault/random"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/sign"
+	"github.com/CanonicalLtd/serial-vault/sync"
+	_ "github.com/lib/pq"
+	"github.com/ory/dockertest"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+const (
+	postgresImage    = "postgres"
+	postgresVersion  = "9.6"
+	postgresPassword = "secret"
+
+	brandID   = "system"
+	modelName = "alder"
+	syncUser  = "factory-sync"
+)
+
+func TestIntegrationSuite(t *testing.T) { check.TestingT(t) }
+
+// IntegrationSuite runs a factory and a cloud serial vault, each with its own database in
+// the same PostgreSQL container
+type IntegrationSuite struct {
+	pool     *dockertest.Pool
+	resource *dockertest.Resource
+
+	factory *datastore.Env
+	cloud   *datastore.Env
+
+	modelAPIKey string
+	syncAPIKey  string
+}
+
+var _ = check.Suite(&IntegrationSuite{})
+
+func (s *IntegrationSuite) SetUpSuite(c *check.C) {
+	pool, err := dockertest.NewPool("")
+	c.Assert(err, check.IsNil)
+	s.pool = pool
+
+	s.resource, err = pool.Run(postgresImage, postgresVersion, []string{"POSTGRES_PASSWORD=" + postgresPassword})
+	c.Assert(err, check.IsNil)
+
+	// Wait for PostgreSQL to accept connections, then create the databases of the vaults
+	var db *sql.DB
+	err = pool.Retry(func() error {
+		var err error
+		db, err = sql.Open("postgres", s.dataSource("postgres"))
+		if err != nil {
+			return err
+		}
+		return db.Ping()
+	})
+	c.Assert(err, check.IsNil)
+	for _, name := range []string{"factory", "cloud"} {
+		_, err = db.Exec("CREATE DATABASE " + name)
+		c.Assert(err, check.IsNil)
+	}
+	db.Close()
+
+	s.cloud = s.openEnv(c, "cloud")
+	s.factory = s.openEnv(c, "factory")
+
+	// The factory signs with a test signing-key from the database keystore
+	err = datastore.OpenKeyStore(s.factory.Config)
+	c.Assert(err, check.IsNil)
+	keypairID := s.loadKeypair(c)
+
+	err = s.factory.DB.CreateAccount(datastore.Account{AuthorityID: brandID})
+	c.Assert(err, check.IsNil)
+	s.modelAPIKey, err = random.GenerateRandomString(40)
+	c.Assert(err, check.IsNil)
+	_, _, err = s.factory.DB.CreateAllowedModel(datastore.Model{BrandID: brandID, Name: modelName, KeypairID: keypairID, APIKey: s.modelAPIKey}, datastore.User{})
+	c.Assert(err, check.IsNil)
+
+	// The factory syncs with the cloud as a sync user
+	userID, err := s.cloud.DB.CreateUser(datastore.User{Username: syncUser, Name: "Factory Sync", Role: datastore.SyncUser})
+	c.Assert(err, check.IsNil)
+	user, err := s.cloud.DB.GetUser(userID)
+	c.Assert(err, check.IsNil)
+	s.syncAPIKey = user.APIKey
+}
+
+func (s *IntegrationSuite) TearDownSuite(c *check.C) {
+	if s.resource != nil {
+		c.Assert(s.pool.Purge(s.resource), check.IsNil)
+	}
+}
+
+func (s *IntegrationSuite) dataSource(name string) string {
+	return fmt.Sprintf("postgres://postgres:%s@localhost:%s/%s?sslmode=disable", postgresPassword, s.resource.GetPort("5432/tcp"), name)
+}
+
+// openEnv opens the database of a vault and runs the schema migrations. The datastore
+// package opens the database in the global environment, which is left pointing at the factory
+func (s *IntegrationSuite) openEnv(c *check.C, name string) *datastore.Env {
+	settings := config.Settings{
+		Driver:         "postgres",
+		DataSource:     s.dataSource(name),
+		KeyStoreType:   datastore.DatabaseStore.Name,
+		KeyStoreSecret: "secret code to encrypt the auth-key hash",
+		JwtSecret:      "SomeTestSecretValue",
+		EnableUserAuth: true,
+	}
+	env := &datastore.Env{Config: settings}
+	datastore.Environ = env
+
+	datastore.OpenSysDatabase(settings.Driver, settings.DataSource)
+	manage.UpdateDatabase()
+	return env
+}
+
+// loadKeypair imports the test signing-key into the keystore of the factory
+func (s *IntegrationSuite) loadKeypair(c *check.C) int {
+	signingKey, err := ioutil.ReadFile("../keystore/TestKey.asc")
+	c.Assert(err, check.IsNil)
+
+	privateKey, sealedKey, err := s.factory.KeypairDB.ImportSigningKey(brandID, base64.StdEncoding.EncodeToString(signingKey))
+	c.Assert(err, check.IsNil)
+
+	keypair := datastore.Keypair{AuthorityID: brandID, KeyID: privateKey.PublicKey().ID(), SealedKey: sealedKey}
+	_, err = s.factory.DB.PutKeypair(keypair)
+	c.Assert(err, check.IsNil)
+
+	keypair, err = s.factory.DB.GetKeypairByPublicID(keypair.AuthorityID, keypair.KeyID)
+	c.Assert(err, check.IsNil)
+	return keypair.ID
+}
+
+func (s *IntegrationSuite) TestSerialSigningAndSync(c *check.C) {
+	signing := httptest.NewServer(service.NewService(s.factory).SigningRouter())
+	defer signing.Close()
+	admin := httptest.NewServer(service.NewService(s.cloud).AdminRouter())
+	defer admin.Close()
+
+	// Request a nonce and sign the serial-request
+	w := post(c, signing.URL+"/v1/request-id", nil, s.modelAPIKey)
+	c.Assert(w.StatusCode, check.Equals, http.StatusOK)
+	nonce := sign.RequestIDResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&nonce), check.IsNil)
+	w.Body.Close()
+	c.Assert(nonce.RequestID, check.Not(check.Equals), "")
+
+	w = post(c, signing.URL+"/v1/serial", serialRequest(c, nonce.RequestID, "A123456L"), s.modelAPIKey)
+	c.Assert(w.StatusCode, check.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(w.Body)
+	w.Body.Close()
+	c.Assert(err, check.IsNil)
+	serial, err := asserts.Decode(content)
+	c.Assert(err, check.IsNil)
+	c.Assert(serial.Type(), check.Equals, asserts.SerialType)
+	c.Assert(serial.HeaderString("serial"), check.Equals, "A123456L")
+
+	// The nonce is single-use
+	w = post(c, signing.URL+"/v1/serial", serialRequest(c, nonce.RequestID, "A123456L"), s.modelAPIKey)
+	w.Body.Close()
+	c.Assert(w.StatusCode, check.Equals, http.StatusBadRequest)
+
+	// The signing log is recorded in the factory, waiting for the sync
+	logs, err := s.factory.DB.ListSerialSigningLog(brandID, modelName, "A123456L")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	unsynced, err := s.factory.DB.SyncSigningLog()
+	c.Assert(err, check.IsNil)
+	c.Assert(unsynced, check.HasLen, 1)
+
+	// Sync the signing log to the cloud, twice to check that it is not duplicated
+	client := sync.NewFactoryClient(admin.URL+"/api/", syncUser, s.syncAPIKey, 0)
+	for i := 0; i < 2; i++ {
+		c.Assert(client.SigningLogs(context.Background()), check.IsNil)
+	}
+
+	unsynced, err = s.factory.DB.SyncSigningLog()
+	c.Assert(err, check.IsNil)
+	c.Assert(unsynced, check.HasLen, 0)
+
+	synced, err := s.cloud.DB.ListSerialSigningLog(brandID, modelName, "A123456L")
+	c.Assert(err, check.IsNil)
+	c.Assert(synced, check.HasLen, 1)
+	c.Assert(synced[0].Fingerprint, check.Equals, logs[0].Fingerprint)
+	c.Assert(synced[0].Created.Unix(), check.Equals, logs[0].Created.Unix())
+}
+
+func post(c *check.C, url string, data []byte, apiKey string) *http.Response {
+	r, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	c.Assert(err, check.IsNil)
+	r.Header.Set("api-key", apiKey)
+
+	w, err := http.DefaultClient.Do(r)
+	c.Assert(err, check.IsNil)
+	return w
+}
+
+// serialRequest creates a serial-request assertion, signed by the test device-key
+func serialRequest(c *check.C, nonce, serial string) []byte {
+	deviceKey, err := ioutil.ReadFile("../keystore/TestDeviceKey.asc")
+	c.Assert(err, check.IsNil)
+	privateKey, _, err := crypt.DeserializePrivateKey(base64.StdEncoding.EncodeToString(deviceKey))
+	c.Assert(err, check.IsNil)
+	encodedPubKey, err := asserts.EncodePublicKey(privateKey.PublicKey())
+	c.Assert(err, check.IsNil)
+
+	headers := map[string]interface{}{
+		"brand-id":   brandID,
+		"device-key": string(encodedPubKey),
+		"request-id": nonce,
+		"model":      modelName,
+		"serial":     serial,
+	}
+	sreq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType, headers, nil, privateKey)
+	c.Assert(err, check.IsNil)
+	return asserts.Encode(sreq)
+}
@@ -0,0 +1,212 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package logsink writes the signing log to a secondary, write-once storage at sign time.
+// The entries that cannot be written are queued and retried in the background, and the
+// storage can be reconciled with the signing log of a brand
+package logsink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// S3Sink is the name of the S3 object-lock sink in the config
+const S3Sink = "s3"
+
+// DefaultRetryInterval is the time between the retries of the queued entries
+const DefaultRetryInterval = time.Minute
+
+// Open creates the signing log sink from the config, returning nil when none is configured
+func Open(settings config.Settings) (datastore.SigningLogSink, error) {
+	switch settings.SigningLogSink {
+	case "":
+		return nil, nil
+	case S3Sink:
+		s, err := NewS3(settings)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("Invalid signing log sink '%s'", settings.SigningLogSink)
+	}
+}
+
+// Interval returns the time between the retries of the queued entries from the config
+func Interval() time.Duration {
+	if datastore.Environ.Config.SigningLogSinkRetryInterval > 0 {
+		return time.Duration(datastore.Environ.Config.SigningLogSinkRetryInterval) * time.Second
+	}
+	return DefaultRetryInterval
+}
+
+// Write writes the signing log entry to the sink, when one is configured, before the entry is
+// stored in the signing log. With the block policy the error is returned, so that the signing
+// is refused until its entry can be written. With the queue policy the entry is queued to be retried
+func Write(ctx context.Context, db datastore.Datastore, signLog datastore.SigningLog) error {
+	sink := datastore.Environ.SigningLogSink
+	if sink == nil {
+		return nil
+	}
+
+	// The entry is written before the signing log sets its timestamp
+	if signLog.Created.IsZero() {
+		signLog.Created = time.Now().UTC()
+	}
+
+	err := sink.Write(ctx, signLog)
+	if err == nil {
+		return nil
+	}
+	metrics.Increment(metrics.SinkWriteErrors)
+	log.Message("SINK", "write-signing-log", err.Error())
+
+	// The signing is refused, so there is no entry to retry
+	if datastore.SinkPolicy() == datastore.SinkPolicyBlock {
+		return err
+	}
+
+	// The entry must not be lost, as the assertion is returned
+	if errQueue := db.QueueSigningLogSink(signLog, err.Error()); errQueue != nil {
+		log.Message("SINK", "queue-signing-log", errQueue.Error())
+		return errQueue
+	}
+	metrics.Increment(metrics.SinkQueued)
+	return nil
+}
+
+// Run retries the queued entries periodically, until the context is done
+func Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Flush(ctx)
+		}
+	}
+}
+
+// Flush writes the queued entries to the sink, oldest first, returning the number that were
+// written. It stops at the first failure, as the sink is likely to be unavailable
+func Flush(ctx context.Context) (int, error) {
+	sink := datastore.Environ.SigningLogSink
+	if sink == nil {
+		return 0, nil
+	}
+	db := datastore.Environ.DB.WithContext(ctx)
+
+	entries, err := db.ListSigningLogSinkQueue()
+	if err != nil {
+		log.Message("SINK", "list-signing-log-queue", err.Error())
+		return 0, err
+	}
+
+	written := 0
+	for _, e := range entries {
+		if err := sink.Write(ctx, e.SigningLog); err != nil {
+			log.Message("SINK", "write-signing-log", err.Error())
+			return written, err
+		}
+		if err := db.DeleteSigningLogSinkQueue(e.ID); err != nil {
+			log.Message("SINK", "delete-signing-log-queue", err.Error())
+			return written, err
+		}
+		written++
+		metrics.Increment(metrics.SinkRetried)
+	}
+	return written, nil
+}
+
+// Reconciliation holds the discrepancies between the signing log of a brand and the sink
+type Reconciliation struct {
+	Entries int
+	Objects int
+	Missing []datastore.SigningLog // in the signing log but not in the sink, nor queued
+	Queued  []datastore.SigningLog // in the signing log and waiting in the queue
+	Unknown []string               // in the sink but not in the signing log e.g. deleted entries
+}
+
+// Reconcile compares the signing log of a brand with the entries in the sink
+func Reconcile(ctx context.Context, brandID string) (Reconciliation, error) {
+	sink := datastore.Environ.SigningLogSink
+	if sink == nil {
+		return Reconciliation{}, fmt.Errorf("The signing log sink is not configured")
+	}
+	db := datastore.Environ.DB.WithContext(ctx)
+
+	signingLogs, err := db.ListSigningLogForBrand(brandID)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("Error retrieving the signing logs: %v", err)
+	}
+	queue, err := db.ListSigningLogSinkQueue()
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("Error retrieving the signing log queue: %v", err)
+	}
+	keys, err := sink.List(ctx, datastore.SigningLogSinkPrefix(brandID))
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("Error listing the signing log sink: %v", err)
+	}
+
+	return reconcileSigningLogs(signingLogs, queue, keys), nil
+}
+
+// reconcileSigningLogs compares the keys of the signing log entries with the keys in the sink
+func reconcileSigningLogs(signingLogs []datastore.SigningLog, queue []datastore.SigningLogSinkEntry, keys []string) Reconciliation {
+	result := Reconciliation{Entries: len(signingLogs), Objects: len(keys)}
+
+	stored := map[string]bool{}
+	for _, k := range keys {
+		stored[k] = true
+	}
+	queued := map[string]bool{}
+	for _, e := range queue {
+		queued[datastore.SigningLogSinkKey(e.SigningLog)] = true
+	}
+
+	logged := map[string]bool{}
+	for _, l := range signingLogs {
+		key := datastore.SigningLogSinkKey(l)
+		logged[key] = true
+		switch {
+		case stored[key]:
+		case queued[key]:
+			result.Queued = append(result.Queued, l)
+		default:
+			result.Missing = append(result.Missing, l)
+		}
+	}
+
+	for _, k := range keys {
+		if !logged[k] {
+			result.Unknown = append(result.Unknown, k)
+		}
+	}
+	return result
+}
@@ -0,0 +1,310 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logsink_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/logsink"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	check "gopkg.in/check.v1"
+)
+
+func TestLogSinkSuite(t *testing.T) { check.TestingT(t) }
+
+type LogSinkSuite struct {
+	db     *datastoretest.DB
+	bucket *fakeBucket
+	server *httptest.Server
+}
+
+var _ = check.Suite(&LogSinkSuite{})
+
+// fakeBucket is an S3 bucket that does not overwrite objects, listing them in pages of two keys
+type fakeBucket struct {
+	lock    sync.Mutex
+	objects map[string]string
+	headers http.Header
+	down    bool
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.down || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		key := strings.TrimPrefix(r.URL.Path, "/signinglog/")
+		if _, ok := b.objects[key]; ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		b.objects[key] = string(body)
+		b.headers = r.Header
+	case "GET":
+		keys := []string{}
+		for k := range b.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+		result := struct {
+			XMLName               xml.Name `xml:"ListBucketResult"`
+			Contents              []struct{ Key string }
+			IsTruncated           bool
+			NextContinuationToken string `xml:",omitempty"`
+		}{}
+		for i := start; i < len(keys) && i < start+2; i++ {
+			result.Contents = append(result.Contents, struct{ Key string }{keys[i]})
+		}
+		if start+2 < len(keys) {
+			result.IsTruncated = true
+			result.NextContinuationToken = strconv.Itoa(start + 2)
+		}
+		xml.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *LogSinkSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.bucket = &fakeBucket{objects: map[string]string{}}
+	s.server = httptest.NewServer(s.bucket)
+
+	settings := config.Settings{
+		SigningLogSink:            logsink.S3Sink,
+		SigningLogS3Endpoint:      s.server.URL,
+		SigningLogS3Region:        "eu-west-1",
+		SigningLogS3Bucket:        "signinglog",
+		SigningLogS3AccessKey:     "access",
+		SigningLogS3SecretKey:     "secret",
+		SigningLogS3RetentionDays: 30,
+	}
+	sink, err := logsink.Open(settings)
+	c.Assert(err, check.IsNil)
+	datastore.Environ = &datastore.Env{DB: s.db, Config: settings, SigningLogSink: sink}
+}
+
+func (s *LogSinkSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func signingLog(serial string, revision int) datastore.SigningLog {
+	return datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: serial, Fingerprint: "fp-" + serial, Revision: revision}
+}
+
+func (s *LogSinkSuite) TestOpen(c *check.C) {
+	tests := []struct {
+		Settings config.Settings
+		Sink     bool
+		Error    bool
+	}{
+		{config.Settings{}, false, false},
+		{config.Settings{SigningLogSink: "tape"}, false, true},
+		{config.Settings{SigningLogSink: "s3", SigningLogS3Region: "eu-west-1", SigningLogS3Bucket: "b", SigningLogS3AccessKey: "a", SigningLogS3SecretKey: "s"}, true, false},
+		{config.Settings{SigningLogSink: "s3", SigningLogS3Region: "eu-west-1", SigningLogS3Bucket: "b", SigningLogS3AccessKey: "a"}, false, true},
+		{config.Settings{SigningLogSink: "s3", SigningLogS3Region: "eu-west-1", SigningLogS3Bucket: "b", SigningLogS3AccessKey: "a", SigningLogS3SecretKey: "s", SigningLogS3RetentionMode: "FOREVER"}, false, true},
+		{config.Settings{SigningLogSink: "s3", SigningLogS3Region: "eu-west-1", SigningLogS3Bucket: "b", SigningLogS3AccessKey: "a", SigningLogS3SecretKey: "s", SigningLogS3RetentionDays: -1}, false, true},
+	}
+
+	for _, t := range tests {
+		sink, err := logsink.Open(t.Settings)
+		c.Assert(err != nil, check.Equals, t.Error)
+		c.Assert(sink != nil, check.Equals, t.Sink)
+	}
+}
+
+func (s *LogSinkSuite) TestWrite(c *check.C) {
+	err := logsink.Write(context.Background(), s.db, signingLog("A/1", 1))
+	c.Assert(err, check.IsNil)
+
+	// The entry is locked and keyed by brand, model, serial number and revision
+	c.Assert(s.bucket.objects, check.HasLen, 1)
+	c.Assert(s.bucket.objects["system/alder/A%2F1/1.json"], check.Matches, `.*"serialnumber":"A/1".*`)
+	c.Assert(s.bucket.headers.Get("X-Amz-Object-Lock-Mode"), check.Equals, logsink.RetentionCompliance)
+	until, err := time.Parse(time.RFC3339, s.bucket.headers.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	c.Assert(err, check.IsNil)
+	c.Assert(until.After(time.Now().AddDate(0, 0, 29)), check.Equals, true)
+
+	// Writing the entry again is not an error, and does not overwrite it
+	content := s.bucket.objects["system/alder/A%2F1/1.json"]
+	err = logsink.Write(context.Background(), s.db, signingLog("A/1", 1))
+	c.Assert(err, check.IsNil)
+	c.Assert(s.bucket.objects["system/alder/A%2F1/1.json"], check.Equals, content)
+}
+
+func (s *LogSinkSuite) TestWriteNoSink(c *check.C) {
+	datastore.Environ.SigningLogSink = nil
+
+	err := logsink.Write(context.Background(), s.db, signingLog("A1", 1))
+	c.Assert(err, check.IsNil)
+	queue, _ := s.db.ListSigningLogSinkQueue()
+	c.Assert(queue, check.HasLen, 0)
+}
+
+func (s *LogSinkSuite) TestWritePolicy(c *check.C) {
+	s.bucket.down = true
+
+	tests := []struct {
+		Policy string
+		Error  bool
+		Queued int
+	}{
+		{"", true, 0},
+		{datastore.SinkPolicyBlock, true, 0},
+		{datastore.SinkPolicyQueue, false, 1},
+	}
+
+	for i, t := range tests {
+		datastore.Environ.Config.SigningLogSinkPolicy = t.Policy
+		before := metrics.Value(metrics.SinkQueued)
+
+		err := logsink.Write(context.Background(), s.db, signingLog("A1", i+1))
+		c.Assert(err != nil, check.Equals, t.Error)
+
+		// The entry is only queued when the signing is not refused
+		c.Assert(metrics.Value(metrics.SinkQueued)-before, check.Equals, int64(t.Queued))
+		queue, err := s.db.ListSigningLogSinkQueue()
+		c.Assert(err, check.IsNil)
+		c.Assert(queue, check.HasLen, t.Queued)
+	}
+
+	queue, _ := s.db.ListSigningLogSinkQueue()
+	c.Assert(queue[0].SigningLog.Revision, check.Equals, 3)
+	c.Assert(queue[0].Reason, check.Matches, ".*503.*")
+}
+
+func (s *LogSinkSuite) TestWriteQueueError(c *check.C) {
+	s.bucket.down = true
+	datastore.Environ.Config.SigningLogSinkPolicy = datastore.SinkPolicyQueue
+
+	// The entry must not be lost, whatever the policy
+	err := logsink.Write(context.Background(), &datastore.ErrorMockDB{}, signingLog("A1", 1))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *LogSinkSuite) TestFlush(c *check.C) {
+	s.bucket.down = true
+	datastore.Environ.Config.SigningLogSinkPolicy = datastore.SinkPolicyQueue
+	for i := 1; i <= 3; i++ {
+		c.Assert(logsink.Write(context.Background(), s.db, signingLog("A1", i)), check.IsNil)
+	}
+
+	written, err := logsink.Flush(context.Background())
+	c.Assert(err, check.NotNil)
+	c.Assert(written, check.Equals, 0)
+
+	s.bucket.down = false
+	before := metrics.Value(metrics.SinkRetried)
+
+	written, err = logsink.Flush(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Assert(written, check.Equals, 3)
+	c.Assert(metrics.Value(metrics.SinkRetried)-before, check.Equals, int64(3))
+	c.Assert(s.bucket.objects, check.HasLen, 3)
+
+	queue, err := s.db.ListSigningLogSinkQueue()
+	c.Assert(err, check.IsNil)
+	c.Assert(queue, check.HasLen, 0)
+}
+
+func (s *LogSinkSuite) TestRun(c *check.C) {
+	c.Assert(s.db.QueueSigningLogSink(signingLog("A1", 1), "unavailable"), check.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		logsink.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	// Wait for the queued entry to be written, then stop
+	for i := 0; i < 1000; i++ {
+		if queue, _ := s.db.ListSigningLogSinkQueue(); len(queue) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	s.bucket.lock.Lock()
+	defer s.bucket.lock.Unlock()
+	c.Assert(s.bucket.objects, check.HasLen, 1)
+}
+
+func (s *LogSinkSuite) TestReconcile(c *check.C) {
+	for i := 1; i <= 5; i++ {
+		l := s.db.AddSigningLog(signingLog("A"+strconv.Itoa(i), 1))
+		if i <= 3 {
+			c.Assert(logsink.Write(context.Background(), s.db, l), check.IsNil)
+		}
+	}
+	c.Assert(s.db.QueueSigningLogSink(signingLog("A4", 1), "unavailable"), check.IsNil)
+
+	// An entry of another brand and one deleted from the signing log
+	s.bucket.objects["other/alder/A1/1.json"] = "{}"
+	s.bucket.objects["system/alder/A0/1.json"] = "{}"
+
+	result, err := logsink.Reconcile(context.Background(), "system")
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Entries, check.Equals, 5)
+	c.Assert(result.Objects, check.Equals, 4)
+	c.Assert(result.Queued, check.HasLen, 1)
+	c.Assert(result.Queued[0].SerialNumber, check.Equals, "A4")
+	c.Assert(result.Missing, check.HasLen, 1)
+	c.Assert(result.Missing[0].SerialNumber, check.Equals, "A5")
+	c.Assert(result.Unknown, check.DeepEquals, []string{"system/alder/A0/1.json"})
+}
+
+func (s *LogSinkSuite) TestReconcileErrors(c *check.C) {
+	s.bucket.down = true
+	_, err := logsink.Reconcile(context.Background(), "system")
+	c.Assert(err, check.ErrorMatches, "Error listing the signing log sink: .*")
+
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	_, err = logsink.Reconcile(context.Background(), "system")
+	c.Assert(err, check.ErrorMatches, "Error retrieving the signing logs: .*")
+
+	datastore.Environ.SigningLogSink = nil
+	_, err = logsink.Reconcile(context.Background(), "system")
+	c.Assert(err, check.NotNil)
+}
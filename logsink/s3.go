@@ -0,0 +1,299 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// Retention modes of the S3 object lock
+const (
+	RetentionCompliance = "COMPLIANCE"
+	RetentionGovernance = "GOVERNANCE"
+)
+
+const s3Algorithm = "AWS4-HMAC-SHA256"
+const s3TimeFormat = "20060102T150405Z"
+
+// S3 writes the signing log entries to an S3 bucket with object lock enabled. The requests
+// are signed with AWS signature version 4 and use path-style URLs, so that S3-compatible
+// storage can be used
+type S3 struct {
+	Endpoint      string
+	Region        string
+	Bucket        string
+	AccessKey     string
+	SecretKey     string
+	RetentionMode string
+	RetentionDays int
+
+	Client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 creates the S3 sink from the config, using the AWS endpoint of the region by default
+func NewS3(settings config.Settings) (*S3, error) {
+	s := &S3{
+		Endpoint:      strings.TrimSuffix(settings.SigningLogS3Endpoint, "/"),
+		Region:        settings.SigningLogS3Region,
+		Bucket:        settings.SigningLogS3Bucket,
+		AccessKey:     settings.SigningLogS3AccessKey,
+		SecretKey:     settings.SigningLogS3SecretKey,
+		RetentionMode: settings.SigningLogS3RetentionMode,
+		RetentionDays: settings.SigningLogS3RetentionDays,
+		Client:        &http.Client{Timeout: 30 * time.Second},
+		now:           time.Now,
+	}
+
+	if len(s.Region) == 0 || len(s.Bucket) == 0 || len(s.AccessKey) == 0 || len(s.SecretKey) == 0 {
+		return nil, errors.New("The S3 region, bucket, access key and secret key of the signing log sink must be supplied")
+	}
+	if len(s.Endpoint) == 0 {
+		s.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+	if len(s.RetentionMode) == 0 {
+		s.RetentionMode = RetentionCompliance
+	}
+	if s.RetentionMode != RetentionCompliance && s.RetentionMode != RetentionGovernance {
+		return nil, errors.New("The S3 retention mode must be COMPLIANCE or GOVERNANCE")
+	}
+	if s.RetentionDays < 0 {
+		return nil, errors.New("The S3 retention days must not be negative")
+	}
+	return s, nil
+}
+
+// Name identifies the sink
+func (s *S3) Name() string {
+	return S3Sink
+}
+
+// Write stores the entry as a locked JSON object. An existing object is not overwritten, and
+// is taken to be the entry already written e.g. by a retry after the response was lost
+func (s *S3) Write(ctx context.Context, signLog datastore.SigningLog) error {
+	body, err := json.Marshal(signLog)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(body)
+
+	req, err := http.NewRequest("PUT", s.objectURL(datastore.SigningLogSinkKey(signLog), nil), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("If-None-Match", "*")
+	if s.RetentionDays > 0 {
+		req.Header.Set("X-Amz-Object-Lock-Mode", s.RetentionMode)
+		req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", s.now().UTC().AddDate(0, 0, s.RetentionDays).Format(time.RFC3339))
+	}
+
+	resp, err := s.do(ctx, req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPreconditionFailed:
+		return nil
+	default:
+		return s3Error("writing", resp)
+	}
+}
+
+// listBucketResult is the response of the S3 list objects (v2) request
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the keys of the objects with the prefix, following the pages of the listing
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := http.NewRequest("GET", s.objectURL("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(ctx, req, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		result := listBucketResult{}
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error("listing", resp)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// objectURL is the path-style URL of an object in the bucket, or of the bucket when the key is empty
+func (s *S3) objectURL(key string, query url.Values) string {
+	u := s.Endpoint + "/" + s3Escape(s.Bucket, false)
+	if len(key) > 0 {
+		u += "/" + s3Escape(key, true)
+	}
+	if len(query) > 0 {
+		u += "?" + s3Query(query)
+	}
+	return u
+}
+
+// do signs and sends the request
+func (s *S3) do(ctx context.Context, req *http.Request, body []byte) (*http.Response, error) {
+	s.sign(req, body, s.now().UTC())
+	return s.Client.Do(req.WithContext(ctx))
+}
+
+// sign adds the AWS signature version 4 of the request, signing the host and all the headers of the request
+func (s *S3) sign(req *http.Request, body []byte, t time.Time) {
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", t.Format(s3TimeFormat))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := []string{}
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := strings.Join([]string{t.Format("20060102"), s.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{s3Algorithm, t.Format(s3TimeFormat), scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{t.Format("20060102"), s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3Algorithm, s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, content string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return mac.Sum(nil)
+}
+
+// s3Escape encodes all but the unreserved characters, as the signature requires. The
+// slashes of an object key are the separators of its path
+func s3Escape(s string, keepSlash bool) string {
+	var b bytes.Buffer
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes the query parameters in the sorted order of the signature
+func s3Query(query url.Values) string {
+	names := []string{}
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	params := []string{}
+	for _, k := range names {
+		for _, v := range query[k] {
+			params = append(params, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3Error reports the status and the start of the error document of a failed request
+func s3Error(operation string, resp *http.Response) error {
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("Error %s the signing log in S3: %s: %s", operation, resp.Status, strings.TrimSpace(string(content)))
+}
@@ -151,14 +151,19 @@ var getRequestID = func(url, apiKey string) (string, error) {
 }
 
 var getSerial = func(serialRequest, url, apiKey string) (string, error) {
+	return postAssertions("serial", serialRequest, url, apiKey)
+}
+
+// postAssertions sends assertions to the serial vault and returns the assertions in the response
+func postAssertions(method, assertions, url, apiKey string) (string, error) {
 	// Format the URL and headers for the HTTP call
-	req := getHTTPRequest("serial", url, serialRequest, apiKey)
+	req := getHTTPRequest(method, url, assertions, apiKey)
 
-	// Call the /request-id API
+	// Call the API
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Error fetching the serial assertion")
+		log.Printf("Error fetching the assertions from /%s\n", method)
 		return "", err
 	}
 	defer resp.Body.Close()
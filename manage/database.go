@@ -23,6 +23,7 @@ package manage
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 )
@@ -33,10 +34,10 @@ const (
 )
 
 type operation struct {
-	method     func() error
-	action     string
-	table      string
-	skipSqlite bool
+	method    func() error
+	action    string
+	table     string
+	cloudOnly bool
 }
 
 func execOne(method func() error, action, tableName string) {
@@ -50,7 +51,7 @@ func execOne(method func() error, action, tableName string) {
 
 func exec(operations []operation) {
 	for _, op := range operations {
-		if op.skipSqlite && datastore.InFactory() {
+		if op.cloudOnly && datastore.InFactory() {
 			continue
 		}
 		execOne(op.method, op.action, op.table)
@@ -85,8 +86,20 @@ func UpdateDatabase() {
 		// Create the keypair table, if it does not exist
 		{datastore.Environ.DB.CreateSettingsTable, create, "settings", false},
 
+		// Create the config settings and their history tables, if they do not exist
+		{datastore.Environ.DB.CreateConfigSettingTables, create, "config setting", true},
+
+		// Create the instance registry table, if it does not exist
+		{datastore.Environ.DB.CreateInstanceTable, create, "instance", true},
+		{datastore.Environ.DB.CreateFactoryCheckInTable, create, "factory check-in", true},
+
 		// Create the signinglog table, if it does not exist
 		{datastore.Environ.DB.CreateSigningLogTable, create, "signinglog", false},
+		{datastore.Environ.DB.CreateSigningLogCheckpointTable, create, "signinglog checkpoint", false},
+		{datastore.Environ.DB.CreateSigningLogSinkQueueTable, create, "signinglog sink queue", false},
+
+		// Create the trigram indexes of the signing log search, if they do not exist (Postgres only)
+		{datastore.Environ.DB.CreateSigningLogSearchIndexes, create, "signinglog search index", true},
 
 		// Create the nonce table, if it does not exist
 		{datastore.Environ.DB.CreateDeviceNonceTable, create, "nonce", false},
@@ -95,6 +108,9 @@ func UpdateDatabase() {
 		{datastore.Environ.DB.CreateAccountTable, create, "account", false},
 		{datastore.Environ.DB.AlterAccountTable, update, "account", false},
 
+		// Create the table of the account-key assertions synced to the factory, if it does not exist
+		{datastore.Environ.DB.CreateAccountKeyTable, create, "account key", false},
+
 		// Update the model table, adding the new user-keypair field
 		{datastore.Environ.DB.AlterModelTable, update, "model", false},
 
@@ -117,19 +133,89 @@ func UpdateDatabase() {
 		{datastore.Environ.DB.CreateKeypairStatusTable, create, "keypair status", false},
 		{datastore.Environ.DB.AlterKeypairStatusTable, update, "keypair status", false},
 
+		// Create the table of the brands/models signed by the signing-keys, if it does not exist
+		{datastore.Environ.DB.CreateKeypairUsageTable, create, "keypair usage", false},
+
+		// Create the table of the model allowlists of the signing-keys, if it does not exist
+		{datastore.Environ.DB.CreateKeypairModelTable, create, "keypair model", false},
+
+		// Create the table of the compromised signing-keys, if it does not exist
+		{datastore.Environ.DB.CreateKeypairCompromiseTable, create, "keypair compromise", false},
+
+		// Create the brand root key and key delegation tables, if they do not exist
+		{datastore.Environ.DB.CreateKeyDelegationTables, create, "key delegation", false},
+
+		// Create the table of the serial assertions of the signed serial-requests, if it does not exist
+		{datastore.Environ.DB.CreateSerialReplayTable, create, "serial replay", false},
+
 		// Create the Model Assertion table, if it does not exist
 		{datastore.Environ.DB.CreateModelAssertTable, create, "model assertion", false},
 		{datastore.Environ.DB.AlterModelAssertTable, update, "model assertion", false},
 
-		// Create the Sub-store table, if it does not exist
+		// Create the Sub-store table, if it does not exist, and add the pattern field
 		{datastore.Environ.DB.CreateSubstoreTable, create, "sub-store", false},
+		{datastore.Environ.DB.AlterSubstoreTable, update, "sub-store", false},
+
+		// Create the station table, if it does not exist
+		{datastore.Environ.DB.CreateStationTable, create, "station", false},
+
+		// Create the table of the fallback signing-keys of the models, if it does not exist
+		{datastore.Environ.DB.CreateModelFallbackKeyTable, create, "model fallback key", false},
+
+		// Create the tables of the canary signing-keys of the models and their results, if they do not exist
+		{datastore.Environ.DB.CreateModelCanaryTables, create, "model canary key", false},
+
+		// Create the table of the models assigned to sync users (cloud only)
+		{datastore.Environ.DB.CreateSyncModelAssignmentTable, create, "sync user model", true},
+
+		// Create the table of the tokens that share the signing log with partners (cloud only)
+		{datastore.Environ.DB.CreateShareTokenTable, create, "share token", true},
+
+		// Create the table of the annotations of the signing log (cloud only)
+		{datastore.Environ.DB.CreateSigningLogAnnotationTable, create, "signing log annotation", true},
+
+		// Create the table of the approvals of the sensitive operations (cloud only)
+		{datastore.Environ.DB.CreateApprovalTable, create, "approval", true},
+
+		// Create the table of the onboarding of the new brands (cloud only)
+		{datastore.Environ.DB.CreateOnboardingTable, create, "onboarding", true},
+
+		// Create the tables of the close-out snapshots of the billing periods (cloud only)
+		{datastore.Environ.DB.CreateBillingTables, create, "billing", true},
+
+		// Create the table of the audit log of the admin actions (cloud only)
+		{datastore.Environ.DB.CreateAuditLogTable, create, "audit log", true},
+
+		// Create the table of the signing authorizations synced to the factory
+		{datastore.Environ.DB.CreateSigningAuthorizationTable, create, "signing authorization", false},
 
 		// Create the testlog table, if it does not exist
 		{datastore.Environ.DB.CreateTestLogTable, create, "testlog", false},
+
+		// Create the tables of the device manifests, if they do not exist
+		{datastore.Environ.DB.CreateDeviceManifestTable, create, "device manifest", false},
+
+		// Create the table of the lifecycle states of the devices, if it does not exist
+		{datastore.Environ.DB.CreateDeviceStateTable, create, "device state", false},
+
+		// Create the table of the quarantined devices, if it does not exist
+		{datastore.Environ.DB.CreateDeviceQuarantineTable, create, "device quarantine", false},
+
+		// Create the table of the certificates issued to the devices, if it does not exist
+		{datastore.Environ.DB.CreateDeviceCertificateTable, create, "device certificate", false},
+
+		// Create the table of the audit of the re-pointed keypairs of the models (cloud only)
+		{datastore.Environ.DB.CreateModelKeypairHistoryTable, create, "model keypair history", true},
 	}
 
 	exec(operations)
 
+	// Record the version of the schema, which the startup self-check compares with the release
+	execOne(func() error { return datastore.Environ.DB.PutSchemaVersion(datastore.SchemaVersion) }, update, "settings")
+
+	// Detect the near-duplicate models, which are ambiguous now that the model names are canonicalized
+	checkNearDuplicateModels()
+
 	// Create the test key (if the filesystem store is used)
 	if datastore.Environ.Config.KeyStoreType == "filesystem" {
 		// Create the test key as it is in the default filesystem keystore
@@ -148,3 +234,21 @@ func UpdateDatabase() {
 	}
 
 }
+
+// checkNearDuplicateModels reports the models that have the same canonical brand ID and model
+// name. They are not changed, as the models that the devices use must be decided by an admin
+func checkNearDuplicateModels() {
+	duplicates, err := datastore.Environ.DB.ListNearDuplicateModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, d := range duplicates {
+		names := []string{}
+		for _, m := range d.Models {
+			names = append(names, fmt.Sprintf("%d '%s/%s'", m.ID, m.BrandID, m.Name))
+		}
+		fmt.Printf("Warning: the models %s are all the model '%s/%s'. Rename or delete all but one of them.\n", strings.Join(names, ", "), d.BrandID, d.Name)
+	}
+	fmt.Printf("Checked the 'model' table for near-duplicates: %d found.\n", len(duplicates))
+}
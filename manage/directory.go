@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package manage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/directory"
+)
+
+// DirectoryCommand is the main command for the users that are synced from the LDAP or Active Directory groups
+type DirectoryCommand struct {
+	Sync DirectorySyncCommand `command:"sync" description:"Sync the users, roles and accounts from the directory groups"`
+}
+
+// DirectorySyncCommand syncs the users from the directory groups of the config
+type DirectorySyncCommand struct {
+	DryRun bool `long:"dry-run" description:"Report the changes without changing the users"`
+}
+
+// Execute the sync of the users from the directory
+func (cmd DirectorySyncCommand) Execute(args []string) error {
+	openDatabase()
+
+	result, err := directory.Sync(context.Background(), datastore.Environ, cmd.DryRun)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if result.DryRun {
+		prefix = "(dry-run) "
+	}
+	for _, u := range result.Created {
+		fmt.Printf("%sCreated: %s\n", prefix, u)
+	}
+	for _, u := range result.Updated {
+		fmt.Printf("%sUpdated: %s\n", prefix, u)
+	}
+	for _, u := range result.Deleted {
+		fmt.Printf("%sDeleted: %s\n", prefix, u)
+	}
+	for _, e := range result.Errors {
+		fmt.Printf("%sError: %s\n", prefix, e)
+	}
+	fmt.Printf("%s%d created, %d updated, %d deleted, %d unchanged\n", prefix, len(result.Created), len(result.Updated), len(result.Deleted), result.Unchanged)
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("The directory sync has %d errors", len(result.Errors))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/compromise"
+	"github.com/CanonicalLtd/serial-vault/store"
+)
+
+// KeypairCommand is the main command for signing-key management
+type KeypairCommand struct {
+	Assign       KeypairAssignCommand       `command:"assign" description:"Re-point the models of a signing-key to another signing-key"`
+	Compromise   KeypairCompromiseCommand   `command:"compromise" description:"Revoke a compromised signing-key and report the affected models and devices"`
+	Registration KeypairRegistrationCommand `command:"registration" description:"Check that the account-keys of the signing-keys are registered and valid in the store"`
+}
+
+// KeypairRegistrationCommand checks the account-key assertion of each signing-key in the
+// store, and reports the keys whose devices will be rejected when they register
+type KeypairRegistrationCommand struct{}
+
+// Execute the registration check of the signing-keys, optionally for a single account
+func (cmd KeypairRegistrationCommand) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("Registration expects an optional authority-id argument")
+	}
+
+	openDatabase()
+
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		return fmt.Errorf("Error retrieving the signing-keys: %v", err)
+	}
+
+	now := time.Now()
+	checked, rejected := 0, 0
+	for _, k := range keypairs {
+		if len(args) == 1 && k.AuthorityID != args[0] {
+			continue
+		}
+
+		reg := store.CheckKeyRegistration(k, now)
+		checked++
+		if reg.Rejected {
+			rejected++
+		}
+		fmt.Printf("%s/%s (%s): %s %s\n", reg.AuthorityID, reg.KeyID, reg.KeyName, reg.Status, reg.Message)
+	}
+
+	fmt.Printf("Checked the store registration of %d signing-keys\n", checked)
+	if rejected > 0 {
+		return fmt.Errorf("The store will reject the devices signed by %d signing-keys", rejected)
+	}
+	return nil
+}
+
+// KeypairAssignCommand re-points the models of a signing-key to another signing-key in one
+// transaction e.g. when the signing-key is retired, recording the change of each model
+type KeypairAssignCommand struct {
+	From    int   `short:"f" long:"from" description:"The ID of the signing-key of the models" required:"yes"`
+	To      int   `short:"t" long:"to" description:"The ID of the new signing-key" required:"yes"`
+	Models  []int `short:"m" long:"model" description:"The ID of a model to re-point (default: all the models of the signing-key)"`
+	Preview bool  `long:"preview" description:"List the models that would be re-pointed without changing them"`
+}
+
+// Execute the re-pointing of the models to the new signing-key
+func (cmd KeypairAssignCommand) Execute(args []string) error {
+	openDatabase()
+
+	assignment := datastore.ModelKeypairAssignment{FromKeypairID: cmd.From, KeypairID: cmd.To, ModelIDs: cmd.Models, Preview: cmd.Preview}
+	changes, err := datastore.Environ.DB.AssignAllowedModelKeypair(assignment, datastore.User{Role: datastore.Superuser, Username: "serial-vault-admin"})
+	if err != nil {
+		return fmt.Errorf("Error assigning the signing-key: %v", err)
+	}
+
+	for _, c := range changes {
+		fmt.Printf("%s/%s (%d): signing-key %d -> %d, system-user key %d -> %d\n", c.BrandID, c.Model, c.ModelID, c.OldKeypairID, c.NewKeypairID, c.OldKeypairIDUser, c.NewKeypairIDUser)
+	}
+
+	if cmd.Preview {
+		fmt.Printf("Would re-point %d models to signing-key %d\n", len(changes), cmd.To)
+		return nil
+	}
+	fmt.Printf("Re-pointed %d models to signing-key %d\n", len(changes), cmd.To)
+	return nil
+}
+
+// KeypairCompromiseCommand revokes a compromised signing-key: it is deactivated everywhere, the
+// factories revoke it on their next sync and its models are blocked. The devices that it signed
+// are written as CSV to the output file
+type KeypairCompromiseCommand struct {
+	ID     int    `long:"id" description:"The ID of the compromised signing-key" required:"yes"`
+	Reason string `long:"reason" description:"The reason of the compromise, for the audit log" required:"yes"`
+	Output string `short:"o" long:"output" description:"The CSV file of the affected devices (default: no file)"`
+}
+
+// Execute the compromise action of the signing-key
+func (cmd KeypairCompromiseCommand) Execute(args []string) error {
+	openDatabase()
+
+	user := datastore.User{Role: datastore.Superuser, Username: "serial-vault-admin"}
+	report, err := compromise.Compromise(datastore.Environ.DB, datastore.Environ.Config, cmd.ID, cmd.Reason, user)
+	if err != nil {
+		return fmt.Errorf("Error revoking the signing-key: %v", err)
+	}
+
+	for _, m := range report.Models {
+		fmt.Printf("%s/%s (%d): %s\n", m.BrandID, m.Model, m.ID, m.Role)
+	}
+	fmt.Printf("Revoked the signing-key %s/%s, used by %d models and signed %d devices\n", report.Compromise.AuthorityID, report.Compromise.KeyID, len(report.Models), len(report.Devices))
+
+	if len(cmd.Output) == 0 {
+		return nil
+	}
+	f, err := os.Create(cmd.Output)
+	if err != nil {
+		return fmt.Errorf("Error creating the report: %v", err)
+	}
+	defer f.Close()
+	return report.WriteCSV(f)
+}
@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/store"
+	"gopkg.in/check.v1"
+)
+
+type KeypairSuite struct {
+	fetch func(string) (store.AccountKey, error)
+}
+
+var _ = check.Suite(&KeypairSuite{})
+
+func (s *KeypairSuite) SetUpTest(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+	s.fetch = store.FetchAccountKey
+	store.FetchAccountKey = func(keyID string) (store.AccountKey, error) {
+		switch keyID {
+		case "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO":
+			return store.AccountKey{AccountID: "system", Since: time.Now().AddDate(-1, 0, 0)}, nil
+		case "inactiveone":
+			return store.AccountKey{}, errors.New("MOCK store unavailable")
+		default:
+			return store.AccountKey{}, store.ErrAccountKeyNotFound
+		}
+	}
+}
+
+func (s *KeypairSuite) TearDownTest(c *check.C) {
+	store.FetchAccountKey = s.fetch
+}
+
+func (s *KeypairSuite) TestKeypairRegistration(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "keypair"},
+			ErrorMessage: "Please specify one command of: assign or registration"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "registration", "system", "systemone"},
+			ErrorMessage: "Registration expects an optional authority-id argument"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "registration"},
+			ErrorMessage: "The store will reject the devices signed by 2 signing-keys"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "registration", "system"},
+			ErrorMessage: "The store will reject the devices signed by 1 signing-keys"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "registration", "systemone"},
+			ErrorMessage: "The store will reject the devices signed by 1 signing-keys"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "registration", "unknown"},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *KeypairSuite) TestKeypairRegistrationError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	runTest(c, []string{"serial-vault-admin", "keypair", "registration"}, "Error retrieving the signing-keys: MOCK Error fetching from the database")
+}
+
+func (s *KeypairSuite) TestKeypairAssign(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "assign", "--from=1"},
+			ErrorMessage: "the required flag `-t, --to' was not specified"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "assign", "--from=1", "--to=1"},
+			ErrorMessage: "Error assigning the signing-key: The new keypair must be different to the keypair of the models"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "assign", "--from=1", "--to=2", "--preview"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "assign", "--from=1", "--to=2", "--model=1"},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *KeypairSuite) TestKeypairAssignError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	runTest(c, []string{"serial-vault-admin", "keypair", "assign", "--from=1", "--to=2"}, "Error assigning the signing-key: MOCK error assigning the keypair of the models")
+}
+
+func (s *KeypairSuite) TestKeypairCompromise(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "compromise", "--id=1"},
+			ErrorMessage: "the required flag `--reason' was not specified"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "compromise", "--id=1", "--reason=stolen"},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *KeypairSuite) TestKeypairCompromiseError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	runTest(c, []string{"serial-vault-admin", "keypair", "compromise", "--id=1", "--reason=stolen"}, "Error revoking the signing-key: MOCK error compromising the signing-key")
+}
@@ -0,0 +1,162 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// rotationChallenge is signed by every signing-key to verify the rotation
+const rotationChallenge = "keystore-secret-rotation"
+
+// KeystoreCommand is the main command for keystore management
+type KeystoreCommand struct {
+	RotateSecret KeystoreRotateSecretCommand `command:"rotate-secret" description:"Re-encrypt the sealed signing-keys under a new keystore secret"`
+	Encrypt      KeystoreEncryptCommand      `command:"encrypt" description:"Encrypt the unencrypted signing-keys of the filesystem keystore"`
+}
+
+// KeystoreEncryptCommand migrates a filesystem keystore with unencrypted signing-keys, sealing
+// each key with the keystore secret and removing the unencrypted key. The services do not
+// open a filesystem keystore that has unencrypted keys
+type KeystoreEncryptCommand struct{}
+
+// Execute the encryption of the filesystem keystore
+func (cmd KeystoreEncryptCommand) Execute(args []string) error {
+	openDatabase()
+
+	if datastore.Environ.Config.KeyStoreType != datastore.FilesystemStore.Name {
+		return errors.New("Only the filesystem keystore can be encrypted")
+	}
+
+	encrypted, err := datastore.EncryptFilesystemKeystore(datastore.Environ.Config.KeyStorePath, datastore.Environ.Config.KeyStoreSecret)
+	for _, keyID := range encrypted {
+		fmt.Printf("Encrypted signing-key %s\n", keyID)
+	}
+	if err != nil {
+		return fmt.Errorf("Error encrypting the filesystem keystore: %v", err)
+	}
+
+	// Verify that the keystore opens with the secret
+	if err = datastore.OpenKeyStore(datastore.Environ.Config); err != nil {
+		return fmt.Errorf("Error opening the keystore: %v", err)
+	}
+	fmt.Printf("Encrypted %d signing-keys of the filesystem keystore\n", len(encrypted))
+	return nil
+}
+
+// KeystoreRotateSecretCommand re-encrypts the database keystore under a new secret. Each
+// signing-key is updated in its own transaction and keys that already use the new secret
+// are skipped, so an interrupted rotation is resumed by running the command again. The
+// settings file must keep the current secret until the rotation has completed
+type KeystoreRotateSecretCommand struct {
+	NewSecret string `long:"new-secret" env:"KEYSTORE_NEW_SECRET" description:"The new keystore secret"`
+}
+
+// Execute the keystore secret rotation
+func (cmd KeystoreRotateSecretCommand) Execute(args []string) error {
+	openDatabase()
+
+	if datastore.Environ.Config.KeyStoreType != datastore.DatabaseStore.Name {
+		return errors.New("The secret can only be rotated for the database keystore")
+	}
+	if len(cmd.NewSecret) == 0 {
+		return errors.New("The new keystore secret must be entered")
+	}
+	if cmd.NewSecret == datastore.Environ.Config.KeyStoreSecret {
+		return errors.New("The new keystore secret must be different to the current secret")
+	}
+
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		return fmt.Errorf("Error retrieving the signing-keys: %v", err)
+	}
+
+	for _, k := range keypairs {
+		keypair, err := datastore.Environ.DB.GetKeypair(k.ID)
+		if err != nil {
+			return fmt.Errorf("Error retrieving signing-key %s/%s: %v", k.AuthorityID, k.KeyID, err)
+		}
+
+		rotated, err := datastore.RotateKeypairSecret(keypair, cmd.NewSecret)
+		if err != nil {
+			return fmt.Errorf("Error rotating signing-key %s/%s: %v", k.AuthorityID, k.KeyID, err)
+		}
+		fmt.Printf("%s signing-key %s/%s\n", rotationStatus(rotated), k.AuthorityID, k.KeyID)
+	}
+
+	rotated, err := datastore.RotateReportKeySecret(cmd.NewSecret)
+	if err != nil {
+		return fmt.Errorf("Error rotating the reporting key: %v", err)
+	}
+	fmt.Printf("%s the reporting key\n", rotationStatus(rotated))
+
+	count, err := datastore.Environ.DB.ResignSigningLogCheckpoints(cmd.NewSecret)
+	if err != nil {
+		return fmt.Errorf("Error re-signing the signing log checkpoints: %v", err)
+	}
+	fmt.Printf("Re-signed %d signing log checkpoints\n", count)
+
+	if err = verifyKeystoreSecret(keypairs, cmd.NewSecret); err != nil {
+		return err
+	}
+
+	fmt.Println("The keystore has been rotated: update the keystoreSecret in the settings file and restart the services")
+	return nil
+}
+
+// verifyKeystoreSecret checks that every signing-key can be unsealed and signs with the new secret,
+// using a fresh keystore so that none of the keys are already unsealed in memory
+func verifyKeystoreSecret(keypairs []datastore.Keypair, newSecret string) error {
+	datastore.Environ.Config.KeyStoreSecret = newSecret
+	if err := datastore.OpenKeyStore(datastore.Environ.Config); err != nil {
+		return fmt.Errorf("Error opening the keystore: %v", err)
+	}
+
+	failed := 0
+	for _, k := range keypairs {
+		keypair, err := datastore.Environ.DB.GetKeypair(k.ID)
+		if err != nil {
+			return fmt.Errorf("Error retrieving signing-key %s/%s: %v", k.AuthorityID, k.KeyID, err)
+		}
+
+		attestation := datastore.Environ.KeypairDB.AttestKeypair(context.Background(), keypair, rotationChallenge)
+		if len(attestation.ProofError) > 0 {
+			fmt.Printf("Signing-key %s/%s cannot sign with the new secret: %s\n", k.AuthorityID, k.KeyID, attestation.ProofError)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("The verification of the new keystore secret failed for %d signing-keys", failed)
+	}
+	fmt.Printf("Verified %d signing-keys with the new secret\n", len(keypairs))
+	return nil
+}
+
+func rotationStatus(rotated bool) string {
+	if rotated {
+		return "Rotated"
+	}
+	return "Skipped"
+}
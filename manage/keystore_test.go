@@ -0,0 +1,103 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"gopkg.in/check.v1"
+)
+
+type KeystoreSuite struct{}
+
+var _ = check.Suite(&KeystoreSuite{})
+
+func (s *KeystoreSuite) SetUpTest(c *check.C) {
+	datastore.Environ = &datastore.Env{
+		DB:     &datastore.MockDB{},
+		Config: config.Settings{KeyStoreType: "database", KeyStoreSecret: "this needs to be something secure"},
+	}
+}
+
+func (s *KeystoreSuite) TestKeystore(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "keystore"},
+			ErrorMessage: "Please specify one command of: encrypt or rotate-secret"},
+		{
+			Args:         []string{"serial-vault-admin", "keystore", "rotate-secret"},
+			ErrorMessage: "The new keystore secret must be entered"},
+		{
+			Args:         []string{"serial-vault-admin", "keystore", "rotate-secret", "--new-secret", "this needs to be something secure"},
+			ErrorMessage: "The new keystore secret must be different to the current secret"},
+		{
+			Args:         []string{"serial-vault-admin", "keystore", "rotate-secret", "--new-secret", "this is something new"},
+			ErrorMessage: "Error rotating signing-key system/UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO: The signing-key is not sealed with the current keystore secret"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *KeystoreSuite) TestKeystoreFilesystem(c *check.C) {
+	datastore.Environ.Config.KeyStoreType = "filesystem"
+
+	runTest(c, []string{"serial-vault-admin", "keystore", "rotate-secret", "--new-secret", "this is something new"}, "The secret can only be rotated for the database keystore")
+}
+
+func (s *KeystoreSuite) TestKeystoreError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	runTest(c, []string{"serial-vault-admin", "keystore", "rotate-secret", "--new-secret", "this is something new"}, "Error retrieving the signing-keys: MOCK Error fetching from the database")
+}
+
+func (s *KeystoreSuite) TestKeystoreEncrypt(c *check.C) {
+	const keyID = "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"
+
+	runTest(c, []string{"serial-vault-admin", "keystore", "encrypt"}, "Only the filesystem keystore can be encrypted")
+
+	// Copy the test key to an unencrypted keystore
+	path := c.MkDir()
+	data, err := ioutil.ReadFile("../keystore/TestKey.snapd")
+	c.Assert(err, check.IsNil)
+	c.Assert(os.Mkdir(filepath.Join(path, "private-keys-v1"), 0700), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(path, "private-keys-v1", keyID), data, 0600), check.IsNil)
+
+	datastore.Environ.Config = config.Settings{KeyStoreType: "filesystem", KeyStorePath: path}
+	runTest(c, []string{"serial-vault-admin", "keystore", "encrypt"}, "Error encrypting the filesystem keystore: The filesystem keystore requires the keystoreSecret to encrypt the signing-keys")
+
+	datastore.Environ.Config.KeyStoreSecret = "this needs to be something secure"
+	c.Assert(datastore.OpenKeyStore(datastore.Environ.Config), check.ErrorMatches, "The filesystem keystore has 1 unencrypted signing-keys.*")
+	runTest(c, []string{"serial-vault-admin", "keystore", "encrypt"}, "")
+
+	_, err = os.Stat(filepath.Join(path, "private-keys-v1", keyID))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(filepath.Join(path, "sealed-keys-v1", keyID))
+	c.Assert(err, check.IsNil)
+
+	// The keystore does not open with a different secret
+	datastore.Environ.Config.KeyStoreSecret = "this is something else"
+	c.Assert(datastore.OpenKeyStore(datastore.Environ.Config), check.ErrorMatches, "Cannot unseal signing-key .*")
+}
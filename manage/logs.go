@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+)
+
+// tailReconnect is the time before the stream is opened again, when it is dropped
+var tailReconnect = 5 * time.Second
+
+// LogsCommand is the command for the live signing activity
+type LogsCommand struct {
+	Tail LogsTailCommand `command:"tail" alias:"t" description:"Follow the signing log as the devices are signed"`
+}
+
+// LogsTailCommand streams the signing log entries from the admin API of a serial vault as the
+// devices are signed, reconnecting when the stream is dropped
+type LogsTailCommand struct {
+	URL     string `short:"u" long:"url" description:"The base URL of the serial vault admin API" required:"yes"`
+	User    string `long:"user" description:"The username for the admin API" required:"yes"`
+	APIKey  string `short:"a" long:"api" description:"The API key of the user" required:"yes"`
+	Brand   string `short:"b" long:"brand" description:"Only show the devices of the brand"`
+	Model   string `short:"m" long:"model" description:"Only show the devices of the model"`
+	Serial  string `short:"s" long:"serial" description:"Only show the device with the serial number"`
+	Station string `long:"station" description:"Only show the devices of the provisioning station"`
+	JSON    bool   `long:"json" description:"Print the signing log entries as JSON"`
+}
+
+// Execute the tail of the signing log
+func (cmd LogsTailCommand) Execute(args []string) error {
+	lastID := -1
+	for {
+		err := cmd.tail(context.Background(), os.Stdout, &lastID)
+		if _, ok := err.(tailError); ok {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "The signing log stream was dropped (%v), reconnecting in %s\n", err, tailReconnect)
+		time.Sleep(tailReconnect)
+	}
+}
+
+// tailError is an error response of the admin API, which is not retried
+type tailError struct {
+	message string
+}
+
+func (e tailError) Error() string {
+	return e.message
+}
+
+// tail prints the signing log entries of the stream after the last ID, updating it, until the
+// stream ends. A negative ID starts the stream with the entries that are signed from now on
+func (cmd LogsTailCommand) tail(ctx context.Context, out io.Writer, lastID *int) error {
+	req, err := http.NewRequest("GET", cmd.tailURL(), nil)
+	if err != nil {
+		return tailError{fmt.Sprintf("Error creating the signing log request: %v", err)}
+	}
+	req.Header.Set("user", cmd.User)
+	req.Header.Set("api-key", cmd.APIKey)
+	req.Header.Set("Accept", stream.ContentType)
+	if *lastID >= 0 {
+		req.Header.Set(stream.LastEventIDHeader, fmt.Sprint(*lastID))
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), stream.ContentType) {
+		result := response.StandardResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return tailError{fmt.Sprintf("Error streaming the signing log: %s", resp.Status)}
+		}
+		return tailError{fmt.Sprintf("Error streaming the signing log: %s: %s", result.ErrorCode, result.ErrorMessage)}
+	}
+
+	sr := stream.NewReader(resp.Body)
+	for {
+		msg, err := sr.Next()
+		if err == io.EOF {
+			return errors.New("The stream ended")
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Event != signinglog.SigningEvent {
+			continue
+		}
+
+		l := datastore.SigningLog{}
+		if err := json.Unmarshal([]byte(msg.Data), &l); err != nil {
+			return err
+		}
+		*lastID = l.ID
+		cmd.print(out, l)
+	}
+}
+
+// tailURL returns the URL of the stream, with the filters of the command
+func (cmd LogsTailCommand) tailURL() string {
+	query := url.Values{}
+	for k, v := range map[string]string{"brand": cmd.Brand, "model": cmd.Model, "serial": cmd.Serial, "station": cmd.Station} {
+		if len(v) > 0 {
+			query.Set(k, v)
+		}
+	}
+
+	u := strings.TrimSuffix(cmd.URL, "/") + "/api/signinglog/tail"
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// print writes a signing log entry as a line, or as JSON
+func (cmd LogsTailCommand) print(out io.Writer, l datastore.SigningLog) {
+	if cmd.JSON {
+		json.NewEncoder(out).Encode(l)
+		return
+	}
+
+	line := fmt.Sprintf("%s  %s/%s  %s  revision %d", l.Created.Local().Format("2006-01-02 15:04:05"), l.Make, l.Model, l.SerialNumber, l.Revision)
+	if len(l.Station) > 0 {
+		line += "  station " + l.Station
+	}
+	if len(l.FallbackKeyID) > 0 {
+		line += "  fallback-key " + l.FallbackKeyID
+	}
+	fmt.Fprintln(out, line)
+}
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+	"gopkg.in/check.v1"
+)
+
+type LogsSuite struct{}
+
+var _ = check.Suite(&LogsSuite{})
+
+func (s *LogsSuite) SetUpTest(c *check.C) {
+	Manage.Logs = LogsCommand{}
+}
+
+func (s *LogsSuite) TestLogsTailArgs(c *check.C) {
+	runTest(c, []string{"serial-vault-admin", "logs", "tail"}, "the required flags `--user', `-a, --api' and `-u, --url' were not specified")
+}
+
+func (s *LogsSuite) TestLogsTail(c *check.C) {
+	var query, lastEventID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		lastEventID = r.Header.Get(stream.LastEventIDHeader)
+
+		sw, err := stream.New(w)
+		c.Assert(err, check.IsNil)
+		sw.Event("4", "signing", map[string]interface{}{"id": 4, "make": "system", "model": "alder", "serialnumber": "A1", "revision": 1, "station": "line-1", "created": "2018-05-01T10:00:00Z"})
+		sw.KeepAlive()
+		sw.Event("", "alert", "not a signing log entry")
+		sw.Event("9", "signing", map[string]interface{}{"id": 9, "make": "system", "model": "alder", "serialnumber": "A2", "revision": 2, "created": "2018-05-01T10:00:05Z"})
+	}))
+	defer server.Close()
+
+	cmd := LogsTailCommand{URL: server.URL + "/", User: "sv", APIKey: "ValidAPIKey", Model: "alder", Station: "line-1"}
+	out := &bytes.Buffer{}
+	lastID := 3
+
+	err := cmd.tail(context.Background(), out, &lastID)
+	c.Assert(err, check.ErrorMatches, "The stream ended")
+	c.Assert(query, check.Equals, "model=alder&station=line-1")
+	c.Assert(lastEventID, check.Equals, "3")
+	c.Assert(lastID, check.Equals, 9)
+	c.Assert(out.String(), check.Matches, `(?s).*system/alder  A1  revision 1  station line-1\n.*system/alder  A2  revision 2\n`)
+}
+
+func (s *LogsSuite) TestLogsTailError(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response.FormatStandardResponse(false, "error-auth", "", "Invalid API key used", w)
+	}))
+	defer server.Close()
+
+	cmd := LogsTailCommand{URL: server.URL, User: "sv", APIKey: "InvalidAPIKey"}
+	lastID := -1
+
+	err := cmd.tail(context.Background(), &bytes.Buffer{}, &lastID)
+	c.Assert(err, check.FitsTypeOf, tailError{})
+	c.Assert(err, check.ErrorMatches, fmt.Sprintf("Error streaming the signing log: %s: %s", "error-auth", "Invalid API key used"))
+}
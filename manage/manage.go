@@ -28,10 +28,19 @@ import (
 type Command struct {
 	SettingsFile string `short:"c" long:"config" description:"Path to the config file" default:"./settings.yaml"`
 
-	Account  AccountCommand  `command:"account" alias:"a" description:"Account management"`
-	Client   ClientCommand   `command:"client" alias:"c" description:"Serial-Vault Client to generate a test serial assertion request"`
-	Database DatabaseCommand `command:"database" alias:"d" description:"Database schema update"`
-	User     UserCommand     `command:"user" alias:"u" description:"User management"`
+	Account    AccountCommand        `command:"account" alias:"a" description:"Account management"`
+	Client     ClientCommand         `command:"client" alias:"c" description:"Serial-Vault Client to generate a test serial assertion request"`
+	Database   DatabaseCommand       `command:"database" alias:"d" description:"Database schema update"`
+	Directory  DirectoryCommand      `command:"directory" description:"Sync the users from the LDAP or Active Directory groups"`
+	Keypair    KeypairCommand        `command:"keypair" description:"Signing-key management"`
+	Keystore   KeystoreCommand       `command:"keystore" alias:"k" description:"Keystore management"`
+	Logs       LogsCommand           `command:"logs" alias:"l" description:"Live signing activity"`
+	Reconcile  ReconcileCommand      `command:"reconcile" alias:"r" description:"Reconcile the signed devices with the store's device registrations for a brand"`
+	SigningLog SigningLogCommand     `command:"signinglog" alias:"s" description:"Signing log integrity management"`
+	Simulate   SimulateDeviceCommand `command:"simulate-device" description:"Simulate a device registration against a serial vault, for end-to-end testing"`
+	Substore   SubstoreCommand       `command:"substore" description:"Bulk import and export of the sub-store mappings"`
+	User       UserCommand           `command:"user" alias:"u" description:"User management"`
+	Archive    VerifyArchiveCommand  `command:"verify-archive" description:"Verify the signatures of the archived serial assertions against the signing-keys"`
 }
 
 // Manage is the implementation of the command configuration for the serial-vault-admin command-line
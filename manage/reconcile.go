@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/store"
+)
+
+// ReconcileCommand compares the devices signed by the serial-vault with the devices
+// that have registered with the store for a brand. This command would normally be run as a cron
+type ReconcileCommand struct {
+	Email    string `short:"e" long:"email" description:"Store account email to fetch the device registrations"`
+	Password string `short:"p" long:"password" description:"Store account password to fetch the device registrations"`
+	OTP      string `short:"o" long:"otp" description:"Store account one-time password"`
+	File     string `short:"f" long:"file" description:"Read the device registrations from a store export instead of the store API"`
+	Grace    int    `short:"g" long:"grace" description:"Days after signing before a device that has not registered is reported" default:"7"`
+}
+
+// Reconciliation holds the discrepancies between the signed and the registered devices
+type Reconciliation struct {
+	Signed        int
+	Registered    int
+	NotRegistered []datastore.SignedDevice
+	NotSigned     []store.DeviceRegistration
+}
+
+// Execute the reconciliation of the signed devices with the store
+func (cmd ReconcileCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Reconcile expects a single brand-id argument")
+	}
+	brandID := args[0]
+
+	registered, err := cmd.registrations(brandID)
+	if err != nil {
+		return err
+	}
+
+	openDatabase()
+
+	signed, err := datastore.Environ.DB.ListSignedDevices(brandID)
+	if err != nil {
+		return fmt.Errorf("Error retrieving the signed devices: %v", err)
+	}
+
+	result := reconcileDevices(signed, registered, time.Now().AddDate(0, 0, -cmd.Grace))
+
+	fmt.Printf("Reconciled %d signed devices with %d store registrations for '%s'\n", result.Signed, result.Registered, brandID)
+	for _, d := range result.NotRegistered {
+		fmt.Printf("Signed but never registered: %s/%s (signed %s)\n", d.Model, d.Serial, d.Signed.Format(time.RFC3339))
+	}
+	for _, d := range result.NotSigned {
+		fmt.Printf("Registered but not signed: %s/%s (registered %s)\n", d.Model, d.Serial, d.Registered.Format(time.RFC3339))
+	}
+
+	if count := len(result.NotRegistered) + len(result.NotSigned); count > 0 {
+		return fmt.Errorf("The reconciliation found %d discrepancies", count)
+	}
+	return nil
+}
+
+// registrations fetches the device registrations from the store, or from an export file
+func (cmd ReconcileCommand) registrations(brandID string) ([]store.DeviceRegistration, error) {
+	if len(cmd.File) > 0 {
+		f, err := os.Open(cmd.File)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening the device registrations: %v", err)
+		}
+		defer f.Close()
+		return store.ReadDeviceRegistrations(f)
+	}
+
+	if len(cmd.Email) == 0 || len(cmd.Password) == 0 {
+		return nil, errors.New("The store email and password, or the registrations file, must be provided")
+	}
+
+	devices, err := store.FetchDeviceRegistrations(store.Auth{Email: cmd.Email, Password: cmd.Password, OTP: cmd.OTP}, brandID)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching the device registrations: %v", err)
+	}
+	return devices, nil
+}
+
+// reconcileDevices compares the signed and the registered devices. Devices signed after
+// the cutoff are not reported, as they may not have registered yet
+func reconcileDevices(signed []datastore.SignedDevice, registered []store.DeviceRegistration, cutoff time.Time) Reconciliation {
+	result := Reconciliation{Signed: len(signed), Registered: len(registered)}
+
+	signedDevices := map[string]bool{}
+	for _, d := range signed {
+		signedDevices[d.Model+"/"+d.Serial] = true
+	}
+
+	registeredDevices := map[string]bool{}
+	for _, d := range registered {
+		registeredDevices[d.Model+"/"+d.Serial] = true
+		if !signedDevices[d.Model+"/"+d.Serial] {
+			result.NotSigned = append(result.NotSigned, d)
+		}
+	}
+
+	for _, d := range signed {
+		if !registeredDevices[d.Model+"/"+d.Serial] && d.Signed.Before(cutoff) {
+			result.NotRegistered = append(result.NotRegistered, d)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/store"
+	"gopkg.in/check.v1"
+)
+
+type ReconcileSuite struct {
+	fetch func(store.Auth, string) ([]store.DeviceRegistration, error)
+}
+
+var _ = check.Suite(&ReconcileSuite{})
+
+const registrationsJSON = `{"devices": [
+	{"model": "alder", "serial": "A1", "registered": "2018-06-01T10:00:00Z"},
+	{"model": "alder", "serial": "A2", "registered": "2018-06-01T11:00:00Z"},
+	{"model": "alder", "serial": "B9", "registered": "2018-06-02T10:00:00Z"}
+]}`
+
+func (s *ReconcileSuite) SetUpTest(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+	Manage.Reconcile = ReconcileCommand{}
+	s.fetch = store.FetchDeviceRegistrations
+	store.FetchDeviceRegistrations = func(keyAuth store.Auth, brandID string) ([]store.DeviceRegistration, error) {
+		if keyAuth.Password != "secret" {
+			return nil, errors.New("MOCK invalid login")
+		}
+		return []store.DeviceRegistration{
+			{Model: "alder", Serial: "A1"}, {Model: "alder", Serial: "A2"}, {Model: "alder", Serial: "A3"}, {Model: "alder", Serial: "A4"},
+		}, nil
+	}
+}
+
+func (s *ReconcileSuite) TearDownTest(c *check.C) {
+	store.FetchDeviceRegistrations = s.fetch
+}
+
+func (s *ReconcileSuite) TestReconcile(c *check.C) {
+	file := filepath.Join(c.MkDir(), "registrations.json")
+	err := ioutil.WriteFile(file, []byte(registrationsJSON), 0600)
+	c.Assert(err, check.IsNil)
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "reconcile"},
+			ErrorMessage: "Reconcile expects a single brand-id argument"},
+		{
+			Args:         []string{"serial-vault-admin", "reconcile", "system"},
+			ErrorMessage: "The store email and password, or the registrations file, must be provided"},
+		{
+			Args:         []string{"serial-vault-admin", "reconcile", "system", "--email=a@example.com", "--password=invalid"},
+			ErrorMessage: "Error fetching the device registrations: MOCK invalid login"},
+		{
+			Args:         []string{"serial-vault-admin", "reconcile", "system", "--email=a@example.com", "--password=secret"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "reconcile", "system", "--file=" + file},
+			ErrorMessage: "The reconciliation found 1 discrepancies"},
+		{
+			Args:         []string{"serial-vault-admin", "reconcile", "system", "--file=" + file + ".missing"},
+			ErrorMessage: "Error opening the device registrations: .*"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *ReconcileSuite) TestReconcileError(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}}
+
+	runTest(c, []string{"serial-vault-admin", "reconcile", "system", "--email=a@example.com", "--password=secret"}, "Error retrieving the signed devices: MOCK error retrieving the signed devices")
+}
+
+func (s *ReconcileSuite) TestReconcileDevices(c *check.C) {
+	now := time.Now()
+	signed := []datastore.SignedDevice{
+		{Model: "alder", Serial: "A1", Signed: now.AddDate(0, 0, -30)},
+		{Model: "alder", Serial: "A2", Signed: now.AddDate(0, 0, -30)},
+		{Model: "alder", Serial: "A3", Signed: now},
+	}
+	registered := []store.DeviceRegistration{
+		{Model: "alder", Serial: "A1"},
+		{Model: "ash", Serial: "A2"},
+	}
+
+	result := reconcileDevices(signed, registered, now.AddDate(0, 0, -7))
+	c.Assert(result.Signed, check.Equals, 3)
+	c.Assert(result.Registered, check.Equals, 2)
+
+	// A3 was signed recently, so it may not have registered yet
+	c.Assert(result.NotRegistered, check.HasLen, 1)
+	c.Assert(result.NotRegistered[0].Serial, check.Equals, "A2")
+	c.Assert(result.NotSigned, check.HasLen, 1)
+	c.Assert(result.NotSigned[0].Model, check.Equals, "ash")
+}
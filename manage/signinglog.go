@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/logsink"
+)
+
+// SigningLogCommand is the main command for signing log integrity management
+type SigningLogCommand struct {
+	Checkpoint SigningLogCheckpointCommand `command:"checkpoint" alias:"c" description:"Anchor the signing log with a signed checkpoint"`
+	Verify     SigningLogVerifyCommand     `command:"verify" alias:"v" description:"Verify the signing log chain, detecting modified or deleted entries"`
+	Sink       SigningLogSinkCommand       `command:"sink" description:"Write-once storage of the signing log"`
+	Import     SigningLogImportCommand     `command:"import" description:"Import the signing log entries of another signing system"`
+}
+
+// SigningLogCheckpointCommand anchors the most recent signing log entry.
+// Checkpoints are created automatically, but this command would normally also be run as a cron
+type SigningLogCheckpointCommand struct{}
+
+// Execute the signing log checkpoint
+func (cmd SigningLogCheckpointCommand) Execute(args []string) error {
+	openDatabase()
+
+	checkpoint, err := datastore.Environ.DB.CreateSigningLogCheckpoint()
+	if err != nil {
+		return fmt.Errorf("Error creating the signing log checkpoint: %v", err)
+	}
+
+	fmt.Printf("Created checkpoint %d for signing log %d: %s\n", checkpoint.ID, checkpoint.LogID, checkpoint.Hash)
+	return nil
+}
+
+// SigningLogVerifyCommand verifies the signing log chain and its checkpoints
+type SigningLogVerifyCommand struct{}
+
+// Execute the signing log verification
+func (cmd SigningLogVerifyCommand) Execute(args []string) error {
+	openDatabase()
+
+	result, err := datastore.Environ.DB.VerifySigningLog()
+	if err != nil {
+		return fmt.Errorf("Error verifying the signing log: %v", err)
+	}
+
+	fmt.Printf("Verified %d signing logs and %d checkpoints\n", result.Rows, result.Checkpoints)
+	for _, e := range result.Errors {
+		fmt.Println(e)
+	}
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("The signing log verification found %d problems", len(result.Errors))
+	}
+	return nil
+}
+
+// SigningLogImportCommand imports the historical signing log entries of another signing system
+// from a JSON file. The entries that are already in the signing log are skipped, so the import
+// can be run again
+type SigningLogImportCommand struct {
+	Source string `short:"s" long:"source" description:"The signing system of the entries, overriding the source of the file"`
+}
+
+// Execute the import of the signing log
+func (cmd SigningLogImportCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Import expects a single file argument")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("Error opening the signing log import: %v", err)
+	}
+	defer f.Close()
+
+	imp := datastore.SigningLogImport{}
+	if err = json.NewDecoder(f).Decode(&imp); err != nil {
+		return fmt.Errorf("Error reading the signing log import: %v", err)
+	}
+	if len(cmd.Source) > 0 {
+		imp.Source = cmd.Source
+	}
+
+	if err = datastore.ValidateSigningLogImport(imp, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	openDatabase()
+
+	result, err := datastore.ImportSigningLogs(datastore.Environ.DB, imp, "serial-vault-admin")
+	fmt.Printf("Imported %d signing logs from '%s' in batch %s, skipping %d duplicates\n", result.Imported, imp.Source, result.Batch, result.Duplicates)
+	for _, e := range result.Conflicts {
+		fmt.Printf("Revision %d of %s/%s/%s was signed for a different device-key\n", e.Revision, e.Make, e.Model, e.SerialNumber)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		return fmt.Errorf("The import skipped %d conflicting signing logs", len(result.Conflicts))
+	}
+	return nil
+}
+
+// SigningLogSinkCommand is the command for the write-once storage of the signing log
+type SigningLogSinkCommand struct {
+	Flush     SigningLogSinkFlushCommand     `command:"flush" alias:"f" description:"Write the queued signing logs to the sink"`
+	Reconcile SigningLogSinkReconcileCommand `command:"reconcile" alias:"r" description:"Reconcile the signing log of a brand with the sink"`
+}
+
+// SigningLogSinkFlushCommand writes the queued signing logs to the sink. The signing
+// service retries them in the background, but this command can be run once the sink is back
+type SigningLogSinkFlushCommand struct{}
+
+// Execute the flush of the signing log sink queue
+func (cmd SigningLogSinkFlushCommand) Execute(args []string) error {
+	if err := openSigningLogSink(); err != nil {
+		return err
+	}
+
+	written, err := logsink.Flush(context.Background())
+	fmt.Printf("Wrote %d queued signing logs to the sink\n", written)
+	if err != nil {
+		return fmt.Errorf("Error writing the queued signing logs: %v", err)
+	}
+	return nil
+}
+
+// SigningLogSinkReconcileCommand compares the signing log of a brand with the sink, optionally
+// writing the missing entries. This command would normally be run as a cron
+type SigningLogSinkReconcileCommand struct {
+	Repair bool `long:"repair" description:"Write the signing logs that are missing from the sink"`
+}
+
+// Execute the reconciliation of the signing log with the sink
+func (cmd SigningLogSinkReconcileCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Reconcile expects a single brand-id argument")
+	}
+	brandID := args[0]
+
+	if err := openSigningLogSink(); err != nil {
+		return err
+	}
+
+	result, err := logsink.Reconcile(context.Background(), brandID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reconciled %d signing logs with %d entries in the sink for '%s'\n", result.Entries, result.Objects, brandID)
+	for _, l := range result.Queued {
+		fmt.Printf("Queued for the sink: %s\n", datastore.SigningLogSinkKey(l))
+	}
+	for _, k := range result.Unknown {
+		fmt.Printf("In the sink but not in the signing log: %s\n", k)
+	}
+
+	missing := 0
+	for _, l := range result.Missing {
+		if cmd.Repair {
+			if err := datastore.Environ.SigningLogSink.Write(context.Background(), signingLogForSink(l)); err == nil {
+				fmt.Printf("Written to the sink: %s\n", datastore.SigningLogSinkKey(l))
+				continue
+			}
+		}
+		fmt.Printf("Missing from the sink: %s\n", datastore.SigningLogSinkKey(l))
+		missing++
+	}
+
+	if count := missing + len(result.Unknown); count > 0 {
+		return fmt.Errorf("The reconciliation found %d discrepancies", count)
+	}
+	return nil
+}
+
+// signingLogForSink clears the fields of the signing log that are not known at sign time
+func signingLogForSink(signLog datastore.SigningLog) datastore.SigningLog {
+	signLog.ID = 0
+	signLog.Synced = 0
+	signLog.Hash = ""
+	if signLog.Created.IsZero() {
+		signLog.Created = time.Now().UTC()
+	}
+	return signLog
+}
+
+// openSigningLogSink opens the database and the signing log sink from the config
+func openSigningLogSink() error {
+	openDatabase()
+
+	// Check that the sink has not been set e.g. by a mock
+	if datastore.Environ.SigningLogSink != nil {
+		return nil
+	}
+
+	sink, err := logsink.Open(datastore.Environ.Config)
+	if err != nil {
+		return err
+	}
+	if sink == nil {
+		return errors.New("The signing log sink is not configured")
+	}
+	datastore.Environ.SigningLogSink = sink
+	return nil
+}
@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"gopkg.in/check.v1"
+)
+
+type SigningLogSuite struct{}
+
+var _ = check.Suite(&SigningLogSuite{})
+
+func (s *SigningLogSuite) SetUpTest(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+}
+
+func (s *SigningLogSuite) TestSigningLog(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "signinglog"},
+			ErrorMessage: "Please specify one command of: checkpoint, import, sink or verify"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "checkpoint"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "verify"},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *SigningLogSuite) TestSigningLogError(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}}
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "checkpoint"},
+			ErrorMessage: "Error creating the signing log checkpoint: MOCK error creating the signing log checkpoint"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "verify"},
+			ErrorMessage: "The signing log verification found 1 problems"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *SigningLogSuite) TestSigningLogImport(c *check.C) {
+	signed := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	dir := c.MkDir()
+	valid := filepath.Join(dir, "valid.json")
+	err := ioutil.WriteFile(valid, []byte(fmt.Sprintf(`{"source": "legacy", "entries": [
+		{"make": "system", "model": "alder", "serialnumber": "A1", "fingerprint": "a1", "created": "%s"},
+		{"make": "system", "model": "alder", "serialnumber": "A2", "fingerprint": "a2", "created": "%s"}]}`, signed, signed)), 0600)
+	c.Assert(err, check.IsNil)
+	invalid := filepath.Join(dir, "invalid.json")
+	err = ioutil.WriteFile(invalid, []byte(`{"source": "legacy", "entries": [{"make": "system", "model": "alder", "serialnumber": "A1"}]}`), 0600)
+	c.Assert(err, check.IsNil)
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "import"},
+			ErrorMessage: "Import expects a single file argument"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "import", filepath.Join(dir, "missing.json")},
+			ErrorMessage: fmt.Sprintf("Error opening the signing log import: open %s: no such file or directory", filepath.Join(dir, "missing.json"))},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "import", invalid},
+			ErrorMessage: "Entry 1: the Make, Model, Serial Number and device-key Fingerprint must be supplied"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "import", valid},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "import", "--source", "factory-2017", valid},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+
+	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}}
+	runTest(c, []string{"serial-vault-admin", "signinglog", "import", valid}, "Error importing system/alder/A1: MOCK error importing the signing log")
+}
+
+// mockSink is a signing log sink that holds the first entry of the mock signing log
+type mockSink struct {
+	keys []string
+	fail bool
+}
+
+func (sink *mockSink) Name() string {
+	return "mock"
+}
+
+func (sink *mockSink) Write(ctx context.Context, signLog datastore.SigningLog) error {
+	if sink.fail {
+		return errors.New("MOCK error writing to the sink")
+	}
+	sink.keys = append(sink.keys, datastore.SigningLogSinkKey(signLog))
+	return nil
+}
+
+func (sink *mockSink) List(ctx context.Context, prefix string) ([]string, error) {
+	return sink.keys, nil
+}
+
+func (s *SigningLogSuite) TestSigningLogSink(c *check.C) {
+	sink := &mockSink{keys: []string{"system/alder/A1/1.json"}}
+	datastore.Environ.SigningLogSink = sink
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "sink"},
+			ErrorMessage: "Please specify one command of: flush or reconcile"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "sink", "flush"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "sink", "reconcile"},
+			ErrorMessage: "Reconcile expects a single brand-id argument"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "sink", "reconcile", "system"},
+			ErrorMessage: "The reconciliation found 1 discrepancies"},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "sink", "reconcile", "--repair", "system"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "signinglog", "sink", "reconcile", "system"},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+	c.Assert(sink.keys, check.DeepEquals, []string{"system/alder/A1/1.json", "system/alder/A1/2.json"})
+}
+
+func (s *SigningLogSuite) TestSigningLogSinkError(c *check.C) {
+	// The sink is not configured
+	runTest(c, []string{"serial-vault-admin", "signinglog", "sink", "flush"}, "The signing log sink is not configured")
+
+	datastore.Environ.SigningLogSink = &mockSink{fail: true}
+	runTest(c, []string{"serial-vault-admin", "signinglog", "sink", "reconcile", "--repair", "system"}, "The reconciliation found 2 discrepancies")
+
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	runTest(c, []string{"serial-vault-admin", "signinglog", "sink", "flush"}, "Error writing the queued signing logs: MOCK error retrieving the signing log queue")
+}
@@ -0,0 +1,236 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/CanonicalLtd/serial-vault/account"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// deviceKeyBits is the size of the device key, as generated by snapd
+const deviceKeyBits = 4096
+
+// SimulateDeviceCommand simulates the registration of a device against a serial vault, for
+// end-to-end testing. A new device key is generated, a request-id is fetched and the serial-request
+// is signed. The signature of the returned serial assertion is verified
+type SimulateDeviceCommand struct {
+	Brand          string `short:"b" long:"brand" description:"The brand-id of the device" required:"yes"`
+	Model          string `short:"m" long:"model" description:"The model name of the device" required:"yes"`
+	SerialNumber   string `short:"s" long:"serial" description:"The serial number of the device" required:"yes"`
+	URL            string `short:"u" long:"url" description:"The base URL of the serial vault API" required:"yes"`
+	APIKey         string `short:"a" long:"api" description:"The API Key for the serial vault" required:"yes"`
+	ModelAssertion string `long:"model-assertion" description:"Path to the model assertion to send with the serial-request"`
+	AccountKey     string `long:"account-key" description:"Path to the account-key assertion to verify the signature, instead of fetching it from the store"`
+	Pivot          bool   `long:"pivot" description:"Pivot the signed device to its sub-store model and verify the pivoted serial assertion"`
+}
+
+// Execute the device simulation
+func (cmd SimulateDeviceCommand) Execute(args []string) error {
+	privateKey, err := generateDeviceKey()
+	if err != nil {
+		return fmt.Errorf("Error generating the device key: %v", err)
+	}
+
+	// Fetch a request-id and create the serial-request
+	requestID, err := getRequestID(cmd.URL, cmd.APIKey)
+	if err != nil {
+		return fmt.Errorf("Error fetching the request-id: %v", err)
+	}
+
+	serialRequest, err := cmd.serialRequest(privateKey, requestID)
+	if err != nil {
+		return err
+	}
+
+	// Request the serial assertion and verify it
+	content, err := getSerial(serialRequest, cmd.URL, cmd.APIKey)
+	if err != nil {
+		return fmt.Errorf("Error signing the serial-request: %v", err)
+	}
+
+	serial, err := cmd.verifySerial(content, cmd.Model, privateKey.PublicKey())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", asserts.Encode(serial))
+	fmt.Printf("Verified the serial assertion for %s/%s/%s, signed by %s\n", cmd.Brand, cmd.Model, cmd.SerialNumber, serial.SignKeyID())
+
+	if !cmd.Pivot {
+		return nil
+	}
+
+	// Pivot the device using its serial assertion
+	content, err = getPivotSerial(string(asserts.Encode(serial)), cmd.URL, cmd.APIKey)
+	if err != nil {
+		return fmt.Errorf("Error pivoting the serial assertion: %v", err)
+	}
+
+	pivoted, err := cmd.verifySerial(content, "", privateKey.PublicKey())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", asserts.Encode(pivoted))
+	fmt.Printf("Verified the pivoted serial assertion for %s/%s/%s, signed by %s\n", cmd.Brand, pivoted.HeaderString("model"), cmd.SerialNumber, pivoted.SignKeyID())
+
+	return nil
+}
+
+// generateDeviceKey generates a new device key, as a device does on first boot
+var generateDeviceKey = func() (asserts.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, deviceKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return asserts.RSAPrivateKey(key), nil
+}
+
+var getPivotSerial = func(serial, url, apiKey string) (string, error) {
+	return postAssertions("pivotserial", serial, url, apiKey)
+}
+
+func (cmd SimulateDeviceCommand) serialRequest(privateKey asserts.PrivateKey, requestID string) (string, error) {
+	encodedPubKey, err := asserts.EncodePublicKey(privateKey.PublicKey())
+	if err != nil {
+		return "", fmt.Errorf("Error encoding the device key: %v", err)
+	}
+
+	headers := map[string]interface{}{
+		"brand-id":   cmd.Brand,
+		"device-key": string(encodedPubKey),
+		"request-id": requestID,
+		"model":      cmd.Model,
+		"serial":     cmd.SerialNumber,
+	}
+
+	sreq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType, headers, nil, privateKey)
+	if err != nil {
+		return "", fmt.Errorf("Error signing the serial-request: %v", err)
+	}
+
+	request := asserts.Encode(sreq)
+
+	// The model assertion follows the serial-request in the stream
+	if len(cmd.ModelAssertion) > 0 {
+		model, err := ioutil.ReadFile(cmd.ModelAssertion)
+		if err != nil {
+			return "", fmt.Errorf("Error reading the model assertion: %v", err)
+		}
+		request = append(append(request, '\n'), model...)
+	}
+
+	return string(request), nil
+}
+
+// verifySerial checks that the serial assertion matches the device and that it has been
+// signed by the brand's account-key. The model is not checked when it is empty
+func (cmd SimulateDeviceCommand) verifySerial(content, model string, deviceKey asserts.PublicKey) (asserts.Assertion, error) {
+	var serial asserts.Assertion
+	accountKeys := []asserts.Assertion{}
+
+	// The response may include the account and account-key assertions
+	dec := asserts.NewDecoder(bytes.NewBufferString(content))
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding the serial assertion: %v", err)
+		}
+
+		switch a.Type() {
+		case asserts.SerialType:
+			serial = a
+		case asserts.AccountKeyType:
+			accountKeys = append(accountKeys, a)
+		}
+	}
+	if serial == nil {
+		return nil, errors.New("The response does not contain a serial assertion")
+	}
+
+	// Check that the serial assertion is for this device
+	switch {
+	case serial.HeaderString("brand-id") != cmd.Brand:
+		return nil, fmt.Errorf("The serial assertion is for brand '%s'", serial.HeaderString("brand-id"))
+	case len(model) > 0 && serial.HeaderString("model") != model:
+		return nil, fmt.Errorf("The serial assertion is for model '%s'", serial.HeaderString("model"))
+	case serial.HeaderString("serial") != cmd.SerialNumber:
+		return nil, fmt.Errorf("The serial assertion is for serial number '%s'", serial.HeaderString("serial"))
+	case serial.HeaderString("device-key-sha3-384") != deviceKey.ID():
+		return nil, errors.New("The serial assertion is not for the device key")
+	}
+
+	accountKey, err := cmd.accountKey(serial.SignKeyID(), accountKeys)
+	if err != nil {
+		return nil, err
+	}
+	if accountKey.HeaderString("account-id") != serial.AuthorityID() {
+		return nil, fmt.Errorf("The signing key belongs to '%s', not to the authority '%s'", accountKey.HeaderString("account-id"), serial.AuthorityID())
+	}
+
+	publicKey, err := asserts.DecodePublicKey(accountKey.Body())
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the account-key: %v", err)
+	}
+	if err = asserts.SignatureCheck(serial, publicKey); err != nil {
+		return nil, fmt.Errorf("Invalid signature of the serial assertion: %v", err)
+	}
+
+	return serial, nil
+}
+
+// accountKey finds the account-key for the signing key: from the response, the command-line or the store
+func (cmd SimulateDeviceCommand) accountKey(keyID string, accountKeys []asserts.Assertion) (asserts.Assertion, error) {
+	for _, a := range accountKeys {
+		if a.HeaderString("public-key-sha3-384") == keyID {
+			return a, nil
+		}
+	}
+
+	if len(cmd.AccountKey) > 0 {
+		content, err := ioutil.ReadFile(cmd.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the account-key: %v", err)
+		}
+		a, err := asserts.Decode(content)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding the account-key: %v", err)
+		}
+		if a.Type() != asserts.AccountKeyType || a.HeaderString("public-key-sha3-384") != keyID {
+			return nil, fmt.Errorf("The account-key is not for the signing key '%s'", keyID)
+		}
+		return a, nil
+	}
+
+	a, err := account.FetchAssertionFromStore(asserts.AccountKeyType, []string{keyID})
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching the account-key from the store: %v", err)
+	}
+	return a, nil
+}
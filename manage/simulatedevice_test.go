@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+
+	"github.com/snapcore/snapd/asserts"
+	"gopkg.in/check.v1"
+)
+
+type SimulateDeviceSuite struct{}
+
+var _ = check.Suite(&SimulateDeviceSuite{})
+
+func (s *SimulateDeviceSuite) SetUpTest(c *check.C) {
+	Manage.Simulate = SimulateDeviceCommand{}
+
+	getRequestID = MockGetRequestID
+	getSerial = MockSerial
+	getPivotSerial = MockSerial
+	generateDeviceKey = mockGenerateDeviceKey
+}
+
+func (s *SimulateDeviceSuite) TestSimulateDevice(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "simulate-device"},
+			ErrorMessage: "the required flags `-a, --api', `-b, --brand', `-m, --model', `-s, --serial' and `-u, --url' were not specified"},
+		{
+			Args:         []string{"serial-vault-admin", "simulate-device", "-a", "ValidAPIKey", "-b", "system", "-m", "alder", "-s", "A1234", "-u", "http://example.com/v1/"},
+			ErrorMessage: "Error decoding the serial assertion: .*"},
+		{
+			Args:         []string{"serial-vault-admin", "simulate-device", "-a", "ValidAPIKey", "-b", "system", "-m", "alder", "-s", "A1234", "-u", "http://example.com/v1/", "--model-assertion", "/does/not/exist"},
+			ErrorMessage: "Error reading the model assertion: .*"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *SimulateDeviceSuite) TestSimulateDeviceErrors(c *check.C) {
+	args := []string{"serial-vault-admin", "simulate-device", "-a", "ValidAPIKey", "-b", "system", "-m", "alder", "-s", "A1234", "-u", "http://example.com/v1/"}
+
+	getRequestID = mockGetRequestIDError
+	runTest(c, args, "Error fetching the request-id: MOCK error")
+
+	getRequestID = MockGetRequestID
+	getSerial = mockSerialError
+	runTest(c, args, "Error signing the serial-request: MOCK error")
+
+	getSerial = mockSerialEmpty
+	runTest(c, args, "The response does not contain a serial assertion")
+}
+
+func mockGenerateDeviceKey() (asserts.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+	return asserts.RSAPrivateKey(key), nil
+}
+
+func mockGetRequestIDError(url, apiKey string) (string, error) {
+	return "", errors.New("MOCK error")
+}
+
+func mockSerialError(serialRequest, url, apiKey string) (string, error) {
+	return "", errors.New("MOCK error")
+}
+
+func mockSerialEmpty(serialRequest, url, apiKey string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// SubstoreCommand is the main command for the sub-store mappings of the pivoted models
+type SubstoreCommand struct {
+	Import SubstoreImportCommand `command:"import" description:"Import the sub-store mappings of a CSV file"`
+	Export SubstoreExportCommand `command:"export" description:"Export the sub-store mappings of an account as a CSV file"`
+}
+
+// SubstoreImportCommand creates the sub-store mappings of a CSV file with the columns:
+// model, store, serialnumber, modelname. The serial number may be a range, e.g. A0001..A0100.
+// The mappings that already exist are skipped, so the import can be run again
+type SubstoreImportCommand struct {
+	Account string `short:"a" long:"account" description:"The authority-id of the account of the models" required:"yes"`
+}
+
+// Execute the import of the sub-store mappings
+func (cmd SubstoreImportCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Import expects a single file argument")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("Error opening the sub-store import: %v", err)
+	}
+	defer f.Close()
+
+	entries, err := datastore.ParseSubstoreCSV(f)
+	if err != nil {
+		return err
+	}
+
+	openDatabase()
+
+	account, err := datastore.Environ.DB.GetAccount(cmd.Account)
+	if err != nil {
+		return fmt.Errorf("Error retrieving the account '%s': %v", cmd.Account, err)
+	}
+
+	result, err := datastore.ImportSubstores(datastore.Environ.DB, datastore.Environ.Config.ModelNameCase, account, entries, datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range result.Conflicts {
+		fmt.Printf("Line %d: serial number %s: %s\n", c.Line, c.SerialNumber, c.Message)
+	}
+	if len(result.Conflicts) > 0 {
+		return fmt.Errorf("The import has %d conflicting serial numbers, no sub-store mappings were imported", len(result.Conflicts))
+	}
+
+	fmt.Printf("Imported %d sub-store mappings for '%s', skipping %d duplicates\n", result.Imported, account.AuthorityID, result.Duplicates)
+	return nil
+}
+
+// SubstoreExportCommand writes the sub-store mappings of an account as a CSV file that can
+// be imported, combining the consecutive serial numbers into ranges
+type SubstoreExportCommand struct {
+	Account string `short:"a" long:"account" description:"The authority-id of the account of the models" required:"yes"`
+	Output  string `short:"o" long:"output" description:"The CSV file to write, instead of the standard output"`
+}
+
+// Execute the export of the sub-store mappings
+func (cmd SubstoreExportCommand) Execute(args []string) error {
+	openDatabase()
+
+	account, err := datastore.Environ.DB.GetAccount(cmd.Account)
+	if err != nil {
+		return fmt.Errorf("Error retrieving the account '%s': %v", cmd.Account, err)
+	}
+
+	stores, err := datastore.Environ.DB.ListSubstores(account.ID, datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		return fmt.Errorf("Error retrieving the sub-store mappings: %v", err)
+	}
+
+	if len(cmd.Output) == 0 {
+		return datastore.WriteSubstoreCSV(os.Stdout, stores)
+	}
+
+	f, err := os.Create(cmd.Output)
+	if err != nil {
+		return fmt.Errorf("Error creating the sub-store export: %v", err)
+	}
+	defer f.Close()
+
+	if err = datastore.WriteSubstoreCSV(f, stores); err != nil {
+		return fmt.Errorf("Error writing the sub-store export: %v", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"gopkg.in/check.v1"
+)
+
+type SubstoreSuite struct{}
+
+var _ = check.Suite(&SubstoreSuite{})
+
+func (s *SubstoreSuite) SetUpTest(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+}
+
+func (s *SubstoreSuite) TestSubstoreImport(c *check.C) {
+	dir := c.MkDir()
+	valid := filepath.Join(dir, "valid.csv")
+	conflict := filepath.Join(dir, "conflict.csv")
+	invalid := filepath.Join(dir, "invalid.csv")
+	c.Assert(ioutil.WriteFile(valid, []byte("model,store,serialnumber,modelname\nalder,mybrand,abc1230..abc1239,alder-mybrand\n"), 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(conflict, []byte("model,store,serialnumber,modelname\nalder,otherstore,abc1234,alder-mybrand\n"), 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(invalid, []byte("serialnumber\nabc1234\n"), 0600), check.IsNil)
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "substore"},
+			ErrorMessage: "Please specify one command of: export or import"},
+		{
+			Args:         []string{"serial-vault-admin", "substore", "import", valid},
+			ErrorMessage: "the required flag `-a, --account' was not specified"},
+		{
+			Args:         []string{"serial-vault-admin", "substore", "import", "--account", "system"},
+			ErrorMessage: "Import expects a single file argument"},
+		{
+			Args:         []string{"serial-vault-admin", "substore", "import", "--account", "system", valid},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "substore", "import", "--account", "system", conflict},
+			ErrorMessage: "The import has 1 conflicting serial numbers, no sub-store mappings were imported"},
+		{
+			Args:         []string{"serial-vault-admin", "substore", "import", "--account", "system", invalid},
+			ErrorMessage: ".*wrong number of fields"},
+		{
+			Args:         []string{"serial-vault-admin", "substore", "import", "--account", "unknown", valid},
+			ErrorMessage: "Error retrieving the account 'unknown': Cannot found the account assertion"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *SubstoreSuite) TestSubstoreExport(c *check.C) {
+	output := filepath.Join(c.MkDir(), "substores.csv")
+
+	runTest(c, []string{"serial-vault-admin", "substore", "export", "--account", "system", "--output", output}, "")
+
+	data, err := ioutil.ReadFile(output)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "model,store,serialnumber,modelname\nalder,mybrand,abc1234,alder-mybrand\nalder,mybrand,abc5678,alder-mybrand\n")
+
+	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}}
+	runTest(c, []string{"serial-vault-admin", "substore", "export", "--account", "system"}, "Error retrieving the account 'system': Cannot found the account assertion")
+}
@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// VerifyArchiveCommand re-verifies the signatures of the archived serial assertions against the
+// signing-keys of the vault, to detect a corrupted archive or a drift of the keypair registry.
+// A very large archive is verified by a random sample of its serial assertions
+type VerifyArchiveCommand struct {
+	Sample float64 `long:"sample" description:"Fraction of the serial assertions to verify, from 0 to 1" default:"1"`
+	Limit  int     `long:"limit" description:"Maximum number of serial assertions to verify, 0 for no limit"`
+	Seed   int64   `long:"seed" description:"Seed of the random sample, to repeat the sample of a verification"`
+}
+
+// ArchiveFailure is a serial assertion of the archive that failed the verification
+type ArchiveFailure struct {
+	Path   string
+	Index  int // the position of the assertion in the file
+	Serial string
+	Reason string
+}
+
+// ArchiveVerification holds the result of the verification of an archive
+type ArchiveVerification struct {
+	Files    int
+	Read     int // the serial assertions that were read, including the ones outside the sample
+	Verified int
+	Failures []ArchiveFailure
+}
+
+// archivePublicKey returns the public key of a signing-key of the vault, opening the keystore
+// when the signing-key has no account-key assertion
+var archivePublicKey = func(keypair datastore.Keypair) (asserts.PublicKey, error) {
+	if datastore.Environ.KeypairDB == nil {
+		if err := datastore.OpenKeyStore(datastore.Environ.Config); err != nil {
+			return nil, fmt.Errorf("Error opening the keystore: %v", err)
+		}
+	}
+	return datastore.Environ.KeypairDB.KeypairPublicKey(keypair)
+}
+
+// archiveKey is the public key of a signing-key, or the reason it cannot verify the assertions
+type archiveKey struct {
+	publicKey asserts.PublicKey
+	reason    string
+}
+
+// archiveVerifier verifies the serial assertions of the archive, looking up each signing-key once
+type archiveVerifier struct {
+	db     datastore.Datastore
+	sample float64
+	limit  int
+	random *rand.Rand
+	keys   map[string]archiveKey
+	result ArchiveVerification
+}
+
+// errArchiveLimit stops the verification when the limit of serial assertions has been verified
+var errArchiveLimit = errors.New("limit reached")
+
+// Execute the verification of the archive
+func (cmd VerifyArchiveCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Verify-archive expects the files or directories of the archive")
+	}
+	if cmd.Sample <= 0 || cmd.Sample > 1 {
+		return errors.New("The sample must be a fraction between 0 and 1")
+	}
+	seed := cmd.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	openDatabase()
+
+	result, err := verifyArchive(datastore.Environ.DB, args, cmd.Sample, cmd.Limit, seed)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %d of %d serial assertions in %d files (sample %g, seed %d)\n", result.Verified, result.Read, result.Files, cmd.Sample, seed)
+	for _, f := range result.Failures {
+		fmt.Printf("%s #%d %s: %s\n", f.Path, f.Index, f.Serial, f.Reason)
+	}
+
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("The archive verification found %d failures", len(result.Failures))
+	}
+	return nil
+}
+
+// verifyArchive verifies the serial assertions in the files, and in the files of the directories
+func verifyArchive(db datastore.Datastore, paths []string, sample float64, limit int, seed int64) (ArchiveVerification, error) {
+	v := &archiveVerifier{
+		db:     db,
+		sample: sample,
+		limit:  limit,
+		random: rand.New(rand.NewSource(seed)),
+		keys:   map[string]archiveKey{},
+		result: ArchiveVerification{Failures: []ArchiveFailure{}},
+	}
+
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			return v.verifyFile(path)
+		})
+		if err == errArchiveLimit {
+			break
+		}
+		if err != nil {
+			return v.result, fmt.Errorf("Error reading the archive: %v", err)
+		}
+	}
+
+	return v.result, nil
+}
+
+// verifyFile verifies the serial assertions of a file, which holds a stream of assertions.
+// The other types of assertion are skipped, and a corrupted assertion ends the stream
+func (v *archiveVerifier) verifyFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	v.result.Files++
+
+	dec := asserts.NewDecoder(f)
+	for index := 1; ; index++ {
+		assertion, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			v.fail(path, index, "", fmt.Sprintf("The assertion is corrupted: %v", err))
+			return nil
+		}
+		if assertion.Type() != asserts.SerialType {
+			continue
+		}
+
+		if v.limit > 0 && v.result.Verified >= v.limit {
+			return errArchiveLimit
+		}
+		v.result.Read++
+		if v.random.Float64() >= v.sample {
+			continue
+		}
+
+		v.result.Verified++
+		if reason := v.verifySerial(assertion); len(reason) > 0 {
+			v.fail(path, index, fmt.Sprintf("%s/%s/%s", assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial")), reason)
+		}
+	}
+}
+
+// verifySerial checks the signature of the serial assertion against its signing-key in the
+// keypair registry, returning the reason it failed
+func (v *archiveVerifier) verifySerial(serial asserts.Assertion) string {
+	key := v.publicKey(serial.AuthorityID(), serial.SignKeyID())
+	if len(key.reason) > 0 {
+		return key.reason
+	}
+	if err := asserts.SignatureCheck(serial, key.publicKey); err != nil {
+		return "The signature does not match the signing-key"
+	}
+	return ""
+}
+
+// publicKey finds the public key of the signing-key in the keypair registry
+func (v *archiveVerifier) publicKey(authorityID, keyID string) archiveKey {
+	if key, ok := v.keys[authorityID+"/"+keyID]; ok {
+		return key
+	}
+
+	key := archiveKey{}
+	keypair, err := v.db.GetKeypairByPublicID(authorityID, keyID)
+	if err != nil || keypair.KeyID != keyID {
+		key.reason = fmt.Sprintf("The signing-key %s of %s is not in the keypair registry", keyID, authorityID)
+	} else if key.publicKey, err = archivePublicKey(keypair); err != nil {
+		key.reason = fmt.Sprintf("The public key of the signing-key %s cannot be loaded: %v", keyID, err)
+	}
+
+	v.keys[authorityID+"/"+keyID] = key
+	return key
+}
+
+func (v *archiveVerifier) fail(path string, index int, serial, reason string) {
+	v.result.Failures = append(v.result.Failures, ArchiveFailure{Path: path, Index: index, Serial: serial, Reason: reason})
+}
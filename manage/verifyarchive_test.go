@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/snapcore/snapd/asserts"
+	"gopkg.in/check.v1"
+)
+
+type VerifyArchiveSuite struct {
+	publicKey func(datastore.Keypair) (asserts.PublicKey, error)
+	signer    *asserts.Database
+	key       asserts.PrivateKey
+	other     asserts.PrivateKey
+}
+
+var _ = check.Suite(&VerifyArchiveSuite{})
+
+func (s *VerifyArchiveSuite) SetUpTest(c *check.C) {
+	Manage.Archive = VerifyArchiveCommand{}
+
+	s.key = archiveTestKey(c)
+	s.other = archiveTestKey(c)
+
+	// The archive is signed with a signing-key of the registry, and another key that is not
+	var err error
+	s.signer, err = asserts.OpenDatabase(&asserts.DatabaseConfig{KeypairManager: asserts.NewMemoryKeypairManager()})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.signer.ImportKey(s.key), check.IsNil)
+	c.Assert(s.signer.ImportKey(s.other), check.IsNil)
+
+	db := datastoretest.New()
+	db.AddKeypair(datastoretest.NewKeypair("system", s.key.PublicKey().ID()).Build())
+	datastore.Environ = &datastore.Env{DB: db}
+
+	s.publicKey = archivePublicKey
+	archivePublicKey = func(keypair datastore.Keypair) (asserts.PublicKey, error) {
+		return s.key.PublicKey(), nil
+	}
+}
+
+func (s *VerifyArchiveSuite) TearDownTest(c *check.C) {
+	archivePublicKey = s.publicKey
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func archiveTestKey(c *check.C) asserts.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, check.IsNil)
+	return asserts.RSAPrivateKey(key)
+}
+
+func (s *VerifyArchiveSuite) serial(c *check.C, serialNumber string, key asserts.PrivateKey) []byte {
+	deviceKey := archiveTestKey(c)
+	encodedKey, err := asserts.EncodePublicKey(deviceKey.PublicKey())
+	c.Assert(err, check.IsNil)
+
+	headers := map[string]interface{}{
+		"authority-id":        "system",
+		"brand-id":            "system",
+		"model":               "alder",
+		"serial":              serialNumber,
+		"device-key":          string(encodedKey),
+		"device-key-sha3-384": deviceKey.PublicKey().ID(),
+		"timestamp":           time.Now().UTC().Format(time.RFC3339),
+	}
+	serial, err := s.signer.Sign(asserts.SerialType, headers, nil, key.PublicKey().ID())
+	c.Assert(err, check.IsNil)
+	return asserts.Encode(serial)
+}
+
+func (s *VerifyArchiveSuite) archive(c *check.C) string {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "line-1"), 0700), check.IsNil)
+
+	valid := append(s.serial(c, "A1", s.key), '\n')
+	valid = append(valid, s.serial(c, "A2", s.key)...)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "line-1", "valid.assert"), valid, 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "unknown.assert"), s.serial(c, "B1", s.other), 0600), check.IsNil)
+
+	// Flip the serial number, so the signature no longer matches
+	tampered := strings.Replace(string(s.serial(c, "C1", s.key)), "serial: C1", "serial: C2", 1)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "tampered.assert"), []byte(tampered), 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "corrupt.assert"), []byte("type: serial\n\ninvalid"), 0600), check.IsNil)
+	return dir
+}
+
+func (s *VerifyArchiveSuite) TestVerifyArchive(c *check.C) {
+	dir := s.archive(c)
+
+	result, err := verifyArchive(datastore.Environ.DB, []string{dir}, 1, 0, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Files, check.Equals, 4)
+	c.Assert(result.Read, check.Equals, 4)
+	c.Assert(result.Verified, check.Equals, 4)
+	c.Assert(result.Failures, check.HasLen, 3)
+
+	reasons := map[string]string{}
+	for _, f := range result.Failures {
+		reasons[filepath.Base(f.Path)] = f.Reason
+	}
+	c.Assert(reasons["corrupt.assert"], check.Matches, "The assertion is corrupted: .*")
+	c.Assert(reasons["tampered.assert"], check.Equals, "The signature does not match the signing-key")
+	c.Assert(reasons["unknown.assert"], check.Matches, "The signing-key .* is not in the keypair registry")
+}
+
+func (s *VerifyArchiveSuite) TestVerifyArchiveSample(c *check.C) {
+	dir := s.archive(c)
+
+	result, err := verifyArchive(datastore.Environ.DB, []string{filepath.Join(dir, "line-1")}, 1, 1, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Verified, check.Equals, 1)
+	c.Assert(result.Failures, check.HasLen, 0)
+
+	// The same seed picks the same sample
+	first, err := verifyArchive(datastore.Environ.DB, []string{dir}, 0.5, 0, 42)
+	c.Assert(err, check.IsNil)
+	second, err := verifyArchive(datastore.Environ.DB, []string{dir}, 0.5, 0, 42)
+	c.Assert(err, check.IsNil)
+	c.Assert(second.Verified, check.Equals, first.Verified)
+	c.Assert(second.Failures, check.DeepEquals, first.Failures)
+}
+
+func (s *VerifyArchiveSuite) TestVerifyArchiveCommand(c *check.C) {
+	dir := s.archive(c)
+
+	tests := []manTest{
+		{Args: []string{"serial-vault-admin", "verify-archive"}, ErrorMessage: "Verify-archive expects the files or directories of the archive"},
+		{Args: []string{"serial-vault-admin", "verify-archive", "--sample", "0", dir}, ErrorMessage: "The sample must be a fraction between 0 and 1"},
+		{Args: []string{"serial-vault-admin", "verify-archive", filepath.Join(dir, "line-1")}, ErrorMessage: ""},
+		{Args: []string{"serial-vault-admin", "verify-archive", dir}, ErrorMessage: "The archive verification found 3 failures"},
+		{Args: []string{"serial-vault-admin", "verify-archive", filepath.Join(dir, "does-not-exist")}, ErrorMessage: "Error reading the archive: .*"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// MaxKeystoreErrors is the number of the most recent keystore errors that are kept
+const MaxKeystoreErrors = 100
+
+// KeystoreError is a failed operation of a keystore backend e.g. a signing-key that could
+// not be unsealed, or a signing that timed out
+type KeystoreError struct {
+	Backend    string    `json:"backend"`
+	Operation  string    `json:"operation"`
+	KeyID      string    `json:"key-id"`
+	Error      string    `json:"error"`
+	DurationMS int64     `json:"duration-ms"`
+	Time       time.Time `json:"time"`
+}
+
+// keystore holds the latency of the keystore operations by backend and operation
+var keystore = newHistograms("keystore")
+
+// keystoreQueue holds the number of the signings that wait for a free slot of the keystore, by
+// signing-key or backend
+var keystoreQueue = expvar.NewMap("keystore-queue")
+
+var keystoreErrors = struct {
+	sync.Mutex
+	log []KeystoreError
+}{}
+
+// ObserveKeystore records an operation of a keystore backend, e.g. "tpm2.0 sign", adding it to
+// the recent keystore errors when it failed
+func ObserveKeystore(backend, operation, keyID string, d time.Duration, err error) {
+	keystore.get(backend + " " + operation).Observe(d)
+	if err == nil {
+		return
+	}
+	Increment(KeystoreErrors)
+
+	keystoreErrors.Lock()
+	defer keystoreErrors.Unlock()
+	keystoreErrors.log = append(keystoreErrors.log, KeystoreError{
+		Backend:    backend,
+		Operation:  operation,
+		KeyID:      keyID,
+		Error:      err.Error(),
+		DurationMS: int64(d / time.Millisecond),
+		Time:       time.Now().UTC(),
+	})
+	if len(keystoreErrors.log) > MaxKeystoreErrors {
+		keystoreErrors.log = keystoreErrors.log[len(keystoreErrors.log)-MaxKeystoreErrors:]
+	}
+}
+
+// Keystore returns the latency histograms of the keystore operations, by backend and operation
+func Keystore() map[string]HistogramSnapshot {
+	return keystore.snapshot()
+}
+
+// RecentKeystoreErrors returns the recent keystore errors, most recent first
+func RecentKeystoreErrors() []KeystoreError {
+	keystoreErrors.Lock()
+	defer keystoreErrors.Unlock()
+
+	log := make([]KeystoreError, 0, len(keystoreErrors.log))
+	for i := len(keystoreErrors.log) - 1; i >= 0; i-- {
+		log = append(log, keystoreErrors.log[i])
+	}
+	return log
+}
+
+// QueueKeystore adds the delta to the signings that wait for a free slot of the signing-key or backend
+func QueueKeystore(slot string, delta int64) {
+	keystoreQueue.Add(slot, delta)
+}
+
+// KeystoreQueue returns the number of the signings that wait for a free slot, by signing-key or backend
+func KeystoreQueue() map[string]int64 {
+	queue := map[string]int64{}
+	keystoreQueue.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			queue[kv.Key] = v.Value()
+		}
+	})
+	return queue
+}
@@ -0,0 +1,169 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in milliseconds, of the buckets of the latency histograms
+var LatencyBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// maxHistograms limits the number of distinct endpoints or queries that are tracked.
+// The observations beyond the limit are added to the Other histogram
+const maxHistograms = 500
+
+// Other is the name of the histogram for the observations beyond the tracked limit
+const Other = "other"
+
+// Bucket is the number of observations up to the latency bound
+type Bucket struct {
+	Bound string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+// HistogramSnapshot is a copy of the latency histogram, for reporting
+type HistogramSnapshot struct {
+	Count   int64    `json:"count"`
+	TotalMS int64    `json:"total-ms"`
+	MaxMS   int64    `json:"max-ms"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Histogram records the latency of a request or query, in the LatencyBuckets
+type Histogram struct {
+	mu      sync.Mutex
+	count   int64
+	total   int64
+	max     int64
+	buckets []int64
+}
+
+// NewHistogram creates an empty latency histogram
+func NewHistogram() *Histogram {
+	return &Histogram{buckets: make([]int64, len(LatencyBuckets)+1)}
+}
+
+// Observe adds the duration to the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	ms := int64(d / time.Millisecond)
+
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return ms <= LatencyBuckets[i] })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.total += ms
+	if ms > h.max {
+		h.max = ms
+	}
+	h.buckets[i]++
+}
+
+// Snapshot returns a copy of the histogram
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := HistogramSnapshot{Count: h.count, TotalMS: h.total, MaxMS: h.max}
+	for i, c := range h.buckets {
+		bound := "+Inf"
+		if i < len(LatencyBuckets) {
+			bound = fmt.Sprintf("%dms", LatencyBuckets[i])
+		}
+		s.Buckets = append(s.Buckets, Bucket{Bound: bound, Count: c})
+	}
+	return s
+}
+
+// histograms holds the latency histograms by name, e.g. by endpoint
+type histograms struct {
+	mu sync.Mutex
+	m  map[string]*Histogram
+}
+
+func newHistograms(name string) *histograms {
+	hs := &histograms{m: map[string]*Histogram{}}
+	expvar.Publish(name, expvar.Func(func() interface{} { return hs.snapshot() }))
+	return hs
+}
+
+// get returns the histogram, creating it when it is not tracked yet
+func (hs *histograms) get(name string) *Histogram {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	h, ok := hs.m[name]
+	if ok {
+		return h
+	}
+	if len(hs.m) >= maxHistograms {
+		name = Other
+		if h, ok = hs.m[name]; ok {
+			return h
+		}
+	}
+	h = NewHistogram()
+	hs.m[name] = h
+	return h
+}
+
+func (hs *histograms) snapshot() map[string]HistogramSnapshot {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	s := map[string]HistogramSnapshot{}
+	for name, h := range hs.m {
+		s[name] = h.Snapshot()
+	}
+	return s
+}
+
+// requests holds the latency of the requests by endpoint, and queries the latency of the
+// datastore queries by statement
+var (
+	requests = newHistograms("requests")
+	queries  = newHistograms("queries")
+)
+
+// ObserveRequest records a request to the endpoint, e.g. "GET /api/models"
+func ObserveRequest(endpoint string, d time.Duration) {
+	requests.get(endpoint).Observe(d)
+}
+
+// ObserveQuery records a datastore query
+func ObserveQuery(query string, d time.Duration) {
+	Increment(DatastoreQueries)
+	queries.get(query).Observe(d)
+}
+
+// Requests returns the latency histograms of the requests, by endpoint
+func Requests() map[string]HistogramSnapshot {
+	return requests.snapshot()
+}
+
+// Queries returns the latency histograms of the datastore queries, by statement
+func Queries() map[string]HistogramSnapshot {
+	return queries.snapshot()
+}
@@ -0,0 +1,103 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package metrics holds the operational counters of the service. The counters
+// are published with expvar and served as JSON by the metrics handler.
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// Counter names
+const (
+	Panics                  = "panics"                     // requests that panicked in a handler
+	SigningFallbacks        = "signing-fallbacks"          // serials signed with a fallback signing-key
+	BreakerOpened           = "datastore-breaker-opened"   // times the datastore circuit breaker opened
+	BreakerShed             = "datastore-breaker-shed"     // signing requests shed by the open breaker
+	NonceBans               = "nonce-bans"                 // API keys banned for requesting too many nonces
+	NoncesPurged            = "nonces-purged"              // expired nonces removed by the janitor
+	NoncePurgeErrors        = "nonce-purge-errors"         // failed purges of the expired nonces
+	KeypairsReloaded        = "keystore-keys-reloaded"     // signing-keys loaded into the keystore after it was opened
+	KeypairReloadErrors     = "keystore-reload-errors"     // signing-keys that could not be loaded into the keystore
+	SinkWriteErrors         = "signinglog-sink-errors"     // signing logs that failed to be written to the sink
+	SinkQueued              = "signinglog-sink-queued"     // signing logs queued to be written to the sink
+	SinkRetried             = "signinglog-sink-retried"    // queued signing logs written to the sink
+	DeviceKeysRejected      = "device-keys-rejected"       // serial-requests with a weak or malformed device-key
+	SerialLintViolations    = "serial-lint-violations"     // signed serial assertions that violate the content policy
+	DatastoreQueries        = "datastore-queries"          // queries run on the datastore
+	DatastoreSlowQueries    = "datastore-slow-queries"     // queries that took longer than the slow-query threshold
+	Replicated              = "replication-entries"        // signing log entries replicated from the peer vaults
+	ReplicationConflicts    = "replication-conflicts"      // replicated serial numbers that were signed for different devices
+	ReplicationErrors       = "replication-errors"         // failed replications from a peer vault
+	KeyUsageAlerts          = "keypair-usage-alerts"       // signings for a brand/model that is new or not allowed for the signing-key
+	SerialReplays           = "serial-replays"             // serial-requests replayed within the window, returning the signed serial assertion
+	SerialReplaysPurged     = "serial-replays-purged"      // expired serial replays removed by the janitor
+	DuplicatesRejected      = "duplicates-rejected"        // serial-requests rejected as re-signs by the duplicate policy of the model
+	DirectoryCreated        = "directory-users-created"    // users created by the directory sync
+	DirectoryUpdated        = "directory-users-updated"    // users updated by the directory sync
+	DirectoryDeleted        = "directory-users-deleted"    // users deleted by the directory sync
+	DirectoryErrors         = "directory-sync-errors"      // failed syncs from the directory
+	KeypairsCompromised     = "keypairs-compromised"       // signing-keys revoked by the compromise action
+	QuarantineRejected      = "quarantine-rejected"        // serial-requests of quarantined devices
+	KeystoreErrors          = "keystore-errors"            // failed operations of the keystore backend
+	FailoverPromotions      = "failover-promotions"        // times this vault became the active vault of the failover pair
+	FailoverDemotions       = "failover-demotions"         // times this vault lost the leader lock of the failover pair
+	InvalidationsSent       = "invalidations-sent"         // invalidation events broadcast for changed models, signing-keys and substores
+	InvalidationsHandled    = "invalidations-handled"      // invalidation events received from the instances
+	InvalidationErrors      = "invalidation-errors"        // invalidation events that could not be sent or received
+	RevocationsPublished    = "revocations-published"      // device revocations and reinstatements published upstream
+	RevocationPublishErrors = "revocation-publish-errors"  // device revocations that could not be published upstream
+	PolicyDenied            = "policy-denied"              // signing requests denied by the external policy engine
+	PolicyErrors            = "policy-errors"              // signing requests that the external policy engine failed to decide
+	CertificatesIssued      = "device-certificates-issued" // device certificates issued to the signed devices
+	CertificateErrors       = "device-certificate-errors"  // signed devices whose device certificate could not be issued
+	QueueRejected           = "queue-rejected"             // signing requests rejected when the signing queue is above its watermark
+	AuthSchemeRejected      = "auth-scheme-rejected"       // requests rejected for not presenting the authentication schemes of their endpoint group
+)
+
+// counters holds the operational counters of the service
+var counters = expvar.NewMap("counters")
+
+// Increment adds one to the counter
+func Increment(name string) {
+	counters.Add(name, 1)
+}
+
+// Add adds the delta to the counter
+func Add(name string, delta int64) {
+	counters.Add(name, delta)
+}
+
+// Value returns the current value of the counter
+func Value(name string) int64 {
+	v, ok := counters.Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+// Handler serves the counters as JSON. The other expvar variables, such as the
+// command-line, are not published
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write([]byte(counters.String()))
+}
@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// MaxSlowQueries is the number of the most recent slow queries that are kept
+const MaxSlowQueries = 100
+
+// SlowQuery is a datastore query that took longer than the threshold. The parameters
+// are redacted, so only their types are recorded
+type SlowQuery struct {
+	Query      string    `json:"query"`
+	Params     []string  `json:"params"`
+	DurationMS int64     `json:"duration-ms"`
+	Time       time.Time `json:"time"`
+}
+
+var slowQueries = struct {
+	sync.Mutex
+	log []SlowQuery
+}{}
+
+// RecordSlowQuery adds the query to the slow-query log, dropping the oldest query when it is full
+func RecordSlowQuery(q SlowQuery) {
+	Increment(DatastoreSlowQueries)
+
+	slowQueries.Lock()
+	defer slowQueries.Unlock()
+	slowQueries.log = append(slowQueries.log, q)
+	if len(slowQueries.log) > MaxSlowQueries {
+		slowQueries.log = slowQueries.log[len(slowQueries.log)-MaxSlowQueries:]
+	}
+}
+
+// SlowQueries returns the slow-query log, most recent first
+func SlowQueries() []SlowQuery {
+	slowQueries.Lock()
+	defer slowQueries.Unlock()
+
+	log := make([]SlowQuery, 0, len(slowQueries.log))
+	for i := len(slowQueries.log) - 1; i >= 0; i-- {
+		log = append(log, slowQueries.log[i])
+	}
+	return log
+}
@@ -32,13 +32,15 @@ import (
 	"github.com/snapcore/snapd/asserts"
 )
 
-// ListResponse is the JSON response from the API Accounts method
+// ListResponse is the JSON response from the API Accounts method. The API call also returns
+// the account-key assertions of the signing-keys of the accounts, for the factory sync
 type ListResponse struct {
-	Success      bool                `json:"success"`
-	ErrorCode    string              `json:"error_code"`
-	ErrorSubcode string              `json:"error_subcode"`
-	ErrorMessage string              `json:"message"`
-	Accounts     []datastore.Account `json:"accounts"`
+	Success      bool                   `json:"success"`
+	ErrorCode    string                 `json:"error_code"`
+	ErrorSubcode string                 `json:"error_subcode"`
+	ErrorMessage string                 `json:"message"`
+	Accounts     []datastore.Account    `json:"accounts"`
+	AccountKeys  []datastore.AccountKey `json:"account-keys,omitempty"`
 }
 
 // GetResponse is the JSON response from the API Account method
@@ -51,36 +53,62 @@ type GetResponse struct {
 }
 
 // listHandler is the API method to fetch the user records
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	accounts, err := datastore.Environ.DB.ListAllowedAccounts(user)
+	accounts, err := srv.DB.ListAllowedAccounts(user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-models", "", err.Error(), w)
 		return
 	}
 
+	accountKeys := []datastore.AccountKey{}
+	if apiCall {
+		accountKeys, err = srv.accountKeys(user)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-fetch-keypairs", "", err.Error(), w)
+			return
+		}
+	}
+
 	// Return successful JSON response with the list of models
 	w.WriteHeader(http.StatusOK)
-	formatListResponse(accounts, w)
+	formatListResponse(accounts, accountKeys, w)
+}
+
+// accountKeys returns the account-key assertions of the signing-keys of the accounts of the user
+func (srv *Service) accountKeys(user datastore.User) ([]datastore.AccountKey, error) {
+	keypairs, err := srv.DB.ListAllowedKeypairs(user)
+	if err != nil {
+		return nil, err
+	}
+
+	accountKeys := []datastore.AccountKey{}
+	for _, k := range keypairs {
+		if len(k.Assertion) == 0 {
+			continue
+		}
+		accountKeys = append(accountKeys, datastore.AccountKey{AuthorityID: k.AuthorityID, KeyID: k.KeyID, Assertion: k.Assertion})
+	}
+	return accountKeys, nil
 }
 
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
+func (srv *Service) createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	err = datastore.Environ.DB.CreateAccount(acct)
+	err = srv.DB.CreateAccount(acct)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-creating-account", "", "Error creating the account in the database", w)
 		return
@@ -92,16 +120,16 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acc
 }
 
 // getHandler is the API method to fetch the accounts
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+func (srv *Service) getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	account, err := datastore.Environ.DB.GetAccountByID(accountID, user)
+	account, err := srv.DB.GetAccountByID(accountID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-account", "", err.Error(), w)
 		return
@@ -112,16 +140,16 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accoun
 	formatGetResponse(account, w)
 }
 
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
+func (srv *Service) updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	err = datastore.Environ.DB.UpdateAccount(acct, user)
+	err = srv.DB.UpdateAccount(acct, user)
 	if err != nil {
 		log.Println("Error updating the account:", err)
 		response.FormatStandardResponse(false, "error-account", "", "Error updating the model", w)
@@ -133,10 +161,10 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acc
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func uploadHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
+func (srv *Service) uploadHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
@@ -167,7 +195,7 @@ func uploadHandler(w http.ResponseWriter, user datastore.User, apiCall bool, ass
 		Assertion:   string(decodedAssertion),
 	}
 
-	errorCode, err := datastore.Environ.DB.PutAccount(account, user)
+	errorCode, err := srv.DB.PutAccount(account, user)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
@@ -178,8 +206,8 @@ func uploadHandler(w http.ResponseWriter, user datastore.User, apiCall bool, ass
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func formatListResponse(accounts []datastore.Account, w http.ResponseWriter) error {
-	response := ListResponse{Success: true, Accounts: accounts}
+func formatListResponse(accounts []datastore.Account, accountKeys []datastore.AccountKey, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Accounts: accounts, AccountKeys: accountKeys}
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -32,6 +32,11 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the account handlers
+type Service struct {
+	*datastore.Env
+}
+
 // AssertionRequest is the JSON version of a account assertion
 type AssertionRequest struct {
 	ID        int    `json:"id"`
@@ -39,19 +44,19 @@ type AssertionRequest struct {
 }
 
 // List is the API method to list the account assertions
-func List(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
-	listHandler(w, authUser, false)
+	srv.listHandler(w, authUser, false)
 }
 
 // Create is the API method to create an account
-func Create(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Create(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -73,12 +78,12 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, acct)
+	srv.createHandler(w, authUser, false, acct)
 }
 
 // Get is the API method to fetch an account
-func Get(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Get(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -91,12 +96,12 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	srv.getHandler(w, authUser, false, id)
 }
 
 // Update is the API method to update a model
-func Update(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Update(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -120,12 +125,12 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateHandler(w, authUser, false, acct)
+	srv.updateHandler(w, authUser, false, acct)
 }
 
 // Upload is the API method to upload an account assertion
-func Upload(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Upload(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -147,5 +152,5 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uploadHandler(w, authUser, false, assertionRequest)
+	srv.uploadHandler(w, authUser, false, assertionRequest)
 }
@@ -28,14 +28,14 @@ import (
 )
 
 // APIList is the API method to fetch the sub-store models
-func APIList(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	srv.listHandler(w, user, true)
 }
@@ -27,6 +27,7 @@ import (
 	"net/http/httptest"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
 	"github.com/CanonicalLtd/serial-vault/service"
 	check "gopkg.in/check.v1"
 )
@@ -57,6 +58,31 @@ func (s *AccountSuite) TestAPIListHandler(c *check.C) {
 	}
 }
 
+func (s *AccountSuite) TestAPIListAccountKeys(c *check.C) {
+	db := datastoretest.New()
+	system := db.AddAccount(datastore.Account{AuthorityID: "system"})
+	other := db.AddAccount(datastore.Account{AuthorityID: "other"})
+	db.AddUser(datastore.User{Username: "sync", APIKey: "ValidAPIKey", Role: datastore.SyncUser, Accounts: []datastore.Account{system}})
+	db.AddKeypair(datastoretest.NewKeypair("system", "system-key").WithAssertion("account-key assertion").Build())
+	db.AddKeypair(datastoretest.NewKeypair("system", "unregistered-key").Build())
+	db.AddKeypair(datastoretest.NewKeypair(other.AuthorityID, "other-key").WithAssertion("account-key assertion").Build())
+	datastore.Environ.DB = db
+	datastore.Environ.Config.EnableUserAuth = true
+	defer func() { datastore.Environ.Config.EnableUserAuth = false }()
+
+	// The account-key assertions of the signing-keys of the accounts of the sync user are returned
+	w := sendAdminAPIRequest("GET", "/api/accounts", nil, datastore.SyncUser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result, err := parseListResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Accounts, check.HasLen, 1)
+	c.Assert(result.AccountKeys, check.HasLen, 1)
+	c.Assert(result.AccountKeys[0].AuthorityID, check.Equals, "system")
+	c.Assert(result.AccountKeys[0].KeyID, check.Equals, "system-key")
+	c.Assert(result.AccountKeys[0].Assertion, check.Equals, "account-key assertion")
+}
+
 func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
@@ -72,7 +98,7 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
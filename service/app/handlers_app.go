@@ -28,6 +28,11 @@ import (
 	"github.com/CanonicalLtd/serial-vault/datastore"
 )
 
+// Service holds the dependencies of the web application handlers
+type Service struct {
+	*datastore.Env
+}
+
 // IndexTemplate is the path to the HTML template
 var IndexTemplate = "/static/app.html"
 
@@ -38,10 +43,10 @@ type Page struct {
 }
 
 // Index is the front page of the web application
-func Index(w http.ResponseWriter, r *http.Request) {
-	page := Page{Title: datastore.Environ.Config.Title, Logo: datastore.Environ.Config.Logo}
+func (srv *Service) Index(w http.ResponseWriter, r *http.Request) {
+	page := Page{Title: srv.Config.Title, Logo: srv.Config.Logo}
 
-	path := []string{datastore.Environ.Config.DocRoot, IndexTemplate}
+	path := []string{srv.Config.DocRoot, IndexTemplate}
 	t, err := template.ParseFiles(strings.Join(path, ""))
 	if err != nil {
 		log.Printf("Error loading the application template: %v\n", err)
@@ -34,11 +34,11 @@ func TestIndexHandler(t *testing.T) {
 	app.IndexTemplate = "../../static/app.html"
 
 	config := config.Settings{Title: "Site Title", Logo: "/url"}
-	datastore.Environ = &datastore.Env{Config: config}
+	srv := &app.Service{Env: &datastore.Env{Config: config}}
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/", nil)
-	http.HandlerFunc(app.Index).ServeHTTP(w, r)
+	http.HandlerFunc(srv.Index).ServeHTTP(w, r)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d, got: %d", http.StatusOK, w.Code)
@@ -50,11 +50,11 @@ func TestIndexHandlerInvalidTemplate(t *testing.T) {
 	app.IndexTemplate = "../../static/does_not_exist.html"
 
 	config := config.Settings{Title: "Site Title", Logo: "/url"}
-	datastore.Environ = &datastore.Env{Config: config}
+	srv := &app.Service{Env: &datastore.Env{Config: config}}
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/", nil)
-	http.HandlerFunc(app.Index).ServeHTTP(w, r)
+	http.HandlerFunc(srv.Index).ServeHTTP(w, r)
 
 	if w.Code != http.StatusInternalServerError {
 		t.Errorf("Expected status %d, got: %d", http.StatusInternalServerError, w.Code)
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package approval
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ListResponse is the JSON response from the API approvals method
+type ListResponse struct {
+	Success      bool                 `json:"success"`
+	ErrorCode    string               `json:"error_code"`
+	ErrorSubcode string               `json:"error_subcode"`
+	ErrorMessage string               `json:"message"`
+	Approvals    []datastore.Approval `json:"approvals"`
+}
+
+// listHandler is the API method to fetch the approvals of the accounts of the user, optionally
+// with a status e.g. pending
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, status string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	approvals, err := srv.DB.ListAllowedApprovals(user, status)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-approvals-json", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of approvals
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(approvals, w)
+}
+
+// decideHandler is the API method for a second admin to approve or reject a pending approval
+func (srv *Service) decideHandler(w http.ResponseWriter, user datastore.User, apiCall bool, approvalID int, approved bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The approver must be identified, to check that it is not the requester
+	if len(user.Username) == 0 {
+		response.FormatStandardResponse(false, "approval-auth", "", "Approving an operation requires user authentication", w)
+		return
+	}
+
+	approval, err := srv.DB.DecideApproval(approvalID, approved, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-approval", "", err.Error(), w)
+		return
+	}
+
+	rule, _ := Required(srv.Config, approval.Operation)
+	Notify(rule.NotifyURL, approval)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func formatListResponse(approvals []datastore.Approval, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Approvals: approvals}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the approvals response.")
+		return err
+	}
+	return nil
+}
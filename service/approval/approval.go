@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package approval holds the sensitive operations until a second admin has approved them,
+// following the four-eyes principle
+package approval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// notifyTimeout is the limit for sending an approval to a notification hook
+const notifyTimeout = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// Required returns the approval rule of the operation, if the config requires an approval for it
+func Required(settings config.Settings, operation string) (config.ApprovalRule, bool) {
+	for _, rule := range settings.Approvals {
+		if rule.Operation == operation {
+			return rule, true
+		}
+	}
+	return config.ApprovalRule{}, false
+}
+
+// Approved checks that a sensitive operation on the target can complete. When the operation needs
+// an approval that has not been given, the pending approval is written as the response and the
+// operation must not go ahead. The approval is decided by an admin of the account of the target,
+// or by a superuser when the account is empty
+func Approved(w http.ResponseWriter, env *datastore.Env, user datastore.User, operation, authorityID, target, details string) bool {
+	rule, ok := Required(env.Config, operation)
+	if !ok {
+		return true
+	}
+	return approved(w, env, user, rule, operation, authorityID, target, details)
+}
+
+// Always checks that a sensitive operation on the target can complete, like Approved, for an
+// operation that always needs an approval. The config only sets its notification hook
+func Always(w http.ResponseWriter, env *datastore.Env, user datastore.User, operation, authorityID, target, details string) bool {
+	rule, _ := Required(env.Config, operation)
+	return approved(w, env, user, rule, operation, authorityID, target, details)
+}
+
+func approved(w http.ResponseWriter, env *datastore.Env, user datastore.User, rule config.ApprovalRule, operation, authorityID, target, details string) bool {
+	// A second admin can only be told apart with user authentication
+	if len(user.Username) == 0 {
+		response.FormatStandardResponse(false, "approval-auth", "", "The operation needs an approval, which requires user authentication", w)
+		return false
+	}
+
+	approval, created, err := env.DB.RequestApproval(datastore.Approval{
+		Operation: operation, Target: target, AuthorityID: authorityID, Details: details, RequestedBy: user.Username,
+	})
+	if err != nil {
+		response.FormatStandardResponse(false, "error-approval", "", err.Error(), w)
+		return false
+	}
+
+	if approval.Status == datastore.ApprovalCompleted {
+		log.Infof("Operation %s on %s by %s completes approval %d", operation, target, user.Username, approval.ID)
+		return true
+	}
+
+	if created {
+		Notify(rule.NotifyURL, approval)
+	}
+	response.FormatStandardResponse(false, "approval-pending", "", fmt.Sprintf("The operation is waiting for a second admin to approve it (approval %d)", approval.ID), w)
+	return false
+}
+
+// Notify sends the approval to the notification hook of its operation, in the background
+var Notify = func(url string, approval datastore.Approval) {
+	if len(url) == 0 {
+		return
+	}
+
+	go func() {
+		if err := sendNotification(url, approval); err != nil {
+			log.Errorf("Error notifying approval %d: %v", approval.ID, err)
+		}
+	}()
+}
+
+func sendNotification(url string, approval datastore.Approval) error {
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the notification hook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package approval
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// APIList is the API method to fetch the approvals
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.listHandler(w, user, true, r.URL.Query().Get("status"))
+}
+
+// APIApprove is the API method to approve a pending operation
+func (srv *Service) APIApprove(w http.ResponseWriter, r *http.Request) {
+	srv.apiDecide(w, r, true)
+}
+
+// APIReject is the API method to reject a pending operation
+func (srv *Service) APIReject(w http.ResponseWriter, r *http.Request) {
+	srv.apiDecide(w, r, false)
+}
+
+func (srv *Service) apiDecide(w http.ResponseWriter, r *http.Request, approved bool) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	approvalID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidID.Code, "", fmt.Sprintf("%v", vars["id"]), w)
+		return
+	}
+
+	srv.decideHandler(w, user, true, approvalID, approved)
+}
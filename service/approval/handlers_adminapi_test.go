@@ -0,0 +1,243 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package approval_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func TestApprovalSuite(t *testing.T) { check.TestingT(t) }
+
+type ApprovalSuite struct {
+	db       *datastoretest.DB
+	notified []datastore.Approval
+}
+
+var _ = check.Suite(&ApprovalSuite{})
+
+func (s *ApprovalSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	acme := s.db.AddAccount(datastore.Account{AuthorityID: "acme"})
+	s.db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "admin", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "acme", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{acme}})
+	s.db.AddUser(datastore.User{Username: "user", APIKey: "ValidAPIKey", Role: datastore.Standard})
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true,
+		Approvals: []config.ApprovalRule{{Operation: datastore.ApprovalQuotaRaise, NotifyURL: "https://hooks.example.com/vault"}}}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+
+	s.notified = nil
+	approval.Notify = func(url string, a datastore.Approval) {
+		c.Assert(url, check.Equals, "https://hooks.example.com/vault")
+		s.notified = append(s.notified, a)
+	}
+}
+
+func (s *ApprovalSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func sendAdminAPIRequest(method, url string, data io.Reader, username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func (s *ApprovalSuite) updateSetting(c *check.C, value string) response.StandardResponse {
+	data, _ := json.Marshal(map[string]string{"value": value})
+	w := sendAdminAPIRequest("PUT", "/api/settings/signing/nonce-rate-limit", bytes.NewReader(data), "root")
+
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ApprovalSuite) decide(c *check.C, url, username string) response.StandardResponse {
+	w := sendAdminAPIRequest("POST", url, nil, username)
+
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ApprovalSuite) listApprovals(c *check.C, url, username string) approval.ListResponse {
+	w := sendAdminAPIRequest("GET", url, nil, username)
+
+	result := approval.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ApprovalSuite) TestQuotaRaiseApproved(c *check.C) {
+	// Raising the limit waits for an approval, which is notified once
+	result := s.updateSetting(c, "1000")
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+	result = s.updateSetting(c, "1000")
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+	c.Assert(s.notified, check.HasLen, 1)
+	c.Assert(s.notified[0].Status, check.Equals, datastore.ApprovalPending)
+
+	// The setting is global, so only the superusers see the approval
+	pending := s.listApprovals(c, "/api/approvals?status=pending", "sv")
+	c.Assert(pending.Success, check.Equals, true)
+	c.Assert(pending.Approvals, check.HasLen, 0)
+
+	pending = s.listApprovals(c, "/api/approvals?status=pending", "admin")
+	c.Assert(pending.Success, check.Equals, true)
+	c.Assert(pending.Approvals, check.HasLen, 1)
+	c.Assert(pending.Approvals[0].Operation, check.Equals, datastore.ApprovalQuotaRaise)
+	c.Assert(pending.Approvals[0].Target, check.Equals, "setting/signing/nonce-rate-limit")
+	c.Assert(pending.Approvals[0].Details, check.Equals, "1000")
+	c.Assert(pending.Approvals[0].RequestedBy, check.Equals, "root")
+	url := fmt.Sprintf("/api/approvals/%d/approve", pending.Approvals[0].ID)
+
+	// The requester cannot approve its own operation
+	result = s.decide(c, url, "root")
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorMessage, check.Equals, datastore.ErrSelfApproval.Error())
+
+	// An admin cannot approve an operation outside its accounts
+	result = s.decide(c, url, "sv")
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorMessage, check.Equals, datastore.ErrApprovalAccount.Error())
+
+	result = s.decide(c, url, "admin")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(s.notified, check.HasLen, 2)
+	c.Assert(s.notified[1].Status, check.Equals, datastore.ApprovalApproved)
+
+	result = s.decide(c, url, "admin")
+	c.Assert(result.Success, check.Equals, false)
+
+	// The approval allows a different limit only once
+	result = s.updateSetting(c, "2000")
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+	result = s.updateSetting(c, "1000")
+	c.Assert(result.Success, check.Equals, true)
+	result = s.updateSetting(c, "1200")
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+
+	settings, err := s.db.ListConfigSettings()
+	c.Assert(err, check.IsNil)
+	c.Assert(settings, check.HasLen, 1)
+	c.Assert(settings[0].Value, check.Equals, "1000")
+
+	// Lowering the limit does not need an approval
+	result = s.updateSetting(c, "100")
+	c.Assert(result.Success, check.Equals, true)
+
+	completed := s.listApprovals(c, "/api/approvals?status=completed", "admin")
+	c.Assert(completed.Approvals, check.HasLen, 1)
+	all := s.listApprovals(c, "/api/approvals", "admin")
+	c.Assert(all.Approvals, check.HasLen, 3)
+}
+
+func (s *ApprovalSuite) TestQuotaRaiseRejected(c *check.C) {
+	result := s.updateSetting(c, "1000")
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+
+	pending := s.listApprovals(c, "/api/approvals?status=pending", "admin")
+	c.Assert(pending.Approvals, check.HasLen, 1)
+	url := fmt.Sprintf("/api/approvals/%d/reject", pending.Approvals[0].ID)
+
+	for _, username := range []string{"user", ""} {
+		result = s.decide(c, url, username)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, "error-auth")
+	}
+
+	result = s.decide(c, url, "admin")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(s.notified[len(s.notified)-1].Status, check.Equals, datastore.ApprovalRejected)
+
+	// A rejected operation needs a new approval
+	result = s.updateSetting(c, "1000")
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+	pending = s.listApprovals(c, "/api/approvals?status=pending", "admin")
+	c.Assert(pending.Approvals, check.HasLen, 1)
+
+	result = s.decide(c, "/api/approvals/999/approve", "admin")
+	c.Assert(result.Success, check.Equals, false)
+}
+
+func (s *ApprovalSuite) TestApprovalAccounts(c *check.C) {
+	a, _, err := s.db.RequestApproval(datastore.Approval{Operation: datastore.ApprovalKeypairEnable, Target: "keypair/1", AuthorityID: "system", RequestedBy: "root"})
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/api/approvals/%d/approve", a.ID)
+
+	// Only the admins of the account of the approval see it
+	for _, username := range []string{"sv", "admin"} {
+		result := s.listApprovals(c, "/api/approvals", username)
+		c.Assert(result.Approvals, check.HasLen, 1)
+		c.Assert(result.Approvals[0].AuthorityID, check.Equals, "system")
+	}
+	result := s.listApprovals(c, "/api/approvals", "acme")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Approvals, check.HasLen, 0)
+
+	decision := s.decide(c, url, "acme")
+	c.Assert(decision.Success, check.Equals, false)
+	c.Assert(decision.ErrorMessage, check.Equals, datastore.ErrApprovalAccount.Error())
+
+	decision = s.decide(c, url, "sv")
+	c.Assert(decision.Success, check.Equals, true)
+}
+
+func (s *ApprovalSuite) TestListHandler(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+
+	result := s.listApprovals(c, "/api/approvals", "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Approvals, check.HasLen, 2)
+
+	result = s.listApprovals(c, "/api/approvals?status=pending", "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Approvals, check.HasLen, 1)
+
+	for _, username := range []string{"user1", ""} {
+		result = s.listApprovals(c, "/api/approvals", username)
+		c.Assert(result.Success, check.Equals, false)
+	}
+}
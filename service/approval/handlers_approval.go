@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package approval
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// Service holds the dependencies of the approval handlers
+type Service struct {
+	*datastore.Env
+}
+
+// List is the API method to fetch the approvals
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.listHandler(w, authUser, false, r.URL.Query().Get("status"))
+}
+
+// Approve is the API method to approve a pending operation
+func (srv *Service) Approve(w http.ResponseWriter, r *http.Request) {
+	srv.decide(w, r, true)
+}
+
+// Reject is the API method to reject a pending operation
+func (srv *Service) Reject(w http.ResponseWriter, r *http.Request) {
+	srv.decide(w, r, false)
+}
+
+func (srv *Service) decide(w http.ResponseWriter, r *http.Request, approved bool) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	approvalID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidID.Code, "", fmt.Sprintf("%v", vars["id"]), w)
+		return
+	}
+
+	srv.decideHandler(w, authUser, false, approvalID, approved)
+}
@@ -21,6 +21,7 @@
 package assertion
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -33,9 +34,9 @@ import (
 	"github.com/snapcore/snapd/asserts"
 )
 
-func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAssertionRequest) response.ErrorResponse {
+func (srv *Service) modelAssertionHandler(ctx context.Context, w http.ResponseWriter, apiKey string, request ModelAssertionRequest) response.ErrorResponse {
 	// Check that the reseller functionality is enabled for the brand
-	acc, err := datastore.Environ.DB.GetAccount(request.BrandID)
+	acc, err := srv.DB.GetAccount(request.BrandID)
 	if err != nil {
 		return response.ErrorResponse{Success: false, Code: "error-account", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
@@ -44,7 +45,7 @@ func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAs
 	}
 
 	// Validate the model by checking that it exists on the database
-	model, err := datastore.Environ.DB.FindModel(request.BrandID, request.Name, apiKey)
+	model, err := srv.DB.FindModel(request.BrandID, request.Name, apiKey)
 	if err != nil {
 		log.Message("MODEL", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
 		return response.ErrorInvalidModel
@@ -53,24 +54,21 @@ func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAs
 	assertions := []asserts.Assertion{}
 
 	// Build the model assertion headers
-	assertionHeaders, keypair, err := CreateModelAssertionHeaders(model)
+	assertionHeaders, keypair, err := srv.CreateModelAssertionHeaders(model)
 	if err != nil {
 		log.Message("MODEL", response.ErrorCreateModelAssertion.Code, err.Error())
 		return response.ErrorCreateModelAssertion
 	}
 
 	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.ModelType, assertionHeaders, []byte(""), model.BrandID, keypair.KeyID, keypair.SealedKey)
+	signedAssertion, err := srv.KeypairDB.SignAssertion(ctx, srv.DB.WithContext(ctx), asserts.ModelType, assertionHeaders, []byte(""), model.BrandID, keypair.KeyID, keypair.SealedKey)
 	if err != nil {
 		log.Message("MODEL", response.ErrorSignAssertion.Code, err.Error())
 		return response.ErrorResponse{Success: false, Code: response.ErrorSignAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	// Add the account assertion to the assertions list
-	fetchAssertionFromStore(&assertions, asserts.AccountType, []string{model.BrandID})
-
-	// Add the account-key assertion to the assertions list
-	fetchAssertionFromStore(&assertions, asserts.AccountKeyType, []string{keypair.KeyID})
+	// Add the account and account-key assertions to the assertions list
+	srv.addAccountAssertions(&assertions, acc, keypair)
 
 	// Add the model assertion after the account and account-key assertions
 	assertions = append(assertions, signedAssertion)
@@ -81,16 +79,16 @@ func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAs
 }
 
 // CreateModelAssertionHeaders returns the model assertion headers for a model
-func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, datastore.Keypair, error) {
+func (srv *Service) CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, datastore.Keypair, error) {
 
 	// Get the assertion headers for the model
-	assert, err := datastore.Environ.DB.GetModelAssert(m.ID)
+	assert, err := srv.DB.GetModelAssert(m.ID)
 	if err != nil {
 		return nil, datastore.Keypair{}, err
 	}
 
 	// Get the keypair for the model assertion
-	keypair, err := datastore.Environ.DB.GetKeypair(assert.KeypairID)
+	keypair, err := srv.DB.GetKeypair(assert.KeypairID)
 	if err != nil {
 		return nil, keypair, err
 	}
@@ -152,6 +150,47 @@ func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, dat
 	return headers, keypair, nil
 }
 
+// addAccountAssertions adds the account and account-key assertions of the signing-key to the
+// assertions list. The assertions in the database, e.g. synced to a factory, are used before
+// the store, as a factory may not reach the store
+func (srv *Service) addAccountAssertions(assertions *[]asserts.Assertion, acc datastore.Account, keypair datastore.Keypair) {
+	if assertion, ok := decodeAssertion(acc.Assertion, asserts.AccountType); ok {
+		*assertions = append(*assertions, assertion)
+	} else {
+		fetchAssertionFromStore(assertions, asserts.AccountType, []string{acc.AuthorityID})
+	}
+
+	if assertion, ok := srv.storedAccountKey(keypair); ok {
+		*assertions = append(*assertions, assertion)
+	} else {
+		fetchAssertionFromStore(assertions, asserts.AccountKeyType, []string{keypair.KeyID})
+	}
+}
+
+// storedAccountKey returns the account-key assertion of the signing-key from the database: the
+// assertion of the keypair, or the account-key assertion that is synced to the factory
+func (srv *Service) storedAccountKey(keypair datastore.Keypair) (asserts.Assertion, bool) {
+	accountKey := keypair.Assertion
+	if len(accountKey) == 0 {
+		if key, err := srv.DB.GetAccountKey(keypair.AuthorityID, keypair.KeyID); err == nil {
+			accountKey = key.Assertion
+		}
+	}
+	return decodeAssertion(accountKey, asserts.AccountKeyType)
+}
+
+// decodeAssertion decodes a stored assertion of the type
+func decodeAssertion(data string, assertType *asserts.AssertionType) (asserts.Assertion, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	assertion, err := asserts.Decode([]byte(data))
+	if err != nil || assertion.Type() != assertType {
+		return nil, false
+	}
+	return assertion, true
+}
+
 func fetchAssertionFromStore(assertions *[]asserts.Assertion, modelType *asserts.AssertionType, headers []string) {
 	assertion, err := account.FetchAssertionFromStore(modelType, headers)
 	if err != nil {
@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// bundleHandler returns the assertions that a device needs to seed its assertion database: the
+// account assertion of the brand, the account-key assertions of the signing-keys of the model
+// and, when the reseller functions are enabled for the brand, the model assertion. The bundle
+// is assembled from the stored assertions, without reaching the store
+func (srv *Service) bundleHandler(ctx context.Context, w http.ResponseWriter, apiKey, brandID, modelName string) response.ErrorResponse {
+	model, err := srv.DB.FindModel(brandID, modelName, apiKey)
+	if err != nil {
+		log.Message("BUNDLE", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
+		return response.ErrorInvalidModel
+	}
+
+	acc, err := srv.DB.GetAccount(model.BrandID)
+	if err != nil {
+		log.Message("BUNDLE", response.ErrorInvalidAccount.Code, err.Error())
+		return response.ErrorInvalidAccount
+	}
+
+	accountAssertion, ok := decodeAssertion(acc.Assertion, asserts.AccountType)
+	if !ok {
+		return missingAssertion(fmt.Sprintf("the account assertion of %s", model.BrandID))
+	}
+	assertions := []asserts.Assertion{accountAssertion}
+
+	// The serial assertions are signed by the signing-key of the model
+	keypairs := []datastore.Keypair{}
+	keypair, err := srv.DB.GetKeypair(model.KeypairID)
+	if err != nil {
+		log.Message("BUNDLE", response.ErrorInvalidKeypair.Code, err.Error())
+		return response.ErrorInvalidKeypair
+	}
+	keypairs = append(keypairs, keypair)
+
+	// The model assertion is only signed by the vault for the resellers
+	var modelAssertion asserts.Assertion
+	if acc.ResellerAPI {
+		headers, modelKeypair, err := srv.CreateModelAssertionHeaders(model)
+		if err != nil {
+			log.Message("BUNDLE", response.ErrorCreateModelAssertion.Code, err.Error())
+			return response.ErrorCreateModelAssertion
+		}
+
+		modelAssertion, err = srv.KeypairDB.SignAssertion(ctx, srv.DB.WithContext(ctx), asserts.ModelType, headers, []byte(""), model.BrandID, modelKeypair.KeyID, modelKeypair.SealedKey)
+		if err != nil {
+			log.Message("BUNDLE", response.ErrorSignAssertion.Code, err.Error())
+			return response.ErrorResponse{Success: false, Code: response.ErrorSignAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+		}
+		if modelKeypair.KeyID != keypair.KeyID {
+			keypairs = append(keypairs, modelKeypair)
+		}
+	}
+
+	for _, k := range keypairs {
+		accountKey, ok := srv.storedAccountKey(k)
+		if !ok {
+			return missingAssertion(fmt.Sprintf("the account-key assertion of %s", k.KeyID))
+		}
+		assertions = append(assertions, accountKey)
+	}
+
+	// The model assertion is after the account and account-key assertions
+	if modelAssertion != nil {
+		assertions = append(assertions, modelAssertion)
+	}
+
+	formatAssertionResponse(assertions, w)
+	return response.ErrorResponse{Success: true}
+}
+
+// missingAssertion is the response for an assertion of the bundle that is not stored
+func missingAssertion(what string) response.ErrorResponse {
+	e := response.ErrorMissingAssertion
+	e.Message = fmt.Sprintf("%s: %s", e.Message, what)
+	log.Message("BUNDLE", e.Code, e.Message)
+	return e
+}
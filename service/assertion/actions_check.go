@@ -21,6 +21,7 @@
 package assertion
 
 import (
+	"database/sql"
 	"net/http"
 
 	"github.com/snapcore/snapd/asserts"
@@ -29,44 +30,57 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
 )
 
 const (
 	responseValidModel    = "valid-model"
 	responseValidSubstore = "valid-substore"
+	responseQuarantined   = datastore.DeviceStateQuarantined
 )
 
 // validateAssertionAction is called by the API method to check a serial assertion
-func validateAssertionAction(w http.ResponseWriter, authUser datastore.User, apiCall bool, assertion asserts.Assertion) {
+func (srv *Service) validateAssertionAction(w http.ResponseWriter, authUser datastore.User, apiCall bool, assertion asserts.Assertion) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(authUser, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(authUser, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", response.ErrorAuth.Message, w)
 		return
 	}
 
 	// Check that the account is accessbible by the user
-	if _, err = datastore.Environ.DB.GetAllowedAccount(assertion.HeaderString("brand-id"), authUser); err != nil {
+	if _, err = srv.DB.GetAllowedAccount(assertion.HeaderString("brand-id"), authUser); err != nil {
 		response.FormatStandardResponse(false, response.ErrorInvalidAccount.Code, "", response.ErrorInvalidAccount.Message, w)
 		return
 	}
 
-	// Assume this is an original (non-pivoted) serial assertion
-	// Validate the model by checking that it exists on the database
-	if modelFound := datastore.Environ.DB.CheckModelExists(assertion.HeaderString("brand-id"), assertion.HeaderString("model")); modelFound {
-		response.FormatStandardResponse(true, responseValidModel, "", "", w)
+	// Check if the device is on the quarantine list, by its serial number or device-key
+	fingerprint := ""
+	if serial, ok := assertion.(*asserts.Serial); ok {
+		fingerprint = serial.DeviceKey().ID()
+	}
+	quarantine, err := srv.DB.CheckDeviceQuarantine(assertion.HeaderString("brand-id"), assertion.HeaderString("serial"), fingerprint)
+	if err != nil && err != sql.ErrNoRows {
+		response.FormatStandardResponse(false, response.ErrorQuarantinedDevice.Code, "", err.Error(), w)
+		return
+	}
+	if err == nil {
+		response.FormatStandardResponse(true, responseQuarantined, "", quarantine.Reason, w)
 		return
 	}
 
-	// Assume that this is a pivoted serial assertion
-	// Check for a sub-store model for the pivot
-	if _, err = datastore.Environ.DB.GetSubstoreModel(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial")); err != nil {
+	// Check for the model of the device, or the sub-store model that it was pivoted to
+	resolved, err := validation.ResolveSerial(srv.DB, assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"))
+	if err != nil {
 		svlog.Message("CHECK", "invalid-substore", "Cannot find sub-store model")
 		response.FormatStandardResponse(false, response.ErrorInvalidSubstore.Code, "", response.ErrorInvalidSubstore.Message, w)
 		return
 	}
 
-	// Found the sub-store record
-	response.FormatStandardResponse(true, responseValidSubstore, "", "", w)
+	if resolved == validation.ResolvedSubstore {
+		response.FormatStandardResponse(true, responseValidSubstore, "", "", w)
+		return
+	}
+	response.FormatStandardResponse(true, responseValidModel, "", "", w)
 }
@@ -21,6 +21,7 @@
 package assertion
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -31,6 +32,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/random"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/keyusage"
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/snapcore/snapd/asserts"
@@ -38,17 +40,17 @@ import (
 )
 
 // systemUserAssertionAction is called by the API method to generate a system-user assertion
-func systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, apiCall bool, user SystemUserRequest) {
+func (srv *Service) systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, apiCall bool, user SystemUserRequest) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(authUser, datastore.Standard, apiCall)
+	err := auth.CheckUserPermissions(authUser, datastore.Standard, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
 		return
 	}
 
 	// Get the model:
-	model, err := datastore.Environ.DB.GetAllowedModel(user.ModelID, datastore.User{})
+	model, err := srv.DB.GetAllowedModel(user.ModelID, datastore.User{})
 	if err != nil {
 		svlog.Message("USER", response.ErrorInvalidModelID.Code, response.ErrorInvalidModelID.Message)
 		response.FormatStandardResponse(false, response.ErrorInvalidModelID.Code, "", response.ErrorInvalidModelID.Message, w)
@@ -56,7 +58,7 @@ func systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, a
 	}
 
 	// Generate the system-user assertion and return the response
-	resp := GenerateSystemUserAssertion(user, model)
+	resp := srv.GenerateSystemUserAssertion(user, model)
 	if !resp.Success {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -68,7 +70,7 @@ func systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, a
 }
 
 // GenerateSystemUserAssertion creates a system-user assertion from the model and user details
-func GenerateSystemUserAssertion(user SystemUserRequest, model datastore.Model) SystemUserResponse {
+func (srv *Service) GenerateSystemUserAssertion(user SystemUserRequest, model datastore.Model) SystemUserResponse {
 	// Check that the model has an active system-user keypair
 	if !model.KeyActiveUser {
 		svlog.Message("USER", response.ErrorInactiveModel.Code, response.ErrorInactiveModel.Message)
@@ -76,7 +78,7 @@ func GenerateSystemUserAssertion(user SystemUserRequest, model datastore.Model)
 	}
 
 	// Fetch the account assertion from the database
-	account, err := datastore.Environ.DB.GetAccount(model.AuthorityIDUser)
+	account, err := srv.DB.GetAccount(model.AuthorityIDUser)
 	if err != nil {
 		svlog.Message("USER", response.ErrorAccountAssertion.Code, err.Error())
 		return SystemUserResponse{ErrorCode: response.ErrorAccountAssertion.Code, ErrorMessage: response.ErrorAccountAssertion.Message}
@@ -86,12 +88,16 @@ func GenerateSystemUserAssertion(user SystemUserRequest, model datastore.Model)
 	assertionHeaders := userRequestToAssertion(user, model)
 
 	// Sign the system-user assertion using the system-user key
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SystemUserType, assertionHeaders, nil, model.AuthorityIDUser, model.KeyIDUser, model.SealedKeyUser)
+	signedAssertion, err := srv.KeypairDB.SignAssertion(context.Background(), srv.DB, asserts.SystemUserType, assertionHeaders, nil, model.AuthorityIDUser, model.KeyIDUser, model.SealedKeyUser)
 	if err != nil {
 		svlog.Message("USER", response.ErrorSignAssertion.Code, err.Error())
 		return SystemUserResponse{ErrorCode: response.ErrorSignAssertion.Code, ErrorMessage: err.Error()}
 	}
 
+	// Alert when the system-user key signed for a model that is new for it, or not in its allowlist
+	keypair := datastore.Keypair{ID: model.KeypairIDUser, AuthorityID: model.AuthorityIDUser, KeyID: model.KeyIDUser}
+	keyusage.Check(srv.DB, srv.Config, keypair, asserts.SystemUserType.Name, model.BrandID, model.Name)
+
 	// Get the signed assertion
 	serializedAssertion := asserts.Encode(signedAssertion)
 
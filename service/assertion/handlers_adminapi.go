@@ -28,14 +28,15 @@ import (
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
 	"github.com/snapcore/snapd/asserts"
 )
 
 // APISystemUser is the API method to generate a signed system-user assertion for a device
-func APISystemUser(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APISystemUser(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the user and API key
-	authUser, err := request.CheckUserAPI(r)
+	authUser, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -55,13 +56,13 @@ func APISystemUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	systemUserAssertionAction(w, authUser, true, user)
+	srv.systemUserAssertionAction(w, authUser, true, user)
 }
 
 // APIValidateSerial is the API method to validate a serial assertion for a device
-func APIValidateSerial(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIValidateSerial(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	authUser, err := request.CheckUserAPI(r)
+	authUser, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -73,28 +74,24 @@ func APIValidateSerial(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validateAssertionAction(w, authUser, true, assertion)
+	srv.validateAssertionAction(w, authUser, true, assertion)
 }
 
 func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorResponse) {
 	defer r.Body.Close()
 
 	// Get the serial assertion from the body
-	dec := asserts.NewDecoder(r.Body)
-	assertion, err := dec.Decode()
-	if err == io.EOF {
+	assertion, err := validation.DecodeSerial(r.Body)
+	switch {
+	case err == validation.ErrEmptyData:
 		svlog.Message("CHECK", response.ErrorInvalidAssertion.Code, response.ErrorEmptyData.Message)
 		return nil, response.ErrorEmptyData
-	}
-	if err != nil {
-		svlog.Message("CHECK", response.ErrorInvalidAssertion.Code, err.Error())
-		return nil, response.ErrorResponse{Success: false, Code: "decode-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
-	}
-
-	// Check that we have a serial assertion (the details will have been validated by Decode call)
-	if assertion.Type() != asserts.SerialType {
+	case err == validation.ErrNotSerial:
 		svlog.Message("CHECK", response.ErrorInvalidType.Code, response.ErrorInvalidType.Message)
 		return nil, response.ErrorInvalidType
+	case err != nil:
+		svlog.Message("CHECK", response.ErrorInvalidAssertion.Code, err.Error())
+		return nil, response.ErrorResponse{Success: false, Code: "decode-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 	return assertion, response.ErrorResponse{Success: true}
 }
@@ -111,7 +111,7 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
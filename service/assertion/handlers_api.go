@@ -25,11 +25,17 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
+// Service holds the dependencies of the assertion handlers
+type Service struct {
+	*datastore.Env
+}
+
 // ModelAssertionRequest is the JSON version of a model assertion request
 type ModelAssertionRequest struct {
 	BrandID string `json:"brand-id"`
@@ -37,9 +43,9 @@ type ModelAssertionRequest struct {
 }
 
 // ModelAssertion is the API method to generate a model assertion
-func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+func (srv *Service) ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	// Validate the model API key
-	apiKey, err := request.CheckModelAPI(r)
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
 	if err != nil {
 		log.Message("MODEL", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
 		return response.ErrorInvalidAPIKey
@@ -59,5 +65,25 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 		return response.ErrorResponse{Success: false, Code: response.ErrorDecodeJSON.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	return modelAssertionHandler(w, apiKey, request)
+	return srv.modelAssertionHandler(r.Context(), w, apiKey, request)
+}
+
+// Bundle is the API method to fetch the account, account-key and model assertions that a
+// device needs to seed its assertion database
+func (srv *Service) Bundle(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	// Validate the model API key
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
+	if err != nil {
+		log.Message("BUNDLE", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
+		return response.ErrorInvalidAPIKey
+	}
+
+	brandID := r.URL.Query().Get("brand")
+	modelName := r.URL.Query().Get("model")
+	if len(brandID) == 0 || len(modelName) == 0 {
+		log.Message("BUNDLE", response.ErrorInvalidModel.Code, "The brand and model must be supplied")
+		return response.ErrorInvalidModel
+	}
+
+	return srv.bundleHandler(r.Context(), w, apiKey, brandID, modelName)
 }
@@ -22,15 +22,19 @@ package assertion_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/CanonicalLtd/serial-vault/account"
 	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
 	"github.com/CanonicalLtd/serial-vault/service/response"
@@ -54,10 +58,10 @@ var _ = check.Suite(&AssertionSuite{})
 func (s *AssertionSuite) SetUpTest(c *check.C) {
 	// Mock the store
 	account.FetchAssertionFromStore = account.MockFetchAssertionFromStore
-	service.MiddlewareWithCSRF = service.Middleware
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
 
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 }
@@ -67,7 +71,7 @@ func (s *AssertionSuite) sendRequest(method, url string, data io.Reader, apiKey
 	r, _ := http.NewRequest(method, url, data)
 	r.Header.Set("api-key", apiKey)
 
-	service.SigningRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -110,6 +114,91 @@ func (s *AssertionSuite) TestAssertionErrorHandler(c *check.C) {
 
 }
 
+func (s *AssertionSuite) TestBundleHandler(c *check.C) {
+	accountAssertion, err := account.MockFetchAssertionFromStore(asserts.AccountType, []string{"canonical"})
+	c.Assert(err, check.IsNil)
+	keyID, accountKey := generateAccountKey(c, "canonical")
+
+	db := datastoretest.New()
+	db.AddAccount(datastore.Account{AuthorityID: "canonical", Assertion: string(asserts.Encode(accountAssertion))})
+	keypair := db.AddKeypair(datastoretest.NewKeypair("canonical", keyID).WithAssertion(accountKey).Build())
+	db.AddModel(datastoretest.NewModel("canonical", "alder").WithKeypair(keypair).WithAPIKey("ValidAPIKey").Build())
+	datastore.Environ.DB = db
+	defer func() { datastore.Environ.DB = &datastore.MockDB{} }()
+
+	// The account and account-key assertions are returned, without the model assertion of a brand
+	// that is not a reseller
+	w := s.sendRequest("GET", "/v1/assertions/bundle?brand=canonical&model=alder", nil, "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, asserts.MediaType)
+
+	types := []string{}
+	decoder := asserts.NewDecoder(w.Body)
+	for {
+		assertion, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		types = append(types, assertion.Type().Name)
+	}
+	c.Assert(types, check.DeepEquals, []string{"account", "account-key"})
+}
+
+func (s *AssertionSuite) TestBundleHandlerErrors(c *check.C) {
+	db := datastoretest.New()
+	db.AddAccount(datastore.Account{AuthorityID: "canonical"})
+	keypair := db.AddKeypair(datastoretest.NewKeypair("canonical", "canonical-key").Build())
+	db.AddModel(datastoretest.NewModel("canonical", "alder").WithKeypair(keypair).WithAPIKey("ValidAPIKey").Build())
+	datastore.Environ.DB = db
+	defer func() { datastore.Environ.DB = &datastore.MockDB{} }()
+
+	tests := []struct {
+		URL    string
+		APIKey string
+		Code   int
+		Error  string
+	}{
+		{"/v1/assertions/bundle?brand=canonical&model=alder", "InvalidAPIKey", 400, response.ErrorInvalidAPIKey.Code},
+		{"/v1/assertions/bundle?brand=canonical", "ValidAPIKey", 400, response.ErrorInvalidModel.Code},
+		{"/v1/assertions/bundle?brand=canonical&model=ash", "ValidAPIKey", 400, response.ErrorInvalidModel.Code},
+		// The assertions are not fetched from the store
+		{"/v1/assertions/bundle?brand=canonical&model=alder", "ValidAPIKey", 404, response.ErrorMissingAssertion.Code},
+	}
+
+	for _, t := range tests {
+		w := s.sendRequest("GET", t.URL, nil, t.APIKey, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, t.Error)
+	}
+}
+
+// generateAccountKey signs an account-key assertion of the test device key for the account
+func generateAccountKey(c *check.C, accountID string) (string, string) {
+	data, err := ioutil.ReadFile("../../keystore/TestDeviceKey.asc")
+	c.Assert(err, check.IsNil)
+	privateKey, _, err := crypt.DeserializePrivateKey(base64.StdEncoding.EncodeToString(data))
+	c.Assert(err, check.IsNil)
+
+	body, err := asserts.EncodePublicKey(privateKey.PublicKey())
+	c.Assert(err, check.IsNil)
+
+	headers := map[string]interface{}{
+		"authority-id":        "system",
+		"account-id":          accountID,
+		"public-key-sha3-384": privateKey.PublicKey().ID(),
+		"since":               "2016-01-02T15:04:05Z",
+		"revision":            "1",
+	}
+	assertion, err := datastore.Environ.KeypairDB.Sign(asserts.AccountKeyType, headers, body, "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO")
+	c.Assert(err, check.IsNil)
+
+	return privateKey.PublicKey().ID(), string(asserts.Encode(assertion))
+}
+
 func validModel() []byte {
 	a := assertion.ModelAssertionRequest{
 		BrandID: "system",
@@ -64,8 +64,8 @@ type SystemUserResponse struct {
 }
 
 // SystemUserAssertion is the API method to generate a signed system-user assertion for a device
-func SystemUserAssertion(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) SystemUserAssertion(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -90,5 +90,5 @@ func SystemUserAssertion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	systemUserAssertionAction(w, authUser, false, user)
+	srv.systemUserAssertionAction(w, authUser, false, user)
 }
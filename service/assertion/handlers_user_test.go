@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
 	check "gopkg.in/check.v1"
@@ -59,7 +60,7 @@ func sendSystemUserAssertion(request string, c *check.C) (int, bool, string) {
 	// Submit the serial-request assertion for signing
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("POST", "/v1/assertions", bytes.NewBufferString(request))
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	// Check the JSON response
 	result := assertion.SystemUserResponse{}
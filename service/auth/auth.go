@@ -23,14 +23,15 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/usso"
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
 // GetUserFromJWT retrieves the user details from the JSON Web Token
-func GetUserFromJWT(w http.ResponseWriter, r *http.Request) (datastore.User, error) {
-	token, err := JWTCheck(w, r)
+func GetUserFromJWT(w http.ResponseWriter, r *http.Request, settings config.Settings) (datastore.User, error) {
+	token, err := JWTCheck(w, r, settings)
 	if err != nil {
 		return datastore.User{}, err
 	}
@@ -40,20 +41,56 @@ func GetUserFromJWT(w http.ResponseWriter, r *http.Request) (datastore.User, err
 		return datastore.User{}, nil
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	username := claims[usso.ClaimsUsername].(string)
-	role := int(claims[usso.ClaimsRole].(float64))
+	return userFromClaims(token.Claims.(jwt.MapClaims)), nil
+}
+
+// GetImpersonationFromJWT retrieves the user details from the JSON Web Token, when a superuser
+// is acting as the user. The request is not checked to have a valid token otherwise
+func GetImpersonationFromJWT(r *http.Request, settings config.Settings) (datastore.User, bool) {
+	if !settings.EnableUserAuth || !hasJWT(r) {
+		return datastore.User{}, false
+	}
+
+	jwtToken, err := usso.JWTExtractor(r)
+	if err != nil {
+		return datastore.User{}, false
+	}
+	token, err := usso.VerifyJWT(jwtToken, settings.JwtSecret)
+	if err != nil || !token.Valid {
+		return datastore.User{}, false
+	}
 
-	return datastore.User{
-		Username: username,
-		Role:     role,
-	}, nil
+	user := userFromClaims(token.Claims.(jwt.MapClaims))
+	return user, len(user.Impersonator) > 0
+}
+
+// hasJWT checks if the request has a JWT in the header or the cookie
+func hasJWT(r *http.Request) bool {
+	if len(r.Header.Get("Authorization")) > 0 {
+		return true
+	}
+	_, err := r.Cookie(usso.JWTCookie)
+	return err == nil
+}
+
+func userFromClaims(claims jwt.MapClaims) datastore.User {
+	user := datastore.User{
+		Username: claims[usso.ClaimsUsername].(string),
+		Role:     int(claims[usso.ClaimsRole].(float64)),
+	}
+
+	// The superuser that is acting as the user, in the support mode
+	if impersonator, ok := claims[usso.ClaimsImpersonator].(string); ok {
+		user.Impersonator = impersonator
+		user.ImpersonatedBrand, _ = claims[usso.ClaimsBrand].(string)
+	}
+	return user
 }
 
 // CheckUserPermissions verifies that a user has a minimum role
-func CheckUserPermissions(user datastore.User, minimumAuthorizedRole int, apiCall bool) error {
+func CheckUserPermissions(user datastore.User, minimumAuthorizedRole int, apiCall bool, settings config.Settings) error {
 	// User authentication is turned off (ignore if this is an Admin API call)
-	if !apiCall && !datastore.Environ.Config.EnableUserAuth {
+	if !apiCall && !settings.EnableUserAuth {
 		// Superuser permissions don't allow turned off authentication
 		if minimumAuthorizedRole == datastore.Superuser {
 			return errors.New("The user is not authorized")
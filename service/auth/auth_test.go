@@ -47,19 +47,18 @@ var _ = check.Suite(&authSuite{})
 
 func (s *authSuite) TestGetUserAuthWhenAuthEnabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/", nil)
 
-	_, err := auth.GetUserFromJWT(w, r)
+	_, err := auth.GetUserFromJWT(w, r, config)
 	c.Assert(err, check.NotNil)
 
 	theRoles := []int{datastore.Standard, datastore.Admin, datastore.Superuser}
 	for _, role := range theRoles {
 		err := createJWTWithRole(r, role)
 		c.Assert(err, check.IsNil)
-		user, err := auth.GetUserFromJWT(w, r)
+		user, err := auth.GetUserFromJWT(w, r, config)
 		c.Assert(err, check.IsNil)
 		c.Assert(user.Username, check.Equals, "sv")
 		c.Assert(user.Role, check.Equals, role)
@@ -68,12 +67,11 @@ func (s *authSuite) TestGetUserAuthWhenAuthEnabled(c *check.C) {
 
 func (s *authSuite) TestGetUserAuthWhenAuthDisabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: false, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/", nil)
 
-	user, err := auth.GetUserFromJWT(w, r)
+	user, err := auth.GetUserFromJWT(w, r, config)
 	c.Assert(err, check.IsNil)
 	c.Assert(user.Username, check.Equals, "")
 	c.Assert(user.Role, check.Equals, 0)
@@ -82,7 +80,7 @@ func (s *authSuite) TestGetUserAuthWhenAuthDisabled(c *check.C) {
 	for _, role := range roles {
 		err := createJWTWithRole(r, role)
 		c.Assert(err, check.IsNil)
-		user, err := auth.GetUserFromJWT(w, r)
+		user, err := auth.GetUserFromJWT(w, r, config)
 		c.Assert(err, check.IsNil)
 		c.Assert(user.Username, check.Equals, "")
 		c.Assert(user.Role, check.Equals, 0)
@@ -91,7 +89,6 @@ func (s *authSuite) TestGetUserAuthWhenAuthDisabled(c *check.C) {
 
 func (s *authSuite) TestCheckStandardPermissionsWhenAuthEnabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
 
 	noRoleUser := datastore.User{Username: "auser", Role: 0}
 	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
@@ -106,14 +103,13 @@ func (s *authSuite) TestCheckStandardPermissionsWhenAuthEnabled(c *check.C) {
 	}
 
 	for _, t := range tests {
-		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false, config)
 		c.Assert(err, t.Check)
 	}
 }
 
 func (s *authSuite) TestCheckStandardPermissionsWhenAuthDisabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: false, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
 
 	noRoleUser := datastore.User{Username: "auser", Role: 0}
 	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
@@ -128,14 +124,13 @@ func (s *authSuite) TestCheckStandardPermissionsWhenAuthDisabled(c *check.C) {
 	}
 
 	for _, t := range tests {
-		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false, config)
 		c.Assert(err, t.Check)
 	}
 }
 
 func (s *authSuite) TestCheckAdminPermissionsWhenAuthEnabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
 
 	noRoleUser := datastore.User{Username: "auser", Role: 0}
 	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
@@ -150,14 +145,13 @@ func (s *authSuite) TestCheckAdminPermissionsWhenAuthEnabled(c *check.C) {
 	}
 
 	for _, t := range tests {
-		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false, config)
 		c.Assert(err, t.Check)
 	}
 }
 
 func (s *authSuite) TestCheckAdminPermissionsWhenAuthDisabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: false, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
 
 	noRoleUser := datastore.User{Username: "auser", Role: 0}
 	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
@@ -172,14 +166,13 @@ func (s *authSuite) TestCheckAdminPermissionsWhenAuthDisabled(c *check.C) {
 	}
 
 	for _, t := range tests {
-		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false, config)
 		c.Assert(err, t.Check)
 	}
 }
 
 func (s *authSuite) TestCheckSuperuserPermissionsWhenAuthEnabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
 
 	noRoleUser := datastore.User{Username: "auser", Role: 0}
 	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
@@ -194,7 +187,7 @@ func (s *authSuite) TestCheckSuperuserPermissionsWhenAuthEnabled(c *check.C) {
 	}
 
 	for _, t := range tests {
-		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false, config)
 		c.Assert(err, t.Check)
 	}
 }
@@ -202,7 +195,6 @@ func (s *authSuite) TestCheckSuperuserPermissionsWhenAuthEnabled(c *check.C) {
 func (s *authSuite) TestCheckSuperuserPermissionsWhenAuthDisabled(c *check.C) {
 
 	config := config.Settings{EnableUserAuth: false, JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
 
 	noRoleUser := datastore.User{Username: "auser", Role: 0}
 	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
@@ -217,16 +209,51 @@ func (s *authSuite) TestCheckSuperuserPermissionsWhenAuthDisabled(c *check.C) {
 	}
 
 	for _, t := range tests {
-		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false, config)
 		c.Assert(err, t.Check)
 	}
 
 }
 
+func (s *authSuite) TestGetImpersonationFromJWT(c *check.C) {
+	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	_, ok := auth.GetImpersonationFromJWT(r, config)
+	c.Assert(ok, check.Equals, false)
+
+	// A token of the user is not an impersonation
+	err := createJWTWithRole(r, datastore.Admin)
+	c.Assert(err, check.IsNil)
+	_, ok = auth.GetImpersonationFromJWT(r, config)
+	c.Assert(ok, check.Equals, false)
+
+	jwtToken, err := usso.NewImpersonationJWT(datastore.User{Username: "sv", Role: datastore.Admin}, "root", "system", config.JwtSecret)
+	c.Assert(err, check.IsNil)
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+
+	user, ok := auth.GetImpersonationFromJWT(r, config)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(user.Username, check.Equals, "sv")
+	c.Assert(user.Role, check.Equals, datastore.Admin)
+	c.Assert(user.Impersonator, check.Equals, "root")
+	c.Assert(user.ImpersonatedBrand, check.Equals, "system")
+
+	// The user of the handlers is flagged with the impersonator
+	user, err = auth.GetUserFromJWT(httptest.NewRecorder(), r, config)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.Impersonator, check.Equals, "root")
+
+	// The token must be signed with the secret
+	config.JwtSecret = "AnotherSecret"
+	_, ok = auth.GetImpersonationFromJWT(r, config)
+	c.Assert(ok, check.Equals, false)
+}
+
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, role)
+	jwtToken, err := usso.NewJWTToken(&resp, role, "SomeTestSecretValue")
 	if err != nil {
 		return fmt.Errorf("Error creating a JWT: %v", err)
 	}
@@ -24,16 +24,16 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/usso"
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
 // JWTCheck extracts the JWT from the request, validates it and returns the token
-func JWTCheck(w http.ResponseWriter, r *http.Request) (*jwt.Token, error) {
+func JWTCheck(w http.ResponseWriter, r *http.Request, settings config.Settings) (*jwt.Token, error) {
 
 	// Do not validate access if user authentication is off (default)
-	if !datastore.Environ.Config.EnableUserAuth {
+	if !settings.EnableUserAuth {
 		return nil, nil
 	}
 
@@ -45,7 +45,7 @@ func JWTCheck(w http.ResponseWriter, r *http.Request) (*jwt.Token, error) {
 	}
 
 	// Verify the JWT string
-	token, err := usso.VerifyJWT(jwtToken)
+	token, err := usso.VerifyJWT(jwtToken, settings.JwtSecret)
 	if err != nil {
 		log.Printf("JWT fails verification: %v", err.Error())
 		return nil, errors.New("The authentication token is invalid")
@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/service/backpressure"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// queued tracks the signing requests in the signing queue, reporting its depth and utilization in
+// the response headers. Above the watermark, the request is rejected telling the client when to retry
+func (srv *Service) queued(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, ok, retry := backpressure.Signing.Enter()
+		backpressure.Signing.SetHeaders(w)
+		if !ok {
+			svlog.Message("QUEUE", response.ErrorQueueFull.Code, r.Method+" "+r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			w.Header().Set("Content-Type", response.JSONHeader)
+			w.WriteHeader(response.ErrorQueueFull.StatusCode)
+			json.NewEncoder(w).Encode(response.ErrorQueueFull)
+			return
+		}
+		defer release()
+
+		inner.ServeHTTP(w, r)
+	})
+}
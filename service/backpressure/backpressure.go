@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package backpressure tracks the depth of the signing queue: the signing requests that the vault
+// is processing, including those that wait for the keystore. The depth and the utilization of the
+// capacity are reported to the clients, and above the watermark new signing requests are rejected
+// straight away, telling the clients when to retry instead of letting them time out.
+package backpressure
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+// Default limits of the signing queue
+const (
+	DefaultCapacity   = 50
+	DefaultRetryAfter = 5 * time.Second
+)
+
+// Response headers with the state of the signing queue
+const (
+	DepthHeader       = "X-Vault-Queue-Depth"
+	UtilizationHeader = "X-Vault-Queue-Utilization"
+)
+
+// Limits are the limits of a signing queue
+type Limits struct {
+	Capacity   int           // the signing requests the vault is sized to process at a time
+	Watermark  int           // the utilization percentage above which requests are rejected (zero never rejects)
+	RetryAfter time.Duration // the time the rejected clients should wait before retrying
+}
+
+// Status is the state of a signing queue
+type Status struct {
+	Depth       int  `json:"depth"`
+	Capacity    int  `json:"capacity"`
+	Utilization int  `json:"utilization"`
+	Watermark   int  `json:"watermark,omitempty"`
+	Accepting   bool `json:"accepting"`
+}
+
+// Queue tracks the depth of a signing queue
+type Queue struct {
+	lock  sync.Mutex
+	depth int
+
+	limits func() Limits
+}
+
+// Signing is the queue of the signing requests. The service sets the limits from its config
+var Signing = New(ConfigLimits(&config.Settings{}))
+
+// New creates an empty signing queue. The limits function returns the current limits
+func New(limits func() Limits) *Queue {
+	return &Queue{limits: limits}
+}
+
+// ConfigLimits returns the limits function of the settings
+func ConfigLimits(settings *config.Settings) func() Limits {
+	return func() Limits {
+		limits := Limits{
+			Capacity:   DefaultCapacity,
+			Watermark:  settings.SigningQueueWatermark,
+			RetryAfter: DefaultRetryAfter,
+		}
+		if settings.SigningQueueCapacity > 0 {
+			limits.Capacity = settings.SigningQueueCapacity
+		}
+		if settings.SigningQueueRetryAfter > 0 {
+			limits.RetryAfter = time.Duration(settings.SigningQueueRetryAfter) * time.Second
+		}
+		return limits
+	}
+}
+
+// SetLimits replaces the limits function of the queue
+func (q *Queue) SetLimits(limits func() Limits) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.limits = limits
+}
+
+// Enter adds a request to the queue, unless the queue is above the watermark. The returned
+// function removes the request from the queue. When the request is rejected, the time until
+// the client should retry is returned
+func (q *Queue) Enter() (func(), bool, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	limits := q.limits()
+
+	if limits.Watermark > 0 && utilization(q.depth+1, limits.Capacity) > limits.Watermark {
+		metrics.Increment(metrics.QueueRejected)
+		return nil, false, limits.RetryAfter
+	}
+
+	q.depth++
+	released := false
+	return func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+
+		// The request is removed once, even when the function is called again
+		if !released {
+			released = true
+			q.depth--
+		}
+	}, true, 0
+}
+
+// Status returns the state of the queue
+func (q *Queue) Status() Status {
+	q.lock.Lock()
+	limits := q.limits()
+	depth := q.depth
+	q.lock.Unlock()
+
+	status := Status{
+		Depth:       depth,
+		Capacity:    limits.Capacity,
+		Utilization: utilization(depth, limits.Capacity),
+		Watermark:   limits.Watermark,
+	}
+	status.Accepting = limits.Watermark == 0 || utilization(depth+1, limits.Capacity) <= limits.Watermark
+	return status
+}
+
+// SetHeaders sets the depth and the utilization of the queue in the response headers
+func (q *Queue) SetHeaders(w http.ResponseWriter) {
+	status := q.Status()
+	w.Header().Set(DepthHeader, strconv.Itoa(status.Depth))
+	w.Header().Set(UtilizationHeader, fmt.Sprintf("%d%%", status.Utilization))
+}
+
+// utilization returns the percentage of the capacity that the depth uses
+func utilization(depth, capacity int) int {
+	if capacity <= 0 {
+		return 0
+	}
+	return depth * 100 / capacity
+}
@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backpressure
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func TestBackpressureSuite(t *testing.T) { check.TestingT(t) }
+
+type BackpressureSuite struct{}
+
+var _ = check.Suite(&BackpressureSuite{})
+
+func testQueue(watermark int) *Queue {
+	return New(func() Limits { return Limits{Capacity: 4, Watermark: watermark, RetryAfter: 3 * time.Second} })
+}
+
+func (s *BackpressureSuite) TestEnter(c *check.C) {
+	q := testQueue(50)
+
+	release1, ok, _ := q.Enter()
+	c.Assert(ok, check.Equals, true)
+	release2, ok, _ := q.Enter()
+	c.Assert(ok, check.Equals, true)
+	c.Assert(q.Status(), check.DeepEquals, Status{Depth: 2, Capacity: 4, Utilization: 50, Watermark: 50})
+
+	// Above the watermark the request is rejected, and the queue is not changed
+	_, ok, retry := q.Enter()
+	c.Assert(ok, check.Equals, false)
+	c.Assert(retry, check.Equals, 3*time.Second)
+	c.Assert(q.Status().Depth, check.Equals, 2)
+
+	// A request is removed once from the queue
+	release1()
+	release1()
+	c.Assert(q.Status(), check.DeepEquals, Status{Depth: 1, Capacity: 4, Utilization: 25, Watermark: 50, Accepting: true})
+
+	release2()
+	c.Assert(q.Status().Depth, check.Equals, 0)
+}
+
+func (s *BackpressureSuite) TestNoWatermark(c *check.C) {
+	q := testQueue(0)
+
+	for i := 0; i < 6; i++ {
+		_, ok, _ := q.Enter()
+		c.Assert(ok, check.Equals, true)
+	}
+	c.Assert(q.Status(), check.DeepEquals, Status{Depth: 6, Capacity: 4, Utilization: 150, Accepting: true})
+}
+
+func (s *BackpressureSuite) TestSetHeaders(c *check.C) {
+	q := testQueue(0)
+	q.Enter()
+
+	w := httptest.NewRecorder()
+	q.SetHeaders(w)
+	c.Assert(w.Header().Get(DepthHeader), check.Equals, "1")
+	c.Assert(w.Header().Get(UtilizationHeader), check.Equals, "25%")
+}
@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/backpressure"
+	check "gopkg.in/check.v1"
+)
+
+type BackpressureSuite struct {
+	srv *Service
+}
+
+var _ = check.Suite(&BackpressureSuite{})
+
+func (s *BackpressureSuite) SetUpTest(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: datastoretest.New(), Config: config.Settings{SigningQueueCapacity: 2, SigningQueueWatermark: 50, SigningQueueRetryAfter: 7}}
+	s.srv = NewService(datastore.Environ)
+}
+
+func (s *BackpressureSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *BackpressureSuite) TestQueued(c *check.C) {
+	var depth string
+	handler := s.srv.queued(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		depth = w.Header().Get(backpressure.DepthHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/serial", nil)
+	handler.ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(depth, check.Equals, "1")
+	c.Assert(w.Header().Get(backpressure.UtilizationHeader), check.Equals, "50%")
+	c.Assert(backpressure.Signing.Status().Depth, check.Equals, 0)
+
+	// Another signing request is in progress, so the queue is at its watermark
+	release, ok, _ := backpressure.Signing.Enter()
+	c.Assert(ok, check.Equals, true)
+	defer release()
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(w.Header().Get("Retry-After"), check.Equals, "7")
+	c.Assert(w.Header().Get(backpressure.DepthHeader), check.Equals, "1")
+}
@@ -0,0 +1,207 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package billing meters the signings of the accounts per billing period, for the usage-based
+// billing of the brands
+package billing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Supported export formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// pushTimeout is the limit for sending a closed billing period to the billing hook
+const pushTimeout = 30 * time.Second
+
+var pushClient = &http.Client{Timeout: pushTimeout}
+
+var csvHeader = []string{"period", "authority-id", "model", "signings", "devices"}
+
+// Response is the JSON response from the API billing period method
+type Response struct {
+	Success      bool                    `json:"success"`
+	ErrorCode    string                  `json:"error_code"`
+	ErrorSubcode string                  `json:"error_subcode"`
+	ErrorMessage string                  `json:"message"`
+	Period       datastore.BillingPeriod `json:"period"`
+}
+
+// ListResponse is the JSON response from the API billing periods method
+type ListResponse struct {
+	Success      bool                      `json:"success"`
+	ErrorCode    string                    `json:"error_code"`
+	ErrorSubcode string                    `json:"error_subcode"`
+	ErrorMessage string                    `json:"message"`
+	Periods      []datastore.BillingPeriod `json:"periods"`
+}
+
+// listHandler is the API method to fetch the closed billing periods
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	periods, err := srv.DB.ListBillingPeriods()
+	if err != nil {
+		response.FormatStandardResponse(false, "error-billing", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatResponse(ListResponse{Success: true, Periods: periods}, w)
+}
+
+// periodHandler is the API method to export the usage of a billing period, as JSON or as a CSV
+// file. The usage of a period that is not closed is the current usage, which may still change
+func (srv *Service) periodHandler(w http.ResponseWriter, user datastore.User, apiCall bool, period, format string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(format) == 0 {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatCSV {
+		response.FormatStandardResponse(false, "error-billing", "", "The format must be json or csv", w)
+		return
+	}
+
+	p, err := srv.DB.GetBillingPeriod(period)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-billing", "", err.Error(), w)
+		return
+	}
+
+	if format == FormatJSON {
+		w.WriteHeader(http.StatusOK)
+		formatResponse(Response{Success: true, Period: p}, w)
+		return
+	}
+
+	document, err := formatCSV(p)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-billing", "", err.Error(), w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=billing-%s.csv", p.Period))
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+}
+
+// closeHandler is the API method to take the immutable snapshot of the usage of a billing
+// period that has ended, which is pushed to the billing hook
+func (srv *Service) closeHandler(w http.ResponseWriter, user datastore.User, apiCall bool, period string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	p, err := srv.DB.CloseBillingPeriod(period, user.Username)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-billing", "", err.Error(), w)
+		return
+	}
+	log.Infof("Billing period %s closed by %s with digest %s", p.Period, user.Username, p.Digest)
+
+	Push(srv.Config.BillingWebhook, p)
+
+	w.WriteHeader(http.StatusOK)
+	formatResponse(Response{Success: true, Period: p}, w)
+}
+
+// Push sends a closed billing period to the billing hook, in the background
+var Push = func(url string, period datastore.BillingPeriod) {
+	if len(url) == 0 {
+		return
+	}
+
+	go func() {
+		if err := sendPeriod(url, period); err != nil {
+			log.Errorf("Error pushing billing period %s: %v", period.Period, err)
+		}
+	}()
+}
+
+func sendPeriod(url string, period datastore.BillingPeriod) error {
+	data, err := json.Marshal(period)
+	if err != nil {
+		return err
+	}
+
+	resp, err := pushClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the billing hook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// formatCSV writes the usage of the billing period, one line per account and model
+func formatCSV(p datastore.BillingPeriod) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	writer.Write(csvHeader)
+	for _, u := range p.Usage {
+		writer.Write([]string{p.Period, u.AuthorityID, u.Model, strconv.Itoa(u.Signings), strconv.Itoa(u.Devices)})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func formatResponse(resp interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error forming the billing response: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package billing
+
+import (
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// Service holds the dependencies of the billing handlers
+type Service struct {
+	*datastore.Env
+}
+
+// List is the API method to fetch the closed billing periods
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.listHandler(w, authUser, false)
+}
+
+// Period is the API method to export the usage of a billing period
+func (srv *Service) Period(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.periodHandler(w, authUser, false, mux.Vars(r)["period"], r.URL.Query().Get("format"))
+}
+
+// Close is the API method to close a billing period
+func (srv *Service) Close(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.closeHandler(w, authUser, false, mux.Vars(r)["period"])
+}
+
+// APIList is the API method to fetch the closed billing periods
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.listHandler(w, user, true)
+}
+
+// APIPeriod is the API method to export the usage of a billing period
+func (srv *Service) APIPeriod(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.periodHandler(w, user, true, mux.Vars(r)["period"], r.URL.Query().Get("format"))
+}
+
+// APIClose is the API method to close a billing period
+func (srv *Service) APIClose(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.closeHandler(w, user, true, mux.Vars(r)["period"])
+}
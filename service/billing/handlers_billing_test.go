@@ -0,0 +1,186 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package billing_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/billing"
+	check "gopkg.in/check.v1"
+)
+
+func TestBillingSuite(t *testing.T) { check.TestingT(t) }
+
+type BillingSuite struct {
+	db     *datastoretest.DB
+	pushed []datastore.BillingPeriod
+}
+
+var _ = check.Suite(&BillingSuite{})
+
+func (s *BillingSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin})
+
+	signed := []struct {
+		brand, model, serial string
+		created              time.Time
+	}{
+		{"system", "alder", "A001", time.Date(2018, 5, 31, 23, 59, 0, 0, time.UTC)},
+		{"system", "alder", "A001", time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"system", "alder", "A001", time.Date(2018, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"system", "alder", "A002", time.Date(2018, 6, 3, 0, 0, 0, 0, time.UTC)},
+		{"system", "ash", "B001", time.Date(2018, 6, 30, 23, 59, 0, 0, time.UTC)},
+		{"generic", "alder", "A001", time.Date(2018, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{"system", "alder", "A003", time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, l := range signed {
+		s.db.AddSigningLog(datastore.SigningLog{Make: l.brand, Model: l.model, SerialNumber: l.serial, Created: l.created})
+	}
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true,
+		BillingWebhook: "https://billing.example.com/usage"}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+
+	s.pushed = nil
+	billing.Push = func(url string, p datastore.BillingPeriod) {
+		c.Assert(url, check.Equals, "https://billing.example.com/usage")
+		s.pushed = append(s.pushed, p)
+	}
+}
+
+func (s *BillingSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func sendAdminAPIRequest(method, url string, data io.Reader, username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func parseResponse(c *check.C, w *httptest.ResponseRecorder) billing.Response {
+	result := billing.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *BillingSuite) TestPeriod(c *check.C) {
+	w := sendAdminAPIRequest("GET", "/api/billing/2018-06", nil, "root")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := parseResponse(c, w)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Period.Closed, check.Equals, false)
+	c.Assert(result.Period.Usage, check.DeepEquals, []datastore.BillingUsage{
+		{AuthorityID: "generic", Model: "alder", Signings: 1, Devices: 1},
+		{AuthorityID: "system", Model: "alder", Signings: 3, Devices: 2},
+		{AuthorityID: "system", Model: "ash", Signings: 1, Devices: 1},
+	})
+}
+
+func (s *BillingSuite) TestPeriodCSV(c *check.C) {
+	w := sendAdminAPIRequest("GET", "/api/billing/2018-06?format=csv", nil, "root")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, "text/csv; charset=UTF-8")
+	c.Assert(w.Body.String(), check.Equals, `period,authority-id,model,signings,devices
+2018-06,generic,alder,1,1
+2018-06,system,alder,3,2
+2018-06,system,ash,1,1
+`)
+}
+
+func (s *BillingSuite) TestPeriodInvalid(c *check.C) {
+	tests := []struct {
+		url      string
+		username string
+		code     string
+	}{
+		{"/api/billing/2018-06", "sv", "error-auth"},
+		{"/api/billing/2018-13", "root", "error-billing"},
+		{"/api/billing/2018-06?format=xml", "root", "error-billing"},
+		{"/api/billing/2018-06/close", "sv", "error-auth"},
+	}
+
+	for _, t := range tests {
+		method := "GET"
+		if t.url == "/api/billing/2018-06/close" {
+			method = "POST"
+		}
+		w := sendAdminAPIRequest(method, t.url, nil, t.username)
+		result := parseResponse(c, w)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.code)
+	}
+}
+
+func (s *BillingSuite) TestClose(c *check.C) {
+	w := sendAdminAPIRequest("POST", "/api/billing/2018-06/close", nil, "root")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	result := parseResponse(c, w)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Period.Closed, check.Equals, true)
+	c.Assert(result.Period.ClosedBy, check.Equals, "root")
+	c.Assert(result.Period.Digest, check.Equals, datastore.BillingDigest(result.Period.Usage))
+	c.Assert(s.pushed, check.HasLen, 1)
+	c.Assert(s.pushed[0].Period, check.Equals, "2018-06")
+
+	// The snapshot does not change with the signings that are synced later
+	s.db.AddSigningLog(datastore.SigningLog{Make: "system", Model: "ash", SerialNumber: "B002", Created: time.Date(2018, 6, 20, 0, 0, 0, 0, time.UTC)})
+	w = sendAdminAPIRequest("GET", "/api/billing/2018-06", nil, "root")
+	period := parseResponse(c, w).Period
+	c.Assert(period.Closed, check.Equals, true)
+	c.Assert(period.Usage, check.DeepEquals, result.Period.Usage)
+
+	// A period is closed once
+	w = sendAdminAPIRequest("POST", "/api/billing/2018-06/close", nil, "root")
+	c.Assert(parseResponse(c, w).ErrorMessage, check.Equals, datastore.ErrBillingPeriodClosed.Error())
+	c.Assert(s.pushed, check.HasLen, 1)
+
+	w = sendAdminAPIRequest("GET", "/api/billing", nil, "root")
+	list := billing.ListResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&list), check.IsNil)
+	c.Assert(list.Periods, check.HasLen, 1)
+	c.Assert(list.Periods[0].Usage, check.IsNil)
+}
+
+func (s *BillingSuite) TestCloseCurrentPeriod(c *check.C) {
+	period := time.Now().UTC().Format(datastore.BillingPeriodFormat)
+
+	w := sendAdminAPIRequest("POST", "/api/billing/"+period+"/close", nil, "root")
+	c.Assert(parseResponse(c, w).ErrorMessage, check.Equals, datastore.ErrBillingPeriodOpen.Error())
+	c.Assert(s.pushed, check.HasLen, 0)
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package breaker holds the circuit breaker of the datastore in the signing path. When the
+// datastore keeps failing, the breaker opens and the signing requests are shed straight away,
+// instead of piling up on slow, failing queries. After the cool-down one request is let
+// through to probe the datastore, which closes the breaker when it succeeds.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+)
+
+// Default limits of the circuit breaker
+const (
+	DefaultFailures = 5
+	DefaultCooldown = 30 * time.Second
+)
+
+// States of the circuit breaker
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half-open"
+)
+
+// Breaker is a circuit breaker that opens after a number of consecutive failures
+type Breaker struct {
+	lock     sync.Mutex
+	state    string
+	failures int
+	until    time.Time // requests are shed until then, when the breaker is not closed
+
+	limits func() (int, time.Duration)
+	now    func() time.Time
+}
+
+// Datastore is the circuit breaker of the datastore operations in the signing path.
+// The service sets the limits from its config
+var Datastore = New(ConfigLimits(&config.Settings{}))
+
+// New creates a closed circuit breaker. The limits function returns the number of
+// consecutive failures that open the breaker and the cool-down before it is probed
+func New(limits func() (int, time.Duration)) *Breaker {
+	return &Breaker{state: Closed, limits: limits, now: time.Now}
+}
+
+// ConfigLimits returns the limits function of the settings
+func ConfigLimits(settings *config.Settings) func() (int, time.Duration) {
+	return func() (int, time.Duration) {
+		failures, cooldown := DefaultFailures, DefaultCooldown
+		if settings.BreakerFailures > 0 {
+			failures = settings.BreakerFailures
+		}
+		if settings.BreakerCooldown > 0 {
+			cooldown = time.Duration(settings.BreakerCooldown) * time.Second
+		}
+		return failures, cooldown
+	}
+}
+
+// SetLimits replaces the limits function of the breaker
+func (b *Breaker) SetLimits(limits func() (int, time.Duration)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.limits = limits
+}
+
+// Allow checks if a request may go ahead. When it is shed, the time until the
+// breaker will be probed again is returned
+func (b *Breaker) Allow() (bool, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == Closed {
+		return true, 0
+	}
+
+	now := b.now()
+	if now.Before(b.until) {
+		metrics.Increment(metrics.BreakerShed)
+		return false, b.until.Sub(now)
+	}
+
+	// Let this request probe the datastore, shedding the others until the next cool-down
+	_, cooldown := b.limits()
+	b.state = HalfOpen
+	b.until = now.Add(cooldown)
+	return true, 0
+}
+
+// Success records a successful operation, which closes the breaker
+func (b *Breaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.state = Closed
+	b.failures = 0
+}
+
+// Failure records a failed operation, opening the breaker when the failure
+// budget is used up or when the probe of the datastore fails
+func (b *Breaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	failures, cooldown := b.limits()
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= failures) {
+		metrics.Increment(metrics.BreakerOpened)
+		b.state = Open
+		b.until = b.now().Add(cooldown)
+	}
+}
+
+// State returns the state of the circuit breaker
+func (b *Breaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
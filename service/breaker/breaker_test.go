@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func TestBreakerSuite(t *testing.T) { check.TestingT(t) }
+
+type BreakerSuite struct{}
+
+var _ = check.Suite(&BreakerSuite{})
+
+// testBreaker creates a breaker that opens after two failures, with a clock set by the test
+func testBreaker(now *time.Time) *Breaker {
+	b := New(func() (int, time.Duration) { return 2, 10 * time.Second })
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+func (s *BreakerSuite) TestOpen(c *check.C) {
+	now := time.Now()
+	b := testBreaker(&now)
+
+	// A success resets the failure budget
+	b.Failure()
+	b.Success()
+	b.Failure()
+	c.Assert(b.State(), check.Equals, Closed)
+	ok, _ := b.Allow()
+	c.Assert(ok, check.Equals, true)
+
+	b.Failure()
+	c.Assert(b.State(), check.Equals, Open)
+
+	now = now.Add(4 * time.Second)
+	ok, retry := b.Allow()
+	c.Assert(ok, check.Equals, false)
+	c.Assert(retry, check.Equals, 6*time.Second)
+}
+
+func (s *BreakerSuite) TestProbe(c *check.C) {
+	now := time.Now()
+	b := testBreaker(&now)
+	b.Failure()
+	b.Failure()
+
+	tests := []struct {
+		Success bool
+		State   string
+	}{
+		{false, Open},
+		{true, Closed},
+	}
+
+	for _, t := range tests {
+		// A single request probes the datastore after the cool-down
+		now = now.Add(10 * time.Second)
+		ok, _ := b.Allow()
+		c.Assert(ok, check.Equals, true)
+		c.Assert(b.State(), check.Equals, HalfOpen)
+		ok, _ = b.Allow()
+		c.Assert(ok, check.Equals, false)
+
+		if t.Success {
+			b.Success()
+		} else {
+			b.Failure()
+		}
+		c.Assert(b.State(), check.Equals, t.State)
+	}
+
+	ok, _ := b.Allow()
+	c.Assert(ok, check.Equals, true)
+}
@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content codings of the compressed responses
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// Compress is a middleware that compresses the response with gzip or deflate, when the
+// client accepts it. The handlers that set their own content coding are not compressed
+func Compress(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if len(encoding) == 0 || r.Method == "HEAD" {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		inner.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the preferred content coding of the Accept-Encoding header that the
+// service supports, with gzip preferred over deflate for the same quality, or "" for none
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				q = v
+			}
+		}
+
+		switch coding {
+		case encodingGzip, "*":
+			coding = encodingGzip
+		case encodingDeflate:
+		default:
+			continue
+		}
+		if q > bestQ || (q == bestQ && coding == encodingGzip) {
+			best, bestQ = coding, q
+		}
+	}
+	if bestQ <= 0 {
+		return ""
+	}
+	return best
+}
+
+// compressWriter compresses the body of the response. The encoder is created when the body is
+// first written, so a response without a body, or with its own content coding, is unchanged
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	encoder  io.WriteCloser
+	started  bool
+}
+
+// WriteHeader decides whether the response is compressed, before the headers are sent
+func (cw *compressWriter) WriteHeader(code int) {
+	if !cw.started {
+		cw.started = true
+		cw.start(code)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) start(code int) {
+	h := cw.Header()
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified || len(h.Get("Content-Encoding")) > 0 {
+		return
+	}
+
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.encoding)
+	if cw.encoding == encodingGzip {
+		cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		return
+	}
+	cw.encoder, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+}
+
+// Write compresses the body of the response
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.encoder.Write(b)
+}
+
+// Close flushes the compressed body of the response
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
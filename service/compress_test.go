@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	check "gopkg.in/check.v1"
+)
+
+type CompressSuite struct{}
+
+var _ = check.Suite(&CompressSuite{})
+
+var compressBody = strings.Repeat(`{"id": 1, "brand": "system", "model": "alder"}`, 100)
+
+func (s *CompressSuite) serve(handler http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/signinglog", nil)
+	if len(acceptEncoding) > 0 {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *CompressSuite) TestAcceptedEncoding(c *check.C) {
+	tests := []struct {
+		Header   string
+		Encoding string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP; q=0.8, br", "gzip"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+	}
+
+	for _, t := range tests {
+		c.Assert(acceptedEncoding(t.Header), check.Equals, t.Encoding, check.Commentf(t.Header))
+	}
+}
+
+func (s *CompressSuite) TestCompress(c *check.C) {
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Write([]byte(compressBody))
+	}))
+
+	w := s.serve(handler, "gzip")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "gzip")
+	c.Assert(w.Header().Get("Vary"), check.Equals, "Accept-Encoding")
+	c.Assert(w.Body.Len() < len(compressBody), check.Equals, true)
+	gz, err := gzip.NewReader(w.Body)
+	c.Assert(err, check.IsNil)
+	body, err := ioutil.ReadAll(gz)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(body), check.Equals, compressBody)
+
+	w = s.serve(handler, "deflate")
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "deflate")
+	body, err = ioutil.ReadAll(flate.NewReader(w.Body))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(body), check.Equals, compressBody)
+
+	// The response is not compressed for clients that do not accept it
+	w = s.serve(handler, "")
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "")
+	c.Assert(w.Body.String(), check.Equals, compressBody)
+}
+
+func (s *CompressSuite) TestCompressNoBody(c *check.C) {
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := s.serve(handler, "gzip")
+	c.Assert(w.Code, check.Equals, http.StatusNoContent)
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "")
+	c.Assert(w.Body.Len(), check.Equals, 0)
+}
+
+func (s *CompressSuite) TestCompressedRoutes(c *check.C) {
+	env := &datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{ResponseCompression: true}}
+	w := s.serve(NewService(env).AdminRouter(), "gzip")
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "gzip")
+
+	// The compression is disabled by default
+	env = &datastore.Env{DB: &datastore.MockDB{}}
+	w = s.serve(NewService(env).AdminRouter(), "gzip")
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "")
+}
+
+func (s *CompressSuite) TestHTTPServer(c *check.C) {
+	srv := NewService(&datastore.Env{})
+	server := srv.HTTPServer(":8080", http.NotFoundHandler())
+	c.Assert(server.TLSNextProto, check.IsNil)
+
+	srv = NewService(&datastore.Env{Config: config.Settings{DisableHTTP2: true}})
+	server = srv.HTTPServer(":8080", http.NotFoundHandler())
+	c.Assert(server.TLSNextProto, check.NotNil)
+	c.Assert(server.TLSNextProto, check.HasLen, 0)
+}
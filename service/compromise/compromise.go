@@ -0,0 +1,226 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+// Package compromise is the response to a compromised signing-key: it revokes the signing-key,
+// blocks the models that use it and reports the devices that it signed, in a single action
+package compromise
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/keyusage"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Roles of the compromised signing-key in a model
+const (
+	RoleSigning    = "signing"     // the signing-key of the serial assertions
+	RoleSystemUser = "system-user" // the signing-key of the system-user assertions
+	RoleFallback   = "fallback"    // a fallback signing-key
+	RoleCanary     = "canary"      // the canary signing-key
+)
+
+// Model is a model that uses the compromised signing-key. The models with the signing role are
+// blocked until they are re-pointed to another signing-key
+type Model struct {
+	ID      int    `json:"id"`
+	BrandID string `json:"brand-id"`
+	Model   string `json:"model"`
+	Role    string `json:"role"`
+}
+
+// Device is a device that was signed by the compromised signing-key. An unattributed device is
+// of a model of the signing-key, from before the signing log recorded the signer
+type Device struct {
+	BrandID      string    `json:"brand-id"`
+	Model        string    `json:"model"`
+	SerialNumber string    `json:"serial"`
+	DeviceKey    string    `json:"device-key"`
+	Revision     int       `json:"revision"`
+	Signed       time.Time `json:"signed"`
+	Fallback     bool      `json:"fallback"`
+	Attributed   bool      `json:"attributed"`
+}
+
+// Report is the result of the compromise action, with the models and the devices that are affected
+type Report struct {
+	Compromise datastore.KeypairCompromise `json:"compromise"`
+	Models     []Model                     `json:"models"`
+	Devices    []Device                    `json:"devices"`
+}
+
+// Compromise revokes the signing-key: it is deactivated and marked as compromised in one
+// transaction, so it cannot be enabled again and the factories revoke it on their next sync.
+// The action is recorded in the audit log and sent to the notification hooks of the signing-key,
+// and the report of the affected models and devices is returned
+func Compromise(db datastore.Datastore, settings config.Settings, keypairID int, reason string, user datastore.User) (Report, error) {
+	c, err := db.CompromiseAllowedKeypair(keypairID, reason, user)
+	if err != nil {
+		return Report{}, err
+	}
+
+	metrics.Increment(metrics.KeypairsCompromised)
+	log.Warningf("Signing-key %s/%s was revoked as compromised by %s: %s", c.AuthorityID, c.KeyID, user.Username, c.Reason)
+
+	entry := datastore.AuditEntry{Username: user.Username, Brand: c.AuthorityID, Action: fmt.Sprintf("COMPROMISE keypair %d", keypairID)}
+	if _, err := db.CreateAuditEntry(entry); err != nil {
+		log.Errorf("Error auditing the compromised signing-key %s: %v", c.KeyID, err)
+	}
+
+	alert := keyusage.Alert{Reason: keyusage.ReasonCompromised, AuthorityID: c.AuthorityID, KeyID: c.KeyID, Created: time.Now().UTC()}
+	for _, rule := range settings.KeyUsageAlerts {
+		if len(rule.KeyID) == 0 || rule.KeyID == c.KeyID {
+			keyusage.Notify(rule.NotifyURL, alert)
+		}
+	}
+
+	return BuildReport(db, c)
+}
+
+// BuildReport finds the models that use the compromised signing-key and the devices that it signed
+func BuildReport(db datastore.Datastore, c datastore.KeypairCompromise) (Report, error) {
+	report := Report{Compromise: c, Models: []Model{}, Devices: []Device{}}
+
+	models, err := db.ListAllowedModels(datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		return report, err
+	}
+
+	signing := map[string]bool{}
+	for _, m := range models {
+		roles, err := modelRoles(db, m, c.KeypairID)
+		if err != nil {
+			return report, err
+		}
+		for _, role := range roles {
+			report.Models = append(report.Models, Model{ID: m.ID, BrandID: m.BrandID, Model: m.Name, Role: role})
+			if role == RoleSigning {
+				signing[m.BrandID+"/"+m.Name] = true
+			}
+		}
+	}
+
+	signed, err := db.ListSigningLogForKeypair(c.KeyID)
+	if err != nil {
+		return report, err
+	}
+	for _, l := range signed {
+		report.Devices = append(report.Devices, newDevice(l, true))
+		report.Devices[len(report.Devices)-1].Fallback = l.FallbackKeyID == c.KeyID
+	}
+
+	// The entries from before the signer was recorded are attributed to the models of the signing-key
+	brands := map[string]bool{}
+	for _, m := range report.Models {
+		if m.Role != RoleSigning || brands[m.BrandID] {
+			continue
+		}
+		brands[m.BrandID] = true
+
+		logs, err := db.ListSigningLogForBrand(m.BrandID)
+		if err != nil {
+			return report, err
+		}
+		for _, l := range logs {
+			if l.Signer == nil && len(l.FallbackKeyID) == 0 && signing[l.Make+"/"+l.Model] {
+				report.Devices = append(report.Devices, newDevice(l, false))
+			}
+		}
+	}
+
+	sort.SliceStable(report.Devices, func(i, j int) bool {
+		a, b := report.Devices[i], report.Devices[j]
+		if a.BrandID != b.BrandID {
+			return a.BrandID < b.BrandID
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		if a.SerialNumber != b.SerialNumber {
+			return a.SerialNumber < b.SerialNumber
+		}
+		return a.Revision < b.Revision
+	})
+	return report, nil
+}
+
+// modelRoles returns the roles of the signing-key in the model
+func modelRoles(db datastore.Datastore, m datastore.Model, keypairID int) ([]string, error) {
+	roles := []string{}
+	if m.KeypairID == keypairID {
+		roles = append(roles, RoleSigning)
+	}
+	if m.KeypairIDUser == keypairID {
+		roles = append(roles, RoleSystemUser)
+	}
+
+	fallbacks, err := db.ListModelFallbackKeypairs(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range fallbacks {
+		if k.ID == keypairID {
+			roles = append(roles, RoleFallback)
+		}
+	}
+
+	canary, err := db.GetModelCanary(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	if canary.KeypairID == keypairID {
+		roles = append(roles, RoleCanary)
+	}
+	return roles, nil
+}
+
+func newDevice(l datastore.SigningLog, attributed bool) Device {
+	return Device{
+		BrandID:      l.Make,
+		Model:        l.Model,
+		SerialNumber: l.SerialNumber,
+		DeviceKey:    l.Fingerprint,
+		Revision:     l.Revision,
+		Signed:       l.Created,
+		Attributed:   attributed,
+	}
+}
+
+// WriteCSV writes the affected devices of the report as CSV, for the brand and the store
+func (report Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"brand-id", "model", "serial", "device-key", "revision", "signed", "fallback", "attributed"}); err != nil {
+		return err
+	}
+	for _, d := range report.Devices {
+		record := []string{d.BrandID, d.Model, d.SerialNumber, d.DeviceKey, strconv.Itoa(d.Revision), d.Signed.Format(time.RFC3339), strconv.FormatBool(d.Fallback), strconv.FormatBool(d.Attributed)}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,167 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package compromise_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/compromise"
+	"github.com/CanonicalLtd/serial-vault/service/keyusage"
+	check "gopkg.in/check.v1"
+)
+
+func TestCompromiseSuite(t *testing.T) { check.TestingT(t) }
+
+type CompromiseSuite struct {
+	db      *datastoretest.DB
+	keypair datastore.Keypair
+	other   datastore.Keypair
+	alerts  []keyusage.Alert
+	notify  func(string, keyusage.Alert)
+}
+
+var _ = check.Suite(&CompromiseSuite{})
+
+var superuser = datastore.User{Role: datastore.Superuser, Username: "sv"}
+
+func (s *CompromiseSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	datastore.Environ = &datastore.Env{DB: s.db}
+
+	s.keypair = s.db.AddKeypair(datastoretest.NewKeypair("system", "compromised-key").Build())
+	s.other = s.db.AddKeypair(datastoretest.NewKeypair("system", "other-key").Build())
+
+	s.db.AddModel(datastoretest.NewModel("system", "alder").WithKeypair(s.keypair).Build())
+	s.db.AddModel(datastoretest.NewModel("system", "ash").WithKeypair(s.other).WithUserKeypair(s.keypair).Build())
+	birch := s.db.AddModel(datastoretest.NewModel("system", "birch").WithKeypair(s.other).Build())
+	c.Assert(s.db.UpdateAllowedModelFallbackKeypairs(birch.ID, []int{s.keypair.ID}, superuser), check.IsNil)
+
+	// Signed by the key, as the signer or a fallback, and from before the signer was recorded
+	signed := datastoretest.NewSigningLog("system", "alder", "A1").Build()
+	signed.Signer = &datastore.SigningAudit{KeypairID: s.keypair.ID, KeyID: s.keypair.KeyID}
+	s.db.AddSigningLog(signed)
+	fallback := datastoretest.NewSigningLog("system", "birch", "B1").Build()
+	fallback.Signer = &datastore.SigningAudit{KeypairID: s.keypair.ID, KeyID: s.keypair.KeyID}
+	fallback.FallbackKeyID = s.keypair.KeyID
+	s.db.AddSigningLog(fallback)
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A0").Build())
+
+	// Not signed by the key
+	unrelated := datastoretest.NewSigningLog("system", "birch", "B2").Build()
+	unrelated.Signer = &datastore.SigningAudit{KeypairID: s.other.ID, KeyID: s.other.KeyID}
+	s.db.AddSigningLog(unrelated)
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "birch", "B0").Build())
+
+	s.alerts = nil
+	s.notify = keyusage.Notify
+	keyusage.Notify = func(url string, alert keyusage.Alert) {
+		s.alerts = append(s.alerts, alert)
+	}
+}
+
+func (s *CompromiseSuite) TearDownTest(c *check.C) {
+	keyusage.Notify = s.notify
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *CompromiseSuite) TestCompromise(c *check.C) {
+	settings := config.Settings{KeyUsageAlerts: []config.KeyUsageAlert{
+		{KeyID: s.keypair.KeyID, NotifyURL: "https://hooks.example.com/compromised"},
+		{KeyID: s.other.KeyID, NotifyURL: "https://hooks.example.com/other"},
+	}}
+	before := metrics.Value(metrics.KeypairsCompromised)
+
+	report, err := compromise.Compromise(s.db, settings, s.keypair.ID, " stolen HSM credentials ", superuser)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Compromise.KeyID, check.Equals, s.keypair.KeyID)
+	c.Assert(report.Compromise.Reason, check.Equals, "stolen HSM credentials")
+	c.Assert(report.Compromise.ReportedBy, check.Equals, "sv")
+	c.Assert(metrics.Value(metrics.KeypairsCompromised)-before, check.Equals, int64(1))
+
+	// The signing-key is deactivated and cannot be enabled again
+	k, err := s.db.GetKeypair(s.keypair.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(k.Active, check.Equals, false)
+	c.Assert(s.db.UpdateAllowedKeypairActive(s.keypair.ID, true, superuser), check.Equals, datastore.ErrKeypairCompromised)
+
+	// The models that use the signing-key, in any role
+	roles := []string{}
+	for _, m := range report.Models {
+		roles = append(roles, m.Model+":"+m.Role)
+	}
+	c.Assert(roles, check.DeepEquals, []string{"alder:signing", "alder:system-user", "ash:system-user", "birch:fallback"})
+
+	// The devices that the signing-key signed
+	devices := []string{}
+	for _, d := range report.Devices {
+		devices = append(devices, d.SerialNumber)
+	}
+	c.Assert(devices, check.DeepEquals, []string{"A0", "A1", "B1"})
+	c.Assert(report.Devices[0].Attributed, check.Equals, false)
+	c.Assert(report.Devices[1].Attributed, check.Equals, true)
+	c.Assert(report.Devices[2].Fallback, check.Equals, true)
+
+	// The action is audited and notified to the hooks of the signing-key
+	entries, err := s.db.ListAuditLog("")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Brand, check.Equals, "system")
+	c.Assert(s.alerts, check.HasLen, 1)
+	c.Assert(s.alerts[0].Reason, check.Equals, keyusage.ReasonCompromised)
+	c.Assert(s.alerts[0].KeyID, check.Equals, s.keypair.KeyID)
+
+	// Compromising the signing-key again keeps the first record
+	again, err := compromise.Compromise(s.db, settings, s.keypair.ID, "again", superuser)
+	c.Assert(err, check.IsNil)
+	c.Assert(again.Compromise.ID, check.Equals, report.Compromise.ID)
+	c.Assert(again.Compromise.Reason, check.Equals, "stolen HSM credentials")
+}
+
+func (s *CompromiseSuite) TestCompromiseNotAuthorized(c *check.C) {
+	admin := datastore.User{Role: datastore.Admin, Username: "admin"}
+
+	_, err := compromise.Compromise(s.db, config.Settings{}, s.keypair.ID, "stolen", admin)
+	c.Assert(err, check.ErrorMatches, "You do not have permissions for that authority")
+
+	k, err := s.db.GetKeypair(s.keypair.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(k.Active, check.Equals, true)
+	c.Assert(s.alerts, check.HasLen, 0)
+}
+
+func (s *CompromiseSuite) TestWriteCSV(c *check.C) {
+	report, err := compromise.Compromise(s.db, config.Settings{}, s.keypair.ID, "stolen", superuser)
+	c.Assert(err, check.IsNil)
+
+	buf := &bytes.Buffer{}
+	c.Assert(report.WriteCSV(buf), check.IsNil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(lines, check.HasLen, 4)
+	c.Assert(lines[0], check.Equals, "brand-id,model,serial,device-key,revision,signed,fallback,attributed")
+	c.Assert(strings.HasPrefix(lines[3], "system,birch,B1,"), check.Equals, true)
+	c.Assert(strings.HasSuffix(lines[3], ",true,true"), check.Equals, true)
+}
@@ -26,11 +26,19 @@ import (
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/backpressure"
+	"github.com/CanonicalLtd/serial-vault/service/breaker"
+	"github.com/CanonicalLtd/serial-vault/service/failover"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/csrf"
 )
 
+// Service holds the dependencies of the core handlers
+type Service struct {
+	*datastore.Env
+}
+
 // VersionResponse is the JSON response from the API Version method
 type VersionResponse struct {
 	Version string `json:"version"`
@@ -41,16 +49,24 @@ type HealthResponse struct {
 	Database string `json:"database"`
 }
 
+// ReadyResponse is the JSON response from the readiness check method
+type ReadyResponse struct {
+	Ready    bool   `json:"ready"`
+	Database string `json:"database"`
+	Breaker  string `json:"breaker"`
+	Failover string `json:"failover,omitempty"`
+}
+
 // TokenResponse is the JSON response from the API Version method
 type TokenResponse struct {
 	EnableUserAuth bool `json:"enableUserAuth"`
 }
 
 // Version is the API method to return the version of the service
-func Version(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Version(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", response.JSONHeader)
 
-	response := VersionResponse{Version: datastore.Environ.Config.Version}
+	response := VersionResponse{Version: srv.Config.Version}
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -60,9 +76,9 @@ func Version(w http.ResponseWriter, r *http.Request) {
 }
 
 // Health is the API method to return if the app is up and db.Ping() doesn't return an error
-func Health(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", response.JSONHeader)
-	err := datastore.Environ.DB.HealthCheck()
+	err := srv.DB.HealthCheck()
 	var database string
 
 	if err != nil {
@@ -78,17 +94,62 @@ func Health(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Ready is the API method to return if the service is ready to sign. It is not ready
+// when the circuit breaker of the datastore is open, the database cannot be reached, or
+// it is the standby vault of a failover pair
+func (srv *Service) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+
+	resp := ReadyResponse{Ready: true, Database: "healthy", Breaker: breaker.Datastore.State()}
+	if resp.Breaker != breaker.Closed {
+		resp.Ready = false
+	}
+	if err := srv.DB.HealthCheck(); err != nil {
+		resp.Ready = false
+		resp.Database = err.Error()
+	}
+	if status := failover.Signing.Status(); status.Enabled {
+		resp.Failover = status.Role
+		resp.Ready = resp.Ready && status.Role == failover.Active
+	}
+
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		message := fmt.Sprintf("Error encoding the ready response: %v", err)
+		log.Message("READY", "ready", message)
+	}
+}
+
+// Queue is the API method to return the depth and the utilization of the signing queue, so the
+// clients can back off before their requests are rejected. It is unavailable when the queue is
+// above its watermark
+func (srv *Service) Queue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	backpressure.Signing.SetHeaders(w)
+
+	resp := backpressure.Signing.Status()
+	if !resp.Accepting {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		message := fmt.Sprintf("Error encoding the queue response: %v", err)
+		log.Message("QUEUE", "queue", message)
+	}
+}
+
 // Token returns CSRF protection new token in a X-CSRF-Token response header
 // This method is also used by the /authtoken endpoint to return the JWT. The method
 // indicates to the UI whether OpenID user auth is enabled
-func Token(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Token(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", response.JSONHeader)
 	w.Header().Set("X-CSRF-Token", csrf.Token(r))
 
 	// Check the JWT and return it in the authorization header, if valid
-	auth.JWTCheck(w, r)
+	auth.JWTCheck(w, r, srv.Config)
 
-	response := TokenResponse{EnableUserAuth: datastore.Environ.Config.EnableUserAuth}
+	response := TokenResponse{EnableUserAuth: srv.Config.EnableUserAuth}
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
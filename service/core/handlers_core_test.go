@@ -21,6 +21,7 @@ package core_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -30,7 +31,10 @@ import (
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/backpressure"
+	"github.com/CanonicalLtd/serial-vault/service/breaker"
 	"github.com/CanonicalLtd/serial-vault/service/core"
+	"github.com/CanonicalLtd/serial-vault/service/failover"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	check "gopkg.in/check.v1"
 )
@@ -54,7 +58,7 @@ var _ = check.Suite(&CoreSuite{})
 
 func (s *CoreSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 }
@@ -63,7 +67,7 @@ func sendRequest(method, url string, data io.Reader, c *check.C) *httptest.Respo
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
 
-	service.SigningRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -72,7 +76,7 @@ func sendAdminRequest(method, url string, data io.Reader, c *check.C) *httptest.
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -139,3 +143,93 @@ func (s *CoreSuite) TestHealthHandler(c *check.C) {
 		datastore.Environ.DB = &datastore.MockDB{}
 	}
 }
+
+func (s *CoreSuite) TestReadyHandler(c *check.C) {
+	tests := []struct {
+		MockError bool
+		Trip      bool
+		Code      int
+		Ready     bool
+		Breaker   string
+	}{
+		{false, false, 200, true, breaker.Closed},
+		{true, false, 503, false, breaker.Closed},
+		{false, true, 503, false, breaker.Open},
+	}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+		if t.Trip {
+			for i := 0; i < breaker.DefaultFailures; i++ {
+				breaker.Datastore.Failure()
+			}
+		}
+
+		w := sendRequest("GET", "/readyz", nil, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, response.JSONHeader)
+
+		result := core.ReadyResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Ready, check.Equals, t.Ready)
+		c.Assert(result.Breaker, check.Equals, t.Breaker)
+
+		breaker.Datastore.Success()
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *CoreSuite) TestQueueHandler(c *check.C) {
+	datastore.Environ.Config.SigningQueueCapacity = 2
+	datastore.Environ.Config.SigningQueueWatermark = 50
+	defer func() {
+		datastore.Environ.Config.SigningQueueCapacity = 0
+		datastore.Environ.Config.SigningQueueWatermark = 0
+	}()
+
+	w := sendRequest("GET", "/v1/queue", nil, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get(backpressure.DepthHeader), check.Equals, "0")
+	result := backpressure.Status{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result, check.DeepEquals, backpressure.Status{Capacity: 2, Watermark: 50, Accepting: true})
+
+	// The queue is at its watermark, so it does not accept more signing requests
+	release, ok, _ := backpressure.Signing.Enter()
+	c.Assert(ok, check.Equals, true)
+	defer release()
+
+	w = sendRequest("GET", "/v1/queue", nil, c)
+	c.Assert(w.Code, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(w.Header().Get(backpressure.UtilizationHeader), check.Equals, "50%")
+	result = backpressure.Status{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result, check.DeepEquals, backpressure.Status{Depth: 1, Capacity: 2, Utilization: 50, Watermark: 50})
+}
+
+func (s *CoreSuite) TestReadyHandlerFailover(c *check.C) {
+	datastore.Environ.Config.Failover = true
+	defer func() { datastore.Environ.Config.Failover = false }()
+
+	// The standby vault is not ready to sign
+	w := sendRequest("GET", "/readyz", nil, c)
+	c.Assert(w.Code, check.Equals, http.StatusServiceUnavailable)
+	result := core.ReadyResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result.Ready, check.Equals, false)
+	c.Assert(result.Failover, check.Equals, failover.Standby)
+
+	_, err := failover.Signing.Elect(context.Background(), datastore.Environ.DB)
+	c.Assert(err, check.IsNil)
+	defer failover.Signing.Resign()
+
+	w = sendRequest("GET", "/readyz", nil, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	result = core.ReadyResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result.Ready, check.Equals, true)
+	c.Assert(result.Failover, check.Equals, failover.Active)
+}
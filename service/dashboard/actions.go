@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// KeypairSummary holds the status of a signing-key and the expiry from its account-key assertion
+type KeypairSummary struct {
+	ID          int        `json:"id"`
+	AuthorityID string     `json:"authority-id"`
+	KeyID       string     `json:"key-id"`
+	KeyName     string     `json:"key-name"`
+	Active      bool       `json:"active"`
+	Until       *time.Time `json:"until,omitempty"`
+}
+
+// Response is the JSON response from the API dashboard method
+type Response struct {
+	Success      bool                `json:"success"`
+	ErrorCode    string              `json:"error_code"`
+	ErrorSubcode string              `json:"error_subcode"`
+	ErrorMessage string              `json:"message"`
+	Dashboard    datastore.Dashboard `json:"dashboard"`
+	Keypairs     []KeypairSummary    `json:"keypairs"`
+	Failures     []log.Failure       `json:"failures"`
+}
+
+// summaryHandler is the API method to fetch the dashboard summary
+func (srv *Service) summaryHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	dashboard, err := srv.DB.AllowedDashboard(user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchDashboard.Code, "", err.Error(), w)
+		return
+	}
+
+	keypairs, err := srv.DB.ListAllowedKeypairs(user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypairs.Code, "", err.Error(), w)
+		return
+	}
+
+	summary := []KeypairSummary{}
+	for _, k := range keypairs {
+		summary = append(summary, KeypairSummary{
+			ID:          k.ID,
+			AuthorityID: k.AuthorityID,
+			KeyID:       k.KeyID,
+			KeyName:     k.KeyName,
+			Active:      k.Active,
+			Until:       keypairExpiry(k),
+		})
+	}
+
+	// The recent failures are not recorded with their account, so they are only returned to
+	// the superusers
+	failures := []log.Failure{}
+	if user.Role == datastore.Invalid || user.Role == datastore.Superuser {
+		failures = log.RecentFailures()
+	}
+
+	// Return successful JSON response with the summary
+	w.WriteHeader(http.StatusOK)
+	formatResponse(dashboard, summary, failures, w)
+}
+
+// keypairExpiry returns the 'until' date of the account-key assertion, if there is one
+func keypairExpiry(keypair datastore.Keypair) *time.Time {
+	if len(keypair.Assertion) == 0 {
+		return nil
+	}
+
+	assertion, err := asserts.Decode([]byte(keypair.Assertion))
+	if err != nil || assertion.Type().Name != asserts.AccountKeyType.Name {
+		return nil
+	}
+
+	until, err := time.Parse(time.RFC3339, assertion.HeaderString("until"))
+	if err != nil {
+		return nil
+	}
+	return &until
+}
+
+func formatResponse(dashboard datastore.Dashboard, keypairs []KeypairSummary, failures []log.Failure, w http.ResponseWriter) error {
+	response := Response{Success: true, Dashboard: dashboard, Keypairs: keypairs, Failures: failures}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the dashboard response: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dashboard_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/dashboard"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	check "gopkg.in/check.v1"
+)
+
+func TestDashboardSuite(t *testing.T) { check.TestingT(t) }
+
+type DashboardSuite struct{}
+
+var _ = check.Suite(&DashboardSuite{})
+
+type DashboardTest struct {
+	Method      string
+	URL         string
+	Code        int
+	Type        string
+	Permissions int
+	EnableAuth  bool
+	Success     bool
+	Signings    int
+	Keypairs    int
+}
+
+func (s *DashboardSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
+}
+
+func (s *DashboardSuite) TestAPISummaryHandler(c *check.C) {
+	tests := []DashboardTest{
+		{"GET", "/api/dashboard", 400, "application/json; charset=UTF-8", 0, false, false, 0, 0},
+		{"GET", "/api/dashboard", 200, "application/json; charset=UTF-8", datastore.Admin, false, true, 2, 2},
+		{"GET", "/api/dashboard", 400, "application/json; charset=UTF-8", datastore.SyncUser, true, false, 0, 0},
+		{"GET", "/api/dashboard", 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0, 0},
+		{"GET", "/api/dashboard", 400, "application/json; charset=UTF-8", 0, true, false, 0, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Dashboard.Signings), check.Equals, t.Signings)
+		c.Assert(len(result.Keypairs), check.Equals, t.Keypairs)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *DashboardSuite) TestSummaryHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminAPIRequest("GET", "/v1/dashboard", 0)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result, err := parseResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "fetch-dashboard")
+}
+
+func (s *DashboardSuite) TestAPISummaryFailures(c *check.C) {
+	log.Message("SIGN", "invalid-nonce", "Nonce is invalid or expired")
+
+	// The failures of all the accounts are returned to the superusers
+	w := sendAdminAPIRequest("GET", "/api/dashboard", datastore.Superuser)
+	c.Assert(w.Code, check.Equals, 200)
+
+	result, err := parseResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(len(result.Failures) > 0, check.Equals, true)
+	c.Assert(result.Failures[0].Code, check.Equals, "invalid-nonce")
+
+	// The admins only see the summary of their own accounts, without the failures
+	w = sendAdminAPIRequest("GET", "/api/dashboard", datastore.Admin)
+	c.Assert(w.Code, check.Equals, 200)
+
+	result, err = parseResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Failures, check.HasLen, 0)
+}
+
+func sendAdminAPIRequest(method, url string, permissions int) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, nil)
+
+	switch permissions {
+	case datastore.Admin:
+		r.Header.Set("user", "sv")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.Superuser:
+		r.Header.Set("user", "root")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.SyncUser:
+		r.Header.Set("user", "sync")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.Standard:
+		r.Header.Set("user", "user1")
+		r.Header.Set("api-key", "ValidAPIKey")
+	default:
+		break
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func parseResponse(w *httptest.ResponseRecorder) (dashboard.Response, error) {
+	// Check the JSON response
+	result := dashboard.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// DebugHeader is the request header that captures the statements that are run on the datastore
+// for the request, with the plans of the queries. Its value is the username and API key of a
+// superuser: "username:api-key"
+const DebugHeader = "X-Serial-Vault-Debug"
+
+// DebugEnvelope is the response of a debugged request: the response of the handler, with the
+// statements that were run on the datastore
+type DebugEnvelope struct {
+	Status    int                   `json:"status"`
+	Header    http.Header           `json:"header"`
+	Body      json.RawMessage       `json:"body"`
+	Datastore *datastore.QueryTrace `json:"datastore"`
+}
+
+// debugged captures the statements that are run on the datastore for the request of a
+// superuser with the debug header, returning them in the debug envelope
+func (srv *Service) debugged(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credentials := r.Header.Get(DebugHeader)
+		if len(credentials) == 0 {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		if !srv.debugAllowed(credentials) {
+			svlog.Message("DEBUG", response.ErrorDebugNotAuthorized.Code, r.Method+" "+r.URL.Path)
+			w.Header().Set("Content-Type", response.JSONHeader)
+			w.WriteHeader(response.ErrorDebugNotAuthorized.StatusCode)
+			json.NewEncoder(w).Encode(response.ErrorDebugNotAuthorized)
+			return
+		}
+		svlog.Message("DEBUG", "datastore-debug", r.Method+" "+r.URL.Path)
+
+		// The credentials are not passed to the handler, and the response is not compressed so
+		// it can be wrapped in the envelope
+		r.Header.Del(DebugHeader)
+		r.Header.Del("Accept-Encoding")
+
+		ctx, trace := datastore.WithQueryTrace(r.Context())
+		bw := &bufferWriter{header: http.Header{}}
+		inner.ServeHTTP(bw, r.WithContext(ctx))
+
+		envelope := DebugEnvelope{Status: bw.Status(), Header: bw.header, Body: debugBody(bw.body.Bytes()), Datastore: trace}
+		w.Header().Set("Content-Type", response.JSONHeader)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(envelope); err != nil {
+			svlog.Errorf("Error encoding the debug envelope: %v", err)
+		}
+	})
+}
+
+// debugAllowed checks that the credentials of the debug header are of a superuser
+func (srv *Service) debugAllowed(credentials string) bool {
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return false
+	}
+
+	user, err := srv.Env.DB.GetUserByAPIKey(parts[1], parts[0])
+	if err != nil {
+		return false
+	}
+	return user.Role >= datastore.Superuser
+}
+
+// debugBody returns the body of the response for the envelope: the JSON of a JSON response, or
+// a string otherwise
+func debugBody(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return json.RawMessage(bytes.TrimSpace(body))
+	}
+	b, _ := json.Marshal(string(body))
+	return json.RawMessage(b)
+}
+
+// bufferWriter holds the response of the handler
+type bufferWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (bw *bufferWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferWriter) WriteHeader(code int) {
+	if bw.status == 0 {
+		bw.status = code
+	}
+}
+
+func (bw *bufferWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
+
+// Status returns the status code of the response, which is OK when it is not written
+func (bw *bufferWriter) Status() int {
+	if bw.status == 0 {
+		return http.StatusOK
+	}
+	return bw.status
+}
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/core"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+type DebugSuite struct {
+	srv *Service
+}
+
+var _ = check.Suite(&DebugSuite{})
+
+func (s *DebugSuite) SetUpTest(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "root", APIKey: "RootAPIKey", Role: datastore.Superuser})
+	db.AddUser(datastore.User{Username: "admin", APIKey: "AdminAPIKey", Role: datastore.Admin})
+	s.srv = NewService(&datastore.Env{DB: db, Config: config.Settings{Version: "1.0"}})
+}
+
+func (s *DebugSuite) send(debug string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/version", nil)
+	if len(debug) > 0 {
+		r.Header.Set(DebugHeader, debug)
+	}
+	s.srv.SigningRouter().ServeHTTP(w, r)
+	return w
+}
+
+func (s *DebugSuite) TestDebugEnvelope(c *check.C) {
+	w := s.send("root:RootAPIKey")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	envelope := DebugEnvelope{}
+	err := json.NewDecoder(w.Body).Decode(&envelope)
+	c.Assert(err, check.IsNil)
+	c.Assert(envelope.Status, check.Equals, http.StatusOK)
+	c.Assert(envelope.Datastore, check.NotNil)
+	c.Assert(envelope.Datastore.Queries, check.HasLen, 0)
+
+	// The envelope holds the response of the handler
+	result := core.VersionResponse{}
+	err = json.Unmarshal(envelope.Body, &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Version, check.Equals, "1.0")
+}
+
+func (s *DebugSuite) TestDebugNotAuthorized(c *check.C) {
+	for _, debug := range []string{"admin:AdminAPIKey", "root:InvalidAPIKey", "RootAPIKey", "root:"} {
+		w := s.send(debug)
+		c.Assert(w.Code, check.Equals, http.StatusForbidden)
+
+		result := response.ErrorResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Code, check.Equals, response.ErrorDebugNotAuthorized.Code)
+	}
+}
+
+func (s *DebugSuite) TestNotDebugged(c *check.C) {
+	w := s.send("")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := core.VersionResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Version, check.Equals, "1.0")
+}
+
+func (s *DebugSuite) TestDebugBody(c *check.C) {
+	c.Assert(string(debugBody([]byte("{\"success\": true}\n"))), check.Equals, `{"success": true}`)
+	c.Assert(string(debugBody([]byte("type: serial\n"))), check.Equals, `"type: serial\n"`)
+	c.Assert(string(debugBody(nil)), check.Equals, `""`)
+}
@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/revocation"
+)
+
+// StateResponse is the JSON response from the API methods for the lifecycle state of a device
+type StateResponse struct {
+	Success      bool                    `json:"success"`
+	ErrorCode    string                  `json:"error_code"`
+	ErrorSubcode string                  `json:"error_subcode"`
+	ErrorMessage string                  `json:"message"`
+	State        string                  `json:"state"` // empty when no state has been recorded
+	History      []datastore.DeviceState `json:"history"`
+
+	// Quarantine is the entry of the quarantine list of a quarantined device, which has the
+	// quarantined state
+	Quarantine *datastore.DeviceQuarantine `json:"quarantine,omitempty"`
+}
+
+// stateHandler is the API method to fetch the lifecycle state of a device, with its history
+func (srv *Service) stateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, brandID, model, serialNumber string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	history, err := srv.DB.ListAllowedDeviceStateHistory(user, brandID, model, serialNumber)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-devicestate", "", err.Error(), w)
+		return
+	}
+
+	quarantine, err := srv.deviceQuarantine(user, brandID, serialNumber)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-devicestate", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the state of the device
+	w.WriteHeader(http.StatusOK)
+	formatStateResponse(true, "", "", "", history, quarantine, w)
+}
+
+// transitionHandler is the API method to move a signed device to a new lifecycle state
+func (srv *Service) transitionHandler(w http.ResponseWriter, user datastore.User, apiCall bool, brandID, model, serialNumber string, state datastore.DeviceState) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	state.Brand = brandID
+	state.Model = model
+	state.SerialNumber = serialNumber
+
+	state, err = srv.DB.TransitionAllowedDeviceState(state, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-transition-devicestate", "", err.Error(), w)
+		return
+	}
+	log.Printf("Device %s/%s/%s moved to %s by %s\n", brandID, model, serialNumber, state.State, user.Username)
+
+	// Publish the revocation when the device moved in or out of a revoking state
+	revocation.Issue(srv.DB, srv.Config, state)
+
+	history, err := srv.DB.ListAllowedDeviceStateHistory(user, brandID, model, serialNumber)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-devicestate", "", err.Error(), w)
+		return
+	}
+
+	quarantine, err := srv.deviceQuarantine(user, brandID, serialNumber)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-devicestate", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the new state of the device
+	w.WriteHeader(http.StatusOK)
+	formatStateResponse(true, "", "", "", history, quarantine, w)
+}
+
+// deviceQuarantine returns the quarantine of the device, or nil when the device is not
+// quarantined or the brand is not visible to the user
+func (srv *Service) deviceQuarantine(user datastore.User, brandID, serialNumber string) (*datastore.DeviceQuarantine, error) {
+	if _, err := srv.DB.GetAllowedAccount(brandID, user); err != nil {
+		return nil, nil
+	}
+
+	quarantine, err := srv.DB.CheckDeviceQuarantine(brandID, serialNumber, "")
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quarantine, nil
+}
+
+func formatStateResponse(success bool, errorCode, errorSubcode, message string, history []datastore.DeviceState, quarantine *datastore.DeviceQuarantine, w http.ResponseWriter) error {
+	response := StateResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, History: history, Quarantine: quarantine}
+	if len(history) > 0 {
+		response.State = history[len(history)-1].State
+	}
+	if quarantine != nil {
+		response.State = datastore.DeviceStateQuarantined
+	}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the device state response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// QuarantineRequest is the JSON version of the devices that are added to the quarantine list
+type QuarantineRequest struct {
+	Devices []datastore.DeviceQuarantine `json:"devices"`
+}
+
+// QuarantineResponse is the JSON response from the API methods for the quarantine list
+type QuarantineResponse struct {
+	Success      bool                             `json:"success"`
+	ErrorCode    string                           `json:"error_code"`
+	ErrorSubcode string                           `json:"error_subcode"`
+	ErrorMessage string                           `json:"message"`
+	Result       datastore.DeviceQuarantineResult `json:"result"`
+	Devices      []datastore.DeviceQuarantine     `json:"devices"`
+}
+
+// quarantineListHandler is the API method to fetch the quarantined devices of a brand, or of
+// all the brands of the user when the brand is empty e.g. for the factory sync
+func (srv *Service) quarantineListHandler(w http.ResponseWriter, user datastore.User, apiCall bool, brandID string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	devices, err := srv.DB.ListAllowedDeviceQuarantine(user, brandID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-quarantine", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the quarantined devices
+	w.WriteHeader(http.StatusOK)
+	formatQuarantineResponse(datastore.DeviceQuarantineResult{}, devices, w)
+}
+
+// quarantineHandler is the API method to add devices to the quarantine list of a brand
+func (srv *Service) quarantineHandler(w http.ResponseWriter, user datastore.User, apiCall bool, brandID string, devices []datastore.DeviceQuarantine, source string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	result, err := srv.DB.QuarantineAllowedDevices(brandID, devices, source, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-quarantine", "", err.Error(), w)
+		return
+	}
+	log.Printf("Quarantined %d devices of %s (%s) by %s\n", result.Quarantined, brandID, source, user.Username)
+
+	// Return successful JSON response with the summary of the quarantined devices
+	w.WriteHeader(http.StatusOK)
+	formatQuarantineResponse(result, []datastore.DeviceQuarantine{}, w)
+}
+
+// quarantineImportHandler is the API method to add the devices of a CSV file to the quarantine
+// list of a brand
+func (srv *Service) quarantineImportHandler(w http.ResponseWriter, user datastore.User, apiCall bool, brandID string, body io.Reader) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	devices, err := datastore.ParseDeviceQuarantineCSV(body)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		response.FormatStandardResponse(false, "error-quarantine-csv", "", err.Error(), w)
+		return
+	}
+
+	srv.quarantineHandler(w, user, apiCall, brandID, devices, datastore.QuarantineSourceImport)
+}
+
+// releaseHandler is the API method to remove a device from the quarantine list of a brand
+func (srv *Service) releaseHandler(w http.ResponseWriter, user datastore.User, apiCall bool, brandID string, quarantineID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = srv.DB.DeleteAllowedDeviceQuarantine(brandID, quarantineID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-release-quarantine", "", err.Error(), w)
+		return
+	}
+	log.Printf("Released the quarantined device %d of %s by %s\n", quarantineID, brandID, user.Username)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// decodeQuarantine decodes the devices that are added to the quarantine list from the request body
+func decodeQuarantine(w http.ResponseWriter, r *http.Request) ([]datastore.DeviceQuarantine, bool) {
+	defer r.Body.Close()
+
+	req := QuarantineRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-quarantine-data", "", "No quarantined devices supplied", w)
+		return nil, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return nil, false
+	}
+	return req.Devices, true
+}
+
+func formatQuarantineResponse(result datastore.DeviceQuarantineResult, devices []datastore.DeviceQuarantine, w http.ResponseWriter) error {
+	response := QuarantineResponse{Success: true, Result: result, Devices: devices}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the quarantine response.")
+		return err
+	}
+	return nil
+}
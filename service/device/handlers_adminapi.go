@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// APIState is the API method to fetch the lifecycle state of a device, with its history
+func (srv *Service) APIState(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.stateHandler(w, user, true, vars["authorityID"], vars["model"], vars["serial"])
+}
+
+// APITransition is the API method to move a signed device to a new lifecycle state
+func (srv *Service) APITransition(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	state, ok := decodeState(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.transitionHandler(w, user, true, vars["authorityID"], vars["model"], vars["serial"], state)
+}
+
+// APIQuarantine is the API method to fetch the quarantined devices of a brand, or of all the
+// brands of the user for the factory sync
+func (srv *Service) APIQuarantine(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.quarantineListHandler(w, user, true, vars["authorityID"])
+}
+
+// APIQuarantineDevices is the API method to add devices to the quarantine list of a brand
+func (srv *Service) APIQuarantineDevices(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	devices, ok := decodeQuarantine(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.quarantineHandler(w, user, true, vars["authorityID"], devices, datastore.QuarantineSourceManual)
+}
+
+// APIQuarantineImport is the API method to add the devices of a CSV file to the quarantine list
+func (srv *Service) APIQuarantineImport(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+
+	srv.quarantineImportHandler(w, user, true, vars["authorityID"], r.Body)
+}
+
+// APIQuarantineRelease is the API method to remove a device from the quarantine list
+func (srv *Service) APIQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	quarantineID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-quarantine", "", err.Error(), w)
+		return
+	}
+
+	srv.releaseHandler(w, user, true, vars["authorityID"], quarantineID)
+}
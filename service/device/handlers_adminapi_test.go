@@ -0,0 +1,235 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	check "gopkg.in/check.v1"
+)
+
+func TestDeviceSuite(t *testing.T) { check.TestingT(t) }
+
+type DeviceSuite struct {
+	db *datastoretest.DB
+}
+
+var _ = check.Suite(&DeviceSuite{})
+
+func (s *DeviceSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	other := s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "otheradmin", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{other}})
+	s.db.AddUser(datastore.User{Username: "user1", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+}
+
+func (s *DeviceSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *DeviceSuite) sendRequest(c *check.C, method, url string, data []byte, username string) device.StateResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, bytes.NewReader(data))
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := device.StateResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *DeviceSuite) TestTransition(c *check.C) {
+	result := s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.State, check.Equals, "")
+	c.Assert(result.History, check.HasLen, 0)
+
+	result = s.sendRequest(c, "POST", "/api/devices/system/alder/A1/state", []byte(`{"state": "shipped"}`), "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.State, check.Equals, datastore.DeviceStateShipped)
+
+	result = s.sendRequest(c, "POST", "/api/devices/system/alder/A1/state", []byte(`{"state": "rma", "note": "Faulty modem"}`), "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.State, check.Equals, datastore.DeviceStateRMA)
+
+	result = s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.State, check.Equals, datastore.DeviceStateRMA)
+	c.Assert(result.History, check.HasLen, 2)
+	c.Assert(result.History[1].PreviousState, check.Equals, datastore.DeviceStateShipped)
+	c.Assert(result.History[1].Note, check.Equals, "Faulty modem")
+	c.Assert(result.History[1].CreatedBy, check.Equals, "sv")
+}
+
+func (s *DeviceSuite) TestTransitionInvalid(c *check.C) {
+	s.sendRequest(c, "POST", "/api/devices/system/alder/A1/state", []byte(`{"state": "scrapped"}`), "sv")
+
+	tests := []struct {
+		URL       string
+		Username  string
+		Data      string
+		ErrorCode string
+	}{
+		{"/api/devices/system/alder/A1/state", "", `{"state": "rma"}`, "error-auth"},
+		{"/api/devices/system/alder/A1/state", "user1", `{"state": "rma"}`, "error-auth"},
+		{"/api/devices/system/alder/A1/state", "sv", "", "error-devicestate-data"},
+		{"/api/devices/system/alder/A1/state", "sv", "{invalid", "error-decode-json"},
+		{"/api/devices/system/alder/A1/state", "otheradmin", `{"state": "rma"}`, "error-transition-devicestate"},
+		{"/api/devices/system/alder/A1/state", "sv", `{"state": "rma"}`, "error-transition-devicestate"},
+		{"/api/devices/system/alder/A2/state", "sv", `{"state": "shipped"}`, "error-transition-devicestate"},
+		{"/api/devices/system/alder/A2/state", "sv", `{"state": "invalid"}`, "error-transition-devicestate"},
+	}
+
+	for _, t := range tests {
+		result := s.sendRequest(c, "POST", t.URL, []byte(t.Data), t.Username)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+
+	result := s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "sv")
+	c.Assert(result.State, check.Equals, datastore.DeviceStateScrapped)
+	c.Assert(result.History, check.HasLen, 1)
+}
+
+func (s *DeviceSuite) sendQuarantineRequest(c *check.C, method, url string, data []byte, username string) device.QuarantineResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, bytes.NewReader(data))
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := device.QuarantineResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *DeviceSuite) TestQuarantine(c *check.C) {
+	result := s.sendQuarantineRequest(c, "POST", "/api/quarantine/system", []byte(`{"devices": [{"serial": "A1", "reason": "Recalled batch"}]}`), "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Result, check.Equals, datastore.DeviceQuarantineResult{Quarantined: 1})
+
+	// A device that is already quarantined is skipped
+	result = s.sendQuarantineRequest(c, "POST", "/api/quarantine/system", []byte(`{"devices": [{"serial": "A1"}]}`), "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Result, check.Equals, datastore.DeviceQuarantineResult{Duplicates: 1})
+
+	state := s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "sv")
+	c.Assert(state.Success, check.Equals, true)
+	c.Assert(state.State, check.Equals, datastore.DeviceStateQuarantined)
+	c.Assert(state.Quarantine, check.NotNil)
+	c.Assert(state.Quarantine.Reason, check.Equals, "Recalled batch")
+	c.Assert(state.Quarantine.Source, check.Equals, datastore.QuarantineSourceManual)
+
+	// The quarantine is not visible to the admins of other brands
+	state = s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "otheradmin")
+	c.Assert(state.State, check.Equals, "")
+	c.Assert(state.Quarantine, check.IsNil)
+
+	result = s.sendQuarantineRequest(c, "GET", "/api/quarantine/system", nil, "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Devices, check.HasLen, 1)
+	c.Assert(result.Devices[0].CreatedBy, check.Equals, "sv")
+	quarantineID := result.Devices[0].ID
+
+	result = s.sendQuarantineRequest(c, "GET", "/api/quarantine/system", nil, "otheradmin")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Devices, check.HasLen, 0)
+
+	result = s.sendQuarantineRequest(c, "DELETE", fmt.Sprintf("/api/quarantine/system/%d", quarantineID), nil, "sv")
+	c.Assert(result.Success, check.Equals, true)
+
+	state = s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "sv")
+	c.Assert(state.State, check.Equals, "")
+}
+
+func (s *DeviceSuite) TestQuarantineImport(c *check.C) {
+	csv := "serialnumber,fingerprint,reason\nB0001..B0003,,Faulty modem\n,fingerprint-A1,Compromised supplier\n"
+
+	result := s.sendQuarantineRequest(c, "POST", "/api/quarantine/system/import", []byte(csv), "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Result, check.Equals, datastore.DeviceQuarantineResult{Quarantined: 4})
+
+	result = s.sendQuarantineRequest(c, "GET", "/api/quarantine/system", nil, "sv")
+	c.Assert(result.Devices, check.HasLen, 4)
+	c.Assert(result.Devices[1].SerialNumber, check.Equals, "B0002")
+	c.Assert(result.Devices[1].Source, check.Equals, datastore.QuarantineSourceImport)
+
+	// The signed device is found by the device-key of its signing log
+	state := s.sendRequest(c, "GET", "/api/devices/system/alder/A1/state", nil, "sv")
+	c.Assert(state.State, check.Equals, datastore.DeviceStateQuarantined)
+	c.Assert(state.Quarantine.Fingerprint, check.Equals, "fingerprint-A1")
+}
+
+func (s *DeviceSuite) TestQuarantineInvalid(c *check.C) {
+	tests := []struct {
+		URL       string
+		Username  string
+		Data      string
+		ErrorCode string
+	}{
+		{"/api/quarantine/system", "", `{"devices": [{"serial": "A1"}]}`, "error-auth"},
+		{"/api/quarantine/system", "user1", `{"devices": [{"serial": "A1"}]}`, "error-auth"},
+		{"/api/quarantine/system", "sv", "", "error-quarantine-data"},
+		{"/api/quarantine/system", "sv", "{invalid", "error-decode-json"},
+		{"/api/quarantine/system", "otheradmin", `{"devices": [{"serial": "A1"}]}`, "error-quarantine"},
+		{"/api/quarantine/system", "sv", `{"devices": [{"reason": "No device"}]}`, "error-quarantine"},
+		{"/api/quarantine/system", "sv", `{"devices": [{"serial": "A1", "fingerprint": "fingerprint-A1"}]}`, "error-quarantine"},
+		{"/api/quarantine/system", "sv", `{"devices": [{"serial": "A*"}]}`, "error-quarantine"},
+		{"/api/quarantine/system/import", "sv", "", "error-quarantine-csv"},
+		{"/api/quarantine/system/import", "sv", "serial,reason\nA1,Recall\n", "error-quarantine-csv"},
+	}
+
+	for _, t := range tests {
+		result := s.sendQuarantineRequest(c, "POST", t.URL, []byte(t.Data), t.Username)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+
+	result := s.sendQuarantineRequest(c, "DELETE", "/api/quarantine/system/1000", nil, "sv")
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-release-quarantine")
+
+	result = s.sendQuarantineRequest(c, "GET", "/api/quarantine/system", nil, "sv")
+	c.Assert(result.Devices, check.HasLen, 0)
+}
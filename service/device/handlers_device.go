@@ -0,0 +1,150 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// Service holds the dependencies of the device lifecycle handlers
+type Service struct {
+	*datastore.Env
+}
+
+// State is the API method to fetch the lifecycle state of a device
+func (srv *Service) State(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.stateHandler(w, authUser, false, vars["authorityID"], vars["model"], vars["serial"])
+}
+
+// Transition is the API method to move a device to a new lifecycle state
+func (srv *Service) Transition(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	state, ok := decodeState(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.transitionHandler(w, authUser, false, vars["authorityID"], vars["model"], vars["serial"], state)
+}
+
+// Quarantine is the API method to fetch the quarantined devices of a brand
+func (srv *Service) Quarantine(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.quarantineListHandler(w, authUser, false, vars["authorityID"])
+}
+
+// QuarantineDevices is the API method to add devices to the quarantine list of a brand
+func (srv *Service) QuarantineDevices(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	devices, ok := decodeQuarantine(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.quarantineHandler(w, authUser, false, vars["authorityID"], devices, datastore.QuarantineSourceManual)
+}
+
+// QuarantineImport is the API method to add the devices of a CSV file to the quarantine list
+func (srv *Service) QuarantineImport(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+
+	srv.quarantineImportHandler(w, authUser, false, vars["authorityID"], r.Body)
+}
+
+// QuarantineRelease is the API method to remove a device from the quarantine list
+func (srv *Service) QuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	quarantineID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-quarantine", "", err.Error(), w)
+		return
+	}
+
+	srv.releaseHandler(w, authUser, false, vars["authorityID"], quarantineID)
+}
+
+// decodeState decodes the new lifecycle state of a device from the request body
+func decodeState(w http.ResponseWriter, r *http.Request) (datastore.DeviceState, bool) {
+	defer r.Body.Close()
+
+	state := datastore.DeviceState{}
+	err := json.NewDecoder(r.Body).Decode(&state)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-devicestate-data", "", "No device state data supplied", w)
+		return state, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return state, false
+	}
+	return state, true
+}
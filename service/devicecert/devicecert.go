@@ -0,0 +1,226 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package devicecert issues the X.509 device certificates of the signed devices from the device CA
+// of the vault, binding the serial number of a device to its device-key, and serves the certificate
+// revocation list and the status of the certificates
+package devicecert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/snapcore/snapd/asserts"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// defaultValidityDays is the validity of a device certificate, when it is not configured
+const defaultValidityDays = 3650
+
+// crlValidity is the time until the next update of the certificate revocation list
+const crlValidity = 24 * time.Hour
+
+// deviceKeyFormat is the prefix of an encoded device-key, which is followed by the
+// base64 encoding of the OpenPGP public key packet
+const deviceKeyFormat = "openpgp "
+
+// Statuses of a device certificate
+const (
+	StatusGood    = "good"
+	StatusRevoked = "revoked"
+	StatusUnknown = "unknown" // the certificate was not issued by the device CA
+)
+
+// Status is the status of a device certificate, a lightweight alternative to OCSP
+type Status struct {
+	CertificateSerial string     `json:"certificate-serial"`
+	Status            string     `json:"status"`
+	NotAfter          *time.Time `json:"not-after,omitempty"`
+	RevokedAt         *time.Time `json:"revoked-at,omitempty"`
+	Generated         time.Time  `json:"generated"`
+}
+
+// Issue issues the device certificate of a signed serial assertion from the device CA, and
+// stores it. The certificate is for the device-key of the serial assertion, with the brand, model
+// and serial number of the device in its subject
+func Issue(db datastore.Datastore, settings config.Settings, serial asserts.Assertion) (datastore.DeviceCertificate, error) {
+	cert, err := issue(db, settings, serial)
+	if err != nil {
+		metrics.Increment(metrics.CertificateErrors)
+		return cert, err
+	}
+	metrics.Increment(metrics.CertificatesIssued)
+	return cert, nil
+}
+
+func issue(db datastore.Datastore, settings config.Settings, serial asserts.Assertion) (datastore.DeviceCertificate, error) {
+	publicKey, err := devicePublicKey(serial.HeaderString("device-key"))
+	if err != nil {
+		return datastore.DeviceCertificate{}, err
+	}
+
+	caCertificate, caKey, err := datastore.DeviceCA(db, settings)
+	if err != nil {
+		return datastore.DeviceCertificate{}, err
+	}
+
+	certificateSerial, err := datastore.NewCertificateSerial()
+	if err != nil {
+		return datastore.DeviceCertificate{}, err
+	}
+
+	days := settings.DeviceCertificateDays
+	if days <= 0 {
+		days = defaultValidityDays
+	}
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: certificateSerial,
+		Subject: pkix.Name{
+			CommonName:         serial.HeaderString("serial"),
+			SerialNumber:       serial.HeaderString("serial"),
+			Organization:       []string{serial.HeaderString("brand-id")},
+			OrganizationalUnit: []string{serial.HeaderString("model")},
+		},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.AddDate(0, 0, days),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := publicKey.(*rsa.PublicKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if len(settings.DeviceCertificateURL) > 0 {
+		template.CRLDistributionPoints = []string{strings.TrimSuffix(settings.DeviceCertificateURL, "/") + "/v1/certificates/crl"}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCertificate, publicKey, caKey)
+	if err != nil {
+		return datastore.DeviceCertificate{}, err
+	}
+
+	cert := datastore.DeviceCertificate{
+		CertificateSerial: EncodeSerial(certificateSerial),
+		Brand:             serial.HeaderString("brand-id"),
+		Model:             serial.HeaderString("model"),
+		SerialNumber:      serial.HeaderString("serial"),
+		Fingerprint:       serial.HeaderString("device-key-sha3-384"),
+		Certificate:       string(datastore.EncodeCertificate(der)),
+		NotAfter:          template.NotAfter,
+	}
+	return cert, db.CreateDeviceCertificate(cert)
+}
+
+// EncodeSerial returns the hex encoding of a certificate serial
+func EncodeSerial(serial *big.Int) string {
+	return fmt.Sprintf("%x", serial)
+}
+
+// BuildStatus returns the status of the device certificate with the hex encoded serial
+func BuildStatus(db datastore.Datastore, certificateSerial string) (Status, error) {
+	status := Status{CertificateSerial: strings.ToLower(certificateSerial), Status: StatusUnknown, Generated: time.Now().UTC()}
+
+	cert, err := db.GetDeviceCertificate(status.CertificateSerial)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	status.Status = StatusGood
+	status.NotAfter = &cert.NotAfter
+	if cert.Revoked {
+		status.Status = StatusRevoked
+		status.RevokedAt = &cert.RevokedAt
+	}
+	return status, nil
+}
+
+// BuildCRL returns the DER encoded certificate revocation list of the device CA, with the revoked
+// device certificates that have not expired
+func BuildCRL(db datastore.Datastore, settings config.Settings) ([]byte, error) {
+	certs, err := db.ListRevokedDeviceCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	caCertificate, caKey, err := datastore.DeviceCA(db, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []pkix.RevokedCertificate{}
+	for _, c := range certs {
+		serial, ok := new(big.Int).SetString(c.CertificateSerial, 16)
+		if !ok {
+			return nil, fmt.Errorf("The device certificate serial '%s' is invalid", c.CertificateSerial)
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: c.RevokedAt})
+	}
+
+	now := time.Now().UTC()
+	return caCertificate.CreateCRL(rand.Reader, caKey, revoked, now, now.Add(crlValidity))
+}
+
+// devicePublicKey decodes the public key of the device-key header of a serial assertion
+func devicePublicKey(encoded string) (crypto.PublicKey, error) {
+	if !strings.HasPrefix(encoded, deviceKeyFormat) {
+		return nil, errors.New("The device-key must be an encoded openpgp public key")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, deviceKeyFormat))
+	if err != nil {
+		return nil, fmt.Errorf("The device-key is not valid base64: %v", err)
+	}
+
+	p, err := packet.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("The device-key is malformed: %v", err)
+	}
+	pubKey, ok := p.(*packet.PublicKey)
+	if !ok {
+		return nil, errors.New("The device-key is not a public key")
+	}
+
+	switch k := pubKey.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("The device-key algorithm %d is not supported for a certificate", pubKey.PubKeyAlgo)
+	}
+}
@@ -0,0 +1,232 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicecert_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/devicecert"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+func TestDeviceCertSuite(t *testing.T) { check.TestingT(t) }
+
+type DeviceCertSuite struct {
+	db        *datastoretest.DB
+	signer    *asserts.Database
+	key       asserts.PrivateKey
+	deviceKey *rsa.PrivateKey
+}
+
+var _ = check.Suite(&DeviceCertSuite{})
+
+func (s *DeviceCertSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddModel(datastoretest.NewModel("system", "alder").WithAPIKey("alder-api-key").Build())
+
+	settings := config.Settings{
+		KeyStoreSecret:       "secret code to encrypt the auth-key hash",
+		DeviceCertificates:   true,
+		DeviceCertificateURL: "https://serial-vault.example.com/",
+	}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: settings}
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, check.IsNil)
+	s.key = asserts.RSAPrivateKey(key)
+	s.signer, err = asserts.OpenDatabase(&asserts.DatabaseConfig{KeypairManager: asserts.NewMemoryKeypairManager()})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.signer.ImportKey(s.key), check.IsNil)
+
+	s.deviceKey, err = rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *DeviceCertSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *DeviceCertSuite) serial(c *check.C, serialNumber string) asserts.Assertion {
+	deviceKey := asserts.RSAPrivateKey(s.deviceKey)
+	encodedKey, err := asserts.EncodePublicKey(deviceKey.PublicKey())
+	c.Assert(err, check.IsNil)
+
+	headers := map[string]interface{}{
+		"authority-id":        "system",
+		"brand-id":            "system",
+		"model":               "alder",
+		"serial":              serialNumber,
+		"device-key":          string(encodedKey),
+		"device-key-sha3-384": deviceKey.PublicKey().ID(),
+		"timestamp":           time.Now().UTC().Format(time.RFC3339),
+	}
+	serial, err := s.signer.Sign(asserts.SerialType, headers, nil, s.key.PublicKey().ID())
+	c.Assert(err, check.IsNil)
+	return serial
+}
+
+func parseCertificate(c *check.C, data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	c.Assert(block, check.NotNil)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, check.IsNil)
+	return cert
+}
+
+func (s *DeviceCertSuite) TestIssue(c *check.C) {
+	serial := s.serial(c, "A1")
+	cert, err := devicecert.Issue(s.db, datastore.Environ.Config, serial)
+	c.Assert(err, check.IsNil)
+	c.Assert(cert.SerialNumber, check.Equals, "A1")
+	c.Assert(cert.Fingerprint, check.Equals, serial.HeaderString("device-key-sha3-384"))
+
+	certificate := parseCertificate(c, []byte(cert.Certificate))
+	c.Assert(certificate.Subject.CommonName, check.Equals, "A1")
+	c.Assert(certificate.Subject.Organization, check.DeepEquals, []string{"system"})
+	c.Assert(certificate.Subject.OrganizationalUnit, check.DeepEquals, []string{"alder"})
+	c.Assert(certificate.CRLDistributionPoints, check.DeepEquals, []string{"https://serial-vault.example.com/v1/certificates/crl"})
+	c.Assert(devicecert.EncodeSerial(certificate.SerialNumber), check.Equals, cert.CertificateSerial)
+	c.Assert(certificate.PublicKey.(*rsa.PublicKey).Equal(&s.deviceKey.PublicKey), check.Equals, true)
+
+	// The certificate verifies with the device CA
+	w := sendRequest(c, "/v1/certificates/ca", "")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	roots := x509.NewCertPool()
+	roots.AddCert(parseCertificate(c, w.Body.Bytes()))
+	_, err = certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *DeviceCertSuite) TestIssueInvalidDeviceKey(c *check.C) {
+	serial := s.serial(c, "A1")
+	headers := serial.Headers()
+	headers["device-key"] = "openpgp invalid"
+
+	_, err := devicecert.Issue(s.db, datastore.Environ.Config, assertionWithHeaders{serial, headers})
+	c.Assert(err, check.ErrorMatches, "The device-key is not valid base64: .*")
+}
+
+func (s *DeviceCertSuite) TestDevice(c *check.C) {
+	cert, err := devicecert.Issue(s.db, datastore.Environ.Config, s.serial(c, "A1"))
+	c.Assert(err, check.IsNil)
+
+	w := sendRequest(c, "/v1/certificates/device?brand=system&model=alder&serial=A1", "alder-api-key")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Body.String(), check.Equals, cert.Certificate)
+
+	w = sendRequest(c, "/v1/certificates/device?brand=system&model=alder&serial=A2", "alder-api-key")
+	c.Assert(w.Code, check.Equals, http.StatusNotFound)
+
+	w = sendRequest(c, "/v1/certificates/device?brand=system&model=alder&serial=A1", "invalid-api-key")
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *DeviceCertSuite) TestRevocation(c *check.C) {
+	cert, err := devicecert.Issue(s.db, datastore.Environ.Config, s.serial(c, "A1"))
+	c.Assert(err, check.IsNil)
+
+	status := s.status(c, cert.CertificateSerial)
+	c.Assert(status.Status, check.Equals, devicecert.StatusGood)
+	c.Assert(status.RevokedAt, check.IsNil)
+	c.Assert(s.crl(c).TBSCertList.RevokedCertificates, check.HasLen, 0)
+
+	c.Assert(s.db.RevokeDeviceCertificates("system", "alder", "A1", true), check.IsNil)
+	status = s.status(c, cert.CertificateSerial)
+	c.Assert(status.Status, check.Equals, devicecert.StatusRevoked)
+	c.Assert(status.RevokedAt, check.NotNil)
+
+	crl := s.crl(c)
+	c.Assert(crl.TBSCertList.RevokedCertificates, check.HasLen, 1)
+	c.Assert(devicecert.EncodeSerial(crl.TBSCertList.RevokedCertificates[0].SerialNumber), check.Equals, cert.CertificateSerial)
+
+	c.Assert(s.status(c, "abcdef").Status, check.Equals, devicecert.StatusUnknown)
+}
+
+func (s *DeviceCertSuite) TestDisabled(c *check.C) {
+	datastore.Environ.Config.DeviceCertificates = false
+
+	for _, url := range []string{"/v1/certificates/ca", "/v1/certificates/crl", "/v1/certificates/status/abcdef"} {
+		w := sendRequest(c, url, "")
+		c.Assert(w.Code, check.Equals, http.StatusNotFound)
+	}
+}
+
+func (s *DeviceCertSuite) TestCRLError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendRequest(c, "/v1/certificates/crl", "")
+	c.Assert(w.Code, check.Equals, http.StatusInternalServerError)
+}
+
+func (s *DeviceCertSuite) status(c *check.C, certificateSerial string) devicecert.Status {
+	w := sendRequest(c, "/v1/certificates/status/"+certificateSerial, "")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	status := devicecert.Status{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &status), check.IsNil)
+	return status
+}
+
+func (s *DeviceCertSuite) crl(c *check.C) *pkix.CertificateList {
+	w := sendRequest(c, "/v1/certificates/crl", "")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	crl, err := x509.ParseDERCRL(w.Body.Bytes())
+	c.Assert(err, check.IsNil)
+	ca, _, err := datastore.DeviceCA(s.db, datastore.Environ.Config)
+	c.Assert(err, check.IsNil)
+	c.Assert(ca.CheckCRLSignature(crl), check.IsNil)
+	return crl
+}
+
+// assertionWithHeaders overrides the headers of an assertion
+type assertionWithHeaders struct {
+	asserts.Assertion
+	headers map[string]interface{}
+}
+
+func (a assertionWithHeaders) HeaderString(name string) string {
+	value, _ := a.headers[name].(string)
+	return value
+}
+
+func sendRequest(c *check.C, url, apiKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+	if len(apiKey) > 0 {
+		r.Header.Set("api-key", apiKey)
+	}
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
+	return w
+}
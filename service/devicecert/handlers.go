@@ -0,0 +1,151 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicecert
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/revocation"
+	"github.com/gorilla/mux"
+)
+
+// Media types of the certificates and the certificate revocation list
+const (
+	pemMediaType = "application/x-pem-file"
+	crlMediaType = "application/pkix-crl"
+)
+
+// Service holds the dependencies of the device certificate handlers
+type Service struct {
+	*datastore.Env
+}
+
+// CA is the API method to fetch the PEM encoded certificate of the device CA
+func (srv *Service) CA(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	if !srv.Config.DeviceCertificates {
+		return response.ErrorCertificatesDisabled
+	}
+
+	caCertificate, _, err := datastore.DeviceCA(srv.DB, srv.Config)
+	if err != nil {
+		log.Errorf("Error fetching the device CA: %v", err)
+		return response.ErrorDeviceCA
+	}
+
+	formatPEM(w, datastore.EncodeCertificate(caCertificate.Raw))
+	return response.ErrorResponse{Success: true}
+}
+
+// CRL is the API method to fetch the DER encoded certificate revocation list of the device CA
+func (srv *Service) CRL(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	if !srv.Config.DeviceCertificates {
+		return response.ErrorCertificatesDisabled
+	}
+
+	crl, err := BuildCRL(srv.DB, srv.Config)
+	if err != nil {
+		log.Errorf("Error building the certificate revocation list: %v", err)
+		return response.ErrorDeviceCA
+	}
+
+	w.Header().Set("Content-Type", crlMediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(crl)
+	return response.ErrorResponse{Success: true}
+}
+
+// Status is the API method to check the status of a device certificate by its hex encoded
+// serial. The status is the JSON document, with its detached signature in the response headers
+// as the revocation lists, so it verifies with the revocation key
+func (srv *Service) Status(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	if !srv.Config.DeviceCertificates {
+		return response.ErrorCertificatesDisabled
+	}
+
+	status, err := BuildStatus(srv.DB, mux.Vars(r)["serial"])
+	if err != nil {
+		log.Message("CERTIFICATE", response.ErrorFetchCertificate.Code, err.Error())
+		return response.ErrorFetchCertificate
+	}
+
+	document, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		log.Message("CERTIFICATE", response.ErrorFetchCertificate.Code, err.Error())
+		return response.ErrorFetchCertificate
+	}
+
+	signature, err := datastore.SignReport(srv.DB, srv.Config, document)
+	if err != nil {
+		log.Errorf("Error signing the device certificate status: %v", err)
+		return response.ErrorDeviceCA
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set(revocation.SignatureHeader, signature.Signature)
+	w.Header().Set(revocation.KeyIDHeader, signature.KeyID)
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+	return response.ErrorResponse{Success: true}
+}
+
+// Device is the API method to fetch the latest certificate of a signed device, with the API key
+// of its model
+func (srv *Service) Device(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
+	if err != nil {
+		log.Message("CERTIFICATE", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
+		return response.ErrorInvalidAPIKey
+	}
+
+	if !srv.Config.DeviceCertificates {
+		return response.ErrorCertificatesDisabled
+	}
+
+	query := r.URL.Query()
+	model, err := srv.DB.FindModel(query.Get("brand"), query.Get("model"), apiKey)
+	if err != nil {
+		log.Message("CERTIFICATE", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
+		return response.ErrorInvalidModel
+	}
+
+	cert, err := srv.DB.GetLatestDeviceCertificate(model.BrandID, model.Name, query.Get("serial"))
+	if err == sql.ErrNoRows {
+		return response.ErrorMissingCertificate
+	}
+	if err != nil {
+		log.Message("CERTIFICATE", response.ErrorFetchCertificate.Code, err.Error())
+		return response.ErrorFetchCertificate
+	}
+
+	formatPEM(w, []byte(cert.Certificate))
+	return response.ErrorResponse{Success: true}
+}
+
+func formatPEM(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", pemMediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,357 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package directory syncs the users of the vault from the groups of an LDAP or Active Directory
+// server. The members of the groups are created, updated with the role and accounts of their groups,
+// and deleted when they are no longer in any group. Only the users that were created or taken over
+// by the sync are deleted, so the users that are managed by hand are kept
+package directory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DefaultInterval is the time between the syncs from the directory
+const DefaultInterval = time.Hour
+
+// requestTimeout is the limit for a request to the directory
+const requestTimeout = time.Minute
+
+// managedSetting is the code of the setting that holds the usernames that are managed by the sync
+const managedSetting = "directory/users"
+
+// Result lists the usernames of the users that were changed by a sync, or that would be changed
+// by a dry-run. The Errors are the members of the groups that could not be synced
+type Result struct {
+	DryRun    bool     `json:"dryRun"`
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	Errors    []string `json:"errors"`
+}
+
+// Interval returns the time between the syncs from the config
+func Interval(settings config.Settings) time.Duration {
+	if settings.Directory.Interval > 0 {
+		return time.Duration(settings.Directory.Interval) * time.Second
+	}
+	return DefaultInterval
+}
+
+// Run syncs the users from the directory periodically, until the context is done
+func Run(ctx context.Context, env *datastore.Env, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := Sync(ctx, env, env.Config.Directory.DryRun)
+			if err == nil {
+				log.Infof("Directory sync (dry-run: %v): %d created, %d updated, %d deleted, %d unchanged, %d errors",
+					result.DryRun, len(result.Created), len(result.Updated), len(result.Deleted), result.Unchanged, len(result.Errors))
+			}
+		}
+	}
+}
+
+// Sync updates the users from the members of the directory groups. With dryRun, the changes are
+// only reported. The sync is aborted without changes when the directory cannot be searched
+func Sync(ctx context.Context, env *datastore.Env, dryRun bool) (Result, error) {
+	result, err := sync(ctx, env.DB.WithContext(ctx), env.Config.Directory, dryRun)
+	if err != nil {
+		metrics.Increment(metrics.DirectoryErrors)
+		log.Message("DIRECTORY", "sync", err.Error())
+	}
+	for _, e := range result.Errors {
+		log.Warningf("Directory sync: %s", e)
+	}
+	return result, err
+}
+
+func sync(ctx context.Context, db datastore.Datastore, settings config.Directory, dryRun bool) (Result, error) {
+	result := Result{DryRun: dryRun, Created: []string{}, Updated: []string{}, Deleted: []string{}, Errors: []string{}}
+
+	members, err := fetchMembers(ctx, settings, &result)
+	if err != nil {
+		return result, err
+	}
+	if err := resolveAccounts(db, members, &result); err != nil {
+		return result, err
+	}
+
+	managed, err := managedUsers(db)
+	if err != nil {
+		return result, err
+	}
+
+	for _, username := range sortedUsernames(members) {
+		user := members[username]
+		existing, err := db.GetUserByUsername(username)
+		if err != nil && err != sql.ErrNoRows {
+			result.Errors = append(result.Errors, fmt.Sprintf("Error fetching the user '%s': %v", username, err))
+			continue
+		}
+		if err == sql.ErrNoRows {
+			if !dryRun {
+				if _, err := db.CreateUser(user); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("Error creating the user '%s': %v", username, err))
+					continue
+				}
+				metrics.Increment(metrics.DirectoryCreated)
+			}
+			result.Created = append(result.Created, username)
+			managed[username] = true
+			continue
+		}
+
+		if !changed(existing, user) {
+			result.Unchanged++
+			managed[username] = true
+			continue
+		}
+
+		// The API key of the user is kept, as it is not managed by the directory
+		user.ID = existing.ID
+		user.APIKey = existing.APIKey
+		if !dryRun {
+			if err := db.UpdateUser(user); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("Error updating the user '%s': %v", username, err))
+				continue
+			}
+			metrics.Increment(metrics.DirectoryUpdated)
+		}
+		result.Updated = append(result.Updated, username)
+		managed[username] = true
+	}
+
+	for _, username := range sortedManaged(managed) {
+		if _, ok := members[username]; ok {
+			continue
+		}
+
+		existing, err := db.GetUserByUsername(username)
+		if err == sql.ErrNoRows {
+			// The user has been deleted by hand
+			delete(managed, username)
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Error fetching the user '%s': %v", username, err))
+			continue
+		}
+		if !dryRun {
+			if err := db.DeleteUser(existing.ID); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("Error deleting the user '%s': %v", username, err))
+				continue
+			}
+			metrics.Increment(metrics.DirectoryDeleted)
+		}
+		result.Deleted = append(result.Deleted, username)
+		delete(managed, username)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, putManagedUsers(db, managed)
+}
+
+// fetchMembers searches the members of the groups, with the highest role of their groups. The
+// Accounts of the users only hold the authority-ids of the accounts of their groups
+func fetchMembers(ctx context.Context, settings config.Directory, result *Result) (map[string]datastore.User, error) {
+	if len(settings.URL) == 0 {
+		return nil, errors.New("The directory URL is not configured")
+	}
+	if len(settings.Groups) == 0 {
+		return nil, errors.New("No directory groups are configured")
+	}
+	for _, g := range settings.Groups {
+		if datastore.RoleID[g.Role] == 0 {
+			return nil, fmt.Errorf("The role '%s' of the directory group '%s' is invalid", g.Role, g.DN)
+		}
+	}
+
+	usernameAttribute := attributeOrDefault(settings.UsernameAttribute, "uid")
+	nameAttribute := attributeOrDefault(settings.NameAttribute, "cn")
+	emailAttribute := attributeOrDefault(settings.EmailAttribute, "mail")
+	memberOfAttribute := attributeOrDefault(settings.MemberOfAttribute, "memberOf")
+
+	conn, err := Dial(ctx, settings, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(settings.BindDN, settings.BindPassword); err != nil {
+		return nil, err
+	}
+
+	members := map[string]datastore.User{}
+	for _, g := range settings.Groups {
+		entries, err := conn.Search(settings.BaseDN, memberOfAttribute, g.DN, []string{usernameAttribute, nameAttribute, emailAttribute})
+		if err != nil {
+			return nil, fmt.Errorf("Error searching the members of '%s': %v", g.DN, err)
+		}
+
+		for _, e := range entries {
+			// The usernames of the vault are in lower case, and the directory usernames are case-insensitive
+			username := strings.ToLower(e.Get(usernameAttribute))
+			if len(username) == 0 {
+				result.Errors = append(result.Errors, fmt.Sprintf("The member '%s' has no %s", e.DN, usernameAttribute))
+				continue
+			}
+
+			user, ok := members[username]
+			if !ok {
+				user = datastore.User{Username: username, Name: e.Get(nameAttribute), Email: strings.ToLower(e.Get(emailAttribute))}
+				if len(user.Name) == 0 {
+					user.Name = username
+				}
+			}
+			if role := datastore.RoleID[g.Role]; role > user.Role {
+				user.Role = role
+			}
+			for _, authorityID := range g.Accounts {
+				if !hasAccount(user.Accounts, authorityID) {
+					user.Accounts = append(user.Accounts, datastore.Account{AuthorityID: authorityID})
+				}
+			}
+			members[username] = user
+		}
+	}
+	return members, nil
+}
+
+// resolveAccounts replaces the authority-ids of the accounts of the members with the accounts
+func resolveAccounts(db datastore.Datastore, members map[string]datastore.User, result *Result) error {
+	accounts := map[string]datastore.Account{}
+	for _, username := range sortedUsernames(members) {
+		user := members[username]
+		resolved := []datastore.Account{}
+		for _, a := range user.Accounts {
+			account, ok := accounts[a.AuthorityID]
+			if !ok {
+				var err error
+				account, err = db.GetAccount(a.AuthorityID)
+				if err != nil {
+					return fmt.Errorf("The account '%s' of the directory groups cannot be found", a.AuthorityID)
+				}
+				accounts[a.AuthorityID] = account
+			}
+			resolved = append(resolved, account)
+		}
+		user.Accounts = resolved
+		members[username] = user
+	}
+	return nil
+}
+
+// changed checks whether the user differs from the member of the directory groups
+func changed(existing, user datastore.User) bool {
+	if existing.Name != user.Name || existing.Email != user.Email || existing.Role != user.Role {
+		return true
+	}
+	if len(existing.Accounts) != len(user.Accounts) {
+		return true
+	}
+	for _, a := range user.Accounts {
+		if !hasAccount(existing.Accounts, a.AuthorityID) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAccount(accounts []datastore.Account, authorityID string) bool {
+	for _, a := range accounts {
+		if a.AuthorityID == authorityID {
+			return true
+		}
+	}
+	return false
+}
+
+func attributeOrDefault(attribute, defaultAttribute string) string {
+	if len(attribute) > 0 {
+		return attribute
+	}
+	return defaultAttribute
+}
+
+// managedUsers returns the usernames of the users that are managed by the sync
+func managedUsers(db datastore.Datastore) (map[string]bool, error) {
+	managed := map[string]bool{}
+	setting, err := db.GetSetting(managedSetting)
+	if err != nil || len(setting.Data) == 0 {
+		// No users have been synced yet
+		return managed, nil
+	}
+
+	usernames := []string{}
+	if err := json.Unmarshal([]byte(setting.Data), &usernames); err != nil {
+		return nil, fmt.Errorf("Error reading the users that are managed by the directory: %v", err)
+	}
+	for _, u := range usernames {
+		managed[u] = true
+	}
+	return managed, nil
+}
+
+func putManagedUsers(db datastore.Datastore, managed map[string]bool) error {
+	data, err := json.Marshal(sortedManaged(managed))
+	if err != nil {
+		return err
+	}
+	return db.PutSetting(datastore.Setting{Code: managedSetting, Data: string(data)})
+}
+
+// sortedUsernames returns the usernames of the members in order, so the results are stable
+func sortedUsernames(members map[string]datastore.User) []string {
+	usernames := []string{}
+	for u := range members {
+		usernames = append(usernames, u)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func sortedManaged(managed map[string]bool) []string {
+	usernames := []string{}
+	for u := range managed {
+		usernames = append(usernames, u)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
@@ -0,0 +1,386 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package directory
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	ber "gopkg.in/asn1-ber.v1"
+	check "gopkg.in/check.v1"
+	ldap "gopkg.in/ldap.v2"
+)
+
+func TestDirectorySuite(t *testing.T) { check.TestingT(t) }
+
+type DirectorySuite struct {
+	db     *datastoretest.DB
+	env    *datastore.Env
+	server *ldapServer
+	// ldaps is the listener for the ldaps:// URL and plain is the one for ldap:// with StartTLS
+	ldaps net.Listener
+	plain net.Listener
+}
+
+var _ = check.Suite(&DirectorySuite{})
+
+const (
+	adminsDN     = "cn=vault-admins,ou=groups,dc=example,dc=com"
+	developersDN = "cn=vault-developers,ou=groups,dc=example,dc=com"
+)
+
+func member(uid, name, email string) Entry {
+	return Entry{
+		DN:         "uid=" + uid + ",ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{"uid": {uid}, "cn": {name}, "mail": {email}},
+	}
+}
+
+func (s *DirectorySuite) SetUpTest(c *check.C) {
+	tlsConfig, caFile := generateServerTLS(c)
+	s.server = &ldapServer{tlsConfig: tlsConfig}
+	s.server.setGroups(map[string][]Entry{
+		adminsDN:     {member("alice", "Alice", "alice@example.com")},
+		developersDN: {member("alice", "Alice", "alice@example.com"), member("Bob", "Bob", "bob@example.com")},
+	})
+
+	var err error
+	s.ldaps, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	s.plain, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	go s.server.serve(tls.NewListener(s.ldaps, tlsConfig))
+	go s.server.serve(s.plain)
+
+	s.db = datastoretest.New()
+	s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "carol", Name: "Carol", Email: "carol@example.com", Role: datastore.Superuser})
+
+	s.env = &datastore.Env{DB: s.db, Config: config.Settings{Directory: config.Directory{
+		URL:          "ldaps://" + s.ldaps.Addr().String(),
+		CACert:       caFile,
+		BindDN:       "cn=vault,dc=example,dc=com",
+		BindPassword: "secret",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		Groups: []config.DirectoryGroup{
+			{DN: adminsDN, Role: "admin", Accounts: []string{"system"}},
+			{DN: developersDN, Role: "standard", Accounts: []string{"other"}},
+		},
+	}}}
+}
+
+func (s *DirectorySuite) TearDownTest(c *check.C) {
+	s.ldaps.Close()
+	s.plain.Close()
+}
+
+// generateServerTLS creates a self-signed certificate for 127.0.0.1 and writes it to the CA file
+// that the client trusts
+func generateServerTLS(c *check.C) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, check.IsNil)
+
+	caFile := filepath.Join(c.MkDir(), "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	c.Assert(err, check.IsNil)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, caFile
+}
+
+// ldapServer answers the StartTLS, bind and search requests of the sync as an LDAP server
+type ldapServer struct {
+	tlsConfig *tls.Config
+
+	mu gosync.Mutex
+	// groups holds the members of the directory groups by the DN of the group
+	groups map[string][]Entry
+}
+
+func (l *ldapServer) setGroups(groups map[string][]Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.groups = groups
+}
+
+func (l *ldapServer) members(groupDN string) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.groups[groupDN]
+}
+
+// serve accepts the connections until the listener is closed
+func (l *ldapServer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.handle(conn)
+	}
+}
+
+func (l *ldapServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationExtendedRequest:
+			// StartTLS: the handshake follows the response
+			if _, err = conn.Write(response(id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))); err != nil {
+				return
+			}
+			conn = tls.Server(conn, l.tlsConfig)
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultSuccess
+			if op.Children[2].Data.String() != "secret" {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(response(id, result(ldap.ApplicationBindResponse, code)))
+		case ldap.ApplicationSearchRequest:
+			filter := op.Children[6]
+			for _, e := range l.members(filter.Children[1].Value.(string)) {
+				conn.Write(response(id, searchEntry(e)))
+			}
+			conn.Write(response(id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)))
+		default:
+			return
+		}
+	}
+}
+
+func response(id int64, op *ber.Packet) []byte {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	return packet.Bytes()
+}
+
+func result(tag ber.Tag, code int) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "MOCK result", "diagnosticMessage"))
+	return packet
+}
+
+func searchEntry(e Entry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range e.Attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+func (s *DirectorySuite) TestSyncDryRun(c *check.C) {
+	result, err := Sync(context.Background(), s.env, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.DryRun, check.Equals, true)
+	c.Assert(result.Created, check.DeepEquals, []string{"alice", "bob"})
+
+	// The users are not created
+	_, err = s.db.GetUserByUsername("alice")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *DirectorySuite) TestSync(c *check.C) {
+	before := metrics.Value(metrics.DirectoryCreated)
+
+	result, err := Sync(context.Background(), s.env, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Created, check.DeepEquals, []string{"alice", "bob"})
+	c.Assert(result.Errors, check.HasLen, 0)
+	c.Assert(metrics.Value(metrics.DirectoryCreated), check.Equals, before+2)
+
+	// The user has the highest role and all the accounts of their groups
+	alice, err := s.db.GetUserByUsername("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(alice.Role, check.Equals, datastore.Admin)
+	c.Assert(alice.Email, check.Equals, "alice@example.com")
+	c.Assert(alice.Accounts, check.HasLen, 2)
+	c.Assert(alice.APIKey, check.Not(check.Equals), "")
+
+	bob, err := s.db.GetUserByUsername("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert(bob.Role, check.Equals, datastore.Standard)
+	c.Assert(bob.Accounts, check.HasLen, 1)
+	c.Assert(bob.Accounts[0].AuthorityID, check.Equals, "other")
+
+	// A second sync has no changes
+	result, err = Sync(context.Background(), s.env, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Unchanged, check.Equals, 2)
+	c.Assert(result.Created, check.HasLen, 0)
+
+	// Alice leaves the admins and Bob leaves the directory groups
+	s.server.setGroups(map[string][]Entry{developersDN: {member("alice", "Alice", "alice@example.com")}})
+
+	result, err = Sync(context.Background(), s.env, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Updated, check.DeepEquals, []string{"alice"})
+	c.Assert(result.Deleted, check.DeepEquals, []string{"bob"})
+
+	updated, err := s.db.GetUserByUsername("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(updated.Role, check.Equals, datastore.Standard)
+	c.Assert(updated.APIKey, check.Equals, alice.APIKey)
+
+	_, err = s.db.GetUserByUsername("bob")
+	c.Assert(err, check.NotNil)
+
+	// The users that are not managed by the sync are kept
+	_, err = s.db.GetUserByUsername("carol")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *DirectorySuite) TestSyncMissingUsername(c *check.C) {
+	s.server.setGroups(map[string][]Entry{developersDN: {
+		member("alice", "Alice", "alice@example.com"),
+		member("Bob", "Bob", "bob@example.com"),
+		{DN: "cn=service,dc=example,dc=com", Attributes: map[string][]string{"cn": {"service"}}},
+	}})
+
+	result, err := Sync(context.Background(), s.env, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Created, check.DeepEquals, []string{"alice", "bob"})
+	c.Assert(result.Errors, check.DeepEquals, []string{"The member 'cn=service,dc=example,dc=com' has no uid"})
+}
+
+func (s *DirectorySuite) TestSyncStartTLS(c *check.C) {
+	s.env.Config.Directory.URL = "ldap://" + s.plain.Addr().String()
+	s.env.Config.Directory.StartTLS = true
+
+	result, err := Sync(context.Background(), s.env, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Created, check.DeepEquals, []string{"alice", "bob"})
+}
+
+func (s *DirectorySuite) TestSyncExistingUserError(c *check.C) {
+	s.db.AddUser(datastore.User{Username: "bob", Name: "Bob", Email: "bob@example.com", Role: datastore.Standard})
+	s.env.DB = &failingUserDB{DB: s.db, username: "bob"}
+
+	result, err := Sync(context.Background(), s.env, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Created, check.DeepEquals, []string{"alice"})
+	c.Assert(result.Errors, check.DeepEquals, []string{"Error fetching the user 'bob': MOCK error"})
+
+	// The user is not created again
+	bob, err := s.db.GetUserByUsername("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert(bob.Accounts, check.HasLen, 0)
+}
+
+// failingUserDB fails to fetch the user, other than with a not-found error
+type failingUserDB struct {
+	*datastoretest.DB
+	username string
+}
+
+func (db *failingUserDB) WithContext(ctx context.Context) datastore.Datastore {
+	return db
+}
+
+func (db *failingUserDB) GetUserByUsername(username string) (datastore.User, error) {
+	if username == db.username {
+		return datastore.User{}, errors.New("MOCK error")
+	}
+	return db.DB.GetUserByUsername(username)
+}
+
+func (s *DirectorySuite) TestSyncErrors(c *check.C) {
+	tests := []struct {
+		update func(*config.Directory)
+		err    string
+	}{
+		{func(d *config.Directory) { d.BindPassword = "invalid" }, `The directory bind failed: LDAP Result Code 49 "Invalid Credentials": MOCK result`},
+		{func(d *config.Directory) { d.BindPassword = "" }, "The directory bind password must not be empty"},
+		{func(d *config.Directory) { d.URL = "http://example.com" }, "The directory URL must be ldaps:// or ldap://, not 'http://example.com'"},
+		{func(d *config.Directory) { d.URL = "ldap://" + s.plain.Addr().String() }, "The directory URL must be ldaps://, or ldap:// with startTLS, so the bind password is encrypted"},
+		{func(d *config.Directory) { d.CACert = "" }, "Error connecting to the directory: .*certificate signed by unknown authority"},
+		{func(d *config.Directory) { d.Groups[0].Role = "owner" }, "The role 'owner' of the directory group '" + adminsDN + "' is invalid"},
+		{func(d *config.Directory) { d.Groups[1].Accounts = []string{"unknown"} }, "The account 'unknown' of the directory groups cannot be found"},
+		{func(d *config.Directory) { d.Groups = nil }, "No directory groups are configured"},
+	}
+
+	for _, t := range tests {
+		// Each case changes a copy of the settings, so the server of the test is kept
+		settings := s.env.Config
+		settings.Directory.Groups = append([]config.DirectoryGroup{}, s.env.Config.Directory.Groups...)
+		t.update(&settings.Directory)
+		env := &datastore.Env{DB: s.db, Config: settings}
+		before := metrics.Value(metrics.DirectoryErrors)
+
+		_, err := Sync(context.Background(), env, false)
+		c.Check(err, check.ErrorMatches, t.err)
+		c.Check(metrics.Value(metrics.DirectoryErrors), check.Equals, before+1)
+
+		// No users are created when the sync fails
+		_, err = s.db.GetUserByUsername("alice")
+		c.Check(err, check.NotNil)
+	}
+}
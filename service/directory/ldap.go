@@ -0,0 +1,179 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Entry is an object that is found by a search. The names of the attributes are in lower case
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of the attribute, or an empty string
+func (e Entry) Get(attribute string) string {
+	values := e.Attributes[strings.ToLower(attribute)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Conn is an encrypted connection to an LDAP server, which supports the simple bind and the
+// equality searches
+type Conn struct {
+	conn *ldap.Conn
+}
+
+// Dial connects to the LDAP server of the directory settings, at an ldaps:// URL or at an ldap://
+// URL with StartTLS. The bind password is never sent in the clear, so a plain ldap:// URL is refused
+func Dial(ctx context.Context, settings config.Directory, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(settings.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid directory URL: %v", err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("The directory URL must be ldaps:// or ldap://, not '%s'", settings.URL)
+	}
+	if u.Scheme == "ldap" && !settings.StartTLS {
+		return nil, errors.New("The directory URL must be ldaps://, or ldap:// with startTLS, so the bind password is encrypted")
+	}
+
+	tlsConfig, err := directoryTLSConfig(settings, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn *ldap.Conn
+	if u.Scheme == "ldaps" {
+		netConn, err := dialer.DialContext(ctx, "tcp", hostPort(u, "636"))
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to the directory: %v", err)
+		}
+		tlsConn := tls.Client(netConn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err = tlsConn.Handshake(); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("Error connecting to the directory: %v", err)
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = ldap.NewConn(tlsConn, true)
+		conn.Start()
+	} else {
+		netConn, err := dialer.DialContext(ctx, "tcp", hostPort(u, "389"))
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to the directory: %v", err)
+		}
+		conn = ldap.NewConn(netConn, false)
+		conn.Start()
+		conn.SetTimeout(timeout)
+
+		netConn.SetDeadline(time.Now().Add(timeout))
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error starting TLS with the directory: %v", err)
+		}
+		netConn.SetDeadline(time.Time{})
+	}
+
+	conn.SetTimeout(timeout)
+	return &Conn{conn: conn}, nil
+}
+
+// directoryTLSConfig verifies the certificate of the server with the system CAs, or with the CAs
+// of the CACert file
+func directoryTLSConfig(settings config.Directory, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: serverName}
+	if len(settings.CACert) == 0 {
+		return tlsConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(settings.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the CA certificates of the directory: %v", err)
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No CA certificates found in '%s'", settings.CACert)
+	}
+	return tlsConfig, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if len(u.Port()) > 0 {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// Close closes the connection
+func (c *Conn) Close() {
+	c.conn.Close()
+}
+
+// Bind authenticates the connection with the DN and password
+func (c *Conn) Bind(dn, password string) error {
+	if len(password) == 0 {
+		// An empty password is an unauthenticated bind, which succeeds for any DN
+		return errors.New("The directory bind password must not be empty")
+	}
+
+	if err := c.conn.Bind(dn, password); err != nil {
+		return fmt.Errorf("The directory bind failed: %v", err)
+	}
+	return nil
+}
+
+// Search returns the objects under the base DN whose attribute has the value, with the attributes
+func (c *Conn) Search(baseDN, attribute, value string, attributes []string) ([]Entry, error) {
+	filter := fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(attribute), ldap.EscapeFilter(value))
+	request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, attributes, nil)
+
+	result, err := c.conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// The search result references to other servers are not followed
+	entries := []Entry{}
+	for _, e := range result.Entries {
+		entry := Entry{DN: e.DN, Attributes: map[string][]string{}}
+		for _, a := range e.Attributes {
+			name := strings.ToLower(a.Name)
+			entry.Attributes[name] = append(entry.Attributes[name], a.Values...)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
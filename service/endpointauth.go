@@ -0,0 +1,186 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/usso"
+)
+
+// Endpoint groups of the authentication rules
+const (
+	GroupSign  = "sign"  // the signing service API
+	GroupAdmin = "admin" // the API of the admin web UI
+	GroupAPI   = "api"   // the admin API
+	GroupSync  = "sync"  // the admin API that the factories sync with
+)
+
+// Authentication schemes of the endpoint groups
+const (
+	SchemeAPIKey     = "apikey"
+	SchemeUserAPIKey = "user-apikey"
+	SchemeMTLS       = "mtls"
+	SchemeJWT        = "jwt"
+)
+
+// Matches of the schemes of a rule
+const (
+	MatchAny = "any"
+	MatchAll = "all"
+)
+
+var endpointGroups = []string{GroupSign, GroupAdmin, GroupAPI, GroupSync}
+var authSchemes = []string{SchemeAPIKey, SchemeUserAPIKey, SchemeMTLS, SchemeJWT}
+
+// EndpointAuthRules returns the authentication rules of the endpoint groups from the config,
+// checking that they are valid
+func EndpointAuthRules(settings config.Settings) (map[string]config.EndpointAuth, error) {
+	rules := map[string]config.EndpointAuth{}
+	for _, rule := range settings.EndpointAuth {
+		if !contains(endpointGroups, rule.Group) {
+			return nil, fmt.Errorf("The endpoint group '%s' is invalid", rule.Group)
+		}
+		if _, ok := rules[rule.Group]; ok {
+			return nil, fmt.Errorf("The endpoint group '%s' has more than one rule", rule.Group)
+		}
+		if len(rule.Schemes) == 0 {
+			return nil, fmt.Errorf("The endpoint group '%s' must have an authentication scheme", rule.Group)
+		}
+		for _, scheme := range rule.Schemes {
+			if !contains(authSchemes, scheme) {
+				return nil, fmt.Errorf("The authentication scheme '%s' of the endpoint group '%s' is invalid", scheme, rule.Group)
+			}
+		}
+		switch rule.Match {
+		case "":
+			rule.Match = MatchAny
+		case MatchAny, MatchAll:
+		default:
+			return nil, fmt.Errorf("The match '%s' of the endpoint group '%s' must be 'any' or 'all'", rule.Match, rule.Group)
+		}
+		rules[rule.Group] = rule
+	}
+	return rules, nil
+}
+
+// authenticated checks that the requests of the endpoint group present the authentication schemes
+// of its rule, before the handler runs its own checks. The handler is not changed when the group
+// has no rule
+func (srv *Service) authenticated(group string, inner http.Handler) http.Handler {
+	rules, err := EndpointAuthRules(srv.Env.Config)
+	if err != nil {
+		// The rules are checked when the service starts, so this is a test or a tool
+		svlog.Errorf("Error in the endpoint authentication: %v", err)
+	}
+	rule, ok := rules[group]
+	if !ok {
+		return inner
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !srv.presentsSchemes(r, rule) {
+			metrics.Increment(metrics.AuthSchemeRejected)
+			svlog.Message("AUTH", response.ErrorAuthScheme.Code, fmt.Sprintf("%s %s does not present the %s schemes of the %s endpoints: %v", r.Method, r.URL.Path, rule.Match, group, rule.Schemes))
+			w.Header().Set("Content-Type", response.JSONHeader)
+			w.WriteHeader(response.ErrorAuthScheme.StatusCode)
+			json.NewEncoder(w).Encode(response.ErrorAuthScheme)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// presentsSchemes checks if the request presents any, or all, of the schemes of the rule
+func (srv *Service) presentsSchemes(r *http.Request, rule config.EndpointAuth) bool {
+	for _, scheme := range rule.Schemes {
+		presented := srv.presentsScheme(r, scheme)
+		if presented && rule.Match == MatchAny {
+			return true
+		}
+		if !presented && rule.Match == MatchAll {
+			return false
+		}
+	}
+	return rule.Match == MatchAll
+}
+
+// presentsScheme checks if the request presents valid credentials of the scheme
+func (srv *Service) presentsScheme(r *http.Request, scheme string) bool {
+	switch scheme {
+	case SchemeAPIKey:
+		_, err := request.CheckModelAPI(r, srv.Env.DB)
+		return err == nil
+	case SchemeUserAPIKey:
+		_, err := request.CheckUserAPI(r, srv.Env.DB)
+		return err == nil
+	case SchemeMTLS:
+		// The server only accepts the client certificates that verify with the client CAs
+		if r.TLS != nil {
+			return len(r.TLS.PeerCertificates) > 0 && len(r.TLS.VerifiedChains) > 0
+		}
+		return len(request.ClientCertSubject(r, srv.Env.Config)) > 0
+	case SchemeJWT:
+		jwtToken, err := usso.JWTExtractor(r)
+		if err != nil {
+			return false
+		}
+		token, err := usso.VerifyJWT(jwtToken, srv.Env.Config.JwtSecret)
+		return err == nil && token.Valid
+	default:
+		return false
+	}
+}
+
+// signingMiddleware pre-processes the requests of the signing service API
+func (srv *Service) signingMiddleware(inner http.Handler) http.Handler {
+	return srv.middleware(srv.authenticated(GroupSign, inner))
+}
+
+// adminMiddleware pre-processes the requests of the API of the admin web UI, with CSRF protection
+func (srv *Service) adminMiddleware(inner http.Handler) http.Handler {
+	return srv.middlewareWithCSRF(srv.authenticated(GroupAdmin, inner))
+}
+
+// apiMiddleware pre-processes the requests of the admin API
+func (srv *Service) apiMiddleware(inner http.Handler) http.Handler {
+	return srv.middleware(srv.authenticated(GroupAPI, inner))
+}
+
+// syncMiddleware pre-processes the requests of the admin API that the factories sync with
+func (srv *Service) syncMiddleware(inner http.Handler) http.Handler {
+	return srv.middleware(srv.authenticated(GroupSync, inner))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
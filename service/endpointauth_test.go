@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	check "gopkg.in/check.v1"
+)
+
+type EndpointAuthSuite struct {
+	db *datastoretest.DB
+}
+
+var _ = check.Suite(&EndpointAuthSuite{})
+
+func (s *EndpointAuthSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddModel(datastore.Model{BrandID: "system", Name: "alder"})
+}
+
+func (s *EndpointAuthSuite) send(settings config.Settings, group string, headers map[string]string) int {
+	srv := NewService(&datastore.Env{DB: s.db, Config: settings})
+	handler := srv.authenticated(group, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/serial", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func (s *EndpointAuthSuite) TestEndpointAuthRules(c *check.C) {
+	tests := []struct {
+		Rules []config.EndpointAuth
+		Error string
+	}{
+		{nil, ""},
+		{[]config.EndpointAuth{{Group: "sign", Schemes: []string{"apikey", "mtls"}, Match: "all"}, {Group: "admin", Schemes: []string{"jwt"}}}, ""},
+		{[]config.EndpointAuth{{Group: "invalid", Schemes: []string{"jwt"}}}, "The endpoint group 'invalid' is invalid"},
+		{[]config.EndpointAuth{{Group: "sync", Schemes: []string{"jwt"}}, {Group: "sync", Schemes: []string{"apikey"}}}, "The endpoint group 'sync' has more than one rule"},
+		{[]config.EndpointAuth{{Group: "api"}}, "The endpoint group 'api' must have an authentication scheme"},
+		{[]config.EndpointAuth{{Group: "api", Schemes: []string{"oidc"}}}, "The authentication scheme 'oidc' of the endpoint group 'api' is invalid"},
+		{[]config.EndpointAuth{{Group: "api", Schemes: []string{"jwt"}, Match: "some"}}, "The match 'some' of the endpoint group 'api' must be 'any' or 'all'"},
+	}
+
+	for _, t := range tests {
+		rules, err := EndpointAuthRules(config.Settings{EndpointAuth: t.Rules})
+		if len(t.Error) > 0 {
+			c.Assert(err, check.ErrorMatches, t.Error)
+			continue
+		}
+		c.Assert(err, check.IsNil)
+		c.Assert(rules, check.HasLen, len(t.Rules))
+	}
+
+	rules, err := EndpointAuthRules(config.Settings{EndpointAuth: []config.EndpointAuth{{Group: "admin", Schemes: []string{"jwt"}}}})
+	c.Assert(err, check.IsNil)
+	c.Assert(rules["admin"].Match, check.Equals, MatchAny)
+}
+
+func (s *EndpointAuthSuite) TestAuthenticated(c *check.C) {
+	settings := config.Settings{
+		ClientCertHeader: "X-SSL-Client-S-DN",
+		TrustedProxies:   []string{"192.0.2.0/24"},
+		EndpointAuth: []config.EndpointAuth{
+			{Group: GroupSign, Schemes: []string{SchemeAPIKey, SchemeMTLS}, Match: MatchAll},
+			{Group: GroupSync, Schemes: []string{SchemeUserAPIKey, SchemeJWT}},
+		},
+	}
+
+	tests := []struct {
+		Group   string
+		Headers map[string]string
+		Code    int
+	}{
+		{GroupSign, map[string]string{"api-key": "system-alder", "X-SSL-Client-S-DN": "CN=station1"}, http.StatusOK},
+		{GroupSign, map[string]string{"api-key": "system-alder"}, http.StatusUnauthorized},
+		{GroupSign, map[string]string{"api-key": "invalid", "X-SSL-Client-S-DN": "CN=station1"}, http.StatusUnauthorized},
+		{GroupSync, map[string]string{"api-key": "system-alder"}, http.StatusUnauthorized},
+		{GroupSync, map[string]string{"Authorization": "Bearer invalid"}, http.StatusUnauthorized},
+		{GroupAdmin, nil, http.StatusOK},
+	}
+
+	for _, t := range tests {
+		c.Assert(s.send(settings, t.Group, t.Headers), check.Equals, t.Code, check.Commentf("%s: %v", t.Group, t.Headers))
+	}
+}
+
+func (s *EndpointAuthSuite) TestAuthenticatedMTLSHeader(c *check.C) {
+	rule := []config.EndpointAuth{{Group: GroupSign, Schemes: []string{SchemeMTLS}}}
+	headers := map[string]string{"X-SSL-Client-S-DN": "CN=station1"}
+
+	// The header is only accepted from a trusted proxy
+	settings := config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", EndpointAuth: rule}
+	c.Assert(s.send(settings, GroupSign, headers), check.Equals, http.StatusUnauthorized)
+	settings.TrustedProxies = []string{"198.51.100.7"}
+	c.Assert(s.send(settings, GroupSign, headers), check.Equals, http.StatusUnauthorized)
+	settings.TrustedProxies = []string{"192.0.2.1"}
+	c.Assert(s.send(settings, GroupSign, headers), check.Equals, http.StatusOK)
+
+	// The header is ignored when the service terminates TLS itself
+	settings.TLSCertFile = "cert.pem"
+	c.Assert(s.send(settings, GroupSign, headers), check.Equals, http.StatusUnauthorized)
+	settings.TLSCertFile = ""
+	settings.ACMEDomains = []string{"vault.example.com"}
+	c.Assert(s.send(settings, GroupSign, headers), check.Equals, http.StatusUnauthorized)
+}
+
+func (s *EndpointAuthSuite) TestAuthenticatedUserAPIKey(c *check.C) {
+	s.db.AddUser(datastore.User{Username: "sync", APIKey: "sync-api-key", Role: datastore.SyncUser})
+	settings := config.Settings{EndpointAuth: []config.EndpointAuth{{Group: GroupSync, Schemes: []string{SchemeUserAPIKey, SchemeJWT}}}}
+
+	c.Assert(s.send(settings, GroupSync, map[string]string{"user": "sync", "api-key": "sync-api-key"}), check.Equals, http.StatusOK)
+	c.Assert(s.send(settings, GroupSync, map[string]string{"user": "sync", "api-key": "invalid"}), check.Equals, http.StatusUnauthorized)
+}
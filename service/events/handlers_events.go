@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package events
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+)
+
+// pollInterval is the time between the checks for changes, and keepAliveInterval is the time
+// after which the stream is kept alive when there are no changes
+var (
+	pollInterval      = 2 * time.Second
+	keepAliveInterval = 15 * time.Second
+)
+
+// Service holds the dependencies of the events handlers
+type Service struct {
+	*datastore.Env
+}
+
+// Stream is the API method to stream the signing, sync and alert events to the admin UI, as
+// server-sent events. A reconnecting client resumes the signing events after the Last-Event-ID
+func (srv *Service) Stream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.streamHandler(r.Context(), w, authUser, false, stream.LastEventID(r))
+}
+
+// APIStream is the API method to stream the signing, sync and alert events, as server-sent events
+func (srv *Service) APIStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.streamHandler(r.Context(), w, user, true, stream.LastEventID(r))
+}
+
+// streamHandler streams the events after the signing log entry with the ID, until the client
+// disconnects. A negative ID streams the entries that are signed from now on
+func (srv *Service) streamHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, afterID int) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The first poll records the current state, so only the changes from now on are streamed
+	watcher := NewWatcher(user, srv.Config, afterID)
+	events, err := watcher.Poll(srv.DB, time.Now().UTC())
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-events", "", err.Error(), w)
+		return
+	}
+
+	sw, err := stream.New(w)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-stream", "", err.Error(), w)
+		return
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+
+	for {
+		for _, e := range events {
+			if err := sw.Event(e.ID, e.Name, e.Data); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+
+		if time.Since(lastWrite) >= keepAliveInterval {
+			if err := sw.KeepAlive(); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		events, err = watcher.Poll(srv.DB, time.Now().UTC())
+		if err != nil {
+			// The changes that were missed are found on the next tick
+			log.Message("EVENTS", "error-fetch-events", err.Error())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/events"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+	check "gopkg.in/check.v1"
+)
+
+type StreamSuite struct {
+	db     *datastoretest.DB
+	server *httptest.Server
+}
+
+var _ = check.Suite(&StreamSuite{})
+
+func (s *StreamSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "user1", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+
+	config := config.Settings{JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+	s.server = httptest.NewServer(service.NewService(datastore.Environ).AdminRouter())
+}
+
+func (s *StreamSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *StreamSuite) stream(ctx context.Context, c *check.C, username, lastEventID string) *http.Response {
+	r, _ := http.NewRequest("GET", s.server.URL+"/api/events", nil)
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+	if len(lastEventID) > 0 {
+		r.Header.Set(stream.LastEventIDHeader, lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	c.Assert(err, check.IsNil)
+	return resp
+}
+
+func (s *StreamSuite) TestStream(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The stream resumes after the last event
+	resp := s.stream(ctx, c, "sv", "0")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, stream.ContentType)
+
+	sr := stream.NewReader(resp.Body)
+	msg, err := sr.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Event, check.Equals, events.SigningEvent)
+
+	l := datastore.SigningLog{}
+	c.Assert(json.Unmarshal([]byte(msg.Data), &l), check.IsNil)
+	c.Assert(l.SerialNumber, check.Equals, "A1")
+
+	// A factory that checks in for the first time is a sync event
+	now := time.Now().UTC()
+	s.db.CheckInFactory(datastore.FactoryCheckIn{InstanceID: "factory1", Hostname: "line1", KeystoreHealthy: true, Reported: now, Received: now})
+	msg, err = sr.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Event, check.Equals, events.SyncEvent)
+}
+
+func (s *StreamSuite) TestStreamNotAuthorized(c *check.C) {
+	resp := s.stream(context.Background(), c, "user1", "")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusBadRequest)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+}
@@ -0,0 +1,214 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package events streams the changes of the vault to the admin UI as server-sent events: the
+// devices that are signed, the sync status of the factories and the alerts, so the dashboards
+// are updated as they happen instead of polling the list methods. The admin and signing services
+// run in separate processes, so the changes are found in the datastore.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
+)
+
+// Names of the events
+const (
+	SigningEvent = "signing" // a signing log entry, with its ID as the event ID
+	SyncEvent    = "sync"    // the changed check-in status of a factory
+	AlertEvent   = "alert"   // an alert
+)
+
+// Kinds of the alerts
+const (
+	AlertFactorySilent   = "factory-silent"   // a factory has stopped checking in
+	AlertFactoryKeystore = "factory-keystore" // a factory reported that its keystore failed
+	AlertInstanceStale   = "instance-stale"   // a vault instance has missed its heartbeats
+)
+
+// Alert is a condition that needs the attention of the admins
+type Alert struct {
+	Kind       string    `json:"kind"`
+	InstanceID string    `json:"instance-id"`
+	Hostname   string    `json:"hostname"`
+	Message    string    `json:"message"`
+	Created    time.Time `json:"created"`
+}
+
+// Event is a change of the vault, with the ID that a reconnecting client resumes after
+type Event struct {
+	ID   string
+	Name string
+	Data interface{}
+}
+
+// Watcher finds the changes of the vault that are visible to a user since its last poll. The
+// first poll only records the state of the factories and the instances, which are not changes
+type Watcher struct {
+	user     datastore.User
+	settings config.Settings
+
+	lastID   int
+	started  bool
+	checkIns map[string]instance.CheckInStatus
+	stale    map[string]bool
+}
+
+// NewWatcher creates a watcher of the changes after the signing log entry with the ID. A negative
+// ID watches the entries that are signed from now on
+func NewWatcher(user datastore.User, settings config.Settings, afterID int) *Watcher {
+	return &Watcher{
+		user:     user,
+		settings: settings,
+		lastID:   afterID,
+		checkIns: map[string]instance.CheckInStatus{},
+		stale:    map[string]bool{},
+	}
+}
+
+// Poll returns the changes since the last poll, oldest first. The changes that are found are
+// returned with the error of a datastore query that failed
+func (wt *Watcher) Poll(db datastore.Datastore, now time.Time) ([]Event, error) {
+	events := []Event{}
+
+	signings, err := wt.pollSigningLog(db)
+	events = append(events, signings...)
+	if err != nil {
+		return events, err
+	}
+
+	checkIns, err := wt.pollCheckIns(db, now)
+	events = append(events, checkIns...)
+	if err != nil {
+		return events, err
+	}
+
+	instances, err := wt.pollInstances(db, now)
+	events = append(events, instances...)
+	if err != nil {
+		return events, err
+	}
+
+	wt.started = true
+	return events, nil
+}
+
+// pollSigningLog returns the signing log entries after the last one
+func (wt *Watcher) pollSigningLog(db datastore.Datastore) ([]Event, error) {
+	events := []Event{}
+
+	if wt.lastID < 0 {
+		logs, err := db.TailAllowedSigningLog(wt.user, -1, 1)
+		if err != nil {
+			return events, err
+		}
+		wt.lastID = 0
+		if len(logs) > 0 {
+			wt.lastID = logs[len(logs)-1].ID
+		}
+		return events, nil
+	}
+
+	logs, err := db.TailAllowedSigningLog(wt.user, wt.lastID, 0)
+	if err != nil {
+		return events, err
+	}
+	for _, l := range logs {
+		wt.lastID = l.ID
+		events = append(events, Event{ID: strconv.Itoa(l.ID), Name: SigningEvent, Data: l})
+	}
+	return events, nil
+}
+
+// pollCheckIns returns the factories whose check-in status has changed, with the alerts of the
+// factories that have gone silent or whose keystore has failed
+func (wt *Watcher) pollCheckIns(db datastore.Datastore, now time.Time) ([]Event, error) {
+	events := []Event{}
+
+	checkIns, err := db.ListFactoryCheckIns()
+	if err != nil {
+		return events, err
+	}
+
+	for _, c := range instance.CheckInStatuses(checkIns, wt.settings, now) {
+		prev, ok := wt.checkIns[c.InstanceID]
+		wt.checkIns[c.InstanceID] = c
+		if !wt.started {
+			continue
+		}
+
+		checkedIn := !ok || !prev.Received.Equal(c.Received)
+		if !checkedIn && prev.Silent == c.Silent {
+			continue
+		}
+		events = append(events, Event{Name: SyncEvent, Data: c})
+
+		if c.Silent && (!ok || !prev.Silent) {
+			events = append(events, Event{Name: AlertEvent, Data: Alert{
+				Kind:       AlertFactorySilent,
+				InstanceID: c.InstanceID,
+				Hostname:   c.Hostname,
+				Message:    fmt.Sprintf("The factory has not checked in since %s", c.Received.Format(time.RFC3339)),
+				Created:    now,
+			}})
+		}
+		if checkedIn && !c.KeystoreHealthy && (!ok || prev.KeystoreHealthy) {
+			events = append(events, Event{Name: AlertEvent, Data: Alert{
+				Kind:       AlertFactoryKeystore,
+				InstanceID: c.InstanceID,
+				Hostname:   c.Hostname,
+				Message:    fmt.Sprintf("The keystore of the factory failed: %s", c.KeystoreError),
+				Created:    now,
+			}})
+		}
+	}
+	return events, nil
+}
+
+// pollInstances returns the alerts of the vault instances that have missed their heartbeats
+func (wt *Watcher) pollInstances(db datastore.Datastore, now time.Time) ([]Event, error) {
+	events := []Event{}
+
+	instances, err := db.ListInstances()
+	if err != nil {
+		return events, err
+	}
+
+	for _, i := range instance.Statuses(instances, wt.settings, now) {
+		wasStale := wt.stale[i.InstanceID]
+		wt.stale[i.InstanceID] = i.Stale
+		if !wt.started || !i.Stale || wasStale {
+			continue
+		}
+
+		events = append(events, Event{Name: AlertEvent, Data: Alert{
+			Kind:       AlertInstanceStale,
+			InstanceID: i.InstanceID,
+			Hostname:   i.Hostname,
+			Message:    fmt.Sprintf("The %s instance has missed its heartbeats since %s", i.Mode, i.Heartbeat.Format(time.RFC3339)),
+			Created:    now,
+		}})
+	}
+	return events, nil
+}
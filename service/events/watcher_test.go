@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/events"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
+	check "gopkg.in/check.v1"
+)
+
+func TestEventsSuite(t *testing.T) { check.TestingT(t) }
+
+type WatcherSuite struct {
+	db   *datastoretest.DB
+	user datastore.User
+	now  time.Time
+}
+
+var _ = check.Suite(&WatcherSuite{})
+
+func (s *WatcherSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.user = s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+
+	s.now = time.Now().UTC()
+	s.db.CheckInFactory(datastore.FactoryCheckIn{InstanceID: "factory1", Hostname: "line1", KeystoreHealthy: true, Reported: s.now, Received: s.now})
+	s.db.RegisterInstance(datastore.Instance{InstanceID: "factory1", Role: datastore.InstanceRoleFactory, Mode: "signing"})
+
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config.Settings{}}
+}
+
+func (s *WatcherSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *WatcherSuite) TestPollSigningLog(c *check.C) {
+	// The first poll only records the latest entry
+	watcher := events.NewWatcher(s.user, config.Settings{}, -1)
+	evts, err := watcher.Poll(s.db, s.now)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "ash", "A2").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("other", "ash", "A3").Build())
+
+	// The entries in the user's accounts are returned once
+	evts, err = watcher.Poll(s.db, s.now)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Name, check.Equals, events.SigningEvent)
+	c.Assert(evts[0].Data.(datastore.SigningLog).SerialNumber, check.Equals, "A2")
+	c.Assert(len(evts[0].ID) > 0, check.Equals, true)
+
+	evts, err = watcher.Poll(s.db, s.now)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *WatcherSuite) TestPollResume(c *check.C) {
+	watcher := events.NewWatcher(s.user, config.Settings{}, 0)
+	evts, err := watcher.Poll(s.db, s.now)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Data.(datastore.SigningLog).SerialNumber, check.Equals, "A1")
+}
+
+func (s *WatcherSuite) TestPollCheckIns(c *check.C) {
+	watcher := events.NewWatcher(s.user, config.Settings{}, -1)
+	_, err := watcher.Poll(s.db, s.now)
+	c.Assert(err, check.IsNil)
+
+	// A check-in with a failed keystore is a sync event and an alert
+	later := s.now.Add(time.Minute)
+	s.db.CheckInFactory(datastore.FactoryCheckIn{InstanceID: "factory1", Hostname: "line1", KeystoreError: "TPM not found", Reported: later, Received: later})
+	evts, err := watcher.Poll(s.db, later)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 2)
+	c.Assert(evts[0].Name, check.Equals, events.SyncEvent)
+	c.Assert(evts[0].Data.(instance.CheckInStatus).KeystoreHealthy, check.Equals, false)
+	c.Assert(evts[1].Name, check.Equals, events.AlertEvent)
+	c.Assert(evts[1].Data.(events.Alert).Kind, check.Equals, events.AlertFactoryKeystore)
+	c.Assert(evts[1].Data.(events.Alert).Hostname, check.Equals, "line1")
+
+	// The factory goes silent, which is alerted once
+	silent := later.Add(instance.DefaultSilence + time.Minute)
+	evts, err = watcher.Poll(s.db, silent)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 3)
+	c.Assert(evts[0].Name, check.Equals, events.SyncEvent)
+	c.Assert(evts[0].Data.(instance.CheckInStatus).Silent, check.Equals, true)
+	c.Assert(evts[1].Data.(events.Alert).Kind, check.Equals, events.AlertFactorySilent)
+	c.Assert(evts[2].Data.(events.Alert).Kind, check.Equals, events.AlertInstanceStale)
+
+	evts, err = watcher.Poll(s.db, silent.Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *WatcherSuite) TestPollError(c *check.C) {
+	watcher := events.NewWatcher(s.user, config.Settings{}, -1)
+	_, err := watcher.Poll(&datastore.ErrorMockDB{}, s.now)
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,184 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package failover runs the signing service as one of an active/standby pair of vaults, so that
+// the failure of a single host does not stop the production line. The vaults share a Postgres
+// database, which holds the nonces and the signing log, and the active vault is the one that
+// holds the leader lock of the database. The standby vault rejects the signing requests and
+// takes the lock over, promoting itself, when the session of the active vault ends.
+package failover
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/keyreload"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DefaultInterval is the time between the checks of the leader lock
+const DefaultInterval = 5 * time.Second
+
+// Roles of the vault in the failover pair
+const (
+	Active  = "active"
+	Standby = "standby"
+)
+
+// Election holds the role of this vault in the failover pair
+type Election struct {
+	lock  sync.Mutex
+	held  datastore.LeaderLock
+	since time.Time
+
+	enabled func() bool
+	now     func() time.Time
+}
+
+// Status is the role of the vault in the failover pair
+type Status struct {
+	Enabled bool      `json:"enabled"`
+	Role    string    `json:"role"`
+	Since   time.Time `json:"since"`
+}
+
+// Signing is the election of the signing service. The service enables it from its config
+var Signing = New(ConfigEnabled(&config.Settings{}))
+
+// New creates an election, which starts as the standby vault when it is enabled
+func New(enabled func() bool) *Election {
+	return &Election{since: time.Now(), enabled: enabled, now: time.Now}
+}
+
+// ConfigEnabled returns the function that checks if the failover is enabled by the settings
+func ConfigEnabled(settings *config.Settings) func() bool {
+	return func() bool {
+		return settings.Failover
+	}
+}
+
+// Interval returns the time between the checks of the leader lock from the config
+func Interval(settings config.Settings) time.Duration {
+	if settings.FailoverInterval > 0 {
+		return time.Duration(settings.FailoverInterval) * time.Second
+	}
+	return DefaultInterval
+}
+
+// SetEnabled replaces the function that checks if the election is enabled
+func (e *Election) SetEnabled(enabled func() bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.enabled = enabled
+}
+
+// IsActive checks if this vault may sign. A vault without failover is always active
+func (e *Election) IsActive() bool {
+	return e.Status().Role == Active
+}
+
+// Status returns the role of this vault, and the time it took the role
+func (e *Election) Status() Status {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if !e.enabled() {
+		return Status{Role: Active, Since: e.since}
+	}
+	if e.held == nil {
+		return Status{Enabled: true, Role: Standby, Since: e.since}
+	}
+	return Status{Enabled: true, Role: Active, Since: e.since}
+}
+
+// Elect runs a round of the election. The active vault checks that it still holds the
+// leader lock, stepping down when it is lost, and the standby vault tries to take the
+// lock. It returns true when this vault has been promoted
+func (e *Election) Elect(ctx context.Context, db datastore.Datastore) (bool, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.held != nil {
+		err := e.held.Check(ctx)
+		if err == nil {
+			return false, nil
+		}
+
+		// Step down straight away, as the standby vault may take over once the session is gone
+		e.held.Release()
+		e.held = nil
+		e.since = e.now()
+		metrics.Increment(metrics.FailoverDemotions)
+		log.Message("FAILOVER", "demoted", err.Error())
+		return false, err
+	}
+
+	lock, acquired, err := db.WithContext(ctx).TryLeaderLock()
+	if err != nil {
+		log.Message("FAILOVER", "leader-lock", err.Error())
+		return false, err
+	}
+	if !acquired {
+		return false, nil
+	}
+
+	e.held = lock
+	e.since = e.now()
+	metrics.Increment(metrics.FailoverPromotions)
+	log.Infof("Failover: this vault is the active vault of the pair")
+	return true, nil
+}
+
+// Resign releases the leader lock, so the standby vault can take over
+func (e *Election) Resign() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.held == nil {
+		return nil
+	}
+	err := e.held.Release()
+	e.held = nil
+	e.since = e.now()
+	return err
+}
+
+// Run runs the election of the signing service periodically, until the context is done.
+// A promoted vault loads the signing-keys that were synced while it was the standby vault
+func Run(ctx context.Context, env *datastore.Env, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if promoted, _ := Signing.Elect(ctx, env.DB); promoted {
+			keyreload.Reload(ctx, env)
+		}
+
+		select {
+		case <-ctx.Done():
+			Signing.Resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package failover
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	check "gopkg.in/check.v1"
+)
+
+func TestFailoverSuite(t *testing.T) { check.TestingT(t) }
+
+type FailoverSuite struct{}
+
+var _ = check.Suite(&FailoverSuite{})
+
+func enabled() bool { return true }
+
+func (s *FailoverSuite) TestElect(c *check.C) {
+	db := datastoretest.New()
+	first, second := New(enabled), New(enabled)
+
+	// Both vaults start as the standby vault
+	c.Assert(first.IsActive(), check.Equals, false)
+	c.Assert(second.IsActive(), check.Equals, false)
+
+	// The first vault takes the leader lock
+	before := metrics.Value(metrics.FailoverPromotions)
+	promoted, err := first.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, true)
+	c.Assert(first.IsActive(), check.Equals, true)
+	c.Assert(metrics.Value(metrics.FailoverPromotions)-before, check.Equals, int64(1))
+
+	promoted, err = second.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, false)
+	c.Assert(second.IsActive(), check.Equals, false)
+
+	// The active vault keeps the lock
+	promoted, err = first.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, false)
+	c.Assert(first.IsActive(), check.Equals, true)
+
+	// The standby vault takes over when the active vault resigns
+	c.Assert(first.Resign(), check.IsNil)
+	c.Assert(first.IsActive(), check.Equals, false)
+
+	promoted, err = second.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, true)
+	c.Assert(second.Status().Role, check.Equals, Active)
+}
+
+func (s *FailoverSuite) TestElectLockLost(c *check.C) {
+	db := datastoretest.New()
+	first, second := New(enabled), New(enabled)
+
+	_, err := first.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(first.IsActive(), check.Equals, true)
+
+	// The session of the active vault ends e.g. its host fails, and the standby vault takes over
+	db.DropLeaderLock()
+	promoted, err := second.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, true)
+
+	// The former active vault steps down on its next round
+	before := metrics.Value(metrics.FailoverDemotions)
+	_, err = first.Elect(context.Background(), db)
+	c.Assert(err, check.Equals, datastore.ErrLeaderLockLost)
+	c.Assert(first.IsActive(), check.Equals, false)
+	c.Assert(metrics.Value(metrics.FailoverDemotions)-before, check.Equals, int64(1))
+
+	// ...and it cannot take the lock back from the new active vault
+	promoted, err = first.Elect(context.Background(), db)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, false)
+	c.Assert(second.IsActive(), check.Equals, true)
+}
+
+func (s *FailoverSuite) TestElectError(c *check.C) {
+	e := New(enabled)
+
+	promoted, err := e.Elect(context.Background(), &datastore.ErrorMockDB{})
+	c.Assert(err, check.NotNil)
+	c.Assert(promoted, check.Equals, false)
+	c.Assert(e.IsActive(), check.Equals, false)
+}
+
+func (s *FailoverSuite) TestDisabled(c *check.C) {
+	e := New(func() bool { return false })
+
+	// A vault without failover is always active
+	status := e.Status()
+	c.Assert(status.Enabled, check.Equals, false)
+	c.Assert(status.Role, check.Equals, Active)
+	c.Assert(e.IsActive(), check.Equals, true)
+}
+
+func (s *FailoverSuite) TestInterval(c *check.C) {
+	settings := config.Settings{}
+	c.Assert(Interval(settings), check.Equals, DefaultInterval)
+	enabled := ConfigEnabled(&settings)
+	c.Assert(enabled(), check.Equals, false)
+
+	settings = config.Settings{Failover: true, FailoverInterval: 2}
+	c.Assert(Interval(settings).Seconds(), check.Equals, float64(2))
+	c.Assert(enabled(), check.Equals, true)
+}
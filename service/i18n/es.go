@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package i18n
+
+// es is the Spanish catalog of the standard error messages
+var es = map[string]string{
+	"An unexpected error occurred":                                                 "Se produjo un error inesperado",
+	"The datastore or keystore did not respond in time":                            "La base de datos o el almacén de claves no respondió a tiempo",
+	"The datastore is failing. Please try again later":                             "La base de datos está fallando. Vuelva a intentarlo más tarde",
+	"Signing is paused for scheduled maintenance. Please try again later":          "La firma está en pausa por un mantenimiento programado. Vuelva a intentarlo más tarde",
+	"This vault is the standby vault. Please send the request to the active vault": "Esta bóveda es la bóveda en espera. Envíe la solicitud a la bóveda activa",
+	"Your user does not have permissions for the Signing Authority":                "Su usuario no tiene permisos para la autoridad de firma",
+	"This feature is not enabled for this account":                                 "Esta función no está habilitada para esta cuenta",
+	"Invalid record ID":                                                                      "ID de registro no válido",
+	"Invalid API key used":                                                                   "Se usó una clave de API no válida",
+	"Uninitialized POST data":                                                                "Datos POST no inicializados",
+	"Invalid data supplied":                                                                  "Los datos proporcionados no son válidos",
+	"No data supplied for signing":                                                           "No se proporcionaron datos para firmar",
+	"Error decoding JSON":                                                                    "Error al decodificar el JSON",
+	"The assertion type must be 'serial'":                                                    "El tipo de aserción debe ser 'serial'",
+	"The 2nd assertion type must be 'model'":                                                 "El tipo de la segunda aserción debe ser 'model'",
+	"Nonce is invalid or expired":                                                            "El nonce no es válido o ha caducado",
+	"Cannot find model with the matching brand and model":                                    "No se encuentra el modelo con la marca y el modelo indicados",
+	"Cannot find model with the selected ID":                                                 "No se encuentra el modelo con el ID seleccionado",
+	"Cannot find a matching model or sub-store model":                                        "No se encuentra un modelo o un modelo de sub-tienda coincidente",
+	"Cannot find sub-store mapping for the model":                                            "No se encuentra la asignación de sub-tienda del modelo",
+	"The station is not registered for the model":                                            "La estación no está registrada para el modelo",
+	"The factory is not authorized to sign for the model":                                    "La fábrica no está autorizada a firmar para el modelo",
+	"The model is linked with an inactive signing-key":                                       "El modelo está vinculado a una clave de firma inactiva",
+	"The model is linked with a compromised signing-key":                                     "El modelo está vinculado a una clave de firma comprometida",
+	"The account cannot be found":                                                            "No se encuentra la cuenta",
+	"The assertion is invalid":                                                               "La aserción no es válida",
+	"The assertion is not stored in the vault":                                               "La aserción no está almacenada en la bóveda",
+	"The keypair is invalid":                                                                 "El par de claves no es válido",
+	"Error fetching the signing-keys":                                                        "Error al obtener las claves de firma",
+	"Error fetching the signing-key":                                                         "Error al obtener la clave de firma",
+	"Error string the signing-key":                                                           "Error al guardar la clave de firma",
+	"The serial number is missing from both the header and body":                             "Falta el número de serie en la cabecera y en el cuerpo",
+	"The device-key is malformed or not accepted for the model":                              "La clave del dispositivo está mal formada o no se acepta para el modelo",
+	"The serial number is not accepted by the serial pipeline of the model":                  "El número de serie no es aceptado por el proceso de números de serie del modelo",
+	"The manufacture date is invalid or out of bounds":                                       "La fecha de fabricación no es válida o está fuera de los límites",
+	"The headers of the assertion format of the serial-request are invalid":                  "Las cabeceras del formato de aserción de la solicitud de serie no son válidas",
+	"The device manifest of the serial-request is invalid":                                   "El manifiesto del dispositivo de la solicitud de serie no es válido",
+	"The lifecycle state of the device does not allow it to be signed":                       "El estado del ciclo de vida del dispositivo no permite firmarlo",
+	"The device is quarantined and cannot be signed":                                         "El dispositivo está en cuarentena y no se puede firmar",
+	"Error converting the serial-request to a serial assertion":                              "Error al convertir la solicitud de serie en una aserción de serie",
+	"Error decoding the assertion":                                                           "Error al decodificar la aserción",
+	"Error checking the serial-request. Please try again later":                              "Error al comprobar la solicitud de serie. Vuelva a intentarlo más tarde",
+	"Error with the model assertion headers":                                                 "Error en las cabeceras de la aserción del modelo",
+	"Error with the system-user assertion":                                                   "Error en la aserción del usuario del sistema",
+	"The serial number and/or device-key have already been used to sign a device":            "El número de serie y/o la clave del dispositivo ya se han usado para firmar un dispositivo",
+	"Error retrieving the account assertion from the database":                               "Error al obtener la aserción de la cuenta de la base de datos",
+	"Error signing the assertion":                                                            "Error al firmar la aserción",
+	"The datastore debugging is only available to a superuser":                               "La depuración de la base de datos solo está disponible para un superusuario",
+	"The signed serial assertion violates the content policy of the vault":                   "La aserción de serie firmada infringe la política de contenido del vault",
+	"The signing log could not be written to the write-once storage. Please try again later": "No se pudo escribir el registro de firmas en el almacenamiento de una sola escritura. Vuelva a intentarlo más tarde",
+	"Error generating a nonce. Please try again later":                                       "Error al generar un nonce. Vuelva a intentarlo más tarde",
+	"The number of nonces requested is invalid":                                              "El número de nonces solicitados no es válido",
+	"Too many unused nonces have been issued for the API key":                                "Se han emitido demasiados nonces sin usar para la clave de API",
+	"The API key is temporarily banned from requesting nonces":                               "La clave de API tiene prohibido temporalmente solicitar nonces",
+	"Error fetching the dashboard summary":                                                   "Error al obtener el resumen del panel",
+	"The report period must be valid dates (YYYY-MM-DD) of up to a year":                     "El periodo del informe debe ser de fechas válidas (AAAA-MM-DD) de hasta un año",
+	"The report format must be 'json' or 'csv'":                                              "El formato del informe debe ser 'json' o 'csv'",
+	"Error fetching the production report":                                                   "Error al obtener el informe de producción",
+	"Error signing the production report":                                                    "Error al firmar el informe de producción",
+}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package i18n
+
+import (
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		lang   string
+	}{
+		{"", "en"},
+		{"es", "es"},
+		{"es-MX", "es"},
+		{"zh-CN,zh;q=0.9,en;q=0.8", "zh"},
+		{"fr-FR,fr;q=0.9,en;q=0.8", "en"},
+		{"fr, es;q=0.5", "es"},
+		{"en;q=0.9, es;q=0.5", "en"},
+		{"es;q=0.4, ZH;q=0.7", "zh"},
+		{"es;q=0", "en"},
+		{"*", "en"},
+	}
+
+	for _, tt := range tests {
+		if lang := Negotiate(tt.header); lang != tt.lang {
+			t.Errorf("%s: expected %s, got %s", tt.header, tt.lang, lang)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if message := Translate("es", "Invalid data supplied"); message != "Los datos proporcionados no son válidos" {
+		t.Errorf("Expected the Spanish message, got: %s", message)
+	}
+	if message := Translate("es", "MOCK error"); message != "MOCK error" {
+		t.Errorf("Expected the untranslated message, got: %s", message)
+	}
+	if message := Translate("en", "Invalid data supplied"); message != "Invalid data supplied" {
+		t.Errorf("Expected the default message, got: %s", message)
+	}
+}
+
+func TestCatalogs(t *testing.T) {
+	// All the catalogs translate the same messages
+	for _, lang := range Languages() {
+		for _, other := range Languages() {
+			for message, translated := range catalogs[lang] {
+				if len(translated) == 0 {
+					t.Errorf("%s: the translation of '%s' is empty", lang, message)
+				}
+				if _, ok := catalogs[other][message]; !ok {
+					t.Errorf("%s: the message '%s' is not translated", other, message)
+				}
+			}
+		}
+	}
+}
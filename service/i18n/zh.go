@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package i18n
+
+// zh is the Simplified Chinese catalog of the standard error messages
+var zh = map[string]string{
+	"An unexpected error occurred":                                                 "发生了意外错误",
+	"The datastore or keystore did not respond in time":                            "数据存储或密钥库未及时响应",
+	"The datastore is failing. Please try again later":                             "数据存储出现故障。请稍后重试",
+	"Signing is paused for scheduled maintenance. Please try again later":          "签名因计划维护而暂停。请稍后重试",
+	"This vault is the standby vault. Please send the request to the active vault": "此保管库是备用保管库。请将请求发送到活动保管库",
+	"Your user does not have permissions for the Signing Authority":                "您的用户没有该签名机构的权限",
+	"This feature is not enabled for this account":                                 "此账户未启用该功能",
+	"Invalid record ID":                                                                      "记录 ID 无效",
+	"Invalid API key used":                                                                   "使用了无效的 API 密钥",
+	"Uninitialized POST data":                                                                "POST 数据未初始化",
+	"Invalid data supplied":                                                                  "提供的数据无效",
+	"No data supplied for signing":                                                           "未提供要签名的数据",
+	"Error decoding JSON":                                                                    "JSON 解码错误",
+	"The assertion type must be 'serial'":                                                    "断言类型必须为 'serial'",
+	"The 2nd assertion type must be 'model'":                                                 "第二个断言的类型必须为 'model'",
+	"Nonce is invalid or expired":                                                            "Nonce 无效或已过期",
+	"Cannot find model with the matching brand and model":                                    "找不到与品牌和型号匹配的型号",
+	"Cannot find model with the selected ID":                                                 "找不到所选 ID 的型号",
+	"Cannot find a matching model or sub-store model":                                        "找不到匹配的型号或子商店型号",
+	"Cannot find sub-store mapping for the model":                                            "找不到该型号的子商店映射",
+	"The station is not registered for the model":                                            "该工位未注册到此型号",
+	"The factory is not authorized to sign for the model":                                    "该工厂无权为此型号签名",
+	"The model is linked with an inactive signing-key":                                       "该型号关联的签名密钥未激活",
+	"The model is linked with a compromised signing-key":                                     "该型号关联的签名密钥已泄露",
+	"The account cannot be found":                                                            "找不到该账户",
+	"The assertion is invalid":                                                               "断言无效",
+	"The assertion is not stored in the vault":                                               "保管库中未存储该断言",
+	"The keypair is invalid":                                                                 "密钥对无效",
+	"Error fetching the signing-keys":                                                        "获取签名密钥列表时出错",
+	"Error fetching the signing-key":                                                         "获取签名密钥时出错",
+	"Error string the signing-key":                                                           "保存签名密钥时出错",
+	"The serial number is missing from both the header and body":                             "标头和正文中均缺少序列号",
+	"The device-key is malformed or not accepted for the model":                              "设备密钥格式错误或不被此型号接受",
+	"The serial number is not accepted by the serial pipeline of the model":                  "序列号未通过此型号的序列号处理流程",
+	"The manufacture date is invalid or out of bounds":                                       "生产日期无效或超出范围",
+	"The headers of the assertion format of the serial-request are invalid":                  "序列请求的断言格式标头无效",
+	"The device manifest of the serial-request is invalid":                                   "序列请求的设备清单无效",
+	"The lifecycle state of the device does not allow it to be signed":                       "设备的生命周期状态不允许签名",
+	"The device is quarantined and cannot be signed":                                         "设备已被隔离，无法签名",
+	"Error converting the serial-request to a serial assertion":                              "将序列请求转换为序列断言时出错",
+	"Error decoding the assertion":                                                           "解码断言时出错",
+	"Error checking the serial-request. Please try again later":                              "检查序列请求时出错。请稍后重试",
+	"Error with the model assertion headers":                                                 "型号断言标头有误",
+	"Error with the system-user assertion":                                                   "系统用户断言有误",
+	"The serial number and/or device-key have already been used to sign a device":            "序列号和/或设备密钥已被用于为设备签名",
+	"Error retrieving the account assertion from the database":                               "从数据库获取账户断言时出错",
+	"Error signing the assertion":                                                            "签名断言时出错",
+	"The datastore debugging is only available to a superuser":                               "只有超级用户可以使用数据存储调试",
+	"The signed serial assertion violates the content policy of the vault":                   "已签名的序列断言违反了 Vault 的内容策略",
+	"The signing log could not be written to the write-once storage. Please try again later": "无法将签名日志写入一次写入存储。请稍后重试",
+	"Error generating a nonce. Please try again later":                                       "生成 nonce 时出错。请稍后重试",
+	"The number of nonces requested is invalid":                                              "请求的 nonce 数量无效",
+	"Too many unused nonces have been issued for the API key":                                "已为该 API 密钥签发过多未使用的 nonce",
+	"The API key is temporarily banned from requesting nonces":                               "该 API 密钥暂时被禁止请求 nonce",
+	"Error fetching the dashboard summary":                                                   "获取仪表板摘要时出错",
+	"The report period must be valid dates (YYYY-MM-DD) of up to a year":                     "报告期间必须是有效日期 (YYYY-MM-DD)，且不超过一年",
+	"The report format must be 'json' or 'csv'":                                              "报告格式必须为 'json' 或 'csv'",
+	"Error fetching the production report":                                                   "获取生产报告时出错",
+	"Error signing the production report":                                                    "签名生产报告时出错",
+}
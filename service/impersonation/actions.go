@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package impersonation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/usso"
+)
+
+// StartRequest is the JSON request to act as an admin of the brand. The first admin of the
+// brand is used when the username is not given
+type StartRequest struct {
+	Brand    string `json:"brand"`
+	Username string `json:"username"`
+}
+
+// StartResponse is the JSON response from the API method to act as an admin of a brand
+type StartResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	Username     string `json:"username"`
+	Brand        string `json:"brand"`
+}
+
+// ListResponse is the JSON response from the API audit log method
+type ListResponse struct {
+	Success      bool                   `json:"success"`
+	ErrorCode    string                 `json:"error_code"`
+	ErrorSubcode string                 `json:"error_subcode"`
+	ErrorMessage string                 `json:"message"`
+	Entries      []datastore.AuditEntry `json:"entries"`
+}
+
+// startHandler is the API method for a superuser to act as an admin of a brand. The session
+// token is replaced by a token of the brand admin, which identifies the superuser
+func (srv *Service) startHandler(w http.ResponseWriter, user datastore.User, req StartRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// The superuser permissions need user authentication
+	err := auth.CheckUserPermissions(user, datastore.Superuser, false, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if _, err := srv.DB.GetAccount(req.Brand); err != nil {
+		response.FormatStandardResponse(false, "error-impersonation", "", fmt.Sprintf("Cannot find the brand '%s'", req.Brand), w)
+		return
+	}
+
+	admin, err := srv.brandAdmin(req.Brand, req.Username)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-impersonation", "", err.Error(), w)
+		return
+	}
+
+	jwtToken, err := usso.NewImpersonationJWT(admin, user.Username, req.Brand, srv.Config.JwtSecret)
+	if err != nil {
+		log.Printf("Error creating the JWT: %v", err)
+		response.FormatStandardResponse(false, "error-impersonation", "", err.Error(), w)
+		return
+	}
+
+	// The start of the session is audited before the superuser can act as the brand admin
+	entry, err := srv.DB.CreateAuditEntry(datastore.AuditEntry{
+		Username: admin.Username, Impersonator: user.Username, Brand: req.Brand, Action: datastore.AuditImpersonationStart,
+	})
+	if err != nil {
+		response.FormatStandardResponse(false, "error-impersonation", "", err.Error(), w)
+		return
+	}
+	for _, url := range NotifyURLs(srv.Config, req.Brand) {
+		Notify(url, entry)
+	}
+
+	usso.AddJWTCookie(jwtToken, w)
+
+	// Return successful JSON response with the brand admin
+	w.WriteHeader(http.StatusOK)
+	formatStartResponse(admin.Username, req.Brand, w)
+}
+
+// stopHandler is the API method for a superuser to stop acting as an admin of a brand. The
+// session token is replaced by a token of the superuser
+func (srv *Service) stopHandler(w http.ResponseWriter, user datastore.User) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if len(user.Impersonator) == 0 {
+		response.FormatStandardResponse(false, "error-impersonation", "", "The user is not acting as a brand admin", w)
+		return
+	}
+
+	// The superuser may have been changed during the session
+	superuser, err := srv.DB.GetUserByUsername(user.Impersonator)
+	if err != nil || superuser.Role != datastore.Superuser {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	jwtToken, err := usso.NewUserJWT(superuser, srv.Config.JwtSecret)
+	if err != nil {
+		log.Printf("Error creating the JWT: %v", err)
+		response.FormatStandardResponse(false, "error-impersonation", "", err.Error(), w)
+		return
+	}
+
+	Audit(srv.DB, user, datastore.AuditImpersonationEnd, http.StatusOK)
+	usso.AddJWTCookie(jwtToken, w)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// listHandler is the API method to fetch the audit log, optionally of an impersonator
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, impersonator string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	entries, err := srv.DB.ListAuditLog(impersonator)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-audit-log", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the audit log
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(entries, w)
+}
+
+// brandAdmin finds the admin user of the brand, or the first one when the username is empty
+func (srv *Service) brandAdmin(brand, username string) (datastore.User, error) {
+	users, err := srv.DB.ListAccountUsers(brand)
+	if err != nil {
+		return datastore.User{}, err
+	}
+
+	for _, u := range users {
+		if u.Role == datastore.Admin && (len(username) == 0 || u.Username == username) {
+			return u, nil
+		}
+	}
+	if len(username) == 0 {
+		return datastore.User{}, fmt.Errorf("The brand '%s' has no admin user", brand)
+	}
+	return datastore.User{}, fmt.Errorf("The user '%s' is not an admin of the brand '%s'", username, brand)
+}
+
+func formatStartResponse(username, brand string, w http.ResponseWriter) error {
+	response := StartResponse{Success: true, Username: username, Brand: brand}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the impersonation response.")
+		return err
+	}
+	return nil
+}
+
+func formatListResponse(entries []datastore.AuditEntry, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Entries: entries}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the audit log response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package impersonation_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/impersonation"
+	"github.com/CanonicalLtd/serial-vault/service/model"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	check "gopkg.in/check.v1"
+)
+
+func TestImpersonationSuite(t *testing.T) { check.TestingT(t) }
+
+type ImpersonationSuite struct {
+	db       *datastoretest.DB
+	notified []string
+}
+
+var _ = check.Suite(&ImpersonationSuite{})
+
+func (s *ImpersonationSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	other := s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "viewer", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "partner", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{other}})
+	s.db.AddModel(datastoretest.NewModel("system", "alder").Build())
+	s.db.AddModel(datastoretest.NewModel("other", "ash").Build())
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true,
+		ImpersonationNotify: []config.ImpersonationNotify{{Brand: "system", NotifyURL: "https://hooks.example.com/system"}}}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
+
+	s.notified = nil
+	impersonation.Notify = func(url string, entry datastore.AuditEntry) {
+		c.Assert(entry.Action, check.Equals, datastore.AuditImpersonationStart)
+		s.notified = append(s.notified, url)
+	}
+}
+
+func (s *ImpersonationSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ImpersonationSuite) userToken(c *check.C, username string) string {
+	user, err := s.db.GetUserByUsername(username)
+	c.Assert(err, check.IsNil)
+	jwtToken, err := usso.NewUserJWT(user, datastore.Environ.Config.JwtSecret)
+	c.Assert(err, check.IsNil)
+	return jwtToken
+}
+
+func sendRequest(method, url string, data io.Reader, jwtToken string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func (s *ImpersonationSuite) start(c *check.C, brand, username, jwtToken string) (impersonation.StartResponse, string) {
+	data, _ := json.Marshal(impersonation.StartRequest{Brand: brand, Username: username})
+	w := sendRequest("POST", "/v1/impersonate", bytes.NewReader(data), jwtToken)
+
+	result := impersonation.StartResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == usso.JWTCookie {
+			return result, cookie.Value
+		}
+	}
+	return result, ""
+}
+
+func (s *ImpersonationSuite) listModels(c *check.C, jwtToken string) []datastore.Model {
+	w := sendRequest("GET", "/v1/models", nil, jwtToken)
+
+	result := model.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result.Models
+}
+
+func (s *ImpersonationSuite) listAuditLog(c *check.C, url string) impersonation.ListResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+	r.Header.Set("user", "root")
+	r.Header.Set("api-key", "ValidAPIKey")
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := impersonation.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ImpersonationSuite) TestImpersonation(c *check.C) {
+	rootToken := s.userToken(c, "root")
+	c.Assert(s.listModels(c, rootToken), check.HasLen, 2)
+
+	// The superuser acts as the admin of the brand, with the scope of the brand admin
+	result, jwtToken := s.start(c, "system", "", rootToken)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Username, check.Equals, "sv")
+	c.Assert(jwtToken, check.Not(check.Equals), "")
+	c.Assert(s.notified, check.DeepEquals, []string{"https://hooks.example.com/system"})
+
+	models := s.listModels(c, jwtToken)
+	c.Assert(models, check.HasLen, 1)
+	c.Assert(models[0].BrandID, check.Equals, "system")
+
+	// The brand admin cannot use the superuser methods
+	w := sendRequest("GET", "/v1/auditlog", nil, jwtToken)
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+
+	// The superuser stops acting as the brand admin
+	w = sendRequest("DELETE", "/v1/impersonate", nil, jwtToken)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	var rootCookie string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == usso.JWTCookie {
+			rootCookie = cookie.Value
+		}
+	}
+	c.Assert(s.listModels(c, rootCookie), check.HasLen, 2)
+
+	// The actions of the session are flagged with the superuser, and the superuser's own actions are not audited
+	entries := s.listAuditLog(c, "/api/auditlog?impersonator=root").Entries
+	c.Assert(entries, check.HasLen, 5)
+	actions := []string{}
+	for _, e := range entries {
+		c.Assert(e.Impersonator, check.Equals, "root")
+		c.Assert(e.Username, check.Equals, "sv")
+		c.Assert(e.Brand, check.Equals, "system")
+		actions = append(actions, e.Action)
+	}
+	c.Assert(actions, check.DeepEquals, []string{
+		"DELETE /v1/impersonate", datastore.AuditImpersonationEnd, "GET /v1/auditlog", "GET /v1/models", datastore.AuditImpersonationStart,
+	})
+	c.Assert(entries[2].Status, check.Equals, http.StatusBadRequest)
+	c.Assert(entries[3].Status, check.Equals, http.StatusOK)
+}
+
+func (s *ImpersonationSuite) TestImpersonationUser(c *check.C) {
+	rootToken := s.userToken(c, "root")
+
+	result, _ := s.start(c, "other", "partner", rootToken)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Username, check.Equals, "partner")
+	c.Assert(s.notified, check.HasLen, 0)
+
+	// Only the admins of the brand can be impersonated
+	result, _ = s.start(c, "system", "partner", rootToken)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorMessage, check.Equals, "The user 'partner' is not an admin of the brand 'system'")
+
+	result, _ = s.start(c, "system", "viewer", rootToken)
+	c.Assert(result.Success, check.Equals, false)
+
+	result, _ = s.start(c, "invalid", "", rootToken)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorMessage, check.Equals, "Cannot find the brand 'invalid'")
+}
+
+func (s *ImpersonationSuite) TestImpersonationNotSuperuser(c *check.C) {
+	result, jwtToken := s.start(c, "other", "partner", s.userToken(c, "sv"))
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-auth")
+	c.Assert(jwtToken, check.Equals, "")
+	entries, err := s.db.ListAuditLog("")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 0)
+
+	// The superuser is not acting as a brand admin
+	w := sendRequest("DELETE", "/v1/impersonate", nil, s.userToken(c, "root"))
+	result2, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result2.Success, check.Equals, false)
+	c.Assert(result2.ErrorCode, check.Equals, "error-impersonation")
+}
+
+func (s *ImpersonationSuite) TestImpersonationStream(c *check.C) {
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+	result, jwtToken := s.start(c, "system", "", s.userToken(c, "root"))
+	c.Assert(result.Success, check.Equals, true)
+
+	// The audited events of the brand are streamed to the superuser acting as its admin
+	server := httptest.NewServer(service.NewService(datastore.Environ).AdminRouter())
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r, _ := http.NewRequest("GET", server.URL+"/v1/events", nil)
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	r.Header.Set(stream.LastEventIDHeader, "0")
+	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, stream.ContentType)
+
+	msg, err := stream.NewReader(resp.Body).Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Data, check.Matches, `.*"A1".*`)
+}
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package impersonation lets a superuser act as an admin of a brand, to see the exact scope
+// of the brand admin when supporting them. The actions of the support session are recorded
+// in the audit log, flagged with the superuser
+package impersonation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// notifyTimeout is the limit for sending an audit log entry to a notification hook
+const notifyTimeout = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// Audit records an action of a superuser acting as a brand admin in the audit log
+func Audit(db datastore.Datastore, user datastore.User, action string, status int) {
+	entry := datastore.AuditEntry{
+		Username:     user.Username,
+		Impersonator: user.Impersonator,
+		Brand:        user.ImpersonatedBrand,
+		Action:       action,
+		Status:       status,
+	}
+	if _, err := db.CreateAuditEntry(entry); err != nil {
+		log.Errorf("Error auditing %s by %s as %s: %v", action, user.Impersonator, user.Username, err)
+	}
+}
+
+// NotifyURLs returns the notification hooks of the brand from the config
+func NotifyURLs(settings config.Settings, brand string) []string {
+	urls := []string{}
+	for _, n := range settings.ImpersonationNotify {
+		if len(n.Brand) == 0 || n.Brand == brand {
+			urls = append(urls, n.NotifyURL)
+		}
+	}
+	return urls
+}
+
+// Notify sends the audit log entry to a notification hook of the brand, in the background
+var Notify = func(url string, entry datastore.AuditEntry) {
+	if len(url) == 0 {
+		return
+	}
+
+	go func() {
+		if err := sendNotification(url, entry); err != nil {
+			log.Errorf("Error notifying the impersonation of %s: %v", entry.Brand, err)
+		}
+	}()
+}
+
+func sendNotification(url string, entry datastore.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the notification hook returned %s", resp.Status)
+	}
+	return nil
+}
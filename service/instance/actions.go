@@ -0,0 +1,193 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package instance
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Status is a registered instance, marked as stale when it has missed its heartbeats
+type Status struct {
+	datastore.Instance
+	Stale bool `json:"stale"`
+}
+
+// ListResponse is the JSON response from the API instances method
+type ListResponse struct {
+	Success      bool     `json:"success"`
+	ErrorCode    string   `json:"error_code"`
+	ErrorSubcode string   `json:"error_subcode"`
+	ErrorMessage string   `json:"message"`
+	Instances    []Status `json:"instances"`
+}
+
+// CheckInListResponse is the JSON response from the API factory check-ins method
+type CheckInListResponse struct {
+	Success      bool            `json:"success"`
+	ErrorCode    string          `json:"error_code"`
+	ErrorSubcode string          `json:"error_subcode"`
+	ErrorMessage string          `json:"message"`
+	CheckIns     []CheckInStatus `json:"checkins"`
+}
+
+// listHandler is the API method to fetch the registered instances
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	instances, err := srv.DB.ListInstances()
+	if err != nil {
+		response.FormatStandardResponse(false, "error-instances-json", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of instances
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(Statuses(instances, srv.Config, time.Now().UTC()), w)
+}
+
+// heartbeatHandler is the API method for a factory to register itself in the cloud registry
+func (srv *Service) heartbeatHandler(w http.ResponseWriter, user datastore.User, apiCall bool, instance datastore.Instance) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if err := datastore.ValidateInstance(instance); err != nil {
+		response.FormatStandardResponse(false, "error-validate-instance", "", err.Error(), w)
+		return
+	}
+
+	err = srv.DB.RegisterInstance(instance)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-registering-instance", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// checkInListHandler is the API method to fetch the latest check-in of each factory
+func (srv *Service) checkInListHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	checkIns, err := srv.DB.ListFactoryCheckIns()
+	if err != nil {
+		response.FormatStandardResponse(false, "error-checkins-json", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of check-ins
+	w.WriteHeader(http.StatusOK)
+	formatCheckInListResponse(CheckInStatuses(checkIns, srv.Config, time.Now().UTC()), w)
+}
+
+// checkInHandler is the API method for a factory to report its health to the cloud. The check-in
+// is signed with the API key of the sync user, so it cannot be altered in transit
+func (srv *Service) checkInHandler(w http.ResponseWriter, user datastore.User, apiCall bool, apiKey string, body []byte, signature string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(body) == 0 {
+		response.FormatStandardResponse(false, "error-checkin-data", "", "No check-in data supplied.", w)
+		return
+	}
+
+	if !verifyCheckIn(body, apiKey, signature) {
+		response.FormatStandardResponse(false, "error-checkin-signature", "", "The check-in signature is invalid.", w)
+		return
+	}
+
+	checkIn := datastore.FactoryCheckIn{}
+	if err := json.Unmarshal(body, &checkIn); err != nil {
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	// The identity and arrival of the check-in are set by the cloud, not the factory
+	checkIn.Username = user.Username
+	checkIn.Received = time.Now().UTC()
+	checkIn.ClockSkew = int(checkIn.Received.Sub(checkIn.Reported) / time.Second)
+
+	if err := datastore.ValidateFactoryCheckIn(checkIn); err != nil {
+		response.FormatStandardResponse(false, "error-validate-checkin", "", err.Error(), w)
+		return
+	}
+
+	err = srv.DB.CheckInFactory(checkIn)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-recording-checkin", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func formatCheckInListResponse(checkIns []CheckInStatus, w http.ResponseWriter) error {
+	response := CheckInListResponse{Success: true, CheckIns: checkIns}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the check-ins response.")
+		return err
+	}
+	return nil
+}
+
+func formatListResponse(instances []Status, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Instances: instances}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the instances response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package instance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// CheckInSignatureHeader is the request header with the signature of a factory check-in
+const CheckInSignatureHeader = "X-Checkin-Signature"
+
+// DefaultSilence is the time without a check-in before a factory is reported as silent,
+// which allows for a couple of missed hourly syncs
+const DefaultSilence = 3 * time.Hour
+
+// CheckInStatus is the latest check-in of a factory, marked as silent when it has stopped checking in
+type CheckInStatus struct {
+	datastore.FactoryCheckIn
+	Silent bool `json:"silent"`
+}
+
+// Silence returns the time without a check-in before a factory is silent from the config
+func Silence(settings config.Settings) time.Duration {
+	if settings.FactoryCheckInSilence > 0 {
+		return time.Duration(settings.FactoryCheckInSilence) * time.Second
+	}
+	return DefaultSilence
+}
+
+// SignCheckIn signs the body of a check-in with the sync credentials of the factory
+func SignCheckIn(body []byte, apiKey string) string {
+	mac := hmac.New(sha256.New, []byte("factory-checkin:"+apiKey))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCheckIn checks the signature of a check-in body
+func verifyCheckIn(body []byte, apiKey, signature string) bool {
+	return hmac.Equal([]byte(SignCheckIn(body, apiKey)), []byte(signature))
+}
+
+// CheckInStatuses marks the check-ins of the factories that have stopped checking in
+func CheckInStatuses(checkIns []datastore.FactoryCheckIn, settings config.Settings, now time.Time) []CheckInStatus {
+	silence := Silence(settings)
+	statuses := []CheckInStatus{}
+	for _, c := range checkIns {
+		statuses = append(statuses, CheckInStatus{FactoryCheckIn: c, Silent: silent(c, now, silence)})
+	}
+	return statuses
+}
+
+// silent checks if the factory has not checked in for the silence period
+func silent(checkIn datastore.FactoryCheckIn, now time.Time, silence time.Duration) bool {
+	return now.Sub(checkIn.Received) > silence
+}
@@ -0,0 +1,250 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package instance_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func TestInstanceSuite(t *testing.T) { check.TestingT(t) }
+
+type InstanceSuite struct {
+	db *datastoretest.DB
+}
+
+var _ = check.Suite(&InstanceSuite{})
+
+func (s *InstanceSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "sync", APIKey: "ValidAPIKey", Role: datastore.SyncUser})
+	s.db.AddUser(datastore.User{Username: "user", APIKey: "ValidAPIKey", Role: datastore.Standard})
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true, InstanceID: "vault-1", Version: "2.4-6"}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+}
+
+func (s *InstanceSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func sendAdminAPIRequest(method, url string, data io.Reader, username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func (s *InstanceSuite) listInstances(c *check.C, username string) instance.ListResponse {
+	w := sendAdminAPIRequest("GET", "/api/instances", nil, username)
+
+	result := instance.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *InstanceSuite) TestAPIListHandler(c *check.C) {
+	err := instance.Heartbeat(context.Background(), datastore.Environ, "signing")
+	c.Assert(err, check.IsNil)
+	err = instance.Heartbeat(context.Background(), datastore.Environ, "signing")
+	c.Assert(err, check.IsNil)
+
+	result := s.listInstances(c, "root")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Instances, check.HasLen, 1)
+	c.Assert(result.Instances[0].InstanceID, check.Equals, "vault-1")
+	c.Assert(result.Instances[0].Role, check.Equals, datastore.InstanceRoleCloud)
+	c.Assert(result.Instances[0].Mode, check.Equals, "signing")
+	c.Assert(result.Instances[0].Version, check.Equals, "2.4-6")
+	c.Assert(result.Instances[0].Stale, check.Equals, false)
+
+	for _, username := range []string{"sync", "user", ""} {
+		result = s.listInstances(c, username)
+		c.Assert(result.Success, check.Equals, false)
+	}
+}
+
+func (s *InstanceSuite) TestAPIListHandlerStale(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+
+	result := s.listInstances(c, "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Instances, check.HasLen, 2)
+	c.Assert(result.Instances[0].Stale, check.Equals, false)
+	c.Assert(result.Instances[1].Stale, check.Equals, true)
+}
+
+func (s *InstanceSuite) TestAPIHeartbeatHandler(c *check.C) {
+	tests := []struct {
+		Instance datastore.Instance
+		Username string
+		Success  bool
+	}{
+		{datastore.Instance{InstanceID: "factory-1", Role: datastore.InstanceRoleFactory, Mode: "sync"}, "sync", true},
+		{datastore.Instance{InstanceID: "factory-1", Role: datastore.InstanceRoleFactory, Mode: "sync", Version: "2.4-6"}, "sync", true},
+		{datastore.Instance{InstanceID: "factory-2", Role: "laptop", Mode: "sync"}, "sync", false},
+		{datastore.Instance{Role: datastore.InstanceRoleFactory, Mode: "sync"}, "sync", false},
+		{datastore.Instance{InstanceID: "factory-2", Role: datastore.InstanceRoleFactory, Mode: "sync"}, "user", false},
+		{datastore.Instance{InstanceID: "factory-2", Role: datastore.InstanceRoleFactory, Mode: "sync"}, "", false},
+	}
+
+	for _, t := range tests {
+		t.Instance.Started = time.Now().UTC()
+		data, _ := json.Marshal(t.Instance)
+		w := sendAdminAPIRequest("POST", "/api/instances/heartbeat", bytes.NewReader(data), t.Username)
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+
+	instances, err := s.db.ListInstances()
+	c.Assert(err, check.IsNil)
+	c.Assert(instances, check.HasLen, 1)
+	c.Assert(instances[0].InstanceID, check.Equals, "factory-1")
+	c.Assert(instances[0].Version, check.Equals, "2.4-6")
+}
+
+func (s *InstanceSuite) TestAPIHeartbeatHandlerInvalid(c *check.C) {
+	w := sendAdminAPIRequest("POST", "/api/instances/heartbeat", bytes.NewReader(nil), "sync")
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-instance-data")
+}
+
+func sendCheckIn(checkIn datastore.FactoryCheckIn, username, signingKey string) response.StandardResponse {
+	data, _ := json.Marshal(checkIn)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/instances/checkin", bytes.NewReader(data))
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+	r.Header.Set(instance.CheckInSignatureHeader, instance.SignCheckIn(data, signingKey))
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result, _ := response.ParseStandardResponse(w)
+	return result
+}
+
+func (s *InstanceSuite) listCheckIns(c *check.C, username string) instance.CheckInListResponse {
+	w := sendAdminAPIRequest("GET", "/api/instances/checkins", nil, username)
+
+	result := instance.CheckInListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *InstanceSuite) TestAPICheckInHandler(c *check.C) {
+	reported := time.Now().UTC().Add(-time.Minute)
+	checkIn := datastore.FactoryCheckIn{InstanceID: "factory-1", Version: "2.4-6", PendingSigningLogs: 5, KeystoreHealthy: true, Reported: reported}
+
+	tests := []struct {
+		CheckIn    datastore.FactoryCheckIn
+		Username   string
+		SigningKey string
+		Success    bool
+		ErrorCode  string
+	}{
+		{checkIn, "sync", "ValidAPIKey", true, ""},
+		{checkIn, "sync", "ValidAPIKey", false, "error-recording-checkin"},
+		{checkIn, "sync", "OtherAPIKey", false, "error-checkin-signature"},
+		{checkIn, "user", "ValidAPIKey", false, "error-auth"},
+		{datastore.FactoryCheckIn{Reported: reported}, "sync", "ValidAPIKey", false, "error-validate-checkin"},
+		{datastore.FactoryCheckIn{InstanceID: "factory-1"}, "sync", "ValidAPIKey", false, "error-validate-checkin"},
+	}
+
+	for _, t := range tests {
+		result := sendCheckIn(t.CheckIn, t.Username, t.SigningKey)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+
+	checkIns, err := s.db.ListFactoryCheckIns()
+	c.Assert(err, check.IsNil)
+	c.Assert(checkIns, check.HasLen, 1)
+	c.Assert(checkIns[0].Username, check.Equals, "sync")
+	c.Assert(checkIns[0].PendingSigningLogs, check.Equals, 5)
+	c.Assert(checkIns[0].ClockSkew >= 60, check.Equals, true)
+
+	// A newer check-in replaces the last one
+	checkIn.Reported = time.Now().UTC()
+	checkIn.PendingSigningLogs = 0
+	result := sendCheckIn(checkIn, "sync", "ValidAPIKey")
+	c.Assert(result.Success, check.Equals, true)
+
+	checkIns, err = s.db.ListFactoryCheckIns()
+	c.Assert(err, check.IsNil)
+	c.Assert(checkIns, check.HasLen, 1)
+	c.Assert(checkIns[0].PendingSigningLogs, check.Equals, 0)
+}
+
+func (s *InstanceSuite) TestAPICheckInHandlerInvalid(c *check.C) {
+	w := sendAdminAPIRequest("POST", "/api/instances/checkin", bytes.NewReader(nil), "sync")
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-checkin-data")
+}
+
+func (s *InstanceSuite) TestAPICheckInsHandler(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+
+	result := s.listCheckIns(c, "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.CheckIns, check.HasLen, 2)
+	c.Assert(result.CheckIns[0].Silent, check.Equals, false)
+	c.Assert(result.CheckIns[1].Silent, check.Equals, true)
+	c.Assert(result.CheckIns[1].KeystoreHealthy, check.Equals, false)
+
+	// A longer silence period hides the factory that has not checked in
+	datastore.Environ.Config.FactoryCheckInSilence = 100 * 3600
+	result = s.listCheckIns(c, "sv")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.CheckIns[1].Silent, check.Equals, false)
+
+	for _, username := range []string{"sync", "user", ""} {
+		result = s.listCheckIns(c, username)
+		c.Assert(result.Success, check.Equals, false)
+	}
+}
@@ -0,0 +1,103 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package instance registers the running vault in the instance registry, with a periodic
+// heartbeat, so that operators can see the fleet of vault instances
+package instance
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DefaultInterval is the time between the heartbeats of an instance
+const DefaultInterval = time.Minute
+
+// staleHeartbeats is the number of missed heartbeats before an instance is reported as stale
+const staleHeartbeats = 3
+
+// started is the time that this instance started
+var started = time.Now().UTC()
+
+// Interval returns the time between the heartbeats from the config
+func Interval(settings config.Settings) time.Duration {
+	if settings.InstanceHeartbeatInterval > 0 {
+		return time.Duration(settings.InstanceHeartbeatInterval) * time.Second
+	}
+	return DefaultInterval
+}
+
+// Self describes this instance, running the service mode e.g. signing, admin, sync. The role
+// defaults to the factory role for a sqlite database or a failover pair
+func Self(env *datastore.Env, mode string) datastore.Instance {
+	hostname, _ := os.Hostname()
+	return datastore.Instance{
+		InstanceID: env.InstanceID(),
+		Hostname:   hostname,
+		Version:    env.Config.Version,
+		Role:       env.InstanceRole(),
+		Mode:       mode,
+		Started:    started,
+	}
+}
+
+// Heartbeat registers this instance, updating its heartbeat
+func Heartbeat(ctx context.Context, env *datastore.Env, mode string) error {
+	err := env.DB.WithContext(ctx).RegisterInstance(Self(env, mode))
+	if err != nil {
+		log.Message("INSTANCE", "register-instance", err.Error())
+	}
+	return err
+}
+
+// Run registers this instance and sends the heartbeats periodically, until the context is done
+func Run(ctx context.Context, env *datastore.Env, mode string, interval time.Duration) {
+	Heartbeat(ctx, env, mode)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Heartbeat(ctx, env, mode)
+		}
+	}
+}
+
+// Statuses marks the registered instances that have missed their heartbeats
+func Statuses(instances []datastore.Instance, settings config.Settings, now time.Time) []Status {
+	statuses := []Status{}
+	for _, i := range instances {
+		statuses = append(statuses, Status{Instance: i, Stale: stale(i, settings, now)})
+	}
+	return statuses
+}
+
+// stale checks if the instance has missed its heartbeats
+func stale(instance datastore.Instance, settings config.Settings, now time.Time) bool {
+	return now.Sub(instance.Heartbeat) > staleHeartbeats*Interval(settings)
+}
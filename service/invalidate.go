@@ -0,0 +1,40 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/service/invalidation"
+	"github.com/gorilla/mux"
+)
+
+// invalidates broadcasts the change of a model, signing-key or substore to the vault instances,
+// when the request succeeds, so they drop their cached copies
+func (srv *Service) invalidates(kind string, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		inner.ServeHTTP(sw, r)
+
+		if sw.Status() >= http.StatusOK && sw.Status() < http.StatusMultipleChoices {
+			invalidation.Publish(kind, mux.Vars(r)["id"])
+		}
+	})
+}
@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/invalidation"
+	"github.com/gorilla/mux"
+	check "gopkg.in/check.v1"
+)
+
+type InvalidateSuite struct {
+	srv *Service
+	bus *recordingBus
+}
+
+var _ = check.Suite(&InvalidateSuite{})
+
+// recordingBus records the published events
+type recordingBus struct {
+	events []invalidation.Event
+}
+
+func (b *recordingBus) Publish(event invalidation.Event) error {
+	b.events = append(b.events, event)
+	return nil
+}
+
+func (b *recordingBus) Listen(ctx context.Context, handler invalidation.Handler) error {
+	return nil
+}
+
+func (s *InvalidateSuite) SetUpTest(c *check.C) {
+	s.srv = NewService(&datastore.Env{DB: datastoretest.New(), Config: config.Settings{Version: "1.0"}})
+	s.bus = &recordingBus{}
+	invalidation.Use(s.bus)
+}
+
+func (s *InvalidateSuite) TearDownTest(c *check.C) {
+	invalidation.Use(invalidation.NewLocalBus())
+}
+
+func (s *InvalidateSuite) send(code int) {
+	router := mux.NewRouter()
+	router.Handle("/v1/models/{id:[0-9]+}", s.srv.invalidates(invalidation.Model, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))).Methods("PUT")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("PUT", "/v1/models/42", nil)
+	router.ServeHTTP(w, r)
+}
+
+func (s *InvalidateSuite) TestInvalidates(c *check.C) {
+	s.send(http.StatusOK)
+
+	c.Assert(s.bus.events, check.HasLen, 1)
+	c.Assert(s.bus.events[0].Kind, check.Equals, invalidation.Model)
+	c.Assert(s.bus.events[0].ID, check.Equals, "42")
+}
+
+func (s *InvalidateSuite) TestInvalidatesFailedRequest(c *check.C) {
+	s.send(http.StatusBadRequest)
+	c.Assert(s.bus.events, check.HasLen, 0)
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package invalidation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/lib/pq"
+)
+
+// Limits of the reconnection of the Postgres listener, and the time between its pings
+const (
+	minReconnectInterval = 10 * time.Second
+	maxReconnectInterval = time.Minute
+	pingInterval         = 90 * time.Second
+)
+
+// localQueue is the number of the events that are queued by the local bus
+const localQueue = 100
+
+// ErrQueueFull is returned when the local bus cannot queue any more events
+var ErrQueueFull = errors.New("The queue of the invalidation events is full")
+
+// localBus passes the events within the process
+type localBus struct {
+	events chan Event
+}
+
+// NewLocalBus creates the bus of a single instance
+func NewLocalBus() Bus {
+	return &localBus{events: make(chan Event, localQueue)}
+}
+
+// Publish queues the event for the listener of the process
+func (b *localBus) Publish(event Event) error {
+	select {
+	case b.events <- event:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Listen passes the queued events to the handler, until the context is done
+func (b *localBus) Listen(ctx context.Context, handler Handler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-b.events:
+			handler(event)
+		}
+	}
+}
+
+// postgresBus broadcasts the events with the notifications of the shared Postgres database
+type postgresBus struct {
+	db         datastore.Datastore
+	dataSource string
+}
+
+// NewPostgresBus creates the bus of the instances that share the Postgres database
+func NewPostgresBus(db datastore.Datastore, dataSource string) Bus {
+	return &postgresBus{db: db, dataSource: dataSource}
+}
+
+// Publish sends the event on the invalidation channel of the database
+func (b *postgresBus) Publish(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.db.NotifyInvalidation(string(payload))
+}
+
+// Listen passes the notifications of the invalidation channel to the handler, until the context
+// is done. The listener reconnects when the connection is lost, and the events that were sent
+// meanwhile are missed, so all the kinds are invalidated
+func (b *postgresBus) Listen(ctx context.Context, handler Handler) error {
+	listener := pq.NewListener(b.dataSource, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Message("INVALIDATION", "listener", err.Error())
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(datastore.InvalidationChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				handler(Event{Kind: All})
+				continue
+			}
+			event, err := decodeEvent(n.Extra)
+			if err != nil {
+				metrics.Increment(metrics.InvalidationErrors)
+				log.Message("INVALIDATION", "decode", err.Error())
+				continue
+			}
+			handler(event)
+		case <-time.After(pingInterval):
+			go listener.Ping()
+		}
+	}
+}
+
+// decodeEvent decodes the payload of a notification
+func decodeEvent(payload string) (Event, error) {
+	var event Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return event, err
+	}
+	if len(event.Kind) == 0 {
+		return event, errors.New("The invalidation event has no kind")
+	}
+	return event, nil
+}
@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package invalidation broadcasts the changes of the models, signing-keys and substores to the
+// vault instances, so that they drop their cached copies without waiting for the next reload.
+// The events are sent with Postgres NOTIFY on the shared database and each instance listens on
+// the invalidation channel. The bus is pluggable, so another pub/sub can replace Postgres
+package invalidation
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Kinds of the invalidation events
+const (
+	Model    = "model"
+	Keypair  = "keypair"
+	Substore = "substore"
+
+	// All invalidates the cached copies of every kind, when the events may have been missed
+	All = "all"
+)
+
+// Event is the change of a model, signing-key or substore, identified by the ID of its record
+type Event struct {
+	Kind     string `json:"kind"`
+	ID       string `json:"id,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// Handler drops the cached copies that are invalidated by the event
+type Handler func(event Event)
+
+// Bus broadcasts the invalidation events to the vault instances
+type Bus interface {
+	// Publish sends the event to the instances, including this one
+	Publish(event Event) error
+	// Listen passes the events of the instances to the handler, until the context is done
+	Listen(ctx context.Context, handler Handler) error
+}
+
+var bus Bus = NewLocalBus()
+
+var handlers = struct {
+	sync.RWMutex
+	kinds map[string][]Handler
+}{kinds: map[string][]Handler{}}
+
+// Open returns the bus for the database of the environment. The local sqlite database of the factory
+// is not shared, so its events stay in the process
+func Open(env *datastore.Env) Bus {
+	if env.Config.Driver == "sqlite3" {
+		return NewLocalBus()
+	}
+	return NewPostgresBus(env.DB, env.Config.DataSource)
+}
+
+// Use sets the bus that the events are published and received on
+func Use(b Bus) {
+	bus = b
+}
+
+// Subscribe registers the handler for the events of the kind
+func Subscribe(kind string, handler Handler) {
+	handlers.Lock()
+	defer handlers.Unlock()
+	handlers.kinds[kind] = append(handlers.kinds[kind], handler)
+}
+
+// Reset removes the handlers of all kinds
+func Reset() {
+	handlers.Lock()
+	defer handlers.Unlock()
+	handlers.kinds = map[string][]Handler{}
+}
+
+// Publish broadcasts the change of the record to the instances
+func Publish(kind, id string) error {
+	hostname, _ := os.Hostname()
+
+	if err := bus.Publish(Event{Kind: kind, ID: id, Hostname: hostname}); err != nil {
+		metrics.Increment(metrics.InvalidationErrors)
+		log.Message("INVALIDATION", "publish", err.Error())
+		return err
+	}
+	metrics.Increment(metrics.InvalidationsSent)
+	return nil
+}
+
+// Run passes the events of the instances to the subscribed handlers, until the context is done
+func Run(ctx context.Context) {
+	if err := bus.Listen(ctx, Dispatch); err != nil {
+		metrics.Increment(metrics.InvalidationErrors)
+		log.Message("INVALIDATION", "listen", err.Error())
+	}
+}
+
+// Dispatch runs the handlers that are subscribed to the kind of the event. The events of all
+// kinds run every handler once
+func Dispatch(event Event) {
+	metrics.Increment(metrics.InvalidationsHandled)
+
+	handlers.RLock()
+	var matched []Handler
+	for kind, hh := range handlers.kinds {
+		if event.Kind == All || event.Kind == kind {
+			matched = append(matched, hh...)
+		}
+	}
+	handlers.RUnlock()
+
+	for _, h := range matched {
+		h(event)
+	}
+}
@@ -0,0 +1,115 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package invalidation_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/invalidation"
+	check "gopkg.in/check.v1"
+)
+
+func TestInvalidationSuite(t *testing.T) { check.TestingT(t) }
+
+type InvalidationSuite struct{}
+
+var _ = check.Suite(&InvalidationSuite{})
+
+func (s *InvalidationSuite) SetUpTest(c *check.C) {
+	invalidation.Reset()
+	invalidation.Use(invalidation.NewLocalBus())
+}
+
+func (s *InvalidationSuite) TearDownTest(c *check.C) {
+	invalidation.Reset()
+	invalidation.Use(invalidation.NewLocalBus())
+}
+
+func (s *InvalidationSuite) TestDispatch(c *check.C) {
+	var models, keypairs []invalidation.Event
+	invalidation.Subscribe(invalidation.Model, func(e invalidation.Event) { models = append(models, e) })
+	invalidation.Subscribe(invalidation.Keypair, func(e invalidation.Event) { keypairs = append(keypairs, e) })
+
+	invalidation.Dispatch(invalidation.Event{Kind: invalidation.Keypair, ID: "3"})
+	c.Assert(models, check.HasLen, 0)
+	c.Assert(keypairs, check.DeepEquals, []invalidation.Event{{Kind: invalidation.Keypair, ID: "3"}})
+
+	invalidation.Dispatch(invalidation.Event{Kind: invalidation.Substore, ID: "5"})
+	c.Assert(models, check.HasLen, 0)
+	c.Assert(keypairs, check.HasLen, 1)
+
+	invalidation.Dispatch(invalidation.Event{Kind: invalidation.All})
+	c.Assert(models, check.HasLen, 1)
+	c.Assert(keypairs, check.HasLen, 2)
+}
+
+func (s *InvalidationSuite) TestPublishLocal(c *check.C) {
+	received := make(chan invalidation.Event, 1)
+	invalidation.Subscribe(invalidation.Substore, func(e invalidation.Event) { received <- e })
+
+	c.Assert(invalidation.Publish(invalidation.Substore, "7"), check.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go invalidation.Run(ctx)
+
+	select {
+	case e := <-received:
+		c.Assert(e.Kind, check.Equals, invalidation.Substore)
+		c.Assert(e.ID, check.Equals, "7")
+	case <-time.After(5 * time.Second):
+		c.Fatal("The invalidation event was not received")
+	}
+}
+
+func (s *InvalidationSuite) TestPublishLocalQueueFull(c *check.C) {
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = invalidation.Publish(invalidation.Model, "1")
+	}
+	c.Assert(err, check.Equals, invalidation.ErrQueueFull)
+}
+
+func (s *InvalidationSuite) TestPublishPostgres(c *check.C) {
+	db := datastoretest.New()
+	datastore.Environ = &datastore.Env{DB: db, Config: config.Settings{Driver: "postgres"}}
+	invalidation.Use(invalidation.Open(datastore.Environ))
+
+	c.Assert(invalidation.Publish(invalidation.Keypair, "9"), check.IsNil)
+
+	c.Assert(db.Invalidations(), check.HasLen, 1)
+	var e invalidation.Event
+	c.Assert(json.Unmarshal([]byte(db.Invalidations()[0]), &e), check.IsNil)
+	c.Assert(e.Kind, check.Equals, invalidation.Keypair)
+	c.Assert(e.ID, check.Equals, "9")
+}
+
+func (s *InvalidationSuite) TestPublishPostgresError(c *check.C) {
+	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}, Config: config.Settings{Driver: "postgres"}}
+	invalidation.Use(invalidation.Open(datastore.Environ))
+
+	c.Assert(invalidation.Publish(invalidation.Keypair, "9"), check.NotNil)
+}
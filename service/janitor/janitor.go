@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package janitor removes the expired device nonces and serial replays in the background, so
+// the signing requests do not have to scan the tables for them
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DefaultInterval is the time between the purges of the expired nonces
+const DefaultInterval = time.Minute
+
+// Interval returns the time between the purges from the config
+func Interval(settings config.Settings) time.Duration {
+	if settings.NonceJanitorInterval > 0 {
+		return time.Duration(settings.NonceJanitorInterval) * time.Second
+	}
+	return DefaultInterval
+}
+
+// Run purges the expired nonces periodically, until the context is done
+func Run(ctx context.Context, env *datastore.Env, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Purge(ctx, env)
+			PurgeSerialReplays(ctx, env)
+		}
+	}
+}
+
+// Purge removes the expired nonces, returning the number that were removed
+func Purge(ctx context.Context, env *datastore.Env) (int, error) {
+	purged, err := env.DB.WithContext(ctx).DeleteExpiredDeviceNonces()
+	if err != nil {
+		metrics.Increment(metrics.NoncePurgeErrors)
+		log.Message("JANITOR", "delete-expired-nonces", err.Error())
+		return 0, err
+	}
+
+	metrics.Add(metrics.NoncesPurged, int64(purged))
+	return purged, nil
+}
+
+// PurgeSerialReplays removes the serial assertions of the serial-requests that were signed before
+// the replay window, returning the number that were removed
+func PurgeSerialReplays(ctx context.Context, env *datastore.Env) (int, error) {
+	window := datastore.SerialReplayWindow(env.Config)
+	if window == 0 {
+		return 0, nil
+	}
+
+	purged, err := env.DB.WithContext(ctx).DeleteExpiredSerialReplays(window)
+	if err != nil {
+		log.Message("JANITOR", "delete-expired-serial-replays", err.Error())
+		return 0, err
+	}
+
+	metrics.Add(metrics.SerialReplaysPurged, int64(purged))
+	return purged, nil
+}
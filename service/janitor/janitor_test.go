@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package janitor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/janitor"
+	check "gopkg.in/check.v1"
+)
+
+func TestJanitorSuite(t *testing.T) { check.TestingT(t) }
+
+type JanitorSuite struct {
+	env *datastore.Env
+}
+
+var _ = check.Suite(&JanitorSuite{})
+
+func (s *JanitorSuite) SetUpTest(c *check.C) {
+	s.env = &datastore.Env{DB: &datastore.MockDB{}}
+}
+
+func (s *JanitorSuite) TestPurge(c *check.C) {
+	db := datastoretest.New()
+	expired := time.Now().Add(-time.Hour).Unix()
+	db.AddDeviceNonce(datastore.DeviceNonce{Nonce: "expired1", TimeStamp: expired}, "system-alder")
+	db.AddDeviceNonce(datastore.DeviceNonce{Nonce: "expired2", TimeStamp: expired}, "system-alder")
+	valid := db.AddDeviceNonce(datastore.DeviceNonce{Nonce: "valid", TimeStamp: time.Now().Unix()}, "system-alder")
+	s.env.DB = db
+
+	before := metrics.Value(metrics.NoncesPurged)
+
+	purged, err := janitor.Purge(context.Background(), s.env)
+	c.Assert(err, check.IsNil)
+	c.Assert(purged, check.Equals, 2)
+	c.Assert(metrics.Value(metrics.NoncesPurged)-before, check.Equals, int64(2))
+
+	// The expired nonces are rejected even before they are purged
+	c.Assert(db.ValidateDeviceNonce(valid.Nonce, "system-alder", ""), check.IsNil)
+	count, err := db.CountDeviceNonces("system-alder")
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *JanitorSuite) TestPurgeError(c *check.C) {
+	s.env.DB = &datastore.ErrorMockDB{}
+	before := metrics.Value(metrics.NoncePurgeErrors)
+
+	_, err := janitor.Purge(context.Background(), s.env)
+	c.Assert(err, check.NotNil)
+	c.Assert(metrics.Value(metrics.NoncePurgeErrors)-before, check.Equals, int64(1))
+}
+
+func (s *JanitorSuite) TestRun(c *check.C) {
+	db := datastoretest.New()
+	db.AddDeviceNonce(datastore.DeviceNonce{Nonce: "expired", TimeStamp: time.Now().Add(-time.Hour).Unix()}, "system-alder")
+	s.env.DB = db
+	before := metrics.Value(metrics.NoncesPurged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		janitor.Run(ctx, s.env, time.Millisecond)
+		close(done)
+	}()
+
+	// Wait for the janitor to purge the nonce, then stop it
+	for i := 0; i < 1000 && metrics.Value(metrics.NoncesPurged) == before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	c.Assert(metrics.Value(metrics.NoncesPurged)-before, check.Equals, int64(1))
+}
+
+func (s *JanitorSuite) TestPurgeSerialReplays(c *check.C) {
+	db := datastoretest.New()
+	db.AddSerialReplay("expired", "system-alder", "serial", time.Now().Add(-time.Hour))
+	db.AddSerialReplay("valid", "system-alder", "serial", time.Now())
+	s.env.DB = db
+
+	purged, err := janitor.PurgeSerialReplays(context.Background(), s.env)
+	c.Assert(err, check.IsNil)
+	c.Assert(purged, check.Equals, 1)
+
+	replay, err := db.GetSerialReplay("valid", "system-alder", time.Hour)
+	c.Assert(err, check.IsNil)
+	c.Assert(replay.Serial, check.Equals, "serial")
+
+	// The replay detection is disabled
+	s.env.Config.SerialReplayWindow = -1
+	purged, err = janitor.PurgeSerialReplays(context.Background(), s.env)
+	c.Assert(err, check.IsNil)
+	c.Assert(purged, check.Equals, 0)
+
+	s.env.DB = &datastore.ErrorMockDB{}
+	s.env.Config.SerialReplayWindow = 0
+	_, err = janitor.PurgeSerialReplays(context.Background(), s.env)
+	c.Assert(err, check.NotNil)
+}
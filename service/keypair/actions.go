@@ -27,10 +27,14 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/invalidation"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/store"
 	"github.com/snapcore/snapd/asserts"
 )
 
@@ -61,17 +65,26 @@ type ProgressResponse struct {
 	Status       []datastore.KeypairStatus `json:"status"`
 }
 
+// RegistrationResponse is the JSON response from the API registration check of the keypairs
+type RegistrationResponse struct {
+	Success      bool                    `json:"success"`
+	ErrorCode    string                  `json:"error_code"`
+	ErrorSubcode string                  `json:"error_subcode"`
+	ErrorMessage string                  `json:"message"`
+	Keypairs     []store.KeyRegistration `json:"keypairs"`
+}
+
 // listHandler is the API method to fetch the signing keys
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
 		return
 	}
 
-	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(user)
+	keypairs, err := srv.DB.ListAllowedKeypairs(user)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorFetchKeypairs.Code, "", err.Error(), w)
 		return
@@ -83,16 +96,16 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 }
 
 // getHandler is the API method to fetch a signing key
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int) {
+func (srv *Service) getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
 		return
 	}
 
-	keypair, err := datastore.Environ.DB.GetKeypair(keypairID)
+	keypair, err := srv.DB.GetKeypair(keypairID)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
 		return
@@ -104,16 +117,16 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypai
 }
 
 // updateHandler is the API method to update a signing key
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypair datastore.Keypair) {
+func (srv *Service) updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypair datastore.Keypair) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
 		return
 	}
 
-	k, err := datastore.Environ.DB.GetKeypair(keypair.ID)
+	k, err := srv.DB.GetKeypair(keypair.ID)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
 		return
@@ -122,7 +135,7 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 	// Update the key name
 	k.KeyName = keypair.KeyName
 
-	errorCode, err := datastore.Environ.DB.PutKeypair(k)
+	errorCode, err := srv.DB.PutKeypair(k)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
@@ -134,8 +147,8 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 }
 
 // createHandler is the API method to create a signing key
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+func (srv *Service) createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -147,7 +160,7 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 	}
 
 	// Store the signing-key in the keypair store using the asserts module
-	privateKey, sealedPrivateKey, err := datastore.Environ.KeypairDB.ImportSigningKey(keypairWithKey.AuthorityID, keypairWithKey.PrivateKey)
+	privateKey, sealedPrivateKey, err := srv.KeypairDB.ImportSigningKey(keypairWithKey.AuthorityID, keypairWithKey.PrivateKey)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
 		return
@@ -160,7 +173,7 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 		SealedKey:   sealedPrivateKey,
 		KeyName:     keypairWithKey.KeyName,
 	}
-	errorCode, err := datastore.Environ.DB.PutKeypair(keypair)
+	errorCode, err := srv.DB.PutKeypair(keypair)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
@@ -172,8 +185,8 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 }
 
 // generateHandler is the API method to generate a signing key
-func generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+func (srv *Service) generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -184,7 +197,12 @@ func generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, k
 		return
 	}
 
-	go datastore.GenerateKeypair(keypairWithKey.AuthorityID, "", keypairWithKey.KeyName)
+	// The instances load the signing-key, once it is generated
+	go func() {
+		if err := datastore.GenerateKeypair(keypairWithKey.AuthorityID, "", keypairWithKey.KeyName); err == nil {
+			invalidation.Publish(invalidation.Keypair, "")
+		}
+	}()
 
 	// Return the URL to watch for the response
 	statusURL := fmt.Sprintf("/v1/keypairs/status/%s/%s", keypairWithKey.AuthorityID, keypairWithKey.KeyName)
@@ -194,15 +212,23 @@ func generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, k
 }
 
 // enableDisableHandler is the API method to enable/disable a signing key
-func enableDisableHandler(w http.ResponseWriter, user datastore.User, apiCall bool, enabled bool, keypairID int) {
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+func (srv *Service) enableDisableHandler(w http.ResponseWriter, user datastore.User, apiCall bool, enabled bool, keypairID int) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
+	// Activating a signing-key may need the approval of a second admin of its account
+	if enabled {
+		keypair, _ := srv.DB.GetKeypair(keypairID)
+		if !approval.Approved(w, srv.Env, user, datastore.ApprovalKeypairEnable, keypair.AuthorityID, fmt.Sprintf("keypair/%d", keypairID), "") {
+			return
+		}
+	}
+
 	// Update the keypair in the local database
-	err = datastore.Environ.DB.UpdateAllowedKeypairActive(keypairID, enabled, user)
+	err = srv.DB.UpdateAllowedKeypairActive(keypairID, enabled, user)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
 		return
@@ -214,8 +240,8 @@ func enableDisableHandler(w http.ResponseWriter, user datastore.User, apiCall bo
 }
 
 // assertionHandler is the API method to update a key assertion
-func assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+func (srv *Service) assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -254,7 +280,7 @@ func assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool,
 		Assertion:   string(decodedAssertion),
 	}
 
-	errorCode, err := datastore.Environ.DB.UpdateKeypairAssertion(keypair, user)
+	errorCode, err := srv.DB.UpdateKeypairAssertion(keypair, user)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
@@ -266,22 +292,22 @@ func assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool,
 }
 
 // statusHandler is the API method to fetch the status of a signing key
-func statusHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID, keyName string) {
+func (srv *Service) statusHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID, keyName string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
 	// Check that the user has permissions to this authority-id
-	if !datastore.Environ.DB.CheckUserInAccount(user.Username, authorityID) {
+	if !srv.DB.CheckUserInAccount(user.Username, authorityID) {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
 		return
 	}
 
-	ks, err := datastore.Environ.DB.GetKeypairStatus(authorityID, keyName)
+	ks, err := srv.DB.GetKeypairStatus(authorityID, keyName)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "Cannot find the status of the keypair", w)
 		return
@@ -293,16 +319,16 @@ func statusHandler(w http.ResponseWriter, user datastore.User, apiCall bool, aut
 }
 
 // progressHandler is the API method to fetch the progress of signing key generation
-func progressHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) progressHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
-	ks, err := datastore.Environ.DB.ListAllowedKeypairStatus(user)
+	ks, err := srv.DB.ListAllowedKeypairStatus(user)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "Cannot find the status of the keypairs", w)
 		return
@@ -311,6 +337,34 @@ func progressHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	formatProgressResponse(ks, w)
 }
 
+// registrationHandler is the API method to check that the account-key of each signing-key
+// is registered and valid in the store, so the devices that it signs are not rejected
+func (srv *Service) registrationHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	keypairs, err := srv.DB.ListAllowedKeypairs(user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypairs.Code, "", err.Error(), w)
+		return
+	}
+
+	now := time.Now()
+	registrations := []store.KeyRegistration{}
+	for _, k := range keypairs {
+		registrations = append(registrations, store.CheckKeyRegistration(k, now))
+	}
+
+	// Return successful JSON response with the registration status of the keypairs
+	w.WriteHeader(http.StatusOK)
+	formatRegistrationResponse(registrations, w)
+}
+
 func formatListResponse(success bool, errorCode, errorSubcode, message string, keypairs []datastore.Keypair, w http.ResponseWriter) error {
 	response := ListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Keypairs: keypairs}
 
@@ -343,3 +397,14 @@ func formatProgressResponse(status []datastore.KeypairStatus, w http.ResponseWri
 	}
 	return nil
 }
+
+func formatRegistrationResponse(registrations []store.KeyRegistration, w http.ResponseWriter) error {
+	response := RegistrationResponse{Success: true, Keypairs: registrations}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the keypair registration response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keypair
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/compromise"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// CompromiseRequest is the JSON version of the compromise action of a signing-key
+type CompromiseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CompromiseResponse is the JSON response from the API compromise method, with the models and
+// the devices that are affected by the compromised signing-key
+type CompromiseResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	compromise.Report
+}
+
+// compromiseHandler is the API method to revoke a compromised signing-key
+func (srv *Service) compromiseHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int, req CompromiseRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	report, err := compromise.Compromise(srv.DB, srv.Config, keypairID, req.Reason, user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the affected models and devices
+	w.WriteHeader(http.StatusOK)
+	formatCompromiseResponse(report, w)
+}
+
+// compromiseReportHandler is the API method to fetch the report of a compromised signing-key
+func (srv *Service) compromiseReportHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	c, err := srv.DB.GetKeypairCompromise(keypairID)
+	if err == sql.ErrNoRows {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", "The signing-key is not compromised", w)
+		return
+	}
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Check that the user has permissions to this authority-id
+	if user.Role == datastore.Admin && !srv.DB.CheckUserInAccount(user.Username, c.AuthorityID) {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
+		return
+	}
+
+	report, err := compromise.BuildReport(srv.DB, c)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the affected models and devices
+	w.WriteHeader(http.StatusOK)
+	formatCompromiseResponse(report, w)
+}
+
+func formatCompromiseResponse(report compromise.Report, w http.ResponseWriter) error {
+	response := CompromiseResponse{Success: true, Report: report}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Info("Error forming the compromise response.")
+		return err
+	}
+	return nil
+}
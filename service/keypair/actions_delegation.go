@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keypair
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// RootKeyRequest is the JSON version of the root key of a brand. Replacing or removing the root
+// key needs a superuser and the approval of a second admin, or the revocation of the current
+// root key, signed by it
+type RootKeyRequest struct {
+	PublicKey  string                       `json:"public-key"`
+	Revocation *datastore.RootKeyRevocation `json:"revocation,omitempty"`
+}
+
+// DelegationRequest is the JSON version of a delegation of the brand root key, with the signed
+// document and its ASCII-armored, detached signature
+type DelegationRequest struct {
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+}
+
+// DelegationsResponse is the JSON response from the API key delegation methods
+type DelegationsResponse struct {
+	Success      bool                      `json:"success"`
+	ErrorCode    string                    `json:"error_code"`
+	ErrorSubcode string                    `json:"error_subcode"`
+	ErrorMessage string                    `json:"message"`
+	RootKey      *datastore.BrandRootKey   `json:"root-key"`
+	Delegations  []datastore.KeyDelegation `json:"delegations"`
+}
+
+// SyncDelegationsResponse is the JSON response with the root keys of the brands of the sync user
+// and their delegations, for the factory sync
+type SyncDelegationsResponse struct {
+	Success      bool                      `json:"success"`
+	ErrorCode    string                    `json:"error_code"`
+	ErrorSubcode string                    `json:"error_subcode"`
+	ErrorMessage string                    `json:"message"`
+	RootKeys     []datastore.BrandRootKey  `json:"root-keys"`
+	Delegations  []datastore.KeyDelegation `json:"delegations"`
+}
+
+// delegationsHandler is the API method to fetch the root key of a brand and its delegations
+func (srv *Service) delegationsHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	// Check that the user has permissions to this authority-id
+	if user.Role == datastore.Admin && !srv.DB.CheckUserInAccount(user.Username, authorityID) {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
+		return
+	}
+
+	srv.formatDelegations(w, authorityID)
+}
+
+// updateRootKeyHandler is the API method to register or remove the root key of a brand
+func (srv *Service) updateRootKeyHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, req RootKeyRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	rootKey, err := datastore.CanonicalBrandRootKey(datastore.BrandRootKey{AuthorityID: authorityID, PublicKey: req.PublicKey})
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	revocation := datastore.RootKeyRevocation{}
+	if req.Revocation != nil {
+		revocation = *req.Revocation
+	}
+
+	// Without a revocation, a superuser replaces or removes the root key once a second admin
+	// has approved it
+	current, err := srv.DB.GetBrandRootKey(rootKey.AuthorityID)
+	if err == nil && datastore.ReplacesRootKey(current, rootKey) && len(revocation.Signature) == 0 && user.Role == datastore.Superuser {
+		if !approval.Always(w, srv.Env, user, datastore.ApprovalBrandRootKey, rootKey.AuthorityID, "brandrootkey/"+rootKey.AuthorityID, rootKey.Fingerprint) {
+			return
+		}
+	}
+
+	err = srv.DB.UpdateAllowedBrandRootKey(rootKey, revocation, user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.formatDelegations(w, authorityID)
+}
+
+// syncDelegationsHandler is the API method to fetch the root keys of the brands of the sync user
+// and their delegations, so they are enforced on the factory
+func (srv *Service) syncDelegationsHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	resp := SyncDelegationsResponse{Success: true, Delegations: []datastore.KeyDelegation{}}
+	resp.RootKeys, err = srv.DB.ListAllowedBrandRootKeys(user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	for _, k := range resp.RootKeys {
+		delegations, err := srv.DB.ListKeyDelegations(k.AuthorityID)
+		if err != nil {
+			response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+			return
+		}
+		resp.Delegations = append(resp.Delegations, delegations...)
+	}
+
+	// Return successful JSON response with the root keys and the delegations
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("Error forming the key delegations response.")
+	}
+}
+
+// createDelegationHandler is the API method to import a delegation of the root key of a brand
+func (srv *Service) createDelegationHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, req DelegationRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	delegation := datastore.KeyDelegation{AuthorityID: authorityID, Document: req.Document, Signature: req.Signature}
+	if _, err := srv.DB.CreateAllowedKeyDelegation(delegation, user); err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.formatDelegations(w, authorityID)
+}
+
+// deleteDelegationHandler is the API method to revoke a delegation of the root key of a brand
+func (srv *Service) deleteDelegationHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, delegationID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	if err := srv.DB.DeleteAllowedKeyDelegation(delegationID, user); err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.formatDelegations(w, authorityID)
+}
+
+// formatDelegations returns the root key of the brand and its delegations
+func (srv *Service) formatDelegations(w http.ResponseWriter, authorityID string) {
+	resp := DelegationsResponse{Success: true}
+
+	rootKey, err := srv.DB.GetBrandRootKey(authorityID)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	default:
+		resp.RootKey = &rootKey
+	}
+
+	resp.Delegations, err = srv.DB.ListKeyDelegations(authorityID)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the root key and the delegations
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("Error forming the key delegations response.")
+	}
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keypair
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ModelsRequest is the JSON version of the model allowlist of a signing-key
+type ModelsRequest struct {
+	Models []datastore.KeypairModel `json:"models"`
+}
+
+// ModelsResponse is the JSON response from the API model allowlist method
+type ModelsResponse struct {
+	Success      bool                     `json:"success"`
+	ErrorCode    string                   `json:"error_code"`
+	ErrorSubcode string                   `json:"error_subcode"`
+	ErrorMessage string                   `json:"message"`
+	Models       []datastore.KeypairModel `json:"models"`
+}
+
+// modelsHandler is the API method to fetch the model allowlist of a signing key
+func (srv *Service) modelsHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	keypair, err := srv.DB.GetKeypair(keypairID)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Check that the user has permissions to this authority-id
+	if user.Role == datastore.Admin && !srv.DB.CheckUserInAccount(user.Username, keypair.AuthorityID) {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
+		return
+	}
+
+	models, err := srv.DB.ListKeypairModels(keypair.ID)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the model allowlist
+	w.WriteHeader(http.StatusOK)
+	formatModelsResponse(models, w)
+}
+
+// updateModelsHandler is the API method to replace the model allowlist of a signing key
+func (srv *Service) updateModelsHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int, req ModelsRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	err = srv.DB.UpdateAllowedKeypairModels(keypairID, req.Models, user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	models, err := srv.DB.ListKeypairModels(keypairID)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the stored model allowlist
+	w.WriteHeader(http.StatusOK)
+	formatModelsResponse(models, w)
+}
+
+func formatModelsResponse(models []datastore.KeypairModel, w http.ResponseWriter) error {
+	response := ModelsResponse{Success: true, Models: models}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the model allowlist response.")
+		return err
+	}
+	return nil
+}
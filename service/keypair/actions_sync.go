@@ -25,6 +25,7 @@ import (
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
@@ -39,10 +40,10 @@ type SyncResponse struct {
 // syncHandler fetches the signing-keys accessible by a user
 // A encryption secret is provided and the keypairs are decrypted and re-encrypted
 // using the supplied keystore secret
-func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, request SyncRequest) {
+func (srv *Service) syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, request SyncRequest) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
@@ -55,8 +56,22 @@ func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, reque
 		}
 	}
 
-	// Get the keypairs that the user can access (does not include the sealed key)
-	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(user)
+	// Exporting the signing-keys may need the approval of a second admin. The export covers the
+	// accounts of the sync user, so it is approved by a superuser
+	if !approval.Approved(w, srv.Env, user, datastore.ApprovalKeypairExport, "", "keypairs/sync", "") {
+		return
+	}
+
+	// Get the keypairs that the user can access, limited to the keypairs of the models
+	// assigned to the sync user (does not include the sealed key)
+	keypairs, err := srv.DB.ListAllowedSyncKeypairs(user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-sync-keypairs", "", err.Error(), w)
+		return
+	}
+
+	// The compromised keypairs are revoked on the factory
+	compromised, err := srv.compromisedKeypairs()
 	if err != nil {
 		response.FormatStandardResponse(false, "error-sync-keypairs", "", err.Error(), w)
 		return
@@ -65,8 +80,15 @@ func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, reque
 	syncKeypairs := []datastore.SyncKeypair{}
 
 	for _, k := range keypairs {
+		if compromised[k.ID] {
+			k.Active = false
+			k.SealedKey = ""
+			syncKeypairs = append(syncKeypairs, datastore.SyncKeypair{Keypair: k, Revoked: true})
+			continue
+		}
+
 		// Get the keypair with the sealed key
-		keypair, err := datastore.Environ.DB.GetKeypair(k.ID)
+		keypair, err := srv.DB.GetKeypair(k.ID)
 		if err != nil {
 			response.FormatStandardResponse(false, "error-sync-keypair", "", err.Error(), w)
 			return
@@ -91,6 +113,20 @@ func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, reque
 	formatSyncResponse(syncKeypairs, w)
 }
 
+// compromisedKeypairs returns the IDs of the compromised keypairs
+func (srv *Service) compromisedKeypairs() (map[int]bool, error) {
+	compromises, err := srv.DB.ListKeypairCompromises()
+	if err != nil {
+		return nil, err
+	}
+
+	compromised := map[int]bool{}
+	for _, c := range compromises {
+		compromised[c.KeypairID] = true
+	}
+	return compromised, nil
+}
+
 func formatSyncResponse(keypairs []datastore.SyncKeypair, w http.ResponseWriter) error {
 	response := SyncResponse{Success: true, Keypairs: keypairs}
 
@@ -24,10 +24,12 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
 )
 
 // SyncRequest is the request to fetch keypairs
@@ -36,24 +38,201 @@ type SyncRequest struct {
 }
 
 // APIList is the API method to fetch the log records from signing
-func APIList(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	srv.listHandler(w, user, true)
+}
+
+// APIModels is the API method to fetch the model allowlist of a keypair
+func (srv *Service) APIModels(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.modelsHandler(w, user, true, id)
+}
+
+// APIUpdateModels is the API method to replace the model allowlist of a keypair
+func (srv *Service) APIUpdateModels(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	req, ok := decodeModelsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	srv.updateModelsHandler(w, user, true, id, req)
+}
+
+// APICompromise is the API method to revoke a compromised keypair, returning the affected models
+// and devices
+func (srv *Service) APICompromise(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	req := CompromiseRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	srv.compromiseHandler(w, user, true, id, req)
+}
+
+// APICompromiseReport is the API method to fetch the affected models and devices of a compromised keypair
+func (srv *Service) APICompromiseReport(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.compromiseReportHandler(w, user, true, id)
+}
+
+// APIDelegations is the API method to fetch the root key of a brand and its delegations
+func (srv *Service) APIDelegations(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.delegationsHandler(w, user, true, mux.Vars(r)["authorityID"])
+}
+
+// APIUpdateRootKey is the API method to register or remove the root key of a brand
+func (srv *Service) APIUpdateRootKey(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	req := RootKeyRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	srv.updateRootKeyHandler(w, user, true, mux.Vars(r)["authorityID"], req)
+}
+
+// APISyncDelegations is the API method to fetch the root keys of the brands of the sync user
+// and their delegations, for the factory sync
+func (srv *Service) APISyncDelegations(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.syncDelegationsHandler(w, user, true)
+}
+
+// APICreateDelegation is the API method to import a delegation of the root key of a brand
+func (srv *Service) APICreateDelegation(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	req := DelegationRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	srv.createDelegationHandler(w, user, true, mux.Vars(r)["authorityID"], req)
+}
+
+// APIDeleteDelegation is the API method to revoke a delegation of the root key of a brand
+func (srv *Service) APIDeleteDelegation(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidID.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.deleteDelegationHandler(w, user, true, vars["authorityID"], id)
+}
+
+// APIRegistration is the API method to check the registration of the keypairs in the store
+func (srv *Service) APIRegistration(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.registrationHandler(w, user, true)
 }
 
 // APISyncKeypairs fetches the signing-keys accessible by a user
 // A encryption secret is provided and the keypairs are decrypted and re-encrypted
 // using the supplied keystore secret
-func APISyncKeypairs(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APISyncKeypairs(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		log.Error("error-auth", err)
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
@@ -73,5 +252,5 @@ func APISyncKeypairs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	syncHandler(w, user, true, request)
+	srv.syncHandler(w, user, true, request)
 }
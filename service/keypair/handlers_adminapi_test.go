@@ -25,10 +25,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
+	"github.com/CanonicalLtd/serial-vault/store"
 	check "gopkg.in/check.v1"
 )
 
@@ -90,6 +92,70 @@ func (s *KeypairSuite) TestAPISyncKeypairsHandler(c *check.C) {
 	}
 }
 
+func (s *KeypairSuite) TestAPISyncKeypairsAssignedModels(c *check.C) {
+	datastore.ReEncryptKeypair = mockReEncryptKeypair
+	datastore.Environ.Config.EnableUserAuth = true
+	defer func() { datastore.Environ.Config.EnableUserAuth = false }()
+
+	// Only the signing-keys of the models assigned to the sync user are synced
+	err := datastore.Environ.DB.CreateSyncModelAssignment(datastore.SyncModelAssignment{UserID: 6, ModelID: 1})
+	c.Assert(err, check.IsNil)
+
+	k := keypair.SyncRequest{Secret: "NewKeystoreSecretInTheFactory"}
+	data, _ := json.Marshal(k)
+
+	tests := []KeypairTest{
+		{"POST", "/api/keypairs/sync", data, 200, "application/json; charset=UTF-8", datastore.SyncUser, true, true, 1},
+		{"POST", "/api/keypairs/sync", data, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 2},
+	}
+
+	for _, t := range tests {
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result, err := parseSyncResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Keypairs), check.Equals, t.List)
+	}
+}
+
+func (s *KeypairSuite) TestAPIRegistrationHandler(c *check.C) {
+	fetch := store.FetchAccountKey
+	defer func() { store.FetchAccountKey = fetch }()
+	store.FetchAccountKey = func(keyID string) (store.AccountKey, error) {
+		if keyID == "invalidone" {
+			return store.AccountKey{}, store.ErrAccountKeyNotFound
+		}
+		return store.AccountKey{AccountID: "system", Since: time.Now().AddDate(-1, 0, 0)}, nil
+	}
+
+	tests := []KeypairTest{
+		{"GET", "/api/keypairs/registration", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/api/keypairs/registration", nil, 200, "application/json; charset=UTF-8", datastore.Admin, false, true, 2},
+		{"GET", "/api/keypairs/registration", nil, 400, "application/json; charset=UTF-8", datastore.Standard, false, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := keypair.RegistrationResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Keypairs), check.Equals, t.List)
+
+		if t.Success {
+			c.Assert(result.Keypairs[0].Status, check.Equals, store.KeyRegistered)
+			c.Assert(result.Keypairs[0].Rejected, check.Equals, false)
+			c.Assert(result.Keypairs[1].Status, check.Equals, store.KeyNotRegistered)
+			c.Assert(result.Keypairs[1].Rejected, check.Equals, true)
+		}
+	}
+}
+
 func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
@@ -108,7 +174,7 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
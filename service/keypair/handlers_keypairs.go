@@ -34,6 +34,11 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the signing-key handlers
+type Service struct {
+	*datastore.Env
+}
+
 // WithPrivateKey is the JSON version of a keypair, including the base64 armored, signing-key
 type WithPrivateKey struct {
 	ID          int    `json:"id"`
@@ -50,14 +55,14 @@ type AssertionRequest struct {
 
 // List fetches the available keypairs for display from the database.
 // Only viewable reference data is stored in the database, not the restricted private key.
-func List(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
-	listHandler(w, authUser, false)
+	srv.listHandler(w, authUser, false)
 }
 
 // Create is the API method to create a keypair
@@ -65,24 +70,24 @@ func List(w http.ResponseWriter, r *http.Request) {
 // keypairs are stored in the signing database and the authority-id/key-id is
 // stored in the models database. Models can then be linked to one of the
 // existing signing-keys.
-func Create(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Create(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
-	keypairWithKey, ok := verifyKeypair(w, r, authUser)
+	keypairWithKey, ok := srv.verifyKeypair(w, r, authUser)
 	if !ok {
 		return
 	}
 
-	createHandler(w, authUser, false, keypairWithKey)
+	srv.createHandler(w, authUser, false, keypairWithKey)
 }
 
 // Get is the API method to fetch a keypair
-func Get(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Get(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -95,12 +100,12 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	srv.getHandler(w, authUser, false, id)
 }
 
 // Update is the API method to update a keypair name
-func Update(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Update(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -133,37 +138,181 @@ func Update(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the keypair
 	k := WithPrivateKey{AuthorityID: keypair.AuthorityID, KeyName: keypair.KeyName}
-	if ok := validateKeypair(w, &k, authUser); !ok {
+	if ok := srv.validateKeypair(w, &k, authUser); !ok {
+		return
+	}
+
+	srv.updateHandler(w, authUser, false, keypair)
+}
+
+// Models is the API method to fetch the model allowlist of a keypair
+func (srv *Service) Models(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.modelsHandler(w, authUser, false, id)
+}
+
+// UpdateModels is the API method to replace the model allowlist of a keypair
+func (srv *Service) UpdateModels(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	req, ok := decodeModelsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	srv.updateModelsHandler(w, authUser, false, id, req)
+}
+
+// Compromise is the API method to revoke a compromised keypair, returning the affected models
+// and devices
+func (srv *Service) Compromise(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
 		return
 	}
 
-	updateHandler(w, authUser, false, keypair)
+	req := CompromiseRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	srv.compromiseHandler(w, authUser, false, id, req)
+}
+
+// CompromiseReport is the API method to fetch the affected models and devices of a compromised keypair
+func (srv *Service) CompromiseReport(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.compromiseReportHandler(w, authUser, false, id)
+}
+
+// Delegations is the API method to fetch the root key of a brand and its delegations
+func (srv *Service) Delegations(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.delegationsHandler(w, authUser, false, mux.Vars(r)["authorityID"])
+}
+
+// UpdateRootKey is the API method to register or remove the root key of a brand
+func (srv *Service) UpdateRootKey(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	req := RootKeyRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	srv.updateRootKeyHandler(w, authUser, false, mux.Vars(r)["authorityID"], req)
+}
+
+// CreateDelegation is the API method to import a delegation of the root key of a brand
+func (srv *Service) CreateDelegation(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	req := DelegationRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	srv.createDelegationHandler(w, authUser, false, mux.Vars(r)["authorityID"], req)
+}
+
+// DeleteDelegation is the API method to revoke a delegation of the root key of a brand
+func (srv *Service) DeleteDelegation(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidID.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.deleteDelegationHandler(w, authUser, false, vars["authorityID"], id)
 }
 
 // Generate is the API method to generate a new keypair that can be used
 // for signing serial (or model) assertions. The keypairs are stored in the signing database
 // and the authority-id/key-id is stored in the models database. Models can then be
 // linked to one of the existing signing-keys.
-func Generate(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Generate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
-	keypairWithKey, ok := verifyKeypair(w, r, authUser)
+	keypairWithKey, ok := srv.verifyKeypair(w, r, authUser)
 	if !ok {
 		return
 	}
 
-	generateHandler(w, authUser, false, keypairWithKey)
+	srv.generateHandler(w, authUser, false, keypairWithKey)
 }
 
 // Disable disables an existing keypair, which will mean that any
 // linked Models will not be able to be signed. The asserts module does not allow
 // a keypair to be deleted, so the keypair will just be disabled in the local database.
-func Disable(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Disable(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -177,14 +326,14 @@ func Disable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enableDisableHandler(w, authUser, false, false, keypairID)
+	srv.enableDisableHandler(w, authUser, false, false, keypairID)
 }
 
 // Enable enables an existing keypair, which will mean that any
 // linked Models will be able to be signed. The asserts module does not allow
 // a keypair to be deleted, so the keypair will just be enabled in the local database.
-func Enable(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Enable(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -198,12 +347,12 @@ func Enable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enableDisableHandler(w, authUser, false, true, keypairID)
+	srv.enableDisableHandler(w, authUser, false, true, keypairID)
 }
 
 // Assertion updates the account key assertion on a keypair
-func Assertion(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Assertion(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -224,12 +373,12 @@ func Assertion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertionHandler(w, authUser, false, assertionRequest)
+	srv.assertionHandler(w, authUser, false, assertionRequest)
 }
 
 // Status returns the creation status of a keypair
-func Status(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Status(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
@@ -237,21 +386,32 @@ func Status(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	statusHandler(w, authUser, false, vars["authorityID"], vars["keyName"])
+	srv.statusHandler(w, authUser, false, vars["authorityID"], vars["keyName"])
 }
 
 // Progress returns the status of keypairs that are being generated
-func Progress(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Progress(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
-	progressHandler(w, authUser, false)
+	srv.progressHandler(w, authUser, false)
 }
 
-func verifyKeypair(w http.ResponseWriter, r *http.Request, authUser datastore.User) (WithPrivateKey, bool) {
+// Registration checks the registration of the keypairs in the store
+func (srv *Service) Registration(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	srv.registrationHandler(w, authUser, false)
+}
+
+func (srv *Service) verifyKeypair(w http.ResponseWriter, r *http.Request, authUser datastore.User) (WithPrivateKey, bool) {
 
 	keypairWithKey := WithPrivateKey{}
 
@@ -271,14 +431,14 @@ func verifyKeypair(w http.ResponseWriter, r *http.Request, authUser datastore.Us
 	}
 
 	// Validate the keypair
-	if ok := validateKeypair(w, &keypairWithKey, authUser); !ok {
+	if ok := srv.validateKeypair(w, &keypairWithKey, authUser); !ok {
 		return keypairWithKey, false
 	}
 
 	return keypairWithKey, true
 }
 
-func validateKeypair(w http.ResponseWriter, keypairWithKey *WithPrivateKey, authUser datastore.User) bool {
+func (srv *Service) validateKeypair(w http.ResponseWriter, keypairWithKey *WithPrivateKey, authUser datastore.User) bool {
 	// Validate the authority-id
 	keypairWithKey.AuthorityID = strings.TrimSpace(keypairWithKey.AuthorityID)
 	if len(keypairWithKey.AuthorityID) == 0 {
@@ -287,16 +447,51 @@ func validateKeypair(w http.ResponseWriter, keypairWithKey *WithPrivateKey, auth
 	}
 
 	// Check that the user has permissions to this authority-id
-	if !datastore.Environ.DB.CheckUserInAccount(authUser.Username, keypairWithKey.AuthorityID) {
+	if !srv.DB.CheckUserInAccount(authUser.Username, keypairWithKey.AuthorityID) {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
 		return false
 	}
 
 	// Check that the key-name does not already exist for the authority-id
-	if datastore.Environ.DB.CheckKeypairKeynameExists(keypairWithKey.AuthorityID, keypairWithKey.KeyName) {
+	if srv.DB.CheckKeypairKeynameExists(keypairWithKey.AuthorityID, keypairWithKey.KeyName) {
 		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "A key with this name already exists for this Signing Authority", w)
 		return false
 	}
 
 	return true
 }
+
+func decodeModelsRequest(w http.ResponseWriter, r *http.Request) (ModelsRequest, bool) {
+	defer r.Body.Close()
+
+	req := ModelsRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", response.ErrorInvalidData.Message, w)
+		return req, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", err.Error(), w)
+		return req, false
+	}
+	return req, true
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", response.ErrorInvalidData.Message, w)
+		return false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", err.Error(), w)
+		return false
+	}
+	return true
+}
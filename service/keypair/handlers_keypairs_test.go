@@ -63,12 +63,12 @@ type KeypairTest struct {
 
 func (s *KeypairSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 
 	// Disable CSRF for tests as we do not have a secure connection
-	service.MiddlewareWithCSRF = service.Middleware
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
 }
 
 func (s *KeypairSuite) TestListStatusHandler(c *check.C) {
@@ -314,6 +314,74 @@ func (s *KeypairSuite) TestAssertionHandler(c *check.C) {
 	}
 }
 
+func (s *KeypairSuite) TestEnableHandlerApproval(c *check.C) {
+	config := config.Settings{KeyStoreType: "memory", EnableUserAuth: true, JwtSecret: "SomeTestSecretValue",
+		Approvals: []config.ApprovalRule{{Operation: datastore.ApprovalKeypairEnable}}}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	// Activating the signing-key waits for the approval
+	w := sendAdminRequest("POST", "/v1/keypairs/1/enable", nil, datastore.Admin, c)
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "approval-pending")
+
+	// Disabling it does not need an approval
+	w = sendAdminRequest("POST", "/v1/keypairs/1/disable", nil, datastore.Admin, c)
+	result, err = response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+
+	// An approval cannot be requested without user authentication
+	datastore.Environ.Config.EnableUserAuth = false
+	w = sendAdminRequest("POST", "/v1/keypairs/1/enable", nil, datastore.Admin, c)
+	result, err = response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "approval-auth")
+}
+
+func (s *KeypairSuite) TestModelsHandler(c *check.C) {
+	data := []byte(`{"models": [{"brand-id": "system", "model": "alder"}]}`)
+	tests := []KeypairTest{
+		{"GET", "/v1/keypairs/1/models", nil, 200, response.JSONHeader, 0, false, true, 1},
+		{"GET", "/v1/keypairs/1/models", nil, 200, response.JSONHeader, datastore.Admin, true, true, 1},
+		{"GET", "/v1/keypairs/1/models", nil, 400, response.JSONHeader, datastore.Standard, true, false, 0},
+		{"PUT", "/v1/keypairs/1/models", data, 200, response.JSONHeader, 0, false, true, 1},
+		{"PUT", "/v1/keypairs/1/models", data, 200, response.JSONHeader, datastore.Admin, true, true, 1},
+		{"PUT", "/v1/keypairs/1/models", data, 400, response.JSONHeader, datastore.Standard, true, false, 0},
+		{"PUT", "/v1/keypairs/1/models", nil, 400, response.JSONHeader, 0, false, false, 0},
+		{"PUT", "/v1/keypairs/1/models", []byte("\u1000"), 400, response.JSONHeader, 0, false, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := keypair.ModelsResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Models), check.Equals, t.List)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *KeypairSuite) TestModelsErrorHandler(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("GET", "/v1/keypairs/1/models", nil, 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	w = sendAdminRequest("PUT", "/v1/keypairs/1/models", bytes.NewReader([]byte(`{"models": []}`)), 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
 func parseListResponse(w *httptest.ResponseRecorder) (keypair.ListResponse, error) {
 	// Check the JSON response
 	result := keypair.ListResponse{}
@@ -331,7 +399,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 		c.Assert(err, check.IsNil)
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -339,7 +407,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, role)
+	jwtToken, err := usso.NewJWTToken(&resp, role, datastore.Environ.Config.JwtSecret)
 	if err != nil {
 		return fmt.Errorf("Error creating a JWT: %v", err)
 	}
@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package keyreload loads the signing-keys that were added since the keystore was opened,
+// e.g. by the factory sync, so they are signable without restarting the service
+package keyreload
+
+import (
+	"context"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DefaultInterval is the time between the reloads of the signing-keys
+const DefaultInterval = 5 * time.Minute
+
+// Interval returns the time between the reloads of the signing-keys from the config
+func Interval(settings config.Settings) time.Duration {
+	if settings.KeystoreReloadInterval > 0 {
+		return time.Duration(settings.KeystoreReloadInterval) * time.Second
+	}
+	return DefaultInterval
+}
+
+// Run reloads the signing-keys periodically, until the context is done
+func Run(ctx context.Context, env *datastore.Env, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Reload(ctx, env)
+		}
+	}
+}
+
+// Reload loads the active signing-keys of the database that are not in the keystore yet,
+// returning the number that were loaded
+func Reload(ctx context.Context, env *datastore.Env) (int, error) {
+	keypairs, err := env.DB.WithContext(ctx).ListAllowedKeypairs(datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		metrics.Increment(metrics.KeypairReloadErrors)
+		log.Message("KEYSTORE", "list-keypairs", err.Error())
+		return 0, err
+	}
+
+	loaded, err := env.KeypairDB.ReloadKeypairs(keypairs)
+	metrics.Add(metrics.KeypairsReloaded, int64(loaded))
+	if err != nil {
+		metrics.Increment(metrics.KeypairReloadErrors)
+		log.Message("KEYSTORE", "reload-keypairs", err.Error())
+	}
+	return loaded, err
+}
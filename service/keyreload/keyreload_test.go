@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keyreload_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/keyreload"
+	check "gopkg.in/check.v1"
+)
+
+const testKeyID = "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"
+
+func TestKeyReloadSuite(t *testing.T) { check.TestingT(t) }
+
+type KeyReloadSuite struct {
+	path string
+}
+
+var _ = check.Suite(&KeyReloadSuite{})
+
+func (s *KeyReloadSuite) SetUpTest(c *check.C) {
+	s.path = c.MkDir()
+	settings := config.Settings{KeyStoreType: "filesystem", KeyStorePath: s.path, KeyStoreSecret: "secret"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: settings}
+	c.Assert(datastore.OpenKeyStore(settings), check.IsNil)
+}
+
+// addKeyFile adds the test signing-key to the filesystem keystore, as another process would
+func (s *KeyReloadSuite) addKeyFile(c *check.C) {
+	data, err := ioutil.ReadFile("../../keystore/TestKey.snapd")
+	c.Assert(err, check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(s.path, "private-keys-v1"), 0700), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.path, "private-keys-v1", testKeyID), data, 0600), check.IsNil)
+
+	_, err = datastore.EncryptFilesystemKeystore(s.path, "secret")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *KeyReloadSuite) TestReload(c *check.C) {
+	db := datastoretest.New()
+	db.AddKeypair(datastoretest.NewKeypair("system", testKeyID).Build())
+	db.AddKeypair(datastoretest.NewKeypair("system", "inactive-key").Inactive().Build())
+	datastore.Environ.DB = db
+
+	// The signing-key is not in the keystore yet
+	beforeErrors := metrics.Value(metrics.KeypairReloadErrors)
+	loaded, err := keyreload.Reload(context.Background(), datastore.Environ)
+	c.Assert(err, check.NotNil)
+	c.Assert(loaded, check.Equals, 0)
+	c.Assert(metrics.Value(metrics.KeypairReloadErrors)-beforeErrors, check.Equals, int64(1))
+
+	// The signing-key is loaded once it is added, without reopening the keystore
+	s.addKeyFile(c)
+	before := metrics.Value(metrics.KeypairsReloaded)
+	loaded, err = keyreload.Reload(context.Background(), datastore.Environ)
+	c.Assert(err, check.IsNil)
+	c.Assert(loaded, check.Equals, 1)
+	c.Assert(metrics.Value(metrics.KeypairsReloaded)-before, check.Equals, int64(1))
+
+	_, err = datastore.Environ.KeypairDB.PublicKey(testKeyID)
+	c.Assert(err, check.IsNil)
+
+	// The loaded signing-keys are not loaded again
+	loaded, err = keyreload.Reload(context.Background(), datastore.Environ)
+	c.Assert(err, check.IsNil)
+	c.Assert(loaded, check.Equals, 0)
+}
+
+func (s *KeyReloadSuite) TestReloadError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	before := metrics.Value(metrics.KeypairReloadErrors)
+
+	_, err := keyreload.Reload(context.Background(), datastore.Environ)
+	c.Assert(err, check.NotNil)
+	c.Assert(metrics.Value(metrics.KeypairReloadErrors)-before, check.Equals, int64(1))
+}
+
+func (s *KeyReloadSuite) TestInterval(c *check.C) {
+	c.Assert(keyreload.Interval(config.Settings{}), check.Equals, keyreload.DefaultInterval)
+	c.Assert(keyreload.Interval(config.Settings{KeystoreReloadInterval: 30}).Seconds(), check.Equals, float64(30))
+}
@@ -0,0 +1,154 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package keyusage alerts when a signing-key signs an assertion for a brand/model that it has
+// not signed before, or that is not in its model allowlist, so a misassigned signing-key is
+// caught before it signs the devices of a production run
+package keyusage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Reasons of the alerts
+const (
+	ReasonFirstUse    = "first-use"   // the signing-key has not signed for the brand/model before
+	ReasonNotAllowed  = "not-allowed" // the brand/model is not in the allowlist of the signing-key
+	ReasonCompromised = "compromised" // the signing-key was revoked by the compromise action
+)
+
+// notifyTimeout is the limit for sending an alert to a notification hook
+const notifyTimeout = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// Alert is sent to the notification hooks when a signing-key signs for an unexpected brand/model
+type Alert struct {
+	Reason      string    `json:"reason"`
+	Assertion   string    `json:"assertion"`
+	AuthorityID string    `json:"authority-id"`
+	KeyID       string    `json:"key-id"`
+	BrandID     string    `json:"brand-id"`
+	Model       string    `json:"model"`
+	Created     time.Time `json:"created"`
+}
+
+// Check records that the keypair signed an assertion of the type for the brand/model, raising an
+// alert when the brand/model is new for the signing-key or outside its allowlist. The assertion
+// is already signed, so the errors are only logged
+func Check(db datastore.Datastore, settings config.Settings, keypair datastore.Keypair, assertion, brandID, model string) {
+	first, err := db.RecordKeypairUsage(keypair.ID, brandID, model)
+	if err != nil {
+		log.Message("KEYUSAGE", "record-usage", err.Error())
+	}
+
+	allowed := Allowed(settings, keypair.KeyID, brandID, model)
+	if !first && allowed {
+		return
+	}
+
+	alert := Alert{Reason: ReasonFirstUse, Assertion: assertion, AuthorityID: keypair.AuthorityID, KeyID: keypair.KeyID, BrandID: brandID, Model: model, Created: time.Now().UTC()}
+	if !allowed {
+		alert.Reason = ReasonNotAllowed
+	}
+	metrics.Increment(metrics.KeyUsageAlerts)
+	log.Warningf("Signing-key %s signed a %s assertion for %s/%s: %s", keypair.KeyID, assertion, brandID, model, alert.Reason)
+
+	// Only the first signing is sent to the hooks, not every device of the brand/model
+	if !first {
+		return
+	}
+	for _, rule := range settings.KeyUsageAlerts {
+		if len(rule.KeyID) == 0 || rule.KeyID == keypair.KeyID {
+			Notify(rule.NotifyURL, alert)
+		}
+	}
+}
+
+// Allowed checks that the brand/model is in the allowlists of the signing-key. A signing-key
+// without an allowlist can sign for all the models
+func Allowed(settings config.Settings, keyID, brandID, model string) bool {
+	name := modelName(settings.ModelNameCase, brandID, model)
+
+	for _, rule := range settings.KeyUsageAlerts {
+		if len(rule.Models) == 0 || (len(rule.KeyID) > 0 && rule.KeyID != keyID) {
+			continue
+		}
+		if !inAllowlist(settings.ModelNameCase, rule.Models, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func inAllowlist(modelNameCase []config.ModelNameCase, models []string, name string) bool {
+	for _, m := range models {
+		parts := strings.SplitN(m, "/", 2)
+		if len(parts) == 2 && modelName(modelNameCase, parts[0], parts[1]) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// modelName returns the canonical "brand/model" name
+func modelName(modelNameCase []config.ModelNameCase, brandID, model string) string {
+	return datastore.CanonicalBrandID(brandID) + "/" + datastore.CanonicalModelName(modelNameCase, brandID, model)
+}
+
+// Notify sends the alert to the notification hook, in the background
+var Notify = func(url string, alert Alert) {
+	if len(url) == 0 {
+		return
+	}
+
+	go func() {
+		if err := sendNotification(url, alert); err != nil {
+			log.Errorf("Error notifying the signing-key alert of %s: %v", alert.KeyID, err)
+		}
+	}()
+}
+
+func sendNotification(url string, alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the notification hook returned %s", resp.Status)
+	}
+	return nil
+}
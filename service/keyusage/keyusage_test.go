@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keyusage_test
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/keyusage"
+	check "gopkg.in/check.v1"
+)
+
+func TestKeyUsageSuite(t *testing.T) { check.TestingT(t) }
+
+type KeyUsageSuite struct {
+	db     *datastoretest.DB
+	alerts []keyusage.Alert
+	urls   []string
+}
+
+var _ = check.Suite(&KeyUsageSuite{})
+
+var keypair = datastore.Keypair{ID: 1, AuthorityID: "system", KeyID: "61abf588e52be7a3"}
+
+func (s *KeyUsageSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	datastore.Environ = &datastore.Env{DB: s.db}
+
+	s.alerts, s.urls = nil, nil
+	keyusage.Notify = func(url string, alert keyusage.Alert) {
+		s.urls = append(s.urls, url)
+		s.alerts = append(s.alerts, alert)
+	}
+}
+
+func (s *KeyUsageSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *KeyUsageSuite) TestCheckFirstUse(c *check.C) {
+	settings := config.Settings{KeyUsageAlerts: []config.KeyUsageAlert{{NotifyURL: "https://hooks.example.com/keys"}}}
+	before := metrics.Value(metrics.KeyUsageAlerts)
+
+	keyusage.Check(s.db, settings, keypair, "serial", "system", "alder")
+	keyusage.Check(s.db, settings, keypair, "serial", "system", "alder")
+	keyusage.Check(s.db, settings, keypair, "serial", "system", "ash")
+
+	c.Assert(s.urls, check.DeepEquals, []string{"https://hooks.example.com/keys", "https://hooks.example.com/keys"})
+	c.Assert(s.alerts[0].Reason, check.Equals, keyusage.ReasonFirstUse)
+	c.Assert(s.alerts[0].Model, check.Equals, "alder")
+	c.Assert(s.alerts[1].Model, check.Equals, "ash")
+	c.Assert(metrics.Value(metrics.KeyUsageAlerts)-before, check.Equals, int64(2))
+
+	usage, err := s.db.ListKeypairUsage(keypair.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.HasLen, 2)
+	c.Assert(usage[0].Signed, check.Equals, 2)
+	c.Assert(usage[1].Signed, check.Equals, 1)
+}
+
+func (s *KeyUsageSuite) TestCheckNotAllowed(c *check.C) {
+	settings := config.Settings{KeyUsageAlerts: []config.KeyUsageAlert{
+		{KeyID: keypair.KeyID, Models: []string{"system/alder"}, NotifyURL: "https://hooks.example.com/system"},
+		{KeyID: "other-key", NotifyURL: "https://hooks.example.com/other"},
+	}}
+	before := metrics.Value(metrics.KeyUsageAlerts)
+
+	keyusage.Check(s.db, settings, keypair, "serial", "system", "Alder")
+	keyusage.Check(s.db, settings, keypair, "serial", "other", "ash")
+	keyusage.Check(s.db, settings, keypair, "serial", "other", "ash")
+
+	// Every signing outside the allowlist is counted, but only the first one is notified
+	c.Assert(s.urls, check.DeepEquals, []string{"https://hooks.example.com/system", "https://hooks.example.com/system"})
+	c.Assert(s.alerts[0].Reason, check.Equals, keyusage.ReasonFirstUse)
+	c.Assert(s.alerts[1].Reason, check.Equals, keyusage.ReasonNotAllowed)
+	c.Assert(metrics.Value(metrics.KeyUsageAlerts)-before, check.Equals, int64(3))
+}
+
+func (s *KeyUsageSuite) TestAllowed(c *check.C) {
+	settings := config.Settings{KeyUsageAlerts: []config.KeyUsageAlert{
+		{KeyID: keypair.KeyID, Models: []string{"system/alder", "system/ash"}},
+		{Models: []string{"system/alder", "system/ash", "system/birch"}},
+	}}
+
+	tests := []struct {
+		keyID   string
+		brandID string
+		model   string
+		allowed bool
+	}{
+		{keypair.KeyID, "system", "alder", true},
+		{keypair.KeyID, "system", "ASH", true},
+		{keypair.KeyID, "system", "birch", false},
+		{"other-key", "system", "birch", true},
+		{"other-key", "other", "alder", false},
+	}
+
+	for _, t := range tests {
+		c.Assert(keyusage.Allowed(settings, t.keyID, t.brandID, t.model), check.Equals, t.allowed, check.Commentf("%s %s/%s", t.keyID, t.brandID, t.model))
+	}
+}
+
+func (s *KeyUsageSuite) TestCheckRecordError(c *check.C) {
+	settings := config.Settings{KeyUsageAlerts: []config.KeyUsageAlert{{Models: []string{"system/alder"}, NotifyURL: "https://hooks.example.com/keys"}}}
+	before := metrics.Value(metrics.KeyUsageAlerts)
+
+	// The usage cannot be recorded, but the signing outside the allowlist is still counted
+	keyusage.Check(&datastore.ErrorMockDB{}, settings, keypair, "serial", "system", "ash")
+	c.Assert(s.urls, check.HasLen, 0)
+	c.Assert(metrics.Value(metrics.KeyUsageAlerts)-before, check.Equals, int64(1))
+}
@@ -0,0 +1,140 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/service/i18n"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// localized translates the message of the JSON error responses into the language that is negotiated
+// from the Accept-Language header of the request. The error codes and the successful responses are
+// not changed
+func localized(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+
+		lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+		if lang == i18n.DefaultLanguage {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		lw := &localizeWriter{ResponseWriter: w, lang: lang}
+		inner.ServeHTTP(lw, r)
+		lw.Close()
+	})
+}
+
+// localizeWriter holds the body of a JSON error response, so its message can be translated. Other
+// responses are written through
+type localizeWriter struct {
+	http.ResponseWriter
+	lang      string
+	started   bool
+	buffering bool
+	status    int
+	body      bytes.Buffer
+}
+
+// WriteHeader decides whether the response is translated, before the headers are sent
+func (lw *localizeWriter) WriteHeader(code int) {
+	if lw.started {
+		return
+	}
+	lw.started = true
+
+	h := lw.Header()
+	if code >= http.StatusBadRequest && strings.Contains(h.Get("Content-Type"), "json") && len(h.Get("Content-Encoding")) == 0 {
+		lw.buffering = true
+		lw.status = code
+		return
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *localizeWriter) Write(b []byte) (int, error) {
+	if !lw.started {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if !lw.buffering {
+		return lw.ResponseWriter.Write(b)
+	}
+	return lw.body.Write(b)
+}
+
+// Flush sends the buffered data to the client, for the streamed responses. The error responses are
+// held until they are translated
+func (lw *localizeWriter) Flush() {
+	if lw.buffering {
+		return
+	}
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes the error response with the translated message
+func (lw *localizeWriter) Close() {
+	if !lw.buffering {
+		return
+	}
+
+	body := lw.body.Bytes()
+	if translated, ok := translateMessage(lw.lang, body); ok {
+		body = translated
+		lw.Header().Set("Content-Language", lw.lang)
+	}
+
+	lw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	lw.ResponseWriter.WriteHeader(lw.status)
+	if _, err := lw.ResponseWriter.Write(body); err != nil {
+		svlog.Errorf("Error writing the localized response: %v", err)
+	}
+}
+
+// translateMessage translates the message of the JSON body, when the catalog of the language has it
+func translateMessage(lang string, body []byte) ([]byte, bool) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, false
+	}
+
+	var message string
+	if err := json.Unmarshal(fields["message"], &message); err != nil {
+		return nil, false
+	}
+	translated := i18n.Translate(lang, message)
+	if translated == message {
+		return nil, false
+	}
+
+	fields["message"], _ = json.Marshal(translated)
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false
+	}
+	return append(content, '\n'), true
+}
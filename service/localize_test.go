@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package service
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+type LocalizeSuite struct{}
+
+var _ = check.Suite(&LocalizeSuite{})
+
+func (s *LocalizeSuite) send(handler http.Handler, lang string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/models", nil)
+	r.Header.Set("Accept-Language", lang)
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func errorHandler(e response.ErrorResponse) http.Handler {
+	return ErrorHandler(func(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+		return e
+	})
+}
+
+func (s *LocalizeSuite) TestLocalizedError(c *check.C) {
+	w := s.send(localized(errorHandler(response.ErrorInvalidModel)), "es-ES,es;q=0.9,en;q=0.8")
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(w.Header().Get("Content-Language"), check.Equals, "es")
+
+	result := response.ErrorResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Code, check.Equals, "invalid-model")
+	c.Assert(result.Message, check.Equals, "No se encuentra el modelo con la marca y el modelo indicados")
+}
+
+func (s *LocalizeSuite) TestLocalizedUnchanged(c *check.C) {
+	// The default language, the messages without a translation and the successful responses are not changed
+	dynamic := response.ErrorInvalidModel
+	dynamic.Message = "MOCK error"
+	tests := []struct {
+		handler http.Handler
+		lang    string
+		code    int
+		body    string
+	}{
+		{errorHandler(response.ErrorInvalidModel), "en-US", http.StatusBadRequest, response.ErrorInvalidModel.Message},
+		{errorHandler(response.ErrorInvalidModel), "fr", http.StatusBadRequest, response.ErrorInvalidModel.Message},
+		{errorHandler(dynamic), "zh-CN", http.StatusBadRequest, "MOCK error"},
+		{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response.FormatStandardResponse(true, "", "", "Invalid data supplied", w)
+		}), "zh", http.StatusOK, "Invalid data supplied"},
+	}
+
+	for _, t := range tests {
+		w := s.send(localized(t.handler), t.lang)
+		c.Check(w.Code, check.Equals, t.code)
+		c.Check(w.Header().Get("Content-Language"), check.Equals, "")
+
+		result := response.StandardResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Check(result.ErrorMessage, check.Equals, t.body)
+	}
+}
+
+func (s *LocalizeSuite) TestLocalizedCompressed(c *check.C) {
+	handler := localized(Compress(localized(errorHandler(response.ErrorInvalidAccount))))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/models", nil)
+	r.Header.Set("Accept-Language", "zh")
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(w.Header().Get("Content-Encoding"), check.Equals, "gzip")
+
+	reader, err := gzip.NewReader(w.Body)
+	c.Assert(err, check.IsNil)
+	result := response.ErrorResponse{}
+	err = json.NewDecoder(reader).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Code, check.Equals, "invalid-account")
+	c.Assert(result.Message, check.Equals, "找不到该账户")
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	logging "github.com/op/go-logging"
+)
+
+// journaldSocket is the socket of the native journald protocol
+var journaldSocket = "/run/systemd/journal/socket"
+
+// journaldPriorities maps the log levels to the syslog priorities used by journald
+var journaldPriorities = map[logging.Level]int{
+	logging.CRITICAL: 2,
+	logging.ERROR:    3,
+	logging.WARNING:  4,
+	logging.NOTICE:   5,
+	logging.INFO:     6,
+	logging.DEBUG:    7,
+}
+
+// JournaldBackend writes the log records to journald using its native protocol, so the
+// level and module of the records are kept as journal fields
+type JournaldBackend struct {
+	lock sync.Mutex
+	tag  string
+	conn *net.UnixConn
+}
+
+// NewJournaldBackend connects to the journald socket
+func NewJournaldBackend(tag string) (*JournaldBackend, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("Error opening the journald log sink: %v", err)
+	}
+	return &JournaldBackend{tag: tag, conn: conn}, nil
+}
+
+// Log writes the log record to the journal
+func (b *JournaldBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	var entry bytes.Buffer
+	journaldField(&entry, "PRIORITY", fmt.Sprint(journaldPriorities[level]))
+	journaldField(&entry, "SYSLOG_IDENTIFIER", b.tag)
+	journaldField(&entry, "SERIAL_VAULT_MODULE", rec.Module)
+	journaldField(&entry, "MESSAGE", rec.Message())
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, err := b.conn.Write(entry.Bytes())
+	return err
+}
+
+// Close closes the connection to journald
+func (b *JournaldBackend) Close() error {
+	return b.conn.Close()
+}
+
+// journaldField encodes a field of a journal entry. A value with a newline is encoded
+// with its length, instead of being terminated by the newline
+func journaldField(entry *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(entry, "%s=%s\n", name, value)
+		return
+	}
+
+	entry.WriteString(name)
+	entry.WriteByte('\n')
+	binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.WriteString(value)
+	entry.WriteByte('\n')
+}
@@ -0,0 +1,64 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+// jsonRecord is a log record written as a JSON object
+type jsonRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Module  string `json:"module"`
+	ID      uint64 `json:"id"`
+	Message string `json:"message"`
+}
+
+// JSONBackend writes the log records as JSON objects, one per line, for log collectors
+type JSONBackend struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewJSONBackend creates a backend that writes JSON log records to the writer
+func NewJSONBackend(w io.Writer) *JSONBackend {
+	return &JSONBackend{enc: json.NewEncoder(w)}
+}
+
+// Log writes the log record as a JSON object
+func (b *JSONBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	r := jsonRecord{
+		Time:    rec.Time.UTC().Format(time.RFC3339Nano),
+		Level:   level.String(),
+		Module:  rec.Module,
+		ID:      rec.ID,
+		Message: rec.Message(),
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.enc.Encode(r)
+}
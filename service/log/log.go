@@ -22,6 +22,8 @@ package log
 import (
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	logging "github.com/op/go-logging"
 )
@@ -30,10 +32,54 @@ import (
 // e.g. "METHOD CODE descriptive reason"
 func Message(method, code, reason string) {
 	log.Printf("%s %s %s\n", method, code, reason)
+	recordFailure(method, code, reason)
+}
+
+// maxRecentFailures is the number of failure messages kept in memory
+const maxRecentFailures = 20
+
+// Failure holds the details of a failed request reported via Message
+type Failure struct {
+	Method  string    `json:"method"`
+	Code    string    `json:"code"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+var recent struct {
+	sync.Mutex
+	failures []Failure
+}
+
+func recordFailure(method, code, reason string) {
+	recent.Lock()
+	defer recent.Unlock()
+
+	recent.failures = append(recent.failures, Failure{method, code, reason, time.Now()})
+	if len(recent.failures) > maxRecentFailures {
+		recent.failures = recent.failures[len(recent.failures)-maxRecentFailures:]
+	}
+}
+
+// RecentFailures returns the most recent failure messages, newest first
+func RecentFailures() []Failure {
+	recent.Lock()
+	defer recent.Unlock()
+
+	failures := make([]Failure, 0, len(recent.failures))
+	for i := len(recent.failures) - 1; i >= 0; i-- {
+		failures = append(failures, recent.failures[i])
+	}
+	return failures
 }
 
 var l = logging.MustGetLogger("serialvault")
 
+// Logger returns the logger of the service, which writes to the configured sinks
+func Logger() *logging.Logger {
+	return l
+}
+
 // InitLogger initializes logger for backend with the specified level
 func InitLogger(level logging.Level) {
 	backend := logging.NewLogBackend(os.Stderr, "", 0)
@@ -51,40 +97,40 @@ func InitLogger(level logging.Level) {
 
 // Errorf calls logger in eror level with format
 func Errorf(format string, args ...interface{}) {
-	l.Errorf(format, args)
+	l.Errorf(format, args...)
 }
 
 // Error calls logger in error level
 func Error(args ...interface{}) {
-	l.Error(args)
+	l.Error(args...)
 }
 
 // Warningf calls logger in warning level with format
 func Warningf(format string, args ...interface{}) {
-	l.Warningf(format, args)
+	l.Warningf(format, args...)
 }
 
 // Warning calls logger in warning level
 func Warning(args ...interface{}) {
-	l.Warning(args)
+	l.Warning(args...)
 }
 
 // Infof calls logger in info level with format
 func Infof(format string, args ...interface{}) {
-	l.Infof(format, args)
+	l.Infof(format, args...)
 }
 
 // Info calls logger in info level
 func Info(args ...interface{}) {
-	l.Info(args)
+	l.Info(args...)
 }
 
 // Debugf calls logger in debug level with format
 func Debugf(format string, args ...interface{}) {
-	l.Debugf(format, args)
+	l.Debugf(format, args...)
 }
 
 // Debug calls logger in debug level
 func Debug(args ...interface{}) {
-	l.Debug(args)
+	l.Debug(args...)
 }
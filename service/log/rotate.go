@@ -0,0 +1,206 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rotation intervals of a log file
+const (
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+)
+
+var rotateIntervals = map[string]time.Duration{
+	RotateHourly: time.Hour,
+	RotateDaily:  24 * time.Hour,
+}
+
+// rotatedSuffix is the time format of the suffix of a rotated log file
+const rotatedSuffix = "20060102-150405.000"
+
+// RotatingFile is a log file that is rotated when it reaches a maximum size or at the start of
+// each interval, so the logs of a host without a log shipper are kept without filling the disk
+type RotatingFile struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	now        func() time.Time
+
+	file   *os.File
+	size   int64
+	period time.Time
+}
+
+// NewRotatingFile opens the log file for appending. The file is rotated after maxSize megabytes
+// and at the rotate interval, when they are set, and the oldest rotated files beyond maxBackups
+// are removed (zero keeps them all)
+func NewRotatingFile(path string, maxSize int, rotate string, maxBackups int) (*RotatingFile, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("The path of the file log sink must be entered")
+	}
+
+	var interval time.Duration
+	if len(rotate) > 0 {
+		var ok bool
+		if interval, ok = rotateIntervals[rotate]; !ok {
+			return nil, fmt.Errorf("Invalid rotate interval '%s' of the file log sink", rotate)
+		}
+	}
+
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		interval:   interval,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Error creating the directory of the file log sink: %v", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends to the log file, rotating it first when needed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.needsRotation(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// needsRotation checks if the write would exceed the maximum size, or if the period has ended.
+// An empty file is not rotated
+func (f *RotatingFile) needsRotation(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && !f.currentPeriod().Equal(f.period)
+}
+
+// open opens the log file, continuing the period of an existing file
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("Error opening the file log sink: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Error opening the file log sink: %v", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.period = f.currentPeriod()
+	if f.size > 0 && f.interval > 0 {
+		f.period = info.ModTime().Truncate(f.interval)
+	}
+	return nil
+}
+
+// rotate renames the log file with the time of the rotation and opens a new file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	rotated := fmt.Sprintf("%s.%s", f.path, f.now().Format(rotatedSuffix))
+	if err := os.Rename(f.path, rotated); err != nil {
+		return fmt.Errorf("Error rotating the file log sink: %v", err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.removeBackups()
+}
+
+// removeBackups removes the oldest rotated files beyond the maximum
+func (f *RotatingFile) removeBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+
+	// The suffixes sort in the order of the rotations
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(b); err != nil {
+			return fmt.Errorf("Error removing a rotated file of the log sink: %v", err)
+		}
+	}
+	return nil
+}
+
+func (f *RotatingFile) currentPeriod() time.Time {
+	if f.interval == 0 {
+		return time.Time{}
+	}
+	return f.now().Truncate(f.interval)
+}
@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	logging "github.com/op/go-logging"
+)
+
+// Types of the log sinks
+const (
+	SinkStderr   = "stderr"
+	SinkStdout   = "stdout"
+	SinkSyslog   = "syslog"
+	SinkJournald = "journald"
+	SinkFile     = "file"
+)
+
+// Formats of the stream and file log sinks
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// defaultTag identifies the service in syslog and journald
+const defaultTag = "serial-vault"
+
+const textFormat = `%{time:2006/01/02 15:04:05.000} %{module} ▶ %{level:.4s} %{id:03x} %{message}`
+
+// opened holds the sinks that must be closed when they are replaced
+var opened struct {
+	sync.Mutex
+	closers []io.Closer
+}
+
+// InitSinks initializes the logger with the configured sinks, each filtering at its own level
+// (the level by default). The standard logger is also written to the sinks, with the messages
+// that start with "Error" at the error level. Without sinks, the logger writes to stderr
+func InitSinks(sinks []config.LogSink, level logging.Level) error {
+	if len(sinks) == 0 {
+		InitLogger(level)
+		return nil
+	}
+
+	backends := []logging.Backend{}
+	closers := []io.Closer{}
+	for _, s := range sinks {
+		backend, closer, err := openSink(s, level)
+		if err != nil {
+			closeAll(closers)
+			return err
+		}
+		backends = append(backends, backend)
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+
+	logging.SetBackend(backends...)
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+
+	// Close the sinks that have been replaced
+	opened.Lock()
+	closeAll(opened.closers)
+	opened.closers = closers
+	opened.Unlock()
+	return nil
+}
+
+// openSink opens the backend of a sink, filtered at its level
+func openSink(s config.LogSink, level logging.Level) (logging.LeveledBackend, io.Closer, error) {
+	if len(s.Level) > 0 {
+		l, err := logging.LogLevel(s.Level)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid level '%s' of the %s log sink", s.Level, s.Type)
+		}
+		level = l
+	}
+
+	tag := s.Tag
+	if len(tag) == 0 {
+		tag = defaultTag
+	}
+
+	var (
+		backend logging.Backend
+		closer  io.Closer
+		err     error
+	)
+	switch s.Type {
+	case SinkStderr:
+		backend, err = streamBackend(os.Stderr, s.Format, FormatText)
+	case SinkStdout:
+		backend, err = streamBackend(os.Stdout, s.Format, FormatJSON)
+	case SinkFile:
+		var f *RotatingFile
+		f, err = NewRotatingFile(s.Path, s.MaxSize, s.Rotate, s.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend, err = streamBackend(f, s.Format, FormatText)
+		closer = f
+	case SinkSyslog:
+		var b *logging.SyslogBackend
+		b, err = logging.NewSyslogBackendPriority(tag, syslog.LOG_DAEMON|syslog.LOG_INFO)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error opening the syslog log sink: %v", err)
+		}
+		backend = logging.NewBackendFormatter(b, logging.MustStringFormatter(`%{module} %{message}`))
+		closer = b.Writer
+	case SinkJournald:
+		var b *JournaldBackend
+		b, err = NewJournaldBackend(tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend, closer = b, b
+	default:
+		return nil, nil, fmt.Errorf("Invalid log sink type '%s'", s.Type)
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+
+	leveled := logging.AddModuleLevel(backend)
+	leveled.SetLevel(level, "")
+	return leveled, closer, nil
+}
+
+// streamBackend writes the logs to a stream, as text lines or JSON objects
+func streamBackend(w io.Writer, format, defaultFormat string) (logging.Backend, error) {
+	if len(format) == 0 {
+		format = defaultFormat
+	}
+
+	switch format {
+	case FormatText:
+		return logging.NewBackendFormatter(logging.NewLogBackend(w, "", 0), logging.MustStringFormatter(textFormat)), nil
+	case FormatJSON:
+		return NewJSONBackend(w), nil
+	default:
+		return nil, fmt.Errorf("Invalid log format '%s'", format)
+	}
+}
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// stdWriter writes the messages of the standard logger to the sinks
+type stdWriter struct{}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	if strings.HasPrefix(message, "Error") {
+		l.Error(message)
+	} else {
+		l.Info(message)
+	}
+	return len(p), nil
+}
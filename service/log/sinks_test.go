@@ -0,0 +1,250 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	logging "github.com/op/go-logging"
+)
+
+func resetSinks() {
+	opened.Lock()
+	closeAll(opened.closers)
+	opened.closers = nil
+	opened.Unlock()
+
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+	InitLogger(logging.INFO)
+}
+
+func readLines(t *testing.T, path string) []string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading the log file: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestInitSinksLevels(t *testing.T) {
+	defer resetSinks()
+
+	dir, err := ioutil.TempDir("", "serial-vault-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	debug := filepath.Join(dir, "debug.log")
+	errors := filepath.Join(dir, "logs", "errors.json")
+
+	err = InitSinks([]config.LogSink{
+		{Type: SinkFile, Path: debug, Level: "debug"},
+		{Type: SinkFile, Path: errors, Level: "ERROR", Format: FormatJSON},
+	}, logging.INFO)
+	if err != nil {
+		t.Fatalf("Expected the sinks to open: %v", err)
+	}
+
+	Debugf("debug %d", 1)
+	Infof("info %s", "two")
+	Errorf("error %d", 3)
+	log.Printf("Error from the standard logger\n")
+	log.Println("Standard logger")
+
+	lines := readLines(t, debug)
+	if len(lines) != 5 {
+		t.Fatalf("Expected all the messages in the debug sink, got: %v", lines)
+	}
+	expected := []struct{ level, message string }{
+		{"DEBU", "debug 1"}, {"INFO", "info two"}, {"ERRO", "error 3"}, {"ERRO", "Error from the standard logger"}, {"INFO", "Standard logger"},
+	}
+	for i, e := range expected {
+		if !strings.Contains(lines[i], " serialvault ▶ "+e.level+" ") || !strings.HasSuffix(lines[i], " "+e.message) {
+			t.Errorf("Expected the %s message '%s', got: %s", e.level, e.message, lines[i])
+		}
+	}
+
+	lines = readLines(t, errors)
+	if len(lines) != 2 {
+		t.Fatalf("Expected the errors in the error sink, got: %v", lines)
+	}
+	r := jsonRecord{}
+	if err = json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatalf("Expected a JSON record: %v", err)
+	}
+	if r.Level != "ERROR" || r.Module != "serialvault" || r.Message != "error 3" || len(r.Time) == 0 {
+		t.Errorf("Unexpected JSON record: %v", r)
+	}
+}
+
+func TestInitSinksInvalid(t *testing.T) {
+	defer resetSinks()
+
+	dir, err := ioutil.TempDir("", "serial-vault-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := [][]config.LogSink{
+		{{Type: "kafka"}},
+		{{Type: SinkStderr, Level: "verbose"}},
+		{{Type: SinkStdout, Format: "xml"}},
+		{{Type: SinkFile}},
+		{{Type: SinkFile, Path: filepath.Join(dir, "a.log"), Rotate: "weekly"}},
+		{{Type: SinkStderr}, {Type: SinkFile, Path: filepath.Join(dir, "b.log"), Format: "xml"}},
+	}
+
+	for _, sinks := range tests {
+		if err := InitSinks(sinks, logging.INFO); err == nil {
+			t.Errorf("Expected an error for the sinks: %v", sinks)
+		}
+	}
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial-vault-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.log")
+
+	f, err := NewRotatingFile(path, 1, "", 2)
+	if err != nil {
+		t.Fatalf("Expected the file to open: %v", err)
+	}
+	defer f.Close()
+
+	clock := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	line := bytes.Repeat([]byte("x"), 600*1024)
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Error writing the log file: %v", err)
+		}
+	}
+
+	// Each line is rotated, keeping the two most recent rotated files
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 rotated files, got: %v", backups)
+	}
+	if filepath.Base(backups[0]) != "service.log.20261015-090003.000" || filepath.Base(backups[1]) != "service.log.20261015-090004.000" {
+		t.Errorf("Expected the most recent rotated files, got: %v", backups)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(line)) {
+		t.Errorf("Expected the last line in the log file, got %d bytes", info.Size())
+	}
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial-vault-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.log")
+
+	f, err := NewRotatingFile(path, 0, RotateHourly, 0)
+	if err != nil {
+		t.Fatalf("Expected the file to open: %v", err)
+	}
+	defer f.Close()
+
+	clock := time.Date(2026, 10, 15, 9, 10, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+	f.period = f.currentPeriod()
+
+	f.Write([]byte("first\n"))
+	clock = clock.Add(30 * time.Minute)
+	f.Write([]byte("second\n"))
+	clock = clock.Add(30 * time.Minute)
+	f.Write([]byte("third\n"))
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("Expected a rotated file, got: %v", backups)
+	}
+	if lines := readLines(t, backups[0]); len(lines) != 2 {
+		t.Errorf("Expected the first hour in the rotated file, got: %v", lines)
+	}
+	if lines := readLines(t, path); len(lines) != 1 || lines[0] != "third" {
+		t.Errorf("Expected the second hour in the log file, got: %v", lines)
+	}
+}
+
+func TestJournaldBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial-vault-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Error creating the journal socket: %v", err)
+	}
+	defer conn.Close()
+
+	defer func(s string) { journaldSocket = s }(journaldSocket)
+	journaldSocket = socket
+
+	b, err := NewJournaldBackend("vault")
+	if err != nil {
+		t.Fatalf("Expected the journal to open: %v", err)
+	}
+	defer b.Close()
+
+	logger := logging.MustGetLogger("journaltest")
+	logger.SetBackend(logging.AddModuleLevel(b))
+	logger.Warning("two\nlines")
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading the journal entry: %v", err)
+	}
+
+	var expected bytes.Buffer
+	expected.WriteString("PRIORITY=4\nSYSLOG_IDENTIFIER=vault\nSERIAL_VAULT_MODULE=journaltest\nMESSAGE\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(9))
+	expected.WriteString("two\nlines\n")
+	if !bytes.Equal(buf[:n], expected.Bytes()) {
+		t.Errorf("Unexpected journal entry: %q", buf[:n])
+	}
+}
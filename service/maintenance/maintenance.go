@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package maintenance checks the scheduled maintenance windows, during which signing is paused
+// so that e.g. database migrations or key ceremonies can be performed
+package maintenance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// Window is a parsed maintenance window
+type Window struct {
+	Start  time.Time
+	End    time.Time
+	Brand  string
+	Model  string
+	Reason string
+}
+
+// Applies checks if the window pauses the signing for the model at the time. A request
+// without a model, e.g. for a nonce, is only paused by the windows of all the models
+func (w Window) Applies(brand, model string, now time.Time) bool {
+	if now.Before(w.Start) || !now.Before(w.End) {
+		return false
+	}
+	if len(w.Brand) == 0 {
+		return true
+	}
+	return w.Brand == brand && (len(w.Model) == 0 || w.Model == model)
+}
+
+// Windows parses the maintenance windows of the config
+func Windows(settings config.Settings) ([]Window, error) {
+	windows := []Window{}
+	for i, m := range settings.MaintenanceWindows {
+		start, err := time.Parse(time.RFC3339, m.Start)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: invalid start: %v", i+1, err)
+		}
+		end, err := time.Parse(time.RFC3339, m.End)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: invalid end: %v", i+1, err)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("maintenance window %d: the end must be after the start", i+1)
+		}
+		if len(m.Model) > 0 && len(m.Brand) == 0 {
+			return nil, fmt.Errorf("maintenance window %d: the brand of the model must be provided", i+1)
+		}
+		windows = append(windows, Window{Start: start, End: end, Brand: m.Brand, Model: m.Model, Reason: m.Reason})
+	}
+	return windows, nil
+}
+
+// Active returns the maintenance window that pauses the signing for the model at the time. When
+// windows overlap, it is the one that ends last. The windows are validated when the service starts
+func Active(settings config.Settings, brand, model string, now time.Time) (Window, bool) {
+	windows, err := Windows(settings)
+	if err != nil {
+		return Window{}, false
+	}
+
+	var active Window
+	var found bool
+	for _, w := range windows {
+		if w.Applies(brand, model, now) && (!found || w.End.After(active.End)) {
+			active, found = w, true
+		}
+	}
+	return active, found
+}
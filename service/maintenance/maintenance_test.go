@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestWindowsInvalid(t *testing.T) {
+	tests := []config.MaintenanceWindow{
+		{Start: "tomorrow", End: "2026-11-01T04:00:00Z"},
+		{Start: "2026-11-01T02:00:00Z", End: ""},
+		{Start: "2026-11-01T04:00:00Z", End: "2026-11-01T02:00:00Z"},
+		{Start: "2026-11-01T02:00:00Z", End: "2026-11-01T04:00:00Z", Model: "alder"},
+	}
+
+	for _, m := range tests {
+		_, err := Windows(config.Settings{MaintenanceWindows: []config.MaintenanceWindow{m}})
+		if err == nil {
+			t.Errorf("Window %v: expected an error", m)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	settings := config.Settings{MaintenanceWindows: []config.MaintenanceWindow{
+		{Start: "2026-11-01T02:00:00Z", End: "2026-11-01T04:00:00Z", Reason: "Database migration"},
+		{Start: "2026-11-01T03:00:00Z", End: "2026-11-01T05:00:00Z", Brand: "system"},
+		{Start: "2026-11-08T09:00:00Z", End: "2026-11-08T10:00:00Z", Brand: "system", Model: "alder"},
+	}}
+
+	tests := []struct {
+		brand  string
+		model  string
+		now    string
+		active bool
+		end    string
+	}{
+		{"system", "alder", "2026-11-01T01:59:59Z", false, ""},
+		{"system", "alder", "2026-11-01T02:00:00Z", true, "2026-11-01T04:00:00Z"},
+		{"system", "alder", "2026-11-01T03:30:00Z", true, "2026-11-01T05:00:00Z"},
+		{"generic", "alder", "2026-11-01T03:30:00Z", true, "2026-11-01T04:00:00Z"},
+		{"", "", "2026-11-01T04:30:00Z", false, ""},
+		{"system", "ash", "2026-11-01T04:30:00Z", true, "2026-11-01T05:00:00Z"},
+		{"system", "alder", "2026-11-08T09:30:00Z", true, "2026-11-08T10:00:00Z"},
+		{"system", "ash", "2026-11-08T09:30:00Z", false, ""},
+		{"system", "alder", "2026-11-08T10:00:00Z", false, ""},
+	}
+
+	for _, tt := range tests {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		w, active := Active(settings, tt.brand, tt.model, now)
+		if active != tt.active {
+			t.Errorf("%s/%s at %s: expected active %v, got %v", tt.brand, tt.model, tt.now, tt.active, active)
+			continue
+		}
+		if active && w.End.Format(time.RFC3339) != tt.end {
+			t.Errorf("%s/%s at %s: expected the window to end at %s, got %s", tt.brand, tt.model, tt.now, tt.end, w.End.Format(time.RFC3339))
+		}
+	}
+}
@@ -21,14 +21,19 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
 	"time"
 
-	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/random"
+	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
 )
 
 // Logger Handle logging for the web service
@@ -46,6 +51,10 @@ func ErrorHandler(f func(http.ResponseWriter, *http.Request) response.ErrorRespo
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Call the handler and it will return a custom error
 		e := f(w, r)
+		if !e.Success && request.AcceptsCBOR(r) {
+			response.FormatCBORError(e, w)
+			return
+		}
 		if !e.Success {
 			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 			w.WriteHeader(e.StatusCode)
@@ -58,20 +67,82 @@ func ErrorHandler(f func(http.ResponseWriter, *http.Request) response.ErrorRespo
 	}
 }
 
+// CorrelationIDHeader is the response header that identifies the log entry of an internal error
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// Recover is a middleware that converts a panic in the handler into an internal error
+// response. The stack is logged with a correlation ID, which is returned to the caller
+func Recover(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// The server aborts the response without logging
+				panic(p)
+			}
+
+			metrics.Increment(metrics.Panics)
+
+			correlationID, err := random.GenerateRandomString(12)
+			if err != nil {
+				correlationID = fmt.Sprintf("%d", time.Now().UnixNano())
+			}
+			log.Printf("Panic handling %s %s [%s]: %v\n%s", r.Method, r.RequestURI, correlationID, p, debug.Stack())
+
+			e := response.ErrorInternal
+			e.Message = fmt.Sprintf("%s (correlation ID: %s)", e.Message, correlationID)
+
+			w.Header().Set(CorrelationIDHeader, correlationID)
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(e.StatusCode)
+			if err := json.NewEncoder(w).Encode(e); err != nil {
+				log.Printf("Error forming the error response: %v\n", err)
+			}
+		}()
+
+		inner.ServeHTTP(w, r)
+	})
+}
+
 // Middleware to pre-process web service requests
 func Middleware(inner http.Handler) http.Handler {
+	return middleware(inner, Logger)
+}
+
+// middleware pre-processes the web service requests, logging them with the logger
+func middleware(inner http.Handler, logger func(time.Time, *http.Request)) http.Handler {
+	recoverer := Recover(inner)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		// Log the request
-		Logger(start, r)
+		logger(start, r)
 
-		inner.ServeHTTP(w, r)
+		recoverer.ServeHTTP(w, r)
+
+		metrics.ObserveRequest(endpoint(r), time.Since(start))
 	})
 }
 
-// MiddlewareWithCSRF to pre-process web service requests with CSRF protection
-var MiddlewareWithCSRF = func(inner http.Handler) http.Handler {
+// endpoint identifies the route of the request, without the values of its variables
+func endpoint(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return r.Method + " " + metrics.Other
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return r.Method + " " + metrics.Other
+	}
+	return r.Method + " " + template
+}
+
+// CSRFProtection protects the handler from request forgery, using the authentication key
+var CSRFProtection = func(inner http.Handler, authKey string) http.Handler {
 	// configure request forgery protection
 	csrfSecure := true
 	csrfSecureEnv := os.Getenv("CSRF_SECURE")
@@ -80,10 +151,10 @@ var MiddlewareWithCSRF = func(inner http.Handler) http.Handler {
 	}
 
 	CSRF := csrf.Protect(
-		[]byte(datastore.Environ.Config.CSRFAuthKey),
+		[]byte(authKey),
 		csrf.Secure(csrfSecure),
 		csrf.HttpOnly(csrfSecure),
 	)
 
-	return CSRF(Middleware(inner))
+	return CSRF(inner)
 }
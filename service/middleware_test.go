@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+	check "gopkg.in/check.v1"
+)
+
+func TestMiddlewareSuite(t *testing.T) { check.TestingT(t) }
+
+type MiddlewareSuite struct{}
+
+var _ = check.Suite(&MiddlewareSuite{})
+
+func (s *MiddlewareSuite) TestRecover(c *check.C) {
+	panics := metrics.Value(metrics.Panics)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("MOCK panic")
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/serial", nil)
+	handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, check.Equals, http.StatusInternalServerError)
+	correlationID := w.Header().Get(CorrelationIDHeader)
+	c.Assert(correlationID, check.Not(check.Equals), "")
+
+	result := response.ErrorResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.Code, check.Equals, "error-internal")
+	c.Assert(result.Message, check.Equals, "An unexpected error occurred (correlation ID: "+correlationID+")")
+
+	c.Assert(metrics.Value(metrics.Panics), check.Equals, panics+1)
+}
+
+func (s *MiddlewareSuite) TestRecoverNoPanic(c *check.C) {
+	panics := metrics.Value(metrics.Panics)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/version", nil)
+	handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, check.Equals, http.StatusAccepted)
+	c.Assert(w.Header().Get(CorrelationIDHeader), check.Equals, "")
+	c.Assert(metrics.Value(metrics.Panics), check.Equals, panics)
+}
+
+func (s *MiddlewareSuite) TestMetrics(c *check.C) {
+	metrics.Increment(metrics.Panics)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/metrics", nil)
+	Middleware(http.HandlerFunc(metrics.Handler)).ServeHTTP(w, r)
+
+	result := map[string]int64{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result[metrics.Panics], check.Equals, metrics.Value(metrics.Panics))
+}
+
+func (s *MiddlewareSuite) TestEndpointMetrics(c *check.C) {
+	router := mux.NewRouter()
+	router.Handle("/api/models/{id:[0-9]+}", Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))).Methods("GET")
+
+	count := metrics.Requests()["GET /api/models/{id:[0-9]+}"].Count
+	for _, url := range []string{"/api/models/1", "/api/models/2"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, http.StatusOK)
+	}
+
+	h := metrics.Requests()["GET /api/models/{id:[0-9]+}"]
+	c.Assert(h.Count, check.Equals, count+2)
+	c.Assert(h.Buckets, check.HasLen, len(metrics.LatencyBuckets)+1)
+	c.Assert(h.Buckets[0].Bound, check.Equals, "5ms")
+	c.Assert(h.Buckets[len(h.Buckets)-1].Bound, check.Equals, "+Inf")
+}
+
+func (s *MiddlewareSuite) TestErrorHandlerCBOR(c *check.C) {
+	handler := ErrorHandler(func(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+		return response.ErrorInvalidNonce
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/serial", nil)
+	r.Header.Set("Accept", "application/cbor")
+	handler.ServeHTTP(w, r)
+
+	expected, err := response.EncodeCBOR(map[string]interface{}{"error_code": response.ErrorInvalidNonce.Code, "message": response.ErrorInvalidNonce.Message})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(w.Code, check.Equals, response.ErrorInvalidNonce.StatusCode)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, response.CBORHeader)
+	c.Assert(w.Header().Get("Content-Length"), check.Not(check.Equals), "")
+	c.Assert(bytes.Equal(w.Body.Bytes(), expected), check.Equals, true)
+}
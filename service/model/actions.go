@@ -21,12 +21,16 @@ package model
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
 )
 
 // ListResponse is the JSON response from the API Models method
@@ -47,17 +51,103 @@ type GetResponse struct {
 	Model        datastore.Model `json:"model"`
 }
 
+// PreviewResponse is the JSON response from the API serial assertion preview method
+type PreviewResponse struct {
+	Success      bool              `json:"success"`
+	ErrorCode    string            `json:"error_code"`
+	ErrorSubcode string            `json:"error_subcode"`
+	ErrorMessage string            `json:"message"`
+	Headers      map[string]string `json:"headers"`
+	Problems     []string          `json:"problems"`
+}
+
+// FallbackKeysRequest is the JSON request to set the ordered fallback signing-keys of a model
+type FallbackKeysRequest struct {
+	KeypairIDs []int `json:"keypair-ids"`
+}
+
+// FallbackKey is a fallback signing-key of a model, without the sealed key
+type FallbackKey struct {
+	ID          int    `json:"id"`
+	AuthorityID string `json:"authority-id"`
+	KeyID       string `json:"key-id"`
+	Active      bool   `json:"active"`
+	KeyName     string `json:"key-name"`
+}
+
+// FallbackKeysResponse is the JSON response from the API fallback signing-keys method
+type FallbackKeysResponse struct {
+	Success      bool          `json:"success"`
+	ErrorCode    string        `json:"error_code"`
+	ErrorSubcode string        `json:"error_subcode"`
+	ErrorMessage string        `json:"message"`
+	Keypairs     []FallbackKey `json:"keypairs"`
+}
+
+// CanaryRequest is the JSON request to set the canary signing-key of a model. A zero
+// percentage removes the canary key
+type CanaryRequest struct {
+	KeypairID int `json:"keypair-id"`
+	Percent   int `json:"percent"`
+}
+
+// CanaryResponse is the JSON response from the API canary signing-key method, with the
+// signing results of the keys of the model
+type CanaryResponse struct {
+	Success      bool                       `json:"success"`
+	ErrorCode    string                     `json:"error_code"`
+	ErrorSubcode string                     `json:"error_subcode"`
+	ErrorMessage string                     `json:"message"`
+	Canary       datastore.ModelCanary      `json:"canary"`
+	Results      []datastore.ModelKeyResult `json:"results"`
+}
+
+// CloneRequest is the JSON request to clone a model under new model names, using the
+// model as the template of the product family
+type CloneRequest struct {
+	Models []string `json:"models"`
+}
+
+// CloneResponse is the JSON response from the API clone method, with the new models
+type CloneResponse struct {
+	Success      bool              `json:"success"`
+	ErrorCode    string            `json:"error_code"`
+	ErrorSubcode string            `json:"error_subcode"`
+	ErrorMessage string            `json:"message"`
+	Models       []datastore.Model `json:"models"`
+}
+
+// KeypairAssignmentResponse is the JSON response from the API method to re-point the models
+// of a keypair, with the models that are changed (or would be, for a preview)
+type KeypairAssignmentResponse struct {
+	Success      bool                           `json:"success"`
+	ErrorCode    string                         `json:"error_code"`
+	ErrorSubcode string                         `json:"error_subcode"`
+	ErrorMessage string                         `json:"message"`
+	Preview      bool                           `json:"preview"`
+	Changes      []datastore.ModelKeypairChange `json:"changes"`
+}
+
+// KeypairHistoryResponse is the JSON response from the API keypair history method of a model
+type KeypairHistoryResponse struct {
+	Success      bool                           `json:"success"`
+	ErrorCode    string                         `json:"error_code"`
+	ErrorSubcode string                         `json:"error_subcode"`
+	ErrorMessage string                         `json:"message"`
+	Changes      []datastore.ModelKeypairChange `json:"changes"`
+}
+
 // listHandler is the API method to fetch the user records
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Standard, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Standard, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	dbModels, err := datastore.Environ.DB.ListAllowedModels(user)
+	dbModels, err := srv.DB.ListAllowedModels(user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-models", "", err.Error(), w)
 		return
@@ -69,16 +159,16 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 }
 
 // getHandler is the API method to fetch the models
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+func (srv *Service) getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	model, err := datastore.Environ.DB.GetAllowedModel(modelID, user)
+	model, err := srv.DB.GetAllowedModel(modelID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-model", "", err.Error(), w)
 		return
@@ -89,10 +179,10 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelI
 	formatGetResponse(model, w)
 }
 
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, mdl datastore.Model) {
+func (srv *Service) updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, mdl datastore.Model) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
@@ -103,7 +193,18 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mod
 		return
 	}
 
-	errorSubcode, err := datastore.Environ.DB.UpdateAllowedModel(mdl, user)
+	// Changing the signing-keys of the model may need the approval of a second admin
+	if _, ok := approval.Required(srv.Config, datastore.ApprovalModelSigningKey); ok {
+		current, err := srv.DB.GetAllowedModel(modelID, user)
+		if err == nil && (current.KeypairID != mdl.KeypairID || current.KeypairIDUser != mdl.KeypairIDUser) {
+			details := fmt.Sprintf("keypair-id=%d keypair-id-user=%d", mdl.KeypairID, mdl.KeypairIDUser)
+			if !approval.Approved(w, srv.Env, user, datastore.ApprovalModelSigningKey, current.BrandID, fmt.Sprintf("model/%d", modelID), details) {
+				return
+			}
+		}
+	}
+
+	errorSubcode, err := srv.DB.UpdateAllowedModel(mdl, user)
 	if err != nil {
 		log.Println("Error updating the store:", err)
 		response.FormatStandardResponse(false, "error-updating-model", errorSubcode, "Error updating the model", w)
@@ -115,17 +216,17 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mod
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+func (srv *Service) deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
 	mdl := datastore.Model{ID: modelID}
-	errorSubcode, err := datastore.Environ.DB.DeleteAllowedModel(mdl, user)
+	errorSubcode, err := srv.DB.DeleteAllowedModel(mdl, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-deleting-model", errorSubcode, err.Error(), w)
 		return
@@ -136,16 +237,16 @@ func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mod
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mdl datastore.Model) {
+func (srv *Service) createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mdl datastore.Model) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	_, errorSubcode, err := datastore.Environ.DB.CreateAllowedModel(mdl, user)
+	_, errorSubcode, err := srv.DB.CreateAllowedModel(mdl, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-model-json", errorSubcode, "", w)
 		return
@@ -156,23 +257,23 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mdl
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func assertionHeaders(w http.ResponseWriter, user datastore.User, apiCall bool, assert datastore.ModelAssertion) {
+func (srv *Service) assertionHeaders(w http.ResponseWriter, user datastore.User, apiCall bool, assert datastore.ModelAssertion) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
 	// Check that the user has permissions to access the model
-	_, err = datastore.Environ.DB.GetAllowedModel(assert.ModelID, user)
+	_, err = srv.DB.GetAllowedModel(assert.ModelID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-get-model", "", "Cannot find model with the selected ID", w)
 		return
 	}
 
-	err = datastore.Environ.DB.UpsertModelAssert(assert)
+	err = srv.DB.UpsertModelAssert(assert)
 	if err != nil {
 		response.FormatStandardResponse(false, "create-assertion", "", err.Error(), w)
 		return
@@ -182,6 +283,364 @@ func assertionHeaders(w http.ResponseWriter, user datastore.User, apiCall bool,
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
+// previewHandler is the API method to preview the serial assertion that would be signed for
+// a model, without signing it. The problems with the configuration of the model are listed,
+// so they can be fixed before the factory starts
+func (srv *Service) previewHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	model, err := srv.DB.GetAllowedModel(modelID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-model", "", err.Error(), w)
+		return
+	}
+
+	// The headers of the serial assertion, as they are set when a serial-request is signed
+	headers := map[string]string{
+		"type":                asserts.SerialType.Name,
+		"authority-id":        model.BrandID,
+		"brand-id":            model.BrandID,
+		"model":               model.Name,
+		"serial":              "<serial from the serial-request>",
+		"device-key":          "<device-key from the serial-request>",
+		"device-key-sha3-384": "<device-key-sha3-384 from the serial-request>",
+		"sign-key-sha3-384":   model.KeyID,
+		"revision":            "<revision of the serial>",
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatPreviewResponse(headers, srv.previewProblems(model), w)
+}
+
+// fallbackKeysHandler is the API method to fetch the fallback signing-keys of a model,
+// in the order that they are tried when the signing-key of the model fails
+func (srv *Service) fallbackKeysHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	model, err := srv.DB.GetAllowedModel(modelID, user)
+	if err != nil || model.ID == 0 {
+		response.FormatStandardResponse(false, "error-fetch-model", "", "Cannot find the model", w)
+		return
+	}
+
+	keypairs, err := srv.DB.ListModelFallbackKeypairs(model.ID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-keypairs", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatFallbackKeysResponse(keypairs, w)
+}
+
+// updateFallbackKeysHandler is the API method to replace the fallback signing-keys of a model
+func (srv *Service) updateFallbackKeysHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, req FallbackKeysRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = srv.DB.UpdateAllowedModelFallbackKeypairs(modelID, req.KeypairIDs, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-updating-model", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// canaryHandler is the API method to fetch the canary signing-key of a model and the
+// signing results of its keys
+func (srv *Service) canaryHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	model, err := srv.DB.GetAllowedModel(modelID, user)
+	if err != nil || model.ID == 0 {
+		response.FormatStandardResponse(false, "error-fetch-model", "", "Cannot find the model", w)
+		return
+	}
+
+	canary, err := srv.DB.GetModelCanary(model.ID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-canary", "", err.Error(), w)
+		return
+	}
+
+	results, err := srv.DB.ListModelKeyResults(model.ID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-canary", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatCanaryResponse(canary, results, w)
+}
+
+// updateCanaryHandler is the API method to set the canary signing-key of a model
+func (srv *Service) updateCanaryHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, req CanaryRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	canary := datastore.ModelCanary{ModelID: modelID, KeypairID: req.KeypairID, Percent: req.Percent}
+	err = srv.DB.UpdateAllowedModelCanary(canary, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-updating-model", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// cloneHandler is the API method to create models with the configuration of a template
+// model: the signing-keys, the timestamp policy, the model assertion details, the
+// fallback and canary signing-keys and the provisioning stations. Each new model gets
+// its own API key
+func (srv *Service) cloneHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, req CloneRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	template, err := srv.DB.GetAllowedModel(modelID, user)
+	if err != nil || template.ID == 0 {
+		response.FormatStandardResponse(false, "error-fetch-model", "", "Cannot find the model", w)
+		return
+	}
+
+	// Check all the names before creating any model
+	if len(req.Models) == 0 {
+		response.FormatStandardResponse(false, "error-model-data", "", "No model names supplied.", w)
+		return
+	}
+	seen := map[string]bool{}
+	for _, name := range req.Models {
+		if seen[name] {
+			response.FormatStandardResponse(false, "error-model-data", "", fmt.Sprintf("The model name '%s' is repeated", name), w)
+			return
+		}
+		seen[name] = true
+
+		if srv.DB.CheckModelExists(template.BrandID, name) {
+			response.FormatStandardResponse(false, "error-model-exists", "", fmt.Sprintf("The model '%s' already exists", name), w)
+			return
+		}
+	}
+
+	models := []datastore.Model{}
+	for _, name := range req.Models {
+		mdl, err := srv.cloneModel(template, name, user)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-clone-model", "", fmt.Sprintf("Error cloning the model '%s': %v", name, err), w)
+			return
+		}
+		models = append(models, mdl)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatCloneResponse(models, w)
+}
+
+// assignKeypairHandler is the API method to re-point the models of a keypair to a new keypair
+// in one transaction, e.g. when the keypair is retired. A preview returns the affected models
+// without changing them
+func (srv *Service) assignKeypairHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assignment datastore.ModelKeypairAssignment) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if err := datastore.ValidateModelKeypairAssignment(assignment); err != nil {
+		response.FormatStandardResponse(false, "error-keypair-assignment-data", "", err.Error(), w)
+		return
+	}
+
+	// Changing the signing-keys of the models may need the approval of a second admin
+	if _, ok := approval.Required(srv.Config, datastore.ApprovalModelSigningKey); ok && !assignment.Preview {
+		details := fmt.Sprintf("from-keypair-id=%d keypair-id=%d models=%v", assignment.FromKeypairID, assignment.KeypairID, assignment.ModelIDs)
+		keypair, _ := srv.DB.GetKeypair(assignment.FromKeypairID)
+		if !approval.Approved(w, srv.Env, user, datastore.ApprovalModelSigningKey, keypair.AuthorityID, fmt.Sprintf("keypair/%d", assignment.FromKeypairID), details) {
+			return
+		}
+	}
+
+	changes, err := srv.DB.AssignAllowedModelKeypair(assignment, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-assign-keypair", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatKeypairAssignmentResponse(assignment.Preview, changes, w)
+}
+
+// keypairHistoryHandler is the API method to fetch the audit of the re-pointed keypairs of a model
+func (srv *Service) keypairHistoryHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	changes, err := srv.DB.ListAllowedModelKeypairHistory(modelID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-keypair-history", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatKeypairHistoryResponse(changes, w)
+}
+
+// cloneModel creates a model with the configuration of the template model
+func (srv *Service) cloneModel(template datastore.Model, name string, user datastore.User) (datastore.Model, error) {
+	db := srv.DB
+
+	mdl, _, err := db.CreateAllowedModel(datastore.Model{BrandID: template.BrandID, Name: name, KeypairID: template.KeypairID, KeypairIDUser: template.KeypairIDUser, TimestampPolicy: template.TimestampPolicy, DeviceKeyPolicy: template.DeviceKeyPolicy, SerialPipeline: template.SerialPipeline, DuplicatePolicy: template.DuplicatePolicy, SerialResponse: template.SerialResponse}, user)
+	if err != nil {
+		return mdl, err
+	}
+
+	if template.ModelAssertion.ID > 0 {
+		assert := template.ModelAssertion
+		assert.ID = 0
+		assert.ModelID = mdl.ID
+		if _, err = db.CreateModelAssert(assert); err != nil {
+			return mdl, err
+		}
+	}
+
+	keypairs, err := db.ListModelFallbackKeypairs(template.ID)
+	if err != nil {
+		return mdl, err
+	}
+	if len(keypairs) > 0 {
+		keypairIDs := []int{}
+		for _, k := range keypairs {
+			keypairIDs = append(keypairIDs, k.ID)
+		}
+		if err = db.UpdateAllowedModelFallbackKeypairs(mdl.ID, keypairIDs, user); err != nil {
+			return mdl, err
+		}
+	}
+
+	canary, err := db.GetModelCanary(template.ID)
+	if err != nil {
+		return mdl, err
+	}
+	if canary.KeypairID > 0 {
+		canary.ModelID = mdl.ID
+		if err = db.UpdateAllowedModelCanary(canary, user); err != nil {
+			return mdl, err
+		}
+	}
+
+	stations, err := db.ListAllowedStations(template.ID, user)
+	if err != nil {
+		return mdl, err
+	}
+	for _, s := range stations {
+		s.ID = 0
+		s.ModelID = mdl.ID
+		if err = db.CreateAllowedStation(s, user); err != nil {
+			return mdl, err
+		}
+	}
+
+	return db.GetAllowedModel(mdl.ID, user)
+}
+
+// previewProblems checks the signing configuration of a model
+func (srv *Service) previewProblems(model datastore.Model) []string {
+	problems := []string{}
+
+	if len(model.KeyID) == 0 {
+		return append(problems, "The model does not have a signing-key")
+	}
+	if model.AuthorityID != model.BrandID {
+		problems = append(problems, fmt.Sprintf("The signing-key belongs to '%s', but the serial assertion is issued by the brand '%s'", model.AuthorityID, model.BrandID))
+	}
+	if !model.KeyActive {
+		problems = append(problems, "The signing-key is disabled")
+	}
+
+	keypair, err := srv.DB.GetKeypair(model.KeypairID)
+	if err != nil {
+		return append(problems, fmt.Sprintf("Cannot find the signing-key: %v", err))
+	}
+	if len(keypair.Assertion) == 0 {
+		problems = append(problems, "The account-key assertion of the signing-key has not been uploaded")
+	} else {
+		problems = append(problems, accountKeyProblems(keypair, model)...)
+	}
+
+	if err = srv.DB.CheckSigningAuthorization(model.BrandID, model.Name); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return problems
+}
+
+// accountKeyProblems checks that the account-key assertion matches the signing-key and the brand
+func accountKeyProblems(keypair datastore.Keypair, model datastore.Model) []string {
+	assertion, err := asserts.Decode([]byte(keypair.Assertion))
+	if err != nil {
+		return []string{fmt.Sprintf("Cannot decode the account-key assertion: %v", err)}
+	}
+
+	accountKey, ok := assertion.(*asserts.AccountKey)
+	if !ok {
+		return []string{"The assertion of the signing-key is not an account-key assertion"}
+	}
+
+	problems := []string{}
+	if accountKey.AccountID() != model.BrandID {
+		problems = append(problems, fmt.Sprintf("The account-key assertion is for account '%s', not the brand '%s'", accountKey.AccountID(), model.BrandID))
+	}
+	if accountKey.PublicKeyID() != model.KeyID {
+		problems = append(problems, "The account-key assertion is for a different signing-key")
+	}
+	return problems
+}
+
 func formatListResponse(models []datastore.Model, w http.ResponseWriter) error {
 	response := ListResponse{Success: true, Models: models}
 
@@ -203,3 +662,73 @@ func formatGetResponse(model datastore.Model, w http.ResponseWriter) error {
 	}
 	return nil
 }
+
+func formatPreviewResponse(headers map[string]string, problems []string, w http.ResponseWriter) error {
+	response := PreviewResponse{Success: true, Headers: headers, Problems: problems}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the preview response.")
+		return err
+	}
+	return nil
+}
+
+func formatFallbackKeysResponse(keypairs []datastore.Keypair, w http.ResponseWriter) error {
+	keys := []FallbackKey{}
+	for _, k := range keypairs {
+		keys = append(keys, FallbackKey{ID: k.ID, AuthorityID: k.AuthorityID, KeyID: k.KeyID, Active: k.Active, KeyName: k.KeyName})
+	}
+	response := FallbackKeysResponse{Success: true, Keypairs: keys}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the fallback keys response.")
+		return err
+	}
+	return nil
+}
+
+func formatCanaryResponse(canary datastore.ModelCanary, results []datastore.ModelKeyResult, w http.ResponseWriter) error {
+	response := CanaryResponse{Success: true, Canary: canary, Results: results}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the canary response.")
+		return err
+	}
+	return nil
+}
+
+func formatCloneResponse(models []datastore.Model, w http.ResponseWriter) error {
+	response := CloneResponse{Success: true, Models: models}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the clone response.")
+		return err
+	}
+	return nil
+}
+
+func formatKeypairAssignmentResponse(preview bool, changes []datastore.ModelKeypairChange, w http.ResponseWriter) error {
+	response := KeypairAssignmentResponse{Success: true, Preview: preview, Changes: changes}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the keypair assignment response.")
+		return err
+	}
+	return nil
+}
+
+func formatKeypairHistoryResponse(changes []datastore.ModelKeypairChange, w http.ResponseWriter) error {
+	response := KeypairHistoryResponse{Success: true, Changes: changes}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the keypair history response.")
+		return err
+	}
+	return nil
+}
@@ -33,22 +33,22 @@ import (
 )
 
 // APIList is the API method to fetch the models
-func APIList(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	srv.listHandler(w, user, true)
 }
 
 // APIGet is the API method to fetch a model
-func APIGet(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIGet(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -62,13 +62,13 @@ func APIGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	getHandler(w, user, true, id)
+	srv.getHandler(w, user, true, id)
 }
 
 // APIUpdate is the API method to update a model
-func APIUpdate(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIUpdate(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -97,13 +97,13 @@ func APIUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateHandler(w, user, true, modelID, mdl)
+	srv.updateHandler(w, user, true, modelID, mdl)
 }
 
 // APIDelete is the API method to delete a model
-func APIDelete(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIDelete(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -116,13 +116,13 @@ func APIDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, user, true, modelID)
+	srv.deleteHandler(w, user, true, modelID)
 }
 
 // APICreate is the API method to create a model
-func APICreate(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APICreate(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -144,13 +144,13 @@ func APICreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, user, true, mdl)
+	srv.createHandler(w, user, true, mdl)
 }
 
 // APIAssertionHeaders is the API method to upsert the model assertion header details
-func APIAssertionHeaders(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIAssertionHeaders(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -172,5 +172,211 @@ func APIAssertionHeaders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertionHeaders(w, user, true, assert)
+	srv.assertionHeaders(w, user, true, assert)
+}
+
+// APIPreview is the API method to preview the serial assertion for a model, without signing it
+func (srv *Service) APIPreview(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.previewHandler(w, user, true, id)
+}
+
+// APIFallbackKeys is the API method to list the fallback signing-keys of a model
+func (srv *Service) APIFallbackKeys(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.fallbackKeysHandler(w, user, true, id)
+}
+
+// APIUpdateFallbackKeys is the API method to set the fallback signing-keys of a model
+func (srv *Service) APIUpdateFallbackKeys(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := FallbackKeysRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No fallback keys supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.updateFallbackKeysHandler(w, user, true, id, req)
+}
+
+// APICanary is the API method to fetch the canary signing-key of a model
+func (srv *Service) APICanary(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.canaryHandler(w, user, true, id)
+}
+
+// APIUpdateCanary is the API method to set the canary signing-key of a model
+func (srv *Service) APIUpdateCanary(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := CanaryRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No canary key supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.updateCanaryHandler(w, user, true, id, req)
+}
+
+// APIClone is the API method to create models from a template model
+func (srv *Service) APIClone(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := CloneRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No model names supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.cloneHandler(w, user, true, id, req)
+}
+
+// APIAssignKeypair is the API method to re-point the models of a keypair to a new keypair
+func (srv *Service) APIAssignKeypair(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	assignment, err := decodeKeypairAssignment(w, r)
+	if err != nil {
+		return
+	}
+
+	// Call the API with the user
+	srv.assignKeypairHandler(w, user, true, assignment)
+}
+
+// APIKeypairHistory is the API method to fetch the audit of the re-pointed keypairs of a model
+func (srv *Service) APIKeypairHistory(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.keypairHistoryHandler(w, user, true, id)
 }
@@ -22,12 +22,17 @@ package model_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/model"
+	"github.com/CanonicalLtd/serial-vault/service/response"
 	check "gopkg.in/check.v1"
 )
 
@@ -82,7 +87,365 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
+
+func (s *ModelsSuite) TestAPIPreviewHandler(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{{AuthorityID: "acme"}}})
+	key := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-key").Build())
+	other := db.AddKeypair(datastoretest.NewKeypair("other", "other-key").Inactive().Build())
+	ready := db.AddModel(datastoretest.NewModel("acme", "alder").WithKeypair(key).Build())
+	mismatch := db.AddModel(datastoretest.NewModel("acme", "ash").WithKeypair(other).Build())
+	unsigned := db.AddModel(datastoretest.NewModel("acme", "birch").Build())
+	datastore.Environ.DB = db
+
+	tests := []struct {
+		ModelID   int
+		Code      int
+		Success   bool
+		SignKeyID string
+		Problems  []string
+	}{
+		{ready.ID, 200, true, "acme-key", []string{"The account-key assertion of the signing-key has not been uploaded"}},
+		{mismatch.ID, 200, true, "other-key", []string{
+			"The signing-key belongs to 'other', but the serial assertion is issued by the brand 'acme'",
+			"The signing-key is disabled",
+			"The account-key assertion of the signing-key has not been uploaded",
+		}},
+		{unsigned.ID, 200, true, "", []string{"The model does not have a signing-key"}},
+		{9999, 400, false, "", nil},
+	}
+
+	for _, t := range tests {
+		w := sendAdminAPIRequest("GET", fmt.Sprintf("/api/models/%d/preview", t.ModelID), nil, datastore.Admin, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result := model.PreviewResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if !t.Success {
+			continue
+		}
+		c.Assert(result.Problems, check.DeepEquals, t.Problems)
+		c.Assert(result.Headers["type"], check.Equals, "serial")
+		c.Assert(result.Headers["authority-id"], check.Equals, "acme")
+		c.Assert(result.Headers["brand-id"], check.Equals, "acme")
+		c.Assert(result.Headers["sign-key-sha3-384"], check.Equals, t.SignKeyID)
+	}
+
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ModelsSuite) TestAPIFallbackKeysHandler(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{{AuthorityID: "acme"}}})
+	key := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-key").Build())
+	fallback1 := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-fallback1").Build())
+	fallback2 := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-fallback2").Inactive().Build())
+	other := db.AddKeypair(datastoretest.NewKeypair("other", "other-key").Build())
+	mdl := db.AddModel(datastoretest.NewModel("acme", "alder").WithKeypair(key).Build())
+	datastore.Environ.DB = db
+
+	tests := []struct {
+		KeypairIDs []int
+		Success    bool
+		Keys       []string
+	}{
+		{[]int{fallback2.ID, fallback1.ID}, true, []string{"acme-fallback2", "acme-fallback1"}},
+		{[]int{fallback1.ID}, true, []string{"acme-fallback1"}},
+		{[]int{key.ID}, false, []string{"acme-fallback1"}},
+		{[]int{other.ID}, false, []string{"acme-fallback1"}},
+		{[]int{fallback1.ID, fallback1.ID}, false, []string{"acme-fallback1"}},
+		{[]int{}, true, []string{}},
+	}
+
+	for _, t := range tests {
+		data, _ := json.Marshal(model.FallbackKeysRequest{KeypairIDs: t.KeypairIDs})
+		w := sendAdminAPIRequest("PUT", fmt.Sprintf("/api/models/%d/fallback-keys", mdl.ID), bytes.NewReader(data), datastore.Admin, c)
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		w = sendAdminAPIRequest("GET", fmt.Sprintf("/api/models/%d/fallback-keys", mdl.ID), nil, datastore.Admin, c)
+		c.Assert(w.Code, check.Equals, 200)
+		keys := model.FallbackKeysResponse{}
+		err = json.NewDecoder(w.Body).Decode(&keys)
+		c.Assert(err, check.IsNil)
+		c.Assert(keys.Success, check.Equals, true)
+		keyIDs := []string{}
+		for _, k := range keys.Keypairs {
+			keyIDs = append(keyIDs, k.KeyID)
+		}
+		c.Assert(keyIDs, check.DeepEquals, t.Keys)
+	}
+
+	w := sendAdminAPIRequest("GET", "/api/models/9999/fallback-keys", nil, datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ModelsSuite) TestAPICanaryHandler(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{{AuthorityID: "acme"}}})
+	key := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-key").Build())
+	canary := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-canary").Build())
+	other := db.AddKeypair(datastoretest.NewKeypair("other", "other-key").Build())
+	mdl := db.AddModel(datastoretest.NewModel("acme", "alder").WithKeypair(key).Build())
+	datastore.Environ.DB = db
+
+	tests := []struct {
+		Request   model.CanaryRequest
+		Success   bool
+		KeypairID int
+		Percent   int
+	}{
+		{model.CanaryRequest{KeypairID: canary.ID, Percent: 10}, true, canary.ID, 10},
+		{model.CanaryRequest{KeypairID: canary.ID, Percent: 50}, true, canary.ID, 50},
+		{model.CanaryRequest{KeypairID: key.ID, Percent: 10}, false, canary.ID, 50},
+		{model.CanaryRequest{KeypairID: other.ID, Percent: 10}, false, canary.ID, 50},
+		{model.CanaryRequest{KeypairID: canary.ID, Percent: 101}, false, canary.ID, 50},
+		{model.CanaryRequest{KeypairID: 0, Percent: 10}, false, canary.ID, 50},
+		{model.CanaryRequest{Percent: 0}, true, 0, 0},
+	}
+
+	for _, t := range tests {
+		data, _ := json.Marshal(t.Request)
+		w := sendAdminAPIRequest("PUT", fmt.Sprintf("/api/models/%d/canary", mdl.ID), bytes.NewReader(data), datastore.Admin, c)
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		w = sendAdminAPIRequest("GET", fmt.Sprintf("/api/models/%d/canary", mdl.ID), nil, datastore.Admin, c)
+		c.Assert(w.Code, check.Equals, 200)
+		resp := model.CanaryResponse{}
+		err = json.NewDecoder(w.Body).Decode(&resp)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.Success, check.Equals, true)
+		c.Assert(resp.Canary.KeypairID, check.Equals, t.KeypairID)
+		c.Assert(resp.Canary.Percent, check.Equals, t.Percent)
+	}
+
+	w := sendAdminAPIRequest("GET", "/api/models/9999/canary", nil, datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ModelsSuite) TestAPICanaryHandlerResults(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{{AuthorityID: "acme"}}})
+	key := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-key").Build())
+	canary := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-canary").Build())
+	mdl := db.AddModel(datastoretest.NewModel("acme", "alder").WithKeypair(key).Build())
+	datastore.Environ.DB = db
+
+	err := db.UpdateAllowedModelCanary(datastore.ModelCanary{ModelID: mdl.ID, KeypairID: canary.ID, Percent: 10}, datastore.User{})
+	c.Assert(err, check.IsNil)
+	db.RecordModelKeyResult(mdl.ID, key.ID, true)
+	db.RecordModelKeyResult(mdl.ID, canary.ID, true)
+	db.RecordModelKeyResult(mdl.ID, canary.ID, false)
+
+	w := sendAdminAPIRequest("GET", fmt.Sprintf("/api/models/%d/canary", mdl.ID), nil, datastore.Admin, c)
+	resp := model.CanaryResponse{}
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Canary.KeyID, check.Equals, "acme-canary")
+	c.Assert(resp.Results, check.DeepEquals, []datastore.ModelKeyResult{
+		{ModelID: mdl.ID, KeypairID: key.ID, KeyID: "acme-key", Signed: 1},
+		{ModelID: mdl.ID, KeypairID: canary.ID, KeyID: "acme-canary", Signed: 1, Failed: 1},
+	})
+
+	// Changing the canary signing-key resets the results
+	err = db.UpdateAllowedModelCanary(datastore.ModelCanary{ModelID: mdl.ID, KeypairID: canary.ID, Percent: 50}, datastore.User{})
+	c.Assert(err, check.IsNil)
+	results, _ := db.ListModelKeyResults(mdl.ID)
+	c.Assert(results, check.HasLen, 2)
+	err = db.UpdateAllowedModelCanary(datastore.ModelCanary{ModelID: mdl.ID}, datastore.User{})
+	c.Assert(err, check.IsNil)
+	results, _ = db.ListModelKeyResults(mdl.ID)
+	c.Assert(results, check.HasLen, 0)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ModelsSuite) TestAPICloneHandler(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{{AuthorityID: "acme"}}})
+	key := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-key").Build())
+	fallback := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-fallback").Build())
+	canary := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-canary").Build())
+	mdl := db.AddModel(datastoretest.NewModel("acme", "alder").WithKeypair(key).Build())
+	db.AddModel(datastoretest.NewModel("acme", "birch").WithKeypair(key).Build())
+	datastore.Environ.DB = db
+
+	_, err := db.CreateModelAssert(datastore.ModelAssertion{ModelID: mdl.ID, KeypairID: key.ID, Series: 16, Architecture: "amd64", Gadget: "alder-gadget", Kernel: "pc-kernel"})
+	c.Assert(err, check.IsNil)
+	c.Assert(db.UpdateAllowedModelFallbackKeypairs(mdl.ID, []int{fallback.ID}, datastore.User{}), check.IsNil)
+	c.Assert(db.UpdateAllowedModelCanary(datastore.ModelCanary{ModelID: mdl.ID, KeypairID: canary.ID, Percent: 10}, datastore.User{}), check.IsNil)
+	c.Assert(db.CreateAllowedStation(datastore.Station{ModelID: mdl.ID, Code: "line-1"}, datastore.User{}), check.IsNil)
+
+	tests := []struct {
+		Models  []string
+		Success bool
+	}{
+		{[]string{"alder-sku1", "alder-sku2"}, true},
+		{[]string{"alder-sku3", "alder-sku3"}, false},
+		{[]string{"alder-sku4", "birch"}, false},
+		{[]string{}, false},
+	}
+
+	for _, t := range tests {
+		data, _ := json.Marshal(model.CloneRequest{Models: t.Models})
+		w := sendAdminAPIRequest("POST", fmt.Sprintf("/api/models/%d/clone", mdl.ID), bytes.NewReader(data), datastore.Admin, c)
+		result := model.CloneResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if !t.Success {
+			c.Assert(result.Models, check.HasLen, 0)
+			continue
+		}
+
+		c.Assert(result.Models, check.HasLen, len(t.Models))
+		for i, m := range result.Models {
+			c.Assert(m.Name, check.Equals, t.Models[i])
+			c.Assert(m.KeypairID, check.Equals, key.ID)
+			c.Assert(m.APIKey, check.Not(check.Equals), mdl.APIKey)
+			c.Assert(m.ModelAssertion.Gadget, check.Equals, "alder-gadget")
+
+			keypairs, _ := db.ListModelFallbackKeypairs(m.ID)
+			c.Assert(keypairs, check.HasLen, 1)
+			c.Assert(keypairs[0].ID, check.Equals, fallback.ID)
+			cnry, _ := db.GetModelCanary(m.ID)
+			c.Assert(cnry.KeypairID, check.Equals, canary.ID)
+			c.Assert(cnry.Percent, check.Equals, 10)
+			c.Assert(db.ValidateStation(m.ID, "line-1"), check.IsNil)
+		}
+	}
+
+	c.Assert(db.CheckModelExists("acme", "alder-sku3"), check.Equals, false)
+	c.Assert(db.CheckModelExists("acme", "alder-sku4"), check.Equals, false)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ModelsSuite) TestAPIAssignKeypairHandler(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{{AuthorityID: "acme"}}})
+	old := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-old").Build())
+	key := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-new").Build())
+	userKey := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-user").Build())
+	inactive := db.AddKeypair(datastoretest.NewKeypair("acme", "acme-inactive").Inactive().Build())
+	other := db.AddKeypair(datastoretest.NewKeypair("other", "other-key").Build())
+	alder := db.AddModel(datastoretest.NewModel("acme", "alder").WithKeypair(old).Build())
+	birch := db.AddModel(datastoretest.NewModel("acme", "birch").WithKeypair(old).WithUserKeypair(userKey).Build())
+	cedar := db.AddModel(datastoretest.NewModel("acme", "cedar").WithKeypair(key).Build())
+	datastore.Environ.DB = db
+
+	tests := []struct {
+		Assignment datastore.ModelKeypairAssignment
+		Success    bool
+		Changes    int
+	}{
+		{datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: key.ID, Preview: true}, true, 2},
+		{datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: old.ID}, false, 0},
+		{datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: other.ID}, false, 0},
+		{datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: inactive.ID}, false, 0},
+		{datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: key.ID, ModelIDs: []int{cedar.ID}}, false, 0},
+		{datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: key.ID, ModelIDs: []int{alder.ID}, Preview: true}, true, 1},
+	}
+
+	for _, t := range tests {
+		data, _ := json.Marshal(t.Assignment)
+		w := sendAdminAPIRequest("POST", "/api/models/keypairs/assign", bytes.NewReader(data), datastore.Admin, c)
+		result := model.KeypairAssignmentResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.Changes, check.HasLen, t.Changes)
+	}
+
+	// The previews and the failures do not change the models
+	m, _ := db.GetAllowedModel(alder.ID, datastore.User{})
+	c.Assert(m.KeypairID, check.Equals, old.ID)
+
+	data, _ := json.Marshal(datastore.ModelKeypairAssignment{FromKeypairID: old.ID, KeypairID: key.ID})
+	w := sendAdminAPIRequest("POST", "/api/models/keypairs/assign", bytes.NewReader(data), datastore.Admin, c)
+	result := model.KeypairAssignmentResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Changes, check.HasLen, 2)
+
+	m, _ = db.GetAllowedModel(alder.ID, datastore.User{})
+	c.Assert(m.KeypairID, check.Equals, key.ID)
+	c.Assert(m.KeypairIDUser, check.Equals, key.ID)
+	m, _ = db.GetAllowedModel(birch.ID, datastore.User{})
+	c.Assert(m.KeypairID, check.Equals, key.ID)
+	c.Assert(m.KeypairIDUser, check.Equals, userKey.ID)
+
+	// Each re-pointed model has an audit entry
+	w = sendAdminAPIRequest("GET", fmt.Sprintf("/api/models/%d/keypairs/history", birch.ID), nil, datastore.Admin, c)
+	history := model.KeypairHistoryResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&history), check.IsNil)
+	c.Assert(history.Success, check.Equals, true)
+	c.Assert(history.Changes, check.HasLen, 1)
+	c.Assert(history.Changes[0].OldKeypairID, check.Equals, old.ID)
+	c.Assert(history.Changes[0].NewKeypairID, check.Equals, key.ID)
+	c.Assert(history.Changes[0].NewKeypairIDUser, check.Equals, userKey.ID)
+	c.Assert(history.Changes[0].ChangedBy, check.Equals, "sv")
+
+	// The signing-key of a model cannot also be one of its fallback keys
+	c.Assert(db.UpdateAllowedModelFallbackKeypairs(cedar.ID, []int{old.ID}, datastore.User{}), check.IsNil)
+	data, _ = json.Marshal(datastore.ModelKeypairAssignment{FromKeypairID: key.ID, KeypairID: old.ID})
+	w = sendAdminAPIRequest("POST", "/api/models/keypairs/assign", bytes.NewReader(data), datastore.Admin, c)
+	result = model.KeypairAssignmentResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	m, _ = db.GetAllowedModel(alder.ID, datastore.User{})
+	c.Assert(m.KeypairID, check.Equals, key.ID)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ModelsSuite) TestAPIAssignKeypairHandlerErrors(c *check.C) {
+	assignment := []byte(`{"from-keypair-id":1,"keypair-id":2}`)
+	tests := []SuiteTest{
+		{false, "POST", "/api/models/keypairs/assign", []byte(""), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/api/models/keypairs/assign", []byte("{invalid"), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/api/models/keypairs/assign", []byte(`{"from-keypair-id":1,"keypair-id":1}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/api/models/keypairs/assign", assignment, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{false, "POST", "/api/models/keypairs/assign", assignment, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{true, "POST", "/api/models/keypairs/assign", assignment, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "GET", "/api/models/1/keypairs/history", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{true, "GET", "/api/models/1/keypairs/history", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := model.KeypairAssignmentResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.Changes, check.HasLen, t.List)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		if t.MockError {
+			datastore.Environ.DB = &datastore.MockDB{}
+		}
+	}
+}
@@ -31,20 +31,25 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the model handlers
+type Service struct {
+	*datastore.Env
+}
+
 // List is the API method to fetch the users
-func List(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
-	listHandler(w, authUser, false)
+	srv.listHandler(w, authUser, false)
 }
 
 // Get is the API method to fetch a model
-func Get(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Get(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -57,12 +62,12 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	srv.getHandler(w, authUser, false, id)
 }
 
 // Update is the API method to update a model
-func Update(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Update(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -91,12 +96,12 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateHandler(w, authUser, false, modelID, mdl)
+	srv.updateHandler(w, authUser, false, modelID, mdl)
 }
 
 // Delete is the API method to delete a model
-func Delete(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Delete(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -109,12 +114,12 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, authUser, false, modelID)
+	srv.deleteHandler(w, authUser, false, modelID)
 }
 
 // Create is the API method to create a model
-func Create(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Create(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -136,12 +141,12 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, mdl)
+	srv.createHandler(w, authUser, false, mdl)
 }
 
 // AssertionHeaders is the API method to upsert the model assertion header details
-func AssertionHeaders(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) AssertionHeaders(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -163,5 +168,214 @@ func AssertionHeaders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertionHeaders(w, authUser, false, assert)
+	srv.assertionHeaders(w, authUser, false, assert)
+}
+
+// Preview is the API method to preview the serial assertion for a model
+func (srv *Service) Preview(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	srv.previewHandler(w, authUser, false, id)
+}
+
+// FallbackKeys is the API method to list the fallback signing-keys of a model
+func (srv *Service) FallbackKeys(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	srv.fallbackKeysHandler(w, authUser, false, id)
+}
+
+// UpdateFallbackKeys is the API method to set the fallback signing-keys of a model
+func (srv *Service) UpdateFallbackKeys(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := FallbackKeysRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No fallback keys supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	srv.updateFallbackKeysHandler(w, authUser, false, id, req)
+}
+
+// Canary is the API method to fetch the canary signing-key of a model
+func (srv *Service) Canary(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	srv.canaryHandler(w, authUser, false, id)
+}
+
+// UpdateCanary is the API method to set the canary signing-key of a model
+func (srv *Service) UpdateCanary(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := CanaryRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No canary key supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	srv.updateCanaryHandler(w, authUser, false, id, req)
+}
+
+// Clone is the API method to create models from a template model
+func (srv *Service) Clone(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := CloneRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No model names supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	srv.cloneHandler(w, authUser, false, id, req)
+}
+
+// AssignKeypair is the API method to re-point the models of a keypair to a new keypair
+func (srv *Service) AssignKeypair(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	assignment, err := decodeKeypairAssignment(w, r)
+	if err != nil {
+		return
+	}
+
+	srv.assignKeypairHandler(w, authUser, false, assignment)
+}
+
+// KeypairHistory is the API method to fetch the audit of the re-pointed keypairs of a model
+func (srv *Service) KeypairHistory(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	srv.keypairHistoryHandler(w, authUser, false, id)
+}
+
+func decodeKeypairAssignment(w http.ResponseWriter, r *http.Request) (datastore.ModelKeypairAssignment, error) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	assignment := datastore.ModelKeypairAssignment{}
+	err := json.NewDecoder(r.Body).Decode(&assignment)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-keypair-assignment-data", "", "No keypair assignment supplied.", w)
+		return assignment, err
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return assignment, err
+	}
+	return assignment, nil
 }
@@ -64,7 +64,7 @@ func (s *ModelsSuite) SetUpTest(c *check.C) {
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 
 	// Disable CSRF for tests as we do not have a secure connection
-	service.MiddlewareWithCSRF = service.Middleware
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
 }
 
 func sendAdminRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
@@ -77,7 +77,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 		c.Assert(err, check.IsNil)
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -94,7 +94,7 @@ func (s *ModelsSuite) sendPOSTRequest(url string, data io.Reader, permissions in
 		}
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 	return w, nil
 }
 
@@ -375,7 +375,7 @@ func (s *ModelsSuite) TestAssertionHandler(c *check.C) {
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, role)
+	jwtToken, err := usso.NewJWTToken(&resp, role, datastore.Environ.Config.JwtSecret)
 	if err != nil {
 		return fmt.Errorf("Error creating a JWT: %v", err)
 	}
@@ -0,0 +1,306 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package onboarding
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Response is the JSON response from the API method of an onboarding
+type Response struct {
+	Success      bool                 `json:"success"`
+	ErrorCode    string               `json:"error_code"`
+	ErrorSubcode string               `json:"error_subcode"`
+	ErrorMessage string               `json:"message"`
+	Onboarding   datastore.Onboarding `json:"onboarding"`
+}
+
+// ListResponse is the JSON response from the API onboardings method
+type ListResponse struct {
+	Success      bool                   `json:"success"`
+	ErrorCode    string                 `json:"error_code"`
+	ErrorSubcode string                 `json:"error_subcode"`
+	ErrorMessage string                 `json:"message"`
+	Onboardings  []datastore.Onboarding `json:"onboardings"`
+}
+
+// submitHandler is the API method for a new brand to apply for the onboarding. An uploaded
+// signing-key is only encrypted with the keystore secret and stored with the onboarding, as it
+// is not imported into the keystore until the onboarding is approved. The response holds the
+// onboarding token, which is only returned once
+func (srv *Service) submitHandler(w http.ResponseWriter, application Application) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if _, err := srv.DB.GetAccount(application.AuthorityID); err == nil {
+		response.FormatStandardResponse(false, "error-onboarding", "", "The brand is already registered in the vault", w)
+		return
+	}
+
+	o := datastore.Onboarding{
+		AuthorityID:      application.AuthorityID,
+		Username:         application.Username,
+		Name:             application.Name,
+		Email:            application.Email,
+		AccountAssertion: application.AccountAssertion,
+		KeyName:          application.KeyName,
+		Generate:         application.Generate,
+		Model:            application.Model,
+	}
+
+	if len(application.PrivateKey) > 0 {
+		if application.Generate {
+			response.FormatStandardResponse(false, "error-onboarding", "", "A signing-key must either be uploaded or generated by the vault", w)
+			return
+		}
+
+		keyID, sealedKey, err := sealOnboardingKey(application.PrivateKey, srv.Config.KeyStoreSecret)
+		if err != nil {
+			response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+			return
+		}
+		o.KeyID = keyID
+		o.SealedKey = sealedKey
+	}
+
+	o, err := srv.DB.CreateOnboarding(o)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-onboarding", "", err.Error(), w)
+		return
+	}
+	log.Printf("Onboarding %d of brand %s requested by %s\n", o.ID, o.AuthorityID, o.Username)
+
+	// Return successful JSON response with the onboarding token
+	w.WriteHeader(http.StatusOK)
+	formatResponse(o, w)
+}
+
+// statusHandler is the API method for the applicant to check their onboarding with its
+// onboarding token. The API key of the model is returned once the brand is provisioned
+func (srv *Service) statusHandler(w http.ResponseWriter, token string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	o, err := srv.DB.GetOnboardingByToken(token)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the onboarding
+	w.WriteHeader(http.StatusOK)
+	formatResponse(o, w)
+}
+
+// listHandler is the API method for an operator to fetch the onboardings, optionally with a status e.g. pending
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, status string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	onboardings, err := srv.DB.ListOnboardings(status)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-onboardings-json", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of onboardings
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(onboardings, w)
+}
+
+// decideHandler is the API method for an operator to approve or reject a pending onboarding.
+// An approved brand is provisioned straight away, except for the generation of a signing-key
+// by the vault, which is provisioned in the background
+func (srv *Service) decideHandler(w http.ResponseWriter, user datastore.User, apiCall bool, onboardingID int, approved bool, reason string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	o, err := srv.DB.DecideOnboarding(onboardingID, approved, user.Username, reason)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-onboarding", "", err.Error(), w)
+		return
+	}
+	log.Printf("Onboarding %d of brand %s %s by %s\n", o.ID, o.AuthorityID, o.Status, user.Username)
+
+	switch {
+	case !approved:
+		w.WriteHeader(http.StatusOK)
+	case o.Generate:
+		go srv.provision(o)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		srv.provision(o)
+		if o, err = srv.DB.GetOnboarding(o.ID); err != nil {
+			response.FormatStandardResponse(false, "error-onboarding", "", err.Error(), w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	formatResponse(o, w)
+}
+
+// provision creates the account, the admin user, the signing-key and the model of an approved
+// onboarding, and records its outcome
+func (srv *Service) provision(o datastore.Onboarding) {
+	apiKey, err := srv.provisionBrand(o)
+	if err != nil {
+		log.Printf("Error provisioning the onboarding %d of brand %s: %v\n", o.ID, o.AuthorityID, err)
+		srv.DB.FinishOnboarding(o.ID, datastore.OnboardingFailed, "", err.Error())
+		return
+	}
+	srv.DB.FinishOnboarding(o.ID, datastore.OnboardingCompleted, apiKey, o.Reason)
+}
+
+func (srv *Service) provisionBrand(o datastore.Onboarding) (string, error) {
+	if err := srv.DB.CreateAccount(datastore.Account{AuthorityID: o.AuthorityID, Assertion: o.AccountAssertion}); err != nil {
+		return "", err
+	}
+	account, err := srv.DB.GetAccount(o.AuthorityID)
+	if err != nil {
+		return "", err
+	}
+
+	keypair, err := srv.provisionKeypair(o)
+	if err != nil {
+		return "", err
+	}
+
+	// An existing user is added to the account, otherwise the brand admin is created
+	user, err := srv.DB.GetUserByUsername(o.Username)
+	if err == nil {
+		if user.Accounts, err = srv.DB.ListUserAccounts(o.Username); err != nil {
+			return "", err
+		}
+		user.Accounts = append(user.Accounts, account)
+		err = srv.DB.UpdateUser(user)
+	} else {
+		_, err = srv.DB.CreateUser(datastore.User{Username: o.Username, Name: o.Name, Email: o.Email, Role: datastore.Admin, Accounts: []datastore.Account{account}})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	model := datastore.Model{BrandID: o.AuthorityID, Name: o.Model, KeypairID: keypair.ID, KeypairIDUser: keypair.ID}
+	model, _, err = srv.DB.CreateAllowedModel(model, datastore.User{Username: o.Username, Role: datastore.Admin})
+	if err != nil {
+		return "", err
+	}
+	return model.APIKey, nil
+}
+
+func (srv *Service) provisionKeypair(o datastore.Onboarding) (datastore.Keypair, error) {
+	if o.Generate {
+		if err := datastore.GenerateKeypair(o.AuthorityID, "", o.KeyName); err != nil {
+			return datastore.Keypair{}, err
+		}
+		return srv.DB.GetKeypairByName(o.AuthorityID, o.KeyName)
+	}
+
+	if len(o.SealedKey) == 0 {
+		return datastore.Keypair{}, errors.New("The signing-key of the onboarding has been discarded")
+	}
+	privateKeyData, err := unsealOnboardingKey(o.SealedKey, srv.Config.KeyStoreSecret)
+	if err != nil {
+		return datastore.Keypair{}, err
+	}
+
+	// The signing-key is imported into the keystore now that the onboarding is approved
+	privateKey, sealedPrivateKey, err := srv.KeypairDB.ImportSigningKey(o.AuthorityID, privateKeyData)
+	if err != nil {
+		return datastore.Keypair{}, err
+	}
+	if privateKey.PublicKey().ID() != o.KeyID {
+		return datastore.Keypair{}, errors.New("The signing-key of the onboarding does not match its key ID")
+	}
+
+	keypair := datastore.Keypair{AuthorityID: o.AuthorityID, KeyID: o.KeyID, SealedKey: sealedPrivateKey, KeyName: o.KeyName}
+	if _, err := srv.DB.PutKeypair(keypair); err != nil {
+		return datastore.Keypair{}, err
+	}
+	return srv.DB.GetKeypairByPublicID(o.AuthorityID, o.KeyID)
+}
+
+// sealOnboardingKey checks an uploaded signing-key and encrypts it with the keystore secret,
+// returning its key ID and the base64 encoded encrypted key
+func sealOnboardingKey(privateKeyData, keystoreSecret string) (string, string, error) {
+	imported, _, err := crypt.ImportPrivateKey(privateKeyData)
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed, err := crypt.EncryptKey(privateKeyData, keystoreSecret)
+	if err != nil {
+		return "", "", err
+	}
+	return imported.KeyID, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unsealOnboardingKey decrypts the uploaded signing-key of an onboarding
+func unsealOnboardingKey(sealedKey, keystoreSecret string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(sealedKey)
+	if err != nil {
+		return "", err
+	}
+
+	privateKeyData, err := crypt.DecryptKey(sealed, keystoreSecret)
+	if err != nil {
+		return "", err
+	}
+	return string(privateKeyData), nil
+}
+
+func formatResponse(o datastore.Onboarding, w http.ResponseWriter) error {
+	response := Response{Success: true, Onboarding: o}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the onboarding response.")
+		return err
+	}
+	return nil
+}
+
+func formatListResponse(onboardings []datastore.Onboarding, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Onboardings: onboardings}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the onboardings response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package onboarding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// TokenHeader is the request header that holds the onboarding token of an applicant
+const TokenHeader = "onboarding-token"
+
+// maxApplicationSize is the largest onboarding application that is accepted, as the method
+// does not need authentication
+const maxApplicationSize = 256 * 1024
+
+// Service holds the dependencies of the onboarding handlers
+type Service struct {
+	*datastore.Env
+}
+
+// Application is the onboarding application of a new brand. The signing-key is either
+// uploaded, as a base64 encoded private key, or generated by the vault
+type Application struct {
+	AuthorityID      string `json:"authority-id"`
+	Username         string `json:"username"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	AccountAssertion string `json:"account-assertion"`
+	KeyName          string `json:"key-name"`
+	PrivateKey       string `json:"private-key"`
+	Generate         bool   `json:"generate"`
+	Model            string `json:"model"`
+}
+
+// Decision is the reason of an operator for approving or rejecting an onboarding
+type Decision struct {
+	Reason string `json:"reason"`
+}
+
+// APISubmit is the API method for a new brand to apply for the onboarding
+func (srv *Service) APISubmit(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	application := Application{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApplicationSize)).Decode(&application)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-onboarding-data", "", "No onboarding data supplied", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	srv.submitHandler(w, application)
+}
+
+// APIStatus is the API method for the applicant to check their onboarding
+func (srv *Service) APIStatus(w http.ResponseWriter, r *http.Request) {
+	srv.statusHandler(w, r.Header.Get(TokenHeader))
+}
+
+// List is the API method to fetch the onboardings
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.listHandler(w, authUser, false, r.URL.Query().Get("status"))
+}
+
+// Approve is the API method to approve a pending onboarding
+func (srv *Service) Approve(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+	srv.decide(w, r, authUser, false, true)
+}
+
+// Reject is the API method to reject a pending onboarding
+func (srv *Service) Reject(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+	srv.decide(w, r, authUser, false, false)
+}
+
+// APIList is the API method to fetch the onboardings
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	srv.listHandler(w, user, true, r.URL.Query().Get("status"))
+}
+
+// APIApprove is the API method to approve a pending onboarding
+func (srv *Service) APIApprove(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+	srv.decide(w, r, user, true, true)
+}
+
+// APIReject is the API method to reject a pending onboarding
+func (srv *Service) APIReject(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+	srv.decide(w, r, user, true, false)
+}
+
+func (srv *Service) decide(w http.ResponseWriter, r *http.Request, user datastore.User, apiCall, approved bool) {
+	vars := mux.Vars(r)
+	onboardingID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidID.Code, "", fmt.Sprintf("%v", vars["id"]), w)
+		return
+	}
+
+	// The reason is optional
+	decision := Decision{}
+	if r.Body != nil {
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&decision); err != nil && err != io.EOF {
+			response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+			return
+		}
+	}
+
+	srv.decideHandler(w, user, apiCall, onboardingID, approved, decision.Reason)
+}
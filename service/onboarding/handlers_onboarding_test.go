@@ -0,0 +1,261 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package onboarding_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/account"
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/onboarding"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+func TestOnboardingSuite(t *testing.T) { check.TestingT(t) }
+
+type OnboardingSuite struct {
+	db        *datastoretest.DB
+	assertion string
+}
+
+var _ = check.Suite(&OnboardingSuite{})
+
+func (s *OnboardingSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin})
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+	datastore.Environ.KeypairDB, _ = datastore.GetMemoryKeyStore(config)
+
+	assertion, err := account.MockFetchAssertionFromStore(asserts.AccountType, []string{"canonical"})
+	c.Assert(err, check.IsNil)
+	s.assertion = string(asserts.Encode(assertion))
+}
+
+func (s *OnboardingSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func sendRequest(method, url string, data io.Reader, username, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+	if len(token) > 0 {
+		r.Header.Set(onboarding.TokenHeader, token)
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func parseResponse(c *check.C, w *httptest.ResponseRecorder) onboarding.Response {
+	result := onboarding.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *OnboardingSuite) application() onboarding.Application {
+	return onboarding.Application{AuthorityID: "canonical", Username: "brandadmin", Name: "Brand Admin", Email: "admin@example.com",
+		AccountAssertion: s.assertion, KeyName: "brand-key", Generate: true, Model: "alder"}
+}
+
+func (s *OnboardingSuite) submit(c *check.C, application onboarding.Application) onboarding.Response {
+	data, _ := json.Marshal(application)
+	w := sendRequest("POST", "/api/onboarding", bytes.NewReader(data), "", "")
+	return parseResponse(c, w)
+}
+
+func (s *OnboardingSuite) TestSubmit(c *check.C) {
+	result := s.submit(c, s.application())
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Onboarding.Status, check.Equals, datastore.OnboardingPending)
+	c.Assert(result.Onboarding.Token, check.Not(check.Equals), "")
+
+	// The applicant checks the onboarding with the token
+	w := sendRequest("GET", "/api/onboarding/status", nil, "", result.Onboarding.Token)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	status := parseResponse(c, w)
+	c.Assert(status.Onboarding.ID, check.Equals, result.Onboarding.ID)
+	c.Assert(status.Onboarding.Token, check.Equals, "")
+
+	// A brand has one onboarding in progress
+	result = s.submit(c, s.application())
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorMessage, check.Equals, "The brand already has an onboarding in progress")
+}
+
+func (s *OnboardingSuite) TestSubmitInvalid(c *check.C) {
+	s.db.AddAccount(datastore.Account{AuthorityID: "registered"})
+
+	tests := []struct {
+		update  func(*onboarding.Application)
+		message string
+	}{
+		{func(a *onboarding.Application) { a.AuthorityID = "registered" }, "The brand is already registered in the vault"},
+		{func(a *onboarding.Application) { a.AccountAssertion = "" }, "Account assertion must not be empty"},
+		{func(a *onboarding.Application) { a.AuthorityID = "other" }, "The account assertion is not for the account"},
+		{func(a *onboarding.Application) { a.Generate = false }, "A signing-key must either be uploaded or generated by the vault"},
+		{func(a *onboarding.Application) { a.PrivateKey = "key" }, "A signing-key must either be uploaded or generated by the vault"},
+		{func(a *onboarding.Application) { a.Email = "" }, "Email must not be empty"},
+		{func(a *onboarding.Application) { a.Model = "" }, "Model name must not be empty"},
+	}
+
+	for _, t := range tests {
+		application := s.application()
+		t.update(&application)
+
+		result := s.submit(c, application)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorMessage, check.Equals, t.message)
+	}
+}
+
+func (s *OnboardingSuite) uploadApplication(c *check.C) onboarding.Application {
+	signingKey, err := ioutil.ReadFile("../../keystore/TestKey.asc")
+	c.Assert(err, check.IsNil)
+
+	application := s.application()
+	application.Generate = false
+	application.PrivateKey = base64.StdEncoding.EncodeToString(signingKey)
+	return application
+}
+
+func (s *OnboardingSuite) TestSubmitUpload(c *check.C) {
+	// The uploaded signing-key is not imported into the keystore before the approval
+	datastore.Environ.KeypairDB, _ = datastore.GetErrorMockKeyStore(datastore.Environ.Config)
+
+	application := s.uploadApplication(c)
+	result := s.submit(c, application)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Onboarding.KeyID, check.Not(check.Equals), "")
+
+	o, err := s.db.GetOnboarding(result.Onboarding.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(o.SealedKey, check.Not(check.Equals), "")
+	c.Assert(o.SealedKey, check.Not(check.Equals), application.PrivateKey)
+}
+
+func (s *OnboardingSuite) TestSubmitTooLarge(c *check.C) {
+	application := s.application()
+	application.AccountAssertion = strings.Repeat("x", 512*1024)
+	result := s.submit(c, application)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-decode-json")
+}
+
+func (s *OnboardingSuite) TestApprove(c *check.C) {
+	submitted := s.submit(c, s.uploadApplication(c))
+	c.Assert(submitted.Success, check.Equals, true)
+	o := submitted.Onboarding
+
+	// Only a superuser can decide an onboarding
+	w := sendRequest("POST", fmt.Sprintf("/api/onboardings/%d/approve", o.ID), nil, "sv", "")
+	c.Assert(parseResponse(c, w).Success, check.Equals, false)
+
+	w = sendRequest("POST", fmt.Sprintf("/api/onboardings/%d/approve", o.ID), nil, "root", "")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	result := parseResponse(c, w)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Onboarding.Status, check.Equals, datastore.OnboardingCompleted)
+	c.Assert(result.Onboarding.DecidedBy, check.Equals, "root")
+
+	// The brand is provisioned
+	acc, err := s.db.GetAccount("canonical")
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.Assertion, check.Equals, s.assertion)
+	c.Assert(s.db.CheckUserInAccount("brandadmin", "canonical"), check.Equals, true)
+	keypair, err := s.db.GetKeypairByPublicID("canonical", o.KeyID)
+	c.Assert(err, check.IsNil)
+	model, err := s.db.FindModel("canonical", "alder", result.Onboarding.APIKey)
+	c.Assert(err, check.IsNil)
+	c.Assert(model.KeypairID, check.Equals, keypair.ID)
+
+	// The applicant gets the API key of the model
+	w = sendRequest("GET", "/api/onboarding/status", nil, "", o.Token)
+	c.Assert(parseResponse(c, w).Onboarding.APIKey, check.Equals, model.APIKey)
+
+	// The onboarding cannot be decided again
+	w = sendRequest("POST", fmt.Sprintf("/api/onboardings/%d/reject", o.ID), nil, "root", "")
+	c.Assert(parseResponse(c, w).ErrorMessage, check.Equals, datastore.ErrOnboardingDecided.Error())
+}
+
+func (s *OnboardingSuite) TestReject(c *check.C) {
+	result := s.submit(c, s.application())
+	c.Assert(result.Success, check.Equals, true)
+
+	data, _ := json.Marshal(onboarding.Decision{Reason: "Unknown brand"})
+	w := sendRequest("POST", fmt.Sprintf("/api/onboardings/%d/reject", result.Onboarding.ID), bytes.NewReader(data), "root", "")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(parseResponse(c, w).Onboarding.Status, check.Equals, datastore.OnboardingRejected)
+
+	w = sendRequest("GET", "/api/onboarding/status", nil, "", result.Onboarding.Token)
+	status := parseResponse(c, w)
+	c.Assert(status.Onboarding.Status, check.Equals, datastore.OnboardingRejected)
+	c.Assert(status.Onboarding.Reason, check.Equals, "Unknown brand")
+
+	// The brand is not provisioned, and it can apply again
+	_, err := s.db.GetAccount("canonical")
+	c.Assert(err, check.NotNil)
+	c.Assert(s.submit(c, s.application()).Success, check.Equals, true)
+}
+
+func (s *OnboardingSuite) TestList(c *check.C) {
+	_, err := s.db.CreateOnboarding(datastore.Onboarding{AuthorityID: "canonical", Username: "brandadmin", Name: "Brand Admin",
+		Email: "admin@example.com", AccountAssertion: s.assertion, KeyName: "brand-key", KeyID: "brand-key-id", SealedKey: "sealed", Model: "alder"})
+	c.Assert(err, check.IsNil)
+
+	w := sendRequest("GET", "/api/onboardings?status=pending", nil, "root", "")
+	result := onboarding.ListResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result.Onboardings, check.HasLen, 1)
+	c.Assert(result.Onboardings[0].SealedKey, check.Equals, "")
+
+	w = sendRequest("GET", "/api/onboardings", nil, "sv", "")
+	c.Assert(parseResponse(c, w).Success, check.Equals, false)
+}
+
+func (s *OnboardingSuite) TestStatusInvalidToken(c *check.C) {
+	w := sendRequest("GET", "/api/onboarding/status", nil, "", "")
+	result := parseResponse(c, w)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorMessage, check.Equals, "The onboarding token must be provided")
+
+	w = sendRequest("GET", "/api/onboarding/status", nil, "", "invalid")
+	c.Assert(parseResponse(c, w).ErrorMessage, check.Equals, "Invalid onboarding token")
+}
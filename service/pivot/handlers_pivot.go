@@ -22,7 +22,6 @@ package pivot
 
 import (
 	"encoding/json"
-	"io"
 	"log"
 	"net/http"
 	"time"
@@ -33,9 +32,15 @@ import (
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
 	"github.com/snapcore/snapd/asserts"
 )
 
+// Service holds the dependencies of the pivot handlers
+type Service struct {
+	*datastore.Env
+}
+
 // Response is the JSON response from the API Sub-Stores method
 type Response struct {
 	Success      bool               `json:"success"`
@@ -44,33 +49,33 @@ type Response struct {
 }
 
 // Model is the API method to determine the pivot details of a model
-func Model(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+func (srv *Service) Model(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 
-	assertion, errResponse := parseSerialAssertion(r)
+	assertion, errResponse := srv.parseSerialAssertion(r)
 	if !errResponse.Success {
 		return errResponse
 	}
 
-	substore, errResponse := findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
+	pivot, errResponse := srv.findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
 
 	// Return the model pivot details (store and model name)
-	formatPivotResponse(true, "", substore, w)
+	formatPivotResponse(true, "", pivot.Substore, w)
 	return response.ErrorResponse{Success: true}
 }
 
 // ModelAssertion is the API method to get the model assertions for a pivoted model
 // The serial assertion of the original model is supplied, and the assertions for the pivoted model are returned
-func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
-	assertion, errResponse := parseSerialAssertion(r)
+func (srv *Service) ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	assertion, errResponse := srv.parseSerialAssertion(r)
 	if !errResponse.Success {
 		return errResponse
 	}
 
 	// Check that the reseller functionality is enabled for the brand
-	acc, err := datastore.Environ.DB.GetAccount(assertion.HeaderString("brand-id"))
+	acc, err := srv.DB.GetAccount(assertion.HeaderString("brand-id"))
 	if err != nil {
 		return response.ErrorResponse{Success: false, Code: "error-account", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
@@ -78,15 +83,19 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 		return response.ErrorResponse{Success: false, Code: "error-auth", Message: "This feature is not enabled for this account", StatusCode: http.StatusBadRequest}
 	}
 
-	substore, errResponse := findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
+	pivot, errResponse := srv.findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
+	substore := pivot.Substore
 
 	assertions := []asserts.Assertion{}
 
-	// Build the model assertion headers for the original model
-	assertionHeaders, keypair, err := assert.CreateModelAssertionHeaders(substore.FromModel)
+	// Build the model assertion headers for the original model or, when the device is pivoted
+	// to another brand, for the target model of that brand
+	signingModel := pivot.SigningModel()
+	modelAssertions := assert.Service{Env: srv.Env}
+	assertionHeaders, keypair, err := modelAssertions.CreateModelAssertionHeaders(signingModel)
 	if err != nil {
 		svlog.Message("PIVOT", "create-assertion", err.Error())
 		return response.ErrorCreateModelAssertion
@@ -97,14 +106,14 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 	assertionHeaders["store"] = substore.Store
 
 	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.ModelType, assertionHeaders, []byte(""), substore.FromModel.BrandID, keypair.KeyID, keypair.SealedKey)
+	signedAssertion, err := srv.KeypairDB.SignAssertion(r.Context(), srv.DB.WithContext(r.Context()), asserts.ModelType, assertionHeaders, []byte(""), signingModel.BrandID, keypair.KeyID, keypair.SealedKey)
 	if err != nil {
 		svlog.Message("PIVOT", "signing-assertion", err.Error())
 		return response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
 	// Add the account assertion to the assertions list
-	fetchAssertionFromStore(&assertions, asserts.AccountType, []string{substore.FromModel.BrandID})
+	fetchAssertionFromStore(&assertions, asserts.AccountType, []string{signingModel.BrandID})
 
 	// Add the account-key assertion to the assertions list
 	fetchAssertionFromStore(&assertions, asserts.AccountKeyType, []string{keypair.KeyID})
@@ -120,14 +129,14 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 
 // SerialAssertion is the API method to get the serial assertions for a pivoted model
 // The serial assertion of the original model is supplied, and the assertions for the pivoted model are returned
-func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
-	assertion, errResponse := parseSerialAssertion(r)
+func (srv *Service) SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	assertion, errResponse := srv.parseSerialAssertion(r)
 	if !errResponse.Success {
 		return errResponse
 	}
 
 	// Check that the reseller functionality is enabled for the brand
-	acc, err := datastore.Environ.DB.GetAccount(assertion.HeaderString("brand-id"))
+	acc, err := srv.DB.GetAccount(assertion.HeaderString("brand-id"))
 	if err != nil {
 		return response.ErrorResponse{Success: false, Code: "error-account", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
@@ -135,31 +144,38 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 		return response.ErrorResponse{Success: false, Code: "error-auth", Message: "This feature is not enabled for this account", StatusCode: http.StatusBadRequest}
 	}
 
-	substore, errResponse := findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
+	pivot, errResponse := srv.findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
+	substore := pivot.Substore
 
 	assertions := []asserts.Assertion{}
 
 	// Build the serial assertion headers for the original model
 	// Override the model assertion headers with the sub-store details
+	// The serial assertion is signed by the account of the signing-key of the model, which is the
+	// target model of the sub-store brand when the device is pivoted to another brand
+	signingModel := pivot.SigningModel()
+	authorityID := pivot.AuthorityID(assertion.HeaderString("brand-id"))
 	assertionHeaders := assertion.Headers()
+	assertionHeaders["brand-id"] = pivot.BrandID(assertion.HeaderString("brand-id"))
+	assertionHeaders["authority-id"] = authorityID
 	assertionHeaders["model"] = substore.ModelName
 	assertionHeaders["timestamp"] = time.Now().Format(time.RFC3339)
 
 	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SerialType, assertionHeaders, assertion.Body(), substore.FromModel.BrandID, substore.FromModel.KeyID, substore.FromModel.SealedKey)
+	signedAssertion, err := srv.KeypairDB.SignAssertion(r.Context(), srv.DB.WithContext(r.Context()), asserts.SerialType, assertionHeaders, assertion.Body(), signingModel.BrandID, signingModel.KeyID, signingModel.SealedKey)
 	if err != nil {
 		svlog.Message("PIVOT", "signing-assertion", err.Error())
 		return response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
 	// Add the account assertion to the assertions list
-	fetchAssertionFromStore(&assertions, asserts.AccountType, []string{substore.FromModel.BrandID})
+	fetchAssertionFromStore(&assertions, asserts.AccountType, []string{authorityID})
 
 	// Add the account-key assertion to the assertions list
-	fetchAssertionFromStore(&assertions, asserts.AccountKeyType, []string{substore.FromModel.KeyID})
+	fetchAssertionFromStore(&assertions, asserts.AccountKeyType, []string{signingModel.KeyID})
 
 	// Add the model assertion after the account and account-key assertions
 	assertions = append(assertions, signedAssertion)
@@ -170,9 +186,9 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 
 }
 
-func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorResponse) {
+func (srv *Service) parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorResponse) {
 	// Check that we have an authorised API key header
-	_, err := request.CheckModelAPI(r)
+	_, err := request.CheckModelAPI(r, srv.DB)
 	if err != nil {
 		svlog.Message("PIVOT", "invalid-api-key", "Invalid API key used")
 		return nil, response.ErrorInvalidAPIKey
@@ -181,42 +197,46 @@ func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorRes
 	defer r.Body.Close()
 
 	// Get the serial assertion from the body
-	dec := asserts.NewDecoder(r.Body)
-	assertion, err := dec.Decode()
-	if err == io.EOF {
+	assertion, err := validation.DecodeSerial(r.Body)
+	switch {
+	case err == validation.ErrEmptyData:
 		svlog.Message("PIVOT", "invalid-assertion", "No data supplied for pivot")
 		return nil, response.ErrorEmptyData
-	}
-	if err != nil {
+	case err == validation.ErrNotSerial:
+		svlog.Message("PIVOT", "invalid-type", err.Error())
+		return nil, response.ErrorInvalidType
+	case err != nil:
 		svlog.Message("PIVOT", "invalid-assertion", err.Error())
 		return nil, response.ErrorResponse{Success: false, Code: "decode-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	// Check that we have a serial assertion (the details will have been validated by Decode call)
-	if assertion.Type() != asserts.SerialType {
-		svlog.Message("PIVOT", "invalid-type", "The assertion type must be 'serial'")
-		return nil, response.ErrorInvalidType
-	}
-
 	return assertion, response.ErrorResponse{Success: true}
 }
 
-func findModelPivot(brand, modelName, serial, apiKey string) (datastore.Substore, response.ErrorResponse) {
-	// Validate the model by checking that it exists on the database
-	model, err := datastore.Environ.DB.FindModel(brand, modelName, apiKey)
-	if err != nil {
-		svlog.Message("PIVOT", "invalid-model", "Cannot find model with the matching brand and model")
-		return datastore.Substore{}, response.ErrorInvalidModel
+func (srv *Service) findModelPivot(brand, modelName, serial, apiKey string) (validation.Pivot, response.ErrorResponse) {
+	substore, err := validation.ResolvePivot(srv.DB, brand, modelName, serial, apiKey)
+	if err == nil {
+		var pivot validation.Pivot
+		pivot, err = validation.ResolvePivotTarget(srv.DB, substore)
+		if err == nil {
+			return pivot, response.ErrorResponse{Success: true}
+		}
 	}
 
-	// Check for a sub-store model for the pivot
-	substore, err := datastore.Environ.DB.GetSubstore(model.ID, serial)
-	if err != nil {
-		svlog.Message("PIVOT", "invalid-substore", "Cannot find sub-store mapping for the model")
-		return substore, response.ErrorInvalidSubstore
+	switch err {
+	case validation.ErrInvalidAPIKey:
+		svlog.Message("PIVOT", "invalid-api-key", err.Error())
+		return validation.Pivot{}, response.ErrorInvalidAPIKey
+	case validation.ErrModelNotFound:
+		svlog.Message("PIVOT", "invalid-model", err.Error())
+		return validation.Pivot{}, response.ErrorInvalidModel
+	case validation.ErrPivotTargetNotFound:
+		svlog.Message("PIVOT", response.ErrorPivotTargetNotFound.Code, err.Error())
+		return validation.Pivot{}, response.ErrorPivotTargetNotFound
+	default:
+		svlog.Message("PIVOT", "invalid-substore", err.Error())
+		return validation.Pivot{}, response.ErrorInvalidSubstore
 	}
-
-	return substore, response.ErrorResponse{Success: true}
 }
 
 func formatPivotResponse(success bool, message string, store datastore.Substore, w http.ResponseWriter) error {
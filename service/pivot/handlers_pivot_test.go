@@ -64,7 +64,7 @@ func parsePivotResponse(w *httptest.ResponseRecorder) (pivot.Response, error) {
 
 func (s *PivotSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 }
@@ -162,7 +162,7 @@ func sendSigningRequest(method, url string, data io.Reader, apiKey string, c *ch
 	r, _ := http.NewRequest(method, url, data)
 	r.Header.Set("api-key", apiKey)
 
-	service.SigningRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
 
 	return w
 }
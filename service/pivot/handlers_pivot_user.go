@@ -34,9 +34,9 @@ import (
 )
 
 // SystemUserAssertion is the API method to generate a system-user assertion for a pivoted model
-func SystemUserAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+func (srv *Service) SystemUserAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	// Check that we have an authorised API key header
-	_, err := request.CheckModelAPI(r)
+	_, err := request.CheckModelAPI(r, srv.DB)
 	if err != nil {
 		svlog.Message("PIVOTUSER", "invalid-api-key", "Invalid API key used")
 		return response.ErrorInvalidAPIKey
@@ -54,17 +54,19 @@ func SystemUserAssertion(w http.ResponseWriter, r *http.Request) response.ErrorR
 		return response.ErrorResponse{Success: false, Code: "error-decode-json", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	substore, errResponse := findModelPivot(user.Brand, user.ModelName, user.SerialNumber, r.Header.Get("api-key"))
+	pivot, errResponse := srv.findModelPivot(user.Brand, user.ModelName, user.SerialNumber, r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
 
-	// Set up the request details for the pivot model
-	model := substore.FromModel
-	model.Name = substore.ModelName
+	// Set up the request details for the pivot model, which is signed by the target model of
+	// the sub-store brand when the device is pivoted to another brand
+	model := pivot.SigningModel()
+	model.Name = pivot.Substore.ModelName
 
 	// Generate the system-user assertion for the pivoted model
-	resp := assertion.GenerateSystemUserAssertion(user.SystemUserRequest, model)
+	assertions := assertion.Service{Env: srv.Env}
+	resp := assertions.GenerateSystemUserAssertion(user.SystemUserRequest, model)
 	if !resp.Success {
 		return response.ErrorResponse{Success: false, Code: resp.ErrorCode, Message: resp.ErrorMessage, StatusCode: http.StatusBadRequest}
 	}
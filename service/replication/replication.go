@@ -0,0 +1,196 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package replication replicates the signing log between vaults, for the brands that run their
+// own vault as well as the hosted vault. Each vault fetches the entries signed by its peers, so
+// the duplicate serial numbers and device-keys are detected across the vaults. Only the registry
+// of the signed devices is replicated, not the signing-keys
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DefaultInterval is the time between the replications from the peers
+const DefaultInterval = 5 * time.Minute
+
+// requestTimeout is the limit for a request to a peer
+const requestTimeout = time.Minute
+
+// cursorPrefix is the code of the setting that holds the ID of the last entry replicated from a peer
+const cursorPrefix = "replication/"
+
+// HTTPClient sends the requests to the peers
+var HTTPClient = &http.Client{Timeout: requestTimeout}
+
+// Result counts the signing log entries of a replication from a peer
+type Result struct {
+	Replicated int
+	Duplicates int
+	Conflicts  int
+}
+
+// Interval returns the time between the replications from the config
+func Interval(settings config.Settings) time.Duration {
+	if settings.ReplicationInterval > 0 {
+		return time.Duration(settings.ReplicationInterval) * time.Second
+	}
+	return DefaultInterval
+}
+
+// Run replicates the signing log from the peers periodically, until the context is done
+func Run(ctx context.Context, env *datastore.Env, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, peer := range env.Config.ReplicationPeers {
+				Replicate(ctx, env, peer)
+			}
+		}
+	}
+}
+
+// Replicate adds the signing log entries of the peer that were signed since the last replication.
+// The entries of a serial number that was signed for a different device are not added, and are
+// reported as conflicts
+func Replicate(ctx context.Context, env *datastore.Env, peer config.ReplicationPeer) (Result, error) {
+	result, err := replicate(ctx, env.DB.WithContext(ctx), peer)
+	if err != nil {
+		metrics.Increment(metrics.ReplicationErrors)
+		log.Message("REPLICATION", peer.Name, err.Error())
+	}
+	return result, err
+}
+
+func replicate(ctx context.Context, db datastore.Datastore, peer config.ReplicationPeer) (Result, error) {
+	result := Result{}
+	if !strings.HasPrefix(peer.URL, "https://") {
+		return result, fmt.Errorf("The replication peer '%s' must use HTTPS", peer.Name)
+	}
+
+	afterID := cursor(db, peer.Name)
+
+	for {
+		signingLogs, err := FetchSigningLogs(ctx, peer, afterID)
+		if err != nil {
+			return result, err
+		}
+
+		for _, l := range signingLogs {
+			outcome, err := db.ImportSigningLog(replica(peer.Name, l))
+			if err != nil {
+				return result, err
+			}
+
+			switch outcome {
+			case datastore.ImportCreated:
+				result.Replicated++
+				metrics.Increment(metrics.Replicated)
+			case datastore.ImportDuplicate:
+				result.Duplicates++
+			case datastore.ImportConflict:
+				result.Conflicts++
+				metrics.Increment(metrics.ReplicationConflicts)
+				log.Warningf("Serial number %s/%s/%s revision %d was signed by '%s' for a different device-key", l.Make, l.Model, l.SerialNumber, l.Revision, peer.Name)
+			}
+		}
+
+		if len(signingLogs) == 0 {
+			return result, nil
+		}
+
+		// The entries are replicated in order, so the next replication starts after the last one
+		afterID = signingLogs[len(signingLogs)-1].ID
+		if err := db.PutSetting(datastore.Setting{Code: cursorPrefix + peer.Name, Data: strconv.Itoa(afterID)}); err != nil {
+			return result, err
+		}
+
+		if len(signingLogs) < datastore.MaxReplicationBatch {
+			return result, nil
+		}
+	}
+}
+
+// cursor returns the ID of the last entry of the peer that was replicated
+func cursor(db datastore.Datastore, peer string) int {
+	setting, err := db.GetSetting(cursorPrefix + peer)
+	if err != nil {
+		return 0
+	}
+	afterID, _ := strconv.Atoi(setting.Data)
+	return afterID
+}
+
+// replica returns the signing log entry of the peer, with its provenance
+func replica(peer string, signLog datastore.SigningLog) datastore.SigningLog {
+	signer := datastore.SigningAudit{}
+	if signLog.Signer != nil {
+		signer = *signLog.Signer
+	}
+	signer.Replica = &datastore.SigningReplica{Peer: peer, PeerID: signLog.ID, Replicated: time.Now().UTC()}
+
+	signLog.Signer = &signer
+	signLog.ID = 0
+	signLog.Synced = 0
+	signLog.Hash = ""
+	signLog.Annotations = nil
+	return signLog
+}
+
+// FetchSigningLogs fetches the signing log entries of the peer after the ID, authenticated as the sync user
+var FetchSigningLogs = func(ctx context.Context, peer config.ReplicationPeer, afterID int) ([]datastore.SigningLog, error) {
+	r, err := http.NewRequest("GET", fmt.Sprintf("%sreplication/signinglog?after=%d&limit=%d", peer.URL, afterID, datastore.MaxReplicationBatch), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("user", peer.Username)
+	r.Header.Set("api-key", peer.APIKey)
+
+	w, err := HTTPClient.Do(r.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Body.Close()
+
+	result := ListResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, errors.New(result.ErrorMessage)
+	}
+	return result.SigningLogs, nil
+}
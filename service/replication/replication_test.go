@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package replication_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/replication"
+	check "gopkg.in/check.v1"
+)
+
+func TestReplicationSuite(t *testing.T) { check.TestingT(t) }
+
+type ReplicationSuite struct {
+	db     *datastoretest.DB
+	logs   []datastore.SigningLog
+	peerDB *datastoretest.DB
+	server *httptest.Server
+	peer   config.ReplicationPeer
+}
+
+var _ = check.Suite(&ReplicationSuite{})
+
+func (s *ReplicationSuite) SetUpTest(c *check.C) {
+	// The peer vault signs the devices of the system brand
+	s.peerDB = datastoretest.New()
+	system := s.peerDB.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.peerDB.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.peerDB.AddUser(datastore.User{Username: "sync", APIKey: "SyncAPIKey", Role: datastore.SyncUser, Accounts: []datastore.Account{system}})
+	s.peerDB.AddUser(datastore.User{Username: "viewer", APIKey: "ViewerAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+	s.logs = []datastore.SigningLog{
+		s.peerDB.AddSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "fp1", Revision: 1}),
+		s.peerDB.AddSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "fp2", Revision: 1}),
+		s.peerDB.AddSigningLog(datastore.SigningLog{Make: "other", Model: "ash", SerialNumber: "B1", Fingerprint: "fp3", Revision: 1}),
+	}
+
+	peerEnv := &datastore.Env{DB: s.peerDB, Config: config.Settings{EnableUserAuth: true}}
+	s.server = httptest.NewTLSServer(service.NewService(peerEnv).AdminRouter())
+	replication.HTTPClient = s.server.Client()
+	s.peer = config.ReplicationPeer{Name: "brand-vault", URL: s.server.URL + "/api/", Username: "sync", APIKey: "SyncAPIKey"}
+
+	s.db = datastoretest.New()
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config.Settings{ReplicationPeers: []config.ReplicationPeer{s.peer}}}
+}
+
+func (s *ReplicationSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ReplicationSuite) TestReplicate(c *check.C) {
+	before := metrics.Value(metrics.Replicated)
+
+	result, err := replication.Replicate(context.Background(), datastore.Environ, s.peer)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, replication.Result{Replicated: 2})
+	c.Assert(metrics.Value(metrics.Replicated)-before, check.Equals, int64(2))
+
+	// The replicas record their provenance
+	logs, err := s.db.ListSerialSigningLog("system", "alder", "A2")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Fingerprint, check.Equals, "fp2")
+	c.Assert(logs[0].Signer, check.NotNil)
+	c.Assert(logs[0].Signer.Replica, check.NotNil)
+	c.Assert(logs[0].Signer.Replica.Peer, check.Equals, "brand-vault")
+	c.Assert(logs[0].Signer.Replica.PeerID, check.Equals, s.logs[1].ID)
+
+	// The next replication only fetches the new entries
+	s.peerDB.AddSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A3", Fingerprint: "fp4", Revision: 1})
+	result, err = replication.Replicate(context.Background(), datastore.Environ, s.peer)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, replication.Result{Replicated: 1})
+}
+
+func (s *ReplicationSuite) TestReplicateConflict(c *check.C) {
+	s.db.AddSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "fp1", Revision: 1})
+	s.db.AddSigningLog(datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "local", Revision: 1})
+	before := metrics.Value(metrics.ReplicationConflicts)
+
+	result, err := replication.Replicate(context.Background(), datastore.Environ, s.peer)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, replication.Result{Duplicates: 1, Conflicts: 1})
+	c.Assert(metrics.Value(metrics.ReplicationConflicts)-before, check.Equals, int64(1))
+}
+
+func (s *ReplicationSuite) TestReplicateNotHTTPS(c *check.C) {
+	before := metrics.Value(metrics.ReplicationErrors)
+	s.peer.URL = "http://brand-vault.example.com/api/"
+
+	_, err := replication.Replicate(context.Background(), datastore.Environ, s.peer)
+	c.Assert(err, check.NotNil)
+	c.Assert(metrics.Value(metrics.ReplicationErrors)-before, check.Equals, int64(1))
+}
+
+func (s *ReplicationSuite) TestReplicateInvalidAPIKey(c *check.C) {
+	s.peer.APIKey = "InvalidAPIKey"
+
+	_, err := replication.Replicate(context.Background(), datastore.Environ, s.peer)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *ReplicationSuite) TestAPISigningLogPermissions(c *check.C) {
+	tests := []struct {
+		user    string
+		apiKey  string
+		after   string
+		success bool
+		count   int
+	}{
+		{"sync", "SyncAPIKey", "0", true, 2},
+		{"sync", "SyncAPIKey", strconv.Itoa(s.logs[0].ID), true, 1},
+		{"sync", "SyncAPIKey", "", false, 0},
+		{"viewer", "ViewerAPIKey", "0", false, 0},
+		{"sync", "InvalidAPIKey", "0", false, 0},
+	}
+
+	for _, t := range tests {
+		r, _ := http.NewRequest("GET", s.server.URL+"/api/replication/signinglog?after="+t.after, nil)
+		r.Header.Set("user", t.user)
+		r.Header.Set("api-key", t.apiKey)
+
+		w, err := s.server.Client().Do(r)
+		c.Assert(err, check.IsNil)
+
+		result := replication.ListResponse{}
+		err = json.NewDecoder(w.Body).Decode(&result)
+		w.Body.Close()
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(result.SigningLogs, check.HasLen, t.count)
+	}
+}
@@ -0,0 +1,264 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Supported report formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// defaultPeriodDays is the length of the report period when no dates are given
+const defaultPeriodDays = 30
+
+// maximumPeriodDays limits the length of the report period
+const maximumPeriodDays = 366
+
+// Response is the JSON response from the API report method. The document is
+// signed as-is, so it must be stored unchanged for the signature to verify
+type Response struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	Format       string `json:"format"`
+	Document     string `json:"document"`
+	Signature    string `json:"signature"`
+	KeyID        string `json:"key-id"`
+}
+
+// KeyResponse is the JSON response from the API reporting key method
+type KeyResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	PublicKey    string `json:"public-key"`
+	KeyID        string `json:"key-id"`
+}
+
+// AttestationReport is the document of the signing-key attestation report
+type AttestationReport struct {
+	Generated time.Time                  `json:"generated"`
+	Challenge string                     `json:"challenge,omitempty"`
+	Keypairs  []datastore.KeyAttestation `json:"keypairs"`
+}
+
+// reportHandler is the API method to produce a signed production report for an account
+func (srv *Service) reportHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID, fromDay, toDay, format string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	from, to, err := parsePeriod(fromDay, toDay)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidReportPeriod.Code, "", err.Error(), w)
+		return
+	}
+
+	if len(format) == 0 {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatCSV {
+		response.FormatStandardResponse(false, response.ErrorInvalidReportFormat.Code, "", response.ErrorInvalidReportFormat.Message, w)
+		return
+	}
+
+	report, err := srv.DB.AllowedProductionReport(user, authorityID, from, to)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchReport.Code, "", err.Error(), w)
+		return
+	}
+
+	var document []byte
+	if format == FormatCSV {
+		document, err = formatCSV(report)
+	} else {
+		document, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchReport.Code, "", err.Error(), w)
+		return
+	}
+
+	signature, err := datastore.SignReport(srv.DB, srv.Config, document)
+	if err != nil {
+		log.Errorf("Error signing the production report: %v", err)
+		response.FormatStandardResponse(false, response.ErrorSignReport.Code, "", response.ErrorSignReport.Message, w)
+		return
+	}
+
+	// Return successful JSON response with the signed report
+	w.WriteHeader(http.StatusOK)
+	formatResponse(Response{Success: true, Format: format, Document: string(document), Signature: signature.Signature, KeyID: signature.KeyID}, w)
+}
+
+// keyHandler is the API method to fetch the public key that verifies the reports
+func (srv *Service) keyHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	publicKey, keyID, err := datastore.ReportPublicKey(srv.DB, srv.Config)
+	if err != nil {
+		log.Errorf("Error fetching the reporting key: %v", err)
+		response.FormatStandardResponse(false, response.ErrorSignReport.Code, "", response.ErrorSignReport.Message, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatResponse(KeyResponse{Success: true, PublicKey: publicKey, KeyID: keyID}, w)
+}
+
+// attestationHandler is the API method to produce the signed attestation report of the signing-keys,
+// with a proof-of-possession signature over the challenge from each key
+func (srv *Service) attestationHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, challenge string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	keypairs, err := srv.DB.ListAllowedKeypairs(user)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchReport.Code, "", err.Error(), w)
+		return
+	}
+
+	report := AttestationReport{Generated: time.Now().UTC(), Challenge: challenge, Keypairs: []datastore.KeyAttestation{}}
+	for _, k := range keypairs {
+		// The list of keypairs does not include the sealed signing-key
+		keypair, err := srv.DB.GetKeypair(k.ID)
+		if err != nil {
+			response.FormatStandardResponse(false, response.ErrorFetchReport.Code, "", err.Error(), w)
+			return
+		}
+
+		report.Keypairs = append(report.Keypairs, srv.KeypairDB.AttestKeypair(ctx, keypair, challenge))
+	}
+
+	document, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorFetchReport.Code, "", err.Error(), w)
+		return
+	}
+
+	signature, err := datastore.SignReport(srv.DB, srv.Config, document)
+	if err != nil {
+		log.Errorf("Error signing the attestation report: %v", err)
+		response.FormatStandardResponse(false, response.ErrorSignReport.Code, "", response.ErrorSignReport.Message, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatResponse(Response{Success: true, Format: FormatJSON, Document: string(document), Signature: signature.Signature, KeyID: signature.KeyID}, w)
+}
+
+// parsePeriod parses the from and to days, defaulting to the last 30 days
+func parsePeriod(fromDay, toDay string) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var err error
+	if len(toDay) > 0 {
+		to, err = time.Parse(datastore.ReportDateFormat, toDay)
+		if err != nil {
+			return to, to, err
+		}
+	}
+
+	from := to.AddDate(0, 0, 1-defaultPeriodDays)
+	if len(fromDay) > 0 {
+		from, err = time.Parse(datastore.ReportDateFormat, fromDay)
+		if err != nil {
+			return from, to, err
+		}
+	}
+
+	if from.After(to) {
+		return from, to, errors.New("The start of the report period must not be after the end")
+	}
+	if to.Sub(from) >= maximumPeriodDays*24*time.Hour {
+		return from, to, errors.New("The report period cannot be longer than a year")
+	}
+	return from, to, nil
+}
+
+// formatCSV writes the report as CSV sections: the report details, the
+// devices signed per model per day and the serial number ranges
+func formatCSV(report datastore.ProductionReport) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	records := [][]string{
+		{"authority-id", "from", "to", "generated"},
+		{report.AuthorityID, report.From, report.To, report.Generated.Format(time.RFC3339)},
+		{},
+		{"model", "day", "count"},
+	}
+	for _, d := range report.Days {
+		records = append(records, []string{d.Model, d.Day, strconv.Itoa(d.Count)})
+	}
+
+	records = append(records, []string{}, []string{"model", "first", "last", "serials"})
+	for _, r := range report.Ranges {
+		records = append(records, []string{r.Model, r.First, r.Last, strconv.Itoa(r.Serials)})
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func formatResponse(resp interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error forming the report response: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package report_test
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/report"
+	check "gopkg.in/check.v1"
+)
+
+func TestReportSuite(t *testing.T) { check.TestingT(t) }
+
+type ReportSuite struct{}
+
+var _ = check.Suite(&ReportSuite{})
+
+type ReportTest struct {
+	Method      string
+	URL         string
+	Code        int
+	Permissions int
+	EnableAuth  bool
+	Success     bool
+	ErrorCode   string
+	Format      string
+}
+
+func (s *ReportSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
+}
+
+func (s *ReportSuite) TestAPIReportHandler(c *check.C) {
+	tests := []ReportTest{
+		{"GET", "/api/reports/account/System", 400, 0, false, false, "error-auth", ""},
+		{"GET", "/api/reports/account/System", 200, datastore.Admin, false, true, "", "json"},
+		{"GET", "/api/reports/account/System?from=2018-05-01&to=2018-05-31&format=csv", 200, datastore.Admin, false, true, "", "csv"},
+		{"GET", "/api/reports/account/System?from=2018-05-01&to=2018-05-31&format=json", 200, datastore.Admin, false, true, "", "json"},
+		{"GET", "/api/reports/account/System?format=xml", 400, datastore.Admin, false, false, "invalid-format", ""},
+		{"GET", "/api/reports/account/System?from=01/05/2018", 400, datastore.Admin, false, false, "invalid-period", ""},
+		{"GET", "/api/reports/account/System?from=2018-06-01&to=2018-05-01", 400, datastore.Admin, false, false, "invalid-period", ""},
+		{"GET", "/api/reports/account/System?from=2017-01-01&to=2018-05-01", 400, datastore.Admin, false, false, "invalid-period", ""},
+		{"GET", "/api/reports/account/System", 400, datastore.SyncUser, true, false, "error-auth", ""},
+		{"GET", "/api/reports/account/System", 400, datastore.Standard, true, false, "error-auth", ""},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result := report.Response{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+		c.Assert(result.Format, check.Equals, t.Format)
+		if t.Success {
+			c.Assert(len(result.Signature) > 0, check.Equals, true)
+			c.Assert(len(result.KeyID) > 0, check.Equals, true)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *ReportSuite) TestAPIReportVerify(c *check.C) {
+	w := sendAdminAPIRequest("GET", "/api/reports/account/System?from=2018-05-01&to=2018-05-31&format=csv", datastore.Admin)
+	c.Assert(w.Code, check.Equals, 200)
+
+	result := report.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(result.Document, "alder,2018-05-01,2\n"), check.Equals, true)
+	c.Assert(strings.Contains(result.Document, "alder,A1000,A1002,3\n"), check.Equals, true)
+
+	// The report is verified using the public reporting key
+	w = sendAdminAPIRequest("GET", "/api/reports/key", datastore.Admin)
+	c.Assert(w.Code, check.Equals, 200)
+
+	key := report.KeyResponse{}
+	err = json.NewDecoder(w.Body).Decode(&key)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.KeyID, check.Equals, result.KeyID)
+
+	block, _ := pem.Decode([]byte(key.PublicKey))
+	c.Assert(block, check.NotNil)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	c.Assert(err, check.IsNil)
+
+	signature, err := base64.StdEncoding.DecodeString(result.Signature)
+	c.Assert(err, check.IsNil)
+
+	digest := sha256.Sum256([]byte(result.Document))
+	err = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+	c.Assert(err, check.IsNil)
+
+	// A modified report does not verify
+	digest = sha256.Sum256([]byte(strings.Replace(result.Document, "alder,2018-05-01,2", "alder,2018-05-01,20", 1)))
+	err = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *ReportSuite) TestAPIAttestationHandler(c *check.C) {
+	tests := []ReportTest{
+		{"GET", "/api/reports/keypairs", 400, 0, false, false, "error-auth", ""},
+		{"GET", "/api/reports/keypairs", 200, datastore.Admin, false, true, "", "json"},
+		{"GET", "/api/reports/keypairs?challenge=audit-2018", 200, datastore.Admin, false, true, "", "json"},
+		{"GET", "/api/reports/keypairs", 400, datastore.SyncUser, true, false, "error-auth", ""},
+		{"GET", "/api/reports/keypairs", 400, datastore.Standard, true, false, "error-auth", ""},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result := report.Response{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+		c.Assert(result.Format, check.Equals, t.Format)
+		if t.Success {
+			c.Assert(len(result.Signature) > 0, check.Equals, true)
+
+			attestation := report.AttestationReport{}
+			err = json.Unmarshal([]byte(result.Document), &attestation)
+			c.Assert(err, check.IsNil)
+			c.Assert(attestation.Keypairs, check.HasLen, 2)
+			for _, k := range attestation.Keypairs {
+				c.Assert(k.Backend, check.Equals, "filesystem")
+				c.Assert(strings.HasSuffix(k.Location, k.KeyID), check.Equals, true)
+			}
+			if strings.Contains(t.URL, "challenge") {
+				c.Assert(attestation.Challenge, check.Equals, "audit-2018")
+			} else {
+				c.Assert(attestation.Keypairs[0].Proof, check.Equals, "")
+			}
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *ReportSuite) TestReportHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	tests := []ReportTest{
+		{"GET", "/v1/reports/account/System", 400, 0, false, false, "fetch-report", ""},
+		{"GET", "/v1/reports/key", 400, 0, false, false, "sign-report", ""},
+		{"GET", "/v1/reports/keypairs", 400, 0, false, false, "fetch-report", ""},
+	}
+
+	for _, t := range tests {
+		w := sendAdminAPIRequest(t.Method, t.URL, t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result := report.Response{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+}
+
+func sendAdminAPIRequest(method, url string, permissions int) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, nil)
+
+	switch permissions {
+	case datastore.Admin:
+		r.Header.Set("user", "sv")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.SyncUser:
+		r.Header.Set("user", "sync")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.Standard:
+		r.Header.Set("user", "user1")
+		r.Header.Set("api-key", "ValidAPIKey")
+	default:
+		break
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
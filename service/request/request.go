@@ -20,32 +20,131 @@
 package request
 
 import (
+	"context"
 	"errors"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
 // CheckUserAPI validates the user and API key
-func CheckUserAPI(r *http.Request) (datastore.User, error) {
+func CheckUserAPI(r *http.Request, db datastore.Datastore) (datastore.User, error) {
 	// Get the user and API key from the header
 	username := r.Header.Get("user")
 	apiKey := r.Header.Get("api-key")
 
 	// Find the user by API key
-	return datastore.Environ.DB.GetUserByAPIKey(apiKey, username)
+	return db.GetUserByAPIKey(apiKey, username)
 }
 
 // CheckModelAPI the API key header to make sure it is an allowed header
-func CheckModelAPI(r *http.Request) (string, error) {
+func CheckModelAPI(r *http.Request, db datastore.Datastore) (string, error) {
 	apiKey := r.Header.Get("api-key")
 	if len(apiKey) == 0 {
 		return apiKey, errors.New("Blank API key used")
 	}
 
-	if ok := datastore.Environ.DB.CheckAPIKey(apiKey); !ok {
+	if ok := db.CheckAPIKey(apiKey); !ok {
 		return apiKey, errors.New("Unauthorized API key used")
 	}
 
 	return apiKey, nil
 }
+
+// ClientIP returns the IP address of the client. Behind a proxy, it is the last address in the
+// client IP header from the config, as the proxy appends the address of its client to the list
+func ClientIP(r *http.Request, settings config.Settings) string {
+	if header := settings.ClientIPHeader; len(header) > 0 {
+		if value := r.Header.Get(header); len(value) > 0 {
+			addresses := strings.Split(value, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientCertSubject returns the subject of the TLS client certificate from the client certificate
+// header of the config, as set by the proxy that terminated the TLS connection. The header is only
+// accepted from the trusted proxies, and never when the service terminates TLS itself, as it could
+// be set by the client
+func ClientCertSubject(r *http.Request, settings config.Settings) string {
+	header := settings.ClientCertHeader
+	if len(header) == 0 || r.TLS != nil || terminatesTLS(settings) || !TrustedProxy(r, settings) {
+		return ""
+	}
+	return r.Header.Get(header)
+}
+
+// TrustedProxy checks if the connection of the request is from one of the trusted proxies of the
+// config, which are addresses or CIDR networks
+func TrustedProxy(r *http.Request, settings config.Settings) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range settings.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if address := net.ParseIP(proxy); address != nil && address.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// terminatesTLS checks if the service terminates TLS itself, with the certificate files or with
+// the certificates of an ACME server
+func terminatesTLS(settings config.Settings) bool {
+	return len(settings.TLSCertFile) > 0 || len(settings.ACMEDomains) > 0
+}
+
+// DatastoreContext returns the context for the datastore queries of the request, which
+// is limited by the latency budget from the config
+func DatastoreContext(r *http.Request, settings config.Settings) (context.Context, context.CancelFunc) {
+	if timeout := settings.DatastoreTimeout; timeout > 0 {
+		return context.WithTimeout(r.Context(), time.Duration(timeout)*time.Second)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// TimedOut checks if the latency budget of the context has been used up
+func TimedOut(ctx context.Context) bool {
+	return ctx.Err() == context.DeadlineExceeded
+}
+
+// AcceptsCBOR checks if the client negotiated a CBOR response using the Accept header
+func AcceptsCBOR(r *http.Request) bool {
+	return Accepts(r, response.CBORHeader)
+}
+
+// Accepts checks if the client listed the media type in the Accept header. Wildcards
+// are not matched, so a client must ask for the media type explicitly
+func Accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		m, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && m == mediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package request
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		header    string
+		forwarded string
+		expected  string
+	}{
+		{"", "", "192.0.2.1"},
+		{"", "10.0.0.1", "192.0.2.1"},
+		{"X-Forwarded-For", "", "192.0.2.1"},
+		{"X-Forwarded-For", "10.0.0.1", "10.0.0.1"},
+		{"X-Forwarded-For", "203.0.113.9, 10.0.0.1", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/v1/serial", nil)
+		if len(tt.forwarded) > 0 {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		if ip := ClientIP(r, config.Settings{ClientIPHeader: tt.header}); ip != tt.expected {
+			t.Errorf("Expected client IP %s, got: %s", tt.expected, ip)
+		}
+	}
+}
+
+func TestClientCertSubject(t *testing.T) {
+	tests := []struct {
+		settings config.Settings
+		expected string
+	}{
+		{config.Settings{}, ""},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN"}, ""},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"192.0.2.1"}}, "CN=line-1"},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"192.0.2.0/24"}}, "CN=line-1"},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"198.51.100.0/24", "invalid"}}, ""},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"192.0.2.1"}, TLSCertFile: "cert.pem"}, ""},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"192.0.2.1"}, ACMEDomains: []string{"vault.example.com"}}, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/v1/serial", nil)
+		r.Header.Set("X-SSL-Client-S-DN", "CN=line-1")
+
+		if subject := ClientCertSubject(r, tt.settings); subject != tt.expected {
+			t.Errorf("Expected subject '%s', got: '%s'", tt.expected, subject)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// CBORHeader is the CBOR HTTP header, for the constrained HTTP clients of first-boot provisioning tools
+const CBORHeader = "application/cbor"
+
+// CBOR major types
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborSimple   = 7
+)
+
+// EncodeCBOR encodes a value as CBOR (RFC 7049). Only the types used by the API responses
+// are supported: strings, byte strings, booleans, integers, string lists and maps with string
+// keys. Map keys are sorted in the canonical order, so the encoding is deterministic
+func EncodeCBOR(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case bool:
+		if value {
+			buf.WriteByte(cborSimple<<5 | 21)
+		} else {
+			buf.WriteByte(cborSimple<<5 | 20)
+		}
+	case int:
+		if value < 0 {
+			writeCBORHead(buf, cborNegative, uint64(-1-value))
+		} else {
+			writeCBORHead(buf, cborUnsigned, uint64(value))
+		}
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(value)))
+		buf.WriteString(value)
+	case []byte:
+		writeCBORHead(buf, cborBytes, uint64(len(value)))
+		buf.Write(value)
+	case []string:
+		writeCBORHead(buf, cborArray, uint64(len(value)))
+		for _, s := range value {
+			encodeCBOR(buf, s)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		// Canonical CBOR: shorter keys sort first, then byte-wise
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+
+		writeCBORHead(buf, cborMap, uint64(len(keys)))
+		for _, k := range keys {
+			encodeCBOR(buf, k)
+			if err := encodeCBOR(buf, value[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Cannot encode %T as CBOR", v)
+	}
+	return nil
+}
+
+// writeCBORHead writes the initial byte of a data item, with its argument in the shortest form
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= 0xffffffff:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// FormatCBORResponse writes a CBOR response. The content length is always set, as the HTTP
+// stacks of some boards cannot handle a chunked transfer
+func FormatCBORResponse(statusCode int, v interface{}, w http.ResponseWriter) error {
+	data, err := EncodeCBOR(v)
+	if err != nil {
+		log.Printf("Error forming the CBOR response: %v\n", err)
+		return err
+	}
+
+	w.Header().Set("Content-Type", CBORHeader)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(statusCode)
+	_, err = w.Write(data)
+	return err
+}
+
+// FormatCBORError writes the error response as CBOR
+func FormatCBORError(e ErrorResponse, w http.ResponseWriter) error {
+	resp := map[string]interface{}{"error_code": e.Code, "message": e.Message}
+	if len(e.SubCode) > 0 {
+		resp["error_subcode"] = e.SubCode
+	}
+	return FormatCBORResponse(e.StatusCode, resp, w)
+}
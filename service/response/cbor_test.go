@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package response_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func TestCBORSuite(t *testing.T) { check.TestingT(t) }
+
+type CBORSuite struct{}
+
+var _ = check.Suite(&CBORSuite{})
+
+func (s *CBORSuite) TestEncodeCBOR(c *check.C) {
+	// Examples from appendix A of RFC 7049
+	tests := []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{false, "f4"},
+		{true, "f5"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]string{"a", "b"}, "8261616162"},
+		{map[string]interface{}{"a": 1, "b": []string{"c"}}, "a26161016162816163"},
+		// Canonical order sorts shorter keys first
+		{map[string]interface{}{"bb": true, "a": false}, "a26161f4626262f5"},
+	}
+
+	for _, t := range tests {
+		data, err := response.EncodeCBOR(t.Value)
+		c.Assert(err, check.IsNil)
+		c.Assert(hex.EncodeToString(data), check.Equals, t.Expected)
+	}
+}
+
+func (s *CBORSuite) TestEncodeCBORInvalid(c *check.C) {
+	_, err := response.EncodeCBOR(1.5)
+	c.Assert(err, check.NotNil)
+
+	_, err = response.EncodeCBOR(map[string]interface{}{"a": struct{}{}})
+	c.Assert(err, check.NotNil)
+}
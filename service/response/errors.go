@@ -33,6 +33,12 @@ type ErrorResponse struct {
 
 // Standard error messages
 var (
+	ErrorInternal                  = ErrorResponse{false, "error-internal", "", "An unexpected error occurred", http.StatusInternalServerError}
+	ErrorUpstreamTimeout           = ErrorResponse{false, "upstream-timeout", "", "The datastore or keystore did not respond in time", http.StatusGatewayTimeout}
+	ErrorDatastoreUnavailable      = ErrorResponse{false, "datastore-unavailable", "", "The datastore is failing. Please try again later", http.StatusServiceUnavailable}
+	ErrorMaintenance               = ErrorResponse{false, "maintenance", "", "Signing is paused for scheduled maintenance. Please try again later", http.StatusServiceUnavailable}
+	ErrorKeystoreBusy              = ErrorResponse{false, "keystore-busy", "", "The keystore is busy. Please try again later", http.StatusServiceUnavailable}
+	ErrorStandby                   = ErrorResponse{false, "standby", "", "This vault is the standby vault. Please send the request to the active vault", http.StatusServiceUnavailable}
 	ErrorAuth                      = ErrorResponse{false, "error-auth", "", "Your user does not have permissions for the Signing Authority", http.StatusBadRequest}
 	ErrorAuthDisabled              = ErrorResponse{false, "error-auth", "", "This feature is not enabled for this account", http.StatusBadRequest}
 	ErrorInvalidID                 = ErrorResponse{false, "invalid-record", "", "Invalid record ID", http.StatusBadRequest}
@@ -48,14 +54,30 @@ var (
 	ErrorInvalidModelID            = ErrorResponse{false, "invalid-model", "", "Cannot find model with the selected ID", http.StatusBadRequest}
 	ErrorInvalidModelSubstore      = ErrorResponse{false, "invalid-model", "", "Cannot find a matching model or sub-store model", http.StatusBadRequest}
 	ErrorInvalidSubstore           = ErrorResponse{false, "invalid-substore", "", "Cannot find sub-store mapping for the model", http.StatusBadRequest}
+	ErrorSubstoreAPIKey            = ErrorResponse{false, "invalid-substore-api-key", "", "The API key is not the API key of the model that the sub-store model was pivoted from", http.StatusBadRequest}
+	ErrorPivotTargetNotFound       = ErrorResponse{false, "invalid-pivot-target", "", "Cannot find the model of the sub-store brand that the device is pivoted to", http.StatusBadRequest}
+	ErrorCrossAuthorityPivot       = ErrorResponse{false, "cross-authority-pivot", "", "The device is pivoted to another brand and must request its serial with the brand of the sub-store", http.StatusBadRequest}
+	ErrorInvalidStation            = ErrorResponse{false, "invalid-station", "", "The station is not registered for the model", http.StatusBadRequest}
+	ErrorSigningNotAuthorized      = ErrorResponse{false, "signing-not-authorized", "", "The factory is not authorized to sign for the model", http.StatusBadRequest}
 	ErrorInactiveModel             = ErrorResponse{false, "invalid-model", "", "The model is linked with an inactive signing-key", http.StatusBadRequest}
+	ErrorCompromisedModel          = ErrorResponse{false, "compromised-model", "", "The model is linked with a compromised signing-key", http.StatusBadRequest}
 	ErrorInvalidAccount            = ErrorResponse{false, "invalid-account", "", "The account cannot be found", http.StatusBadRequest}
 	ErrorInvalidAssertion          = ErrorResponse{false, "invalid-assertion", "", "The assertion is invalid", http.StatusBadRequest}
+	ErrorMissingAssertion          = ErrorResponse{false, "missing-assertion", "", "The assertion is not stored in the vault", http.StatusNotFound}
 	ErrorInvalidKeypair            = ErrorResponse{false, "invalid-keypair", "", "The keypair is invalid", http.StatusBadRequest}
 	ErrorFetchKeypairs             = ErrorResponse{false, "fetch-keypairs", "", "Error fetching the signing-keys", http.StatusBadRequest}
 	ErrorFetchKeypair              = ErrorResponse{false, "fetch-keypair", "", "Error fetching the signing-key", http.StatusBadRequest}
 	ErrorStoreKeypair              = ErrorResponse{false, "store-keypair", "", "Error string the signing-key", http.StatusBadRequest}
 	ErrorEmptySerial               = ErrorResponse{false, "create-assertion", "", "The serial number is missing from both the header and body", http.StatusBadRequest}
+	ErrorInvalidDeviceKey          = ErrorResponse{false, "invalid-device-key", "", "The device-key is malformed or not accepted for the model", http.StatusBadRequest}
+	ErrorInvalidSerial             = ErrorResponse{false, "invalid-serial", "", "The serial number is not accepted by the serial pipeline of the model", http.StatusBadRequest}
+	ErrorInvalidManufactureDate    = ErrorResponse{false, "invalid-manufacture-date", "", "The manufacture date is invalid or out of bounds", http.StatusBadRequest}
+	ErrorInvalidAssertionFormat    = ErrorResponse{false, "invalid-assertion-format", "", "The headers of the assertion format of the serial-request are invalid", http.StatusBadRequest}
+	ErrorInvalidManifest           = ErrorResponse{false, "invalid-manifest", "", "The device manifest of the serial-request is invalid", http.StatusBadRequest}
+	ErrorDeviceState               = ErrorResponse{false, "device-state", "", "The lifecycle state of the device does not allow it to be signed", http.StatusBadRequest}
+	ErrorQuarantinedDevice         = ErrorResponse{false, "quarantined-device", "", "The device is quarantined and cannot be signed", http.StatusBadRequest}
+	ErrorPolicyDenied              = ErrorResponse{false, "policy-denied", "", "The signing request is denied by the policy of the brand", http.StatusForbidden}
+	ErrorPolicyUnavailable         = ErrorResponse{false, "policy-unavailable", "", "The policy of the brand cannot be checked", http.StatusServiceUnavailable}
 	ErrorCreateAssertion           = ErrorResponse{false, "create-assertion", "", "Error converting the serial-request to a serial assertion", http.StatusBadRequest}
 	ErrorDecodeAssertion           = ErrorResponse{false, "decode-assertion", "", "Error decoding the assertion", http.StatusBadRequest}
 	ErrorCheckAssertion            = ErrorResponse{false, "duplicate-assertion", "", "Error checking the serial-request. Please try again later", http.StatusBadRequest}
@@ -64,5 +86,25 @@ var (
 	ErrorDuplicateAssertion        = ErrorResponse{false, "duplicate-assertion", "", "The serial number and/or device-key have already been used to sign a device", http.StatusBadRequest}
 	ErrorAccountAssertion          = ErrorResponse{false, "account-assertion", "", "Error retrieving the account assertion from the database", http.StatusBadRequest}
 	ErrorSignAssertion             = ErrorResponse{false, "signing-assertion", "", "Error signing the assertion", http.StatusBadRequest}
+	ErrorDebugNotAuthorized        = ErrorResponse{false, "debug-not-authorized", "", "The datastore debugging is only available to a superuser", http.StatusForbidden}
+	ErrorSerialLint                = ErrorResponse{false, "serial-lint", "", "The signed serial assertion violates the content policy of the vault", http.StatusInternalServerError}
+	ErrorSigningLogSink            = ErrorResponse{false, "signing-log-sink", "", "The signing log could not be written to the write-once storage. Please try again later", http.StatusServiceUnavailable}
 	ErrorGenerateNonce             = ErrorResponse{false, "generate-nonce", "", "Error generating a nonce. Please try again later", http.StatusBadRequest}
+	ErrorInvalidNonceCount         = ErrorResponse{false, "invalid-count", "", "The number of nonces requested is invalid", http.StatusBadRequest}
+	ErrorInvalidModelCount         = ErrorResponse{false, "invalid-count", "", "The number of models to verify is invalid", http.StatusBadRequest}
+	ErrorNonceLimit                = ErrorResponse{false, "nonce-limit", "", "Too many unused nonces have been issued for the API key", http.StatusTooManyRequests}
+	ErrorNonceBanned               = ErrorResponse{false, "nonce-banned", "", "The API key is temporarily banned from requesting nonces", http.StatusTooManyRequests}
+	ErrorFetchDashboard            = ErrorResponse{false, "fetch-dashboard", "", "Error fetching the dashboard summary", http.StatusBadRequest}
+	ErrorInvalidReportPeriod       = ErrorResponse{false, "invalid-period", "", "The report period must be valid dates (YYYY-MM-DD) of up to a year", http.StatusBadRequest}
+	ErrorInvalidReportFormat       = ErrorResponse{false, "invalid-format", "", "The report format must be 'json' or 'csv'", http.StatusBadRequest}
+	ErrorFetchReport               = ErrorResponse{false, "fetch-report", "", "Error fetching the production report", http.StatusBadRequest}
+	ErrorSignReport                = ErrorResponse{false, "sign-report", "", "Error signing the production report", http.StatusBadRequest}
+	ErrorFetchRevocations          = ErrorResponse{false, "fetch-revocations", "", "Error fetching the revocation list", http.StatusInternalServerError}
+	ErrorSignRevocations           = ErrorResponse{false, "sign-revocations", "", "Error signing the revocation list", http.StatusInternalServerError}
+	ErrorDeviceCA                  = ErrorResponse{false, "device-ca", "", "Error fetching the device CA", http.StatusInternalServerError}
+	ErrorFetchCertificate          = ErrorResponse{false, "fetch-certificate", "", "Error fetching the device certificate", http.StatusInternalServerError}
+	ErrorMissingCertificate        = ErrorResponse{false, "missing-certificate", "", "The device certificate is not issued by the vault", http.StatusNotFound}
+	ErrorCertificatesDisabled      = ErrorResponse{false, "certificates-disabled", "", "The device certificates are not enabled", http.StatusNotFound}
+	ErrorQueueFull                 = ErrorResponse{false, "queue-full", "", "The vault is busy signing other devices. Please try again later", http.StatusServiceUnavailable}
+	ErrorAuthScheme                = ErrorResponse{false, "auth-scheme", "", "The request does not present the authentication schemes of the endpoint", http.StatusUnauthorized}
 )
@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package revocation
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// Service holds the dependencies of the revocation handlers
+type Service struct {
+	*datastore.Env
+}
+
+// KeyResponse is the JSON response with the public key that verifies the revocations
+type KeyResponse struct {
+	PublicKey string `json:"public-key"`
+	KeyID     string `json:"key-id"`
+}
+
+// List is the API method to fetch the signed revocation list of a brand. The list is the
+// JSON document, with its detached signature in the response headers
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	list, err := BuildList(srv.DB, srv.Config, mux.Vars(r)["authorityID"])
+	if err != nil {
+		log.Message("REVOCATION", response.ErrorFetchRevocations.Code, err.Error())
+		return response.ErrorFetchRevocations
+	}
+
+	document, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		log.Message("REVOCATION", response.ErrorFetchRevocations.Code, err.Error())
+		return response.ErrorFetchRevocations
+	}
+
+	signature, err := datastore.SignReport(srv.DB, srv.Config, document)
+	if err != nil {
+		log.Errorf("Error signing the revocation list: %v", err)
+		return response.ErrorSignRevocations
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set(SignatureHeader, signature.Signature)
+	w.Header().Set(KeyIDHeader, signature.KeyID)
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+	return response.ErrorResponse{Success: true}
+}
+
+// Key is the API method to fetch the public key that verifies the revocation lists and events
+func (srv *Service) Key(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	publicKey, keyID, err := datastore.ReportPublicKey(srv.DB, srv.Config)
+	if err != nil {
+		log.Errorf("Error fetching the reporting key: %v", err)
+		return response.ErrorSignRevocations
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(KeyResponse{PublicKey: publicKey, KeyID: keyID}); err != nil {
+		log.Errorf("Error forming the revocation key response: %v", err)
+	}
+	return response.ErrorResponse{Success: true}
+}
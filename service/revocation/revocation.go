@@ -0,0 +1,182 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package revocation issues the revocations of the devices that move to a revoking lifecycle
+// state e.g. scrapped, so that the ecosystem can act on them: the signed revocation list of each
+// brand, which the signing service serves, and the publication of each revocation upstream
+package revocation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Actions of the published revocation events
+const (
+	ActionRevoke    = "revoke"    // the device moved to a revoking state
+	ActionReinstate = "reinstate" // the device moved out of a revoking state
+)
+
+// Headers of the detached signature of a revocation list or event, made with the vault reporting key
+const (
+	SignatureHeader = "X-Vault-Signature"
+	KeyIDHeader     = "X-Vault-Key-ID"
+)
+
+// publishTimeout is the limit for publishing a revocation event upstream
+const publishTimeout = 10 * time.Second
+
+var publishClient = &http.Client{Timeout: publishTimeout}
+
+// List is the revocation list of a brand: the devices whose current lifecycle state revokes them
+type List struct {
+	BrandID     string                       `json:"brand-id"`
+	Generated   time.Time                    `json:"generated"`
+	States      []string                     `json:"states"`
+	Revocations []datastore.DeviceRevocation `json:"revocations"`
+}
+
+// Event is published upstream when a device is revoked, or reinstated
+type Event struct {
+	Action string `json:"action"`
+	datastore.DeviceRevocation
+}
+
+// Revoking checks if the lifecycle state revokes a device
+func Revoking(settings config.Settings, state string) bool {
+	for _, s := range settings.RevokeDeviceStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildList returns the revocation list of the brand
+func BuildList(db datastore.Datastore, settings config.Settings, brandID string) (List, error) {
+	revocations, err := db.ListDeviceRevocations(brandID, settings.RevokeDeviceStates)
+	if err != nil {
+		return List{}, err
+	}
+
+	states := settings.RevokeDeviceStates
+	if states == nil {
+		states = []string{}
+	}
+	return List{BrandID: brandID, Generated: time.Now().UTC(), States: states, Revocations: revocations}, nil
+}
+
+// Issue publishes the revocation of a device that moved to a revoking state, or its reinstatement
+// when it moved out of one. The transition is already recorded and the device is in the revocation
+// list, so the errors are only logged
+func Issue(db datastore.Datastore, settings config.Settings, state datastore.DeviceState) {
+	event := Event{Action: ActionRevoke}
+	switch {
+	case Revoking(settings, state.State):
+	case Revoking(settings, state.PreviousState):
+		event.Action = ActionReinstate
+	default:
+		return
+	}
+	log.Warningf("Device %s/%s/%s %s: %s", state.Brand, state.Model, state.SerialNumber, eventVerb(event.Action), state.State)
+
+	// Revoke the device certificates, which are listed in the CRL of the device CA
+	if err := db.RevokeDeviceCertificates(state.Brand, state.Model, state.SerialNumber, event.Action == ActionRevoke); err != nil {
+		log.Message("REVOCATION", "revoke-certificates", err.Error())
+	}
+
+	event.DeviceRevocation = datastore.DeviceRevocation{Brand: state.Brand, Model: state.Model, SerialNumber: state.SerialNumber, State: state.State, Revoked: state.Created}
+	logs, err := db.ListSerialSigningLog(state.Brand, state.Model, state.SerialNumber)
+	if err != nil {
+		log.Message("REVOCATION", "fetch-signinglog", err.Error())
+	}
+	if len(logs) > 0 {
+		event.Fingerprint = logs[len(logs)-1].Fingerprint
+	}
+
+	Publish(db, settings, event)
+}
+
+func eventVerb(action string) string {
+	if action == ActionReinstate {
+		return "reinstated"
+	}
+	return "revoked"
+}
+
+// Publish sends the signed revocation event to the upstream URL of the settings, in the background.
+// The event is signed first, as the datastore may be bound to the request of the transition
+var Publish = func(db datastore.Datastore, settings config.Settings, event Event) {
+	if len(settings.RevocationPublishURL) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Error publishing the revocation of %s/%s/%s: %v", event.Brand, event.Model, event.SerialNumber, err)
+		return
+	}
+	signature, err := datastore.SignReport(db, settings, data)
+	if err != nil {
+		metrics.Increment(metrics.RevocationPublishErrors)
+		log.Errorf("Error publishing the revocation of %s/%s/%s: %v", event.Brand, event.Model, event.SerialNumber, err)
+		return
+	}
+
+	go func() {
+		if err := publish(settings.RevocationPublishURL, settings.RevocationPublishAuth, data, signature); err != nil {
+			metrics.Increment(metrics.RevocationPublishErrors)
+			log.Errorf("Error publishing the revocation of %s/%s/%s: %v", event.Brand, event.Model, event.SerialNumber, err)
+			return
+		}
+		metrics.Increment(metrics.RevocationsPublished)
+	}()
+}
+
+func publish(url, authorization string, data []byte, signature datastore.ReportSignature) error {
+	r, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(SignatureHeader, signature.Signature)
+	r.Header.Set(KeyIDHeader, signature.KeyID)
+	if len(authorization) > 0 {
+		r.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := publishClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the revocation endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package revocation_test
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/revocation"
+	check "gopkg.in/check.v1"
+)
+
+func TestRevocationSuite(t *testing.T) { check.TestingT(t) }
+
+type RevocationSuite struct {
+	db     *datastoretest.DB
+	events []revocation.Event
+	urls   []string
+}
+
+var _ = check.Suite(&RevocationSuite{})
+
+var superuser = datastore.User{Username: "sv", Role: datastore.Superuser}
+
+func (s *RevocationSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint("old").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint("new").WithRevision(2).Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A2").Build())
+
+	settings := config.Settings{
+		KeyStoreSecret:       "secret code to encrypt the auth-key hash",
+		RevokeDeviceStates:   []string{datastore.DeviceStateScrapped, datastore.DeviceStateRMA},
+		RevocationPublishURL: "https://revocations.example.com/devices",
+	}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: settings}
+
+	s.events, s.urls = nil, nil
+	revocation.Publish = func(db datastore.Datastore, settings config.Settings, event revocation.Event) {
+		s.urls = append(s.urls, settings.RevocationPublishURL)
+		s.events = append(s.events, event)
+	}
+}
+
+func (s *RevocationSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *RevocationSuite) transition(c *check.C, serialNumber, state string) datastore.DeviceState {
+	st, err := s.db.TransitionAllowedDeviceState(datastore.DeviceState{Brand: "system", Model: "alder", SerialNumber: serialNumber, State: state}, superuser)
+	c.Assert(err, check.IsNil)
+	revocation.Issue(s.db, datastore.Environ.Config, st)
+	return st
+}
+
+func (s *RevocationSuite) TestIssue(c *check.C) {
+	cert := datastore.DeviceCertificate{CertificateSerial: "a1", Brand: "system", Model: "alder", SerialNumber: "A1", NotAfter: time.Now().Add(time.Hour)}
+	c.Assert(s.db.CreateDeviceCertificate(cert), check.IsNil)
+
+	s.transition(c, "A1", datastore.DeviceStateShipped)
+	c.Assert(s.events, check.HasLen, 0)
+
+	s.transition(c, "A1", datastore.DeviceStateRMA)
+	c.Assert(s.events, check.HasLen, 1)
+	c.Assert(s.urls[0], check.Equals, "https://revocations.example.com/devices")
+	c.Assert(s.events[0].Action, check.Equals, revocation.ActionRevoke)
+	c.Assert(s.events[0].SerialNumber, check.Equals, "A1")
+	c.Assert(s.events[0].Fingerprint, check.Equals, "new")
+
+	// The device certificates are revoked with the device, and reinstated with it
+	revoked, err := s.db.ListRevokedDeviceCertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(revoked, check.HasLen, 1)
+
+	s.transition(c, "A1", datastore.DeviceStateShipped)
+	c.Assert(s.events, check.HasLen, 2)
+	c.Assert(s.events[1].Action, check.Equals, revocation.ActionReinstate)
+	c.Assert(s.events[1].State, check.Equals, datastore.DeviceStateShipped)
+	revoked, err = s.db.ListRevokedDeviceCertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(revoked, check.HasLen, 0)
+}
+
+func (s *RevocationSuite) TestList(c *check.C) {
+	s.transition(c, "A1", datastore.DeviceStateScrapped)
+	s.transition(c, "A2", datastore.DeviceStateRMA)
+	s.transition(c, "A2", datastore.DeviceStateShipped)
+
+	w := sendRequest(c, "/v1/revocations/system")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	list := revocation.List{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &list), check.IsNil)
+	c.Assert(list.BrandID, check.Equals, "system")
+	c.Assert(list.Revocations, check.HasLen, 1)
+	c.Assert(list.Revocations[0].SerialNumber, check.Equals, "A1")
+	c.Assert(list.Revocations[0].State, check.Equals, datastore.DeviceStateScrapped)
+	c.Assert(list.Revocations[0].Fingerprint, check.Equals, "new")
+
+	// The detached signature verifies with the public key of the vault
+	k := sendRequest(c, "/v1/revocationkey")
+	c.Assert(k.Code, check.Equals, http.StatusOK)
+	key := revocation.KeyResponse{}
+	c.Assert(json.Unmarshal(k.Body.Bytes(), &key), check.IsNil)
+	c.Assert(w.Header().Get(revocation.KeyIDHeader), check.Equals, key.KeyID)
+
+	block, _ := pem.Decode([]byte(key.PublicKey))
+	c.Assert(block, check.NotNil)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	c.Assert(err, check.IsNil)
+	signature, err := base64.StdEncoding.DecodeString(w.Header().Get(revocation.SignatureHeader))
+	c.Assert(err, check.IsNil)
+	digest := sha256.Sum256(w.Body.Bytes())
+	c.Assert(rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature), check.IsNil)
+}
+
+func (s *RevocationSuite) TestListDisabled(c *check.C) {
+	s.transition(c, "A1", datastore.DeviceStateScrapped)
+	datastore.Environ.Config.RevokeDeviceStates = nil
+
+	w := sendRequest(c, "/v1/revocations/system")
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	list := revocation.List{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &list), check.IsNil)
+	c.Assert(list.Revocations, check.HasLen, 0)
+}
+
+func (s *RevocationSuite) TestListError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendRequest(c, "/v1/revocations/system")
+	c.Assert(w.Code, check.Equals, http.StatusInternalServerError)
+}
+
+func sendRequest(c *check.C, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
+	return w
+}
@@ -24,16 +24,32 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
 	"github.com/CanonicalLtd/serial-vault/service/account"
 	"github.com/CanonicalLtd/serial-vault/service/app"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/billing"
 	"github.com/CanonicalLtd/serial-vault/service/core"
+	"github.com/CanonicalLtd/serial-vault/service/dashboard"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/CanonicalLtd/serial-vault/service/devicecert"
+	"github.com/CanonicalLtd/serial-vault/service/events"
+	"github.com/CanonicalLtd/serial-vault/service/impersonation"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
+	"github.com/CanonicalLtd/serial-vault/service/invalidation"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
 	"github.com/CanonicalLtd/serial-vault/service/model"
+	"github.com/CanonicalLtd/serial-vault/service/onboarding"
 	"github.com/CanonicalLtd/serial-vault/service/pivot"
+	"github.com/CanonicalLtd/serial-vault/service/replication"
+	"github.com/CanonicalLtd/serial-vault/service/report"
+	"github.com/CanonicalLtd/serial-vault/service/revocation"
+	"github.com/CanonicalLtd/serial-vault/service/setting"
 	"github.com/CanonicalLtd/serial-vault/service/sign"
 	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	"github.com/CanonicalLtd/serial-vault/service/station"
+	"github.com/CanonicalLtd/serial-vault/service/stats"
 	"github.com/CanonicalLtd/serial-vault/service/store"
 	"github.com/CanonicalLtd/serial-vault/service/substore"
 	"github.com/CanonicalLtd/serial-vault/service/testlog"
@@ -43,133 +59,344 @@ import (
 )
 
 // SigningRouter returns the application route handler for the signing service methods
-func SigningRouter() *mux.Router {
+func (srv *Service) SigningRouter() *mux.Router {
+	assertions := &assertion.Service{Env: srv.Env}
+	certificates := &devicecert.Service{Env: srv.Env}
+	pivots := &pivot.Service{Env: srv.Env}
+	revocations := &revocation.Service{Env: srv.Env}
+	signer := &sign.Service{Env: srv.Env}
+	status := &core.Service{Env: srv.Env}
+	testLogs := &testlog.Service{Env: srv.Env}
+
 	// Start the web service router
 	router := mux.NewRouter()
 
 	// API routes
-	router.Handle("/v1/version", Middleware(http.HandlerFunc(core.Version))).Methods("GET")
-	router.Handle("/v1/health", Middleware(http.HandlerFunc(core.Health))).Methods("GET")
-	router.Handle("/v1/serial", Middleware(ErrorHandler(sign.Serial))).Methods("POST")
-	router.Handle("/v1/request-id", Middleware(ErrorHandler(sign.RequestID))).Methods("POST")
-	router.Handle("/v1/model", Middleware(ErrorHandler(assertion.ModelAssertion))).Methods("POST")
-	router.Handle("/v1/pivot", Middleware(ErrorHandler(pivot.Model))).Methods("POST")
-	router.Handle("/v1/pivotmodel", Middleware(ErrorHandler(pivot.ModelAssertion))).Methods("POST")
-	router.Handle("/v1/pivotserial", Middleware(ErrorHandler(pivot.SerialAssertion))).Methods("POST")
-	router.Handle("/v1/pivotuser", Middleware(ErrorHandler(pivot.SystemUserAssertion))).Methods("POST")
+	router.Handle("/v1/version", srv.middleware(http.HandlerFunc(status.Version))).Methods("GET")
+	router.Handle("/v1/health", srv.middleware(http.HandlerFunc(status.Health))).Methods("GET")
+	router.Handle("/v1/metrics", srv.middleware(http.HandlerFunc(metrics.Handler))).Methods("GET")
+	router.Handle("/readyz", srv.middleware(http.HandlerFunc(status.Ready))).Methods("GET")
+	router.Handle("/v1/queue", srv.middleware(http.HandlerFunc(status.Queue))).Methods("GET")
+	router.Handle("/v1/serial", srv.signingMiddleware(srv.queued(ErrorHandler(signer.Serial)))).Methods("POST")
+	router.Handle("/v1/request-id", srv.signingMiddleware(ErrorHandler(signer.RequestID))).Methods("POST")
+	router.Handle("/v1/request-ids", srv.signingMiddleware(ErrorHandler(signer.RequestIDBatch))).Methods("POST")
+	router.Handle("/v1/verify", srv.signingMiddleware(ErrorHandler(signer.Verify))).Methods("POST")
+	router.Handle("/v1/apikey/verify", srv.signingMiddleware(ErrorHandler(signer.VerifyAPIKey))).Methods("POST")
+	router.Handle("/v1/model", srv.signingMiddleware(srv.queued(ErrorHandler(assertions.ModelAssertion)))).Methods("POST")
+	router.Handle("/v1/assertions/bundle", srv.signingMiddleware(ErrorHandler(assertions.Bundle))).Methods("GET")
+	router.Handle("/v1/pivot", srv.signingMiddleware(ErrorHandler(pivots.Model))).Methods("POST")
+	router.Handle("/v1/pivotmodel", srv.signingMiddleware(srv.queued(ErrorHandler(pivots.ModelAssertion)))).Methods("POST")
+	router.Handle("/v1/pivotserial", srv.signingMiddleware(srv.queued(ErrorHandler(pivots.SerialAssertion)))).Methods("POST")
+	router.Handle("/v1/pivotuser", srv.signingMiddleware(srv.queued(ErrorHandler(pivots.SystemUserAssertion)))).Methods("POST")
+
+	// API routes: signed revocation lists of the devices
+	router.Handle("/v1/revocationkey", srv.middleware(ErrorHandler(revocations.Key))).Methods("GET")
+	router.Handle("/v1/revocations/{authorityID}", srv.middleware(srv.compressed(ErrorHandler(revocations.List)))).Methods("GET")
+
+	// API routes: device certificates, with the CRL and status of the device CA
+	router.Handle("/v1/certificates/ca", srv.middleware(ErrorHandler(certificates.CA))).Methods("GET")
+	router.Handle("/v1/certificates/crl", srv.middleware(ErrorHandler(certificates.CRL))).Methods("GET")
+	router.Handle("/v1/certificates/status/{serial}", srv.middleware(ErrorHandler(certificates.Status))).Methods("GET")
+	router.Handle("/v1/certificates/device", srv.signingMiddleware(ErrorHandler(certificates.Device))).Methods("GET")
 
 	// Test log upload routes (only in the factory)
-	if datastore.InFactory() {
-		router.Handle("/testlog", Middleware(http.HandlerFunc(testlog.Index))).Methods("GET")
-		router.Handle("/testlog", Middleware(http.HandlerFunc(testlog.Submit))).Methods("POST")
+	if srv.Env.InFactory() {
+		router.Handle("/testlog", srv.middleware(http.HandlerFunc(testlog.Index))).Methods("GET")
+		router.Handle("/testlog", srv.middleware(http.HandlerFunc(testLogs.Submit))).Methods("POST")
 	}
 
 	return router
 }
 
 // AdminRouter returns the application route handler for administrating the application
-func AdminRouter() *mux.Router {
+func (srv *Service) AdminRouter() *mux.Router {
+	accounts := &account.Service{Env: srv.Env}
+	approvals := &approval.Service{Env: srv.Env}
+	assertions := &assertion.Service{Env: srv.Env}
+	billings := &billing.Service{Env: srv.Env}
+	dashboards := &dashboard.Service{Env: srv.Env}
+	devices := &device.Service{Env: srv.Env}
+	liveEvents := &events.Service{Env: srv.Env}
+	impersonations := &impersonation.Service{Env: srv.Env}
+	instances := &instance.Service{Env: srv.Env}
+	keypairs := &keypair.Service{Env: srv.Env}
+	models := &model.Service{Env: srv.Env}
+	onboardings := &onboarding.Service{Env: srv.Env}
+	replications := &replication.Service{Env: srv.Env}
+	reports := &report.Service{Env: srv.Env}
+	settings := &setting.Service{Env: srv.Env}
+	signingLogs := &signinglog.Service{Env: srv.Env}
+	sso := &usso.Service{Env: srv.Env}
+	stations := &station.Service{Env: srv.Env}
+	statistics := &stats.Service{Env: srv.Env}
+	status := &core.Service{Env: srv.Env}
+	stores := &store.Service{Env: srv.Env}
+	substores := &substore.Service{Env: srv.Env}
+	testLogs := &testlog.Service{Env: srv.Env}
+	users := &user.Service{Env: srv.Env}
+	webapp := &app.Service{Env: srv.Env}
+
 	// Start the web service router
 	router := mux.NewRouter()
 
-	router.Handle("/v1/version", Middleware(http.HandlerFunc(core.Version))).Methods("GET")
-	router.Handle("/v1/health", Middleware(http.HandlerFunc(core.Health))).Methods("GET")
+	router.Handle("/v1/version", srv.middleware(http.HandlerFunc(status.Version))).Methods("GET")
+	router.Handle("/v1/health", srv.middleware(http.HandlerFunc(status.Health))).Methods("GET")
+	router.Handle("/v1/metrics", srv.middleware(http.HandlerFunc(metrics.Handler))).Methods("GET")
 
 	// API routes: csrf token and auth token
-	router.Handle("/v1/token", MiddlewareWithCSRF(http.HandlerFunc(core.Token))).Methods("GET")
-	router.Handle("/v1/authtoken", MiddlewareWithCSRF(http.HandlerFunc(core.Token))).Methods("GET")
+	router.Handle("/v1/token", srv.middlewareWithCSRF(http.HandlerFunc(status.Token))).Methods("GET")
+	router.Handle("/v1/authtoken", srv.middlewareWithCSRF(http.HandlerFunc(status.Token))).Methods("GET")
 
 	// API routes: models admin
-	router.Handle("/v1/models", MiddlewareWithCSRF(http.HandlerFunc(model.List))).Methods("GET")
-	router.Handle("/v1/models/assertion", MiddlewareWithCSRF(http.HandlerFunc(model.AssertionHeaders))).Methods("POST")
-	router.Handle("/v1/models", MiddlewareWithCSRF(http.HandlerFunc(model.Create))).Methods("POST")
-	router.Handle("/v1/models/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(model.Get))).Methods("GET")
-	router.Handle("/v1/models/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(model.Update))).Methods("PUT")
-	router.Handle("/v1/models/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(model.Delete))).Methods("DELETE")
+	router.Handle("/v1/models", srv.adminMiddleware(srv.compressed(http.HandlerFunc(models.List)))).Methods("GET")
+	router.Handle("/v1/models/assertion", srv.adminMiddleware(http.HandlerFunc(models.AssertionHeaders))).Methods("POST")
+	router.Handle("/v1/models", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.Create)))).Methods("POST")
+	router.Handle("/v1/models/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(models.Get))).Methods("GET")
+	router.Handle("/v1/models/{id:[0-9]+}", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.Update)))).Methods("PUT")
+	router.Handle("/v1/models/{id:[0-9]+}", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.Delete)))).Methods("DELETE")
+	router.Handle("/v1/models/{id:[0-9]+}/preview", srv.adminMiddleware(http.HandlerFunc(models.Preview))).Methods("GET")
+	router.Handle("/v1/models/{id:[0-9]+}/fallback-keys", srv.adminMiddleware(http.HandlerFunc(models.FallbackKeys))).Methods("GET")
+	router.Handle("/v1/models/{id:[0-9]+}/fallback-keys", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.UpdateFallbackKeys)))).Methods("PUT")
+	router.Handle("/v1/models/{id:[0-9]+}/canary", srv.adminMiddleware(http.HandlerFunc(models.Canary))).Methods("GET")
+	router.Handle("/v1/models/{id:[0-9]+}/canary", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.UpdateCanary)))).Methods("PUT")
+	router.Handle("/v1/models/{id:[0-9]+}/clone", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.Clone)))).Methods("POST")
+	router.Handle("/v1/models/{id:[0-9]+}/keypairs/history", srv.adminMiddleware(http.HandlerFunc(models.KeypairHistory))).Methods("GET")
+	router.Handle("/v1/models/keypairs/assign", srv.adminMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.AssignKeypair)))).Methods("POST")
 
 	// API routes: signing-keys
-	router.Handle("/v1/keypairs", MiddlewareWithCSRF(http.HandlerFunc(keypair.List))).Methods("GET")
-	router.Handle("/v1/keypairs", MiddlewareWithCSRF(http.HandlerFunc(keypair.Create))).Methods("POST")
-	router.Handle("/v1/keypairs/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(keypair.Get))).Methods("GET")
-	router.Handle("/v1/keypairs/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(keypair.Update))).Methods("PUT")
-	router.Handle("/v1/keypairs/{id:[0-9]+}/disable", MiddlewareWithCSRF(http.HandlerFunc(keypair.Disable))).Methods("POST")
-	router.Handle("/v1/keypairs/{id:[0-9]+}/enable", MiddlewareWithCSRF(http.HandlerFunc(keypair.Enable))).Methods("POST")
-	router.Handle("/v1/keypairs/assertion", MiddlewareWithCSRF(http.HandlerFunc(keypair.Assertion))).Methods("POST")
-
-	router.Handle("/v1/keypairs/generate", MiddlewareWithCSRF(http.HandlerFunc(keypair.Generate))).Methods("POST")
-	router.Handle("/v1/keypairs/status/{authorityID}/{keyName}", MiddlewareWithCSRF(http.HandlerFunc(keypair.Status))).Methods("GET")
-	router.Handle("/v1/keypairs/status", MiddlewareWithCSRF(http.HandlerFunc(keypair.Progress))).Methods("GET")
-	router.Handle("/v1/keypairs/register", MiddlewareWithCSRF(http.HandlerFunc(store.KeyRegister))).Methods("POST")
+	router.Handle("/v1/keypairs", srv.adminMiddleware(srv.compressed(http.HandlerFunc(keypairs.List)))).Methods("GET")
+	router.Handle("/v1/keypairs", srv.adminMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.Create)))).Methods("POST")
+	router.Handle("/v1/keypairs/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(keypairs.Get))).Methods("GET")
+	router.Handle("/v1/keypairs/{id:[0-9]+}", srv.adminMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.Update)))).Methods("PUT")
+	router.Handle("/v1/keypairs/{id:[0-9]+}/disable", srv.adminMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.Disable)))).Methods("POST")
+	router.Handle("/v1/keypairs/{id:[0-9]+}/enable", srv.adminMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.Enable)))).Methods("POST")
+	router.Handle("/v1/keypairs/{id:[0-9]+}/models", srv.adminMiddleware(http.HandlerFunc(keypairs.Models))).Methods("GET")
+	router.Handle("/v1/keypairs/{id:[0-9]+}/models", srv.adminMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.UpdateModels)))).Methods("PUT")
+	router.Handle("/v1/keypairs/{id:[0-9]+}/compromise", srv.adminMiddleware(http.HandlerFunc(keypairs.CompromiseReport))).Methods("GET")
+	router.Handle("/v1/keypairs/{id:[0-9]+}/compromise", srv.adminMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.Compromise)))).Methods("POST")
+	router.Handle("/v1/keypairs/assertion", srv.adminMiddleware(http.HandlerFunc(keypairs.Assertion))).Methods("POST")
+	router.Handle("/v1/delegations/{authorityID}", srv.adminMiddleware(http.HandlerFunc(keypairs.Delegations))).Methods("GET")
+	router.Handle("/v1/delegations/{authorityID}", srv.adminMiddleware(http.HandlerFunc(keypairs.CreateDelegation))).Methods("POST")
+	router.Handle("/v1/delegations/{authorityID}/rootkey", srv.adminMiddleware(http.HandlerFunc(keypairs.UpdateRootKey))).Methods("PUT")
+	router.Handle("/v1/delegations/{authorityID}/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(keypairs.DeleteDelegation))).Methods("DELETE")
+
+	router.Handle("/v1/keypairs/generate", srv.adminMiddleware(http.HandlerFunc(keypairs.Generate))).Methods("POST")
+	router.Handle("/v1/keypairs/status/{authorityID}/{keyName}", srv.adminMiddleware(http.HandlerFunc(keypairs.Status))).Methods("GET")
+	router.Handle("/v1/keypairs/status", srv.adminMiddleware(http.HandlerFunc(keypairs.Progress))).Methods("GET")
+	router.Handle("/v1/keypairs/registration", srv.adminMiddleware(http.HandlerFunc(keypairs.Registration))).Methods("GET")
+	router.Handle("/v1/keypairs/register", srv.adminMiddleware(http.HandlerFunc(stores.KeyRegister))).Methods("POST")
+
+	// API routes: dashboard
+	router.Handle("/v1/dashboard", srv.adminMiddleware(http.HandlerFunc(dashboards.Summary))).Methods("GET")
 
 	// API routes: signing log
-	router.Handle("/v1/signinglog", MiddlewareWithCSRF(http.HandlerFunc(signinglog.List))).Methods("GET")
-	router.Handle("/v1/signinglog/account/{authorityID}", MiddlewareWithCSRF(http.HandlerFunc(signinglog.ListForAccount))).Methods("GET")
-	router.Handle("/v1/signinglog/account/{authorityID}/filters", MiddlewareWithCSRF(http.HandlerFunc(signinglog.ListFilters))).Methods("GET")
+	router.Handle("/v1/signinglog", srv.adminMiddleware(srv.compressed(http.HandlerFunc(signingLogs.List)))).Methods("GET")
+	router.Handle("/v1/signinglog/account/{authorityID}", srv.adminMiddleware(srv.compressed(http.HandlerFunc(signingLogs.ListForAccount)))).Methods("GET")
+	router.Handle("/v1/signinglog/account/{authorityID}/filters", srv.adminMiddleware(srv.compressed(http.HandlerFunc(signingLogs.ListFilters)))).Methods("GET")
+	router.Handle("/v1/signinglog/account/{authorityID}/search", srv.adminMiddleware(srv.compressed(http.HandlerFunc(signingLogs.SearchForAccount)))).Methods("GET")
+	router.Handle("/v1/signinglog/account/{authorityID}/shares", srv.adminMiddleware(http.HandlerFunc(signingLogs.ListShareTokens))).Methods("GET")
+	router.Handle("/v1/signinglog/account/{authorityID}/shares", srv.adminMiddleware(http.HandlerFunc(signingLogs.CreateShareToken))).Methods("POST")
+	router.Handle("/v1/signinglog/shares/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(signingLogs.DeleteShareToken))).Methods("DELETE")
+	router.Handle("/v1/signinglog/{id:[0-9]+}/annotations", srv.adminMiddleware(http.HandlerFunc(signingLogs.Annotate))).Methods("POST")
+
+	// API routes: signed production reports
+	router.Handle("/v1/reports/account/{authorityID}", srv.adminMiddleware(srv.compressed(http.HandlerFunc(reports.Report)))).Methods("GET")
+	router.Handle("/v1/reports/key", srv.adminMiddleware(srv.compressed(http.HandlerFunc(reports.Key)))).Methods("GET")
+	router.Handle("/v1/reports/keypairs", srv.adminMiddleware(srv.compressed(http.HandlerFunc(reports.Attestation)))).Methods("GET")
 
 	// API routes: account assertions
-	router.Handle("/v1/accounts", MiddlewareWithCSRF(http.HandlerFunc(account.List))).Methods("GET")
-	router.Handle("/v1/accounts", MiddlewareWithCSRF(http.HandlerFunc(account.Create))).Methods("POST")
-	router.Handle("/v1/accounts/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(account.Update))).Methods("PUT")
-	router.Handle("/v1/accounts/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(account.Get))).Methods("GET")
-	router.Handle("/v1/accounts/upload", MiddlewareWithCSRF(http.HandlerFunc(account.Upload))).Methods("POST")
-	router.Handle("/v1/accounts/{id:[0-9]+}/stores", MiddlewareWithCSRF(http.HandlerFunc(substore.List))).Methods("GET")
-	router.Handle("/v1/accounts/stores/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(substore.Update))).Methods("PUT")
-	router.Handle("/v1/accounts/stores/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(substore.Delete))).Methods("DELETE")
-	router.Handle("/v1/accounts/stores", MiddlewareWithCSRF(http.HandlerFunc(substore.Create))).Methods("POST")
+	router.Handle("/v1/accounts", srv.adminMiddleware(srv.compressed(http.HandlerFunc(accounts.List)))).Methods("GET")
+	router.Handle("/v1/accounts", srv.adminMiddleware(http.HandlerFunc(accounts.Create))).Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(accounts.Update))).Methods("PUT")
+	router.Handle("/v1/accounts/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(accounts.Get))).Methods("GET")
+	router.Handle("/v1/accounts/upload", srv.adminMiddleware(http.HandlerFunc(accounts.Upload))).Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores", srv.adminMiddleware(http.HandlerFunc(substores.List))).Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/import", srv.adminMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.Import)))).Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/export", srv.adminMiddleware(http.HandlerFunc(substores.Export))).Methods("GET")
+	router.Handle("/v1/accounts/stores/{id:[0-9]+}", srv.adminMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.Update)))).Methods("PUT")
+	router.Handle("/v1/accounts/stores/{id:[0-9]+}", srv.adminMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.Delete)))).Methods("DELETE")
+	router.Handle("/v1/accounts/stores", srv.adminMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.Create)))).Methods("POST")
+
+	// API routes: provisioning stations
+	router.Handle("/v1/models/{id:[0-9]+}/stations", srv.adminMiddleware(http.HandlerFunc(stations.List))).Methods("GET")
+	router.Handle("/v1/models/stations/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(stations.Delete))).Methods("DELETE")
+	router.Handle("/v1/models/stations", srv.adminMiddleware(http.HandlerFunc(stations.Create))).Methods("POST")
+
+	// API routes: lifecycle states of the devices
+	router.Handle("/v1/devices/{authorityID}/{model}/{serial}/state", srv.adminMiddleware(http.HandlerFunc(devices.State))).Methods("GET")
+	router.Handle("/v1/devices/{authorityID}/{model}/{serial}/state", srv.adminMiddleware(http.HandlerFunc(devices.Transition))).Methods("POST")
+
+	// API routes: quarantine list of the devices
+	router.Handle("/v1/quarantine/{authorityID}", srv.adminMiddleware(http.HandlerFunc(devices.Quarantine))).Methods("GET")
+	router.Handle("/v1/quarantine/{authorityID}", srv.adminMiddleware(http.HandlerFunc(devices.QuarantineDevices))).Methods("POST")
+	router.Handle("/v1/quarantine/{authorityID}/import", srv.adminMiddleware(http.HandlerFunc(devices.QuarantineImport))).Methods("POST")
+	router.Handle("/v1/quarantine/{authorityID}/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(devices.QuarantineRelease))).Methods("DELETE")
 
 	// API routes: system-user assertion
-	router.Handle("/v1/assertions", MiddlewareWithCSRF(http.HandlerFunc(assertion.SystemUserAssertion))).Methods("POST")
+	router.Handle("/v1/assertions", srv.adminMiddleware(http.HandlerFunc(assertions.SystemUserAssertion))).Methods("POST")
 
 	// API routes: users management
-	router.Handle("/v1/users", MiddlewareWithCSRF(http.HandlerFunc(user.List))).Methods("GET")
-	router.Handle("/v1/users", MiddlewareWithCSRF(http.HandlerFunc(user.Create))).Methods("POST")
-	router.Handle("/v1/users/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(user.Get))).Methods("GET")
-	router.Handle("/v1/users/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(user.Update))).Methods("PUT")
-	router.Handle("/v1/users/{id:[0-9]+}", MiddlewareWithCSRF(http.HandlerFunc(user.Delete))).Methods("DELETE")
-	router.Handle("/v1/users/{id:[0-9]+}/otheraccounts", MiddlewareWithCSRF(http.HandlerFunc(user.GetOtherAccounts))).Methods("GET")
+	router.Handle("/v1/users", srv.adminMiddleware(srv.compressed(http.HandlerFunc(users.List)))).Methods("GET")
+	router.Handle("/v1/users", srv.adminMiddleware(http.HandlerFunc(users.Create))).Methods("POST")
+	router.Handle("/v1/users/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(users.Get))).Methods("GET")
+	router.Handle("/v1/users/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(users.Update))).Methods("PUT")
+	router.Handle("/v1/users/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(users.Delete))).Methods("DELETE")
+	router.Handle("/v1/users/{id:[0-9]+}/otheraccounts", srv.adminMiddleware(http.HandlerFunc(users.GetOtherAccounts))).Methods("GET")
+	router.Handle("/v1/users/{id:[0-9]+}/syncmodels", srv.adminMiddleware(http.HandlerFunc(users.ListSyncModels))).Methods("GET")
+	router.Handle("/v1/users/{id:[0-9]+}/syncmodels/scope", srv.adminMiddleware(http.HandlerFunc(users.ScopeSyncModels))).Methods("PUT")
+	router.Handle("/v1/users/syncmodels/{id:[0-9]+}", srv.adminMiddleware(http.HandlerFunc(users.DeleteSyncModel))).Methods("DELETE")
+	router.Handle("/v1/users/syncmodels", srv.adminMiddleware(http.HandlerFunc(users.CreateSyncModel))).Methods("POST")
+
+	// API routes: approvals of the sensitive operations
+	router.Handle("/v1/approvals", srv.adminMiddleware(srv.compressed(http.HandlerFunc(approvals.List)))).Methods("GET")
+	router.Handle("/v1/approvals/{id:[0-9]+}/approve", srv.adminMiddleware(http.HandlerFunc(approvals.Approve))).Methods("POST")
+	router.Handle("/v1/approvals/{id:[0-9]+}/reject", srv.adminMiddleware(http.HandlerFunc(approvals.Reject))).Methods("POST")
+
+	// API routes: usage of the accounts in the billing periods
+	router.Handle("/v1/billing", srv.adminMiddleware(http.HandlerFunc(billings.List))).Methods("GET")
+	router.Handle("/v1/billing/{period:[0-9]{4}-[0-9]{2}}", srv.adminMiddleware(srv.compressed(http.HandlerFunc(billings.Period)))).Methods("GET")
+	router.Handle("/v1/billing/{period:[0-9]{4}-[0-9]{2}}/close", srv.adminMiddleware(http.HandlerFunc(billings.Close))).Methods("POST")
+
+	// API routes: onboarding of the new brands
+	router.Handle("/v1/onboardings", srv.adminMiddleware(srv.compressed(http.HandlerFunc(onboardings.List)))).Methods("GET")
+	router.Handle("/v1/onboardings/{id:[0-9]+}/approve", srv.adminMiddleware(http.HandlerFunc(onboardings.Approve))).Methods("POST")
+	router.Handle("/v1/onboardings/{id:[0-9]+}/reject", srv.adminMiddleware(http.HandlerFunc(onboardings.Reject))).Methods("POST")
+
+	// API routes: support mode, with the audit log of the superusers acting as brand admins
+	router.Handle("/v1/impersonate", srv.adminMiddleware(http.HandlerFunc(impersonations.Start))).Methods("POST")
+	router.Handle("/v1/impersonate", srv.adminMiddleware(http.HandlerFunc(impersonations.Stop))).Methods("DELETE")
+	router.Handle("/v1/auditlog", srv.adminMiddleware(srv.compressed(http.HandlerFunc(impersonations.List)))).Methods("GET")
+
+	// API routes: config settings
+	router.Handle("/v1/settings", srv.adminMiddleware(srv.compressed(http.HandlerFunc(settings.List)))).Methods("GET")
+	router.Handle("/v1/settings/{namespace}/{name}", srv.adminMiddleware(http.HandlerFunc(settings.Update))).Methods("PUT")
+	router.Handle("/v1/settings/{namespace}/{name}/history", srv.adminMiddleware(http.HandlerFunc(settings.History))).Methods("GET")
+
+	// API routes: instance registry
+	router.Handle("/v1/instances", srv.adminMiddleware(srv.compressed(http.HandlerFunc(instances.List)))).Methods("GET")
+	router.Handle("/v1/instances/checkins", srv.adminMiddleware(srv.compressed(http.HandlerFunc(instances.CheckIns)))).Methods("GET")
+	router.Handle("/v1/events", srv.adminMiddleware(http.HandlerFunc(liveEvents.Stream))).Methods("GET")
+
+	// API routes: request and datastore statistics
+	router.Handle("/v1/debug/stats", srv.adminMiddleware(http.HandlerFunc(statistics.Stats))).Methods("GET")
+	router.Handle("/v1/debug/keystore", srv.adminMiddleware(http.HandlerFunc(statistics.Keystore))).Methods("GET")
+	router.Handle("/v1/debug/selfcheck", srv.adminMiddleware(http.HandlerFunc(statistics.SelfCheck))).Methods("GET")
 
 	// OpenID routes: using Ubuntu SSO
-	router.Handle("/login", MiddlewareWithCSRF(http.HandlerFunc(usso.LoginHandler)))
-	router.Handle("/logout", MiddlewareWithCSRF(http.HandlerFunc(usso.LogoutHandler)))
+	router.Handle("/login", srv.middlewareWithCSRF(http.HandlerFunc(sso.LoginHandler)))
+	router.Handle("/logout", srv.middlewareWithCSRF(http.HandlerFunc(sso.LogoutHandler)))
 
 	// Web application routes
-	path := []string{datastore.Environ.Config.DocRoot, "/static/"}
+	path := []string{srv.Env.Config.DocRoot, "/static/"}
 	fs := http.StripPrefix("/static/", http.FileServer(http.Dir(strings.Join(path, ""))))
 	router.PathPrefix("/static/").Handler(fs)
-	router.PathPrefix("/signing-keys").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/models").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/keypairs").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/accounts").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/signinglog").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/substores").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/systemuser").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/users").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.PathPrefix("/notfound").Handler(MiddlewareWithCSRF(http.HandlerFunc(app.Index)))
-	router.Handle("/", MiddlewareWithCSRF(http.HandlerFunc(app.Index))).Methods("GET")
+	router.PathPrefix("/signing-keys").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/models").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/keypairs").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/accounts").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/signinglog").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/substores").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/systemuser").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/users").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.PathPrefix("/notfound").Handler(srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index)))
+	router.Handle("/", srv.middlewareWithCSRF(http.HandlerFunc(webapp.Index))).Methods("GET")
 
 	// Admin API routes
-	router.Handle("/api/signinglog", Middleware(http.HandlerFunc(signinglog.APIList))).Methods("GET")
-	router.Handle("/api/keypairs", Middleware(http.HandlerFunc(keypair.APIList))).Methods("GET")
-	router.Handle("/api/accounts/{id:[0-9]+}/stores", Middleware(http.HandlerFunc(substore.APIList))).Methods("GET")
-	router.Handle("/api/accounts/stores/{id:[0-9]+}", Middleware(http.HandlerFunc(substore.APIUpdate))).Methods("PUT")
-	router.Handle("/api/accounts/stores/{id:[0-9]+}", Middleware(http.HandlerFunc(substore.APIDelete))).Methods("DELETE")
-	router.Handle("/api/accounts/stores", Middleware(http.HandlerFunc(substore.APICreate))).Methods("POST")
-	router.Handle("/api/assertions/checkserial", Middleware(http.HandlerFunc(assertion.APIValidateSerial))).Methods("POST")
-	router.Handle("/api/assertions", Middleware(http.HandlerFunc(assertion.APISystemUser))).Methods("POST")
-	router.Handle("/api/models/{id:[0-9]+}", Middleware(http.HandlerFunc(model.APIGet))).Methods("GET")
-	router.Handle("/api/models/{id:[0-9]+}", Middleware(http.HandlerFunc(model.APIUpdate))).Methods("PUT")
-	router.Handle("/api/models/{id:[0-9]+}", Middleware(http.HandlerFunc(model.APIDelete))).Methods("DELETE")
-	router.Handle("/api/models", Middleware(http.HandlerFunc(model.APICreate))).Methods("POST")
-	router.Handle("/api/models/assertion", Middleware(http.HandlerFunc(model.APIAssertionHeaders))).Methods("POST")
+	router.Handle("/api/signinglog", srv.apiMiddleware(srv.compressed(http.HandlerFunc(signingLogs.APIList)))).Methods("GET")
+	router.Handle("/api/signinglog/account/{authorityID}/search", srv.apiMiddleware(srv.compressed(http.HandlerFunc(signingLogs.APISearchForAccount)))).Methods("GET")
+	router.Handle("/api/signinglog/account/{authorityID}/shares", srv.apiMiddleware(http.HandlerFunc(signingLogs.APIListShareTokens))).Methods("GET")
+	router.Handle("/api/signinglog/account/{authorityID}/shares", srv.apiMiddleware(http.HandlerFunc(signingLogs.APICreateShareToken))).Methods("POST")
+	router.Handle("/api/signinglog/shares/{id:[0-9]+}", srv.apiMiddleware(http.HandlerFunc(signingLogs.APIDeleteShareToken))).Methods("DELETE")
+	router.Handle("/api/signinglog/account/{authorityID}/import", srv.apiMiddleware(http.HandlerFunc(signingLogs.APIImport))).Methods("POST")
+	router.Handle("/api/signinglog/{id:[0-9]+}/annotations", srv.apiMiddleware(http.HandlerFunc(signingLogs.APIAnnotate))).Methods("POST")
+	router.Handle("/api/signinglog/tail", srv.apiMiddleware(http.HandlerFunc(signingLogs.APITail))).Methods("GET")
+	router.Handle("/api/replication/signinglog", srv.apiMiddleware(srv.compressed(http.HandlerFunc(replications.APISigningLog)))).Methods("GET")
+	router.Handle("/api/dashboard", srv.apiMiddleware(http.HandlerFunc(dashboards.APISummary))).Methods("GET")
+	router.Handle("/api/reports/account/{authorityID}", srv.apiMiddleware(srv.compressed(http.HandlerFunc(reports.APIReport)))).Methods("GET")
+	router.Handle("/api/reports/key", srv.apiMiddleware(srv.compressed(http.HandlerFunc(reports.APIKey)))).Methods("GET")
+	router.Handle("/api/reports/keypairs", srv.apiMiddleware(srv.compressed(http.HandlerFunc(reports.APIAttestation)))).Methods("GET")
+	router.Handle("/api/manifests/account/{authorityID}", srv.apiMiddleware(srv.compressed(http.HandlerFunc(testLogs.APIListDeviceManifests)))).Methods("GET")
+	router.Handle("/api/devices/{authorityID}/{model}/{serial}/state", srv.apiMiddleware(http.HandlerFunc(devices.APIState))).Methods("GET")
+	router.Handle("/api/devices/{authorityID}/{model}/{serial}/state", srv.apiMiddleware(http.HandlerFunc(devices.APITransition))).Methods("POST")
+	router.Handle("/api/quarantine/{authorityID}", srv.apiMiddleware(srv.compressed(http.HandlerFunc(devices.APIQuarantine)))).Methods("GET")
+	router.Handle("/api/quarantine/{authorityID}", srv.apiMiddleware(http.HandlerFunc(devices.APIQuarantineDevices))).Methods("POST")
+	router.Handle("/api/quarantine/{authorityID}/import", srv.apiMiddleware(http.HandlerFunc(devices.APIQuarantineImport))).Methods("POST")
+	router.Handle("/api/quarantine/{authorityID}/{id:[0-9]+}", srv.apiMiddleware(http.HandlerFunc(devices.APIQuarantineRelease))).Methods("DELETE")
+	router.Handle("/api/keypairs", srv.apiMiddleware(srv.compressed(http.HandlerFunc(keypairs.APIList)))).Methods("GET")
+	router.Handle("/api/keypairs/registration", srv.apiMiddleware(http.HandlerFunc(keypairs.APIRegistration))).Methods("GET")
+	router.Handle("/api/keypairs/{id:[0-9]+}/models", srv.apiMiddleware(http.HandlerFunc(keypairs.APIModels))).Methods("GET")
+	router.Handle("/api/keypairs/{id:[0-9]+}/models", srv.apiMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.APIUpdateModels)))).Methods("PUT")
+	router.Handle("/api/keypairs/{id:[0-9]+}/compromise", srv.apiMiddleware(http.HandlerFunc(keypairs.APICompromiseReport))).Methods("GET")
+	router.Handle("/api/keypairs/{id:[0-9]+}/compromise", srv.apiMiddleware(srv.invalidates(invalidation.Keypair, http.HandlerFunc(keypairs.APICompromise)))).Methods("POST")
+	router.Handle("/api/delegations/{authorityID}", srv.apiMiddleware(http.HandlerFunc(keypairs.APIDelegations))).Methods("GET")
+	router.Handle("/api/delegations/{authorityID}", srv.apiMiddleware(http.HandlerFunc(keypairs.APICreateDelegation))).Methods("POST")
+	router.Handle("/api/delegations/{authorityID}/rootkey", srv.apiMiddleware(http.HandlerFunc(keypairs.APIUpdateRootKey))).Methods("PUT")
+	router.Handle("/api/delegations/{authorityID}/{id:[0-9]+}", srv.apiMiddleware(http.HandlerFunc(keypairs.APIDeleteDelegation))).Methods("DELETE")
+	router.Handle("/api/accounts/{id:[0-9]+}/stores", srv.apiMiddleware(http.HandlerFunc(substores.APIList))).Methods("GET")
+	router.Handle("/api/accounts/{id:[0-9]+}/stores/import", srv.apiMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.APIImport)))).Methods("POST")
+	router.Handle("/api/accounts/{id:[0-9]+}/stores/export", srv.apiMiddleware(http.HandlerFunc(substores.APIExport))).Methods("GET")
+	router.Handle("/api/accounts/stores/{id:[0-9]+}", srv.apiMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.APIUpdate)))).Methods("PUT")
+	router.Handle("/api/accounts/stores/{id:[0-9]+}", srv.apiMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.APIDelete)))).Methods("DELETE")
+	router.Handle("/api/accounts/stores", srv.apiMiddleware(srv.invalidates(invalidation.Substore, http.HandlerFunc(substores.APICreate)))).Methods("POST")
+	router.Handle("/api/models/{id:[0-9]+}/stations", srv.apiMiddleware(http.HandlerFunc(stations.APIList))).Methods("GET")
+	router.Handle("/api/models/stations/{id:[0-9]+}", srv.apiMiddleware(http.HandlerFunc(stations.APIDelete))).Methods("DELETE")
+	router.Handle("/api/models/stations", srv.apiMiddleware(http.HandlerFunc(stations.APICreate))).Methods("POST")
+	router.Handle("/api/assertions/checkserial", srv.apiMiddleware(http.HandlerFunc(assertions.APIValidateSerial))).Methods("POST")
+	router.Handle("/api/assertions", srv.apiMiddleware(http.HandlerFunc(assertions.APISystemUser))).Methods("POST")
+	router.Handle("/api/models/{id:[0-9]+}", srv.apiMiddleware(http.HandlerFunc(models.APIGet))).Methods("GET")
+	router.Handle("/api/models/{id:[0-9]+}", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APIUpdate)))).Methods("PUT")
+	router.Handle("/api/models/{id:[0-9]+}", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APIDelete)))).Methods("DELETE")
+	router.Handle("/api/models/{id:[0-9]+}/preview", srv.apiMiddleware(http.HandlerFunc(models.APIPreview))).Methods("GET")
+	router.Handle("/api/models/{id:[0-9]+}/fallback-keys", srv.apiMiddleware(http.HandlerFunc(models.APIFallbackKeys))).Methods("GET")
+	router.Handle("/api/models/{id:[0-9]+}/fallback-keys", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APIUpdateFallbackKeys)))).Methods("PUT")
+	router.Handle("/api/models/{id:[0-9]+}/canary", srv.apiMiddleware(http.HandlerFunc(models.APICanary))).Methods("GET")
+	router.Handle("/api/models/{id:[0-9]+}/canary", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APIUpdateCanary)))).Methods("PUT")
+	router.Handle("/api/models/{id:[0-9]+}/clone", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APIClone)))).Methods("POST")
+	router.Handle("/api/models/{id:[0-9]+}/keypairs/history", srv.apiMiddleware(http.HandlerFunc(models.APIKeypairHistory))).Methods("GET")
+	router.Handle("/api/models/keypairs/assign", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APIAssignKeypair)))).Methods("POST")
+	router.Handle("/api/models", srv.apiMiddleware(srv.invalidates(invalidation.Model, http.HandlerFunc(models.APICreate)))).Methods("POST")
+	router.Handle("/api/models/assertion", srv.apiMiddleware(http.HandlerFunc(models.APIAssertionHeaders))).Methods("POST")
+	router.Handle("/api/approvals", srv.apiMiddleware(srv.compressed(http.HandlerFunc(approvals.APIList)))).Methods("GET")
+	router.Handle("/api/approvals/{id:[0-9]+}/approve", srv.apiMiddleware(http.HandlerFunc(approvals.APIApprove))).Methods("POST")
+	router.Handle("/api/approvals/{id:[0-9]+}/reject", srv.apiMiddleware(http.HandlerFunc(approvals.APIReject))).Methods("POST")
+	router.Handle("/api/billing", srv.apiMiddleware(http.HandlerFunc(billings.APIList))).Methods("GET")
+	router.Handle("/api/billing/{period:[0-9]{4}-[0-9]{2}}", srv.apiMiddleware(srv.compressed(http.HandlerFunc(billings.APIPeriod)))).Methods("GET")
+	router.Handle("/api/billing/{period:[0-9]{4}-[0-9]{2}}/close", srv.apiMiddleware(http.HandlerFunc(billings.APIClose))).Methods("POST")
+	router.Handle("/api/onboardings", srv.apiMiddleware(srv.compressed(http.HandlerFunc(onboardings.APIList)))).Methods("GET")
+	router.Handle("/api/onboardings/{id:[0-9]+}/approve", srv.apiMiddleware(http.HandlerFunc(onboardings.APIApprove))).Methods("POST")
+	router.Handle("/api/onboardings/{id:[0-9]+}/reject", srv.apiMiddleware(http.HandlerFunc(onboardings.APIReject))).Methods("POST")
+	router.Handle("/api/auditlog", srv.apiMiddleware(srv.compressed(http.HandlerFunc(impersonations.APIList)))).Methods("GET")
+	router.Handle("/api/settings", srv.apiMiddleware(srv.compressed(http.HandlerFunc(settings.APIList)))).Methods("GET")
+	router.Handle("/api/settings/{namespace}/{name}", srv.apiMiddleware(http.HandlerFunc(settings.APIUpdate))).Methods("PUT")
+	router.Handle("/api/settings/{namespace}/{name}/history", srv.apiMiddleware(http.HandlerFunc(settings.APIHistory))).Methods("GET")
+	router.Handle("/api/instances", srv.apiMiddleware(srv.compressed(http.HandlerFunc(instances.APIList)))).Methods("GET")
+	router.Handle("/api/instances/heartbeat", srv.apiMiddleware(http.HandlerFunc(instances.APIHeartbeat))).Methods("POST")
+	router.Handle("/api/instances/checkins", srv.apiMiddleware(srv.compressed(http.HandlerFunc(instances.APICheckIns)))).Methods("GET")
+	router.Handle("/api/instances/checkin", srv.apiMiddleware(http.HandlerFunc(instances.APICheckIn))).Methods("POST")
+	router.Handle("/api/events", srv.apiMiddleware(http.HandlerFunc(liveEvents.APIStream))).Methods("GET")
+	router.Handle("/api/debug/stats", srv.apiMiddleware(http.HandlerFunc(statistics.APIStats))).Methods("GET")
+	router.Handle("/api/debug/keystore", srv.apiMiddleware(http.HandlerFunc(statistics.APIKeystore))).Methods("GET")
+	router.Handle("/api/debug/selfcheck", srv.apiMiddleware(http.HandlerFunc(statistics.APISelfCheck))).Methods("GET")
+
+	// Partner API routes: using a share token of the brand
+	router.Handle("/api/signinglog/shared", srv.middleware(srv.compressed(http.HandlerFunc(signingLogs.APIShared)))).Methods("GET")
+
+	// Onboarding API routes: for the applicants, using the onboarding token of the brand
+	router.Handle("/api/onboarding", srv.middleware(http.HandlerFunc(onboardings.APISubmit))).Methods("POST")
+	router.Handle("/api/onboarding/status", srv.middleware(http.HandlerFunc(onboardings.APIStatus))).Methods("GET")
 
 	// Sync API routes
-	router.Handle("/api/accounts", Middleware(http.HandlerFunc(account.APIList))).Methods("GET")
-	router.Handle("/api/keypairs/sync", Middleware(http.HandlerFunc(keypair.APISyncKeypairs))).Methods("POST")
-	router.Handle("/api/models", Middleware(http.HandlerFunc(model.APIList))).Methods("GET")
-	router.Handle("/api/signinglog", Middleware(http.HandlerFunc(signinglog.APISyncLog))).Methods("POST")
-	router.Handle("/api/testlog", Middleware(http.HandlerFunc(testlog.APIListLog))).Methods("GET")
-	router.Handle("/api/testlog", Middleware(http.HandlerFunc(testlog.APISyncLog))).Methods("POST")
-	router.Handle("/api/testlog/{id:[0-9]+}", Middleware(http.HandlerFunc(testlog.APISyncUpdateLog))).Methods("PUT")
+	router.Handle("/api/accounts", srv.syncMiddleware(srv.compressed(http.HandlerFunc(accounts.APIList)))).Methods("GET")
+	router.Handle("/api/keypairs/sync", srv.syncMiddleware(http.HandlerFunc(keypairs.APISyncKeypairs))).Methods("POST")
+	router.Handle("/api/syncmodels", srv.syncMiddleware(srv.compressed(http.HandlerFunc(users.APISyncModels)))).Methods("GET")
+	router.Handle("/api/quarantine", srv.syncMiddleware(srv.compressed(http.HandlerFunc(devices.APIQuarantine)))).Methods("GET")
+	router.Handle("/api/delegations", srv.syncMiddleware(srv.compressed(http.HandlerFunc(keypairs.APISyncDelegations)))).Methods("GET")
+	router.Handle("/api/models", srv.syncMiddleware(srv.compressed(http.HandlerFunc(models.APIList)))).Methods("GET")
+	router.Handle("/api/signinglog", srv.syncMiddleware(http.HandlerFunc(signingLogs.APISyncLog))).Methods("POST")
+	router.Handle("/api/testlog", srv.syncMiddleware(srv.compressed(http.HandlerFunc(testLogs.APIListLog)))).Methods("GET")
+	router.Handle("/api/testlog", srv.syncMiddleware(http.HandlerFunc(testLogs.APISyncLog))).Methods("POST")
+	router.Handle("/api/testlog/{id:[0-9]+}", srv.syncMiddleware(http.HandlerFunc(testLogs.APISyncUpdateLog))).Methods("PUT")
+	router.Handle("/api/manifests", srv.syncMiddleware(http.HandlerFunc(testLogs.APIDeviceManifest))).Methods("POST")
 
 	return router
 }
@@ -0,0 +1,220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package selfcheck
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Status of a check, and of the report as the worst status of its checks
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusError   = "error"
+)
+
+// challenge is signed by each active signing-key, to test that the keystore unseals it
+const challenge = "serial-vault-self-check"
+
+// Check is the result of one check of the self-check
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Report is the result of the self-check of the vault
+type Report struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Checks []Check   `json:"checks"`
+}
+
+var (
+	lock sync.RWMutex
+	last Report
+)
+
+// Last returns the report of the latest self-check, which is empty before the self-check has run
+func Last() Report {
+	lock.RLock()
+	defer lock.RUnlock()
+	return last
+}
+
+// Run checks the database, the schema version, the signing-keys of the models and the keystore,
+// so a misconfiguration is found at startup instead of by the devices that fail to get a serial.
+// The checks are logged and the report is kept for the admin service
+func Run(ctx context.Context, env *datastore.Env) Report {
+	report := Report{Time: time.Now().UTC(), Status: StatusOK, Checks: []Check{}}
+
+	report.add(checkDatabase(env.DB))
+	if report.Status == StatusOK {
+		report.add(checkSchema(env.DB))
+		report.add(checkModels(env.DB, report.Time)...)
+		report.add(checkKeystore(ctx, env)...)
+	}
+
+	for _, c := range report.Checks {
+		switch c.Status {
+		case StatusError:
+			svlog.Errorf("Self-check %s: %s", c.Name, c.Message)
+		case StatusWarning:
+			svlog.Warningf("Self-check %s: %s", c.Name, c.Message)
+		}
+	}
+	svlog.Infof("Self-check completed with status %s: %d checks", report.Status, len(report.Checks))
+
+	lock.Lock()
+	last = report
+	lock.Unlock()
+	return report
+}
+
+// add records the checks, keeping the worst status for the report
+func (r *Report) add(checks ...Check) {
+	for _, c := range checks {
+		if severity(c.Status) > severity(r.Status) {
+			r.Status = c.Status
+		}
+		r.Checks = append(r.Checks, c)
+	}
+}
+
+func severity(status string) int {
+	switch status {
+	case StatusError:
+		return 2
+	case StatusWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// checkDatabase checks that the database is reachable
+func checkDatabase(db datastore.Datastore) Check {
+	if err := db.HealthCheck(); err != nil {
+		return Check{"database", StatusError, fmt.Sprintf("The database cannot be reached: %v", err)}
+	}
+	return Check{"database", StatusOK, "The database is reachable"}
+}
+
+// checkSchema compares the version of the database schema with the version of the release
+func checkSchema(db datastore.Datastore) Check {
+	version, err := db.GetSchemaVersion()
+	switch {
+	case err != nil:
+		return Check{"schema", StatusError, fmt.Sprintf("The schema version cannot be read: %v", err)}
+	case version < datastore.SchemaVersion:
+		return Check{"schema", StatusError, fmt.Sprintf("The database schema is at version %d, but this release needs version %d. Run `serial-vault-admin database` to update it", version, datastore.SchemaVersion)}
+	case version > datastore.SchemaVersion:
+		return Check{"schema", StatusWarning, fmt.Sprintf("The database schema is at version %d, which is newer than the version %d of this release", version, datastore.SchemaVersion)}
+	}
+	return Check{"schema", StatusOK, fmt.Sprintf("The database schema is at version %d", version)}
+}
+
+// checkModels checks that the models are linked with signing-keys that exist, are active and
+// have not expired
+func checkModels(db datastore.Datastore, now time.Time) []Check {
+	superuser := datastore.User{Role: datastore.Superuser}
+
+	missing, err := db.ListModelsMissingKeypairs()
+	if err != nil {
+		return []Check{{"models", StatusError, fmt.Sprintf("The models cannot be listed: %v", err)}}
+	}
+	models, err := db.ListAllowedModels(superuser)
+	if err != nil {
+		return []Check{{"models", StatusError, fmt.Sprintf("The models cannot be listed: %v", err)}}
+	}
+	keypairs, err := db.ListAllowedKeypairs(superuser)
+	if err != nil {
+		return []Check{{"models", StatusError, fmt.Sprintf("The signing-keys cannot be listed: %v", err)}}
+	}
+
+	byID := map[int]datastore.Keypair{}
+	for _, k := range keypairs {
+		byID[k.ID] = k
+	}
+
+	checks := []Check{}
+	for _, m := range missing {
+		checks = append(checks, Check{modelCheck(m), StatusError, fmt.Sprintf("The model is linked with signing-key %d and system-user key %d, and one of them does not exist", m.KeypairID, m.KeypairIDUser)})
+	}
+	for _, m := range models {
+		keypair, ok := byID[m.KeypairID]
+		if !ok {
+			continue
+		}
+		if !keypair.Active {
+			checks = append(checks, Check{modelCheck(m), StatusWarning, fmt.Sprintf("The model is linked with the inactive signing-key %s", keypair.KeyID)})
+			continue
+		}
+		if until := datastore.KeypairExpiry(keypair); until != nil && until.Before(now) {
+			checks = append(checks, Check{modelCheck(m), StatusError, fmt.Sprintf("The model is linked with the signing-key %s, which expired on %s", keypair.KeyID, until.Format(time.RFC3339))})
+		}
+	}
+
+	if len(checks) == 0 {
+		return []Check{{"models", StatusOK, fmt.Sprintf("The %d models are linked with active signing-keys", len(models))}}
+	}
+	return checks
+}
+
+func modelCheck(m datastore.Model) string {
+	return fmt.Sprintf("model %s/%s", m.BrandID, m.Name)
+}
+
+// checkKeystore test-signs with each active signing-key, which unseals it in the keystore
+func checkKeystore(ctx context.Context, env *datastore.Env) []Check {
+	if env.KeypairDB == nil {
+		return []Check{{"keystore", StatusError, "The keystore is not open"}}
+	}
+
+	keypairs, err := env.DB.ListAllowedKeypairs(datastore.User{Role: datastore.Superuser})
+	if err != nil {
+		return []Check{{"keystore", StatusError, fmt.Sprintf("The signing-keys cannot be listed: %v", err)}}
+	}
+
+	checks := []Check{}
+	signed := 0
+	for _, k := range keypairs {
+		if !k.Active {
+			continue
+		}
+		attestation := env.KeypairDB.AttestKeypair(ctx, k, challenge)
+		if len(attestation.ProofError) > 0 {
+			checks = append(checks, Check{fmt.Sprintf("keystore %s/%s", k.AuthorityID, k.KeyID), StatusError, fmt.Sprintf("The signing-key cannot sign: %s", attestation.ProofError)})
+			continue
+		}
+		signed++
+	}
+
+	if len(checks) == 0 {
+		return []Check{{"keystore", StatusOK, fmt.Sprintf("The %d active signing-keys signed a test assertion", signed)}}
+	}
+	return checks
+}
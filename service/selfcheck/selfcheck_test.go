@@ -0,0 +1,84 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package selfcheck
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+)
+
+func TestCheckModels(t *testing.T) {
+	db := datastoretest.New()
+	active := db.AddKeypair(datastoretest.NewKeypair("system", "activekey").Build())
+	inactive := db.AddKeypair(datastoretest.NewKeypair("system", "inactivekey").Inactive().Build())
+	db.AddModel(datastoretest.NewModel("system", "alder").WithKeypair(active).Build())
+	db.AddModel(datastoretest.NewModel("system", "birch").WithKeypair(inactive).Build())
+	db.AddModel(datastoretest.NewModel("system", "cedar").WithKeypair(datastore.Keypair{ID: 999}).Build())
+
+	expected := map[string]string{
+		"model system/birch": StatusWarning,
+		"model system/cedar": StatusError,
+	}
+
+	checks := checkModels(db, time.Now())
+	if len(checks) != len(expected) {
+		t.Fatalf("checkModels: expected %d checks, got %v", len(expected), checks)
+	}
+	for _, c := range checks {
+		if expected[c.Name] != c.Status {
+			t.Errorf("checkModels: expected %s to be %q, got %q: %s", c.Name, expected[c.Name], c.Status, c.Message)
+		}
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		version int
+		status  string
+	}{
+		{0, StatusError},
+		{datastore.SchemaVersion, StatusOK},
+		{datastore.SchemaVersion + 1, StatusWarning},
+	}
+
+	for _, tt := range tests {
+		db := datastoretest.New()
+		if tt.version > 0 {
+			db.PutSchemaVersion(tt.version)
+		}
+		if c := checkSchema(db); c.Status != tt.status {
+			t.Errorf("checkSchema: expected %q for version %d, got %q: %s", tt.status, tt.version, c.Status, c.Message)
+		}
+	}
+}
+
+func TestRunUnreachableDatabase(t *testing.T) {
+	report := Run(context.Background(), &datastore.Env{DB: &datastore.ErrorMockDB{}})
+	if report.Status != StatusError || len(report.Checks) != 1 || report.Checks[0].Name != "database" {
+		t.Errorf("Run: expected only the failed database check, got %v", report)
+	}
+	if Last().Time != report.Time {
+		t.Error("Run: expected the report to be kept")
+	}
+}
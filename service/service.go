@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/backpressure"
+	"github.com/CanonicalLtd/serial-vault/service/breaker"
+	"github.com/CanonicalLtd/serial-vault/service/failover"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	logging "github.com/op/go-logging"
+)
+
+// Service is the web service. The handlers are given its dependencies, rather than
+// reading the global environment, so services with different configurations can run
+// in the same process
+type Service struct {
+	Env    *datastore.Env
+	Logger *logging.Logger
+}
+
+// NewService creates the web service for the environment. The signing queue, the circuit
+// breaker of the datastore and the failover election are shared by the process, and take
+// their limits from the config of the environment
+func NewService(env *datastore.Env) *Service {
+	backpressure.Signing.SetLimits(backpressure.ConfigLimits(&env.Config))
+	breaker.Datastore.SetLimits(breaker.ConfigLimits(&env.Config))
+	failover.Signing.SetEnabled(failover.ConfigEnabled(&env.Config))
+	return &Service{Env: env, Logger: svlog.Logger()}
+}
+
+// logRequest logs a web service request
+func (srv *Service) logRequest(start time.Time, r *http.Request) {
+	srv.Logger.Infof(
+		"%s\t%s\t%s",
+		r.Method,
+		r.RequestURI,
+		time.Since(start),
+	)
+}
+
+// middleware pre-processes the web service requests, translating the messages of the error responses
+func (srv *Service) middleware(inner http.Handler) http.Handler {
+	return middleware(srv.debugged(localized(inner)), srv.logRequest)
+}
+
+// middlewareWithCSRF pre-processes the web service requests with CSRF protection. The requests
+// of a superuser acting as a brand admin are audited
+func (srv *Service) middlewareWithCSRF(inner http.Handler) http.Handler {
+	return CSRFProtection(srv.middleware(srv.audited(inner)), srv.Env.Config.CSRFAuthKey)
+}
+
+// compressed compresses the responses of the handler, when it is enabled in the config. It is
+// used for the list methods, as their responses can be large. The messages of the error responses
+// are translated before they are compressed
+func (srv *Service) compressed(inner http.Handler) http.Handler {
+	if !srv.Env.Config.ResponseCompression {
+		return inner
+	}
+	return Compress(localized(inner))
+}
+
+// HTTPServer creates the HTTP server of the service. HTTP/2 is served when the service
+// terminates TLS, unless it is disabled in the config
+func (srv *Service) HTTPServer(address string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: address, Handler: handler}
+	if srv.Env.Config.DisableHTTP2 {
+		// A non-nil map disables the automatic HTTP/2 support of the server
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return server
+}
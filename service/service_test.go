@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/core"
+	check "gopkg.in/check.v1"
+)
+
+type ServiceSuite struct{}
+
+var _ = check.Suite(&ServiceSuite{})
+
+func (s *ServiceSuite) TestServicesWithDifferentConfig(c *check.C) {
+	first := NewService(&datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{Version: "1.0"}})
+	second := NewService(&datastore.Env{DB: &datastore.ErrorMockDB{}, Config: config.Settings{Version: "2.0"}})
+
+	for _, t := range []struct {
+		srv     *Service
+		version string
+		status  int
+	}{
+		{first, "1.0", http.StatusOK},
+		{second, "2.0", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/v1/version", nil)
+		t.srv.SigningRouter().ServeHTTP(w, r)
+
+		result := core.VersionResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Version, check.Equals, t.version)
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "/v1/health", nil)
+		t.srv.SigningRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.status)
+	}
+}
@@ -0,0 +1,210 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/approval"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Setting is a config setting with its definition. The default value is used when it has not been set
+type Setting struct {
+	datastore.ConfigSettingDefinition
+	Value      string     `json:"value"`
+	IsDefault  bool       `json:"is-default"`
+	Modified   *time.Time `json:"modified,omitempty"`
+	ModifiedBy string     `json:"modified-by,omitempty"`
+}
+
+// ListResponse is the JSON response from the API config settings method
+type ListResponse struct {
+	Success      bool      `json:"success"`
+	ErrorCode    string    `json:"error_code"`
+	ErrorSubcode string    `json:"error_subcode"`
+	ErrorMessage string    `json:"message"`
+	Settings     []Setting `json:"settings"`
+}
+
+// UpdateRequest is the JSON request to change the value of a config setting
+type UpdateRequest struct {
+	Value string `json:"value"`
+}
+
+// HistoryResponse is the JSON response from the API config setting history method
+type HistoryResponse struct {
+	Success      bool                            `json:"success"`
+	ErrorCode    string                          `json:"error_code"`
+	ErrorSubcode string                          `json:"error_subcode"`
+	ErrorMessage string                          `json:"message"`
+	Changes      []datastore.ConfigSettingChange `json:"changes"`
+}
+
+// listHandler is the API method to fetch the config settings, with their defaults
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	stored, err := srv.DB.ListConfigSettings()
+	if err != nil {
+		response.FormatStandardResponse(false, "error-settings-json", "", err.Error(), w)
+		return
+	}
+
+	settings := []Setting{}
+	for _, d := range datastore.ConfigSettingDefinitions() {
+		s := Setting{ConfigSettingDefinition: d, Value: d.Default, IsDefault: true}
+		for _, v := range stored {
+			if v.Namespace == d.Namespace && v.Name == d.Name {
+				modified := v.Modified
+				s.Value, s.IsDefault, s.Modified, s.ModifiedBy = v.Value, false, &modified, v.ModifiedBy
+			}
+		}
+		settings = append(settings, s)
+	}
+
+	// Return successful JSON response with the list of settings
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(settings, w)
+}
+
+// updateHandler is the API method to change the value of a config setting
+func (srv *Service) updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, namespace, name string, req UpdateRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	setting := datastore.ConfigSetting{Namespace: namespace, Name: name, Value: req.Value, ModifiedBy: user.Username}
+	if err := datastore.ValidateConfigSetting(setting); err != nil {
+		response.FormatStandardResponse(false, "error-validate-setting", "", err.Error(), w)
+		return
+	}
+
+	// Raising a limit may need the approval of a second admin
+	if _, ok := approval.Required(srv.Config, datastore.ApprovalQuotaRaise); ok {
+		raised, err := srv.raisesLimit(setting)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-settings-json", "", err.Error(), w)
+			return
+		}
+		if raised && !approval.Approved(w, srv.Env, user, datastore.ApprovalQuotaRaise, "", fmt.Sprintf("setting/%s/%s", namespace, name), req.Value) {
+			return
+		}
+	}
+
+	err = srv.DB.PutConfigSetting(setting)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-updating-setting", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// historyHandler is the API method to fetch the changes of a config setting
+func (srv *Service) historyHandler(w http.ResponseWriter, user datastore.User, apiCall bool, namespace, name string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// Only the understood settings have a history
+	if _, err := datastore.FindConfigSettingDefinition(namespace, name); err != nil {
+		response.FormatStandardResponse(false, "error-validate-setting", "", err.Error(), w)
+		return
+	}
+
+	changes, err := srv.DB.ListConfigSettingHistory(namespace, name)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-settings-json", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatHistoryResponse(changes, w)
+}
+
+// raisesLimit checks if the setting raises the current value of an integer setting
+func (srv *Service) raisesLimit(setting datastore.ConfigSetting) (bool, error) {
+	definition, err := datastore.FindConfigSettingDefinition(setting.Namespace, setting.Name)
+	if err != nil || definition.Type != datastore.SettingTypeInt {
+		return false, nil
+	}
+
+	stored, err := srv.DB.ListConfigSettings()
+	if err != nil {
+		return false, err
+	}
+
+	current := definition.Default
+	for _, v := range stored {
+		if v.Namespace == setting.Namespace && v.Name == setting.Name {
+			current = v.Value
+		}
+	}
+
+	value, _ := strconv.Atoi(setting.Value)
+	currentValue, _ := strconv.Atoi(current)
+	return value > currentValue, nil
+}
+
+func formatListResponse(settings []Setting, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Settings: settings}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the settings response.")
+		return err
+	}
+	return nil
+}
+
+func formatHistoryResponse(changes []datastore.ConfigSettingChange, w http.ResponseWriter) error {
+	response := HistoryResponse{Success: true, Changes: changes}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the setting history response.")
+		return err
+	}
+	return nil
+}
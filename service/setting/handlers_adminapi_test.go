@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package setting_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/setting"
+	check "gopkg.in/check.v1"
+)
+
+func TestSettingSuite(t *testing.T) { check.TestingT(t) }
+
+type SettingSuite struct {
+	db *datastoretest.DB
+}
+
+var _ = check.Suite(&SettingSuite{})
+
+func (s *SettingSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin})
+
+	// The keypair auth entries are kept in the settings table
+	s.db.PutSetting(datastore.Setting{Code: "system/key-id", Data: "secret-auth-key"})
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+}
+
+func (s *SettingSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func sendAdminAPIRequest(method, url string, data io.Reader, username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func (s *SettingSuite) listSettings(c *check.C) map[string]setting.Setting {
+	w := sendAdminAPIRequest("GET", "/api/settings", nil, "root")
+	c.Assert(w.Code, check.Equals, 200)
+
+	result := setting.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+
+	settings := map[string]setting.Setting{}
+	for _, st := range result.Settings {
+		settings[st.Namespace+"/"+st.Name] = st
+	}
+	return settings
+}
+
+func (s *SettingSuite) TestAPIUpdateHandler(c *check.C) {
+	tests := []struct {
+		URL      string
+		Value    string
+		Username string
+		Success  bool
+	}{
+		{"/api/settings/sync/parallelism", "8", "root", true},
+		{"/api/settings/sync/parallelism", "12", "root", true},
+		{"/api/settings/sync/parallelism", "12", "sv", false},
+		{"/api/settings/sync/parallelism", "12", "", false},
+		{"/api/settings/sync/parallelism", "100", "root", false},
+		{"/api/settings/sync/parallelism", "many", "root", false},
+		{"/api/settings/ui/read-only", "maybe", "root", false},
+		{"/api/settings/ui/read-only", "true", "root", true},
+		{"/api/settings/system/key-id", "stolen", "root", false},
+	}
+
+	for _, t := range tests {
+		data, _ := json.Marshal(setting.UpdateRequest{Value: t.Value})
+		w := sendAdminAPIRequest("PUT", t.URL, bytes.NewReader(data), t.Username)
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+
+	settings := s.listSettings(c)
+	c.Assert(settings["sync/parallelism"].Value, check.Equals, "12")
+	c.Assert(settings["sync/parallelism"].IsDefault, check.Equals, false)
+	c.Assert(settings["sync/parallelism"].ModifiedBy, check.Equals, "root")
+	c.Assert(settings["ui/read-only"].Value, check.Equals, "true")
+	c.Assert(settings["signing/nonce-rate-limit"].Value, check.Equals, "600")
+	c.Assert(settings["signing/nonce-rate-limit"].IsDefault, check.Equals, true)
+
+	// The keypair auth entries are neither listed nor changed
+	_, ok := settings["system/key-id"]
+	c.Assert(ok, check.Equals, false)
+	auth, err := s.db.GetSetting("system/key-id")
+	c.Assert(err, check.IsNil)
+	c.Assert(auth.Data, check.Equals, "secret-auth-key")
+}
+
+func (s *SettingSuite) TestAPIHistoryHandler(c *check.C) {
+	for _, v := range []string{"8", "12"} {
+		data, _ := json.Marshal(setting.UpdateRequest{Value: v})
+		sendAdminAPIRequest("PUT", "/api/settings/sync/parallelism", bytes.NewReader(data), "root")
+	}
+
+	w := sendAdminAPIRequest("GET", "/api/settings/sync/parallelism/history", nil, "root")
+	c.Assert(w.Code, check.Equals, 200)
+	result := setting.HistoryResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Changes, check.HasLen, 2)
+	c.Assert(result.Changes[0].OldValue, check.Equals, "8")
+	c.Assert(result.Changes[0].NewValue, check.Equals, "12")
+	c.Assert(result.Changes[1].OldValue, check.Equals, "")
+	c.Assert(result.Changes[1].ChangedBy, check.Equals, "root")
+
+	tests := []struct {
+		URL      string
+		Username string
+		Code     int
+	}{
+		{"/api/settings/sync/parallelism/history", "sv", 400},
+		{"/api/settings/system/key-id/history", "root", 400},
+	}
+	for _, t := range tests {
+		w := sendAdminAPIRequest("GET", t.URL, nil, t.Username)
+		c.Assert(w.Code, check.Equals, t.Code)
+	}
+}
+
+func (s *SettingSuite) TestAPIListHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = false
+
+	w := sendAdminAPIRequest("GET", "/api/settings", nil, "")
+	c.Assert(w.Code, check.Equals, 400)
+}
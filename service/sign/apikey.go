@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
+)
+
+// maxAPIKeyModels limits the number of models that are verified in one request
+const maxAPIKeyModels = 100
+
+// APIKeyModel is a model that a provisioning tool signs the devices of, with the serial number
+// of a pivoted device to check its sub-store model
+type APIKeyModel struct {
+	BrandID      string `json:"brand-id"`
+	Name         string `json:"model"`
+	SerialNumber string `json:"serial,omitempty"`
+}
+
+// APIKeyRequest is the JSON request to verify the API key for a list of models
+type APIKeyRequest struct {
+	Models []APIKeyModel `json:"models"`
+}
+
+// APIKeyResult is the authorization of the API key for a model. A model of another API key
+// cannot be told apart from a model that does not exist, so the other models are not leaked
+type APIKeyResult struct {
+	APIKeyModel
+	Authorized bool   `json:"authorized"`
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// APIKeyResponse is the JSON response from the API key verification method
+type APIKeyResponse struct {
+	Success      bool           `json:"success"`
+	ErrorMessage string         `json:"message"`
+	Models       []APIKeyResult `json:"models"`
+}
+
+// VerifyAPIKey is the API method for a provisioning tool to verify upfront that its API key
+// can sign the devices of the models, before the first device of the line requests its serial
+func (srv *Service) VerifyAPIKey(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	// Check that we have an authorised API key header
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
+	if err != nil {
+		log.Message("APIKEY", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
+		return response.ErrorInvalidAPIKey
+	}
+
+	verify := APIKeyRequest{}
+	err = json.NewDecoder(r.Body).Decode(&verify)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		log.Message("APIKEY", response.ErrorNilData.Code, response.ErrorNilData.Message)
+		return response.ErrorNilData
+		// Check for parsing errors
+	case err != nil:
+		log.Message("APIKEY", response.ErrorDecodeJSON.Code, err.Error())
+		return response.ErrorDecodeJSON
+	}
+
+	if len(verify.Models) < 1 || len(verify.Models) > maxAPIKeyModels {
+		log.Message("APIKEY", response.ErrorInvalidModelCount.Code, response.ErrorInvalidModelCount.Message)
+		return response.ErrorInvalidModelCount
+	}
+
+	if !datastoreAvailable(w) {
+		return response.ErrorDatastoreUnavailable
+	}
+
+	ctx, cancel := request.DatastoreContext(r, srv.Config)
+	defer cancel()
+	db := srv.DB.WithContext(ctx)
+
+	results := []APIKeyResult{}
+	for _, m := range verify.Models {
+		results = append(results, verifyAPIKeyModel(db, m, apiKey))
+	}
+
+	// Encode the response as JSON
+	resp := APIKeyResponse{Success: true, Models: results}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Message("APIKEY", "error-form-apikey", err.Error())
+	}
+	return response.ErrorResponse{Success: true}
+}
+
+// verifyAPIKeyModel checks that the API key can sign the devices of a model, as a serial request
+// does, and that the signing-key of the model is active
+func verifyAPIKeyModel(db datastore.Datastore, m APIKeyModel, apiKey string) APIKeyResult {
+	result := APIKeyResult{APIKeyModel: m}
+
+	resolution, err := validation.ResolveDevice(db, m.BrandID, m.Name, m.SerialNumber, apiKey)
+	switch {
+	case err == validation.ErrPivotTargetNotFound:
+		return apiKeyFailure(result, response.ErrorPivotTargetNotFound)
+	case err == validation.ErrCrossAuthorityPivot:
+		return apiKeyFailure(result, response.ErrorCrossAuthorityPivot)
+	case err != nil:
+		// The model of another API key, of a sub-store of another API key, and a model that
+		// does not exist are all unauthorized
+		return apiKeyFailure(result, response.ErrorInvalidAPIKey)
+	}
+
+	if !resolution.Model.KeyActive {
+		if _, err := db.GetKeypairCompromise(resolution.Model.KeypairID); err == nil {
+			return apiKeyFailure(result, response.ErrorCompromisedModel)
+		}
+		return apiKeyFailure(result, response.ErrorInactiveModel)
+	}
+
+	result.Authorized = true
+	return result
+}
+
+// apiKeyFailure sets the reason that the devices of the model cannot be signed
+func apiKeyFailure(result APIKeyResult, e response.ErrorResponse) APIKeyResult {
+	result.Code = e.Code
+	result.Message = e.Message
+	return result
+}
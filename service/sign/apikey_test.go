@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+func apiKeyDB() *datastoretest.DB {
+	db := datastoretest.New()
+	db.AddAccount(datastore.Account{AuthorityID: "system"})
+	key := db.AddKeypair(datastoretest.NewKeypair("system", "systemkey").Build())
+	inactive := db.AddKeypair(datastoretest.NewKeypair("system", "inactivekey").Inactive().Build())
+	db.AddModel(datastoretest.NewModel("system", "alder").WithKeypair(key).WithAPIKey("line-1").Build())
+	db.AddModel(datastoretest.NewModel("system", "birch").WithKeypair(inactive).WithAPIKey("line-1").Build())
+	db.AddModel(datastoretest.NewModel("system", "cedar").WithKeypair(key).WithAPIKey("line-2").Build())
+	return db
+}
+
+func verifyAPIKey(db datastore.Datastore, apiKey string, body []byte) (*httptest.ResponseRecorder, response.ErrorResponse) {
+	srv := &Service{&datastore.Env{DB: db}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/apikey/verify", bytes.NewReader(body))
+	r.Header.Set("api-key", apiKey)
+
+	return w, srv.VerifyAPIKey(w, r)
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	db := apiKeyDB()
+	body := []byte(`{"models": [
+		{"brand-id": "system", "model": "alder"},
+		{"brand-id": "system", "model": "birch"},
+		{"brand-id": "system", "model": "cedar"},
+		{"brand-id": "system", "model": "unknown"}
+	]}`)
+
+	w, result := verifyAPIKey(db, "line-1", body)
+	if !result.Success || w.Code != http.StatusOK {
+		t.Fatalf("VerifyAPIKey: expected success, got %d: %s", w.Code, result.Message)
+	}
+	resp := APIKeyResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("VerifyAPIKey: invalid response: %v", err)
+	}
+
+	expected := []struct {
+		model      string
+		authorized bool
+		code       string
+	}{
+		{"alder", true, ""},
+		{"birch", false, response.ErrorInactiveModel.Code},
+		{"cedar", false, response.ErrorInvalidAPIKey.Code},
+		{"unknown", false, response.ErrorInvalidAPIKey.Code},
+	}
+	if len(resp.Models) != len(expected) {
+		t.Fatalf("VerifyAPIKey: expected %d results, got %d", len(expected), len(resp.Models))
+	}
+	for i, e := range expected {
+		m := resp.Models[i]
+		if m.Name != e.model || m.Authorized != e.authorized || m.Code != e.code {
+			t.Errorf("VerifyAPIKey: expected %s authorized=%v code=%q, got %s authorized=%v code=%q", e.model, e.authorized, e.code, m.Name, m.Authorized, m.Code)
+		}
+	}
+
+	// The model of another API key is reported as a model that does not exist
+	if resp.Models[2].Message != resp.Models[3].Message {
+		t.Errorf("VerifyAPIKey: expected the same message for the model of another API key, got %q and %q", resp.Models[2].Message, resp.Models[3].Message)
+	}
+}
+
+func TestVerifyAPIKeyInvalid(t *testing.T) {
+	db := apiKeyDB()
+	tests := []struct {
+		apiKey string
+		body   string
+		code   string
+	}{
+		{"unknown", `{"models": [{"brand-id": "system", "model": "alder"}]}`, response.ErrorInvalidAPIKey.Code},
+		{"line-1", `{"models": []}`, response.ErrorInvalidModelCount.Code},
+		{"line-1", `invalid`, response.ErrorDecodeJSON.Code},
+		{"line-1", ``, response.ErrorNilData.Code},
+	}
+
+	for _, tt := range tests {
+		_, result := verifyAPIKey(db, tt.apiKey, []byte(tt.body))
+		if result.Success || result.Code != tt.code || result.StatusCode != http.StatusBadRequest {
+			t.Errorf("VerifyAPIKey: expected code %q for %q, got %q (%d)", tt.code, tt.body, result.Code, result.StatusCode)
+		}
+	}
+}
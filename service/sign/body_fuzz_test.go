@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// FuzzRequestBody checks that the attacker-controlled YAML body of a serial-request is
+// handled without panics, and that the manufacture date stays within its bounds
+func FuzzRequestBody(f *testing.F) {
+	seeds := []string{
+		"",
+		"serial: A123456L\n",
+		"serial: 123456\n",
+		"serial: [A1, A2]\n",
+		"serial: {number: A1}\n",
+		"station: line-1\nmanufacture-date: 2018-06-01\n",
+		"manufacture-date: \"2018-06-15T12:00:00Z\"\n",
+		"manufacture-date: 1528977600\n",
+		"manufacture-date: [2018-06-01]\n",
+		"cpu: 4\nram: 2.5\nwifi: true\n",
+		"a: &a [x, x]\nb: &b [*a, *a]\nc: [*b, *b]\n",
+		"- serial\n- A123456L\n",
+		"serial: !!binary QTEyMzQ1Nkw=\n",
+		":\n\t- :",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	srv := &Service{Env: &datastore.Env{Config: config.Settings{SigningLogBodyFields: []string{"serial", "station", "cpu", "ram", "wifi"}}}}
+
+	policy := datastore.TimestampPolicy{ManufactureDate: true, MaxAge: 30, MaxFuture: 10}
+	r := httptest.NewRequest("POST", "/v1/serial", nil)
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		body := decodeRequestBody(content)
+		requestStation(r, body)
+		srv.requestDetails(body)
+
+		now := time.Now()
+		timestamp, err := serialTimestamp(policy, body, now)
+		if err != nil && !timestamp.Equal(now) {
+			t.Errorf("expected the signing time for an invalid manufacture date, got %v", timestamp)
+		}
+		if timestamp.Before(now.AddDate(0, 0, -policy.MaxAge)) || timestamp.After(now.Add(time.Duration(policy.MaxFuture)*time.Minute)) {
+			t.Errorf("the timestamp %v is out of bounds", timestamp)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// deviceKeyFormat is the prefix of an encoded device-key, which is followed by the
+// base64 encoding of the OpenPGP public key packet
+const deviceKeyFormat = "openpgp "
+
+// deviceKey describes the public key of a device
+type deviceKey struct {
+	Type  string
+	Bits  int
+	Curve string // of an ECDSA key
+}
+
+// parseDeviceKey decodes the device-key header of a serial-request
+func parseDeviceKey(encoded string) (deviceKey, error) {
+	if !strings.HasPrefix(encoded, deviceKeyFormat) {
+		return deviceKey{}, errors.New("The device-key must be an encoded openpgp public key")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, deviceKeyFormat))
+	if err != nil {
+		return deviceKey{}, fmt.Errorf("The device-key is not valid base64: %v", err)
+	}
+
+	r := bytes.NewReader(data)
+	p, err := packet.Read(r)
+	if err != nil {
+		return deviceKey{}, fmt.Errorf("The device-key is malformed: %v", err)
+	}
+	if r.Len() > 0 {
+		return deviceKey{}, errors.New("The device-key has data beyond the public key")
+	}
+	pubKey, ok := p.(*packet.PublicKey)
+	if !ok {
+		return deviceKey{}, errors.New("The device-key is not a public key")
+	}
+
+	switch k := pubKey.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if k.E < 3 || k.E%2 == 0 {
+			return deviceKey{}, errors.New("The device-key has an invalid RSA exponent")
+		}
+		return deviceKey{Type: datastore.DeviceKeyRSA, Bits: k.N.BitLen()}, nil
+	case *ecdsa.PublicKey:
+		return deviceKey{Type: datastore.DeviceKeyECDSA, Bits: k.Curve.Params().BitSize, Curve: k.Curve.Params().Name}, nil
+	default:
+		return deviceKey{}, fmt.Errorf("The device-key algorithm %d is not supported", pubKey.PubKeyAlgo)
+	}
+}
+
+// checkDeviceKey checks that the device-key is well-formed and accepted by the
+// device-key policy of the model
+func checkDeviceKey(policy datastore.DeviceKeyPolicy, encoded string) error {
+	key, err := parseDeviceKey(encoded)
+	if err != nil {
+		return err
+	}
+
+	if !policy.AllowsType(key.Type) {
+		return fmt.Errorf("The device-key type '%s' is not accepted for the model", key.Type)
+	}
+
+	switch key.Type {
+	case datastore.DeviceKeyRSA:
+		if key.Bits < policy.RSABits() {
+			return fmt.Errorf("The device-key has %d bits, but at least %d bits are required for the model", key.Bits, policy.RSABits())
+		}
+	case datastore.DeviceKeyECDSA:
+		if !policy.AllowsCurve(key.Curve) {
+			return fmt.Errorf("The device-key curve '%s' is not accepted for the model", key.Curve)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// encodeDeviceKey encodes the public key packet as in the device-key header
+func encodeDeviceKey(t *testing.T, pubKey *packet.PublicKey) string {
+	buf := &bytes.Buffer{}
+	if err := pubKey.Serialize(buf); err != nil {
+		t.Fatalf("Error serializing the public key: %v", err)
+	}
+	return deviceKeyFormat + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func rsaDeviceKey(t *testing.T, bits int) string {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("Error generating the RSA key: %v", err)
+	}
+	return encodeDeviceKey(t, packet.NewRSAPublicKey(time.Now(), &key.PublicKey))
+}
+
+func ecdsaDeviceKey(t *testing.T, curve elliptic.Curve) string {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating the ECDSA key: %v", err)
+	}
+	return encodeDeviceKey(t, packet.NewECDSAPublicKey(time.Now(), &key.PublicKey))
+}
+
+func TestParseDeviceKey(t *testing.T) {
+	rsa2048 := rsaDeviceKey(t, 2048)
+	p384 := ecdsaDeviceKey(t, elliptic.P384())
+
+	tests := []struct {
+		encoded string
+		key     deviceKey
+		valid   bool
+	}{
+		{rsa2048, deviceKey{Type: "rsa", Bits: 2048}, true},
+		{p384, deviceKey{Type: "ecdsa", Bits: 384, Curve: "P-384"}, true},
+		{"", deviceKey{}, false},
+		{strings.TrimPrefix(rsa2048, deviceKeyFormat), deviceKey{}, false},
+		{deviceKeyFormat + "not base64!", deviceKey{}, false},
+		{deviceKeyFormat + base64.StdEncoding.EncodeToString([]byte("not a packet")), deviceKey{}, false},
+		{rsa2048[:len(rsa2048)-40], deviceKey{}, false},
+		{rsa2048 + base64.StdEncoding.EncodeToString([]byte("trailing")), deviceKey{}, false},
+	}
+
+	for _, tt := range tests {
+		key, err := parseDeviceKey(tt.encoded)
+		if (err == nil) != tt.valid {
+			t.Errorf("Expected valid %v, got error %v", tt.valid, err)
+		}
+		if key != tt.key {
+			t.Errorf("Expected the device-key %+v, got %+v", tt.key, key)
+		}
+	}
+}
+
+func TestCheckDeviceKey(t *testing.T) {
+	rsa1024 := rsaDeviceKey(t, 1024)
+	rsa2048 := rsaDeviceKey(t, 2048)
+	p256 := ecdsaDeviceKey(t, elliptic.P256())
+
+	tests := []struct {
+		policy  datastore.DeviceKeyPolicy
+		encoded string
+		valid   bool
+	}{
+		{datastore.DeviceKeyPolicy{}, rsa2048, true},
+		{datastore.DeviceKeyPolicy{}, rsa1024, false},
+		{datastore.DeviceKeyPolicy{}, p256, false},
+		{datastore.DeviceKeyPolicy{}, "openpgp invalid", false},
+		{datastore.DeviceKeyPolicy{MinRSABits: 3072}, rsa2048, false},
+		{datastore.DeviceKeyPolicy{KeyTypes: []string{"rsa", "ecdsa"}}, p256, true},
+		{datastore.DeviceKeyPolicy{KeyTypes: []string{"rsa", "ecdsa"}}, rsa1024, false},
+		{datastore.DeviceKeyPolicy{KeyTypes: []string{"ecdsa"}}, rsa2048, false},
+		{datastore.DeviceKeyPolicy{KeyTypes: []string{"ecdsa"}, Curves: []string{"P-384"}}, p256, false},
+	}
+
+	for i, tt := range tests {
+		err := checkDeviceKey(tt.policy, tt.encoded)
+		if (err == nil) != tt.valid {
+			t.Errorf("%d: expected valid %v, got error %v", i, tt.valid, err)
+		}
+	}
+}
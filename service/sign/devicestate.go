@@ -0,0 +1,45 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// refusedDeviceState returns the lifecycle state of the device when the settings refuse to
+// sign its serial number again e.g. a scrapped device, or an empty state when it can be signed.
+// The state is only looked up when the settings refuse some states
+func refusedDeviceState(db datastore.Datastore, settings config.Settings, signingLog datastore.SigningLog) (string, error) {
+	if len(settings.RefuseSigningDeviceStates) == 0 {
+		return "", nil
+	}
+
+	state, err := db.GetDeviceState(signingLog.Make, signingLog.Model, signingLog.SerialNumber)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range settings.RefuseSigningDeviceStates {
+		if len(state) > 0 && s == state {
+			return state, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+)
+
+func TestRefusedDeviceState(t *testing.T) {
+	db := datastoretest.New()
+	db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+	db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A2").Build())
+	for _, s := range []string{datastore.DeviceStateShipped, datastore.DeviceStateScrapped} {
+		if _, err := db.TransitionAllowedDeviceState(datastore.DeviceState{Brand: "system", Model: "alder", SerialNumber: "A1", State: s}, datastore.User{}); err != nil {
+			t.Fatalf("error changing the device state: %v", err)
+		}
+	}
+
+	tests := []struct {
+		serial  string
+		refused []string
+		state   string
+	}{
+		{"A1", nil, ""},
+		{"A1", []string{datastore.DeviceStateScrapped}, datastore.DeviceStateScrapped},
+		{"A1", []string{datastore.DeviceStateRMA}, ""},
+		{"A2", []string{datastore.DeviceStateScrapped}, ""},
+		{"A3", []string{datastore.DeviceStateScrapped, datastore.DeviceStateRMA}, ""},
+	}
+
+	for _, tt := range tests {
+		settings := config.Settings{RefuseSigningDeviceStates: tt.refused}
+		signingLog := datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: tt.serial}
+
+		state, err := refusedDeviceState(db, settings, signingLog)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.serial, err)
+		}
+		if state != tt.state {
+			t.Errorf("%s %v: expected refused state %q, got %q", tt.serial, tt.refused, tt.state, state)
+		}
+	}
+}
+
+func TestRefusedDeviceStateError(t *testing.T) {
+	settings := config.Settings{RefuseSigningDeviceStates: []string{datastore.DeviceStateScrapped}}
+
+	_, err := refusedDeviceState(&datastore.ErrorMockDB{}, settings, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1"})
+	if err == nil {
+		t.Error("expected an error for the device state lookup")
+	}
+
+	// The state is not looked up when no states are refused
+	_, err = refusedDeviceState(&datastore.ErrorMockDB{}, config.Settings{}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package sign
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// duplicateError is the error of a serial-request that re-signs a device, when the
+// duplicate policy of the model rejects the re-signs
+type duplicateError struct {
+	error
+}
+
+// checkDuplicate checks whether the device has been signed before, in the duplicate scope of the
+// model, and returns the revision of the serial number to sign. A re-sign is signed with the next
+// revision of the serial number, unless the duplicate policy rejects the re-signs
+func checkDuplicate(db datastore.Datastore, policy datastore.DuplicatePolicy, signingLog *datastore.SigningLog) (int, bool, error) {
+	scope := policy.DuplicateScope()
+	duplicateExists, maxRevision, err := db.CheckForDuplicate(signingLog, scope)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if duplicateExists && policy.RejectsResigns() {
+		return 0, true, duplicateError{fmt.Errorf("The %s has already been used to sign a device", duplicateSubject(scope))}
+	}
+	return maxRevision + 1, duplicateExists, nil
+}
+
+func duplicateSubject(scope string) string {
+	switch scope {
+	case datastore.DuplicateScopeSerial:
+		return "serial number"
+	case datastore.DuplicateScopeDeviceKey:
+		return "device-key"
+	case datastore.DuplicateScopePair:
+		return "serial number and device-key"
+	default:
+		return "serial number or device-key"
+	}
+}
@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package sign
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+)
+
+func TestCheckDuplicate(t *testing.T) {
+	db := datastoretest.New()
+	db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint("fp1").Build())
+	db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint("fp2").WithRevision(2).Build())
+
+	tests := []struct {
+		policy    datastore.DuplicatePolicy
+		log       datastore.SigningLog
+		revision  int
+		duplicate bool
+		rejected  bool
+	}{
+		{datastore.DuplicatePolicy{}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "fp3"}, 1, false, false},
+		{datastore.DuplicatePolicy{}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "fp3"}, 3, true, false},
+		{datastore.DuplicatePolicy{Resign: "reject"}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "fp3"}, 0, true, true},
+		{datastore.DuplicatePolicy{Scope: "pair", Resign: "reject"}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "fp3"}, 3, false, false},
+		{datastore.DuplicatePolicy{Scope: "pair", Resign: "reject"}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Fingerprint: "fp2"}, 0, true, true},
+		{datastore.DuplicatePolicy{Scope: "device-key", Resign: "reject"}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "fp1"}, 0, true, true},
+		{datastore.DuplicatePolicy{Scope: "serial", Resign: "reject"}, datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A2", Fingerprint: "fp1"}, 1, false, false},
+	}
+
+	for i, tt := range tests {
+		revision, duplicate, err := checkDuplicate(db, tt.policy, &tt.log)
+		if _, ok := err.(duplicateError); ok != tt.rejected {
+			t.Errorf("%d: expected rejected %v, got error %v", i, tt.rejected, err)
+		}
+		if revision != tt.revision || duplicate != tt.duplicate {
+			t.Errorf("%d: expected revision %d and duplicate %v, got %d and %v", i, tt.revision, tt.duplicate, revision, duplicate)
+		}
+	}
+}
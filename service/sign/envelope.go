@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// envelopeMediaType is the media type that a client accepts for the JSON envelope
+const envelopeMediaType = "application/json"
+
+// StatusSigned is the status of the JSON envelope of a signed serial assertion
+const StatusSigned = "signed"
+
+// SerialEnvelope is the JSON response of the serial method, for the integrations that
+// cannot handle the assertion media type e.g. manufacturing execution systems
+type SerialEnvelope struct {
+	Status    string         `json:"status"`
+	Assertion string         `json:"assertion"` // the base64 encoded serial assertion
+	Metadata  SerialMetadata `json:"metadata"`
+	// Fields are the serial response fields of the model e.g. an enrollment URL
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// SerialMetadata describes the signed serial assertion of the JSON envelope
+type SerialMetadata struct {
+	MediaType string                  `json:"media-type"` // of the encoded assertion
+	BrandID   string                  `json:"brand-id"`
+	Model     string                  `json:"model"`
+	Serial    string                  `json:"serial"`
+	Revision  int                     `json:"revision"`
+	DeviceKey string                  `json:"device-key-sha3-384"`
+	Timestamp string                  `json:"timestamp"`
+	Signer    *datastore.SigningAudit `json:"signer,omitempty"`
+}
+
+// acceptsEnvelope checks if the client negotiated the JSON envelope. Existing clients that
+// also accept the assertion media type keep receiving the assertion
+func acceptsEnvelope(r *http.Request) bool {
+	return request.Accepts(r, envelopeMediaType) && !request.Accepts(r, asserts.MediaType)
+}
+
+// newSerialEnvelope wraps the signed serial assertion, its signing log and the serial response
+// fields in the JSON envelope
+func newSerialEnvelope(assertion asserts.Assertion, signingLog datastore.SigningLog, fields map[string]string) SerialEnvelope {
+	return SerialEnvelope{
+		Status:    StatusSigned,
+		Assertion: base64.StdEncoding.EncodeToString(asserts.Encode(assertion)),
+		Metadata: SerialMetadata{
+			MediaType: asserts.MediaType,
+			BrandID:   assertion.HeaderString("brand-id"),
+			Model:     assertion.HeaderString("model"),
+			Serial:    assertion.HeaderString("serial"),
+			Revision:  assertion.Revision(),
+			DeviceKey: assertion.HeaderString("device-key-sha3-384"),
+			Timestamp: assertion.HeaderString("timestamp"),
+			Signer:    signingLog.Signer,
+		},
+		Fields: fields,
+	}
+}
+
+func formatSerialEnvelope(assertion asserts.Assertion, signingLog datastore.SigningLog, fields map[string]string, w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	w.WriteHeader(http.StatusOK)
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(newSerialEnvelope(assertion, signingLog, fields)); err != nil {
+		log.Message("SIGN", "error-encode-envelope", err.Error())
+		return err
+	}
+	return nil
+}
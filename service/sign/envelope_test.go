@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAcceptsEnvelope(t *testing.T) {
+	tests := []struct {
+		accept   string
+		envelope bool
+	}{
+		{"application/json", true},
+		{"text/plain, application/json; charset=UTF-8", true},
+		{"application/json, application/x.ubuntu.assertion", false},
+		{"application/x.ubuntu.assertion", false},
+		{"application/cbor", false},
+		{"*/*", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("POST", "/v1/serial", nil)
+		r.Header.Set("Accept", tt.accept)
+		if envelope := acceptsEnvelope(r); envelope != tt.envelope {
+			t.Errorf("'%s': expected envelope %v, got %v", tt.accept, tt.envelope, envelope)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+// assertionFormat is a format level of the snapd assertions, with the headers that the level adds
+// to the serial-request or model assertions of a device. The headers are validated and passed
+// through to the serial assertion, as snapd reads them from the serial of the device
+type assertionFormat struct {
+	level   int
+	headers map[string][]string // the header names and their accepted values
+}
+
+// assertionFormats are the format levels that the vault handles, in order. A level includes the
+// headers of the levels below it
+var assertionFormats = []assertionFormat{
+	{level: 0},
+	{level: 1, headers: map[string][]string{
+		"grade":          {"dangerous", "signed", "secured"},
+		"storage-safety": {"unset", "encrypted", "prefer-encrypted", "prefer-unencrypted"},
+	}},
+}
+
+// maxAssertionFormat is the latest format level that the vault handles
+var maxAssertionFormat = assertionFormats[len(assertionFormats)-1].level
+
+// assertionFormatError is returned when a header of the negotiated format level is invalid
+type assertionFormatError struct {
+	error
+}
+
+// negotiateFormat returns the format level of the assertions of a device: the newest format of its
+// serial-request and optional model assertion, limited to the latest level that the vault handles.
+// The headers of a newer level are not passed through, as the vault cannot validate them
+func negotiateFormat(serialRequest, model asserts.Assertion) int {
+	level := serialRequest.Format()
+	if model != nil && model.Format() > level {
+		level = model.Format()
+	}
+	if level > maxAssertionFormat {
+		return maxAssertionFormat
+	}
+	return level
+}
+
+// formatHeaders validates and returns the headers of the format levels up to the negotiated one.
+// A header is taken from the serial-request or, when it is not there, from the model assertion
+func formatHeaders(level int, serialRequestHeaders, modelHeaders map[string]interface{}) (map[string]interface{}, error) {
+	headers := map[string]interface{}{}
+
+	for _, f := range assertionFormats {
+		if f.level > level {
+			break
+		}
+
+		for name, accepted := range f.headers {
+			value, ok := serialRequestHeaders[name]
+			if !ok {
+				value, ok = modelHeaders[name]
+			}
+			if !ok {
+				continue
+			}
+
+			v, isString := value.(string)
+			if !isString || !acceptedValue(v, accepted) {
+				return nil, assertionFormatError{fmt.Errorf("The %s header must be one of: %s", name, strings.Join(accepted, ", "))}
+			}
+			headers[name] = v
+		}
+	}
+	return headers, nil
+}
+
+func acceptedValue(value string, accepted []string) bool {
+	for _, a := range accepted {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+// formatAssertion is an assertion of a format level
+type formatAssertion struct {
+	asserts.Assertion
+	format int
+}
+
+func (a formatAssertion) Format() int { return a.format }
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		serialRequest int
+		model         *int
+		expected      int
+	}{
+		{0, nil, 0},
+		{1, nil, 1},
+		{0, intPtr(1), 1},
+		{1, intPtr(0), 1},
+		{maxAssertionFormat + 1, nil, maxAssertionFormat},
+		{0, intPtr(maxAssertionFormat + 3), maxAssertionFormat},
+	}
+
+	for _, tt := range tests {
+		var model asserts.Assertion
+		if tt.model != nil {
+			model = formatAssertion{format: *tt.model}
+		}
+
+		level := negotiateFormat(formatAssertion{format: tt.serialRequest}, model)
+		if level != tt.expected {
+			t.Errorf("Expected format %d for the serial-request format %d, got: %d", tt.expected, tt.serialRequest, level)
+		}
+	}
+}
+
+func TestFormatHeaders(t *testing.T) {
+	uc20 := map[string]interface{}{"grade": "secured", "storage-safety": "encrypted", "model": "alder"}
+
+	tests := []struct {
+		level         int
+		serialRequest map[string]interface{}
+		model         map[string]interface{}
+		expected      map[string]interface{}
+		err           bool
+	}{
+		// Format 0 does not pass through the headers of the newer formats
+		{0, map[string]interface{}{"model": "alder"}, nil, map[string]interface{}{}, false},
+		{0, uc20, nil, map[string]interface{}{}, false},
+		{0, map[string]interface{}{"grade": "invalid"}, nil, map[string]interface{}{}, false},
+
+		// Format 1 passes through the UC20 headers, from the serial-request or the model
+		{1, uc20, nil, map[string]interface{}{"grade": "secured", "storage-safety": "encrypted"}, false},
+		{1, map[string]interface{}{"model": "alder"}, uc20, map[string]interface{}{"grade": "secured", "storage-safety": "encrypted"}, false},
+		{1, map[string]interface{}{"grade": "dangerous"}, uc20, map[string]interface{}{"grade": "dangerous", "storage-safety": "encrypted"}, false},
+		{1, map[string]interface{}{"model": "alder"}, nil, map[string]interface{}{}, false},
+		{1, map[string]interface{}{"grade": "invalid"}, nil, nil, true},
+		{1, nil, map[string]interface{}{"storage-safety": "invalid"}, nil, true},
+		{1, map[string]interface{}{"grade": []interface{}{"secured"}}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		headers, err := formatHeaders(tt.level, tt.serialRequest, tt.model)
+		if (err != nil) != tt.err {
+			t.Errorf("Unexpected error for format %d %v: %v", tt.level, tt.serialRequest, err)
+		}
+		if _, ok := err.(assertionFormatError); err != nil && !ok {
+			t.Errorf("Expected an assertion format error, got: %v", err)
+		}
+		if !tt.err && !reflect.DeepEqual(headers, tt.expected) {
+			t.Errorf("Expected headers %v for format %d, got: %v", tt.expected, tt.level, headers)
+		}
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -21,21 +21,54 @@
 package sign
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/logsink"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/breaker"
+	"github.com/CanonicalLtd/serial-vault/service/devicecert"
+	"github.com/CanonicalLtd/serial-vault/service/failover"
+	"github.com/CanonicalLtd/serial-vault/service/keyusage"
 	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/maintenance"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
 	"github.com/snapcore/snapd/asserts"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Service holds the dependencies of the signing handlers
+type Service struct {
+	*datastore.Env
+}
+
+// SignerHeader is the response header of a signed serial assertion, identifying the
+// signing-key, keystore and vault instance that signed it
+const SignerHeader = "X-Serial-Vault-Signer"
+
+// maxSerialRequestSize limits the request stream of the serial-request and the optional
+// model assertion, which is decoded with the signing-keys loaded in memory
+const maxSerialRequestSize = 256 * 1024
+
+// canaryRoll picks the percentile of a serial request, which is signed with the canary
+// signing-key of the model when it is below the canary percentage
+var canaryRoll = func() int {
+	return rand.Intn(100)
+}
+
 // RequestIDResponse is the JSON response from the API Version method
 type RequestIDResponse struct {
 	Success      bool   `json:"success"`
@@ -43,206 +76,742 @@ type RequestIDResponse struct {
 	RequestID    string `json:"request-id"`
 }
 
+// RequestIDBatchRequest is the JSON request for a batch of nonces
+type RequestIDBatchRequest struct {
+	Count int `json:"count"`
+}
+
+// RequestIDBatchResponse is the JSON response from the API batch nonce method
+type RequestIDBatchResponse struct {
+	Success      bool     `json:"success"`
+	ErrorMessage string   `json:"message"`
+	RequestIDs   []string `json:"request-ids"`
+}
+
 // RequestID is the API method to generate a nonce
-func RequestID(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+func (srv *Service) RequestID(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	w.Header().Set("Content-Type", response.JSONHeader)
 	// Check that we have an authorised API key header
-	_, err := request.CheckModelAPI(r)
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
 	if err != nil {
 		log.Message("REQUESTID", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
 		return response.ErrorInvalidAPIKey
 	}
 
-	err = datastore.Environ.DB.DeleteExpiredDeviceNonces()
-	if err != nil {
-		log.Message("REQUESTID", "delete-expired-nonces", err.Error())
-		return response.ErrorGenerateNonce
+	if e, paused := srv.inMaintenance(w, "REQUESTID", "", ""); paused {
+		return e
 	}
 
-	nonce, err := datastore.Environ.DB.CreateDeviceNonce()
+	if onStandby("REQUESTID") {
+		return response.ErrorStandby
+	}
+
+	if !srv.nonceIssuanceAllowed(w, apiKey, 1) {
+		return response.ErrorNonceBanned
+	}
+
+	if !datastoreAvailable(w) {
+		return response.ErrorDatastoreUnavailable
+	}
+
+	ctx, cancel := request.DatastoreContext(r, srv.Config)
+	defer cancel()
+	db := srv.DB.WithContext(ctx)
+
+	// The batch of one is refused when the API key holds too many unused nonces
+	nonces, err := db.CreateDeviceNonces(apiKey, request.ClientIP(r, srv.Config), 1)
+	if err == datastore.ErrNonceLimit {
+		log.Message("REQUESTID", response.ErrorNonceLimit.Code, response.ErrorNonceLimit.Message)
+		return response.ErrorNonceLimit
+	}
 	if err != nil {
 		log.Message("REQUESTID", "generate-request-id", err.Error())
-		return response.ErrorGenerateNonce
+		return datastoreError(ctx, response.ErrorGenerateNonce)
 	}
+	nonce := nonces[0]
+
+	breaker.Datastore.Success()
 
-	// Return successful JSON response with the nonce
+	// Return successful response with the nonce, using CBOR when the client negotiated it
+	if request.AcceptsCBOR(r) {
+		response.FormatCBORResponse(http.StatusOK, map[string]interface{}{"request-id": nonce.Nonce}, w)
+		return response.ErrorResponse{Success: true}
+	}
 	formatRequestIDResponse(nonce, w)
 	return response.ErrorResponse{Success: true}
 }
 
-// Serial is the API method to sign serial assertions from the device
-func Serial(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+// RequestIDBatch is the API method to generate a batch of nonces, so a provisioning
+// station can pipeline its serial requests. Each nonce is single-use and expires independently.
+func (srv *Service) RequestIDBatch(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	w.Header().Set("Content-Type", response.JSONHeader)
 	// Check that we have an authorised API key header
-	apiKey, err := request.CheckModelAPI(r)
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
 	if err != nil {
-		log.Message("SIGN", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
+		log.Message("REQUESTIDS", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
 		return response.ErrorInvalidAPIKey
 	}
 
-	defer r.Body.Close()
+	batch := RequestIDBatchRequest{}
+	err = json.NewDecoder(r.Body).Decode(&batch)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		log.Message("REQUESTIDS", response.ErrorNilData.Code, response.ErrorNilData.Message)
+		return response.ErrorNilData
+		// Check for parsing errors
+	case err != nil:
+		log.Message("REQUESTIDS", response.ErrorDecodeJSON.Code, err.Error())
+		return response.ErrorDecodeJSON
+	}
 
-	// Use snapd assertion module to decode the assertions in the request stream
-	dec := asserts.NewDecoder(r.Body)
-	assertion, err := dec.Decode()
-	if err == io.EOF {
-		log.Message("SIGN", "invalid-assertion", response.ErrorEmptyData.Message)
-		return response.ErrorEmptyData
+	if batch.Count < 1 || batch.Count > datastore.NonceBatchMaximum {
+		log.Message("REQUESTIDS", response.ErrorInvalidNonceCount.Code, response.ErrorInvalidNonceCount.Message)
+		return response.ErrorInvalidNonceCount
 	}
-	if err != nil {
-		log.Message("SIGN", "invalid-assertion", err.Error())
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+
+	if e, paused := srv.inMaintenance(w, "REQUESTIDS", "", ""); paused {
+		return e
 	}
 
-	// Decode the optional model
-	modelAssert, err := dec.Decode()
-	if err != nil && err != io.EOF {
-		log.Message("SIGN", "invalid-assertion", err.Error())
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	if onStandby("REQUESTIDS") {
+		return response.ErrorStandby
 	}
 
-	// Stream must be ended now
-	_, err = dec.Decode()
-	if err != io.EOF {
-		if err == nil {
-			err = fmt.Errorf("unexpected assertion in the request stream")
-		}
-		log.Message("SIGN", response.ErrorInvalidAssertion.Code, err.Error())
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	if !srv.nonceIssuanceAllowed(w, apiKey, batch.Count) {
+		return response.ErrorNonceBanned
 	}
 
-	// Check that we have a serial-request assertion (the details will have been validated by Decode call)
-	if assertion.Type() != asserts.SerialRequestType {
-		log.Message("SIGN", response.ErrorInvalidType.Code, "The assertion type must be 'serial-request'")
-		return response.ErrorInvalidType
+	if !datastoreAvailable(w) {
+		return response.ErrorDatastoreUnavailable
 	}
 
-	// Double check the model assertion if present
-	if modelAssert != nil {
-		if modelAssert.Type() != asserts.ModelType {
-			log.Message("SIGN", response.ErrorInvalidSecondType.Code, response.ErrorInvalidSecondType.Message)
-			return response.ErrorInvalidSecondType
-		}
-		if modelAssert.HeaderString("brand-id") != assertion.HeaderString("brand-id") || modelAssert.HeaderString("model") != assertion.HeaderString("model") {
-			const msg = "Model and serial-request assertion do not match"
-			log.Message("SIGN", "mismatched-model", msg)
-			return response.ErrorResponse{Success: false, Code: "mismatched-model", Message: msg, StatusCode: http.StatusBadRequest}
+	ctx, cancel := request.DatastoreContext(r, srv.Config)
+	defer cancel()
+	db := srv.DB.WithContext(ctx)
+
+	// The batch is refused when it would take the API key over its limit of unused nonces
+	nonces, err := db.CreateDeviceNonces(apiKey, request.ClientIP(r, srv.Config), batch.Count)
+	if err == datastore.ErrNonceLimit {
+		log.Message("REQUESTIDS", response.ErrorNonceLimit.Code, response.ErrorNonceLimit.Message)
+		return response.ErrorNonceLimit
+	}
+	if err != nil {
+		log.Message("REQUESTIDS", "generate-request-ids", err.Error())
+		return datastoreError(ctx, response.ErrorGenerateNonce)
+	}
+
+	breaker.Datastore.Success()
+
+	// Return successful response with the nonces, using CBOR when the client negotiated it
+	if request.AcceptsCBOR(r) {
+		requestIDs := []string{}
+		for _, n := range nonces {
+			requestIDs = append(requestIDs, n.Nonce)
 		}
+		response.FormatCBORResponse(http.StatusOK, map[string]interface{}{"request-ids": requestIDs}, w)
+		return response.ErrorResponse{Success: true}
+	}
+	formatRequestIDBatchResponse(nonces, w)
+	return response.ErrorResponse{Success: true}
+}
 
-		// TODO: ideally check the signature of model, need access
-		// to the brand public key(s) for models
+// Serial is the API method to sign serial assertions from the device
+func (srv *Service) Serial(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	// Check that we have an authorised API key header
+	apiKey, err := request.CheckModelAPI(r, srv.DB)
+	if err != nil {
+		log.Message("SIGN", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
+		return response.ErrorInvalidAPIKey
 	}
 
-	// Verify that the nonce is valid and has not expired
-	err = datastore.Environ.DB.ValidateDeviceNonce(assertion.HeaderString("request-id"))
+	defer r.Body.Close()
+
+	// Decode the serial-request and the optional model assertion in the request stream
+	assertion, modelAssertion, err := validation.DecodeSerialRequest(http.MaxBytesReader(w, r.Body, maxSerialRequestSize))
 	if err != nil {
-		log.Message("SIGN", response.ErrorInvalidNonce.Code, response.ErrorInvalidNonce.Message)
-		return response.ErrorInvalidNonce
+		return serialRequestError(err)
+	}
+
+	if e, paused := srv.inMaintenance(w, "SIGN", assertion.HeaderString("brand-id"), assertion.HeaderString("model")); paused {
+		return e
+	}
+
+	if onStandby("SIGN") {
+		return response.ErrorStandby
+	}
+
+	if !datastoreAvailable(w) {
+		return response.ErrorDatastoreUnavailable
+	}
+
+	ctx, cancel := request.DatastoreContext(r, srv.Config)
+	defer cancel()
+	db := srv.DB.WithContext(ctx)
+
+	// An exact replay of a serial-request e.g. a retry on a flaky network, gets the serial assertion
+	// that was signed for it when the model allows replays, instead of a new revision. Its nonce was
+	// used when it was signed, so it is not validated again
+	requestHash := serialRequestHash(assertion)
+	replay, replayLog := replayedSerial(db, srv.Config, apiKey, requestHash)
+
+	// Verify that the nonce is valid, has not expired and was issued for this API key
+	if replay == nil {
+		err = db.ValidateDeviceNonce(assertion.HeaderString("request-id"), apiKey, request.ClientIP(r, srv.Config))
+		if err != nil {
+			log.Message("SIGN", response.ErrorInvalidNonce.Code, response.ErrorInvalidNonce.Message)
+			return upstreamError(ctx, response.ErrorInvalidNonce)
+		}
 	}
 
 	// Validate the model by checking that it exists on the database
-	model, errResponse := findModel(assertion, apiKey)
+	resolution, errResponse := findModel(db, assertion, apiKey)
 	if !errResponse.Success {
-		return errResponse
+		return upstreamError(ctx, errResponse)
+	}
+	model := resolution.Model
+
+	// The nonce of a replay has been used, unless the model allows replays
+	if replay != nil && !model.DuplicatePolicy.Replay {
+		log.Message("SIGN", response.ErrorInvalidNonce.Code, response.ErrorInvalidNonce.Message)
+		return response.ErrorInvalidNonce
 	}
 
-	// Check that the model has an active keypair
+	// Check that the model has an active keypair, blocking the models of a compromised keypair
 	if !model.KeyActive {
+		if _, err := db.GetKeypairCompromise(model.KeypairID); err == nil {
+			log.Message("SIGN", response.ErrorCompromisedModel.Code, response.ErrorCompromisedModel.Message)
+			return response.ErrorCompromisedModel
+		}
 		log.Message("SIGN", response.ErrorInactiveModel.Code, response.ErrorInactiveModel.Message)
 		return response.ErrorInactiveModel
 	}
 
+	// Reject weak or malformed device-keys, as set by the device-key policy of the model
+	err = checkDeviceKey(model.DeviceKeyPolicy, assertion.HeaderString("device-key"))
+	if err != nil {
+		metrics.Increment(metrics.DeviceKeysRejected)
+		log.Message("SIGN", response.ErrorInvalidDeviceKey.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidDeviceKey.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	// Identify the provisioning station and check that it is registered for the model
+	body := decodeRequestBody(assertion.Body())
+	station := requestStation(r, body)
+	err = db.ValidateStation(model.ID, station)
+	if err != nil {
+		log.Message("SIGN", response.ErrorInvalidStation.Code, err.Error())
+		return upstreamError(ctx, response.ErrorResponse{Success: false, Code: response.ErrorInvalidStation.Code, Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	// Check that the factory is authorized to sign for the model at this time
+	err = db.CheckSigningAuthorization(model.BrandID, model.Name)
+	if err != nil {
+		log.Message("SIGN", response.ErrorSigningNotAuthorized.Code, err.Error())
+		return upstreamError(ctx, response.ErrorResponse{Success: false, Code: response.ErrorSigningNotAuthorized.Code, Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	if replay != nil {
+		return srv.replaySerial(ctx, w, r, db, apiKey, resolution, replay, replayLog)
+	}
+
 	// Create a basic signing log entry (without the serial number)
-	signingLog := datastore.SigningLog{Make: assertion.HeaderString("brand-id"), Model: assertion.HeaderString("model"), Fingerprint: assertion.SignKeyID()}
+	signingLog := datastore.SigningLog{Make: assertion.HeaderString("brand-id"), Model: assertion.HeaderString("model"), Fingerprint: assertion.SignKeyID(), Station: station, Details: srv.requestDetails(body), Origin: srv.requestOrigin(r)}
+
+	// Get the timestamp of the serial assertion from the timestamp policy of the model
+	timestamp, err := serialTimestamp(model.TimestampPolicy, body, time.Now())
+	if err != nil {
+		log.Message("SIGN", response.ErrorInvalidManufactureDate.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidManufactureDate.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
 
 	// Convert the serial-request headers into a serial assertion
-	serialAssertion, err := serialRequestToSerial(assertion, &signingLog)
+	serialAssertion, err := serialRequestToSerial(db, assertion, modelAssertion, body, resolution.AuthorityID(assertion.HeaderString("brand-id")), model.SerialPipeline, model.DuplicatePolicy, timestamp, &signingLog)
+	if _, ok := err.(serialPipelineError); ok {
+		log.Message("SIGN", response.ErrorInvalidSerial.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidSerial.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	if _, ok := err.(assertionFormatError); ok {
+		log.Message("SIGN", response.ErrorInvalidAssertionFormat.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertionFormat.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	if _, ok := err.(duplicateError); ok {
+		metrics.Increment(metrics.DuplicatesRejected)
+		log.Message("SIGN", response.ErrorDuplicateAssertion.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorDuplicateAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
 	if err != nil {
 		log.Message("SIGN", response.ErrorCreateAssertion.Code, err.Error())
-		return response.ErrorCreateAssertion
+		return upstreamError(ctx, response.ErrorCreateAssertion)
 	}
 
-	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SerialType, serialAssertion.Headers(), serialAssertion.Body(), model.AuthorityID, model.KeyID, model.SealedKey)
+	// Check the device manifest of the serial-request, which is stored with the serial number
+	manifest, err := serialManifest(assertion, body, signingLog)
+	if err != nil {
+		log.Message("SIGN", response.ErrorInvalidManifest.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidManifest.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	// Refuse the device by its lifecycle state, the quarantine list or the external policy engine
+	if errResponse := srv.deviceAllowed(ctx, r, db, apiKey, resolution, signingLog); !errResponse.Success {
+		return errResponse
+	}
+
+	// Sign the assertion with the snapd assertions module, failing over to the fallback
+	// signing-keys of the model. The keystore has its own timeout
+	canary := modelCanary(db, model)
+	signedAssertion, keypair, err := srv.signSerial(r.Context(), db, model, canary, serialAssertion)
+	if err == context.DeadlineExceeded {
+		log.Message("SIGN", response.ErrorUpstreamTimeout.Code, "Timeout signing the serial assertion")
+		return response.ErrorUpstreamTimeout
+	}
+	if err == datastore.ErrKeystoreBusy {
+		log.Message("SIGN", response.ErrorKeystoreBusy.Code, err.Error())
+		return response.ErrorKeystoreBusy
+	}
 	if err != nil {
 		log.Message("SIGN", "signing-assertion", err.Error())
 		return response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	// Store the serial number and device-key fingerprint in the database
-	err = datastore.Environ.DB.CreateSigningLog(signingLog)
+	// Check the signed assertion against the content policy, before a device receives it
+	if !srv.serialLinted(signedAssertion, model, keypair) {
+		return response.ErrorSerialLint
+	}
+
+	// Store the serial number and device-key fingerprint in the database, with the audit
+	// of the signing and marking the devices that were signed with a fallback signing-key
+	signingLog.Signer = srv.NewSigningAudit(keypair)
+	switch keypair.ID {
+	case model.KeypairID:
+	case canary.KeypairID:
+		signingLog.Signer.Canary = true
+	default:
+		signingLog.FallbackKeyID = keypair.KeyID
+	}
+
+	// Write the signing log entry to the write-once storage, when it is configured. It is written
+	// before the signing log is stored, so a failure refuses the signing and the device can retry it
+	err = logsink.Write(r.Context(), db, signingLog)
+	if err != nil {
+		log.Message("SIGN", response.ErrorSigningLogSink.Code, err.Error())
+		return response.ErrorSigningLogSink
+	}
+
+	err = db.CreateSigningLog(signingLog)
+	if _, ok := err.(datastore.ErrMaxUnitsReached); ok {
+		log.Message("SIGN", response.ErrorSigningNotAuthorized.Code, err.Error())
+		return upstreamError(ctx, response.ErrorResponse{Success: false, Code: response.ErrorSigningNotAuthorized.Code, Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
 	if err != nil {
 		log.Message("SIGN", "logging-assertion", err.Error())
-		return response.ErrorResponse{Success: false, Code: "logging-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
+		return datastoreError(ctx, response.ErrorResponse{Success: false, Code: "logging-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest})
 	}
 
-	// Return successful JSON response with the signed text
-	formatSignResponse(signedAssertion, w)
+	// Alert when the signing-key signed for a brand/model that it has not signed before, or
+	// that is not in its allowlist
+	keyusage.Check(db, srv.Config, keypair, asserts.SerialType.Name, model.BrandID, model.Name)
+
+	// Store the device manifest. The device is signed, so a failure is only logged
+	if manifest != nil {
+		if err := db.CreateDeviceManifest(*manifest); err != nil {
+			log.Message("SIGN", "logging-manifest", err.Error())
+		}
+	}
+
+	// Issue the device certificate, which the device fetches with the API key. The device is
+	// signed, so a failure is only logged
+	if srv.Config.DeviceCertificates {
+		if _, err := devicecert.Issue(db, srv.Config, signedAssertion); err != nil {
+			log.Message("SIGN", "issue-certificate", err.Error())
+		}
+	}
+
+	// Keep the serial assertion for the replays of the serial-request
+	recordSerial(db, srv.Config, model, apiKey, requestHash, signedAssertion, signingLog)
+
+	breaker.Datastore.Success()
+	w.Header().Set(SignerHeader, signingLog.Signer.String())
+
+	formatSerial(w, r, signedAssertion, signingLog, serialResponseFields(model, signedAssertion, signingLog))
 	return response.ErrorResponse{Success: true}
 }
 
-// findModel finds the model by checking that there is an original or pivoted model
-func findModel(assertion asserts.Assertion, apiKey string) (datastore.Model, response.ErrorResponse) {
-	// Assume this is an original (non-pivoted) serial assertion
-	// Validate the model by checking that it exists on the database
-	model, err := datastore.Environ.DB.FindModel(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), apiKey)
+// deviceAllowed checks that the lifecycle state of the device allows its serial number to be signed,
+// that the device is not on the quarantine list, by its serial number or device-key, and that the
+// external policy engine allows the signing request, for the bespoke rules of the brand
+func (srv *Service) deviceAllowed(ctx context.Context, r *http.Request, db datastore.Datastore, apiKey string, resolution validation.Resolution, signingLog datastore.SigningLog) response.ErrorResponse {
+	state, err := refusedDeviceState(db, srv.Config, signingLog)
 	if err != nil {
-		log.Message("SIGN", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
-	} else {
-		// Found the model, so return it
-		return model, response.ErrorResponse{Success: true}
+		log.Message("SIGN", response.ErrorDeviceState.Code, err.Error())
+		return datastoreError(ctx, response.ErrorResponse{Success: false, Code: response.ErrorDeviceState.Code, Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if len(state) > 0 {
+		message := fmt.Sprintf("The device with serial number %s is %s", signingLog.SerialNumber, state)
+		log.Message("SIGN", response.ErrorDeviceState.Code, message)
+		return response.ErrorResponse{Success: false, Code: response.ErrorDeviceState.Code, Message: message, StatusCode: http.StatusBadRequest}
 	}
 
-	// Assume that this is a pivoted serial assertion
-	// Check for a sub-store model for the pivot
-	substore, err := datastore.Environ.DB.GetSubstoreModel(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"))
+	quarantine, err := db.CheckDeviceQuarantine(signingLog.Make, signingLog.SerialNumber, signingLog.Fingerprint)
+	if err != nil && err != sql.ErrNoRows {
+		log.Message("SIGN", response.ErrorQuarantinedDevice.Code, err.Error())
+		return datastoreError(ctx, response.ErrorResponse{Success: false, Code: response.ErrorQuarantinedDevice.Code, Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err == nil {
+		metrics.Increment(metrics.QuarantineRejected)
+		log.Message("SIGN", response.ErrorQuarantinedDevice.Code, fmt.Sprintf("The device with serial number %s is quarantined: %s", signingLog.SerialNumber, quarantine.Reason))
+		return response.ErrorQuarantinedDevice
+	}
+
+	return srv.policyAllows(r, apiKey, resolution, signingLog)
+}
+
+// formatSerial returns the signed serial assertion, using CBOR or the JSON envelope when the
+// client negotiated it. The serial response fields are only returned in the JSON envelope
+func formatSerial(w http.ResponseWriter, r *http.Request, signedAssertion asserts.Assertion, signingLog datastore.SigningLog, fields map[string]string) {
+	if request.AcceptsCBOR(r) {
+		response.FormatCBORResponse(http.StatusOK, map[string]interface{}{"serial": asserts.Encode(signedAssertion)}, w)
+		return
+	}
+	if acceptsEnvelope(r) {
+		formatSerialEnvelope(signedAssertion, signingLog, fields, w)
+		return
+	}
+	formatSignResponse(signedAssertion, w)
+}
+
+// modelCanary returns the canary signing-key of the model. A model without a canary
+// key has a zero keypair ID, and the model's signing-key is used when it cannot be read
+func modelCanary(db datastore.Datastore, model datastore.Model) datastore.ModelCanary {
+	canary, err := db.GetModelCanary(model.ID)
 	if err != nil {
-		log.Message("CHECK", response.ErrorInvalidModelSubstore.Code, response.ErrorInvalidModelSubstore.Message)
-		return model, response.ErrorInvalidModelSubstore
+		log.Message("SIGN", "signing-canary", err.Error())
+		return datastore.ModelCanary{}
+	}
+	return canary
+}
+
+// recordKeyResult counts the result of the signing-key while the model has a canary key
+func recordKeyResult(db datastore.Datastore, modelID, keypairID int, err error) {
+	if errRecord := db.RecordModelKeyResult(modelID, keypairID, err == nil); errRecord != nil {
+		log.Message("SIGN", "signing-canary", errRecord.Error())
+	}
+}
+
+// signSerial signs the serial assertion with the signing-key of the model. The active
+// canary signing-key of the model signs its percentage of the serial requests instead,
+// falling back to the model's signing-key when it fails. When that fails too, e.g. the
+// HSM is down, the active fallback signing-keys of the model are tried in order. The
+// keypair that signed the assertion is returned, and the error of the model's
+// signing-key is returned when none of the keys can sign
+func (srv *Service) signSerial(ctx context.Context, db datastore.Datastore, model datastore.Model, canary datastore.ModelCanary, serialAssertion asserts.Assertion) (asserts.Assertion, datastore.Keypair, error) {
+	keypair := datastore.Keypair{ID: model.KeypairID, AuthorityID: model.AuthorityID, KeyID: model.KeyID}
+
+	if canary.KeypairID > 0 && canary.KeyActive && canaryRoll() < canary.Percent {
+		canaryKeypair := datastore.Keypair{ID: canary.KeypairID, AuthorityID: canary.AuthorityID, KeyID: canary.KeyID}
+		signedAssertion, err := srv.KeypairDB.SignAssertion(ctx, db, asserts.SerialType, serialAssertion.Headers(), serialAssertion.Body(), canary.AuthorityID, canary.KeyID, canary.SealedKey)
+		recordKeyResult(db, model.ID, canary.KeypairID, err)
+		if err == nil || ctx.Err() != nil {
+			return signedAssertion, canaryKeypair, err
+		}
+		log.Message("SIGN", "signing-canary", fmt.Sprintf("Error signing with the canary key %s: %v", canary.KeyID, err))
+	}
+
+	signedAssertion, err := srv.KeypairDB.SignAssertion(ctx, db, asserts.SerialType, serialAssertion.Headers(), serialAssertion.Body(), model.AuthorityID, model.KeyID, model.SealedKey)
+	if canary.KeypairID > 0 {
+		recordKeyResult(db, model.ID, model.KeypairID, err)
+	}
+	if err == nil || ctx.Err() != nil {
+		return signedAssertion, keypair, err
 	}
 
-	if substore.FromModel.APIKey != apiKey {
-		return substore.FromModel, response.ErrorInvalidModelSubstore
+	keypairs, errFallback := db.ListModelFallbackKeypairs(model.ID)
+	if errFallback != nil {
+		log.Message("SIGN", "signing-fallback", errFallback.Error())
+		return nil, keypair, err
 	}
 
-	return substore.FromModel, response.ErrorResponse{Success: true}
+	for _, k := range keypairs {
+		if !k.Active {
+			continue
+		}
+
+		signedAssertion, errFallback := srv.KeypairDB.SignAssertion(ctx, db, asserts.SerialType, serialAssertion.Headers(), serialAssertion.Body(), k.AuthorityID, k.KeyID, k.SealedKey)
+		if errFallback != nil {
+			log.Message("SIGN", "signing-fallback", fmt.Sprintf("Error signing with the fallback key %s: %v", k.KeyID, errFallback))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		// Raise the alert: the model's signing-key must be fixed
+		metrics.Increment(metrics.SigningFallbacks)
+		log.Message("SIGN", "signing-fallback", fmt.Sprintf("Signed the serial assertion of model %s/%s with the fallback key %s, the signing-key failed: %v", model.BrandID, model.Name, k.KeyID, err))
+		return signedAssertion, k, nil
+	}
+
+	return nil, keypair, err
+}
+
+// upstreamError returns the upstream-timeout error when the latency budget of the
+// request has been used up, as that is the cause of the error. The slow datastore
+// counts as a failure for the circuit breaker
+func upstreamError(ctx context.Context, e response.ErrorResponse) response.ErrorResponse {
+	if request.TimedOut(ctx) {
+		breaker.Datastore.Failure()
+		log.Message("SIGN", response.ErrorUpstreamTimeout.Code, response.ErrorUpstreamTimeout.Message)
+		return response.ErrorUpstreamTimeout
+	}
+	return e
 }
 
-// serialRequestToSerial converts a serial-request to a serial assertion
-func serialRequestToSerial(assertion asserts.Assertion, signingLog *datastore.SigningLog) (asserts.Assertion, error) {
+// datastoreError records the failure of the datastore in the circuit breaker
+func datastoreError(ctx context.Context, e response.ErrorResponse) response.ErrorResponse {
+	if request.TimedOut(ctx) {
+		return upstreamError(ctx, e)
+	}
+	breaker.Datastore.Failure()
+	return e
+}
+
+// nonceIssuanceAllowed checks that the API key is not banned from requesting nonces. A new
+// ban is recorded in the audit log, with the masked API key
+func (srv *Service) nonceIssuanceAllowed(w http.ResponseWriter, apiKey string, count int) bool {
+	ok, retry, banned := issuance.request(apiKey, count, srv.Config)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		log.Message("REQUESTID", response.ErrorNonceBanned.Code, response.ErrorNonceBanned.Message)
+	}
+	if banned {
+		entry := datastore.AuditEntry{Username: maskAPIKey(apiKey), Action: datastore.AuditNonceBan, Status: response.ErrorNonceBanned.StatusCode}
+		if _, err := srv.DB.CreateAuditEntry(entry); err != nil {
+			log.Message("REQUESTID", "audit-nonce-ban", err.Error())
+		}
+	}
+	return ok
+}
+
+// serialLinted checks the signed serial assertion against the content policy, logging the
+// violations. The assertion must not be returned when the policy blocks the violations
+func (srv *Service) serialLinted(signedAssertion asserts.Assertion, model datastore.Model, keypair datastore.Keypair) bool {
+	policy := lintPolicy(srv.Config)
+	if policy == lintOff {
+		return true
+	}
+
+	violations := lintSerial(srv.Config, signedAssertion.Headers(), signedAssertion.SignKeyID(), model, keypair, time.Now())
+	if len(violations) == 0 {
+		return true
+	}
+
+	metrics.Increment(metrics.SerialLintViolations)
+	for _, v := range violations {
+		log.Message("SIGN", response.ErrorSerialLint.Code, fmt.Sprintf("Serial assertion of model %s/%s: %s", model.BrandID, model.Name, v))
+	}
+	return policy != lintBlock
+}
+
+// inMaintenance checks the maintenance windows of the model. During a window the request is
+// rejected, telling the client to retry when the window ends
+func (srv *Service) inMaintenance(w http.ResponseWriter, method, brand, model string) (response.ErrorResponse, bool) {
+	now := time.Now()
+	window, paused := maintenance.Active(srv.Config, brand, model, now)
+	if !paused {
+		return response.ErrorResponse{Success: true}, false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(window.End.Sub(now).Seconds()))))
+
+	e := response.ErrorMaintenance
+	if len(window.Reason) > 0 {
+		e.Message = fmt.Sprintf("%s (%s)", e.Message, window.Reason)
+	}
+	log.Message(method, e.Code, e.Message)
+	return e, true
+}
+
+// onStandby checks if this vault is the standby vault of a failover pair, which must not
+// sign until it is promoted
+func onStandby(method string) bool {
+	if failover.Signing.IsActive() {
+		return false
+	}
+	log.Message(method, response.ErrorStandby.Code, response.ErrorStandby.Message)
+	return true
+}
+
+// datastoreAvailable checks the circuit breaker of the datastore. When it is open the
+// request is shed, telling the client when to retry
+func datastoreAvailable(w http.ResponseWriter) bool {
+	ok, retry := breaker.Datastore.Allow()
+	if !ok {
+		seconds := int(math.Ceil(retry.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		log.Message("SIGN", response.ErrorDatastoreUnavailable.Code, "The circuit breaker of the datastore is open")
+	}
+	return ok
+}
+
+// serialRequestError maps an error decoding the request stream to its response
+func serialRequestError(err error) response.ErrorResponse {
+	switch err {
+	case validation.ErrEmptyData:
+		log.Message("SIGN", response.ErrorInvalidAssertion.Code, response.ErrorEmptyData.Message)
+		return response.ErrorEmptyData
+	case validation.ErrNotSerialRequest:
+		log.Message("SIGN", response.ErrorInvalidType.Code, err.Error())
+		return response.ErrorInvalidType
+	case validation.ErrNotModel:
+		log.Message("SIGN", response.ErrorInvalidSecondType.Code, response.ErrorInvalidSecondType.Message)
+		return response.ErrorInvalidSecondType
+	case validation.ErrMismatchedModel:
+		log.Message("SIGN", "mismatched-model", err.Error())
+		return response.ErrorResponse{Success: false, Code: "mismatched-model", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	default:
+		log.Message("SIGN", response.ErrorInvalidAssertion.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+}
+
+// findModel finds the model by checking that there is an original or pivoted model, telling apart
+// a wrong API key from a device that has no model nor sub-store model
+func findModel(db datastore.Datastore, assertion asserts.Assertion, apiKey string) (validation.Resolution, response.ErrorResponse) {
+	resolution, err := validation.ResolveDevice(db, assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), apiKey)
+	switch err {
+	case nil:
+		return resolution, response.ErrorResponse{Success: true}
+	case validation.ErrInvalidAPIKey:
+		log.Message("SIGN", response.ErrorInvalidAPIKey.Code, err.Error())
+		return resolution, response.ErrorInvalidAPIKey
+	case validation.ErrSubstoreAPIKey:
+		log.Message("SIGN", response.ErrorSubstoreAPIKey.Code, err.Error())
+		return resolution, response.ErrorSubstoreAPIKey
+	case validation.ErrPivotTargetNotFound:
+		log.Message("SIGN", response.ErrorPivotTargetNotFound.Code, err.Error())
+		return resolution, response.ErrorPivotTargetNotFound
+	case validation.ErrCrossAuthorityPivot:
+		log.Message("SIGN", response.ErrorCrossAuthorityPivot.Code, err.Error())
+		return resolution, response.ErrorCrossAuthorityPivot
+	default:
+		log.Message("SIGN", response.ErrorInvalidModelSubstore.Code, err.Error())
+		return resolution, response.ErrorInvalidModelSubstore
+	}
+}
+
+// yamlAlias matches an alias in a YAML document: a node that starts with '*', which
+// cannot start a plain scalar
+var yamlAlias = regexp.MustCompile(`(?m)(^[ \t]*(-[ \t]+)*|[:?][ \t]+|[\[{,][ \t]*)\*`)
+
+// decodeRequestBody decodes the body of the serial-request, which must be YAML. The
+// body is optional, so an invalid body is treated as empty. The YAML decoder expands
+// aliases without limit, so a small body with nested aliases takes exponential time
+// to decode: the bodies with aliases are not decoded
+func decodeRequestBody(content []byte) map[string]interface{} {
+	if yamlAlias.Match(content) {
+		return map[string]interface{}{}
+	}
+
+	body := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &body); err != nil {
+		return map[string]interface{}{}
+	}
+	return body
+}
+
+// requestStation gets the provisioning station from the request header, but falls back
+// to the body of the serial-request
+func requestStation(r *http.Request, body map[string]interface{}) string {
+	if station := r.Header.Get("station"); len(station) > 0 {
+		return station
+	}
+
+	station, _ := body["station"].(string)
+	return station
+}
+
+// requestDetails gets the allowed fields from the body of the serial-request, so the
+// hardware details of the device are stored in the signing log
+func (srv *Service) requestDetails(body map[string]interface{}) map[string]string {
+	if len(srv.Config.SigningLogBodyFields) == 0 {
+		return nil
+	}
+
+	details := map[string]string{}
+	for _, field := range srv.Config.SigningLogBodyFields {
+		switch value := body[field].(type) {
+		case string:
+			details[field] = value
+		case int, float64, bool:
+			details[field] = fmt.Sprint(value)
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// serialRequestToSerial converts a serial-request to a serial assertion of the authority, with the timestamp.
+// The serial number is normalized by the serial pipeline of the model, and checked for duplicates by its
+// duplicate policy. The headers of the format level of the serial-request and the optional model assertion
+// are passed through
+func serialRequestToSerial(db datastore.Datastore, assertion, modelAssertion asserts.Assertion, body map[string]interface{}, authorityID string, pipeline datastore.SerialPipeline, duplicatePolicy datastore.DuplicatePolicy, timestamp time.Time, signingLog *datastore.SigningLog) (asserts.Assertion, error) {
 
 	// Create the serial assertion header from the serial-request headers
 	serialHeaders := assertion.Headers()
 	headers := map[string]interface{}{
 		"type":                asserts.SerialType.Name,
-		"authority-id":        serialHeaders["brand-id"],
+		"authority-id":        authorityID,
 		"brand-id":            serialHeaders["brand-id"],
 		"serial":              serialHeaders["serial"],
 		"device-key":          serialHeaders["device-key"],
 		"sign-key-sha3-384":   serialHeaders["sign-key-sha3-384"],
 		"device-key-sha3-384": serialHeaders["sign-key-sha3-384"],
 		"model":               serialHeaders["model"],
-		"timestamp":           time.Now().Format(time.RFC3339),
+		"timestamp":           timestamp.Format(time.RFC3339),
 	}
 
-	// Get the serial-number from the header, but fallback to the body if it is not there
-	if headers["serial"] == nil || headers["serial"].(string) == "" {
-		// Decode the body which must be YAML, ignore errors
-		body := make(map[string]interface{})
-		yaml.Unmarshal(assertion.Body(), &body)
+	// Pass through the headers of the negotiated format level of the assertions
+	var modelHeaders map[string]interface{}
+	if modelAssertion != nil {
+		modelHeaders = modelAssertion.Headers()
+	}
+	passThrough, err := formatHeaders(negotiateFormat(assertion, modelAssertion), serialHeaders, modelHeaders)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range passThrough {
+		headers[name] = value
+	}
 
-		// Get the extra headers from the body
-		headers["serial"] = body["serial"]
+	// Get the serial-number from the header, but fallback to the body if it is not there.
+	// The body is attacker-controlled YAML, so the serial may not be a string
+	serial, _ := serialHeaders["serial"].(string)
+	if len(serial) == 0 {
+		serial, _ = body["serial"].(string)
 	}
 
 	// Check that we have a serial
-	if headers["serial"] == nil {
+	if len(serial) == 0 {
 		log.Message("SIGN", "create-assertion", response.ErrorEmptySerial.Message)
 		return nil, errors.New(response.ErrorEmptySerial.Message)
 	}
 
-	// Check that we have not already signed this device, and get the max. revision number for the serial number
-	signingLog.SerialNumber = headers["serial"].(string)
-	duplicateExists, maxRevision, err := datastore.Environ.DB.CheckForDuplicate(signingLog)
+	// Strip, normalize and validate the serial number before it is signed
+	serial, err = applySerialPipeline(pipeline, serial)
+	if err != nil {
+		return nil, err
+	}
+	headers["serial"] = serial
+
+	// Check whether we have already signed this device, and get the revision number for the serial number
+	signingLog.SerialNumber = serial
+	revision, duplicateExists, err := checkDuplicate(db, duplicatePolicy, signingLog)
+	if _, ok := err.(duplicateError); ok {
+		return nil, err
+	}
 	if err != nil {
 		log.Message("SIGN", "duplicate-assertion", err.Error())
 		return nil, errors.New(response.ErrorDuplicateAssertion.Message)
@@ -252,7 +821,7 @@ func serialRequestToSerial(assertion asserts.Assertion, signingLog *datastore.Si
 	}
 
 	// Set the revision number, incrementing the previously used one
-	signingLog.Revision = maxRevision + 1
+	signingLog.Revision = revision
 	headers["revision"] = fmt.Sprintf("%d", signingLog.Revision)
 
 	// If we have a body, set the body length
@@ -289,3 +858,17 @@ func formatRequestIDResponse(nonce datastore.DeviceNonce, w http.ResponseWriter)
 	}
 	return nil
 }
+
+func formatRequestIDBatchResponse(nonces []datastore.DeviceNonce, w http.ResponseWriter) error {
+	response := RequestIDBatchResponse{Success: true, RequestIDs: []string{}}
+	for _, n := range nonces {
+		response.RequestIDs = append(response.RequestIDs, n.Nonce)
+	}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Message("REQUESTIDS", "error-form-requestids", err.Error())
+		return err
+	}
+	return nil
+}
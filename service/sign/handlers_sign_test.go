@@ -22,18 +22,24 @@ package sign_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/crypt"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/breaker"
+	"github.com/CanonicalLtd/serial-vault/service/failover"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/sign"
 	"github.com/snapcore/snapd/asserts"
 	check "gopkg.in/check.v1"
 )
@@ -56,9 +62,12 @@ var _ = check.Suite(&SignSuite{})
 
 func (s *SignSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
+
+	// The failing datastore mock must not open the circuit breaker for the other tests
+	breaker.Datastore.Success()
 }
 
 func sendRequest(method, url string, data io.Reader, apiKey string, c *check.C) *httptest.ResponseRecorder {
@@ -66,7 +75,7 @@ func sendRequest(method, url string, data io.Reader, apiKey string, c *check.C)
 	r, _ := http.NewRequest(method, url, data)
 	r.Header.Set("api-key", apiKey)
 
-	service.SigningRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -95,18 +104,28 @@ func (s *SignSuite) TestSerial(c *check.C) {
 	c.Assert(err, check.IsNil)
 	assertDuplicate, err := generateSerialRequestAssertion("alder", "Aduplicate", "")
 	c.Assert(err, check.IsNil)
+	assertStation, err := generateSerialRequestAssertion("alder", "A123456L", "station: line-1")
+	c.Assert(err, check.IsNil)
+	assertBadStation, err := generateSerialRequestAssertion("alder", "A123456L", "station: unregistered")
+	c.Assert(err, check.IsNil)
 	assertSigningLogError, err := generateSerialRequestAssertion("alder", "AsigninglogError", "")
 	c.Assert(err, check.IsNil)
+	assertSerialNotString, err := generateSerialRequestAssertion("alder", "", "serial: 123456")
+	c.Assert(err, check.IsNil)
 
 	tests := []SuiteTest{
 		{false, "POST", "/v1/serial", assert, 200, asserts.MediaType, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertSerialInBody, 200, asserts.MediaType, "ValidAPIKey"},
+		{false, "POST", "/v1/serial", assertStation, 200, asserts.MediaType, "ValidAPIKey"},
+		{false, "POST", "/v1/serial", assertBadStation, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertSPlusM, 200, asserts.MediaType, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertSPlusBad, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertSPlusMPlusBad, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertSPlusMPlusExtra, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertSPlusMPlusWrong, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertNoSerial, 400, response.JSONHeader, "ValidAPIKey"},
+		{false, "POST", "/v1/serial", assertSerialNotString, 400, response.JSONHeader, "ValidAPIKey"},
+		{false, "POST", "/v1/serial", bytes.Repeat([]byte("a"), 512*1024), 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertFakeModel, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assert, 400, response.JSONHeader, "NoModelForApiKey"},
 		{false, "POST", "/v1/serial", assertSigningLogError, 400, response.JSONHeader, "ValidAPIKey"},
@@ -133,10 +152,99 @@ func (s *SignSuite) TestSerial(c *check.C) {
 	}
 }
 
+func (s *SignSuite) TestSerialModelErrors(c *check.C) {
+	assertModel, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+	assertPivoted, err := generateSerialRequestAssertion("alder-mybrand", "abc1234", "")
+	c.Assert(err, check.IsNil)
+	assertFakeModel, err := generateSerialRequestAssertion("invalid", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		data   []byte
+		apiKey string
+		code   string
+	}{
+		{assertModel, "NoModelForApiKey", response.ErrorInvalidAPIKey.Code},
+		{assertPivoted, "ValidAPIKey", response.ErrorSubstoreAPIKey.Code},
+		{assertFakeModel, "ValidAPIKey", response.ErrorInvalidModelSubstore.Code},
+	}
+
+	for _, t := range tests {
+		w := sendRequest("POST", "/v1/serial", bytes.NewReader(t.data), t.apiKey, c)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, t.code)
+	}
+}
+
+func (s *SignSuite) TestSerialSigner(c *check.C) {
+	datastore.Environ.Config.InstanceID = "vault-1"
+	datastore.Environ.Config.Version = "2.4-6"
+
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	w := sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get(sign.SignerHeader), check.Matches, "keypair-id=[0-9]+; key-id=.+; backend=filesystem; instance=vault-1; version=2.4-6")
+}
+
+func (s *SignSuite) TestSerialEnvelope(c *check.C) {
+	tests := []struct {
+		Accept   string
+		Envelope bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=UTF-8", true},
+		{"application/json, " + asserts.MediaType, false},
+		{"*/*", false},
+		{"", false},
+	}
+
+	for _, t := range tests {
+		assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+		c.Assert(err, check.IsNil)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/serial", bytes.NewReader(assert))
+		r.Header.Set("api-key", "ValidAPIKey")
+		r.Header.Set("Accept", t.Accept)
+		service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, 200)
+
+		if !t.Envelope {
+			c.Assert(w.Header().Get("Content-Type"), check.Equals, asserts.MediaType)
+			continue
+		}
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, response.JSONHeader)
+
+		result := sign.SerialEnvelope{}
+		err = json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Status, check.Equals, sign.StatusSigned)
+		c.Assert(result.Metadata.MediaType, check.Equals, asserts.MediaType)
+		c.Assert(result.Metadata.Serial, check.Equals, "A123456L")
+		c.Assert(result.Metadata.Model, check.Equals, "alder")
+		c.Assert(result.Metadata.Signer, check.NotNil)
+
+		// The envelope holds the encoded serial assertion
+		content, err := base64.StdEncoding.DecodeString(result.Assertion)
+		c.Assert(err, check.IsNil)
+		serial, err := asserts.Decode(content)
+		c.Assert(err, check.IsNil)
+		c.Assert(serial.Type(), check.Equals, asserts.SerialType)
+		c.Assert(serial.HeaderString("serial"), check.Equals, result.Metadata.Serial)
+	}
+}
+
 func (s *SignSuite) TestRequestIDHandler(c *check.C) {
 	tests := []SuiteTest{
 		{false, "POST", "/v1/request-id", nil, 200, response.JSONHeader, "InbuiltAPIKey"},
 		{false, "POST", "/v1/request-id", nil, 400, response.JSONHeader, "InvalidAPIKey"},
+		{false, "POST", "/v1/request-id", nil, 429, response.JSONHeader, "ExhaustedAPIKey"},
 		{true, "POST", "/v1/request-id", nil, 400, response.JSONHeader, "InbuiltAPIKey"},
 	}
 
@@ -153,6 +261,109 @@ func (s *SignSuite) TestRequestIDHandler(c *check.C) {
 	}
 }
 
+func (s *SignSuite) TestRequestIDBan(c *check.C) {
+	datastore.Environ.Config.NonceRateLimit = 25
+
+	tests := []struct {
+		URL        string
+		Data       []byte
+		APIKey     string
+		Code       int
+		RetryAfter string
+	}{
+		{"/v1/request-ids", []byte(`{"count": 20}`), "GreedyAPIKey", 200, ""},
+		{"/v1/request-id", nil, "GreedyAPIKey", 200, ""},
+		{"/v1/request-ids", []byte(`{"count": 20}`), "GreedyAPIKey", 429, "600"},
+		{"/v1/request-id", nil, "GreedyAPIKey", 429, "600"},
+		{"/v1/request-id", nil, "ModestAPIKey", 200, ""},
+	}
+
+	for _, t := range tests {
+		w := sendRequest("POST", t.URL, bytes.NewReader(t.Data), t.APIKey, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Retry-After"), check.Equals, t.RetryAfter)
+	}
+}
+
+func (s *SignSuite) TestRequestIDMaintenance(c *check.C) {
+	now := time.Now().UTC()
+	datastore.Environ.Config.MaintenanceWindows = []config.MaintenanceWindow{
+		{Start: now.Add(-time.Minute).Format(time.RFC3339), End: now.Add(10 * time.Minute).Format(time.RFC3339), Reason: "Key ceremony"},
+	}
+
+	for _, url := range []string{"/v1/request-id", "/v1/request-ids"} {
+		w := sendRequest("POST", url, bytes.NewReader([]byte(`{"count": 2}`)), "InbuiltAPIKey", c)
+		c.Assert(w.Code, check.Equals, http.StatusServiceUnavailable)
+		c.Assert(w.Header().Get("Retry-After"), check.Not(check.Equals), "")
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, response.ErrorMaintenance.Code)
+		c.Assert(result.ErrorMessage, check.Matches, ".*Key ceremony.*")
+	}
+
+	// The window of a model does not pause the nonces
+	datastore.Environ.Config.MaintenanceWindows[0].Brand = "system"
+	w := sendRequest("POST", "/v1/request-id", nil, "InbuiltAPIKey", c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+}
+
+func (s *SignSuite) TestRequestIDStandby(c *check.C) {
+	datastore.Environ.Config.Failover = true
+	defer func() { datastore.Environ.Config.Failover = false }()
+
+	// The standby vault does not sign
+	for _, url := range []string{"/v1/request-id", "/v1/request-ids"} {
+		w := sendRequest("POST", url, bytes.NewReader([]byte(`{"count": 2}`)), "InbuiltAPIKey", c)
+		c.Assert(w.Code, check.Equals, http.StatusServiceUnavailable)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, response.ErrorStandby.Code)
+	}
+
+	// The vault signs once it is promoted
+	promoted, err := failover.Signing.Elect(context.Background(), datastore.Environ.DB)
+	c.Assert(err, check.IsNil)
+	c.Assert(promoted, check.Equals, true)
+	defer failover.Signing.Resign()
+
+	w := sendRequest("POST", "/v1/request-id", nil, "InbuiltAPIKey", c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+}
+
+func (s *SignSuite) TestRequestIDBatchHandler(c *check.C) {
+	tests := []SuiteTest{
+		{false, "POST", "/v1/request-ids", []byte(`{"count": 20}`), 200, response.JSONHeader, "InbuiltAPIKey"},
+		{false, "POST", "/v1/request-ids", []byte(`{"count": 20}`), 400, response.JSONHeader, "InvalidAPIKey"},
+		{false, "POST", "/v1/request-ids", []byte(`{"count": 0}`), 400, response.JSONHeader, "InbuiltAPIKey"},
+		{false, "POST", "/v1/request-ids", []byte(`{"count": 1000}`), 400, response.JSONHeader, "InbuiltAPIKey"},
+		{false, "POST", "/v1/request-ids", []byte(`{"count": 20}`), 429, response.JSONHeader, "ExhaustedAPIKey"},
+		{false, "POST", "/v1/request-ids", []byte(`invalid`), 400, response.JSONHeader, "InbuiltAPIKey"},
+		{false, "POST", "/v1/request-ids", nil, 400, response.JSONHeader, "InbuiltAPIKey"},
+		{true, "POST", "/v1/request-ids", []byte(`{"count": 20}`), 400, response.JSONHeader, "InbuiltAPIKey"},
+	}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.APIKey, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		if t.Code == 200 {
+			result := sign.RequestIDBatchResponse{}
+			err := json.NewDecoder(w.Body).Decode(&result)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.RequestIDs, check.HasLen, 20)
+		}
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
 func generatePrivateKey() (asserts.PrivateKey, error) {
 	signingKey, err := ioutil.ReadFile("../../keystore/TestDeviceKey.asc")
 	if err != nil {
@@ -316,7 +527,7 @@ openpgp PvKbwRkU3v5LNmFZJYsjAV3TqhFBUp61AHpr5pvTMw3fJ8j3h
 
 func (s *SignSuite) TestSignHandlerErrorKeyStore(c *check.C) {
 	// Mock the database and the keystore
-	settings := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	settings := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: settings}
 	datastore.Environ.KeypairDB, _ = datastore.GetErrorMockKeyStore(settings)
 
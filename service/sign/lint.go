@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// Policies of the linting of the signed serial assertions
+const (
+	lintOff   = "off"
+	lintLog   = "log"
+	lintBlock = "block"
+)
+
+// Rules of the content policy of the signed serial assertions
+const (
+	lintRequiredHeaders = "required-headers"
+	lintAuthorityBrand  = "authority-brand"
+	lintTimestamp       = "timestamp"
+	lintKeyID           = "key-id"
+)
+
+// lintClockSkew is the tolerance for the timestamp of a serial assertion that is the signing time
+const lintClockSkew = 5 * time.Minute
+
+// serialRequiredHeaders are the headers that a serial assertion must have
+var serialRequiredHeaders = []string{"authority-id", "brand-id", "model", "serial", "device-key", "sign-key-sha3-384", "timestamp"}
+
+// lintPolicy returns the policy of the linting from the config
+func lintPolicy(settings config.Settings) string {
+	switch settings.SerialLint {
+	case lintOff, lintBlock:
+		return settings.SerialLint
+	default:
+		return lintLog
+	}
+}
+
+// lintRuleEnabled checks if the config checks the rule
+func lintRuleEnabled(settings config.Settings, rule string) bool {
+	if len(settings.SerialLintRules) == 0 {
+		return true
+	}
+	for _, r := range settings.SerialLintRules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// lintSerial checks the headers of a signed serial assertion against the content policy, so
+// a misconfigured model is caught before the devices receive bad assertions. The keypair is
+// the signing-key that signed the assertion, and the violations of the enabled rules are returned
+func lintSerial(settings config.Settings, headers map[string]interface{}, signKeyID string, model datastore.Model, keypair datastore.Keypair, now time.Time) []string {
+	violations := []string{}
+	header := func(name string) string {
+		value, _ := headers[name].(string)
+		return value
+	}
+
+	if lintRuleEnabled(settings, lintRequiredHeaders) {
+		for _, name := range serialRequiredHeaders {
+			if len(header(name)) == 0 {
+				violations = append(violations, fmt.Sprintf("%s: the %s header is missing", lintRequiredHeaders, name))
+			}
+		}
+	}
+
+	if lintRuleEnabled(settings, lintAuthorityBrand) && header("authority-id") != header("brand-id") {
+		violations = append(violations, fmt.Sprintf("%s: the authority-id %s is not the brand %s", lintAuthorityBrand, header("authority-id"), header("brand-id")))
+	}
+
+	if lintRuleEnabled(settings, lintTimestamp) {
+		if violation := lintSerialTimestamp(header("timestamp"), model.TimestampPolicy, now); len(violation) > 0 {
+			violations = append(violations, fmt.Sprintf("%s: %s", lintTimestamp, violation))
+		}
+	}
+
+	if lintRuleEnabled(settings, lintKeyID) {
+		if signKeyID != keypair.KeyID {
+			violations = append(violations, fmt.Sprintf("%s: the assertion is signed by %s, not the signing-key %s", lintKeyID, signKeyID, keypair.KeyID))
+		}
+		if header("brand-id") != model.BrandID || header("model") != model.Name {
+			violations = append(violations, fmt.Sprintf("%s: the assertion is for %s/%s, not the model %s/%s", lintKeyID, header("brand-id"), header("model"), model.BrandID, model.Name))
+		}
+	}
+
+	return violations
+}
+
+// lintSerialTimestamp checks the timestamp of a serial assertion is the signing time or, when
+// the timestamp policy of the model accepts the manufacture date, that it is within its bounds
+func lintSerialTimestamp(value string, policy datastore.TimestampPolicy, now time.Time) string {
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Sprintf("the timestamp %q is invalid", value)
+	}
+
+	earliest := now.Add(-lintClockSkew)
+	if policy.ManufactureDate {
+		earliest = now.AddDate(0, 0, -policy.MaxAge).Add(-lintClockSkew)
+	}
+	latest := now.Add(lintClockSkew + time.Duration(policy.MaxFuture)*time.Minute)
+
+	if timestamp.Before(earliest) || timestamp.After(latest) {
+		return fmt.Sprintf("the timestamp %s is out of bounds of the signing time", value)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestLintSerial(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	model := datastore.Model{BrandID: "system", Name: "alder", KeyID: "model-key"}
+	keypair := datastore.Keypair{KeyID: "model-key"}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"authority-id": "system", "brand-id": "system", "model": "alder", "serial": "A1234",
+			"device-key": "device-key", "sign-key-sha3-384": "model-key", "timestamp": now.Format(time.RFC3339),
+		}
+	}
+
+	tests := []struct {
+		change     func(h map[string]interface{})
+		model      datastore.Model
+		signKeyID  string
+		rules      []string
+		violations []string
+	}{
+		{func(h map[string]interface{}) {}, model, "model-key", nil, nil},
+		{func(h map[string]interface{}) { delete(h, "serial") }, model, "model-key", nil, []string{"required-headers"}},
+		{func(h map[string]interface{}) { h["authority-id"] = "reseller" }, model, "model-key", nil, []string{"authority-brand"}},
+		{func(h map[string]interface{}) { h["authority-id"] = "reseller" }, model, "model-key", []string{"timestamp", "key-id"}, nil},
+		{func(h map[string]interface{}) { h["timestamp"] = now.Add(-time.Hour).Format(time.RFC3339) }, model, "model-key", nil, []string{"timestamp"}},
+		{func(h map[string]interface{}) { h["timestamp"] = now.Add(-time.Hour).Format(time.RFC3339) },
+			datastore.Model{BrandID: "system", Name: "alder", TimestampPolicy: datastore.TimestampPolicy{ManufactureDate: true, MaxAge: 30}}, "model-key", nil, nil},
+		{func(h map[string]interface{}) { h["timestamp"] = now.Add(time.Hour).Format(time.RFC3339) }, model, "model-key", nil, []string{"timestamp"}},
+		{func(h map[string]interface{}) { h["timestamp"] = "yesterday" }, model, "model-key", nil, []string{"timestamp"}},
+		{func(h map[string]interface{}) {}, model, "other-key", nil, []string{"key-id"}},
+		{func(h map[string]interface{}) { h["model"] = "ash" }, model, "model-key", nil, []string{"key-id"}},
+	}
+
+	for i, tt := range tests {
+		headers := valid()
+		tt.change(headers)
+
+		violations := lintSerial(config.Settings{SerialLintRules: tt.rules}, headers, tt.signKeyID, tt.model, keypair, now)
+		if len(violations) != len(tt.violations) {
+			t.Errorf("Test %d: expected %d violations, got %v", i, len(tt.violations), violations)
+			continue
+		}
+		for j, v := range violations {
+			if !strings.HasPrefix(v, tt.violations[j]+":") {
+				t.Errorf("Test %d: expected a %s violation, got %s", i, tt.violations[j], v)
+			}
+		}
+	}
+}
+
+func TestLintPolicy(t *testing.T) {
+	tests := map[string]string{"": lintLog, "log": lintLog, "block": lintBlock, "off": lintOff, "invalid": lintLog}
+	for value, expected := range tests {
+		if policy := lintPolicy(config.Settings{SerialLint: value}); policy != expected {
+			t.Errorf("Policy %q: expected %s, got %s", value, expected, policy)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/snapcore/snapd/asserts"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// requestManifest gets the device manifest from the manifest field of the serial-request
+// body: the installed snap revisions, the image version and the hardware components. A
+// serial-request without a manifest returns nil
+func requestManifest(content []byte, body map[string]interface{}) (*datastore.DeviceManifest, error) {
+	if body["manifest"] == nil {
+		return nil, nil
+	}
+
+	request := struct {
+		Manifest datastore.DeviceManifest `yaml:"manifest"`
+	}{}
+	if err := yaml.Unmarshal(content, &request); err != nil {
+		return nil, fmt.Errorf("The manifest of the serial-request is malformed: %v", err)
+	}
+	return &request.Manifest, nil
+}
+
+// serialManifest gets the device manifest of the serial-request, for the brand, model
+// and normalized serial number of the signing log
+func serialManifest(assertion asserts.Assertion, body map[string]interface{}, signingLog datastore.SigningLog) (*datastore.DeviceManifest, error) {
+	manifest, err := requestManifest(assertion.Body(), body)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+
+	manifest.Brand = signingLog.Make
+	manifest.Model = signingLog.Model
+	manifest.SerialNumber = signingLog.SerialNumber
+	return manifest, datastore.ValidateDeviceManifest(*manifest)
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+const manifestBody = `serial: A1234
+manifest:
+  image-version: "20260901"
+  snaps:
+    - name: pc-kernel
+      revision: 1234
+    - name: my-app
+      revision: x1
+  components:
+    - name: wifi
+      vendor: Acme
+      version: "1.2"
+`
+
+func TestRequestManifest(t *testing.T) {
+	tests := []struct {
+		body      string
+		snaps     int
+		hasResult bool
+		valid     bool
+	}{
+		{"", 0, false, true},
+		{"serial: A1234\n", 0, false, true},
+		{manifestBody, 2, true, true},
+		{"manifest:\n  snaps: pc-kernel\n", 0, false, false},
+		{"manifest:\n  image-version: \"20260901\"\n", 0, true, true},
+	}
+
+	for _, tt := range tests {
+		manifest, err := requestManifest([]byte(tt.body), decodeRequestBody([]byte(tt.body)))
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got error %v", tt.body, tt.valid, err)
+		}
+		if (manifest != nil) != tt.hasResult {
+			t.Errorf("%q: expected a manifest %v, got %v", tt.body, tt.hasResult, manifest)
+			continue
+		}
+		if manifest != nil && len(manifest.Snaps) != tt.snaps {
+			t.Errorf("%q: expected %d snaps, got %d", tt.body, tt.snaps, len(manifest.Snaps))
+		}
+	}
+
+	manifest, err := requestManifest([]byte(manifestBody), decodeRequestBody([]byte(manifestBody)))
+	if err != nil {
+		t.Fatalf("Error decoding the manifest: %v", err)
+	}
+	if manifest.ImageVersion != "20260901" || manifest.Snaps[0].Revision != "1234" || manifest.Snaps[1].Revision != "x1" || manifest.Components[0].Vendor != "Acme" {
+		t.Errorf("Unexpected manifest: %v", manifest)
+	}
+	if err := datastore.ValidateDeviceManifest(*manifest); err == nil {
+		t.Error("Expected an error for a manifest without the serial number")
+	}
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Default limits of the nonce issuance for an API key
+const (
+	defaultNonceRateLimit   = 600 // nonces per minute
+	defaultNonceBanDuration = 10 * time.Minute
+)
+
+// nonceRateWindow is the period that the issued nonces are counted over
+const nonceRateWindow = time.Minute
+
+// nonceIssuance tracks the nonces requested with an API key in the current window
+type nonceIssuance struct {
+	windowStart time.Time
+	count       int
+	bannedUntil time.Time
+}
+
+// nonceGuard protects the nonce table from clients that request nonces in a loop. An
+// API key that exceeds the issuance rate is banned from requesting nonces for a while.
+// The issuance is tracked in the memory of the instance, so the rate and the bans are
+// per instance: they are lost on a restart, and the limit of a deployment with several
+// signing instances is the limit of an instance times the number of instances
+type nonceGuard struct {
+	lock   sync.Mutex
+	issued map[string]*nonceIssuance
+	now    func() time.Time
+}
+
+var issuance = &nonceGuard{issued: map[string]*nonceIssuance{}, now: time.Now}
+
+func nonceLimits(settings config.Settings) (int, time.Duration) {
+	limit, ban := defaultNonceRateLimit, defaultNonceBanDuration
+	if settings.NonceRateLimit > 0 {
+		limit = settings.NonceRateLimit
+	}
+	if settings.NonceBanDuration > 0 {
+		ban = time.Duration(settings.NonceBanDuration) * time.Second
+	}
+	return limit, ban
+}
+
+// request records a request for nonces with the API key. When the API key is banned,
+// the time until the ban is lifted is returned, and if the request started the ban
+func (g *nonceGuard) request(apiKey string, count int, settings config.Settings) (bool, time.Duration, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := g.now()
+	limit, ban := nonceLimits(settings)
+
+	issued, ok := g.issued[apiKey]
+	if !ok {
+		issued = &nonceIssuance{windowStart: now}
+		g.issued[apiKey] = issued
+	}
+
+	if now.Before(issued.bannedUntil) {
+		return false, issued.bannedUntil.Sub(now), false
+	}
+
+	if now.Sub(issued.windowStart) >= nonceRateWindow {
+		issued.windowStart = now
+		issued.count = 0
+	}
+
+	issued.count += count
+	if issued.count > limit {
+		issued.bannedUntil = now.Add(ban)
+		issued.count = 0
+
+		metrics.Increment(metrics.NonceBans)
+		log.Message("REQUESTID", "nonce-ban", fmt.Sprintf("The API key %s exceeded %d nonces per minute and is banned until %s", maskAPIKey(apiKey), limit, issued.bannedUntil.Format(time.RFC3339)))
+		return false, ban, true
+	}
+
+	return true, 0, false
+}
+
+// maskAPIKey keeps the API key out of the logs and the audit log. A quarter of the key, and
+// no more than 8 characters, is shown to identify the model
+func maskAPIKey(apiKey string) string {
+	shown := len(apiKey) / 4
+	if shown > 8 {
+		shown = 8
+	}
+	return apiKey[:shown] + "..."
+}
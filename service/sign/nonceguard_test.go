@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+)
+
+func TestMaskAPIKey(t *testing.T) {
+	tests := []struct {
+		apiKey string
+		masked string
+	}{
+		{"", "..."},
+		{"abc", "..."},
+		{"abcdefgh", "ab..."},
+		{"abcdefghijklmnop", "abcd..."},
+		{strings.Repeat("a", 64), "aaaaaaaa..."},
+	}
+
+	for _, tt := range tests {
+		if masked := maskAPIKey(tt.apiKey); masked != tt.masked {
+			t.Errorf("%s: expected `%s`, got `%s`", tt.apiKey, tt.masked, masked)
+		}
+	}
+}
+
+func TestNonceBanAudit(t *testing.T) {
+	db := datastoretest.New()
+	srv := &Service{&datastore.Env{DB: db, Config: config.Settings{NonceRateLimit: 2}}}
+
+	for i, allowed := range []bool{true, true, false, false} {
+		w := httptest.NewRecorder()
+		if srv.nonceIssuanceAllowed(w, "BannedAuditKey", 1) != allowed {
+			t.Errorf("%d: expected allowed %v", i, allowed)
+		}
+		if !allowed && len(w.Header().Get("Retry-After")) == 0 {
+			t.Errorf("%d: expected the time to retry", i)
+		}
+	}
+
+	// The ban is audited once, without the API key
+	entries, err := db.ListAuditLog("")
+	if err != nil {
+		t.Fatalf("Error listing the audit log: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected one audit log entry, got: %v", entries)
+	}
+	if entries[0].Action != datastore.AuditNonceBan || entries[0].Username != "Ban..." || entries[0].Status != http.StatusTooManyRequests {
+		t.Errorf("Unexpected audit log entry: %v", entries[0])
+	}
+}
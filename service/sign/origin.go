@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+)
+
+// The privacy controls of the origin of the signing requests
+const (
+	originNone      = "none"
+	originTruncated = "truncated"
+)
+
+// requestOrigin gets the origin of the signing request that is stored in the signing log:
+// the client IP, its country and the identity of the TLS client certificate, as allowed by
+// the privacy controls of the config. A request without any origin returns nil
+func (srv *Service) requestOrigin(r *http.Request) *datastore.SigningOrigin {
+	origin := datastore.SigningOrigin{}
+
+	clientIP := request.ClientIP(r, srv.Config)
+	if srv.GeoIP != nil {
+		origin.Country = srv.GeoIP.Country(clientIP)
+	}
+
+	switch srv.Config.SigningLogClientIP {
+	case originNone:
+	case originTruncated:
+		origin.ClientIP = truncateIP(clientIP)
+	default:
+		origin.ClientIP = clientIP
+	}
+
+	if srv.Config.SigningLogTLSIdentity != originNone {
+		origin.TLSIdentity = tlsIdentity(r, srv.Config)
+	}
+
+	if origin == (datastore.SigningOrigin{}) {
+		return nil
+	}
+	return &origin
+}
+
+// truncateIP removes the host from the IP address, keeping its /24 network for IPv4 and
+// its /48 network for IPv6. An invalid address is not stored
+func truncateIP(ip string) string {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return ""
+	}
+	if v4 := address.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: address.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// tlsIdentity gets the subject of the TLS client certificate, from the connection or from
+// the header of the trusted proxy that terminated the TLS connection
+func tlsIdentity(r *http.Request, settings config.Settings) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.String()
+	}
+	return request.ClientCertSubject(r, settings)
+}
@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/geoip"
+)
+
+func TestRequestOrigin(t *testing.T) {
+	db, err := geoip.Parse(strings.NewReader("81.2.69.0/24,GB\n"))
+	if err != nil {
+		t.Fatalf("Error parsing the GeoIP database: %v", err)
+	}
+
+	tests := []struct {
+		settings config.Settings
+		geoIP    bool
+		origin   *datastore.SigningOrigin
+	}{
+		{config.Settings{}, false, &datastore.SigningOrigin{ClientIP: "81.2.69.160"}},
+		{config.Settings{}, true, &datastore.SigningOrigin{ClientIP: "81.2.69.160", Country: "GB"}},
+		{config.Settings{SigningLogClientIP: "truncated"}, true, &datastore.SigningOrigin{ClientIP: "81.2.69.0/24", Country: "GB"}},
+		{config.Settings{SigningLogClientIP: "none"}, true, &datastore.SigningOrigin{Country: "GB"}},
+		{config.Settings{SigningLogClientIP: "none"}, false, nil},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"81.2.69.0/24"}}, false, &datastore.SigningOrigin{ClientIP: "81.2.69.160", TLSIdentity: "CN=line-1,O=Factory"}},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN", TrustedProxies: []string{"81.2.69.0/24"}, SigningLogTLSIdentity: "none"}, false, &datastore.SigningOrigin{ClientIP: "81.2.69.160"}},
+		{config.Settings{ClientCertHeader: "X-SSL-Client-S-DN"}, false, &datastore.SigningOrigin{ClientIP: "81.2.69.160"}},
+	}
+
+	for i, tt := range tests {
+		srv := &Service{Env: &datastore.Env{Config: tt.settings}}
+		if tt.geoIP {
+			srv.GeoIP = db
+		}
+
+		r := httptest.NewRequest("POST", "/v1/serial", nil)
+		r.RemoteAddr = "81.2.69.160:51234"
+		r.Header.Set("X-SSL-Client-S-DN", "CN=line-1,O=Factory")
+
+		origin := srv.requestOrigin(r)
+		if (origin == nil) != (tt.origin == nil) || (origin != nil && *origin != *tt.origin) {
+			t.Errorf("%d: expected origin %v, got %v", i, tt.origin, origin)
+		}
+	}
+}
+
+func TestTruncateIP(t *testing.T) {
+	tests := map[string]string{
+		"81.2.69.160":        "81.2.69.0/24",
+		"2001:db8:1:2:3::1":  "2001:db8:1::/48",
+		"::ffff:81.2.69.160": "81.2.69.0/24",
+		"invalid":            "",
+	}
+
+	for ip, expected := range tests {
+		if truncated := truncateIP(ip); truncated != expected {
+			t.Errorf("%s: expected '%s', got '%s'", ip, expected, truncated)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
+)
+
+// Failure policies of the policy hook, when the policy engine cannot decide a request
+const (
+	policyFailureDeny  = "deny"
+	policyFailureAllow = "allow"
+)
+
+// defaultPolicyTimeout is the limit for a decision of the policy engine
+const defaultPolicyTimeout = 2 * time.Second
+
+// policyInput is the context of a signing request that the policy engine decides on, as the
+// input document of the data API of an Open Policy Agent
+type policyInput struct {
+	BrandID      string            `json:"brand-id"`
+	Model        string            `json:"model"`
+	SerialNumber string            `json:"serial"`
+	DeviceKey    string            `json:"device-key-sha3-384"`
+	Station      string            `json:"station,omitempty"`
+	APIKey       policyAPIKey      `json:"api-key"`
+	Pivot        *policyPivot      `json:"pivot,omitempty"`
+	Time         time.Time         `json:"time"`
+	SourceIP     string            `json:"source-ip"`
+	Details      map[string]string `json:"details,omitempty"`
+}
+
+// policyAPIKey is the metadata of the API key of the request: the model that it belongs to and
+// the digest of the key, which is never sent
+type policyAPIKey struct {
+	SHA256  string `json:"sha256"`
+	ModelID int    `json:"model-id"`
+	BrandID string `json:"brand-id"`
+	Model   string `json:"model"`
+}
+
+// policyPivot is the sub-store that a pivoted device was pivoted to
+type policyPivot struct {
+	Store string `json:"store"`
+	Model string `json:"model"`
+}
+
+// policyDecision is the result of the policy engine: a boolean, or an object with the decision
+// and the reason of a denial
+type policyDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// policyDenial is the error for a signing request that the policy engine denied
+type policyDenial struct {
+	reason string
+}
+
+func (e policyDenial) Error() string {
+	if len(e.reason) == 0 {
+		return "The signing request is denied by the policy of the brand"
+	}
+	return e.reason
+}
+
+// newPolicyInput builds the context of the signing request for the policy engine. The source IP
+// is the full client IP, whatever the origin that is stored in the signing log
+func newPolicyInput(apiKey, sourceIP string, resolution validation.Resolution, signingLog datastore.SigningLog) policyInput {
+	digest := sha256.Sum256([]byte(apiKey))
+	input := policyInput{
+		BrandID:      signingLog.Make,
+		Model:        signingLog.Model,
+		SerialNumber: signingLog.SerialNumber,
+		DeviceKey:    signingLog.Fingerprint,
+		Station:      signingLog.Station,
+		APIKey:       policyAPIKey{SHA256: hex.EncodeToString(digest[:]), ModelID: resolution.Model.ID, BrandID: resolution.Model.BrandID, Model: resolution.Model.Name},
+		Time:         time.Now().UTC(),
+		SourceIP:     sourceIP,
+		Details:      signingLog.Details,
+	}
+	if resolution.Pivoted() {
+		// The API key belongs to the model that the device was pivoted from
+		from := resolution.Substore.FromModel
+		input.APIKey = policyAPIKey{SHA256: input.APIKey.SHA256, ModelID: from.ID, BrandID: from.BrandID, Model: from.Name}
+		input.Pivot = &policyPivot{Store: resolution.Substore.Store, Model: resolution.Substore.ModelName}
+	}
+	return input
+}
+
+// policyAllows checks the signing request with the policy hook, mapping a denial or a failure of
+// the policy engine to the response of the request
+func (srv *Service) policyAllows(r *http.Request, apiKey string, resolution validation.Resolution, signingLog datastore.SigningLog) response.ErrorResponse {
+	input := newPolicyInput(apiKey, request.ClientIP(r, srv.Config), resolution, signingLog)
+	err := checkPolicy(r.Context(), srv.Config.PolicyHook, input)
+	if err == nil {
+		return response.ErrorResponse{Success: true}
+	}
+
+	if denial, ok := err.(policyDenial); ok {
+		metrics.Increment(metrics.PolicyDenied)
+		log.Message("SIGN", response.ErrorPolicyDenied.Code, fmt.Sprintf("Serial number %s of %s/%s: %s", input.SerialNumber, input.BrandID, input.Model, denial.Error()))
+		return response.ErrorResponse{Success: false, Code: response.ErrorPolicyDenied.Code, Message: denial.Error(), StatusCode: response.ErrorPolicyDenied.StatusCode}
+	}
+	metrics.Increment(metrics.PolicyErrors)
+	log.Message("SIGN", response.ErrorPolicyUnavailable.Code, err.Error())
+	return response.ErrorPolicyUnavailable
+}
+
+// checkPolicy asks the policy engine to decide the signing request. The brands without the hook
+// are allowed. A failure of the engine is returned as an error, unless the failure policy allows
+// the request
+func checkPolicy(ctx context.Context, hook config.PolicyHook, input policyInput) error {
+	if len(hook.URL) == 0 || !policyBrand(hook, input.BrandID) {
+		return nil
+	}
+
+	err := queryPolicy(ctx, hook, input)
+	if _, ok := err.(policyDenial); ok || err == nil {
+		return err
+	}
+	if hook.FailurePolicy == policyFailureAllow {
+		return nil
+	}
+	return err
+}
+
+func policyBrand(hook config.PolicyHook, brandID string) bool {
+	if len(hook.Brands) == 0 {
+		return true
+	}
+	for _, b := range hook.Brands {
+		if b == brandID {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPolicy sends the input to the data API of the policy engine, and decodes its decision
+func queryPolicy(ctx context.Context, hook config.PolicyHook, input policyInput) error {
+	timeout := defaultPolicyTimeout
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(struct {
+		Input policyInput `json:"input"`
+	}{input})
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	if len(hook.Authorization) > 0 {
+		r.Header.Set("Authorization", hook.Authorization)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the policy engine returned %s", resp.Status)
+	}
+
+	result := struct {
+		Result json.RawMessage `json:"result"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	decision, err := decodePolicyDecision(result.Result)
+	if err != nil {
+		return err
+	}
+	if !decision.Allow {
+		return policyDenial{reason: decision.Reason}
+	}
+	return nil
+}
+
+// decodePolicyDecision decodes the result of the policy engine. An undefined result, when the
+// policy has no rule for the request, is an error so the failure policy applies
+func decodePolicyDecision(result json.RawMessage) (policyDecision, error) {
+	if len(result) == 0 {
+		return policyDecision{}, errors.New("the policy engine returned an undefined decision")
+	}
+
+	var allow bool
+	if err := json.Unmarshal(result, &allow); err == nil {
+		return policyDecision{Allow: allow}, nil
+	}
+
+	decision := policyDecision{}
+	if err := json.Unmarshal(result, &decision); err != nil {
+		return decision, fmt.Errorf("the policy engine returned an invalid decision: %v", err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,162 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/validation"
+)
+
+// policyEngine is a fake data API of a policy engine, which returns the result and records the input
+func policyEngine(t *testing.T, status int, result string, inputs *[]policyInput) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			Input policyInput `json:"input"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid policy input: %v", err)
+		}
+		if inputs != nil {
+			*inputs = append(*inputs, body.Input)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(result))
+	}))
+}
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		status  int
+		result  string
+		failure string
+		allowed bool
+		denied  bool
+	}{
+		{http.StatusOK, `{"result": true}`, "", true, false},
+		{http.StatusOK, `{"result": false}`, "", false, true},
+		{http.StatusOK, `{"result": {"allow": true}}`, "", true, false},
+		{http.StatusOK, `{"result": {"allow": false, "reason": "Serial out of the production run"}}`, "", false, true},
+		{http.StatusOK, `{}`, "", false, false},
+		{http.StatusOK, `{}`, policyFailureAllow, true, false},
+		{http.StatusOK, `{"result": "yes"}`, "", false, false},
+		{http.StatusInternalServerError, `{"result": true}`, "", false, false},
+		{http.StatusInternalServerError, `{"result": true}`, policyFailureAllow, true, false},
+		{http.StatusOK, `{"result": false}`, policyFailureAllow, false, true},
+	}
+
+	for _, tt := range tests {
+		engine := policyEngine(t, tt.status, tt.result, nil)
+		hook := config.PolicyHook{URL: engine.URL, FailurePolicy: tt.failure}
+
+		err := checkPolicy(context.Background(), hook, policyInput{BrandID: "system", Model: "alder", SerialNumber: "A1"})
+		engine.Close()
+
+		if (err == nil) != tt.allowed {
+			t.Errorf("%d %s %s: expected allowed %v, got %v", tt.status, tt.result, tt.failure, tt.allowed, err)
+		}
+		if _, ok := err.(policyDenial); ok != tt.denied {
+			t.Errorf("%d %s %s: expected denied %v, got %v", tt.status, tt.result, tt.failure, tt.denied, err)
+		}
+	}
+}
+
+func TestCheckPolicyReason(t *testing.T) {
+	engine := policyEngine(t, http.StatusOK, `{"result": {"allow": false, "reason": "Serial out of the production run"}}`, nil)
+	defer engine.Close()
+
+	err := checkPolicy(context.Background(), config.PolicyHook{URL: engine.URL}, policyInput{BrandID: "system"})
+	if err == nil || err.Error() != "Serial out of the production run" {
+		t.Errorf("expected the reason of the denial, got %v", err)
+	}
+}
+
+func TestCheckPolicyBrands(t *testing.T) {
+	inputs := []policyInput{}
+	engine := policyEngine(t, http.StatusOK, `{"result": false}`, &inputs)
+	defer engine.Close()
+
+	hook := config.PolicyHook{URL: engine.URL, Brands: []string{"system"}}
+	if err := checkPolicy(context.Background(), hook, policyInput{BrandID: "other"}); err != nil {
+		t.Errorf("expected the brand without the hook to be allowed, got %v", err)
+	}
+	if err := checkPolicy(context.Background(), hook, policyInput{BrandID: "system"}); err == nil {
+		t.Error("expected the brand with the hook to be denied")
+	}
+	if len(inputs) != 1 {
+		t.Errorf("expected one query of the policy engine, got %d", len(inputs))
+	}
+
+	// No hook is configured
+	if err := checkPolicy(context.Background(), config.PolicyHook{}, policyInput{BrandID: "system"}); err != nil {
+		t.Errorf("expected the request to be allowed without a hook, got %v", err)
+	}
+}
+
+func TestCheckPolicyTimeout(t *testing.T) {
+	done := make(chan struct{})
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer engine.Close()
+	defer close(done)
+
+	start := time.Now()
+	err := checkPolicy(context.Background(), config.PolicyHook{URL: engine.URL, Timeout: 1}, policyInput{BrandID: "system"})
+	if err == nil {
+		t.Error("expected an error for the policy engine timeout")
+	}
+	if _, ok := err.(policyDenial); ok {
+		t.Errorf("expected a failure of the policy engine, got a denial: %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("expected the query to time out after a second, took %v", time.Since(start))
+	}
+}
+
+func TestPolicyInput(t *testing.T) {
+	from := datastore.Model{ID: 1, BrandID: "system", Name: "alder"}
+	signingLog := datastore.SigningLog{Make: "system", Model: "alder-mybrand", SerialNumber: "A1", Fingerprint: "fingerprint", Station: "line-1"}
+
+	input := newPolicyInput("secret-api-key", "10.0.0.1", validation.Resolution{Model: from}, signingLog)
+	if input.APIKey.Model != "alder" || input.Pivot != nil || input.SourceIP != "10.0.0.1" || input.DeviceKey != "fingerprint" {
+		t.Errorf("unexpected policy input: %+v", input)
+	}
+
+	substore := datastore.Substore{FromModel: from, Store: "mybrand", ModelName: "alder-mybrand"}
+	input = newPolicyInput("secret-api-key", "10.0.0.1", validation.Resolution{Model: from, Substore: &substore}, signingLog)
+	if input.Pivot == nil || input.Pivot.Store != "mybrand" || input.APIKey.ModelID != 1 {
+		t.Errorf("unexpected policy input of a pivoted device: %+v", input)
+	}
+
+	// The API key itself is never sent to the policy engine
+	data, _ := json.Marshal(input)
+	if strings.Contains(string(data), "secret-api-key") {
+		t.Errorf("the policy input contains the API key: %s", data)
+	}
+}
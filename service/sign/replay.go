@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/breaker"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// serialRequestHash is the hash of the content and signature of the serial-request, which is the
+// same for the retries of a serial-request
+func serialRequestHash(assertion asserts.Assertion) string {
+	sum := sha256.Sum256(asserts.Encode(assertion))
+	return hex.EncodeToString(sum[:])
+}
+
+// replayedSerial returns the serial assertion, with its signing log entry, that was signed for an
+// exact replay of the serial-request with the API key within the replay window. The serial-request
+// is signed again when the serial assertion cannot be retrieved
+func replayedSerial(db datastore.Datastore, settings config.Settings, apiKey, requestHash string) (asserts.Assertion, datastore.SigningLog) {
+	window := datastore.SerialReplayWindow(settings)
+	if window == 0 {
+		return nil, datastore.SigningLog{}
+	}
+
+	replay, err := db.GetSerialReplay(requestHash, apiKey, window)
+	if err != nil {
+		log.Message("SIGN", "serial-replay", err.Error())
+		return nil, datastore.SigningLog{}
+	}
+	if len(replay.Serial) == 0 {
+		return nil, datastore.SigningLog{}
+	}
+
+	assertion, err := asserts.Decode([]byte(replay.Serial))
+	if err != nil {
+		log.Message("SIGN", "serial-replay", err.Error())
+		return nil, datastore.SigningLog{}
+	}
+	return assertion, replay.SigningLog
+}
+
+// replaySerial returns the serial assertion that was signed for a replayed serial-request, with the
+// signer and the serial response fields of its signing. The replay is refused as the serial-request
+// would be: when the signing-key that signed it has been compromised since, or when the device is
+// refused by its lifecycle state, the quarantine list or the policy engine
+func (srv *Service) replaySerial(ctx context.Context, w http.ResponseWriter, r *http.Request, db datastore.Datastore, apiKey string, resolution validation.Resolution, serial asserts.Assertion, signingLog datastore.SigningLog) response.ErrorResponse {
+	if signingLog.Signer != nil {
+		if _, err := db.GetKeypairCompromise(signingLog.Signer.KeypairID); err == nil {
+			log.Message("SIGN", response.ErrorCompromisedModel.Code, fmt.Sprintf("The replayed serial assertion was signed with the compromised key %s", signingLog.Signer.KeyID))
+			return response.ErrorCompromisedModel
+		}
+	}
+
+	if errResponse := srv.deviceAllowed(ctx, r, db, apiKey, resolution, signingLog); !errResponse.Success {
+		return errResponse
+	}
+
+	metrics.Increment(metrics.SerialReplays)
+	log.Message("SIGN", "serial-replay", fmt.Sprintf("Replayed serial-request for the serial number %s of %s/%s",
+		serial.HeaderString("serial"), serial.HeaderString("brand-id"), serial.HeaderString("model")))
+
+	breaker.Datastore.Success()
+	if signingLog.Signer != nil {
+		w.Header().Set(SignerHeader, signingLog.Signer.String())
+	}
+
+	formatSerial(w, r, serial, signingLog, serialResponseFields(resolution.Model, serial, signingLog))
+	return response.ErrorResponse{Success: true}
+}
+
+// recordSerial stores the serial assertion that was signed for the serial-request, with its signing
+// log entry, for the replays when the model allows them. The device is signed, so a failure is only logged
+func recordSerial(db datastore.Datastore, settings config.Settings, model datastore.Model, apiKey, requestHash string, serial asserts.Assertion, signingLog datastore.SigningLog) {
+	if !model.DuplicatePolicy.Replay || datastore.SerialReplayWindow(settings) == 0 {
+		return
+	}
+
+	replay := datastore.SerialReplay{Serial: string(asserts.Encode(serial)), SigningLog: signingLog}
+	if err := db.CreateSerialReplay(requestHash, apiKey, replay); err != nil {
+		log.Message("SIGN", "serial-replay", err.Error())
+	}
+}
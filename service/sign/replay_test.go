@@ -0,0 +1,151 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/validation"
+	"github.com/snapcore/snapd/asserts"
+)
+
+func signedTestAssertion(t *testing.T, serial string) asserts.Assertion {
+	privateKey, err := asserts.GenerateKey()
+	if err != nil {
+		t.Fatalf("error generating the key: %v", err)
+	}
+	encodedPubKey, _ := asserts.EncodePublicKey(privateKey.PublicKey())
+
+	headers := map[string]interface{}{
+		"brand-id":   "system",
+		"model":      "alder",
+		"serial":     serial,
+		"device-key": string(encodedPubKey),
+		"request-id": "REQID",
+	}
+	assertion, err := asserts.SignWithoutAuthority(asserts.SerialRequestType, headers, nil, privateKey)
+	if err != nil {
+		t.Fatalf("error signing the assertion: %v", err)
+	}
+	return assertion
+}
+
+func TestReplayedSerial(t *testing.T) {
+	db := datastoretest.New()
+	request := signedTestAssertion(t, "A1")
+	serial := signedTestAssertion(t, "A1")
+	hash := serialRequestHash(request)
+	model := datastore.Model{BrandID: "system", Name: "alder", DuplicatePolicy: datastore.DuplicatePolicy{Replay: true}}
+	signingLog := datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Signer: &datastore.SigningAudit{KeypairID: 1, KeyID: "signing-key"}}
+
+	if replayed, _ := replayedSerial(db, config.Settings{}, "system-alder", hash); replayed != nil {
+		t.Fatal("expected no replay for a new serial-request")
+	}
+
+	// The serial assertion is only kept for the models that allow replays
+	recordSerial(db, config.Settings{}, datastore.Model{BrandID: "system", Name: "alder"}, "system-alder", hash, serial, signingLog)
+	if replayed, _ := replayedSerial(db, config.Settings{}, "system-alder", hash); replayed != nil {
+		t.Fatal("expected no replay for a model that does not allow replays")
+	}
+
+	recordSerial(db, config.Settings{}, model, "system-alder", hash, serial, signingLog)
+
+	replayed, replayedLog := replayedSerial(db, config.Settings{}, "system-alder", hash)
+	if replayed == nil {
+		t.Fatal("expected the serial assertion of the replayed serial-request")
+	}
+	if string(asserts.Encode(replayed)) != string(asserts.Encode(serial)) {
+		t.Error("expected the same serial assertion for the replayed serial-request")
+	}
+	if replayedLog.Signer == nil || replayedLog.Signer.KeyID != "signing-key" {
+		t.Errorf("expected the signer of the replayed serial-request, got %v", replayedLog.Signer)
+	}
+
+	// The replay is only detected for the same API key
+	if replayed, _ := replayedSerial(db, config.Settings{}, "other-alder", hash); replayed != nil {
+		t.Error("expected no replay for another API key")
+	}
+
+	// A different serial-request of the device is signed
+	if replayed, _ := replayedSerial(db, config.Settings{}, "system-alder", serialRequestHash(signedTestAssertion(t, "A1"))); replayed != nil {
+		t.Error("expected no replay for a different serial-request")
+	}
+
+	// The replay detection is disabled
+	if replayed, _ := replayedSerial(db, config.Settings{SerialReplayWindow: -1}, "system-alder", hash); replayed != nil {
+		t.Error("expected no replay when the replay detection is disabled")
+	}
+}
+
+func TestReplayedSerialError(t *testing.T) {
+	if replayed, _ := replayedSerial(&datastore.ErrorMockDB{}, config.Settings{}, "system-alder", "hash"); replayed != nil {
+		t.Error("expected the serial-request to be signed when the replay cannot be retrieved")
+	}
+}
+
+func TestReplaySerial(t *testing.T) {
+	db := datastoretest.New()
+	keypair := db.AddKeypair(datastore.Keypair{AuthorityID: "system", KeyID: "signing-key", Active: true})
+	model := datastore.Model{BrandID: "system", Name: "alder", DuplicatePolicy: datastore.DuplicatePolicy{Replay: true}}
+	signingLog := datastore.SigningLog{Make: "system", Model: "alder", SerialNumber: "A1", Signer: &datastore.SigningAudit{KeypairID: keypair.ID, KeyID: "signing-key"}}
+	serial := signedTestAssertion(t, "A1")
+	srv := &Service{Env: &datastore.Env{DB: db}}
+
+	replay := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/v1/serial", nil)
+		if e := srv.replaySerial(context.Background(), w, r, db, "system-alder", validation.Resolution{Model: model}, serial, signingLog); !e.Success {
+			w.Code = e.StatusCode
+			w.Header().Set("Error-Code", e.Code)
+		}
+		return w
+	}
+
+	// The serial assertion is returned with its signer
+	w := replay()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the replay to be returned, got %d", w.Code)
+	}
+	if w.Header().Get(SignerHeader) != signingLog.Signer.String() {
+		t.Errorf("expected the signer header '%s', got '%s'", signingLog.Signer.String(), w.Header().Get(SignerHeader))
+	}
+
+	// A quarantined device is refused
+	db.SyncDeviceQuarantine([]datastore.DeviceQuarantine{{Brand: "system", SerialNumber: "A1", Reason: "stolen"}})
+	if w = replay(); w.Header().Get("Error-Code") != response.ErrorQuarantinedDevice.Code {
+		t.Errorf("expected the replay of a quarantined device to be refused, got %d", w.Code)
+	}
+	db.SyncDeviceQuarantine(nil)
+
+	// A serial assertion that was signed by a signing-key that has since been compromised is refused
+	if _, err := db.CompromiseAllowedKeypair(keypair.ID, "leaked", datastore.User{Username: "root", Role: datastore.Superuser}); err != nil {
+		t.Fatalf("error compromising the signing-key: %v", err)
+	}
+	if w = replay(); w.Header().Get("Error-Code") != response.ErrorCompromisedModel.Code {
+		t.Errorf("expected the replay of a compromised signing-key to be refused, got %d", w.Code)
+	}
+}
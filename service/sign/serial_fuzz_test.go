@@ -0,0 +1,65 @@
+//go:build go1.18
+// +build go1.18
+
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/sign"
+)
+
+// FuzzSerial checks that the Serial handler rejects malformed request streams without
+// panics. The seed corpus of malformed serial-requests is in testdata/fuzz/FuzzSerial
+func FuzzSerial(f *testing.F) {
+	for _, body := range []string{"", "serial: A123456L", "station: line-1\nserial: 123456"} {
+		assert, err := generateSerialRequestAssertion("alder", "", body)
+		if err != nil {
+			f.Fatalf("Error generating the serial-request: %v", err)
+		}
+		f.Add(assert)
+	}
+
+	env := datastore.Environ
+	defer func() { datastore.Environ = env }()
+	settings := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: settings}
+	datastore.OpenKeyStore(settings)
+	signer := &sign.Service{Env: datastore.Environ}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/v1/serial", bytes.NewReader(data))
+		r.Header.Set("api-key", "ValidAPIKey")
+
+		// Call the handler directly, as the router recovers from panics
+		result := signer.Serial(w, r)
+		if !result.Success && result.StatusCode < http.StatusBadRequest {
+			t.Errorf("expected an error status for %q, got %d", result.Code, result.StatusCode)
+		}
+	})
+}
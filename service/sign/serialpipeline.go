@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// serialStep transforms or validates the serial number, using the value of the step
+type serialStep func(serial, value string) (string, error)
+
+// serialSteps are the implementations of the built-in steps of the serial pipeline
+var serialSteps = map[string]serialStep{
+	datastore.SerialStepTrim: func(serial, value string) (string, error) {
+		return strings.TrimSpace(serial), nil
+	},
+	datastore.SerialStepUppercase: func(serial, value string) (string, error) {
+		return strings.ToUpper(serial), nil
+	},
+	datastore.SerialStepLowercase: func(serial, value string) (string, error) {
+		return strings.ToLower(serial), nil
+	},
+	datastore.SerialStepStripPrefix: func(serial, value string) (string, error) {
+		return strings.TrimPrefix(serial, value), nil
+	},
+	datastore.SerialStepStripSuffix: func(serial, value string) (string, error) {
+		return strings.TrimSuffix(serial, value), nil
+	},
+	datastore.SerialStepRemoveChars: func(serial, value string) (string, error) {
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(value, r) {
+				return -1
+			}
+			return r
+		}, serial), nil
+	},
+	datastore.SerialStepLuhn: func(serial, value string) (string, error) {
+		if !validLuhn(serial) {
+			return serial, errors.New("The check digit of the serial number is invalid")
+		}
+		return serial, nil
+	},
+	datastore.SerialStepPattern: func(serial, value string) (string, error) {
+		matched, err := regexp.MatchString(value, serial)
+		if err != nil {
+			return serial, err
+		}
+		if !matched {
+			return serial, errors.New("The serial number does not match the pattern of the model")
+		}
+		return serial, nil
+	},
+}
+
+// serialPipelineError is returned when the serial number is rejected by the serial pipeline
+type serialPipelineError struct {
+	error
+}
+
+// applySerialPipeline runs the steps of the serial pipeline of the model, in order, and
+// returns the normalized serial number
+func applySerialPipeline(pipeline datastore.SerialPipeline, serial string) (string, error) {
+	for _, s := range pipeline {
+		step, ok := serialSteps[s.Step]
+		if !ok {
+			return serial, serialPipelineError{fmt.Errorf("The serial pipeline step '%s' is not supported", s.Step)}
+		}
+
+		var err error
+		serial, err = step(serial, s.Value)
+		if err != nil {
+			return serial, serialPipelineError{err}
+		}
+	}
+
+	if len(serial) == 0 {
+		return serial, serialPipelineError{errors.New("The serial number is empty after the serial pipeline of the model")}
+	}
+	return serial, nil
+}
+
+// validLuhn checks the Luhn (mod 10) check digit, which is the last digit of the serial
+func validLuhn(serial string) bool {
+	if len(serial) < 2 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(serial) - 1; i >= 0; i-- {
+		c := serial[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
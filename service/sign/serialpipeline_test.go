@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestApplySerialPipeline(t *testing.T) {
+	tests := []struct {
+		pipeline datastore.SerialPipeline
+		serial   string
+		expected string
+		valid    bool
+	}{
+		{nil, "abc123", "abc123", true},
+		{datastore.SerialPipeline{{Step: "trim"}, {Step: "uppercase"}}, " abc123\n", "ABC123", true},
+		{datastore.SerialPipeline{{Step: "lowercase"}}, "ABC123", "abc123", true},
+		{datastore.SerialPipeline{{Step: "strip-prefix", Value: "SN:"}}, "SN:abc123", "abc123", true},
+		{datastore.SerialPipeline{{Step: "strip-prefix", Value: "SN:"}}, "abc123", "abc123", true},
+		{datastore.SerialPipeline{{Step: "strip-suffix", Value: "/A"}}, "abc123/A", "abc123", true},
+		{datastore.SerialPipeline{{Step: "remove-chars", Value: "- "}}, "4992-7398 716", "49927398716", true},
+		{datastore.SerialPipeline{{Step: "remove-chars", Value: "-"}, {Step: "luhn"}}, "4992-7398-716", "49927398716", true},
+		{datastore.SerialPipeline{{Step: "luhn"}}, "49927398717", "", false},
+		{datastore.SerialPipeline{{Step: "luhn"}}, "4992739871A", "", false},
+		{datastore.SerialPipeline{{Step: "uppercase"}, {Step: "pattern", Value: "^[A-Z]{3}[0-9]+$"}}, "abc123", "ABC123", true},
+		{datastore.SerialPipeline{{Step: "pattern", Value: "^[A-Z]{3}[0-9]+$"}}, "abc123", "", false},
+		{datastore.SerialPipeline{{Step: "pattern", Value: "["}}, "abc123", "", false},
+		{datastore.SerialPipeline{{Step: "strip-prefix", Value: "SN:"}}, "SN:", "", false},
+		{datastore.SerialPipeline{{Step: "invalid"}}, "abc123", "", false},
+	}
+
+	for i, tt := range tests {
+		serial, err := applySerialPipeline(tt.pipeline, tt.serial)
+		if (err == nil) != tt.valid {
+			t.Errorf("%d: expected valid %v, got error %v", i, tt.valid, err)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(serialPipelineError); !ok {
+				t.Errorf("%d: expected a serial pipeline error, got %T", i, err)
+			}
+			continue
+		}
+		if serial != tt.expected {
+			t.Errorf("%d: expected the serial '%s', got '%s'", i, tt.expected, serial)
+		}
+	}
+}
+
+func TestSerialStepsBuiltIn(t *testing.T) {
+	for _, step := range datastore.SerialSteps {
+		if _, ok := serialSteps[step]; !ok {
+			t.Errorf("Expected the serial pipeline step '%s' to be implemented", step)
+		}
+	}
+}
+
+func TestValidLuhn(t *testing.T) {
+	tests := []struct {
+		serial string
+		valid  bool
+	}{
+		{"79927398713", true},
+		{"49927398716", true},
+		{"1234567812345670", true},
+		{"79927398710", false},
+		{"1234567812345678", false},
+		{"0", false},
+		{"", false},
+		{"7992 7398 713", false},
+	}
+
+	for _, tt := range tests {
+		if validLuhn(tt.serial) != tt.valid {
+			t.Errorf("%s: expected valid %v", tt.serial, tt.valid)
+		}
+	}
+}
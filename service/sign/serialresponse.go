@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"bytes"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// serialResponseFields renders the serial response fields of the model for the signed
+// serial assertion. A field that fails to render is left out of the response, as the
+// device has already been signed
+func serialResponseFields(model datastore.Model, assertion asserts.Assertion, signingLog datastore.SigningLog) map[string]string {
+	if len(model.SerialResponse) == 0 {
+		return nil
+	}
+
+	ctx := datastore.SerialResponseContext{
+		BrandID:     assertion.HeaderString("brand-id"),
+		Model:       assertion.HeaderString("model"),
+		Serial:      assertion.HeaderString("serial"),
+		Revision:    assertion.Revision(),
+		DeviceKey:   assertion.HeaderString("device-key-sha3-384"),
+		Timestamp:   assertion.HeaderString("timestamp"),
+		Station:     signingLog.Station,
+		AuthorityID: assertion.AuthorityID(),
+		Details:     signingLog.Details,
+	}
+	if signingLog.Signer != nil {
+		ctx.KeyID = signingLog.Signer.KeyID
+	}
+
+	fields := map[string]string{}
+	for _, f := range model.SerialResponse {
+		t, err := datastore.ParseSerialResponseField(f)
+		if err != nil {
+			log.Message("SIGN", "serial-response", err.Error())
+			continue
+		}
+		var value bytes.Buffer
+		if err = t.Execute(&value, ctx); err != nil {
+			log.Message("SIGN", "serial-response", err.Error())
+			continue
+		}
+		fields[f.Name] = value.String()
+	}
+	return fields
+}
@@ -0,0 +1,62 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestSerialResponseFields(t *testing.T) {
+	assertion := signedTestAssertion(t, "A1234")
+	signingLog := datastore.SigningLog{
+		Station: "line-1",
+		Details: map[string]string{"mac": "00:11:22:33:44:55"},
+		Signer:  &datastore.SigningAudit{KeyID: "model-key"},
+	}
+
+	tests := []struct {
+		fields   datastore.SerialResponse
+		expected map[string]string
+	}{
+		{nil, nil},
+		{datastore.SerialResponse{{Name: "enroll-url", Value: "https://mdm.example.com/{{.BrandID}}/{{.Model}}/{{.Serial}}"}},
+			map[string]string{"enroll-url": "https://mdm.example.com/system/alder/A1234"}},
+		{datastore.SerialResponse{{Name: "station", Value: "{{.Station}}"}, {Name: "mac", Value: "{{.Details.mac}}"}, {Name: "key", Value: "{{.KeyID}}"}},
+			map[string]string{"station": "line-1", "mac": "00:11:22:33:44:55", "key": "model-key"}},
+		{datastore.SerialResponse{{Name: "sku", Value: "{{.Details.sku}}"}}, map[string]string{"sku": ""}},
+		{datastore.SerialResponse{{Name: "invalid", Value: "{{.Unknown}}"}, {Name: "serial", Value: "{{.Serial}}"}},
+			map[string]string{"serial": "A1234"}},
+	}
+
+	for i, tt := range tests {
+		fields := serialResponseFields(datastore.Model{SerialResponse: tt.fields}, assertion, signingLog)
+		if len(fields) != len(tt.expected) {
+			t.Errorf("%d: expected fields %v, got %v", i, tt.expected, fields)
+			continue
+		}
+		for name, value := range tt.expected {
+			if fields[name] != value {
+				t.Errorf("%d: expected field '%s' to be '%s', got '%s'", i, name, value, fields[name])
+			}
+		}
+	}
+}
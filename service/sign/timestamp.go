@@ -0,0 +1,71 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// manufactureDateLayouts are the accepted formats of the manufacture-date body field
+var manufactureDateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// serialTimestamp returns the timestamp of the serial assertion, which is the signing
+// time unless the timestamp policy of the model accepts the manufacture-date field of
+// the serial-request body. The manufacture date must be within the bounds of the policy
+func serialTimestamp(policy datastore.TimestampPolicy, body map[string]interface{}, now time.Time) (time.Time, error) {
+	if !policy.ManufactureDate {
+		return now, nil
+	}
+
+	var date time.Time
+	switch value := body["manufacture-date"].(type) {
+	case nil:
+		return now, nil
+	case time.Time:
+		date = value
+	case string:
+		date = parseManufactureDate(value)
+	}
+	if date.IsZero() {
+		return now, errors.New("The manufacture-date must be in RFC3339 or YYYY-MM-DD format")
+	}
+
+	if date.Before(now.AddDate(0, 0, -policy.MaxAge)) {
+		return now, fmt.Errorf("The manufacture-date %s is more than %d days before the signing", date.Format(time.RFC3339), policy.MaxAge)
+	}
+	if date.After(now.Add(time.Duration(policy.MaxFuture) * time.Minute)) {
+		return now, fmt.Errorf("The manufacture-date %s is after the signing", date.Format(time.RFC3339))
+	}
+	return date, nil
+}
+
+// parseManufactureDate parses the manufacture date, returning the zero time when invalid
+func parseManufactureDate(value string) time.Time {
+	for _, layout := range manufactureDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestSerialTimestamp(t *testing.T) {
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	policy := datastore.TimestampPolicy{ManufactureDate: true, MaxAge: 30, MaxFuture: 10}
+
+	tests := []struct {
+		policy    datastore.TimestampPolicy
+		body      string
+		timestamp time.Time
+		valid     bool
+	}{
+		{datastore.TimestampPolicy{}, "manufacture-date: 2018-06-01\n", now, true},
+		{policy, "", now, true},
+		{policy, "serial: A1234\n", now, true},
+		{policy, "manufacture-date: 2018-06-01\n", time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{policy, "manufacture-date: \"2018-06-15T12:05:00Z\"\n", time.Date(2018, 6, 15, 12, 5, 0, 0, time.UTC), true},
+		{policy, "manufacture-date: 2018-05-01\n", now, false},
+		{policy, "manufacture-date: \"2018-06-15T12:30:00Z\"\n", now, false},
+		{policy, "manufacture-date: last week\n", now, false},
+		{policy, "manufacture-date: 20180601\n", now, false},
+	}
+
+	for _, tt := range tests {
+		timestamp, err := serialTimestamp(tt.policy, decodeRequestBody([]byte(tt.body)), now)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got error %v", tt.body, tt.valid, err)
+		}
+		if !timestamp.Equal(tt.timestamp) {
+			t.Errorf("%q: expected timestamp %v, got %v", tt.body, tt.timestamp, timestamp)
+		}
+	}
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	tests := []struct {
+		body   string
+		fields int
+	}{
+		{"", 0},
+		{"serial: A123456L\nstation: line-1\n", 2},
+		{"serial: \"*A123456L\"\n", 1},
+		{"serial: A1*2\nstation: line *1\n", 2},
+		{"not yaml: [\n", 0},
+		{"a: &a [x, x]\nb: [*a, *a]\n", 0},
+		{"a: &a x\nb: *a\n", 0},
+		{"a: &a\n- x\nb:\n- *a\n", 0},
+	}
+
+	for _, tt := range tests {
+		if body := decodeRequestBody([]byte(tt.body)); len(body) != tt.fields {
+			t.Errorf("%q: expected %d fields, got %v", tt.body, tt.fields, body)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// Verdicts of the verification of a serial assertion
+const (
+	VerdictValid      = "valid"      // issued by the vault, and it is the current serial assertion of the device
+	VerdictSuperseded = "superseded" // issued by the vault, but the serial number was signed again
+	VerdictRevoked    = "revoked"    // issued by the vault, but its signing-key has been disabled
+	VerdictUnknown    = "unknown"    // signed by a signing-key of the vault, but not issued by this vault
+	VerdictInvalid    = "invalid"    // not signed by a signing-key of the vault
+)
+
+// VerifyResponse is the JSON response from the API method to verify a serial assertion
+type VerifyResponse struct {
+	Success      bool       `json:"success"`
+	ErrorMessage string     `json:"message"`
+	Verdict      string     `json:"verdict"`
+	Reason       string     `json:"reason,omitempty"`
+	BrandID      string     `json:"brand-id"`
+	Model        string     `json:"model"`
+	Serial       string     `json:"serial"`
+	Revision     int        `json:"revision"`
+	Signed       *time.Time `json:"signed,omitempty"` // when the vault issued the serial assertion
+}
+
+// Verify is the API method to verify a serial assertion. It checks the signature against the
+// signing-keys of the vault and the signing log, and returns the verdict. The method does not
+// need an API key, so that anyone holding a serial assertion can check it
+func (srv *Service) Verify(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	defer r.Body.Close()
+
+	// The request stream must hold the serial assertion only
+	dec := asserts.NewDecoder(http.MaxBytesReader(w, r.Body, maxSerialRequestSize))
+	assertion, err := dec.Decode()
+	if err == io.EOF {
+		log.Message("VERIFY", "invalid-assertion", response.ErrorEmptyData.Message)
+		return response.ErrorEmptyData
+	}
+	if err != nil {
+		log.Message("VERIFY", "invalid-assertion", err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	if _, err = dec.Decode(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("unexpected assertion in the request stream")
+		}
+		log.Message("VERIFY", response.ErrorInvalidAssertion.Code, err.Error())
+		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	if assertion.Type() != asserts.SerialType {
+		log.Message("VERIFY", response.ErrorInvalidType.Code, response.ErrorInvalidType.Message)
+		return response.ErrorInvalidType
+	}
+
+	ctx, cancel := request.DatastoreContext(r, srv.Config)
+	defer cancel()
+	db := srv.DB.WithContext(ctx)
+
+	result, err := srv.verifySerial(ctx, db, assertion)
+	if err != nil {
+		log.Message("VERIFY", response.ErrorCheckAssertion.Code, err.Error())
+		return datastoreError(ctx, response.ErrorCheckAssertion)
+	}
+	if result.Verdict != VerdictValid {
+		log.Message("VERIFY", result.Verdict, fmt.Sprintf("Serial assertion %s/%s/%s: %s", result.BrandID, result.Model, result.Serial, result.Reason))
+	}
+
+	w.Header().Set("Content-Type", response.JSONHeader)
+	w.WriteHeader(http.StatusOK)
+	formatVerifyResponse(result, w)
+	return response.ErrorResponse{Success: true}
+}
+
+// verifySerial checks the signature of the serial assertion and finds it in the signing log.
+// An error is only returned when the datastore fails, as the verdict cannot be reached
+func (srv *Service) verifySerial(ctx context.Context, db datastore.Datastore, serial asserts.Assertion) (VerifyResponse, error) {
+	result := VerifyResponse{
+		Success:  true,
+		BrandID:  serial.HeaderString("brand-id"),
+		Model:    serial.HeaderString("model"),
+		Serial:   serial.HeaderString("serial"),
+		Revision: serial.Revision(),
+	}
+	verdict := func(v, reason string) (VerifyResponse, error) {
+		result.Verdict, result.Reason = v, reason
+		return result, nil
+	}
+
+	// The signing-key must be one of the signing-keys of the vault
+	keypair, err := db.GetKeypairByPublicID(serial.AuthorityID(), serial.SignKeyID())
+	if request.TimedOut(ctx) {
+		return result, err
+	}
+	if err != nil || keypair.KeyID != serial.SignKeyID() {
+		return verdict(VerdictInvalid, "The signing-key is not known to the vault")
+	}
+
+	publicKey, err := srv.KeypairDB.KeypairPublicKey(keypair)
+	if err != nil {
+		return result, fmt.Errorf("Error loading the public key of the signing-key %s: %v", keypair.KeyID, err)
+	}
+	if err = asserts.SignatureCheck(serial, publicKey); err != nil {
+		return verdict(VerdictInvalid, "The signature does not match the signing-key")
+	}
+
+	// The serial assertion must have been issued by the vault
+	logs, err := db.ListSerialSigningLog(result.BrandID, result.Model, result.Serial)
+	if err != nil {
+		return result, err
+	}
+
+	var issued *datastore.SigningLog
+	latest := 0
+	for i, l := range logs {
+		if l.Revision == result.Revision && l.Fingerprint == serial.HeaderString("device-key-sha3-384") {
+			issued = &logs[i]
+		}
+		if l.Revision > latest {
+			latest = l.Revision
+		}
+	}
+	if issued == nil {
+		return verdict(VerdictUnknown, "The vault has no record of issuing the serial assertion")
+	}
+	result.Signed = &issued.Created
+
+	switch {
+	case !keypair.Active:
+		return verdict(VerdictRevoked, "The signing-key has been disabled")
+	case latest > result.Revision:
+		return verdict(VerdictSuperseded, "The serial number was signed again in revision "+strconv.Itoa(latest))
+	default:
+		return verdict(VerdictValid, "")
+	}
+}
+
+func formatVerifyResponse(result VerifyResponse, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Message("VERIFY", "error-form-verify", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/sign"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+// The signing-key of the filesystem keystore
+const testKeyID = "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"
+
+type VerifySuite struct {
+	db          *datastoretest.DB
+	keypair     datastore.Keypair
+	deviceKeyID string
+}
+
+var _ = check.Suite(&VerifySuite{})
+
+func (s *VerifySuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.keypair = s.db.AddKeypair(datastoretest.NewKeypair("system", testKeyID).Build())
+	s.db.AddModel(datastoretest.NewModel("system", "alder").WithKeypair(s.keypair).Build())
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash"}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+	datastore.OpenKeyStore(config)
+
+	privateKey, err := generatePrivateKey()
+	c.Assert(err, check.IsNil)
+	s.deviceKeyID = privateKey.PublicKey().ID()
+}
+
+func (s *VerifySuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+// serialAssertion signs a serial assertion for the test device-key with the keystore
+func (s *VerifySuite) serialAssertion(c *check.C, serial string, revision int) []byte {
+	privateKey, err := generatePrivateKey()
+	c.Assert(err, check.IsNil)
+	encodedPubKey, err := asserts.EncodePublicKey(privateKey.PublicKey())
+	c.Assert(err, check.IsNil)
+
+	headers := map[string]interface{}{
+		"authority-id":        "system",
+		"brand-id":            "system",
+		"model":               "alder",
+		"serial":              serial,
+		"device-key":          string(encodedPubKey),
+		"device-key-sha3-384": s.deviceKeyID,
+		"revision":            fmt.Sprintf("%d", revision),
+		"timestamp":           time.Now().UTC().Format(time.RFC3339),
+	}
+	assertion, err := datastore.Environ.KeypairDB.SignAssertion(context.Background(), datastore.Environ.DB, asserts.SerialType, headers, nil, "system", testKeyID, "")
+	c.Assert(err, check.IsNil)
+	return asserts.Encode(assertion)
+}
+
+func (s *VerifySuite) verify(c *check.C, data []byte) sign.VerifyResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/verify", bytes.NewReader(data))
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, response.JSONHeader)
+
+	result := sign.VerifyResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	return result
+}
+
+func (s *VerifySuite) TestVerify(c *check.C) {
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint(s.deviceKeyID).Build())
+
+	result := s.verify(c, s.serialAssertion(c, "A1", 1))
+	c.Assert(result.Verdict, check.Equals, sign.VerdictValid)
+	c.Assert(result.BrandID, check.Equals, "system")
+	c.Assert(result.Model, check.Equals, "alder")
+	c.Assert(result.Serial, check.Equals, "A1")
+	c.Assert(result.Revision, check.Equals, 1)
+	c.Assert(result.Signed, check.NotNil)
+}
+
+func (s *VerifySuite) TestVerifyUnknown(c *check.C) {
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint("other-device-key").Build())
+
+	for _, serial := range []string{"A1", "A2"} {
+		result := s.verify(c, s.serialAssertion(c, serial, 1))
+		c.Assert(result.Verdict, check.Equals, sign.VerdictUnknown)
+		c.Assert(result.Signed, check.IsNil)
+	}
+}
+
+func (s *VerifySuite) TestVerifySuperseded(c *check.C) {
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint(s.deviceKeyID).Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint("new-device-key").WithRevision(2).Build())
+
+	result := s.verify(c, s.serialAssertion(c, "A1", 1))
+	c.Assert(result.Verdict, check.Equals, sign.VerdictSuperseded)
+	c.Assert(result.Signed, check.NotNil)
+}
+
+func (s *VerifySuite) TestVerifyRevoked(c *check.C) {
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint(s.deviceKeyID).Build())
+	err := s.db.UpdateAllowedKeypairActive(s.keypair.ID, false, datastore.User{Role: datastore.Superuser})
+	c.Assert(err, check.IsNil)
+
+	result := s.verify(c, s.serialAssertion(c, "A1", 1))
+	c.Assert(result.Verdict, check.Equals, sign.VerdictRevoked)
+}
+
+func (s *VerifySuite) TestVerifyInvalid(c *check.C) {
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithFingerprint(s.deviceKeyID).Build())
+	assertion := s.serialAssertion(c, "A1", 1)
+
+	// The serial assertion has been changed after it was signed
+	tampered := bytes.Replace(assertion, []byte("serial: A1\n"), []byte("serial: A9\n"), 1)
+	c.Assert(tampered, check.Not(check.DeepEquals), assertion)
+	result := s.verify(c, tampered)
+	c.Assert(result.Verdict, check.Equals, sign.VerdictInvalid)
+
+	// The signing-key is not a signing-key of the vault
+	s.db = datastoretest.New()
+	datastore.Environ.DB = s.db
+
+	result = s.verify(c, assertion)
+	c.Assert(result.Verdict, check.Equals, sign.VerdictInvalid)
+}
+
+func (s *VerifySuite) TestVerifyBadRequest(c *check.C) {
+	serialRequest, err := generateSerialRequestAssertion("alder", "A1", "")
+	c.Assert(err, check.IsNil)
+	serial := s.serialAssertion(c, "A1", 1)
+
+	tests := []struct {
+		Data []byte
+		Code string
+	}{
+		{nil, response.ErrorEmptyData.Code},
+		{[]byte("not an assertion"), response.ErrorInvalidAssertion.Code},
+		{serialRequest, response.ErrorInvalidType.Code},
+		{append(append(serial, '\n'), serial...), response.ErrorInvalidAssertion.Code},
+	}
+
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/verify", bytes.NewReader(t.Data))
+		service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+
+		result := response.ErrorResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Code, check.Equals, t.Code)
+	}
+}
@@ -20,6 +20,7 @@
 package signinglog
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -48,16 +49,16 @@ type FiltersResponse struct {
 }
 
 // listHandler is the API method to fetch the log records from signing
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	logs, err := datastore.Environ.DB.ListAllowedSigningLog(user)
+	logs, err := srv.DB.WithContext(ctx).ListAllowedSigningLog(user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
@@ -69,16 +70,16 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 }
 
 // listForAccountHandler is the API method to fetch the log records from signing for an account
-func listForAccountHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
+func (srv *Service) listForAccountHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	logs, err := datastore.Environ.DB.ListAllowedSigningLogForAccount(user, authorityID)
+	logs, err := srv.DB.WithContext(ctx).ListAllowedSigningLogForAccount(user, authorityID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
@@ -89,17 +90,44 @@ func listForAccountHandler(w http.ResponseWriter, user datastore.User, apiCall b
 	formatListResponse(true, "", "", "", logs, w)
 }
 
+// searchForAccountHandler is the API method to find the log records from signing for an account,
+// that have a matching value for a field of the serial-request details
+func (srv *Service) searchForAccountHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID, field, value string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(field) == 0 {
+		response.FormatStandardResponse(false, "error-search-signinglog", "", "The details field to search must be provided", w)
+		return
+	}
+
+	logs, err := srv.DB.WithContext(ctx).SearchAllowedSigningLogForAccount(user, authorityID, field, value)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-search-signinglog", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of models
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(true, "", "", "", logs, w)
+}
+
 // listFiltersHandler is the API method to fetch the log filter values
-func listFiltersHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
+func (srv *Service) listFiltersHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	filters, err := datastore.Environ.DB.AllowedSigningLogFilterValues(user, authorityID)
+	filters, err := srv.DB.WithContext(ctx).AllowedSigningLogFilterValues(user, authorityID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
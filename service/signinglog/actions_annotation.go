@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// AnnotationResponse is the JSON response from the API method to annotate a signing log entry
+type AnnotationResponse struct {
+	Success      bool                           `json:"success"`
+	ErrorCode    string                         `json:"error_code"`
+	ErrorSubcode string                         `json:"error_subcode"`
+	ErrorMessage string                         `json:"message"`
+	Annotation   datastore.SigningLogAnnotation `json:"annotation"`
+}
+
+// annotateHandler is the API method to attach an annotation to a signing log entry
+func (srv *Service) annotateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, signingLogID string, annotation datastore.SigningLogAnnotation) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	annotation.SigningLogID, err = strconv.Atoi(signingLogID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-signinglog", "", err.Error(), w)
+		return
+	}
+
+	annotation, err = srv.DB.CreateAllowedSigningLogAnnotation(annotation, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-create-annotation", "", err.Error(), w)
+		return
+	}
+	log.Printf("Signing log %d annotated by %s: %q\n", annotation.SigningLogID, user.Username, annotation.Note)
+
+	// Return successful JSON response with the annotation
+	w.WriteHeader(http.StatusOK)
+	formatAnnotationResponse(true, "", "", "", annotation, w)
+}
+
+func formatAnnotationResponse(success bool, errorCode, errorSubcode, message string, annotation datastore.SigningLogAnnotation, w http.ResponseWriter) error {
+	response := AnnotationResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Annotation: annotation}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the annotation response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ImportResponse is the JSON response from the API method to import legacy signing log entries
+type ImportResponse struct {
+	Success      bool                             `json:"success"`
+	ErrorCode    string                           `json:"error_code"`
+	ErrorSubcode string                           `json:"error_subcode"`
+	ErrorMessage string                           `json:"message"`
+	Result       datastore.SigningLogImportResult `json:"result"`
+}
+
+// importHandler is the API method to import the signing log entries of an account from another
+// signing system. The entries that are already in the signing log are skipped
+func (srv *Service) importHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, imp datastore.SigningLogImport) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	_, err = srv.DB.GetAllowedAccount(authorityID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	for i := range imp.Entries {
+		if len(imp.Entries[i].Make) == 0 {
+			imp.Entries[i].Make = authorityID
+		}
+		if imp.Entries[i].Make != authorityID {
+			response.FormatStandardResponse(false, "error-signinglog-import", "", fmt.Sprintf("Entry %d: the make must be the account %s", i+1, authorityID), w)
+			return
+		}
+	}
+
+	err = datastore.ValidateSigningLogImport(imp, time.Now().UTC())
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-import", "", err.Error(), w)
+		return
+	}
+
+	result, err := datastore.ImportSigningLogs(srv.DB, imp, user.Username)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-import", "", err.Error(), w)
+		return
+	}
+	log.Printf("Signing log batch %s imported by %s for %s from %s: %d imported, %d duplicates, %d conflicts\n", result.Batch, user.Username, authorityID, imp.Source, result.Imported, result.Duplicates, len(result.Conflicts))
+
+	// Return successful JSON response with the outcome of the import
+	w.WriteHeader(http.StatusOK)
+	formatImportResponse(true, "", "", "", result, w)
+}
+
+func formatImportResponse(success bool, errorCode, errorSubcode, message string, result datastore.SigningLogImportResult, w http.ResponseWriter) error {
+	response := ImportResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Result: result}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the signing log import response.")
+		return err
+	}
+	return nil
+}
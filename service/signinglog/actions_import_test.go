@@ -0,0 +1,150 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	check "gopkg.in/check.v1"
+)
+
+type ImportSuite struct {
+	db *datastoretest.DB
+}
+
+var _ = check.Suite(&ImportSuite{})
+
+func (s *ImportSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	other := s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "otheradmin", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{other}})
+	s.db.AddUser(datastore.User{Username: "user1", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+}
+
+func (s *ImportSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ImportSuite) importLog(c *check.C, username string, imp datastore.SigningLogImport) signinglog.ImportResponse {
+	data, _ := json.Marshal(imp)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/signinglog/account/system/import", bytes.NewReader(data))
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := signinglog.ImportResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ImportSuite) TestImport(c *check.C) {
+	signed := time.Now().UTC().Add(-48 * time.Hour)
+	imp := datastore.SigningLogImport{Source: "legacy-vault", Entries: []datastore.SigningLogImportEntry{
+		{SourceID: "3", Model: "alder", SerialNumber: "A1", Fingerprint: "fingerprint-A1", Created: signed},
+		{SourceID: "2", Model: "alder", SerialNumber: "A2", Fingerprint: "fingerprint-A2", Created: signed.Add(-time.Hour), Revision: 1, KeyID: "legacy-key"},
+		{SourceID: "1", Model: "alder", SerialNumber: "A1", Fingerprint: "fingerprint-A1-new", Created: signed.Add(-2 * time.Hour)},
+		{SourceID: "4", Model: "alder", SerialNumber: "A1", Fingerprint: "fingerprint-A1-other", Created: signed, Revision: 1},
+	}}
+
+	result := s.importLog(c, "sv", imp)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Result.Batch, check.Not(check.Equals), "")
+	c.Assert(result.Result.Imported, check.Equals, 2)
+	c.Assert(result.Result.Duplicates, check.Equals, 1)
+	c.Assert(result.Result.Conflicts, check.HasLen, 1)
+	c.Assert(result.Result.Conflicts[0].SourceID, check.Equals, "4")
+
+	logs, err := s.db.ListSerialSigningLog("system", "alder", "A1")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+
+	var imported datastore.SigningLog
+	for _, l := range logs {
+		if l.Fingerprint == "fingerprint-A1-new" {
+			imported = l
+		}
+	}
+	c.Assert(imported.Revision, check.Equals, 2)
+	c.Assert(imported.Created.Equal(signed.Add(-2*time.Hour)), check.Equals, true)
+	c.Assert(imported.Signer, check.NotNil)
+	c.Assert(imported.Signer.Backend, check.Equals, datastore.SigningImportBackend)
+	c.Assert(imported.Signer.Import, check.NotNil)
+	c.Assert(imported.Signer.Import.Source, check.Equals, "legacy-vault")
+	c.Assert(imported.Signer.Import.SourceID, check.Equals, "1")
+	c.Assert(imported.Signer.Import.Batch, check.Equals, result.Result.Batch)
+	c.Assert(imported.Signer.Import.ImportedBy, check.Equals, "sv")
+
+	logs, err = s.db.ListSerialSigningLog("system", "alder", "A2")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Signer.KeyID, check.Equals, "legacy-key")
+
+	// Importing again only finds duplicates
+	result = s.importLog(c, "sv", imp)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Result.Imported, check.Equals, 0)
+	c.Assert(result.Result.Duplicates, check.Equals, 3)
+}
+
+func (s *ImportSuite) TestImportInvalid(c *check.C) {
+	signed := time.Now().UTC().Add(-time.Hour)
+	tests := []struct {
+		Username string
+		Import   datastore.SigningLogImport
+		Code     string
+	}{
+		{"user1", datastore.SigningLogImport{Source: "legacy", Entries: []datastore.SigningLogImportEntry{{Model: "alder", SerialNumber: "A3", Fingerprint: "f", Created: signed}}}, "error-auth"},
+		{"otheradmin", datastore.SigningLogImport{Source: "legacy", Entries: []datastore.SigningLogImportEntry{{Model: "alder", SerialNumber: "A3", Fingerprint: "f", Created: signed}}}, "error-auth"},
+		{"sv", datastore.SigningLogImport{Entries: []datastore.SigningLogImportEntry{{Model: "alder", SerialNumber: "A3", Fingerprint: "f", Created: signed}}}, "error-signinglog-import"},
+		{"sv", datastore.SigningLogImport{Source: "legacy"}, "error-signinglog-import"},
+		{"sv", datastore.SigningLogImport{Source: "legacy", Entries: []datastore.SigningLogImportEntry{{Make: "other", Model: "birch", SerialNumber: "A3", Fingerprint: "f", Created: signed}}}, "error-signinglog-import"},
+		{"sv", datastore.SigningLogImport{Source: "legacy", Entries: []datastore.SigningLogImportEntry{{Model: "alder", SerialNumber: "A3", Fingerprint: "f"}}}, "error-signinglog-import"},
+		{"sv", datastore.SigningLogImport{Source: "legacy", Entries: []datastore.SigningLogImportEntry{{Model: "alder", SerialNumber: "A3", Fingerprint: "f", Created: time.Now().Add(time.Hour)}}}, "error-signinglog-import"},
+		{"sv", datastore.SigningLogImport{Source: "legacy", Entries: []datastore.SigningLogImportEntry{{Model: "alder", SerialNumber: "A3", Created: signed}}}, "error-signinglog-import"},
+	}
+
+	for _, t := range tests {
+		result := s.importLog(c, t.Username, t.Import)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.Code)
+	}
+
+	logs, err := s.db.ListSerialSigningLog("system", "alder", "A3")
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 0)
+}
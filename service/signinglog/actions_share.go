@@ -0,0 +1,204 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ShareTokenHeader is the request header that holds the share token of a partner
+const ShareTokenHeader = "share-token"
+
+// ShareTokenResponse is the JSON response from the API method to create a share token
+type ShareTokenResponse struct {
+	Success      bool                 `json:"success"`
+	ErrorCode    string               `json:"error_code"`
+	ErrorSubcode string               `json:"error_subcode"`
+	ErrorMessage string               `json:"message"`
+	ShareToken   datastore.ShareToken `json:"share-token"`
+}
+
+// ShareTokenListResponse is the JSON response from the API method to list the share tokens
+type ShareTokenListResponse struct {
+	Success      bool                   `json:"success"`
+	ErrorCode    string                 `json:"error_code"`
+	ErrorSubcode string                 `json:"error_subcode"`
+	ErrorMessage string                 `json:"message"`
+	ShareTokens  []datastore.ShareToken `json:"share-tokens"`
+}
+
+// SharedResponse is the JSON response from the API method for partners to find a signed device
+type SharedResponse struct {
+	Success      bool                     `json:"success"`
+	ErrorCode    string                   `json:"error_code"`
+	ErrorSubcode string                   `json:"error_subcode"`
+	ErrorMessage string                   `json:"message"`
+	Devices      []datastore.SignedDevice `json:"devices"`
+}
+
+// shareTokenListHandler is the API method to fetch the share tokens of an account
+func (srv *Service) shareTokenListHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	tokens, err := srv.DB.ListAllowedShareTokens(user, authorityID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-sharetokens", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of share tokens
+	w.WriteHeader(http.StatusOK)
+	formatShareTokenListResponse(true, "", "", "", tokens, w)
+}
+
+// shareTokenCreateHandler is the API method to create a share token for an account. The token
+// expires after the default lifetime, unless the expiry is given
+func (srv *Service) shareTokenCreateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, token datastore.ShareToken) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	token.BrandID = authorityID
+	if token.Expires.IsZero() {
+		token.Expires = time.Now().UTC().Add(datastore.DefaultShareTokenLifetime)
+	}
+
+	token, err = srv.DB.CreateAllowedShareToken(token, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-create-sharetoken", "", err.Error(), w)
+		return
+	}
+	log.Printf("Share token %d created by %s for %s, expiring %s\n", token.ID, user.Username, token.BrandID, token.Expires.Format(time.RFC3339))
+
+	// Return successful JSON response with the token, which cannot be retrieved again
+	w.WriteHeader(http.StatusOK)
+	formatShareTokenResponse(true, "", "", "", token, w)
+}
+
+// shareTokenDeleteHandler is the API method to revoke a share token
+func (srv *Service) shareTokenDeleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, tokenID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = srv.DB.DeleteAllowedShareToken(tokenID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-delete-sharetoken", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// sharedHandler is the API method for a partner to find the signed devices with a serial number,
+// limited to the brand and models of the share token. Only the model, serial number and signing
+// date of the devices are returned
+func (srv *Service) sharedHandler(w http.ResponseWriter, token, serialNumber, model string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	shareToken, err := srv.DB.GetShareToken(token)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	if len(serialNumber) == 0 {
+		response.FormatStandardResponse(false, "error-shared-signinglog", "", "The serial number must be provided", w)
+		return
+	}
+	if len(model) > 0 && !shareToken.AllowsModel(model) {
+		response.FormatStandardResponse(false, "error-shared-signinglog", "", "The model is not shared by the share token", w)
+		return
+	}
+
+	devices, err := srv.DB.FindSignedDevices(shareToken.BrandID, serialNumber)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-shared-signinglog", "", err.Error(), w)
+		return
+	}
+	log.Printf("Share token %d of %s looked up serial number %q\n", shareToken.ID, shareToken.BrandID, serialNumber)
+
+	shared := []datastore.SignedDevice{}
+	for _, d := range devices {
+		if shareToken.AllowsModel(d.Model) && (len(model) == 0 || d.Model == model) {
+			shared = append(shared, d)
+		}
+	}
+
+	// Return successful JSON response with the matching devices
+	w.WriteHeader(http.StatusOK)
+	formatSharedResponse(true, "", "", "", shared, w)
+}
+
+func formatShareTokenResponse(success bool, errorCode, errorSubcode, message string, token datastore.ShareToken, w http.ResponseWriter) error {
+	response := ShareTokenResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, ShareToken: token}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the share token response.")
+		return err
+	}
+	return nil
+}
+
+func formatShareTokenListResponse(success bool, errorCode, errorSubcode, message string, tokens []datastore.ShareToken, w http.ResponseWriter) error {
+	response := ShareTokenListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, ShareTokens: tokens}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the share tokens response.")
+		return err
+	}
+	return nil
+}
+
+func formatSharedResponse(success bool, errorCode, errorSubcode, message string, devices []datastore.SignedDevice, w http.ResponseWriter) error {
+	response := SharedResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Devices: devices}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the shared signing log response.")
+		return err
+	}
+	return nil
+}
@@ -28,17 +28,17 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
-func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, signLog datastore.SigningLog) {
+func (srv *Service) syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, signLog datastore.SigningLog) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
 	// Create the the signing-log if it does not exist
-	exists, err := datastore.Environ.DB.CheckForMatching(signLog)
+	exists, err := srv.DB.CheckForMatching(signLog)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-signinglog-match", "", err.Error(), w)
 		return
@@ -46,7 +46,7 @@ func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, si
 
 	if !exists {
 		// The signing log has not been sync-ed, so create it (keep the same create timestamp)
-		err = datastore.Environ.DB.CreateSigningLogSync(signLog)
+		err = srv.DB.CreateSigningLogSync(signLog)
 		if err != nil {
 			response.FormatStandardResponse(false, "error-signinglog-create", "", err.Error(), w)
 			return
@@ -27,27 +27,46 @@ import (
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
 )
 
 // APIList is the API method to fetch the log records from signing
-func APIList(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	srv.listHandler(r.Context(), w, user, true)
+}
+
+// APISearchForAccount is the API method to find the log records from signing for an account,
+// using the field and value query parameters to match the serial-request details
+func (srv *Service) APISearchForAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	srv.searchForAccountHandler(r.Context(), w, user, true, vars["authorityID"], query.Get("field"), query.Get("value"))
 }
 
 // APISyncLog is the API method to sync a factory log to the cloud
-func APISyncLog(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APISyncLog(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -67,5 +86,35 @@ func APISyncLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	syncLogHandler(w, user, true, request)
+	srv.syncLogHandler(w, user, true, request)
+}
+
+// APIImport is the API method to import the legacy signing log entries of an account
+func (srv *Service) APIImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	imp := datastore.SigningLogImport{}
+	err = json.NewDecoder(r.Body).Decode(&imp)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-signinglog-data", "", "No signing-log data supplied", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-signinglog-json", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	srv.importHandler(w, user, true, vars["authorityID"], imp)
 }
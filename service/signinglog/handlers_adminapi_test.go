@@ -41,6 +41,8 @@ func (s *SigningLogSuite) TestAPISigningLogHandler(c *check.C) {
 		{"GET", "/api/signinglog", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 4},
 		{"GET", "/api/signinglog", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"GET", "/api/signinglog", nil, 400, "application/json; charset=UTF-8", 0, true, false, 0},
+		{"GET", "/api/signinglog/account/system/search?field=mac&value=00:11:22:33:44:01", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{"GET", "/api/signinglog/account/system/search?field=mac&value=00:11:22:33:44:01", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"POST", "/api/signinglog", l1, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{"POST", "/api/signinglog", l1, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
 		{"POST", "/api/signinglog", l1, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
@@ -80,7 +82,7 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
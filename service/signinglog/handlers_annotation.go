@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// Annotate is the API method to attach an annotation to a signing log entry
+func (srv *Service) Annotate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	annotation, ok := decodeAnnotation(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.annotateHandler(w, authUser, false, vars["id"], annotation)
+}
+
+// APIAnnotate is the API method to attach an annotation to a signing log entry
+func (srv *Service) APIAnnotate(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	annotation, ok := decodeAnnotation(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.annotateHandler(w, user, true, vars["id"], annotation)
+}
+
+// decodeAnnotation decodes the note of a new annotation from the request body
+func decodeAnnotation(w http.ResponseWriter, r *http.Request) (datastore.SigningLogAnnotation, bool) {
+	defer r.Body.Close()
+
+	annotation := datastore.SigningLogAnnotation{}
+	err := json.NewDecoder(r.Body).Decode(&annotation)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-annotation-data", "", "No annotation data supplied", w)
+		return annotation, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return annotation, false
+	}
+	return annotation, true
+}
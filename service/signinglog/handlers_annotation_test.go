@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	check "gopkg.in/check.v1"
+)
+
+type AnnotationSuite struct {
+	db    *datastoretest.DB
+	alder datastore.SigningLog
+	birch datastore.SigningLog
+}
+
+var _ = check.Suite(&AnnotationSuite{})
+
+func (s *AnnotationSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	other := s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "otheradmin", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{other}})
+	s.db.AddUser(datastore.User{Username: "user1", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+
+	s.alder = s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithDetail("sku", "S1").Build())
+	s.birch = s.db.AddSigningLog(datastoretest.NewSigningLog("other", "birch", "A2").Build())
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+}
+
+func (s *AnnotationSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *AnnotationSuite) annotate(c *check.C, signingLogID int, username string, data []byte) signinglog.AnnotationResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", fmt.Sprintf("/api/signinglog/%d/annotations", signingLogID), bytes.NewReader(data))
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := signinglog.AnnotationResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *AnnotationSuite) search(c *check.C, query string) signinglog.ListResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/signinglog/account/system/search"+query, nil)
+	r.Header.Set("user", "sv")
+	r.Header.Set("api-key", "ValidAPIKey")
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := signinglog.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *AnnotationSuite) TestAnnotate(c *check.C) {
+	result := s.annotate(c, s.alder.ID, "sv", []byte(`{"note": "RMA replacement"}`))
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Annotation.SigningLogID, check.Equals, s.alder.ID)
+	c.Assert(result.Annotation.Note, check.Equals, "RMA replacement")
+	c.Assert(result.Annotation.CreatedBy, check.Equals, "sv")
+
+	result = s.annotate(c, s.alder.ID, "sv", []byte(`{"note": "scrapped unit"}`))
+	c.Assert(result.Success, check.Equals, true)
+
+	// The annotations are returned with the entry, in the order they were added
+	list := s.search(c, "?field=sku&value=S1")
+	c.Assert(list.Success, check.Equals, true)
+	c.Assert(list.SigningLog, check.HasLen, 1)
+	c.Assert(list.SigningLog[0].Annotations, check.HasLen, 2)
+	c.Assert(list.SigningLog[0].Annotations[0].Note, check.Equals, "RMA replacement")
+	c.Assert(list.SigningLog[0].Annotations[1].Note, check.Equals, "scrapped unit")
+}
+
+func (s *AnnotationSuite) TestAnnotateInvalid(c *check.C) {
+	tests := []struct {
+		SigningLogID int
+		Username     string
+		Data         string
+		ErrorCode    string
+	}{
+		{s.alder.ID, "", `{"note": "RMA replacement"}`, "error-auth"},
+		{s.alder.ID, "user1", `{"note": "RMA replacement"}`, "error-auth"},
+		{s.alder.ID, "sv", "", "error-annotation-data"},
+		{s.alder.ID, "sv", "{invalid", "error-decode-json"},
+		{s.alder.ID, "sv", `{"note": " "}`, "error-create-annotation"},
+		{s.alder.ID, "sv", fmt.Sprintf(`{"note": "%s"}`, strings.Repeat("a", datastore.MaxAnnotationLength+1)), "error-create-annotation"},
+		{s.birch.ID, "sv", `{"note": "RMA replacement"}`, "error-create-annotation"},
+		{999, "sv", `{"note": "RMA replacement"}`, "error-create-annotation"},
+	}
+
+	for _, t := range tests {
+		result := s.annotate(c, t.SigningLogID, t.Username, []byte(t.Data))
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+
+	list := s.search(c, "?field=sku&value=S1")
+	c.Assert(list.SigningLog, check.HasLen, 1)
+	c.Assert(list.SigningLog[0].Annotations, check.HasLen, 0)
+}
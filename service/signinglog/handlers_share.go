@@ -0,0 +1,161 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// ListShareTokens is the API method to fetch the share tokens of an account
+func (srv *Service) ListShareTokens(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.shareTokenListHandler(w, authUser, false, vars["authorityID"])
+}
+
+// CreateShareToken is the API method to create a share token for an account
+func (srv *Service) CreateShareToken(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	token, ok := decodeShareToken(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.shareTokenCreateHandler(w, authUser, false, vars["authorityID"], token)
+}
+
+// DeleteShareToken is the API method to revoke a share token
+func (srv *Service) DeleteShareToken(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tokenID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-sharetoken", "", err.Error(), w)
+		return
+	}
+
+	srv.shareTokenDeleteHandler(w, authUser, false, tokenID)
+}
+
+// APIListShareTokens is the API method to fetch the share tokens of an account
+func (srv *Service) APIListShareTokens(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.shareTokenListHandler(w, user, true, vars["authorityID"])
+}
+
+// APICreateShareToken is the API method to create a share token for an account
+func (srv *Service) APICreateShareToken(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	token, ok := decodeShareToken(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.shareTokenCreateHandler(w, user, true, vars["authorityID"], token)
+}
+
+// APIDeleteShareToken is the API method to revoke a share token
+func (srv *Service) APIDeleteShareToken(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tokenID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-sharetoken", "", err.Error(), w)
+		return
+	}
+
+	srv.shareTokenDeleteHandler(w, user, true, tokenID)
+}
+
+// APIShared is the API method for a partner to find the signed devices with a serial number,
+// using the share token in the header instead of a user
+func (srv *Service) APIShared(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	srv.sharedHandler(w, r.Header.Get(ShareTokenHeader), query.Get("serial"), query.Get("model"))
+}
+
+// decodeShareToken decodes the details of a new share token from the request body
+func decodeShareToken(w http.ResponseWriter, r *http.Request) (datastore.ShareToken, bool) {
+	defer r.Body.Close()
+
+	token := datastore.ShareToken{}
+	err := json.NewDecoder(r.Body).Decode(&token)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-sharetoken-data", "", "No share token data supplied", w)
+		return token, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return token, false
+	}
+	return token, true
+}
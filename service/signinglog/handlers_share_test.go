@@ -0,0 +1,219 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	check "gopkg.in/check.v1"
+)
+
+type ShareSuite struct {
+	db *datastoretest.DB
+}
+
+var _ = check.Suite(&ShareSuite{})
+
+func (s *ShareSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	other := s.db.AddAccount(datastore.Account{AuthorityID: "other"})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "otheradmin", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{other}})
+	s.db.AddUser(datastore.User{Username: "user1", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+
+	s.db.AddModel(datastoretest.NewModel("system", "alder").Build())
+	s.db.AddModel(datastoretest.NewModel("system", "ash").Build())
+	s.db.AddModel(datastoretest.NewModel("other", "birch").Build())
+
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").WithRevision(2).Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "ash", "A1").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("other", "birch", "A2").Build())
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+}
+
+func (s *ShareSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *ShareSuite) sendRequest(method, url string, data []byte, username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, bytes.NewReader(data))
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+	return w
+}
+
+func (s *ShareSuite) createToken(c *check.C, authorityID, username string, token datastore.ShareToken) signinglog.ShareTokenResponse {
+	data, _ := json.Marshal(token)
+	w := s.sendRequest("POST", fmt.Sprintf("/api/signinglog/account/%s/shares", authorityID), data, username)
+
+	result := signinglog.ShareTokenResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ShareSuite) shared(c *check.C, token, query string) signinglog.SharedResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/signinglog/shared"+query, nil)
+	if len(token) > 0 {
+		r.Header.Set(signinglog.ShareTokenHeader, token)
+	}
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := signinglog.SharedResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *ShareSuite) TestCreateShareToken(c *check.C) {
+	result := s.createToken(c, "system", "sv", datastore.ShareToken{Description: "Distributor"})
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.ShareToken.Token, check.Not(check.Equals), "")
+	c.Assert(result.ShareToken.BrandID, check.Equals, "system")
+	c.Assert(result.ShareToken.CreatedBy, check.Equals, "sv")
+	c.Assert(result.ShareToken.Expires.After(time.Now().Add(datastore.DefaultShareTokenLifetime-time.Hour)), check.Equals, true)
+
+	// The token is only returned when it is created
+	w := s.sendRequest("GET", "/api/signinglog/account/system/shares", nil, "sv")
+	list := signinglog.ShareTokenListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&list)
+	c.Assert(err, check.IsNil)
+	c.Assert(list.Success, check.Equals, true)
+	c.Assert(list.ShareTokens, check.HasLen, 1)
+	c.Assert(list.ShareTokens[0].Description, check.Equals, "Distributor")
+	c.Assert(list.ShareTokens[0].Token, check.Equals, "")
+}
+
+func (s *ShareSuite) TestCreateShareTokenInvalid(c *check.C) {
+	tests := []struct {
+		AuthorityID string
+		Username    string
+		Token       datastore.ShareToken
+		ErrorCode   string
+	}{
+		{"system", "", datastore.ShareToken{}, "error-auth"},
+		{"system", "user1", datastore.ShareToken{}, "error-auth"},
+		{"system", "otheradmin", datastore.ShareToken{}, "error-create-sharetoken"},
+		{"system", "sv", datastore.ShareToken{Models: []string{"birch"}}, "error-create-sharetoken"},
+		{"system", "sv", datastore.ShareToken{Expires: time.Now().Add(-time.Hour)}, "error-create-sharetoken"},
+		{"system", "sv", datastore.ShareToken{Expires: time.Now().Add(datastore.MaxShareTokenLifetime + time.Hour)}, "error-create-sharetoken"},
+	}
+
+	for _, t := range tests {
+		result := s.createToken(c, t.AuthorityID, t.Username, t.Token)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+}
+
+func (s *ShareSuite) TestShared(c *check.C) {
+	token := s.createToken(c, "system", "sv", datastore.ShareToken{}).ShareToken.Token
+
+	result := s.shared(c, token, "?serial=A1")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Devices, check.HasLen, 2)
+	c.Assert(result.Devices[0].Model, check.Equals, "alder")
+	c.Assert(result.Devices[1].Model, check.Equals, "ash")
+
+	result = s.shared(c, token, "?serial=A1&model=ash")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Devices, check.HasLen, 1)
+	c.Assert(result.Devices[0].Model, check.Equals, "ash")
+
+	// The devices of other brands are not shared
+	result = s.shared(c, token, "?serial=A2")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Devices, check.HasLen, 0)
+}
+
+func (s *ShareSuite) TestSharedModels(c *check.C) {
+	token := s.createToken(c, "system", "sv", datastore.ShareToken{Models: []string{"alder"}}).ShareToken.Token
+
+	result := s.shared(c, token, "?serial=A1")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Devices, check.HasLen, 1)
+	c.Assert(result.Devices[0].Model, check.Equals, "alder")
+
+	result = s.shared(c, token, "?serial=A1&model=ash")
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-shared-signinglog")
+}
+
+func (s *ShareSuite) TestSharedInvalid(c *check.C) {
+	token := s.createToken(c, "system", "sv", datastore.ShareToken{}).ShareToken.Token
+
+	tests := []struct {
+		Token     string
+		Query     string
+		ErrorCode string
+	}{
+		{"", "?serial=A1", "error-auth"},
+		{"InvalidToken", "?serial=A1", "error-auth"},
+		{token, "", "error-shared-signinglog"},
+	}
+
+	for _, t := range tests {
+		result := s.shared(c, t.Token, t.Query)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+	}
+}
+
+func (s *ShareSuite) TestDeleteShareToken(c *check.C) {
+	created := s.createToken(c, "system", "sv", datastore.ShareToken{}).ShareToken
+	url := fmt.Sprintf("/api/signinglog/shares/%d", created.ID)
+
+	// Only an admin of the brand can revoke the token
+	w := s.sendRequest("DELETE", url, nil, "otheradmin")
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(s.shared(c, created.Token, "?serial=A1").Success, check.Equals, true)
+
+	w = s.sendRequest("DELETE", url, nil, "sv")
+	result, err = response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+
+	shared := s.shared(c, created.Token, "?serial=A1")
+	c.Assert(shared.Success, check.Equals, false)
+	c.Assert(shared.ErrorCode, check.Equals, "error-auth")
+}
@@ -22,38 +22,59 @@ package signinglog
 import (
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the signing log handlers
+type Service struct {
+	*datastore.Env
+}
+
 // List is the API method to fetch the log records from signing
-func List(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
-	listHandler(w, authUser, false)
+	srv.listHandler(r.Context(), w, authUser, false)
 }
 
 // ListForAccount is the API method to fetch the log records from signing for an account
-func ListForAccount(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) ListForAccount(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	srv.listForAccountHandler(r.Context(), w, authUser, false, vars["authorityID"])
+}
+
+// SearchForAccount is the API method to find the log records from signing for an account,
+// using the field and value query parameters to match the serial-request details
+func (srv *Service) SearchForAccount(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
 	vars := mux.Vars(r)
+	query := r.URL.Query()
 
-	listForAccountHandler(w, authUser, false, vars["authorityID"])
+	srv.searchForAccountHandler(r.Context(), w, authUser, false, vars["authorityID"], query.Get("field"), query.Get("value"))
 }
 
 // ListFilters is the API method to fetch the log filter values
-func ListFilters(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) ListFilters(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -61,5 +82,5 @@ func ListFilters(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	listFiltersHandler(w, authUser, false, vars["authorityID"])
+	srv.listFiltersHandler(r.Context(), w, authUser, false, vars["authorityID"])
 }
@@ -57,12 +57,12 @@ type SigningLogTest struct {
 
 func (s *SigningLogSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 
 	// Disable CSRF for tests as we do not have a secure connection
-	service.MiddlewareWithCSRF = service.Middleware
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
 }
 
 func (s *SigningLogSuite) TestSigningLogHandler(c *check.C) {
@@ -75,6 +75,11 @@ func (s *SigningLogSuite) TestSigningLogHandler(c *check.C) {
 		{"GET", "/v1/signinglog/account/system", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 4},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", 0, true, false, 0},
+		{"GET", "/v1/signinglog/account/system/search?field=mac&value=00:11:22:33:44:02", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/signinglog/account/system/search?field=mac&value=00:11:22:33:44:99", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"GET", "/v1/signinglog/account/system/search?field=sku&value=A100", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"GET", "/v1/signinglog/account/system/search", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/account/system/search?field=mac&value=00:11:22:33:44:02", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 	}
 
 	for _, t := range tests {
@@ -102,6 +107,7 @@ func (s *SigningLogSuite) TestSigningLogErrorHandler(c *check.C) {
 		{"GET", "/v1/signinglog", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"GET", "/v1/signinglog/account/system/search?field=mac&value=00:11:22:33:44:02", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 	}
 
 	for _, t := range tests {
@@ -195,7 +201,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 		c.Assert(err, check.IsNil)
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -203,7 +209,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, role)
+	jwtToken, err := usso.NewJWTToken(&resp, role, datastore.Environ.Config.JwtSecret)
 	if err != nil {
 		return fmt.Errorf("Error creating a JWT: %v", err)
 	}
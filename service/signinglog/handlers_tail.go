@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+)
+
+// SigningEvent is the name of the server-sent event of a signing log entry
+const SigningEvent = "signing"
+
+// tailInterval is the time between the checks for new signing log entries, and keepAliveInterval
+// is the time after which the stream is kept alive when there are no entries
+var (
+	tailInterval      = time.Second
+	keepAliveInterval = 15 * time.Second
+)
+
+// TailFilter selects the signing log entries of a stream
+type TailFilter struct {
+	Brand   string
+	Model   string
+	Serial  string
+	Station string
+}
+
+// Matches checks if the signing log entry is selected by the filter
+func (f TailFilter) Matches(l datastore.SigningLog) bool {
+	return (len(f.Brand) == 0 || f.Brand == l.Make) &&
+		(len(f.Model) == 0 || f.Model == l.Model) &&
+		(len(f.Serial) == 0 || f.Serial == l.SerialNumber) &&
+		(len(f.Station) == 0 || f.Station == l.Station)
+}
+
+// APITail is the API method to stream the signing log entries as the devices are signed, as
+// server-sent events. The brand, model, serial and station query parameters filter the entries,
+// and a reconnecting client resumes after the Last-Event-ID
+func (srv *Service) APITail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := TailFilter{Brand: query.Get("brand"), Model: query.Get("model"), Serial: query.Get("serial"), Station: query.Get("station")}
+
+	srv.tailHandler(r.Context(), w, user, true, filter, stream.LastEventID(r))
+}
+
+// tailHandler streams the signing log entries after the ID that match the filter, until the
+// client disconnects. A negative ID streams the entries that are signed from now on
+func (srv *Service) tailHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, filter TailFilter, afterID int) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if afterID < 0 {
+		afterID, err = srv.lastSigningLogID(user)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
+			return
+		}
+	}
+
+	sw, err := stream.New(w)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-stream", "", err.Error(), w)
+		return
+	}
+
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		logs, err := srv.DB.TailAllowedSigningLog(user, afterID, 0)
+		if err != nil {
+			// The entries are fetched again on the next tick
+			log.Message("TAIL", "error-fetch-signinglog", err.Error())
+			continue
+		}
+
+		for _, l := range logs {
+			afterID = l.ID
+			if !filter.Matches(l) {
+				continue
+			}
+			if err := sw.Event(strconv.Itoa(l.ID), SigningEvent, l); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+
+		if time.Since(lastWrite) >= keepAliveInterval {
+			if err := sw.KeepAlive(); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+	}
+}
+
+// lastSigningLogID returns the ID of the latest signing log entry that the user can see
+func (srv *Service) lastSigningLogID(user datastore.User) (int, error) {
+	logs, err := srv.DB.TailAllowedSigningLog(user, -1, 1)
+	if err != nil || len(logs) == 0 {
+		return 0, err
+	}
+	return logs[len(logs)-1].ID, nil
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	"github.com/CanonicalLtd/serial-vault/service/stream"
+	check "gopkg.in/check.v1"
+)
+
+type TailSuite struct {
+	db     *datastoretest.DB
+	server *httptest.Server
+}
+
+var _ = check.Suite(&TailSuite{})
+
+func (s *TailSuite) SetUpTest(c *check.C) {
+	s.db = datastoretest.New()
+	system := s.db.AddAccount(datastore.Account{AuthorityID: "system"})
+	s.db.AddUser(datastore.User{Username: "sv", APIKey: "ValidAPIKey", Role: datastore.Admin, Accounts: []datastore.Account{system}})
+	s.db.AddUser(datastore.User{Username: "user1", APIKey: "ValidAPIKey", Role: datastore.Standard, Accounts: []datastore.Account{system}})
+
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A1").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "ash", "A2").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("other", "alder", "A3").Build())
+
+	config := config.Settings{JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: s.db, Config: config}
+	s.server = httptest.NewServer(service.NewService(datastore.Environ).AdminRouter())
+}
+
+func (s *TailSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *TailSuite) tail(ctx context.Context, c *check.C, query, username, lastEventID string) *http.Response {
+	r, _ := http.NewRequest("GET", s.server.URL+"/api/signinglog/tail"+query, nil)
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+	if len(lastEventID) > 0 {
+		r.Header.Set(stream.LastEventIDHeader, lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	c.Assert(err, check.IsNil)
+	return resp
+}
+
+func (s *TailSuite) nextSigningLog(c *check.C, sr *stream.Reader) datastore.SigningLog {
+	msg, err := sr.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Event, check.Equals, signinglog.SigningEvent)
+
+	l := datastore.SigningLog{}
+	c.Assert(json.Unmarshal([]byte(msg.Data), &l), check.IsNil)
+	return l
+}
+
+func (s *TailSuite) TestTail(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The stream resumes after the last event, with the entries of the model in the user's accounts
+	resp := s.tail(ctx, c, "?model=alder", "sv", "0")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, stream.ContentType)
+
+	sr := stream.NewReader(resp.Body)
+	l := s.nextSigningLog(c, sr)
+	c.Assert(l.Make, check.Equals, "system")
+	c.Assert(l.SerialNumber, check.Equals, "A1")
+
+	// New entries are streamed as they are signed
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "ash", "A4").Build())
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "alder", "A5").Build())
+	l = s.nextSigningLog(c, sr)
+	c.Assert(l.Model, check.Equals, "alder")
+	c.Assert(l.SerialNumber, check.Equals, "A5")
+}
+
+func (s *TailSuite) TestTailFromNow(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp := s.tail(ctx, c, "", "sv", "")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+
+	s.db.AddSigningLog(datastoretest.NewSigningLog("system", "ash", "A6").Build())
+	l := s.nextSigningLog(c, stream.NewReader(resp.Body))
+	c.Assert(l.SerialNumber, check.Equals, "A6")
+}
+
+func (s *TailSuite) TestTailNotAuthorized(c *check.C) {
+	resp := s.tail(context.Background(), c, "", "user1", "")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusBadRequest)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+}
@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/selfcheck"
+)
+
+// Response is the JSON response from the API stats method, with the latency histograms
+// of the requests by endpoint and of the datastore queries by statement
+type Response struct {
+	Success      bool                                 `json:"success"`
+	ErrorCode    string                               `json:"error_code"`
+	ErrorSubcode string                               `json:"error_subcode"`
+	ErrorMessage string                               `json:"message"`
+	Requests     map[string]metrics.HistogramSnapshot `json:"requests"`
+	Queries      map[string]metrics.HistogramSnapshot `json:"queries"`
+	SlowQueries  []metrics.SlowQuery                  `json:"slow-queries"`
+}
+
+// statsHandler is the API method to fetch the request and datastore statistics
+func (srv *Service) statsHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	resp := Response{
+		Success:     true,
+		Requests:    metrics.Requests(),
+		Queries:     metrics.Queries(),
+		SlowQueries: metrics.SlowQueries(),
+	}
+
+	// Return successful JSON response with the statistics
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("Error forming the stats response.")
+	}
+}
+
+// KeystoreResponse is the JSON response from the API keystore method, with the latency
+// histograms of the keystore operations by backend and operation, the signings that wait for a
+// free slot by signing-key or backend, and the recent errors
+type KeystoreResponse struct {
+	Success      bool                                 `json:"success"`
+	ErrorCode    string                               `json:"error_code"`
+	ErrorSubcode string                               `json:"error_subcode"`
+	ErrorMessage string                               `json:"message"`
+	Backend      string                               `json:"backend"`
+	Operations   map[string]metrics.HistogramSnapshot `json:"operations"`
+	Queue        map[string]int64                     `json:"queue"`
+	Errors       []metrics.KeystoreError              `json:"errors"`
+}
+
+// keystoreHandler is the API method to fetch the statistics and the recent errors of the keystore
+func (srv *Service) keystoreHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	resp := KeystoreResponse{
+		Success:    true,
+		Backend:    srv.Config.KeyStoreType,
+		Operations: metrics.Keystore(),
+		Queue:      metrics.KeystoreQueue(),
+		Errors:     metrics.RecentKeystoreErrors(),
+	}
+
+	// Return successful JSON response with the keystore statistics
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("Error forming the keystore stats response.")
+	}
+}
+
+// SelfCheckResponse is the JSON response from the API self-check method
+type SelfCheckResponse struct {
+	Success      bool             `json:"success"`
+	ErrorCode    string           `json:"error_code"`
+	ErrorSubcode string           `json:"error_subcode"`
+	ErrorMessage string           `json:"message"`
+	Report       selfcheck.Report `json:"report"`
+}
+
+// selfCheckHandler is the API method to fetch the report of the startup self-check, which is run
+// again when it is refreshed
+func (srv *Service) selfCheckHandler(w http.ResponseWriter, r *http.Request, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	report := selfcheck.Last()
+	if r.URL.Query().Get("refresh") == "true" {
+		report = selfcheck.Run(r.Context(), srv.Env)
+	}
+
+	// Return successful JSON response with the self-check report
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(SelfCheckResponse{Success: true, Report: report}); err != nil {
+		log.Println("Error forming the self-check response.")
+	}
+}
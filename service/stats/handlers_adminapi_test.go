@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+	"github.com/CanonicalLtd/serial-vault/metrics"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/selfcheck"
+	"github.com/CanonicalLtd/serial-vault/service/stats"
+	check "gopkg.in/check.v1"
+)
+
+func TestStatsSuite(t *testing.T) { check.TestingT(t) }
+
+type StatsSuite struct{}
+
+var _ = check.Suite(&StatsSuite{})
+
+func (s *StatsSuite) SetUpTest(c *check.C) {
+	db := datastoretest.New()
+	db.AddUser(datastore.User{Username: "root", APIKey: "ValidAPIKey", Role: datastore.Superuser})
+	db.AddUser(datastore.User{Username: "admin", APIKey: "ValidAPIKey", Role: datastore.Admin})
+
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue", EnableUserAuth: true}
+	datastore.Environ = &datastore.Env{DB: db, Config: config}
+}
+
+func (s *StatsSuite) TearDownTest(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *StatsSuite) getStats(c *check.C, username string) stats.Response {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/debug/stats", nil)
+	if len(username) > 0 {
+		r.Header.Set("user", username)
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := stats.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *StatsSuite) TestAPIStats(c *check.C) {
+	metrics.RecordSlowQuery(metrics.SlowQuery{Query: "select * from model where id=$1", Params: []string{"int"}, DurationMS: 600, Time: time.Now()})
+
+	// The first request is recorded once it completes, so it is in the statistics of the second
+	s.getStats(c, "root")
+	result := s.getStats(c, "root")
+	c.Assert(result.Success, check.Equals, true)
+
+	requests, ok := result.Requests["GET /api/debug/stats"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(requests.Count >= 1, check.Equals, true)
+	c.Assert(requests.Buckets, check.HasLen, len(metrics.LatencyBuckets)+1)
+
+	c.Assert(len(result.SlowQueries) > 0, check.Equals, true)
+	c.Assert(result.SlowQueries[0].Query, check.Equals, "select * from model where id=$1")
+	c.Assert(result.SlowQueries[0].Params, check.DeepEquals, []string{"int"})
+}
+
+func (s *StatsSuite) TestAPIStatsUnauthorized(c *check.C) {
+	for _, username := range []string{"admin", "invalid", ""} {
+		result := s.getStats(c, username)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, "error-auth")
+		c.Assert(result.Requests, check.IsNil)
+	}
+}
+
+func (s *StatsSuite) TestAPIKeystore(c *check.C) {
+	metrics.ObserveKeystore("filesystem", "unseal", "key1", 3*time.Millisecond, errors.New("MOCK error unsealing the signing-key"))
+	metrics.ObserveKeystore("filesystem", "sign", "key1", 40*time.Millisecond, nil)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/debug/keystore", nil)
+	r.Header.Set("user", "root")
+	r.Header.Set("api-key", "ValidAPIKey")
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := stats.KeystoreResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Backend, check.Equals, "filesystem")
+
+	sign, ok := result.Operations["filesystem sign"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(sign.Count >= 1, check.Equals, true)
+	c.Assert(sign.MaxMS >= 40, check.Equals, true)
+
+	c.Assert(len(result.Errors) > 0, check.Equals, true)
+	c.Assert(result.Errors[0].Operation, check.Equals, "unseal")
+	c.Assert(result.Errors[0].KeyID, check.Equals, "key1")
+	c.Assert(result.Errors[0].Error, check.Equals, "MOCK error unsealing the signing-key")
+}
+
+func (s *StatsSuite) TestAPIKeystoreUnauthorized(c *check.C) {
+	for _, username := range []string{"admin", "invalid", ""} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/debug/keystore", nil)
+		if len(username) > 0 {
+			r.Header.Set("user", username)
+			r.Header.Set("api-key", "ValidAPIKey")
+		}
+		service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+		result := stats.KeystoreResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, "error-auth")
+		c.Assert(result.Operations, check.IsNil)
+	}
+}
+
+func (s *StatsSuite) getSelfCheck(c *check.C, username, url string) stats.SelfCheckResponse {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result := stats.SelfCheckResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *StatsSuite) TestAPISelfCheck(c *check.C) {
+	// The schema version has not been recorded, and the keystore is not open
+	result := s.getSelfCheck(c, "root", "/api/debug/selfcheck?refresh=true")
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Report.Status, check.Equals, selfcheck.StatusError)
+
+	statuses := map[string]string{}
+	for _, ck := range result.Report.Checks {
+		statuses[ck.Name] = ck.Status
+	}
+	c.Assert(statuses["database"], check.Equals, selfcheck.StatusOK)
+	c.Assert(statuses["schema"], check.Equals, selfcheck.StatusError)
+	c.Assert(statuses["models"], check.Equals, selfcheck.StatusOK)
+	c.Assert(statuses["keystore"], check.Equals, selfcheck.StatusError)
+
+	// The report is kept until the self-check is refreshed
+	datastore.Environ.DB.PutSchemaVersion(datastore.SchemaVersion)
+	result = s.getSelfCheck(c, "root", "/api/debug/selfcheck")
+	c.Assert(result.Report.Checks, check.HasLen, len(statuses))
+	c.Assert(result.Report.Checks[1].Status, check.Equals, selfcheck.StatusError)
+
+	result = s.getSelfCheck(c, "root", "/api/debug/selfcheck?refresh=true")
+	c.Assert(result.Report.Checks[1].Name, check.Equals, "schema")
+	c.Assert(result.Report.Checks[1].Status, check.Equals, selfcheck.StatusOK)
+}
+
+func (s *StatsSuite) TestAPISelfCheckUnauthorized(c *check.C) {
+	for _, username := range []string{"admin", "invalid"} {
+		result := s.getSelfCheck(c, username, "/api/debug/selfcheck?refresh=true")
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, "error-auth")
+	}
+}
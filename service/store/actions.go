@@ -29,22 +29,22 @@ import (
 	"github.com/CanonicalLtd/serial-vault/store"
 )
 
-func keyRegisterHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keyAuth store.KeyRegister) {
+func (srv *Service) keyRegisterHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keyAuth store.KeyRegister) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
 	// Check that the user has permissions to this authority-id
-	if !datastore.Environ.DB.CheckUserInAccount(user.Username, keyAuth.AuthorityID) {
+	if !srv.DB.CheckUserInAccount(user.Username, keyAuth.AuthorityID) {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", response.ErrorAuth.Message, w)
 		return
 	}
 
-	keypair, err := datastore.Environ.DB.GetKeypairByName(keyAuth.AuthorityID, keyAuth.KeyName)
+	keypair, err := srv.DB.GetKeypairByName(keyAuth.AuthorityID, keyAuth.KeyName)
 	if err != nil {
 		log.Message("KEYPAIR", response.ErrorFetchKeypair.Code, err.Error())
 		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", "Cannot find the signing key", w)
@@ -24,11 +24,17 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/CanonicalLtd/serial-vault/store"
 )
 
+// Service holds the dependencies of the store handlers
+type Service struct {
+	*datastore.Env
+}
+
 const (
 	ssoBaseURL   = "https://login.ubuntu.com/api/v2/"
 	storeBaseURL = "https://dashboard.snapcraft.io/dev/api/"
@@ -52,8 +58,8 @@ type Auth struct {
 }
 
 // KeyRegister is the API method to upload a signing-key to the store
-func KeyRegister(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) KeyRegister(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -68,5 +74,5 @@ func KeyRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keyRegisterHandler(w, authUser, false, keyAuth)
+	srv.keyRegisterHandler(w, authUser, false, keyAuth)
 }
@@ -56,7 +56,7 @@ var _ = check.Suite(&StoreSuite{})
 
 func (s *StoreSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 
@@ -64,7 +64,7 @@ func (s *StoreSuite) SetUpTest(c *check.C) {
 	store.RegisterKey = mockRegisterKey
 
 	// Disable CSRF for tests as we do not have a secure connection
-	service.MiddlewareWithCSRF = service.Middleware
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
 }
 
 func sendAdminRequest(method, url string, data io.Reader, permissions int, skipJWT bool, c *check.C) *httptest.ResponseRecorder {
@@ -77,7 +77,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, skipJ
 		c.Assert(err, check.IsNil)
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -133,7 +133,7 @@ func mockRegisterKeyError(keyAuth store.KeyRegister, keypair datastore.Keypair)
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, role)
+	jwtToken, err := usso.NewJWTToken(&resp, role, datastore.Environ.Config.JwtSecret)
 	if err != nil {
 		return fmt.Errorf("Error creating a JWT: %v", err)
 	}
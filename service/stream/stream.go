@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package stream writes the server-sent events of the streaming API methods, so the clients are
+// told of the changes as they happen instead of polling the list methods.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of a stream of server-sent events
+const ContentType = "text/event-stream"
+
+// LastEventIDHeader is the request header with the ID of the last event that a reconnecting client received
+const LastEventIDHeader = "Last-Event-ID"
+
+// Writer writes server-sent events to the response, flushing each event to the client
+type Writer struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// New starts a stream of server-sent events on the response
+func New(w http.ResponseWriter) (*Writer, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("The response cannot be streamed")
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tell the proxies not to buffer the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &Writer{w: w, flusher: flusher}, nil
+}
+
+// Event writes an event with the JSON encoding of the data. The ID is sent back by a reconnecting
+// client, and is not sent when it is empty
+func (sw *Writer) Event(id, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	if len(id) > 0 {
+		fmt.Fprintf(&msg, "id: %s\n", id)
+	}
+	fmt.Fprintf(&msg, "event: %s\ndata: %s\n\n", event, b)
+
+	if _, err := sw.w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// KeepAlive writes a comment, so the connection is not closed by the proxies while there are no events
+func (sw *Writer) KeepAlive() error {
+	if _, err := sw.w.Write([]byte(":\n\n")); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// LastEventID returns the ID of the last event that a reconnecting client received, or -1
+func LastEventID(r *http.Request) int {
+	id, err := strconv.Atoi(r.Header.Get(LastEventIDHeader))
+	if err != nil || id < 0 {
+		return -1
+	}
+	return id
+}
+
+// Message is a server-sent event that is read from a stream
+type Message struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// Reader reads the server-sent events of a stream
+type Reader struct {
+	scanner *bufio.Scanner
+}
+
+// NewReader reads the server-sent events of the response body
+func NewReader(body io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(body)}
+}
+
+// Next returns the next event of the stream, skipping the comments. The error is io.EOF when
+// the stream ends
+func (sr *Reader) Next() (Message, error) {
+	msg := Message{}
+	started := false
+	for sr.scanner.Scan() {
+		line := sr.scanner.Text()
+		if len(line) == 0 {
+			if started {
+				return msg, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		started = true
+		switch field {
+		case "id":
+			msg.ID = value
+		case "event":
+			msg.Event = value
+		case "data":
+			if len(msg.Data) > 0 {
+				msg.Data += "\n"
+			}
+			msg.Data += value
+		}
+	}
+	if err := sr.scanner.Err(); err != nil {
+		return msg, err
+	}
+	return msg, io.EOF
+}
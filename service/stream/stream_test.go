@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	check "gopkg.in/check.v1"
+)
+
+func TestStreamSuite(t *testing.T) { check.TestingT(t) }
+
+type StreamSuite struct{}
+
+var _ = check.Suite(&StreamSuite{})
+
+func (s *StreamSuite) TestWriter(c *check.C) {
+	w := httptest.NewRecorder()
+	sw, err := New(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, ContentType)
+
+	c.Assert(sw.Event("7", "signing", map[string]string{"model": "alder"}), check.IsNil)
+	c.Assert(sw.KeepAlive(), check.IsNil)
+	c.Assert(sw.Event("", "alert", "breaker open"), check.IsNil)
+	c.Assert(w.Body.String(), check.Equals, "id: 7\nevent: signing\ndata: {\"model\":\"alder\"}\n\n:\n\nevent: alert\ndata: \"breaker open\"\n\n")
+	c.Assert(w.Flushed, check.Equals, true)
+}
+
+func (s *StreamSuite) TestReader(c *check.C) {
+	sr := NewReader(strings.NewReader(": keep alive\n\nid: 7\nevent: signing\ndata: {\"model\":\ndata: \"alder\"}\n\nevent: alert\ndata:open\n\n"))
+
+	msg, err := sr.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msg, check.DeepEquals, Message{ID: "7", Event: "signing", Data: "{\"model\":\n\"alder\"}"})
+
+	msg, err = sr.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msg, check.DeepEquals, Message{Event: "alert", Data: "open"})
+
+	_, err = sr.Next()
+	c.Assert(err, check.Equals, io.EOF)
+}
+
+func (s *StreamSuite) TestLastEventID(c *check.C) {
+	tests := []struct {
+		Header string
+		ID     int
+	}{
+		{"", -1},
+		{"12", 12},
+		{"invalid", -1},
+		{"-3", -1},
+	}
+
+	for _, t := range tests {
+		r, _ := http.NewRequest("GET", "/api/signinglog/tail", nil)
+		r.Header.Set(LastEventIDHeader, t.Header)
+		c.Assert(LastEventID(r), check.Equals, t.ID)
+	}
+}
@@ -21,6 +21,8 @@ package substore
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 
@@ -39,17 +41,17 @@ type ListResponse struct {
 }
 
 // listHandler is the API method to fetch the list sub-stores
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	//logs, err := datastore.Environ.DB.ListAllowedSigningLog(user)
-	stores, err := datastore.Environ.DB.ListSubstores(accountID, user)
+	//logs, err := srv.DB.ListAllowedSigningLog(user)
+	stores, err := srv.DB.ListSubstores(accountID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-stores-json", "", "", w)
 		return
@@ -60,10 +62,10 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accou
 	formatListResponse(true, "", "", "", stores, w)
 }
 
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, storeID int, store datastore.Substore) {
+func (srv *Service) updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, storeID int, store datastore.Substore) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
@@ -74,7 +76,7 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, sto
 		return
 	}
 
-	err = datastore.Environ.DB.UpdateAllowedSubstore(store, user)
+	err = srv.DB.UpdateAllowedSubstore(store, user)
 	if err != nil {
 		log.Println("Error updating the store:", err)
 		response.FormatStandardResponse(false, "error-stores-substore", "", "Error updating the store", w)
@@ -86,16 +88,16 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, sto
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, store datastore.Substore) {
+func (srv *Service) createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, store datastore.Substore) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	err = datastore.Environ.DB.CreateAllowedSubstore(store, user)
+	err = srv.DB.CreateAllowedSubstore(store, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-stores-json", "", err.Error(), w)
 		return
@@ -106,6 +108,96 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, sto
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
+// ImportResponse is the JSON response from the API sub-stores import method
+type ImportResponse struct {
+	Success      bool                           `json:"success"`
+	ErrorCode    string                         `json:"error_code"`
+	ErrorSubcode string                         `json:"error_subcode"`
+	ErrorMessage string                         `json:"message"`
+	Result       datastore.SubstoreImportResult `json:"result"`
+}
+
+// importHandler is the API method to create the sub-store mappings of a CSV file in bulk
+func (srv *Service) importHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int, body io.Reader) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	account, err := srv.DB.GetAccountByID(accountID, user)
+	if err != nil || account.ID == 0 {
+		response.FormatStandardResponse(false, "error-invalid-account", "", "You do not have permissions to this account", w)
+		return
+	}
+
+	entries, err := datastore.ParseSubstoreCSV(body)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-stores-csv", "", err.Error(), w)
+		return
+	}
+
+	result, err := datastore.ImportSubstores(srv.DB, srv.Config.ModelNameCase, account, entries, user)
+	if err != nil {
+		log.Println("Error importing the sub-stores:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		formatImportResponse(false, "error-stores-import", "", err.Error(), result, w)
+		return
+	}
+	if len(result.Conflicts) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		formatImportResponse(false, "error-stores-conflict", "", fmt.Sprintf("%d serial numbers are mapped to a different sub-store model", len(result.Conflicts)), result, w)
+		return
+	}
+
+	// Return successful JSON response with the import summary
+	w.WriteHeader(http.StatusOK)
+	formatImportResponse(true, "", "", "", result, w)
+}
+
+// exportHandler is the API method to download the sub-store mappings of an account as a CSV file
+func (srv *Service) exportHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	account, err := srv.DB.GetAccountByID(accountID, user)
+	if err != nil || account.ID == 0 {
+		response.FormatStandardResponse(false, "error-invalid-account", "", "You do not have permissions to this account", w)
+		return
+	}
+
+	stores, err := srv.DB.ListSubstores(accountID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-stores-json", "", "", w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"substores-%s.csv\"", account.AuthorityID))
+	w.WriteHeader(http.StatusOK)
+	if err := datastore.WriteSubstoreCSV(w, stores); err != nil {
+		log.Println("Error writing the sub-stores export:", err)
+	}
+}
+
+func formatImportResponse(success bool, errorCode, errorSubcode, message string, result datastore.SubstoreImportResult, w http.ResponseWriter) error {
+	response := ImportResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Result: result}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the sub-stores import response.")
+		return err
+	}
+	return nil
+}
+
 func formatListResponse(success bool, errorCode, errorSubcode, message string, stores []datastore.Substore, w http.ResponseWriter) error {
 	response := ListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Substores: stores}
 
@@ -117,16 +209,16 @@ func formatListResponse(success bool, errorCode, errorSubcode, message string, s
 	return nil
 }
 
-func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, storeID int) {
+func (srv *Service) deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, storeID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	errorSubcode, err := datastore.Environ.DB.DeleteAllowedSubstore(storeID, user)
+	errorSubcode, err := srv.DB.DeleteAllowedSubstore(storeID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-deleting-store", errorSubcode, err.Error(), w)
 		return
@@ -32,11 +32,11 @@ import (
 )
 
 // APIList is the API method to fetch the sub-store models
-func APIList(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -50,15 +50,15 @@ func APIList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true, accountID)
+	srv.listHandler(w, user, true, accountID)
 }
 
 // APIUpdate is the API method to update a sub-store model
-func APIUpdate(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIUpdate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -86,15 +86,15 @@ func APIUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	updateHandler(w, user, true, storeID, store)
+	srv.updateHandler(w, user, true, storeID, store)
 }
 
 // APICreate is the API method to create a sub-store model
-func APICreate(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APICreate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -115,15 +115,15 @@ func APICreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	createHandler(w, user, true, store)
+	srv.createHandler(w, user, true, store)
 }
 
 // APIDelete is the API method to delete a sub-store model
-func APIDelete(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIDelete(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -137,5 +137,51 @@ func APIDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	deleteHandler(w, user, true, storeID)
+	srv.deleteHandler(w, user, true, storeID)
+}
+
+// APIImport is the API method to create the sub-store mappings of a CSV file in bulk
+func (srv *Service) APIImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Call the API with the user
+	srv.importHandler(w, user, true, accountID, r.Body)
+}
+
+// APIExport is the API method to download the sub-store mappings as a CSV file
+func (srv *Service) APIExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.exportHandler(w, user, true, accountID)
 }
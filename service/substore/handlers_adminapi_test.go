@@ -29,6 +29,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/substore"
 	check "gopkg.in/check.v1"
 )
 
@@ -99,6 +100,65 @@ func (s *SubstoreSuite) TestAPICreateUpdateDeleteHandler(c *check.C) {
 	}
 }
 
+func (s *SubstoreSuite) TestAPIImportHandler(c *check.C) {
+	valid := []byte("model,store,serialnumber,modelname\nalder,mybrand,abc1230..abc1239,alder-mybrand\n")
+	conflict := []byte("model,store,serialnumber,modelname\nalder,mybrand,abc1230..abc1239,alder-other\n")
+	invalid := []byte("model,store,serialnumber,modelname\nalder,mybrand,abc1230..abd1239,alder-mybrand\n")
+
+	tests := []struct {
+		Data        []byte
+		Code        int
+		Permissions int
+		Success     bool
+		ErrorCode   string
+		Imported    int
+		Conflicts   int
+	}{
+		{valid, 400, 0, false, "error-auth", 0, 0},
+		{valid, 400, datastore.Standard, false, "error-auth", 0, 0},
+		{valid, 200, datastore.Admin, true, "", 9, 0},
+		{conflict, 400, datastore.Admin, false, "error-stores-conflict", 0, 1},
+		{invalid, 400, datastore.Admin, false, "error-stores-import", 0, 0},
+		{[]byte("serial\nabc1234\n"), 400, datastore.Admin, false, "error-stores-csv", 0, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = true
+
+		w := sendAdminAPIRequest("POST", "/api/accounts/1/stores/import", bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := substore.ImportResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.ErrorCode, check.Equals, t.ErrorCode)
+		c.Assert(result.Result.Imported, check.Equals, t.Imported)
+		c.Assert(len(result.Result.Conflicts), check.Equals, t.Conflicts)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SubstoreSuite) TestAPIExportHandler(c *check.C) {
+	datastore.Environ.Config.EnableUserAuth = true
+	defer func() { datastore.Environ.Config.EnableUserAuth = false }()
+
+	w := sendAdminAPIRequest("GET", "/api/accounts/1/stores/export", nil, datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, "text/csv; charset=UTF-8")
+	c.Assert(w.Header().Get("Content-Disposition"), check.Equals, `attachment; filename="substores-system.csv"`)
+	c.Assert(w.Body.String(), check.Equals, "model,store,serialnumber,modelname\nalder,mybrand,abc1234,alder-mybrand\nalder,mybrand,abc5678,alder-mybrand\n")
+
+	w = sendAdminAPIRequest("GET", "/api/accounts/1/stores/export", nil, datastore.Standard, c)
+	c.Assert(w.Code, check.Equals, 400)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+	w = sendAdminAPIRequest("GET", "/api/accounts/99/stores/export", nil, datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
 func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
@@ -114,7 +174,7 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -31,11 +31,16 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the sub-store handlers
+type Service struct {
+	*datastore.Env
+}
+
 // List is the API method to fetch the sub-store models
-func List(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -48,14 +53,14 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false, accountID)
+	srv.listHandler(w, authUser, false, accountID)
 }
 
 // Update is the API method to update a sub-store model
-func Update(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Update(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -84,14 +89,14 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateHandler(w, authUser, false, storeID, store)
+	srv.updateHandler(w, authUser, false, storeID, store)
 }
 
 // Create is the API method to create a sub-store model
-func Create(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Create(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -113,14 +118,14 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, store)
+	srv.createHandler(w, authUser, false, store)
 }
 
 // Delete is the API method to delete a sub-store model
-func Delete(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Delete(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -133,5 +138,47 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, authUser, false, storeID)
+	srv.deleteHandler(w, authUser, false, storeID)
+}
+
+// Import is the API method to create the sub-store mappings of a CSV file in bulk
+func (srv *Service) Import(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	srv.importHandler(w, authUser, false, accountID, r.Body)
+}
+
+// Export is the API method to download the sub-store mappings as a CSV file
+func (srv *Service) Export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	srv.exportHandler(w, authUser, false, accountID)
 }
@@ -0,0 +1,103 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package systemd_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/systemd"
+	check "gopkg.in/check.v1"
+)
+
+func TestSystemdSuite(t *testing.T) { check.TestingT(t) }
+
+type SystemdSuite struct{}
+
+var _ = check.Suite(&SystemdSuite{})
+
+func (s *SystemdSuite) TearDownTest(c *check.C) {
+	for _, k := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID", "LISTEN_PID", "LISTEN_FDS"} {
+		os.Unsetenv(k)
+	}
+}
+
+func (s *SystemdSuite) TestNotify(c *check.C) {
+	// Not supervised by systemd
+	sent, err := systemd.Notify(systemd.Ready)
+	c.Assert(err, check.IsNil)
+	c.Assert(sent, check.Equals, false)
+
+	path := filepath.Join(c.MkDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", path)
+
+	sent, err = systemd.Notify(systemd.Ready)
+	c.Assert(err, check.IsNil)
+	c.Assert(sent, check.Equals, true)
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buf[:n]), check.Equals, "READY=1")
+}
+
+func (s *SystemdSuite) TestNotifyError(c *check.C) {
+	os.Setenv("NOTIFY_SOCKET", filepath.Join(c.MkDir(), "missing.sock"))
+
+	sent, err := systemd.Notify(systemd.Ready)
+	c.Assert(err, check.NotNil)
+	c.Assert(sent, check.Equals, false)
+}
+
+func (s *SystemdSuite) TestWatchdogInterval(c *check.C) {
+	c.Assert(systemd.WatchdogInterval(), check.Equals, time.Duration(0))
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	c.Assert(systemd.WatchdogInterval(), check.Equals, 15*time.Second)
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	c.Assert(systemd.WatchdogInterval(), check.Equals, 15*time.Second)
+
+	// The watchdog is for another process
+	os.Setenv("WATCHDOG_PID", "1")
+	c.Assert(systemd.WatchdogInterval(), check.Equals, time.Duration(0))
+}
+
+func (s *SystemdSuite) TestListenersNotActivated(c *check.C) {
+	listeners, err := systemd.Listeners()
+	c.Assert(err, check.IsNil)
+	c.Assert(listeners, check.HasLen, 0)
+
+	// The sockets are for another process
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err = systemd.Listeners()
+	c.Assert(err, check.IsNil)
+	c.Assert(listeners, check.HasLen, 0)
+	c.Assert(os.Getenv("LISTEN_FDS"), check.Equals, "")
+}
@@ -40,16 +40,16 @@ type ListResponse struct {
 }
 
 // listHandler is the API method to fetch the log records from signing
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	logs, err := datastore.Environ.DB.ListAllowedTestLog(user)
+	logs, err := srv.DB.ListAllowedTestLog(user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
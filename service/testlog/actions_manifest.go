@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package testlog
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ManifestsResponse is the JSON response from the API Device Manifests method
+type ManifestsResponse struct {
+	Success      bool                       `json:"success"`
+	ErrorCode    string                     `json:"error_code"`
+	ErrorSubcode string                     `json:"error_subcode"`
+	ErrorMessage string                     `json:"message"`
+	Manifests    []datastore.DeviceManifest `json:"manifests"`
+}
+
+// manifestHandler stores the manifest of a device that has been signed
+func (srv *Service) manifestHandler(w http.ResponseWriter, user datastore.User, apiCall bool, manifest datastore.DeviceManifest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if err = datastore.ValidateDeviceManifest(manifest); err != nil {
+		response.FormatStandardResponse(false, "error-manifest-data", "", err.Error(), w)
+		return
+	}
+
+	// The manifest is linked to the serial number of a signed device
+	logs, err := srv.DB.ListSerialSigningLog(manifest.Brand, manifest.Model, manifest.SerialNumber)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-manifest-serial", "", err.Error(), w)
+		return
+	}
+	if len(logs) == 0 {
+		response.FormatStandardResponse(false, "error-manifest-serial", "", "The serial number has not been signed for the model", w)
+		return
+	}
+
+	err = srv.DB.CreateAllowedDeviceManifest(manifest, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-manifest-create", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// listManifestsHandler finds the device manifests of an account, e.g. the devices that
+// shipped with a revision of a snap
+func (srv *Service) listManifestsHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, query datastore.DeviceManifestQuery) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	manifests, err := srv.DB.ListAllowedDeviceManifests(user, authorityID, query)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-manifests", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of manifests
+	w.WriteHeader(http.StatusOK)
+	formatManifestsResponse(true, "", "", "", manifests, w)
+}
+
+func formatManifestsResponse(success bool, errorCode, errorSubcode, message string, manifests []datastore.DeviceManifest, w http.ResponseWriter) error {
+	response := ManifestsResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Manifests: manifests}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error forming the device manifests response.")
+		return err
+	}
+	return nil
+}
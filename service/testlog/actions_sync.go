@@ -29,10 +29,10 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
-func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, testLog datastore.TestLog) {
+func (srv *Service) syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, testLog datastore.TestLog) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
@@ -51,7 +51,7 @@ func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, te
 	}
 
 	// Create the test log record
-	err = datastore.Environ.DB.CreateTestLog(testLog)
+	err = srv.DB.CreateTestLog(testLog)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-testlog-create", "", err.Error(), w)
 		return
@@ -62,17 +62,17 @@ func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, te
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func syncUpdateLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, logID int) {
+func (srv *Service) syncUpdateLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, logID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
 	// Update the test log record to indicate that it's been synced
-	err = datastore.Environ.DB.UpdateAllowedTestLog(logID, user)
+	err = srv.DB.UpdateAllowedTestLog(logID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-testlog-update", "", err.Error(), w)
 		return
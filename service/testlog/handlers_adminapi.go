@@ -32,10 +32,15 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the test log handlers
+type Service struct {
+	*datastore.Env
+}
+
 // APISyncLog is the API method to sync a factory test log to the cloud
-func APISyncLog(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APISyncLog(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -55,26 +60,26 @@ func APISyncLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	syncLogHandler(w, user, true, request)
+	srv.syncLogHandler(w, user, true, request)
 }
 
 // APIListLog is the API method to list the unsync-ed factory test logs
-func APIListLog(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APIListLog(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	srv.listHandler(w, user, true)
 }
 
 // APISyncUpdateLog is the API method to marka test log as synced
-func APISyncUpdateLog(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) APISyncUpdateLog(w http.ResponseWriter, r *http.Request) {
 	// Validate the user and API key
-	user, err := request.CheckUserAPI(r)
+	user, err := request.CheckUserAPI(r, srv.DB)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -88,5 +93,53 @@ func APISyncUpdateLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	syncUpdateLogHandler(w, user, true, logID)
+	srv.syncUpdateLogHandler(w, user, true, logID)
+}
+
+// APIDeviceManifest is the API method to store the manifest of a signed device, after the
+// signing or synced from the factory
+func (srv *Service) APIDeviceManifest(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	manifest := datastore.DeviceManifest{}
+	err = json.NewDecoder(r.Body).Decode(&manifest)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-manifest-data", "", "No manifest data supplied", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-manifest-json", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	srv.manifestHandler(w, user, true, manifest)
+}
+
+// APIListDeviceManifests is the API method to find the device manifests of an account
+func (srv *Service) APIListDeviceManifests(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r, srv.DB)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	query := datastore.DeviceManifestQuery{
+		Model:        r.FormValue("model"),
+		SerialNumber: r.FormValue("serial"),
+		Snap:         r.FormValue("snap"),
+		Revision:     r.FormValue("revision"),
+	}
+
+	// Call the API with the user
+	srv.listManifestsHandler(w, user, true, vars["authorityID"], query)
 }
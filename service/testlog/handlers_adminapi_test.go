@@ -163,6 +163,87 @@ func (s *LogSuite) TestAPIUpdateLogHandler(c *check.C) {
 	}
 }
 
+func (s *LogSuite) TestAPIDeviceManifestHandler(c *check.C) {
+	m1 := datastore.DeviceManifest{
+		Brand: "system", Model: "alder", SerialNumber: "A1", ImageVersion: "20260901",
+		Snaps:      []datastore.ManifestSnap{{Name: "pc-kernel", Revision: "1001"}},
+		Components: []datastore.ManifestComponent{{Name: "wifi", Vendor: "Acme", Version: "1.2"}},
+	}
+	manifest1, err := json.Marshal(m1)
+	c.Assert(err, check.IsNil)
+
+	m2 := m1
+	m2.SerialNumber = "A999"
+	manifest2, err := json.Marshal(m2)
+	c.Assert(err, check.IsNil)
+
+	m3 := m1
+	m3.Snaps = []datastore.ManifestSnap{{Name: "pc-kernel"}}
+	manifest3, err := json.Marshal(m3)
+	c.Assert(err, check.IsNil)
+
+	tests := []SyncTest{
+		{"POST", "/api/manifests", []byte("bad"), 400, response.JSONHeader, datastore.SyncUser, false, false, false, 0},
+		{"POST", "/api/manifests", []byte(""), 400, response.JSONHeader, datastore.SyncUser, false, false, false, 0},
+		{"POST", "/api/manifests", manifest2, 400, response.JSONHeader, datastore.SyncUser, false, false, false, 0},
+		{"POST", "/api/manifests", manifest3, 400, response.JSONHeader, datastore.SyncUser, false, false, false, 0},
+		{"POST", "/api/manifests", manifest1, 400, response.JSONHeader, datastore.SyncUser, false, false, true, 0},
+		{"POST", "/api/manifests", manifest1, 400, response.JSONHeader, datastore.Standard, true, false, false, 0},
+		{"POST", "/api/manifests", manifest1, 400, response.JSONHeader, 0, true, false, false, 0},
+		{"POST", "/api/manifests", manifest1, 200, response.JSONHeader, datastore.SyncUser, true, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *LogSuite) TestAPIListDeviceManifestsHandler(c *check.C) {
+	tests := []SyncTest{
+		{"GET", "/api/manifests/account/system", nil, 400, response.JSONHeader, datastore.Standard, false, false, false, 0},
+		{"GET", "/api/manifests/account/system", nil, 400, response.JSONHeader, datastore.SyncUser, false, false, false, 0},
+		{"GET", "/api/manifests/account/system", nil, 400, response.JSONHeader, datastore.Admin, false, false, true, 0},
+		{"GET", "/api/manifests/account/system", nil, 200, response.JSONHeader, datastore.Admin, false, true, false, 2},
+		{"GET", "/api/manifests/account/system?snap=pc-kernel&revision=1002", nil, 200, response.JSONHeader, datastore.Admin, false, true, false, 1},
+		{"GET", "/api/manifests/account/system?serial=A3", nil, 200, response.JSONHeader, datastore.Admin, false, true, false, 0},
+		{"GET", "/api/manifests/account/other", nil, 200, response.JSONHeader, datastore.Admin, false, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := testlog.ManifestsResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Manifests), check.Equals, t.Count)
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
 func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
@@ -171,6 +252,9 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 	case datastore.SyncUser:
 		r.Header.Set("user", "sync")
 		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.Admin:
+		r.Header.Set("user", "sv")
+		r.Header.Set("api-key", "ValidAPIKey")
 	case datastore.Standard:
 		r.Header.Set("user", "user1")
 		r.Header.Set("api-key", "ValidAPIKey")
@@ -178,7 +262,7 @@ func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c
 		break
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
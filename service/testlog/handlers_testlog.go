@@ -83,14 +83,14 @@ func Index(w http.ResponseWriter, r *http.Request) {
 }
 
 // Submit is the POST request for caching a test log
-func Submit(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Submit(w http.ResponseWriter, r *http.Request) {
 	if strings.TrimSpace(r.FormValue("brand")) == "" || strings.TrimSpace(r.FormValue("model")) == "" {
 		formatUploadResponse(w, http.StatusBadRequest, "The 'brand' and 'model' must be supplied")
 		return
 	}
 
 	// Check that the model exists
-	if found := datastore.Environ.DB.CheckModelExists(r.FormValue("brand"), r.FormValue("model")); !found {
+	if found := srv.DB.CheckModelExists(r.FormValue("brand"), r.FormValue("model")); !found {
 		formatUploadResponse(w, http.StatusBadRequest, "The model does not exist")
 		return
 	}
@@ -107,7 +107,7 @@ func Submit(w http.ResponseWriter, r *http.Request) {
 		Brand: r.FormValue("brand"), Model: r.FormValue("model"),
 		Filename: filename, Data: base64File,
 	}
-	err = datastore.Environ.DB.CreateTestLog(t)
+	err = srv.DB.CreateTestLog(t)
 	if err != nil {
 		formatUploadResponse(w, http.StatusBadRequest, err.Error())
 		return
@@ -56,7 +56,7 @@ var _ = check.Suite(&LogSuite{})
 
 func (s *LogSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{Driver: "sqlite3", KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{Driver: "sqlite3", KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 }
@@ -74,7 +74,7 @@ func sendSigningRequest(method, url string, modelName, path string, withFile boo
 		r, _ = http.NewRequest(method, url, nil)
 	}
 
-	service.SigningRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).SigningRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -0,0 +1,215 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultACMEHTTPAddress is the address that answers the http-01 challenges of the ACME server
+const DefaultACMEHTTPAddress = ":80"
+
+// ListenAndServe serves the requests on the address of the server, terminating TLS when it is
+// set in the config
+func (srv *Service) ListenAndServe(server *http.Server) error {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(server, listener)
+}
+
+// Serve serves the requests on the listener, terminating TLS when it is set in the config: with
+// the certificates of an ACME server, or with the certificate files
+func (srv *Service) Serve(server *http.Server, listener net.Listener) error {
+	// The listener is closed when the service cannot be started
+	defer listener.Close()
+
+	certFile, keyFile := srv.Env.Config.TLSCertFile, srv.Env.Config.TLSKeyFile
+
+	switch {
+	case len(srv.Env.Config.ACMEDomains) > 0:
+		manager, err := srv.acmeManager()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: manager.GetCertificate}
+		if err := srv.verifyClients(server.TLSConfig); err != nil {
+			return err
+		}
+
+		// The challenges are also answered by the other services that share the cache, so the
+		// service continues when the address is in use
+		go func() {
+			if err := http.ListenAndServe(srv.acmeHTTPAddress(), manager.HTTPHandler(nil)); err != nil {
+				svlog.Errorf("Error answering the ACME challenges: %v", err)
+			}
+		}()
+		return server.ServeTLS(listener, "", "")
+
+	case len(certFile) == 0 && len(keyFile) == 0:
+		return server.Serve(listener)
+
+	case len(certFile) == 0 || len(keyFile) == 0:
+		return errors.New("Both the tlsCertFile and the tlsKeyFile must be set to terminate TLS")
+
+	default:
+		reloader, err := NewCertificateReloader(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+		if err := srv.verifyClients(server.TLSConfig); err != nil {
+			return err
+		}
+		return server.ServeTLS(listener, "", "")
+	}
+}
+
+// verifyClients verifies the client certificates of the TLS connections with the client CAs of the
+// config, for the mtls authentication scheme. The clients need not present a certificate, as that
+// is enforced by the authentication rules of the endpoint groups
+func (srv *Service) verifyClients(config *tls.Config) error {
+	if len(srv.Env.Config.TLSClientCAFile) == 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(srv.Env.Config.TLSClientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.New("The tlsClientCAFile has no valid PEM certificates")
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// acmeManager obtains and renews the certificates of the domains from the ACME server
+func (srv *Service) acmeManager() (*autocert.Manager, error) {
+	if len(srv.Env.Config.ACMECacheDir) == 0 {
+		return nil, errors.New("The acmeCacheDir must be set to manage the certificates with ACME")
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(srv.Env.Config.ACMEDomains...),
+		Cache:      autocert.DirCache(srv.Env.Config.ACMECacheDir),
+		Email:      srv.Env.Config.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: srv.Env.Config.ACMEDirectoryURL},
+	}, nil
+}
+
+// acmeHTTPAddress returns the address that answers the http-01 challenges from the config
+func (srv *Service) acmeHTTPAddress() string {
+	if len(srv.Env.Config.ACMEHTTPAddress) > 0 {
+		return srv.Env.Config.ACMEHTTPAddress
+	}
+	return DefaultACMEHTTPAddress
+}
+
+// CertificateReloader serves the certificate of the TLS key pair files, reloading it when the
+// files change e.g. when the certificate is renewed. The last valid certificate is served when
+// the files cannot be loaded, e.g. while they are written
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// NewCertificateReloader loads the certificate of the TLS key pair files
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	cr := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the certificate for the TLS handshake, reloading it when the files
+// have changed since it was loaded
+func (cr *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	if modified, err := cr.lastModified(); err == nil && !modified.Equal(cr.modified) {
+		if err := cr.load(); err != nil {
+			svlog.Errorf("Error reloading the TLS certificate: %v", err)
+		}
+	}
+	return cr.cert, nil
+}
+
+func (cr *CertificateReloader) reload() error {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	return cr.load()
+}
+
+// load reads the key pair files. The lock must be held
+func (cr *CertificateReloader) load() error {
+	modified, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+
+	// The files are not loaded again until they change, even when they are invalid
+	cr.modified = modified
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	return nil
+}
+
+// lastModified returns the latest modification time of the key pair files
+func (cr *CertificateReloader) lastModified() (time.Time, error) {
+	var modified time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	check "gopkg.in/check.v1"
+)
+
+type TLSSuite struct {
+	certFile string
+	keyFile  string
+}
+
+var _ = check.Suite(&TLSSuite{})
+
+func (s *TLSSuite) SetUpTest(c *check.C) {
+	dir := c.MkDir()
+	s.certFile = filepath.Join(dir, "cert.pem")
+	s.keyFile = filepath.Join(dir, "key.pem")
+}
+
+// writeKeyPair writes a self-signed certificate with the serial number, modified at the time
+func (s *TLSSuite) writeKeyPair(c *check.C, serial int64, modified time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "serial-vault.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	c.Assert(err, check.IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(ioutil.WriteFile(s.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(s.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), check.IsNil)
+	c.Assert(os.Chtimes(s.certFile, modified, modified), check.IsNil)
+	c.Assert(os.Chtimes(s.keyFile, modified, modified), check.IsNil)
+}
+
+func (s *TLSSuite) serial(c *check.C, cr *CertificateReloader) int64 {
+	cert, err := cr.GetCertificate(&tls.ClientHelloInfo{})
+	c.Assert(err, check.IsNil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	c.Assert(err, check.IsNil)
+	return leaf.SerialNumber.Int64()
+}
+
+func (s *TLSSuite) TestCertificateReloader(c *check.C) {
+	_, err := NewCertificateReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.NotNil)
+
+	modified := time.Now().Add(-time.Minute)
+	s.writeKeyPair(c, 1, modified)
+	cr, err := NewCertificateReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.serial(c, cr), check.Equals, int64(1))
+
+	// The renewed certificate is served without restarting the service
+	s.writeKeyPair(c, 2, modified.Add(time.Second))
+	c.Assert(s.serial(c, cr), check.Equals, int64(2))
+
+	// The last valid certificate is served while the files are invalid
+	c.Assert(ioutil.WriteFile(s.keyFile, []byte("invalid"), 0600), check.IsNil)
+	c.Assert(os.Chtimes(s.keyFile, modified.Add(2*time.Second), modified.Add(2*time.Second)), check.IsNil)
+	c.Assert(s.serial(c, cr), check.Equals, int64(2))
+
+	s.writeKeyPair(c, 3, modified.Add(3*time.Second))
+	c.Assert(s.serial(c, cr), check.Equals, int64(3))
+}
+
+func (s *TLSSuite) TestListenAndServeErrors(c *check.C) {
+	tests := []struct {
+		Config config.Settings
+		Error  string
+	}{
+		{config.Settings{TLSCertFile: s.certFile}, "Both the tlsCertFile and the tlsKeyFile must be set to terminate TLS"},
+		{config.Settings{TLSCertFile: s.certFile, TLSKeyFile: s.keyFile}, ".*no such file or directory"},
+		{config.Settings{ACMEDomains: []string{"serial-vault.example.com"}}, "The acmeCacheDir must be set to manage the certificates with ACME"},
+	}
+
+	for _, t := range tests {
+		srv := NewService(&datastore.Env{Config: t.Config})
+		err := srv.ListenAndServe(srv.HTTPServer(":0", nil))
+		c.Assert(err, check.ErrorMatches, t.Error)
+	}
+}
+
+func (s *TLSSuite) TestACMEHTTPAddress(c *check.C) {
+	srv := NewService(&datastore.Env{})
+	c.Assert(srv.acmeHTTPAddress(), check.Equals, DefaultACMEHTTPAddress)
+
+	srv = NewService(&datastore.Env{Config: config.Settings{ACMEHTTPAddress: ":8000"}})
+	c.Assert(srv.acmeHTTPAddress(), check.Equals, ":8000")
+}
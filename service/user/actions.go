@@ -58,16 +58,16 @@ type AccountsResponse struct {
 }
 
 // listHandler is the API method to fetch the user records
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func (srv *Service) listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	users, err := datastore.Environ.DB.ListUsers()
+	users, err := srv.DB.ListUsers()
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-users", "", err.Error(), w)
 		return
@@ -79,16 +79,16 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 }
 
 // getHandler is the API method to fetch the log records from signing
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
+func (srv *Service) getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	u, err := datastore.Environ.DB.GetUser(userID)
+	u, err := srv.DB.GetUser(userID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-users", "", err.Error(), w)
 		return
@@ -99,16 +99,16 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID
 	formatUserResponse(u, w)
 }
 
-func createHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
+func (srv *Service) createHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	user.ID, err = datastore.Environ.DB.CreateUser(user)
+	user.ID, err = srv.DB.CreateUser(user)
 	if err != nil {
 		svlog.Error("error-creating-user", err)
 		response.FormatStandardResponse(false, "error-creating-user", "", err.Error(), w)
@@ -142,16 +142,16 @@ func formatUserResponse(user datastore.User, w http.ResponseWriter) error {
 	return nil
 }
 
-func updateHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
+func (srv *Service) updateHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	err = datastore.Environ.DB.UpdateUser(user)
+	err = srv.DB.UpdateUser(user)
 	if err != nil {
 		log.Println("Error updating the store:", err)
 		response.FormatStandardResponse(false, "error-stores-substore", "", "Error updating the store", w)
@@ -163,16 +163,16 @@ func updateHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool,
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
+func (srv *Service) deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	err = datastore.Environ.DB.DeleteUser(userID)
+	err = srv.DB.DeleteUser(userID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-deleting-user", "", err.Error(), w)
 		return
@@ -183,22 +183,22 @@ func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, use
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func getOtherAccountsHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
+func (srv *Service) getOtherAccountsHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth2", "", "", w)
 		return
 	}
 
-	user, err := datastore.Environ.DB.GetUser(userID)
+	user, err := srv.DB.GetUser(userID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-get-user", "", err.Error(), w)
 		return
 	}
 
-	accounts, err := datastore.Environ.DB.ListNotUserAccounts(user.Username)
+	accounts, err := srv.DB.ListNotUserAccounts(user.Username)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-get-non-user-accounts", "", err.Error(), w)
 		return
@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// SyncModelsResponse is the JSON response from the API sync user's models method
+type SyncModelsResponse struct {
+	Success      bool                            `json:"success"`
+	ErrorCode    string                          `json:"error_code"`
+	ErrorSubcode string                          `json:"error_subcode"`
+	ErrorMessage string                          `json:"message"`
+	Scoped       bool                            `json:"scoped"` // only the signing-keys of the models are synced
+	Models       []datastore.SyncModelAssignment `json:"models"`
+}
+
+// SyncModelScopeRequest is the JSON request to set if a sync user is model-scoped
+type SyncModelScopeRequest struct {
+	Scoped bool `json:"scoped"`
+}
+
+// listSyncModelsHandler is the API method to fetch the models assigned to a sync user
+func (srv *Service) listSyncModelsHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	srv.formatSyncModels(w, userID)
+}
+
+// syncModelsHandler is the API method for a sync user to fetch its own assigned models
+func (srv *Service) syncModelsHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.SyncUser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	srv.formatSyncModels(w, authUser.ID)
+}
+
+// createSyncModelHandler is the API method to assign a model to a sync user
+func (srv *Service) createSyncModelHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, assignment datastore.SyncModelAssignment) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = srv.DB.CreateSyncModelAssignment(assignment)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-creating-sync-model", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// deleteSyncModelHandler is the API method to remove a model from a sync user
+func (srv *Service) deleteSyncModelHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, assignmentID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = srv.DB.DeleteSyncModelAssignment(assignmentID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-deleting-sync-model", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// scopeSyncModelsHandler is the API method to set if a sync user only receives the signing-keys
+// of its assigned models. A model-scoped user without models receives no signing-keys
+func (srv *Service) scopeSyncModelsHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int, scope SyncModelScopeRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall, srv.Config)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = srv.DB.SetSyncModelScope(userID, scope.Scoped)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-sync-model-scope", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// formatSyncModels writes the models assigned to the sync user and its scope as the response
+func (srv *Service) formatSyncModels(w http.ResponseWriter, userID int) {
+	scoped, err := srv.DB.SyncModelScoped(userID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-sync-models", "", err.Error(), w)
+		return
+	}
+
+	models, err := srv.DB.ListSyncModelAssignments(userID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-sync-models", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of models
+	w.WriteHeader(http.StatusOK)
+	formatSyncModelsResponse(scoped, models, w)
+}
+
+func formatSyncModelsResponse(scoped bool, models []datastore.SyncModelAssignment, w http.ResponseWriter) error {
+	response := SyncModelsResponse{Success: true, Scoped: scoped, Models: models}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		svlog.Error("error-sync-models-response", err)
+		return err
+	}
+	return nil
+}
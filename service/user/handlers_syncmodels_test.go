@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package user_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/user"
+	check "gopkg.in/check.v1"
+)
+
+func (s *ServiceSuite) TestSyncModelsHandler(c *check.C) {
+	tests := []UserTest{
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":6, "modelID":1}`), 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":6, "modelID":1, "valid-from":"2018-01-01T00:00:00Z", "valid-until":"2018-07-01T00:00:00Z", "max-units":1000}`), 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":6, "modelID":1, "valid-from":"2018-07-01T00:00:00Z", "valid-until":"2018-01-01T00:00:00Z"}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":6, "modelID":1, "max-units":-1}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":3, "modelID":1}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":6, "modelID":1}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`invalid`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/syncmodels", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"GET", "/v1/users/6/syncmodels", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 2},
+		{"GET", "/v1/users/3/syncmodels", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"GET", "/v1/users/6/syncmodels", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"DELETE", "/v1/users/syncmodels/1", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"DELETE", "/v1/users/syncmodels/1", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"PUT", "/v1/users/6/syncmodels/scope", []byte(`{"scoped":false}`), 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"PUT", "/v1/users/6/syncmodels/scope", []byte(`{"scoped":true}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"PUT", "/v1/users/6/syncmodels/scope", []byte(`invalid`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"PUT", "/v1/users/6/syncmodels/scope", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseSyncModelsResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Models), check.Equals, t.List)
+	}
+}
+
+func (s *ServiceSuite) TestSyncModelsHandlerWithError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = false
+
+	tests := []UserTest{
+		{"GET", "/v1/users/6/syncmodels", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"POST", "/v1/users/syncmodels", []byte(`{"userID":6, "modelID":1}`), 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"DELETE", "/v1/users/syncmodels/1", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"PUT", "/v1/users/6/syncmodels/scope", []byte(`{"scoped":true}`), 400, "application/json; charset=UTF-8", 0, false, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		result, err := parseSyncModelsResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
+func (s *ServiceSuite) TestAPISyncModels(c *check.C) {
+	until := time.Now().Add(24 * time.Hour)
+	err := datastore.Environ.DB.CreateSyncModelAssignment(datastore.SyncModelAssignment{UserID: 6, ModelID: 1, ValidUntil: &until, MaxUnits: 10})
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		User    string
+		Code    int
+		Success bool
+		List    int
+	}{
+		{"sync", 200, true, 1},
+		{"sv", 200, true, 0},
+		{"user1", 400, false, 0},
+		{"invalid", 400, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendSyncModelsRequest(t.User)
+		c.Assert(w.Code, check.Equals, t.Code)
+		result, err := parseSyncModelsResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Models), check.Equals, t.List)
+	}
+
+	result, _ := parseSyncModelsResponse(sendSyncModelsRequest("sync"))
+	c.Assert(result.Scoped, check.Equals, true)
+	c.Assert(result.Models[0].MaxUnits, check.Equals, 10)
+	c.Assert(result.Models[0].ValidFrom, check.IsNil)
+	c.Assert(result.Models[0].ValidUntil.Unix(), check.Equals, until.Unix())
+}
+
+func sendSyncModelsRequest(username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/syncmodels", nil)
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", "ValidAPIKey")
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+	return w
+}
+
+func parseSyncModelsResponse(w *httptest.ResponseRecorder) (user.SyncModelsResponse, error) {
+	// Check the JSON response
+	result := user.SyncModelsResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
@@ -31,6 +31,11 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Service holds the dependencies of the user handlers
+type Service struct {
+	*datastore.Env
+}
+
 // Request is the JSON version of the request to create a user
 type Request struct {
 	Username string   `json:"username"`
@@ -42,19 +47,19 @@ type Request struct {
 }
 
 // List is the API method to fetch the users
-func List(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
-	listHandler(w, authUser, false)
+	srv.listHandler(w, authUser, false)
 }
 
 // Get is the API method to fetch a user
-func Get(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Get(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -67,12 +72,12 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	srv.getHandler(w, authUser, false, id)
 }
 
 // Create is the API method to create a sub-store model
-func Create(w http.ResponseWriter, r *http.Request) {
-	authUser, err := auth.GetUserFromJWT(w, r)
+func (srv *Service) Create(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -104,14 +109,14 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		Accounts: datastore.BuildAccountsFromAuthorityIDs(userRequest.Accounts),
 	}
 
-	createHandler(w, authUser, false, user)
+	srv.createHandler(w, authUser, false, user)
 }
 
 // Update is the API method to update a user
-func Update(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Update(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -151,14 +156,14 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		Accounts: datastore.BuildAccountsFromAuthorityIDs(userRequest.Accounts),
 	}
 
-	updateHandler(w, authUser, false, user)
+	srv.updateHandler(w, authUser, false, user)
 }
 
 // Delete is the API method to delete a user
-func Delete(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) Delete(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -171,14 +176,14 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, authUser, false, userID)
+	srv.deleteHandler(w, authUser, false, userID)
 }
 
 // GetOtherAccounts is the API method to retrieve accounts not belonging to the user
-func GetOtherAccounts(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) GetOtherAccounts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	authUser, err := auth.GetUserFromJWT(w, r)
+	authUser, err := auth.GetUserFromJWT(w, r, srv.Config)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
@@ -193,5 +198,5 @@ func GetOtherAccounts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getOtherAccountsHandler(w, authUser, false, id)
+	srv.getOtherAccountsHandler(w, authUser, false, id)
 }
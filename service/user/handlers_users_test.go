@@ -58,14 +58,14 @@ var _ = check.Suite(&ServiceSuite{})
 
 func (s *ServiceSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 
 	datastore.Environ.Config.EnableUserAuth = true
 
 	// Disable CSRF for tests as we do not have a secure connection
-	service.MiddlewareWithCSRF = service.Middleware
+	service.CSRFProtection = func(inner http.Handler, authKey string) http.Handler { return inner }
 }
 
 func (s *ServiceSuite) TestUsersHandler(c *check.C) {
@@ -308,7 +308,7 @@ func (s *ServiceSuite) TestDeleteUserHandlerWithoutPermissions(c *check.C) {
 func (s *ServiceSuite) createSuperuserJWT(r *http.Request, c *check.C) {
 	sreg := map[string]string{"nickname": "root", "fullname": "Root User", "email": "the_root_user@thisdb.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, datastore.Superuser)
+	jwtToken, err := usso.NewJWTToken(&resp, datastore.Superuser, datastore.Environ.Config.JwtSecret)
 	c.Assert(err, check.IsNil)
 
 	r.Header.Set("Authorization", "Bearer "+jwtToken)
@@ -342,7 +342,7 @@ func (s *ServiceSuite) sendRequest(method, url string, data io.Reader, c *check.
 
 	s.createSuperuserJWT(r, c)
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w.Body
 }
@@ -366,7 +366,7 @@ func (s *ServiceSuite) sendRequestRepliesUsersListError(method, url string, data
 func (s *ServiceSuite) sendRequestWithoutPermissions(method, url string, data io.Reader, c *check.C) {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	result := user.GetResponse{}
 
@@ -420,7 +420,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 		c.Assert(err, check.IsNil)
 	}
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -428,7 +428,7 @@ func sendAdminRequest(method, url string, data io.Reader, permissions int, c *ch
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "root", "fullname": "Root User", "email": "the_root_user@thisdb.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
-	jwtToken, err := usso.NewJWTToken(&resp, role)
+	jwtToken, err := usso.NewJWTToken(&resp, role, datastore.Environ.Config.JwtSecret)
 	if err != nil {
 		return fmt.Errorf("Error creating a JWT: %v", err)
 	}
@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// assertionsBaseURL is the store API that serves the assertions to the devices
+const assertionsBaseURL = "https://api.snapcraft.io/v2/assertions/"
+
+// AccountKeyExpiryWarning is the time before the expiry of an account-key that it is reported
+const AccountKeyExpiryWarning = 30 * 24 * time.Hour
+
+// Registration statuses of a signing-key in the store
+const (
+	KeyRegistered    = "registered"     // the account-key is valid
+	KeyExpiring      = "expiring"       // the account-key expires within the warning period
+	KeyNotRegistered = "not-registered" // there is no account-key in the store
+	KeyWrongAccount  = "wrong-account"  // the account-key is registered for a different account
+	KeyNotYetValid   = "not-yet-valid"  // the account-key is not valid until a later date
+	KeyExpired       = "expired"        // the account-key has expired or has been revoked
+	KeyUnknown       = "unknown"        // the store could not be checked
+)
+
+// ErrAccountKeyNotFound is returned when the store does not have the account-key
+var ErrAccountKeyNotFound = errors.New("The account-key is not registered in the store")
+
+// AccountKey is the validity of an account-key assertion in the store
+type AccountKey struct {
+	AccountID string
+	Name      string
+	Since     time.Time
+	Until     time.Time // zero when the key does not expire
+}
+
+// KeyRegistration is the registration status of a signing-key in the store. The serial
+// assertions of a rejected key will be rejected when the devices register with the store
+type KeyRegistration struct {
+	ID          int        `json:"id"`
+	AuthorityID string     `json:"authority-id"`
+	KeyID       string     `json:"key-id"`
+	KeyName     string     `json:"key-name"`
+	Active      bool       `json:"active"`
+	Status      string     `json:"status"`
+	Message     string     `json:"message"`
+	Rejected    bool       `json:"rejected"`
+	Since       *time.Time `json:"since,omitempty"`
+	Until       *time.Time `json:"until,omitempty"`
+}
+
+// FetchAccountKey fetches the account-key assertion of a signing-key from the store
+var FetchAccountKey = func(keyID string) (AccountKey, error) {
+	r, _ := http.NewRequest("GET", assertionsBaseURL+"account-key/"+url.PathEscape(keyID), nil)
+	r.Header.Set("Accept", asserts.MediaType)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(r)
+	if err != nil {
+		log.Printf("Error fetching the account-key: %v", err)
+		return AccountKey{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return AccountKey{}, ErrAccountKeyNotFound
+	default:
+		return AccountKey{}, fmt.Errorf("Error fetching the account-key: %s", resp.Status)
+	}
+
+	assertion, err := asserts.NewDecoder(resp.Body).Decode()
+	if err != nil {
+		log.Printf("Error decoding the account-key: %v", err)
+		return AccountKey{}, err
+	}
+	accountKey, ok := assertion.(*asserts.AccountKey)
+	if !ok {
+		return AccountKey{}, errors.New("The store did not return an account-key assertion")
+	}
+
+	return AccountKey{AccountID: accountKey.AccountID(), Name: accountKey.Name(), Since: accountKey.Since(), Until: accountKey.Until()}, nil
+}
+
+// CheckKeyRegistration checks that the account-key of the signing-key is registered in the
+// store for its account, and is valid
+func CheckKeyRegistration(keypair datastore.Keypair, now time.Time) KeyRegistration {
+	accountKey, err := FetchAccountKey(keypair.KeyID)
+	return keyRegistration(keypair, accountKey, err, now)
+}
+
+// keyRegistration decides the registration status of a signing-key from its account-key
+func keyRegistration(keypair datastore.Keypair, accountKey AccountKey, err error, now time.Time) KeyRegistration {
+	reg := KeyRegistration{ID: keypair.ID, AuthorityID: keypair.AuthorityID, KeyID: keypair.KeyID, KeyName: keypair.KeyName, Active: keypair.Active}
+
+	switch {
+	case err == ErrAccountKeyNotFound:
+		reg.Status, reg.Message = KeyNotRegistered, err.Error()
+		reg.Rejected = true
+		return reg
+	case err != nil:
+		reg.Status, reg.Message = KeyUnknown, err.Error()
+		return reg
+	}
+
+	reg.Since = &accountKey.Since
+	if !accountKey.Until.IsZero() {
+		reg.Until = &accountKey.Until
+	}
+
+	switch {
+	case accountKey.AccountID != keypair.AuthorityID:
+		reg.Status = KeyWrongAccount
+		reg.Message = fmt.Sprintf("The account-key is registered for the account '%s'", accountKey.AccountID)
+	case now.Before(accountKey.Since):
+		reg.Status = KeyNotYetValid
+		reg.Message = fmt.Sprintf("The account-key is not valid until %s", accountKey.Since.Format(time.RFC3339))
+	case reg.Until != nil && !now.Before(accountKey.Until):
+		reg.Status = KeyExpired
+		reg.Message = fmt.Sprintf("The account-key expired or was revoked on %s", accountKey.Until.Format(time.RFC3339))
+	case reg.Until != nil && now.Add(AccountKeyExpiryWarning).After(accountKey.Until):
+		reg.Status = KeyExpiring
+		reg.Message = fmt.Sprintf("The account-key expires on %s", accountKey.Until.Format(time.RFC3339))
+	default:
+		reg.Status = KeyRegistered
+	}
+
+	reg.Rejected = reg.Status != KeyRegistered && reg.Status != KeyExpiring
+	return reg
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestKeyRegistration(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	keypair := datastore.Keypair{ID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyName: "serial", Active: true}
+	since := now.AddDate(-1, 0, 0)
+
+	tests := []struct {
+		accountKey AccountKey
+		err        error
+		status     string
+		rejected   bool
+	}{
+		{AccountKey{AccountID: "system", Since: since}, nil, KeyRegistered, false},
+		{AccountKey{AccountID: "system", Since: since, Until: now.AddDate(1, 0, 0)}, nil, KeyRegistered, false},
+		{AccountKey{AccountID: "system", Since: since, Until: now.AddDate(0, 0, 10)}, nil, KeyExpiring, false},
+		{AccountKey{AccountID: "system", Since: since, Until: now.AddDate(0, 0, -1)}, nil, KeyExpired, true},
+		{AccountKey{AccountID: "system", Since: since, Until: now}, nil, KeyExpired, true},
+		{AccountKey{AccountID: "system", Since: now.AddDate(0, 0, 1)}, nil, KeyNotYetValid, true},
+		{AccountKey{AccountID: "other", Since: since}, nil, KeyWrongAccount, true},
+		{AccountKey{}, ErrAccountKeyNotFound, KeyNotRegistered, true},
+		{AccountKey{}, errors.New("MOCK store unavailable"), KeyUnknown, false},
+	}
+
+	for i, tt := range tests {
+		reg := keyRegistration(keypair, tt.accountKey, tt.err, now)
+		if reg.Status != tt.status || reg.Rejected != tt.rejected {
+			t.Errorf("%d: expected %s (rejected %v), got %s (rejected %v): %s", i, tt.status, tt.rejected, reg.Status, reg.Rejected, reg.Message)
+		}
+		if reg.ID != keypair.ID || reg.AuthorityID != keypair.AuthorityID || reg.KeyID != keypair.KeyID || reg.KeyName != keypair.KeyName {
+			t.Errorf("%d: expected the details of the keypair, got %+v", i, reg)
+		}
+		if tt.err == nil && (reg.Since == nil || !reg.Since.Equal(tt.accountKey.Since)) {
+			t.Errorf("%d: expected the validity of the account-key, got %v", i, reg.Since)
+		}
+		if (reg.Until == nil) != tt.accountKey.Until.IsZero() {
+			t.Errorf("%d: expected the expiry of the account-key, got %v", i, reg.Until)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DeviceRegistration is a device of a brand that has registered with the store
+type DeviceRegistration struct {
+	Model      string    `json:"model"`
+	Serial     string    `json:"serial"`
+	Registered time.Time `json:"registered"`
+}
+
+// deviceRegistrations is a page of device registrations from the store
+type deviceRegistrations struct {
+	Devices []DeviceRegistration `json:"devices"`
+	Next    string               `json:"next"`
+}
+
+// FetchDeviceRegistrations fetches the device registrations of a brand from the store,
+// following the pages of the response
+var FetchDeviceRegistrations = func(keyAuth Auth, brandID string) ([]DeviceRegistration, error) {
+	// Login to the store as the user
+	permissions := []string{"package_access"}
+	m, discharge, err := LoginUser(keyAuth.Email, keyAuth.Password, keyAuth.OTP, permissions)
+	if err != nil {
+		log.Println("Error logging in to store", err)
+		return nil, fmt.Errorf("Error logging in to store")
+	}
+
+	// Generate the authorization header from the macaroons
+	authHeader, err := AuthorizationHeader(m, discharge)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []DeviceRegistration{}
+	next := storeBaseURL + "brands/" + url.PathEscape(brandID) + "/devices"
+	for len(next) > 0 {
+		r, _ := http.NewRequest("GET", next, nil)
+		r.Header.Set("Authorization", authHeader)
+		r.Header.Set("Accept", "application/json")
+
+		client := http.Client{}
+		resp, err := client.Do(r)
+		if err != nil {
+			log.Printf("Error fetching the device registrations: %v", err)
+			return nil, err
+		}
+
+		page, err := decodeDeviceRegistrations(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, page.Devices...)
+		next = page.Next
+	}
+
+	return devices, nil
+}
+
+func decodeDeviceRegistrations(resp *http.Response) (deviceRegistrations, error) {
+	defer resp.Body.Close()
+
+	page := deviceRegistrations{}
+	if resp.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("Error fetching the device registrations: %s", resp.Status)
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		log.Printf("Error decoding the device registrations: %v", err)
+	}
+	return page, err
+}
+
+// ReadDeviceRegistrations reads the device registrations from an export of the store,
+// in the same JSON format as the store API
+func ReadDeviceRegistrations(r io.Reader) ([]DeviceRegistration, error) {
+	page := deviceRegistrations{}
+	err := json.NewDecoder(r).Decode(&page)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the device registrations: %v", err)
+	}
+	return page.Devices, nil
+}
@@ -21,6 +21,7 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -186,7 +187,7 @@ func generateAccountKeyRequest(keyAuth KeyRegister, keypair datastore.Keypair) (
 		return "", err
 	}
 
-	accountKey, err := datastore.Environ.KeypairDB.SignAssertion(asserts.AccountKeyRequestType, headers, pubKeyEncoded, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
+	accountKey, err := datastore.Environ.KeypairDB.SignAssertion(context.Background(), nil, asserts.AccountKeyRequestType, headers, pubKeyEncoded, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
 	if err != nil {
 		log.Printf("Error creating account-key assertion: %v", err)
 		return "", err
@@ -21,19 +21,25 @@
 package sync
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/crypt"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 )
 
+// DefaultRequestTimeout is the limit for a request to the cloud serial-vault
+const DefaultRequestTimeout = time.Minute
+
 // Client is the sync interface for the serial vault
 type Client interface {
-	Accounts() error
+	Accounts(ctx context.Context) error
 }
 
 // FactoryClient is the implementation of the factory sync for the serial vault
@@ -41,42 +47,123 @@ type FactoryClient struct {
 	URL      string
 	Username string
 	APIKey   string
+
+	// HTTPClient sends the requests to the cloud serial-vault
+	HTTPClient *http.Client
+
+	// Parallelism is the number of logs that are uploaded concurrently
+	Parallelism int
+
+	// Report counts the records and bytes that are synced
+	Report *Report
 }
 
-// NewFactoryClient creates a factory client to sync data with the cloud serial-vault
-func NewFactoryClient(url, username, apiKey string) *FactoryClient {
-	hclient = http.Client{}
+// NewFactoryClient creates a factory client to sync data with the cloud serial-vault.
+// Each request to the cloud is limited by the request timeout
+func NewFactoryClient(url, username, apiKey string, requestTimeout time.Duration) *FactoryClient {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	report := &Report{Started: time.Now().UTC()}
 	return &FactoryClient{
 		URL: url, Username: username, APIKey: apiKey,
+		HTTPClient: &http.Client{Timeout: requestTimeout, Transport: countingTransport{http.DefaultTransport, report}},
+		Report:     report,
+	}
+}
+
+// Heartbeat registers the factory in the instance registry of the cloud serial-vault
+func (c *FactoryClient) Heartbeat(ctx context.Context) error {
+	_, err := SendHeartbeat(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey, instance.Self(datastore.Environ, "sync"))
+	return err
+}
+
+// CheckIn reports the health of the factory to the cloud serial-vault: the version, the logs
+// waiting to be synced, the health of the keystore and the clock of the factory
+func (c *FactoryClient) CheckIn(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
+	self := instance.Self(datastore.Environ, "sync")
+	checkIn := datastore.FactoryCheckIn{
+		InstanceID:      self.InstanceID,
+		Hostname:        self.Hostname,
+		Version:         self.Version,
+		KeystoreHealthy: true,
+	}
+
+	if logs, err := db.SyncSigningLog(); err == nil {
+		checkIn.PendingSigningLogs = len(logs)
+	} else {
+		log.Errorf("Error fetching unsynced signing logs: %v", err)
+	}
+	if logs, err := db.SyncListTestLogs(); err == nil {
+		checkIn.PendingTestLogs = len(logs)
+	} else {
+		log.Errorf("Error fetching unsynced test logs: %v", err)
+	}
+
+	if err := datastore.CheckKeyStore(datastore.Environ.Config); err != nil {
+		checkIn.KeystoreHealthy = false
+		checkIn.KeystoreError = err.Error()
 	}
+
+	checkIn.Reported = time.Now().UTC()
+	_, err := SendCheckIn(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey, checkIn)
+	return err
 }
 
-// Accounts synchronizes the account details to the factory instance
-func (c *FactoryClient) Accounts() error {
+// Accounts synchronizes the account details, and the account and account-key assertions, to
+// the factory instance
+func (c *FactoryClient) Accounts(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
 	// Fetch the accounts from the serial-vault
-	result, err := FetchAccounts(c.URL, c.Username, c.APIKey)
+	result, err := FetchAccounts(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey)
 	if err != nil {
 		log.Errorf("Error parsing accounts: %v", err)
-		return err
+		return cloudError(err)
 	}
 	if !result.Success {
 		log.Errorf("Error fetching accounts: %s", result.ErrorMessage)
-		return errors.New(result.ErrorMessage)
+		return cloudError(errors.New(result.ErrorMessage))
 	}
 
-	// Update the factory database with the accounts
+	// Update the factory database with the accounts. An assertion that is not the account
+	// assertion of the account is not stored
 	for _, a := range result.Accounts {
-		if err = datastore.Environ.DB.SyncAccount(a); err != nil {
+		if err := datastore.ValidateAccountAssertion(a); err != nil {
+			log.Warningf("Invalid account assertion of %s: %v", a.AuthorityID, err)
+			a.Assertion = ""
+		}
+		if err = db.SyncAccount(a); err != nil {
 			log.Errorf("Error updating accounts: %v", err)
-			return err
+			return datastoreError(err)
 		}
+		c.Report.count(EntityAccounts, 1, 0)
+	}
+
+	// Update the factory database with the account-key assertions, so the factory can serve
+	// the assertion chain of its signing-keys without reaching the store
+	for _, k := range result.AccountKeys {
+		if err := datastore.ValidateAccountKey(k); err != nil {
+			log.Warningf("Invalid account-key assertion of %s/%s: %v", k.AuthorityID, k.KeyID, err)
+			c.Report.count(EntityAccounts, 0, 1)
+			continue
+		}
+		if err = db.SyncAccountKey(k); err != nil {
+			log.Errorf("Error updating account-key assertions: %v", err)
+			return datastoreError(err)
+		}
+		c.Report.count(EntityAccounts, 1, 0)
 	}
 
 	return nil
 }
 
 // SigningKeys synchronizes the signing-keys to the factory instance
-func (c *FactoryClient) SigningKeys() error {
+func (c *FactoryClient) SigningKeys(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
 	// Get the signing keys by sending our keystore secret
 	req := keypair.SyncRequest{Secret: datastore.Environ.Config.KeyStoreSecret}
 	data, err := json.Marshal(req)
@@ -86,124 +173,282 @@ func (c *FactoryClient) SigningKeys() error {
 	}
 
 	// Fetch the signing-keys from the cloud serial-vault
-	result, err := FetchSigningKeys(c.URL, c.Username, c.APIKey, data)
+	result, err := FetchSigningKeys(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey, data)
 	if err != nil {
 		log.Errorf("Error parsing signing-keys: %v", err)
-		return err
+		return cloudError(err)
 	}
 	if !result.Success {
 		log.Errorf("Error fetching signing-keys")
-		return errors.New("Error fetching signing keys")
+		return cloudError(errors.New("Error fetching signing keys"))
 	}
 
 	// Update the factory database with the signing-keys
 	for _, k := range result.Keypairs {
 
 		// Check if we've already sync-ed the keypair
-		_, err = GetKeypairByPublicID(k.AuthorityID, k.KeyID)
-		if err == nil {
+		existing, err := GetKeypairByPublicID(k.AuthorityID, k.KeyID)
+
+		// A compromised keypair is deactivated and its sealed key is removed
+		if k.Revoked {
+			if err == nil && !existing.Active && len(existing.SealedKey) == 0 {
+				continue
+			}
+			k.Active = false
+			k.SealedKey = ""
+			if err := db.SyncKeypair(k); err != nil {
+				log.Errorf("Error revoking keypair: %v", err)
+				return datastoreError(err)
+			}
+			log.Warningf("Revoked the compromised signing-key %s/%s", k.AuthorityID, k.KeyID)
+			c.Report.count(EntitySigningKeys, 1, 0)
+			continue
+		}
+
+		if err == nil && len(existing.SealedKey) > 0 {
 			// Already have the keypair, so no need to store it again
-			// This is important as we get a new encryption key and sealed key each time
+			// This is important as we get a new encryption key and sealed key each time.
+			// A keypair that was revoked outside its authorization window is stored again
 			continue
 		}
 
-		err = datastore.Environ.DB.SyncKeypair(k)
+		err = db.SyncKeypair(k)
 		if err != nil {
 			log.Errorf("Error updating keypairs: %v", err)
-			return err
+			return datastoreError(err)
 		}
 
-		err = datastore.Environ.DB.PutSetting(
+		err = db.PutSetting(
 			datastore.Setting{
 				Code: crypt.GenerateAuthKey(k.AuthorityID, k.KeyID),
 				Data: k.AuthKeyHash})
 		if err != nil {
 			log.Errorf("Error saving keypair auth: %v", err)
-			return err
+			return datastoreError(err)
 		}
+		c.Report.count(EntitySigningKeys, 1, 0)
 	}
 
 	return nil
 }
 
 // Models synchronizes the model details to the factory instance
-func (c *FactoryClient) Models() error {
+func (c *FactoryClient) Models(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
 	// Fetch the accounts from the serial-vault
-	result, err := FetchModels(c.URL, c.Username, c.APIKey)
+	result, err := FetchModels(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey)
 	if err != nil {
 		log.Errorf("Error parsing models: %v", err)
-		return err
+		return cloudError(err)
 	}
 	if !result.Success {
 		log.Errorf("Error fetching models: %s", result.ErrorMessage)
-		return errors.New(result.ErrorMessage)
+		return cloudError(errors.New(result.ErrorMessage))
 	}
 
 	// Update the factory database with the accounts
 	for _, m := range result.Models {
-		err = datastore.Environ.DB.SyncModel(m)
+		err = db.SyncModel(m)
 		if err != nil {
 			log.Errorf("Error updating models: %v", err)
-			return err
+			return datastoreError(err)
 		}
+		c.Report.count(EntityModels, 1, 0)
+	}
+
+	return nil
+}
+
+// Delegations synchronizes the root keys of the brands and their delegations to the factory
+// instance, so the factory only signs with the delegated signing-keys
+func (c *FactoryClient) Delegations(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
+	// Fetch the root keys and the delegations from the cloud serial-vault
+	result, err := FetchKeyDelegations(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey)
+	if err != nil {
+		log.Errorf("Error parsing key delegations: %v", err)
+		return cloudError(err)
+	}
+	if !result.Success {
+		log.Errorf("Error fetching key delegations: %s", result.ErrorMessage)
+		return cloudError(errors.New(result.ErrorMessage))
+	}
+
+	// Replace the root keys and the delegations of the factory database
+	err = db.SyncKeyDelegations(result.RootKeys, result.Delegations)
+	if err != nil {
+		log.Errorf("Error updating key delegations: %v", err)
+		return datastoreError(err)
+	}
+	c.Report.count(EntityDelegations, len(result.RootKeys)+len(result.Delegations), 0)
+
+	return nil
+}
+
+// Authorizations synchronizes the signing authorizations of the models to the factory
+// instance. The signing-keys of the models outside their authorization window are revoked
+func (c *FactoryClient) Authorizations(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
+	// Fetch the models assigned to the sync user from the cloud serial-vault
+	result, err := FetchSyncModels(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey)
+	if err != nil {
+		log.Errorf("Error parsing signing authorizations: %v", err)
+		return cloudError(err)
+	}
+	if !result.Success {
+		log.Errorf("Error fetching signing authorizations: %s", result.ErrorMessage)
+		return cloudError(errors.New(result.ErrorMessage))
+	}
+
+	// Update the factory database with the authorizations. A cloud that does not report the
+	// scope of the sync user only sends the models of a model-scoped user
+	scoped := result.Scoped || len(result.Models) > 0
+	err = db.SyncSigningAuthorizations(scoped, result.Models)
+	if err != nil {
+		log.Errorf("Error updating signing authorizations: %v", err)
+		return datastoreError(err)
+	}
+	c.Report.count(EntityAuthorizations, len(result.Models), 0)
+
+	return nil
+}
+
+// Quarantine synchronizes the quarantine list of the devices to the factory instance, so the
+// factory refuses to sign the quarantined devices
+func (c *FactoryClient) Quarantine(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
 
+	// Fetch the quarantined devices from the cloud serial-vault
+	result, err := FetchDeviceQuarantine(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey)
+	if err != nil {
+		log.Errorf("Error parsing quarantined devices: %v", err)
+		return cloudError(err)
+	}
+	if !result.Success {
+		log.Errorf("Error fetching quarantined devices: %s", result.ErrorMessage)
+		return cloudError(errors.New(result.ErrorMessage))
+	}
+
+	// Replace the quarantine list of the factory database
+	err = db.SyncDeviceQuarantine(result.Devices)
+	if err != nil {
+		log.Errorf("Error updating quarantined devices: %v", err)
+		return datastoreError(err)
 	}
+	c.Report.count(EntityQuarantine, len(result.Devices), 0)
 
 	return nil
 }
 
 // SigningLogs sends signing logs to the cloud from the factory
-func (c *FactoryClient) SigningLogs() error {
+func (c *FactoryClient) SigningLogs(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
 	// Fetch the signing logs that have not been synced
-	logs, err := datastore.Environ.DB.SyncSigningLog()
+	logs, err := db.SyncSigningLog()
 	if err != nil {
 		log.Errorf("Error fetching unsynced signing logs: %v", err)
-		return err
+		return datastoreError(err)
 	}
 
-	// Send each signing log to the cloud
-	for _, l := range logs {
-		success, err := SendSigningLog(c.URL, c.Username, c.APIKey, l)
-		if err != nil || !success {
+	// Send the signing logs to the cloud
+	sent := uploadAll(ctx, len(logs), c.Parallelism, func(i int) bool {
+		success, err := SendSigningLog(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey, logs[i])
+		return err == nil && success
+	})
+
+	for i, l := range logs {
+		if !sent[i] {
 			// Leave this one till the next sync
+			c.Report.count(EntitySigningLogs, 0, 1)
 			continue
 		}
+		c.Report.count(EntitySigningLogs, 1, 0)
 
 		// Mark the sync as done
-		err = datastore.Environ.DB.SyncUpdateSigningLog(l.ID)
+		err = db.SyncUpdateSigningLog(l.ID)
 		if err != nil {
 			log.Errorf("Error marking signing logs: %v", err)
 		}
 	}
 
-	return nil
+	// The logs that were not sent before the end of the sync cycle are left till the next sync
+	return ctx.Err()
 }
 
 // TestLogs sends logs to the cloud from the factory
-func (c *FactoryClient) TestLogs() error {
+func (c *FactoryClient) TestLogs(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
 	// Fetch the test logs that have not been synced
-	logs, err := datastore.Environ.DB.SyncListTestLogs()
+	logs, err := db.SyncListTestLogs()
 	if err != nil {
 		log.Errorf("Error fetching unsynced test logs: %v", err)
-		return err
+		return datastoreError(err)
 	}
 
-	// Send each signing log to the cloud
-	for _, l := range logs {
-		success, err := SendTestLog(c.URL, c.Username, c.APIKey, l)
-		if err != nil || !success {
+	// Send the test logs to the cloud
+	sent := uploadAll(ctx, len(logs), c.Parallelism, func(i int) bool {
+		success, err := SendTestLog(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey, logs[i])
+		return err == nil && success
+	})
+
+	for i, l := range logs {
+		if !sent[i] {
 			// Leave this one till the next sync
+			c.Report.count(EntityTestLogs, 0, 1)
 			continue
 		}
+		c.Report.count(EntityTestLogs, 1, 0)
 
 		// Delete the factory test log
-		err = datastore.Environ.DB.SyncDeleteTestLog(l.ID)
+		err = db.SyncDeleteTestLog(l.ID)
 		if err != nil {
 			log.Errorf("Error deleting test log: %v", err)
 		}
 	}
 
-	return nil
+	// The logs that were not sent before the end of the sync cycle are left till the next sync
+	return ctx.Err()
+}
+
+// DeviceManifests sends the device manifests to the cloud from the factory
+func (c *FactoryClient) DeviceManifests(ctx context.Context) error {
+	db := datastore.Environ.DB.WithContext(ctx)
+
+	// Fetch the device manifests that have not been synced
+	manifests, err := db.SyncListDeviceManifests()
+	if err != nil {
+		log.Errorf("Error fetching unsynced device manifests: %v", err)
+		return datastoreError(err)
+	}
+
+	// Send the device manifests to the cloud
+	sent := uploadAll(ctx, len(manifests), c.Parallelism, func(i int) bool {
+		success, err := SendDeviceManifest(ctx, c.HTTPClient, c.URL, c.Username, c.APIKey, manifests[i])
+		return err == nil && success
+	})
+
+	for i, m := range manifests {
+		if !sent[i] {
+			// Leave this one till the next sync
+			c.Report.count(EntityDeviceManifests, 0, 1)
+			continue
+		}
+		c.Report.count(EntityDeviceManifests, 1, 0)
+
+		// Delete the factory device manifest
+		err = db.SyncDeleteDeviceManifest(m.ID)
+		if err != nil {
+			log.Errorf("Error deleting device manifest: %v", err)
+		}
+	}
+
+	// The manifests that were not sent before the end of the sync cycle are left till the next sync
+	return ctx.Err()
 }
 
 // GetKeypairByPublicID is the mockable call to the database function
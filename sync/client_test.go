@@ -22,17 +22,27 @@ package sync_test
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	gosync "sync"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
 	"github.com/CanonicalLtd/serial-vault/service/model"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/user"
 	"github.com/CanonicalLtd/serial-vault/sync"
 	check "gopkg.in/check.v1"
 )
@@ -72,6 +82,39 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 			Args:         []string{"model"},
 			ErrorMessage: "MOCK fail fetching models",
 			MockFail:     true},
+		{
+			Args:         []string{"delegation"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"delegation"},
+			ErrorMessage: "MOCK error fetching key delegations",
+			MockErrorDB:  true},
+		{
+			Args:         []string{"delegation"},
+			ErrorMessage: "MOCK fail fetching key delegations",
+			MockFail:     true},
+		{
+			Args:         []string{"authorization"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"authorization"},
+			ErrorMessage: "MOCK error fetching sync models",
+			MockErrorDB:  true},
+		{
+			Args:         []string{"authorization"},
+			ErrorMessage: "MOCK fail fetching sync models",
+			MockFail:     true},
+		{
+			Args:         []string{"quarantine"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"quarantine"},
+			ErrorMessage: "MOCK error fetching quarantined devices",
+			MockErrorDB:  true},
+		{
+			Args:         []string{"quarantine"},
+			ErrorMessage: "MOCK fail fetching quarantined devices",
+			MockFail:     true},
 		{
 			Args:         []string{"signinglog"},
 			ErrorMessage: ""},
@@ -86,6 +129,13 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 			Args:         []string{"testlog"},
 			ErrorMessage: "MOCK Cannot fetch the test logs",
 			MockErrorDB:  true},
+		{
+			Args:         []string{"manifest"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"manifest"},
+			ErrorMessage: "MOCK error retrieving the device manifests",
+			MockErrorDB:  true},
 	}
 
 	for _, t := range tests {
@@ -96,14 +146,21 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 			sync.FetchAccounts = mockFetchAccountsError
 			sync.FetchSigningKeys = mockFetchSigningKeysError
 			sync.FetchModels = mockFetchModelsError
+			sync.FetchKeyDelegations = mockFetchKeyDelegationsError
+			sync.FetchSyncModels = mockFetchSyncModelsError
+			sync.FetchDeviceQuarantine = mockFetchDeviceQuarantineError
 			sync.SendSigningLog = mockSendSigningLogError
 			sync.SendTestLog = mockSendTestLogError
+			sync.SendDeviceManifest = mockSendDeviceManifestError
 		}
 		if t.MockFail {
 			datastore.Environ.DB = &datastore.ErrorMockDB{}
 			sync.FetchAccounts = mockFetchAccountsFail
 			sync.FetchSigningKeys = mockFetchSigningKeysFail
 			sync.FetchModels = mockFetchModelsFail
+			sync.FetchKeyDelegations = mockFetchKeyDelegationsFail
+			sync.FetchSyncModels = mockFetchSyncModelsFail
+			sync.FetchDeviceQuarantine = mockFetchDeviceQuarantineFail
 			sync.SendTestLog = mockSendTestLogError
 		}
 		if !t.MockErrorDB && !t.MockFail {
@@ -111,19 +168,27 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 			sync.GetKeypairByPublicID = mockGetKeypairByPublicID
 		}
 
-		client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey")
+		client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
 
 		switch t.Args[0] {
 		case "account":
-			err = client.Accounts()
+			err = client.Accounts(context.Background())
 		case "signingkey":
-			err = client.SigningKeys()
+			err = client.SigningKeys(context.Background())
 		case "model":
-			err = client.Models()
+			err = client.Models(context.Background())
+		case "delegation":
+			err = client.Delegations(context.Background())
+		case "authorization":
+			err = client.Authorizations(context.Background())
+		case "quarantine":
+			err = client.Quarantine(context.Background())
 		case "signinglog":
-			err = client.SigningLogs()
+			err = client.SigningLogs(context.Background())
 		case "testlog":
-			err = client.TestLogs()
+			err = client.TestLogs(context.Background())
+		case "manifest":
+			err = client.DeviceManifests(context.Background())
 		}
 
 		if len(t.ErrorMessage) == 0 {
@@ -137,67 +202,357 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 		sync.FetchAccounts = mockFetchAccounts
 		sync.FetchSigningKeys = mockFetchSigningKeys
 		sync.FetchModels = mockFetchModels
+		sync.FetchKeyDelegations = mockFetchKeyDelegations
+		sync.FetchSyncModels = mockFetchSyncModels
+		sync.FetchDeviceQuarantine = mockFetchDeviceQuarantine
 		sync.SendSigningLog = mockSendSigningLog
 		sync.SendTestLog = mockSendTestLog
+		sync.SendDeviceManifest = mockSendDeviceManifest
+	}
+
+}
+
+func (s *startSuite) TestAuthorizations(c *check.C) {
+	now := time.Now()
+	expired := now.Add(-time.Hour)
+	sync.FetchSyncModels = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (user.SyncModelsResponse, error) {
+		return user.SyncModelsResponse{Success: true, Scoped: true, Models: []datastore.SyncModelAssignment{
+			{BrandID: "System", Name: "alder", MaxUnits: 100},
+			{BrandID: "System", Name: "ash", ValidUntil: &expired},
+		}}, nil
+	}
+
+	// Without authorizations, the factory can sign for all models
+	c.Assert(datastore.Environ.DB.CheckSigningAuthorization("System", "ash"), check.IsNil)
+
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+	err := client.Authorizations(context.Background())
+	c.Assert(err, check.IsNil)
+
+	c.Assert(datastore.Environ.DB.CheckSigningAuthorization("System", "alder"), check.IsNil)
+	c.Assert(datastore.Environ.DB.CheckSigningAuthorization("System", "ash"), check.ErrorMatches, ".*expired.*")
+	c.Assert(datastore.Environ.DB.CheckSigningAuthorization("System", "birch"), check.ErrorMatches, ".*not authorized.*")
+
+	// A model-scoped sync user without models cannot sign for any model
+	sync.FetchSyncModels = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (user.SyncModelsResponse, error) {
+		return user.SyncModelsResponse{Success: true, Scoped: true}, nil
+	}
+	err = client.Authorizations(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Assert(datastore.Environ.DB.CheckSigningAuthorization("System", "alder"), check.ErrorMatches, ".*not authorized.*")
+
+	sync.FetchSyncModels = mockFetchSyncModels
+}
+
+func (s *startSuite) TestQuarantine(c *check.C) {
+	db := datastoretest.New()
+	db.AddSigningLog(datastoretest.NewSigningLog("System", "alder", "A2").Build())
+	datastore.Environ.DB = db
+
+	sync.FetchDeviceQuarantine = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (device.QuarantineResponse, error) {
+		return device.QuarantineResponse{Success: true, Devices: []datastore.DeviceQuarantine{
+			{ID: 4, Brand: "System", SerialNumber: "A1", Reason: "Recalled batch", Source: datastore.QuarantineSourceImport},
+		}}, nil
+	}
+
+	_, err := db.CheckDeviceQuarantine("System", "A1", "")
+	c.Assert(err, check.Equals, sql.ErrNoRows)
+
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+	err = client.Quarantine(context.Background())
+	c.Assert(err, check.IsNil)
+
+	q, err := db.CheckDeviceQuarantine("System", "A1", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(q.ID, check.Equals, 4)
+	c.Assert(q.Reason, check.Equals, "Recalled batch")
+	_, err = db.CheckDeviceQuarantine("System", "A2", "")
+	c.Assert(err, check.Equals, sql.ErrNoRows)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+	sync.FetchDeviceQuarantine = mockFetchDeviceQuarantine
+}
+
+func (s *startSuite) TestDelegations(c *check.C) {
+	db := datastoretest.New()
+	datastore.Environ.DB = db
+
+	sync.FetchKeyDelegations = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (keypair.SyncDelegationsResponse, error) {
+		return keypair.SyncDelegationsResponse{Success: true,
+			RootKeys: []datastore.BrandRootKey{{AuthorityID: "system", Fingerprint: "ABCDEF", PublicKey: "root key"}},
+			Delegations: []datastore.KeyDelegation{
+				{ID: 3, AuthorityID: "system", KeyID: "key1", Document: "{}", Signature: "not signed by the root key"},
+			}}, nil
 	}
 
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+	err := client.Delegations(context.Background())
+	c.Assert(err, check.IsNil)
+
+	rootKey, err := db.GetBrandRootKey("system")
+	c.Assert(err, check.IsNil)
+	c.Assert(rootKey.Fingerprint, check.Equals, "ABCDEF")
+
+	// The delegation that is not signed by the root key is not stored
+	delegations, err := db.ListKeyDelegations("system")
+	c.Assert(err, check.IsNil)
+	c.Assert(delegations, check.HasLen, 0)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+	sync.FetchKeyDelegations = mockFetchKeyDelegations
+}
+
+func (s *startSuite) TestHeartbeat(c *check.C) {
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+
+	err := client.Heartbeat(context.Background())
+	c.Assert(err, check.IsNil)
+
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	err = client.Heartbeat(context.Background())
+	c.Assert(err, check.NotNil)
+}
+
+func (s *startSuite) TestCheckIn(c *check.C) {
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+
+	err := client.CheckIn(context.Background())
+	c.Assert(err, check.IsNil)
+
+	client = sync.NewFactoryClient("/api/", "user1", "ValidAPIKey", 0)
+	err = client.CheckIn(context.Background())
+	c.Assert(err, check.NotNil)
+}
+
+func (s *startSuite) TestSyncCancelled(c *check.C) {
+	datastore.Environ.DB = &datastore.MockDB{}
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c.Assert(client.SigningLogs(ctx), check.Equals, context.Canceled)
+	c.Assert(client.TestLogs(ctx), check.Equals, context.Canceled)
+	c.Assert(client.DeviceManifests(ctx), check.Equals, context.Canceled)
 }
 
-func mockFetchAccounts(url, username, apikey string) (account.ListResponse, error) {
+func (s *startSuite) TestSigningLogsParallel(c *check.C) {
+	db := datastoretest.New()
+	for i := 0; i < 20; i++ {
+		db.AddSigningLog(datastoretest.NewSigningLog("System", "alder", fmt.Sprintf("A%d", i)).Build())
+	}
+	datastore.Environ.DB = db
+
+	// Track the concurrent uploads and fail the odd serial numbers
+	var mu gosync.Mutex
+	running, maxRunning := 0, 0
+	sync.SendSigningLog = func(ctx context.Context, hclient *http.Client, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		var n int
+		fmt.Sscanf(signLog.SerialNumber, "A%d", &n)
+		return n%2 == 0, nil
+	}
+
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+	client.Parallelism = 3
+	err := client.SigningLogs(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Assert(maxRunning > 1, check.Equals, true)
+	c.Assert(maxRunning <= 3, check.Equals, true)
+
+	// The failed uploads are counted in the report
+	client.Report.Record(sync.EntitySigningLogs, err)
+	c.Assert(client.Report.Entities, check.DeepEquals, []sync.EntityReport{
+		{Entity: sync.EntitySigningLogs, Synced: 10, Failed: 10, Category: sync.FailureCloud},
+	})
+
+	// Only the failed uploads are left for the next sync
+	logs, err := db.SyncSigningLog()
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 10)
+	for _, l := range logs {
+		var n int
+		fmt.Sscanf(l.SerialNumber, "A%d", &n)
+		c.Assert(n%2, check.Equals, 1)
+	}
+
+	datastore.Environ.DB = &datastore.MockDB{}
+	sync.SendSigningLog = mockSendSigningLog
+}
+
+func (s *startSuite) TestSendRequestTimeout(c *check.C) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hang until the test is complete
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	// The request timeout of the client
+	client := sync.NewFactoryClient(server.URL+"/api/", "sync", "ValidAPIKey", 50*time.Millisecond)
+	_, err := sync.SendRequest(context.Background(), client.HTTPClient, "GET", client.URL, "accounts", client.Username, client.APIKey, nil)
+	c.Assert(err, check.NotNil)
+
+	// The deadline of the sync cycle
+	client = sync.NewFactoryClient(server.URL+"/api/", "sync", "ValidAPIKey", time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = sync.SendRequest(ctx, client.HTTPClient, "GET", client.URL, "accounts", client.Username, client.APIKey, nil)
+	c.Assert(err, check.NotNil)
+	c.Assert(ctx.Err(), check.Equals, context.DeadlineExceeded)
+}
+
+func (s *startSuite) TestAccountsInvalidAssertions(c *check.C) {
+	db := datastoretest.New()
+	datastore.Environ.DB = db
+	sync.FetchAccounts = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (account.ListResponse, error) {
+		return account.ListResponse{
+			Success:     true,
+			Accounts:    []datastore.Account{{ID: 1, AuthorityID: "system", Assertion: "not an assertion"}},
+			AccountKeys: []datastore.AccountKey{{AuthorityID: "system", KeyID: "system-key", Assertion: "not an assertion"}},
+		}, nil
+	}
+
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey", 0)
+	err := client.Accounts(context.Background())
+	c.Assert(err, check.IsNil)
+
+	// The account is synced without its invalid assertion, and the account-key assertion is skipped
+	acc, err := db.GetAccount("system")
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.Assertion, check.Equals, "")
+
+	_, err = db.GetAccountKey("system", "system-key")
+	c.Assert(err, check.Equals, sql.ErrNoRows)
+
+	client.Report.Record(sync.EntityAccounts, nil)
+	c.Assert(client.Report.Entities, check.DeepEquals, []sync.EntityReport{
+		{Entity: sync.EntityAccounts, Synced: 1, Failed: 1, Category: sync.FailureCloud},
+	})
+
+	datastore.Environ.DB = &datastore.MockDB{}
+	sync.FetchAccounts = mockFetchAccounts
+}
+
+func mockFetchAccounts(ctx context.Context, hclient *http.Client, url, username, apikey string) (account.ListResponse, error) {
 	w := sendSyncAPIRequest("GET", "/api/accounts", nil)
 	return parseListResponse(w)
 }
 
-func mockFetchAccountsError(url, username, apikey string) (account.ListResponse, error) {
+func mockFetchAccountsError(ctx context.Context, hclient *http.Client, url, username, apikey string) (account.ListResponse, error) {
 	return account.ListResponse{}, errors.New("MOCK error fetching accounts")
 }
 
-func mockFetchAccountsFail(url, username, apikey string) (account.ListResponse, error) {
+func mockFetchAccountsFail(ctx context.Context, hclient *http.Client, url, username, apikey string) (account.ListResponse, error) {
 	return account.ListResponse{Success: false, ErrorMessage: "MOCK fail fetching accounts"}, nil
 }
 
-func mockFetchSigningKeys(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+func mockFetchSigningKeys(ctx context.Context, hclient *http.Client, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
 	w := sendSyncAPIRequest("POST", "/api/keypairs/sync", bytes.NewReader(data))
 	return parseKeysResponse(w)
 }
 
-func mockFetchSigningKeysError(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+func mockFetchSigningKeysError(ctx context.Context, hclient *http.Client, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
 	return keypair.SyncResponse{}, errors.New("MOCK error fetching signing keys")
 }
 
-func mockFetchSigningKeysFail(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+func mockFetchSigningKeysFail(ctx context.Context, hclient *http.Client, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
 	return keypair.SyncResponse{Success: false}, nil
 }
 
-func mockFetchModels(url, username, apikey string) (model.ListResponse, error) {
+func mockFetchModels(ctx context.Context, hclient *http.Client, url, username, apikey string) (model.ListResponse, error) {
 	w := sendSyncAPIRequest("GET", "/api/models", nil)
 	return parseModelResponse(w)
 }
 
-func mockFetchModelsError(url, username, apikey string) (model.ListResponse, error) {
+func mockFetchModelsError(ctx context.Context, hclient *http.Client, url, username, apikey string) (model.ListResponse, error) {
 	return model.ListResponse{}, errors.New("MOCK error fetching models")
 }
 
-func mockFetchModelsFail(url, username, apikey string) (model.ListResponse, error) {
+func mockFetchModelsFail(ctx context.Context, hclient *http.Client, url, username, apikey string) (model.ListResponse, error) {
 	return model.ListResponse{Success: false, ErrorMessage: "MOCK fail fetching models"}, nil
 }
 
-func mockSendSigningLog(url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+func mockFetchSyncModels(ctx context.Context, hclient *http.Client, url, username, apikey string) (user.SyncModelsResponse, error) {
+	w := sendSyncAPIRequest("GET", "/api/syncmodels", nil)
+	return parseSyncModelsResponse(w)
+}
+
+func mockFetchSyncModelsError(ctx context.Context, hclient *http.Client, url, username, apikey string) (user.SyncModelsResponse, error) {
+	return user.SyncModelsResponse{}, errors.New("MOCK error fetching sync models")
+}
+
+func mockFetchSyncModelsFail(ctx context.Context, hclient *http.Client, url, username, apikey string) (user.SyncModelsResponse, error) {
+	return user.SyncModelsResponse{Success: false, ErrorMessage: "MOCK fail fetching sync models"}, nil
+}
+
+func mockFetchKeyDelegations(ctx context.Context, hclient *http.Client, url, username, apikey string) (keypair.SyncDelegationsResponse, error) {
+	w := sendSyncAPIRequest("GET", "/api/delegations", nil)
+	result := keypair.SyncDelegationsResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func mockFetchKeyDelegationsError(ctx context.Context, hclient *http.Client, url, username, apikey string) (keypair.SyncDelegationsResponse, error) {
+	return keypair.SyncDelegationsResponse{}, errors.New("MOCK error fetching key delegations")
+}
+
+func mockFetchKeyDelegationsFail(ctx context.Context, hclient *http.Client, url, username, apikey string) (keypair.SyncDelegationsResponse, error) {
+	return keypair.SyncDelegationsResponse{Success: false, ErrorMessage: "MOCK fail fetching key delegations"}, nil
+}
+
+func mockFetchDeviceQuarantine(ctx context.Context, hclient *http.Client, url, username, apikey string) (device.QuarantineResponse, error) {
+	w := sendSyncAPIRequest("GET", "/api/quarantine", nil)
+	result := device.QuarantineResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func mockFetchDeviceQuarantineError(ctx context.Context, hclient *http.Client, url, username, apikey string) (device.QuarantineResponse, error) {
+	return device.QuarantineResponse{}, errors.New("MOCK error fetching quarantined devices")
+}
+
+func mockFetchDeviceQuarantineFail(ctx context.Context, hclient *http.Client, url, username, apikey string) (device.QuarantineResponse, error) {
+	return device.QuarantineResponse{Success: false, ErrorMessage: "MOCK fail fetching quarantined devices"}, nil
+}
+
+func mockSendSigningLog(ctx context.Context, hclient *http.Client, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
 	return true, nil
 }
 
-func mockSendSigningLogError(url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+func mockSendSigningLogError(ctx context.Context, hclient *http.Client, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
 	return false, errors.New("MOCK error syncing signing log")
 }
 
-func mockSendTestLog(url, username, apikey string, testLog datastore.TestLog) (bool, error) {
+func mockSendTestLog(ctx context.Context, hclient *http.Client, url, username, apikey string, testLog datastore.TestLog) (bool, error) {
 	return true, nil
 }
 
-func mockSendTestLogError(url, username, apikey string, testLog datastore.TestLog) (bool, error) {
+func mockSendTestLogError(ctx context.Context, hclient *http.Client, url, username, apikey string, testLog datastore.TestLog) (bool, error) {
 	return false, errors.New("MOCK error syncing test log")
 }
 
+func mockSendDeviceManifest(ctx context.Context, hclient *http.Client, url, username, apikey string, manifest datastore.DeviceManifest) (bool, error) {
+	return true, nil
+}
+
+func mockSendDeviceManifestError(ctx context.Context, hclient *http.Client, url, username, apikey string, manifest datastore.DeviceManifest) (bool, error) {
+	return false, errors.New("MOCK error syncing device manifest")
+}
+
 func sendSyncAPIRequest(method, url string, data io.Reader) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
@@ -205,7 +560,7 @@ func sendSyncAPIRequest(method, url string, data io.Reader) *httptest.ResponseRe
 	r.Header.Set("user", "sync")
 	r.Header.Set("api-key", "ValidAPIKey")
 
-	service.AdminRouter().ServeHTTP(w, r)
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
 
 	return w
 }
@@ -231,6 +586,48 @@ func parseModelResponse(w *httptest.ResponseRecorder) (model.ListResponse, error
 	return result, err
 }
 
+func parseSyncModelsResponse(w *httptest.ResponseRecorder) (user.SyncModelsResponse, error) {
+	// Check the JSON response
+	result := user.SyncModelsResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func mockSendHeartbeat(ctx context.Context, hclient *http.Client, url, username, apikey string, instance datastore.Instance) (bool, error) {
+	data, _ := json.Marshal(instance)
+	w := sendSyncAPIRequest("POST", "/api/instances/heartbeat", bytes.NewReader(data))
+
+	result, err := response.ParseStandardResponse(w)
+	if err != nil {
+		return false, err
+	}
+	if !result.Success {
+		return false, errors.New(result.ErrorMessage)
+	}
+	return true, nil
+}
+
+func mockSendCheckIn(ctx context.Context, hclient *http.Client, url, username, apikey string, checkIn datastore.FactoryCheckIn) (bool, error) {
+	data, _ := json.Marshal(checkIn)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/instances/checkin", bytes.NewReader(data))
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", apikey)
+	r.Header.Set(instance.CheckInSignatureHeader, instance.SignCheckIn(data, apikey))
+
+	service.NewService(datastore.Environ).AdminRouter().ServeHTTP(w, r)
+
+	result, err := response.ParseStandardResponse(w)
+	if err != nil {
+		return false, err
+	}
+	if !result.Success {
+		return false, errors.New(result.ErrorMessage)
+	}
+	return true, nil
+}
+
 func mockReEncryptKeypair(keypair datastore.Keypair, newSecret string) (string, string, error) {
 	return "Base64SealedKey", "Base64SAuthKey", nil
 }
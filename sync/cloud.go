@@ -22,32 +22,38 @@ package sync
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/CanonicalLtd/serial-vault/service/instance"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/model"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/user"
 )
 
-var hclient http.Client
-
-// SendRequest sends the request to the serial vault
-var SendRequest = func(method, url, endpoint, username, apikey string, data []byte) (*http.Response, error) {
+// SendRequest sends the request to the serial vault, cancelling it when the context is done
+var SendRequest = func(ctx context.Context, hclient *http.Client, method, url, endpoint, username, apikey string, data []byte) (*http.Response, error) {
 	log.Infof("Call the cloud %s", url+endpoint)
-	r, _ := http.NewRequest(method, url+endpoint, bytes.NewReader(data))
+	r, err := http.NewRequest(method, url+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
 	r.Header.Set("user", username)
 	r.Header.Set("api-key", apikey)
 
-	return hclient.Do(r)
+	return hclient.Do(r.WithContext(ctx))
 }
 
 // FetchAccounts fetches the accounts from the cloud serial vault
-var FetchAccounts = func(url, username, apikey string) (account.ListResponse, error) {
-	w, err := SendRequest("GET", url, "accounts", username, apikey, nil)
+var FetchAccounts = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (account.ListResponse, error) {
+	w, err := SendRequest(ctx, hclient, "GET", url, "accounts", username, apikey, nil)
 	if err != nil {
 		log.Errorf("Error fetching accounts: %v", err)
 		return account.ListResponse{}, err
@@ -59,8 +65,8 @@ var FetchAccounts = func(url, username, apikey string) (account.ListResponse, er
 
 // FetchSigningKeys fetches the signing-keys from the cloud serial vault
 // Send our keystore secret to the cloud and get back the keys encrypted using our secret
-var FetchSigningKeys = func(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
-	w, err := SendRequest("POST", url, "keypairs/sync", username, apikey, data)
+var FetchSigningKeys = func(ctx context.Context, hclient *http.Client, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+	w, err := SendRequest(ctx, hclient, "POST", url, "keypairs/sync", username, apikey, data)
 	if err != nil {
 		log.Errorf("Error fetching accounts: %v", err)
 		return keypair.SyncResponse{}, err
@@ -71,8 +77,8 @@ var FetchSigningKeys = func(url, username, apikey string, data []byte) (keypair.
 }
 
 // FetchModels fetches the models from the cloud serial vault
-var FetchModels = func(url, username, apikey string) (model.ListResponse, error) {
-	w, err := SendRequest("GET", url, "models", username, apikey, nil)
+var FetchModels = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (model.ListResponse, error) {
+	w, err := SendRequest(ctx, hclient, "GET", url, "models", username, apikey, nil)
 	if err != nil {
 		log.Errorf("Error fetching models: %v", err)
 		return model.ListResponse{}, err
@@ -82,8 +88,44 @@ var FetchModels = func(url, username, apikey string) (model.ListResponse, error)
 	return parseModelResponse(w)
 }
 
+// FetchKeyDelegations fetches the root keys of the brands of the sync user and their delegations
+var FetchKeyDelegations = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (keypair.SyncDelegationsResponse, error) {
+	w, err := SendRequest(ctx, hclient, "GET", url, "delegations", username, apikey, nil)
+	if err != nil {
+		log.Errorf("Error fetching key delegations: %v", err)
+		return keypair.SyncDelegationsResponse{}, err
+	}
+
+	// Parse the response from the cloud
+	return parseDelegationsResponse(w)
+}
+
+// FetchSyncModels fetches the models assigned to the sync user, with their signing authorization
+var FetchSyncModels = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (user.SyncModelsResponse, error) {
+	w, err := SendRequest(ctx, hclient, "GET", url, "syncmodels", username, apikey, nil)
+	if err != nil {
+		log.Errorf("Error fetching signing authorizations: %v", err)
+		return user.SyncModelsResponse{}, err
+	}
+
+	// Parse the response from the cloud
+	return parseSyncModelsResponse(w)
+}
+
+// FetchDeviceQuarantine fetches the quarantined devices of the accounts of the sync user
+var FetchDeviceQuarantine = func(ctx context.Context, hclient *http.Client, url, username, apikey string) (device.QuarantineResponse, error) {
+	w, err := SendRequest(ctx, hclient, "GET", url, "quarantine", username, apikey, nil)
+	if err != nil {
+		log.Errorf("Error fetching the quarantined devices: %v", err)
+		return device.QuarantineResponse{}, err
+	}
+
+	// Parse the response from the cloud
+	return parseQuarantineResponse(w)
+}
+
 // SendSigningLog sends a signing log to the cloud serial vault
-var SendSigningLog = func(url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+var SendSigningLog = func(ctx context.Context, hclient *http.Client, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
 
 	data, err := json.Marshal(signLog)
 	if err != nil {
@@ -91,7 +133,7 @@ var SendSigningLog = func(url, username, apikey string, signLog datastore.Signin
 		return false, err
 	}
 
-	w, err := SendRequest("POST", url, "signinglog", username, apikey, data)
+	w, err := SendRequest(ctx, hclient, "POST", url, "signinglog", username, apikey, data)
 	if err != nil {
 		log.Errorf("Error syncing signing log: %v", err)
 		return false, err
@@ -112,14 +154,14 @@ var SendSigningLog = func(url, username, apikey string, signLog datastore.Signin
 }
 
 // SendTestLog sends a test log to the cloud serial vault
-var SendTestLog = func(url, username, apikey string, testLog datastore.TestLog) (bool, error) {
+var SendTestLog = func(ctx context.Context, hclient *http.Client, url, username, apikey string, testLog datastore.TestLog) (bool, error) {
 	data, err := json.Marshal(testLog)
 	if err != nil {
 		log.Errorf("Error marshalling test log: %v", err)
 		return false, err
 	}
 
-	w, err := SendRequest("POST", url, "testlog", username, apikey, data)
+	w, err := SendRequest(ctx, hclient, "POST", url, "testlog", username, apikey, data)
 	if err != nil {
 		log.Errorf("Error syncing test log: %v", err)
 		return false, err
@@ -139,6 +181,98 @@ var SendTestLog = func(url, username, apikey string, testLog datastore.TestLog)
 	return result.Success, nil
 }
 
+// SendDeviceManifest sends a device manifest to the cloud serial vault
+var SendDeviceManifest = func(ctx context.Context, hclient *http.Client, url, username, apikey string, manifest datastore.DeviceManifest) (bool, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		log.Errorf("Error marshalling device manifest: %v", err)
+		return false, err
+	}
+
+	w, err := SendRequest(ctx, hclient, "POST", url, "manifests", username, apikey, data)
+	if err != nil {
+		log.Errorf("Error syncing device manifest: %v", err)
+		return false, err
+	}
+
+	// Parse the response from the cloud
+	result, err := parseStandardResponse(w)
+	if err != nil {
+		log.Errorf("Error parsing device manifest: %v", err)
+		return false, err
+	}
+	if !result.Success {
+		log.Errorf("Error syncing device manifest: %v", result.ErrorMessage)
+		return false, err
+	}
+
+	return result.Success, nil
+}
+
+// SendHeartbeat registers the factory in the instance registry of the cloud serial vault
+var SendHeartbeat = func(ctx context.Context, hclient *http.Client, url, username, apikey string, instance datastore.Instance) (bool, error) {
+	data, err := json.Marshal(instance)
+	if err != nil {
+		log.Errorf("Error marshalling the instance: %v", err)
+		return false, err
+	}
+
+	w, err := SendRequest(ctx, hclient, "POST", url, "instances/heartbeat", username, apikey, data)
+	if err != nil {
+		log.Errorf("Error sending the heartbeat: %v", err)
+		return false, err
+	}
+
+	// Parse the response from the cloud
+	result, err := parseStandardResponse(w)
+	if err != nil {
+		log.Errorf("Error parsing the heartbeat: %v", err)
+		return false, err
+	}
+	if !result.Success {
+		log.Errorf("Error sending the heartbeat: %v", result.ErrorMessage)
+		return false, errors.New(result.ErrorMessage)
+	}
+
+	return result.Success, nil
+}
+
+// SendCheckIn reports the health of the factory to the cloud serial vault, signed with the sync credentials
+var SendCheckIn = func(ctx context.Context, hclient *http.Client, url, username, apikey string, checkIn datastore.FactoryCheckIn) (bool, error) {
+	data, err := json.Marshal(checkIn)
+	if err != nil {
+		log.Errorf("Error marshalling the check-in: %v", err)
+		return false, err
+	}
+
+	r, err := http.NewRequest("POST", url+"instances/checkin", bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	r.Header.Set("user", username)
+	r.Header.Set("api-key", apikey)
+	r.Header.Set(instance.CheckInSignatureHeader, instance.SignCheckIn(data, apikey))
+
+	w, err := hclient.Do(r.WithContext(ctx))
+	if err != nil {
+		log.Errorf("Error sending the check-in: %v", err)
+		return false, err
+	}
+
+	// Parse the response from the cloud
+	result, err := parseStandardResponse(w)
+	if err != nil {
+		log.Errorf("Error parsing the check-in: %v", err)
+		return false, err
+	}
+	if !result.Success {
+		log.Errorf("Error sending the check-in: %v", result.ErrorMessage)
+		return false, errors.New(result.ErrorMessage)
+	}
+
+	return result.Success, nil
+}
+
 func parseAccountResponse(w *http.Response) (account.ListResponse, error) {
 	// Check the JSON response
 	result := account.ListResponse{}
@@ -160,6 +294,27 @@ func parseModelResponse(w *http.Response) (model.ListResponse, error) {
 	return result, err
 }
 
+func parseDelegationsResponse(w *http.Response) (keypair.SyncDelegationsResponse, error) {
+	// Check the JSON response
+	result := keypair.SyncDelegationsResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func parseSyncModelsResponse(w *http.Response) (user.SyncModelsResponse, error) {
+	// Check the JSON response
+	result := user.SyncModelsResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func parseQuarantineResponse(w *http.Response) (device.QuarantineResponse, error) {
+	// Check the JSON response
+	result := device.QuarantineResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
 func parseStandardResponse(w *http.Response) (response.StandardResponse, error) {
 	// Check the JSON response
 	result := response.StandardResponse{}
@@ -0,0 +1,219 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sync
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Failure categories of a sync cycle
+const (
+	FailureConfig    = "config"    // the sync parameters are missing
+	FailureCloud     = "cloud"     // the cloud serial-vault failed or rejected a request
+	FailureDatastore = "datastore" // the factory database failed
+	FailureTimeout   = "timeout"   // the sync cycle timed out
+)
+
+// Entities of the sync, in the order that they are synced
+const (
+	EntityAccounts        = "accounts"
+	EntitySigningKeys     = "signing-keys"
+	EntityModels          = "models"
+	EntityDelegations     = "delegations"
+	EntityAuthorizations  = "authorizations"
+	EntityQuarantine      = "quarantine"
+	EntitySigningLogs     = "signing-logs"
+	EntityTestLogs        = "test-logs"
+	EntityDeviceManifests = "device-manifests"
+)
+
+// exitCodes are the exit codes of the sync command for the failure categories. When a cycle
+// fails in more than one category, the highest exit code is used
+var exitCodes = map[string]int{
+	FailureConfig:    2,
+	FailureCloud:     3,
+	FailureDatastore: 4,
+	FailureTimeout:   5,
+}
+
+// ExitError is the error of the sync command, with the exit code of its failure category
+type ExitError struct {
+	Category string
+	Code     int
+	Message  string
+}
+
+func (e *ExitError) Error() string {
+	return e.Message
+}
+
+func newExitError(category, message string) *ExitError {
+	return &ExitError{Category: category, Code: exitCodes[category], Message: message}
+}
+
+// syncError is an error of the sync with its failure category
+type syncError struct {
+	category string
+	err      error
+}
+
+func (e syncError) Error() string {
+	return e.err.Error()
+}
+
+func cloudError(err error) error {
+	return syncError{FailureCloud, err}
+}
+
+func datastoreError(err error) error {
+	return syncError{FailureDatastore, err}
+}
+
+// Category returns the failure category of an error of the sync
+func Category(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case syncError:
+		return e.category
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return FailureTimeout
+	}
+	return FailureCloud
+}
+
+// EntityReport counts the records of an entity that were synced in a sync cycle
+type EntityReport struct {
+	Entity   string `json:"entity"`
+	Synced   int    `json:"synced"`
+	Failed   int    `json:"failed"`
+	Category string `json:"category,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the summary of a sync cycle
+type Report struct {
+	// The byte counters are first, so they are aligned for the atomic operations
+	BytesSent     int64 `json:"bytes-sent"`
+	BytesReceived int64 `json:"bytes-received"`
+
+	Started  time.Time      `json:"started"`
+	Duration float64        `json:"duration"` // seconds
+	Entities []EntityReport `json:"entities"`
+	Success  bool           `json:"success"`
+	Category string         `json:"category,omitempty"`
+	ExitCode int            `json:"exit-code"`
+}
+
+// entity returns the report of the entity, adding it when it is new
+func (r *Report) entity(name string) *EntityReport {
+	for i := range r.Entities {
+		if r.Entities[i].Entity == name {
+			return &r.Entities[i]
+		}
+	}
+	r.Entities = append(r.Entities, EntityReport{Entity: name})
+	return &r.Entities[len(r.Entities)-1]
+}
+
+// count adds the synced and failed records of the entity
+func (r *Report) count(name string, synced, failed int) {
+	e := r.entity(name)
+	e.Synced += synced
+	e.Failed += failed
+}
+
+// Record adds the result of the sync of an entity. The records that failed to upload are a
+// failure of the cloud, though they are retried in the next sync
+func (r *Report) Record(name string, err error) {
+	e := r.entity(name)
+	switch {
+	case err != nil:
+		e.Category = Category(err)
+		e.Error = err.Error()
+	case e.Failed > 0:
+		e.Category = FailureCloud
+	}
+}
+
+// Finish completes the report of the sync cycle, with the most severe failure category of the
+// entities. A cycle that timed out is a timeout failure
+func (r *Report) Finish(timedOut bool) {
+	r.Duration = time.Since(r.Started).Seconds()
+	r.Success = true
+	r.Category = ""
+	r.ExitCode = 0
+
+	categories := []string{}
+	for _, e := range r.Entities {
+		categories = append(categories, e.Category)
+	}
+	if timedOut {
+		categories = append(categories, FailureTimeout)
+	}
+
+	for _, c := range categories {
+		if len(c) == 0 {
+			continue
+		}
+		r.Success = false
+		if exitCodes[c] > r.ExitCode {
+			r.Category = c
+			r.ExitCode = exitCodes[c]
+		}
+	}
+}
+
+// countingTransport counts the bytes sent to and received from the cloud in the report
+type countingTransport struct {
+	base   http.RoundTripper
+	report *Report
+}
+
+func (t countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.ContentLength > 0 {
+		atomic.AddInt64(&t.report.BytesSent, r.ContentLength)
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = countingBody{resp.Body, &t.report.BytesReceived}
+	return resp, nil
+}
+
+// countingBody counts the bytes read from a response body
+type countingBody struct {
+	io.ReadCloser
+	count *int64
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.count, int64(n))
+	return n, err
+}
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sync_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/sync"
+	check "gopkg.in/check.v1"
+)
+
+type reportSuite struct{}
+
+var _ = check.Suite(&reportSuite{})
+
+func (s *reportSuite) TestCategory(c *check.C) {
+	c.Assert(sync.Category(nil), check.Equals, "")
+	c.Assert(sync.Category(context.Canceled), check.Equals, sync.FailureTimeout)
+	c.Assert(sync.Category(context.DeadlineExceeded), check.Equals, sync.FailureTimeout)
+	c.Assert(sync.Category(errors.New("MOCK error")), check.Equals, sync.FailureCloud)
+}
+
+func (s *reportSuite) TestFinish(c *check.C) {
+	tests := []struct {
+		errors   map[string]error
+		timedOut bool
+		success  bool
+		category string
+		exitCode int
+	}{
+		{map[string]error{}, false, true, "", 0},
+		{map[string]error{sync.EntityAccounts: errors.New("MOCK error")}, false, false, sync.FailureCloud, 3},
+		{map[string]error{sync.EntityAccounts: errors.New("MOCK error"), sync.EntityTestLogs: context.Canceled}, false, false, sync.FailureTimeout, 5},
+		{map[string]error{}, true, false, sync.FailureTimeout, 5},
+	}
+
+	for _, t := range tests {
+		report := sync.Report{}
+		for _, entity := range []string{sync.EntityAccounts, sync.EntityModels, sync.EntityTestLogs} {
+			report.Record(entity, t.errors[entity])
+		}
+		report.Finish(t.timedOut)
+
+		c.Assert(report.Entities, check.HasLen, 3)
+		c.Assert(report.Success, check.Equals, t.success)
+		c.Assert(report.Category, check.Equals, t.category)
+		c.Assert(report.ExitCode, check.Equals, t.exitCode)
+	}
+}
+
+func (s *reportSuite) TestBytes(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	client := sync.NewFactoryClient(server.URL+"/api/", "sync", "ValidAPIKey", 0)
+	w, err := sync.SendRequest(context.Background(), client.HTTPClient, "POST", client.URL, "accounts", client.Username, client.APIKey, []byte("12345"))
+	c.Assert(err, check.IsNil)
+	ioutil.ReadAll(w.Body)
+	w.Body.Close()
+
+	c.Assert(client.Report.BytesSent, check.Equals, int64(5))
+	c.Assert(client.Report.BytesReceived, check.Equals, int64(17))
+}
@@ -21,7 +21,10 @@
 package sync
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -30,17 +33,22 @@ import (
 
 const sleepHours = 1
 
+// defaultCycleTimeout is the limit for a complete sync cycle, so a hung cloud serial-vault
+// does not block the next cycle
+const defaultCycleTimeout = 30 * time.Minute
+
 // StartCommand starts the sync process
 type StartCommand struct {
 	URL      string `short:"s" long:"svurl" description:"Sync URL for the cloud serial-vault" default:"https://serial-vault-partners.canonical.com/api/"`
 	Username string `short:"u" long:"user" description:"Sync username for the cloud serial-vault"`
 	APIKey   string `short:"a" long:"apikey" description:"Sync API key for the cloud serial-vault"`
 	Daemon   bool   `short:"d" long:"daemon" description:"Starts the sync as a scheduled process"`
+	JSON     bool   `long:"json" description:"Prints a JSON summary of each sync cycle"`
 }
 
 // Execute the sync for the factory
 func (cmd StartCommand) Execute(args []string) error {
-	withErrors := false
+	var report *Report
 	repeat := true
 
 	// Open the connection to the factory database
@@ -48,53 +56,68 @@ func (cmd StartCommand) Execute(args []string) error {
 
 	// Check that the parameters are set in the config file or command line
 	if err := cmd.verifyParameters(); err != nil {
-		return err
+		return newExitError(FailureConfig, err.Error())
 	}
 
 	for repeat {
-		withErrors = false
-
 		// Initialize the factory client
 		client := NewFactoryClient(
-			datastore.Environ.Config.SyncURL, datastore.Environ.Config.SyncUser, datastore.Environ.Config.SyncAPIKey)
-
-		// Sync the accounts
-		log.Info("Sync the accounts from the cloud")
-		err := client.Accounts()
-		if err != nil {
-			withErrors = true
+			datastore.Environ.Config.SyncURL, datastore.Environ.Config.SyncUser, datastore.Environ.Config.SyncAPIKey,
+			time.Duration(datastore.Environ.Config.SyncRequestTimeout)*time.Second)
+		client.Parallelism = datastore.Environ.Config.SyncParallelism
+		report = client.Report
+
+		ctx, cancel := context.WithTimeout(context.Background(), cycleTimeout())
+
+		// Register the factory with the cloud. The sync does not depend on it, so it
+		// continues when the cloud does not support the instance registry
+		log.Info("Send the heartbeat to the cloud")
+		client.Heartbeat(ctx)
+
+		// Report the health of the factory, before the sync changes the pending logs
+		log.Info("Send the check-in to the cloud")
+		client.CheckIn(ctx)
+
+		// The key delegations and signing authorizations are synced after the signing-keys and
+		// models, and the device manifests after the signing logs of the devices
+		steps := []struct {
+			entity  string
+			message string
+			run     func(context.Context) error
+		}{
+			{EntityAccounts, "Sync the accounts from the cloud", client.Accounts},
+			{EntitySigningKeys, "Sync the signing-keys from the cloud", client.SigningKeys},
+			{EntityModels, "Sync the models from the cloud", client.Models},
+			{EntityDelegations, "Sync the key delegations from the cloud", client.Delegations},
+			{EntityAuthorizations, "Sync the signing authorizations from the cloud", client.Authorizations},
+			{EntityQuarantine, "Sync the quarantined devices from the cloud", client.Quarantine},
+			{EntitySigningLogs, "Sync the signing logs to the cloud", client.SigningLogs},
+			{EntityTestLogs, "Sync the test logs to the cloud", client.TestLogs},
+			{EntityDeviceManifests, "Sync the device manifests to the cloud", client.DeviceManifests},
 		}
 
-		// Sync the signing-keys
-		log.Info("Sync the signing-keys from the cloud")
-		err = client.SigningKeys()
-		if err != nil {
-			withErrors = true
-		}
+		for _, step := range steps {
+			log.Info(step.message)
+			report.Record(step.entity, step.run(ctx))
 
-		// Sync the models
-		log.Info("Sync the models from the cloud")
-		err = client.Models()
-		if err != nil {
-			withErrors = true
+			e := report.entity(step.entity)
+			log.Infof("Synced %d %s (%d failed)", e.Synced, step.entity, e.Failed)
 		}
 
-		// Sync the signing logs
-		log.Info("Sync the signing logs to the cloud")
-		err = client.SigningLogs()
-		if err != nil {
-			withErrors = true
+		if ctx.Err() != nil {
+			log.Error("Sync cycle timed out")
 		}
+		report.Finish(ctx.Err() != nil)
+		cancel()
 
-		// Sync the test logs
-		log.Info("Sync the test logs to the cloud")
-		err = client.TestLogs()
-		if err != nil {
-			withErrors = true
+		log.Infof("Sync cycle took %.1fs, sent %d bytes and received %d bytes",
+			report.Duration, report.BytesSent, report.BytesReceived)
+		if !report.Success {
+			log.Errorf("Sync completed with errors (%s)", report.Category)
 		}
 
-		if withErrors {
-			log.Error("Sync completed with errors")
+		if cmd.JSON {
+			printReport(report)
 		}
 
 		if cmd.Daemon {
@@ -106,13 +129,31 @@ func (cmd StartCommand) Execute(args []string) error {
 		}
 	}
 
-	if withErrors {
-		return errors.New("Sync completed with errors")
+	if !report.Success {
+		return newExitError(report.Category, "Sync completed with errors")
 	}
 
 	return nil
 }
 
+// printReport writes the summary of a sync cycle to stdout, as a line of JSON
+func printReport(report *Report) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		log.Errorf("Error encoding the sync report: %v", err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
+// cycleTimeout returns the limit for a sync cycle from the config
+func cycleTimeout() time.Duration {
+	if datastore.Environ.Config.SyncCycleTimeout > 0 {
+		return time.Duration(datastore.Environ.Config.SyncCycleTimeout) * time.Second
+	}
+	return defaultCycleTimeout
+}
+
 func (cmd StartCommand) verifyParameters() error {
 
 	// Use the sync parameters from config file first
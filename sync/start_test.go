@@ -41,8 +41,14 @@ func (s *startSuite) SetUpTest(c *check.C) {
 	sync.FetchSigningKeys = mockFetchSigningKeys
 	datastore.ReEncryptKeypair = mockReEncryptKeypair
 	sync.FetchModels = mockFetchModels
+	sync.FetchKeyDelegations = mockFetchKeyDelegations
+	sync.FetchSyncModels = mockFetchSyncModels
+	sync.FetchDeviceQuarantine = mockFetchDeviceQuarantine
 	sync.SendSigningLog = mockSendSigningLog
 	sync.SendTestLog = mockSendTestLog
+	sync.SendDeviceManifest = mockSendDeviceManifest
+	sync.SendHeartbeat = mockSendHeartbeat
+	sync.SendCheckIn = mockSendCheckIn
 }
 
 func (s *startSuite) TestStart(c *check.C) {
@@ -69,6 +75,8 @@ func (s *startSuite) TestStart(c *check.C) {
 			sync.FetchAccounts = mockFetchAccountsError
 			sync.FetchSigningKeys = mockFetchSigningKeysError
 			sync.FetchModels = mockFetchModelsError
+			sync.FetchKeyDelegations = mockFetchKeyDelegationsError
+			sync.FetchSyncModels = mockFetchSyncModelsError
 			sync.SendSigningLog = mockSendSigningLogError
 		}
 		if t.MockFail {
@@ -76,6 +84,8 @@ func (s *startSuite) TestStart(c *check.C) {
 			sync.FetchAccounts = mockFetchAccountsFail
 			sync.FetchSigningKeys = mockFetchSigningKeysFail
 			sync.FetchModels = mockFetchModelsFail
+			sync.FetchKeyDelegations = mockFetchKeyDelegationsFail
+			sync.FetchSyncModels = mockFetchSyncModelsFail
 			sync.SendSigningLog = mockSendSigningLogError
 		}
 
@@ -85,6 +95,30 @@ func (s *startSuite) TestStart(c *check.C) {
 		sync.FetchAccounts = mockFetchAccounts
 		sync.FetchSigningKeys = mockFetchSigningKeys
 		sync.FetchModels = mockFetchModels
+		sync.FetchKeyDelegations = mockFetchKeyDelegations
+		sync.FetchSyncModels = mockFetchSyncModels
 		sync.SendSigningLog = mockSendSigningLog
 	}
 }
+
+func (s *startSuite) TestStartExitCode(c *check.C) {
+	restore := mockArgs("factory", "sync", "--user=", "--apikey=")
+	err := RunMain()
+	restore()
+	c.Assert(err, check.FitsTypeOf, &sync.ExitError{})
+	c.Assert(err.(*sync.ExitError).Category, check.Equals, sync.FailureConfig)
+	c.Assert(err.(*sync.ExitError).Code, check.Equals, 2)
+
+	// The failures of the factory database are more severe than the failures of the cloud
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	sync.FetchAccounts = mockFetchAccountsError
+	restore = mockArgs("factory", "sync", "--user=sync", "--apikey=ValidAPIKey", "--json")
+	err = RunMain()
+	restore()
+	c.Assert(err, check.FitsTypeOf, &sync.ExitError{})
+	c.Assert(err.(*sync.ExitError).Category, check.Equals, sync.FailureDatastore)
+	c.Assert(err.(*sync.ExitError).Code, check.Equals, 4)
+
+	datastore.Environ.DB = &datastore.MockDB{}
+	sync.FetchAccounts = mockFetchAccounts
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/log"
+	logging "github.com/op/go-logging"
 )
 
 // Command defines the options for the serial-vault-admin command-line utility
@@ -44,6 +45,13 @@ func openDatabase() {
 	log.Infof("Open the settings file: %s", Sync.SettingsFile)
 	config.ReadConfig(&datastore.Environ.Config, Sync.SettingsFile)
 
+	// Write the logs to the configured sinks, as the factory may not have a log shipper
+	if len(datastore.Environ.Config.LogSinks) > 0 {
+		if err := log.InitSinks(datastore.Environ.Config.LogSinks, logging.INFO); err != nil {
+			log.Errorf("Error opening the log sinks: %v", err)
+		}
+	}
+
 	// Open the connection to the database
 	datastore.OpenSysDatabase(datastore.Environ.Config.Driver, datastore.Environ.Config.DataSource)
 }
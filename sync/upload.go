@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sync
+
+import (
+	"context"
+	gosync "sync"
+)
+
+// DefaultParallelism is the number of concurrent uploads to the cloud serial-vault
+const DefaultParallelism = 4
+
+// uploadAll runs the upload of each item using a bounded pool of workers, so a large
+// backlog is synced quickly without flooding the cloud. The results are returned in the
+// order of the items, so they can be checkpointed in order. Items that have not been
+// started when the context is done are reported as not uploaded
+func uploadAll(ctx context.Context, count, parallelism int, upload func(i int) bool) []bool {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	if parallelism > count {
+		parallelism = count
+	}
+
+	results := make([]bool, count)
+	items := make(chan int)
+
+	var wg gosync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				if ctx.Err() != nil {
+					continue
+				}
+				results[i] = upload(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	return results
+}
@@ -31,6 +31,8 @@ const (
 	ClaimsEmail            = "email"
 	ClaimsName             = "name"
 	ClaimsRole             = "role"
+	ClaimsImpersonator     = "impersonator"
+	ClaimsBrand            = "brand"
 	StandardClaimExpiresAt = "exp"
 )
 
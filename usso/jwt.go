@@ -32,18 +32,23 @@ import (
 	"github.com/juju/usso/openid"
 )
 
-func createJWT(username, name, email, identity string, role int, expires int64) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	claims := token.Claims.(jwt.MapClaims)
-	claims[ClaimsUsername] = username
-	claims[ClaimsName] = name
-	claims[ClaimsEmail] = email
-	claims[ClaimsIdentity] = identity
-	claims[ClaimsRole] = role
-	claims[StandardClaimExpiresAt] = expires
-
-	jwtSecret := datastore.Environ.Config.JwtSecret
+// ImpersonationLifetime is the time that a superuser can act as a brand admin with one token
+const ImpersonationLifetime = time.Hour
+
+func createJWT(username, name, email, identity string, role int, expires int64, jwtSecret string) (string, error) {
+	return signJWT(jwt.MapClaims{
+		ClaimsUsername:         username,
+		ClaimsName:             name,
+		ClaimsEmail:            email,
+		ClaimsIdentity:         identity,
+		ClaimsRole:             role,
+		StandardClaimExpiresAt: expires,
+	}, jwtSecret)
+}
+
+func signJWT(claims jwt.MapClaims, jwtSecret string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
 	if len(jwtSecret) == 0 {
 		return "", errors.New("JWT secret empty value. Please configure it properly")
 	}
@@ -55,23 +60,43 @@ func createJWT(username, name, email, identity string, role int, expires int64)
 	return tokenString, err
 }
 
-// NewJWTToken creates a new JWT from the verified OpenID response
-func NewJWTToken(resp *openid.Response, role int) (string, error) {
-	return createJWT(resp.SReg["nickname"], resp.SReg["fullname"], resp.SReg["email"], resp.ID, role, time.Now().Add(time.Hour*24).Unix())
+// NewJWTToken creates a new JWT from the verified OpenID response, signed with the JWT secret
+func NewJWTToken(resp *openid.Response, role int, jwtSecret string) (string, error) {
+	return createJWT(resp.SReg["nickname"], resp.SReg["fullname"], resp.SReg["email"], resp.ID, role, time.Now().Add(time.Hour*24).Unix(), jwtSecret)
 }
 
-func keyFunc(token *jwt.Token) (interface{}, error) {
-	jwtSecret := datastore.Environ.Config.JwtSecret
-	if len(jwtSecret) == 0 {
-		return []byte{}, errors.New("JWT secret empty value. Please configure it properly")
+// NewUserJWT creates a new JWT for a user of the datastore, signed with the JWT secret
+func NewUserJWT(user datastore.User, jwtSecret string) (string, error) {
+	return createJWT(user.Username, user.Name, user.Email, "", user.Role, time.Now().Add(time.Hour*24).Unix(), jwtSecret)
+}
+
+// NewImpersonationJWT creates a JWT for a superuser to act as an admin user of the brand. The
+// token identifies the impersonator and the brand, so the actions can be audited
+func NewImpersonationJWT(user datastore.User, impersonator, brand, jwtSecret string) (string, error) {
+	return signJWT(jwt.MapClaims{
+		ClaimsUsername:         user.Username,
+		ClaimsName:             user.Name,
+		ClaimsEmail:            user.Email,
+		ClaimsRole:             user.Role,
+		ClaimsImpersonator:     impersonator,
+		ClaimsBrand:            brand,
+		StandardClaimExpiresAt: time.Now().Add(ImpersonationLifetime).Unix(),
+	}, jwtSecret)
+}
+
+func keyFunc(jwtSecret string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if len(jwtSecret) == 0 {
+			return []byte{}, errors.New("JWT secret empty value. Please configure it properly")
+		}
+		return []byte(jwtSecret), nil
 	}
-	return []byte(jwtSecret), nil
 }
 
-// VerifyJWT checks that we have a valid token
-func VerifyJWT(jwtToken string) (*jwt.Token, error) {
+// VerifyJWT checks that we have a valid token, signed with the JWT secret
+func VerifyJWT(jwtToken, jwtSecret string) (*jwt.Token, error) {
 
-	token, err := jwt.Parse(jwtToken, keyFunc)
+	token, err := jwt.Parse(jwtToken, keyFunc(jwtSecret))
 
 	return token, err
 }
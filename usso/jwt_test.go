@@ -24,12 +24,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	"github.com/CanonicalLtd/serial-vault/config"
-	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/juju/usso/openid"
 )
 
+const testSecret = "SomeTestSecretValue"
+
 type testJWT struct {
 	resp     openid.Response
 	expected []string
@@ -57,13 +57,10 @@ func TestNewJWTToken(t *testing.T) {
 		role:     300,
 	}
 
-	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{Config: config}
-
 	for _, r := range []testJWT{test1, test2, test3} {
 
 		// adding arbitrary role value for second parameter
-		jwtToken, err := NewJWTToken(&r.resp, r.role)
+		jwtToken, err := NewJWTToken(&r.resp, r.role, testSecret)
 		if err != nil {
 			t.Errorf("Error creating JWT: %v", err)
 		}
@@ -74,7 +71,7 @@ func TestNewJWTToken(t *testing.T) {
 }
 
 func expectedToken(t *testing.T, jwtToken string, resp *openid.Response, username, email, name string, role int) {
-	token, err := VerifyJWT(jwtToken)
+	token, err := VerifyJWT(jwtToken, testSecret)
 	if err != nil {
 		t.Errorf("Error validating JWT: %v", err)
 	}
@@ -50,16 +50,21 @@ func replyHTTPError(w http.ResponseWriter, returnCode int, err error) {
 	errorTemplate.Execute(w, err)
 }
 
+// Service holds the dependencies of the Ubuntu SSO handlers
+type Service struct {
+	*datastore.Env
+}
+
 // LoginHandler processes the login for Ubuntu SSO
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	r.ParseForm()
 
 	url := *r.URL
 
 	// Set the return URL: from the OpenID login with the full domain name
-	url.Scheme = datastore.Environ.Config.URLScheme
-	url.Host = datastore.Environ.Config.URLHost
+	url.Scheme = srv.Config.URLScheme
+	url.Host = srv.Config.URLHost
 
 	if r.Form.Get("openid.ns") == "" {
 		req := openid.Request{
@@ -89,7 +94,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	User, err := datastore.Environ.DB.GetUserByUsername(username)
+	User, err := srv.DB.GetUserByUsername(username)
 	if err != nil {
 		// Cannot find the user, so redirect to the login page
 		log.Printf("Error retrieving user from datastore: %v\n", err)
@@ -105,7 +110,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build the JWT
-	jwtToken, err := NewJWTToken(resp, User.Role)
+	jwtToken, err := NewJWTToken(resp, User.Role, srv.Config.JwtSecret)
 	if err != nil {
 		// Unexpected that this should occur, so leave the detailed response
 		log.Printf("Error creating the JWT: %v", err)
@@ -131,13 +136,13 @@ var errorTemplate = template.Must(template.New("failure").Parse(`<html>
 `))
 
 // LogoutHandler logs the user out by removing the cookie and the JWT authorization header
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Service) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Remove the authorization header with contains the bearer token
 	w.Header().Del("Authorization")
 
 	// Create a new invalid token with an unauthorized user
-	jwtToken, err := createJWT("INVALID", "Not Logged-In", "", "", 0, 0)
+	jwtToken, err := createJWT("INVALID", "Not Logged-In", "", "", 0, 0, srv.Config.JwtSecret)
 	if err != nil {
 		log.Println("Error logging out:", err.Error())
 	}
@@ -40,12 +40,12 @@ import (
 func TestLoginHandlerUSSORedirect(t *testing.T) {
 
 	// Mock the database
-	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	config := config.Settings{JwtSecret: testSecret}
+	srv := &Service{&datastore.Env{DB: &datastore.MockDB{}, Config: config}}
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/login", nil)
-	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+	http.HandlerFunc(srv.LoginHandler).ServeHTTP(w, r)
 
 	if w.Code != http.StatusFound {
 		t.Errorf("Expected HTTP status '302', got: %v", w.Code)
@@ -68,13 +68,13 @@ func TestLoginHandlerReturn(t *testing.T) {
 	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.op_endpoint=https://login.ubuntu.com/%2Bopenid&openid.claimed_id=https://login.ubuntu.com/%2Bid/AAAAAA&openid.identity=https://login.ubuntu.com/%2Bid/AAAAAA&openid.return_to=http://return.to&openid.response_nonce=2005-05-15T17:11:51ZUNIQUE&openid.assoc_handle=1&openid.signed=op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,sreg.email,sreg.fullname&openid.sig=AAAA&openid.ns.sreg=http://openid.net/extensions/sreg/1.1&openid.sreg.email=a@example.org&openid.sreg.fullname=A&openid.sreg.nickname=a"
 
 	// Mock the database and OpenID verification
-	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	config := config.Settings{JwtSecret: testSecret}
+	srv := &Service{&datastore.Env{DB: &datastore.MockDB{}, Config: config}}
 	verify = verifySuccess
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", url, nil)
-	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+	http.HandlerFunc(srv.LoginHandler).ServeHTTP(w, r)
 
 	if w.Code != http.StatusTemporaryRedirect {
 		t.Errorf("Expected HTTP status '307', got: %v", w.Code)
@@ -93,7 +93,7 @@ func TestLoginHandlerReturn(t *testing.T) {
 	response, _ := verifySuccess(url)
 
 	// Get User Role
-	user, err := datastore.Environ.DB.GetUserByUsername(response.SReg["nickname"])
+	user, err := srv.DB.GetUserByUsername(response.SReg["nickname"])
 
 	if err != nil {
 		t.Errorf("Could not get datastore user %v: %v\n", response.SReg["nickname"], err)
@@ -107,12 +107,12 @@ func TestLoginHandlerBadUser(t *testing.T) {
 	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.op_endpoint=https://login.ubuntu.com/%2Bopenid&openid.claimed_id=https://login.ubuntu.com/%2Bid/AAAAAA&openid.identity=https://login.ubuntu.com/%2Bid/AAAAAA&openid.return_to=http://return.to&openid.response_nonce=2005-05-15T17:11:51ZUNIQUE&openid.assoc_handle=1&openid.signed=op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,sreg.email,sreg.fullname&openid.sig=AAAA&openid.ns.sreg=http://openid.net/extensions/sreg/1.1&openid.sreg.email=a@example.org&openid.sreg.fullname=A&openid.sreg.nickname=a"
 
 	// Mock the database (with error) and OpenID verification
-	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}}
+	srv := &Service{&datastore.Env{DB: &datastore.ErrorMockDB{}}}
 	verify = verifySuccess
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", url, nil)
-	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+	http.HandlerFunc(srv.LoginHandler).ServeHTTP(w, r)
 
 	if w.Code != http.StatusTemporaryRedirect {
 		t.Errorf("Expected HTTP status '307', got: %v", w.Code)
@@ -128,12 +128,12 @@ func TestLoginHandlerReturnBad(t *testing.T) {
 	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.op_endpoint=https://login.ubuntu.com/%2Bopenid&openid.claimed_id=https://login.ubuntu.com/%2Bid/AAAAAA&openid.identity=https://login.ubuntu.com/%2Bid/AAAAAA&openid.return_to=http://return.to&openid.response_nonce=2005-05-15T17:11:51ZUNIQUE&openid.assoc_handle=1&openid.signed=op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,sreg.email,sreg.fullname&openid.sig=AAAA&openid.ns.sreg=http://openid.net/extensions/sreg/1.1&openid.sreg.email=a@example.org&openid.sreg.fullname=A&openid.sreg.nickname="
 
 	// Mock the database and OpenID verification
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+	srv := &Service{&datastore.Env{DB: &datastore.MockDB{}}}
 	verify = verifySuccess
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", url, nil)
-	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+	http.HandlerFunc(srv.LoginHandler).ServeHTTP(w, r)
 
 	if w.Code != http.StatusTemporaryRedirect {
 		t.Errorf("Expected HTTP status '307', got: %v", w.Code)
@@ -149,12 +149,12 @@ func TestLoginHandlerReturnFail(t *testing.T) {
 	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.op_endpoint=https://login.ubuntu.com/%2Bopenid&openid.claimed_id=https://login.ubuntu.com/%2Bid/AAAAAA&openid.identity=https://login.ubuntu.com/%2Bid/AAAAAA&openid.return_to=http://return.to&openid.response_nonce=2005-05-15T17:11:51ZUNIQUE&openid.assoc_handle=1&openid.signed=op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,sreg.email,sreg.fullname&openid.sig=AAAA&openid.ns.sreg=http://openid.net/extensions/sreg/1.1&openid.sreg.email=a@example.org&openid.sreg.fullname=A&openid.sreg.nickname=a"
 
 	// Mock the database and OpenID verification
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+	srv := &Service{&datastore.Env{DB: &datastore.MockDB{}}}
 	verify = verifyFail
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", url, nil)
-	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+	http.HandlerFunc(srv.LoginHandler).ServeHTTP(w, r)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected HTTP status '400', got: %v", w.Code)
@@ -164,7 +164,7 @@ func TestLoginHandlerReturnFail(t *testing.T) {
 func TestLogoutHandler(t *testing.T) {
 
 	// Mock the database
-	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+	srv := &Service{&datastore.Env{DB: &datastore.MockDB{}}}
 
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/logout", nil)
@@ -178,7 +178,7 @@ func TestLogoutHandler(t *testing.T) {
 	r.AddCookie(&c)
 
 	// Send the logout request
-	http.HandlerFunc(LogoutHandler).ServeHTTP(w, r)
+	http.HandlerFunc(srv.LogoutHandler).ServeHTTP(w, r)
 
 	if w.Code != http.StatusTemporaryRedirect {
 		t.Errorf("Expected HTTP status '307', got: %v", w.Code)
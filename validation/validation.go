@@ -0,0 +1,300 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package validation holds the rules that the serial-vault applies to the serial-request and
+// serial assertions it receives, and the resolution of their model or sub-store model. It does
+// not use the datastore globals: the models are looked up through the Models interface, so the
+// rules can be reused by other services and tests with their own datastore.
+package validation
+
+import (
+	"errors"
+	"io"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// Kinds of model that a serial assertion resolves to
+const (
+	ResolvedModel    = "model"    // a model of the brand
+	ResolvedSubstore = "substore" // a sub-store model that the device was pivoted to
+)
+
+// Validation errors, which the services map to their responses
+var (
+	ErrEmptyData           = errors.New("No data supplied")
+	ErrNotSerialRequest    = errors.New("The assertion type must be 'serial-request'")
+	ErrNotSerial           = errors.New("The assertion type must be 'serial'")
+	ErrNotModel            = errors.New("The 2nd assertion type must be 'model'")
+	ErrMismatchedModel     = errors.New("Model and serial-request assertion do not match")
+	ErrUnexpectedAssertion = errors.New("unexpected assertion in the request stream")
+	ErrModelNotFound       = errors.New("Cannot find model with the matching brand and model")
+	ErrSubstoreNotFound    = errors.New("Cannot find sub-store mapping for the model")
+	ErrNoModelOrSubstore   = errors.New("Cannot find a matching model or sub-store model")
+	ErrInvalidAPIKey       = errors.New("The API key is not the API key of the model")
+	ErrSubstoreAPIKey      = errors.New("The API key is not the API key of the model that the sub-store model was pivoted from")
+	ErrPivotTargetNotFound = errors.New("Cannot find the model of the sub-store brand that the device is pivoted to")
+	ErrCrossAuthorityPivot = errors.New("The device is pivoted to another brand and must request its serial with the brand of the sub-store")
+)
+
+// Resolution is the model that signs for a device and, when the device has been pivoted, the
+// sub-store model that it was pivoted to. When the sub-store is another brand, the model that
+// signs is the target model of that brand
+type Resolution struct {
+	Model    datastore.Model
+	Substore *datastore.Substore
+	Target   *datastore.Model
+}
+
+// Pivoted checks if the device has been pivoted to a sub-store model
+func (r Resolution) Pivoted() bool {
+	return r.Substore != nil
+}
+
+// AuthorityID returns the authority-id of the serial assertion of the device
+func (r Resolution) AuthorityID(brandID string) string {
+	if !r.Pivoted() {
+		return brandID
+	}
+	return Pivot{Substore: *r.Substore, Target: r.Target}.AuthorityID(brandID)
+}
+
+// Pivot is a sub-store model that a device is pivoted to and, when the account of the sub-store
+// is another brand than the model it was pivoted from, the target model of that brand
+type Pivot struct {
+	Substore datastore.Substore
+	Target   *datastore.Model
+}
+
+// CrossAuthority checks if the device is pivoted to a model of another brand
+func (p Pivot) CrossAuthority() bool {
+	return p.Target != nil
+}
+
+// SigningModel returns the model whose signing-keys sign the assertions of the pivoted device
+func (p Pivot) SigningModel() datastore.Model {
+	if p.CrossAuthority() {
+		return *p.Target
+	}
+	return p.Substore.FromModel
+}
+
+// BrandID returns the brand-id of the assertions of the pivoted device
+func (p Pivot) BrandID(brandID string) string {
+	if p.CrossAuthority() {
+		return p.Target.BrandID
+	}
+	return brandID
+}
+
+// AuthorityID returns the authority-id of the serial assertion of the pivoted device
+func (p Pivot) AuthorityID(brandID string) string {
+	if !p.CrossAuthority() {
+		return SerialAuthority(p.Substore, brandID)
+	}
+	if len(p.Target.AuthorityID) == 0 {
+		return p.Target.BrandID
+	}
+	return p.Target.AuthorityID
+}
+
+// Models looks up the models and sub-store models, as implemented by the datastore
+type Models interface {
+	FindModel(brandID, modelName, apiKey string) (datastore.Model, error)
+	CheckModelExists(brandID, name string) bool
+	GetSubstore(fromModelID int, serialNumber string) (datastore.Substore, error)
+	GetSubstoreModel(brand, model, serialNumber string) (datastore.Substore, error)
+	PivotTarget(store datastore.Substore) (datastore.Model, bool, error)
+}
+
+// DecodeSerialRequest decodes the request stream of a device: a serial-request assertion, optionally
+// followed by the model assertion of the device, which must be for the same brand and model. A decode
+// error is returned as it is, so the error for an invalid assertion is descriptive
+func DecodeSerialRequest(r io.Reader) (asserts.Assertion, asserts.Assertion, error) {
+	dec := asserts.NewDecoder(r)
+	serialRequest, err := dec.Decode()
+	if err == io.EOF {
+		return nil, nil, ErrEmptyData
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Decode the optional model
+	model, err := dec.Decode()
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	// Stream must be ended now
+	_, err = dec.Decode()
+	if err != io.EOF {
+		if err == nil {
+			err = ErrUnexpectedAssertion
+		}
+		return nil, nil, err
+	}
+
+	// The details of the serial-request will have been validated by the Decode call
+	if serialRequest.Type() != asserts.SerialRequestType {
+		return nil, nil, ErrNotSerialRequest
+	}
+
+	// Double check the model assertion if present
+	if model != nil {
+		if model.Type() != asserts.ModelType {
+			return nil, nil, ErrNotModel
+		}
+		if model.HeaderString("brand-id") != serialRequest.HeaderString("brand-id") || model.HeaderString("model") != serialRequest.HeaderString("model") {
+			return nil, nil, ErrMismatchedModel
+		}
+
+		// TODO: ideally check the signature of model, need access
+		// to the brand public key(s) for models
+	}
+
+	return serialRequest, model, nil
+}
+
+// DecodeSerial decodes a serial assertion, as supplied to check or pivot a device
+func DecodeSerial(r io.Reader) (asserts.Assertion, error) {
+	dec := asserts.NewDecoder(r)
+	serial, err := dec.Decode()
+	if err == io.EOF {
+		return nil, ErrEmptyData
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The details will have been validated by the Decode call
+	if serial.Type() != asserts.SerialType {
+		return nil, ErrNotSerial
+	}
+	return serial, nil
+}
+
+// ResolveDevice finds the model that signs for a device. The models are matched in order:
+//  1. the model of the brand with the API key, for an original device
+//  2. the sub-store model of the brand with the serial number, for a pivoted device: an exact serial
+//     number first, and then the serial ranges and patterns by their precedence. The model that the
+//     sub-store model was pivoted from must have the API key
+//
+// A sub-store model whose model has another API key fails with ErrSubstoreAPIKey, and a model of the
+// brand with another API key with ErrInvalidAPIKey. Otherwise there is no model nor sub-store model
+// and it fails with ErrNoModelOrSubstore.
+//
+// A device that is pivoted to a sub-store of another brand is signed by the target model of that
+// brand, so it must request its serial with the brand of the sub-store: a request with the brand
+// of the model it was pivoted from fails with ErrCrossAuthorityPivot
+func ResolveDevice(db Models, brandID, modelName, serialNumber, apiKey string) (Resolution, error) {
+	// Assume this is an original (non-pivoted) device
+	model, err := db.FindModel(brandID, modelName, apiKey)
+	if err == nil {
+		return Resolution{Model: model}, nil
+	}
+
+	// Assume that this is a pivoted device, so check for a sub-store model for the pivot
+	substore, err := db.GetSubstoreModel(brandID, modelName, serialNumber)
+	if err == nil {
+		if substore.FromModel.APIKey != apiKey {
+			return Resolution{}, ErrSubstoreAPIKey
+		}
+		pivot, err := ResolvePivotTarget(db, substore)
+		if err != nil {
+			return Resolution{}, err
+		}
+		if pivot.CrossAuthority() && pivot.Target.BrandID != datastore.CanonicalBrandID(brandID) {
+			return Resolution{}, ErrCrossAuthorityPivot
+		}
+		return Resolution{Model: pivot.SigningModel(), Substore: &pivot.Substore, Target: pivot.Target}, nil
+	}
+
+	if db.CheckModelExists(brandID, modelName) {
+		return Resolution{}, ErrInvalidAPIKey
+	}
+	return Resolution{}, ErrNoModelOrSubstore
+}
+
+// ResolveModel finds the model that signs for a device: the model of the brand with the API key
+// or, when the device has been pivoted, the model that its sub-store model was pivoted from, which
+// must have the API key
+func ResolveModel(db Models, brandID, modelName, serialNumber, apiKey string) (datastore.Model, error) {
+	resolution, err := ResolveDevice(db, brandID, modelName, serialNumber, apiKey)
+	return resolution.Model, err
+}
+
+// ResolvePivot finds the sub-store model that a device of the model with the API key is pivoted to.
+// A model of the brand with another API key fails with ErrInvalidAPIKey
+func ResolvePivot(db Models, brandID, modelName, serialNumber, apiKey string) (datastore.Substore, error) {
+	model, err := db.FindModel(brandID, modelName, apiKey)
+	if err != nil && db.CheckModelExists(brandID, modelName) {
+		return datastore.Substore{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return datastore.Substore{}, ErrModelNotFound
+	}
+
+	substore, err := db.GetSubstore(model.ID, serialNumber)
+	if err != nil {
+		return datastore.Substore{}, ErrSubstoreNotFound
+	}
+
+	return substore, nil
+}
+
+// ResolvePivotTarget finds the target model of a sub-store model of another brand than the model it
+// was pivoted from. The brand of the sub-store must have a model with the sub-store model name, or it
+// fails with ErrPivotTargetNotFound
+func ResolvePivotTarget(db Models, substore datastore.Substore) (Pivot, error) {
+	target, crossAuthority, err := db.PivotTarget(substore)
+	switch {
+	case err == datastore.ErrPivotTargetNotFound:
+		return Pivot{}, ErrPivotTargetNotFound
+	case err != nil:
+		return Pivot{}, ErrSubstoreNotFound
+	case !crossAuthority:
+		return Pivot{Substore: substore}, nil
+	}
+	return Pivot{Substore: substore, Target: &target}, nil
+}
+
+// SerialAuthority returns the authority-id of the serial assertion of a device that is pivoted to
+// the sub-store model: the brand of the device, unless the signing-key of the model that it was
+// pivoted from belongs to another account, as a serial assertion is signed by its authority
+func SerialAuthority(substore datastore.Substore, brandID string) string {
+	if len(substore.FromModel.AuthorityID) == 0 {
+		return brandID
+	}
+	return substore.FromModel.AuthorityID
+}
+
+// ResolveSerial checks that a device is of a model of the brand, or of a sub-store model
+// that it was pivoted to, returning the kind of model that it resolves to
+func ResolveSerial(db Models, brandID, modelName, serialNumber string) (string, error) {
+	if db.CheckModelExists(brandID, modelName) {
+		return ResolvedModel, nil
+	}
+
+	if _, err := db.GetSubstoreModel(brandID, modelName, serialNumber); err != nil {
+		return "", ErrSubstoreNotFound
+	}
+	return ResolvedSubstore, nil
+}
@@ -0,0 +1,288 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package validation
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+)
+
+func TestDecodeSerialRequestInvalid(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{"", ErrEmptyData},
+		{"invalid data", nil},
+	}
+
+	for _, tt := range tests {
+		serialRequest, model, err := DecodeSerialRequest(bytes.NewBufferString(tt.data))
+		if err == nil {
+			t.Errorf("DecodeSerialRequest(%q): expected an error", tt.data)
+		}
+		if tt.err != nil && err != tt.err {
+			t.Errorf("DecodeSerialRequest(%q): expected %v, got %v", tt.data, tt.err, err)
+		}
+		if serialRequest != nil || model != nil {
+			t.Errorf("DecodeSerialRequest(%q): expected no assertions", tt.data)
+		}
+	}
+}
+
+func TestDecodeSerialInvalid(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{"", ErrEmptyData},
+		{"invalid data", nil},
+	}
+
+	for _, tt := range tests {
+		serial, err := DecodeSerial(bytes.NewBufferString(tt.data))
+		if err == nil {
+			t.Errorf("DecodeSerial(%q): expected an error", tt.data)
+		}
+		if tt.err != nil && err != tt.err {
+			t.Errorf("DecodeSerial(%q): expected %v, got %v", tt.data, tt.err, err)
+		}
+		if serial != nil {
+			t.Errorf("DecodeSerial(%q): expected no assertion", tt.data)
+		}
+	}
+}
+
+func TestResolveModel(t *testing.T) {
+	tests := []struct {
+		db        Models
+		brandID   string
+		modelName string
+		apiKey    string
+		modelID   int
+		err       error
+	}{
+		{&datastore.MockDB{}, "system", "alder", "apikey", 1, nil},
+		{&datastore.MockDB{}, "system", "alder-mybrand", "", 1, nil},
+		{&datastore.MockDB{}, "system", "alder-mybrand", "apikey", 0, ErrSubstoreAPIKey},
+		{&datastore.MockDB{}, "system", "alder", "NoModelForApiKey", 0, ErrInvalidAPIKey},
+		{&datastore.MockDB{}, "system", "invalid", "", 0, ErrNoModelOrSubstore},
+		{&datastore.ErrorMockDB{}, "system", "alder", "apikey", 0, ErrNoModelOrSubstore},
+	}
+
+	for _, tt := range tests {
+		model, err := ResolveModel(tt.db, tt.brandID, tt.modelName, "abc1234", tt.apiKey)
+		if err != tt.err {
+			t.Errorf("ResolveModel(%s, %s): expected %v, got %v", tt.brandID, tt.modelName, tt.err, err)
+		}
+		if model.ID != tt.modelID {
+			t.Errorf("ResolveModel(%s, %s): expected model %d, got %d", tt.brandID, tt.modelName, tt.modelID, model.ID)
+		}
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	tests := []struct {
+		modelName   string
+		pivoted     bool
+		authorityID string
+	}{
+		{"alder", false, "system"},
+		{"alder-mybrand", true, "generic"},
+	}
+
+	for _, tt := range tests {
+		resolution, err := ResolveDevice(&datastore.MockDB{}, "system", tt.modelName, "abc1234", "")
+		if err != nil {
+			t.Fatalf("ResolveDevice(%s): unexpected error: %v", tt.modelName, err)
+		}
+		if resolution.Pivoted() != tt.pivoted {
+			t.Errorf("ResolveDevice(%s): expected pivoted %v, got %v", tt.modelName, tt.pivoted, resolution.Pivoted())
+		}
+		if authorityID := resolution.AuthorityID("system"); authorityID != tt.authorityID {
+			t.Errorf("ResolveDevice(%s): expected authority %q, got %q", tt.modelName, tt.authorityID, authorityID)
+		}
+	}
+}
+
+func TestSerialAuthority(t *testing.T) {
+	tests := []struct {
+		keyAuthority string
+		authorityID  string
+	}{
+		{"", "system"},
+		{"system", "system"},
+		{"reseller", "reseller"},
+	}
+
+	for _, tt := range tests {
+		substore := datastore.Substore{FromModel: datastore.Model{BrandID: "system", AuthorityID: tt.keyAuthority}}
+		if authorityID := SerialAuthority(substore, "system"); authorityID != tt.authorityID {
+			t.Errorf("SerialAuthority(%q): expected %q, got %q", tt.keyAuthority, tt.authorityID, authorityID)
+		}
+	}
+}
+
+func TestResolvePivotAPIKey(t *testing.T) {
+	_, err := ResolvePivot(&datastore.MockDB{}, "system", "alder", "abc1234", "NoModelForApiKey")
+	if err != ErrInvalidAPIKey {
+		t.Errorf("ResolvePivot: expected %v, got %v", ErrInvalidAPIKey, err)
+	}
+}
+
+func TestResolvePivot(t *testing.T) {
+	tests := []struct {
+		db        Models
+		modelName string
+		store     string
+		err       error
+	}{
+		{&datastore.MockDB{}, "alder", "mybrand", nil},
+		{&datastore.MockDB{}, "invalid", "", ErrModelNotFound},
+		{&datastore.ErrorMockDB{}, "alder", "", ErrModelNotFound},
+	}
+
+	for _, tt := range tests {
+		substore, err := ResolvePivot(tt.db, "system", tt.modelName, "abc1234", "apikey")
+		if err != tt.err {
+			t.Errorf("ResolvePivot(%s): expected %v, got %v", tt.modelName, tt.err, err)
+		}
+		if substore.Store != tt.store {
+			t.Errorf("ResolvePivot(%s): expected store %q, got %q", tt.modelName, tt.store, substore.Store)
+		}
+	}
+}
+
+// crossAuthorityDB returns a datastore with a sub-store of the reseller brand that pivots the
+// devices of a model of the system brand
+func crossAuthorityDB(t *testing.T) (*datastoretest.DB, datastore.Account) {
+	db := datastoretest.New()
+	db.AddAccount(datastore.Account{AuthorityID: "system"})
+	reseller := db.AddAccount(datastore.Account{AuthorityID: "reseller"})
+
+	systemKey := db.AddKeypair(datastoretest.NewKeypair("system", "systemkey").Build())
+	resellerKey := db.AddKeypair(datastoretest.NewKeypair("reseller", "resellerkey").Build())
+	alder := db.AddModel(datastoretest.NewModel("system", "alder").WithKeypair(systemKey).Build())
+	db.AddModel(datastoretest.NewModel("reseller", "alder-reseller").WithKeypair(resellerKey).Build())
+
+	store := datastore.Substore{AccountID: reseller.ID, FromModelID: alder.ID, Store: "reseller-store", SerialNumber: "abc1234", ModelName: "alder-reseller"}
+	if err := db.CreateAllowedSubstore(store, datastore.User{Role: datastore.Superuser}); err != nil {
+		t.Fatalf("CreateAllowedSubstore: unexpected error: %v", err)
+	}
+	return db, reseller
+}
+
+func TestResolveDeviceCrossAuthority(t *testing.T) {
+	db, _ := crossAuthorityDB(t)
+
+	resolution, err := ResolveDevice(db, "reseller", "alder-reseller", "abc1234", "system-alder")
+	if err != nil {
+		t.Fatalf("ResolveDevice: unexpected error: %v", err)
+	}
+	if resolution.Target == nil || resolution.Model.KeyID != "resellerkey" {
+		t.Errorf("ResolveDevice: expected the reseller model to sign, got key %q", resolution.Model.KeyID)
+	}
+	if authorityID := resolution.AuthorityID("reseller"); authorityID != "reseller" {
+		t.Errorf("ResolveDevice: expected authority %q, got %q", "reseller", authorityID)
+	}
+
+	// The device must request its serial with the brand of the sub-store
+	_, err = ResolveDevice(db, "system", "alder-reseller", "abc1234", "system-alder")
+	if err != ErrCrossAuthorityPivot {
+		t.Errorf("ResolveDevice: expected %v, got %v", ErrCrossAuthorityPivot, err)
+	}
+}
+
+func TestResolvePivotTarget(t *testing.T) {
+	db, _ := crossAuthorityDB(t)
+
+	substore, err := ResolvePivot(db, "system", "alder", "abc1234", "system-alder")
+	if err != nil {
+		t.Fatalf("ResolvePivot: unexpected error: %v", err)
+	}
+	pivot, err := ResolvePivotTarget(db, substore)
+	if err != nil {
+		t.Fatalf("ResolvePivotTarget: unexpected error: %v", err)
+	}
+	if !pivot.CrossAuthority() {
+		t.Fatal("ResolvePivotTarget: expected a cross-authority pivot")
+	}
+	if brandID := pivot.BrandID("system"); brandID != "reseller" {
+		t.Errorf("ResolvePivotTarget: expected brand %q, got %q", "reseller", brandID)
+	}
+	if keyID := pivot.SigningModel().KeyID; keyID != "resellerkey" {
+		t.Errorf("ResolvePivotTarget: expected key %q, got %q", "resellerkey", keyID)
+	}
+
+	// A sub-store of the same brand is signed by the model it was pivoted from
+	pivot, err = ResolvePivotTarget(&datastore.MockDB{}, datastore.Substore{FromModel: datastore.Model{BrandID: "system", KeyID: "systemkey"}})
+	if err != nil || pivot.CrossAuthority() || pivot.SigningModel().KeyID != "systemkey" {
+		t.Errorf("ResolvePivotTarget: expected the original model to sign, got %v, %v", pivot, err)
+	}
+}
+
+func TestCreateCrossAuthoritySubstore(t *testing.T) {
+	db, reseller := crossAuthorityDB(t)
+
+	tests := []struct {
+		modelName string
+		user      datastore.User
+		ok        bool
+	}{
+		{"alder-reseller", datastore.User{Role: datastore.Superuser}, true},
+		{"missing", datastore.User{Role: datastore.Superuser}, false},
+		{"alder-reseller", datastore.User{Username: "reseller-admin", Role: datastore.Admin}, false},
+	}
+
+	db.AddUser(datastore.User{Username: "reseller-admin", Role: datastore.Admin, Accounts: []datastore.Account{reseller}})
+	alder, _ := db.FindModel("system", "alder", "system-alder")
+	for _, tt := range tests {
+		store := datastore.Substore{AccountID: reseller.ID, FromModelID: alder.ID, Store: "reseller-store", SerialNumber: "xyz*", ModelName: tt.modelName}
+		err := db.CreateAllowedSubstore(store, tt.user)
+		if (err == nil) != tt.ok {
+			t.Errorf("CreateAllowedSubstore(%s, %s): expected success %v, got %v", tt.modelName, tt.user.Username, tt.ok, err)
+		}
+	}
+}
+
+func TestResolveSerial(t *testing.T) {
+	tests := []struct {
+		modelName string
+		resolved  string
+		err       error
+	}{
+		{"alder", ResolvedModel, nil},
+		{"alder-mybrand", ResolvedSubstore, nil},
+		{"invalid", "", ErrSubstoreNotFound},
+	}
+
+	for _, tt := range tests {
+		resolved, err := ResolveSerial(&datastore.MockDB{}, "system", tt.modelName, "abc1234")
+		if err != tt.err {
+			t.Errorf("ResolveSerial(%s): expected %v, got %v", tt.modelName, tt.err, err)
+		}
+		if resolved != tt.resolved {
+			t.Errorf("ResolveSerial(%s): expected %q, got %q", tt.modelName, tt.resolved, resolved)
+		}
+	}
+}
@@ -41,6 +41,7 @@ import Accounts from './models/accounts'
 import Keypairs from './models/keypairs'
 import Models from './models/models';
 import SigningLogModel from './models/signinglog';
+import Events from './models/events';
 import {sectionFromPath, sectionIdFromPath, subSectionIdFromPath, isLoggedIn, getAccount, saveAccount, isUserAdmin, isUserSuperuser, formatError} from './components/Utils'
 import createHistory from 'history/createBrowserHistory'
 import './sass/App.css'
@@ -179,6 +180,25 @@ class App extends Component {
     });
   }
 
+  componentDidMount() {
+    if (isLoggedIn(this.props.token)) {
+      Events.subscribe('signing', this.handleSigningEvent)
+    }
+  }
+
+  componentWillUnmount() {
+    Events.unsubscribe('signing', this.handleSigningEvent)
+    Events.close()
+  }
+
+  // Prepend the devices that are signed for the selected account to the signing log
+  handleSigningEvent = (log) => {
+    if (sectionFromPath(window.location.pathname) !== 'signinglog') {return}
+    if (log.make !== this.state.selectedAccount.AuthorityID) {return}
+
+    this.setState({logs: [log].concat(this.state.logs)})
+  }
+
   handleItemClick = (index, key) => {
     var items;
     if (key === 'models') {
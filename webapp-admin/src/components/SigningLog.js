@@ -102,7 +102,7 @@ class SigningLog extends Component {
           <table>
             <thead>
               <tr>
-                <th>{T('brand')}</th><th>{T('model')}</th><th>{T('serial-number')}</th><th>{T('revision')}</th><th>{T('fingerprint')}</th><th>{T('date')}</th>
+                <th>{T('brand')}</th><th>{T('model')}</th><th>{T('serial-number')}</th><th>{T('revision')}</th><th>{T('fingerprint')}</th><th>{T('date')}</th><th>{T('annotations')}</th>
               </tr>
             </thead>
             <tbody>
@@ -20,6 +20,13 @@ import moment from 'moment';
 
 
 class SigningLogRow extends Component {
+	renderAnnotations() {
+		var annotations = this.props.log.annotations || [];
+		return annotations.map(function(a) {
+			return <div key={a.id} title={a['created-by'] + ' ' + moment(a.created).format("YYYY-MM-DD HH:mm")}>{a.note}</div>;
+		});
+	}
+
 	render() {
 		return (
 			<tr>
@@ -29,6 +36,7 @@ class SigningLogRow extends Component {
 				<td>{this.props.log.revision}</td>
 				<td className="overflow" title={this.props.log.fingerprint}>{this.props.log.fingerprint}</td>
 				<td className="wrap">{moment(this.props.log.created).format("YYYY-MM-DD HH:mm")}</td>
+				<td className="wrap">{this.renderAnnotations()}</td>
 			</tr>
 		)
 	}
@@ -30,6 +30,7 @@ var intlData = {
       "add-new-model": "Add a new model",
       "add-new-signing-key": "Import a signing key",
       "add-new-user": "Add a new user",
+      "annotations": "Annotations",
       "api-key": "API Key",
       "api-key-description": "API Key to sign a serial assertion request (min. 10 characters). Will be generated if blank or invalid",
       "architecture": "Architecture",
@@ -138,7 +139,7 @@ var intlData = {
       "new-model": "New Model",
       "new-public-key-description": "Paste the public key of the machine that needs access to the Serial Vault",
       "new-public-key": "New Public Key",
-      "new-signing-key-description": "Paste the signing-key or upload the file",
+      "new-signing-key-description": "Paste the signing-key or upload the file: an armored GPG key, a PEM RSA key or a key exported by snapcraft",
       "new-signing-key": "Import Signing Key",
       "new-substore-device": "Create a new sub-store mapping for a device",
       "new-user": "New User",
@@ -18,6 +18,11 @@ import request from 'then-request';
 
 var API_VERSION = '/v1/';
 
+// The error messages of the API are translated into the language of the UI
+function acceptLanguage() {
+	return window.AppState ? window.AppState.getLocale() : 'en';
+}
+
 var Ajax = {
 
 	getToken: function() {
@@ -33,7 +38,9 @@ var Ajax = {
 				qs = {};
 			}
 			return request('GET', API_VERSION + url, {
-				headers: {},
+				headers: {
+					'Accept-Language': acceptLanguage(),
+				},
 				qs: qs
 			});
 	},
@@ -44,6 +51,7 @@ var Ajax = {
 			return request('POST', API_VERSION + url, {
 				headers: {
 					'X-CSRF-Token': response.headers['x-csrf-token'],
+					'Accept-Language': acceptLanguage(),
 				},
 				json: data
 			});
@@ -56,6 +64,7 @@ var Ajax = {
 			return request('PUT', API_VERSION + url, {
 				headers: {
 					'X-CSRF-Token': response.headers['x-csrf-token'],
+					'Accept-Language': acceptLanguage(),
 				},
 				json: data
 			});
@@ -68,6 +77,7 @@ var Ajax = {
 			return request('DELETE', API_VERSION + url, {
 				headers: {
 					'X-CSRF-Token': response.headers['x-csrf-token'],
+					'Accept-Language': acceptLanguage(),
 				},
 				json: data
 			});
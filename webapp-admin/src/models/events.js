@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+var EVENTS_URL = '/v1/events';
+
+// Events streams the signing, sync and alert events of the vault, so the views are updated as
+// they happen. The browser reconnects when the stream is dropped, resuming after the last event
+var Events = {
+	source: null,
+	listeners: {},
+
+	subscribe: function(event, callback) {
+		if (!this.listeners[event]) {
+			this.listeners[event] = [];
+			if (this.source) {
+				this.listen(event);
+			}
+		}
+		this.listeners[event].push(callback);
+		this.open();
+	},
+
+	unsubscribe: function(event, callback) {
+		this.listeners[event] = (this.listeners[event] || []).filter(function(c) {
+			return c !== callback;
+		});
+	},
+
+	open: function() {
+		if (this.source || !window.EventSource) {
+			return;
+		}
+		this.source = new window.EventSource(EVENTS_URL);
+		Object.keys(this.listeners).forEach(this.listen, this);
+	},
+
+	close: function() {
+		if (this.source) {
+			this.source.close();
+			this.source = null;
+		}
+	},
+
+	listen: function(event) {
+		this.source.addEventListener(event, (e) => {
+			var data = JSON.parse(e.data);
+			(this.listeners[event] || []).forEach(function(callback) {
+				callback(data);
+			});
+		});
+	}
+}
+
+export default Events;
@@ -37,10 +37,14 @@ var SigningLog = {
 
 	download: function(data) {
 		// Convert the filtered data to a CSV array format
-		var lines = ['data:text/csv;charset=utf-8,ID,Make,Model,Serial Number,Revision,Fingerprint,Date'];
+		var lines = ['data:text/csv;charset=utf-8,ID,Make,Model,Serial Number,Revision,Fingerprint,Date,Annotations'];
 
 		data.forEach(function(d, index){
-			var line = [d.id,d.make,d.model,d.serialnumber,d.revision,d.fingerprint,d.created].join(",");
+			// The notes of the annotations are quoted, as they may hold commas
+			var annotations = (d.annotations || []).map(function(a) {
+				return a.note;
+			}).join("; ");
+			var line = [d.id,d.make,d.model,d.serialnumber,d.revision,d.fingerprint,d.created,'"' + annotations.replace(/"/g, '""') + '"'].join(",");
 			lines.push(line);
 		});
 